@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"github.com/skycoin/teller/src/bench"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+const benchUsage = `usage: teller bench [--binds n] [--deposits n] [--concurrency n] [--timeout duration] [--json]
+
+Binds deposit addresses and makes deposits to them against the exchange and a temporary database,
+with a simulated chain and sender, then reports the throughput and latency percentiles of each.
+Deposits are reported as fast as the exchange accepts them, so deposit latencies include the time spent queued.`
+
+// runBench runs the "teller bench" subcommand
+func runBench(args []string) error {
+	fs := pflag.NewFlagSet("bench", pflag.ContinueOnError)
+	binds := fs.Int("binds", 1000, "number of deposit addresses to bind")
+	deposits := fs.Int("deposits", 1000, "number of deposits to make")
+	concurrency := fs.Int("concurrency", 16, "number of bind requests made at the same time")
+	timeout := fs.Duration("timeout", time.Minute*10, "max time to wait for the deposits to be paid out")
+	dir := fs.String("dir", "", "directory of the temporary database, defaults to the system's temp directory")
+	jsonOut := fs.Bool("json", false, "print the result as json")
+	debug := fs.Bool("debug", false, "log the exchange's activity")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, benchUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	log, err := logger.NewLogger("", false)
+	if err != nil {
+		return err
+	}
+	log.Out = os.Stderr
+	// The exchange logs every step of every deposit, which would dominate the result
+	if !*debug {
+		log.Level = logrus.WarnLevel
+	}
+
+	r, err := bench.Run(log, bench.Config{
+		Binds:       *binds,
+		Deposits:    *deposits,
+		Concurrency: *concurrency,
+		Timeout:     *timeout,
+		Dir:         *dir,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		b, err := json.MarshalIndent(r, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\tcount\tduration\tper second\tp50\tp90\tp99\tmax")
+	printBenchStats(w, "binds", r.Binds)
+	printBenchStats(w, "deposits", r.Deposits)
+	return w.Flush()
+}
+
+func printBenchStats(w *tabwriter.Writer, name string, s bench.Stats) {
+	fmt.Fprintf(w, "%s\t%d\t%s\t%.1f\t%s\t%s\t%s\t%s\n", name, s.Count, s.Duration, s.Throughput, s.P50, s.P90, s.P99, s.Max)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/skycoin/teller/src/client"
+)
+
+const defaultClientAddr = "http://127.0.0.1:7071"
+
+const statusUsage = `usage: teller status [--addr url] [--json] <skyaddr>
+
+Prints the deposit statuses of a skycoin address, from the API of a running teller.`
+
+const bindUsage = `usage: teller bind [--addr url] [--coin-type BTC] [--json] <skyaddr>
+
+Binds a deposit address to a skycoin address with the API of a running teller, and prints the deposit address.`
+
+const healthUsage = `usage: teller health [--addr url] [--json]
+
+Prints the version, coin types and availability of a running teller.
+Exits with an error if no coin type has deposit addresses left, or binding is closed.`
+
+const statsUsage = `usage: teller stats [--addr url] [--json]
+
+Prints the event stats of a running teller: the deposits raised, participants and skycoin sent.`
+
+// clientCommand is a subcommand which calls the API of a running teller
+type clientCommand struct {
+	name    string
+	fs      *pflag.FlagSet
+	addr    *string
+	timeout *time.Duration
+	jsonOut *bool
+}
+
+func newClientCommand(name, usage string) *clientCommand {
+	fs := pflag.NewFlagSet(name, pflag.ContinueOnError)
+	c := &clientCommand{
+		name:    name,
+		fs:      fs,
+		addr:    fs.String("addr", defaultClientAddr, "base URL of the teller API"),
+		timeout: fs.Duration("timeout", time.Minute, "max time to wait for the response"),
+		jsonOut: fs.Bool("json", false, "print the result as json"),
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+	return c
+}
+
+// parse parses args, and returns the positional args and a client of the teller API
+func (c *clientCommand) parse(args []string, nargs int) ([]string, *client.Client, error) {
+	if err := c.fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if c.fs.NArg() != nargs {
+		c.fs.Usage()
+		return nil, nil, fmt.Errorf("%s takes %d argument(s)", c.name, nargs)
+	}
+
+	cl := client.New(*c.addr)
+	cl.HTTP.Timeout = *c.timeout
+	return c.fs.Args(), cl, nil
+}
+
+// printJSON prints v as json, if --json is set
+func (c *clientCommand) printJSON(v interface{}) (bool, error) {
+	if !*c.jsonOut {
+		return false, nil
+	}
+
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return true, err
+	}
+	fmt.Println(string(b))
+	return true, nil
+}
+
+// runStatus runs the "teller status" subcommand
+func runStatus(args []string) error {
+	cmd := newClientCommand("status", statusUsage)
+	pos, cl, err := cmd.parse(args, 1)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := cl.Status(pos[0])
+	if err != nil {
+		return err
+	}
+
+	if ok, err := cmd.printJSON(statuses); ok {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "seq\tcoin type\tstatus\tconfirmations\tupdated at")
+	for _, ds := range statuses {
+		updatedAt := "-"
+		if ds.UpdatedAt != 0 {
+			updatedAt = time.Unix(ds.UpdatedAt, 0).UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d/%d\t%s\n", ds.Seq, ds.CoinType, ds.Status, ds.Confirmations, ds.ConfirmationsRequired, updatedAt)
+	}
+	return w.Flush()
+}
+
+// runBind runs the "teller bind" subcommand
+func runBind(args []string) error {
+	cmd := newClientCommand("bind", bindUsage)
+	coinType := cmd.fs.String("coin-type", "BTC", "coin type of the deposit address")
+	pos, cl, err := cmd.parse(args, 1)
+	if err != nil {
+		return err
+	}
+
+	rsp, err := cl.Bind(pos[0], *coinType)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := cmd.printJSON(rsp); ok {
+		return err
+	}
+
+	fmt.Println(rsp.DepositAddress)
+	return nil
+}
+
+// healthResponse is the result of "teller health"
+type healthResponse struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	CoinTypes []string `json:"coin_types"`
+	// Healthy is false if no coin type has deposit addresses left, or binding is closed
+	Healthy       bool            `json:"healthy"`
+	Depleted      bool            `json:"depleted"`
+	CoinsDepleted map[string]bool `json:"coins_depleted"`
+	Ended         bool            `json:"ended"`
+	CapReached    bool            `json:"cap_reached"`
+	Maintenance   bool            `json:"maintenance"`
+}
+
+// errUnhealthy is returned by "teller health" when teller is not accepting deposits
+var errUnhealthy = errors.New("teller is not accepting deposits")
+
+// runHealth runs the "teller health" subcommand
+func runHealth(args []string) error {
+	cmd := newClientCommand("health", healthUsage)
+	_, cl, err := cmd.parse(args, 0)
+	if err != nil {
+		return err
+	}
+
+	v, err := cl.Version()
+	if err != nil {
+		return err
+	}
+
+	ps, err := cl.PublicStatus()
+	if err != nil {
+		return err
+	}
+
+	rsp := healthResponse{
+		Version:       v.Version,
+		Commit:        v.Commit,
+		CoinTypes:     v.CoinTypes,
+		Healthy:       !ps.Depleted && !ps.Ended && !ps.CapReached && !ps.Maintenance,
+		Depleted:      ps.Depleted,
+		CoinsDepleted: ps.CoinsDepleted,
+		Ended:         ps.Ended,
+		CapReached:    ps.CapReached,
+		Maintenance:   ps.Maintenance,
+	}
+
+	if ok, err := cmd.printJSON(rsp); ok {
+		if err == nil && !rsp.Healthy {
+			err = errUnhealthy
+		}
+		return err
+	}
+
+	var depleted []string
+	for coinType, d := range rsp.CoinsDepleted {
+		if d {
+			depleted = append(depleted, coinType)
+		}
+	}
+	sort.Strings(depleted)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "version\t%s (%s)\n", rsp.Version, rsp.Commit)
+	fmt.Fprintf(w, "coin types\t%s\n", strings.Join(rsp.CoinTypes, ", "))
+	fmt.Fprintf(w, "depleted\t%s\n", strings.Join(depleted, ", "))
+	fmt.Fprintf(w, "ended\t%v\n", rsp.Ended)
+	fmt.Fprintf(w, "cap reached\t%v\n", rsp.CapReached)
+	fmt.Fprintf(w, "maintenance\t%v\n", rsp.Maintenance)
+	fmt.Fprintf(w, "healthy\t%v\n", rsp.Healthy)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !rsp.Healthy {
+		return errUnhealthy
+	}
+	return nil
+}
+
+// runStats runs the "teller stats" subcommand
+func runStats(args []string) error {
+	cmd := newClientCommand("stats", statsUsage)
+	_, cl, err := cmd.parse(args, 0)
+	if err != nil {
+		return err
+	}
+
+	rsp, err := cl.Stats()
+	if err != nil {
+		return err
+	}
+
+	if ok, err := cmd.printJSON(rsp); ok {
+		return err
+	}
+
+	var coinTypes []string
+	for coinType := range rsp.Raised {
+		coinTypes = append(coinTypes, coinType)
+	}
+	sort.Strings(coinTypes)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, coinType := range coinTypes {
+		fmt.Fprintf(w, "raised %s\t%s\n", coinType, rsp.Raised[coinType])
+	}
+	fmt.Fprintf(w, "participants\t%d\n", rsp.Participants)
+	if rsp.Withheld {
+		fmt.Fprintf(w, "amounts\twithheld until stats.min_participants made a deposit\n")
+	} else {
+		fmt.Fprintf(w, "sky sent\t%s\n", rsp.SkySent)
+	}
+	if rsp.SkyRemaining != "" {
+		fmt.Fprintf(w, "sky remaining\t%s\n", rsp.SkyRemaining)
+	}
+	fmt.Fprintf(w, "updated at\t%s\n", time.Unix(rsp.UpdatedAt, 0).UTC().Format(time.RFC3339))
+	return w.Flush()
+}
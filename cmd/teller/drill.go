@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	"github.com/skycoin/teller/src/drill"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+const drillUsage = `usage: teller drill [--addr url] [--dummy-addr url] [--admin-addr url] [--binds n] [--deposits n] [--json]
+
+Runs a launch readiness exercise against a staging teller running with the dummy scanner and sender:
+floods it with binds, injects deposits, takes the skycoin node down, and checks that the API keeps serving,
+the runbook alerts and the payouts recover once the node is up. Prints a pass/fail report of each check,
+and exits with an error if teller is not ready.`
+
+// errNotReady is returned by "teller drill" when a check failed
+var errNotReady = errors.New("teller is not ready for launch")
+
+// runDrill runs the "teller drill" subcommand
+func runDrill(args []string) error {
+	fs := pflag.NewFlagSet("drill", pflag.ContinueOnError)
+	addr := fs.String("addr", defaultClientAddr, "base URL of the teller API")
+	dummyAddr := fs.String("dummy-addr", "http://127.0.0.1:4121", "base URL of the dummy admin interface, dummy.http_addr")
+	adminAddr := fs.String("admin-addr", "", "base URL of the admin panel, to check the runbook's alerts. The alerting check is skipped if unset")
+	binds := fs.Int("binds", 100, "number of deposit addresses to bind")
+	concurrency := fs.Int("concurrency", 16, "number of bind requests made at the same time")
+	deposits := fs.Int("deposits", 10, "number of deposits to make")
+	depositValue := fs.Int64("deposit-value", 1e6, "value of each deposit, in satoshis")
+	outage := fs.Duration("outage", time.Minute, "how long the skycoin node is down before the alert is checked")
+	timeout := fs.Duration("timeout", time.Minute*10, "max time to wait for the payouts, the alert and the recovery")
+	jsonOut := fs.Bool("json", false, "print the report as json")
+	debug := fs.Bool("debug", false, "log the progress of the drill")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, drillUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	log, err := logger.NewLogger("", false)
+	if err != nil {
+		return err
+	}
+	log.Out = os.Stderr
+	if !*debug {
+		log.Level = logrus.WarnLevel
+	}
+
+	r, err := drill.Run(log, drill.Config{
+		Addr:         *addr,
+		DummyAddr:    *dummyAddr,
+		AdminAddr:    *adminAddr,
+		Binds:        *binds,
+		Concurrency:  *concurrency,
+		Deposits:     *deposits,
+		DepositValue: *depositValue,
+		Outage:       *outage,
+		Timeout:      *timeout,
+		PollInterval: time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *jsonOut {
+		b, err := json.MarshalIndent(r, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "check\tresult\tduration\tdetail")
+		for _, c := range r.Checks {
+			result := "FAIL"
+			switch {
+			case c.Skipped:
+				result = "SKIP"
+			case c.Passed:
+				result = "PASS"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Name, result, c.Duration.Round(time.Millisecond), c.Detail)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if r.Ready {
+			fmt.Println("\nteller is ready")
+		}
+	}
+
+	if !r.Ready {
+		return errNotReady
+	}
+	return nil
+}
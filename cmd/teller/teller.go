@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -12,7 +13,9 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime/pprof"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/boltdb/bolt"
@@ -21,16 +24,41 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/abuse"
 	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/backup"
+	"github.com/skycoin/teller/src/capture"
 	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/events"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/handover"
+	"github.com/skycoin/teller/src/metrics"
 	"github.com/skycoin/teller/src/monitor"
+	"github.com/skycoin/teller/src/notify"
+	"github.com/skycoin/teller/src/objstore"
+	"github.com/skycoin/teller/src/receipt"
+	"github.com/skycoin/teller/src/reconcile"
+	"github.com/skycoin/teller/src/replica"
+	"github.com/skycoin/teller/src/runbook"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
 	"github.com/skycoin/teller/src/teller"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/connutil"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/httpclient"
 	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/pauseutil"
+	"github.com/skycoin/teller/src/version"
 )
 
+// handoverDBTimeout is how long to wait for a released teller to shut down and release the db
+const handoverDBTimeout = time.Minute
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Println(err)
@@ -38,65 +66,265 @@ func main() {
 	}
 }
 
-func createBtcScanner(log *logrus.Logger, cfg config.Config, scanStore *scanner.Store) (*scanner.BTCScanner, error) {
+func createBtcScanner(log *logrus.Logger, cfg config.Config, scanStore *scanner.Store) (*scanner.BTCScanner, *scanner.BtcConn, error) {
 	// create btc rpc client
 	certs, err := ioutil.ReadFile(cfg.BtcRPC.Cert)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to read cfg.BtcRPC.Cert %s: %v", cfg.BtcRPC.Cert, err)
+		return nil, nil, fmt.Errorf("Failed to read cfg.BtcRPC.Cert %s: %v", cfg.BtcRPC.Cert, err)
 	}
 
 	log.Info("Connecting to btcd")
 
-	btcrpc, err := btcrpcclient.New(&btcrpcclient.ConnConfig{
+	// btcd forgets the transaction filter when the websocket disconnects,
+	// so it is reloaded after every reconnect
+	txFilter := scanner.NewBtcTxFilter()
+
+	btcrpc, err := scanner.NewBtcConn(log, btcrpcclient.ConnConfig{
 		Endpoint:     "ws",
 		Host:         cfg.BtcRPC.Server,
 		User:         cfg.BtcRPC.User,
 		Pass:         cfg.BtcRPC.Pass,
 		Certificates: certs,
-	}, nil)
+	}, &btcrpcclient.NotificationHandlers{
+		OnClientConnected: txFilter.Reset,
+	}, reconnectConfig(cfg.BtcRPC.Reconnect))
 	if err != nil {
 		log.WithError(err).Error("Connect btcd failed")
-		return nil, err
+		return nil, nil, err
 	}
 
 	log.Info("Connect to btcd succeeded")
 
+	// with additional nodes, scan the blocks which a quorum of the nodes agree on
+	var btcClient scanner.BtcRPCClient = btcrpc
+	if len(cfg.BtcRPC.Nodes) != 0 {
+		clients := []scanner.BtcRPCClient{btcrpc}
+		for i, n := range cfg.BtcRPC.Nodes {
+			c, err := connectBtcNode(log, n, cfg.BtcRPC.Reconnect)
+			if err != nil {
+				log.WithError(err).WithField("node", i+1).Error("Connect btcd node failed")
+				return nil, nil, err
+			}
+			clients = append(clients, c)
+		}
+
+		btcClient, err = scanner.NewBtcQuorum(log, clients, cfg.BtcRPC.QuorumSize())
+		if err != nil {
+			log.WithError(err).Error("scanner.NewBtcQuorum failed")
+			return nil, nil, err
+		}
+
+		log.WithFields(logrus.Fields{
+			"nodes":  len(clients),
+			"quorum": cfg.BtcRPC.QuorumSize(),
+		}).Info("Scanning blocks agreed by a quorum of btcd nodes")
+	}
+
 	scanStore.AddSupportedCoin(scanner.CoinTypeBTC)
 
-	btcScanner, err := scanner.NewBTCScanner(log, scanStore, btcrpc, scanner.Config{
+	scanCfg := btcScanConfig(cfg)
+
+	var btcScanner *scanner.BTCScanner
+	if cfg.BtcScanner.TxFilter {
+		btcScanner, err = scanner.NewBTCScannerTxFilter(log, scanStore, btcrpc, txFilter, scanCfg)
+	} else {
+		btcScanner, err = scanner.NewBTCScanner(log, scanStore, btcClient, scanCfg)
+	}
+	if err != nil {
+		log.WithError(err).Error("Open scan service failed")
+		return nil, nil, err
+	}
+	return btcScanner, btcrpc, nil
+}
+
+// btcScanConfig returns the config of the BTC scanner
+func btcScanConfig(cfg config.Config) scanner.Config {
+	return scanner.Config{
 		ScanPeriod:            cfg.BtcScanner.ScanPeriod,
 		ConfirmationsRequired: cfg.BtcScanner.ConfirmationsRequired,
 		InitialScanHeight:     cfg.BtcScanner.InitialScanHeight,
-	})
+		Lag: scanner.LagConfig{
+			TipURLs:       cfg.BtcScanner.Lag.TipURLs,
+			MaxLag:        cfg.BtcScanner.Lag.MaxLag,
+			CheckInterval: cfg.BtcScanner.Lag.CheckInterval,
+		},
+		Poll: scanner.PollConfig{
+			MinPeriod: cfg.BtcScanner.Poll.MinPeriod,
+			MaxPeriod: cfg.BtcScanner.Poll.MaxPeriod,
+			BindBoost: cfg.BtcScanner.Poll.BindBoost,
+		},
+		Finality: scanner.FinalityConfig{
+			Policy:        scanner.FinalityPolicy(cfg.BtcScanner.Finality.Policy),
+			CheckpointURL: cfg.BtcScanner.Finality.CheckpointURL,
+			CheckInterval: cfg.BtcScanner.Finality.CheckInterval,
+			SettleWindow:  cfg.BtcScanner.Finality.SettleWindow,
+		},
+	}
+}
+
+// connectBtcNode connects to an additional btcd node, for quorum scanning
+func connectBtcNode(log logrus.FieldLogger, n config.BtcNode, reconnect config.Reconnect) (*scanner.BtcConn, error) {
+	certs, err := ioutil.ReadFile(n.Cert)
 	if err != nil {
-		log.WithError(err).Error("Open scan service failed")
-		return nil, err
+		return nil, fmt.Errorf("Failed to read btcd node cert %s: %v", n.Cert, err)
+	}
+
+	return scanner.NewBtcConn(log, btcrpcclient.ConnConfig{
+		Endpoint:     "ws",
+		Host:         n.Server,
+		User:         n.User,
+		Pass:         n.Pass,
+		Certificates: certs,
+	}, nil, reconnectConfig(reconnect))
+}
+
+// reconnectConfig returns the connutil.Config of a node's reconnect config
+func reconnectConfig(c config.Reconnect) connutil.Config {
+	return connutil.Config{
+		ConnectTimeout: c.ConnectTimeout,
+		RequestTimeout: c.RequestTimeout,
+		MinBackoff:     c.MinBackoff,
+		MaxBackoff:     c.MaxBackoff,
+		AlertAfter:     c.AlertAfter,
 	}
-	return btcScanner, nil
 }
 
-func createEthScanner(log *logrus.Logger, cfg config.Config, scanStore *scanner.Store) (*scanner.ETHScanner, error) {
+// newReceipts creates the receipt service, or returns nil if receipts are disabled
+func newReceipts(cfg config.Config) (*receipt.Service, error) {
+	if !cfg.Receipts.Enabled {
+		return nil, nil
+	}
+
+	b := cfg.ReceiptBranding()
+	return receipt.NewService(receipt.Config{
+		URL:        cfg.Receipts.URL,
+		SigningKey: cfg.Receipts.SigningKey,
+		PDF:        cfg.Receipts.PDF,
+		PayoutCoin: cfg.Payout.Coin,
+		Branding: receipt.Branding{
+			Name:         b.Name,
+			LogoURL:      b.LogoURL,
+			Website:      b.Website,
+			SupportEmail: b.SupportEmail,
+			Footer:       b.Footer,
+		},
+	})
+}
+
+func createEthScanner(log *logrus.Logger, cfg config.Config, scanStore *scanner.Store) (*scanner.ETHScanner, *scanner.EthClient, error) {
 	ethrpc, err := scanner.NewEthClient(cfg.EthRPC.Server, cfg.EthRPC.Port)
 	if err != nil {
 		log.WithError(err).Error("Connect geth failed")
-		return nil, err
+		return nil, nil, err
 	}
 
 	scanStore.AddSupportedCoin(scanner.CoinTypeETH)
 
-	ethScanner, err := scanner.NewETHScanner(log, scanStore, ethrpc, scanner.Config{
+	ethScanner, err := scanner.NewETHScanner(log, scanStore, ethrpc, ethScanConfig(cfg))
+	if err != nil {
+		log.WithError(err).Error("Open ethscan service failed")
+		return nil, nil, err
+	}
+	return ethScanner, ethrpc, nil
+}
+
+// ethScanConfig returns the config of the ETH scanner
+func ethScanConfig(cfg config.Config) scanner.Config {
+	return scanner.Config{
 		ScanPeriod:            cfg.EthScanner.ScanPeriod,
 		ConfirmationsRequired: cfg.EthScanner.ConfirmationsRequired,
 		InitialScanHeight:     cfg.EthScanner.InitialScanHeight,
+		Lag: scanner.LagConfig{
+			TipURLs:       cfg.EthScanner.Lag.TipURLs,
+			MaxLag:        cfg.EthScanner.Lag.MaxLag,
+			CheckInterval: cfg.EthScanner.Lag.CheckInterval,
+		},
+		Poll: scanner.PollConfig{
+			MinPeriod: cfg.EthScanner.Poll.MinPeriod,
+			MaxPeriod: cfg.EthScanner.Poll.MaxPeriod,
+			BindBoost: cfg.EthScanner.Poll.BindBoost,
+		},
+		Finality: scanner.FinalityConfig{
+			Policy:        scanner.FinalityPolicy(cfg.EthScanner.Finality.Policy),
+			CheckpointURL: cfg.EthScanner.Finality.CheckpointURL,
+			CheckInterval: cfg.EthScanner.Finality.CheckInterval,
+			SettleWindow:  cfg.EthScanner.Finality.SettleWindow,
+		},
+	}
+}
+
+// pluginCoinScanConfig returns the scanner config of a coin type added by a scanner plugin
+func pluginCoinScanConfig(pc config.PluginCoin) scanner.Config {
+	return scanner.Config{
+		ScanPeriod:            pc.ScanPeriod,
+		ConfirmationsRequired: pc.ConfirmationsRequired,
+		InitialScanHeight:     pc.InitialScanHeight,
+	}
+}
+
+// pluginCoinRates returns the SKY/coin rates of the coin types added by scanner plugins, by coin type
+func pluginCoinRates(cfg config.Config) map[string]string {
+	rates := make(map[string]string, len(cfg.PluginCoins))
+	for _, pc := range cfg.PluginCoins {
+		rates[pc.CoinType] = pc.SkyExchangeRate
+	}
+	return rates
+}
+
+// createPluginScanner creates the scanner of a coin type whose blocks are served by a scanner plugin instead of its node
+func createPluginScanner(log *logrus.Logger, coinType string, plugin config.ScannerPlugin, scanCfg scanner.Config, scanStore *scanner.Store) (*scanner.PluginScanner, error) {
+	client, err := scanner.NewPluginClient(log, scanner.PluginConfig{
+		CoinType:       coinType,
+		Command:        plugin.Command,
+		RequestTimeout: plugin.RequestTimeout,
 	})
 	if err != nil {
-		log.WithError(err).Error("Open ethscan service failed")
+		log.WithError(err).Error("scanner.NewPluginClient failed")
 		return nil, err
 	}
-	return ethScanner, nil
+
+	// Start the plugin now, so that a broken plugin fails the startup
+	info, err := client.Info()
+	if err != nil {
+		log.WithError(err).WithField("coinType", coinType).Error("Start scanner plugin failed")
+		return nil, err
+	}
+
+	log.WithFields(logrus.Fields{
+		"coinType": coinType,
+		"name":     info.Name,
+		"version":  info.Version,
+	}).Info("Scanning the blocks served by a scanner plugin")
+
+	scanStore.AddSupportedCoin(coinType)
+
+	pluginScanner, err := scanner.NewPluginScanner(log, scanStore, client, coinType, scanCfg)
+	if err != nil {
+		client.Shutdown()
+		log.WithError(err).Error("Open plugin scan service failed")
+		return nil, err
+	}
+	return pluginScanner, nil
 }
 
 func run() error {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "bench":
+			return runBench(os.Args[2:])
+		case "status":
+			return runStatus(os.Args[2:])
+		case "bind":
+			return runBind(os.Args[2:])
+		case "health":
+			return runHealth(os.Args[2:])
+		case "stats":
+			return runStats(os.Args[2:])
+		case "drill":
+			return runDrill(os.Args[2:])
+		}
+	}
+
 	cur, err := user.Current()
 	if err != nil {
 		fmt.Println("Failed to get user's home directory:", err)
@@ -106,27 +334,76 @@ func run() error {
 
 	appDirOpt := pflag.StringP("dir", "d", defaultAppDir, "application data directory")
 	configNameOpt := pflag.StringP("config", "c", "config", "name of configuration file")
+	envOpt := pflag.StringP("env", "e", "", "config profile merged over the configuration file, one of "+strings.Join(config.Envs, ", "))
+	handoverFromOpt := pflag.String("handover-from", "", "admin panel URL of a running teller to take the db over from, e.g. http://127.0.0.1:7711")
+	containerOpt := pflag.Bool("container", false, "run in a container, with the data directory at $"+containerDataDirEnv+", config keys from TELLER_* environment variables, JSON logs and the health probes")
 	pflag.Parse()
 
+	// In a container, the data directory is the volume mounted at $DATA_DIR
+	if *containerOpt && !pflag.CommandLine.Changed("dir") {
+		*appDirOpt = containerDataDir()
+	}
+
 	if err := createFolderIfNotExist(*appDirOpt); err != nil {
 		fmt.Println("Create application data directory failed:", err)
 		return err
 	}
 
-	cfg, err := config.Load(*configNameOpt, *appDirOpt)
+	cfg, err := config.Load(*configNameOpt, *appDirOpt, *envOpt, *containerOpt)
 	if err != nil {
 		return fmt.Errorf("Config error:\n%v", err)
 	}
 
+	// The coin types added by scanner plugins are registered before their deposits are read
+	for _, pc := range cfg.PluginCoins {
+		if err := deposits.RegisterCoin(deposits.Coin{
+			Type:     pc.CoinType,
+			Decimals: pc.Decimals,
+		}); err != nil {
+			return fmt.Errorf("Config error:\nplugin_coins: %v", err)
+		}
+	}
+
+	// The clients of the third party services are created on this transport
+	if err := httpclient.Configure(httpclient.Config{
+		Proxy:               cfg.HTTPClient.Proxy,
+		Retries:             cfg.HTTPClient.Retries,
+		RetryBackoff:        cfg.HTTPClient.RetryBackoff,
+		MaxIdleConnsPerHost: cfg.HTTPClient.MaxIdleConnsPerHost,
+		Pins:                cfg.HTTPClient.PinMap(),
+	}); err != nil {
+		return fmt.Errorf("Config error:\n%v", err)
+	}
+
 	// Init logger
-	rusloggger, err := logger.NewLogger(cfg.LogFilename, cfg.Debug)
+	var logHooks []logrus.Hook
+	if cfg.LogRedact.Enabled {
+		redactHook, err := logger.NewRedactHook(logger.RedactRules{
+			Hash:           cfg.LogRedact.Hash,
+			Drop:           cfg.LogRedact.Drop,
+			Truncate:       cfg.LogRedact.Truncate,
+			Salt:           []byte(cfg.LogRedact.Salt),
+			TruncateLength: cfg.LogRedact.TruncateLength,
+		})
+		if err != nil {
+			return fmt.Errorf("Config error:\nlog_redact: %v", err)
+		}
+		logHooks = append(logHooks, redactHook)
+	}
+
+	rusloggger, err := logger.NewLogger(cfg.LogFilename, cfg.Debug, logHooks...)
 	if err != nil {
 		fmt.Println("Failed to create Logrus logger:", err)
 		return err
 	}
 
+	if cfg.LogFormat == config.LogFormatJSON {
+		rusloggger.Formatter = &logrus.JSONFormatter{}
+	}
+
 	log := rusloggger.WithField("prefix", "teller")
 
+	log.WithField("version", version.Get()).Info("Starting teller")
 	log.WithField("config", cfg.Redacted()).Info("Loaded teller config")
 
 	if cfg.Profile {
@@ -140,16 +417,214 @@ func run() error {
 	}
 
 	quit := make(chan struct{})
-	go catchInterrupt(quit)
+	// A container is stopped with SIGTERM, which teller gets as PID 1
+	interrupts := []os.Signal{os.Interrupt}
+	if *containerOpt {
+		interrupts = append(interrupts, syscall.SIGTERM)
+	}
+	go catchInterrupt(quit, interrupts)
+
+	// SIGHUP reloads the web listen addresses and TLS certificate from the config files.
+	// It is caught before an archive runs too, which doesn't reload.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// An archive serves the status of an event which has ended, from a read only db
+	if cfg.Archive.Enabled {
+		if *handoverFromOpt != "" {
+			return errors.New("--handover-from can't be used with archive.enabled")
+		}
+
+		return runArchive(log, cfg, *appDirOpt, quit)
+	}
+
+	// A standby keeps a replica of the primary's db, until it is stopped to fail over to it
+	if cfg.Replication.Primary != "" {
+		if *handoverFromOpt != "" {
+			return errors.New("--handover-from can't be used with replication.primary")
+		}
+
+		return runStandby(log, cfg, *appDirOpt, quit)
+	}
+
+	// Take the db over from the running teller, which shuts down to release it
+	dbTimeout := 1 * time.Second
+	if *handoverFromOpt != "" {
+		if cfg.AdminPanel.HandoverToken == "" {
+			return errors.New("--handover-from requires admin_panel.handover_token")
+		}
+
+		if err := handover.TakeOver(log, handover.NewClient(*handoverFromOpt, cfg.AdminPanel.HandoverToken)); err != nil {
+			log.WithError(err).Error("Handover failed")
+			return err
+		}
+
+		dbTimeout = handoverDBTimeout
+	}
+
+	reloadWeb := func(tellerServer *teller.Teller) error {
+		newCfg, err := config.Load(*configNameOpt, *appDirOpt, *envOpt, *containerOpt)
+		if err != nil {
+			return fmt.Errorf("Config error:\n%v", err)
+		}
+
+		if err := tellerServer.ReloadWeb(newCfg.Web); err != nil {
+			return err
+		}
+
+		// Keep the reloaded addresses when teller restarts to compact the db
+		cfg.Web = cfg.Web.WithListen(newCfg.Web)
+		return nil
+	}
 
-	// Open db
 	dbPath := filepath.Join(*appDirOpt, cfg.DBFilename)
+
+	// After a failover, a sync which the standby left half applied is completed before the replica is opened
+	if err := replica.Recover(dbPath); err != nil {
+		log.WithError(err).Error("replica.Recover failed")
+		return err
+	}
+
+	for {
+		compact, err := runTeller(rusloggger, log, cfg, *appDirOpt, dbTimeout, quit, hup, reloadWeb)
+		if err != nil || !compact {
+			return err
+		}
+
+		// The compacted db is opened by the same process
+		dbTimeout = 1 * time.Second
+
+		log.WithField("dbPath", dbPath).Info("Compacting db")
+		res, err := dbutil.CompactFile(dbPath)
+		if err != nil {
+			// The db is unchanged if the compaction failed
+			log.WithField("alert", "db_compact").WithError(err).Error("ALERT: compacting the db failed, restarting teller with the db unchanged")
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"sizeBefore": res.SizeBefore,
+			"sizeAfter":  res.SizeAfter,
+			"duration":   res.Duration,
+		}).Info("Compacted db, restarting teller")
+	}
+}
+
+// newBucket creates the object_storage bucket, nil if object_storage.backend is unset
+func newBucket(cfg config.ObjectStorage) (*objstore.Bucket, error) {
+	if cfg.Backend == "" {
+		return nil, nil
+	}
+
+	return objstore.New(objstore.Config{
+		Backend:         cfg.Backend,
+		Endpoint:        cfg.Endpoint,
+		Region:          cfg.Region,
+		Bucket:          cfg.Bucket,
+		Prefix:          cfg.Prefix,
+		AccessKeyID:     cfg.AccessKeyID,
+		SecretAccessKey: cfg.SecretAccessKey,
+		PathStyle:       cfg.PathStyle,
+		Encryption:      cfg.Encryption,
+		KMSKeyID:        cfg.KMSKeyID,
+		CustomerKey:     cfg.CustomerKey,
+	})
+}
+
+// addressShard parses this instance's shard of a coin type's address pool, nil if the pools aren't sharded
+func addressShard(cfg config.AddressShard, ranges []string) (*addrs.Shard, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	return addrs.ParseShard(cfg.Index, ranges)
+}
+
+// rateFeedConfig converts the sky_exchanger.rate_feeds config
+func rateFeedConfig(cfg config.RateFeeds) exchange.RateFeedConfig {
+	feeds := make([]exchange.RateFeed, len(cfg.Feeds))
+	for i, f := range cfg.Feeds {
+		feeds[i] = exchange.RateFeed{
+			Name:     f.Name,
+			CoinType: f.CoinType,
+			URL:      f.URL,
+			Field:    f.Field,
+			Invert:   f.Invert,
+		}
+	}
+
+	return exchange.RateFeedConfig{
+		Feeds:        feeds,
+		Quorum:       cfg.Quorum,
+		MaxDeviation: cfg.MaxDeviation,
+		MaxStaleness: cfg.MaxStaleness,
+		PollInterval: cfg.PollInterval,
+	}
+}
+
+// runbookConfig converts the runbook config, with the fallback addresses of sky_rpc and btc_rpc
+func runbookConfig(cfg config.Config) runbook.Config {
+	rules := make([]runbook.Rule, len(cfg.Runbook.Rules))
+	for i, r := range cfg.Runbook.Rules {
+		actions := make([]runbook.Action, len(r.Actions))
+		for j, a := range r.Actions {
+			actions[j] = runbook.Action{
+				Type:      a.Type,
+				Subsystem: a.Subsystem,
+				Node:      a.Node,
+			}
+		}
+
+		rules[i] = runbook.Rule{
+			Name:      r.Name,
+			Condition: r.Condition,
+			Node:      r.Node,
+			For:       r.For,
+			CoinType:  r.CoinType,
+			Below:     r.Below,
+			Streak:    r.Streak,
+			Actions:   actions,
+		}
+	}
+
+	fallbacks := make(map[string]string)
+	if cfg.SkyRPC.FallbackAddress != "" {
+		fallbacks[runbook.NodeSky] = cfg.SkyRPC.FallbackAddress
+	}
+	if cfg.BtcRPC.FallbackServer != "" {
+		fallbacks[runbook.NodeBTC] = cfg.BtcRPC.FallbackServer
+	}
+
+	return runbook.Config{
+		Interval:  cfg.Runbook.Interval,
+		DryRun:    cfg.Runbook.DryRun,
+		Rules:     rules,
+		Fallbacks: fallbacks,
+	}
+}
+
+// runTeller runs teller's services until quit is closed or a service fails.
+// reloadWeb is called on each signal on hup.
+// It returns true if the services were stopped to compact the db, which is closed then.
+func runTeller(rusloggger *logrus.Logger, log logrus.FieldLogger, cfg config.Config, appDir string, dbTimeout time.Duration, quit <-chan struct{}, hup <-chan os.Signal, reloadWeb func(*teller.Teller) error) (bool, error) {
+	// Open db
+	dbPath := filepath.Join(appDir, cfg.DBFilename)
 	db, err := bolt.Open(dbPath, 0700, &bolt.Options{
-		Timeout: 1 * time.Second,
+		Timeout: dbTimeout,
 	})
 	if err != nil {
 		log.WithError(err).Error("Open db failed")
-		return err
+		return false, err
+	}
+
+	// requested from the admin API, the db is compacted once the services are stopped
+	compactor := dbutil.NewCompactor(db)
+
+	bucket, err := newBucket(cfg.ObjectStorage)
+	if err != nil {
+		log.WithError(err).Error("objstore.New failed")
+		return false, err
 	}
 
 	errC := make(chan error, 20)
@@ -172,89 +647,238 @@ func run() error {
 
 	var btcScanner *scanner.BTCScanner
 	var ethScanner *scanner.ETHScanner
-	var scanService scanner.Scanner
-	var scanEthService scanner.Scanner
+	// The scanners of the coin types whose blocks are served by a scanner plugin, by coin type
+	pluginScanners := make(map[string]*scanner.PluginScanner)
 	var sendService *sender.SendService
 	var sendRPC sender.Sender
+	var coinHours *sender.HoursTracker
+	var skyChain teller.AddressSeer
+	var hotWallet reconcile.HotWallet
+	var chainDeposits reconcile.ChainDeposits
 	var btcAddrMgr *addrs.Addrs
 	var ethAddrMgr *addrs.Addrs
+	// Check the unused deposit addresses for on-chain transactions, if enabled
+	var btcHistory addrs.HistoryChecker
+	var ethHistory addrs.HistoryChecker
+	// The chain tips the clock skew guard compares the clock to
+	var chainTips []clock.ChainSource
+	// The nodes whose connections the runbook watches and switches to their fallbacks
+	runbookNodes := make(map[string]runbook.Node)
 
 	//create multiplexer to manage scanner
 	multiplexer := scanner.NewMultiplexer(log)
 
+	// registerScanner runs the scanner of a coin type, and registers it with the multiplexer,
+	// which makes the coin type bindable
+	registerScanner := func(name, coinType string, s scanner.Scanner) error {
+		background(name, errC, s.Run)
+		if err := multiplexer.AddScanner(s, coinType); err != nil {
+			log.WithError(err).Errorf("multiplexer.AddScanner of %s failed", coinType)
+			return err
+		}
+		return nil
+	}
+
 	dummyMux := http.NewServeMux()
 
 	// create scan storer
 	scanStore, err := scanner.NewStore(log, db)
 	if err != nil {
 		log.WithError(err).Error("scanner.NewStore failed")
-		return err
+		return false, err
 	}
 
+	// The nodes of the enabled coin types are added to the deposit inspector
+	inspector := scanner.NewInspector(scanStore)
+
 	if cfg.Dummy.Scanner {
 		log.Info("btcd disabled, running dummy scanner")
-		scanService = scanner.NewDummyScanner(log)
-		scanEthService = scanner.NewDummyScanner(log)
-		scanService.(*scanner.DummyScanner).BindHandlers(dummyMux)
+		dummyScanner := scanner.NewDummyScanner(log)
+		dummyScanner.BindHandlers(dummyMux)
+		if cfg.BtcRPC.Enabled {
+			if err := registerScanner("dummyScanner.Run", scanner.CoinTypeBTC, dummyScanner); err != nil {
+				return false, err
+			}
+		}
+		if cfg.EthRPC.Enabled {
+			if err := registerScanner("dummyEthScanner.Run", scanner.CoinTypeETH, scanner.NewDummyScanner(log)); err != nil {
+				return false, err
+			}
+		}
 	} else {
+		chainDeposits = scanStore
+
 		// enable btc scanner
-		if cfg.BtcRPC.Enabled {
-			btcScanner, err = createBtcScanner(rusloggger, cfg, scanStore)
+		if cfg.BtcRPC.Enabled && cfg.BtcRPC.Plugin.Enabled() {
+			pluginScanner, err := createPluginScanner(rusloggger, scanner.CoinTypeBTC, cfg.BtcRPC.Plugin, btcScanConfig(cfg), scanStore)
 			if err != nil {
-				log.WithError(err).Error("create btc scanner failed")
-				return err
+				log.WithError(err).Error("create btc plugin scanner failed")
+				return false, err
 			}
-			background("btcScanner.Run", errC, btcScanner.Run)
+			pluginScanners[scanner.CoinTypeBTC] = pluginScanner
 
-			scanService = btcScanner
-
-			err = multiplexer.AddScanner(scanService, scanner.CoinTypeBTC)
+			if err := registerScanner("btcPluginScanner.Run", scanner.CoinTypeBTC, pluginScanner); err != nil {
+				return false, err
+			}
+		} else if cfg.BtcRPC.Enabled {
+			var btcrpc *scanner.BtcConn
+			btcScanner, btcrpc, err = createBtcScanner(rusloggger, cfg, scanStore)
 			if err != nil {
-				log.WithError(err).Errorf("multiplexer.AddScanner of %s failed", scanner.CoinTypeBTC)
-				return err
+				log.WithError(err).Error("create btc scanner failed")
+				return false, err
+			}
+			if cfg.BtcRPC.CheckAddressHistory {
+				btcHistory = scanner.NewBtcHistory(btcrpc)
+			}
+			inspector.AddNode(scanner.CoinTypeBTC, scanner.NewBtcInspector(btcrpc))
+			chainTips = append(chainTips, clock.ChainSource{
+				Name: scanner.CoinTypeBTC,
+				Tip: func() (time.Time, error) {
+					return scanner.BtcTipTime(btcrpc)
+				},
+			})
+			runbookNodes[runbook.NodeBTC] = btcrpc
+
+			if err := registerScanner("btcScanner.Run", scanner.CoinTypeBTC, btcScanner); err != nil {
+				return false, err
 			}
 		}
 
 		// enable eth scanner
-		if cfg.EthRPC.Enabled {
-			ethScanner, err = createEthScanner(rusloggger, cfg, scanStore)
+		if cfg.EthRPC.Enabled && cfg.EthRPC.Plugin.Enabled() {
+			pluginScanner, err := createPluginScanner(rusloggger, scanner.CoinTypeETH, cfg.EthRPC.Plugin, ethScanConfig(cfg), scanStore)
 			if err != nil {
-				log.WithError(err).Error("create eth scanner failed")
-				return err
+				log.WithError(err).Error("create eth plugin scanner failed")
+				return false, err
 			}
+			pluginScanners[scanner.CoinTypeETH] = pluginScanner
 
-			background("ethScanner.Run", errC, ethScanner.Run)
-
-			scanEthService = ethScanner
+			if err := registerScanner("ethPluginScanner.Run", scanner.CoinTypeETH, pluginScanner); err != nil {
+				return false, err
+			}
+		} else if cfg.EthRPC.Enabled {
+			var ethrpc *scanner.EthClient
+			ethScanner, ethrpc, err = createEthScanner(rusloggger, cfg, scanStore)
+			if err != nil {
+				log.WithError(err).Error("create eth scanner failed")
+				return false, err
+			}
+			if cfg.EthRPC.CheckAddressHistory {
+				ethHistory = ethrpc
+			}
+			inspector.AddNode(scanner.CoinTypeETH, ethrpc)
+			chainTips = append(chainTips, clock.ChainSource{
+				Name: scanner.CoinTypeETH,
+				Tip:  ethrpc.TipTime,
+			})
+
+			if err := registerScanner("ethScanner.Run", scanner.CoinTypeETH, ethScanner); err != nil {
+				return false, err
+			}
+		}
 
-			err = multiplexer.AddScanner(scanEthService, scanner.CoinTypeETH)
+		// enable the scanners of the coin types added by plugins
+		for _, pc := range cfg.PluginCoins {
+			pluginScanner, err := createPluginScanner(rusloggger, pc.CoinType, pc.Plugin, pluginCoinScanConfig(pc), scanStore)
 			if err != nil {
-				log.WithError(err).Errorf("multiplexer.AddScanner of %s failed", scanner.CoinTypeETH)
-				return err
+				log.WithError(err).WithField("coinType", pc.CoinType).Error("create plugin coin scanner failed")
+				return false, err
+			}
+			pluginScanners[pc.CoinType] = pluginScanner
+
+			if err := registerScanner(strings.ToLower(pc.CoinType)+"PluginScanner.Run", pc.CoinType, pluginScanner); err != nil {
+				return false, err
 			}
 		}
 	}
 
-	background("multiplex.Run", errC, multiplexer.Multiplex)
+	background("multiplex.Run", errC, multiplexer.Run)
+
+	// create exchange service
+	// The senders look up the idempotency keys of the broadcasts in the exchange store
+	exchangeStore, err := exchange.NewStore(log, db)
+	if err != nil {
+		log.WithError(err).Error("exchange.NewStore failed")
+		return false, err
+	}
+
+	// Check the deposits if the last run didn't shut down, before any service handles them
+	if _, err := exchangeStore.StartRun(time.Now(), version.Get().Version); err != nil {
+		log.WithError(err).Error("exchangeStore.StartRun failed")
+		return false, err
+	}
+
+	// The shutdown report replaces the run state marker when teller stops, or fails to start
+	runStopped := false
+	defer func() {
+		if !runStopped {
+			if err := exchangeStore.StopRun(time.Now(), "startup", errors.New("Teller failed to start")); err != nil {
+				log.WithError(err).Error("exchangeStore.StopRun failed")
+			}
+		}
+	}()
 
 	if cfg.Dummy.Sender {
 		log.Info("skyd disabled, running dummy sender")
-		sendRPC = sender.NewDummySender(log)
-		sendRPC.(*sender.DummySender).BindHandlers(dummyMux)
+		dummySender := sender.NewDummySender(log, exchangeStore)
+		dummySender.BindHandlers(dummyMux)
+		sendRPC = dummySender
+		skyChain = dummySender
+		hotWallet = dummySender
+		// The simulated node can be taken down through the dummy admin interface, e.g. by teller drill
+		runbookNodes[runbook.NodeSky] = dummySender
 	} else {
-		skyRPC, err := sender.NewRPC(cfg.SkyExchanger.Wallet, cfg.SkyRPC.Address)
+		log.WithFields(logrus.Fields{
+			"coin":  cfg.Payout.Coin,
+			"chain": cfg.Payout.Chain,
+		}).Info("Connecting to the payout node")
+		skyRPC, err := sender.NewRPC(log, cfg.SkyExchanger.Wallet, cfg.SkyRPC.Address, sender.Chain{
+			Name:        cfg.Payout.Chain,
+			GenesisHash: cfg.Payout.GenesisHash,
+		}, reconnectConfig(cfg.SkyRPC.Reconnect))
 		if err != nil {
 			log.WithError(err).Error("sender.NewRPC failed")
-			return err
+			return false, err
 		}
 
-		sendService = sender.NewService(log, skyRPC)
+		sendService = sender.NewService(log, skyRPC, exchangeStore)
+		skyChain = skyRPC
+		hotWallet = skyRPC
+		runbookNodes[runbook.NodeSky] = skyRPC
 
 		background("sendService.Run", errC, sendService.Run)
 
+		if cfg.SkyExchanger.CoinHours.Enabled {
+			coinHours = sender.NewHoursTracker(log, sender.HoursConfig{
+				CheckInterval: cfg.SkyExchanger.CoinHours.CheckInterval,
+				HoursPerSend:  cfg.SkyExchanger.CoinHours.HoursPerSend,
+			}, skyRPC)
+			background("coinHours.Run", errC, coinHours.Run)
+		}
+
 		sendRPC = sender.NewRetrySender(sendService)
 	}
 
+	// The launch gate and other time dependent behavior read this clock
+	var clk clock.Clock = clock.Real{}
+	if cfg.Dummy.Clock {
+		log.Info("Running with the dummy clock")
+		dummyClock := clock.NewShifted()
+		dummyClock.BindHandlers(dummyMux)
+		clk = dummyClock
+	}
+
+	// Pause the launch gate, widget sessions and cancel requests while the clock is skewed
+	skewGuard := clock.NewSkewGuard(log, clock.SkewConfig{
+		NTPServers:    cfg.ClockSkew.NTPServers,
+		MaxSkew:       cfg.ClockSkew.MaxSkew,
+		MaxChainSkew:  cfg.ClockSkew.MaxChainSkew,
+		CheckInterval: cfg.ClockSkew.CheckInterval,
+	}, clk, chainTips)
+	if skewGuard != nil {
+		background("skewGuard.Run", errC, skewGuard.Run)
+	}
+
 	if cfg.Dummy.Scanner || cfg.Dummy.Sender {
 		log.Infof("Starting dummy admin interface listener on http://%s", cfg.Dummy.HTTPAddr)
 		go func() {
@@ -264,44 +888,262 @@ func run() error {
 		}()
 	}
 
-	// create exchange service
-	exchangeStore, err := exchange.NewStore(log, db)
+	// create analytics service
+	var tracker analytics.Tracker = analytics.Noop{}
+	var analyticsEmitter *analytics.Emitter
+	analyticsSink, err := analytics.NewSink(cfg.Analytics)
 	if err != nil {
-		log.WithError(err).Error("exchange.NewStore failed")
-		return err
+		log.WithError(err).Error("analytics.NewSink failed")
+		return false, err
+	}
+	if analyticsSink != nil {
+		analyticsEmitter = analytics.NewEmitter(log, analyticsSink, cfg.Analytics.Salt)
+		background("analyticsEmitter.Run", errC, analyticsEmitter.Run)
+		tracker = analyticsEmitter
+	}
+
+	// publish the deposit pipeline's events to a message queue
+	var eventPublisher *events.Publisher
+	eventSink, err := events.NewSink(cfg.Events)
+	if err != nil {
+		log.WithError(err).Error("events.NewSink failed")
+		return false, err
 	}
-	exchangeClient, err := exchange.NewExchange(log, exchangeStore, multiplexer, sendRPC, exchange.Config{
+	if eventSink != nil {
+		eventPublisher, err = events.NewPublisher(log, db, eventSink, events.Config{
+			IncludeAddresses: cfg.Events.IncludeAddresses,
+			BatchSize:        cfg.Events.BatchSize,
+			RetryBackoff:     cfg.Events.RetryBackoff,
+		})
+		if err != nil {
+			log.WithError(err).Error("events.NewPublisher failed")
+			return false, err
+		}
+		eventPublisher.Watch(exchangeStore.StateMachine())
+		background("eventPublisher.Run", errC, eventPublisher.Run)
+	}
+
+	// create receipt service
+	receipts, err := newReceipts(cfg)
+	if err != nil {
+		log.WithError(err).Error("receipt.NewService failed")
+		return false, err
+	}
+	// The receipts are linked in the payout emails, and in the statuses unless receipts.status_links is off
+	var receiptLinks, statusReceiptLinks exchange.ReceiptLinker
+	if receipts != nil {
+		receiptLinks = receipts
+		if cfg.Receipts.StatusLinks {
+			statusReceiptLinks = receipts
+		}
+	}
+
+	// create contact email service
+	var notifier *notify.Notifier
+	var contacts teller.ContactBook
+	var contactEraser monitor.ContactEraser
+	var emailPreviewer monitor.EmailPreviewer
+	if cfg.Email.Enabled {
+		mailer, err := notify.NewSMTPMailer(notify.SMTPConfig{
+			Addr:     cfg.Email.SMTPAddr,
+			Username: cfg.Email.SMTPUsername,
+			Password: cfg.Email.SMTPPassword,
+			From:     cfg.Email.From,
+		})
+		if err != nil {
+			log.WithError(err).Error("notify.NewSMTPMailer failed")
+			return false, err
+		}
+
+		notifier, err = notify.NewNotifier(log, db, mailer, notify.Config{
+			StatusURL:       cfg.Email.StatusURL,
+			SigningKey:      cfg.Email.SigningKey,
+			EncryptionKey:   cfg.Email.EncryptionKey,
+			PayoutCoin:      cfg.Payout.Coin,
+			TemplatesDir:    cfg.Email.TemplatesDir,
+			DefaultLanguage: cfg.Email.DefaultLanguage,
+			Explorer:        cfg.Explorer.Links(cfg.Payout),
+			Receipts:        receiptLinks,
+			Branding: notify.Branding{
+				ServiceName:  cfg.Branding.ServiceName,
+				SupportURL:   cfg.Branding.SupportURL,
+				ContactEmail: cfg.Branding.ContactEmail,
+			},
+		})
+		if err != nil {
+			log.WithError(err).Error("notify.NewNotifier failed")
+			return false, err
+		}
+
+		background("notifier.Run", errC, notifier.Run)
+
+		// The notifier emails payouts when they are confirmed
+		notifier.Watch(exchangeStore.StateMachine())
+		contacts = notifier
+		contactEraser = notifier
+		emailPreviewer = notifier
+	}
+
+	exchangeClient, err := exchange.NewExchange(log, exchangeStore, multiplexer, sendRPC, tracker, exchange.Config{
 		BtcRate:                 cfg.SkyExchanger.SkyBtcExchangeRate,
 		EthRate:                 cfg.SkyExchanger.SkyEthExchangeRate,
+		CoinRates:               pluginCoinRates(cfg),
 		TxConfirmationCheckWait: cfg.SkyExchanger.TxConfirmationCheckWait,
+		LedgerCheckInterval:     cfg.SkyExchanger.LedgerCheckInterval,
 		MaxDecimals:             cfg.SkyExchanger.MaxDecimals,
+		RateGuard: exchange.RateGuardConfig{
+			ReferenceRates: map[string]string{
+				scanner.CoinTypeBTC: cfg.SkyExchanger.RateGuard.ReferenceBtcRate,
+				scanner.CoinTypeETH: cfg.SkyExchanger.RateGuard.ReferenceEthRate,
+			},
+			MaxDeviation:       cfg.SkyExchanger.RateGuard.MaxDeviation,
+			MaxChangePerMinute: cfg.SkyExchanger.RateGuard.MaxChangePerMinute,
+		},
+		RateFeeds: rateFeedConfig(cfg.SkyExchanger.RateFeeds),
+		Quote: exchange.QuoteConfig{
+			Currency: cfg.SkyExchanger.Quote.Currency,
+			SkyPrice: cfg.SkyExchanger.Quote.SkyPrice,
+			Feeds:    rateFeedConfig(cfg.SkyExchanger.Quote.RateFeeds()),
+		},
+		Display: exchange.DisplayConfig{
+			Currency: cfg.SkyExchanger.Display.Currency,
+			Decimals: cfg.SkyExchanger.Display.Decimals,
+			Feeds:    rateFeedConfig(cfg.SkyExchanger.Display.RateFeeds()),
+		},
+		PayoutLog: exchange.PayoutLogConfig{
+			Enabled: cfg.PayoutLog.Enabled,
+			Salt:    cfg.PayoutLog.Salt,
+		},
+		CampaignCap: exchange.CampaignCapConfig{
+			MaxBTC: cfg.SkyExchanger.CampaignCap.MaxBTC,
+			MaxSky: cfg.SkyExchanger.CampaignCap.MaxSky,
+			Policy: exchange.CapPolicy(cfg.SkyExchanger.CampaignCap.Policy),
+		},
+		DustThreshold: exchange.DustConfig{
+			BTC: cfg.SkyExchanger.Dust.BTC,
+			ETH: cfg.SkyExchanger.Dust.ETH,
+		},
+		SendThrottle: exchange.SendThrottleConfig{
+			SendsPerSecond: cfg.SkyExchanger.SendThrottle.SendsPerSecond,
+			MaxInFlight:    cfg.SkyExchanger.SendThrottle.MaxInFlight,
+		},
+		DepositValidation: exchange.DepositValidationConfig{
+			Validators: cfg.SkyExchanger.DepositValidation.Validators,
+			HTTP: exchange.HTTPDepositValidatorConfig{
+				URL:     cfg.SkyExchanger.DepositValidation.URL,
+				Token:   cfg.SkyExchanger.DepositValidation.Token,
+				Timeout: cfg.SkyExchanger.DepositValidation.Timeout,
+			},
+			FailOpen: cfg.SkyExchanger.DepositValidation.FailOpen,
+		},
+		Snapshots: exchange.SnapshotConfig{
+			Enabled: cfg.SkyExchanger.ReportSnapshots.Enabled,
+			Dir:     cfg.SkyExchanger.ReportSnapshots.Dir,
+		},
+		StatusCacheSize: cfg.SkyExchanger.StatusCacheSize,
+		Explorer:        cfg.Explorer.Links(cfg.Payout),
+		Receipts:        statusReceiptLinks,
 	})
 	if err != nil {
 		log.WithError(err).Error("exchange.NewExchange failed")
-		return err
+		return false, err
+	}
+
+	if cfg.SkyExchanger.CheckFirstUse {
+		exchangeClient.CheckFirstUse(skyChain)
+	}
+
+	var coinHoursStatus monitor.CoinHoursGetter
+	if coinHours != nil {
+		exchangeClient.WaitForCoinHours(coinHours)
+		coinHoursStatus = coinHours
+	}
+
+	var rateFeeds monitor.RateFeedStatusGetter
+	if len(cfg.SkyExchanger.RateFeeds.Feeds) != 0 || cfg.SkyExchanger.Quote.Currency != "" || len(cfg.SkyExchanger.Display.Feeds) != 0 {
+		rateFeeds = exchangeClient
 	}
 
 	background("exchangeClient.Run", errC, exchangeClient.Run)
 
+	// start reconcile service
+	var reconciler *reconcile.Reconciler
+	if cfg.Reconcile.Enabled {
+		reportDir := cfg.Reconcile.ReportDir
+		if !filepath.IsAbs(reportDir) {
+			reportDir = filepath.Join(appDir, reportDir)
+		}
+
+		var reports reconcile.ObjectStore
+		if cfg.Reconcile.ReportStorage == config.ReportStorageObject {
+			reports = bucket
+		}
+
+		reconciler, err = reconcile.NewReconciler(log, reconcile.Config{
+			Interval:  cfg.Reconcile.Interval,
+			ReportDir: reportDir,
+			SecretKey: cfg.Reconcile.SecretKey,
+			Tolerances: map[string]string{
+				scanner.CoinTypeBTC:  cfg.Reconcile.BtcTolerance,
+				scanner.CoinTypeETH:  cfg.Reconcile.EthTolerance,
+				exchange.CurrencySKY: cfg.Reconcile.SkyTolerance,
+			},
+		}, exchangeStore, chainDeposits, hotWallet, reports)
+		if err != nil {
+			log.WithError(err).Error("reconcile.NewReconciler failed")
+			return false, err
+		}
+
+		background("reconciler.Run", errC, reconciler.Run)
+	}
+
+	// start db backup service
+	var backuper *backup.Backuper
+	if cfg.DBBackup.Interval > 0 {
+		backuper, err = backup.NewBackuper(log, backup.Config{
+			Interval: cfg.DBBackup.Interval,
+			Keep:     cfg.DBBackup.Keep,
+		}, db, bucket)
+		if err != nil {
+			log.WithError(err).Error("backup.NewBackuper failed")
+			return false, err
+		}
+
+		background("backuper.Run", errC, backuper.Run)
+	}
+
 	//create AddrManager
-	addrManager := addrs.NewAddrManager()
+	addrManager := addrs.NewAddrManager(addrs.AllocConfig{
+		QueueSize: cfg.Teller.BindQueueSize,
+		MaxWait:   cfg.Teller.BindMaxWait,
+	})
 
 	if cfg.BtcRPC.Enabled {
 		// create bitcoin address manager
 		f, err := ioutil.ReadFile(cfg.BtcAddresses)
 		if err != nil {
 			log.WithError(err).Error("Load deposit bitcoin address list failed")
-			return err
+			return false, err
+		}
+
+		btcShard, err := addressShard(cfg.AddressShard, cfg.AddressShard.BTC)
+		if err != nil {
+			log.WithError(err).Error("Invalid address_shard.btc")
+			return false, err
 		}
 
-		btcAddrMgr, err = addrs.NewBTCAddrs(log, db, bytes.NewReader(f))
+		btcAddrMgr, err = addrs.NewBTCAddrs(log, db, bytes.NewReader(f), addrs.PoolChecks{
+			Bindings: exchangeClient,
+			History:  btcHistory,
+			Shard:    btcShard,
+		})
 		if err != nil {
 			log.WithError(err).Error("Create bitcoin deposit address manager failed")
-			return err
+			return false, err
 		}
 		if err := addrManager.PushGenerator(btcAddrMgr, scanner.CoinTypeBTC); err != nil {
 			log.WithError(err).Error("add btc address manager failed")
-			return err
+			return false, err
 		}
 	}
 
@@ -310,37 +1152,267 @@ func run() error {
 		f, err := ioutil.ReadFile(cfg.EthAddresses)
 		if err != nil {
 			log.WithError(err).Error("Load deposit ethcoin address list failed")
-			return err
+			return false, err
 		}
 
-		ethAddrMgr, err = addrs.NewETHAddrs(log, db, bytes.NewReader(f))
+		ethShard, err := addressShard(cfg.AddressShard, cfg.AddressShard.ETH)
+		if err != nil {
+			log.WithError(err).Error("Invalid address_shard.eth")
+			return false, err
+		}
+
+		ethAddrMgr, err = addrs.NewETHAddrs(log, db, bytes.NewReader(f), addrs.PoolChecks{
+			Bindings: exchangeClient,
+			History:  ethHistory,
+			Shard:    ethShard,
+		})
 		if err != nil {
 			log.WithError(err).Error("Create ethcoin deposit address manager failed")
-			return err
+			return false, err
 		}
 		if err := addrManager.PushGenerator(ethAddrMgr, scanner.CoinTypeETH); err != nil {
 			log.WithError(err).Error("add eth address manager failed")
-			return err
+			return false, err
 		}
 	}
 
-	tellerServer := teller.New(log, exchangeClient, addrManager, cfg)
+	// The addresses of the coin types added by plugins are checked by their plugin
+	for _, pc := range cfg.PluginCoins {
+		pluginScanner, ok := pluginScanners[pc.CoinType]
+		if !ok {
+			// The dummy scanner doesn't scan the plugin coin types, so they can't be bound
+			continue
+		}
+
+		log := log.WithField("coinType", pc.CoinType)
+
+		f, err := ioutil.ReadFile(pc.Addresses)
+		if err != nil {
+			log.WithError(err).Error("Load deposit address list failed")
+			return false, err
+		}
+
+		pluginAddrMgr, err := addrs.NewPluginAddrs(log, db, pc.CoinType, bytes.NewReader(f), pluginScanner, addrs.PoolChecks{
+			Bindings: exchangeClient,
+		})
+		if err != nil {
+			log.WithError(err).Error("Create plugin coin deposit address manager failed")
+			return false, err
+		}
+		if err := addrManager.PushGenerator(pluginAddrMgr, pc.CoinType); err != nil {
+			log.WithError(err).Error("add plugin coin address manager failed")
+			return false, err
+		}
+	}
+
+	// predict when the deposit address pools run out from the bind rate
+	forecaster := addrs.NewForecaster(log, addrs.ForecastConfig{
+		Window:         cfg.AddressForecast.Window,
+		SampleInterval: cfg.AddressForecast.SampleInterval,
+		AlertHorizon:   cfg.AddressForecast.AlertHorizon,
+	}, addrManager)
+
+	background("forecaster.Run", errC, forecaster.Run)
+
+	var certCache teller.CertCache
+	if cfg.Web.AutoTLSHost != "" {
+		certCache, err = teller.NewCertCache(cfg.Web, db, bucket)
+		if err != nil {
+			log.WithError(err).Error("teller.NewCertCache failed")
+			return false, err
+		}
+	}
+
+	guard, err := abuse.New(log, cfg.Abuse, clk)
+	if err != nil {
+		log.WithError(err).Error("abuse.New failed")
+		return false, err
+	}
+
+	// Records requests and responses while an operator runs a capture session from the admin API
+	recorder, err := capture.New(log, cfg.Capture, db, clk)
+	if err != nil {
+		log.WithError(err).Error("capture.New failed")
+		return false, err
+	}
+
+	tellerServer := teller.New(log, exchangeClient, multiplexer, addrManager, certCache, tracker, skyChain, contacts, guard, clk, skewGuard, recorder, receipts, cfg)
 
 	// Run the service
 	background("tellerServer.Run", errC, tellerServer.Run)
 
+	// quiesce binding before sending, so that no new deposit address is handed out while sends drain
+	var coordinator *handover.Coordinator
+	var ho monitor.Handover
+	if cfg.AdminPanel.HandoverToken != "" {
+		coordinator = handover.NewCoordinator(log, tellerServer, exchangeClient)
+		ho = coordinator
+	}
+
+	// subsystems which operators can pause from the admin API
+	subsystems := pauseutil.NewRegistry()
+	if btcScanner != nil {
+		subsystems.Add("btc_scanner", btcScanner.GetPauseGate())
+	}
+	if ethScanner != nil {
+		subsystems.Add("eth_scanner", ethScanner.GetPauseGate())
+	}
+	for coinType, s := range pluginScanners {
+		subsystems.Add(strings.ToLower(coinType)+"_scanner", s.GetPauseGate())
+	}
+	subsystems.Add("dispatcher", exchangeClient.DispatchGate())
+	subsystems.Add("sender", exchangeClient.SendGate())
+	if notifier != nil {
+		subsystems.Add("notifier", notifier.PauseGate())
+	}
+	subsystems.Add("binder", tellerServer.BindGate())
+
+	// respond to the alert conditions of the runbook's rules
+	var rb *runbook.Runbook
+	var runbookStatus monitor.RunbookStatusGetter
+	if cfg.Runbook.Enabled {
+		var pager runbook.Pager
+		if cfg.Runbook.Pager.URL != "" {
+			pager = runbook.NewWebhookPager(cfg.Runbook.Pager.URL, cfg.Runbook.Pager.Token)
+		}
+
+		rb, err = runbook.New(log, runbookConfig(cfg), runbookNodes, addrManager, exchangeClient, subsystems, pager)
+		if err != nil {
+			log.WithError(err).Error("runbook.New failed")
+			return false, err
+		}
+		runbookStatus = rb
+
+		background("runbook.Run", errC, rb.Run)
+	}
+
 	// start monitor service
+	var auditLogKey cipher.SecKey
+	if cfg.AuditLog.Enabled {
+		// The key is checked by config.AuditLog.Validate
+		auditLogKey, _ = cipher.SecKeyFromHex(cfg.AuditLog.SecretKey) // nolint: errcheck
+	}
+
 	monitorCfg := monitor.Config{
-		Addr: cfg.AdminPanel.Host,
+		Addr:             cfg.AdminPanel.Host,
+		HandoverToken:    cfg.AdminPanel.HandoverToken,
+		ReplicationToken: cfg.Replication.Token,
+		DBPath:           dbPath,
+		Explorer:         cfg.Explorer.Links(cfg.Payout),
+		AuditLogKey:      auditLogKey,
+		Auth: monitor.AuthConfig{
+			Enabled:             cfg.AdminPanel.Auth.Enabled,
+			SessionTTL:          cfg.AdminPanel.Auth.SessionTTL,
+			SecureCookie:        cfg.AdminPanel.Auth.SecureCookie,
+			TOTPSecret:          cfg.AdminPanel.Auth.TOTPSecret,
+			WebAuthnRPID:        cfg.AdminPanel.Auth.WebAuthnRPID,
+			WebAuthnOrigin:      cfg.AdminPanel.Auth.WebAuthnOrigin,
+			WebAuthnCredentials: cfg.AdminPanel.Auth.WebAuthnCredentials,
+		},
+	}
+	var abuseStats monitor.AbuseStatsGetter
+	if guard != nil {
+		abuseStats = guard
+	}
+	var capturer monitor.Capturer
+	if recorder != nil {
+		capturer = recorder
 	}
-	monitorService := monitor.New(log, monitorCfg, btcAddrMgr, ethAddrMgr, exchangeClient, btcScanner)
+	// the standby syncs its replica of the db from the admin panel
+	var replicationSource *replica.Source
+	var rs monitor.ReplicationSource
+	if cfg.Replication.Token != "" {
+		replicationSource = replica.NewSource(log, db, cfg.Replication.Token)
+		rs = replicationSource
+	}
+	// The admin panel lists the BTC scan addresses
+	var scanAddrs monitor.ScanAddressGetter = btcScanner
+	if s, ok := pluginScanners[scanner.CoinTypeBTC]; ok {
+		scanAddrs = s
+	}
+	monitorService := monitor.New(log, monitorCfg, btcAddrMgr, ethAddrMgr, exchangeClient, scanAddrs, addrManager, contactEraser, ho, subsystems, compactor, abuseStats, capturer, inspector, forecaster, emailPreviewer, rateFeeds, tellerServer.Features(), rs, exchangeStore, runbookStatus, coinHoursStatus)
 
 	background("monitorService.Run", errC, monitorService.Run)
 
+	// start metrics push service
+	var metricsPusher *metrics.Pusher
+	if cfg.MetricsPush.URL != "" {
+		scannerPolls := make(map[string]metrics.PollStatsGetter)
+		if btcScanner != nil {
+			scannerPolls[scanner.CoinTypeBTC] = btcScanner.GetPollScheduler()
+		}
+		if ethScanner != nil {
+			scannerPolls[scanner.CoinTypeETH] = ethScanner.GetPollScheduler()
+		}
+		for coinType, s := range pluginScanners {
+			scannerPolls[coinType] = s.GetPollScheduler()
+		}
+
+		instance := cfg.MetricsPush.Instance
+		if instance == "" {
+			instance, err = os.Hostname()
+			if err != nil {
+				log.WithError(err).Error("os.Hostname failed")
+				return false, err
+			}
+		}
+
+		gatherers := []metrics.Gatherer{metrics.ExchangeGatherer(exchangeClient), metrics.AddrGatherer(addrManager), metrics.ScannerGatherer(scannerPolls), metrics.DBGatherer(compactor), metrics.StatusCacheGatherer(exchangeClient), metrics.ClockSkewGatherer(skewGuard), metrics.ForecastGatherer(forecaster), metrics.RateLimitGatherer(tellerServer)}
+		if replicationSource != nil {
+			gatherers = append(gatherers, metrics.ReplicationGatherer(replicationSource))
+		}
+
+		metricsPusher, err = metrics.NewPusher(log, metrics.PushConfig{
+			URL:      cfg.MetricsPush.URL,
+			Job:      cfg.MetricsPush.Job,
+			Instance: instance,
+			Interval: cfg.MetricsPush.Interval,
+		}, gatherers...)
+		if err != nil {
+			log.WithError(err).Error("metrics.NewPusher failed")
+			return false, err
+		}
+
+		background("metricsPusher.Run", errC, metricsPusher.Run)
+	}
+
+	reloadQuit := make(chan struct{})
+	reloadDone := make(chan struct{})
+	go func() {
+		defer close(reloadDone)
+		catchHangup(log, hup, reloadQuit, func() error {
+			return reloadWeb(tellerServer)
+		})
+	}()
+
+	var released <-chan struct{}
+	if coordinator != nil {
+		released = coordinator.Released()
+	}
+
+	var compactAt <-chan time.Time
+	if cfg.DBCompact.Interval > 0 {
+		compactAt = time.After(cfg.DBCompact.Interval)
+	}
+
 	var finalErr error
+	var compact bool
+	var stopReason string
 	select {
 	case <-quit:
+		stopReason = "signal"
+	case <-released:
+		stopReason = "handover"
+	case <-compactor.Requested():
+		log.Info("DB compaction requested from the admin API")
+		compact = true
+		stopReason = "compact"
+	case <-compactAt:
+		log.Info("DB compaction is due")
+		compact = true
+		stopReason = "compact"
 	case finalErr = <-errC:
+		stopReason = "error"
 		if finalErr != nil {
 			log.WithError(finalErr).Error("Goroutine error")
 		}
@@ -353,35 +1425,281 @@ func run() error {
 		monitorService.Shutdown()
 	}
 
+	// stop reloading before the web listeners are shut down
+	close(reloadQuit)
+	<-reloadDone
+
 	// close the teller service
 	log.Info("Shutting down tellerServer")
 	tellerServer.Shutdown()
 
-	// close the scan service
-	if btcScanner != nil {
-		log.Info("Shutting down btcScanner")
-		btcScanner.Shutdown()
+	if skewGuard != nil {
+		log.Info("Shutting down skewGuard")
+		skewGuard.Shutdown()
 	}
-	// close the scan service
-	if ethScanner != nil {
-		log.Info("Shutting down ethScanner")
-		ethScanner.Shutdown()
+
+	if rb != nil {
+		log.Info("Shutting down runbook")
+		rb.Shutdown()
+	}
+
+	log.Info("Shutting down forecaster")
+	forecaster.Shutdown()
+
+	// close the scan services
+	for _, coinType := range multiplexer.CoinTypes() {
+		log.WithField("coinType", coinType).Info("Shutting down scanner")
+		multiplexer.GetScanner(coinType).Shutdown()
+	}
+
+	if reconciler != nil {
+		log.Info("Shutting down reconciler")
+		reconciler.Shutdown()
+	}
+
+	// stop backing up before the db is closed
+	if backuper != nil {
+		log.Info("Shutting down backuper")
+		backuper.Shutdown()
 	}
 
 	// close exchange service
 	log.Info("Shutting down exchangeClient")
 	exchangeClient.Shutdown()
 
+	// the scanners are shut down, the multiplexer stops once it has forwarded their deposits
+	log.Info("Shutting down multiplexer")
+	multiplexer.Shutdown()
+
+	if coinHours != nil {
+		log.Info("Shutting down coinHours")
+		coinHours.Shutdown()
+	}
+
 	// close the skycoin send service
 	if sendService != nil {
 		log.Info("Shutting down sendService")
 		sendService.Shutdown()
 	}
 
+	// send the queued emails, after the exchange has stopped tracking payouts
+	if notifier != nil {
+		log.Info("Shutting down notifier")
+		notifier.Shutdown()
+	}
+
+	// push the final metrics, after every service has stopped updating them
+	if metricsPusher != nil {
+		log.Info("Shutting down metricsPusher")
+		metricsPusher.Shutdown()
+	}
+
+	// the unpublished events stay in the outbox until teller restarts
+	if eventPublisher != nil {
+		log.Info("Shutting down eventPublisher")
+		eventPublisher.Shutdown()
+	}
+
+	// flush the analytics events
+	if analyticsEmitter != nil {
+		log.Info("Shutting down analyticsEmitter")
+		analyticsEmitter.Shutdown()
+	}
+
 	log.Info("Waiting for goroutines to exit")
 
 	wg.Wait()
 
+	// the last write, once every service has stopped
+	runStopped = true
+	if err := exchangeStore.StopRun(time.Now(), stopReason, finalErr); err != nil {
+		log.WithError(err).Error("exchangeStore.StopRun failed")
+	}
+
+	// release the db lock, for a teller taking the db over
+	if err := db.Close(); err != nil {
+		log.WithError(err).Error("Close db failed")
+	}
+
+	log.Info("Shutdown complete")
+
+	return compact && finalErr == nil, finalErr
+}
+
+// runArchive serves the status and history of the deposits from the db, without the scanners,
+// sender or skycoin node. Binding is refused, and the admin panel is not started.
+func runArchive(log logrus.FieldLogger, cfg config.Config, appDir string, quit <-chan struct{}) error {
+	dbPath := cfg.Archive.DBFilename
+	if dbPath == "" {
+		dbPath = cfg.DBFilename
+	}
+	if !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(appDir, dbPath)
+	}
+
+	log = log.WithField("dbPath", dbPath)
+	log.Info("Starting archived teller")
+
+	// Opening read only takes a shared lock, so a snapshot can be served by several archives,
+	// but not a db which a running teller holds
+	db, err := bolt.Open(dbPath, 0400, &bolt.Options{
+		Timeout:  1 * time.Second,
+		ReadOnly: true,
+	})
+	if err != nil {
+		log.WithError(err).Error("Open db failed")
+		return err
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.WithError(err).Error("Close db failed")
+		}
+	}()
+
+	exchangeStore, err := exchange.NewStore(log, db)
+	if err != nil {
+		log.WithError(err).Error("exchange.NewStore failed")
+		return err
+	}
+
+	// The receipts of the archived deposits stay available for the depositors' tax records
+	receipts, err := newReceipts(cfg)
+	if err != nil {
+		log.WithError(err).Error("receipt.NewService failed")
+		return err
+	}
+	var statusReceiptLinks exchange.ReceiptLinker
+	if receipts != nil && cfg.Receipts.StatusLinks {
+		statusReceiptLinks = receipts
+	}
+
+	// The exchange is only read, it is not run
+	exchangeClient, err := exchange.NewExchange(log, exchangeStore, nil, nil, analytics.Noop{}, exchange.Config{
+		BtcRate:     cfg.SkyExchanger.SkyBtcExchangeRate,
+		EthRate:     cfg.SkyExchanger.SkyEthExchangeRate,
+		MaxDecimals: cfg.SkyExchanger.MaxDecimals,
+		Receipts:    statusReceiptLinks,
+	})
+	if err != nil {
+		log.WithError(err).Error("exchange.NewExchange failed")
+		return err
+	}
+
+	bucket, err := newBucket(cfg.ObjectStorage)
+	if err != nil {
+		log.WithError(err).Error("objstore.New failed")
+		return err
+	}
+
+	var certCache teller.CertCache
+	if cfg.Web.AutoTLSHost != "" {
+		certCache, err = teller.NewCertCache(cfg.Web, nil, bucket)
+		if err != nil {
+			log.WithError(err).Error("teller.NewCertCache failed")
+			return err
+		}
+	}
+
+	addrManager := addrs.NewAddrManager(addrs.AllocConfig{
+		QueueSize: cfg.Teller.BindQueueSize,
+		MaxWait:   cfg.Teller.BindMaxWait,
+	})
+
+	tellerServer := teller.New(log, exchangeClient, nil, addrManager, certCache, analytics.Noop{}, nil, nil, nil, clock.Real{}, nil, nil, receipts, cfg)
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- tellerServer.Run()
+	}()
+
+	var finalErr error
+	select {
+	case <-quit:
+	case finalErr = <-errC:
+		if finalErr != nil {
+			log.WithError(finalErr).Error("tellerServer.Run failed")
+		}
+	}
+
+	log.Info("Shutting down tellerServer")
+	tellerServer.Shutdown()
+
+	log.Info("Shutdown complete")
+
+	return finalErr
+}
+
+// runStandby syncs a replica of the primary's db to dbfile until quit is closed, without the rest of teller.
+// The replica is locked while the standby runs, teller is started on it with replication.primary unset to fail over.
+func runStandby(log logrus.FieldLogger, cfg config.Config, appDir string, quit <-chan struct{}) error {
+	dbPath := filepath.Join(appDir, cfg.DBFilename)
+
+	log = log.WithField("dbPath", dbPath)
+	log.Info("Starting replication standby")
+
+	standby, err := replica.NewStandby(log, replica.StandbyConfig{
+		Primary:  cfg.Replication.Primary,
+		Token:    cfg.Replication.Token,
+		Interval: cfg.Replication.Interval,
+		Timeout:  cfg.Replication.Timeout,
+		Path:     dbPath,
+	})
+	if err != nil {
+		log.WithError(err).Error("replica.NewStandby failed")
+		return err
+	}
+
+	errC := make(chan error, 2)
+	go func() {
+		errC <- standby.Run()
+	}()
+
+	var metricsPusher *metrics.Pusher
+	if cfg.MetricsPush.URL != "" {
+		instance := cfg.MetricsPush.Instance
+		if instance == "" {
+			instance, err = os.Hostname()
+			if err != nil {
+				log.WithError(err).Error("os.Hostname failed")
+				standby.Shutdown()
+				return err
+			}
+		}
+
+		metricsPusher, err = metrics.NewPusher(log, metrics.PushConfig{
+			URL:      cfg.MetricsPush.URL,
+			Job:      cfg.MetricsPush.Job,
+			Instance: instance,
+			Interval: cfg.MetricsPush.Interval,
+		}, metrics.ReplicationGatherer(standby))
+		if err != nil {
+			log.WithError(err).Error("metrics.NewPusher failed")
+			standby.Shutdown()
+			return err
+		}
+
+		go func() {
+			errC <- metricsPusher.Run()
+		}()
+	}
+
+	var finalErr error
+	select {
+	case <-quit:
+	case finalErr = <-errC:
+		if finalErr != nil {
+			log.WithError(finalErr).Error("Replication standby failed")
+		}
+	}
+
+	if metricsPusher != nil {
+		log.Info("Shutting down metricsPusher")
+		metricsPusher.Shutdown()
+	}
+
+	log.Info("Shutting down replication standby")
+	standby.Shutdown()
+
 	log.Info("Shutdown complete")
 
 	return finalErr
@@ -405,22 +1723,49 @@ func printProgramStatus() {
 	}
 }
 
-func catchInterrupt(quit chan<- struct{}) {
+// containerDataDirEnv is the environment variable of the data directory in --container mode, set by the Dockerfile
+const containerDataDirEnv = "DATA_DIR"
+
+// containerDataDir returns the data directory in --container mode
+func containerDataDir() string {
+	if dir := os.Getenv(containerDataDirEnv); dir != "" {
+		return dir
+	}
+	return "/data"
+}
+
+// catchInterrupt closes quit on the first of the interrupt signals
+func catchInterrupt(quit chan<- struct{}, interrupts []os.Signal) {
 	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
+	signal.Notify(sigchan, interrupts...)
 	<-sigchan
 	signal.Stop(sigchan)
 	close(quit)
 
 	// If ctrl-c is called again, panic so that the program state can be examined.
 	// Ctrl-c would be called again if program shutdown was stuck.
-	go catchInterruptPanic()
+	go catchInterruptPanic(interrupts)
+}
+
+// catchHangup calls reload on each signal on hup, until quit is closed
+func catchHangup(log logrus.FieldLogger, hup <-chan os.Signal, quit <-chan struct{}, reload func() error) {
+	for {
+		select {
+		case <-quit:
+			return
+		case <-hup:
+			log.Info("SIGHUP received, reloading the web config")
+			if err := reload(); err != nil {
+				log.WithError(err).Error("Reload web config failed, the current listeners are kept")
+			}
+		}
+	}
 }
 
-// catchInterruptPanic catches os.Interrupt and panics
-func catchInterruptPanic() {
+// catchInterruptPanic catches the interrupt signals and panics
+func catchInterruptPanic(interrupts []os.Signal) {
 	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
+	signal.Notify(sigchan, interrupts...)
 	<-sigchan
 	signal.Stop(sigchan)
 	printProgramStatus()
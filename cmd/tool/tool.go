@@ -16,12 +16,18 @@ import (
 	"io/ioutil"
 
 	"math"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	btcrpcclient "github.com/btcsuite/btcd/rpcclient"
 
 	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/reconcile"
+	"github.com/skycoin/teller/src/teller"
+	"github.com/skycoin/teller/src/util/logger"
 )
 
 // btc address json struct
@@ -40,9 +46,14 @@ Usage:
 The commands are:
 
     addbtcaddress       add the bitcoin address to the deposit address pool
+    exportdeposits      export the deposits in the db as csv or json lines
     getbtcaddress       list all bitcoin deposit address in the pool
     newbtcaddress       generate bitcoin address
+    rebuildindexes      rebuild the deposit indexes of the db
     scanblock           scan block from specific height to get all vout with interger value
+    signcancelbind      sign a request to cancel the binding of a deposit address
+    verifyauditlog      verify the signature and hash chain of an exported audit log page
+    verifyreport        verify the signature of a reconciliation report
 `, filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
 
 func main() {
@@ -92,12 +103,22 @@ func main() {
 			fmt.Println("usage: addbtcaddress btc_address")
 		case "getbtcaddress":
 			fmt.Println("usage: getbtcaddress")
+		case "exportdeposits":
+			fmt.Println(exportDepositsUsage)
 		case "newbtcaddress":
 			fmt.Println("usage: [-json] newbtcaddress seed num. -json will print as json.")
+		case "rebuildindexes":
+			fmt.Println("usage: [-db teller.db] rebuildindexes. Stop the teller first, its db is locked while it runs.")
 		case "scanblock":
 			fmt.Println("usage: server user pass cert_path height")
 		case "newkeys":
 			fmt.Println("usage: newkeys")
+		case "verifyreport":
+			fmt.Println("usage: verifyreport pubkey report_file. The signature is read from report_file.sig")
+		case "verifyauditlog":
+			fmt.Println("usage: verifyauditlog pubkey page_file [prev_hash]. The signature is read from page_file.sig, prev_hash is the last hash of the previous page.")
+		case "signcancelbind":
+			fmt.Println("usage: signcancelbind seckey deposit_addr coin_type. Prints the DELETE /api/bind request body, valid for 10 minutes.")
 		}
 		return
 	case "newkeys":
@@ -184,6 +205,53 @@ func main() {
 		}
 
 		return
+	case "exportdeposits":
+		if err := exportDeposits(*dbFile, args[1:]); err != nil {
+			fmt.Println("Export deposits failed:", err)
+			os.Exit(1)
+		}
+	case "rebuildindexes":
+		if err := rebuildIndexes(*dbFile); err != nil {
+			fmt.Println("Rebuild indexes failed:", err)
+			os.Exit(1)
+		}
+	case "verifyreport":
+		if len(args) != 3 {
+			fmt.Println("Invalid arguments")
+			return
+		}
+
+		if err := verifyReport(args[1], args[2]); err != nil {
+			fmt.Println("Verify report failed:", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Report signature is valid")
+	case "verifyauditlog":
+		if len(args) != 3 && len(args) != 4 {
+			fmt.Println("Invalid arguments")
+			return
+		}
+
+		var prevHash string
+		if len(args) == 4 {
+			prevHash = args[3]
+		}
+
+		if err := verifyAuditLog(args[1], args[2], prevHash); err != nil {
+			fmt.Println("Verify audit log failed:", err)
+			os.Exit(1)
+		}
+	case "signcancelbind":
+		if len(args) != 4 {
+			fmt.Println("Invalid arguments")
+			return
+		}
+
+		if err := signCancelBind(args[1], args[2], args[3]); err != nil {
+			fmt.Println("Sign cancel bind failed:", err)
+			os.Exit(1)
+		}
 	case "scanblock":
 		if len(args) != 6 {
 			fmt.Println("Invalid arguments")
@@ -256,3 +324,181 @@ func main() {
 		log.Printf("Unknown command: %s\n", cmd)
 	}
 }
+
+const exportDepositsUsage = `usage: [-db teller.db] exportdeposits [-format csv|jsonl] [-from date] [-to date] [-status statuses] [-coin coin_type] [-min-amount amount] [-o file]
+
+Dates are RFC3339 or YYYY-MM-DD, from is inclusive and to is exclusive.
+Statuses are comma separated, e.g. waiting_review,done.
+The min amount is in whole coins of each deposit's coin type, e.g. 0.5.
+The db is opened read-only, so stop the teller first or export a copy of its db.`
+
+// exportDeposits writes the deposits in the db matching the filter flags to stdout or a file
+func exportDeposits(dbFile string, args []string) error {
+	fs := flag.NewFlagSet("exportdeposits", flag.ContinueOnError)
+	format := fs.String("format", string(exchange.ExportCSV), "output format, csv or jsonl")
+	from := fs.String("from", "", "export deposits updated at or after this date")
+	to := fs.String("to", "", "export deposits updated before this date")
+	status := fs.String("status", "", "export deposits in these comma separated statuses")
+	coinType := fs.String("coin", "", "export deposits of this coin type")
+	minAmount := fs.String("min-amount", "", "export deposits of at least this amount")
+	out := fs.String("o", "", "output file, defaults to stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, exportDepositsUsage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	exportFormat, err := exchange.NewExportFormatFromStr(*format)
+	if err != nil {
+		return err
+	}
+
+	flt, err := exchange.NewExportFilter(*from, *to, *status, *coinType, *minAmount)
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(dbFile); err != nil {
+		return err
+	}
+
+	// The teller holds an exclusive lock on its db while running
+	db, err := bolt.Open(dbFile, 0700, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  time.Second * 3,
+	})
+	if err != nil {
+		return fmt.Errorf("Open db failed, is the teller still running? %v", err)
+	}
+	defer db.Close()
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	n, err := exchange.ExportDepositsDB(db, w, exportFormat, flt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d deposits\n", n)
+
+	return nil
+}
+
+// rebuildIndexes recreates the deposit indexes of the db from its deposits
+func rebuildIndexes(dbFile string) error {
+	if _, err := os.Stat(dbFile); err != nil {
+		return err
+	}
+
+	// The teller holds an exclusive lock on its db while running
+	db, err := bolt.Open(dbFile, 0700, &bolt.Options{
+		Timeout: time.Second * 3,
+	})
+	if err != nil {
+		return fmt.Errorf("Open db failed, is the teller still running? %v", err)
+	}
+	defer db.Close()
+
+	log, err := logger.NewLogger("", false)
+	if err != nil {
+		return err
+	}
+
+	store, err := exchange.NewStore(log, db)
+	if err != nil {
+		return err
+	}
+
+	n, err := store.RebuildDepositIndexes()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Indexed %d deposits\n", n)
+
+	return nil
+}
+
+// verifyReport verifies a reconciliation report against its signature file
+func verifyReport(pubKey, reportFile string) error {
+	report, err := ioutil.ReadFile(reportFile)
+	if err != nil {
+		return err
+	}
+
+	sig, err := ioutil.ReadFile(reportFile + reconcile.SigExt)
+	if err != nil {
+		return err
+	}
+
+	return reconcile.VerifyReport(report, string(sig), pubKey)
+}
+
+// verifyAuditLog verifies an audit log page against its signature file, and prints the cursor and hash the next page continues from
+func verifyAuditLog(pubKey, pageFile, prevHash string) error {
+	b, err := ioutil.ReadFile(pageFile)
+	if err != nil {
+		return err
+	}
+
+	sig, err := ioutil.ReadFile(pageFile + reconcile.SigExt)
+	if err != nil {
+		return err
+	}
+
+	page, err := exchange.VerifyAuditLogPage(b, string(sig), pubKey, prevHash)
+	if err != nil {
+		return err
+	}
+
+	lastHash := page.PrevHash
+	if len(page.Entries) > 0 {
+		lastHash = page.Entries[len(page.Entries)-1].Hash
+	}
+
+	fmt.Printf("Audit log page is valid: %d entries, cursor %d, last hash %s, more %v\n", len(page.Entries), page.Cursor, lastHash, page.More)
+	return nil
+}
+
+// signCancelBind prints the body of a request to cancel a binding of the skycoin address of secKey
+func signCancelBind(secKey, depositAddr, coinType string) error {
+	sec, err := cipher.SecKeyFromHex(secKey)
+	if err != nil {
+		return err
+	}
+
+	skyAddr := cipher.AddressFromSecKey(sec).String()
+	timestamp := time.Now().Unix()
+	sig := cipher.SignHash(teller.CancelBindHash(skyAddr, depositAddr, coinType, timestamp), sec)
+
+	v, err := json.MarshalIndent(struct {
+		SkyAddr     string `json:"skyaddr"`
+		DepositAddr string `json:"deposit_addr"`
+		CoinType    string `json:"coin_type"`
+		Timestamp   int64  `json:"timestamp"`
+		Sig         string `json:"sig"`
+	}{
+		SkyAddr:     skyAddr,
+		DepositAddr: depositAddr,
+		CoinType:    coinType,
+		Timestamp:   timestamp,
+		Sig:         sig.Hex(),
+	}, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(v))
+	return nil
+}
@@ -0,0 +1,420 @@
+// Package abuse detects clients whose requests look automated, and throttles them progressively.
+// A client is a client IP, or the network of an IPv6 client. Each anomalous request is a strike
+// against its client, and a client with more strikes than the strike limit is throttled, for
+// longer at each further strike. A throttled client can solve a captcha to be let through.
+package abuse
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/asnutil"
+	"github.com/skycoin/teller/src/util/clock"
+)
+
+const (
+	// pruneInterval is how often the clients which were not seen for the strike TTL are forgotten
+	pruneInterval = time.Minute
+	// statsMaxASNs is the number of ASNs with the most requests reported by Stats
+	statsMaxASNs = 20
+	// statsMaxClients is the number of clients with the most strikes reported by Stats
+	statsMaxClients = 100
+)
+
+// Anomalies which are a strike against a client
+const (
+	// AnomalyNoUserAgent is a request without a User-Agent
+	AnomalyNoUserAgent = "no_user_agent"
+	// AnomalyBotUserAgent is a request with the User-Agent of a known bot
+	AnomalyBotUserAgent = "bot_user_agent"
+	// AnomalyBurst is a client sending more than the burst max requests within the burst window
+	AnomalyBurst = "burst"
+	// AnomalyASNRate is a request from an ASN which sent more than its request limit
+	AnomalyASNRate = "asn_rate"
+)
+
+// Decision is the Guard's decision on a request
+type Decision struct {
+	// Allow is false if the request is refused because its client is throttled
+	Allow bool
+	// RetryAfter is how long the client is still throttled for
+	RetryAfter time.Duration
+	// Captcha is true if the client can solve a captcha instead of waiting
+	Captcha bool
+}
+
+// Stats are the requests seen by the Guard, for the admin API
+type Stats struct {
+	Requests uint64 `json:"requests"`
+	// Anomalous requests, by anomaly
+	Anomalies map[string]uint64 `json:"anomalies"`
+	// Requests refused because their client is throttled
+	Refused uint64 `json:"refused"`
+	// Clients throttled, counting a client each time its throttling is extended
+	Throttled        uint64 `json:"throttled"`
+	ChallengesPassed uint64 `json:"challenges_passed"`
+	ChallengesFailed uint64 `json:"challenges_failed"`
+	// Number of networks in the ASN db
+	ASNNetworks int `json:"asn_networks"`
+	// The ASNs which sent the most requests
+	ASNs []ASNStats `json:"asns"`
+	// The clients with the most strikes
+	Clients []ClientStats `json:"clients"`
+}
+
+// ASNStats are the requests of an ASN
+type ASNStats struct {
+	asnutil.ASN
+	Requests uint64 `json:"requests"`
+	// Requests in the current asn_throttle_duration
+	WindowRequests int64  `json:"window_requests"`
+	Anomalies      uint64 `json:"anomalies"`
+}
+
+// ClientStats are the strikes of a client
+type ClientStats struct {
+	Client         string     `json:"client"`
+	ASN            uint32     `json:"asn"`
+	Strikes        int        `json:"strikes"`
+	LastStrike     time.Time  `json:"last_strike"`
+	ThrottledUntil *time.Time `json:"throttled_until,omitempty"`
+}
+
+type client struct {
+	asn            asnutil.ASN
+	hasASN         bool
+	strikes        int
+	lastStrike     time.Time
+	lastSeen       time.Time
+	throttledUntil time.Time
+	burstStart     time.Time
+	burstCount     int64
+}
+
+type asnCounter struct {
+	asn         asnutil.ASN
+	windowStart time.Time
+	count       int64
+	requests    uint64
+	anomalies   uint64
+}
+
+// Guard tracks the request patterns of clients and ASNs, and throttles anomalous clients
+type Guard struct {
+	sync.Mutex
+	log       logrus.FieldLogger
+	cfg       config.Abuse
+	clock     clock.Clock
+	asnDB     *asnutil.DB
+	botAgents []string
+	captcha   *captchaVerifier
+	clients   map[string]*client
+	asns      map[uint32]*asnCounter
+	lastPrune time.Time
+	stats     Stats
+}
+
+// New creates a Guard and loads its ASN db. It returns nil if abuse detection is disabled, which is safe to use.
+func New(log logrus.FieldLogger, cfg config.Abuse, clk clock.Clock) (*Guard, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	asnDB, err := asnutil.LoadFiles(cfg.ASNFiles...)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Guard{
+		log:     log.WithField("prefix", "abuse"),
+		cfg:     cfg,
+		clock:   clk,
+		asnDB:   asnDB,
+		clients: make(map[string]*client),
+		asns:    make(map[uint32]*asnCounter),
+		stats: Stats{
+			Anomalies:   make(map[string]uint64),
+			ASNNetworks: asnDB.Len(),
+		},
+	}
+
+	for _, a := range cfg.BotUserAgents {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" {
+			g.botAgents = append(g.botAgents, a)
+		}
+	}
+
+	if cfg.CaptchaVerifyURL != "" {
+		g.captcha = newCaptchaVerifier(cfg.CaptchaVerifyURL, cfg.CaptchaSecret)
+	}
+
+	return g, nil
+}
+
+// Check decides if the request of a client is allowed. key identifies the client,
+// and ip is its IP, to look up its ASN. captchaToken is the client's captcha solution, if any.
+func (g *Guard) Check(key string, ip net.IP, userAgent, captchaToken string) Decision {
+	if g == nil {
+		return Decision{Allow: true}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	now := g.clock.Now()
+	g.stats.Requests++
+	g.prune(now)
+
+	c := g.clients[key]
+	if c == nil {
+		c = &client{}
+		if ip != nil {
+			c.asn, c.hasASN = g.asnDB.Lookup(ip)
+		}
+		g.clients[key] = c
+	}
+	c.lastSeen = now
+
+	if c.strikes != 0 && now.Sub(c.lastStrike) >= g.cfg.StrikeTTL {
+		c.strikes = 0
+	}
+
+	log := g.log.WithFields(logrus.Fields{
+		"client": key,
+		"asn":    c.asn.Number,
+	})
+
+	if now.Before(c.throttledUntil) {
+		if g.captcha != nil && captchaToken != "" {
+			if g.verifyCaptcha(log, captchaToken, ip) {
+				g.stats.ChallengesPassed++
+				log.Info("Client solved a captcha, no longer throttled")
+				*c = client{
+					asn:      c.asn,
+					hasASN:   c.hasASN,
+					lastSeen: now,
+				}
+				return Decision{Allow: true}
+			}
+			g.stats.ChallengesFailed++
+		}
+
+		g.stats.Refused++
+		return g.refuse(c, now)
+	}
+
+	anomalies := g.anomalies(c, userAgent, now)
+	for _, a := range anomalies {
+		g.stats.Anomalies[a]++
+		c.strikes++
+		c.lastStrike = now
+	}
+
+	if len(anomalies) == 0 || c.strikes <= g.cfg.StrikeLimit {
+		return Decision{Allow: true}
+	}
+
+	penalty := g.penalty(c.strikes)
+	c.throttledUntil = now.Add(penalty)
+	g.stats.Throttled++
+	g.stats.Refused++
+
+	log.WithFields(logrus.Fields{
+		"anomalies": anomalies,
+		"strikes":   c.strikes,
+		"penalty":   penalty,
+	}).Warn("Throttling anomalous client")
+
+	return g.refuse(c, now)
+}
+
+func (g *Guard) refuse(c *client, now time.Time) Decision {
+	return Decision{
+		RetryAfter: c.throttledUntil.Sub(now),
+		Captcha:    g.captcha != nil,
+	}
+}
+
+// penalty returns how long a client with strikes over the strike limit is throttled for,
+// doubling at each strike
+func (g *Guard) penalty(strikes int) time.Duration {
+	p := g.cfg.Penalty
+	for i := g.cfg.StrikeLimit + 1; i < strikes && p < g.cfg.MaxPenalty; i++ {
+		p *= 2
+	}
+
+	if p > g.cfg.MaxPenalty {
+		p = g.cfg.MaxPenalty
+	}
+
+	return p
+}
+
+// anomalies returns the anomalies of a client's request
+func (g *Guard) anomalies(c *client, userAgent string, now time.Time) []string {
+	var anomalies []string
+
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		anomalies = append(anomalies, AnomalyNoUserAgent)
+	} else {
+		for _, a := range g.botAgents {
+			if strings.Contains(ua, a) {
+				anomalies = append(anomalies, AnomalyBotUserAgent)
+				break
+			}
+		}
+	}
+
+	if g.cfg.BurstMax > 0 {
+		if now.Sub(c.burstStart) >= g.cfg.BurstWindow {
+			c.burstStart = now
+			c.burstCount = 0
+		}
+		c.burstCount++
+
+		// A burst is one strike, however long it lasts
+		if c.burstCount == g.cfg.BurstMax+1 {
+			anomalies = append(anomalies, AnomalyBurst)
+		}
+	}
+
+	if c.hasASN {
+		a := g.asns[c.asn.Number]
+		if a == nil {
+			a = &asnCounter{
+				asn: c.asn,
+			}
+			g.asns[c.asn.Number] = a
+		}
+		a.requests++
+
+		if g.cfg.ASNThrottleMax > 0 {
+			if now.Sub(a.windowStart) >= g.cfg.ASNThrottleDuration {
+				a.windowStart = now
+				a.count = 0
+			}
+			a.count++
+
+			if a.count > g.cfg.ASNThrottleMax {
+				anomalies = append(anomalies, AnomalyASNRate)
+			}
+		}
+
+		if len(anomalies) != 0 {
+			a.anomalies++
+		}
+	}
+
+	return anomalies
+}
+
+// prune forgets the clients which have no strikes left and are not throttled
+func (g *Guard) prune(now time.Time) {
+	if now.Sub(g.lastPrune) < pruneInterval {
+		return
+	}
+	g.lastPrune = now
+
+	for k, c := range g.clients {
+		if now.Sub(c.lastSeen) >= g.cfg.StrikeTTL && !now.Before(c.throttledUntil) {
+			delete(g.clients, k)
+		}
+	}
+}
+
+// Stats returns the stats of the requests seen so far
+func (g *Guard) Stats() Stats {
+	if g == nil {
+		return Stats{}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	now := g.clock.Now()
+
+	s := g.stats
+	s.Anomalies = make(map[string]uint64, len(g.stats.Anomalies))
+	for k, v := range g.stats.Anomalies {
+		s.Anomalies[k] = v
+	}
+
+	s.ASNs = make([]ASNStats, 0, len(g.asns))
+	for _, a := range g.asns {
+		as := ASNStats{
+			ASN:       a.asn,
+			Requests:  a.requests,
+			Anomalies: a.anomalies,
+		}
+		if now.Sub(a.windowStart) < g.cfg.ASNThrottleDuration {
+			as.WindowRequests = a.count
+		}
+		s.ASNs = append(s.ASNs, as)
+	}
+	sort.Slice(s.ASNs, func(i, j int) bool {
+		if s.ASNs[i].Requests != s.ASNs[j].Requests {
+			return s.ASNs[i].Requests > s.ASNs[j].Requests
+		}
+		return s.ASNs[i].Number < s.ASNs[j].Number
+	})
+	if len(s.ASNs) > statsMaxASNs {
+		s.ASNs = s.ASNs[:statsMaxASNs]
+	}
+
+	s.Clients = []ClientStats{}
+	for k, c := range g.clients {
+		throttled := now.Before(c.throttledUntil)
+		if c.strikes == 0 && !throttled {
+			continue
+		}
+
+		cs := ClientStats{
+			Client:     k,
+			ASN:        c.asn.Number,
+			Strikes:    c.strikes,
+			LastStrike: c.lastStrike,
+		}
+		if throttled {
+			until := c.throttledUntil
+			cs.ThrottledUntil = &until
+		}
+		s.Clients = append(s.Clients, cs)
+	}
+	sort.Slice(s.Clients, func(i, j int) bool {
+		if s.Clients[i].Strikes != s.Clients[j].Strikes {
+			return s.Clients[i].Strikes > s.Clients[j].Strikes
+		}
+		return s.Clients[i].Client < s.Clients[j].Client
+	})
+	if len(s.Clients) > statsMaxClients {
+		s.Clients = s.Clients[:statsMaxClients]
+	}
+
+	return s
+}
+
+// verifyCaptcha verifies a captcha solution. The lock is released while the captcha service is called.
+func (g *Guard) verifyCaptcha(log logrus.FieldLogger, token string, ip net.IP) bool {
+	g.Unlock()
+	defer g.Lock()
+
+	var remoteIP string
+	if ip != nil {
+		remoteIP = ip.String()
+	}
+
+	ok, err := g.captcha.verify(token, remoteIP)
+	if err != nil {
+		log.WithError(err).Error("Captcha verification failed")
+		return false
+	}
+
+	return ok
+}
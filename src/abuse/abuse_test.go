@@ -0,0 +1,256 @@
+package abuse
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+const browserUA = "Mozilla/5.0 (X11; Linux x86_64; rv:60.0) Gecko/20100101 Firefox/60.0"
+
+func testConfig() config.Abuse {
+	return config.Abuse{
+		Enabled:       true,
+		BotUserAgents: []string{"curl", "Python-Requests", " "},
+		BurstMax:      5,
+		BurstWindow:   time.Second * 10,
+		StrikeLimit:   2,
+		StrikeTTL:     time.Hour,
+		Penalty:       time.Minute,
+		MaxPenalty:    time.Minute * 3,
+	}
+}
+
+func newTestGuard(t *testing.T, cfg config.Abuse) (*Guard, *clock.Fake) {
+	log, _ := testutil.NewLogger(t)
+	clk := clock.NewFake(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+	g, err := New(log, cfg, clk)
+	require.NoError(t, err)
+	require.NotNil(t, g)
+	return g, clk
+}
+
+func TestNewDisabled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	g, err := New(log, config.Abuse{}, clock.Real{})
+	require.NoError(t, err)
+	require.Nil(t, g)
+
+	// A nil Guard allows everything
+	require.Equal(t, Decision{Allow: true}, g.Check("1.2.3.4", nil, "", ""))
+	require.Equal(t, Stats{}, g.Stats())
+
+	_, err = New(log, config.Abuse{
+		Enabled:  true,
+		ASNFiles: []string{"missing.csv"},
+	}, clock.Real{})
+	require.Error(t, err)
+}
+
+func TestCheckUserAgent(t *testing.T) {
+	cfg := testConfig()
+	cfg.BurstMax = 0
+	g, clk := newTestGuard(t, cfg)
+	ip := net.ParseIP("1.2.3.4")
+
+	// Browsers are never throttled
+	for i := 0; i < 5; i++ {
+		require.True(t, g.Check("1.2.3.4", ip, browserUA, "").Allow)
+	}
+
+	// Strikes up to the strike limit are allowed
+	require.True(t, g.Check("1.2.3.4", ip, "curl/7.58.0", "").Allow)
+	require.True(t, g.Check("1.2.3.4", ip, "", "").Allow)
+
+	// The first strike over the limit is throttled for the penalty
+	d := g.Check("1.2.3.4", ip, "python-requests/2.18", "")
+	require.Equal(t, Decision{RetryAfter: time.Minute}, d)
+
+	// Requests while throttled are refused, and aren't strikes
+	clk.Advance(time.Second * 30)
+	d = g.Check("1.2.3.4", ip, "curl/7.58.0", "")
+	require.Equal(t, Decision{RetryAfter: time.Second * 30}, d)
+
+	// Other clients aren't affected
+	require.True(t, g.Check("1.2.3.5", net.ParseIP("1.2.3.5"), browserUA, "").Allow)
+
+	// The penalty doubles at each further strike, up to the max penalty
+	clk.Advance(time.Second * 30)
+	require.True(t, g.Check("1.2.3.4", ip, browserUA, "").Allow)
+	d = g.Check("1.2.3.4", ip, "curl/7.58.0", "")
+	require.Equal(t, Decision{RetryAfter: time.Minute * 2}, d)
+
+	clk.Advance(time.Minute * 2)
+	d = g.Check("1.2.3.4", ip, "curl/7.58.0", "")
+	require.Equal(t, Decision{RetryAfter: time.Minute * 3}, d)
+
+	clk.Advance(time.Minute * 3)
+	d = g.Check("1.2.3.4", ip, "curl/7.58.0", "")
+	require.Equal(t, Decision{RetryAfter: time.Minute * 3}, d)
+
+	// Strikes are forgotten after the strike TTL
+	clk.Advance(time.Hour)
+	require.True(t, g.Check("1.2.3.4", ip, "curl/7.58.0", "").Allow)
+
+	s := g.Stats()
+	require.Equal(t, uint64(15), s.Requests)
+	require.Equal(t, map[string]uint64{
+		AnomalyBotUserAgent: 6,
+		AnomalyNoUserAgent:  1,
+	}, s.Anomalies)
+	require.Equal(t, uint64(4), s.Throttled)
+	require.Equal(t, uint64(5), s.Refused)
+	require.Len(t, s.Clients, 1)
+	require.Equal(t, "1.2.3.4", s.Clients[0].Client)
+	require.Equal(t, 1, s.Clients[0].Strikes)
+	require.Nil(t, s.Clients[0].ThrottledUntil)
+}
+
+func TestCheckBurst(t *testing.T) {
+	cfg := testConfig()
+	cfg.StrikeLimit = 1
+	g, clk := newTestGuard(t, cfg)
+
+	// A burst is one strike
+	for i := 0; i < 20; i++ {
+		require.True(t, g.Check("1.2.3.4", nil, browserUA, "").Allow, i)
+	}
+
+	clk.Advance(time.Second * 10)
+	for i := 0; i < 5; i++ {
+		require.True(t, g.Check("1.2.3.4", nil, browserUA, "").Allow, i)
+	}
+
+	d := g.Check("1.2.3.4", nil, browserUA, "")
+	require.Equal(t, Decision{RetryAfter: time.Minute}, d)
+
+	s := g.Stats()
+	require.Equal(t, map[string]uint64{
+		AnomalyBurst: 2,
+	}, s.Anomalies)
+	require.Len(t, s.Clients, 1)
+	require.NotNil(t, s.Clients[0].ThrottledUntil)
+	require.Equal(t, clk.Now().Add(time.Minute), *s.Clients[0].ThrottledUntil)
+}
+
+func TestCheckASN(t *testing.T) {
+	dir, err := ioutil.TempDir("", "abuse")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	asnFile := filepath.Join(dir, "GeoLite2-ASN-Blocks-IPv4.csv")
+	require.NoError(t, ioutil.WriteFile(asnFile, []byte(`network,autonomous_system_number,autonomous_system_organization
+10.0.0.0/8,64496,HOSTING
+192.168.0.0/16,64497,ISP
+`), 0600))
+
+	cfg := testConfig()
+	cfg.ASNFiles = []string{asnFile}
+	cfg.ASNThrottleMax = 3
+	cfg.ASNThrottleDuration = time.Minute
+	cfg.BurstMax = 0
+	cfg.StrikeLimit = 0
+	g, clk := newTestGuard(t, cfg)
+
+	// The ASN limit is shared by the ASN's clients
+	for i := 0; i < 3; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i+1)
+		require.True(t, g.Check(ip, net.ParseIP(ip), browserUA, "").Allow)
+	}
+	require.False(t, g.Check("10.0.0.4", net.ParseIP("10.0.0.4"), browserUA, "").Allow)
+
+	// Other ASNs and IPs without an ASN aren't limited
+	for i := 0; i < 3; i++ {
+		require.True(t, g.Check("192.168.0.1", net.ParseIP("192.168.0.1"), browserUA, "").Allow)
+		require.True(t, g.Check("8.8.8.8", net.ParseIP("8.8.8.8"), browserUA, "").Allow)
+	}
+
+	// The ASN's window resets
+	clk.Advance(time.Minute)
+	require.True(t, g.Check("10.0.0.5", net.ParseIP("10.0.0.5"), browserUA, "").Allow)
+
+	s := g.Stats()
+	require.Equal(t, 2, s.ASNNetworks)
+	require.Equal(t, map[string]uint64{
+		AnomalyASNRate: 1,
+	}, s.Anomalies)
+	require.Len(t, s.ASNs, 2)
+	require.Equal(t, uint32(64496), s.ASNs[0].Number)
+	require.Equal(t, "HOSTING", s.ASNs[0].Organization)
+	require.Equal(t, uint64(5), s.ASNs[0].Requests)
+	require.Equal(t, int64(1), s.ASNs[0].WindowRequests)
+	require.Equal(t, uint64(1), s.ASNs[0].Anomalies)
+	require.Equal(t, uint32(64497), s.ASNs[1].Number)
+	require.Equal(t, uint64(3), s.ASNs[1].Requests)
+	require.Len(t, s.Clients, 1)
+	require.Equal(t, "10.0.0.4", s.Clients[0].Client)
+	require.Equal(t, uint32(64496), s.Clients[0].ASN)
+}
+
+func TestCheckCaptcha(t *testing.T) {
+	var form url.Values
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		form = r.PostForm
+		fmt.Fprintf(w, `{"success": %t}`, r.PostForm.Get("response") == "solved")
+	}))
+	defer ts.Close()
+
+	cfg := testConfig()
+	cfg.StrikeLimit = 0
+	cfg.CaptchaVerifyURL = ts.URL
+	cfg.CaptchaSecret = "secret"
+	g, clk := newTestGuard(t, cfg)
+	ip := net.ParseIP("1.2.3.4")
+
+	d := g.Check("1.2.3.4", ip, "curl/7.58.0", "")
+	require.Equal(t, Decision{RetryAfter: time.Minute, Captcha: true}, d)
+
+	// A wrong solution is refused
+	d = g.Check("1.2.3.4", ip, browserUA, "wrong")
+	require.Equal(t, Decision{RetryAfter: time.Minute, Captcha: true}, d)
+	require.Equal(t, "secret", form.Get("secret"))
+	require.Equal(t, "wrong", form.Get("response"))
+	require.Equal(t, "1.2.3.4", form.Get("remoteip"))
+
+	// A solved captcha clears the client's strikes
+	clk.Advance(time.Second)
+	require.Equal(t, Decision{Allow: true}, g.Check("1.2.3.4", ip, browserUA, "solved"))
+	require.True(t, g.Check("1.2.3.4", ip, browserUA, "").Allow)
+
+	s := g.Stats()
+	require.Equal(t, uint64(1), s.ChallengesPassed)
+	require.Equal(t, uint64(1), s.ChallengesFailed)
+	require.Empty(t, s.Clients)
+
+	// The client is throttled if the captcha service fails
+	g.Check("1.2.3.4", ip, "curl/7.58.0", "")
+	ts.Close()
+	require.False(t, g.Check("1.2.3.4", ip, browserUA, "solved").Allow)
+}
+
+func TestPrune(t *testing.T) {
+	g, clk := newTestGuard(t, testConfig())
+
+	g.Check("1.2.3.4", nil, browserUA, "")
+	g.Check("1.2.3.5", nil, "", "")
+	require.Len(t, g.clients, 2)
+
+	clk.Advance(time.Hour)
+	g.Check("1.2.3.6", nil, browserUA, "")
+	require.Len(t, g.clients, 1)
+	require.NotNil(t, g.clients["1.2.3.6"])
+}
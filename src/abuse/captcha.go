@@ -0,0 +1,63 @@
+package abuse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	captchaVerifyTimeout = time.Second * 10
+	// captchaResponseLimit bounds how much of the captcha service's response is read
+	captchaResponseLimit = 4096
+)
+
+// captchaVerifier verifies captcha solutions with a siteverify style service,
+// such as hCaptcha's or reCAPTCHA's
+type captchaVerifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func newCaptchaVerifier(verifyURL, secret string) *captchaVerifier {
+	return &captchaVerifier{
+		url:    verifyURL,
+		secret: secret,
+		client: httpclient.New(captchaVerifyTimeout),
+	}
+}
+
+func (v *captchaVerifier) verify(token, remoteIP string) (bool, error) {
+	form := url.Values{
+		"secret":   {v.secret},
+		"response": {token},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+
+	rsp, err := v.client.PostForm(v.url, form)
+	if err != nil {
+		return false, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Captcha service returned status %d", rsp.StatusCode)
+	}
+
+	var r struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(io.LimitReader(rsp.Body, captchaResponseLimit)).Decode(&r); err != nil {
+		return false, err
+	}
+
+	return r.Success, nil
+}
@@ -4,39 +4,61 @@ package addrs
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/boltdb/bolt"
 	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/errutil"
 )
 
 // ErrDepositAddressEmpty represents all deposit addresses are used
-var ErrDepositAddressEmpty = errors.New("Deposit address pool is empty")
+var ErrDepositAddressEmpty = errutil.New(errutil.Unavailable, "Deposit address pool is empty")
 var ErrCointypeNotExists = errors.New("Cointype not exists")
 
 // AddrGenerator generate new deposit address
 type AddrGenerator interface {
 	NewAddress() (string, error)
+	Remaining() uint64
+	Return(addr string) error
 }
 
 // Addrs manages deposit addresses
 type Addrs struct {
 	sync.RWMutex
 	log       logrus.FieldLogger
-	used      *Store   // all used addresses
-	addresses []string // address pool for deposit
+	used      *Store       // all used addresses
+	addresses []string     // address pool for deposit
+	shard     *ShardStatus // the pool's shard, nil if the pool is not sharded
 }
 
-// AddrManager control all AddrGenerator according to coinType
+// AddrManager control all AddrGenerator according to coinType.
+// Address requests of each coin type are served in FIFO order by an allocation queue.
 type AddrManager struct {
 	Mutex    sync.RWMutex
 	AGHolder map[string]AddrGenerator
 	AGcount  int
+	cfg      AllocConfig
+	queues   map[string]*allocQueue
 }
 
 // NewAddrManager create a Manager
-func NewAddrManager() *AddrManager {
-	return &AddrManager{AGcount: 0, AGHolder: make(map[string]AddrGenerator)}
+func NewAddrManager(cfg AllocConfig) *AddrManager {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultAllocQueueSize
+	}
+
+	if cfg.MaxWait <= 0 {
+		cfg.MaxWait = defaultAllocMaxWait
+	}
+
+	return &AddrManager{
+		AGcount:  0,
+		AGHolder: make(map[string]AddrGenerator),
+		cfg:      cfg,
+		queues:   make(map[string]*allocQueue),
+	}
 }
 
 // PushGenerator add a AddrGenerater with coinType
@@ -48,18 +70,28 @@ func (am *AddrManager) PushGenerator(ag AddrGenerator, coinType string) error {
 		return errors.New("coinType already exists")
 	}
 	am.AGHolder[coinType] = ag
+	am.queues[coinType] = newAllocQueue(am.cfg)
 	am.AGcount++
 	return nil
 }
 
-// NewAddress return new address according to coinType
+// NewAddress return new address according to coinType.
+// Waits in the coinType's allocation queue, returning ErrAllocQueueFull
+// or ErrAllocTimeout if the queue is too long or the wait exceeds the max wait.
 func (am *AddrManager) NewAddress(coinType string) (string, error) {
-	am.Mutex.Lock()
-	defer am.Mutex.Unlock()
+	am.Mutex.RLock()
 	ag, ok := am.AGHolder[coinType]
+	q := am.queues[coinType]
+	am.Mutex.RUnlock()
 	if !ok {
 		return "", ErrCointypeNotExists
 	}
+
+	if err := q.acquire(); err != nil {
+		return "", err
+	}
+	defer q.release()
+
 	depositAddr, err := ag.NewAddress()
 	if err != nil {
 		return "", err
@@ -67,6 +99,53 @@ func (am *AddrManager) NewAddress(coinType string) (string, error) {
 	return depositAddr, nil
 }
 
+// Remaining returns the number of unused addresses left for coinType
+func (am *AddrManager) Remaining(coinType string) (uint64, error) {
+	am.Mutex.RLock()
+	defer am.Mutex.RUnlock()
+	ag, ok := am.AGHolder[coinType]
+	if !ok {
+		return 0, ErrCointypeNotExists
+	}
+	return ag.Remaining(), nil
+}
+
+// ReturnAddress returns a deposit address of coinType to its pool, to be assigned again
+func (am *AddrManager) ReturnAddress(coinType, addr string) error {
+	am.Mutex.RLock()
+	defer am.Mutex.RUnlock()
+	ag, ok := am.AGHolder[coinType]
+	if !ok {
+		return ErrCointypeNotExists
+	}
+	return ag.Return(addr)
+}
+
+// CoinTypes returns the coin types with an address generator, sorted
+func (am *AddrManager) CoinTypes() []string {
+	am.Mutex.RLock()
+	defer am.Mutex.RUnlock()
+
+	coinTypes := make([]string, 0, len(am.AGHolder))
+	for coinType := range am.AGHolder {
+		coinTypes = append(coinTypes, coinType)
+	}
+	sort.Strings(coinTypes)
+	return coinTypes
+}
+
+// QueueStats returns the allocation queue stats of every coin type
+func (am *AddrManager) QueueStats() map[string]QueueStats {
+	am.Mutex.RLock()
+	defer am.Mutex.RUnlock()
+
+	stats := make(map[string]QueueStats, len(am.queues))
+	for coinType, q := range am.queues {
+		stats[coinType] = q.getStats()
+	}
+	return stats
+}
+
 // NewAddrs creates Addrs instance, will load and verify the addresses
 func NewAddrs(log logrus.FieldLogger, db *bolt.DB, addresses []string, bucketKey string) (*Addrs, error) {
 	used, err := NewStore(db, bucketKey)
@@ -143,3 +222,23 @@ func (a *Addrs) Remaining() uint64 {
 
 	return uint64(len(a.addresses))
 }
+
+// Return marks a used address as unused, adding it to the end of the pool.
+// Returning an address which is not used is not an error.
+func (a *Addrs) Return(addr string) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if used, err := a.used.IsUsed(addr); err != nil {
+		return err
+	} else if !used {
+		return nil
+	}
+
+	if err := a.used.Delete(addr); err != nil {
+		return fmt.Errorf("Delete address from used pool failed: %v", err)
+	}
+
+	a.addresses = append(a.addresses, addr)
+	return nil
+}
@@ -190,7 +190,7 @@ func TestAddrManager(t *testing.T) {
 	typeB := "TOKENB"
 	typeE := "TOKENE"
 
-	addrManager := NewAddrManager()
+	addrManager := NewAddrManager(AllocConfig{})
 	//add generator to addrManager
 	addrManager.PushGenerator(btcGen, typeB)
 	addrManager.PushGenerator(ethGen, typeE)
@@ -232,3 +232,91 @@ func TestAddrManager(t *testing.T) {
 	_, err = addrManager.NewAddress("OTHERTYPE")
 	require.Equal(t, ErrCointypeNotExists, err)
 }
+
+func TestAddrManagerRemaining(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	btcGen, btcAddresses := testNewBtcAddrManager(t, db, log)
+
+	addrManager := NewAddrManager(AllocConfig{})
+	require.NoError(t, addrManager.PushGenerator(btcGen, "TOKENB"))
+
+	n, err := addrManager.Remaining("TOKENB")
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(btcAddresses)), n)
+
+	_, err = addrManager.NewAddress("TOKENB")
+	require.NoError(t, err)
+
+	n, err = addrManager.Remaining("TOKENB")
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(btcAddresses)-1), n)
+
+	_, err = addrManager.Remaining("OTHERTYPE")
+	require.Equal(t, ErrCointypeNotExists, err)
+}
+
+func TestAddrManagerReturnAddress(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	btcGen, btcAddresses := testNewBtcAddrManager(t, db, log)
+
+	addrManager := NewAddrManager(AllocConfig{})
+	require.NoError(t, addrManager.PushGenerator(btcGen, "TOKENB"))
+
+	addr, err := addrManager.NewAddress("TOKENB")
+	require.NoError(t, err)
+
+	used, err := btcGen.used.IsUsed(addr)
+	require.NoError(t, err)
+	require.True(t, used)
+
+	require.NoError(t, addrManager.ReturnAddress("TOKENB", addr))
+
+	used, err = btcGen.used.IsUsed(addr)
+	require.NoError(t, err)
+	require.False(t, used)
+
+	n, err := addrManager.Remaining("TOKENB")
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(btcAddresses)), n)
+
+	// Returning an unused address does nothing
+	require.NoError(t, addrManager.ReturnAddress("TOKENB", addr))
+	n, err = addrManager.Remaining("TOKENB")
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(btcAddresses)), n)
+
+	// The returned address is assigned after the others
+	for i := 0; i < len(btcAddresses)-1; i++ {
+		a, err := addrManager.NewAddress("TOKENB")
+		require.NoError(t, err)
+		require.NotEqual(t, addr, a)
+	}
+	a, err := addrManager.NewAddress("TOKENB")
+	require.NoError(t, err)
+	require.Equal(t, addr, a)
+
+	err = addrManager.ReturnAddress("OTHERTYPE", addr)
+	require.Equal(t, ErrCointypeNotExists, err)
+}
+
+func TestAddrManagerCoinTypes(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	addrManager := NewAddrManager(AllocConfig{})
+	require.Empty(t, addrManager.CoinTypes())
+
+	btcGen, _ := testNewBtcAddrManager(t, db, log)
+	ethGen, _ := testNewEthAddrManager(t, db, log)
+	require.NoError(t, addrManager.PushGenerator(ethGen, "TOKENE"))
+	require.NoError(t, addrManager.PushGenerator(btcGen, "TOKENB"))
+
+	require.Equal(t, []string{"TOKENB", "TOKENE"}, addrManager.CoinTypes())
+}
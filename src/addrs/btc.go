@@ -10,17 +10,22 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/scanner"
 )
 
 const btcBucketKey = "used_btc_address"
 
-// NewBTCAddrs returns an Addrs loaded with BTC addresses
-func NewBTCAddrs(log logrus.FieldLogger, db *bolt.DB, addrsReader io.Reader) (*Addrs, error) {
+// NewBTCAddrs returns an Addrs loaded with BTC addresses.
+// It returns a PoolReport if any address could be assigned twice.
+func NewBTCAddrs(log logrus.FieldLogger, db *bolt.DB, addrsReader io.Reader, checks PoolChecks) (*Addrs, error) {
 	loader, err := loadBTCAddresses(addrsReader)
 	if err != nil {
 		return nil, err
 	}
-	return NewAddrs(log, db, loader, btcBucketKey)
+	return newCheckedAddrs(log, db, loader, btcBucketKey, scanner.CoinTypeBTC, func(addr string) string {
+		return addr
+	}, checks)
 }
 
 func loadBTCAddresses(addrsReader io.Reader) ([]string, error) {
@@ -44,18 +49,10 @@ func verifyBTCAddresses(addrs []string) error {
 		return errors.New("No BTC addresses")
 	}
 
-	addrMap := make(map[string]struct{}, len(addrs))
-
 	for _, addr := range addrs {
-		if _, ok := addrMap[addr]; ok {
-			return fmt.Errorf("Duplicate deposit address `%s`", addr)
-		}
-
 		if _, err := cipher.BitcoinDecodeBase58Address(addr); err != nil {
 			return fmt.Errorf("Invalid deposit address `%s`: %v", addr, err)
 		}
-
-		addrMap[addr] = struct{}{}
 	}
 
 	return nil
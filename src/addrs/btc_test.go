@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/util/testutil"
 )
 
@@ -26,7 +27,7 @@ func TestNewBTCAddrsAllValid(t *testing.T) {
     ]
 }`
 
-	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Nil(t, err)
 	require.NotNil(t, btcAddrMgr)
@@ -49,7 +50,7 @@ func TestNewBtcAddrsContainsInvalid(t *testing.T) {
 
 	expectedErr := errors.New("Invalid deposit address `bad`: Invalid address length")
 
-	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -71,9 +72,12 @@ func TestNewBtcAddrsContainsDuplicated(t *testing.T) {
     ]
 }`
 
-	expectedErr := errors.New("Duplicate deposit address `14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj`")
+	expectedErr := PoolReport{
+		CoinType:   scanner.CoinTypeBTC,
+		Duplicates: []string{"14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj"},
+	}
 
-	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -92,7 +96,7 @@ func TestNewBTCAddrsContainsNull(t *testing.T) {
 
 	expectedErr := errors.New("No BTC addresses")
 
-	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -109,7 +113,7 @@ func TestNewBTCAddrsBadFormat(t *testing.T) {
 
 	expectedErr := errors.New("Decode loaded address json failed: EOF")
 
-	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	btcAddrMgr, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -0,0 +1,170 @@
+package addrs
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+// BindingChecker reports whether a deposit address is bound to a skycoin address
+type BindingChecker interface {
+	IsBound(depositAddr, coinType string) (bool, error)
+}
+
+// HistoryChecker reports whether an address has on-chain transactions
+type HistoryChecker interface {
+	HasHistory(addr string) (bool, error)
+}
+
+// PoolChecks are the optional checks of the unused addresses of a loaded pool
+type PoolChecks struct {
+	// Bindings finds unused addresses which are already bound, e.g. by the exchange's db
+	Bindings BindingChecker
+	// History finds unused addresses which already have on-chain transactions
+	History HistoryChecker
+	// Shard limits the pool to this instance's shard of the addresses file, nil to load the whole file
+	Shard *Shard
+}
+
+// PoolReport lists the addresses of a pool which could be assigned twice.
+// It is returned as an error when a pool is loaded with any such address.
+type PoolReport struct {
+	CoinType string
+	// Addresses appearing more than once in the addresses file
+	Duplicates []string
+	// Unused addresses which are bound to a skycoin address in the db, by a prior run
+	Bound []string
+	// Unused addresses with on-chain transactions
+	WithHistory []string
+}
+
+func (r PoolReport) empty() bool {
+	return len(r.Duplicates) == 0 && len(r.Bound) == 0 && len(r.WithHistory) == 0
+}
+
+func (r PoolReport) Error() string {
+	lines := []string{fmt.Sprintf("%s deposit address pool can't be used, addresses would be assigned twice:", r.CoinType)}
+
+	add := func(addrs []string, what string) {
+		if len(addrs) != 0 {
+			lines = append(lines, fmt.Sprintf("%d %s: %s", len(addrs), what, strings.Join(addrs, ", ")))
+		}
+	}
+
+	add(r.Duplicates, "appear more than once in the addresses file")
+	add(r.Bound, "are not marked used but are already bound to a skycoin address")
+	add(r.WithHistory, "are not marked used but already have on-chain transactions")
+
+	return strings.Join(lines, "\n\t")
+}
+
+// findDuplicates returns the addresses which appear more than once, in order of their first repeat.
+// Addresses are compared by their key, e.g. lowercase ETH addresses.
+func findDuplicates(addrs []string, key func(string) string) []string {
+	seen := make(map[string]int, len(addrs))
+	var dups []string
+	for _, addr := range addrs {
+		k := key(addr)
+		seen[k]++
+		if seen[k] == 2 {
+			dups = append(dups, addr)
+		}
+	}
+	return dups
+}
+
+// newCheckedAddrs creates an Addrs and checks its addresses. It returns a PoolReport
+// if any address could be assigned twice. Duplicates are found by comparing the addresses' keys.
+func newCheckedAddrs(log logrus.FieldLogger, db *bolt.DB, addresses []string, bucketKey, coinType string, key func(string) string, checks PoolChecks) (*Addrs, error) {
+	report := PoolReport{
+		CoinType:   coinType,
+		Duplicates: findDuplicates(addresses, key),
+	}
+
+	// Duplicates are found in the whole file, since the other shards' addresses are assigned by other instances
+	var shard *ShardStatus
+	if checks.Shard != nil {
+		used, err := NewStore(db, bucketKey)
+		if err != nil {
+			return nil, err
+		}
+
+		poolSize := len(addresses)
+		var usedOutside int
+		addresses, usedOutside, err = shardAddresses(coinType, addresses, checks.Shard, used)
+		if err != nil {
+			return nil, err
+		}
+
+		r := checks.Shard.Range()
+		shard = &ShardStatus{
+			CoinType:    coinType,
+			Index:       checks.Shard.Index,
+			Count:       len(checks.Shard.Ranges),
+			Start:       r.Start,
+			End:         r.End,
+			PoolSize:    poolSize,
+			UsedOutside: usedOutside,
+		}
+
+		if usedOutside != 0 {
+			log.WithFields(logrus.Fields{
+				"coinType":    coinType,
+				"shard":       checks.Shard.Index,
+				"usedOutside": usedOutside,
+			}).Warn("The db marks addresses of other shards used, the instances owning them may assign them again")
+		}
+	}
+
+	a, err := NewAddrs(log, db, addresses, bucketKey)
+	if err != nil {
+		return nil, err
+	}
+	a.shard = shard
+
+	if err := a.check(coinType, checks, &report); err != nil {
+		return nil, err
+	}
+
+	if !report.empty() {
+		return nil, report
+	}
+
+	return a, nil
+}
+
+// check runs the checks on the unused addresses of the pool, adding the addresses they find to report
+func (a *Addrs) check(coinType string, checks PoolChecks, report *PoolReport) error {
+	checked := make(map[string]struct{}, len(a.addresses))
+	for _, addr := range a.addresses {
+		// Duplicates are reported once
+		if _, ok := checked[addr]; ok {
+			continue
+		}
+		checked[addr] = struct{}{}
+
+		if checks.Bindings != nil {
+			bound, err := checks.Bindings.IsBound(addr, coinType)
+			if err != nil {
+				return fmt.Errorf("Check binding of deposit address `%s` failed: %v", addr, err)
+			}
+			if bound {
+				report.Bound = append(report.Bound, addr)
+			}
+		}
+
+		if checks.History != nil {
+			seen, err := checks.History.HasHistory(addr)
+			if err != nil {
+				return fmt.Errorf("Check on-chain history of deposit address `%s` failed: %v", addr, err)
+			}
+			if seen {
+				report.WithHistory = append(report.WithHistory, addr)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package addrs
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type dummyBindings map[string]string
+
+func (b dummyBindings) IsBound(depositAddr, coinType string) (bool, error) {
+	return b[depositAddr] == coinType, nil
+}
+
+type dummyHistory struct {
+	seen map[string]bool
+	err  error
+}
+
+func (h dummyHistory) HasHistory(addr string) (bool, error) {
+	return h.seen[addr], h.err
+}
+
+func TestNewBTCAddrsPoolChecks(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+
+	addressesJSON := `{
+    "btc_addresses": [
+        "14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj",
+        "1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy",
+        "1JrzSx8a9FVHHCkUFLB2CHULpbz4dTz5Ap",
+        "1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB",
+        "1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy"
+    ]
+}`
+
+	// A used address which is bound is expected
+	used, err := NewStore(db, btcBucketKey)
+	require.NoError(t, err)
+	require.NoError(t, used.Put("14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj"))
+
+	checks := PoolChecks{
+		Bindings: dummyBindings{
+			"14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj": scanner.CoinTypeBTC,
+			"1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy": scanner.CoinTypeBTC,
+			"1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB": scanner.CoinTypeETH,
+		},
+		History: dummyHistory{
+			seen: map[string]bool{
+				"14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj": true,
+				"1JrzSx8a9FVHHCkUFLB2CHULpbz4dTz5Ap": true,
+			},
+		},
+	}
+
+	_, err = NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJSON)), checks)
+	require.Equal(t, PoolReport{
+		CoinType:    scanner.CoinTypeBTC,
+		Duplicates:  []string{"1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy"},
+		Bound:       []string{"1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy"},
+		WithHistory: []string{"1JrzSx8a9FVHHCkUFLB2CHULpbz4dTz5Ap"},
+	}, err)
+
+	require.Equal(t, `BTC deposit address pool can't be used, addresses would be assigned twice:
+	1 appear more than once in the addresses file: 1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy
+	1 are not marked used but are already bound to a skycoin address: 1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy
+	1 are not marked used but already have on-chain transactions: 1JrzSx8a9FVHHCkUFLB2CHULpbz4dTz5Ap`, err.Error())
+
+	// A failed check fails the load
+	checks.History = dummyHistory{
+		err: errors.New("node unavailable"),
+	}
+	_, err = NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJSON)), checks)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "node unavailable")
+
+	// No problems
+	_, err = NewBTCAddrs(log, db, bytes.NewReader([]byte(`{
+    "btc_addresses": [
+        "14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj",
+        "14FG8vQnmK6B7YbLSr6uC5wfGY78JFNCYg"
+    ]
+}`)), PoolChecks{
+		Bindings: checks.Bindings,
+		History:  dummyHistory{},
+	})
+	require.NoError(t, err)
+}
+
+func TestNewETHAddrsDuplicatedCase(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+
+	addressesJSON := `{
+    "eth_addresses": [
+        "0xc0a51efd9c319dd60d93105ab317eb362017ecb9",
+        "0x3f9f942b8bd4f69432c053eef77cd84fd46b8d76",
+        "0xC0A51EFD9C319DD60D93105AB317EB362017ECB9"
+    ]
+}`
+
+	_, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJSON)), PoolChecks{})
+	require.Equal(t, PoolReport{
+		CoinType:   scanner.CoinTypeETH,
+		Duplicates: []string{"0xC0A51EFD9C319DD60D93105AB317EB362017ECB9"},
+	}, err)
+}
@@ -9,17 +9,21 @@ import (
 
 	"github.com/boltdb/bolt"
 	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/scanner"
 )
 
 const ethBucketKey = "used_eth_address"
 
-// NewETHAddrs returns an Addrs loaded with ETH addresses
-func NewETHAddrs(log logrus.FieldLogger, db *bolt.DB, addrsReader io.Reader) (*Addrs, error) {
+// NewETHAddrs returns an Addrs loaded with ETH addresses.
+// It returns a PoolReport if any address could be assigned twice.
+// ETH addresses are case insensitive, so addresses which differ only in case are duplicates.
+func NewETHAddrs(log logrus.FieldLogger, db *bolt.DB, addrsReader io.Reader, checks PoolChecks) (*Addrs, error) {
 	loader, err := loadETHAddresses(addrsReader)
 	if err != nil {
 		return nil, err
 	}
-	return NewAddrs(log, db, loader, ethBucketKey)
+	return newCheckedAddrs(log, db, loader, ethBucketKey, scanner.CoinTypeETH, strings.ToLower, checks)
 }
 
 func loadETHAddresses(addrsReader io.Reader) ([]string, error) {
@@ -54,18 +58,10 @@ func verifyETHAddresses(addrs []string) error {
 		return errors.New("No ETH addresses")
 	}
 
-	addrMap := make(map[string]struct{}, len(addrs))
-
 	for _, addr := range addrs {
-		if _, ok := addrMap[addr]; ok {
-			return fmt.Errorf("Duplicate deposit address `%s`", addr)
-		}
-
 		if err := validCheckSum(addr); err != nil {
 			return fmt.Errorf("Invalid deposit address `%s`: %v", addr, err)
 		}
-
-		addrMap[addr] = struct{}{}
 	}
 
 	return nil
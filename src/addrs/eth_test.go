@@ -5,6 +5,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/util/testutil"
 	"github.com/stretchr/testify/require"
 )
@@ -24,7 +25,7 @@ func TestNewETHAddrsAllValid(t *testing.T) {
     ]
 }`
 
-	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Nil(t, err)
 	require.NotNil(t, ethAddrMgr)
@@ -48,7 +49,7 @@ func TestNewEthAddrsContainsInvalid(t *testing.T) {
 
 	expectedErr := errors.New("Invalid deposit address `bad`: Invalid address length")
 
-	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -70,9 +71,12 @@ func TestNewEthAddrsContainsDuplicated(t *testing.T) {
     ]
 }`
 
-	expectedErr := errors.New("Duplicate deposit address `0xc0a51efd9c319dd60d93105ab317eb362017ecb9`")
+	expectedErr := PoolReport{
+		CoinType:   scanner.CoinTypeETH,
+		Duplicates: []string{"0xc0a51efd9c319dd60d93105ab317eb362017ecb9"},
+	}
 
-	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -91,7 +95,7 @@ func TestNewETHAddrsContainsNull(t *testing.T) {
 
 	expectedErr := errors.New("No ETH addresses")
 
-	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -108,7 +112,7 @@ func TestNewETHAddrsBadFormat(t *testing.T) {
 
 	expectedErr := errors.New("Decode loaded address json failed: EOF")
 
-	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)))
+	ethAddrMgr, err := NewETHAddrs(log, db, bytes.NewReader([]byte(addressesJson)), PoolChecks{})
 
 	require.Error(t, err)
 	require.Equal(t, expectedErr, err)
@@ -0,0 +1,207 @@
+package addrs
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultForecastWindow         = time.Hour * 6
+	defaultForecastSampleInterval = time.Minute
+)
+
+// ForecastConfig configures the Forecaster
+type ForecastConfig struct {
+	// Window is how far back the bind rate is measured
+	Window time.Duration
+	// SampleInterval is how often the pools' remaining addresses are sampled
+	SampleInterval time.Duration
+	// AlertHorizon alerts when a pool is predicted to run out sooner than this. 0 disables the alert.
+	AlertHorizon time.Duration
+}
+
+// PoolCounter returns the remaining addresses of each coin type's pool
+type PoolCounter interface {
+	CoinTypes() []string
+	Remaining(coinType string) (uint64, error)
+}
+
+// Forecast is the predicted exhaustion of a coin type's deposit address pool
+type Forecast struct {
+	CoinType  string `json:"coin_type"`
+	Remaining uint64 `json:"remaining"`
+	// BindRate is the addresses used per hour over the sampled span, net of the addresses returned to the pool
+	BindRate float64 `json:"bind_rate"`
+	// Span is the time covered by the samples, up to the window. The rate is less reliable while it is short.
+	Span time.Duration `json:"span"`
+	// ExhaustedAt is when the pool runs out at BindRate, nil if the pool isn't shrinking
+	ExhaustedAt *time.Time `json:"exhausted_at"`
+	// Alert is true while ExhaustedAt is within the alert horizon
+	Alert     bool      `json:"alert"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type poolSample struct {
+	at        time.Time
+	remaining uint64
+}
+
+// Forecaster samples the remaining addresses of the deposit address pools, and predicts when each pool runs out
+// at the rate addresses were used over the window. The samples are kept in memory, so a restart starts a new window.
+type Forecaster struct {
+	sync.RWMutex
+	log       logrus.FieldLogger
+	cfg       ForecastConfig
+	pools     PoolCounter
+	samples   map[string][]poolSample
+	forecasts map[string]Forecast
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+// NewForecaster creates a Forecaster of pools
+func NewForecaster(log logrus.FieldLogger, cfg ForecastConfig, pools PoolCounter) *Forecaster {
+	if cfg.Window <= 0 {
+		cfg.Window = defaultForecastWindow
+	}
+
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = defaultForecastSampleInterval
+	}
+
+	return &Forecaster{
+		log:       log.WithField("prefix", "teller.addrs.forecast"),
+		cfg:       cfg,
+		pools:     pools,
+		samples:   make(map[string][]poolSample),
+		forecasts: make(map[string]Forecast),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Run samples the pools at startup, then every SampleInterval until Shutdown is called
+func (f *Forecaster) Run() error {
+	log := f.log.WithField("config", f.cfg)
+	log.Info("Start address pool forecaster")
+	defer log.Info("Address pool forecaster closed")
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.cfg.SampleInterval)
+	defer ticker.Stop()
+
+	for {
+		f.Sample(time.Now())
+
+		select {
+		case <-f.quit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the Forecaster
+func (f *Forecaster) Shutdown() {
+	close(f.quit)
+	<-f.done
+}
+
+// Sample records the remaining addresses of each pool at now, and updates their forecasts
+func (f *Forecaster) Sample(now time.Time) {
+	for _, coinType := range f.pools.CoinTypes() {
+		remaining, err := f.pools.Remaining(coinType)
+		if err != nil {
+			f.log.WithError(err).WithField("coinType", coinType).Error("Get remaining addresses failed")
+			continue
+		}
+
+		f.sample(coinType, remaining, now)
+	}
+}
+
+func (f *Forecaster) sample(coinType string, remaining uint64, now time.Time) {
+	f.Lock()
+	defer f.Unlock()
+
+	// Keep the samples within the window
+	samples := append(f.samples[coinType], poolSample{
+		at:        now,
+		remaining: remaining,
+	})
+	start := now.Add(-f.cfg.Window)
+	i := 0
+	for i < len(samples)-1 && samples[i].at.Before(start) {
+		i++
+	}
+	samples = samples[i:]
+	f.samples[coinType] = samples
+
+	fc := forecast(samples, now)
+	fc.CoinType = coinType
+	fc.Alert = f.cfg.AlertHorizon > 0 && fc.ExhaustedAt != nil && fc.ExhaustedAt.Sub(now) <= f.cfg.AlertHorizon
+
+	log := f.log.WithFields(logrus.Fields{
+		"coinType":  coinType,
+		"remaining": fc.Remaining,
+		"bindRate":  fc.BindRate,
+		"horizon":   f.cfg.AlertHorizon,
+	})
+	if fc.ExhaustedAt != nil {
+		log = log.WithField("exhaustedAt", *fc.ExhaustedAt)
+	}
+
+	last := f.forecasts[coinType]
+	switch {
+	case fc.Alert && !last.Alert:
+		log.WithField("alert", "address_pool_forecast").Error("ALERT: deposit address pool is predicted to run out within the alert horizon")
+	case !fc.Alert && last.Alert:
+		log.Info("Deposit address pool is no longer predicted to run out within the alert horizon")
+	}
+
+	f.forecasts[coinType] = fc
+}
+
+// forecast computes the bind rate from the oldest to the newest sample, and when the pool runs out at that rate
+func forecast(samples []poolSample, now time.Time) Forecast {
+	first := samples[0]
+	last := samples[len(samples)-1]
+
+	fc := Forecast{
+		Remaining: last.remaining,
+		Span:      last.at.Sub(first.at),
+		CheckedAt: now,
+	}
+
+	if fc.Span <= 0 || last.remaining >= first.remaining {
+		return fc
+	}
+
+	used := float64(first.remaining - last.remaining)
+	fc.BindRate = used / fc.Span.Hours()
+
+	left := time.Duration(float64(last.remaining) / used * float64(fc.Span))
+	exhaustedAt := last.at.Add(left)
+	fc.ExhaustedAt = &exhaustedAt
+
+	return fc
+}
+
+// Forecasts returns the last forecast of each coin type, sorted by coin type
+func (f *Forecaster) Forecasts() []Forecast {
+	f.RLock()
+	defer f.RUnlock()
+
+	forecasts := make([]Forecast, 0, len(f.forecasts))
+	for _, fc := range f.forecasts {
+		forecasts = append(forecasts, fc)
+	}
+	sort.Slice(forecasts, func(i, j int) bool {
+		return forecasts[i].CoinType < forecasts[j].CoinType
+	})
+
+	return forecasts
+}
@@ -0,0 +1,121 @@
+package addrs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type dummyPools map[string]uint64
+
+func (p dummyPools) CoinTypes() []string {
+	return []string{"BTC", "ETH", "SKY"}
+}
+
+func (p dummyPools) Remaining(coinType string) (uint64, error) {
+	n, ok := p[coinType]
+	if !ok {
+		return 0, errors.New("no pool")
+	}
+	return n, nil
+}
+
+func TestForecaster(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	pools := dummyPools{
+		"BTC": 1000,
+		"ETH": 50,
+	}
+
+	f := NewForecaster(log, ForecastConfig{
+		Window:       time.Hour * 2,
+		AlertHorizon: time.Hour * 3,
+	}, pools)
+
+	start := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// A single sample has no rate, and a pool which can't be read has no forecast
+	f.Sample(start)
+	fcs := f.Forecasts()
+	require.Len(t, fcs, 2)
+	require.Equal(t, "BTC", fcs[0].CoinType)
+	require.Equal(t, "ETH", fcs[1].CoinType)
+	require.Equal(t, uint64(1000), fcs[0].Remaining)
+	require.Zero(t, fcs[0].BindRate)
+	require.Nil(t, fcs[0].ExhaustedAt)
+	require.False(t, fcs[0].Alert)
+
+	// BTC uses 100 addresses an hour, ETH gets addresses back
+	pools["BTC"] = 900
+	pools["ETH"] = 60
+	now := start.Add(time.Hour)
+	f.Sample(now)
+	fcs = f.Forecasts()
+	require.Equal(t, time.Hour, fcs[0].Span)
+	require.Equal(t, 100.0, fcs[0].BindRate)
+	require.NotNil(t, fcs[0].ExhaustedAt)
+	require.Equal(t, now.Add(time.Hour*9), *fcs[0].ExhaustedAt)
+	require.False(t, fcs[0].Alert)
+	require.Zero(t, fcs[1].BindRate)
+	require.Nil(t, fcs[1].ExhaustedAt)
+	require.Equal(t, now, fcs[1].CheckedAt)
+
+	pools["BTC"] = 400
+	now = start.Add(time.Hour * 2)
+	f.Sample(now)
+	fcs = f.Forecasts()
+	require.Equal(t, 300.0, fcs[0].BindRate)
+	require.Equal(t, now.Add(time.Minute*80), *fcs[0].ExhaustedAt)
+	require.True(t, fcs[0].Alert)
+
+	// The samples older than the window are dropped, so the rate follows the recent binds
+	pools["BTC"] = 390
+	now = start.Add(time.Hour * 3)
+	f.Sample(now)
+	fcs = f.Forecasts()
+	require.Equal(t, time.Hour*2, fcs[0].Span)
+	require.Equal(t, 255.0, fcs[0].BindRate)
+	require.True(t, fcs[0].Alert)
+
+	pools["BTC"] = 380
+	now = start.Add(time.Hour * 5)
+	f.Sample(now)
+	fcs = f.Forecasts()
+	require.Equal(t, time.Hour*2, fcs[0].Span)
+	require.Equal(t, 5.0, fcs[0].BindRate)
+	require.Equal(t, now.Add(time.Hour*76), *fcs[0].ExhaustedAt)
+	require.False(t, fcs[0].Alert)
+
+	// An empty pool is exhausted now
+	pools["BTC"] = 0
+	now = start.Add(time.Hour * 6)
+	f.Sample(now)
+	fcs = f.Forecasts()
+	require.Equal(t, now, *fcs[0].ExhaustedAt)
+	require.True(t, fcs[0].Alert)
+}
+
+func TestForecasterNoAlert(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	pools := dummyPools{
+		"BTC": 10,
+	}
+
+	f := NewForecaster(log, ForecastConfig{}, pools)
+	require.Equal(t, defaultForecastWindow, f.cfg.Window)
+	require.Equal(t, defaultForecastSampleInterval, f.cfg.SampleInterval)
+
+	start := time.Now()
+	f.Sample(start)
+	pools["BTC"] = 5
+	f.Sample(start.Add(time.Minute))
+
+	fcs := f.Forecasts()
+	require.Len(t, fcs, 1)
+	require.NotNil(t, fcs[0].ExhaustedAt)
+	require.False(t, fcs[0].Alert)
+}
@@ -0,0 +1,56 @@
+package addrs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+// AddressValidator checks the addresses of a coin type, e.g. the scanner plugin of a coin type it adds
+type AddressValidator interface {
+	ValidateAddress(addr string) error
+}
+
+// PluginBucketKey returns the bucket of the used deposit addresses of a coin type added by a scanner plugin
+func PluginBucketKey(coinType string) string {
+	return "used_" + strings.ToLower(coinType) + "_address"
+}
+
+// NewPluginAddrs returns an Addrs loaded with the addresses of a coin type added by a scanner plugin,
+// which are checked by validator. The addresses file is {"addresses": [...]}.
+// It returns a PoolReport if any address could be assigned twice.
+func NewPluginAddrs(log logrus.FieldLogger, db *bolt.DB, coinType string, addrsReader io.Reader, validator AddressValidator, checks PoolChecks) (*Addrs, error) {
+	loader, err := loadPluginAddresses(coinType, addrsReader, validator)
+	if err != nil {
+		return nil, err
+	}
+	return newCheckedAddrs(log, db, loader, PluginBucketKey(coinType), coinType, func(addr string) string {
+		return addr
+	}, checks)
+}
+
+func loadPluginAddresses(coinType string, addrsReader io.Reader, validator AddressValidator) ([]string, error) {
+	var addrs struct {
+		Addresses []string `json:"addresses"`
+	}
+
+	if err := json.NewDecoder(addrsReader).Decode(&addrs); err != nil {
+		return nil, fmt.Errorf("Decode loaded address json failed: %v", err)
+	}
+
+	if len(addrs.Addresses) == 0 {
+		return nil, fmt.Errorf("No %s addresses", coinType)
+	}
+
+	for _, addr := range addrs.Addresses {
+		if err := validator.ValidateAddress(addr); err != nil {
+			return nil, fmt.Errorf("Invalid deposit address `%s`: %v", addr, err)
+		}
+	}
+
+	return addrs.Addresses, nil
+}
@@ -0,0 +1,62 @@
+package addrs
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// prefixValidator accepts the addresses with its prefix
+type prefixValidator string
+
+func (v prefixValidator) ValidateAddress(addr string) error {
+	if !strings.HasPrefix(addr, string(v)) {
+		return errors.New("wrong prefix")
+	}
+	return nil
+}
+
+func TestNewPluginAddrs(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+
+	a, err := NewPluginAddrs(log, db, "LTC", bytes.NewReader([]byte(`{"addresses": ["La1", "La2"]}`)), prefixValidator("L"), PoolChecks{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), a.Remaining())
+
+	addr, err := a.NewAddress()
+	require.NoError(t, err)
+	require.Equal(t, "La1", addr)
+
+	// The used addresses are kept in the coin type's bucket
+	a, err = NewPluginAddrs(log, db, "LTC", bytes.NewReader([]byte(`{"addresses": ["La1", "La2"]}`)), prefixValidator("L"), PoolChecks{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), a.Remaining())
+	require.Equal(t, "used_ltc_address", PluginBucketKey("LTC"))
+}
+
+func TestNewPluginAddrsInvalid(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+
+	_, err := NewPluginAddrs(log, db, "LTC", bytes.NewReader([]byte(`{"addresses": ["La1", "bad"]}`)), prefixValidator("L"), PoolChecks{})
+	require.Equal(t, errors.New("Invalid deposit address `bad`: wrong prefix"), err)
+
+	_, err = NewPluginAddrs(log, db, "LTC", bytes.NewReader([]byte(`{"addresses": []}`)), prefixValidator("L"), PoolChecks{})
+	require.Equal(t, errors.New("No LTC addresses"), err)
+
+	_, err = NewPluginAddrs(log, db, "LTC", bytes.NewReader([]byte(`{"addresses": ["La1", "La1"]}`)), prefixValidator("L"), PoolChecks{})
+	require.Equal(t, PoolReport{
+		CoinType:   "LTC",
+		Duplicates: []string{"La1"},
+	}, err)
+}
@@ -0,0 +1,135 @@
+package addrs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/skycoin/teller/src/util/errutil"
+)
+
+const (
+	defaultAllocQueueSize = 1000
+	defaultAllocMaxWait   = time.Second * 5
+)
+
+var (
+	// ErrAllocQueueFull is returned when too many address requests are already waiting
+	ErrAllocQueueFull = errutil.New(errutil.RateLimited, "Too many deposit address requests are waiting")
+	// ErrAllocTimeout is returned when an address request waited longer than the max wait
+	ErrAllocTimeout = errutil.New(errutil.Unavailable, "Timed out waiting for a deposit address")
+)
+
+// AllocConfig configures the address allocation queues
+type AllocConfig struct {
+	// Max number of requests waiting per coin type. Further requests fail with ErrAllocQueueFull.
+	QueueSize int
+	// Max time a request waits in the queue. Longer waits fail with ErrAllocTimeout.
+	MaxWait time.Duration
+}
+
+// QueueStats reports the state of a coin type's allocation queue
+type QueueStats struct {
+	Depth     int           `json:"depth"`      // Requests waiting now
+	PeakDepth int           `json:"peak_depth"` // Most requests waiting at once
+	Served    uint64        `json:"served"`     // Requests which reached the address pool
+	TimedOut  uint64        `json:"timed_out"`  // Requests which failed with ErrAllocTimeout
+	Rejected  uint64        `json:"rejected"`   // Requests which failed with ErrAllocQueueFull
+	MaxWait   time.Duration `json:"max_wait"`   // Longest time a served request waited
+}
+
+// allocQueue serializes the address requests of one coin type in FIFO order.
+// The turn is handed directly from one request to the next waiting request,
+// so a late request can't overtake a waiting one.
+type allocQueue struct {
+	sync.Mutex
+	cfg     AllocConfig
+	busy    bool
+	waiting []chan struct{}
+	stats   QueueStats
+}
+
+func newAllocQueue(cfg AllocConfig) *allocQueue {
+	return &allocQueue{
+		cfg: cfg,
+	}
+}
+
+// acquire waits for the request's turn. release must be called after a nil return.
+func (q *allocQueue) acquire() error {
+	start := time.Now()
+
+	q.Lock()
+	if !q.busy && len(q.waiting) == 0 {
+		q.busy = true
+		q.stats.Served++
+		q.Unlock()
+		return nil
+	}
+
+	if len(q.waiting) >= q.cfg.QueueSize {
+		q.stats.Rejected++
+		q.Unlock()
+		return ErrAllocQueueFull
+	}
+
+	turn := make(chan struct{})
+	q.waiting = append(q.waiting, turn)
+	if len(q.waiting) > q.stats.PeakDepth {
+		q.stats.PeakDepth = len(q.waiting)
+	}
+	q.Unlock()
+
+	timer := time.NewTimer(q.cfg.MaxWait)
+	defer timer.Stop()
+
+	select {
+	case <-turn:
+	case <-timer.C:
+		q.Lock()
+		for i, c := range q.waiting {
+			if c == turn {
+				q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+				q.stats.TimedOut++
+				q.Unlock()
+				return ErrAllocTimeout
+			}
+		}
+		q.Unlock()
+
+		// The turn was handed over while timing out, so take it
+		<-turn
+	}
+
+	q.Lock()
+	q.stats.Served++
+	if wait := time.Since(start); wait > q.stats.MaxWait {
+		q.stats.MaxWait = wait
+	}
+	q.Unlock()
+
+	return nil
+}
+
+// release hands the turn to the next waiting request
+func (q *allocQueue) release() {
+	q.Lock()
+	defer q.Unlock()
+
+	if len(q.waiting) == 0 {
+		q.busy = false
+		return
+	}
+
+	turn := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(turn)
+}
+
+func (q *allocQueue) getStats() QueueStats {
+	q.Lock()
+	defer q.Unlock()
+
+	s := q.stats
+	s.Depth = len(q.waiting)
+	return s
+}
@@ -0,0 +1,140 @@
+package addrs
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// waitForDepth waits until n requests are waiting in q
+func waitForDepth(t *testing.T, q *allocQueue, n int) {
+	for i := 0; i < 1000; i++ {
+		if q.getStats().Depth == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Waiting for queue depth %d timed out", n)
+}
+
+func TestAllocQueueFIFO(t *testing.T) {
+	q := newAllocQueue(AllocConfig{
+		QueueSize: 10,
+		MaxWait:   time.Second * 5,
+	})
+
+	require.NoError(t, q.acquire())
+
+	// Queue the requests one at a time, so that their arrival order is known
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			require.NoError(t, q.acquire())
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			q.release()
+		}(i)
+		waitForDepth(t, q, i+1)
+	}
+
+	q.release()
+	wg.Wait()
+
+	require.Equal(t, []int{0, 1, 2, 3, 4}, order)
+
+	stats := q.getStats()
+	require.Equal(t, 0, stats.Depth)
+	require.Equal(t, 5, stats.PeakDepth)
+	require.Equal(t, uint64(6), stats.Served)
+	require.NotZero(t, stats.MaxWait)
+
+	// The queue is idle again
+	require.NoError(t, q.acquire())
+	q.release()
+}
+
+func TestAllocQueueLimits(t *testing.T) {
+	q := newAllocQueue(AllocConfig{
+		QueueSize: 1,
+		MaxWait:   time.Millisecond * 50,
+	})
+
+	require.NoError(t, q.acquire())
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- q.acquire()
+	}()
+	waitForDepth(t, q, 1)
+
+	// The queue is full
+	require.Equal(t, ErrAllocQueueFull, q.acquire())
+
+	// The waiting request gives up after MaxWait
+	require.Equal(t, ErrAllocTimeout, <-errC)
+
+	stats := q.getStats()
+	require.Equal(t, 0, stats.Depth)
+	require.Equal(t, uint64(1), stats.Served)
+	require.Equal(t, uint64(1), stats.TimedOut)
+	require.Equal(t, uint64(1), stats.Rejected)
+
+	// A timed out request doesn't keep its place in the queue
+	q.release()
+	require.NoError(t, q.acquire())
+	q.release()
+}
+
+func TestAddrManagerConcurrentNewAddress(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	var addresses []string
+	for i := 0; i < 50; i++ {
+		addresses = append(addresses, fmt.Sprintf("addr-%d", i))
+	}
+
+	gen, err := NewAddrs(log, db, addresses, "test_bucket")
+	require.NoError(t, err)
+
+	addrManager := NewAddrManager(AllocConfig{})
+	require.NoError(t, addrManager.PushGenerator(gen, "TOKENB"))
+
+	var mu sync.Mutex
+	got := make(map[string]struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < len(addresses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addr, err := addrManager.NewAddress("TOKENB")
+			require.NoError(t, err)
+			mu.Lock()
+			got[addr] = struct{}{}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	// Every request got a different address
+	require.Len(t, got, len(addresses))
+
+	_, err = addrManager.NewAddress("TOKENB")
+	require.Equal(t, ErrDepositAddressEmpty, err)
+
+	stats := addrManager.QueueStats()
+	require.Len(t, stats, 1)
+	require.Equal(t, uint64(len(addresses)+1), stats["TOKENB"].Served)
+	require.Equal(t, 0, stats["TOKENB"].Depth)
+}
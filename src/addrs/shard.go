@@ -0,0 +1,173 @@
+package addrs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Pool sharding.
+// Teller instances which don't share a db can't tell which addresses the others assigned,
+// so they must never load the same addresses. Each instance owns a slice of the addresses
+// file, its shard, and every instance is configured with the shards of all the instances,
+// so that an overlap is refused at startup by any of them.
+
+// ShardRange is the slice of an addresses file owned by one instance, the addresses at indexes [Start, End)
+type ShardRange struct {
+	Start int
+	End   int
+}
+
+// ParseShardRange parses a range written as "start:end"
+func ParseShardRange(s string) (ShardRange, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return ShardRange{}, fmt.Errorf("Invalid shard range %q, must be \"start:end\"", s)
+	}
+
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return ShardRange{}, fmt.Errorf("Invalid shard range %q start: %v", s, err)
+	}
+
+	end, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return ShardRange{}, fmt.Errorf("Invalid shard range %q end: %v", s, err)
+	}
+
+	if start < 0 || end <= start {
+		return ShardRange{}, fmt.Errorf("Invalid shard range %q, must have 0 <= start < end", s)
+	}
+
+	return ShardRange{
+		Start: start,
+		End:   end,
+	}, nil
+}
+
+func (r ShardRange) String() string {
+	return fmt.Sprintf("%d:%d", r.Start, r.End)
+}
+
+func (r ShardRange) overlaps(o ShardRange) bool {
+	return r.Start < o.End && o.Start < r.End
+}
+
+// Shard is the shard of a pool owned by this instance, out of the shards of every instance
+type Shard struct {
+	// Index of this instance's shard in Ranges
+	Index int
+	// Ranges of every instance, by shard index
+	Ranges []ShardRange
+}
+
+// ParseShard parses the ranges of every instance, and returns an error if index is not one of them or any two overlap
+func ParseShard(index int, ranges []string) (*Shard, error) {
+	if index < 0 || index >= len(ranges) {
+		return nil, fmt.Errorf("Shard index %d is not one of the %d shards", index, len(ranges))
+	}
+
+	s := &Shard{
+		Index:  index,
+		Ranges: make([]ShardRange, len(ranges)),
+	}
+
+	for i, v := range ranges {
+		r, err := ParseShardRange(v)
+		if err != nil {
+			return nil, err
+		}
+
+		for j, o := range s.Ranges[:i] {
+			if r.overlaps(o) {
+				return nil, fmt.Errorf("Shard %d range %s overlaps shard %d range %s", i, r, j, o)
+			}
+		}
+
+		s.Ranges[i] = r
+	}
+
+	return s, nil
+}
+
+// Range returns the range of this instance's shard
+func (s Shard) Range() ShardRange {
+	return s.Ranges[s.Index]
+}
+
+// ShardStatus is the state of this instance's shard of a pool
+type ShardStatus struct {
+	CoinType string `json:"coin_type"`
+	Index    int    `json:"index"`
+	Count    int    `json:"count"`
+	// Indexes [start, end) of the shard's addresses in the addresses file
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// Addresses in the addresses file, of all the shards
+	PoolSize  int    `json:"pool_size"`
+	Remaining uint64 `json:"remaining"`
+	// Addresses of other shards which the db marks used, from when this instance owned another shard.
+	// The instance owning them now may assign them again, unless its pool checks find them.
+	UsedOutside int `json:"used_outside"`
+}
+
+// shardAddresses returns the addresses of the shard, and the number of the other shards' addresses which used marks used
+func shardAddresses(coinType string, addresses []string, shard *Shard, used *Store) ([]string, int, error) {
+	r := shard.Range()
+	if r.End > len(addresses) {
+		return nil, 0, fmt.Errorf("%s address shard %d range %s is past the end of the addresses file, which has %d addresses", coinType, shard.Index, r, len(addresses))
+	}
+
+	var usedOutside int
+	for i, addr := range addresses {
+		if i >= r.Start && i < r.End {
+			continue
+		}
+
+		isUsed, err := used.IsUsed(addr)
+		if err != nil {
+			return nil, 0, err
+		}
+		if isUsed {
+			usedOutside++
+		}
+	}
+
+	return addresses[r.Start:r.End], usedOutside, nil
+}
+
+// Shard returns the status of the pool's shard, or false if the pool is not sharded
+func (a *Addrs) Shard() (ShardStatus, bool) {
+	a.RLock()
+	defer a.RUnlock()
+
+	if a.shard == nil {
+		return ShardStatus{}, false
+	}
+
+	s := *a.shard
+	s.Remaining = uint64(len(a.addresses))
+	return s, true
+}
+
+// Shards returns the shard status of each coin type with a sharded pool, ordered by coin type
+func (am *AddrManager) Shards() []ShardStatus {
+	shards := []ShardStatus{}
+	for _, coinType := range am.CoinTypes() {
+		am.Mutex.RLock()
+		ag := am.AGHolder[coinType]
+		am.Mutex.RUnlock()
+
+		sg, ok := ag.(interface {
+			Shard() (ShardStatus, bool)
+		})
+		if !ok {
+			continue
+		}
+
+		if s, ok := sg.Shard(); ok {
+			shards = append(shards, s)
+		}
+	}
+	return shards
+}
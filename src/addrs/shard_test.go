@@ -0,0 +1,150 @@
+package addrs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestParseShard(t *testing.T) {
+	s, err := ParseShard(1, []string{"0:500", "500:1000", "1000:1200"})
+	require.NoError(t, err)
+	require.Equal(t, &Shard{
+		Index: 1,
+		Ranges: []ShardRange{
+			{0, 500},
+			{500, 1000},
+			{1000, 1200},
+		},
+	}, s)
+	require.Equal(t, ShardRange{500, 1000}, s.Range())
+
+	for _, tc := range []struct {
+		name   string
+		index  int
+		ranges []string
+		err    string
+	}{
+		{
+			name:   "overlap",
+			ranges: []string{"0:500", "400:1000"},
+			err:    "Shard 1 range 400:1000 overlaps shard 0 range 0:500",
+		},
+		{
+			name:   "contained",
+			ranges: []string{"100:200", "500:600", "0:1000"},
+			err:    "Shard 2 range 0:1000 overlaps shard 0 range 100:200",
+		},
+		{
+			name:   "index out of range",
+			index:  2,
+			ranges: []string{"0:500", "500:1000"},
+			err:    "Shard index 2 is not one of the 2 shards",
+		},
+		{
+			name:   "empty range",
+			ranges: []string{"500:500"},
+			err:    `Invalid shard range "500:500", must have 0 <= start < end`,
+		},
+		{
+			name:   "not a range",
+			ranges: []string{"500"},
+			err:    `Invalid shard range "500", must be "start:end"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseShard(tc.index, tc.ranges)
+			require.Error(t, err)
+			require.Equal(t, tc.err, err.Error())
+		})
+	}
+}
+
+func TestNewBTCAddrsShard(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+
+	addressesJSON := `{
+    "btc_addresses": [
+        "14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj",
+        "1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy",
+        "1JrzSx8a9FVHHCkUFLB2CHULpbz4dTz5Ap",
+        "1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB"
+    ]
+}`
+
+	// Used by this db while it owned the first shard
+	used, err := NewStore(db, btcBucketKey)
+	require.NoError(t, err)
+	require.NoError(t, used.Put("14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj"))
+
+	shard, err := ParseShard(1, []string{"0:2", "2:4"})
+	require.NoError(t, err)
+
+	a, err := NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJSON)), PoolChecks{
+		Shard: shard,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), a.Remaining())
+
+	addr, err := a.NewAddress()
+	require.NoError(t, err)
+	require.Equal(t, "1JrzSx8a9FVHHCkUFLB2CHULpbz4dTz5Ap", addr)
+
+	status, ok := a.Shard()
+	require.True(t, ok)
+	require.Equal(t, ShardStatus{
+		CoinType:    scanner.CoinTypeBTC,
+		Index:       1,
+		Count:       2,
+		Start:       2,
+		End:         4,
+		PoolSize:    4,
+		Remaining:   1,
+		UsedOutside: 1,
+	}, status)
+
+	am := NewAddrManager(AllocConfig{})
+	require.NoError(t, am.PushGenerator(a, scanner.CoinTypeBTC))
+	require.Equal(t, []ShardStatus{status}, am.Shards())
+
+	// An unsharded pool has no shard status
+	b, err := NewAddrs(log, db, []string{"1PZ63K3G4gZP6A6E2TTbBwxT5bFQGL2TLB"}, "test_bucket")
+	require.NoError(t, err)
+	_, ok = b.Shard()
+	require.False(t, ok)
+
+	// The shard must be within the addresses file
+	shard, err = ParseShard(1, []string{"0:2", "2:5"})
+	require.NoError(t, err)
+	_, err = NewBTCAddrs(log, db, bytes.NewReader([]byte(addressesJSON)), PoolChecks{
+		Shard: shard,
+	})
+	require.Error(t, err)
+	require.Equal(t, "BTC address shard 1 range 2:5 is past the end of the addresses file, which has 4 addresses", err.Error())
+
+	// An address in two shards is a duplicate, even if only one of them is this instance's
+	dupJSON := `{
+    "btc_addresses": [
+        "14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj",
+        "1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy",
+        "1JrzSx8a9FVHHCkUFLB2CHULpbz4dTz5Ap",
+        "1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy"
+    ]
+}`
+	shard, err = ParseShard(1, []string{"0:2", "2:4"})
+	require.NoError(t, err)
+	_, err = NewBTCAddrs(log, db, bytes.NewReader([]byte(dupJSON)), PoolChecks{
+		Shard: shard,
+	})
+	require.Equal(t, PoolReport{
+		CoinType:   scanner.CoinTypeBTC,
+		Duplicates: []string{"1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy"},
+	}, err)
+}
@@ -41,6 +41,13 @@ func (s *Store) Put(addr string) error {
 	})
 }
 
+// Delete removes an address from the bucket, marking it as unused
+func (s *Store) Delete(addr string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.BucketKey).Delete([]byte(addr))
+	})
+}
+
 // IsUsed checks if address is mark as used
 func (s *Store) IsUsed(addr string) (bool, error) {
 	exists := false
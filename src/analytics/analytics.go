@@ -0,0 +1,172 @@
+// Package analytics emits anonymized funnel events to an external sink
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// EventBindStarted is emitted when a valid bind request is received
+	EventBindStarted = "bind_started"
+	// EventBindCompleted is emitted when a deposit address has been bound
+	EventBindCompleted = "bind_completed"
+	// EventBindCancelled is emitted when a binding is cancelled by the owner of its skycoin address
+	EventBindCancelled = "bind_cancelled"
+	// EventFirstDeposit is emitted when the first deposit to any of a skycoin address's deposit addresses is received
+	EventFirstDeposit = "first_deposit"
+	// EventPayoutCompleted is emitted when the skycoin sent for a deposit is confirmed
+	EventPayoutCompleted = "payout_completed"
+
+	eventBufferSize = 1000
+	flushBatchSize  = 100
+	flushInterval   = time.Second * 10
+)
+
+// Properties are arbitrary event properties. They must not contain addresses or other identifying data.
+type Properties map[string]interface{}
+
+// Event is an anonymized funnel event
+type Event struct {
+	Name string `json:"event"`
+	// Salted hash of the skycoin address, so events of the same user can be joined
+	AnonymousID string     `json:"anonymousId"`
+	Properties  Properties `json:"properties,omitempty"`
+	Timestamp   time.Time  `json:"timestamp"`
+}
+
+// Tracker records funnel events
+type Tracker interface {
+	Track(name, skyAddr string, props Properties)
+}
+
+// Noop is a Tracker which discards all events
+type Noop struct{}
+
+// Track does nothing
+func (Noop) Track(name, skyAddr string, props Properties) {}
+
+// Multi is a Tracker which passes each event to every Tracker in it
+type Multi []Tracker
+
+// Track passes the event to every Tracker
+func (m Multi) Track(name, skyAddr string, props Properties) {
+	for _, t := range m {
+		t.Track(name, skyAddr, props)
+	}
+}
+
+// Sink delivers a batch of events
+type Sink interface {
+	Send([]Event) error
+	Close() error
+}
+
+// Emitter is a Tracker which delivers events to a Sink in the background.
+// Tracking never blocks; events are dropped if the sink can't keep up.
+type Emitter struct {
+	log    logrus.FieldLogger
+	sink   Sink
+	salt   []byte
+	events chan Event
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// NewEmitter creates an Emitter. salt is used to hash the skycoin addresses.
+func NewEmitter(log logrus.FieldLogger, sink Sink, salt string) *Emitter {
+	return &Emitter{
+		log:    log.WithField("prefix", "teller.analytics"),
+		sink:   sink,
+		salt:   []byte(salt),
+		events: make(chan Event, eventBufferSize),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Track queues an event for delivery
+func (e *Emitter) Track(name, skyAddr string, props Properties) {
+	ev := Event{
+		Name:        name,
+		AnonymousID: HashAddress(e.salt, skyAddr),
+		Properties:  props,
+		Timestamp:   time.Now().UTC(),
+	}
+
+	select {
+	case e.events <- ev:
+	default:
+		e.log.WithField("event", name).Warn("Analytics event buffer is full, dropping event")
+	}
+}
+
+// Run delivers queued events to the sink in batches, until Shutdown is called
+func (e *Emitter) Run() error {
+	log := e.log
+	log.Info("Start analytics service")
+	defer log.Info("Analytics service closed")
+	defer close(e.done)
+
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []Event
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := e.sink.Send(batch); err != nil {
+			log.WithError(err).WithField("events", len(batch)).Error("Send analytics events failed, dropping events")
+		}
+
+		batch = nil
+	}
+
+	for {
+		select {
+		case <-e.quit:
+			// Deliver whatever is left in the buffer before closing the sink
+		drain:
+			for {
+				select {
+				case ev := <-e.events:
+					batch = append(batch, ev)
+				default:
+					break drain
+				}
+			}
+			flush()
+
+			if err := e.sink.Close(); err != nil {
+				log.WithError(err).Error("Close analytics sink failed")
+			}
+			return nil
+		case ev := <-e.events:
+			batch = append(batch, ev)
+			if len(batch) >= flushBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Shutdown flushes the queued events and stops the Emitter
+func (e *Emitter) Shutdown() {
+	close(e.quit)
+	<-e.done
+}
+
+// HashAddress returns the hex encoded HMAC-SHA256 of addr keyed by salt
+func HashAddress(salt []byte, addr string) string {
+	h := hmac.New(sha256.New, salt)
+	h.Write([]byte(addr)) // nolint: errcheck
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,156 @@
+package analytics
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+const testSkyAddr = "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+
+func TestHashAddress(t *testing.T) {
+	h := HashAddress([]byte("salt"), testSkyAddr)
+	require.Len(t, h, 64)
+	require.NotContains(t, h, testSkyAddr)
+
+	// Stable for the same salt, so events of the same address can be joined
+	require.Equal(t, h, HashAddress([]byte("salt"), testSkyAddr))
+	require.NotEqual(t, h, HashAddress([]byte("other-salt"), testSkyAddr))
+	require.NotEqual(t, h, HashAddress([]byte("salt"), "cBnu9sUvv12dovBmjQKTtfE4rbjMmf3fzW"))
+}
+
+type recordTracker []string
+
+func (r *recordTracker) Track(name, skyAddr string, props Properties) {
+	*r = append(*r, name)
+}
+
+func TestMulti(t *testing.T) {
+	var a, b recordTracker
+	m := Multi{&a, Noop{}, &b}
+
+	m.Track(EventBindStarted, testSkyAddr, nil)
+	m.Track(EventBindCompleted, testSkyAddr, nil)
+
+	require.Equal(t, recordTracker{EventBindStarted, EventBindCompleted}, a)
+	require.Equal(t, a, b)
+}
+
+func TestEmitterFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analytics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "analytics.log")
+	sink, err := NewSink(config.Analytics{
+		Sink: config.AnalyticsSinkFile,
+		File: path,
+	})
+	require.NoError(t, err)
+
+	log, _ := testutil.NewLogger(t)
+	e := NewEmitter(log, sink, "salt")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, e.Run())
+	}()
+
+	e.Track(EventBindStarted, testSkyAddr, Properties{"coin_type": "BTC"})
+	e.Track(EventBindCompleted, testSkyAddr, Properties{"coin_type": "BTC"})
+
+	// Queued events are flushed on shutdown
+	e.Shutdown()
+	<-done
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		require.NotContains(t, scanner.Text(), testSkyAddr)
+
+		var ev Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &ev))
+		events = append(events, ev)
+	}
+	require.NoError(t, scanner.Err())
+
+	require.Len(t, events, 2)
+	require.Equal(t, EventBindStarted, events[0].Name)
+	require.Equal(t, EventBindCompleted, events[1].Name)
+	for _, ev := range events {
+		require.Equal(t, HashAddress([]byte("salt"), testSkyAddr), ev.AnonymousID)
+		require.Equal(t, Properties{"coin_type": "BTC"}, ev.Properties)
+		require.False(t, ev.Timestamp.IsZero())
+	}
+}
+
+func TestSegmentSink(t *testing.T) {
+	var rsp int
+	var got map[string][]map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/batch", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		user, pass, ok := r.BasicAuth()
+		require.True(t, ok)
+		require.Equal(t, "write-key", user)
+		require.Empty(t, pass)
+
+		got = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(rsp)
+	}))
+	defer srv.Close()
+
+	sink := NewSegmentSink(srv.URL+"/", "write-key")
+
+	ev := Event{
+		Name:        EventPayoutCompleted,
+		AnonymousID: HashAddress([]byte("salt"), testSkyAddr),
+		Properties:  Properties{"coin_type": "ETH"},
+	}
+
+	rsp = http.StatusOK
+	err := sink.Send([]Event{ev})
+	require.NoError(t, err)
+
+	require.Len(t, got["batch"], 1)
+	msg := got["batch"][0]
+	require.Equal(t, "track", msg["type"])
+	require.Equal(t, EventPayoutCompleted, msg["event"])
+	require.Equal(t, ev.AnonymousID, msg["anonymousId"])
+	require.Equal(t, map[string]interface{}{"coin_type": "ETH"}, msg["properties"])
+
+	rsp = http.StatusBadRequest
+	err = sink.Send([]Event{ev})
+	require.Error(t, err)
+}
+
+func TestNewSinkNone(t *testing.T) {
+	sink, err := NewSink(config.Analytics{
+		Sink: config.AnalyticsSinkNone,
+	})
+	require.NoError(t, err)
+	require.Nil(t, sink)
+
+	_, err = NewSink(config.Analytics{
+		Sink: "kafka",
+	})
+	require.Error(t, err)
+}
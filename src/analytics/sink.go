@@ -0,0 +1,144 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const segmentTimeout = time.Second * 10
+
+// NewSink creates the sink configured by analytics.sink.
+// Returns nil if analytics are disabled.
+func NewSink(cfg config.Analytics) (Sink, error) {
+	switch cfg.Sink {
+	case config.AnalyticsSinkNone:
+		return nil, nil
+	case config.AnalyticsSinkSegment:
+		return NewSegmentSink(cfg.Endpoint, cfg.WriteKey), nil
+	case config.AnalyticsSinkFile:
+		return NewFileSink(cfg.File)
+	default:
+		return nil, fmt.Errorf("Invalid analytics sink \"%s\"", cfg.Sink)
+	}
+}
+
+// SegmentSink sends events to a Segment compatible HTTP tracking API
+type SegmentSink struct {
+	endpoint string
+	writeKey string
+	client   *http.Client
+}
+
+// NewSegmentSink creates a SegmentSink. Events are posted to <endpoint>/v1/batch.
+func NewSegmentSink(endpoint, writeKey string) *SegmentSink {
+	return &SegmentSink{
+		endpoint: strings.TrimRight(endpoint, "/"),
+		writeKey: writeKey,
+		client:   httpclient.New(segmentTimeout),
+	}
+}
+
+type segmentMessage struct {
+	Type string `json:"type"`
+	Event
+}
+
+type segmentBatch struct {
+	Batch []segmentMessage `json:"batch"`
+}
+
+// Send posts the events as a single batch
+func (s *SegmentSink) Send(events []Event) error {
+	b := segmentBatch{
+		Batch: make([]segmentMessage, len(events)),
+	}
+	for i, e := range events {
+		b.Batch[i] = segmentMessage{
+			Type:  "track",
+			Event: e,
+		}
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint+"/v1/batch", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(s.writeKey, "")
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(rsp.Body) // nolint: errcheck
+		return fmt.Errorf("Segment API returned status %d: %s", rsp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// Close does nothing
+func (s *SegmentSink) Close() error {
+	return nil
+}
+
+// FileSink appends events to a file, one JSON object per line
+type FileSink struct {
+	sync.Mutex
+	f *os.File
+}
+
+// NewFileSink opens or creates the file at path for appending
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSink{
+		f: f,
+	}, nil
+}
+
+// Send writes the events to the file
+func (s *FileSink) Send(events []Event) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.f.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.f.Close()
+}
@@ -0,0 +1,196 @@
+// Package backup periodically uploads snapshots of the teller db to object storage,
+// so that a teller without a persistent disk can be restored
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Prefix is the prefix of the backups' object keys
+	Prefix = "backups/"
+
+	backupName       = "teller-"
+	backupTimeFormat = "20060102T150405Z"
+	backupExt        = ".db"
+
+	// retryInterval is how long to wait before backing up again after a failure
+	retryInterval = time.Minute * 10
+)
+
+// Config configures the Backuper
+type Config struct {
+	// How often to back up the db
+	Interval time.Duration
+	// Number of backups to keep, 0 keeps every backup
+	Keep int
+}
+
+// Validate returns an error if the configuration is invalid
+func (c Config) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("Backup interval must be > 0")
+	}
+
+	if c.Keep < 0 {
+		return errors.New("Backup keep can't be negative")
+	}
+
+	return nil
+}
+
+// ObjectStore saves objects in an object storage bucket, such as an objstore.Bucket
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	List(ctx context.Context, prefix string) ([]string, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Backuper uploads a snapshot of the db at startup and every interval, and deletes the oldest backups
+type Backuper struct {
+	log     logrus.FieldLogger
+	cfg     Config
+	db      *bolt.DB
+	objects ObjectStore
+	quit    chan struct{}
+	done    chan struct{}
+	// cancel aborts an upload in progress when Shutdown is called
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewBackuper creates a Backuper
+func NewBackuper(log logrus.FieldLogger, cfg Config, db *bolt.DB, objects ObjectStore) (*Backuper, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Backuper{
+		log:     log.WithField("prefix", "teller.backup"),
+		cfg:     cfg,
+		db:      db,
+		objects: objects,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}, nil
+}
+
+// Run backs up the db once at startup, then every interval until Shutdown is called.
+// A failed backup is retried sooner than the interval.
+func (b *Backuper) Run() error {
+	log := b.log.WithField("config", b.cfg)
+	log.Info("Start backup service")
+	defer log.Info("Backup service closed")
+	defer close(b.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-b.quit:
+			return nil
+		case <-timer.C:
+			wait := b.cfg.Interval
+			if _, err := b.Backup(b.ctx, time.Now()); err != nil {
+				log.WithField("alert", "db_backup").WithError(err).Error("ALERT: db backup failed")
+				if retryInterval < wait {
+					wait = retryInterval
+				}
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+// Shutdown stops the Backuper, aborting an upload in progress
+func (b *Backuper) Shutdown() {
+	close(b.quit)
+	b.cancel()
+	<-b.done
+}
+
+// Backup uploads a snapshot of the db, deletes the backups beyond Keep and returns the backup's key.
+// The snapshot is streamed from a read transaction, without a copy on disk.
+func (b *Backuper) Backup(ctx context.Context, now time.Time) (string, error) {
+	key := Prefix + backupName + now.UTC().Format(backupTimeFormat) + backupExt
+
+	start := time.Now()
+	var size int64
+	if err := b.db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+
+		pr, pw := io.Pipe()
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			_, err := tx.WriteTo(pw)
+			pw.CloseWithError(err) // nolint: errcheck
+		}()
+
+		err := b.objects.Put(ctx, key, pr, size)
+		// Stop the writer if the upload failed before reading the whole snapshot,
+		// and wait for it to stop reading the tx
+		pr.CloseWithError(err) // nolint: errcheck
+		<-written
+		return err
+	}); err != nil {
+		return "", err
+	}
+
+	b.log.WithFields(logrus.Fields{
+		"key":      key,
+		"size":     size,
+		"duration": time.Since(start),
+	}).Info("Uploaded db backup")
+
+	if err := b.prune(ctx); err != nil {
+		b.log.WithError(err).Error("Deleting old db backups failed")
+	}
+
+	return key, nil
+}
+
+// prune deletes the oldest backups beyond Keep
+func (b *Backuper) prune(ctx context.Context) error {
+	if b.cfg.Keep == 0 {
+		return nil
+	}
+
+	keys, err := b.objects.List(ctx, Prefix)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, k := range keys {
+		if strings.HasPrefix(k, Prefix+backupName) && strings.HasSuffix(k, backupExt) {
+			backups = append(backups, k)
+		}
+	}
+
+	// The timestamps sort by time
+	sort.Strings(backups)
+
+	for len(backups) > b.cfg.Keep {
+		if err := b.objects.Delete(ctx, backups[0]); err != nil {
+			return err
+		}
+		b.log.WithField("key", backups[0]).Info("Deleted old db backup")
+		backups = backups[1:]
+	}
+
+	return nil
+}
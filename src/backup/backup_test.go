@@ -0,0 +1,135 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type memObjectStore struct {
+	objects map[string][]byte
+	putErr  error
+}
+
+func (m *memObjectStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if m.putErr != nil {
+		return m.putErr
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return errors.New("size mismatch")
+	}
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memObjectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for k := range m.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *memObjectStore) Delete(ctx context.Context, key string) error {
+	delete(m.objects, key)
+	return nil
+}
+
+func TestBackup(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("deposit_info"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	}))
+
+	objects := &memObjectStore{
+		objects: map[string][]byte{
+			"backups/other": []byte("not a backup"),
+		},
+	}
+	b, err := NewBackuper(log, Config{
+		Interval: time.Hour,
+		Keep:     2,
+	}, db, objects)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	key, err := b.Backup(ctx, now)
+	require.NoError(t, err)
+	require.Equal(t, "backups/teller-20180102T030405Z.db", key)
+
+	// The backup is a copy of the db
+	dir, err := ioutil.TempDir("", "backup")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "restored.db")
+	require.NoError(t, ioutil.WriteFile(path, objects.objects[key], 0600))
+	restored, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, restored.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("v"), tx.Bucket([]byte("deposit_info")).Get([]byte("k")))
+		return nil
+	}))
+	require.NoError(t, restored.Close())
+
+	// The oldest backups beyond Keep are deleted
+	for i := 1; i <= 2; i++ {
+		_, err = b.Backup(ctx, now.Add(time.Hour*time.Duration(i)))
+		require.NoError(t, err)
+	}
+
+	keys, err := objects.List(ctx, Prefix)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"backups/other",
+		"backups/teller-20180102T040405Z.db",
+		"backups/teller-20180102T050405Z.db",
+	}, keys)
+
+	// A failed upload doesn't block the db
+	objects.putErr = errors.New("upload failed")
+	_, err = b.Backup(ctx, now.Add(time.Hour*3))
+	require.Equal(t, objects.putErr, err)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("deposit_info")).Put([]byte("k2"), []byte("v2"))
+	}))
+}
+
+func TestNewBackuperInvalidConfig(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	_, err := NewBackuper(log, Config{}, nil, nil)
+	require.Error(t, err)
+
+	_, err = NewBackuper(log, Config{Interval: time.Hour, Keep: -1}, nil, nil)
+	require.Error(t, err)
+}
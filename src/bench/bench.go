@@ -0,0 +1,503 @@
+// Package bench load tests the exchange pipeline. Binds and deposits are run
+// against the real exchange and store code, with a fake chain and sender, so that
+// performance regressions can be found before an event.
+package bench
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/coin"
+
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+)
+
+const (
+	// skyBtcRate is the SKY/BTC rate of the simulated exchange
+	skyBtcRate = "500"
+	// depositAmount is the amount of each simulated deposit, in satoshis
+	depositAmount = 1e6
+	// txConfirmationCheckWait is how often the exchange checks for a confirmed payout.
+	// The fake sender confirms payouts immediately, so this is only waited after a failure.
+	txConfirmationCheckWait = time.Millisecond * 10
+)
+
+// Config configures a load test
+type Config struct {
+	// Number of deposit addresses bound, each to a different skycoin address
+	Binds int
+	// Number of deposits, spread evenly over the bound deposit addresses
+	Deposits int
+	// Number of bind requests made at the same time
+	Concurrency int
+	// Max time to wait for every deposit to be paid out
+	Timeout time.Duration
+	// Directory of the temporary database, the system's temp directory if empty
+	Dir string
+}
+
+// Validate returns an error if the configuration is invalid
+func (c Config) Validate() error {
+	if c.Binds <= 0 {
+		return errors.New("Binds must be > 0")
+	}
+
+	if c.Deposits < 0 {
+		return errors.New("Deposits can't be negative")
+	}
+
+	if c.Concurrency <= 0 {
+		return errors.New("Concurrency must be > 0")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("Timeout must be > 0")
+	}
+
+	return nil
+}
+
+// Stats are the throughput and latency of one kind of operation
+type Stats struct {
+	Count int `json:"count"`
+	// Wall time from the first operation starting to the last one finishing
+	Duration time.Duration `json:"duration"`
+	// Operations finished per second
+	Throughput float64       `json:"throughput"`
+	P50        time.Duration `json:"p50"`
+	P90        time.Duration `json:"p90"`
+	P99        time.Duration `json:"p99"`
+	Max        time.Duration `json:"max"`
+}
+
+// Result is the result of a load test.
+// Durations are in nanoseconds when encoded to JSON.
+type Result struct {
+	Binds Stats `json:"binds"`
+	// The latency of a deposit is from the scanner reporting it to its payout being confirmed.
+	// Deposits are reported as fast as the exchange accepts them, so the latency includes the time spent
+	// queued behind other deposits.
+	Deposits Stats `json:"deposits"`
+}
+
+// newStats calculates the Stats of operations with latencies lats, which took d in total
+func newStats(lats []time.Duration, d time.Duration) Stats {
+	s := Stats{
+		Count:    len(lats),
+		Duration: d,
+	}
+
+	if len(lats) == 0 {
+		return s
+	}
+
+	if d > 0 {
+		s.Throughput = float64(len(lats)) / d.Seconds()
+	}
+
+	sorted := make([]time.Duration, len(lats))
+	copy(sorted, lats)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	s.P50 = percentile(sorted, 0.5)
+	s.P90 = percentile(sorted, 0.9)
+	s.P99 = percentile(sorted, 0.99)
+	s.Max = sorted[len(sorted)-1]
+
+	return s
+}
+
+// percentile returns the p-th percentile of sorted, by the nearest rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// Run runs a load test.
+// A temporary database is created for the test, and removed afterwards.
+func Run(log logrus.FieldLogger, cfg Config) (*Result, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	log = log.WithField("prefix", "teller.bench")
+
+	db, closeDB, err := openTempDB(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDB()
+
+	h, err := newHarness(log, db)
+	if err != nil {
+		return nil, err
+	}
+	defer h.shutdown()
+
+	log.WithField("binds", cfg.Binds).Info("Binding deposit addresses")
+
+	skyAddrs, depositAddrs, binds, err := h.bind(cfg.Binds, cfg.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithField("deposits", cfg.Deposits).Info("Making deposits")
+
+	// Spread the deposits evenly over the bound addresses
+	dvs := make([]deposits.Deposit, cfg.Deposits)
+	for i := range dvs {
+		dvs[i] = newDeposit(depositAddrs[i%len(depositAddrs)], i)
+	}
+
+	depositStats, err := h.deposit(dvs, skyAddrs, cfg.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Binds:    binds,
+		Deposits: depositStats,
+	}, nil
+}
+
+// openTempDB opens a new bolt.DB in dir. The returned func closes and removes it.
+func openTempDB(dir string) (*bolt.DB, func(), error) {
+	f, err := ioutil.TempFile(dir, "teller-bench")
+	if err != nil {
+		return nil, nil, err
+	}
+	f.Close()
+
+	db, err := bolt.Open(f.Name(), 0700, nil)
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, nil, err
+	}
+
+	return db, func() {
+		db.Close()
+		os.Remove(f.Name())
+	}, nil
+}
+
+// harness runs an Exchange with a fake chain and sender
+type harness struct {
+	exchange    *exchange.Exchange
+	scanner     *fakeScanner
+	multiplexer *scanner.Multiplexer
+	tracker     *payoutTracker
+	done        chan struct{}
+}
+
+func newHarness(log logrus.FieldLogger, db *bolt.DB) (*harness, error) {
+	store, err := exchange.NewStore(log, db)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := newFakeScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	if err := multiplexer.AddScanner(scan, scanner.CoinTypeBTC); err != nil {
+		return nil, err
+	}
+
+	tracker := newPayoutTracker()
+
+	e, err := exchange.NewExchange(log, store, multiplexer, &fakeSender{}, tracker, exchange.Config{
+		BtcRate:                 skyBtcRate,
+		TxConfirmationCheckWait: txConfirmationCheckWait,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := &harness{
+		exchange:    e,
+		scanner:     scan,
+		multiplexer: multiplexer,
+		tracker:     tracker,
+		done:        make(chan struct{}),
+	}
+
+	go multiplexer.Run() // nolint: errcheck
+	go func() {
+		defer close(h.done)
+		if err := e.Run(); err != nil {
+			log.WithError(err).Error("exchange.Run failed")
+		}
+	}()
+
+	return h, nil
+}
+
+func (h *harness) shutdown() {
+	h.exchange.Shutdown()
+	<-h.done
+	h.scanner.stop()
+	h.multiplexer.Shutdown()
+}
+
+// bind binds n deposit addresses, each to a new skycoin address, with concurrency binds at a time.
+// It returns the bound skycoin address of each deposit address.
+func (h *harness) bind(n, concurrency int) (map[string]string, []string, Stats, error) {
+	skyAddrs := make(map[string]string, n)
+	depositAddrs := make([]string, n)
+	for i := range depositAddrs {
+		depositAddrs[i] = fmt.Sprintf("bench-deposit-%d", i)
+		skyAddrs[depositAddrs[i]] = newSkyAddress(i)
+	}
+
+	lats := make([]time.Duration, n)
+	errC := make(chan error, concurrency)
+	indexes := make(chan int)
+
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				t := time.Now()
+				if err := h.exchange.BindAddress(skyAddrs[depositAddrs[i]], depositAddrs[i], scanner.CoinTypeBTC, ""); err != nil {
+					errC <- err
+					return
+				}
+				lats[i] = time.Since(t)
+			}
+		}()
+	}
+
+	var err error
+loop:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case err = <-errC:
+			break loop
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errC:
+		default:
+		}
+	}
+
+	if err != nil {
+		return nil, nil, Stats{}, fmt.Errorf("BindAddress failed: %v", err)
+	}
+
+	return skyAddrs, depositAddrs, newStats(lats, time.Since(start)), nil
+}
+
+// deposit reports the deposits to the exchange as fast as it accepts them and waits for them to be paid out
+func (h *harness) deposit(dvs []deposits.Deposit, skyAddrs map[string]string, timeout time.Duration) (Stats, error) {
+	if len(dvs) == 0 {
+		return Stats{}, nil
+	}
+
+	h.tracker.expect(len(dvs))
+
+	start := time.Now()
+	for _, dv := range dvs {
+		h.tracker.started(skyAddrs[dv.Address])
+		h.scanner.addDeposit(dv)
+	}
+
+	select {
+	case <-h.tracker.done:
+	case <-time.After(timeout):
+		return Stats{}, fmt.Errorf("Only %d of %d deposits were paid out in %s", h.tracker.count(), len(dvs), timeout)
+	}
+
+	return newStats(h.tracker.latencies(), time.Since(start)), nil
+}
+
+func newSkyAddress(i int) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return cipher.Address{
+		Key: cipher.HashRipemd160(b),
+	}.String()
+}
+
+func newDeposit(depositAddr string, i int) deposits.Deposit {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(i))
+	return deposits.Deposit{
+		CoinType:      scanner.CoinTypeBTC,
+		Address:       depositAddr,
+		Tx:            cipher.SumSHA256(b).Hex(),
+		Amount:        depositAmount,
+		Height:        int64(i),
+		Confirmations: 1,
+		Final:         true,
+	}
+}
+
+// fakeScanner reports the deposits given to it
+type fakeScanner struct {
+	dvC chan scanner.DepositNote
+}
+
+func newFakeScanner() *fakeScanner {
+	return &fakeScanner{
+		dvC: make(chan scanner.DepositNote, 100),
+	}
+}
+
+func (s *fakeScanner) AddScanAddress(depositAddr, coinType string) error {
+	return nil
+}
+
+func (s *fakeScanner) GetDeposit() <-chan scanner.DepositNote {
+	return s.dvC
+}
+
+func (s *fakeScanner) PendingDeposits(coinType string) []deposits.Deposit {
+	return nil
+}
+
+// Run returns at once, the deposits are added by the harness
+func (s *fakeScanner) Run() error {
+	return nil
+}
+
+// Shutdown does nothing, the harness closes the deposits with stop
+func (s *fakeScanner) Shutdown() {}
+
+func (s *fakeScanner) addDeposit(dv deposits.Deposit) {
+	s.dvC <- scanner.NewDepositNote(dv)
+}
+
+func (s *fakeScanner) stop() {
+	close(s.dvC)
+}
+
+// fakeSender creates unique transactions paying the requested coins,
+// and reports every broadcast transaction as confirmed
+type fakeSender struct {
+	sync.Mutex
+	seq uint64
+}
+
+func (s *fakeSender) CreateTransaction(destAddr string, coins uint64) (*coin.Transaction, error) {
+	addr, err := cipher.DecodeBase58Address(destAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	s.seq++
+	seq := s.seq
+	s.Unlock()
+
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+
+	txn := &coin.Transaction{}
+	txn.PushInput(cipher.SumSHA256(b))
+	txn.PushOutput(addr, coins, 0)
+	return txn, nil
+}
+
+func (s *fakeSender) BroadcastTransaction(txn *coin.Transaction, key string) *sender.BroadcastTxResponse {
+	return &sender.BroadcastTxResponse{
+		Txid: txn.TxIDHex(),
+	}
+}
+
+func (s *fakeSender) IsTxConfirmed(txid string) *sender.ConfirmResponse {
+	return &sender.ConfirmResponse{
+		Confirmed: true,
+	}
+}
+
+// payoutTracker measures the latency of each deposit from its start to its payout being completed.
+// Deposits to the same skycoin address are paid out in the order they arrive.
+type payoutTracker struct {
+	sync.Mutex
+	starts   map[string][]time.Time
+	lats     []time.Duration
+	expected int
+	done     chan struct{}
+}
+
+func newPayoutTracker() *payoutTracker {
+	return &payoutTracker{
+		starts: make(map[string][]time.Time),
+		done:   make(chan struct{}),
+	}
+}
+
+func (t *payoutTracker) expect(n int) {
+	t.Lock()
+	defer t.Unlock()
+	t.expected = n
+}
+
+func (t *payoutTracker) started(skyAddr string) {
+	t.Lock()
+	defer t.Unlock()
+	t.starts[skyAddr] = append(t.starts[skyAddr], time.Now())
+}
+
+// Track implements analytics.Tracker
+func (t *payoutTracker) Track(name, skyAddr string, props analytics.Properties) {
+	if name != analytics.EventPayoutCompleted {
+		return
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	starts := t.starts[skyAddr]
+	if len(starts) == 0 {
+		return
+	}
+	t.starts[skyAddr] = starts[1:]
+
+	t.lats = append(t.lats, time.Since(starts[0]))
+	if len(t.lats) == t.expected {
+		close(t.done)
+	}
+}
+
+func (t *payoutTracker) count() int {
+	t.Lock()
+	defer t.Unlock()
+	return len(t.lats)
+}
+
+func (t *payoutTracker) latencies() []time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	lats := make([]time.Duration, len(t.lats))
+	copy(lats, t.lats)
+	return lats
+}
@@ -0,0 +1,153 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestNewStats(t *testing.T) {
+	lats := make([]time.Duration, 100)
+	for i := range lats {
+		lats[i] = time.Duration(100-i) * time.Millisecond
+	}
+
+	s := newStats(lats, time.Second*2)
+	require.Equal(t, Stats{
+		Count:      100,
+		Duration:   time.Second * 2,
+		Throughput: 50,
+		P50:        time.Millisecond * 50,
+		P90:        time.Millisecond * 90,
+		P99:        time.Millisecond * 99,
+		Max:        time.Millisecond * 100,
+	}, s)
+
+	// The latencies are not modified
+	require.Equal(t, time.Millisecond*100, lats[0])
+
+	s = newStats([]time.Duration{time.Second}, time.Second)
+	require.Equal(t, time.Second, s.P50)
+	require.Equal(t, time.Second, s.P99)
+
+	require.Equal(t, Stats{}, newStats(nil, 0))
+}
+
+func TestRun(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	r, err := Run(log, Config{
+		Binds:       20,
+		Deposits:    50,
+		Concurrency: 4,
+		Timeout:     time.Second * 30,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 20, r.Binds.Count)
+	require.True(t, r.Binds.Throughput > 0)
+	require.True(t, r.Binds.P50 <= r.Binds.P99)
+	require.True(t, r.Binds.P99 <= r.Binds.Max)
+
+	require.Equal(t, 50, r.Deposits.Count)
+	require.True(t, r.Deposits.Throughput > 0)
+	require.True(t, r.Deposits.P50 <= r.Deposits.P99)
+	require.True(t, r.Deposits.P99 <= r.Deposits.Max)
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := Config{
+		Binds:       1,
+		Concurrency: 1,
+		Timeout:     time.Second,
+	}
+	require.NoError(t, cfg.Validate())
+
+	c := cfg
+	c.Binds = 0
+	_, err := Run(log, c)
+	require.Error(t, err)
+
+	c = cfg
+	c.Deposits = -1
+	_, err = Run(log, c)
+	require.Error(t, err)
+
+	c = cfg
+	c.Concurrency = 0
+	_, err = Run(log, c)
+	require.Error(t, err)
+
+	c = cfg
+	c.Timeout = 0
+	_, err = Run(log, c)
+	require.Error(t, err)
+}
+
+func newBenchHarness(b *testing.B) (*harness, func()) {
+	db, closeDB, err := openTempDB("")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.Level = logrus.ErrorLevel
+
+	h, err := newHarness(log, db)
+	if err != nil {
+		closeDB()
+		b.Fatal(err)
+	}
+
+	return h, func() {
+		h.shutdown()
+		closeDB()
+	}
+}
+
+func BenchmarkBindAddress(b *testing.B) {
+	h, shutdown := newBenchHarness(b)
+	defer shutdown()
+
+	b.ResetTimer()
+	if _, _, _, err := h.bind(b.N, 1); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkBindAddressParallel(b *testing.B) {
+	h, shutdown := newBenchHarness(b)
+	defer shutdown()
+
+	b.ResetTimer()
+	if _, _, _, err := h.bind(b.N, 16); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkDeposit(b *testing.B) {
+	h, shutdown := newBenchHarness(b)
+	defer shutdown()
+
+	skyAddrs, depositAddrs, _, err := h.bind(100, 16)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	dvs := make([]deposits.Deposit, b.N)
+	for i := range dvs {
+		dvs[i] = newDeposit(depositAddrs[i%len(depositAddrs)], i)
+	}
+
+	b.ResetTimer()
+	if _, err := h.deposit(dvs, skyAddrs, time.Minute*10); err != nil {
+		b.Fatal(err)
+	}
+}
@@ -0,0 +1,462 @@
+// Package capture records the requests and responses of the API during a time-boxed capture session,
+// to debug client integrations which behave differently from curl. A session records the requests
+// to some endpoints, or the requests which mention a skycoin address. The records are sanitized,
+// saved in the db and read back from the admin API.
+package capture
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// CapturesBkt maps the sequence number of a record to its Record
+var CapturesBkt = []byte("debug_captures")
+
+const redacted = "<redacted>"
+
+var (
+	// ErrSessionActive is returned by Start while a capture session is active
+	ErrSessionActive = errors.New("A capture session is already active")
+	// ErrNoSession is returned by Stop when no capture session is active
+	ErrNoSession = errors.New("No capture session is active")
+)
+
+// redactedHeaders carry credentials or the client's IP, and are not recorded
+var redactedHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Captcha-Token",
+	"X-Widget-Session",
+	"X-Forwarded-For",
+	"X-Real-Ip",
+}
+
+// redactedFields are the JSON fields of request and response bodies which are not recorded
+var redactedFields = map[string]struct{}{
+	"email": {},
+	"token": {},
+}
+
+// Filter selects the requests recorded by a capture session. A request must match every field which is set.
+type Filter struct {
+	// Paths of the endpoints, e.g. "/api/bind"
+	Endpoints []string `json:"endpoints,omitempty"`
+	// A skycoin address in the query, the request body or the response body
+	SkyAddr string `json:"skyaddr,omitempty"`
+}
+
+// Validate returns an error if the filter selects every request, or has an invalid endpoint
+func (f Filter) Validate() error {
+	if len(f.Endpoints) == 0 && f.SkyAddr == "" {
+		return errors.New("endpoints or skyaddr required")
+	}
+
+	for _, e := range f.Endpoints {
+		if !strings.HasPrefix(e, "/api/") {
+			return fmt.Errorf("Invalid endpoint %q, must start with /api/", e)
+		}
+	}
+
+	return nil
+}
+
+// Session is a capture session
+type Session struct {
+	ID string `json:"id"`
+	Filter
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// StoppedAt is set once the session was stopped, expired or reached the max records
+	StoppedAt *time.Time `json:"stopped_at,omitempty"`
+	Records   int        `json:"records"`
+	// Active is true while the session records
+	Active bool `json:"active"`
+}
+
+// recording returns true if the session records at time now
+func (s *Session) recording(now time.Time) bool {
+	return s != nil && s.StoppedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// snapshot returns a copy of the session at time now
+func (s *Session) snapshot(now time.Time) Session {
+	cp := *s
+	cp.Active = s.recording(now)
+	return cp
+}
+
+// Record is a sanitized request and its response
+type Record struct {
+	Seq       uint64    `json:"seq"`
+	SessionID string    `json:"session_id"`
+	Time      time.Time `json:"time"`
+	// Milliseconds spent handling the request
+	DurationMs int64 `json:"duration_ms"`
+
+	Method         string      `json:"method"`
+	Path           string      `json:"path"`
+	Query          string      `json:"query,omitempty"`
+	RequestHeaders http.Header `json:"request_headers"`
+	RequestBody    string      `json:"request_body,omitempty"`
+	// RequestTruncated is true if only the start of the request body was recorded
+	RequestTruncated bool `json:"request_truncated,omitempty"`
+
+	Status            int         `json:"status"`
+	ResponseHeaders   http.Header `json:"response_headers"`
+	ResponseBody      string      `json:"response_body,omitempty"`
+	ResponseTruncated bool        `json:"response_truncated,omitempty"`
+}
+
+// Recorder records the requests selected by the capture session, if one is active
+type Recorder struct {
+	sync.Mutex
+	log     logrus.FieldLogger
+	cfg     config.Capture
+	db      *bolt.DB
+	clock   clock.Clock
+	session *Session // the last session, nil if none was started
+}
+
+// New creates a Recorder. It returns nil if capturing is disabled, which is safe to use.
+func New(log logrus.FieldLogger, cfg config.Capture, db *bolt.DB, clk clock.Clock) (*Recorder, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(CapturesBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(CapturesBkt, err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		log:   log.WithField("prefix", "teller.capture"),
+		cfg:   cfg,
+		db:    db,
+		clock: clk,
+	}, nil
+}
+
+// Start starts a capture session which records the requests matching f for d.
+// d may not exceed the configured max duration.
+func (c *Recorder) Start(f Filter, d time.Duration) (Session, error) {
+	if err := f.Validate(); err != nil {
+		return Session{}, err
+	}
+
+	if d <= 0 || d > c.cfg.MaxDuration {
+		return Session{}, fmt.Errorf("duration must be > 0 and <= %s", c.cfg.MaxDuration)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock.Now().UTC()
+	if c.session.recording(now) {
+		return Session{}, ErrSessionActive
+	}
+
+	c.session = &Session{
+		ID:        now.Format("20060102T150405.000Z"),
+		Filter:    f,
+		StartedAt: now,
+		ExpiresAt: now.Add(d),
+	}
+
+	c.log.WithField("session", c.session).Warn("Capture session started")
+
+	return c.session.snapshot(now), nil
+}
+
+// Stop stops the active capture session
+func (c *Recorder) Stop() (Session, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock.Now().UTC()
+	if !c.session.recording(now) {
+		return Session{}, ErrNoSession
+	}
+
+	c.session.StoppedAt = &now
+	c.log.WithField("session", c.session).Warn("Capture session stopped")
+
+	return c.session.snapshot(now), nil
+}
+
+// Session returns the last capture session, nil if none was started
+func (c *Recorder) Session() *Session {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.session == nil {
+		return nil
+	}
+
+	s := c.session.snapshot(c.clock.Now())
+	return &s
+}
+
+// Records returns the records of a session, or of every session if sessionID is empty.
+// At most limit records are returned, the newest ones, in the order they were recorded. 0 is no limit.
+func (c *Recorder) Records(sessionID string, limit int) ([]Record, error) {
+	var records []Record
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(CapturesBkt).Cursor()
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			if limit > 0 && len(records) >= limit {
+				break
+			}
+
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if sessionID != "" && r.SessionID != sessionID {
+				continue
+			}
+			records = append(records, r)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+
+	return records, nil
+}
+
+// Clear deletes every record, and returns how many were deleted
+func (c *Recorder) Clear() (int, error) {
+	var n int
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		n = tx.Bucket(CapturesBkt).Stats().KeyN
+		if err := tx.DeleteBucket(CapturesBkt); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket(CapturesBkt)
+		return err
+	})
+	return n, err
+}
+
+// Handler records the requests to h which match the active capture session
+func (c *Recorder) Handler(h http.Handler) http.Handler {
+	if c == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := c.match(r.URL.Path)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		start := c.clock.Now()
+
+		reqBody := &limitedBuffer{max: c.cfg.MaxBodySize}
+		if r.Body != nil {
+			r.Body = &teeBody{
+				ReadCloser: r.Body,
+				buf:        reqBody,
+			}
+		}
+
+		rw := &responseRecorder{
+			ResponseWriter: w,
+			body: limitedBuffer{
+				max: c.cfg.MaxBodySize,
+			},
+		}
+
+		h.ServeHTTP(rw, r)
+
+		if session.SkyAddr != "" && !strings.Contains(r.URL.RawQuery, session.SkyAddr) &&
+			!bytes.Contains(reqBody.Bytes(), []byte(session.SkyAddr)) && !bytes.Contains(rw.body.Bytes(), []byte(session.SkyAddr)) {
+			return
+		}
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		c.save(session, Record{
+			SessionID:         session.ID,
+			Time:              start.UTC(),
+			DurationMs:        int64(c.clock.Now().Sub(start) / time.Millisecond),
+			Method:            r.Method,
+			Path:              r.URL.Path,
+			Query:             r.URL.RawQuery,
+			RequestHeaders:    sanitizeHeader(r.Header),
+			RequestBody:       sanitizeBody(reqBody),
+			RequestTruncated:  reqBody.truncated,
+			Status:            status,
+			ResponseHeaders:   sanitizeHeader(w.Header()),
+			ResponseBody:      sanitizeBody(&rw.body),
+			ResponseTruncated: rw.body.truncated,
+		})
+	})
+}
+
+// match returns the active session if it records path
+func (c *Recorder) match(path string) (Session, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := c.clock.Now().UTC()
+	if c.session == nil || c.session.StoppedAt != nil {
+		return Session{}, false
+	}
+
+	if !now.Before(c.session.ExpiresAt) {
+		expiresAt := c.session.ExpiresAt
+		c.session.StoppedAt = &expiresAt
+		c.log.WithField("session", c.session).Warn("Capture session expired")
+		return Session{}, false
+	}
+
+	if len(c.session.Endpoints) != 0 {
+		var ok bool
+		for _, e := range c.session.Endpoints {
+			if e == path {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return Session{}, false
+		}
+	}
+
+	return *c.session, true
+}
+
+// save saves a record of session, and stops the session once it reached the max records
+func (c *Recorder) save(session Session, r Record) {
+	c.Lock()
+	defer c.Unlock()
+
+	// The session may have been stopped while the request was handled
+	if c.session == nil || c.session.ID != session.ID || c.session.StoppedAt != nil {
+		return
+	}
+
+	if err := c.db.Update(func(tx *bolt.Tx) error {
+		seq, err := dbutil.NextSequence(tx, CapturesBkt)
+		if err != nil {
+			return err
+		}
+		r.Seq = seq
+
+		return dbutil.PutBucketValue(tx, CapturesBkt, recordKey(seq), r)
+	}); err != nil {
+		c.log.WithError(err).Error("Save capture record failed")
+		return
+	}
+
+	c.session.Records++
+	if c.session.Records >= c.cfg.MaxRecords {
+		now := c.clock.Now().UTC()
+		c.session.StoppedAt = &now
+		c.log.WithField("session", c.session).Warn("Capture session reached capture.max_records, stopped")
+	}
+}
+
+func recordKey(seq uint64) string {
+	// zero padded so that bolt's byte ordering matches the sequence ordering
+	return fmt.Sprintf("%020d", seq)
+}
+
+// sanitizeHeader copies h without the redactedHeaders
+func sanitizeHeader(h http.Header) http.Header {
+	s := make(http.Header, len(h))
+	for k, v := range h {
+		s[k] = append([]string(nil), v...)
+	}
+
+	for _, k := range redactedHeaders {
+		if _, ok := s[k]; ok {
+			s[k] = []string{redacted}
+		}
+	}
+
+	return s
+}
+
+// sanitizeBody returns the recorded body, with the redactedFields replaced if it is JSON.
+// A truncated body can't be parsed, it is dropped if it may contain a redacted field.
+func sanitizeBody(b *limitedBuffer) string {
+	body := b.Bytes()
+	if len(body) == 0 {
+		return ""
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		lower := bytes.ToLower(body)
+		for f := range redactedFields {
+			if bytes.Contains(lower, []byte(`"`+f+`"`)) {
+				return redacted
+			}
+		}
+		return string(body)
+	}
+
+	if !redactJSON(v) {
+		return string(body)
+	}
+
+	s, err := json.Marshal(v)
+	if err != nil {
+		return redacted
+	}
+	return string(s)
+}
+
+// redactJSON replaces the redactedFields of a JSON decoded value, returning true if any was found
+func redactJSON(v interface{}) bool {
+	var changed bool
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, kv := range x {
+			if _, ok := redactedFields[strings.ToLower(k)]; ok {
+				x[k] = redacted
+				changed = true
+				continue
+			}
+			if redactJSON(kv) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, iv := range x {
+			if redactJSON(iv) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
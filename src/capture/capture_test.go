@@ -0,0 +1,328 @@
+package capture
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+const testSkyAddr = "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+
+func testConfig() config.Capture {
+	return config.Capture{
+		Enabled:     true,
+		MaxDuration: time.Hour,
+		MaxRecords:  10,
+		MaxBodySize: 1024,
+	}
+}
+
+func newTestRecorder(t *testing.T, cfg config.Capture) (*Recorder, *clock.Fake, func()) {
+	db, shutdown := testutil.PrepareDB(t)
+	log, _ := testutil.NewLogger(t)
+	clk := clock.NewFake(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	c, err := New(log, cfg, db, clk)
+	require.NoError(t, err)
+	require.NotNil(t, c)
+
+	return c, clk, shutdown
+}
+
+// echoHandler responds with the request body and a cookie
+func echoHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:  "session",
+			Value: "secret",
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body) // nolint: errcheck
+	})
+}
+
+func serve(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("User-Agent", "partner-client/1.0")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestNewDisabled(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	c, err := New(log, config.Capture{}, db, clock.Real{})
+	require.NoError(t, err)
+	require.Nil(t, c)
+
+	// A nil Recorder doesn't wrap the handler
+	h := echoHandler()
+	w := serve(c.Handler(h), http.MethodPost, "/api/bind", "{}")
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Equal(t, "{}", w.Body.String())
+}
+
+func TestStartStop(t *testing.T) {
+	c, clk, shutdown := newTestRecorder(t, testConfig())
+	defer shutdown()
+
+	require.Nil(t, c.Session())
+
+	_, err := c.Stop()
+	require.Equal(t, ErrNoSession, err)
+
+	// A filter is required
+	_, err = c.Start(Filter{}, time.Minute)
+	require.Error(t, err)
+	_, err = c.Start(Filter{Endpoints: []string{"/status"}}, time.Minute)
+	require.Error(t, err)
+
+	// The duration is bounded
+	_, err = c.Start(Filter{SkyAddr: testSkyAddr}, 0)
+	require.Error(t, err)
+	_, err = c.Start(Filter{SkyAddr: testSkyAddr}, time.Hour*2)
+	require.Error(t, err)
+
+	s, err := c.Start(Filter{SkyAddr: testSkyAddr}, time.Minute*15)
+	require.NoError(t, err)
+	require.True(t, s.Active)
+	require.Equal(t, clk.Now(), s.StartedAt)
+	require.Equal(t, clk.Now().Add(time.Minute*15), s.ExpiresAt)
+	require.Nil(t, s.StoppedAt)
+
+	_, err = c.Start(Filter{SkyAddr: testSkyAddr}, time.Minute)
+	require.Equal(t, ErrSessionActive, err)
+
+	clk.Advance(time.Minute)
+	s, err = c.Stop()
+	require.NoError(t, err)
+	require.False(t, s.Active)
+	require.NotNil(t, s.StoppedAt)
+	require.Equal(t, clk.Now(), *s.StoppedAt)
+
+	_, err = c.Stop()
+	require.Equal(t, ErrNoSession, err)
+
+	// The session expires
+	s, err = c.Start(Filter{SkyAddr: testSkyAddr}, time.Minute)
+	require.NoError(t, err)
+	require.True(t, c.Session().Active)
+	clk.Advance(time.Minute)
+	require.False(t, c.Session().Active)
+	_, err = c.Stop()
+	require.Equal(t, ErrNoSession, err)
+
+	// A new session can start once the last one expired
+	s2, err := c.Start(Filter{SkyAddr: testSkyAddr}, time.Minute)
+	require.NoError(t, err)
+	require.NotEqual(t, s.ID, s2.ID)
+}
+
+func TestHandlerEndpoints(t *testing.T) {
+	c, clk, shutdown := newTestRecorder(t, testConfig())
+	defer shutdown()
+
+	h := c.Handler(echoHandler())
+
+	// Nothing is recorded without a session
+	serve(h, http.MethodPost, "/api/bind", `{"skyaddr":"`+testSkyAddr+`"}`)
+	records, err := c.Records("", 0)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	s, err := c.Start(Filter{Endpoints: []string{"/api/bind"}}, time.Minute)
+	require.NoError(t, err)
+
+	serve(h, http.MethodPost, "/api/status?skyaddr="+testSkyAddr, "")
+	clk.Advance(time.Second)
+	w := serve(h, http.MethodPost, "/api/bind", `{"skyaddr":"`+testSkyAddr+`","email":"a@example.com","nested":{"Token":"abc"}}`)
+	// The client's response is unchanged
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Contains(t, w.Body.String(), "a@example.com")
+	require.NotEmpty(t, w.Header().Get("Set-Cookie"))
+
+	records, err = c.Records("", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	r := records[0]
+	require.Equal(t, uint64(1), r.Seq)
+	require.Equal(t, s.ID, r.SessionID)
+	require.Equal(t, clk.Now(), r.Time)
+	require.Equal(t, http.MethodPost, r.Method)
+	require.Equal(t, "/api/bind", r.Path)
+	require.Equal(t, http.StatusCreated, r.Status)
+	require.Equal(t, "partner-client/1.0", r.RequestHeaders.Get("User-Agent"))
+	require.Equal(t, redacted, r.RequestHeaders.Get("Authorization"))
+	require.Equal(t, redacted, r.ResponseHeaders.Get("Set-Cookie"))
+	require.Equal(t, "application/json", r.ResponseHeaders.Get("Content-Type"))
+	require.Contains(t, r.RequestBody, testSkyAddr)
+	require.NotContains(t, r.RequestBody, "a@example.com")
+	require.NotContains(t, r.RequestBody, "abc")
+	require.Equal(t, r.RequestBody, r.ResponseBody)
+	require.False(t, r.RequestTruncated)
+	require.False(t, r.ResponseTruncated)
+	require.Equal(t, 1, c.Session().Records)
+
+	// Nothing is recorded once the session expired
+	clk.Advance(time.Minute)
+	serve(h, http.MethodPost, "/api/bind", "{}")
+	records, err = c.Records("", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.NotNil(t, c.Session().StoppedAt)
+}
+
+func TestHandlerSkyAddr(t *testing.T) {
+	c, _, shutdown := newTestRecorder(t, testConfig())
+	defer shutdown()
+
+	h := c.Handler(echoHandler())
+
+	_, err := c.Start(Filter{SkyAddr: testSkyAddr}, time.Minute)
+	require.NoError(t, err)
+
+	serve(h, http.MethodGet, "/api/status?skyaddr="+testSkyAddr, "")
+	serve(h, http.MethodGet, "/api/status?skyaddr=other", "")
+	serve(h, http.MethodPost, "/api/bind", `{"skyaddr":"`+testSkyAddr+`"}`)
+	serve(h, http.MethodPost, "/api/bind", `{"skyaddr":"other"}`)
+
+	records, err := c.Records("", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, "skyaddr="+testSkyAddr, records[0].Query)
+	require.Equal(t, "/api/bind", records[1].Path)
+}
+
+func TestHandlerTruncated(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxBodySize = 16
+	c, _, shutdown := newTestRecorder(t, cfg)
+	defer shutdown()
+
+	h := c.Handler(echoHandler())
+
+	_, err := c.Start(Filter{Endpoints: []string{"/api/bind"}}, time.Minute)
+	require.NoError(t, err)
+
+	body := `{"skyaddr":"` + testSkyAddr + `"}`
+	w := serve(h, http.MethodPost, "/api/bind", body)
+	// The handler reads and writes the whole body
+	require.Equal(t, body, w.Body.String())
+
+	emailBody := `{"email":"a@example.com","skyaddr":"` + testSkyAddr + `"}`
+	serve(h, http.MethodPost, "/api/bind", emailBody)
+
+	records, err := c.Records("", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	require.Equal(t, body[:16], records[0].RequestBody)
+	require.True(t, records[0].RequestTruncated)
+	require.Equal(t, body[:16], records[0].ResponseBody)
+	require.True(t, records[0].ResponseTruncated)
+
+	// A truncated body which may contain a redacted field is dropped
+	require.Equal(t, redacted, records[1].RequestBody)
+	require.True(t, records[1].RequestTruncated)
+}
+
+func TestMaxRecords(t *testing.T) {
+	cfg := testConfig()
+	cfg.MaxRecords = 3
+	c, _, shutdown := newTestRecorder(t, cfg)
+	defer shutdown()
+
+	h := c.Handler(echoHandler())
+
+	s, err := c.Start(Filter{Endpoints: []string{"/api/bind"}}, time.Minute)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		serve(h, http.MethodPost, "/api/bind", "{}")
+	}
+
+	records, err := c.Records(s.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	session := c.Session()
+	require.Equal(t, 3, session.Records)
+	require.False(t, session.Active)
+	require.NotNil(t, session.StoppedAt)
+}
+
+func TestRecordsAndClear(t *testing.T) {
+	c, clk, shutdown := newTestRecorder(t, testConfig())
+	defer shutdown()
+
+	h := c.Handler(echoHandler())
+
+	s1, err := c.Start(Filter{Endpoints: []string{"/api/bind"}}, time.Minute)
+	require.NoError(t, err)
+	serve(h, http.MethodPost, "/api/bind", `{"n":1}`)
+	serve(h, http.MethodPost, "/api/bind", `{"n":2}`)
+	_, err = c.Stop()
+	require.NoError(t, err)
+
+	clk.Advance(time.Second)
+	s2, err := c.Start(Filter{Endpoints: []string{"/api/bind"}}, time.Minute)
+	require.NoError(t, err)
+	serve(h, http.MethodPost, "/api/bind", `{"n":3}`)
+
+	records, err := c.Records("", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	for i, r := range records {
+		require.Equal(t, uint64(i+1), r.Seq)
+	}
+
+	records, err = c.Records(s1.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+
+	records, err = c.Records(s2.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, `{"n":3}`, records[0].RequestBody)
+
+	// The limit keeps the newest records, in order
+	records, err = c.Records("", 2)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, `{"n":2}`, records[0].RequestBody)
+	require.Equal(t, `{"n":3}`, records[1].RequestBody)
+
+	n, err := c.Clear()
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	records, err = c.Records("", 0)
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	// The active session keeps recording after a clear
+	serve(h, http.MethodPost, "/api/bind", `{"n":4}`)
+	records, err = c.Records("", 0)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+}
@@ -0,0 +1,70 @@
+package capture
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := b.max - b.Len(); room < len(p) {
+		b.truncated = true
+		if room <= 0 {
+			return n, nil
+		}
+		p = p[:room]
+	}
+	b.Buffer.Write(p) // nolint: errcheck
+	return n, nil
+}
+
+// teeBody records the request body read by the handler
+type teeBody struct {
+	io.ReadCloser
+	buf *limitedBuffer
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.buf.Write(p[:n]) // nolint: errcheck
+	}
+	return n, err
+}
+
+// responseRecorder records the status and body written by the handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   limitedBuffer
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(p) // nolint: errcheck
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush flushes the underlying ResponseWriter, for the requests held by /api/status/wait
+func (w *responseRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,246 @@
+// Package client calls the public API of a running teller
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skycoin/teller/src/teller"
+)
+
+const (
+	// ErrorCodeHeader carries the machine readable error code of an error response
+	ErrorCodeHeader = "X-Error-Code"
+	// clientTimeout bounds a request. A bind request may wait for a deposit address.
+	clientTimeout = time.Minute
+	// defaultMaxAttempts is the number of times a GET request is sent if it keeps getting a transient failure
+	defaultMaxAttempts = 3
+	// defaultMaxRetryWait bounds the total wait between the attempts of a request
+	defaultMaxRetryWait = time.Second * 30
+	// defaultRetryAfter is the wait after a transient failure without a Retry-After header
+	defaultRetryAfter = time.Second
+)
+
+// Error is an error response of the API
+type Error struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	// Code is the X-Error-Code header, e.g. "busy", empty if the response has none
+	Code string
+	// RetryAfter is the Retry-After header of a transient failure, 0 if the response has none
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s %s: %s", e.Method, e.Path, e.Status)
+	if e.Code != "" {
+		msg += fmt.Sprintf(" (%s)", e.Code)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Temporary returns true if the request is worth retrying unchanged after RetryAfter
+func (e *Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// Client calls the public API of a teller
+type Client struct {
+	// Addr is the API's base URL, e.g. http://127.0.0.1:7071
+	Addr string
+	HTTP *http.Client
+	// MaxAttempts is the number of times a GET request is sent while it gets a 429 or 503 response.
+	// A bind is a POST and is sent once. 0 or 1 disables the retries.
+	MaxAttempts int
+	// MaxRetryWait bounds the total wait of a request's retries.
+	// A retry whose Retry-After would exceed it isn't made, and its error is returned.
+	MaxRetryWait time.Duration
+}
+
+// New creates a Client
+func New(addr string) *Client {
+	return &Client{
+		Addr: strings.TrimRight(addr, "/"),
+		HTTP: &http.Client{
+			Timeout: clientTimeout,
+		},
+		MaxAttempts:  defaultMaxAttempts,
+		MaxRetryWait: defaultMaxRetryWait,
+	}
+}
+
+// Bind binds a deposit address of coinType to skyAddr
+func (c *Client) Bind(skyAddr, coinType string) (*teller.BindResponse, error) {
+	body, err := json.Marshal(map[string]string{
+		"skyaddr":   skyAddr,
+		"coin_type": coinType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rsp teller.BindResponse
+	if err := c.do(http.MethodPost, "/api/bind", bytes.NewReader(body), &rsp); err != nil {
+		return nil, err
+	}
+
+	return &rsp, nil
+}
+
+// Status returns the deposit statuses of skyAddr
+func (c *Client) Status(skyAddr string) ([]teller.DepositStatus, error) {
+	var rsp teller.StatusResponse
+	if err := c.do(http.MethodGet, "/api/status?skyaddr="+url.QueryEscape(skyAddr), nil, &rsp); err != nil {
+		return nil, err
+	}
+
+	return rsp.Statuses, nil
+}
+
+// Version returns the build of teller and the coin types it accepts
+func (c *Client) Version() (*teller.VersionResponse, error) {
+	var rsp teller.VersionResponse
+	if err := c.do(http.MethodGet, "/api/version", nil, &rsp); err != nil {
+		return nil, err
+	}
+
+	return &rsp, nil
+}
+
+// PublicStatus returns the service availability status
+func (c *Client) PublicStatus() (*teller.PublicStatusResponse, error) {
+	var rsp teller.PublicStatusResponse
+	if err := c.do(http.MethodGet, "/api/public-status", nil, &rsp); err != nil {
+		return nil, err
+	}
+
+	return &rsp, nil
+}
+
+// Stats returns the current event stats, the first event of /api/stats/stream
+func (c *Client) Stats() (*teller.StatsResponse, error) {
+	const path = "/api/stats/stream"
+
+	resp, err := c.request(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: no stats event received: %v", path, err)
+		}
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var rsp teller.StatsResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &rsp); err != nil {
+			return nil, err
+		}
+
+		return &rsp, nil
+	}
+}
+
+// do makes a request, and decodes the JSON response into v
+func (c *Client) do(method, path string, body io.Reader, v interface{}) error {
+	resp, err := c.request(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// request makes a request, and returns an *Error if the response isn't a 200 OK.
+// A GET request is idempotent, so it is sent again after the Retry-After of a transient failure,
+// up to MaxAttempts times and while the total wait is within MaxRetryWait.
+func (c *Client) request(method, path string, body io.Reader) (*http.Response, error) {
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(method, path, body)
+		if err == nil {
+			return resp, nil
+		}
+
+		apiErr, ok := err.(*Error)
+		if !ok || !apiErr.Temporary() || method != http.MethodGet || attempt >= c.MaxAttempts {
+			return nil, err
+		}
+
+		wait := apiErr.RetryAfter
+		if wait <= 0 {
+			wait = defaultRetryAfter
+		}
+		if waited+wait > c.MaxRetryWait {
+			return nil, err
+		}
+
+		time.Sleep(wait)
+		waited += wait
+	}
+}
+
+// send makes a single request, and returns an *Error if the response isn't a 200 OK
+func (c *Client) send(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.Addr+path, body)
+	if err != nil {
+		return nil, err
+	}
+	// /api/status is an HTML page for browsers
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	msg, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	apiErr := &Error{
+		Method:     method,
+		Path:       strings.SplitN(path, "?", 2)[0],
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Code:       resp.Header.Get(ErrorCodeHeader),
+		Message:    strings.TrimSpace(string(msg)),
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+
+	return nil, apiErr
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/teller"
+)
+
+func TestClient(t *testing.T) {
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/bind", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var req map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req["coin_type"] != "BTC" {
+			w.Header().Set(ErrorCodeHeader, "depleted")
+			w.Header().Set("Retry-After", "300")
+			http.Error(w, "No deposit addresses left", http.StatusServiceUnavailable)
+			return
+		}
+
+		require.Equal(t, skyAddr, req["skyaddr"])
+		require.NoError(t, json.NewEncoder(w).Encode(teller.BindResponse{
+			DepositAddress: "1LEkderht5M5yWj82M87bEd4XDBsczLkp9",
+			CoinType:       "BTC",
+		}))
+	})
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "application/json", r.Header.Get("Accept"))
+		if r.URL.Query().Get("skyaddr") != skyAddr {
+			http.Error(w, "No bound deposit addresses", http.StatusNotFound)
+			return
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(teller.StatusResponse{
+			Statuses: []teller.DepositStatus{
+				{
+					DepositStatus: exchange.DepositStatus{
+						Seq:    1,
+						Status: "waiting_deposit",
+					},
+					ConfirmationsRequired: 1,
+				},
+			},
+		}))
+	})
+	mux.HandleFunc("/api/stats/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", `{"sky_sent":"100","participants":2}`)
+		w.(http.Flusher).Flush()
+		// The stream stays open
+		<-r.Context().Done()
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL + "/")
+
+	rsp, err := c.Bind(skyAddr, "BTC")
+	require.NoError(t, err)
+	require.Equal(t, "1LEkderht5M5yWj82M87bEd4XDBsczLkp9", rsp.DepositAddress)
+
+	_, err = c.Bind(skyAddr, "ETH")
+	require.Error(t, err)
+	apiErr, ok := err.(*Error)
+	require.True(t, ok)
+	require.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+	require.Equal(t, "depleted", apiErr.Code)
+	require.Equal(t, time.Second*300, apiErr.RetryAfter)
+	require.Equal(t, "No deposit addresses left", apiErr.Message)
+	require.True(t, apiErr.Temporary())
+	require.Equal(t, "POST /api/bind: 503 Service Unavailable (depleted): No deposit addresses left", err.Error())
+
+	statuses, err := c.Status(skyAddr)
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, "waiting_deposit", statuses[0].Status)
+	require.Equal(t, int64(1), statuses[0].ConfirmationsRequired)
+
+	_, err = c.Status("23jVqPuKNZuGJuXbb1aRT3fUiAGY3AQbmTQ")
+	apiErr, ok = err.(*Error)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	require.Equal(t, "/api/status", apiErr.Path)
+	require.False(t, apiErr.Temporary())
+
+	stats, err := c.Stats()
+	require.NoError(t, err)
+	require.Equal(t, "100", stats.SkySent)
+	require.Equal(t, 2, stats.Participants)
+}
+
+func TestClientRetry(t *testing.T) {
+	var versions, publicStatuses, binds int
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/version", func(w http.ResponseWriter, r *http.Request) {
+		versions++
+		if versions == 1 {
+			w.Header().Set(ErrorCodeHeader, "busy")
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Busy", http.StatusServiceUnavailable)
+			return
+		}
+
+		require.NoError(t, json.NewEncoder(w).Encode(teller.VersionResponse{
+			CoinTypes: []string{"BTC"},
+		}))
+	})
+	mux.HandleFunc("/api/public-status", func(w http.ResponseWriter, r *http.Request) {
+		publicStatuses++
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	})
+	mux.HandleFunc("/api/bind", func(w http.ResponseWriter, r *http.Request) {
+		binds++
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Busy", http.StatusServiceUnavailable)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL)
+
+	// The GET is retried after its Retry-After
+	start := time.Now()
+	rsp, err := c.Version()
+	require.NoError(t, err)
+	require.Equal(t, []string{"BTC"}, rsp.CoinTypes)
+	require.Equal(t, 2, versions)
+	require.True(t, time.Since(start) >= time.Second)
+
+	// A bind isn't idempotent, so it isn't retried
+	_, err = c.Bind("2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", "BTC")
+	require.Error(t, err)
+	require.Equal(t, 1, binds)
+
+	// The retries stop after MaxAttempts
+	c.MaxAttempts = 2
+	_, err = c.PublicStatus()
+	apiErr, ok := err.(*Error)
+	require.True(t, ok)
+	require.Equal(t, http.StatusTooManyRequests, apiErr.StatusCode)
+	require.Equal(t, 2, publicStatuses)
+
+	// A retry which would wait beyond MaxRetryWait isn't made
+	versions = 0
+	c.MaxAttempts = 3
+	c.MaxRetryWait = time.Millisecond * 500
+	start = time.Now()
+	_, err = c.Version()
+	apiErr, ok = err.(*Error)
+	require.True(t, ok)
+	require.Equal(t, "busy", apiErr.Code)
+	require.Equal(t, 1, versions)
+	require.True(t, time.Since(start) < time.Second)
+}
@@ -2,54 +2,204 @@
 package config
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"net/mail"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
 
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/util/droplet"
 	"github.com/skycoin/skycoin/src/visor"
 	"github.com/skycoin/skycoin/src/wallet"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/explorer"
 	"github.com/skycoin/teller/src/util/mathutil"
 )
 
 const (
 	defaultAdminPanelHost = "127.0.0.1:7711"
+
+	// EnvProduction is the config profile of a live teller, which refuses the test-only options
+	EnvProduction = "production"
+	// EnvStaging is the config profile of a staging teller
+	EnvStaging = "staging"
+	// EnvTest is the config profile of a test teller
+	EnvTest = "test"
+
+	// AutoTLSCacheDir stores Let's Encrypt certs in web.auto_tls_cache_dir
+	AutoTLSCacheDir = "dir"
+	// AutoTLSCacheDB stores Let's Encrypt certs in the teller database
+	AutoTLSCacheDB = "db"
+	// AutoTLSCacheObject stores Let's Encrypt certs in the object_storage bucket
+	AutoTLSCacheObject = "object"
+
+	// ReportStorageDir writes the reconciliation reports to reconcile.report_dir
+	ReportStorageDir = "dir"
+	// ReportStorageObject writes the reconciliation reports to the object_storage bucket
+	ReportStorageObject = "object"
+
+	// AnalyticsSinkNone disables analytics events
+	AnalyticsSinkNone = "none"
+	// AnalyticsSinkSegment sends analytics events to a Segment compatible HTTP API
+	AnalyticsSinkSegment = "segment"
+	// AnalyticsSinkFile appends analytics events to analytics.file
+	AnalyticsSinkFile = "file"
+
+	// EventsSinkNone disables publishing events
+	EventsSinkNone = "none"
+	// EventsSinkKafka produces events to a Kafka topic through a Kafka REST Proxy
+	EventsSinkKafka = "kafka"
+	// EventsSinkNATS publishes events to a NATS JetStream stream
+	EventsSinkNATS = "nats"
+
+	// FlagStatsStream gates /api/stats/stream
+	FlagStatsStream = "stats_stream"
+	// FlagStatusWait gates /api/status/wait
+	FlagStatusWait = "status_wait"
+	// FlagStatusWS gates /api/ws
+	FlagStatusWS = "status_ws"
+	// FlagBindQuote gates the rate and skycoin estimates of the bind response
+	FlagBindQuote = "bind_quote"
+	// FlagBindBTC gates binding BTC deposit addresses
+	FlagBindBTC = "bind_btc"
+	// FlagBindETH gates binding ETH deposit addresses
+	FlagBindETH = "bind_eth"
+
+	// LogFormatText logs human readable lines to stdout
+	LogFormatText = "text"
+	// LogFormatJSON logs a JSON object per line to stdout
+	LogFormatJSON = "json"
+
+	// ContainerEnvPrefix prefixes the environment variables which override config keys in --container mode,
+	// e.g. TELLER_WEB_HTTP_ADDR overrides web.http_addr
+	ContainerEnvPrefix = "TELLER"
 )
 
+// Envs are the config profiles which can be selected with -env
+var Envs = []string{EnvProduction, EnvStaging, EnvTest}
+
+// FeatureFlagDefaults is the state of each feature flag if feature_flags doesn't set it.
+// A risky new endpoint or behavior gets a flag which is off by default, so that it is rolled out
+// by turning it on in a profile or from the admin panel.
+var FeatureFlagDefaults = map[string]bool{
+	FlagStatsStream: true,
+	FlagStatusWait:  true,
+	FlagStatusWS:    false,
+	FlagBindQuote:   true,
+	FlagBindBTC:     true,
+	FlagBindETH:     true,
+}
+
 // Config represents the configuration root
 type Config struct {
+	// Config profile selected with -env, empty if only the base config file was loaded
+	Env string `mapstructure:"env"`
 	// Enable debug logging
 	Debug bool `mapstructure:"debug"`
 	// Run with gops profiler
 	Profile bool `mapstructure:"profile"`
 	// Where log is saved
 	LogFilename string `mapstructure:"logfile"`
+	// Format of the log on stdout, "text" or "json". The logfile is always text
+	LogFormat string `mapstructure:"log_format"`
+	// Redaction of the logged fields
+	LogRedact LogRedact `mapstructure:"log_redact"`
 	// Where database is saved, inside the ~/.teller-skycoin data directory
 	DBFilename string `mapstructure:"dbfile"`
+	// Compaction of the database
+	DBCompact DBCompact `mapstructure:"db_compact"`
+	// Backups of the database to the object_storage bucket
+	DBBackup DBBackup `mapstructure:"db_backup"`
+	// Bucket which the autocert cache, db backups and reports can be stored in, instead of on disk
+	ObjectStorage ObjectStorage `mapstructure:"object_storage"`
+	// Pausing of time-sensitive operations while the system clock is skewed
+	ClockSkew ClockSkew `mapstructure:"clock_skew"`
+	// Requests to third party services, such as the metrics gateway, the captcha service and the explorers
+	HTTPClient HTTPClient `mapstructure:"http_client"`
 
 	// Path of BTC addresses JSON file
 	BtcAddresses string `mapstructure:"btc_addresses"`
 	// Path of ETH addresses JSON file
 	EthAddresses string `mapstructure:"eth_addresses"`
+	// Forecast of when the deposit address pools run out
+	AddressForecast AddressForecast `mapstructure:"address_forecast"`
+	// Sharding of the deposit address pools across teller instances which don't share a db
+	AddressShard AddressShard `mapstructure:"address_shard"`
 
 	Teller Teller `mapstructure:"teller"`
 
 	SkyRPC SkyRPC `mapstructure:"sky_rpc"`
+	// The coin paid out, SKY or a fiber chain's coin
+	Payout Payout `mapstructure:"payout"`
 	BtcRPC BtcRPC `mapstructure:"btc_rpc"`
 	EthRPC EthRPC `mapstructure:"eth_rpc"`
+	// Coin types added by scanner plugins, besides BTC and ETH
+	PluginCoins []PluginCoin `mapstructure:"plugin_coins"`
+
+	// Operator branding of /api/config, the status pages, the receipts and the emails
+	Branding Branding `mapstructure:"branding"`
 
 	BtcScanner   BtcScanner   `mapstructure:"btc_scanner"`
 	EthScanner   EthScanner   `mapstructure:"eth_scanner"`
 	SkyExchanger SkyExchanger `mapstructure:"sky_exchanger"`
 
+	// Explorer links of the transactions and addresses in statuses and emails
+	Explorer Explorer `mapstructure:"explorer"`
+
 	Web Web `mapstructure:"web"`
 
+	Widget Widget `mapstructure:"widget"`
+
+	// Signed links of the bindings, to resume the bind flow on another device
+	DeepLinks DeepLinks `mapstructure:"deep_links"`
+
+	Abuse Abuse `mapstructure:"abuse"`
+
+	Capture Capture `mapstructure:"capture"`
+
 	AdminPanel AdminPanel `mapstructure:"admin_panel"`
 
+	Analytics Analytics `mapstructure:"analytics"`
+
+	// Publishing of the deposit pipeline's events to a message queue
+	Events Events `mapstructure:"events"`
+
+	MetricsPush MetricsPush `mapstructure:"metrics_push"`
+
+	Reconcile Reconcile `mapstructure:"reconcile"`
+
+	Email Email `mapstructure:"email"`
+
+	// Receipts of the completed deposits, linked to in the statuses and payout emails
+	Receipts Receipts `mapstructure:"receipts"`
+
+	// Automated responses to alert conditions, e.g. switching to a fallback node when the node is down
+	Runbook Runbook `mapstructure:"runbook"`
+
+	Stats Stats `mapstructure:"stats"`
+
+	// Feature flags by name, overriding FeatureFlagDefaults
+	FeatureFlags map[string]bool `mapstructure:"feature_flags"`
+
+	PayoutLog PayoutLog `mapstructure:"payout_log"`
+
+	AuditLog AuditLog `mapstructure:"audit_log"`
+
+	Archive Archive `mapstructure:"archive"`
+
+	Replication Replication `mapstructure:"replication"`
+
 	Dummy Dummy `mapstructure:"dummy"`
 }
 
@@ -57,11 +207,206 @@ type Config struct {
 type Teller struct {
 	// Max number of btc addresses a skycoin address can bind
 	MaxBoundAddresses int `mapstructure:"max_bound_addrs"`
+	// Max number of bind requests waiting for a deposit address, per coin type
+	BindQueueSize int `mapstructure:"bind_queue_size"`
+	// Max time a bind request waits for a deposit address
+	BindMaxWait time.Duration `mapstructure:"bind_max_wait"`
+	// When binding opens to everyone, RFC3339. Binding is always open if unset.
+	StartAt string `mapstructure:"start_at"`
+	// Skycoin addresses which can bind before StartAt
+	Allowlist []string `mapstructure:"allowlist"`
+	// API keys which can bind any skycoin address before StartAt, sent in the X-Api-Key header
+	AllowlistAPIKeys []string `mapstructure:"allowlist_api_keys"`
+	// What to do with the deposit address of a cancelled binding, CancelPolicyRetire or CancelPolicyReuse
+	CancelPolicy string `mapstructure:"cancel_policy"`
+}
+
+const (
+	// CancelPolicyRetire keeps the deposit address of a cancelled binding out of the address pool
+	CancelPolicyRetire = "retire"
+	// CancelPolicyReuse returns the deposit address of a cancelled binding to the address pool
+	CancelPolicyReuse = "reuse"
+)
+
+// StartTime returns the parsed StartAt, or the zero time if unset
+func (c Teller) StartTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, c.StartAt) // nolint: errcheck
+	return t
+}
+
+// Validate validates Teller config
+func (c Teller) Validate() error {
+	if c.BindQueueSize <= 0 {
+		return errors.New("teller.bind_queue_size must be > 0")
+	}
+
+	if c.BindMaxWait <= 0 {
+		return errors.New("teller.bind_max_wait must be > 0")
+	}
+
+	switch c.CancelPolicy {
+	case CancelPolicyRetire, CancelPolicyReuse:
+	default:
+		return fmt.Errorf("teller.cancel_policy must be %q or %q", CancelPolicyRetire, CancelPolicyReuse)
+	}
+
+	if c.StartAt == "" {
+		if len(c.Allowlist) != 0 || len(c.AllowlistAPIKeys) != 0 {
+			return errors.New("teller.start_at must be set when teller.allowlist or teller.allowlist_api_keys is set")
+		}
+		return nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, c.StartAt); err != nil {
+		return fmt.Errorf("teller.start_at is invalid: %v", err)
+	}
+
+	for _, addr := range c.Allowlist {
+		if _, err := cipher.DecodeBase58Address(addr); err != nil {
+			return fmt.Errorf("teller.allowlist address %q is invalid: %v", addr, err)
+		}
+	}
+
+	for _, key := range c.AllowlistAPIKeys {
+		if key == "" {
+			return errors.New("teller.allowlist_api_keys can't contain an empty key")
+		}
+	}
+
+	return nil
 }
 
 // SkyRPC config for Skycoin daemon node RPC
 type SkyRPC struct {
 	Address string `mapstructure:"address"`
+	// Node which the runbook's fallback_node action switches to while the node is down
+	FallbackAddress string `mapstructure:"fallback_address"`
+	// Reconnection to the skycoin node
+	Reconnect Reconnect `mapstructure:"reconnect"`
+}
+
+// Payout config for the coin paid out. A fiber chain's coin is paid out from a wallet of the chain,
+// sent by the chain's node. sky_rpc.address and sky_exchanger.wallet are then that node and wallet.
+type Payout struct {
+	// Ticker of the coin paid out, e.g. "SKY"
+	Coin string `mapstructure:"coin"`
+	// Name of the fiber chain the coin is sent on, e.g. "skycoin"
+	Chain string `mapstructure:"chain"`
+	// Hex encoded hash of the chain's genesis block. If set, the payout node must be on this chain.
+	GenesisHash string `mapstructure:"genesis_hash"`
+}
+
+// Explorer config of the explorer links of each coin type
+type Explorer struct {
+	BTC ExplorerCoin `mapstructure:"btc"`
+	ETH ExplorerCoin `mapstructure:"eth"`
+	// Explorer of the payout chain. Defaults to the preset named after payout.chain, if there is one.
+	Payout ExplorerCoin `mapstructure:"payout"`
+}
+
+// ExplorerCoin config of a coin type's explorer
+type ExplorerCoin struct {
+	// Named explorer, e.g. "blockstream", or ExplorerNone for no links
+	Preset string `mapstructure:"preset"`
+	// URL of a transaction with a {txid} placeholder, overrides the preset's
+	TxURL string `mapstructure:"tx_url"`
+	// URL of an address with an {address} placeholder, overrides the preset's
+	AddressURL string `mapstructure:"address_url"`
+}
+
+// ExplorerNone is the preset of a coin type without explorer links
+const ExplorerNone = "none"
+
+// coin returns the explorer of the preset, with its URLs overridden
+func (c ExplorerCoin) coin() (explorer.Coin, error) {
+	var coin explorer.Coin
+	if c.Preset != "" && c.Preset != ExplorerNone {
+		var err error
+		coin, err = explorer.Preset(c.Preset)
+		if err != nil {
+			return explorer.Coin{}, err
+		}
+	}
+
+	if c.TxURL != "" {
+		coin.TxURL = c.TxURL
+	}
+	if c.AddressURL != "" {
+		coin.AddressURL = c.AddressURL
+	}
+
+	return coin, coin.Validate()
+}
+
+// payout returns the explorer config of the payout chain, with the default preset of the chain
+func (c Explorer) payout(p Payout) ExplorerCoin {
+	e := c.Payout
+	if e.Preset != "" {
+		return e
+	}
+
+	for _, name := range explorer.Presets() {
+		if name == p.Chain {
+			e.Preset = name
+		}
+	}
+
+	return e
+}
+
+// Validate validates Explorer config
+func (c Explorer) Validate(p Payout) error {
+	for name, e := range map[string]ExplorerCoin{
+		"btc":    c.BTC,
+		"eth":    c.ETH,
+		"payout": c.payout(p),
+	} {
+		if _, err := e.coin(); err != nil {
+			return fmt.Errorf("explorer.%s is invalid: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// Links returns the explorers of the coin types and of the payout chain p
+func (c Explorer) Links(p Payout) explorer.Links {
+	// The config is validated, so the errors are ignored
+	btc, _ := c.BTC.coin()          // nolint: errcheck
+	eth, _ := c.ETH.coin()          // nolint: errcheck
+	payout, _ := c.payout(p).coin() // nolint: errcheck
+
+	return explorer.Links{
+		Deposits: map[string]explorer.Coin{
+			deposits.CoinTypeBTC: btc,
+			deposits.CoinTypeETH: eth,
+		},
+		Payout: payout,
+	}
+}
+
+// Validate validates Payout config
+func (c Payout) Validate() error {
+	if c.Coin == "" || len(c.Coin) > 10 {
+		return errors.New("payout.coin must be 1 to 10 characters")
+	}
+	for _, r := range c.Coin {
+		if !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+			return errors.New("payout.coin must be uppercase letters and digits, e.g. \"SKY\"")
+		}
+	}
+
+	if c.Chain == "" {
+		return errors.New("payout.chain missing")
+	}
+
+	if c.GenesisHash != "" {
+		if b, err := hex.DecodeString(c.GenesisHash); err != nil || len(b) != 32 {
+			return errors.New("payout.genesis_hash must be a hex encoded 32 byte hash")
+		}
+	}
+
+	return nil
 }
 
 // BtcRPC config for btcrpc
@@ -71,6 +416,36 @@ type BtcRPC struct {
 	Pass    string `mapstructure:"pass"`
 	Cert    string `mapstructure:"cert"`
 	Enabled bool   `mapstructure:"enabled"`
+	// Check that the unused deposit addresses have no transactions when loading the address pool.
+	// Requires btcd's --addrindex.
+	CheckAddressHistory bool `mapstructure:"check_address_history"`
+	// Additional btcd nodes. Blocks and their transactions are only scanned once a quorum of
+	// all the nodes, including server, agree on them.
+	Nodes []BtcNode `mapstructure:"nodes"`
+	// Number of nodes which must agree. Defaults to a majority of the nodes.
+	Quorum int `mapstructure:"quorum"`
+	// Scan the blocks served by a plugin instead of btcd
+	Plugin ScannerPlugin `mapstructure:"plugin"`
+	// Reconnection to server and the additional nodes
+	Reconnect Reconnect `mapstructure:"reconnect"`
+	// btcd which the runbook's fallback_node action switches server to while it is down, with the same user, pass and cert
+	FallbackServer string `mapstructure:"fallback_server"`
+}
+
+// BtcNode config for an additional btcd node
+type BtcNode struct {
+	Server string `mapstructure:"server"`
+	User   string `mapstructure:"user"`
+	Pass   string `mapstructure:"pass"`
+	Cert   string `mapstructure:"cert"`
+}
+
+// QuorumSize returns the number of btcd nodes which must agree on a block
+func (c BtcRPC) QuorumSize() int {
+	if c.Quorum > 0 {
+		return c.Quorum
+	}
+	return (len(c.Nodes)+1)/2 + 1
 }
 
 // EthRPC config for ethrpc
@@ -78,86 +453,2491 @@ type EthRPC struct {
 	Server  string `mapstructure:"server"`
 	Port    string `mapstructure:"port"`
 	Enabled bool   `mapstructure:"enabled"`
+	// Check that the unused deposit addresses have no transactions or balance when loading the address pool
+	CheckAddressHistory bool `mapstructure:"check_address_history"`
+	// Scan the blocks served by a plugin instead of geth
+	Plugin ScannerPlugin `mapstructure:"plugin"`
+}
+
+// ScannerPlugin config for a scanner plugin, a sidecar process serving a coin type's blocks over stdio.
+// See scanner.PluginClient for the protocol.
+type ScannerPlugin struct {
+	// The plugin's executable and its arguments. The plugin is disabled if it is empty.
+	Command []string `mapstructure:"command"`
+	// How long to wait for a response from the plugin, after which it is killed and started again
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+}
+
+// Enabled returns true if the plugin has a command
+func (c ScannerPlugin) Enabled() bool {
+	return len(c.Command) != 0
+}
+
+// Validate returns an error if the plugin config is invalid.
+// Errors are relative to the plugin's section.
+func (c ScannerPlugin) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	var p problems
+	if c.Command[0] == "" {
+		p.add("command is missing the executable")
+	}
+
+	if c.RequestTimeout < 0 {
+		p.add("request_timeout can't be negative")
+	}
+
+	return p.err()
+}
+
+// PluginCoin config for a coin type added by a scanner plugin. The plugin serves the coin type's blocks
+// and checks the addresses of its deposit address pool. The deposits are converted at a fixed rate.
+type PluginCoin struct {
+	// Ticker of the coin type, e.g. "LTC"
+	CoinType string `mapstructure:"coin_type"`
+	// Number of decimal places of the plugin's output values in one whole coin, e.g. 8 if they are satoshis
+	Decimals int32 `mapstructure:"decimals"`
+	// Path of the deposit addresses JSON file, {"addresses": [...]}
+	Addresses string `mapstructure:"addresses"`
+	// SKY/coin exchange rate. Can be an int, float or rational fraction string
+	SkyExchangeRate string `mapstructure:"sky_exchange_rate"`
+	// How often to ask the plugin for a new block
+	ScanPeriod time.Duration `mapstructure:"scan_period"`
+	// Height of the first block scanned
+	InitialScanHeight     int64 `mapstructure:"initial_scan_height"`
+	ConfirmationsRequired int64 `mapstructure:"confirmations_required"`
+	// The plugin serving the coin type, which must answer validate_address
+	Plugin ScannerPlugin `mapstructure:"plugin"`
+}
+
+// Validate returns an error if the plugin coin config is invalid.
+// Errors are relative to the plugin coin's section.
+func (c PluginCoin) Validate() error {
+	var p problems
+
+	if c.CoinType == "" || len(c.CoinType) > 10 {
+		p.add("coin_type must be 1 to 10 characters")
+	}
+	for _, r := range c.CoinType {
+		if !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+			p.add("coin_type must be uppercase letters and digits, e.g. \"LTC\"")
+			break
+		}
+	}
+
+	if c.Decimals < 0 || c.Decimals > deposits.MaxDecimals {
+		p.addf("decimals must be 0 to %d", deposits.MaxDecimals)
+	}
+
+	if r, err := mathutil.DecimalFromString(c.SkyExchangeRate); err != nil {
+		p.addf("sky_exchange_rate invalid: %v", err)
+	} else if r.Sign() <= 0 {
+		p.add("sky_exchange_rate must be greater than zero")
+	}
+
+	if c.ScanPeriod < 0 {
+		p.add("scan_period can't be negative")
+	}
+	if c.InitialScanHeight < 0 {
+		p.add("initial_scan_height must be >= 0")
+	}
+	if c.ConfirmationsRequired < 0 {
+		p.add("confirmations_required must be >= 0")
+	}
+
+	if !c.Plugin.Enabled() {
+		p.add("plugin.command missing")
+	}
+	p.merge("plugin.", c.Plugin.Validate())
+
+	return p.err()
+}
+
+// Reconnect config of the reconnection to a node. A request which fails to connect or times out drops the
+// connection, and the node is reconnected to after a backoff, resolving its host again.
+type Reconnect struct {
+	// How long to wait for the node's host to resolve and for the connection
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
+	// How long to wait for a response, after which the connection is dropped
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
+	// Wait after the first consecutive failure, doubled after each failure up to max_backoff
+	MinBackoff time.Duration `mapstructure:"min_backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+	// Number of consecutive failures after which an alert is logged
+	AlertAfter int `mapstructure:"alert_after"`
+}
+
+// Validate returns an error if the reconnect config is invalid.
+// Errors are relative to the reconnect section.
+func (c Reconnect) Validate() error {
+	var p problems
+	if c.ConnectTimeout < 0 {
+		p.add("connect_timeout can't be negative")
+	}
+	if c.RequestTimeout < 0 {
+		p.add("request_timeout can't be negative")
+	}
+	if c.MinBackoff < 0 {
+		p.add("min_backoff can't be negative")
+	}
+	if c.MaxBackoff < 0 {
+		p.add("max_backoff can't be negative")
+	}
+	if c.MaxBackoff != 0 && c.MinBackoff > c.MaxBackoff {
+		p.add("min_backoff can't be larger than max_backoff")
+	}
+	if c.AlertAfter < 0 {
+		p.add("alert_after can't be negative")
+	}
+	return p.err()
+}
+
+// BtcScanner config for BTC scanner
+type BtcScanner struct {
+	// How often to try to scan for blocks
+	ScanPeriod            time.Duration `mapstructure:"scan_period"`
+	InitialScanHeight     int64         `mapstructure:"initial_scan_height"`
+	ConfirmationsRequired int64         `mapstructure:"confirmations_required"`
+	// Only fetch the transactions of each block which pay to a deposit address,
+	// using btcd's websocket transaction filter
+	TxFilter bool `mapstructure:"tx_filter"`
+	// Stop confirming deposits while btcd lags the network
+	Lag ScannerLag `mapstructure:"lag"`
+	// Adapt how often to poll btcd for a new block
+	Poll ScannerPoll `mapstructure:"poll"`
+	// When a confirmed deposit can't be reversed, and is sent to the exchange
+	Finality ScannerFinality `mapstructure:"finality"`
+}
+
+// EthScanner config for ETH scanner
+type EthScanner struct {
+	// How often to try to scan for blocks
+	ScanPeriod            time.Duration `mapstructure:"scan_period"`
+	InitialScanHeight     int64         `mapstructure:"initial_scan_height"`
+	ConfirmationsRequired int64         `mapstructure:"confirmations_required"`
+	// Stop confirming deposits while geth lags the network
+	Lag ScannerLag `mapstructure:"lag"`
+	// Adapt how often to poll geth for a new block
+	Poll ScannerPoll `mapstructure:"poll"`
+	// When a confirmed deposit can't be reversed, and is sent to the exchange
+	Finality ScannerFinality `mapstructure:"finality"`
+}
+
+// ScannerLag config for pausing a scanner whose node lags the network tip
+type ScannerLag struct {
+	// URLs returning the network's best block height, e.g. block explorer APIs
+	TipURLs []string `mapstructure:"tip_urls"`
+	// Max blocks the node may be behind the median tip of the tip_urls. 0 disables the check
+	MaxLag int64 `mapstructure:"max_lag"`
+	// How often to query the tip_urls
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+}
+
+// Validate returns an error if the scanner lag config is invalid.
+// Errors are relative to the scanner's lag section.
+func (c ScannerLag) Validate() error {
+	if c.MaxLag < 0 {
+		return errors.New("max_lag can't be negative")
+	}
+
+	if c.MaxLag == 0 {
+		return nil
+	}
+
+	var p problems
+	if len(c.TipURLs) == 0 {
+		p.add("tip_urls missing")
+	}
+
+	for _, u := range c.TipURLs {
+		pu, err := url.Parse(u)
+		if err != nil {
+			p.addf("tip_urls invalid: %v", err)
+		} else if pu.Scheme != "http" && pu.Scheme != "https" {
+			p.addf("tip_urls invalid: %q is not an http or https URL", u)
+		}
+	}
+
+	if c.CheckInterval <= 0 {
+		p.add("check_interval must be > 0")
+	}
+
+	return p.err()
+}
+
+// ScannerPoll config for polling a scanner's node faster when a new block is expected, and backing off when it isn't
+type ScannerPoll struct {
+	// Shortest wait between polls. 0 disables adaptive polling, and the node is polled every scan_period
+	MinPeriod time.Duration `mapstructure:"min_period"`
+	// Longest wait between polls, scan_period if not set
+	MaxPeriod time.Duration `mapstructure:"max_period"`
+	// How long to poll every min_period after a deposit address is bound
+	BindBoost time.Duration `mapstructure:"bind_boost"`
+}
+
+// Validate returns an error if the scanner poll config is invalid.
+// Errors are relative to the scanner's poll section. scanPeriod is the scanner's scan_period, the max_period if it isn't set.
+func (c ScannerPoll) Validate(scanPeriod time.Duration) error {
+	if c.MinPeriod < 0 || c.MaxPeriod < 0 || c.BindBoost < 0 {
+		return errors.New("min_period, max_period and bind_boost can't be negative")
+	}
+
+	if c.MinPeriod == 0 {
+		return nil
+	}
+
+	if c.MaxPeriod != 0 && c.MaxPeriod < c.MinPeriod {
+		return errors.New("max_period must be >= min_period")
+	}
+
+	if c.MaxPeriod == 0 && scanPeriod > 0 && scanPeriod < c.MinPeriod {
+		return errors.New("min_period must be <= the scanner's scan_period when max_period isn't set")
+	}
+
+	return nil
+}
+
+const (
+	// FinalityConfirmations makes a deposit final once it has confirmations_required
+	FinalityConfirmations = "confirmations"
+	// FinalityCheckpoint makes a deposit final once the checkpoint service checkpoints its block
+	FinalityCheckpoint = "checkpoint"
+	// FinalityHybrid makes a deposit final once it has confirmations_required and its block
+	// stayed in the chain for the settle_window
+	FinalityHybrid = "hybrid"
+)
+
+// ScannerFinality config for deciding when a scanner's deposits are final
+type ScannerFinality struct {
+	// "confirmations", "checkpoint" or "hybrid"
+	Policy string `mapstructure:"policy"`
+	// URL of the checkpoint service, returning the latest checkpoint as {"height": 500000, "hash": "..."}
+	CheckpointURL string `mapstructure:"checkpoint_url"`
+	// How often to query the checkpoint_url
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+	// How long a block must stay in the chain after it has confirmations_required, for the hybrid policy
+	SettleWindow time.Duration `mapstructure:"settle_window"`
+}
+
+// Validate returns an error if the scanner finality config is invalid.
+// Errors are relative to the scanner's finality section.
+func (c ScannerFinality) Validate() error {
+	var p problems
+	switch c.Policy {
+	case FinalityConfirmations:
+	case FinalityCheckpoint:
+		pu, err := url.Parse(c.CheckpointURL)
+		if err != nil {
+			p.addf("checkpoint_url invalid: %v", err)
+		} else if pu.Scheme != "http" && pu.Scheme != "https" {
+			p.addf("checkpoint_url invalid: %q is not an http or https URL", c.CheckpointURL)
+		}
+		if c.CheckInterval <= 0 {
+			p.add("check_interval must be > 0")
+		}
+	case FinalityHybrid:
+		if c.SettleWindow <= 0 {
+			p.add("settle_window must be > 0")
+		}
+	default:
+		p.addf("policy must be %q, %q or %q", FinalityConfirmations, FinalityCheckpoint, FinalityHybrid)
+	}
+
+	return p.err()
+}
+
+// SkyExchanger config for skycoin sender
+type SkyExchanger struct {
+	// SKY/BTC exchange rate. Can be an int, float or rational fraction string
+	SkyBtcExchangeRate string `mapstructure:"sky_btc_exchange_rate"`
+	SkyEthExchangeRate string `mapstructure:"sky_eth_exchange_rate"`
+	// Number of decimal places to truncate SKY to
+	MaxDecimals int `mapstructure:"max_decimals"`
+	// How long to wait before rechecking transaction confirmations
+	TxConfirmationCheckWait time.Duration `mapstructure:"tx_confirmation_check_wait"`
+	// How often to reconcile the ledger with the deposit records
+	LedgerCheckInterval time.Duration `mapstructure:"ledger_check_interval"`
+	// Path of hot Skycoin wallet file on disk
+	Wallet string `mapstructure:"wallet"`
+	// Deposits converted at a suspicious rate are held for review
+	RateGuard RateGuard `mapstructure:"rate_guard"`
+	// Price feeds which the rates are taken from, instead of the configured rates
+	RateFeeds RateFeeds `mapstructure:"rate_feeds"`
+	// Sale price of SKY in a quote currency, which the rates are converted from instead
+	Quote Quote `mapstructure:"quote"`
+	// Currency the deposits are valued in for the statuses and reports, at the price when they were received
+	Display Display `mapstructure:"display"`
+	// Deposits over the campaign's hard cap are refunded
+	CampaignCap CampaignCap `mapstructure:"campaign_cap"`
+	// Deposits below the dust thresholds are ignored, instead of being converted
+	Dust Dust `mapstructure:"dust"`
+	// Throttles the sends, so that a backlog of deposits doesn't trip the skycoin node's limits
+	SendThrottle SendThrottle `mapstructure:"send_throttle"`
+	// Delays the sends which the hot wallet lacks the coin hours of, and alerts while it lacks them
+	CoinHours CoinHours `mapstructure:"coin_hours"`
+	// Operator validators which hold or reject the deposits before they are credited
+	DepositValidation DepositValidation `mapstructure:"deposit_validation"`
+	// Runs the deposit exports and the ledger report against a snapshot of the db, so that they don't stall the writes
+	ReportSnapshots ReportSnapshots `mapstructure:"report_snapshots"`
+	// Max skycoin addresses whose deposit statuses are cached. 0 disables the cache
+	StatusCacheSize int `mapstructure:"status_cache_size"`
+	// Flag the deposits paid out to skycoin addresses which never received coins, which may be mistyped
+	CheckFirstUse bool `mapstructure:"check_first_use"`
+}
+
+// RateGuard config for holding deposits converted at a suspicious rate
+type RateGuard struct {
+	// Reference SKY/BTC and SKY/ETH rates. Empty disables the deviation check for that coin
+	ReferenceBtcRate string `mapstructure:"reference_btc_rate"`
+	ReferenceEthRate string `mapstructure:"reference_eth_rate"`
+	// Max percent the rate may deviate from its reference rate. 0 disables the check
+	MaxDeviation float64 `mapstructure:"max_deviation"`
+	// Max percent the rate may move within a minute. 0 disables the check
+	MaxChangePerMinute float64 `mapstructure:"max_change_per_minute"`
+}
+
+// Validate returns an error if the rate guard config is invalid
+func (c RateGuard) Validate() error {
+	var p problems
+	if c.MaxDeviation < 0 {
+		p.add("sky_exchanger.rate_guard.max_deviation can't be negative")
+	}
+
+	if c.MaxChangePerMinute < 0 {
+		p.add("sky_exchanger.rate_guard.max_change_per_minute can't be negative")
+	}
+
+	if c.ReferenceBtcRate != "" {
+		if _, err := mathutil.DecimalFromString(c.ReferenceBtcRate); err != nil {
+			p.addf("sky_exchanger.rate_guard.reference_btc_rate invalid: %v", err)
+		}
+	}
+
+	if c.ReferenceEthRate != "" {
+		if _, err := mathutil.DecimalFromString(c.ReferenceEthRate); err != nil {
+			p.addf("sky_exchanger.rate_guard.reference_eth_rate invalid: %v", err)
+		}
+	}
+
+	return p.err()
+}
+
+// RateFeeds config for taking the rates from a quorum of price feeds
+type RateFeeds struct {
+	Feeds []RateFeed `mapstructure:"feeds"`
+	// Number of a coin type's feeds which must agree. Defaults to a majority of the coin type's feeds
+	Quorum int `mapstructure:"quorum"`
+	// Max percent a quote may deviate from the median quote before it is rejected. 0 rejects no quotes
+	MaxDeviation float64 `mapstructure:"max_deviation"`
+	// How long the last agreed rate is used while the feeds don't agree, before falling back to the configured rate
+	MaxStaleness time.Duration `mapstructure:"max_staleness"`
+	// How often to poll the feeds
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// RateFeed config for a price feed
+type RateFeed struct {
+	Name     string `mapstructure:"name"`
+	CoinType string `mapstructure:"coin_type"`
+	URL      string `mapstructure:"url"`
+	// Dot separated path of the quote in a JSON response. Empty if the response is a plain number
+	Field string `mapstructure:"field"`
+	// Set if the feed quotes the coin per SKY, instead of SKY per coin.
+	// For a quote currency feed, set if it quotes the coin per unit of the currency
+	Invert bool `mapstructure:"invert"`
+}
+
+// Validate returns an error if the rate feeds config is invalid
+func (c RateFeeds) Validate() error {
+	return c.validate("sky_exchanger.rate_feeds", deposits.CoinTypeBTC, deposits.CoinTypeETH)
+}
+
+// validate returns an error if the feeds config under key is invalid, or has feeds of coin types other than coinTypes
+func (c RateFeeds) validate(key string, coinTypes ...string) error {
+	var p problems
+	if c.Quorum < 0 {
+		p.addf("%s.quorum can't be negative", key)
+	}
+
+	if c.MaxDeviation < 0 {
+		p.addf("%s.max_deviation can't be negative", key)
+	}
+
+	if len(c.Feeds) != 0 {
+		if c.MaxStaleness <= 0 {
+			p.addf("%s.max_staleness must be > 0", key)
+		}
+		if c.PollInterval <= 0 {
+			p.addf("%s.poll_interval must be > 0", key)
+		}
+	}
+
+	quoted := make([]string, len(coinTypes))
+	for i, coinType := range coinTypes {
+		quoted[i] = fmt.Sprintf("%q", coinType)
+	}
+	allowed := strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+
+	names := make(map[string]struct{}, len(c.Feeds))
+	counts := make(map[string]int)
+	for i, f := range c.Feeds {
+		if f.Name == "" {
+			p.addf("%s.feeds[%d].name missing", key, i)
+		} else if _, ok := names[f.Name]; ok {
+			p.addf("%s.feeds[%d].name %q is duplicated", key, i, f.Name)
+		}
+		names[f.Name] = struct{}{}
+
+		supported := false
+		for _, coinType := range coinTypes {
+			if f.CoinType == coinType {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			p.addf("%s.feeds[%d].coin_type must be %s", key, i, allowed)
+		} else {
+			counts[f.CoinType]++
+		}
+
+		u, err := url.Parse(f.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			p.addf("%s.feeds[%d].url must be an absolute http or https URL", key, i)
+		}
+	}
+
+	for _, coinType := range coinTypes {
+		if n, ok := counts[coinType]; ok && c.Quorum > n {
+			p.addf("%s.quorum is more than the %d %s feeds", key, n, coinType)
+		}
+	}
+
+	return p.err()
 }
 
-// BtcScanner config for BTC scanner
-type BtcScanner struct {
-	// How often to try to scan for blocks
-	ScanPeriod            time.Duration `mapstructure:"scan_period"`
-	InitialScanHeight     int64         `mapstructure:"initial_scan_height"`
-	ConfirmationsRequired int64         `mapstructure:"confirmations_required"`
+// Quote config for selling SKY at a price in a quote currency, converted through the price feeds of
+// the coins and of SKY in that currency. The rates are converted from the prices while they are available.
+type Quote struct {
+	// Quote currency, e.g. USD. Empty disables the quote currency
+	Currency string `mapstructure:"currency"`
+	// Price of SKY in the quote currency, decimal string. Can be empty if there are SKY feeds
+	SkyPrice string `mapstructure:"sky_price"`
+	// Feeds quoting the price of BTC, ETH or SKY in the quote currency
+	Feeds []RateFeed `mapstructure:"feeds"`
+	// Number of a coin type's feeds which must agree. Defaults to a majority of the coin type's feeds
+	Quorum int `mapstructure:"quorum"`
+	// Max percent a quote may deviate from the median quote before it is rejected. 0 rejects no quotes
+	MaxDeviation float64 `mapstructure:"max_deviation"`
+	// How long the last agreed price is used while the feeds don't agree, before it is unavailable
+	MaxStaleness time.Duration `mapstructure:"max_staleness"`
+	// How often to poll the feeds
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// RateFeeds returns the feeds config of the quote currency's price feeds
+func (c Quote) RateFeeds() RateFeeds {
+	return RateFeeds{
+		Feeds:        c.Feeds,
+		Quorum:       c.Quorum,
+		MaxDeviation: c.MaxDeviation,
+		MaxStaleness: c.MaxStaleness,
+		PollInterval: c.PollInterval,
+	}
+}
+
+// Display config for valuing the deposits in a display currency, e.g. USD, through the price feeds of the coins
+// in that currency, or the quote currency's prices if it is the quote currency
+type Display struct {
+	// Display currency, e.g. USD. Empty disables the display values
+	Currency string `mapstructure:"currency"`
+	// Decimals the values are rounded to
+	Decimals int `mapstructure:"decimals"`
+	// Feeds quoting the price of BTC or ETH in the display currency. Can be empty if it is the quote currency
+	Feeds []RateFeed `mapstructure:"feeds"`
+	// Number of a coin type's feeds which must agree. Defaults to a majority of the coin type's feeds
+	Quorum int `mapstructure:"quorum"`
+	// Max percent a quote may deviate from the median quote before it is rejected. 0 rejects no quotes
+	MaxDeviation float64 `mapstructure:"max_deviation"`
+	// How long the last agreed price is used while the feeds don't agree, before it is unavailable
+	MaxStaleness time.Duration `mapstructure:"max_staleness"`
+	// How often to poll the feeds
+	PollInterval time.Duration `mapstructure:"poll_interval"`
+}
+
+// RateFeeds returns the feeds config of the display currency's price feeds
+func (c Display) RateFeeds() RateFeeds {
+	return RateFeeds{
+		Feeds:        c.Feeds,
+		Quorum:       c.Quorum,
+		MaxDeviation: c.MaxDeviation,
+		MaxStaleness: c.MaxStaleness,
+		PollInterval: c.PollInterval,
+	}
+}
+
+// Validate returns an error if the display currency config is invalid
+func (c Display) Validate(quote Quote) error {
+	if c.Currency == "" {
+		if len(c.Feeds) != 0 {
+			return errors.New("sky_exchanger.display.currency is required for sky_exchanger.display.feeds")
+		}
+		return nil
+	}
+
+	var p problems
+	if c.Decimals < 0 {
+		p.add("sky_exchanger.display.decimals can't be negative")
+	}
+
+	p.merge("", c.RateFeeds().validate("sky_exchanger.display", deposits.CoinTypeBTC, deposits.CoinTypeETH))
+
+	if len(c.Feeds) == 0 && c.Currency != quote.Currency {
+		p.add("sky_exchanger.display.feeds needs a BTC or ETH feed, unless the display currency is sky_exchanger.quote.currency")
+	}
+
+	return p.err()
+}
+
+// Validate returns an error if the quote currency config is invalid
+func (c Quote) Validate() error {
+	if c.Currency == "" {
+		if c.SkyPrice != "" || len(c.Feeds) != 0 {
+			return errors.New("sky_exchanger.quote.currency is required for sky_exchanger.quote.sky_price or feeds")
+		}
+		return nil
+	}
+
+	var p problems
+	if c.SkyPrice != "" {
+		if _, err := mathutil.DecimalFromString(c.SkyPrice); err != nil {
+			p.addf("sky_exchanger.quote.sky_price invalid: %v", err)
+		}
+	}
+
+	p.merge("", c.RateFeeds().validate("sky_exchanger.quote", deposits.CoinTypeBTC, deposits.CoinTypeETH, "SKY"))
+
+	var hasSky, hasCoin bool
+	for _, f := range c.Feeds {
+		if f.CoinType == "SKY" {
+			hasSky = true
+		} else {
+			hasCoin = true
+		}
+	}
+
+	if !hasCoin {
+		p.add("sky_exchanger.quote.feeds needs a BTC or ETH feed")
+	}
+
+	if !hasSky && c.SkyPrice == "" {
+		p.add("sky_exchanger.quote.sky_price or a SKY feed in sky_exchanger.quote.feeds is required")
+	}
+
+	return p.err()
+}
+
+// CampaignCap config for the campaign's hard cap
+type CampaignCap struct {
+	// Max BTC raised, decimal string. Empty for no limit
+	MaxBTC string `mapstructure:"max_btc"`
+	// Max skycoin sent, decimal string. Empty for no limit
+	MaxSky string `mapstructure:"max_sky"`
+	// How a deposit over the cap is handled, "refund" or "pro_rata"
+	Policy string `mapstructure:"policy"`
+}
+
+// Validate returns an error if the campaign cap config is invalid
+func (c CampaignCap) Validate() error {
+	var p problems
+	if c.MaxBTC != "" {
+		d, err := decimal.NewFromString(c.MaxBTC)
+		switch {
+		case err != nil:
+			p.addf("sky_exchanger.campaign_cap.max_btc is invalid: %v", err)
+		case d.Sign() <= 0:
+			p.add("sky_exchanger.campaign_cap.max_btc must be > 0")
+		default:
+			if satoshis := d.Mul(decimal.New(1, 8)); !satoshis.Equal(satoshis.Truncate(0)) {
+				p.add("sky_exchanger.campaign_cap.max_btc has more than 8 decimal places")
+			}
+		}
+	}
+
+	if c.MaxSky != "" {
+		n, err := droplet.FromString(c.MaxSky)
+		if err != nil {
+			p.addf("sky_exchanger.campaign_cap.max_sky is invalid: %v", err)
+		} else if n == 0 {
+			p.add("sky_exchanger.campaign_cap.max_sky must be > 0")
+		}
+	}
+
+	if c.MaxBTC == "" && c.MaxSky == "" {
+		return p.err()
+	}
+
+	switch c.Policy {
+	case "refund", "pro_rata":
+	default:
+		p.addf("sky_exchanger.campaign_cap.policy must be \"refund\" or \"pro_rata\", not %q", c.Policy)
+	}
+
+	return p.err()
+}
+
+// Dust config for the thresholds below which deposits are ignored as dust
+type Dust struct {
+	// Min BTC deposit, decimal string. Empty to convert any BTC deposit
+	BTC string `mapstructure:"btc"`
+	// Min ETH deposit, decimal string. Empty to convert any ETH deposit
+	ETH string `mapstructure:"eth"`
+}
+
+// Validate returns an error if the dust config is invalid
+func (c Dust) Validate() error {
+	var p problems
+	for _, v := range []struct {
+		key      string
+		value    string
+		decimals int32
+	}{
+		{"btc", c.BTC, 8},
+		{"eth", c.ETH, 9},
+	} {
+		if v.value == "" {
+			continue
+		}
+
+		d, err := decimal.NewFromString(v.value)
+		switch {
+		case err != nil:
+			p.addf("sky_exchanger.dust.%s is invalid: %v", v.key, err)
+		case d.Sign() <= 0:
+			p.addf("sky_exchanger.dust.%s must be > 0", v.key)
+		default:
+			if n := d.Mul(decimal.New(1, v.decimals)); !n.Equal(n.Truncate(0)) {
+				p.addf("sky_exchanger.dust.%s has more than %d decimal places", v.key, v.decimals)
+			}
+		}
+	}
+
+	return p.err()
+}
+
+// SendThrottle config for throttling the skycoin sends
+type SendThrottle struct {
+	// Max sends started per second. 0 doesn't limit the rate
+	SendsPerSecond float64 `mapstructure:"sends_per_second"`
+	// Max sent deposits waiting for their confirmation at a time
+	MaxInFlight int `mapstructure:"max_in_flight"`
+}
+
+// CoinHours config for tracking the coin hours of the hot wallet
+type CoinHours struct {
+	Enabled bool `mapstructure:"enabled"`
+	// How often the hot wallet's coin hours are read
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+	// Hours predicted to be spent by a send, including its fee. Required when enabled, there is no meaningful default:
+	// a send burns a share of the hours of the outputs it spends, so this depends on the hot wallet's outputs.
+	HoursPerSend uint64 `mapstructure:"hours_per_send"`
+}
+
+// Validate returns an error if the coin hours config is invalid
+func (c CoinHours) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	var p problems
+	if c.CheckInterval <= 0 {
+		p.add("sky_exchanger.coin_hours.check_interval must be > 0")
+	}
+
+	if c.HoursPerSend == 0 {
+		p.add("sky_exchanger.coin_hours.hours_per_send must be set, to about 3/4 of the coin hours of the outputs spent by a payout")
+	}
+
+	return p.err()
+}
+
+// ReportSnapshots config for running the reports against a snapshot of the db
+type ReportSnapshots struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Dir of the snapshot files, which needs room for a copy of the db per concurrent report. Defaults to the db's dir.
+	Dir string `mapstructure:"dir"`
+}
+
+// Validate returns an error if the report snapshots config is invalid
+func (c ReportSnapshots) Validate() error {
+	if !c.Enabled || c.Dir == "" {
+		return nil
+	}
+
+	if fi, err := os.Stat(c.Dir); err != nil || !fi.IsDir() {
+		return errors.New("sky_exchanger.report_snapshots.dir is not a directory")
+	}
+
+	return nil
+}
+
+// Validate returns an error if the send throttle config is invalid
+func (c SendThrottle) Validate() error {
+	var p problems
+	if c.SendsPerSecond < 0 {
+		p.add("sky_exchanger.send_throttle.sends_per_second can't be negative")
+	}
+
+	if c.MaxInFlight < 1 {
+		p.add("sky_exchanger.send_throttle.max_in_flight must be > 0")
+	}
+
+	return p.err()
+}
+
+// DepositValidation config for the operator's deposit validators
+type DepositValidation struct {
+	// Names of the validators which check each deposit, in order. "http" is the external HTTP validator,
+	// the others must be compiled in. Empty disables deposit validation
+	Validators []string `mapstructure:"validators"`
+	// URL which the HTTP validator POSTs each deposit to
+	URL string `mapstructure:"url"`
+	// Bearer token sent to the HTTP validator
+	Token string `mapstructure:"token"`
+	// Timeout of a request to the HTTP validator
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Accept the deposits when a validator fails, instead of holding them for review
+	FailOpen bool `mapstructure:"fail_open"`
+}
+
+// Validate returns an error if the deposit validation config is invalid
+func (c DepositValidation) Validate() error {
+	var p problems
+	names := make(map[string]struct{}, len(c.Validators))
+	for i, name := range c.Validators {
+		if name == "" {
+			p.addf("sky_exchanger.deposit_validation.validators[%d] is empty", i)
+		} else if _, ok := names[name]; ok {
+			p.addf("sky_exchanger.deposit_validation.validators %q is duplicated", name)
+		}
+		names[name] = struct{}{}
+	}
+
+	if _, ok := names["http"]; ok {
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			p.add("sky_exchanger.deposit_validation.url must be an absolute http or https URL")
+		}
+	}
+
+	if c.Timeout <= 0 {
+		p.add("sky_exchanger.deposit_validation.timeout must be > 0")
+	}
+
+	return p.err()
+}
+
+// Web config for the teller HTTP interface
+type Web struct {
+	HTTPAddr         string        `mapstructure:"http_addr"`
+	HTTPSAddr        string        `mapstructure:"https_addr"`
+	HTTPAddr6        string        `mapstructure:"http_addr6"`  // Additional IPv6 HTTP listener, e.g. "[::]:7071"
+	HTTPSAddr6       string        `mapstructure:"https_addr6"` // Additional IPv6 HTTPS listener
+	StaticDir        string        `mapstructure:"static_dir"`
+	AutoTLSHost      string        `mapstructure:"auto_tls_host"`
+	AutoTLSCache     string        `mapstructure:"auto_tls_cache"`     // Where Let's Encrypt certs are cached, "dir", "db" or "object"
+	AutoTLSCacheDir  string        `mapstructure:"auto_tls_cache_dir"` // Cache directory when auto_tls_cache is "dir"
+	TLSCert          string        `mapstructure:"tls_cert"`
+	TLSKey           string        `mapstructure:"tls_key"`
+	ThrottleMax      int64         `mapstructure:"throttle_max"` // Maximum number of requests per duration
+	ThrottleDuration time.Duration `mapstructure:"throttle_duration"`
+	// IPv6 clients are throttled per network of this prefix length, 0 or 128 throttles per address
+	ThrottleIPv6Prefix int `mapstructure:"throttle_ipv6_prefix"`
+	// Algorithm of the API rate limits, and the limits of particular endpoints
+	RateLimit   RateLimit `mapstructure:"rate_limit"`
+	BehindProxy bool      `mapstructure:"behind_proxy"`
+	APIEnabled  bool      `mapstructure:"api_enabled"`
+	// Languages of the frontend builds in subdirectories of StaticDir, e.g. "en" in StaticDir/en.
+	// The first is the default. Empty serves StaticDir itself.
+	Languages []string `mapstructure:"languages"`
+	// LanguageCookie is the cookie which overrides the language negotiated from Accept-Language
+	LanguageCookie string `mapstructure:"language_cookie"`
+	CDN            CDN    `mapstructure:"cdn"`
+	// Health serves the /healthz and /readyz probes
+	Health bool `mapstructure:"health"`
+	// Max bytes of an API request body, after it is decompressed. 0 for no limit
+	MaxRequestBody int64 `mapstructure:"max_request_body"`
+	// How the Content-Type of the JSON request bodies is checked, "strict" or "lenient". Empty is strict
+	ContentType string `mapstructure:"content_type"`
+}
+
+// RateLimit config for the API rate limiting algorithm
+type RateLimit struct {
+	// "token_bucket", "sliding_window" or "leaky_bucket"
+	Algorithm string `mapstructure:"algorithm"`
+	// Requests a client can burst with leaky_bucket. 0 defaults to web.throttle_max
+	Burst int `mapstructure:"burst"`
+	// Sustained requests per second with leaky_bucket. 0 defaults to web.throttle_max per web.throttle_duration
+	Rate float64 `mapstructure:"rate"`
+	// Limits of particular endpoints, overriding the above
+	Endpoints []RateLimitEndpoint `mapstructure:"endpoints"`
+	// Clients which bypass the rate limits, e.g. health checkers, internal dashboards and trusted partners
+	Exempt RateLimitExempt `mapstructure:"exempt"`
+}
+
+// RateLimitExempt config of the clients exempt from the API rate limits and the abuse throttling.
+// Their requests are counted apart from the limited ones.
+type RateLimitExempt struct {
+	// Networks of the client IPs, e.g. "10.0.0.0/8". A bare IP is a network of that IP alone.
+	CIDRs []string `mapstructure:"cidrs"`
+	// API keys sent in the X-Api-Key header
+	APIKeys []string `mapstructure:"api_keys"`
+}
+
+// Networks returns the parsed CIDRs, skipping the invalid ones
+func (c RateLimitExempt) Networks() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range c.CIDRs {
+		if n := parseExemptCIDR(s); n != nil {
+			nets = append(nets, n)
+		}
+	}
+	return nets
+}
+
+// parseExemptCIDR parses a CIDR, or a bare IP as a network of that IP alone. It returns nil if s is invalid.
+func parseExemptCIDR(s string) *net.IPNet {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// RateLimitEndpoint config for the rate limit of an endpoint. Zero values default to web.rate_limit.
+type RateLimitEndpoint struct {
+	// Path of the endpoint, e.g. "/api/bind"
+	Path      string `mapstructure:"path"`
+	Algorithm string `mapstructure:"algorithm"`
+	// Max requests per duration, overriding web.throttle_max and web.throttle_duration
+	Max      int64         `mapstructure:"max"`
+	Duration time.Duration `mapstructure:"duration"`
+	Burst    int           `mapstructure:"burst"`
+	Rate     float64       `mapstructure:"rate"`
+}
+
+func validRateLimitAlgorithm(a string) bool {
+	switch a {
+	case "token_bucket", "sliding_window", "leaky_bucket":
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate validates RateLimit config
+func (c RateLimit) Validate() error {
+	var p problems
+	if c.Algorithm != "" && !validRateLimitAlgorithm(c.Algorithm) {
+		p.addf("web.rate_limit.algorithm must be \"token_bucket\", \"sliding_window\" or \"leaky_bucket\", not %q", c.Algorithm)
+	}
+
+	if c.Burst < 0 {
+		p.add("web.rate_limit.burst can't be negative")
+	}
+
+	if c.Rate < 0 {
+		p.add("web.rate_limit.rate can't be negative")
+	}
+
+	paths := make(map[string]struct{}, len(c.Endpoints))
+	for i, e := range c.Endpoints {
+		if !strings.HasPrefix(e.Path, "/") {
+			p.addf("web.rate_limit.endpoints[%d].path must start with \"/\"", i)
+		}
+		if _, ok := paths[e.Path]; ok {
+			p.addf("web.rate_limit.endpoints[%d].path %q is duplicated", i, e.Path)
+		}
+		paths[e.Path] = struct{}{}
+
+		if e.Algorithm != "" && !validRateLimitAlgorithm(e.Algorithm) {
+			p.addf("web.rate_limit.endpoints[%d].algorithm must be \"token_bucket\", \"sliding_window\" or \"leaky_bucket\", not %q", i, e.Algorithm)
+		}
+
+		if e.Max < 0 || e.Duration < 0 || e.Burst < 0 || e.Rate < 0 {
+			p.addf("web.rate_limit.endpoints[%d] max, duration, burst and rate can't be negative", i)
+		}
+	}
+
+	for i, s := range c.Exempt.CIDRs {
+		if parseExemptCIDR(s) == nil {
+			p.addf("web.rate_limit.exempt.cidrs[%d] %q is not a CIDR or an IP", i, s)
+		}
+	}
+
+	for i, key := range c.Exempt.APIKeys {
+		if key == "" {
+			p.addf("web.rate_limit.exempt.api_keys[%d] can't be empty", i)
+		}
+	}
+
+	return p.err()
+}
+
+// CDN config for running teller behind a CDN such as Cloudflare.
+// Requests are verified as coming from the CDN by a shared secret header, or by the CDN's TLS client certificate.
+type CDN struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Header carrying the client IP set by the CDN, e.g. "CF-Connecting-IP". It is only trusted on verified requests.
+	ClientIPHeader string `mapstructure:"client_ip_header"`
+	// Header carrying OriginSecret, added to the requests by the CDN
+	OriginSecretHeader string `mapstructure:"origin_secret_header"`
+	OriginSecret       string `mapstructure:"origin_secret"`
+	// PEM file of the CAs of the CDN's client certificates, e.g. Cloudflare's authenticated origin pulls CA
+	ClientCA string `mapstructure:"client_ca"`
+	// Refuse the requests which aren't verified, so that clients can't bypass the CDN and its rate limits
+	RejectDirect bool `mapstructure:"reject_direct"`
+}
+
+// Validate validates CDN config
+func (c CDN) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	var p problems
+	if c.ClientIPHeader == "" {
+		p.add("web.cdn.client_ip_header must be set when web.cdn.enabled is true")
+	}
+
+	if c.OriginSecret == "" && c.ClientCA == "" {
+		p.add("web.cdn requires web.cdn.origin_secret or web.cdn.client_ca to verify the requests of the CDN")
+	}
+
+	if c.OriginSecret != "" && c.OriginSecretHeader == "" {
+		p.add("web.cdn.origin_secret_header must be set when using web.cdn.origin_secret")
+	}
+
+	return p.err()
+}
+
+// Validate validates Web config
+func (c Web) Validate() error {
+	var p problems
+	if c.HTTPAddr == "" && c.HTTPSAddr == "" {
+		p.add("at least one of web.http_addr, web.https_addr must be set")
+	}
+
+	for _, a := range []struct {
+		key, addr string
+		ipv6      bool
+	}{
+		{"web.http_addr", c.HTTPAddr, false},
+		{"web.https_addr", c.HTTPSAddr, false},
+		{"web.http_addr6", c.HTTPAddr6, true},
+		{"web.https_addr6", c.HTTPSAddr6, true},
+	} {
+		if a.addr == "" {
+			continue
+		}
+		p.merge("", validateListenAddr(a.key, a.addr, a.ipv6))
+	}
+
+	if c.HTTPAddr6 != "" && c.HTTPAddr == "" {
+		p.add("web.http_addr6 is set but web.http_addr is not, use web.http_addr for an IPv6 only listener")
+	}
+
+	if c.HTTPSAddr6 != "" && c.HTTPSAddr == "" {
+		p.add("web.https_addr6 is set but web.https_addr is not, use web.https_addr for an IPv6 only listener")
+	}
+
+	if c.ThrottleMax < 0 {
+		p.add("web.throttle_max can't be negative")
+	}
+
+	if c.ThrottleMax > 0 && c.ThrottleDuration <= 0 {
+		p.add("web.throttle_duration must be > 0 when web.throttle_max is set")
+	}
+
+	if c.ThrottleIPv6Prefix < 0 || c.ThrottleIPv6Prefix > 128 {
+		p.add("web.throttle_ipv6_prefix must be between 0 and 128")
+	}
+
+	if c.MaxRequestBody < 0 {
+		p.add("web.max_request_body can't be negative")
+	}
+
+	switch c.ContentType {
+	case "", "strict", "lenient":
+	default:
+		p.addf("web.content_type must be \"strict\" or \"lenient\", not %q", c.ContentType)
+	}
+
+	p.merge("", c.RateLimit.Validate())
+
+	// An endpoint's max is per web.throttle_duration unless it has its own duration
+	for i, e := range c.RateLimit.Endpoints {
+		if e.Max > 0 && e.Duration == 0 && c.ThrottleDuration <= 0 {
+			p.addf("web.rate_limit.endpoints[%d].duration must be > 0 when its max is set, unless web.throttle_duration is", i)
+		}
+	}
+
+	languages := make(map[string]struct{}, len(c.Languages))
+	for _, l := range c.Languages {
+		if !validLanguageTag(l) {
+			p.addf("web.languages has an invalid language tag %q, e.g. \"en\" or \"zh-cn\"", l)
+		}
+		if _, ok := languages[strings.ToLower(l)]; ok {
+			p.addf("web.languages has a duplicate language %q", l)
+		}
+		languages[strings.ToLower(l)] = struct{}{}
+	}
+
+	if len(c.Languages) != 0 && c.LanguageCookie == "" {
+		p.add("web.language_cookie must be set when web.languages is set")
+	}
+
+	if c.HTTPSAddr != "" && c.AutoTLSHost == "" && (c.TLSCert == "" || c.TLSKey == "") {
+		p.add("when using web.https_addr, either web.auto_tls_host or both web.tls_cert and web.tls_key must be set")
+	}
+
+	if (c.TLSCert == "" && c.TLSKey != "") || (c.TLSCert != "" && c.TLSKey == "") {
+		p.add("web.tls_cert and web.tls_key must be set or unset together")
+	}
+
+	if c.AutoTLSHost != "" && (c.TLSKey != "" || c.TLSCert != "") {
+		p.add("either use web.auto_tls_host or both web.tls_key and web.tls_cert")
+	}
+
+	if c.HTTPSAddr == "" && (c.AutoTLSHost != "" || c.TLSKey != "" || c.TLSCert != "") {
+		p.add("web.auto_tls_host or web.tls_key or web.tls_cert is set but web.https_addr is not enabled")
+	}
+
+	p.merge("", c.CDN.Validate())
+
+	if c.CDN.Enabled && c.BehindProxy {
+		p.add("web.behind_proxy trusts X-Forwarded-For from every client, it can't be used with web.cdn.enabled")
+	}
+
+	if c.CDN.Enabled && c.CDN.ClientCA != "" && c.HTTPSAddr == "" {
+		p.add("web.cdn.client_ca is set but web.https_addr is not enabled")
+	}
+
+	switch c.AutoTLSCache {
+	case AutoTLSCacheDir:
+		if c.AutoTLSCacheDir == "" {
+			p.add("web.auto_tls_cache_dir must be set when web.auto_tls_cache is \"dir\"")
+		}
+	case AutoTLSCacheDB, AutoTLSCacheObject:
+	default:
+		p.addf("web.auto_tls_cache must be \"%s\", \"%s\" or \"%s\"", AutoTLSCacheDir, AutoTLSCacheDB, AutoTLSCacheObject)
+	}
+
+	return p.err()
+}
+
+// WithListen returns c with the listen addresses and TLS certificate of o, the web settings which teller reloads on SIGHUP
+func (c Web) WithListen(o Web) Web {
+	c.HTTPAddr = o.HTTPAddr
+	c.HTTPAddr6 = o.HTTPAddr6
+	c.HTTPSAddr = o.HTTPSAddr
+	c.HTTPSAddr6 = o.HTTPSAddr6
+	c.TLSCert = o.TLSCert
+	c.TLSKey = o.TLSKey
+	return c
+}
+
+// validLanguageTag returns true if tag is a language tag of letter and digit subtags, separated by "-".
+// The tag is also a directory name, so nothing else is allowed.
+func validLanguageTag(tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	for _, sub := range strings.Split(tag, "-") {
+		if sub == "" || len(sub) > 8 {
+			return false
+		}
+		for _, r := range sub {
+			if !(r >= 'a' && r <= 'z') && !(r >= 'A' && r <= 'Z') && !(r >= '0' && r <= '9') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// validateListenAddr checks that addr is a "host:port" listen address.
+// IPv6 hosts must be in brackets, e.g. "[::]:7071". If ipv6 is true, the host must be an IPv6 address.
+func validateListenAddr(key, addr string, ipv6 bool) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%s invalid: %v, IPv6 hosts must be in brackets, e.g. \"[::]:7071\"", key, err)
+	}
+
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("%s port %q is not a port number", key, port)
+	}
+
+	if ipv6 {
+		if i := strings.LastIndex(host, "%"); i >= 0 {
+			host = host[:i]
+		}
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("%s host must be an IPv6 address, e.g. \"[::]:7071\"", key)
+		}
+	}
+
+	return nil
+}
+
+// Widget config for embedding the bind widget in partner checkout pages
+type Widget struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Origins of the partner pages which can embed the website in a frame, e.g. https://shop.example.com
+	PartnerOrigins []string `mapstructure:"partner_origins"`
+	// Key of the widget session token signatures
+	SigningKey string `mapstructure:"signing_key"`
+	// How long a widget session token is valid
+	SessionTTL time.Duration `mapstructure:"session_ttl"`
+	// Maximum number of bind and status requests per widget session per throttle_duration
+	ThrottleMax      int64         `mapstructure:"throttle_max"`
+	ThrottleDuration time.Duration `mapstructure:"throttle_duration"`
+}
+
+// Validate validates Widget config
+func (c Widget) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if len(c.PartnerOrigins) == 0 {
+		return errors.New("widget.partner_origins must be set when widget is enabled")
+	}
+
+	for _, o := range c.PartnerOrigins {
+		u, err := url.Parse(o)
+		if err != nil {
+			return fmt.Errorf("widget.partner_origins %q is invalid: %v", o, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.TrimSuffix(o, "/") != u.Scheme+"://"+u.Host {
+			return fmt.Errorf("widget.partner_origins %q must be an origin, e.g. https://shop.example.com", o)
+		}
+	}
+
+	if c.SigningKey == "" {
+		return errors.New("widget.signing_key must be set when widget is enabled")
+	}
+
+	if c.SessionTTL <= 0 {
+		return errors.New("widget.session_ttl must be > 0")
+	}
+
+	if c.ThrottleMax <= 0 {
+		return errors.New("widget.throttle_max must be > 0")
+	}
+
+	if c.ThrottleDuration <= 0 {
+		return errors.New("widget.throttle_duration must be > 0")
+	}
+
+	return nil
+}
+
+// Abuse config for throttling clients with anomalous request patterns
+type Abuse struct {
+	Enabled bool `mapstructure:"enabled"`
+	// MaxMind GeoLite2 ASN CSV files, e.g. GeoLite2-ASN-Blocks-IPv4.csv and GeoLite2-ASN-Blocks-IPv6.csv
+	ASNFiles []string `mapstructure:"asn_files"`
+	// Maximum number of requests per ASN per asn_throttle_duration. 0 disables the ASN limit
+	ASNThrottleMax      int64         `mapstructure:"asn_throttle_max"`
+	ASNThrottleDuration time.Duration `mapstructure:"asn_throttle_duration"`
+	// Case insensitive substrings of the user agents of known bots
+	BotUserAgents []string `mapstructure:"bot_user_agents"`
+	// A client sending more than burst_max requests within burst_window is bursting. 0 disables burst detection
+	BurstMax    int64         `mapstructure:"burst_max"`
+	BurstWindow time.Duration `mapstructure:"burst_window"`
+	// Number of anomalies a client may have before it is throttled
+	StrikeLimit int `mapstructure:"strike_limit"`
+	// How long a client's anomalies are remembered after its last one
+	StrikeTTL time.Duration `mapstructure:"strike_ttl"`
+	// How long a client is throttled for at its first anomaly over strike_limit, doubled at each further one
+	Penalty    time.Duration `mapstructure:"penalty"`
+	MaxPenalty time.Duration `mapstructure:"max_penalty"`
+	// Captcha siteverify URL, e.g. https://hcaptcha.com/siteverify. Throttled clients may solve a captcha instead of waiting.
+	CaptchaVerifyURL string `mapstructure:"captcha_verify_url"`
+	CaptchaSecret    string `mapstructure:"captcha_secret"`
+}
+
+// Validate validates Abuse config
+func (c Abuse) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	for _, f := range c.ASNFiles {
+		if _, err := os.Stat(f); err != nil {
+			return fmt.Errorf("abuse.asn_files %q can't be read: %v", f, err)
+		}
+	}
+
+	if c.ASNThrottleMax < 0 {
+		return errors.New("abuse.asn_throttle_max can't be negative")
+	}
+	if c.ASNThrottleMax > 0 {
+		if len(c.ASNFiles) == 0 {
+			return errors.New("abuse.asn_files must be set when abuse.asn_throttle_max is set")
+		}
+		if c.ASNThrottleDuration <= 0 {
+			return errors.New("abuse.asn_throttle_duration must be > 0")
+		}
+	}
+
+	if c.BurstMax < 0 {
+		return errors.New("abuse.burst_max can't be negative")
+	}
+	if c.BurstMax > 0 && c.BurstWindow <= 0 {
+		return errors.New("abuse.burst_window must be > 0")
+	}
+
+	if c.StrikeLimit < 0 {
+		return errors.New("abuse.strike_limit can't be negative")
+	}
+	if c.StrikeTTL <= 0 {
+		return errors.New("abuse.strike_ttl must be > 0")
+	}
+	if c.Penalty <= 0 {
+		return errors.New("abuse.penalty must be > 0")
+	}
+	if c.MaxPenalty < c.Penalty {
+		return errors.New("abuse.max_penalty must be >= abuse.penalty")
+	}
+
+	if (c.CaptchaVerifyURL == "") != (c.CaptchaSecret == "") {
+		return errors.New("abuse.captcha_verify_url and abuse.captcha_secret must be set or unset together")
+	}
+	if c.CaptchaVerifyURL != "" {
+		u, err := url.Parse(c.CaptchaVerifyURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("abuse.captcha_verify_url %q must be an http or https URL", c.CaptchaVerifyURL)
+		}
+	}
+
+	return nil
+}
+
+// Capture config for recording the requests and responses of the API, to debug client integrations.
+// Recording is started from the admin API, for a time-boxed capture session.
+type Capture struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Longest capture session which can be started
+	MaxDuration time.Duration `mapstructure:"max_duration"`
+	// Number of records after which a capture session stops
+	MaxRecords int `mapstructure:"max_records"`
+	// Bytes of each request and response body which are recorded
+	MaxBodySize int `mapstructure:"max_body_size"`
+}
+
+// Validate validates Capture config
+func (c Capture) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.MaxDuration <= 0 {
+		return errors.New("capture.max_duration must be > 0")
+	}
+	if c.MaxRecords <= 0 {
+		return errors.New("capture.max_records must be > 0")
+	}
+	if c.MaxBodySize <= 0 {
+		return errors.New("capture.max_body_size must be > 0")
+	}
+
+	return nil
+}
+
+// AdminPanel config for the admin panel AdminPanel
+type AdminPanel struct {
+	Host string    `mapstructure:"host"`
+	Auth AdminAuth `mapstructure:"auth"`
+	// Authorizes the handover endpoints, used by a new teller instance taking over the db. Handover is disabled if empty.
+	HandoverToken string `mapstructure:"handover_token"`
+}
+
+// AdminAuth config for the admin panel login sessions
+type AdminAuth struct {
+	Enabled bool `mapstructure:"enabled"`
+	// How long a login session is valid for
+	SessionTTL time.Duration `mapstructure:"session_ttl"`
+	// Set the Secure flag on the session cookie. Enable when served over HTTPS.
+	SecureCookie bool `mapstructure:"secure_cookie"`
+	// Base32 encoded TOTP secret, used as a fallback when no security key is available
+	TOTPSecret string `mapstructure:"totp_secret"`
+	// WebAuthn relying party ID, normally the admin panel's domain name
+	WebAuthnRPID string `mapstructure:"webauthn_rp_id"`
+	// WebAuthn origin the browser reports, e.g. https://admin.example.com
+	WebAuthnOrigin string `mapstructure:"webauthn_origin"`
+	// Registered security keys, formatted as "<base64url credential ID>:<base64 SPKI public key>"
+	WebAuthnCredentials []string `mapstructure:"webauthn_credentials"`
+}
+
+// Validate validates AdminAuth config
+func (c AdminAuth) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.SessionTTL <= 0 {
+		return errors.New("admin_panel.auth.session_ttl must be > 0")
+	}
+
+	if c.TOTPSecret == "" && len(c.WebAuthnCredentials) == 0 {
+		return errors.New("admin_panel.auth requires admin_panel.auth.totp_secret or admin_panel.auth.webauthn_credentials")
+	}
+
+	if len(c.WebAuthnCredentials) != 0 && (c.WebAuthnRPID == "" || c.WebAuthnOrigin == "") {
+		return errors.New("admin_panel.auth.webauthn_rp_id and admin_panel.auth.webauthn_origin must be set when using admin_panel.auth.webauthn_credentials")
+	}
+
+	return nil
+}
+
+// Analytics config for the anonymized funnel events
+type Analytics struct {
+	// Where events are sent, "none", "segment" or "file"
+	Sink string `mapstructure:"sink"`
+	// Base URL of the Segment compatible HTTP API
+	Endpoint string `mapstructure:"endpoint"`
+	// Segment write key
+	WriteKey string `mapstructure:"write_key"`
+	// Path of the events file when sink is "file"
+	File string `mapstructure:"file"`
+	// Secret salt used to hash skycoin addresses
+	Salt string `mapstructure:"salt"`
+}
+
+// Validate validates Analytics config
+func (c Analytics) Validate() error {
+	switch c.Sink {
+	case AnalyticsSinkNone:
+		return nil
+	case AnalyticsSinkSegment:
+		if c.Endpoint == "" {
+			return errors.New("analytics.endpoint must be set when analytics.sink is \"segment\"")
+		}
+		if c.WriteKey == "" {
+			return errors.New("analytics.write_key must be set when analytics.sink is \"segment\"")
+		}
+	case AnalyticsSinkFile:
+		if c.File == "" {
+			return errors.New("analytics.file must be set when analytics.sink is \"file\"")
+		}
+	default:
+		return fmt.Errorf("analytics.sink must be \"%s\", \"%s\" or \"%s\"", AnalyticsSinkNone, AnalyticsSinkSegment, AnalyticsSinkFile)
+	}
+
+	if c.Salt == "" {
+		return errors.New("analytics.salt must be set when analytics are enabled")
+	}
+
+	return nil
+}
+
+// Events config for publishing the deposit pipeline's events to a message queue
+type Events struct {
+	// Where events are published, "none", "kafka" or "nats"
+	Sink string `mapstructure:"sink"`
+	// Include the skycoin and deposit addresses in the events
+	IncludeAddresses bool `mapstructure:"include_addresses"`
+	// Max events published at once
+	BatchSize int `mapstructure:"batch_size"`
+	// Wait before retrying a failed batch, doubled for each failure up to a minute
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+	Kafka        EventsKafka   `mapstructure:"kafka"`
+	NATS         EventsNATS    `mapstructure:"nats"`
+}
+
+// EventsKafka config for producing events through a Kafka REST Proxy
+type EventsKafka struct {
+	// Base URL of the Confluent compatible REST Proxy
+	RESTProxyURL string `mapstructure:"rest_proxy_url"`
+	Topic        string `mapstructure:"topic"`
+	// Basic auth of the REST Proxy, if it requires it
+	User string `mapstructure:"user"`
+	Pass string `mapstructure:"pass"`
+}
+
+// EventsNATS config for publishing events to NATS JetStream
+type EventsNATS struct {
+	// nats:// or tls:// URL of the server, with the user and password in it if required
+	URL string `mapstructure:"url"`
+	// Events are published to <subject>.<type>, which a JetStream stream must capture
+	Subject string `mapstructure:"subject"`
+	// Auth token, if the server requires one
+	Token string `mapstructure:"token"`
+	// Bounds connecting and publishing a batch
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Validate validates Events config
+func (c Events) Validate() error {
+	switch c.Sink {
+	case EventsSinkNone:
+		return nil
+	case EventsSinkKafka:
+		u, err := url.Parse(c.Kafka.RESTProxyURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("events.kafka.rest_proxy_url must be an absolute http or https URL when events.sink is \"kafka\"")
+		}
+		if c.Kafka.Topic == "" {
+			return errors.New("events.kafka.topic must be set when events.sink is \"kafka\"")
+		}
+	case EventsSinkNATS:
+		u, err := url.Parse(c.NATS.URL)
+		if err != nil || (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+			return errors.New("events.nats.url must be a nats:// or tls:// URL when events.sink is \"nats\"")
+		}
+		if c.NATS.Subject == "" {
+			return errors.New("events.nats.subject must be set when events.sink is \"nats\"")
+		}
+		if c.NATS.Timeout <= 0 {
+			return errors.New("events.nats.timeout must be > 0")
+		}
+	default:
+		return fmt.Errorf("events.sink must be \"%s\", \"%s\" or \"%s\"", EventsSinkNone, EventsSinkKafka, EventsSinkNATS)
+	}
+
+	if c.BatchSize <= 0 {
+		return errors.New("events.batch_size must be > 0")
+	}
+
+	if c.RetryBackoff <= 0 {
+		return errors.New("events.retry_backoff must be > 0")
+	}
+
+	return nil
+}
+
+// MetricsPush config for pushing metrics to a Prometheus Pushgateway
+type MetricsPush struct {
+	// Base URL of the Pushgateway. Metrics are not pushed if unset.
+	URL string `mapstructure:"url"`
+	// Job and instance labels of the pushed group. Instance defaults to the hostname.
+	Job      string `mapstructure:"job"`
+	Instance string `mapstructure:"instance"`
+	// How often to push. Metrics are also pushed on shutdown.
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// Validate validates MetricsPush config
+func (c MetricsPush) Validate() error {
+	if c.URL == "" {
+		return nil
+	}
+
+	if c.Job == "" {
+		return errors.New("metrics_push.job must be set when metrics_push.url is set")
+	}
+
+	if c.Interval <= 0 {
+		return errors.New("metrics_push.interval must be > 0")
+	}
+
+	return nil
+}
+
+// Reconcile config for the scheduled reconciliation report
+type Reconcile struct {
+	Enabled bool `mapstructure:"enabled"`
+	// How often to reconcile and write a report
+	Interval time.Duration `mapstructure:"interval"`
+	// Where reports are written, ReportStorageDir or ReportStorageObject
+	ReportStorage string `mapstructure:"report_storage"`
+	// Where reports are written, inside the ~/.teller-skycoin data directory if relative, when report_storage is "dir"
+	ReportDir string `mapstructure:"report_dir"`
+	// Hex encoded secret key the reports are signed with, created with "tool newkeys"
+	SecretKey string `mapstructure:"secret_key"`
+	// Max differences tolerated before alerting, in whole coins
+	BtcTolerance string `mapstructure:"btc_tolerance"`
+	EthTolerance string `mapstructure:"eth_tolerance"`
+	SkyTolerance string `mapstructure:"sky_tolerance"`
+}
+
+// Validate validates Reconcile config
+func (c Reconcile) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Interval <= 0 {
+		return errors.New("reconcile.interval must be > 0")
+	}
+
+	switch c.ReportStorage {
+	case ReportStorageDir:
+		if c.ReportDir == "" {
+			return errors.New("reconcile.report_dir must be set when reconcile.report_storage is \"dir\"")
+		}
+	case ReportStorageObject:
+	default:
+		return fmt.Errorf("reconcile.report_storage must be \"%s\" or \"%s\"", ReportStorageDir, ReportStorageObject)
+	}
+
+	if c.SecretKey == "" {
+		return errors.New("reconcile.secret_key must be set when reconcile is enabled")
+	}
+
+	if _, err := cipher.SecKeyFromHex(c.SecretKey); err != nil {
+		return fmt.Errorf("reconcile.secret_key is invalid: %v", err)
+	}
+
+	for name, v := range map[string]string{
+		"btc_tolerance": c.BtcTolerance,
+		"eth_tolerance": c.EthTolerance,
+		"sky_tolerance": c.SkyTolerance,
+	} {
+		if v == "" {
+			continue
+		}
+
+		d, err := decimal.NewFromString(v)
+		if err != nil {
+			return fmt.Errorf("reconcile.%s is invalid: %v", name, err)
+		}
+
+		if d.Sign() < 0 {
+			return fmt.Errorf("reconcile.%s can't be negative", name)
+		}
+	}
+
+	return nil
+}
+
+// Email config for the optional contact emails of bindings
+type Email struct {
+	Enabled bool `mapstructure:"enabled"`
+	// host:port of the SMTP server
+	SMTPAddr string `mapstructure:"smtp_addr"`
+	// SMTP PLAIN auth, skipped if the username is empty
+	SMTPUsername string `mapstructure:"smtp_username"`
+	SMTPPassword string `mapstructure:"smtp_password"`
+	// Sender of the emails
+	From string `mapstructure:"from"`
+	// Page which shows a binding's status, linked to in the emails
+	StatusURL string `mapstructure:"status_url"`
+	// Key of the status link signatures
+	SigningKey string `mapstructure:"signing_key"`
+	// Hex encoded 32 byte key the stored emails are encrypted with
+	EncryptionKey string `mapstructure:"encryption_key"`
+	// Directory of the templates which override the built in English emails, with a directory per language
+	TemplatesDir string `mapstructure:"templates_dir"`
+	// Language of the emails of contacts which didn't give one
+	DefaultLanguage string `mapstructure:"default_language"`
+}
+
+// Validate validates Email config
+func (c Email) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(c.SMTPAddr); err != nil {
+		return fmt.Errorf("email.smtp_addr is invalid: %v", err)
+	}
+
+	if c.From == "" {
+		return errors.New("email.from must be set when email is enabled")
+	}
+
+	u, err := url.Parse(c.StatusURL)
+	if err != nil {
+		return fmt.Errorf("email.status_url is invalid: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return errors.New("email.status_url must be an absolute URL")
+	}
+
+	if c.SigningKey == "" {
+		return errors.New("email.signing_key must be set when email is enabled")
+	}
+
+	if b, err := hex.DecodeString(c.EncryptionKey); err != nil || len(b) != 32 {
+		return errors.New("email.encryption_key must be 32 bytes, hex encoded")
+	}
+
+	if c.TemplatesDir != "" {
+		if fi, err := os.Stat(c.TemplatesDir); err != nil || !fi.IsDir() {
+			return errors.New("email.templates_dir is not a directory")
+		}
+	}
+
+	if c.DefaultLanguage == "" {
+		return errors.New("email.default_language must be set when email is enabled")
+	}
+
+	return nil
+}
+
+// DeepLinks config for the signed deep links of the bindings, returned by /api/bind and verified by /api/status
+type DeepLinks struct {
+	Enabled bool `mapstructure:"enabled"`
+	// URL of the frontend page which resumes a bind flow, the links point to it with the token in the link parameter
+	URL string `mapstructure:"url"`
+	// Key of the deep link signatures. Changing it invalidates the links already issued.
+	SigningKey string `mapstructure:"signing_key"`
+	// How long a deep link is valid
+	TTL time.Duration `mapstructure:"ttl"`
+}
+
+// Validate validates DeepLinks config
+func (c DeepLinks) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	var p problems
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		p.add("deep_links.url must be an absolute http or https URL")
+	}
+
+	if c.SigningKey == "" {
+		p.add("deep_links.signing_key must be set when deep links are enabled")
+	}
+
+	if c.TTL <= 0 {
+		p.add("deep_links.ttl must be > 0")
+	}
+
+	return p.err()
+}
+
+// Branding config of the operator branding shown to the depositors, empty fields are left out
+type Branding struct {
+	// Name of the service, e.g. "Example Exchange"
+	ServiceName string `mapstructure:"service_name"`
+	// Absolute URL of the page where depositors get help
+	SupportURL string `mapstructure:"support_url"`
+	// Absolute URL of the logo
+	LogoURL string `mapstructure:"logo_url"`
+	// Email address depositors can write to
+	ContactEmail string `mapstructure:"contact_email"`
+}
+
+// Validate validates Branding config
+func (c Branding) Validate() error {
+	var p problems
+	for _, f := range []struct {
+		key string
+		url string
+	}{
+		{"branding.support_url", c.SupportURL},
+		{"branding.logo_url", c.LogoURL},
+	} {
+		if f.url == "" {
+			continue
+		}
+		u, err := url.Parse(f.url)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			p.addf("%s must be an absolute http or https URL", f.key)
+		}
+	}
+
+	if c.ContactEmail != "" {
+		if a, err := mail.ParseAddress(c.ContactEmail); err != nil || a.Address != c.ContactEmail {
+			p.add("branding.contact_email must be an email address, e.g. support@example.com")
+		}
+	}
+
+	return p.err()
+}
+
+// ReceiptBranding returns the branding of the receipts, the receipts.branding fields which are set,
+// and the service's branding for the others. The support URL is the receipts' website.
+func (c Config) ReceiptBranding() ReceiptBranding {
+	b := c.Receipts.Branding
+	if b.Name == "" {
+		b.Name = c.Branding.ServiceName
+	}
+	if b.LogoURL == "" {
+		b.LogoURL = c.Branding.LogoURL
+	}
+	if b.Website == "" {
+		b.Website = c.Branding.SupportURL
+	}
+	if b.SupportEmail == "" {
+		b.SupportEmail = c.Branding.ContactEmail
+	}
+	return b
+}
+
+// Receipts config for the receipts of the completed deposits, served by /api/receipt
+type Receipts struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Public URL of teller's /api/receipt endpoint, which the receipt links point to
+	URL string `mapstructure:"url"`
+	// Key of the receipt link signatures. Changing it invalidates the links already sent.
+	SigningKey string `mapstructure:"signing_key"`
+	// Serve the receipts as PDF too, with format=pdf
+	PDF bool `mapstructure:"pdf"`
+	// Include the receipt links in the /api/status responses. Anyone who knows a skycoin address can read its statuses,
+	// and the receipts show the deposit addresses and transactions.
+	StatusLinks bool `mapstructure:"status_links"`
+	// Operator branding printed on the receipts
+	Branding ReceiptBranding `mapstructure:"branding"`
+}
+
+// ReceiptBranding config of the operator branding of the receipts, empty fields are left out
+type ReceiptBranding struct {
+	// Name of the operator, the receipts' title
+	Name string `mapstructure:"name"`
+	// Absolute URL of the logo shown on the HTML receipts
+	LogoURL string `mapstructure:"logo_url"`
+	Website string `mapstructure:"website"`
+	// Where depositors ask about their receipts
+	SupportEmail string `mapstructure:"support_email"`
+	// Text printed at the bottom of the receipts, e.g. the operator's legal name and address
+	Footer string `mapstructure:"footer"`
+}
+
+// Validate validates Receipts config
+func (c Receipts) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("receipts.url must be an absolute http or https URL")
+	}
+
+	if c.SigningKey == "" {
+		return errors.New("receipts.signing_key must be set when receipts are enabled")
+	}
+
+	if c.Branding.LogoURL != "" {
+		u, err := url.Parse(c.Branding.LogoURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("receipts.branding.logo_url must be an absolute http or https URL")
+		}
+	}
+
+	return nil
+}
+
+const (
+	// RunbookNodeDown is true while a node has been down for a rule's for duration
+	RunbookNodeDown = "node_down"
+	// RunbookPoolLow is true while a deposit address pool has fewer than a rule's below addresses left
+	RunbookPoolLow = "pool_low"
+	// RunbookSendFailures is true while the deposits failed to send a rule's streak times in a row
+	RunbookSendFailures = "send_failures"
+
+	// RunbookPause pauses a subsystem, as /api/subsystems/pause
+	RunbookPause = "pause"
+	// RunbookFallbackNode switches a node to its fallback
+	RunbookFallbackNode = "fallback_node"
+	// RunbookPage pages the operator through runbook.pager
+	RunbookPage = "page"
+)
+
+// Runbook config of the automated responses to alert conditions
+type Runbook struct {
+	Enabled bool `mapstructure:"enabled"`
+	// How often the rules' conditions are checked
+	Interval time.Duration `mapstructure:"interval"`
+	// Log the actions which would run, without running them
+	DryRun bool          `mapstructure:"dry_run"`
+	Pager  RunbookPager  `mapstructure:"pager"`
+	Rules  []RunbookRule `mapstructure:"rules"`
+}
+
+// RunbookPager config of the webhook which the page action posts to, e.g. an incident management service's
+type RunbookPager struct {
+	URL string `mapstructure:"url"`
+	// Sent as a bearer token, if set
+	Token string `mapstructure:"token"`
+}
+
+// RunbookRule config of a condition, and the actions which run when it becomes true
+type RunbookRule struct {
+	Name string `mapstructure:"name"`
+	// "node_down", "pool_low" or "send_failures"
+	Condition string `mapstructure:"condition"`
+	// Node of node_down, "sky" or "btc"
+	Node string `mapstructure:"node"`
+	// How long the node has been down, for node_down
+	For time.Duration `mapstructure:"for"`
+	// Coin type and remaining addresses of pool_low
+	CoinType string `mapstructure:"coin_type"`
+	Below    uint64 `mapstructure:"below"`
+	// Consecutive send failures of send_failures
+	Streak  int             `mapstructure:"streak"`
+	Actions []RunbookAction `mapstructure:"actions"`
+}
+
+// RunbookAction config of an action of a runbook rule
+type RunbookAction struct {
+	// "pause", "fallback_node" or "page"
+	Type string `mapstructure:"type"`
+	// Subsystem paused by pause, as named by /api/subsystems
+	Subsystem string `mapstructure:"subsystem"`
+	// Node switched by fallback_node, "sky" or "btc"
+	Node string `mapstructure:"node"`
+}
+
+func validRunbookNode(node string) bool {
+	return node == "sky" || node == "btc"
+}
+
+// Validate validates Runbook config
+func (c Runbook) Validate(cfg Config) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Interval <= 0 {
+		return errors.New("runbook.interval must be positive")
+	}
+
+	if c.Pager.URL != "" {
+		u, err := url.Parse(c.Pager.URL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("runbook.pager.url must be an absolute http or https URL")
+		}
+	}
+
+	if len(c.Rules) == 0 {
+		return errors.New("runbook.rules must have a rule when the runbook is enabled")
+	}
+
+	names := make(map[string]struct{}, len(c.Rules))
+	for i, r := range c.Rules {
+		key := fmt.Sprintf("runbook.rules[%d]", i)
+
+		if r.Name == "" {
+			return fmt.Errorf("%s.name missing", key)
+		}
+		if _, ok := names[r.Name]; ok {
+			return fmt.Errorf("%s.name %q is duplicated", key, r.Name)
+		}
+		names[r.Name] = struct{}{}
+
+		switch r.Condition {
+		case RunbookNodeDown:
+			if !validRunbookNode(r.Node) {
+				return fmt.Errorf("%s.node must be \"sky\" or \"btc\", not %q", key, r.Node)
+			}
+			if r.For < 0 {
+				return fmt.Errorf("%s.for can't be negative", key)
+			}
+		case RunbookPoolLow:
+			if r.CoinType == "" {
+				return fmt.Errorf("%s.coin_type missing", key)
+			}
+			if r.Below == 0 {
+				return fmt.Errorf("%s.below must be positive", key)
+			}
+		case RunbookSendFailures:
+			if r.Streak <= 0 {
+				return fmt.Errorf("%s.streak must be positive", key)
+			}
+		default:
+			return fmt.Errorf("%s.condition must be \"%s\", \"%s\" or \"%s\", not %q", key, RunbookNodeDown, RunbookPoolLow, RunbookSendFailures, r.Condition)
+		}
+
+		if len(r.Actions) == 0 {
+			return fmt.Errorf("%s.actions missing", key)
+		}
+
+		for j, a := range r.Actions {
+			key := fmt.Sprintf("%s.actions[%d]", key, j)
+
+			switch a.Type {
+			case RunbookPause:
+				if a.Subsystem == "" {
+					return fmt.Errorf("%s.subsystem missing", key)
+				}
+			case RunbookFallbackNode:
+				switch a.Node {
+				case "sky":
+					if cfg.SkyRPC.FallbackAddress == "" {
+						return fmt.Errorf("%s requires sky_rpc.fallback_address to be set", key)
+					}
+				case "btc":
+					if cfg.BtcRPC.FallbackServer == "" {
+						return fmt.Errorf("%s requires btc_rpc.fallback_server to be set", key)
+					}
+				default:
+					return fmt.Errorf("%s.node must be \"sky\" or \"btc\", not %q", key, a.Node)
+				}
+			case RunbookPage:
+				if c.Pager.URL == "" {
+					return fmt.Errorf("%s requires runbook.pager.url to be set", key)
+				}
+			default:
+				return fmt.Errorf("%s.type must be \"%s\", \"%s\" or \"%s\", not %q", key, RunbookPause, RunbookFallbackNode, RunbookPage, a.Type)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Stats config for the public campaign stats of /api/stats and /api/stats/stream
+type Stats struct {
+	// How often the stats are read from the ledger and pushed to the clients of /api/stats/stream
+	Interval time.Duration `mapstructure:"interval"`
+	// Skycoin available to the campaign, decimal string. The skycoin remaining is not published if unset.
+	SkyCap string `mapstructure:"sky_cap"`
+	// Maximum number of concurrent stream clients
+	MaxClients int `mapstructure:"max_clients"`
+	// The published amounts are rounded down to a multiple of these, decimal strings. Not rounded if unset.
+	BTCGranularity string `mapstructure:"btc_granularity"`
+	ETHGranularity string `mapstructure:"eth_granularity"`
+	SkyGranularity string `mapstructure:"sky_granularity"`
+	// The published participants are rounded down to a multiple of this. Not rounded if 0.
+	ParticipantsGranularity int `mapstructure:"participants_granularity"`
+	// The amounts are withheld until this many skycoin addresses made a deposit,
+	// so that the deposits of the first participants can't be told from the totals
+	MinParticipants int `mapstructure:"min_participants"`
+}
+
+// Validate validates Stats config
+func (c Stats) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("stats.interval must be > 0")
+	}
+
+	if c.SkyCap != "" {
+		if _, err := droplet.FromString(c.SkyCap); err != nil {
+			return fmt.Errorf("stats.sky_cap is invalid: %v", err)
+		}
+	}
+
+	if c.MaxClients <= 0 {
+		return errors.New("stats.max_clients must be > 0")
+	}
+
+	for name, v := range map[string]struct {
+		granularity string
+		coinType    string
+	}{
+		"btc_granularity": {c.BTCGranularity, deposits.CoinTypeBTC},
+		"eth_granularity": {c.ETHGranularity, deposits.CoinTypeETH},
+	} {
+		if v.granularity == "" {
+			continue
+		}
+
+		coin, err := deposits.GetCoin(v.coinType)
+		if err != nil {
+			return err
+		}
+
+		g, err := decimal.NewFromString(v.granularity)
+		if err != nil {
+			return fmt.Errorf("stats.%s is invalid: %v", name, err)
+		}
+
+		if g.Sign() <= 0 || !g.Equal(g.Truncate(coin.Decimals)) {
+			return fmt.Errorf("stats.%s must be > 0, with at most %d decimal places", name, coin.Decimals)
+		}
+	}
+
+	if c.SkyGranularity != "" {
+		g, err := droplet.FromString(c.SkyGranularity)
+		if err != nil {
+			return fmt.Errorf("stats.sky_granularity is invalid: %v", err)
+		}
+		if g == 0 {
+			return errors.New("stats.sky_granularity must be > 0")
+		}
+	}
+
+	if c.ParticipantsGranularity < 0 {
+		return errors.New("stats.participants_granularity can't be negative")
+	}
+
+	if c.MinParticipants < 0 {
+		return errors.New("stats.min_participants can't be negative")
+	}
+
+	return nil
+}
+
+// DBCompact config for the compaction of the database
+type DBCompact struct {
+	// Compact the database this long after teller starts, 0 to only compact when requested from the admin API
+	Interval time.Duration `mapstructure:"interval"`
+}
+
+// Validate validates DBCompact config
+func (c DBCompact) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("db_compact.interval can't be negative")
+	}
+	return nil
+}
+
+// DBBackup config for the backups of the database
+type DBBackup struct {
+	// How often to upload a snapshot of the database to object_storage, 0 disables backups
+	Interval time.Duration `mapstructure:"interval"`
+	// Number of backups to keep, older backups are deleted. 0 keeps every backup
+	Keep int `mapstructure:"keep"`
+}
+
+// Validate validates DBBackup config
+func (c DBBackup) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("db_backup.interval can't be negative")
+	}
+
+	if c.Keep < 0 {
+		return errors.New("db_backup.keep can't be negative")
+	}
+
+	return nil
+}
+
+// ClockSkew config for pausing the launch gate, widget sessions and cancel requests while
+// the system clock is off from NTP or the chains' block times
+type ClockSkew struct {
+	// NTP servers, as host or host:port. The median offset of those which respond is used
+	NTPServers []string `mapstructure:"ntp_servers"`
+	// Max offset of the clock from the ntp_servers. 0 disables the NTP check
+	MaxSkew time.Duration `mapstructure:"max_skew"`
+	// Max distance of the clock from the block time of the newest btcd or geth tip. 0 disables the chain check
+	MaxChainSkew time.Duration `mapstructure:"max_chain_skew"`
+	// How often to check the clock
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+}
+
+// Validate validates ClockSkew config
+func (c ClockSkew) Validate() error {
+	if c.MaxSkew < 0 {
+		return errors.New("clock_skew.max_skew can't be negative")
+	}
+
+	if c.MaxChainSkew < 0 {
+		return errors.New("clock_skew.max_chain_skew can't be negative")
+	}
+
+	if c.MaxSkew > 0 && len(c.NTPServers) == 0 {
+		return errors.New("clock_skew.ntp_servers missing")
+	}
+
+	if (c.MaxSkew > 0 || c.MaxChainSkew > 0) && c.CheckInterval <= 0 {
+		return errors.New("clock_skew.check_interval must be > 0")
+	}
+
+	return nil
+}
+
+// AddressForecast config for predicting when the deposit address pools run out from the bind rate
+type AddressForecast struct {
+	// How far back the bind rate is measured
+	Window time.Duration `mapstructure:"window"`
+	// How often the remaining addresses are sampled
+	SampleInterval time.Duration `mapstructure:"sample_interval"`
+	// Alert when a pool is predicted to run out sooner than this. 0 disables the alert
+	AlertHorizon time.Duration `mapstructure:"alert_horizon"`
+}
+
+// Validate validates AddressForecast config
+func (c AddressForecast) Validate() error {
+	if c.Window <= 0 {
+		return errors.New("address_forecast.window must be > 0")
+	}
+
+	if c.SampleInterval <= 0 {
+		return errors.New("address_forecast.sample_interval must be > 0")
+	}
+
+	if c.SampleInterval >= c.Window {
+		return errors.New("address_forecast.sample_interval must be less than address_forecast.window")
+	}
+
+	if c.AlertHorizon < 0 {
+		return errors.New("address_forecast.alert_horizon can't be negative")
+	}
+
+	return nil
+}
+
+// AddressShard config for sharding the deposit address pools across teller instances.
+// Every instance is configured with the same BTC and ETH ranges, and its own Index.
+type AddressShard struct {
+	Enabled bool `mapstructure:"enabled"`
+	// This instance's shard
+	Index int `mapstructure:"index"`
+	// Ranges of btc_addresses owned by every instance, by shard index, as "start:end" address indexes
+	BTC []string `mapstructure:"btc"`
+	// Ranges of eth_addresses owned by every instance, by shard index
+	ETH []string `mapstructure:"eth"`
+}
+
+// Validate validates AddressShard config. The ranges are checked for overlaps when the pools are loaded.
+func (c AddressShard) Validate(btcEnabled, ethEnabled bool) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Index < 0 {
+		return errors.New("address_shard.index can't be negative")
+	}
+
+	check := func(key string, ranges []string) error {
+		if len(ranges) == 0 {
+			return fmt.Errorf("address_shard.%s missing", key)
+		}
+
+		if c.Index >= len(ranges) {
+			return fmt.Errorf("address_shard.index %d is not one of the %d address_shard.%s ranges", c.Index, len(ranges), key)
+		}
+
+		return nil
+	}
+
+	if btcEnabled {
+		if err := check("btc", c.BTC); err != nil {
+			return err
+		}
+	}
+
+	if ethEnabled {
+		if err := check("eth", c.ETH); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ObjectStorage config for an S3 compatible or GCS bucket
+type ObjectStorage struct {
+	// "s3" or "gcs". Empty disables object storage
+	Backend string `mapstructure:"backend"`
+	// Base URL of the API. Defaults to AWS for "s3", and to GCS for "gcs"
+	Endpoint string `mapstructure:"endpoint"`
+	// Region of the bucket, for "s3"
+	Region string `mapstructure:"region"`
+	Bucket string `mapstructure:"bucket"`
+	// Prefix of the object keys, e.g. "teller/"
+	Prefix string `mapstructure:"prefix"`
+	// Access keys, or the HMAC keys of a GCS service account
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	// Address the bucket in the URL path instead of the hostname, for S3 compatible services such as MinIO
+	PathStyle bool `mapstructure:"path_style"`
+	// Server side encryption: "" for the bucket's default, "aes256" (S3 only), "kms" or "customer"
+	Encryption string `mapstructure:"encryption"`
+	// KMS key ID or ARN for "s3", or key resource name for "gcs", when encryption is "kms"
+	KMSKeyID string `mapstructure:"kms_key_id"`
+	// Base64 encoded 256 bit key, when encryption is "customer". Objects can't be read without it.
+	CustomerKey string `mapstructure:"customer_key"`
+}
+
+// Validate validates ObjectStorage config
+func (c ObjectStorage) Validate() error {
+	switch c.Backend {
+	case "":
+		return nil
+	case "s3":
+		if c.Region == "" {
+			return errors.New("object_storage.region must be set when object_storage.backend is \"s3\"")
+		}
+	case "gcs":
+		if c.Encryption == "aes256" {
+			return errors.New("object_storage.encryption \"aes256\" is S3 only, GCS always encrypts objects")
+		}
+	default:
+		return fmt.Errorf("object_storage.backend must be \"s3\" or \"gcs\", not %q", c.Backend)
+	}
+
+	if c.Bucket == "" {
+		return errors.New("object_storage.bucket missing")
+	}
+
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return errors.New("object_storage.access_key_id and object_storage.secret_access_key must be set")
+	}
+
+	if c.Endpoint != "" {
+		if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("object_storage.endpoint %q is not an http(s) URL", c.Endpoint)
+		}
+	}
+
+	switch c.Encryption {
+	case "", "aes256":
+	case "kms":
+		if c.KMSKeyID == "" {
+			return errors.New("object_storage.kms_key_id must be set when object_storage.encryption is \"kms\"")
+		}
+	case "customer":
+		if key, err := base64.StdEncoding.DecodeString(c.CustomerKey); err != nil || len(key) != 32 {
+			return errors.New("object_storage.customer_key must be a base64 encoded 32 byte key when object_storage.encryption is \"customer\"")
+		}
+	default:
+		return fmt.Errorf("object_storage.encryption must be \"\", \"aes256\", \"kms\" or \"customer\", not %q", c.Encryption)
+	}
+
+	return nil
+}
+
+// HTTPClient config for the requests to third party services
+type HTTPClient struct {
+	// URL of a proxy the requests are sent through, http, https or socks5.
+	// The HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used if unset.
+	Proxy string `mapstructure:"proxy"`
+	// Retries of an idempotent request which failed to connect or got a 429, 502, 503 or 504 response
+	Retries int `mapstructure:"retries"`
+	// Wait before the first retry, doubled for each retry
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+	// Idle connections kept to each host
+	MaxIdleConnsPerHost int `mapstructure:"max_idle_conns_per_host"`
+	// Public keys which the certificate chains of https hosts must contain
+	Pins []HTTPPin `mapstructure:"pins"`
+}
+
+// HTTPPin config for the public keys pinned for a host
+type HTTPPin struct {
+	// Hostname of the https URLs, e.g. "api.segment.io"
+	Host string `mapstructure:"host"`
+	// Base64 SHA-256 digests of the pinned SubjectPublicKeyInfos, any of which can be in the chain
+	Keys []string `mapstructure:"keys"`
+}
+
+// Validate validates HTTPClient config
+func (c HTTPClient) Validate() error {
+	var p problems
+	if c.Proxy != "" {
+		if u, err := url.Parse(c.Proxy); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			p.addf("http_client.proxy %q is not an http, https or socks5 URL", c.Proxy)
+		}
+	}
+
+	if c.Retries < 0 {
+		p.add("http_client.retries can't be negative")
+	}
+
+	if c.RetryBackoff < 0 {
+		p.add("http_client.retry_backoff can't be negative")
+	}
+
+	if c.MaxIdleConnsPerHost < 0 {
+		p.add("http_client.max_idle_conns_per_host can't be negative")
+	}
+
+	hosts := make(map[string]struct{}, len(c.Pins))
+	for i, pin := range c.Pins {
+		if pin.Host == "" || len(pin.Keys) == 0 {
+			p.addf("http_client.pins[%d] must have a host and keys", i)
+		}
+		if net.ParseIP(pin.Host) != nil {
+			p.addf("http_client.pins[%d].host must be a hostname, not an IP address", i)
+		}
+		if _, ok := hosts[pin.Host]; ok {
+			p.addf("http_client.pins[%d].host %s is pinned twice", i, pin.Host)
+		}
+		hosts[pin.Host] = struct{}{}
+
+		for _, k := range pin.Keys {
+			if b, err := base64.StdEncoding.DecodeString(k); err != nil || len(b) != 32 {
+				p.addf("http_client.pins[%d].keys %q is not a base64 SHA-256 digest", i, k)
+			}
+		}
+	}
+
+	return p.err()
+}
+
+// PinMap returns the pinned keys of each host
+func (c HTTPClient) PinMap() map[string][]string {
+	pins := make(map[string][]string, len(c.Pins))
+	for _, p := range c.Pins {
+		pins[p.Host] = p.Keys
+	}
+	return pins
+}
+
+// PayoutLog config for the public payout log
+type PayoutLog struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Secret salt used to hash the skycoin addresses in the log. Changing it makes the hashes of later payouts to an address differ.
+	Salt string `mapstructure:"salt"`
+}
+
+// Validate validates PayoutLog config
+func (c PayoutLog) Validate(analytics Analytics) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Salt == "" {
+		return errors.New("payout_log.salt must be set when payout_log.enabled is true")
+	}
+
+	// The analytics IDs would be the published address hashes
+	if c.Salt == analytics.Salt {
+		return errors.New("payout_log.salt must be different from analytics.salt")
+	}
+
+	return nil
+}
+
+// AuditLog config for the signed export of the audit log from the admin panel
+type AuditLog struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Hex encoded skycoin secret key the exported pages are signed with. Auditors verify them with its public key.
+	SecretKey string `mapstructure:"secret_key"`
+}
+
+// Validate validates AuditLog config
+func (c AuditLog) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.SecretKey == "" {
+		return errors.New("audit_log.secret_key must be set when audit_log.enabled is true")
+	}
+
+	secKey, err := cipher.SecKeyFromHex(c.SecretKey)
+	if err != nil {
+		return fmt.Errorf("audit_log.secret_key is invalid: %v", err)
+	}
+
+	if err := secKey.Verify(); err != nil {
+		return fmt.Errorf("audit_log.secret_key is invalid: %v", err)
+	}
+
+	return nil
 }
 
-// EthScanner config for ETH scanner
-type EthScanner struct {
-	// How often to try to scan for blocks
-	ScanPeriod            time.Duration `mapstructure:"scan_period"`
-	InitialScanHeight     int64         `mapstructure:"initial_scan_height"`
-	ConfirmationsRequired int64         `mapstructure:"confirmations_required"`
+// Archive config for serving the status of an event which has ended, without the chain nodes
+type Archive struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Database snapshot to serve, inside the ~/.teller-skycoin data directory if relative. dbfile is served if unset.
+	DBFilename string `mapstructure:"dbfile"`
 }
 
-// SkyExchanger config for skycoin sender
-type SkyExchanger struct {
-	// SKY/BTC exchange rate. Can be an int, float or rational fraction string
-	SkyBtcExchangeRate string `mapstructure:"sky_btc_exchange_rate"`
-	SkyEthExchangeRate string `mapstructure:"sky_eth_exchange_rate"`
-	// Number of decimal places to truncate SKY to
-	MaxDecimals int `mapstructure:"max_decimals"`
-	// How long to wait before rechecking transaction confirmations
-	TxConfirmationCheckWait time.Duration `mapstructure:"tx_confirmation_check_wait"`
-	// Path of hot Skycoin wallet file on disk
-	Wallet string `mapstructure:"wallet"`
+// Validate validates Archive config against the rest of the config, since an archive only reads the db
+func (c Archive) Validate(cfg Config) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	var p problems
+	if cfg.Email.Enabled {
+		p.add("archive.enabled can't be used with email.enabled")
+	}
+	if cfg.Reconcile.Enabled {
+		p.add("archive.enabled can't be used with reconcile.enabled")
+	}
+	if cfg.Web.AutoTLSHost != "" && cfg.Web.AutoTLSCache == AutoTLSCacheDB {
+		p.add("archive.enabled requires web.auto_tls_cache to be \"dir\" or \"object\"")
+	}
+	if cfg.Dummy.Scanner || cfg.Dummy.Sender {
+		p.add("archive.enabled can't be used with dummy.scanner or dummy.sender")
+	}
+
+	return p.err()
 }
 
-// Web config for the teller HTTP interface
-type Web struct {
-	HTTPAddr         string        `mapstructure:"http_addr"`
-	HTTPSAddr        string        `mapstructure:"https_addr"`
-	StaticDir        string        `mapstructure:"static_dir"`
-	AutoTLSHost      string        `mapstructure:"auto_tls_host"`
-	TLSCert          string        `mapstructure:"tls_cert"`
-	TLSKey           string        `mapstructure:"tls_key"`
-	ThrottleMax      int64         `mapstructure:"throttle_max"` // Maximum number of requests per duration
-	ThrottleDuration time.Duration `mapstructure:"throttle_duration"`
-	BehindProxy      bool          `mapstructure:"behind_proxy"`
-	APIEnabled       bool          `mapstructure:"api_enabled"`
+// Replication config for replicating the db to a warm standby teller.
+// The primary serves the standby's syncs on the admin panel when the token is set.
+// A teller with primary set runs as the standby instead, and keeps a replica of the primary's db at dbfile.
+type Replication struct {
+	// Authorizes the standby's syncs, and keys the MAC of their responses. Replication is disabled if empty.
+	Token string `mapstructure:"token"`
+	// URL of the primary's admin panel, e.g. http://10.0.0.1:7711. Runs teller as the standby if set.
+	Primary string `mapstructure:"primary"`
+	// How often the standby syncs the replica, which bounds the changes lost by a failover
+	Interval time.Duration `mapstructure:"interval"`
+	// Timeout of a sync, which must fit the first sync of the whole db
+	Timeout time.Duration `mapstructure:"timeout"`
 }
 
-// Validate validates Web config
-func (c Web) Validate() error {
-	if c.HTTPAddr == "" && c.HTTPSAddr == "" {
-		return errors.New("at least one of web.http_addr, web.https_addr must be set")
+// Validate validates Replication config against the rest of the config, since the standby doesn't run the teller
+func (c Replication) Validate(cfg Config) error {
+	if c.Primary == "" {
+		return nil
 	}
 
-	if c.HTTPSAddr != "" && c.AutoTLSHost == "" && (c.TLSCert == "" || c.TLSKey == "") {
-		return errors.New("when using web.https_addr, either web.auto_tls_host or both web.tls_cert and web.tls_key must be set")
+	var p problems
+	u, err := url.Parse(c.Primary)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.add("replication.primary must be an absolute http or https URL")
+	}
+	if c.Token == "" {
+		p.add("replication.primary requires replication.token")
+	}
+	if c.Interval <= 0 {
+		p.add("replication.interval must be > 0")
+	}
+	if c.Timeout <= 0 {
+		p.add("replication.timeout must be > 0")
+	}
+	if cfg.Archive.Enabled {
+		p.add("replication.primary can't be used with archive.enabled")
 	}
 
-	if (c.TLSCert == "" && c.TLSKey != "") || (c.TLSCert != "" && c.TLSKey == "") {
-		return errors.New("web.tls_cert and web.tls_key must be set or unset together")
+	return p.err()
+}
+
+// LogRedact config for redacting addresses, emails and txids from the log fields.
+// See logger.RedactRules for how the fields are matched.
+type LogRedact struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Fields replaced by a salted hash, so that log lines about the same address can still be matched
+	Hash []string `mapstructure:"hash"`
+	// Fields removed
+	Drop []string `mapstructure:"drop"`
+	// Fields cut to truncate_length characters
+	Truncate       []string `mapstructure:"truncate"`
+	TruncateLength int      `mapstructure:"truncate_length"`
+	// Secret salt of the hashes
+	Salt string `mapstructure:"salt"`
+}
+
+// Validate validates LogRedact config
+func (c LogRedact) Validate() error {
+	if !c.Enabled {
+		return nil
 	}
 
-	if c.AutoTLSHost != "" && (c.TLSKey != "" || c.TLSCert != "") {
-		return errors.New("either use web.auto_tls_host or both web.tls_key and web.tls_cert")
+	if len(c.Hash) != 0 && c.Salt == "" {
+		return errors.New("log_redact.salt must be set when log_redact.hash is not empty")
 	}
 
-	if c.HTTPSAddr == "" && (c.AutoTLSHost != "" || c.TLSKey != "" || c.TLSCert != "") {
-		return errors.New("web.auto_tls_host or web.tls_key or web.tls_cert is set but web.https_addr is not enabled")
+	if len(c.Truncate) != 0 && c.TruncateLength <= 0 {
+		return errors.New("log_redact.truncate_length must be > 0")
 	}
 
 	return nil
 }
 
-// AdminPanel config for the admin panel AdminPanel
-type AdminPanel struct {
-	Host string `mapstructure:"host"`
-}
-
 // Dummy config for the fake sender and scanner
 type Dummy struct {
 	Scanner  bool   `mapstructure:"scanner"`
 	Sender   bool   `mapstructure:"sender"`
 	HTTPAddr string `mapstructure:"http_addr"`
+	// Run time dependent behavior on a clock which can be moved through the dummy API
+	Clock bool `mapstructure:"clock"`
 }
 
 // Redacted returns a copy of the config with sensitive information redacted
@@ -170,127 +2950,435 @@ func (c Config) Redacted() Config {
 		c.BtcRPC.Pass = "<redacted>"
 	}
 
-	return c
-}
+	if len(c.BtcRPC.Nodes) != 0 {
+		nodes := make([]BtcNode, len(c.BtcRPC.Nodes))
+		copy(nodes, c.BtcRPC.Nodes)
+		for i := range nodes {
+			if nodes[i].User != "" {
+				nodes[i].User = "<redacted>"
+			}
+			if nodes[i].Pass != "" {
+				nodes[i].Pass = "<redacted>"
+			}
+		}
+		c.BtcRPC.Nodes = nodes
+	}
 
-// Validate validates the config
-func (c Config) Validate() error {
-	var errs []string
-	oops := func(err string) {
-		errs = append(errs, err)
+	if c.Widget.SigningKey != "" {
+		c.Widget.SigningKey = "<redacted>"
+	}
+
+	if c.DeepLinks.SigningKey != "" {
+		c.DeepLinks.SigningKey = "<redacted>"
+	}
+
+	if c.Abuse.CaptchaSecret != "" {
+		c.Abuse.CaptchaSecret = "<redacted>"
+	}
+
+	if c.AdminPanel.Auth.TOTPSecret != "" {
+		c.AdminPanel.Auth.TOTPSecret = "<redacted>"
+	}
+
+	if c.AdminPanel.HandoverToken != "" {
+		c.AdminPanel.HandoverToken = "<redacted>"
+	}
+
+	if c.Replication.Token != "" {
+		c.Replication.Token = "<redacted>"
+	}
+
+	if c.Analytics.WriteKey != "" {
+		c.Analytics.WriteKey = "<redacted>"
+	}
+
+	if c.Analytics.Salt != "" {
+		c.Analytics.Salt = "<redacted>"
+	}
+
+	if c.PayoutLog.Salt != "" {
+		c.PayoutLog.Salt = "<redacted>"
+	}
+
+	if c.AuditLog.SecretKey != "" {
+		c.AuditLog.SecretKey = "<redacted>"
+	}
+
+	if c.Events.Kafka.Pass != "" {
+		c.Events.Kafka.Pass = "<redacted>"
+	}
+
+	if c.Events.NATS.Token != "" {
+		c.Events.NATS.Token = "<redacted>"
 	}
 
-	if c.BtcAddresses == "" {
-		oops("btc_addresses missing")
+	if u, err := url.Parse(c.Events.NATS.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "redacted")
+			c.Events.NATS.URL = u.String()
+		}
+	}
+
+	if c.LogRedact.Salt != "" {
+		c.LogRedact.Salt = "<redacted>"
+	}
+
+	if c.Reconcile.SecretKey != "" {
+		c.Reconcile.SecretKey = "<redacted>"
+	}
+
+	if c.ObjectStorage.SecretAccessKey != "" {
+		c.ObjectStorage.SecretAccessKey = "<redacted>"
+	}
+
+	if c.ObjectStorage.CustomerKey != "" {
+		c.ObjectStorage.CustomerKey = "<redacted>"
+	}
+
+	if c.Email.SMTPPassword != "" {
+		c.Email.SMTPPassword = "<redacted>"
+	}
+
+	if c.Email.SigningKey != "" {
+		c.Email.SigningKey = "<redacted>"
+	}
+
+	if c.Email.EncryptionKey != "" {
+		c.Email.EncryptionKey = "<redacted>"
+	}
+
+	if c.Receipts.SigningKey != "" {
+		c.Receipts.SigningKey = "<redacted>"
+	}
+	if c.Runbook.Pager.Token != "" {
+		c.Runbook.Pager.Token = "<redacted>"
 	}
-	if _, err := os.Stat(c.BtcAddresses); os.IsNotExist(err) {
-		oops("btc_addresses file does not exist")
+
+	if len(c.Teller.AllowlistAPIKeys) != 0 {
+		keys := make([]string, len(c.Teller.AllowlistAPIKeys))
+		for i := range keys {
+			keys[i] = "<redacted>"
+		}
+		c.Teller.AllowlistAPIKeys = keys
 	}
-	if c.EthAddresses == "" {
-		oops("eth_addresses missing")
+
+	if len(c.Web.RateLimit.Exempt.APIKeys) != 0 {
+		keys := make([]string, len(c.Web.RateLimit.Exempt.APIKeys))
+		for i := range keys {
+			keys[i] = "<redacted>"
+		}
+		c.Web.RateLimit.Exempt.APIKeys = keys
 	}
-	if _, err := os.Stat(c.EthAddresses); os.IsNotExist(err) {
-		oops("eth_addresses file does not exist")
+
+	return c
+}
+
+// Validate validates the config
+func (c Config) Validate() error {
+	var p problems
+
+	// An archive doesn't connect to the nodes, or load the address pools and wallet
+	archive := c.Archive.Enabled
+
+	if !archive {
+		if c.BtcAddresses == "" {
+			p.add("btc_addresses missing")
+		}
+		if _, err := os.Stat(c.BtcAddresses); os.IsNotExist(err) {
+			p.add("btc_addresses file does not exist")
+		}
+		if c.EthAddresses == "" {
+			p.add("eth_addresses missing")
+		}
+		if _, err := os.Stat(c.EthAddresses); os.IsNotExist(err) {
+			p.add("eth_addresses file does not exist")
+		}
 	}
 
-	if !c.Dummy.Sender {
+	if !c.Dummy.Sender && !archive {
 		if c.SkyRPC.Address == "" {
-			oops("sky_rpc.address missing")
+			p.add("sky_rpc.address missing")
 		}
 
+		p.merge("sky_rpc.reconnect.", c.SkyRPC.Reconnect.Validate())
+
 		// test if skycoin node rpc service is reachable
-		conn, err := net.Dial("tcp", c.SkyRPC.Address)
+		conn, err := net.DialTimeout("tcp", c.SkyRPC.Address, c.SkyRPC.Reconnect.ConnectTimeout)
 		if err != nil {
-			oops(fmt.Sprintf("sky_rpc.address connect failed: %v", err))
+			p.addf("sky_rpc.address connect failed: %v", err)
 		} else {
 			conn.Close()
 		}
 	}
 
-	if !c.Dummy.Scanner {
-		if c.BtcRPC.Enabled {
+	if !c.Dummy.Scanner && !archive {
+		if c.BtcRPC.Enabled && c.BtcRPC.Plugin.Enabled() {
+			p.merge("btc_rpc.plugin.", c.BtcRPC.Plugin.Validate())
+			if len(c.BtcRPC.Nodes) != 0 {
+				p.add("btc_rpc.nodes can't be used with btc_rpc.plugin")
+			}
+			if c.BtcRPC.CheckAddressHistory {
+				p.add("btc_rpc.check_address_history can't be used with btc_rpc.plugin")
+			}
+			if c.BtcScanner.TxFilter {
+				p.add("btc_scanner.tx_filter can't be used with btc_rpc.plugin")
+			}
+		} else if c.BtcRPC.Enabled {
 			if c.BtcRPC.Server == "" {
-				oops("btc_rpc.server missing")
+				p.add("btc_rpc.server missing")
 			}
 
 			if c.BtcRPC.User == "" {
-				oops("btc_rpc.user missing")
+				p.add("btc_rpc.user missing")
 			}
 			if c.BtcRPC.Pass == "" {
-				oops("btc_rpc.pass missing")
+				p.add("btc_rpc.pass missing")
 			}
 			if c.BtcRPC.Cert == "" {
-				oops("btc_rpc.cert missing")
+				p.add("btc_rpc.cert missing")
 			}
 
 			if _, err := os.Stat(c.BtcRPC.Cert); os.IsNotExist(err) {
-				oops("btc_rpc.cert file does not exist")
+				p.add("btc_rpc.cert file does not exist")
+			}
+
+			for i, n := range c.BtcRPC.Nodes {
+				if n.Server == "" || n.User == "" || n.Pass == "" || n.Cert == "" {
+					p.addf("btc_rpc.nodes[%d] must have a server, user, pass and cert", i)
+				}
+				if _, err := os.Stat(n.Cert); os.IsNotExist(err) {
+					p.addf("btc_rpc.nodes[%d].cert file does not exist", i)
+				}
 			}
+
+			if c.BtcRPC.Quorum < 0 || c.BtcRPC.Quorum > len(c.BtcRPC.Nodes)+1 {
+				p.add("btc_rpc.quorum can't be negative or more than the number of nodes")
+			}
+			if len(c.BtcRPC.Nodes) != 0 && c.BtcScanner.TxFilter {
+				p.add("btc_scanner.tx_filter can't be used with btc_rpc.nodes")
+			}
+
+			p.merge("btc_rpc.reconnect.", c.BtcRPC.Reconnect.Validate())
 		}
-		if c.EthRPC.Enabled {
+		if c.EthRPC.Enabled && c.EthRPC.Plugin.Enabled() {
+			p.merge("eth_rpc.plugin.", c.EthRPC.Plugin.Validate())
+			if c.EthRPC.CheckAddressHistory {
+				p.add("eth_rpc.check_address_history can't be used with eth_rpc.plugin")
+			}
+		} else if c.EthRPC.Enabled {
 			if c.EthRPC.Server == "" {
-				oops("eth_rpc.server missing")
+				p.add("eth_rpc.server missing")
 			}
 			if c.EthRPC.Port == "" {
-				oops("eth_rpc.port missing")
+				p.add("eth_rpc.port missing")
+			}
+		}
+	}
+
+	coinTypes := map[string]bool{
+		deposits.CoinTypeBTC: true,
+		deposits.CoinTypeETH: true,
+		c.Payout.Coin:        true,
+	}
+	for i, pc := range c.PluginCoins {
+		prefix := fmt.Sprintf("plugin_coins[%d].", i)
+		p.merge(prefix, pc.Validate())
+
+		if coinTypes[pc.CoinType] {
+			p.addf("%scoin_type %s is used already", prefix, pc.CoinType)
+		}
+		coinTypes[pc.CoinType] = true
+
+		if !archive {
+			if pc.Addresses == "" {
+				p.addf("%saddresses missing", prefix)
+			} else if _, err := os.Stat(pc.Addresses); os.IsNotExist(err) {
+				p.addf("%saddresses file does not exist", prefix)
 			}
 		}
 	}
 
+	p.merge("", c.Teller.Validate())
+
 	if c.BtcScanner.ConfirmationsRequired < 0 {
-		oops("btc_scanner.confirmations_required must be >= 0")
+		p.add("btc_scanner.confirmations_required must be >= 0")
 	}
 	if c.BtcScanner.InitialScanHeight < 0 {
-		oops("btc_scanner.initial_scan_height must be >= 0")
+		p.add("btc_scanner.initial_scan_height must be >= 0")
 	}
 	if c.EthScanner.ConfirmationsRequired < 0 {
-		oops("eth_scanner.confirmations_required must be >= 0")
+		p.add("eth_scanner.confirmations_required must be >= 0")
 	}
 	if c.EthScanner.InitialScanHeight < 0 {
-		oops("eth_scanner.initial_scan_height must be >= 0")
+		p.add("eth_scanner.initial_scan_height must be >= 0")
 	}
+	p.merge("btc_scanner.lag.", c.BtcScanner.Lag.Validate())
+	p.merge("eth_scanner.lag.", c.EthScanner.Lag.Validate())
+	p.merge("btc_scanner.poll.", c.BtcScanner.Poll.Validate(c.BtcScanner.ScanPeriod))
+	p.merge("eth_scanner.poll.", c.EthScanner.Poll.Validate(c.EthScanner.ScanPeriod))
+	p.merge("btc_scanner.finality.", c.BtcScanner.Finality.Validate())
+	p.merge("eth_scanner.finality.", c.EthScanner.Finality.Validate())
 
 	if _, err := mathutil.DecimalFromString(c.SkyExchanger.SkyBtcExchangeRate); err != nil {
-		oops(fmt.Sprintf("sky_exchanger.sky_btc_exchange_rate invalid: %v", err))
+		p.addf("sky_exchanger.sky_btc_exchange_rate invalid: %v", err)
 	}
 	if _, err := mathutil.DecimalFromString(c.SkyExchanger.SkyEthExchangeRate); err != nil {
-		oops(fmt.Sprintf("sky_exchanger.sky_eth_exchange_rate invalid: %v", err))
+		p.addf("sky_exchanger.sky_eth_exchange_rate invalid: %v", err)
 	}
 
-	if !c.Dummy.Sender {
+	if !c.Dummy.Sender && !archive {
 		if c.SkyExchanger.Wallet == "" {
-			oops("sky_exchanger.wallet missing")
+			p.add("sky_exchanger.wallet missing")
 		}
 
 		if _, err := os.Stat(c.SkyExchanger.Wallet); os.IsNotExist(err) {
-			oops(fmt.Sprintf("sky_exchanger.wallet file %s does not exist", c.SkyExchanger.Wallet))
+			p.addf("sky_exchanger.wallet file %s does not exist", c.SkyExchanger.Wallet)
 		}
 
 		w, err := wallet.Load(c.SkyExchanger.Wallet)
 		if err != nil {
-			oops(fmt.Sprintf("sky_exchanger.wallet file %s failed to load: %v", c.SkyExchanger.Wallet, err))
+			p.addf("sky_exchanger.wallet file %s failed to load: %v", c.SkyExchanger.Wallet, err)
 		} else if err := w.Validate(); err != nil {
-			oops(fmt.Sprintf("sky_exchanger.wallet file %s is invalid: %v", c.SkyExchanger.Wallet, err))
+			p.addf("sky_exchanger.wallet file %s is invalid: %v", c.SkyExchanger.Wallet, err)
 		}
 	}
 
 	if c.SkyExchanger.MaxDecimals < 0 {
-		oops("sky_exchanger.max_decimals can't be negative")
+		p.add("sky_exchanger.max_decimals can't be negative")
 	}
 
 	if uint64(c.SkyExchanger.MaxDecimals) > visor.MaxDropletPrecision {
-		oops(fmt.Sprintf("sky_exchanger.max_decimals is larger than visor.MaxDropletPrecision=%d", visor.MaxDropletPrecision))
+		p.addf("sky_exchanger.max_decimals is larger than visor.MaxDropletPrecision=%d", visor.MaxDropletPrecision)
 	}
 
-	if err := c.Web.Validate(); err != nil {
-		oops(err.Error())
+	if c.SkyExchanger.StatusCacheSize < 0 {
+		p.add("sky_exchanger.status_cache_size can't be negative")
 	}
 
-	if len(errs) == 0 {
-		return nil
+	p.merge("", c.SkyExchanger.RateGuard.Validate())
+
+	p.merge("", c.SkyExchanger.RateFeeds.Validate())
+
+	p.merge("", c.SkyExchanger.Quote.Validate())
+
+	p.merge("", c.SkyExchanger.Display.Validate(c.SkyExchanger.Quote))
+
+	p.merge("", c.SkyExchanger.CampaignCap.Validate())
+
+	p.merge("", c.SkyExchanger.Dust.Validate())
+
+	p.merge("", c.SkyExchanger.SendThrottle.Validate())
+
+	p.merge("", c.SkyExchanger.CoinHours.Validate())
+
+	p.merge("", c.SkyExchanger.DepositValidation.Validate())
+
+	p.merge("", c.SkyExchanger.ReportSnapshots.Validate())
+
+	p.merge("", c.Web.Validate())
+
+	p.merge("", c.Widget.Validate())
+
+	p.merge("", c.DeepLinks.Validate())
+
+	p.merge("", c.Abuse.Validate())
+
+	p.merge("", c.Capture.Validate())
+
+	p.merge("", c.AdminPanel.Auth.Validate())
+
+	p.merge("", c.Analytics.Validate())
+
+	p.merge("", c.Events.Validate())
+
+	if c.LogFormat != LogFormatText && c.LogFormat != LogFormatJSON {
+		p.addf("log_format must be \"%s\" or \"%s\"", LogFormatText, LogFormatJSON)
+	}
+
+	p.merge("", c.LogRedact.Validate())
+
+	p.merge("", c.MetricsPush.Validate())
+
+	p.merge("", c.Reconcile.Validate())
+
+	p.merge("", c.Email.Validate())
+
+	p.merge("", c.Receipts.Validate())
+
+	p.merge("", c.Branding.Validate())
+
+	p.merge("", c.Runbook.Validate(c))
+
+	p.merge("", c.Stats.Validate())
+
+	p.merge("", c.Payout.Validate())
+
+	p.merge("", c.Explorer.Validate(c.Payout))
+
+	p.merge("", c.PayoutLog.Validate(c.Analytics))
+
+	p.merge("", c.AuditLog.Validate())
+
+	for name := range c.FeatureFlags {
+		if _, ok := FeatureFlagDefaults[name]; !ok {
+			p.addf("feature_flags.%s is not a feature flag", name)
+		}
+	}
+
+	p.merge("", c.DBCompact.Validate())
+
+	p.merge("", c.DBBackup.Validate())
+
+	p.merge("", c.ObjectStorage.Validate())
+
+	p.merge("", c.ClockSkew.Validate())
+
+	p.merge("", c.HTTPClient.Validate())
+
+	p.merge("", c.AddressForecast.Validate())
+
+	p.merge("", c.AddressShard.Validate(c.BtcRPC.Enabled, c.EthRPC.Enabled))
+
+	// The plugins don't serve the block times
+	btcNode := c.BtcRPC.Enabled && !c.BtcRPC.Plugin.Enabled()
+	ethNode := c.EthRPC.Enabled && !c.EthRPC.Plugin.Enabled()
+	if c.ClockSkew.MaxChainSkew > 0 && (c.Dummy.Scanner || (!btcNode && !ethNode)) {
+		p.add("clock_skew.max_chain_skew requires btc_rpc or eth_rpc to be enabled without a plugin")
+	}
+
+	if c.ObjectStorage.Backend == "" {
+		if c.Web.AutoTLSHost != "" && c.Web.AutoTLSCache == AutoTLSCacheObject {
+			p.add("web.auto_tls_cache \"object\" requires object_storage.backend to be set")
+		}
+		if c.Reconcile.Enabled && c.Reconcile.ReportStorage == ReportStorageObject {
+			p.add("reconcile.report_storage \"object\" requires object_storage.backend to be set")
+		}
+		if c.DBBackup.Interval > 0 {
+			p.add("db_backup.interval requires object_storage.backend to be set")
+		}
+	}
+
+	p.merge("", c.Archive.Validate(c))
+
+	p.merge("", c.Replication.Validate(c))
+
+	if c.Dummy.Clock && !c.Dummy.Sender {
+		p.add("dummy.clock can only be used with dummy.sender")
+	}
+
+	if c.Env == EnvProduction {
+		if c.Dummy.Scanner {
+			p.add("dummy.scanner can't be enabled under the production profile")
+		}
+		if c.Dummy.Sender {
+			p.add("dummy.sender can't be enabled under the production profile")
+		}
+		if c.Dummy.Clock {
+			p.add("dummy.clock can't be enabled under the production profile")
+		}
 	}
 
-	return errors.New(strings.Join(errs, "\n"))
+	return p.err()
 }
 
 func setDefaults() {
@@ -298,45 +3386,255 @@ func setDefaults() {
 	viper.SetDefault("profile", false)
 	viper.SetDefault("debug", true)
 	viper.SetDefault("logfile", "./teller.log")
+	viper.SetDefault("log_format", LogFormatText)
 	viper.SetDefault("dbfile", "teller.db")
+	viper.SetDefault("db_compact.interval", time.Duration(0))
+	viper.SetDefault("db_backup.interval", time.Duration(0))
+	viper.SetDefault("db_backup.keep", 0)
+
+	// ClockSkew
+	viper.SetDefault("clock_skew.ntp_servers", []string{"0.pool.ntp.org", "1.pool.ntp.org", "2.pool.ntp.org"})
+	viper.SetDefault("clock_skew.max_skew", time.Duration(0))
+	viper.SetDefault("clock_skew.max_chain_skew", time.Duration(0))
+	viper.SetDefault("clock_skew.check_interval", time.Minute)
+
+	// HTTPClient
+	viper.SetDefault("http_client.retries", 2)
+	viper.SetDefault("http_client.retry_backoff", time.Millisecond*500)
+	viper.SetDefault("http_client.max_idle_conns_per_host", 4)
+
+	// AddressForecast
+	viper.SetDefault("address_forecast.window", time.Hour*6)
+	viper.SetDefault("address_forecast.sample_interval", time.Minute)
+	viper.SetDefault("address_forecast.alert_horizon", time.Hour*24)
+
+	// LogRedact
+	viper.SetDefault("log_redact.enabled", false)
+	viper.SetDefault("log_redact.hash", []string{"skyaddr", "sky_address", "deposit_addr", "deposit_address", "deposit.address", "coin_addr", "remote_addr"})
+	viper.SetDefault("log_redact.drop", []string{"email"})
+	viper.SetDefault("log_redact.truncate", []string{"txid", "btc_tx", "deposit_id", "deposit.tx", "broadcast_tx_txid"})
+	viper.SetDefault("log_redact.truncate_length", 8)
 
 	// Teller
 	viper.SetDefault("teller.max_bound_btc_addrs", 5)
+	viper.SetDefault("teller.bind_queue_size", 1000)
+	viper.SetDefault("teller.bind_max_wait", time.Second*5)
+	viper.SetDefault("teller.cancel_policy", CancelPolicyRetire)
 
 	// SkyRPC
 	viper.SetDefault("sky_rpc.address", "127.0.0.1:6430")
+	viper.SetDefault("sky_rpc.reconnect.connect_timeout", time.Second*10)
+	viper.SetDefault("sky_rpc.reconnect.request_timeout", time.Minute)
+	viper.SetDefault("sky_rpc.reconnect.min_backoff", time.Second)
+	viper.SetDefault("sky_rpc.reconnect.max_backoff", time.Minute)
+	viper.SetDefault("sky_rpc.reconnect.alert_after", 5)
+
+	// Payout
+	viper.SetDefault("payout.coin", "SKY")
+	viper.SetDefault("payout.chain", "skycoin")
+
+	// Explorer
+	viper.SetDefault("explorer.btc.preset", "blockstream")
+	viper.SetDefault("explorer.eth.preset", "etherscan")
 
 	// BtcRPC
 	viper.SetDefault("btc_rpc.server", "127.0.0.1:8334")
+	viper.SetDefault("btc_rpc.check_address_history", false)
+	viper.SetDefault("btc_rpc.quorum", 0)
+	viper.SetDefault("btc_rpc.plugin.request_timeout", time.Second*30)
+	viper.SetDefault("btc_rpc.reconnect.connect_timeout", time.Second*10)
+	viper.SetDefault("btc_rpc.reconnect.request_timeout", time.Minute)
+	viper.SetDefault("btc_rpc.reconnect.min_backoff", time.Second)
+	viper.SetDefault("btc_rpc.reconnect.max_backoff", time.Minute)
+	viper.SetDefault("btc_rpc.reconnect.alert_after", 5)
+
+	// EthRPC
+	viper.SetDefault("eth_rpc.check_address_history", false)
+	viper.SetDefault("eth_rpc.plugin.request_timeout", time.Second*30)
 
 	// BtcScanner
 	viper.SetDefault("btc_scanner.scan_period", time.Second*20)
 	viper.SetDefault("btc_scanner.initial_scan_height", int64(492478))
 	viper.SetDefault("btc_scanner.confirmations_required", int64(1))
+	viper.SetDefault("btc_scanner.tx_filter", false)
+	viper.SetDefault("btc_scanner.lag.max_lag", int64(0))
+	viper.SetDefault("btc_scanner.lag.check_interval", time.Minute)
+	viper.SetDefault("eth_scanner.lag.max_lag", int64(0))
+	viper.SetDefault("eth_scanner.lag.check_interval", time.Minute)
+	viper.SetDefault("btc_scanner.poll.min_period", time.Duration(0))
+	viper.SetDefault("btc_scanner.poll.bind_boost", time.Minute*10)
+	viper.SetDefault("eth_scanner.poll.min_period", time.Duration(0))
+	viper.SetDefault("eth_scanner.poll.bind_boost", time.Minute*10)
+	viper.SetDefault("btc_scanner.finality.policy", FinalityConfirmations)
+	viper.SetDefault("btc_scanner.finality.check_interval", time.Minute)
+	viper.SetDefault("eth_scanner.finality.policy", FinalityConfirmations)
+	viper.SetDefault("eth_scanner.finality.check_interval", time.Minute)
 
 	// SkyExchanger
 	viper.SetDefault("sky_exchanger.tx_confirmation_check_wait", time.Second*5)
 	viper.SetDefault("sky_exchanger.max_decimals", 3)
+	viper.SetDefault("sky_exchanger.campaign_cap.policy", "pro_rata")
+	viper.SetDefault("sky_exchanger.ledger_check_interval", time.Minute)
+	viper.SetDefault("sky_exchanger.status_cache_size", 10000)
+	viper.SetDefault("sky_exchanger.check_first_use", false)
+	viper.SetDefault("sky_exchanger.rate_feeds.max_deviation", 5.0)
+	viper.SetDefault("sky_exchanger.rate_feeds.max_staleness", time.Minute*10)
+	viper.SetDefault("sky_exchanger.rate_feeds.poll_interval", time.Minute)
+	viper.SetDefault("sky_exchanger.quote.max_deviation", 5.0)
+	viper.SetDefault("sky_exchanger.quote.max_staleness", time.Minute*10)
+	viper.SetDefault("sky_exchanger.quote.poll_interval", time.Minute)
+	viper.SetDefault("sky_exchanger.display.decimals", 2)
+	viper.SetDefault("sky_exchanger.display.max_deviation", 5.0)
+	viper.SetDefault("sky_exchanger.display.max_staleness", time.Minute*10)
+	viper.SetDefault("sky_exchanger.display.poll_interval", time.Minute)
+	viper.SetDefault("sky_exchanger.send_throttle.sends_per_second", 1.0)
+	viper.SetDefault("sky_exchanger.send_throttle.max_in_flight", 1)
+	viper.SetDefault("sky_exchanger.coin_hours.enabled", false)
+	viper.SetDefault("sky_exchanger.coin_hours.check_interval", time.Minute)
+	viper.SetDefault("sky_exchanger.report_snapshots.enabled", false)
+	viper.SetDefault("sky_exchanger.report_snapshots.dir", "")
+	viper.SetDefault("sky_exchanger.deposit_validation.timeout", time.Second*10)
+	viper.SetDefault("sky_exchanger.deposit_validation.fail_open", false)
 
 	// Web
 	viper.SetDefault("web.http_addr", "127.0.0.1:7071")
 	viper.SetDefault("web.static_dir", "./web/build")
+	viper.SetDefault("web.language_cookie", "lang")
+	viper.SetDefault("web.health", false)
+	viper.SetDefault("web.max_request_body", int64(1024*1024))
+	viper.SetDefault("web.content_type", "strict")
 	viper.SetDefault("web.throttle_max", int64(60))
 	viper.SetDefault("web.throttle_duration", time.Minute)
+	viper.SetDefault("web.throttle_ipv6_prefix", 64)
+	viper.SetDefault("web.rate_limit.algorithm", "token_bucket")
 	viper.SetDefault("web.api_enabled", true)
+	viper.SetDefault("web.auto_tls_cache", AutoTLSCacheDir)
+	viper.SetDefault("web.auto_tls_cache_dir", "cert-cache")
+	viper.SetDefault("web.cdn.enabled", false)
+	viper.SetDefault("web.cdn.client_ip_header", "CF-Connecting-IP")
+	viper.SetDefault("web.cdn.origin_secret_header", "X-Origin-Secret")
+	viper.SetDefault("web.cdn.reject_direct", false)
+
+	// Widget
+	viper.SetDefault("widget.enabled", false)
+	viper.SetDefault("widget.session_ttl", time.Minute*30)
+	viper.SetDefault("widget.throttle_max", int64(10))
+	viper.SetDefault("widget.throttle_duration", time.Minute)
+
+	// Deep links
+	viper.SetDefault("deep_links.enabled", false)
+	viper.SetDefault("deep_links.ttl", time.Hour*24)
+	viper.SetDefault("abuse.enabled", false)
+	viper.SetDefault("abuse.asn_throttle_max", int64(0))
+	viper.SetDefault("abuse.asn_throttle_duration", time.Minute)
+	viper.SetDefault("abuse.bot_user_agents", []string{"curl", "wget", "python-requests", "python-urllib", "scrapy", "headlesschrome", "bot", "spider", "crawler"})
+	viper.SetDefault("abuse.burst_max", int64(20))
+	viper.SetDefault("abuse.burst_window", time.Second*10)
+	viper.SetDefault("abuse.strike_limit", 3)
+	viper.SetDefault("abuse.strike_ttl", time.Hour)
+	viper.SetDefault("abuse.penalty", time.Minute)
+	viper.SetDefault("abuse.max_penalty", time.Hour)
+
+	// Capture
+	viper.SetDefault("capture.enabled", false)
+	viper.SetDefault("capture.max_duration", time.Hour)
+	viper.SetDefault("capture.max_records", 1000)
+	viper.SetDefault("capture.max_body_size", 16384)
 
 	// AdminPanel
 	viper.SetDefault("admin_panel.host", "127.0.0.1:7711")
+	viper.SetDefault("admin_panel.auth.enabled", false)
+	viper.SetDefault("admin_panel.auth.session_ttl", time.Minute*15)
+
+	// Analytics
+	viper.SetDefault("analytics.sink", AnalyticsSinkNone)
+	viper.SetDefault("analytics.endpoint", "https://api.segment.io")
+	viper.SetDefault("analytics.file", "analytics.log")
+
+	// Events
+	viper.SetDefault("events.sink", EventsSinkNone)
+	viper.SetDefault("events.batch_size", 100)
+	viper.SetDefault("events.retry_backoff", time.Second)
+	viper.SetDefault("events.kafka.topic", "teller-events")
+	viper.SetDefault("events.nats.subject", "teller.events")
+	viper.SetDefault("events.nats.timeout", time.Second*10)
+
+	// MetricsPush
+	viper.SetDefault("metrics_push.job", "teller")
+	viper.SetDefault("metrics_push.interval", time.Second*30)
+
+	// Reconcile
+	viper.SetDefault("reconcile.enabled", false)
+	viper.SetDefault("reconcile.interval", time.Hour*24)
+	viper.SetDefault("reconcile.report_storage", ReportStorageDir)
+	viper.SetDefault("reconcile.report_dir", "reconcile")
+
+	// Email
+	viper.SetDefault("email.enabled", false)
+	viper.SetDefault("email.default_language", "en")
+
+	// Receipts
+	viper.SetDefault("receipts.enabled", false)
+	viper.SetDefault("receipts.pdf", false)
+	viper.SetDefault("receipts.status_links", true)
+
+	// Runbook
+	viper.SetDefault("runbook.enabled", false)
+	viper.SetDefault("runbook.interval", time.Second*30)
+	viper.SetDefault("runbook.dry_run", false)
+
+	// Stats
+	viper.SetDefault("stats.interval", time.Second*10)
+	viper.SetDefault("stats.max_clients", 1000)
+
+	// PayoutLog
+	viper.SetDefault("payout_log.enabled", false)
+
+	// Archive
+	viper.SetDefault("archive.enabled", false)
+
+	// Replication
+	viper.SetDefault("replication.interval", time.Second*5)
+	viper.SetDefault("replication.timeout", time.Minute*10)
 
 	// DummySender
 	viper.SetDefault("dummy.http_addr", "127.0.0.1:4121")
 	viper.SetDefault("dummy.scanner", false)
 	viper.SetDefault("dummy.sender", false)
+	viper.SetDefault("dummy.clock", false)
+}
+
+// setContainerDefaults overrides the defaults for running in a container: the web servers listen on all interfaces,
+// the health probes are served and the log is JSON on stdout only
+func setContainerDefaults() {
+	viper.SetDefault("logfile", "")
+	viper.SetDefault("log_format", LogFormatJSON)
+	viper.SetDefault("web.http_addr", "0.0.0.0:7071")
+	viper.SetDefault("web.health", true)
+	viper.SetDefault("admin_panel.host", "0.0.0.0:7711")
+	viper.SetDefault("dummy.http_addr", "0.0.0.0:4121")
 }
 
 // Load loads the configuration from "./$configName.*" where "*" is a
 // JSON, toml or yaml file (toml preferred).
-func Load(configName, appDir string) (Config, error) {
+// If env is set, the profile file "$configName.$env.toml" next to it is merged over it, see profileFiles.
+// If container is set, the defaults are those of setContainerDefaults, the config file is optional,
+// and the environment variables prefixed with ContainerEnvPrefix override the config keys.
+func Load(configName, appDir, env string, container bool) (Config, error) {
+	cfg, err := load(configName, appDir, env, container)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// load reads the config file and its profile files into a Config, which isn't validated
+func load(configName, appDir, env string, container bool) (Config, error) {
 	if strings.HasSuffix(configName, ".toml") {
 		configName = configName[:len(configName)-len(".toml")]
 	}
@@ -348,19 +3646,95 @@ func Load(configName, appDir string) (Config, error) {
 
 	setDefaults()
 
+	if container {
+		setContainerDefaults()
+
+		viper.SetEnvPrefix(ContainerEnvPrefix)
+		viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+		viper.AutomaticEnv()
+	}
+
 	cfg := Config{}
 
 	if err := viper.ReadInConfig(); err != nil {
-		return cfg, err
+		// A container can be configured by its environment alone
+		if _, ok := err.(viper.ConfigFileNotFoundError); !ok || !container {
+			return cfg, err
+		}
 	}
 
-	if err := viper.Unmarshal(&cfg); err != nil {
-		return cfg, err
+	if env != "" {
+		files, err := profileFiles(configName, filepath.Dir(viper.ConfigFileUsed()), env)
+		if err != nil {
+			return cfg, err
+		}
+
+		for _, f := range files {
+			if err := mergeConfigFile(f); err != nil {
+				return cfg, fmt.Errorf("Merge config profile %s failed: %v", f, err)
+			}
+		}
 	}
 
-	if err := cfg.Validate(); err != nil {
+	// The profile is only set by -env, so that a config file can't claim to be another profile
+	viper.Set("env", env)
+
+	if err := viper.Unmarshal(&cfg); err != nil {
 		return cfg, err
 	}
 
 	return cfg, nil
 }
+
+// profileFiles returns the profile files to merge over the base config file for env, in the order they are merged.
+// A profile file can set "inherits" to another profile, whose file is merged before it.
+func profileFiles(configName, dir, env string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+
+	for env != "" {
+		if !validEnv(env) {
+			return nil, fmt.Errorf("Unknown config profile %q, must be one of %s", env, strings.Join(Envs, ", "))
+		}
+
+		if seen[env] {
+			return nil, fmt.Errorf("Config profile %q inherits from itself", env)
+		}
+		seen[env] = true
+
+		f := filepath.Join(dir, fmt.Sprintf("%s.%s.toml", configName, env))
+
+		v := viper.New()
+		v.SetConfigFile(f)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("Read config profile %s failed: %v", f, err)
+		}
+
+		files = append([]string{f}, files...)
+		env = v.GetString("inherits")
+	}
+
+	return files, nil
+}
+
+// mergeConfigFile merges the profile file f over the loaded config.
+// The profile is parsed as the type of its own extension, whatever the type of the base config file.
+func mergeConfigFile(f string) error {
+	r, err := os.Open(f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	viper.SetConfigType(strings.TrimPrefix(filepath.Ext(f), "."))
+	return viper.MergeConfig(r)
+}
+
+func validEnv(env string) bool {
+	for _, e := range Envs {
+		if e == env {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,298 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+// loadProfiles writes the base config and the profile files, by env, to a temp dir, and loads them for env.
+// The config isn't validated, since that needs the address files, the wallet and a skycoin node.
+func loadProfiles(t *testing.T, base string, profiles map[string]string, env string) (Config, error) {
+	viper.Reset()
+	defer viper.Reset()
+
+	dir, err := ioutil.TempDir("", "teller-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+
+	write("teller.toml", base)
+	for e, content := range profiles {
+		write("teller."+e+".toml", content)
+	}
+
+	return load("teller", dir, env, false)
+}
+
+const testBaseConfig = `
+debug = true
+
+[web]
+http_addr = "127.0.0.1:7071"
+api_enabled = true
+
+[sky_exchanger]
+max_decimals = 3
+`
+
+func TestLoadProfiles(t *testing.T) {
+	profiles := map[string]string{
+		"production": `
+[web]
+http_addr = "127.0.0.1:8081"
+`,
+		"staging": `
+inherits = "production"
+debug = false
+
+[sky_exchanger]
+max_decimals = 2
+`,
+	}
+
+	// Without a profile, only the base config is loaded
+	cfg, err := loadProfiles(t, testBaseConfig, profiles, "")
+	require.NoError(t, err)
+	require.Empty(t, cfg.Env)
+	require.True(t, cfg.Debug)
+	require.Equal(t, "127.0.0.1:7071", cfg.Web.HTTPAddr)
+
+	// The inherited profile is merged over the base, then the profile over both
+	cfg, err = loadProfiles(t, testBaseConfig, profiles, EnvStaging)
+	require.NoError(t, err)
+	require.Equal(t, EnvStaging, cfg.Env)
+	require.False(t, cfg.Debug)
+	require.Equal(t, "127.0.0.1:8081", cfg.Web.HTTPAddr)
+	require.Equal(t, 2, cfg.SkyExchanger.MaxDecimals)
+	require.True(t, cfg.Web.APIEnabled)
+
+	cfg, err = loadProfiles(t, testBaseConfig, profiles, EnvProduction)
+	require.NoError(t, err)
+	require.Equal(t, EnvProduction, cfg.Env)
+	require.True(t, cfg.Debug)
+	require.Equal(t, "127.0.0.1:8081", cfg.Web.HTTPAddr)
+	require.Equal(t, 3, cfg.SkyExchanger.MaxDecimals)
+
+	// The profile is set by env alone
+	cfg, err = loadProfiles(t, testBaseConfig+"\nenv = \"production\"\n", nil, "")
+	require.NoError(t, err)
+	require.Empty(t, cfg.Env)
+}
+
+func TestLoadProfilesInvalid(t *testing.T) {
+	tt := []struct {
+		name     string
+		profiles map[string]string
+		env      string
+		err      string
+	}{
+		{
+			name: "unknown env",
+			env:  "dev",
+			err:  `Unknown config profile "dev", must be one of production, staging, test`,
+		},
+		{
+			name: "unknown inherited env",
+			profiles: map[string]string{
+				"staging": `inherits = "dev"`,
+			},
+			env: EnvStaging,
+			err: `Unknown config profile "dev", must be one of production, staging, test`,
+		},
+		{
+			name: "missing profile file",
+			env:  EnvStaging,
+			err:  "Read config profile",
+		},
+		{
+			name: "missing inherited profile file",
+			profiles: map[string]string{
+				"staging": `inherits = "production"`,
+			},
+			env: EnvStaging,
+			err: "teller.production.toml",
+		},
+		{
+			name: "inherits itself",
+			profiles: map[string]string{
+				"staging": `inherits = "staging"`,
+			},
+			env: EnvStaging,
+			err: `Config profile "staging" inherits from itself`,
+		},
+		{
+			name: "inheritance cycle",
+			profiles: map[string]string{
+				"staging":    `inherits = "test"`,
+				"test":       `inherits = "production"`,
+				"production": `inherits = "staging"`,
+			},
+			env: EnvStaging,
+			err: `Config profile "staging" inherits from itself`,
+		},
+		{
+			name: "invalid profile file",
+			profiles: map[string]string{
+				"staging": `[web`,
+			},
+			env: EnvStaging,
+			err: "teller.staging.toml",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadProfiles(t, testBaseConfig, tc.profiles, tc.env)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestValidateProductionRefusesDummy(t *testing.T) {
+	dummy := `
+[dummy]
+scanner = true
+sender = true
+clock = true
+`
+
+	profiles := map[string]string{
+		"production": "",
+		"staging":    `inherits = "production"` + "\n" + dummy,
+	}
+
+	refused := func(cfg Config) bool {
+		err := cfg.Validate()
+		if err == nil {
+			return false
+		}
+		for _, opt := range []string{"dummy.scanner", "dummy.sender", "dummy.clock"} {
+			require.Contains(t, err.Error(), opt+" can't be enabled under the production profile")
+		}
+		return true
+	}
+
+	contains := func(cfg Config) bool {
+		err := cfg.Validate()
+		return err != nil && strings.Contains(err.Error(), "under the production profile")
+	}
+
+	// The test options set in the base config are refused by the production profile
+	cfg, err := loadProfiles(t, testBaseConfig+dummy, profiles, EnvProduction)
+	require.NoError(t, err)
+	require.True(t, cfg.Dummy.Sender)
+	require.True(t, refused(cfg))
+
+	// but not by a profile inheriting it
+	cfg, err = loadProfiles(t, testBaseConfig, profiles, EnvStaging)
+	require.NoError(t, err)
+	require.True(t, cfg.Dummy.Sender)
+	require.False(t, contains(cfg))
+
+	cfg, err = loadProfiles(t, testBaseConfig+dummy, profiles, "")
+	require.NoError(t, err)
+	require.False(t, contains(cfg))
+}
+
+func TestMergeConfigFileType(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	dir, err := ioutil.TempDir("", "teller-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A base config read as json
+	viper.SetConfigType("json")
+	require.NoError(t, viper.ReadConfig(strings.NewReader(`{"debug": true, "web": {"http_addr": "127.0.0.1:7071"}}`)))
+
+	// is merged with a toml profile
+	f := filepath.Join(dir, "teller.staging.toml")
+	require.NoError(t, ioutil.WriteFile(f, []byte("[web]\nhttp_addr = \"127.0.0.1:8081\"\n"), 0600))
+	require.NoError(t, mergeConfigFile(f))
+
+	require.True(t, viper.GetBool("debug"))
+	require.Equal(t, "127.0.0.1:8081", viper.GetString("web.http_addr"))
+}
+
+func TestLoadPluginCoins(t *testing.T) {
+	cfg, err := loadProfiles(t, testBaseConfig+`
+[[plugin_coins]]
+coin_type = "LTC"
+decimals = 8
+addresses = "ltc_addresses.json"
+sky_exchange_rate = "200"
+confirmations_required = 6
+[plugin_coins.plugin]
+command = ["/usr/local/bin/teller-ltc", "-rpc", "127.0.0.1:9332"]
+request_timeout = "10s"
+
+[[plugin_coins]]
+coin_type = "BTC"
+decimals = 19
+sky_exchange_rate = "0"
+[plugin_coins.plugin]
+command = []
+`, nil, "")
+	require.NoError(t, err)
+
+	require.Len(t, cfg.PluginCoins, 2)
+	require.Equal(t, PluginCoin{
+		CoinType:              "LTC",
+		Decimals:              8,
+		Addresses:             "ltc_addresses.json",
+		SkyExchangeRate:       "200",
+		ConfirmationsRequired: 6,
+		Plugin: ScannerPlugin{
+			Command:        []string{"/usr/local/bin/teller-ltc", "-rpc", "127.0.0.1:9332"},
+			RequestTimeout: time.Second * 10,
+		},
+	}, cfg.PluginCoins[0])
+	require.NoError(t, cfg.PluginCoins[0].Validate())
+
+	err = cfg.Validate()
+	require.Error(t, err)
+	for _, problem := range []string{
+		"plugin_coins[0].addresses file does not exist",
+		"plugin_coins[1].decimals must be 0 to 18",
+		"plugin_coins[1].sky_exchange_rate must be greater than zero",
+		"plugin_coins[1].plugin.command missing",
+		"plugin_coins[1].coin_type BTC is used already",
+		"plugin_coins[1].addresses missing",
+	} {
+		require.Contains(t, err.Error(), problem)
+	}
+}
+
+func TestLoadCoinHoursRequiresHoursPerSend(t *testing.T) {
+	cfg, err := loadProfiles(t, testBaseConfig+`
+[sky_exchanger.coin_hours]
+enabled = true
+`, nil, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), cfg.SkyExchanger.CoinHours.HoursPerSend)
+
+	err = cfg.SkyExchanger.CoinHours.Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "sky_exchanger.coin_hours.hours_per_send must be set")
+
+	cfg, err = loadProfiles(t, testBaseConfig+`
+[sky_exchanger.coin_hours]
+enabled = true
+hours_per_send = 1500
+`, nil, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1500), cfg.SkyExchanger.CoinHours.HoursPerSend)
+	require.NoError(t, cfg.SkyExchanger.CoinHours.Validate())
+}
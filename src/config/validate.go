@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Config validation.
+// The Validate methods report all the problems of their section at once, rather than the first one, so that an
+// operator can fix a config in one pass. Each problem is a line of the ValidationError, keyed by its setting.
+// A section validated relative to its key, e.g. a scanner's lag section, has its problems prefixed with the key
+// by the section holding it.
+
+// ValidationError lists the problems found validating a config, one per line of its Error
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "\n")
+}
+
+// problems collects the problems of a config section
+type problems []string
+
+// add adds a problem
+func (p *problems) add(problem string) {
+	*p = append(*p, problem)
+}
+
+// addf adds a problem formatted with fmt.Sprintf
+func (p *problems) addf(format string, a ...interface{}) {
+	p.add(fmt.Sprintf(format, a...))
+}
+
+// merge adds the problems of err, a section's ValidationError or another error, each prefixed with prefix
+func (p *problems) merge(prefix string, err error) {
+	if err == nil {
+		return
+	}
+
+	if e, ok := err.(*ValidationError); ok {
+		for _, problem := range e.Problems {
+			p.add(prefix + problem)
+		}
+		return
+	}
+
+	p.add(prefix + err.Error())
+}
+
+// err returns the problems as a ValidationError, nil if there are none
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	return &ValidationError{
+		Problems: p,
+	}
+}
@@ -0,0 +1,150 @@
+// Package deposits defines the chain-agnostic Deposit that the scanners produce
+// and the exchange consumes, and the units of each coin type's deposit amounts
+package deposits
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// CoinTypeBTC is BTC coin type
+	CoinTypeBTC = "BTC"
+	// CoinTypeETH is ETH coin type
+	CoinTypeETH = "ETH"
+)
+
+var (
+	// ErrUnsupportedCoinType is returned for a coin type without a registered Coin
+	ErrUnsupportedCoinType = errors.New("unsupported coin type")
+)
+
+// MaxDecimals is the most decimal places a coin type can have. The amounts are int64s of the smallest unit,
+// so a coin with more couldn't hold 10 whole coins. Coins with many decimals use a larger unit, as ETH uses gwei.
+const MaxDecimals = 18
+
+// Coin describes the unit of a coin type's deposit amounts
+type Coin struct {
+	Type string
+	// Decimals is the number of decimal places of Deposit.Amount in one whole coin
+	Decimals int32
+}
+
+var (
+	coinsMu sync.RWMutex
+	coins   = map[string]Coin{
+		// Amounts are satoshis
+		CoinTypeBTC: {
+			Type:     CoinTypeBTC,
+			Decimals: 8,
+		},
+		// Amounts are gwei, since wei amounts overflow an int64
+		CoinTypeETH: {
+			Type:     CoinTypeETH,
+			Decimals: 9,
+		},
+	}
+)
+
+// GetCoin returns the Coin of a coin type
+func GetCoin(coinType string) (Coin, error) {
+	coinsMu.RLock()
+	defer coinsMu.RUnlock()
+
+	c, ok := coins[coinType]
+	if !ok {
+		return Coin{}, ErrUnsupportedCoinType
+	}
+	return c, nil
+}
+
+// RegisterCoin adds a coin type, such as the coin type of a scanner plugin's chain.
+// It must be registered before the deposits of the coin type are scanned or read.
+func RegisterCoin(c Coin) error {
+	if c.Type == "" {
+		return errors.New("Coin type missing")
+	}
+	if c.Decimals < 0 || c.Decimals > MaxDecimals {
+		return fmt.Errorf("Coin type %s decimals must be 0 to %d", c.Type, MaxDecimals)
+	}
+
+	coinsMu.Lock()
+	defer coinsMu.Unlock()
+
+	if _, ok := coins[c.Type]; ok {
+		return fmt.Errorf("Coin type %s is registered already", c.Type)
+	}
+
+	coins[c.Type] = c
+	return nil
+}
+
+// CoinTypes returns the registered coin types, sorted
+func CoinTypes() []string {
+	coinsMu.RLock()
+	defer coinsMu.RUnlock()
+
+	coinTypes := make([]string, 0, len(coins))
+	for coinType := range coins {
+		coinTypes = append(coinTypes, coinType)
+	}
+	sort.Strings(coinTypes)
+	return coinTypes
+}
+
+// Coins converts an amount in the smallest unit to whole coins
+func (c Coin) Coins(amount int64) decimal.Decimal {
+	return decimal.New(amount, -c.Decimals)
+}
+
+// Deposit is a payment to a deposit address, normalized across chains
+type Deposit struct {
+	CoinType string // coin type
+	Address  string // deposit address
+	Tx       string // the transaction id
+	N        uint32 // the index of the payment in the transaction, e.g. the vout for BTC
+	// Amount in the coin's smallest unit, see Coin.Decimals.
+	// It is stored as "Value", the name used before deposits were normalized.
+	Amount        int64 `json:"Value"`
+	Height        int64 // the block height
+	Confirmations int64 // confirmations of the block when it was scanned
+	// Final is true once the deposit has the confirmations required by its scanner,
+	// and can't be reversed
+	Final     bool
+	Processed bool // whether this was received by the exchange and saved
+}
+
+// ID returns $tx:$n formatted ID string
+func (d Deposit) ID() string {
+	return fmt.Sprintf("%s:%d", d.Tx, d.N)
+}
+
+// Coins returns the amount in whole coins
+func (d Deposit) Coins() (decimal.Decimal, error) {
+	c, err := GetCoin(d.CoinType)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	return c.Coins(d.Amount), nil
+}
+
+// ParseID splits a deposit ID into its transaction id and payment index
+func ParseID(id string) (string, uint32, error) {
+	pts := strings.Split(id, ":")
+	if len(pts) != 2 || pts[0] == "" || pts[1] == "" {
+		return "", 0, fmt.Errorf("Invalid deposit ID \"%s\"", id)
+	}
+
+	n, err := strconv.ParseUint(pts[1], 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid deposit ID \"%s\": %v", id, err)
+	}
+
+	return pts[0], uint32(n), nil
+}
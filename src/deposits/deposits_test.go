@@ -0,0 +1,144 @@
+package deposits
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDepositID(t *testing.T) {
+	d := Deposit{
+		Tx: "foo",
+		N:  2,
+	}
+
+	require.Equal(t, "foo:2", d.ID())
+
+	tx, n, err := ParseID(d.ID())
+	require.NoError(t, err)
+	require.Equal(t, "foo", tx)
+	require.Equal(t, uint32(2), n)
+}
+
+func TestParseID(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+		id    string
+	}{
+		{
+			"empty string",
+			false,
+			"",
+		},
+		{
+			"colon only",
+			false,
+			":",
+		},
+		{
+			"multiple colons",
+			false,
+			"txid:2:2",
+		},
+		{
+			"no txid",
+			false,
+			":2",
+		},
+		{
+			"no n",
+			false,
+			"txid:",
+		},
+		{
+			"n not int",
+			false,
+			"txid:b",
+		},
+		{
+			"n negative",
+			false,
+			"txid:-1",
+		},
+		{
+			"valid",
+			true,
+			"txid:2",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tx, n, err := ParseID(tc.id)
+			if !tc.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.id, fmt.Sprintf("%s:%d", tx, n))
+		})
+	}
+}
+
+func TestDepositCoins(t *testing.T) {
+	coins, err := Deposit{
+		CoinType: CoinTypeBTC,
+		Amount:   150000000,
+	}.Coins()
+	require.NoError(t, err)
+	require.True(t, decimal.New(15, -1).Equal(coins), coins.String())
+
+	coins, err = Deposit{
+		CoinType: CoinTypeETH,
+		Amount:   1,
+	}.Coins()
+	require.NoError(t, err)
+	require.True(t, decimal.New(1, -9).Equal(coins), coins.String())
+
+	_, err = Deposit{
+		CoinType: "foo",
+	}.Coins()
+	require.Equal(t, ErrUnsupportedCoinType, err)
+}
+
+func TestDepositJSON(t *testing.T) {
+	// Deposits saved before normalization stored the amount as Value
+	var d Deposit
+	err := json.Unmarshal([]byte(`{"CoinType":"BTC","Address":"a","Value":100,"Height":1,"Tx":"t","N":1,"Processed":true}`), &d)
+	require.NoError(t, err)
+	require.Equal(t, Deposit{
+		CoinType:  CoinTypeBTC,
+		Address:   "a",
+		Amount:    100,
+		Height:    1,
+		Tx:        "t",
+		N:         1,
+		Processed: true,
+	}, d)
+}
+
+func TestRegisterCoin(t *testing.T) {
+	_, err := GetCoin("TESTCOIN")
+	require.Equal(t, ErrUnsupportedCoinType, err)
+
+	require.Error(t, RegisterCoin(Coin{Decimals: 8}))
+	require.Error(t, RegisterCoin(Coin{Type: "TESTCOIN", Decimals: -1}))
+	require.Error(t, RegisterCoin(Coin{Type: "TESTCOIN", Decimals: MaxDecimals + 1}))
+	require.Error(t, RegisterCoin(Coin{Type: CoinTypeBTC, Decimals: 8}))
+
+	require.NoError(t, RegisterCoin(Coin{Type: "TESTCOIN", Decimals: 6}))
+	require.Error(t, RegisterCoin(Coin{Type: "TESTCOIN", Decimals: 6}))
+
+	coins, err := Deposit{
+		CoinType: "TESTCOIN",
+		Amount:   1500000,
+	}.Coins()
+	require.NoError(t, err)
+	require.True(t, decimal.New(15, -1).Equal(coins), coins.String())
+
+	require.Equal(t, []string{CoinTypeBTC, CoinTypeETH, "TESTCOIN"}, CoinTypes())
+}
@@ -0,0 +1,789 @@
+// Package drill runs a launch readiness exercise against a staging teller. The launch day failure modes,
+// a flood of binds, a run of deposits and the skycoin node going down, are played against the deployment,
+// which must bear them, alert on them and recover from them for the drill to pass.
+//
+// The staging teller must run with the dummy scanner and sender, whose admin interface injects the deposits,
+// confirms the payouts and takes the simulated skycoin node down. It must accept BTC deposits.
+package drill
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/client"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/runbook"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+const (
+	// CheckReady checks that teller serves its API and is open for binding
+	CheckReady = "ready"
+	// CheckBindFlood floods teller with binds, which must be bound or refused with a retryable error
+	CheckBindFlood = "bind_flood"
+	// CheckDeposits injects deposits to the bound addresses, which must be paid out
+	CheckDeposits = "deposits"
+	// CheckNodeOutage takes the skycoin node down. The API must keep serving, and the payouts must wait.
+	CheckNodeOutage = "node_outage"
+	// CheckAlerting checks that a runbook rule triggers on the outage
+	CheckAlerting = "alerting"
+	// CheckRecovery brings the skycoin node up. The waiting payout must be paid out, and the alert resolved.
+	CheckRecovery = "recovery"
+
+	requestTimeout = time.Minute
+)
+
+// Config configures a drill
+type Config struct {
+	// Base URL of the teller API, e.g. http://127.0.0.1:7071
+	Addr string
+	// Base URL of the dummy admin interface, dummy.http_addr
+	DummyAddr string
+	// Base URL of the admin panel, whose runbook must alert on the outage. The alerting check is skipped if empty.
+	AdminAddr string
+	// Number of bind requests of the flood, each for a different skycoin address
+	Binds int
+	// Number of bind requests made at the same time
+	Concurrency int
+	// Number of deposits injected to the bound addresses
+	Deposits int
+	// Value of each deposit, in satoshis
+	DepositValue int64
+	// How long the skycoin node stays down before the alerting check
+	Outage time.Duration
+	// Max time to wait for the payouts, the alert and the recovery
+	Timeout time.Duration
+	// How often the statuses are polled while waiting
+	PollInterval time.Duration
+}
+
+// Validate returns an error if the configuration is invalid
+func (c Config) Validate() error {
+	if c.Addr == "" {
+		return errors.New("Addr is required")
+	}
+
+	if c.DummyAddr == "" {
+		return errors.New("DummyAddr is required")
+	}
+
+	if c.Binds < 2 {
+		return errors.New("Binds must be >= 2")
+	}
+
+	if c.Concurrency <= 0 {
+		return errors.New("Concurrency must be > 0")
+	}
+
+	if c.Deposits <= 0 {
+		return errors.New("Deposits must be > 0")
+	}
+
+	if c.DepositValue <= 0 {
+		return errors.New("DepositValue must be > 0")
+	}
+
+	if c.Outage < 0 {
+		return errors.New("Outage can't be negative")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("Timeout must be > 0")
+	}
+
+	if c.PollInterval <= 0 {
+		return errors.New("PollInterval must be > 0")
+	}
+
+	return nil
+}
+
+// Check is the result of a check of the drill
+type Check struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Skipped bool   `json:"skipped,omitempty"`
+	// Duration of the check, in nanoseconds when encoded to JSON
+	Duration time.Duration `json:"duration"`
+	// Detail is what was observed, or why the check failed or was skipped
+	Detail string `json:"detail"`
+}
+
+// Report is the readiness report of a drill. teller is ready if every check passed or was skipped.
+type Report struct {
+	Ready  bool    `json:"ready"`
+	Checks []Check `json:"checks"`
+}
+
+// skipError skips a check
+type skipError struct {
+	reason string
+}
+
+func (e skipError) Error() string {
+	return e.reason
+}
+
+// binding is a deposit address bound by the drill
+type binding struct {
+	skyAddr     string
+	depositAddr string
+}
+
+// drill is the state of a drill, passed from a check to the next
+type drill struct {
+	log  logrus.FieldLogger
+	cfg  Config
+	api  *client.Client
+	http *http.Client
+
+	bound []binding
+	// outage is the binding whose deposit is made while the node is down
+	outage binding
+	// nodeDown is true while the drill holds the node down, wentDown once it took it down
+	nodeDown bool
+	wentDown bool
+	// alerts are the runbook rules triggered by the outage
+	alerts []string
+}
+
+// Run runs a drill. An error is returned if the drill can't run, a failed check fails the Report.
+// The skycoin node is brought up again when the drill ends, even if a check failed.
+func Run(log logrus.FieldLogger, cfg Config) (*Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	d := &drill{
+		log: log.WithField("prefix", "drill"),
+		cfg: cfg,
+		api: client.New(cfg.Addr),
+		http: &http.Client{
+			Timeout: requestTimeout,
+		},
+	}
+	d.cfg.DummyAddr = strings.TrimRight(cfg.DummyAddr, "/")
+	d.cfg.AdminAddr = strings.TrimRight(cfg.AdminAddr, "/")
+
+	defer func() {
+		if d.nodeDown {
+			if err := d.setNodeDown(false); err != nil {
+				d.log.WithError(err).Error("Bringing the skycoin node up failed, it must be brought up by hand")
+			}
+		}
+	}()
+
+	checks := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{CheckReady, d.checkReady},
+		{CheckBindFlood, d.checkBindFlood},
+		{CheckDeposits, d.checkDeposits},
+		{CheckNodeOutage, d.checkNodeOutage},
+		{CheckAlerting, d.checkAlerting},
+		{CheckRecovery, d.checkRecovery},
+	}
+
+	r := &Report{
+		Ready: true,
+	}
+
+	for _, c := range checks {
+		log := d.log.WithField("check", c.name)
+
+		// The other checks can't run against a teller which isn't ready, the first check
+		run := c.run
+		if len(r.Checks) != 0 && !r.Checks[0].Passed {
+			run = func() (string, error) {
+				return "", skipError{"the ready check failed"}
+			}
+		} else {
+			log.Info("Running check")
+		}
+
+		start := time.Now()
+		detail, err := run()
+		check := Check{
+			Name:     c.name,
+			Passed:   err == nil,
+			Duration: time.Since(start),
+			Detail:   detail,
+		}
+
+		switch err.(type) {
+		case nil:
+			log.WithField("detail", detail).Info("Check passed")
+		case skipError:
+			check.Skipped = true
+			check.Detail = err.Error()
+			log.WithField("reason", check.Detail).Info("Check skipped")
+		default:
+			check.Detail = err.Error()
+			r.Ready = false
+			log.WithError(err).Error("Check failed")
+		}
+
+		r.Checks = append(r.Checks, check)
+	}
+
+	return r, nil
+}
+
+// checkReady checks that teller serves its API, accepts BTC and is open for binding, and that the dummy admin
+// interface is reachable
+func (d *drill) checkReady() (string, error) {
+	v, err := d.api.Version()
+	if err != nil {
+		return "", err
+	}
+
+	if !contains(v.CoinTypes, scanner.CoinTypeBTC) {
+		return "", fmt.Errorf("%s deposits are not accepted, the drill deposits %s with the dummy scanner", scanner.CoinTypeBTC, scanner.CoinTypeBTC)
+	}
+
+	ps, err := d.api.PublicStatus()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case ps.Maintenance:
+		return "", errors.New("teller is in maintenance mode")
+	case ps.Ended:
+		return "", errors.New("the event has ended")
+	case ps.CapReached:
+		return "", errors.New("the event's cap is reached")
+	case ps.CoinsDepleted[scanner.CoinTypeBTC]:
+		return "", fmt.Errorf("no %s deposit address is left", scanner.CoinTypeBTC)
+	}
+
+	if _, err := d.nodeStatus(); err != nil {
+		return "", fmt.Errorf("the dummy sender is not reachable, teller must run with dummy.scanner and dummy.sender: %v", err)
+	}
+
+	return fmt.Sprintf("teller %s (%s) accepts %s", v.Version, v.Commit, strings.Join(v.CoinTypes, ", ")), nil
+}
+
+// checkBindFlood binds cfg.Binds deposit addresses, cfg.Concurrency at a time. Each bind must either succeed or be
+// refused with a retryable error, e.g. by the rate limits, and at least two must succeed.
+func (d *drill) checkBindFlood() (string, error) {
+	type result struct {
+		binding  binding
+		duration time.Duration
+		err      error
+	}
+
+	skyAddrs := make(chan string, d.cfg.Binds)
+	for i := 0; i < d.cfg.Binds; i++ {
+		skyAddr, err := newSkyAddress()
+		if err != nil {
+			return "", err
+		}
+		skyAddrs <- skyAddr
+	}
+	close(skyAddrs)
+
+	results := make(chan result, d.cfg.Binds)
+	var wg sync.WaitGroup
+	for i := 0; i < d.cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for skyAddr := range skyAddrs {
+				start := time.Now()
+				rsp, err := d.api.Bind(skyAddr, scanner.CoinTypeBTC)
+				r := result{
+					duration: time.Since(start),
+					err:      err,
+				}
+				if err == nil {
+					r.binding = binding{
+						skyAddr:     skyAddr,
+						depositAddr: rsp.DepositAddress,
+					}
+				}
+				results <- r
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	var lats []time.Duration
+	refused := make(map[string]int)
+	var failed int
+	var failure error
+	for r := range results {
+		switch err := r.err.(type) {
+		case nil:
+			d.bound = append(d.bound, r.binding)
+			lats = append(lats, r.duration)
+		case *client.Error:
+			if err.Temporary() {
+				code := err.Code
+				if code == "" {
+					code = strconv.Itoa(err.StatusCode)
+				}
+				refused[code]++
+				continue
+			}
+			failed++
+			failure = err
+		default:
+			failed++
+			failure = err
+		}
+	}
+
+	detail := fmt.Sprintf("%d of %d bound", len(d.bound), d.cfg.Binds)
+	if len(refused) != 0 {
+		var codes []string
+		for code, n := range refused {
+			codes = append(codes, fmt.Sprintf("%s %d", code, n))
+		}
+		sort.Strings(codes)
+		detail += fmt.Sprintf(", refused: %s", strings.Join(codes, ", "))
+	}
+	if len(lats) != 0 {
+		sort.Slice(lats, func(i, j int) bool {
+			return lats[i] < lats[j]
+		})
+		detail += fmt.Sprintf(", latency p50 %s, p99 %s", percentile(lats, 0.5), percentile(lats, 0.99))
+	}
+
+	if failed != 0 {
+		return "", fmt.Errorf("%s; %d failed, e.g. %v", detail, failed, failure)
+	}
+
+	if len(d.bound) < 2 {
+		return "", fmt.Errorf("%s; the drill needs 2 bound addresses, exempt its host from the rate limits", detail)
+	}
+
+	return detail, nil
+}
+
+// checkDeposits injects a deposit to each of up to cfg.Deposits bound addresses, keeping one back for the node
+// outage, and waits for them to be paid out
+func (d *drill) checkDeposits() (string, error) {
+	if len(d.bound) < 2 {
+		return "", errors.New("not enough deposit addresses were bound")
+	}
+
+	d.outage = d.bound[len(d.bound)-1]
+	bound := d.bound[:len(d.bound)-1]
+	if len(bound) > d.cfg.Deposits {
+		bound = bound[:d.cfg.Deposits]
+	}
+
+	start := time.Now()
+	for _, b := range bound {
+		if err := d.deposit(b); err != nil {
+			return "", err
+		}
+	}
+
+	if err := d.waitPaidOut(bound); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d deposits paid out in %s", len(bound), time.Since(start)), nil
+}
+
+// checkNodeOutage takes the skycoin node down and makes a deposit for cfg.Outage. Meanwhile, the API must keep
+// serving, and the deposit must not be sent.
+func (d *drill) checkNodeOutage() (string, error) {
+	if d.outage.depositAddr == "" {
+		return "", errors.New("no deposit address was kept back for the outage")
+	}
+
+	if err := d.setNodeDown(true); err != nil {
+		return "", err
+	}
+	d.nodeDown = true
+	d.wentDown = true
+
+	if err := d.deposit(d.outage); err != nil {
+		return "", err
+	}
+
+	var polls int
+	start := time.Now()
+	deadline := start.Add(d.cfg.Outage)
+	for {
+		_, status, err := d.paidOut(d.outage)
+		if err != nil {
+			return "", fmt.Errorf("the API failed while the node was down: %v", err)
+		}
+		polls++
+
+		switch status {
+		case exchange.StatusWaitConfirm.String(), exchange.StatusDone.String():
+			// The runbook's fallback_node action brings the simulated node up
+			down, err := d.nodeStatus()
+			if err != nil {
+				return "", err
+			}
+			if !down {
+				d.nodeDown = false
+				return fmt.Sprintf("the node was switched to its fallback after %s", time.Since(start)), nil
+			}
+			return "", errors.New("the deposit was sent while the node was down")
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Sprintf("the deposit waited in %s for %s, the API served %d status requests", status, d.cfg.Outage, polls), nil
+		}
+
+		time.Sleep(d.cfg.PollInterval)
+	}
+}
+
+// checkAlerting waits for a node_down or send_failures rule of the runbook to trigger
+func (d *drill) checkAlerting() (string, error) {
+	if d.cfg.AdminAddr == "" {
+		return "", skipError{"no admin panel address"}
+	}
+
+	if !d.wentDown {
+		return "", skipError{"the node was not taken down"}
+	}
+
+	start := time.Now()
+	deadline := start.Add(d.cfg.Timeout)
+	for {
+		var status runbook.Status
+		if err := d.getJSON(d.cfg.AdminAddr+"/api/runbook", &status); err != nil {
+			return "", err
+		}
+
+		var details []string
+		for _, r := range status.Rules {
+			if !r.Triggered {
+				continue
+			}
+
+			switch r.Condition {
+			case runbook.ConditionNodeDown, runbook.ConditionSendFailures:
+			default:
+				continue
+			}
+
+			d.alerts = append(d.alerts, r.Name)
+
+			var actions []string
+			for _, a := range r.Actions {
+				actions = append(actions, a.Type)
+			}
+			details = append(details, fmt.Sprintf("%s (%s: %s)", r.Name, r.Condition, strings.Join(actions, ", ")))
+		}
+
+		if len(details) != 0 {
+			detail := fmt.Sprintf("triggered after %s: %s", time.Since(start), strings.Join(details, "; "))
+			if status.DryRun {
+				detail += ", in dry run"
+			}
+			return detail, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return "", fmt.Errorf("no %s or %s rule triggered in %s", runbook.ConditionNodeDown, runbook.ConditionSendFailures, d.cfg.Timeout)
+		}
+
+		time.Sleep(d.cfg.PollInterval)
+	}
+}
+
+// checkRecovery brings the skycoin node up, and waits for the outage's deposit to be paid out, the alerts to be
+// resolved and teller to be open for binding
+func (d *drill) checkRecovery() (string, error) {
+	if d.outage.depositAddr == "" {
+		return "", errors.New("no deposit was made during the outage")
+	}
+
+	if d.nodeDown {
+		if err := d.setNodeDown(false); err != nil {
+			return "", err
+		}
+		d.nodeDown = false
+	}
+
+	start := time.Now()
+	if err := d.waitPaidOut([]binding{d.outage}); err != nil {
+		return "", fmt.Errorf("%v, check whether the runbook paused a subsystem", err)
+	}
+	detail := fmt.Sprintf("the deposit was paid out %s after the node came up", time.Since(start))
+
+	if len(d.alerts) != 0 {
+		if err := d.waitResolved(); err != nil {
+			return "", err
+		}
+		detail += fmt.Sprintf(", the alerts resolved after %s", time.Since(start))
+	}
+
+	ps, err := d.api.PublicStatus()
+	if err != nil {
+		return "", err
+	}
+	if ps.Maintenance {
+		return "", errors.New("teller stayed in maintenance mode")
+	}
+
+	return detail, nil
+}
+
+// waitPaidOut waits up to cfg.Timeout for the deposits of bound to be paid out, confirming the payouts
+func (d *drill) waitPaidOut(bound []binding) error {
+	deadline := time.Now().Add(d.cfg.Timeout)
+	pending := bound
+	for {
+		if err := d.confirmPayouts(); err != nil {
+			return err
+		}
+
+		var waiting []binding
+		var status string
+		for _, b := range pending {
+			paid, s, err := d.paidOut(b)
+			if err != nil {
+				return err
+			}
+			if !paid {
+				waiting = append(waiting, b)
+				status = s
+			}
+		}
+		pending = waiting
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%d of %d deposits were paid out in %s, e.g. %s is %s", len(bound)-len(pending), len(bound), d.cfg.Timeout, pending[0].skyAddr, status)
+		}
+
+		time.Sleep(d.cfg.PollInterval)
+	}
+}
+
+// waitResolved waits up to cfg.Timeout for the triggered alerts to be resolved
+func (d *drill) waitResolved() error {
+	alerts := make(map[string]struct{}, len(d.alerts))
+	for _, name := range d.alerts {
+		alerts[name] = struct{}{}
+	}
+
+	deadline := time.Now().Add(d.cfg.Timeout)
+	for {
+		var status runbook.Status
+		if err := d.getJSON(d.cfg.AdminAddr+"/api/runbook", &status); err != nil {
+			return err
+		}
+
+		var triggered []string
+		for _, r := range status.Rules {
+			if _, ok := alerts[r.Name]; ok && r.Triggered {
+				triggered = append(triggered, r.Name)
+			}
+		}
+
+		if len(triggered) == 0 {
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("the alerts %s were not resolved in %s", strings.Join(triggered, ", "), d.cfg.Timeout)
+		}
+
+		time.Sleep(d.cfg.PollInterval)
+	}
+}
+
+// paidOut returns true if the deposit to b was paid out, and the status of its skycoin address' latest deposit
+func (d *drill) paidOut(b binding) (bool, string, error) {
+	statuses, err := d.api.Status(b.skyAddr)
+	if err != nil {
+		return false, "", err
+	}
+
+	status := exchange.StatusWaitDeposit.String()
+	var seq uint64
+	for _, s := range statuses {
+		if s.Status == exchange.StatusDone.String() {
+			return true, s.Status, nil
+		}
+		if s.Seq >= seq {
+			seq = s.Seq
+			status = s.Status
+		}
+	}
+
+	return false, status, nil
+}
+
+// deposit injects a deposit to b with the dummy scanner
+func (d *drill) deposit(b binding) error {
+	tx, err := randHex(32)
+	if err != nil {
+		return err
+	}
+
+	return d.postForm(d.cfg.DummyAddr+"/dummy/scanner/deposit", url.Values{
+		"coin":   {scanner.CoinTypeBTC},
+		"addr":   {b.depositAddr},
+		"value":  {strconv.FormatInt(d.cfg.DepositValue, 10)},
+		"height": {"1"},
+		"tx":     {tx},
+		"n":      {"0"},
+	}, nil)
+}
+
+// dummyTransaction is a payout of the dummy sender, from /dummy/sender/broadcasts
+type dummyTransaction struct {
+	Txid      string `json:"txid"`
+	Confirmed bool   `json:"confirmed"`
+}
+
+// confirmPayouts confirms the payouts broadcast by the dummy sender
+func (d *drill) confirmPayouts() error {
+	var txns []dummyTransaction
+	if err := d.getJSON(d.cfg.DummyAddr+"/dummy/sender/broadcasts", &txns); err != nil {
+		return err
+	}
+
+	for _, txn := range txns {
+		if txn.Confirmed {
+			continue
+		}
+
+		if err := d.postForm(d.cfg.DummyAddr+"/dummy/sender/confirm", url.Values{
+			"txid": {txn.Txid},
+		}, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dummyNode is the state of the dummy sender's simulated skycoin node, from /dummy/sender/node
+type dummyNode struct {
+	Down bool `json:"down"`
+}
+
+// nodeStatus returns true if the simulated skycoin node is down
+func (d *drill) nodeStatus() (bool, error) {
+	var node dummyNode
+	if err := d.getJSON(d.cfg.DummyAddr+"/dummy/sender/node", &node); err != nil {
+		return false, err
+	}
+	return node.Down, nil
+}
+
+// setNodeDown takes the simulated skycoin node down, or brings it up
+func (d *drill) setNodeDown(down bool) error {
+	d.log.WithField("down", down).Info("Setting the skycoin node state")
+	return d.postForm(d.cfg.DummyAddr+"/dummy/sender/node", url.Values{
+		"down": {strconv.FormatBool(down)},
+	}, nil)
+}
+
+// getJSON makes a GET request, and decodes the JSON response into v
+func (d *drill) getJSON(u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	return d.do(req, v)
+}
+
+// postForm makes a POST request of a form, and decodes the JSON response into v, if not nil
+func (d *drill) postForm(u string, form url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return d.do(req, v)
+}
+
+// do makes a request, and returns an error if the response isn't a 200 OK
+func (d *drill) do(req *http.Request, v interface{}) error {
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if v == nil {
+		_, err := io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// newSkyAddress returns a random skycoin address, so that the addresses bound by a drill aren't bound by the
+// previous drills
+func newSkyAddress() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return cipher.Address{
+		Key: cipher.HashRipemd160(b),
+	}.String(), nil
+}
+
+func randHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// percentile returns the p-th percentile of sorted, by the nearest rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func contains(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
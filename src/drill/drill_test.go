@@ -0,0 +1,469 @@
+package drill
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/client"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/runbook"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/teller"
+	"github.com/skycoin/teller/src/util/testutil"
+	"github.com/skycoin/teller/src/version"
+)
+
+// fakeTeller serves the API, dummy admin interface and runbook of a staging teller.
+// A deposit is sent when its status is requested while the node is up, and done once its payout is confirmed.
+type fakeTeller struct {
+	sync.Mutex
+	coinTypes []string
+	// Binds after which the binds are rate limited, 0 for no limit
+	bindLimit int
+	// sendWhileDown sends the deposits while the node is down
+	sendWhileDown bool
+
+	binds    int
+	bound    map[string]string // deposit address to skycoin address
+	statuses map[string]string // skycoin address to the status of its deposit
+	txids    map[string]string // skycoin address to the txid of its payout
+	txns     map[string]bool   // txid to confirmed
+	nodeDown bool
+}
+
+func newFakeTeller() *fakeTeller {
+	return &fakeTeller{
+		coinTypes: []string{"BTC"},
+		bound:     make(map[string]string),
+		statuses:  make(map[string]string),
+		txids:     make(map[string]string),
+		txns:      make(map[string]bool),
+	}
+}
+
+func (f *fakeTeller) handler(t *testing.T) http.Handler {
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		require.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, teller.VersionResponse{
+			Info: version.Info{
+				Version: "1.0.0",
+				Commit:  "abc",
+			},
+			CoinTypes: f.coinTypes,
+		})
+	})
+	mux.HandleFunc("/api/public-status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, teller.PublicStatusResponse{})
+	})
+	mux.HandleFunc("/api/bind", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		f.Lock()
+		defer f.Unlock()
+
+		f.binds++
+		if f.bindLimit != 0 && f.binds > f.bindLimit {
+			w.Header().Set(client.ErrorCodeHeader, "rate_limited")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		depositAddr := fmt.Sprintf("btc%d", f.binds)
+		f.bound[depositAddr] = req["skyaddr"]
+		writeJSON(w, teller.BindResponse{
+			DepositAddress: depositAddr,
+			CoinType:       req["coin_type"],
+		})
+	})
+	mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		skyAddr := r.URL.Query().Get("skyaddr")
+
+		f.Lock()
+		defer f.Unlock()
+
+		status, ok := f.statuses[skyAddr]
+		if !ok {
+			status = exchange.StatusWaitDeposit.String()
+		}
+
+		switch status {
+		case exchange.StatusWaitSend.String():
+			if !f.nodeDown || f.sendWhileDown {
+				txid := fmt.Sprintf("tx-%s", skyAddr)
+				f.txids[skyAddr] = txid
+				f.txns[txid] = false
+				status = exchange.StatusWaitConfirm.String()
+			}
+		case exchange.StatusWaitConfirm.String():
+			if f.txns[f.txids[skyAddr]] {
+				status = exchange.StatusDone.String()
+			}
+		}
+		f.statuses[skyAddr] = status
+
+		writeJSON(w, teller.StatusResponse{
+			Statuses: []teller.DepositStatus{
+				{
+					DepositStatus: exchange.DepositStatus{
+						Seq:    1,
+						Status: status,
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/dummy/scanner/deposit", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "BTC", r.FormValue("coin"))
+		require.Equal(t, "1000000", r.FormValue("value"))
+		require.Len(t, r.FormValue("tx"), 64)
+
+		f.Lock()
+		defer f.Unlock()
+
+		skyAddr, ok := f.bound[r.FormValue("addr")]
+		if !ok {
+			http.Error(w, "not bound", http.StatusBadRequest)
+			return
+		}
+		f.statuses[skyAddr] = exchange.StatusWaitSend.String()
+	})
+	mux.HandleFunc("/dummy/sender/broadcasts", func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+
+		txns := []dummyTransaction{}
+		for txid, confirmed := range f.txns {
+			txns = append(txns, dummyTransaction{
+				Txid:      txid,
+				Confirmed: confirmed,
+			})
+		}
+		writeJSON(w, txns)
+	})
+	mux.HandleFunc("/dummy/sender/confirm", func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+		f.txns[r.FormValue("txid")] = true
+	})
+	mux.HandleFunc("/dummy/sender/node", func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+
+		if r.Method == http.MethodPost {
+			f.nodeDown = r.FormValue("down") == "true"
+		}
+		writeJSON(w, dummyNode{
+			Down: f.nodeDown,
+		})
+	})
+	mux.HandleFunc("/api/runbook", func(w http.ResponseWriter, r *http.Request) {
+		f.Lock()
+		defer f.Unlock()
+
+		writeJSON(w, runbook.Status{
+			Rules: []runbook.RuleStatus{
+				{
+					Name:      "pool_low",
+					Condition: runbook.ConditionPoolLow,
+				},
+				{
+					Name:      "sky_down",
+					Condition: runbook.ConditionNodeDown,
+					Triggered: f.nodeDown,
+					Actions: []runbook.ActionResult{
+						{
+							Type: runbook.ActionPage,
+						},
+					},
+				},
+			},
+		})
+	})
+
+	return mux
+}
+
+// exchangeTeller serves the API and runbook of a staging teller from a real Exchange, with the admin interface of
+// its dummy scanner and sender. Its runbook's send_failures rule triggers while the exchange fails to send.
+type exchangeTeller struct {
+	sync.Mutex
+	exchange *exchange.Exchange
+	mux      *http.ServeMux
+	binds    int
+}
+
+func newExchangeTeller(t *testing.T) (*exchangeTeller, func()) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdownDB := testutil.PrepareDB(t)
+
+	store, err := exchange.NewStore(log, db)
+	require.NoError(t, err)
+
+	dummyScanner := scanner.NewDummyScanner(log)
+	multiplexer := scanner.NewMultiplexer(log)
+	require.NoError(t, multiplexer.AddScanner(dummyScanner, scanner.CoinTypeBTC))
+	go multiplexer.Run()
+
+	dummySender := sender.NewDummySender(log, store)
+
+	e, err := exchange.NewExchange(log, store, multiplexer, dummySender, analytics.Noop{}, exchange.Config{
+		BtcRate:                 "100",
+		TxConfirmationCheckWait: time.Millisecond * 10,
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, e.Run())
+	}()
+
+	mux := http.NewServeMux()
+	dummyScanner.BindHandlers(mux)
+	dummySender.BindHandlers(mux)
+
+	shutdown := func() {
+		e.Shutdown()
+		<-done
+		dummyScanner.Shutdown()
+		multiplexer.Shutdown()
+		shutdownDB()
+	}
+
+	return &exchangeTeller{
+		exchange: e,
+		mux:      mux,
+	}, shutdown
+}
+
+func (f *exchangeTeller) handler(t *testing.T) http.Handler {
+	writeJSON := func(w http.ResponseWriter, v interface{}) {
+		require.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+
+	f.mux.HandleFunc("/api/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, teller.VersionResponse{
+			Info: version.Info{
+				Version: "1.0.0",
+				Commit:  "abc",
+			},
+			CoinTypes: []string{scanner.CoinTypeBTC},
+		})
+	})
+	f.mux.HandleFunc("/api/public-status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, teller.PublicStatusResponse{})
+	})
+	f.mux.HandleFunc("/api/bind", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		f.Lock()
+		f.binds++
+		f.Unlock()
+
+		pub, _ := cipher.GenerateKeyPair()
+		depositAddr := cipher.BitcoinAddressFromPubkey(pub)
+		require.NoError(t, f.exchange.BindAddress(req["skyaddr"], depositAddr, req["coin_type"], ""))
+
+		writeJSON(w, teller.BindResponse{
+			DepositAddress: depositAddr,
+			CoinType:       req["coin_type"],
+		})
+	})
+	f.mux.HandleFunc("/api/status", func(w http.ResponseWriter, r *http.Request) {
+		dss, err := f.exchange.GetDepositStatuses(r.URL.Query().Get("skyaddr"))
+		require.NoError(t, err)
+
+		statuses := []teller.DepositStatus{}
+		for _, ds := range dss {
+			statuses = append(statuses, teller.DepositStatus{
+				DepositStatus: ds,
+			})
+		}
+		writeJSON(w, teller.StatusResponse{
+			Statuses: statuses,
+		})
+	})
+	f.mux.HandleFunc("/api/runbook", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, runbook.Status{
+			Rules: []runbook.RuleStatus{
+				{
+					Name:      "sky_send_failures",
+					Condition: runbook.ConditionSendFailures,
+					Triggered: f.exchange.SendFailures() != 0,
+					Actions: []runbook.ActionResult{
+						{
+							Type: runbook.ActionPage,
+						},
+					},
+				},
+			},
+		})
+	})
+
+	return f.mux
+}
+
+func testConfig(addr string) Config {
+	return Config{
+		Addr:         addr,
+		DummyAddr:    addr,
+		AdminAddr:    addr,
+		Binds:        8,
+		Concurrency:  3,
+		Deposits:     3,
+		DepositValue: 1e6,
+		Outage:       time.Millisecond * 50,
+		Timeout:      time.Second * 5,
+		PollInterval: time.Millisecond * 10,
+	}
+}
+
+func checkNames(r *Report) []string {
+	var names []string
+	for _, c := range r.Checks {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestRun(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	f := newFakeTeller()
+	f.bindLimit = 5
+	srv := httptest.NewServer(f.handler(t))
+	defer srv.Close()
+
+	r, err := Run(log, testConfig(srv.URL))
+	require.NoError(t, err)
+	require.True(t, r.Ready, "%+v", r)
+	require.Equal(t, []string{CheckReady, CheckBindFlood, CheckDeposits, CheckNodeOutage, CheckAlerting, CheckRecovery}, checkNames(r))
+
+	for _, c := range r.Checks {
+		require.True(t, c.Passed, c.Name)
+		require.False(t, c.Skipped, c.Name)
+	}
+
+	require.Equal(t, "teller 1.0.0 (abc) accepts BTC", r.Checks[0].Detail)
+	require.True(t, strings.HasPrefix(r.Checks[1].Detail, "5 of 8 bound, refused: rate_limited 3,"), r.Checks[1].Detail)
+	require.True(t, strings.HasPrefix(r.Checks[2].Detail, "3 deposits paid out"), r.Checks[2].Detail)
+	require.Contains(t, r.Checks[3].Detail, "the deposit waited in waiting_send")
+	require.Contains(t, r.Checks[4].Detail, "sky_down (node_down: page)")
+	require.Contains(t, r.Checks[5].Detail, "the alerts resolved")
+
+	// Every deposit was paid out, and the node is up
+	require.False(t, f.nodeDown)
+	require.Len(t, f.txns, 4)
+}
+
+func TestRunExchange(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// The exchange must retry the payout failed by the dummy node's outage, and pay it out once the node is up
+	f, shutdown := newExchangeTeller(t)
+	defer shutdown()
+	srv := httptest.NewServer(f.handler(t))
+	defer srv.Close()
+
+	r, err := Run(log, testConfig(srv.URL))
+	require.NoError(t, err)
+	require.True(t, r.Ready, "%+v", r)
+
+	for _, c := range r.Checks {
+		require.True(t, c.Passed, "%s: %s", c.Name, c.Detail)
+	}
+
+	require.Contains(t, r.Checks[3].Detail, "the deposit waited in waiting_send")
+	require.Contains(t, r.Checks[4].Detail, "sky_send_failures (send_failures: page)")
+	require.Contains(t, r.Checks[5].Detail, "the alerts resolved")
+	require.Zero(t, f.exchange.SendFailures())
+}
+
+func TestRunFailures(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// The payouts don't wait for the node, and there is no admin panel
+	f := newFakeTeller()
+	f.sendWhileDown = true
+	srv := httptest.NewServer(f.handler(t))
+	defer srv.Close()
+
+	cfg := testConfig(srv.URL)
+	cfg.AdminAddr = ""
+	r, err := Run(log, cfg)
+	require.NoError(t, err)
+	require.False(t, r.Ready)
+
+	outage := r.Checks[3]
+	require.Equal(t, CheckNodeOutage, outage.Name)
+	require.False(t, outage.Passed)
+	require.Equal(t, "the deposit was sent while the node was down", outage.Detail)
+
+	alerting := r.Checks[4]
+	require.True(t, alerting.Skipped)
+	require.Equal(t, "no admin panel address", alerting.Detail)
+
+	// The node is brought up after a failed check
+	require.False(t, f.nodeDown)
+
+	// teller doesn't accept BTC, the other checks are skipped
+	f = newFakeTeller()
+	f.coinTypes = []string{"ETH"}
+	srv2 := httptest.NewServer(f.handler(t))
+	defer srv2.Close()
+
+	r, err = Run(log, testConfig(srv2.URL))
+	require.NoError(t, err)
+	require.False(t, r.Ready)
+	require.False(t, r.Checks[0].Passed)
+	require.Contains(t, r.Checks[0].Detail, "BTC deposits are not accepted")
+	for _, c := range r.Checks[1:] {
+		require.True(t, c.Skipped, c.Name)
+	}
+	require.Zero(t, f.binds)
+
+	// Every bind is refused
+	f = newFakeTeller()
+	f.bindLimit = -1
+	srv3 := httptest.NewServer(f.handler(t))
+	defer srv3.Close()
+
+	r, err = Run(log, testConfig(srv3.URL))
+	require.NoError(t, err)
+	require.False(t, r.Checks[1].Passed)
+	require.Contains(t, r.Checks[1].Detail, "exempt its host from the rate limits")
+	require.False(t, r.Checks[2].Passed)
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := testConfig("http://127.0.0.1:7071")
+	cfg.Binds = 1
+	_, err := Run(log, cfg)
+	require.Error(t, err)
+
+	cfg = testConfig("http://127.0.0.1:7071")
+	cfg.DummyAddr = ""
+	_, err = Run(log, cfg)
+	require.Error(t, err)
+}
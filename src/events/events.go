@@ -0,0 +1,261 @@
+// Package events publishes the deposit pipeline's events to a message queue, such as Kafka or NATS,
+// so that operators can feed teller's activity into their own data platforms.
+// Events are saved to an outbox in the same db transaction as the change they report,
+// and are deleted once the sink acknowledges them, so each event is delivered at least once.
+package events
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/exchange"
+)
+
+const (
+	// SchemaVersion is the version of the Event payloads. It is incremented when a field is removed
+	// or changes meaning. New fields may be added without a new version.
+	SchemaVersion = 1
+
+	// EventDepositStatus is published when a deposit's status changes
+	EventDepositStatus = "deposit_status"
+
+	defaultBatchSize     = 100
+	defaultRetryBackoff  = time.Second
+	maxRetryBackoff      = time.Minute
+	defaultFlushInterval = time.Second * 5
+)
+
+// Event is a published event. ID is unique among the events of a teller db, and is the same
+// when an event is redelivered, so consumers can drop the duplicates.
+type Event struct {
+	ID            string          `json:"id"`
+	SchemaVersion int             `json:"schema_version"`
+	Type          string          `json:"type"`
+	Time          time.Time       `json:"time"`
+	Data          json.RawMessage `json:"data"`
+	// key orders the events of the same deposit, e.g. by Kafka partition
+	key string
+}
+
+// Key returns the key which events that must stay in order share
+func (e Event) Key() string {
+	return e.key
+}
+
+// DepositStatusData is the Data of an EventDepositStatus event
+type DepositStatusData struct {
+	DepositID string `json:"deposit_id"`
+	Seq       uint64 `json:"seq"`
+	CoinType  string `json:"coin_type"`
+	From      string `json:"from"`
+	Status    string `json:"status"`
+	// Deposit amount, in the coin type's smallest unit
+	DepositValue   int64  `json:"deposit_value"`
+	ConversionRate string `json:"conversion_rate"`
+	// SKY sent, in droplets
+	SkySent     uint64 `json:"sky_sent"`
+	RefundValue int64  `json:"refund_value"`
+	Txid        string `json:"txid,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// The addresses are omitted unless Config.IncludeAddresses is set
+	SkyAddress     string `json:"sky_address,omitempty"`
+	DepositAddress string `json:"deposit_address,omitempty"`
+}
+
+// Sink publishes a batch of events. It returns nil only once every event was acknowledged.
+type Sink interface {
+	Publish([]Event) error
+	Close() error
+}
+
+// Config configures a Publisher
+type Config struct {
+	// Include the skycoin and deposit addresses in the events
+	IncludeAddresses bool
+	// Max events published at once
+	BatchSize int
+	// Wait before retrying a failed batch, doubled for each failure up to a minute
+	RetryBackoff time.Duration
+}
+
+// Publisher saves the events to the outbox, and publishes them to a Sink in the background
+type Publisher struct {
+	log    logrus.FieldLogger
+	cfg    Config
+	store  *Store
+	sink   Sink
+	notify chan struct{}
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// NewPublisher creates a Publisher
+func NewPublisher(log logrus.FieldLogger, db *bolt.DB, sink Sink, cfg Config) (*Publisher, error) {
+	store, err := NewStore(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+
+	return &Publisher{
+		log:    log.WithField("prefix", "teller.events"),
+		cfg:    cfg,
+		store:  store,
+		sink:   sink,
+		notify: make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// Watch saves an event of each of the state machine's transitions to the outbox, in the transition's bolt.Tx
+func (p *Publisher) Watch(states *exchange.StateMachine) {
+	states.OnTransitionTx(p.saveTransitionTx)
+	states.OnTransition(func(exchange.Transition) {
+		p.wake()
+	})
+}
+
+func (p *Publisher) saveTransitionTx(tx *bolt.Tx, t exchange.Transition) error {
+	d := DepositStatusData{
+		DepositID:      t.To.DepositID,
+		Seq:            t.To.Seq,
+		CoinType:       t.To.CoinType,
+		From:           t.From.Status.String(),
+		Status:         t.To.Status.String(),
+		DepositValue:   t.To.DepositValue,
+		ConversionRate: t.To.ConversionRate,
+		SkySent:        t.To.SkySent,
+		RefundValue:    t.To.RefundValue,
+		Txid:           t.To.Txid,
+		Error:          t.To.Error,
+	}
+
+	if p.cfg.IncludeAddresses {
+		d.SkyAddress = t.To.SkyAddress
+		d.DepositAddress = t.To.DepositAddress
+	}
+
+	return p.store.AddTx(tx, EventDepositStatus, t.To.DepositID, time.Unix(t.To.UpdatedAt, 0).UTC(), d)
+}
+
+// wake starts publishing the outbox, without waiting for the flush interval
+func (p *Publisher) wake() {
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes the outbox at startup, whenever an event is saved, and every few seconds, until Shutdown is called.
+// A failed batch is retried with a backoff, and the events after it wait, so that they are published in order.
+func (p *Publisher) Run() error {
+	log := p.log.WithField("config", p.cfg)
+	log.Info("Start event publisher")
+	defer log.Info("Event publisher closed")
+	defer close(p.done)
+
+	ticker := time.NewTicker(defaultFlushInterval)
+	defer ticker.Stop()
+
+	backoff := p.cfg.RetryBackoff
+	for {
+		wait := p.publishPending()
+		if wait {
+			log.WithField("backoff", backoff).Info("Retrying event batch after backoff")
+			select {
+			case <-p.quit:
+				return p.close()
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxRetryBackoff {
+				backoff = maxRetryBackoff
+			}
+			continue
+		}
+		backoff = p.cfg.RetryBackoff
+
+		select {
+		case <-p.quit:
+			return p.close()
+		case <-p.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Publisher) close() error {
+	if err := p.sink.Close(); err != nil {
+		p.log.WithError(err).Error("Close event sink failed")
+	}
+	return nil
+}
+
+// publishPending publishes the outbox in batches until it is empty. It returns true if a batch failed.
+func (p *Publisher) publishPending() bool {
+	for {
+		select {
+		case <-p.quit:
+			return false
+		default:
+		}
+
+		events, err := p.store.Pending(p.cfg.BatchSize)
+		if err != nil {
+			p.log.WithError(err).Error("Get pending events failed")
+			return true
+		}
+
+		if len(events) == 0 {
+			return false
+		}
+
+		if err := p.sink.Publish(events); err != nil {
+			p.log.WithError(err).WithFields(logrus.Fields{
+				"events":  len(events),
+				"firstID": events[0].ID,
+			}).Error("Publish events failed")
+			return true
+		}
+
+		last, err := strconv.ParseUint(events[len(events)-1].ID, 10, 64)
+		if err != nil {
+			p.log.WithError(err).Error("Parse event id failed")
+			return true
+		}
+
+		// If this fails, the batch is published again
+		if err := p.store.DeleteThrough(last); err != nil {
+			p.log.WithError(err).Error("Delete published events failed")
+			return true
+		}
+
+		if len(events) < p.cfg.BatchSize {
+			return false
+		}
+	}
+}
+
+// Shutdown stops the Publisher. The events which weren't published stay in the outbox and are published on restart.
+func (p *Publisher) Shutdown() {
+	close(p.quit)
+	<-p.done
+}
+
+// Pending returns the number of events waiting to be published
+func (p *Publisher) Pending() (int, error) {
+	return p.store.Count()
+}
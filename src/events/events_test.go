@@ -0,0 +1,322 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+const (
+	testSkyAddr     = "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+	testDepositAddr = "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS"
+)
+
+type dummySink struct {
+	sync.Mutex
+	err       error
+	published [][]Event
+	closed    bool
+}
+
+func (s *dummySink) Publish(events []Event) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.published = append(s.published, events)
+	return nil
+}
+
+func (s *dummySink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *dummySink) setErr(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = err
+}
+
+func (s *dummySink) events() []Event {
+	s.Lock()
+	defer s.Unlock()
+	var events []Event
+	for _, b := range s.published {
+		events = append(events, b...)
+	}
+	return events
+}
+
+// waitFor polls f until it returns true
+func waitFor(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(time.Second * 5)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestStore(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	s, err := NewStore(db)
+	require.NoError(t, err)
+
+	now := time.Date(2018, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	// More than 255 events, so that the keys must sort numerically
+	for i := 0; i < 300; i++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			return s.AddTx(tx, EventDepositStatus, "key", now, map[string]int{"i": i})
+		})
+		require.NoError(t, err)
+	}
+
+	n, err := s.Count()
+	require.NoError(t, err)
+	require.Equal(t, 300, n)
+
+	events, err := s.Pending(260)
+	require.NoError(t, err)
+	require.Len(t, events, 260)
+	for i, e := range events {
+		require.Equal(t, Event{
+			ID:            strconv.Itoa(i + 1),
+			SchemaVersion: SchemaVersion,
+			Type:          EventDepositStatus,
+			Time:          now,
+			Data:          json.RawMessage(`{"i":` + strconv.Itoa(i) + `}`),
+			key:           "key",
+		}, e)
+	}
+
+	require.NoError(t, s.DeleteThrough(256))
+
+	events, err = s.Pending(100)
+	require.NoError(t, err)
+	require.Len(t, events, 44)
+	require.Equal(t, "257", events[0].ID)
+
+	require.NoError(t, s.DeleteThrough(300))
+	n, err = s.Count()
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// The sequence continues after the outbox is emptied, so IDs aren't reused
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return s.AddTx(tx, EventDepositStatus, "key", now, nil)
+	}))
+	events, err = s.Pending(1)
+	require.NoError(t, err)
+	require.Equal(t, "301", events[0].ID)
+}
+
+func newTestPublisher(t *testing.T, cfg Config) (*Publisher, *exchange.Store, *dummySink, func()) {
+	db, shutdown := testutil.PrepareDB(t)
+
+	log, _ := testutil.NewLogger(t)
+	store, err := exchange.NewStore(log, db)
+	require.NoError(t, err)
+
+	sink := &dummySink{}
+	p, err := NewPublisher(log, db, sink, cfg)
+	require.NoError(t, err)
+	p.Watch(store.StateMachine())
+
+	return p, store, sink, shutdown
+}
+
+func createDeposit(t *testing.T, s *exchange.Store) exchange.DepositInfo {
+	require.NoError(t, s.BindAddress(testSkyAddr, testDepositAddr, scanner.CoinTypeBTC, ""))
+
+	di, err := s.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  testDepositAddr,
+		Amount:   1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, "500")
+	require.NoError(t, err)
+	return di
+}
+
+func TestPublisherDepositStatus(t *testing.T) {
+	p, store, sink, shutdown := newTestPublisher(t, Config{
+		RetryBackoff: time.Millisecond * 10,
+	})
+	defer shutdown()
+
+	// The event is saved with the transition, before the publisher runs
+	di := createDeposit(t, store)
+	n, err := p.Pending()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, p.Run())
+	}()
+
+	waitFor(t, func() bool {
+		return len(sink.events()) == 1
+	})
+
+	e := sink.events()[0]
+	require.Equal(t, "1", e.ID)
+	require.Equal(t, SchemaVersion, e.SchemaVersion)
+	require.Equal(t, EventDepositStatus, e.Type)
+	require.Equal(t, di.DepositID, e.Key())
+	require.Equal(t, time.Unix(di.UpdatedAt, 0).UTC(), e.Time)
+
+	var d DepositStatusData
+	require.NoError(t, json.Unmarshal(e.Data, &d))
+	require.Equal(t, DepositStatusData{
+		DepositID:      "btx1:1",
+		Seq:            di.Seq,
+		CoinType:       scanner.CoinTypeBTC,
+		From:           exchange.StatusWaitDeposit.String(),
+		Status:         exchange.StatusWaitSend.String(),
+		DepositValue:   1e6,
+		ConversionRate: "500",
+	}, d)
+
+	// The addresses are left out by default
+	require.NotContains(t, string(e.Data), testSkyAddr)
+	require.NotContains(t, string(e.Data), testDepositAddr)
+
+	waitFor(t, func() bool {
+		n, err := p.Pending()
+		require.NoError(t, err)
+		return n == 0
+	})
+
+	// While the sink fails, the events wait in the outbox
+	sink.setErr(errors.New("unavailable"))
+	_, err = store.UpdateDepositInfo(di.DepositID, func(di exchange.DepositInfo) exchange.DepositInfo {
+		di.Status = exchange.StatusWaitConfirm
+		di.Txid = "txid"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond * 50)
+	n, err = p.Pending()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Len(t, sink.events(), 1)
+
+	sink.setErr(nil)
+	waitFor(t, func() bool {
+		return len(sink.events()) == 2
+	})
+
+	e = sink.events()[1]
+	require.Equal(t, "2", e.ID)
+	require.NoError(t, json.Unmarshal(e.Data, &d))
+	require.Equal(t, exchange.StatusWaitSend.String(), d.From)
+	require.Equal(t, exchange.StatusWaitConfirm.String(), d.Status)
+	require.Equal(t, "txid", d.Txid)
+	require.Equal(t, uint64(5e6), d.SkySent)
+
+	p.Shutdown()
+	<-done
+	require.True(t, sink.closed)
+}
+
+func TestPublisherIncludeAddresses(t *testing.T) {
+	p, store, _, shutdown := newTestPublisher(t, Config{
+		IncludeAddresses: true,
+	})
+	defer shutdown()
+
+	createDeposit(t, store)
+
+	events, err := p.store.Pending(10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+
+	var d DepositStatusData
+	require.NoError(t, json.Unmarshal(events[0].Data, &d))
+	require.Equal(t, testSkyAddr, d.SkyAddress)
+	require.Equal(t, testDepositAddr, d.DepositAddress)
+}
+
+func TestPublisherBatches(t *testing.T) {
+	p, _, sink, shutdown := newTestPublisher(t, Config{
+		BatchSize: 2,
+	})
+	defer shutdown()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.store.db.Update(func(tx *bolt.Tx) error {
+			return p.store.AddTx(tx, EventDepositStatus, "key", time.Now(), nil)
+		}))
+	}
+
+	require.False(t, p.publishPending())
+	require.Len(t, sink.published, 3)
+	require.Len(t, sink.published[0], 2)
+	require.Len(t, sink.published[2], 1)
+
+	var ids []string
+	for _, e := range sink.events() {
+		ids = append(ids, e.ID)
+	}
+	require.Equal(t, []string{"1", "2", "3", "4", "5"}, ids)
+}
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink(config.Events{
+		Sink: config.EventsSinkNone,
+	})
+	require.NoError(t, err)
+	require.Nil(t, sink)
+
+	sink, err = NewSink(config.Events{
+		Sink: config.EventsSinkKafka,
+		Kafka: config.EventsKafka{
+			RESTProxyURL: "http://localhost:8082",
+			Topic:        "teller-events",
+		},
+	})
+	require.NoError(t, err)
+	require.IsType(t, &KafkaSink{}, sink)
+
+	sink, err = NewSink(config.Events{
+		Sink: config.EventsSinkNATS,
+		NATS: config.EventsNATS{
+			URL:     "nats://localhost",
+			Subject: "teller.events",
+		},
+	})
+	require.NoError(t, err)
+	require.IsType(t, &NATSSink{}, sink)
+
+	_, err = NewSink(config.Events{
+		Sink: "foo",
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,381 @@
+package events
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	natsDefaultPort    = "4222"
+	natsDefaultTimeout = time.Second * 10
+	natsReadBuffer     = 32 * 1024
+	// natsMaxMsg bounds the size of a message from the server
+	natsMaxMsg = 1024 * 1024
+)
+
+// NATSSink publishes events to a NATS JetStream stream over the NATS protocol.
+// Each event is published to <subject>.<type>, and only counts as published once JetStream acknowledges it.
+// The events carry a Nats-Msg-Id of the subject and their ID, so JetStream drops the duplicates
+// of a batch which is published again within the stream's duplicate window.
+type NATSSink struct {
+	sync.Mutex
+	addr       string
+	tls        bool
+	serverName string
+	user       string
+	pass       string
+	token      string
+	subject    string
+	timeout    time.Duration
+
+	conn  net.Conn
+	r     *bufio.Reader
+	inbox string
+}
+
+// NewNATSSink creates a NATSSink. rawURL is a nats:// or tls:// URL, with the user and password in it if required.
+// token is the auth token, if the server requires one. timeout bounds connecting and publishing a batch.
+func NewNATSSink(rawURL, subject, token string, timeout time.Duration) (*NATSSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, errors.New("NATS URL must be a nats:// or tls:// URL")
+	}
+
+	if u.Hostname() == "" {
+		return nil, errors.New("NATS URL host missing")
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = natsDefaultPort
+	}
+
+	if subject == "" {
+		return nil, errors.New("NATS subject missing")
+	}
+
+	if timeout <= 0 {
+		timeout = natsDefaultTimeout
+	}
+
+	s := &NATSSink{
+		addr:       net.JoinHostPort(u.Hostname(), port),
+		tls:        u.Scheme == "tls",
+		serverName: u.Hostname(),
+		token:      token,
+		subject:    subject,
+		timeout:    timeout,
+	}
+
+	if u.User != nil {
+		s.user = u.User.Username()
+		s.pass, _ = u.User.Password()
+	}
+
+	return s, nil
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+	Headers     bool `json:"headers"`
+}
+
+type natsConnect struct {
+	Verbose      bool   `json:"verbose"`
+	Pedantic     bool   `json:"pedantic"`
+	Headers      bool   `json:"headers"`
+	NoResponders bool   `json:"no_responders"`
+	Name         string `json:"name"`
+	Lang         string `json:"lang"`
+	Protocol     int    `json:"protocol"`
+	User         string `json:"user,omitempty"`
+	Pass         string `json:"pass,omitempty"`
+	AuthToken    string `json:"auth_token,omitempty"`
+}
+
+type natsPubAck struct {
+	Stream string `json:"stream"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// connect connects and authenticates to the server, and subscribes to the inbox of the acknowledgements
+func (s *NATSSink) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	line, err := readNATSLine(bufio.NewReaderSize(conn, natsReadBuffer))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("Unexpected NATS greeting %q", line)
+	}
+
+	var info natsInfo
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		conn.Close()
+		return fmt.Errorf("Invalid NATS INFO: %v", err)
+	}
+
+	if s.tls || info.TLSRequired {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName: s.serverName,
+		})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+	}
+
+	if !info.Headers {
+		conn.Close()
+		return errors.New("NATS server does not support headers, which JetStream requires")
+	}
+
+	c, err := json.Marshal(natsConnect{
+		Headers:      true,
+		NoResponders: true,
+		Name:         "teller",
+		Lang:         "go",
+		Protocol:     1,
+		User:         s.user,
+		Pass:         s.pass,
+		AuthToken:    s.token,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	inbox, err := newInbox()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s.* 1\r\nPING\r\n", c, inbox); err != nil {
+		conn.Close()
+		return err
+	}
+
+	r := bufio.NewReaderSize(conn, natsReadBuffer)
+	for {
+		line, err := readNATSLine(r)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+
+		switch {
+		case line == "PONG":
+			s.conn = conn
+			s.r = r
+			s.inbox = inbox
+			return nil
+		case strings.HasPrefix(line, "-ERR"):
+			conn.Close()
+			return fmt.Errorf("NATS connect failed: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+func newInbox() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_INBOX." + hex.EncodeToString(b), nil
+}
+
+func readNATSLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Publish publishes the events, then waits until JetStream acknowledged each of them
+func (s *NATSSink) Publish(events []Event) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.publish(events); err != nil {
+		s.closeConn()
+		return err
+	}
+
+	return nil
+}
+
+func (s *NATSSink) publish(events []Event) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	if err := s.conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(s.conn)
+	for i, e := range events {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		hdr := "NATS/1.0\r\nNats-Msg-Id: " + s.subject + ":" + e.ID + "\r\n\r\n"
+		if _, err := fmt.Fprintf(w, "HPUB %s.%s %s.%d %d %d\r\n%s%s\r\n", s.subject, e.Type, s.inbox, i, len(hdr), len(hdr)+len(payload), hdr, payload); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	acked := make([]bool, len(events))
+	for n := 0; n < len(events); {
+		line, err := readNATSLine(s.r)
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PING":
+			if _, err := io.WriteString(s.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+			continue
+		case "-ERR":
+			return fmt.Errorf("NATS publish failed: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		case "MSG", "HMSG":
+		default:
+			continue
+		}
+
+		subject, hdrLen, totalLen, err := parseNATSMsg(fields)
+		if err != nil {
+			return err
+		}
+
+		msg := make([]byte, totalLen+2)
+		if _, err := io.ReadFull(s.r, msg); err != nil {
+			return err
+		}
+		hdr, payload := string(msg[:hdrLen]), msg[hdrLen:totalLen]
+
+		i, err := strconv.Atoi(strings.TrimPrefix(subject, s.inbox+"."))
+		if err != nil || i < 0 || i >= len(events) {
+			// Not a reply to this batch
+			continue
+		}
+
+		// With no_responders, the server replies with a 503 status if no stream captures the subject
+		if strings.HasPrefix(hdr, "NATS/1.0 503") {
+			return fmt.Errorf("No JetStream stream captures subject %s.%s", s.subject, events[i].Type)
+		}
+
+		var ack natsPubAck
+		if err := json.Unmarshal(payload, &ack); err != nil {
+			return fmt.Errorf("Invalid JetStream acknowledgement: %v", err)
+		}
+		if ack.Error != nil {
+			return fmt.Errorf("JetStream refused event %s: %s (%d)", events[i].ID, ack.Error.Description, ack.Error.Code)
+		}
+		if ack.Stream == "" {
+			return fmt.Errorf("JetStream acknowledgement of event %s has no stream", events[i].ID)
+		}
+
+		if !acked[i] {
+			acked[i] = true
+			n++
+		}
+	}
+
+	return nil
+}
+
+// parseNATSMsg parses a "MSG <subject> <sid> [reply] <bytes>" or "HMSG <subject> <sid> [reply] <hdr bytes> <bytes>" line
+func parseNATSMsg(fields []string) (subject string, hdrLen, totalLen int, err error) {
+	invalid := fmt.Errorf("Invalid NATS %s line", fields[0])
+
+	n := len(fields)
+	if fields[0] == "MSG" {
+		if n != 4 && n != 5 {
+			return "", 0, 0, invalid
+		}
+		totalLen, err = strconv.Atoi(fields[n-1])
+		if err != nil || totalLen < 0 || totalLen > natsMaxMsg {
+			return "", 0, 0, invalid
+		}
+		return fields[1], 0, totalLen, nil
+	}
+
+	if n != 5 && n != 6 {
+		return "", 0, 0, invalid
+	}
+	hdrLen, err = strconv.Atoi(fields[n-2])
+	if err != nil {
+		return "", 0, 0, invalid
+	}
+	totalLen, err = strconv.Atoi(fields[n-1])
+	if err != nil || hdrLen < 0 || totalLen < hdrLen || totalLen > natsMaxMsg {
+		return "", 0, 0, invalid
+	}
+	return fields[1], hdrLen, totalLen, nil
+}
+
+func (s *NATSSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close() // nolint: errcheck
+		s.conn = nil
+		s.r = nil
+	}
+}
+
+// Close closes the connection
+func (s *NATSSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.closeConn()
+	return nil
+}
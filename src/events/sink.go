@@ -0,0 +1,147 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	kafkaTimeout = time.Second * 30
+	// kafkaResponseLimit bounds how much of a REST Proxy response is read
+	kafkaResponseLimit = 1024 * 1024
+
+	kafkaContentType = "application/vnd.kafka.json.v2+json"
+	kafkaAccept      = "application/vnd.kafka.v2+json"
+)
+
+// NewSink creates the sink configured by events.sink.
+// Returns nil if publishing events is disabled.
+func NewSink(cfg config.Events) (Sink, error) {
+	switch cfg.Sink {
+	case config.EventsSinkNone:
+		return nil, nil
+	case config.EventsSinkKafka:
+		return NewKafkaSink(cfg.Kafka.RESTProxyURL, cfg.Kafka.Topic, cfg.Kafka.User, cfg.Kafka.Pass), nil
+	case config.EventsSinkNATS:
+		return NewNATSSink(cfg.NATS.URL, cfg.NATS.Subject, cfg.NATS.Token, cfg.NATS.Timeout)
+	default:
+		return nil, fmt.Errorf("Invalid events sink \"%s\"", cfg.Sink)
+	}
+}
+
+// KafkaSink produces events to a Kafka topic through a Confluent compatible REST Proxy, with its v2 API.
+// The events of a deposit share its key, so that they are produced to the same partition in order.
+type KafkaSink struct {
+	endpoint string
+	user     string
+	pass     string
+	client   *http.Client
+}
+
+// NewKafkaSink creates a KafkaSink. Events are posted to <restProxyURL>/topics/<topic>.
+// The requests use basic auth if user is set.
+func NewKafkaSink(restProxyURL, topic, user, pass string) *KafkaSink {
+	return &KafkaSink{
+		endpoint: strings.TrimRight(restProxyURL, "/") + "/topics/" + url.PathEscape(topic),
+		user:     user,
+		pass:     pass,
+		client:   httpclient.New(kafkaTimeout),
+	}
+}
+
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value Event  `json:"value"`
+}
+
+type kafkaRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaResponse struct {
+	Offsets []struct {
+		Partition *int    `json:"partition"`
+		Offset    *int64  `json:"offset"`
+		ErrorCode *int    `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+}
+
+// Publish produces the events in a single request, and checks that the REST Proxy produced each of them
+func (s *KafkaSink) Publish(events []Event) error {
+	r := kafkaRequest{
+		Records: make([]kafkaRecord, len(events)),
+	}
+	for i, e := range events {
+		r.Records[i] = kafkaRecord{
+			Key:   e.Key(),
+			Value: e,
+		}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", kafkaContentType)
+	req.Header.Set("Accept", kafkaAccept)
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.pass)
+	}
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(rsp.Body, kafkaResponseLimit))
+	if err != nil {
+		return err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka REST Proxy returned status %d: %s", rsp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var kr kafkaResponse
+	if err := json.Unmarshal(body, &kr); err != nil {
+		return fmt.Errorf("Invalid Kafka REST Proxy response: %v", err)
+	}
+
+	if len(kr.Offsets) != len(events) {
+		return fmt.Errorf("Kafka REST Proxy returned %d offsets for %d events", len(kr.Offsets), len(events))
+	}
+
+	for i, o := range kr.Offsets {
+		if o.ErrorCode != nil || o.Error != nil || o.Offset == nil {
+			var reason string
+			if o.Error != nil {
+				reason = *o.Error
+			}
+			return fmt.Errorf("Kafka REST Proxy failed to produce event %s: %s", events[i].ID, reason)
+		}
+	}
+
+	return nil
+}
+
+// Close does nothing
+func (s *KafkaSink) Close() error {
+	return nil
+}
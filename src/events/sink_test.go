@@ -0,0 +1,106 @@
+package events
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []Event {
+	now := time.Date(2018, 3, 2, 12, 0, 0, 0, time.UTC)
+	return []Event{
+		{
+			ID:            "1",
+			SchemaVersion: SchemaVersion,
+			Type:          EventDepositStatus,
+			Time:          now,
+			Data:          json.RawMessage(`{"deposit_id":"btx1:1"}`),
+			key:           "btx1:1",
+		},
+		{
+			ID:            "2",
+			SchemaVersion: SchemaVersion,
+			Type:          EventDepositStatus,
+			Time:          now,
+			Data:          json.RawMessage(`{"deposit_id":"btx2:0"}`),
+			key:           "btx2:0",
+		},
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		rsp    string
+		err    string
+	}{
+		{
+			name:   "produced",
+			status: http.StatusOK,
+			rsp:    `{"offsets":[{"partition":0,"offset":10},{"partition":1,"offset":4}]}`,
+		},
+		{
+			name:   "record failed",
+			status: http.StatusOK,
+			rsp:    `{"offsets":[{"partition":0,"offset":10},{"error_code":50002,"error":"Leader not available"}]}`,
+			err:    "Kafka REST Proxy failed to produce event 2: Leader not available",
+		},
+		{
+			name:   "missing offsets",
+			status: http.StatusOK,
+			rsp:    `{"offsets":[{"partition":0,"offset":10}]}`,
+			err:    "Kafka REST Proxy returned 1 offsets for 2 events",
+		},
+		{
+			name:   "topic not found",
+			status: http.StatusNotFound,
+			rsp:    `{"error_code":40401,"message":"Topic not found."}`,
+			err:    `Kafka REST Proxy returned status 404: {"error_code":40401,"message":"Topic not found."}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req kafkaRequest
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, http.MethodPost, r.Method)
+				require.Equal(t, "/topics/teller-events", r.URL.Path)
+				require.Equal(t, kafkaContentType, r.Header.Get("Content-Type"))
+				require.Equal(t, kafkaAccept, r.Header.Get("Accept"))
+
+				user, pass, ok := r.BasicAuth()
+				require.True(t, ok)
+				require.Equal(t, "teller", user)
+				require.Equal(t, "secret", pass)
+
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.NoError(t, json.Unmarshal(body, &req))
+
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.rsp)) // nolint: errcheck
+			}))
+			defer srv.Close()
+
+			s := NewKafkaSink(srv.URL+"/", "teller-events", "teller", "secret")
+			err := s.Publish(testEvents())
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Len(t, req.Records, 2)
+			require.Equal(t, "btx1:1", req.Records[0].Key)
+			require.Equal(t, "1", req.Records[0].Value.ID)
+			require.Equal(t, "btx2:0", req.Records[1].Key)
+			require.Equal(t, json.RawMessage(`{"deposit_id":"btx2:0"}`), req.Records[1].Value.Data)
+		})
+	}
+}
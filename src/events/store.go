@@ -0,0 +1,153 @@
+package events
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// OutboxBkt maps an event's sequence number to the event, until it is published
+var OutboxBkt = []byte("event_outbox")
+
+// record is an Event as saved in the outbox
+type record struct {
+	Type string          `json:"type"`
+	Key  string          `json:"key"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Store is the outbox of the events which haven't been published yet
+type Store struct {
+	db *bolt.DB
+}
+
+// NewStore creates a Store
+func NewStore(db *bolt.DB) (*Store, error) {
+	if db == nil {
+		return nil, errors.New("new events Store failed, db is nil")
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(OutboxBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(OutboxBkt, err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db: db,
+	}, nil
+}
+
+// outboxKey is the big endian sequence number, so that the outbox iterates in order
+func outboxKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// AddTx saves an event to the outbox in tx
+func (s *Store) AddTx(tx *bolt.Tx, eventType, key string, t time.Time, data interface{}) error {
+	bkt := tx.Bucket(OutboxBkt)
+	if bkt == nil {
+		return dbutil.NewBucketNotExistErr(OutboxBkt)
+	}
+
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	seq, err := bkt.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	v, err := json.Marshal(record{
+		Type: eventType,
+		Key:  key,
+		Time: t,
+		Data: d,
+	})
+	if err != nil {
+		return err
+	}
+
+	return bkt.Put(outboxKey(seq), v)
+}
+
+// Pending returns the oldest limit events of the outbox
+func (s *Store) Pending(limit int) ([]Event, error) {
+	var events []Event
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(OutboxBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(OutboxBkt)
+		}
+
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil && len(events) < limit; k, v = c.Next() {
+			var r record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			events = append(events, Event{
+				ID:            strconv.FormatUint(binary.BigEndian.Uint64(k), 10),
+				SchemaVersion: SchemaVersion,
+				Type:          r.Type,
+				Time:          r.Time,
+				Data:          r.Data,
+				key:           r.Key,
+			})
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// DeleteThrough deletes the events of the outbox up to and including seq
+func (s *Store) DeleteThrough(seq uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(OutboxBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(OutboxBkt)
+		}
+
+		c := bkt.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= seq; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Count returns the number of events in the outbox
+func (s *Store) Count() (int, error) {
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(OutboxBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(OutboxBkt)
+		}
+		n = bkt.Stats().KeyN
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,154 @@
+package exchange
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// The audit log is the ledger, exported for third party auditors.
+// Like the payout log, each entry is chained to the entry before it by PrevHash, so that an exported
+// page can't be changed without changing the Hash of every later entry. The hashes are computed from
+// the start of the ledger when a page is read, so they only depend on the ledger transactions.
+
+// ErrAuditLogBroken is returned by VerifyAuditLog if the hash chain is broken
+var ErrAuditLogBroken = errors.New("Audit log hash chain is broken")
+
+// AuditLogEntry is a ledger transaction in the audit log
+type AuditLogEntry struct {
+	LedgerTransaction
+	// Hash of the previous entry, empty for the first entry
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// ComputeHash returns the hex encoded SHA256 of the entry's fields, other than Hash, joined by "|".
+// The postings are joined by "," as "<currency>:<account>:<amount>".
+func (e AuditLogEntry) ComputeHash() string {
+	postings := make([]string, len(e.Postings))
+	for i, p := range e.Postings {
+		postings[i] = fmt.Sprintf("%s:%s:%d", p.Currency, p.Account, p.Amount)
+	}
+
+	h := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s|%s|%s|%s", e.Seq, e.Time, e.DepositID, e.Status, strings.Join(postings, ","), e.PrevHash)))
+	return hex.EncodeToString(h[:])
+}
+
+// AuditLogPage is a page of the audit log
+type AuditLogPage struct {
+	// Hash of the entry before the first entry of the page, empty if the page starts at the start of the log.
+	// It is the Hash of the last entry of the previous page.
+	PrevHash string          `json:"prev_hash"`
+	Entries  []AuditLogEntry `json:"entries"`
+	// Cursor requests the next page, it is the Seq of the last entry
+	Cursor uint64 `json:"cursor"`
+	// More is true if there are entries after this page
+	More bool `json:"more"`
+}
+
+// VerifyAuditLog checks the hash chain of consecutive entries, starting after the entry whose hash is prevHash.
+// prevHash is empty if entries starts with the first entry.
+func VerifyAuditLog(entries []AuditLogEntry, prevHash string) error {
+	for _, e := range entries {
+		if e.PrevHash != prevHash || e.ComputeHash() != e.Hash {
+			return ErrAuditLogBroken
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
+
+// SignAuditLogPage returns the hex encoded signature of the SHA256 of an exported page
+func SignAuditLogPage(page []byte, secKey cipher.SecKey) string {
+	return cipher.SignHash(cipher.SumSHA256(page), secKey).Hex()
+}
+
+// VerifyAuditLogPage verifies the signature of an exported page against the hex encoded public key
+// of the secret key it was signed with, and the hash chain of its entries.
+// prevHash is the Hash of the last entry of the previous page, if it is known.
+func VerifyAuditLogPage(page []byte, sig, pubKey, prevHash string) (AuditLogPage, error) {
+	pub, err := cipher.PubKeyFromHex(strings.TrimSpace(pubKey))
+	if err != nil {
+		return AuditLogPage{}, fmt.Errorf("Invalid public key: %v", err)
+	}
+
+	s, err := cipher.SigFromHex(strings.TrimSpace(sig))
+	if err != nil {
+		return AuditLogPage{}, fmt.Errorf("Invalid signature: %v", err)
+	}
+
+	if err := cipher.VerifySignature(pub, s, cipher.SumSHA256(page)); err != nil {
+		return AuditLogPage{}, err
+	}
+
+	var p AuditLogPage
+	if err := json.Unmarshal(page, &p); err != nil {
+		return AuditLogPage{}, err
+	}
+
+	if prevHash != "" && p.PrevHash != prevHash {
+		return AuditLogPage{}, ErrAuditLogBroken
+	}
+
+	if err := VerifyAuditLog(p.Entries, p.PrevHash); err != nil {
+		return AuditLogPage{}, err
+	}
+
+	return p, nil
+}
+
+// GetAuditLogPage returns up to limit audit log entries with a Seq greater than cursor, oldest first
+func (s *Store) GetAuditLogPage(cursor uint64, limit int) (AuditLogPage, error) {
+	page := AuditLogPage{
+		Entries: []AuditLogEntry{},
+		Cursor:  cursor,
+	}
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		var prevHash string
+		return dbutil.ForEach(tx, LedgerBkt, func(k, v []byte) error {
+			if page.More {
+				return nil
+			}
+
+			var e AuditLogEntry
+			if err := json.Unmarshal(v, &e.LedgerTransaction); err != nil {
+				return err
+			}
+
+			e.PrevHash = prevHash
+			e.Hash = e.ComputeHash()
+			prevHash = e.Hash
+
+			switch {
+			case e.Seq <= cursor:
+				page.PrevHash = e.Hash
+			case len(page.Entries) < limit:
+				page.Entries = append(page.Entries, e)
+				page.Cursor = e.Seq
+			default:
+				page.More = true
+			}
+
+			return nil
+		})
+	}); err != nil {
+		return AuditLogPage{}, err
+	}
+
+	return page, nil
+}
+
+// GetAuditLogPage returns up to limit audit log entries with a Seq greater than cursor, oldest first
+func (s *Exchange) GetAuditLogPage(cursor uint64, limit int) (AuditLogPage, error) {
+	return s.store.GetAuditLogPage(cursor, limit)
+}
@@ -0,0 +1,134 @@
+package exchange
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestStoreGetAuditLogPage(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	page, err := s.GetAuditLogPage(0, 10)
+	require.NoError(t, err)
+	require.Equal(t, AuditLogPage{
+		Entries: []AuditLogEntry{},
+	}, page)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+			return s.postLedgerTx(tx, LedgerTransaction{
+				DepositID: fmt.Sprintf("btx%d:1", i),
+				Status:    StatusWaitSend.String(),
+				Postings: []Posting{
+					{Account: AccountDepositsReceived, Currency: scanner.CoinTypeBTC, Amount: 1e6},
+					{Account: AccountConversion, Currency: scanner.CoinTypeBTC, Amount: -1e6},
+				},
+			})
+		}))
+	}
+
+	// The pages are chained by their prev_hash
+	var entries []AuditLogEntry
+	var cursor uint64
+	var prevHash string
+	for {
+		page, err := s.GetAuditLogPage(cursor, 2)
+		require.NoError(t, err)
+		require.Equal(t, prevHash, page.PrevHash)
+		require.NoError(t, VerifyAuditLog(page.Entries, page.PrevHash))
+
+		entries = append(entries, page.Entries...)
+		if !page.More {
+			require.Len(t, page.Entries, 1)
+			break
+		}
+
+		require.Len(t, page.Entries, 2)
+		cursor = page.Cursor
+		prevHash = page.Entries[1].Hash
+	}
+
+	require.Len(t, entries, 5)
+	for i, e := range entries {
+		require.Equal(t, uint64(i+1), e.Seq)
+		require.Equal(t, fmt.Sprintf("btx%d:1", i), e.DepositID)
+	}
+	require.NoError(t, VerifyAuditLog(entries, ""))
+
+	// The hashes don't depend on the page size
+	page, err = s.GetAuditLogPage(0, 10)
+	require.NoError(t, err)
+	require.Equal(t, entries, page.Entries)
+	require.Equal(t, uint64(5), page.Cursor)
+	require.False(t, page.More)
+
+	// Past the end of the log
+	page, err = s.GetAuditLogPage(5, 10)
+	require.NoError(t, err)
+	require.Empty(t, page.Entries)
+	require.Equal(t, entries[4].Hash, page.PrevHash)
+	require.Equal(t, uint64(5), page.Cursor)
+
+	// Changing an entry breaks the chain
+	changed := append([]AuditLogEntry{}, entries...)
+	changed[2].Postings = []Posting{
+		{Account: AccountDepositsReceived, Currency: scanner.CoinTypeBTC, Amount: 2e6},
+		{Account: AccountConversion, Currency: scanner.CoinTypeBTC, Amount: -2e6},
+	}
+	require.Equal(t, ErrAuditLogBroken, VerifyAuditLog(changed, ""))
+
+	// Removing an entry breaks the chain
+	require.Equal(t, ErrAuditLogBroken, VerifyAuditLog(append(append([]AuditLogEntry{}, entries[:2]...), entries[3:]...), ""))
+}
+
+func TestVerifyAuditLogPage(t *testing.T) {
+	pubKey, secKey := cipher.GenerateKeyPair()
+
+	e := AuditLogEntry{
+		LedgerTransaction: LedgerTransaction{
+			Seq:       3,
+			Time:      1520510400,
+			DepositID: "btx1:1",
+			Status:    StatusWaitSend.String(),
+		},
+		PrevHash: "prev",
+	}
+	e.Hash = e.ComputeHash()
+
+	b, err := json.Marshal(AuditLogPage{
+		PrevHash: "prev",
+		Entries:  []AuditLogEntry{e},
+		Cursor:   3,
+	})
+	require.NoError(t, err)
+
+	sig := SignAuditLogPage(b, secKey)
+
+	page, err := VerifyAuditLogPage(b, sig, pubKey.Hex(), "prev")
+	require.NoError(t, err)
+	require.Equal(t, []AuditLogEntry{e}, page.Entries)
+
+	_, err = VerifyAuditLogPage(b, sig, pubKey.Hex(), "")
+	require.NoError(t, err)
+
+	// Not chained to the previous page
+	_, err = VerifyAuditLogPage(b, sig, pubKey.Hex(), "other")
+	require.Equal(t, ErrAuditLogBroken, err)
+
+	// Signed by another key
+	otherPub, _ := cipher.GenerateKeyPair()
+	_, err = VerifyAuditLogPage(b, sig, otherPub.Hex(), "prev")
+	require.Error(t, err)
+
+	_, err = VerifyAuditLogPage(b, "foo", pubKey.Hex(), "prev")
+	require.Error(t, err)
+}
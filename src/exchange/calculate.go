@@ -2,6 +2,7 @@ package exchange
 
 import (
 	"errors"
+	"math"
 	"math/big"
 
 	"github.com/shopspring/decimal"
@@ -11,6 +12,13 @@ import (
 	"github.com/skycoin/teller/src/util/mathutil"
 )
 
+// ErrSkyAmountOverflow is returned if a calculated skycoin amount is too large for the ledger,
+// which holds int64 droplets. The deposit amount or rate must be wrong.
+var ErrSkyAmountOverflow = errors.New("Calculated sky amount overflows")
+
+// maxSkyDroplets is the largest skycoin amount a conversion can return
+var maxSkyDroplets = decimal.New(math.MaxInt64, 0)
+
 // CalculateBtcSkyValue returns the amount of SKY (in droplets) to give for an
 // amount of BTC (in satoshis).
 // Rate is measured in SKY per BTC. It should be a decimal string.
@@ -23,29 +31,11 @@ func CalculateBtcSkyValue(satoshis int64, skyPerBTC string, maxDecimals int) (ui
 		return 0, errors.New("maxDecimals can't be negative")
 	}
 
-	rate, err := ParseRate(skyPerBTC)
-	if err != nil {
-		return 0, err
-	}
-
 	btc := decimal.New(satoshis, 0)
 	btcToSatoshi := decimal.New(SatoshisPerBTC, 0)
 	btc = btc.DivRound(btcToSatoshi, 8)
 
-	sky := btc.Mul(rate)
-	sky = sky.Truncate(int32(maxDecimals))
-
-	skyToDroplets := decimal.New(droplet.Multiplier, 0)
-	droplets := sky.Mul(skyToDroplets)
-
-	amt := droplets.IntPart()
-	if amt < 0 {
-		// This should never occur, but double check before we convert to uint64,
-		// otherwise we would send all the coins due to integer wrapping.
-		return 0, errors.New("calculated sky amount is negative")
-	}
-
-	return uint64(amt), nil
+	return CalculateSkyValue(btc, skyPerBTC, maxDecimals)
 }
 
 // CalculateEthSkyValue returns the amount of SKY (in droplets) to give for an
@@ -58,21 +48,42 @@ func CalculateEthSkyValue(wei *big.Int, skyPerETH string, maxDecimals int) (uint
 	if maxDecimals < 0 {
 		return 0, errors.New("maxDecimals can't be negative")
 	}
-	rate, err := ParseRate(skyPerETH)
-	if err != nil {
-		return 0, err
-	}
 
 	eth := decimal.NewFromBigInt(wei, 0)
 	ethToWei := decimal.New(WeiPerETH, 0)
 	eth = eth.DivRound(ethToWei, 18)
 
-	sky := eth.Mul(rate)
+	return CalculateSkyValue(eth, skyPerETH, maxDecimals)
+}
+
+// CalculateSkyValue returns the amount of SKY (in droplets) to give for an
+// amount of any coin type, in whole coins.
+// Rate is measured in SKY per coin.
+// ErrSkyAmountOverflow is returned if the amount is larger than maxSkyDroplets.
+func CalculateSkyValue(coins decimal.Decimal, skyPerCoin string, maxDecimals int) (uint64, error) {
+	if coins.Sign() < 0 {
+		return 0, errors.New("coins must be greater than or equal to 0")
+	}
+	if maxDecimals < 0 {
+		return 0, errors.New("maxDecimals can't be negative")
+	}
+
+	rate, err := ParseRate(skyPerCoin)
+	if err != nil {
+		return 0, err
+	}
+
+	sky := coins.Mul(rate)
 	sky = sky.Truncate(int32(maxDecimals))
 
 	skyToDroplets := decimal.New(droplet.Multiplier, 0)
 	droplets := sky.Mul(skyToDroplets)
 
+	// IntPart wraps around if the amount doesn't fit in an int64
+	if droplets.GreaterThan(maxSkyDroplets) {
+		return 0, ErrSkyAmountOverflow
+	}
+
 	amt := droplets.IntPart()
 	if amt < 0 {
 		// This should never occur, but double check before we convert to uint64,
@@ -3,9 +3,12 @@ package exchange
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"testing"
+	"testing/quick"
 
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/require"
 )
 
@@ -491,3 +494,122 @@ func TestCalculateEthSkyValue(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculateSkyValueOverflow(t *testing.T) {
+	cases := []struct {
+		name string
+		f    func() (uint64, error)
+		err  error
+	}{
+		{
+			name: "max satoshis at a huge rate",
+			f: func() (uint64, error) {
+				return CalculateBtcSkyValue(math.MaxInt64, "1e30", 0)
+			},
+			err: ErrSkyAmountOverflow,
+		},
+		{
+			name: "max satoshis at the smallest rate",
+			f: func() (uint64, error) {
+				return CalculateBtcSkyValue(math.MaxInt64, "0.00000001", 6)
+			},
+		},
+		{
+			name: "1 satoshi at a huge rate",
+			f: func() (uint64, error) {
+				return CalculateBtcSkyValue(1, "1e30", 0)
+			},
+			err: ErrSkyAmountOverflow,
+		},
+		{
+			name: "1e30 ETH",
+			f: func() (uint64, error) {
+				wei := big.NewInt(1).Exp(big.NewInt(10), big.NewInt(48), nil)
+				return CalculateEthSkyValue(wei, "1", 0)
+			},
+			err: ErrSkyAmountOverflow,
+		},
+		{
+			name: "exactly max droplets",
+			f: func() (uint64, error) {
+				return CalculateSkyValue(decimal.New(math.MaxInt64, -6), "1", 6)
+			},
+		},
+		{
+			name: "one droplet over max",
+			f: func() (uint64, error) {
+				return CalculateSkyValue(decimal.New(math.MaxInt64, -6).Add(decimal.New(1, -6)), "1", 6)
+			},
+			err: ErrSkyAmountOverflow,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := tc.f()
+			if tc.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.Equal(t, tc.err, err)
+				require.Equal(t, uint64(0), result)
+			}
+		})
+	}
+
+	result, err := CalculateSkyValue(decimal.New(math.MaxInt64, -6), "1", 6)
+	require.NoError(t, err)
+	require.Equal(t, uint64(math.MaxInt64), result)
+}
+
+// expectedBtcSkyValue computes CalculateBtcSkyValue for a rate of num*10^-exp with big.Int arithmetic
+func expectedBtcSkyValue(satoshis, num int64, exp, maxDecimals uint) *big.Int {
+	pow := func(n uint) *big.Int {
+		return big.NewInt(1).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+	}
+
+	// sky, truncated to maxDecimals, scaled by 10^maxDecimals
+	v := big.NewInt(1).Mul(big.NewInt(satoshis), big.NewInt(num))
+	v.Mul(v, pow(maxDecimals))
+	v.Quo(v, big.NewInt(1).Mul(big.NewInt(SatoshisPerBTC), pow(exp)))
+
+	// droplets
+	return v.Mul(v, pow(6-maxDecimals))
+}
+
+func TestCalculateBtcSkyValueProperty(t *testing.T) {
+	maxDroplets := big.NewInt(math.MaxInt64)
+
+	f := func(satoshis, num int64, exp, maxDecimals uint8) bool {
+		if satoshis < 0 {
+			satoshis = -(satoshis + 1)
+		}
+		if num < 0 {
+			num = -(num + 1)
+		}
+		if num == 0 {
+			num = 1
+		}
+		e := uint(exp % 19)
+		md := uint(maxDecimals % 7)
+
+		rate := decimal.New(num, -int32(e)).String()
+		result, err := CalculateBtcSkyValue(satoshis, rate, int(md))
+
+		expected := expectedBtcSkyValue(satoshis, num, e, md)
+		if expected.Cmp(maxDroplets) > 0 {
+			if err != ErrSkyAmountOverflow || result != 0 {
+				t.Logf("satoshis=%d rate=%s maxDecimals=%d: expected overflow, got %d %v", satoshis, rate, md, result, err)
+				return false
+			}
+			return true
+		}
+
+		if err != nil || result != expected.Uint64() {
+			t.Logf("satoshis=%d rate=%s maxDecimals=%d: expected %s, got %d %v", satoshis, rate, md, expected, result, err)
+			return false
+		}
+		return true
+	}
+
+	require.NoError(t, quick.Check(f, &quick.Config{MaxCount: 5000}))
+}
@@ -0,0 +1,216 @@
+package exchange
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/errutil"
+)
+
+// CancelledBindingBkt maps a sequence number to a CancelledBinding
+var CancelledBindingBkt = []byte("cancelled_bindings")
+
+var (
+	// ErrBindingNotFound is returned when cancelling a binding which doesn't exist
+	ErrBindingNotFound = errutil.New(errutil.NotFound, "Deposit address is not bound to this skycoin address")
+	// ErrBindingHasDeposits is returned when cancelling a binding whose deposit address has received deposits
+	ErrBindingHasDeposits = errutil.New(errutil.Conflict, "Deposit address has received deposits, the binding can't be cancelled")
+)
+
+// CancelledBinding records a binding cancelled by the owner of its skycoin address
+type CancelledBinding struct {
+	Seq            uint64 `json:"seq"`
+	SkyAddress     string `json:"skycoin_address"`
+	DepositAddress string `json:"deposit_address"`
+	CoinType       string `json:"coin_type"`
+	CancelledAt    int64  `json:"cancelled_at"`
+	// Reused is true if the deposit address was returned to the address pool
+	Reused bool `json:"reused"`
+	// Restored is true if a deposit was received after the cancellation, which restored the binding
+	Restored bool `json:"restored"`
+	// RefundAddress of the binding, restored with it
+	RefundAddress string `json:"refund_address,omitempty"`
+}
+
+// CancelBinding removes the binding of a deposit address to a skycoin address, if the deposit address has
+// received no deposits, and records the cancellation. reused records whether the deposit address will be
+// returned to the address pool. If version is set, the binding is only cancelled if it is still at version,
+// otherwise a VersionConflictErr is returned.
+func (s *Store) CancelBinding(skyAddr, depositAddr, coinType string, version uint64, reused bool, t time.Time) (CancelledBinding, error) {
+	var cb CancelledBinding
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		boundAddr, err := s.getBindAddressTx(tx, depositAddr, coinType)
+		if err != nil {
+			return err
+		}
+
+		if boundAddr != skyAddr {
+			return ErrBindingNotFound
+		}
+
+		bindVersion, err := s.getBindVersionTx(tx, depositAddr, coinType)
+		if err != nil {
+			return err
+		}
+
+		if err := checkVersion("binding", depositAddr, version, bindVersion); err != nil {
+			return err
+		}
+
+		var txs []string
+		if err := dbutil.GetBucketObject(tx, BtcTxsBkt, depositAddr, &txs); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+			default:
+				return err
+			}
+		}
+
+		if len(txs) != 0 {
+			return ErrBindingHasDeposits
+		}
+
+		if err := tx.Bucket(dbutil.ByteJoin(BindAddressBkt, coinType, "_")).Delete([]byte(depositAddr)); err != nil {
+			return err
+		}
+
+		if err := s.incrBindVersionTx(tx, depositAddr, coinType); err != nil {
+			return err
+		}
+
+		refundAddr, err := s.getRefundAddressTx(tx, depositAddr, coinType)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(dbutil.ByteJoin(RefundAddressBkt, coinType, "_")).Delete([]byte(depositAddr)); err != nil {
+			return err
+		}
+
+		addrs, err := s.getSkyBindBtcAddressesTx(tx, skyAddr)
+		if err != nil {
+			return err
+		}
+
+		var remaining []string
+		for _, a := range addrs {
+			if a != depositAddr {
+				remaining = append(remaining, a)
+			}
+		}
+
+		if len(remaining) == 0 {
+			if err := tx.Bucket(SkyDepositSeqsIndexBkt).Delete([]byte(skyAddr)); err != nil {
+				return err
+			}
+		} else if err := dbutil.PutBucketValue(tx, SkyDepositSeqsIndexBkt, skyAddr, remaining); err != nil {
+			return err
+		}
+
+		seq, err := dbutil.NextSequence(tx, CancelledBindingBkt)
+		if err != nil {
+			return err
+		}
+
+		cb = CancelledBinding{
+			Seq:            seq,
+			SkyAddress:     skyAddr,
+			DepositAddress: depositAddr,
+			CoinType:       coinType,
+			CancelledAt:    t.UTC().Unix(),
+			Reused:         reused,
+			RefundAddress:  refundAddr,
+		}
+
+		return dbutil.PutBucketValue(tx, CancelledBindingBkt, ledgerSeqKey(seq), cb)
+	}); err != nil {
+		return CancelledBinding{}, err
+	}
+
+	return cb, nil
+}
+
+// GetCancelledBindings returns the cancelled bindings, oldest first
+func (s *Store) GetCancelledBindings() ([]CancelledBinding, error) {
+	var cbs []CancelledBinding
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		cbs, err = getCancelledBindingsTx(tx)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return cbs, nil
+}
+
+// getCancelledBindingsTx returns the cancelled bindings, oldest first
+func getCancelledBindingsTx(tx *bolt.Tx) ([]CancelledBinding, error) {
+	var cbs []CancelledBinding
+	if err := dbutil.ForEach(tx, CancelledBindingBkt, func(k, v []byte) error {
+		var cb CancelledBinding
+		if err := json.Unmarshal(v, &cb); err != nil {
+			return err
+		}
+		cbs = append(cbs, cb)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return cbs, nil
+}
+
+// restoreCancelledBindingTx binds a deposit address back to the skycoin address of its latest cancelled binding,
+// so that a deposit sent after the cancellation is not lost. It returns the skycoin address,
+// or an empty string if the deposit address has no cancelled binding.
+func (s *Store) restoreCancelledBindingTx(tx *bolt.Tx, depositAddr, coinType string) (string, error) {
+	var last *CancelledBinding
+	if err := dbutil.ForEach(tx, CancelledBindingBkt, func(k, v []byte) error {
+		var cb CancelledBinding
+		if err := json.Unmarshal(v, &cb); err != nil {
+			return err
+		}
+		if cb.DepositAddress == depositAddr && cb.CoinType == coinType {
+			last = &cb
+		}
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	if last == nil {
+		return "", nil
+	}
+
+	s.log.WithField("cancelledBinding", *last).Warn("Deposit received by the address of a cancelled binding, restoring the binding")
+
+	if err := s.bindAddressTx(tx, last.SkyAddress, depositAddr, coinType, last.RefundAddress); err != nil {
+		return "", err
+	}
+
+	last.Restored = true
+	if err := dbutil.PutBucketValue(tx, CancelledBindingBkt, ledgerSeqKey(last.Seq), *last); err != nil {
+		return "", err
+	}
+
+	return last.SkyAddress, nil
+}
+
+// CancelBinding cancels a binding which has received no deposits. reused records whether the caller
+// will return the deposit address to the address pool. The scanner keeps watching the deposit address,
+// and a deposit received after the cancellation restores the binding.
+func (s *Exchange) CancelBinding(skyAddr, depositAddr, coinType string, reused bool) error {
+	cb, err := s.store.CancelBinding(skyAddr, depositAddr, coinType, 0, reused, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s.log.WithField("cancelledBinding", cb).Info("Cancelled binding")
+	s.watcher.changed(skyAddr)
+	s.statuses.changed(skyAddr)
+	return nil
+}
@@ -0,0 +1,115 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestStoreCancelBinding(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr2", scanner.CoinTypeBTC, ""))
+
+	// Not bound to this skycoin address
+	_, err := s.CancelBinding(testSkyAddr2, "btcaddr1", scanner.CoinTypeBTC, 0, false, time.Now())
+	require.Equal(t, ErrBindingNotFound, err)
+
+	_, err = s.CancelBinding(testSkyAddr, "btcaddr3", scanner.CoinTypeBTC, 0, false, time.Now())
+	require.Equal(t, ErrBindingNotFound, err)
+
+	_, err = s.CancelBinding(testSkyAddr, "btcaddr1", scanner.CoinTypeETH, 0, false, time.Now())
+	require.Equal(t, ErrBindingNotFound, err)
+
+	// Received a deposit
+	_, err = s.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr2",
+		Amount:   1e6,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+
+	_, err = s.CancelBinding(testSkyAddr, "btcaddr2", scanner.CoinTypeBTC, 0, false, time.Now())
+	require.Equal(t, ErrBindingHasDeposits, err)
+
+	now := time.Now()
+	cb, err := s.CancelBinding(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, 0, true, now)
+	require.NoError(t, err)
+	require.Equal(t, CancelledBinding{
+		Seq:            1,
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr1",
+		CoinType:       scanner.CoinTypeBTC,
+		CancelledAt:    now.Unix(),
+		Reused:         true,
+	}, cb)
+
+	skyAddr, err := s.GetBindAddress("btcaddr1", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Empty(t, skyAddr)
+
+	addrs, err := s.GetSkyBindAddresses(testSkyAddr)
+	require.NoError(t, err)
+	require.Equal(t, []string{"btcaddr2"}, addrs)
+
+	cbs, err := s.GetCancelledBindings()
+	require.NoError(t, err)
+	require.Equal(t, []CancelledBinding{cb}, cbs)
+
+	// Already cancelled
+	_, err = s.CancelBinding(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, 0, true, now)
+	require.Equal(t, ErrBindingNotFound, err)
+
+	// The deposit address can be bound again
+	require.NoError(t, s.BindAddress(testSkyAddr2, "btcaddr1", scanner.CoinTypeBTC, ""))
+	_, err = s.CancelBinding(testSkyAddr2, "btcaddr1", scanner.CoinTypeBTC, 0, false, now)
+	require.NoError(t, err)
+
+	// The index entry of a skycoin address without bindings is removed
+	addrs, err = s.GetSkyBindAddresses(testSkyAddr2)
+	require.NoError(t, err)
+	require.Nil(t, addrs)
+}
+
+func TestStoreGetOrCreateDepositInfoCancelledBinding(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	_, err := s.CancelBinding(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, 0, false, time.Now())
+	require.NoError(t, err)
+
+	// A deposit after the cancellation restores the binding
+	di, err := s.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr1",
+		Amount:   1e6,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+	require.Equal(t, testSkyAddr, di.SkyAddress)
+
+	skyAddr, err := s.GetBindAddress("btcaddr1", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, testSkyAddr, skyAddr)
+
+	addrs, err := s.GetSkyBindAddresses(testSkyAddr)
+	require.NoError(t, err)
+	require.Equal(t, []string{"btcaddr1"}, addrs)
+
+	cbs, err := s.GetCancelledBindings()
+	require.NoError(t, err)
+	require.Len(t, cbs, 1)
+	require.True(t, cbs[0].Restored)
+}
@@ -0,0 +1,326 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/errutil"
+)
+
+// CapPolicy is how a deposit which exceeds the campaign cap is handled.
+// Teller can't send BTC or ETH, so refunds are recorded in DepositInfo.RefundValue
+// and paid by the operator.
+type CapPolicy string
+
+const (
+	// CapPolicyRefund refunds all of a deposit which doesn't fit under the cap
+	CapPolicyRefund CapPolicy = "refund"
+	// CapPolicyProRata sends skycoin for the part of a deposit which fits under the cap,
+	// and refunds the rest of it
+	CapPolicyProRata CapPolicy = "pro_rata"
+)
+
+// ErrCampaignCapReached is returned when binding after the campaign cap was reached,
+// and recorded as the Error of the deposits held for a refund
+var ErrCampaignCapReached = errutil.New(errutil.Conflict, "Campaign cap reached")
+
+// CampaignCapConfig configures the campaign's hard cap. The cap is disabled if neither limit is set.
+type CampaignCapConfig struct {
+	MaxBTC string // Max BTC raised, decimal string. Empty for no limit
+	MaxSky string // Max skycoin sent, decimal string. Empty for no limit
+	Policy CapPolicy
+}
+
+// CapProgress is the campaign's progress towards its cap.
+// Only deposits which were sent skycoin, or were too small to send anything, count towards the cap.
+type CapProgress struct {
+	// BTC raised net of refunds, in satoshis
+	BTCRaised int64 `json:"btc_raised"`
+	// 0 if the BTC raised is not capped
+	MaxBTC int64 `json:"max_btc"`
+	// Skycoin sent, in droplets
+	SkySent uint64 `json:"sky_sent"`
+	// 0 if the skycoin sent is not capped
+	MaxSky uint64 `json:"max_sky"`
+	// Reached is true once the campaign can't accept any more deposits. Binding is closed then.
+	Reached bool `json:"reached"`
+}
+
+// campaignCap is a parsed CampaignCapConfig
+type campaignCap struct {
+	maxBTC int64  // satoshis, 0 for no limit
+	maxSky uint64 // droplets, 0 for no limit
+	policy CapPolicy
+}
+
+func newCampaignCap(cfg CampaignCapConfig) (campaignCap, error) {
+	var c campaignCap
+
+	if cfg.MaxBTC != "" {
+		btc, err := decimal.NewFromString(cfg.MaxBTC)
+		if err != nil {
+			return campaignCap{}, fmt.Errorf("Invalid campaign cap MaxBTC: %v", err)
+		}
+
+		satoshis := btc.Mul(decimal.New(1, 8))
+		if !satoshis.Equal(satoshis.Truncate(0)) {
+			return campaignCap{}, errors.New("Campaign cap MaxBTC has more than 8 decimal places")
+		}
+		if satoshis.Sign() <= 0 || satoshis.GreaterThan(decimal.New(math.MaxInt64, 0)) {
+			return campaignCap{}, errors.New("Campaign cap MaxBTC is out of range")
+		}
+		c.maxBTC = satoshis.IntPart()
+	}
+
+	if cfg.MaxSky != "" {
+		sky, err := droplet.FromString(cfg.MaxSky)
+		if err != nil {
+			return campaignCap{}, fmt.Errorf("Invalid campaign cap MaxSky: %v", err)
+		}
+		if sky == 0 {
+			return campaignCap{}, errors.New("Campaign cap MaxSky must be greater than zero")
+		}
+		c.maxSky = sky
+	}
+
+	if !c.enabled() {
+		return c, nil
+	}
+
+	switch cfg.Policy {
+	case CapPolicyRefund, CapPolicyProRata:
+		c.policy = cfg.Policy
+	default:
+		return campaignCap{}, fmt.Errorf("Invalid campaign cap policy %q", cfg.Policy)
+	}
+
+	return c, nil
+}
+
+func (c campaignCap) enabled() bool {
+	return c.maxBTC != 0 || c.maxSky != 0
+}
+
+// capCache caches the campaign's progress, so that the binds and polling /api/public-status don't scan
+// the store on each request. The progress only moves when a deposit's status changes, so it is dropped
+// on each deposit status transition.
+type capCache struct {
+	sync.Mutex
+	progress *CapProgress
+	// gen is bumped by each invalidation, so that a read which raced with one isn't cached
+	gen uint64
+}
+
+// get returns the cached progress, reading it with load on a miss. A nil capCache doesn't cache.
+func (c *capCache) get(load func() (CapProgress, error)) (CapProgress, error) {
+	if c == nil {
+		return load()
+	}
+
+	c.Lock()
+	if c.progress != nil {
+		p := *c.progress
+		c.Unlock()
+		return p, nil
+	}
+	gen := c.gen
+	c.Unlock()
+
+	p, err := load()
+	if err != nil {
+		return CapProgress{}, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.gen == gen {
+		c.progress = &p
+	}
+
+	return p, nil
+}
+
+// onTransition is a TransitionHook which drops the cached progress
+func (c *capCache) onTransition(t Transition) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.gen++
+	c.progress = nil
+}
+
+// capProgress returns the campaign's progress towards its cap
+func (s *Exchange) capProgress() (CapProgress, error) {
+	return s.capCache.get(s.loadCapProgress)
+}
+
+// loadCapProgress sums the campaign's progress towards its cap from the deposits in the store
+func (s *Exchange) loadCapProgress() (CapProgress, error) {
+	p := CapProgress{
+		MaxBTC: s.cap.maxBTC,
+		MaxSky: s.cap.maxSky,
+	}
+
+	if err := s.store.ForEachDepositInfo(func(di DepositInfo) bool {
+		return di.Status == StatusWaitConfirm || di.Status == StatusDone
+	}, func(di DepositInfo) error {
+		if di.CoinType == scanner.CoinTypeBTC {
+			p.BTCRaised += di.DepositValue - di.RefundValue
+		}
+		p.SkySent += di.SkySent
+		return nil
+	}); err != nil {
+		return CapProgress{}, err
+	}
+
+	if p.MaxBTC != 0 && p.BTCRaised >= p.MaxBTC {
+		p.Reached = true
+	}
+
+	// Less skycoin than the smallest amount which can be sent is as good as none
+	minSky := uint64(math.Pow10(droplet.Exponent - s.cfg.MaxDecimals))
+	if p.MaxSky != 0 && (p.SkySent >= p.MaxSky || p.MaxSky-p.SkySent < minSky) {
+		p.Reached = true
+	}
+
+	return p, nil
+}
+
+// CapReached returns true if the campaign cap was reached, false if no cap is set
+func (s *Exchange) CapReached() (bool, error) {
+	if !s.cap.enabled() {
+		return false, nil
+	}
+
+	p, err := s.capProgress()
+	if err != nil {
+		return false, err
+	}
+
+	return p.Reached, nil
+}
+
+// capRefund returns the part of a deposit which is refunded because it exceeds the campaign cap,
+// in the same unit as DepositValue. The deposits are capped in the order they are sent, so a
+// deposit which fits under the cap when it is received can still be refunded.
+// Once the cap is reached, all of every deposit is refunded, whatever its coin type.
+func (s *Exchange) capRefund(di DepositInfo) (int64, error) {
+	if !s.cap.enabled() {
+		return 0, nil
+	}
+
+	p, err := s.capProgress()
+	if err != nil {
+		return 0, err
+	}
+
+	if p.Reached {
+		return di.DepositValue, nil
+	}
+
+	accepted := di.DepositValue
+
+	if p.MaxBTC != 0 && di.CoinType == scanner.CoinTypeBTC && accepted > p.MaxBTC-p.BTCRaised {
+		accepted = p.MaxBTC - p.BTCRaised
+	}
+
+	if p.MaxSky != 0 {
+		remaining := p.MaxSky - p.SkySent
+
+		sky, err := s.calculateSkyDroplets(di.CoinType, accepted, di.ConversionRate)
+		if err != nil {
+			return 0, err
+		}
+
+		if sky > remaining {
+			value, err := s.skyCoinValue(di.CoinType, remaining, di.ConversionRate)
+			if err != nil {
+				return 0, err
+			}
+			if value < accepted {
+				accepted = value
+			}
+		}
+	}
+
+	if accepted == di.DepositValue {
+		return 0, nil
+	}
+
+	if s.cap.policy == CapPolicyRefund || accepted == 0 {
+		return di.DepositValue, nil
+	}
+
+	return di.DepositValue - accepted, nil
+}
+
+// skyCoinValue returns the largest deposit amount of a coin type, in the same unit as DepositValue,
+// which converts to no more than droplets at rate
+func (s *Exchange) skyCoinValue(coinType string, droplets uint64, rate string) (int64, error) {
+	coin, err := deposits.GetCoin(coinType)
+	if err != nil {
+		return 0, err
+	}
+
+	r, err := ParseRate(rate)
+	if err != nil {
+		return 0, err
+	}
+
+	sky := decimal.New(int64(droplets), -droplet.Exponent)
+	value := sky.DivRound(r, coin.Decimals+1).Mul(decimal.New(1, coin.Decimals)).Floor().IntPart()
+
+	// DivRound can round up the last place
+	for value > 0 {
+		converted, err := s.calculateSkyDroplets(coinType, value, rate)
+		if err != nil {
+			return 0, err
+		}
+		if converted <= droplets {
+			break
+		}
+		value--
+	}
+
+	return value, nil
+}
+
+// holdForRefund sets the deposit to StatusWaitReview, recording the part of it which exceeds the campaign cap.
+// The operator refunds it, or approves the deposit after raising the cap.
+func (s *Exchange) holdForRefund(di DepositInfo, refund int64) (DepositInfo, error) {
+	log := s.log.WithField("deposit", di).WithField("refundValue", refund).WithField("refundAddress", di.RefundAddress)
+
+	log.WithField("alert", "campaign_cap").WithError(ErrCampaignCapReached).Error("ALERT: deposit exceeds the campaign cap, holding deposit for a refund")
+
+	if di.RefundAddress == "" {
+		log.Warn("Deposit has no refund address, the depositor must be asked where to send the refund")
+	}
+
+	di, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitReview
+		di.Error = ErrCampaignCapReached.Error()
+		di.RefundValue = refund
+		return di
+	})
+	if err != nil {
+		log.WithError(err).Error("UpdateDepositInfo set StatusWaitReview failed")
+		return di, err
+	}
+
+	log.Info("DepositInfo set to StatusWaitReview")
+
+	return di, nil
+}
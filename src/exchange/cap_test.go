@@ -0,0 +1,313 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestNewCampaignCap(t *testing.T) {
+	c, err := newCampaignCap(CampaignCapConfig{})
+	require.NoError(t, err)
+	require.False(t, c.enabled())
+
+	c, err = newCampaignCap(CampaignCapConfig{
+		MaxBTC: "12.5",
+		MaxSky: "1000.5",
+		Policy: CapPolicyProRata,
+	})
+	require.NoError(t, err)
+	require.Equal(t, campaignCap{
+		maxBTC: 1250000000,
+		maxSky: 1000500000,
+		policy: CapPolicyProRata,
+	}, c)
+
+	for _, cfg := range []CampaignCapConfig{
+		{MaxBTC: "foo", Policy: CapPolicyRefund},
+		{MaxBTC: "0", Policy: CapPolicyRefund},
+		{MaxBTC: "-1", Policy: CapPolicyRefund},
+		{MaxBTC: "0.000000001", Policy: CapPolicyRefund},
+		{MaxSky: "0", Policy: CapPolicyRefund},
+		{MaxSky: "0.0000001", Policy: CapPolicyRefund},
+		{MaxSky: "10"},
+		{MaxBTC: "10", Policy: "fcfs"},
+	} {
+		_, err := newCampaignCap(cfg)
+		require.Error(t, err, "%+v", cfg)
+	}
+}
+
+func newCapTestExchange(t *testing.T, cfg CampaignCapConfig) (*Exchange, func()) {
+	db, shutdown := testutil.PrepareDB(t)
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+
+	var err error
+	e.cap, err = newCampaignCap(cfg)
+	require.NoError(t, err)
+
+	return e, shutdown
+}
+
+// handleCapDeposit saves a BTC deposit and handles it once
+func handleCapDeposit(t *testing.T, e *Exchange, n int, satoshis int64) DepositInfo {
+	depositAddr := fmt.Sprintf("btc-addr-%d", n)
+	err := e.store.BindAddress(testSkyAddr, depositAddr, scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	di, err := e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  depositAddr,
+		Amount:   satoshis,
+		Height:   20,
+		Tx:       fmt.Sprintf("btc-tx-%d", n),
+		N:        1,
+		Final:    true,
+	})
+	require.NoError(t, err)
+
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	return di
+}
+
+func TestExchangeCampaignCapProRata(t *testing.T) {
+	e, shutdown := newCapTestExchange(t, CampaignCapConfig{
+		MaxBTC: "1.5",
+		Policy: CapPolicyProRata,
+	})
+	defer shutdown()
+
+	reached, err := e.CapReached()
+	require.NoError(t, err)
+	require.False(t, reached)
+
+	// Deposits under the cap are sent in full
+	di := handleCapDeposit(t, e, 1, 1e8)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(100e6), di.SkySent)
+	require.Zero(t, di.RefundValue)
+
+	// The deposit crossing the cap is partly sent, the rest of it is refunded
+	di = handleCapDeposit(t, e, 2, 1e8)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(50e6), di.SkySent)
+	require.Equal(t, int64(5e7), di.RefundValue)
+
+	reached, err = e.CapReached()
+	require.NoError(t, err)
+	require.True(t, reached)
+
+	// Deposits after the cap was reached are refunded in full
+	di = handleCapDeposit(t, e, 3, 1e7)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Equal(t, ErrCampaignCapReached.Error(), di.Error)
+	require.Equal(t, int64(1e7), di.RefundValue)
+	require.Zero(t, di.SkySent)
+
+	stats, err := e.GetCampaignStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(1.5e8), stats.Raised[scanner.CoinTypeBTC])
+	require.Equal(t, &CapProgress{
+		BTCRaised: 1.5e8,
+		MaxBTC:    1.5e8,
+		SkySent:   150e6,
+		Reached:   true,
+	}, stats.Cap)
+
+	balances, err := e.store.GetLedgerBalances()
+	require.NoError(t, err)
+	require.Equal(t, int64(-6e7), balances[scanner.CoinTypeBTC][AccountRefunds])
+	require.NoError(t, e.store.CheckLedger())
+
+	// Once the cap is raised, an approved deposit is sent
+	e.cap, err = newCampaignCap(CampaignCapConfig{
+		MaxBTC: "2",
+		Policy: CapPolicyProRata,
+	})
+	require.NoError(t, err)
+
+	di, err = e.ApproveDeposit(di.DepositID, "", 0)
+	require.NoError(t, err)
+	require.Zero(t, di.RefundValue)
+	<-e.depositChan
+
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(10e6), di.SkySent)
+	require.Zero(t, di.RefundValue)
+	require.NoError(t, e.store.CheckLedger())
+}
+
+func TestExchangeCampaignCapRefund(t *testing.T) {
+	e, shutdown := newCapTestExchange(t, CampaignCapConfig{
+		MaxSky: "150",
+		Policy: CapPolicyRefund,
+	})
+	defer shutdown()
+
+	di := handleCapDeposit(t, e, 1, 1e8)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+
+	// A deposit which doesn't fit under the cap is refunded in full
+	di = handleCapDeposit(t, e, 2, 1e8)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Equal(t, int64(1e8), di.RefundValue)
+
+	// A deposit which fits is still sent
+	di = handleCapDeposit(t, e, 3, 5e7)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(50e6), di.SkySent)
+
+	stats, err := e.GetCampaignStats()
+	require.NoError(t, err)
+	require.Equal(t, &CapProgress{
+		BTCRaised: 1.5e8,
+		SkySent:   150e6,
+		MaxSky:    150e6,
+		Reached:   true,
+	}, stats.Cap)
+	require.NoError(t, e.store.CheckLedger())
+}
+
+func TestExchangeCampaignCapSkyProRata(t *testing.T) {
+	e, shutdown := newCapTestExchange(t, CampaignCapConfig{
+		MaxSky: "100.5",
+		Policy: CapPolicyProRata,
+	})
+	defer shutdown()
+	e.cfg.MaxDecimals = 3
+
+	di := handleCapDeposit(t, e, 1, 1e8)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+
+	reached, err := e.CapReached()
+	require.NoError(t, err)
+	require.False(t, reached)
+
+	// The part of the deposit converted is worth at most the skycoin remaining
+	di = handleCapDeposit(t, e, 2, 1e8)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(500e3), di.SkySent)
+	require.Equal(t, int64(1e8-5e5), di.RefundValue)
+
+	reached, err = e.CapReached()
+	require.NoError(t, err)
+	require.True(t, reached)
+}
+
+func TestExchangeSkyCoinValue(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+	e.cfg.MaxDecimals = 3
+
+	cases := []struct {
+		droplets uint64
+		rate     string
+		value    int64
+	}{
+		{100e6, "100", 1e8},
+		{1e6, "3", 33333333},
+		{333e3, "3", 11100000},
+		{0, "3", 0},
+		{1, "3", 33},
+	}
+
+	for _, tc := range cases {
+		value, err := e.skyCoinValue(scanner.CoinTypeBTC, tc.droplets, tc.rate)
+		require.NoError(t, err)
+		require.Equal(t, tc.value, value, "%+v", tc)
+
+		sky, err := e.calculateSkyDroplets(scanner.CoinTypeBTC, value, tc.rate)
+		require.NoError(t, err)
+		require.True(t, sky <= tc.droplets, "%+v", tc)
+	}
+}
+
+// scanCountingStore counts the deposit scans of a Storer
+type scanCountingStore struct {
+	Storer
+	scans int
+}
+
+func (s *scanCountingStore) ForEachDepositInfo(flt DepositFilter, f func(DepositInfo) error) error {
+	s.scans++
+	return s.Storer.ForEachDepositInfo(flt, f)
+}
+
+func TestExchangeCapProgressCached(t *testing.T) {
+	e, shutdown := newCapTestExchange(t, CampaignCapConfig{
+		MaxBTC: "1.5",
+		Policy: CapPolicyProRata,
+	})
+	defer shutdown()
+
+	store := &scanCountingStore{
+		Storer: e.store,
+	}
+	e.store = store
+
+	// The binds and /api/public-status check the cap on each request, which only scans the store once
+	for i := 0; i < 3; i++ {
+		reached, err := e.CapReached()
+		require.NoError(t, err)
+		require.False(t, reached)
+	}
+	require.Equal(t, 1, store.scans)
+
+	// A deposit's transitions drop the cached progress
+	handleCapDeposit(t, e, 1, 1e8)
+	scans := store.scans
+
+	stats, err := e.GetCampaignStats()
+	require.NoError(t, err)
+	require.Equal(t, int64(1e8), stats.Cap.BTCRaised)
+	require.Equal(t, scans+1, store.scans)
+
+	reached, err := e.CapReached()
+	require.NoError(t, err)
+	require.False(t, reached)
+	require.Equal(t, scans+1, store.scans)
+
+	// The deposit crossing the cap closes binding
+	handleCapDeposit(t, e, 2, 1e8)
+	reached, err = e.CapReached()
+	require.NoError(t, err)
+	require.True(t, reached)
+}
+
+func TestCapCacheRace(t *testing.T) {
+	var c capCache
+
+	// A progress read before a transition isn't cached after it
+	p, err := c.get(func() (CapProgress, error) {
+		c.onTransition(Transition{})
+		return CapProgress{BTCRaised: 1}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), p.BTCRaised)
+
+	p, err = c.get(func() (CapProgress, error) {
+		return CapProgress{BTCRaised: 2}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), p.BTCRaised)
+
+	p, err = c.get(func() (CapProgress, error) {
+		return CapProgress{}, errors.New("not read again")
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(2), p.BTCRaised)
+}
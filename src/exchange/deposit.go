@@ -3,10 +3,8 @@ package exchange
 import (
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 
-	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/deposits"
 )
 
 // Status deposit Status
@@ -21,6 +19,10 @@ const (
 	StatusWaitConfirm
 	// StatusDone coins sent and confirmed
 	StatusDone
+	// StatusWaitReview deposit received, but held for manual review because its rate was refused or it exceeds the campaign cap
+	StatusWaitReview
+	// StatusDustIgnored deposit received, but below the dust threshold, so nothing is sent or refunded
+	StatusDustIgnored
 	// StatusUnknown fallback value
 	StatusUnknown
 )
@@ -30,6 +32,8 @@ var statusString = []string{
 	StatusWaitSend:    "waiting_send",
 	StatusWaitConfirm: "waiting_confirm",
 	StatusDone:        "done",
+	StatusWaitReview:  "waiting_review",
+	StatusDustIgnored: "dust_ignored",
 	StatusUnknown:     "unknown",
 }
 
@@ -48,6 +52,10 @@ func NewStatusFromStr(st string) Status {
 		return StatusWaitConfirm
 	case statusString[StatusDone]:
 		return StatusDone
+	case statusString[StatusWaitReview]:
+		return StatusWaitReview
+	case statusString[StatusDustIgnored]:
+		return StatusDustIgnored
 	default:
 		return StatusUnknown
 	}
@@ -56,6 +64,7 @@ func NewStatusFromStr(st string) Status {
 // DepositInfo records the deposit info
 type DepositInfo struct {
 	Seq            uint64
+	Version        uint64 // Incremented by each update, see Store.UpdateDepositInfoVersion. 0 if saved before versions were added.
 	UpdatedAt      int64
 	Status         Status
 	CoinType       string
@@ -64,18 +73,28 @@ type DepositInfo struct {
 	DepositID      string
 	Txid           string
 	ConversionRate string // SKY per other coin, as a decimal string (allows integers, floats, fractions)
-	DepositValue   int64  // Deposit amount. Should be measured in the smallest unit possible (e.g. satoshis for BTC)
+	DepositValue   int64  // Deposit amount, in the coin type's smallest unit. See deposits.Coin
 	SkySent        uint64 // SKY sent, measured in droplets
 	Error          string // An error that occured during processing
+	RateReviewed   bool   // The deposit was approved in a manual review, so it skips the deposit validators and the rate guard
+	RefundValue    int64  // Part of DepositValue over the campaign cap, which is refunded instead of converted
+	RefundAddress  string // Where refunds are sent, given by the depositor when binding. Empty if none was given.
+	FirstUse       bool   // SkyAddress had never received coins on chain when the skycoin was sent, it may be mistyped
+	// The prices ConversionRate was calculated from, if the deposit was priced in the quote currency
+	Quote *ConversionQuote
+	// The value of DepositValue in the display currency when the deposit was received, if it is enabled
+	Display *DisplayValue
 	// The original Deposit is saved for the records, in case there is a mistake.
 	// Do not use this data directly.  All necessary data is copied to the top level
 	// of DepositInfo (e.g. DepositID, DepositAddress, DepositValue, CoinType).
-	Deposit scanner.Deposit
+	Deposit deposits.Deposit
 }
 
 type DepositStats struct {
 	TotalBTCReceived int64 `json:"total_btc_received"`
 	TotalSKYSent     int64 `json:"total_sky_sent"`
+	// Dust is the value of the deposits ignored as dust, by coin type, in its smallest unit
+	Dust map[string]int64 `json:"dust"`
 }
 
 // ValidateForStatus does a consistency check of the data based upon the Status value
@@ -94,12 +113,15 @@ func (di DepositInfo) ValidateForStatus() error {
 		if di.DepositID == "" {
 			return errors.New("DepositID missing")
 		}
-		if di.CoinType == scanner.CoinTypeBTC && !isValidBtcTx(di.DepositID) {
-			return fmt.Errorf("Invalid DepositID value \"%s\"", di.DepositID)
+		if _, _, err := deposits.ParseID(di.DepositID); err != nil {
+			return err
 		}
 		if di.DepositValue == 0 {
 			return errors.New("DepositValue is zero")
 		}
+		if di.RefundValue < 0 || di.RefundValue > di.DepositValue {
+			return errors.New("RefundValue is out of range")
+		}
 		if _, err := ParseRate(di.ConversionRate); err != nil {
 			return err
 		}
@@ -127,7 +149,7 @@ func (di DepositInfo) ValidateForStatus() error {
 		}
 		return checkWaitSend()
 
-	case StatusWaitSend:
+	case StatusWaitSend, StatusWaitReview, StatusDustIgnored:
 		return checkWaitSend()
 
 	case StatusWaitDeposit, StatusUnknown:
@@ -136,25 +158,3 @@ func (di DepositInfo) ValidateForStatus() error {
 		return fmt.Errorf("DepositInfo should not have status %s[%d]", di.Status.String(), di.Status)
 	}
 }
-
-func isValidBtcTx(btcTx string) bool {
-	if btcTx == "" {
-		return false
-	}
-
-	pts := strings.Split(btcTx, ":")
-	if len(pts) != 2 {
-		return false
-	}
-
-	if pts[0] == "" || pts[1] == "" {
-		return false
-	}
-
-	_, err := strconv.ParseInt(pts[1], 10, 64)
-	if err != nil {
-		return false
-	}
-
-	return true
-}
@@ -0,0 +1,147 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+// Display currency values.
+// With a DisplayConfig, each deposit is valued in a display currency, e.g. USD or EUR, when it is received,
+// for the depositors' statuses and the reports. The value is the deposit's amount times the price of its coin
+// in the display currency, and is saved with the deposit as its DisplayValue, so that it doesn't change with
+// the price afterwards. The prices are those agreed by the display currency's feeds, or the coin prices of the
+// deposit's ConversionQuote if the display currency is the quote currency. A deposit received while its coin
+// has no price has no display value. The display value is only informational, the deposit is converted at its
+// ConversionRate as usual.
+
+// DisplayConfig configures the display currency. It is disabled if Currency is empty.
+type DisplayConfig struct {
+	// Currency the deposits are valued in, e.g. USD
+	Currency string
+	// Decimals the values are rounded to
+	Decimals int
+	// Feeds quoting the price of BTC and ETH in Currency. Can be empty if Currency is the quote currency.
+	Feeds RateFeedConfig
+}
+
+// Validate returns an error if the configuration is invalid. quoteCurrency is the currency of the QuoteConfig.
+func (c DisplayConfig) Validate(quoteCurrency string) error {
+	if c.Currency == "" {
+		if len(c.Feeds.Feeds) != 0 {
+			return errors.New("Display currency is required for price feeds")
+		}
+		return nil
+	}
+
+	if c.Decimals < 0 {
+		return errors.New("Display currency decimals can't be negative")
+	}
+
+	if err := c.Feeds.validate(scanner.CoinTypeBTC, scanner.CoinTypeETH); err != nil {
+		return err
+	}
+
+	if len(c.Feeds.Feeds) == 0 && c.Currency != quoteCurrency {
+		return fmt.Errorf("A BTC or ETH price feed in %s is required, unless it is the quote currency", c.Currency)
+	}
+
+	return nil
+}
+
+// DisplayValue is the value of a deposit in the display currency, at the price of its coin when it was received
+type DisplayValue struct {
+	Currency string `json:"currency"`
+	// Value is the deposit's amount times Price, rounded to the display currency's decimals
+	Value string `json:"value"`
+	// Price is the price of the deposit's coin type in Currency
+	Price       string `json:"price"`
+	PriceSource string `json:"price_source"`
+}
+
+// displayFeeds prices the coin types in the display currency
+type displayFeeds struct {
+	cfg DisplayConfig
+	// feeds is nil if the prices are taken from the conversion quotes
+	feeds *rateFeeds
+}
+
+// newDisplayFeeds creates the displayFeeds. It returns nil if the display currency is disabled.
+func newDisplayFeeds(log logrus.FieldLogger, cfg DisplayConfig, quoteCurrency string) (*displayFeeds, error) {
+	if cfg.Currency == "" {
+		return nil, nil
+	}
+
+	if err := cfg.Validate(quoteCurrency); err != nil {
+		return nil, err
+	}
+
+	d := &displayFeeds{
+		cfg: cfg,
+	}
+
+	// A coin type has no price until its feeds agree
+	if len(cfg.Feeds.Feeds) != 0 {
+		d.feeds = newFeeds(log.WithField("currency", cfg.Currency), cfg.Feeds, map[string]string{})
+		d.feeds.log = d.feeds.log.WithField("prefix", "teller.exchange.displayfeeds")
+	}
+
+	return d, nil
+}
+
+// value returns the value of amount of coinType at now. quote is the conversion quote of the deposit, if any.
+// It returns nil if the display currency is disabled, or the coin type has no price.
+func (d *displayFeeds) value(coinType string, amount int64, quote *ConversionQuote, now time.Time) *DisplayValue {
+	if d == nil {
+		return nil
+	}
+
+	var price, source string
+	if d.feeds != nil {
+		price, source = d.feeds.rate(coinType, now)
+	}
+
+	if price == "" && quote != nil && quote.Currency == d.cfg.Currency {
+		price = quote.CoinPrice
+		source = quote.CoinPriceSource
+	}
+
+	if price == "" {
+		return nil
+	}
+
+	p, err := ParseRate(price)
+	if err != nil {
+		return nil
+	}
+
+	coin, err := deposits.GetCoin(coinType)
+	if err != nil {
+		return nil
+	}
+
+	return &DisplayValue{
+		Currency:    d.cfg.Currency,
+		Value:       coin.Coins(amount).Mul(p).StringFixed(int32(d.cfg.Decimals)),
+		Price:       price,
+		PriceSource: source,
+	}
+}
+
+// statuses returns the price of each coin type with feeds at now, and the health of its feeds
+func (d *displayFeeds) statuses(now time.Time) []RateFeedStatus {
+	if d == nil {
+		return nil
+	}
+
+	statuses := d.feeds.statuses(now)
+	for i := range statuses {
+		statuses[i].Currency = d.cfg.Currency
+	}
+	return statuses
+}
@@ -0,0 +1,179 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestDisplayConfigValidate(t *testing.T) {
+	feeds := RateFeedConfig{
+		Feeds: []RateFeed{
+			{Name: "a", CoinType: scanner.CoinTypeBTC, URL: "https://example.com/a"},
+		},
+	}
+
+	require.NoError(t, DisplayConfig{}.Validate(""))
+	require.NoError(t, DisplayConfig{Currency: "EUR", Decimals: 2, Feeds: feeds}.Validate("USD"))
+	// The quote currency's prices are used without feeds
+	require.NoError(t, DisplayConfig{Currency: "USD", Decimals: 2}.Validate("USD"))
+
+	for _, cfg := range []DisplayConfig{
+		{Feeds: feeds},
+		{Currency: "EUR"},
+		{Currency: "EUR", Decimals: -1, Feeds: feeds},
+		{Currency: "EUR", Feeds: RateFeedConfig{Feeds: []RateFeed{{Name: "a", CoinType: CurrencySKY, URL: "https://example.com/a"}}}},
+	} {
+		require.Error(t, cfg.Validate("USD"), "%+v", cfg)
+	}
+}
+
+func TestDisplayFeedsValue(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	d, err := newDisplayFeeds(log, DisplayConfig{}, "")
+	require.NoError(t, err)
+	require.Nil(t, d)
+	require.Nil(t, d.value(scanner.CoinTypeBTC, 1e8, nil, time.Now()))
+	require.Nil(t, d.statuses(time.Now()))
+
+	d, err = newDisplayFeeds(log, DisplayConfig{
+		Currency: "USD",
+		Decimals: 2,
+		Feeds: RateFeedConfig{
+			Feeds: []RateFeed{
+				{Name: "btc", CoinType: scanner.CoinTypeBTC, URL: "https://example.com/btc"},
+			},
+		},
+	}, "USD")
+	require.NoError(t, err)
+
+	now := time.Now()
+	quote := &ConversionQuote{
+		Currency:        "USD",
+		CoinPrice:       "11000",
+		CoinPriceSource: RateSourceQuorum,
+		SkyPrice:        "2",
+		SkyPriceSource:  RateSourceConfigured,
+	}
+
+	// BTC has no price until its feeds agree
+	require.Nil(t, d.value(scanner.CoinTypeBTC, 1e8, nil, now))
+
+	// The quote's price is used while the feeds don't agree
+	require.Equal(t, &DisplayValue{
+		Currency:    "USD",
+		Value:       "16500.00",
+		Price:       "11000",
+		PriceSource: RateSourceQuorum,
+	}, d.value(scanner.CoinTypeBTC, 15e7, quote, now))
+
+	p, err := ParseRate("12345.678")
+	require.NoError(t, err)
+	d.feeds.update([]rateQuote{{feed: d.cfg.Feeds.Feeds[0], quote: p}}, now)
+
+	// The value is rounded to the decimals
+	require.Equal(t, &DisplayValue{
+		Currency:    "USD",
+		Value:       "1234.57",
+		Price:       "12345.678",
+		PriceSource: RateSourceQuorum,
+	}, d.value(scanner.CoinTypeBTC, 1e7, quote, now))
+
+	// ETH has no feeds and the quote is for BTC in another currency
+	require.Nil(t, d.value(scanner.CoinTypeETH, 1e18, &ConversionQuote{Currency: "EUR", CoinPrice: "300"}, now))
+
+	statuses := d.statuses(now)
+	require.Len(t, statuses, 1)
+	require.Equal(t, "USD", statuses[0].Currency)
+	require.Equal(t, "12345.678", statuses[0].Rate)
+}
+
+func TestExchangeDisplayValue(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"price": "9000.5"}`)
+	}))
+	defer srv.Close()
+
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+	e.cfg.Display = DisplayConfig{
+		Currency: "EUR",
+		Decimals: 2,
+		Feeds: RateFeedConfig{
+			Feeds: []RateFeed{
+				{Name: "a", CoinType: scanner.CoinTypeBTC, URL: srv.URL, Field: "price"},
+			},
+		},
+	}
+	var err error
+	e.display, err = newDisplayFeeds(log, e.cfg.Display, "")
+	require.NoError(t, err)
+
+	require.NoError(t, e.store.BindAddress(testSkyAddr, "btcaddr", scanner.CoinTypeBTC, ""))
+
+	// A deposit received before the price is known has no display value
+	di, err := e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr",
+		Amount:   1e8,
+		Tx:       "btx1",
+		Final:    true,
+	})
+	require.NoError(t, err)
+	require.Nil(t, di.Display)
+
+	e.display.feeds.poll(time.Now())
+
+	di, err = e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr",
+		Amount:   2e8,
+		Tx:       "btx2",
+		Final:    true,
+	})
+	require.NoError(t, err)
+	display := &DisplayValue{
+		Currency:    "EUR",
+		Value:       "18001.00",
+		Price:       "9000.5",
+		PriceSource: RateSourceQuorum,
+	}
+	require.Equal(t, display, di.Display)
+
+	// The value is frozen when the deposit is received, a resent deposit keeps it
+	di, err = e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr",
+		Amount:   2e8,
+		Tx:       "btx2",
+		Final:    true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, display, di.Display)
+
+	statuses, err := e.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	require.Nil(t, statuses[0].Display)
+	require.Equal(t, display, statuses[1].Display)
+
+	detail, err := e.GetDepositStatusDetail(func(DepositInfo) bool { return true })
+	require.NoError(t, err)
+	require.Len(t, detail, 2)
+	require.Equal(t, display, detail[1].Display)
+
+	feedStatuses := e.RateFeedStatuses()
+	require.Len(t, feedStatuses, 1)
+	require.Equal(t, "EUR", feedStatuses[0].Currency)
+}
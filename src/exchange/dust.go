@@ -0,0 +1,111 @@
+package exchange
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+// DustConfig configures the dust thresholds, as decimal strings of whole coins.
+// A deposit worth less than its coin type's threshold would cost more to refund than it is worth,
+// so it is set to StatusDustIgnored, which is final, instead of being sent or held, and its value
+// is credited to the dust ledger account. A deposit approved after a review is not checked again.
+// A coin type's deposits aren't checked if its threshold is empty.
+type DustConfig struct {
+	BTC string
+	ETH string
+}
+
+// DustErr is recorded as the Error of a deposit ignored as dust
+type DustErr struct {
+	CoinType  string
+	Value     int64
+	Threshold int64
+}
+
+func (e DustErr) Error() string {
+	return fmt.Sprintf("Deposit of %s %s is below the minimum of %s %s, it was not converted",
+		formatCoins(e.CoinType, e.Value), e.CoinType, formatCoins(e.CoinType, e.Threshold), e.CoinType)
+}
+
+// formatCoins formats a deposit value in whole coins
+func formatCoins(coinType string, value int64) string {
+	coin, err := deposits.GetCoin(coinType)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return decimal.New(value, -coin.Decimals).String()
+}
+
+// newDustThresholds returns the dust threshold of each coin type, in its smallest unit
+func newDustThresholds(cfg DustConfig) (map[string]int64, error) {
+	thresholds := make(map[string]int64)
+
+	for coinType, s := range map[string]string{
+		scanner.CoinTypeBTC: cfg.BTC,
+		scanner.CoinTypeETH: cfg.ETH,
+	} {
+		if s == "" {
+			continue
+		}
+
+		coin, err := deposits.GetCoin(coinType)
+		if err != nil {
+			return nil, err
+		}
+
+		d, err := decimal.NewFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s dust threshold: %v", coinType, err)
+		}
+
+		v := d.Mul(decimal.New(1, coin.Decimals))
+		if !v.Equal(v.Truncate(0)) {
+			return nil, fmt.Errorf("%s dust threshold has more than %d decimal places", coinType, coin.Decimals)
+		}
+		if v.Sign() <= 0 || v.GreaterThan(decimal.New(math.MaxInt64, 0)) {
+			return nil, fmt.Errorf("%s dust threshold is out of range", coinType)
+		}
+
+		thresholds[coinType] = v.IntPart()
+	}
+
+	return thresholds, nil
+}
+
+// dustErr returns a DustErr if the deposit is below its coin type's dust threshold
+func (s *Exchange) dustErr(di DepositInfo) error {
+	threshold, ok := s.dust[di.CoinType]
+	if !ok || di.DepositValue >= threshold {
+		return nil
+	}
+
+	return DustErr{
+		CoinType:  di.CoinType,
+		Value:     di.DepositValue,
+		Threshold: threshold,
+	}
+}
+
+// ignoreDust sets a deposit below the dust threshold to StatusDustIgnored
+func (s *Exchange) ignoreDust(di DepositInfo, reason error) (DepositInfo, error) {
+	log := s.log.WithField("deposit", di)
+
+	di, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusDustIgnored
+		di.Error = reason.Error()
+		return di
+	})
+	if err != nil {
+		log.WithError(err).Error("UpdateDepositInfo set StatusDustIgnored failed")
+		return di, err
+	}
+
+	log.WithError(reason).Info("DepositInfo set to StatusDustIgnored")
+
+	return di, nil
+}
@@ -0,0 +1,91 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestNewDustThresholds(t *testing.T) {
+	d, err := newDustThresholds(DustConfig{})
+	require.NoError(t, err)
+	require.Empty(t, d)
+
+	d, err = newDustThresholds(DustConfig{
+		BTC: "0.0001",
+		ETH: "0.001",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		scanner.CoinTypeBTC: 1e4,
+		scanner.CoinTypeETH: 1e6,
+	}, d)
+
+	for _, cfg := range []DustConfig{
+		{BTC: "foo"},
+		{BTC: "0"},
+		{BTC: "-0.1"},
+		{BTC: "0.000000001"},
+		{ETH: "0.0000000001"},
+		{BTC: "100000000000000"},
+	} {
+		_, err := newDustThresholds(cfg)
+		require.Error(t, err, "%+v", cfg)
+	}
+}
+
+func TestExchangeDust(t *testing.T) {
+	e, shutdown := newCapTestExchange(t, CampaignCapConfig{})
+	defer shutdown()
+
+	var err error
+	e.dust, err = newDustThresholds(DustConfig{
+		BTC: "0.01",
+	})
+	require.NoError(t, err)
+
+	// A deposit below the threshold is ignored
+	di := handleCapDeposit(t, e, 1, 999999)
+	require.Equal(t, StatusDustIgnored, di.Status)
+	require.Equal(t, "Deposit of 0.00999999 BTC is below the minimum of 0.01 BTC, it was not converted", di.Error)
+	require.Zero(t, di.SkySent)
+	require.Empty(t, di.Txid)
+
+	// It is final
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusDustIgnored, di.Status)
+
+	// A deposit at the threshold is sent
+	di = handleCapDeposit(t, e, 2, 1e6)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(1e6), di.SkySent)
+
+	di = handleCapDeposit(t, e, 3, 5e5)
+	require.Equal(t, StatusDustIgnored, di.Status)
+
+	statuses, err := e.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, statuses, 3)
+	require.Equal(t, StatusDustIgnored.String(), statuses[0].Status)
+	require.Equal(t, "Deposit of 0.00999999 BTC is below the minimum of 0.01 BTC, it was not converted", statuses[0].Message)
+	require.Equal(t, StatusWaitConfirm.String(), statuses[1].Status)
+	require.Empty(t, statuses[1].Message)
+
+	stats, err := e.GetDepositStats()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		scanner.CoinTypeBTC: 1499999,
+	}, stats.Dust)
+
+	balances, err := e.store.GetLedgerBalances()
+	require.NoError(t, err)
+	require.Equal(t, int64(-1499999), balances[scanner.CoinTypeBTC][AccountDust])
+	require.NoError(t, e.store.CheckLedger())
+
+	// ETH deposits aren't checked without an ETH threshold
+	di.CoinType = scanner.CoinTypeETH
+	require.NoError(t, e.dustErr(di))
+}
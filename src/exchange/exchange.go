@@ -4,6 +4,7 @@ package exchange
 import (
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
@@ -13,9 +14,13 @@ import (
 	"github.com/skycoin/skycoin/src/util/droplet"
 	"github.com/skycoin/skycoin/src/visor"
 
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/explorer"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
-	"github.com/skycoin/teller/src/util/mathutil"
+	"github.com/skycoin/teller/src/util/errutil"
+	"github.com/skycoin/teller/src/util/pauseutil"
 )
 
 const (
@@ -24,6 +29,7 @@ const (
 	SatoshisPerBTC          int64 = 1e8
 	WeiPerETH               int64 = 1e18
 	txConfirmationCheckWait       = time.Second * 3
+	ledgerCheckInterval           = time.Minute
 )
 
 var (
@@ -38,6 +44,12 @@ var (
 	ErrDepositStatusInvalid = errors.New("Deposit status cannot be handled")
 	// ErrNoBoundAddress is returned if no skycoin address is bound to a deposit's address
 	ErrNoBoundAddress = errors.New("Deposit has no bound skycoin address")
+	// ErrDepositNotFinal is returned if a scanner sends a deposit which could still be reversed
+	ErrDepositNotFinal = errors.New("Deposit is not final")
+	// ErrDepositNotInReview is returned when approving a deposit which is not held for review
+	ErrDepositNotInReview = errutil.New(errutil.Conflict, "Deposit is not waiting for review")
+	// ErrSkyAddressNotBound is returned when getting the deposit statuses of a skycoin address with no bound deposit addresses
+	ErrSkyAddressNotBound = errutil.New(errutil.NotFound, "No deposit address is bound to this skycoin address")
 )
 
 // DepositFilter filters deposits
@@ -45,31 +57,110 @@ type DepositFilter func(di DepositInfo) bool
 
 // Exchanger provides APIs to interact with the exchange service
 type Exchanger interface {
-	BindAddress(skyAddr, depositAddr, coinType string) error
+	BindAddress(skyAddr, depositAddr, coinType, refundAddr string) error
+	CancelBinding(skyAddr, depositAddr, coinType string, reused bool) error
+	IsBound(depositAddr, coinType string) (bool, error)
+	GetBoundSkyAddress(depositAddr, coinType string) (string, error)
 	GetDepositStatuses(skyAddr string) ([]DepositStatus, error)
 	GetDepositStatusDetail(flt DepositFilter) ([]DepositStatusDetail, error)
+	GetDepositRecord(depositID string) (DepositRecord, error)
+	QueryDepositStatusDetail(q DepositQuery) ([]DepositStatusDetail, error)
 	GetBindNum(skyAddr string) (int, error)
 	GetDepositStats() (*DepositStats, error)
+	GetLedgerReport() (*LedgerReport, error)
+	GetCampaignStats() (CampaignStats, error)
+	CapReached() (bool, error)
+	ApproveDeposit(depositID, rate string, version uint64) (DepositInfo, error)
+	ExportDeposits(w io.Writer, format ExportFormat, flt ExportFilter) (int, error)
+	GetRateHistory(coinType string) ([]RateChange, error)
+	CurrentRate(coinType string) (string, error)
+	GetPayoutLog(since uint64, limit int) ([]PayoutLogEntry, error)
+	GetAuditLogPage(cursor uint64, limit int) (AuditLogPage, error)
+	WatchDepositStatuses(skyAddr string) (<-chan struct{}, func())
 }
 
 // Exchange manages coin exchange between deposits and skycoin
 type Exchange struct {
 	log         logrus.FieldLogger
 	cfg         Config
-	multiplexer scanner.Scanner // multiplex provides APIs for interacting with the scan service
-	sender      sender.Sender   // sender provides APIs for sending skycoin
-	store       Storer          // deposit info storage
+	multiplexer scanner.Scanner    // multiplex provides APIs for interacting with the scan service
+	sender      sender.Sender      // sender provides APIs for sending skycoin
+	tracker     analytics.Tracker  // tracker records analytics funnel events
+	rateGuard   *RateGuard         // refuses conversions at broken rates
+	feeds       *rateFeeds         // agrees on the rates quoted by the price feeds, nil if disabled
+	quotes      *quoteFeeds        // prices the deposits in the quote currency, nil if disabled, see quote.go
+	display     *displayFeeds      // values the deposits in the display currency, nil if disabled, see display.go
+	cap         campaignCap        // refunds deposits over the campaign cap
+	capCache    *capCache          // progress towards the campaign cap, see cap.go
+	dust        map[string]int64   // dust threshold of each coin type, see dust.go
+	validation  *depositValidation // operator checks of the deposits before they are credited, nil if disabled
+	store       Storer             // deposit info storage
+	watcher     *statusWatcher     // wakes the requests waiting for a deposit status change
+	statuses    *statusCache       // deposit infos of the polled skycoin addresses, nil if disabled
+	skyChain    AddressSeer        // flags payouts to addresses which never received coins, nil if disabled
+	hours       CoinHourGate       // delays the sends the hot wallet lacks the coin hours of, nil if disabled
 	quit        chan struct{}
 	done        chan struct{}
 	depositChan chan DepositInfo
+	sendQueue   *sendQueue // deposits waiting to be sent, see throttle.go
+
+	// sendMu is held while a deposit's state is handled, resumeC is set while quiesced
+	sendMu  sync.Mutex
+	resumeC chan struct{}
+
+	// Operators pause dispatching deposits from the scanner, or sending, through these gates
+	dispatchGate pauseutil.Gate
+	sendGate     pauseutil.Gate
+
+	// sendFailures counts the consecutive failures of the deposits' sends and confirmations
+	sendFailuresMu sync.Mutex
+	sendFailures   int
 }
 
 // Config exchange config struct
 type Config struct {
-	BtcRate                 string // SKY/BTC rate, decimal string
-	EthRate                 string // SKY/ETH rate, decimal string
+	BtcRate                 string            // SKY/BTC rate, decimal string
+	EthRate                 string            // SKY/ETH rate, decimal string
+	CoinRates               map[string]string // SKY/coin rates of the coin types added by scanner plugins, by coin type
 	TxConfirmationCheckWait time.Duration
+	LedgerCheckInterval     time.Duration // How often the ledger is reconciled with the deposit records
 	MaxDecimals             int
+	RateGuard               RateGuardConfig
+	RateFeeds               RateFeedConfig
+	Quote                   QuoteConfig
+	Display                 DisplayConfig
+	PayoutLog               PayoutLogConfig
+	CampaignCap             CampaignCapConfig
+	DustThreshold           DustConfig
+	SendThrottle            SendThrottleConfig
+	DepositValidation       DepositValidationConfig
+	Snapshots               SnapshotConfig // Run the exports and the ledger report against a snapshot of the store, see snapshot.go
+	StatusCacheSize         int            // Max skycoin addresses whose deposit statuses are cached, 0 disables the cache
+	Explorer                explorer.Links // Explorer links of the statuses' addresses and transactions
+	Receipts                ReceiptLinker  // Receipt links of the completed deposits' statuses, nil if receipts are disabled
+}
+
+// ReceiptLinker returns the signed receipt link of a completed deposit
+type ReceiptLinker interface {
+	Link(depositID string) string
+}
+
+// AddressSeer reports whether a skycoin address has received coins on chain
+type AddressSeer interface {
+	AddressSeen(addr string) (bool, error)
+}
+
+// CoinHourGate delays the sends which the hot wallet lacks the coin hours of
+type CoinHourGate interface {
+	// Wait waits until the hot wallet can pay for a send, queued is the number of sends waiting including it.
+	// It returns false if quit is closed while waiting.
+	Wait(queued int, quit <-chan struct{}) bool
+}
+
+// PayoutLogConfig configures the public payout log
+type PayoutLogConfig struct {
+	Enabled bool
+	Salt    string // Secret salt which the skycoin addresses are hashed with
 }
 
 // Validate returns an error if the configuration is invalid
@@ -78,6 +169,12 @@ func (c Config) Validate() error {
 		return err
 	}
 
+	for coinType, rate := range c.CoinRates {
+		if _, err := ParseRate(rate); err != nil {
+			return fmt.Errorf("%s rate: %v", coinType, err)
+		}
+	}
+
 	if c.MaxDecimals < 0 {
 		return errors.New("MaxDecimals can't be negative")
 	}
@@ -86,11 +183,27 @@ func (c Config) Validate() error {
 		return fmt.Errorf("MaxDecimals is larger than visor.MaxDropletPrecision=%d", visor.MaxDropletPrecision)
 	}
 
-	return nil
+	if c.StatusCacheSize < 0 {
+		return errors.New("StatusCacheSize can't be negative")
+	}
+
+	return c.SendThrottle.Validate()
+}
+
+// rates returns the configured rates, by coin type
+func (c Config) rates() map[string]string {
+	rates := map[string]string{
+		scanner.CoinTypeBTC: c.BtcRate,
+		scanner.CoinTypeETH: c.EthRate,
+	}
+	for coinType, rate := range c.CoinRates {
+		rates[coinType] = rate
+	}
+	return rates
 }
 
 // NewExchange creates exchange service
-func NewExchange(log logrus.FieldLogger, store Storer, multiplexer scanner.Scanner, sender sender.Sender, cfg Config) (*Exchange, error) {
+func NewExchange(log logrus.FieldLogger, store Storer, multiplexer scanner.Scanner, sender sender.Sender, tracker analytics.Tracker, cfg Config) (*Exchange, error) {
 	if _, err := ParseRate(cfg.BtcRate); err != nil {
 		return nil, err
 	}
@@ -99,16 +212,111 @@ func NewExchange(log logrus.FieldLogger, store Storer, multiplexer scanner.Scann
 		cfg.TxConfirmationCheckWait = txConfirmationCheckWait
 	}
 
-	return &Exchange{
+	if cfg.LedgerCheckInterval == 0 {
+		cfg.LedgerCheckInterval = ledgerCheckInterval
+	}
+
+	if err := cfg.SendThrottle.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.SendThrottle.MaxInFlight == 0 {
+		cfg.SendThrottle.MaxInFlight = 1
+	}
+
+	rateGuard, err := NewRateGuard(cfg.RateGuard)
+	if err != nil {
+		return nil, err
+	}
+
+	campaignCap, err := newCampaignCap(cfg.CampaignCap)
+	if err != nil {
+		return nil, err
+	}
+
+	validation, err := newDepositValidation(cfg.DepositValidation)
+	if err != nil {
+		return nil, err
+	}
+
+	dust, err := newDustThresholds(cfg.DustThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	feeds, err := newRateFeeds(log, cfg.RateFeeds, cfg.rates())
+	if err != nil {
+		return nil, err
+	}
+
+	quotes, err := newQuoteFeeds(log, cfg.Quote)
+	if err != nil {
+		return nil, err
+	}
+
+	display, err := newDisplayFeeds(log, cfg.Display, cfg.Quote.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Exchange{
 		cfg:         cfg,
 		log:         log.WithField("prefix", "teller.exchange"),
 		multiplexer: multiplexer,
 		sender:      sender,
+		tracker:     tracker,
+		rateGuard:   rateGuard,
+		feeds:       feeds,
+		quotes:      quotes,
+		display:     display,
+		cap:         campaignCap,
+		capCache:    &capCache{},
+		dust:        dust,
+		validation:  validation,
 		store:       store,
+		watcher:     newStatusWatcher(),
+		statuses:    newStatusCache(cfg.StatusCacheSize),
 		quit:        make(chan struct{}),
 		done:        make(chan struct{}, 1),
 		depositChan: make(chan DepositInfo, 100),
-	}, nil
+		sendQueue:   newSendQueue(),
+	}
+
+	if store != nil {
+		states := store.StateMachine()
+		states.OnTransition(e.logTransition)
+		states.OnTransition(e.trackTransition)
+		states.OnTransition(e.watcher.onTransition)
+		states.OnTransition(e.statuses.onTransition)
+		states.OnTransition(e.capCache.onTransition)
+		if cfg.PayoutLog.Enabled {
+			states.OnTransition(e.logPayout)
+		}
+	}
+
+	return e, nil
+}
+
+// logTransition logs each deposit status transition, for auditing
+func (s *Exchange) logTransition(t Transition) {
+	s.log.WithFields(logrus.Fields{
+		"depositID": t.To.DepositID,
+		"skyAddr":   t.To.SkyAddress,
+		"from":      t.From.Status.String(),
+		"to":        t.To.Status.String(),
+		"txid":      t.To.Txid,
+		"error":     t.To.Error,
+	}).Info("Deposit status transition")
+}
+
+// trackTransition records the analytics events of deposit status transitions
+func (s *Exchange) trackTransition(t Transition) {
+	if t.From.Status == StatusWaitConfirm && t.To.Status == StatusDone {
+		s.tracker.Track(analytics.EventPayoutCompleted, t.To.SkyAddress, analytics.Properties{
+			"coin_type": t.To.CoinType,
+			"sky_sent":  t.To.SkySent,
+		})
+	}
 }
 
 // Run starts the exchange process
@@ -120,6 +328,20 @@ func (s *Exchange) Run() error {
 		s.done <- struct{}{}
 	}()
 
+	if err := s.recordRates(); err != nil {
+		err = fmt.Errorf("recordRates failed: %v", err)
+		log.WithError(err).Error(err)
+		return err
+	}
+
+	if s.cfg.PayoutLog.Enabled {
+		if err := s.backfillPayoutLog(); err != nil {
+			err = fmt.Errorf("backfillPayoutLog failed: %v", err)
+			log.WithError(err).Error(err)
+			return err
+		}
+	}
+
 	// Load StatusWaitSend deposits for processing later
 	waitSendDeposits, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
 		return di.Status == StatusWaitSend
@@ -144,28 +366,32 @@ func (s *Exchange) Run() error {
 
 	var wg sync.WaitGroup
 
-	// This loop processes StatusWaitSend deposits.
-	// Only one deposit is processed at a time; it will not send more coins
-	// until it receives confirmation of the previous send.
+	// This loop queues the deposits to send
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		log := log.WithField("goroutine", "sendSky")
+		log := log.WithField("goroutine", "queueSends")
 		for {
 			select {
 			case <-s.quit:
-				log.Info("exchange.Exchange send loop quit")
+				log.Info("exchange.Exchange queue sends loop quit")
 				return
 			case d := <-s.depositChan:
-				log := log.WithField("depositInfo", d)
-				if err := s.processWaitSendDeposit(d); err != nil {
-					log.WithError(err).Error("processWaitSendDeposit failed. This deposit will not be reprocessed until teller is restarted.")
-				}
+				s.sendQueue.push(d)
 			}
 		}
 	}()
 
+	// This loop processes the queued StatusWaitSend deposits, see throttle.go.
+	// A deposit is processed until its coins are broadcast before the next is started,
+	// and SendThrottle.MaxInFlight deposits wait for their confirmation at a time.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.runSends(&wg)
+	}()
+
 	// Queue the saved StatusWaitConfirm deposits
 	for _, di := range waitConfirmDeposits {
 		s.depositChan <- di
@@ -199,7 +425,14 @@ func (s *Exchange) Run() error {
 			}
 			log := log.WithField("deposit", dv.Deposit)
 
-			// Save a new DepositInfo based upon the scanner.Deposit.
+			// The deposit is not acknowledged if the exchange quits while paused,
+			// the scanner resends it when teller is started
+			if !s.dispatchGate.Enter(s.quit) {
+				log.Info("exchange.Exchange watch deposits loop quit while paused")
+				return
+			}
+
+			// Save a new DepositInfo based upon the deposits.Deposit.
 			// If the save fails, report it to the scanner.
 			// The scanner will mark the deposit as "processed" if no error
 			// occurred.  Any unprocessed deposits held by the scanner
@@ -211,14 +444,82 @@ func (s *Exchange) Run() error {
 				dv.ErrC <- nil
 				s.depositChan <- d
 			}
+			s.dispatchGate.Leave()
+		}
+	}()
+
+	// This loop reconciles the ledger with the deposit records, so that
+	// any drift in the account balances is reported as soon as possible
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		log := log.WithField("goroutine", "checkLedger")
+		ticker := time.NewTicker(s.cfg.LedgerCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.quit:
+				log.Info("exchange.Exchange check ledger loop quit")
+				return
+			case <-ticker.C:
+				s.checkLedger()
+			}
 		}
 	}()
 
+	// This loop polls the price feeds, so that deposits are converted at the rate they agree on
+	if s.feeds != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.feeds.run(s.quit)
+			log.WithField("goroutine", "pollRateFeeds").Info("exchange.Exchange poll rate feeds loop quit")
+		}()
+	}
+
+	// This loop polls the quote currency's price feeds, so that deposits are converted at the prices they agree on
+	if s.quotes != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.quotes.feeds.run(s.quit)
+			log.WithField("goroutine", "pollQuoteFeeds").Info("exchange.Exchange poll quote feeds loop quit")
+		}()
+	}
+
+	// This loop polls the display currency's price feeds, so that deposits are valued at the prices they agree on
+	if s.display != nil && s.display.feeds != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.display.feeds.run(s.quit)
+			log.WithField("goroutine", "pollDisplayFeeds").Info("exchange.Exchange poll display feeds loop quit")
+		}()
+	}
+
 	wg.Wait()
 
 	return nil
 }
 
+// checkLedger reconciles the ledger with the deposit records and logs an alert on drift
+func (s *Exchange) checkLedger() {
+	err := s.store.CheckLedger()
+	switch err.(type) {
+	case nil:
+	case LedgerDriftErr:
+		s.log.WithField("alert", "ledger_drift").WithError(err).Error("ALERT: ledger balances drifted from the deposit records")
+	default:
+		if err == ErrLedgerUnbalanced {
+			s.log.WithField("alert", "ledger_unbalanced").WithError(err).Error("ALERT: ledger is unbalanced")
+			return
+		}
+		s.log.WithError(err).Error("CheckLedger failed")
+	}
+}
+
 // Shutdown close the exchange service
 func (s *Exchange) Shutdown() {
 	close(s.quit)
@@ -227,52 +528,171 @@ func (s *Exchange) Shutdown() {
 	s.log.Info("Shutdown complete")
 }
 
-//getRate returns conversion rate according to coin type
-func (s *Exchange) getRate(coinType string) (string, error) {
+// getRate returns conversion rate according to coin type, and its conversion quote if it was priced in the quote currency
+func (s *Exchange) getRate(coinType string) (string, *ConversionQuote, error) {
+	var rate string
 	switch coinType {
 	case scanner.CoinTypeBTC:
 		s.log.Info("Received bitcoin deposit")
-		return s.cfg.BtcRate, nil
+		rate = s.cfg.BtcRate
 	case scanner.CoinTypeETH:
 		s.log.Info("Received ethcoin deposit")
-		return s.cfg.EthRate, nil
+		rate = s.cfg.EthRate
 	default:
-		s.log.WithError(scanner.ErrUnsupportedCoinType).Error()
-		return "", scanner.ErrUnsupportedCoinType
+		var ok bool
+		rate, ok = s.cfg.CoinRates[coinType]
+		if !ok {
+			s.log.WithError(scanner.ErrUnsupportedCoinType).Error()
+			return "", nil, scanner.ErrUnsupportedCoinType
+		}
+		s.log.WithField("coinType", coinType).Info("Received plugin coin deposit")
+	}
+
+	var quote *ConversionQuote
+	if s.quotes != nil {
+		if r, q, ok := s.quotes.quote(coinType, time.Now()); ok {
+			rate = r
+			quote = &q
+			s.log.WithFields(logrus.Fields{
+				"rate":  rate,
+				"quote": q,
+			}).Info("Using the quote currency's rate")
+		} else {
+			s.log.WithField("currency", s.cfg.Quote.Currency).Warn("No price in the quote currency, using the SKY rate")
+		}
+	}
+
+	if quote == nil && s.feeds != nil {
+		var source string
+		rate, source = s.feeds.rate(coinType, time.Now())
+		s.log.WithFields(logrus.Fields{
+			"rate":   rate,
+			"source": source,
+		}).Info("Using the rate feeds' rate")
 	}
+
+	// The rate changes with the feeds, so it is recorded when a deposit is converted at it
+	if quote != nil || s.feeds != nil {
+		if _, err := s.store.RecordRate(coinType, rate, s.cfg.MaxDecimals, time.Now()); err != nil {
+			s.log.WithError(err).Error("RecordRate failed")
+		}
+	}
+
+	// Record the quoted rate for the rate guard's rate of change check
+	if r, err := ParseRate(rate); err == nil {
+		s.rateGuard.Observe(coinType, r, time.Now())
+	}
+
+	return rate, quote, nil
+}
+
+// CurrentRate returns the rate a deposit of coinType received now would be converted at, the quote currency's
+// rate if it is configured and priced, or the rate feeds' rate if they are configured, otherwise the configured rate.
+// Unlike getRate, it doesn't record the rate, so it can be called to quote the rate.
+func (s *Exchange) CurrentRate(coinType string) (string, error) {
+	var rate string
+	switch coinType {
+	case scanner.CoinTypeBTC:
+		rate = s.cfg.BtcRate
+	case scanner.CoinTypeETH:
+		rate = s.cfg.EthRate
+	default:
+		var ok bool
+		if rate, ok = s.cfg.CoinRates[coinType]; !ok {
+			return "", scanner.ErrUnsupportedCoinType
+		}
+	}
+
+	if s.quotes != nil {
+		if r, _, ok := s.quotes.quote(coinType, time.Now()); ok {
+			return r, nil
+		}
+	}
+
+	if s.feeds != nil {
+		rate, _ = s.feeds.rate(coinType, time.Now())
+	}
+
+	return rate, nil
 }
 
 // saveIncomingDeposit is called when receiving a deposit from the scanner
-func (s *Exchange) saveIncomingDeposit(dv scanner.Deposit) (DepositInfo, error) {
+func (s *Exchange) saveIncomingDeposit(dv deposits.Deposit) (DepositInfo, error) {
 	log := s.log.WithField("deposit", dv)
 
-	var rate string
-	rate, err := s.getRate(dv.CoinType)
+	if !dv.Final {
+		log.WithError(ErrDepositNotFinal).Error("Refusing deposit")
+		return DepositInfo{}, ErrDepositNotFinal
+	}
+
+	rate, quote, err := s.getRate(dv.CoinType)
 	if err != nil {
 		log.WithError(err).Error("get conversion rate failed")
 		return DepositInfo{}, err
 	}
 
-	di, err := s.store.GetOrCreateDepositInfo(dv, rate)
+	// The deposit is valued in the display currency when it is received, and keeps that value
+	display := s.display.value(dv.CoinType, dv.Amount, quote, time.Now())
+	if s.display != nil && display == nil {
+		log.WithField("currency", s.cfg.Display.Currency).Warn("No price in the display currency, the deposit has no display value")
+	}
+
+	di, err := s.store.GetOrCreateQuotedDepositInfo(dv, rate, quote, display)
 	if err != nil {
-		log.WithError(err).Error("GetOrCreateDepositInfo failed")
+		log.WithError(err).Error("GetOrCreateQuotedDepositInfo failed")
 		return DepositInfo{}, err
 	}
 
 	log = log.WithField("depositInfo", di)
 	log.Info("Saved DepositInfo")
 
+	s.trackFirstDeposit(di)
+
 	return di, err
 }
 
+// trackFirstDeposit emits analytics.EventFirstDeposit if di is the only deposit of its skycoin address.
+// The deposits of the address are looked up by its bound deposit addresses, rather than scanning every deposit.
+// A deposit resent by the scanner after a restart can cause a duplicate event, if the address has no other deposit.
+func (s *Exchange) trackFirstDeposit(di DepositInfo) {
+	if di.Status != StatusWaitSend {
+		return
+	}
+
+	dis, err := s.store.GetDepositInfoOfSkyAddress(di.SkyAddress)
+	if err != nil {
+		s.log.WithError(err).Error("GetDepositInfoOfSkyAddress failed")
+		return
+	}
+
+	for _, d := range dis {
+		// Bound deposit addresses without deposits are listed as waiting for a deposit
+		if d.Status != StatusWaitDeposit && d.DepositID != di.DepositID {
+			return
+		}
+	}
+
+	s.tracker.Track(analytics.EventFirstDeposit, di.SkyAddress, analytics.Properties{
+		"coin_type": di.CoinType,
+	})
+}
+
 // processDeposit advances a single deposit through three states:
 // StatusWaitSend -> StatusWaitConfirm
 // StatusWaitConfirm -> StatusDone
 // StatusWaitDeposit is never saved to the database, so it does not transition
-func (s *Exchange) processWaitSendDeposit(di DepositInfo) error {
+// sentC is closed once the deposit's transaction is broadcast, or the deposit is no longer processed.
+func (s *Exchange) processWaitSendDeposit(di DepositInfo, sentC chan<- struct{}) error {
 	log := s.log.WithField("depositInfo", di)
 	log.Info("Processing StatusWaitSend deposit")
 
+	sent := false
+	defer func() {
+		if !sent {
+			close(sentC)
+		}
+	}()
+
 	for {
 		select {
 		case <-s.quit:
@@ -280,12 +700,32 @@ func (s *Exchange) processWaitSendDeposit(di DepositInfo) error {
 		default:
 		}
 
+		if !s.sendGate.Enter(s.quit) {
+			return nil
+		}
+
+		if !s.lockSend() {
+			s.sendGate.Leave()
+			return nil
+		}
+
 		log.Info("handleDepositInfoState")
 
 		var err error
+		status := di.Status
 		di, err = s.handleDepositInfoState(di)
+		s.sendMu.Unlock()
+		s.sendGate.Leave()
 		log = log.WithField("depositInfo", di)
 
+		// The StatusWaitConfirm step broadcasts the saved transaction first
+		if !sent && status == StatusWaitConfirm && err != ErrBroadcastPending {
+			sent = true
+			close(sentC)
+		}
+
+		s.countSendFailure(err)
+
 		switch err.(type) {
 		case sender.RPCError:
 			// Treat skycoin RPC/CLI errors as temporary.
@@ -303,7 +743,7 @@ func (s *Exchange) processWaitSendDeposit(di DepositInfo) error {
 			switch err {
 			case nil:
 				break
-			case ErrNotConfirmed:
+			case ErrNotConfirmed, ErrBroadcastPending:
 				select {
 				case <-time.After(s.cfg.TxConfirmationCheckWait):
 				case <-s.quit:
@@ -315,12 +755,122 @@ func (s *Exchange) processWaitSendDeposit(di DepositInfo) error {
 			}
 		}
 
-		if di.Status == StatusDone {
+		switch di.Status {
+		case StatusDone, StatusWaitReview, StatusDustIgnored:
 			return nil
 		}
 	}
+}
 
-	return nil
+// countSendFailure counts a failure to handle a deposit's state, e.g. a sender error or a broadcast which is pending,
+// or resets the count once a deposit was sent. A transaction which isn't confirmed yet was sent.
+func (s *Exchange) countSendFailure(err error) {
+	s.sendFailuresMu.Lock()
+	defer s.sendFailuresMu.Unlock()
+
+	switch err {
+	case nil, ErrNotConfirmed:
+		s.sendFailures = 0
+	default:
+		s.sendFailures++
+	}
+}
+
+// SendFailures returns the number of consecutive failures to send or confirm the deposits,
+// e.g. while the skycoin node is down or the wallet's balance is too low
+func (s *Exchange) SendFailures() int {
+	s.sendFailuresMu.Lock()
+	defer s.sendFailuresMu.Unlock()
+	return s.sendFailures
+}
+
+// lockSend locks sendMu, waiting while the exchange is quiesced.
+// It returns false if the exchange is shut down while waiting.
+func (s *Exchange) lockSend() bool {
+	s.sendMu.Lock()
+	for s.resumeC != nil {
+		resumeC := s.resumeC
+		s.sendMu.Unlock()
+
+		select {
+		case <-resumeC:
+		case <-s.quit:
+			return false
+		}
+
+		s.sendMu.Lock()
+	}
+	return true
+}
+
+// Quiesce stops sending skycoin, returning once the deposit state change in progress is finished.
+// Deposits keep their status and are processed after Resume, or by the teller the db is handed over to.
+func (s *Exchange) Quiesce() {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.resumeC == nil {
+		s.resumeC = make(chan struct{})
+	}
+}
+
+// Resume undoes Quiesce
+func (s *Exchange) Resume() {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+
+	if s.resumeC != nil {
+		close(s.resumeC)
+		s.resumeC = nil
+	}
+}
+
+// CheckFirstUse flags the deposits whose skycoin address had never received coins when the skycoin was sent,
+// see DepositInfo.FirstUse. It must be called before Run.
+func (s *Exchange) CheckFirstUse(skyChain AddressSeer) {
+	s.skyChain = skyChain
+}
+
+// WaitForCoinHours delays each send until the hot wallet has the coin hours to pay for it.
+// It must be called before Run.
+func (s *Exchange) WaitForCoinHours(hours CoinHourGate) {
+	s.hours = hours
+}
+
+// firstUse returns true if the deposit's skycoin address has never received coins.
+// The check is advisory, so a failed lookup doesn't hold the send.
+func (s *Exchange) firstUse(di DepositInfo) bool {
+	if s.skyChain == nil {
+		return false
+	}
+
+	log := s.log.WithFields(logrus.Fields{
+		"depositID": di.DepositID,
+		"skyAddr":   di.SkyAddress,
+	})
+
+	seen, err := s.skyChain.AddressSeen(di.SkyAddress)
+	if err != nil {
+		log.WithError(err).Warn("AddressSeen failed, not checking the payout address's first use")
+		return false
+	}
+
+	if !seen {
+		log.Warn("Payout address has never received coins, it may be mistyped")
+	}
+
+	return !seen
+}
+
+// DispatchGate returns the gate which pauses saving the deposits received from the scanner
+func (s *Exchange) DispatchGate() *pauseutil.Gate {
+	return &s.dispatchGate
+}
+
+// SendGate returns the gate which pauses sending skycoin and checking the confirmation of sends.
+// Unlike Quiesce, pausing does not wait for the deposit state change in progress, see pauseutil.Status.Busy.
+func (s *Exchange) SendGate() *pauseutil.Gate {
+	return &s.sendGate
 }
 
 func (s *Exchange) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
@@ -333,18 +883,57 @@ func (s *Exchange) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 
 	switch di.Status {
 	case StatusWaitSend:
+		// Ignore the deposit if it is dust, hold it if the operator's validators refuse it,
+		// or for review if its rate looks broken
+		if !di.RateReviewed {
+			if err := s.dustErr(di); err != nil {
+				return s.ignoreDust(di, err)
+			}
+
+			if err := s.validateDeposit(di); err != nil {
+				return s.holdForValidator(di, err)
+			}
+
+			if err := s.rateGuard.Check(di.CoinType, di.ConversionRate, time.Now()); err != nil {
+				return s.holdForReview(di, err)
+			}
+		}
+
+		// Refund the part of the deposit over the campaign cap
+		refund, err := s.capRefund(di)
+		if err != nil {
+			log.WithError(err).Error("capRefund failed")
+			if err == ErrSkyAmountOverflow {
+				return s.holdForReview(di, err)
+			}
+			return di, err
+		}
+		if refund == di.DepositValue {
+			return s.holdForRefund(di, refund)
+		}
+		di.RefundValue = refund
+
+		// Checked before the send, which the address receives
+		firstUse := s.firstUse(di)
+
 		// Prepare skycoin transaction
 		skyTx, err := s.createTransaction(di)
 
 		if err != nil {
 			log.WithError(err).Error("createTransaction failed")
 
+			// A deposit worth more skycoin than the ledger can hold has a broken amount or rate
+			if err == ErrSkyAmountOverflow {
+				return s.holdForReview(di, err)
+			}
+
 			// If the send amount is empty, skip to StatusDone.
 			if err == ErrEmptySendAmount {
 				log.Info("Send amount is 0, skipping to StatusDone")
 				di, err = s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
 					di.Status = StatusDone
 					di.Error = ErrEmptySendAmount.Error()
+					di.RefundValue = refund
 					return di
 				})
 				if err != nil {
@@ -378,43 +967,42 @@ func (s *Exchange) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 			return di, err
 		}
 
-		// Within a bolt.DB transaction, update the db then send the coins
-		// If the send fails, the data is rolled back
-		// If the db save fails, no coins had been sent
-		di, err = s.store.UpdateDepositInfoCallback(di.DepositID, func(di DepositInfo) DepositInfo {
+		// Save the transaction to the outbox in the same db transaction as the status change,
+		// so that it is broadcast at most once per deposit, see outbox.go.
+		// It is broadcast by the StatusWaitConfirm step.
+		sent, err := s.store.CommitSend(di.DepositID, func(di DepositInfo) DepositInfo {
 			di.Status = StatusWaitConfirm
 			di.Txid = skyTx.TxIDHex()
 			di.SkySent = skySent
+			di.RefundValue = refund
+			di.FirstUse = firstUse
 			return di
-		}, func(di DepositInfo) error {
-			// NOTE: broadcastTransaction retries indefinitely on error
-			// If the skycoin node is not reachable, this will block,
-			// which will also block the database since it's in a transaction
-			rsp, err := s.broadcastTransaction(skyTx)
-			if err != nil {
-				log.WithError(err).Error("broadcastTransaction failed")
-				return err
-			}
-
-			// Invariant assertion: do not return this as an error, since
-			// coins have been sent. This should never occur.
-			if rsp.Txid != skyTx.TxIDHex() {
-				log.Error("CRITICAL ERROR: BroadcastTxResponse.Txid != skyTx.TxIDHex()")
-			}
-
-			return nil
-		})
+		}, newOutboxEntry(di.DepositID, skyTx))
 
 		if err != nil {
-			log.WithError(err).Error("store.UpdateDepositInfoCallback failed")
-			return di, err
+			log.WithError(err).Error("store.CommitSend failed")
+
+			// Another transaction was saved to pay the deposit, which must not be paid twice
+			if _, ok := err.(sender.DuplicateSendErr); ok {
+				return s.holdForReview(di, err)
+			}
+			return sent, err
 		}
+		di = sent
 
 		log.Info("DepositInfo set to StatusWaitConfirm")
 
 		return di, nil
 
 	case StatusWaitConfirm:
+		// Broadcast the saved transaction, if it hasn't been yet.
+		// A deposit whose transaction is refused permanently is held for review.
+		var err error
+		di, err = s.dispatch(di)
+		if err != nil || di.Status != StatusWaitConfirm {
+			return di, err
+		}
+
 		// Wait for confirmation
 		rsp := s.sender.IsTxConfirmed(di.Txid)
 
@@ -435,7 +1023,7 @@ func (s *Exchange) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 
 		log.Info("Transaction is confirmed")
 
-		di, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di, err = s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
 			di.Status = StatusDone
 			return di
 		})
@@ -452,9 +1040,17 @@ func (s *Exchange) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 		log.Warn("DepositInfo already processed")
 		return di, nil
 
+	case StatusWaitReview:
+		log.Warn("DepositInfo is waiting for review")
+		return di, nil
+
+	case StatusDustIgnored:
+		log.Warn("DepositInfo was ignored as dust")
+		return di, nil
+
 	case StatusWaitDeposit:
 		// We don't save any deposits with StatusWaitDeposit.
-		// We can't transition to StatusWaitSend without a scanner.Deposit
+		// We can't transition to StatusWaitSend without a deposits.Deposit
 		log.Error("StatusWaitDeposit cannot be processed and should never be handled by this method")
 		fallthrough
 	case StatusUnknown:
@@ -466,27 +1062,109 @@ func (s *Exchange) handleDepositInfoState(di DepositInfo) (DepositInfo, error) {
 	}
 }
 
-func (s *Exchange) calculateSkyDroplets(di DepositInfo) (uint64, error) {
-	log := s.log
-	var err error
-	var skyAmt uint64
-	switch di.CoinType {
-	case scanner.CoinTypeBTC:
-		skyAmt, err = CalculateBtcSkyValue(di.DepositValue, di.ConversionRate, s.cfg.MaxDecimals)
-		if err != nil {
-			log.WithError(err).Error("CalculateBtcSkyValue failed")
-			return 0, err
+// holdForReview sets the deposit to StatusWaitReview, recording why its rate, amount or transaction was refused
+func (s *Exchange) holdForReview(di DepositInfo, reason error) (DepositInfo, error) {
+	log := s.log.WithField("deposit", di)
+
+	_, rateGuardErr := reason.(RateGuardErr)
+	_, validatorErr := reason.(DepositValidatorErr)
+	_, duplicateSendErr := reason.(sender.DuplicateSendErr)
+	_, sendRefusedErr := reason.(SendRefusedErr)
+	switch {
+	case rateGuardErr:
+		log.WithField("alert", "rate_guard").WithError(reason).Error("ALERT: deposit rate refused, holding deposit for review")
+	case validatorErr:
+		log.WithField("alert", "deposit_validator").WithError(reason).Error("ALERT: deposit held by a validator, holding deposit for review")
+	case duplicateSendErr:
+		log.WithField("alert", "duplicate_send").WithError(reason).Error("ALERT: deposit was already sent by another transaction, holding deposit for review")
+	case sendRefusedErr:
+		log.WithField("alert", "send_refused").WithError(reason).Error("ALERT: deposit's skycoin transaction was refused by the sender, holding deposit for review")
+	case reason == ErrSkyAmountOverflow:
+		log.WithField("alert", "sky_amount_overflow").WithError(reason).Error("ALERT: deposit skycoin amount overflows, holding deposit for review")
+	default:
+		log.WithError(reason).Error("Rate guard check failed, holding deposit for review")
+	}
+
+	di, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitReview
+		di.Error = reason.Error()
+		return di
+	})
+	if err != nil {
+		log.WithError(err).Error("UpdateDepositInfo set StatusWaitReview failed")
+		return di, err
+	}
+
+	log.Info("DepositInfo set to StatusWaitReview")
+
+	return di, nil
+}
+
+// ApproveDeposit releases a deposit held for review, so that it is sent at rate.
+// If rate is empty, the deposit's saved rate is used.
+// A deposit held for exceeding the campaign cap is checked against the cap again.
+// If version is set, the deposit is only approved if it is still at version, see Store.UpdateDepositInfoVersion.
+func (s *Exchange) ApproveDeposit(depositID, rate string, version uint64) (DepositInfo, error) {
+	log := s.log.WithField("depositID", depositID)
+
+	if rate != "" {
+		if _, err := ParseRate(rate); err != nil {
+			return DepositInfo{}, err
 		}
-	case scanner.CoinTypeETH:
-		//Gwei convert to wei, because stored-value is Gwei in case overflow of uint64
-		skyAmt, err = CalculateEthSkyValue(mathutil.Gwei2Wei(di.DepositValue), di.ConversionRate, s.cfg.MaxDecimals)
-		if err != nil {
-			log.WithError(err).Error("CalculateEthSkyValue failed")
-			return 0, err
+	}
+
+	var inReview bool
+	di, err := s.store.UpdateDepositInfoVersion(depositID, version, func(di DepositInfo) DepositInfo {
+		inReview = di.Status == StatusWaitReview
+		if !inReview {
+			return di
 		}
-	default:
-		log.WithError(scanner.ErrUnsupportedCoinType).Error()
-		return 0, scanner.ErrUnsupportedCoinType
+
+		di.Status = StatusWaitSend
+		di.RateReviewed = true
+		di.Error = ""
+		// The refund is worked out again, in case the campaign cap was raised
+		di.RefundValue = 0
+		if rate != "" {
+			di.ConversionRate = rate
+			// The reviewer's rate wasn't priced in the quote currency
+			di.Quote = nil
+		}
+		return di
+	}, func(di DepositInfo) error {
+		if !inReview {
+			return ErrDepositNotInReview
+		}
+		return nil
+	})
+	if err != nil {
+		log.WithError(err).Error("UpdateDepositInfoVersion approve deposit failed")
+		return DepositInfo{}, err
+	}
+
+	log.WithField("depositInfo", di).Info("Deposit approved after review")
+
+	select {
+	case s.depositChan <- di:
+	case <-s.quit:
+	}
+
+	return di, nil
+}
+
+// calculateSkyDroplets returns the skycoin to send for a deposit amount, in the same unit as DepositValue
+func (s *Exchange) calculateSkyDroplets(coinType string, value int64, rate string) (uint64, error) {
+	log := s.log
+	coin, err := deposits.GetCoin(coinType)
+	if err != nil {
+		log.WithError(err).Error()
+		return 0, err
+	}
+
+	skyAmt, err := CalculateSkyValue(coin.Coins(value), rate, s.cfg.MaxDecimals)
+	if err != nil {
+		log.WithError(err).Error("CalculateSkyValue failed")
+		return 0, err
 	}
 	return skyAmt, nil
 }
@@ -505,7 +1183,8 @@ func (s *Exchange) createTransaction(di DepositInfo) (*coin.Transaction, error)
 	log = log.WithField("skyRate", di.ConversionRate)
 	log = log.WithField("maxDecimals", s.cfg.MaxDecimals)
 
-	skyAmt, err := s.calculateSkyDroplets(di)
+	// The part of the deposit refunded for exceeding the campaign cap is not converted
+	skyAmt, err := s.calculateSkyDroplets(di.CoinType, di.DepositValue-di.RefundValue, di.ConversionRate)
 	if err != nil {
 		log.WithError(err).Error("calculateSkyDroplets failed")
 		return nil, err
@@ -570,12 +1249,12 @@ func verifyCreatedTransaction(tx *coin.Transaction, di DepositInfo, skyAmt uint6
 	return nil
 }
 
-func (s *Exchange) broadcastTransaction(tx *coin.Transaction) (*sender.BroadcastTxResponse, error) {
+func (s *Exchange) broadcastTransaction(tx *coin.Transaction, key string) (*sender.BroadcastTxResponse, error) {
 	log := s.log.WithField("txid", tx.TxIDHex())
 
 	log.Info("Broadcasting skycoin transaction")
 
-	rsp := s.sender.BroadcastTransaction(tx)
+	rsp := s.sender.BroadcastTransaction(tx, key)
 
 	log = log.WithField("sendRsp", rsp)
 
@@ -585,10 +1264,10 @@ func (s *Exchange) broadcastTransaction(tx *coin.Transaction) (*sender.Broadcast
 		return nil, err
 	}
 
+	// The error is returned as is, so that the caller can tell a permanent refusal, see sender.IsPermanent
 	if rsp.Err != nil {
-		err := fmt.Errorf("Send skycoin failed: %v", rsp.Err)
-		log.WithError(err).Error(err)
-		return nil, err
+		log.WithError(rsp.Err).Error("Send skycoin failed")
+		return nil, rsp.Err
 	}
 
 	log.Info("Sent skycoin")
@@ -599,74 +1278,306 @@ func (s *Exchange) broadcastTransaction(tx *coin.Transaction) (*sender.Broadcast
 // BindAddress binds deposit address with skycoin address, and
 // add the btc/eth address to scan service, when detect deposit coin
 // to the btc/eth address, will send specific skycoin to the binded
-// skycoin address
-func (s *Exchange) BindAddress(skyAddr, depositAddr, coinType string) error {
-	if err := s.store.BindAddress(skyAddr, depositAddr, coinType); err != nil {
+// skycoin address. refundAddr is optional, see ValidateRefundAddress.
+func (s *Exchange) BindAddress(skyAddr, depositAddr, coinType, refundAddr string) error {
+	if refundAddr != "" {
+		if err := ValidateRefundAddress(coinType, refundAddr); err != nil {
+			return err
+		}
+	}
+
+	if err := s.store.BindAddress(skyAddr, depositAddr, coinType, refundAddr); err != nil {
 		return err
 	}
 
+	s.watcher.changed(skyAddr)
+	s.statuses.changed(skyAddr)
+
 	// add btc/etc address to scanner
 	return s.multiplexer.AddScanAddress(depositAddr, coinType)
 }
 
+// IsBound returns true if the deposit address is bound to a skycoin address
+func (s *Exchange) IsBound(depositAddr, coinType string) (bool, error) {
+	skyAddr, err := s.store.GetBindAddress(depositAddr, coinType)
+	if err != nil {
+		return false, err
+	}
+	return skyAddr != "", nil
+}
+
+// GetBoundSkyAddress returns the skycoin address the deposit address is bound to, empty if it isn't bound
+func (s *Exchange) GetBoundSkyAddress(depositAddr, coinType string) (string, error) {
+	return s.store.GetBindAddress(depositAddr, coinType)
+}
+
+// WatchDepositStatuses returns a channel which is closed at the next change of the deposit statuses
+// of skyAddr, and a func which stops watching, which must be called.
+// Deposits detected by a scanner but not final yet don't wake the watchers.
+func (s *Exchange) WatchDepositStatuses(skyAddr string) (<-chan struct{}, func()) {
+	return s.watcher.watch(skyAddr)
+}
+
+// StatusCacheStats returns the hits and misses of the deposit status cache, zero if the cache is disabled
+func (s *Exchange) StatusCacheStats() StatusCacheStats {
+	return s.statuses.Stats()
+}
+
+// DepositStatusDetected is the status of a deposit detected by a scanner in a block which is not final yet.
+// It is not a Status, since the deposit isn't saved until the scanner sends it to the exchange.
+const DepositStatusDetected = "detected"
+
 // DepositStatus json struct for deposit status
 type DepositStatus struct {
 	Seq       uint64 `json:"seq"`
 	UpdatedAt int64  `json:"updated_at"`
 	Status    string `json:"status"`
 	CoinType  string `json:"coin_type"`
+	// Confirmations of the deposit when it was scanned, 0 until a deposit is received
+	Confirmations int64 `json:"confirmations"`
+	// FirstUse is true if the skycoin address had never received coins when the skycoin was sent.
+	// It doesn't hold the send, but the address may be mistyped.
+	FirstUse bool `json:"first_use,omitempty"`
+	// TxURL is the explorer link of the skycoin transaction, once the skycoin is sent
+	TxURL string `json:"tx_url,omitempty"`
+	// Message explains a dust_ignored status to the depositor
+	Message string `json:"message,omitempty"`
+	// ReceiptURL is the signed link of the deposit's receipt, once it is done, if receipts are enabled
+	ReceiptURL string `json:"receipt_url,omitempty"`
+	// Display is the deposit's value in the display currency when it was received, if it is enabled
+	Display *DisplayValue `json:"display,omitempty"`
 }
 
 // DepositStatusDetail deposit status detail info
 type DepositStatusDetail struct {
-	Seq            uint64 `json:"seq"`
+	Seq uint64 `json:"seq"`
+	// Version of the deposit, for compare-and-swap updates such as ApproveDeposit
+	Version        uint64 `json:"version"`
 	UpdatedAt      int64  `json:"updated_at"`
 	Status         string `json:"status"`
 	SkyAddress     string `json:"skycoin_address"`
 	DepositAddress string `json:"deposit_address"`
 	CoinType       string `json:"coin_type"`
 	Txid           string `json:"txid"`
+	DepositID      string `json:"deposit_id"`
+	ConversionRate string `json:"conversion_rate"`
+	Error          string `json:"error,omitempty"`
+	// Part of the deposit to refund for exceeding the campaign cap, in the coin type's smallest unit
+	RefundValue int64 `json:"refund_value,omitempty"`
+	// Where the refund is sent, empty if the depositor gave no refund address
+	RefundAddress string `json:"refund_address,omitempty"`
+	// The skycoin address had never received coins when the skycoin was sent, see DepositStatus
+	FirstUse bool `json:"first_use,omitempty"`
+	// The prices the conversion rate was calculated from, if the deposit was priced in the quote currency
+	Quote *ConversionQuote `json:"quote,omitempty"`
+	// The deposit's value in the display currency when it was received, see DepositStatus
+	Display *DisplayValue `json:"display,omitempty"`
+	// Explorer links of the addresses and transactions, see Link
+	Explorer DepositLinks `json:"explorer"`
+}
+
+// DepositLinks are the explorer links of a deposit's addresses and transactions.
+// A link is empty if its coin type has no explorer, or the deposit doesn't have the address or transaction yet.
+type DepositLinks struct {
+	SkyAddress     string `json:"skycoin_address,omitempty"`
+	DepositAddress string `json:"deposit_address,omitempty"`
+	// Transaction of the deposit
+	DepositTx string `json:"deposit_tx,omitempty"`
+	// Transaction of the skycoin sent
+	Txid          string `json:"txid,omitempty"`
+	RefundAddress string `json:"refund_address,omitempty"`
+}
+
+// Link sets the explorer links of the deposit
+func (d *DepositStatusDetail) Link(l explorer.Links) {
+	var depositTx string
+	if d.DepositID != "" {
+		// An invalid deposit ID has no link
+		depositTx, _, _ = deposits.ParseID(d.DepositID) // nolint: errcheck
+	}
+
+	d.Explorer = DepositLinks{
+		SkyAddress:     l.PayoutAddress(d.SkyAddress),
+		DepositAddress: l.DepositAddress(d.CoinType, d.DepositAddress),
+		DepositTx:      l.DepositTx(d.CoinType, depositTx),
+		Txid:           l.PayoutTx(d.Txid),
+		RefundAddress:  l.DepositAddress(d.CoinType, d.RefundAddress),
+	}
 }
 
 // GetDepositStatuses returns deamon.DepositStatus array of given skycoin address
 func (s *Exchange) GetDepositStatuses(skyAddr string) ([]DepositStatus, error) {
-	dis, err := s.store.GetDepositInfoOfSkyAddress(skyAddr)
+	dis, err := s.statuses.get(skyAddr, s.store.GetDepositInfoOfSkyAddress)
 	if err != nil {
 		return []DepositStatus{}, err
 	}
 
+	if len(dis) == 0 {
+		return []DepositStatus{}, ErrSkyAddressNotBound
+	}
+
+	detected := s.detectedDeposits(dis)
+
 	dss := make([]DepositStatus, 0, len(dis))
 	for _, di := range dis {
-		dss = append(dss, DepositStatus{
-			Seq:       di.Seq,
-			UpdatedAt: di.UpdatedAt,
-			Status:    di.Status.String(),
-			CoinType:  di.CoinType,
-		})
+		// An address with a detected deposit is no longer waiting for a deposit
+		if di.Status == StatusWaitDeposit && len(detected[di.DepositAddress]) != 0 {
+			continue
+		}
+
+		ds := DepositStatus{
+			Seq:           di.Seq,
+			UpdatedAt:     di.UpdatedAt,
+			Status:        di.Status.String(),
+			CoinType:      di.CoinType,
+			Confirmations: di.Deposit.Confirmations,
+			FirstUse:      di.FirstUse,
+			TxURL:         s.cfg.Explorer.PayoutTx(di.Txid),
+			Display:       di.Display,
+		}
+		if di.Status == StatusDustIgnored {
+			ds.Message = di.Error
+		}
+		if di.Status == StatusDone && s.cfg.Receipts != nil {
+			ds.ReceiptURL = s.cfg.Receipts.Link(di.DepositID)
+		}
+		dss = append(dss, ds)
+	}
+
+	now := time.Now().UTC().Unix()
+	for _, di := range dis {
+		for _, dv := range detected[di.DepositAddress] {
+			dss = append(dss, DepositStatus{
+				UpdatedAt:     now,
+				Status:        DepositStatusDetected,
+				CoinType:      dv.CoinType,
+				Confirmations: dv.Confirmations,
+			})
+		}
+		delete(detected, di.DepositAddress)
 	}
+
 	return dss, nil
 }
 
+// detectedDeposits returns the deposits to the deposit addresses of dis which the scanners
+// detected but are not final yet, by deposit address
+func (s *Exchange) detectedDeposits(dis []DepositInfo) map[string][]deposits.Deposit {
+	received := make(map[string]struct{}, len(dis))
+	for _, di := range dis {
+		if di.DepositID != "" {
+			received[di.DepositID] = struct{}{}
+		}
+	}
+
+	detected := make(map[string][]deposits.Deposit)
+	pending := make(map[string][]deposits.Deposit)
+	for _, di := range dis {
+		dvs, ok := pending[di.CoinType]
+		if !ok {
+			dvs = s.multiplexer.PendingDeposits(di.CoinType)
+			pending[di.CoinType] = dvs
+		}
+
+		if _, ok := detected[di.DepositAddress]; ok {
+			continue
+		}
+
+		for _, dv := range dvs {
+			// The scanner sends a deposit once its block is final, before forgetting the detected deposit
+			if _, ok := received[dv.ID()]; ok {
+				continue
+			}
+			if dv.Address == di.DepositAddress {
+				detected[di.DepositAddress] = append(detected[di.DepositAddress], dv)
+			}
+		}
+	}
+
+	return detected
+}
+
 // GetDepositStatusDetail returns deposit status details
 func (s *Exchange) GetDepositStatusDetail(flt DepositFilter) ([]DepositStatusDetail, error) {
-	dis, err := s.store.GetDepositInfoArray(flt)
-	if err != nil {
+	return s.QueryDepositStatusDetail(DepositQuery{
+		Filter: flt,
+	})
+}
+
+// QueryDepositStatusDetail returns the status details of the deposits matching q, found through the deposit indexes
+func (s *Exchange) QueryDepositStatusDetail(q DepositQuery) ([]DepositStatusDetail, error) {
+	dss := []DepositStatusDetail{}
+	if err := s.store.QueryDepositInfos(q, func(di DepositInfo) error {
+		d := newDepositStatusDetail(di)
+		d.Link(s.cfg.Explorer)
+		dss = append(dss, d)
+		return nil
+	}); err != nil {
 		return nil, err
 	}
+	return dss, nil
+}
 
-	dss := make([]DepositStatusDetail, 0, len(dis))
-	for _, di := range dis {
-		dss = append(dss, DepositStatusDetail{
-			Seq:            di.Seq,
-			UpdatedAt:      di.UpdatedAt,
-			Status:         di.Status.String(),
-			SkyAddress:     di.SkyAddress,
-			DepositAddress: di.DepositAddress,
-			Txid:           di.Txid,
-			CoinType:       di.CoinType,
-		})
+func newDepositStatusDetail(di DepositInfo) DepositStatusDetail {
+	return DepositStatusDetail{
+		Seq:            di.Seq,
+		Version:        di.Version,
+		UpdatedAt:      di.UpdatedAt,
+		Status:         di.Status.String(),
+		SkyAddress:     di.SkyAddress,
+		DepositAddress: di.DepositAddress,
+		Txid:           di.Txid,
+		CoinType:       di.CoinType,
+		DepositID:      di.DepositID,
+		ConversionRate: di.ConversionRate,
+		Error:          di.Error,
+		RefundValue:    di.RefundValue,
+		RefundAddress:  di.RefundAddress,
+		FirstUse:       di.FirstUse,
+		Quote:          di.Quote,
+		Display:        di.Display,
 	}
-	return dss, nil
+}
+
+// ExportDeposits streams the deposits matching flt to w, and returns the number written
+func (s *Exchange) ExportDeposits(w io.Writer, format ExportFormat, flt ExportFilter) (int, error) {
+	store, done, err := s.reportStore()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	// The deposits are found through the status and update time indexes
+	forEach := func(f DepositFilter, cb func(DepositInfo) error) error {
+		return store.QueryDepositInfos(DepositQuery{
+			Statuses: flt.Statuses,
+			From:     flt.From,
+			To:       flt.To,
+			Filter:   f,
+		}, cb)
+	}
+
+	return exportDeposits(forEach, w, format, flt)
+}
+
+// reportStore returns the store the reports read, a snapshot if they are enabled, and a func which must be
+// called once the report is done
+func (s *Exchange) reportStore() (Storer, func(), error) {
+	if !s.cfg.Snapshots.Enabled {
+		return s.store, func() {}, nil
+	}
+
+	snap, err := s.store.Snapshot(s.cfg.Snapshots.Dir)
+	if err != nil {
+		s.log.WithError(err).Error("store.Snapshot failed")
+		return nil, nil, err
+	}
+
+	return snap, func() {
+		if err := snap.Close(); err != nil {
+			s.log.WithError(err).Error("Snapshot.Close failed")
+		}
+	}, nil
 }
 
 // GetBindNum returns the number of btc/eth address the given sky address binded
@@ -680,8 +1591,51 @@ func (s *Exchange) GetDepositStats() (stats *DepositStats, err error) {
 	if err != nil {
 		return nil, err
 	}
+
+	balances, err := s.store.GetLedgerBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	// The dust account is credited, so its balances are negative
+	dust := make(map[string]int64)
+	for currency, accounts := range balances {
+		if v := accounts[AccountDust]; v != 0 {
+			dust[currency] = -v
+		}
+	}
+
 	return &DepositStats{
 		TotalBTCReceived: tbr,
 		TotalSKYSent:     tss,
+		Dust:             dust,
 	}, nil
 }
+
+// GetLedgerReport returns the ledger account balances and any drift from the deposit records
+func (s *Exchange) GetLedgerReport() (*LedgerReport, error) {
+	store, done, err := s.reportStore()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	balances, err := store.GetLedgerBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LedgerReport{
+		Balances: balances,
+	}
+
+	switch err := store.CheckLedger().(type) {
+	case nil:
+	case LedgerDriftErr:
+		report.Drift = err.Drifts
+	default:
+		return nil, err
+	}
+
+	return report, nil
+}
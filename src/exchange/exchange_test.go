@@ -18,8 +18,12 @@ import (
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/coin"
 
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/explorer"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/testutil"
 )
 
@@ -27,10 +31,12 @@ type dummySender struct {
 	sync.RWMutex
 	createTransactionErr    error
 	broadcastTransactionErr error
-	confirmErr              error
-	txidConfirmMap          map[string]bool
-	changeAddr              string
-	changeCoins             uint64
+	// broadcastTxidErrs are the broadcast errors of the transactions, by txid
+	broadcastTxidErrs map[string]error
+	confirmErr        error
+	txidConfirmMap    map[string]bool
+	changeAddr        string
+	changeCoins       uint64
 }
 
 func newDummySender() *dummySender {
@@ -63,9 +69,13 @@ func (s *dummySender) CreateTransaction(destAddr string, coins uint64) (*coin.Tr
 	}, nil
 }
 
-func (s *dummySender) BroadcastTransaction(tx *coin.Transaction) *sender.BroadcastTxResponse {
+func (s *dummySender) BroadcastTransaction(tx *coin.Transaction, key string) *sender.BroadcastTxResponse {
+	s.RLock()
+	defer s.RUnlock()
+
 	req := sender.BroadcastTxRequest{
 		Tx:   tx,
+		Key:  key,
 		RspC: make(chan *sender.BroadcastTxResponse, 1),
 	}
 
@@ -76,6 +86,13 @@ func (s *dummySender) BroadcastTransaction(tx *coin.Transaction) *sender.Broadca
 		}
 	}
 
+	if err := s.broadcastTxidErrs[tx.TxIDHex()]; err != nil {
+		return &sender.BroadcastTxResponse{
+			Err: err,
+			Req: req,
+		}
+	}
+
 	return &sender.BroadcastTxResponse{
 		Txid: tx.TxIDHex(),
 		Req:  req,
@@ -117,9 +134,32 @@ func (s *dummySender) setTxConfirmed(txid string) {
 	s.txidConfirmMap[txid] = true
 }
 
+type trackedEvent struct {
+	name    string
+	skyAddr string
+	props   analytics.Properties
+}
+
+type dummyTracker struct {
+	sync.Mutex
+	events []trackedEvent
+}
+
+func (t *dummyTracker) Track(name, skyAddr string, props analytics.Properties) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.events = append(t.events, trackedEvent{
+		name:    name,
+		skyAddr: skyAddr,
+		props:   props,
+	})
+}
+
 type dummyScanner struct {
-	dvC   chan scanner.DepositNote
-	addrs []string
+	dvC     chan scanner.DepositNote
+	addrs   []string
+	pending []deposits.Deposit
 }
 
 func newDummyScanner() *dummyScanner {
@@ -137,6 +177,22 @@ func (scan *dummyScanner) GetDeposit() <-chan scanner.DepositNote {
 	return scan.dvC
 }
 
+func (scan *dummyScanner) PendingDeposits(coinType string) []deposits.Deposit {
+	var dvs []deposits.Deposit
+	for _, dv := range scan.pending {
+		if dv.CoinType == coinType {
+			dvs = append(dvs, dv)
+		}
+	}
+	return dvs
+}
+
+func (scan *dummyScanner) Run() error {
+	return nil
+}
+
+func (scan *dummyScanner) Shutdown() {}
+
 func (scan *dummyScanner) GetScanAddresses() ([]string, error) {
 	return []string{}, nil
 }
@@ -167,9 +223,9 @@ func newTestExchange(t *testing.T, log *logrus.Logger, db *bolt.DB) *Exchange {
 	multiplexer := scanner.NewMultiplexer(log)
 	multiplexer.AddScanner(bscr, scanner.CoinTypeBTC)
 	multiplexer.AddScanner(escr, scanner.CoinTypeETH)
-	go multiplexer.Multiplex()
+	go multiplexer.Run()
 
-	e, err := NewExchange(log, store, multiplexer, newDummySender(), Config{
+	e, err := NewExchange(log, store, multiplexer, newDummySender(), analytics.Noop{}, Config{
 		BtcRate:                 testSkyBtcRate,
 		TxConfirmationCheckWait: time.Millisecond * 100,
 	})
@@ -212,6 +268,7 @@ func runExchange(t *testing.T) (*Exchange, func(), *logrus_test.Hook) {
 
 func runExchangeMockStore(t *testing.T) (*Exchange, func(), *logrus_test.Hook) {
 	store := &MockStore{}
+	store.On("RecordRate", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(false, nil)
 	log, hook := testutil.NewLogger(t)
 
 	bscr := newDummyScanner()
@@ -219,9 +276,9 @@ func runExchangeMockStore(t *testing.T) (*Exchange, func(), *logrus_test.Hook) {
 	multiplexer := scanner.NewMultiplexer(log)
 	multiplexer.AddScanner(bscr, scanner.CoinTypeBTC)
 	multiplexer.AddScanner(escr, scanner.CoinTypeETH)
-	go multiplexer.Multiplex()
+	go multiplexer.Run()
 
-	e, err := NewExchange(log, store, multiplexer, newDummySender(), Config{
+	e, err := NewExchange(log, store, multiplexer, newDummySender(), analytics.Noop{}, Config{
 		BtcRate:                 testSkyBtcRate,
 		TxConfirmationCheckWait: time.Millisecond * 100,
 	})
@@ -265,7 +322,7 @@ func TestExchangeRunSend(t *testing.T) {
 
 	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
-	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC)
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	var value int64 = 1e8
@@ -274,13 +331,14 @@ func TestExchangeRunSend(t *testing.T) {
 	txid := e.sender.(*dummySender).predictTxid(t, skyAddr, skySent)
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    value,
+			Amount:   value,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -327,6 +385,7 @@ func TestExchangeRunSend(t *testing.T) {
 
 	expectedDeposit := DepositInfo{
 		Seq:            1,
+		Version:        2,
 		CoinType:       scanner.CoinTypeBTC,
 		UpdatedAt:      di.UpdatedAt,
 		Status:         StatusWaitConfirm,
@@ -336,7 +395,7 @@ func TestExchangeRunSend(t *testing.T) {
 		Txid:           txid,
 		SkySent:        100e6,
 		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
+		DepositValue:   dn.Deposit.Amount,
 		Deposit:        dn.Deposit,
 	}
 
@@ -374,6 +433,7 @@ func TestExchangeRunSend(t *testing.T) {
 	require.NotEmpty(t, di.UpdatedAt)
 
 	expectedDeposit = DepositInfo{
+		Version:        3,
 		Seq:            1,
 		CoinType:       scanner.CoinTypeBTC,
 		UpdatedAt:      di.UpdatedAt,
@@ -384,7 +444,7 @@ func TestExchangeRunSend(t *testing.T) {
 		Txid:           txid,
 		SkySent:        100e6,
 		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
+		DepositValue:   dn.Deposit.Amount,
 		Deposit:        dn.Deposit,
 	}
 
@@ -392,32 +452,279 @@ func TestExchangeRunSend(t *testing.T) {
 	closeMultiplexer(e)
 }
 
+// testPluginCoinType is a coin type added by a scanner plugin, 1 coin is 1e6 of its units
+const testPluginCoinType = "TPC"
+
+func registerTestPluginCoin(t *testing.T) {
+	if _, err := deposits.GetCoin(testPluginCoinType); err == nil {
+		return
+	}
+	require.NoError(t, deposits.RegisterCoin(deposits.Coin{
+		Type:     testPluginCoinType,
+		Decimals: 6,
+	}))
+}
+
+func TestExchangeRunSendPluginCoin(t *testing.T) {
+	registerTestPluginCoin(t)
+
+	db, shutdownDB := testutil.PrepareDB(t)
+	defer shutdownDB()
+
+	log, _ := testutil.NewLogger(t)
+
+	// The store creates the buckets of the registered coin types
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	scr := newDummyScanner()
+	multiplexer := scanner.NewMultiplexer(log)
+	require.NoError(t, multiplexer.AddScanner(scr, testPluginCoinType))
+	go multiplexer.Run()
+	defer multiplexer.Shutdown()
+
+	e, err := NewExchange(log, store, multiplexer, newDummySender(), analytics.Noop{}, Config{
+		BtcRate:                 testSkyBtcRate,
+		CoinRates:               map[string]string{testPluginCoinType: "2"},
+		TxConfirmationCheckWait: time.Millisecond * 100,
+	})
+	require.NoError(t, err)
+
+	rate, err := e.CurrentRate(testPluginCoinType)
+	require.NoError(t, err)
+	require.Equal(t, "2", rate)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, e.Run())
+	}()
+	defer func() {
+		scr.stop()
+		e.Shutdown()
+		<-done
+	}()
+
+	require.NoError(t, e.BindAddress(testSkyAddr, "tpc-addr", testPluginCoinType, ""))
+	boundAddr, err := e.store.GetBindAddress("tpc-addr", testPluginCoinType)
+	require.NoError(t, err)
+	require.Equal(t, testSkyAddr, boundAddr)
+
+	dn := scanner.DepositNote{
+		Deposit: deposits.Deposit{
+			CoinType: testPluginCoinType,
+			Address:  "tpc-addr",
+			Amount:   1500000,
+			Height:   20,
+			Tx:       "tpc-tx",
+			N:        0,
+			Final:    true,
+		},
+		ErrC: make(chan error, 1),
+	}
+	scr.addDeposit(dn)
+	require.NoError(t, <-dn.ErrC)
+
+	// 1.5 coins are converted at 2 SKY per coin
+	var di DepositInfo
+	for i := 0; di.Status != StatusWaitConfirm; i++ {
+		require.True(t, i < 100, "Waiting for sent deposit timed out")
+		time.Sleep(time.Millisecond * 50)
+		di, err = e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, testPluginCoinType, di.CoinType)
+	require.Equal(t, "2", di.ConversionRate)
+	require.Equal(t, uint64(3e6), di.SkySent)
+
+	history, err := e.GetRateHistory(testPluginCoinType)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, "2", history[0].Rate)
+}
+
+func TestExchangeQuiesce(t *testing.T) {
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	e.Quiesce()
+
+	dn := scanner.DepositNote{
+		Deposit: deposits.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Amount:   1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+			Final:    true,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.multiplexer.(*scanner.Multiplexer)
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// Deposits are still saved while quiesced
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	// but not sent
+	time.Sleep(dbCheckWaitTime * 5)
+	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+
+	e.Resume()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-time.After(dbCheckWaitTime):
+				di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+				require.NoError(t, err)
+				if di.Status == StatusWaitConfirm {
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting for sent deposit timed out")
+	}
+
+	// Shutdown doesn't wait for a resume
+	e.Quiesce()
+	closeMultiplexer(e)
+}
+
+func TestExchangePauseGates(t *testing.T) {
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	skyAddr := testSkyAddr
+	btcAddr := "foo-btc-addr"
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	e.DispatchGate().Pause()
+	e.SendGate().Pause()
+
+	dn := scanner.DepositNote{
+		Deposit: deposits.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  btcAddr,
+			Amount:   1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        2,
+			Final:    true,
+		},
+		ErrC: make(chan error, 1),
+	}
+	mp := e.multiplexer.(*scanner.Multiplexer)
+	mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+
+	// The deposit is not saved while dispatching is paused
+	select {
+	case <-dn.ErrC:
+		t.Fatal("Deposit was saved while paused")
+	case <-time.After(dbCheckWaitTime * 5):
+	}
+
+	e.DispatchGate().Resume()
+	err = <-dn.ErrC
+	require.NoError(t, err)
+
+	// and not sent while sending is paused
+	time.Sleep(dbCheckWaitTime * 5)
+	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+	require.True(t, e.SendGate().Status().Paused)
+	require.False(t, e.SendGate().Status().Busy)
+
+	e.SendGate().Resume()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-time.After(dbCheckWaitTime):
+				di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+				require.NoError(t, err)
+				if di.Status == StatusWaitConfirm {
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting for sent deposit timed out")
+	}
+
+	// Shutdown doesn't wait for a resume
+	e.SendGate().Pause()
+	closeMultiplexer(e)
+}
+
+// waitSendFailures waits until the exchange's consecutive send failures satisfy ok
+func waitSendFailures(t *testing.T, e *Exchange, ok func(int) bool) {
+	timeout := time.After(dbScanTimeout)
+	for !ok(e.SendFailures()) {
+		select {
+		case <-time.After(dbCheckWaitTime):
+		case <-timeout:
+			t.Fatalf("Waiting for send failures timed out, %d send failures", e.SendFailures())
+		}
+	}
+}
+
 func TestExchangeUpdateBroadcastTxFailure(t *testing.T) {
 	// Test that a BroadcastTransaction error is handled properly
-	// The DepositInfo should not be updated if BroadcastTransaction fails.
+	// The signed transaction is saved to the outbox before it is broadcast,
+	// so the deposit moves to StatusWaitConfirm and the same transaction is
+	// broadcast again until it succeeds.
 	// Test that we save the rate when first creating, not on send
-	// To do this, force the sender to return errors to block the transition to
-	// StatusWaitConfirm
 	e, shutdown, _ := runExchange(t)
 	defer shutdown()
 	defer e.Shutdown()
 
 	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
-	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC)
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
-	// Force sender to return a broadcast tx error so that the deposit stays at StatusWaitSend
-	e.sender.(*dummySender).broadcastTransactionErr = errors.New("fake broadcast transaction error")
+	// Force sender to return a broadcast tx error so that the outbox entry stays pending
+	e.sender.(*dummySender).Lock()
+	e.sender.(*dummySender).broadcastTransactionErr = sender.NewRPCError(errors.New("fake broadcast transaction error"))
+	e.sender.(*dummySender).Unlock()
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    1e8,
+			Amount:   1e8,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -429,23 +736,80 @@ func TestExchangeUpdateBroadcastTxFailure(t *testing.T) {
 	err = <-dn.ErrC
 	require.NoError(t, err)
 
-	// Check the DepositInfo in the database
-	// Sky should not be sent
+	txid := e.sender.(*dummySender).predictTxid(t, skyAddr, 100e6)
+
+	// The outbox entry is saved with the status change
+	waitOutboxEntry := func(broadcast bool) OutboxEntry {
+		var entry OutboxEntry
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-time.After(dbCheckWaitTime):
+					var ok bool
+					var err error
+					entry, ok, err = e.store.GetOutboxEntry(txid)
+					require.NoError(t, err)
+					if ok && (entry.BroadcastAt != 0) == broadcast {
+						return
+					}
+				}
+			}
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(dbScanTimeout):
+			t.Fatal("Waiting for outbox entry timed out")
+		}
+
+		return entry
+	}
+
+	entry := waitOutboxEntry(false)
+	require.Equal(t, dn.Deposit.ID(), entry.DepositID)
+	require.NotEmpty(t, entry.CreatedAt)
+
+	// Each pending broadcast is a send failure
+	waitSendFailures(t, e, func(n int) bool { return n >= 1 })
+
+	skyTx, err := entry.Transaction()
+	require.NoError(t, err)
+	require.Equal(t, txid, skyTx.TxIDHex())
+
 	di, err := e.store.(*Store).getDepositInfo(dn.Deposit.ID())
 	require.NoError(t, err)
 	require.NotEmpty(t, di.UpdatedAt)
 	require.Equal(t, DepositInfo{
 		Seq:            1,
+		Version:        2,
 		CoinType:       scanner.CoinTypeBTC,
 		UpdatedAt:      di.UpdatedAt,
 		SkyAddress:     skyAddr,
 		DepositAddress: btcAddr,
 		DepositID:      dn.Deposit.ID(),
-		Status:         StatusWaitSend,
+		Status:         StatusWaitConfirm,
+		Txid:           txid,
+		SkySent:        100e6,
 		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
+		DepositValue:   dn.Deposit.Amount,
 		Deposit:        dn.Deposit,
 	}, di)
+
+	// The saved transaction is broadcast once the node accepts it
+	e.sender.(*dummySender).Lock()
+	e.sender.(*dummySender).broadcastTransactionErr = nil
+	e.sender.(*dummySender).Unlock()
+
+	entry = waitOutboxEntry(true)
+	require.NotEmpty(t, entry.BroadcastAt)
+	waitSendFailures(t, e, func(n int) bool { return n == 0 })
+
+	di, err = e.store.(*Store).getDepositInfo(dn.Deposit.ID())
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, txid, di.Txid)
 }
 
 func TestExchangeCreateTxFailure(t *testing.T) {
@@ -459,20 +823,21 @@ func TestExchangeCreateTxFailure(t *testing.T) {
 
 	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
-	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC)
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	// Force sender to return a create tx error so that the deposit stays at StatusWaitSend
 	e.sender.(*dummySender).createTransactionErr = errors.New("fake create transaction error")
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    1e8,
+			Amount:   1e8,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -490,6 +855,7 @@ func TestExchangeCreateTxFailure(t *testing.T) {
 	require.NotEmpty(t, di.UpdatedAt)
 	require.Equal(t, DepositInfo{
 		Seq:            1,
+		Version:        1,
 		CoinType:       scanner.CoinTypeBTC,
 		UpdatedAt:      di.UpdatedAt,
 		SkyAddress:     skyAddr,
@@ -497,7 +863,7 @@ func TestExchangeCreateTxFailure(t *testing.T) {
 		DepositID:      dn.Deposit.ID(),
 		Status:         StatusWaitSend,
 		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
+		DepositValue:   dn.Deposit.Amount,
 		Deposit:        dn.Deposit,
 	}, di)
 }
@@ -509,7 +875,7 @@ func TestExchangeTxConfirmFailure(t *testing.T) {
 
 	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
-	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC)
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	var value int64 = 1e8
@@ -521,13 +887,14 @@ func TestExchangeTxConfirmFailure(t *testing.T) {
 	e.sender.(*dummySender).confirmErr = errors.New("fake confirm error")
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    value,
+			Amount:   value,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -567,6 +934,7 @@ func TestExchangeTxConfirmFailure(t *testing.T) {
 	require.NotEmpty(t, di.UpdatedAt)
 	require.Equal(t, DepositInfo{
 		Seq:            1,
+		Version:        2,
 		CoinType:       scanner.CoinTypeBTC,
 		UpdatedAt:      di.UpdatedAt,
 		SkyAddress:     skyAddr,
@@ -574,7 +942,7 @@ func TestExchangeTxConfirmFailure(t *testing.T) {
 		DepositID:      dn.Deposit.ID(),
 		Txid:           txid,
 		SkySent:        100e6,
-		DepositValue:   dn.Deposit.Value,
+		DepositValue:   dn.Deposit.Amount,
 		Status:         StatusWaitConfirm,
 		ConversionRate: testSkyBtcRate,
 		Deposit:        dn.Deposit,
@@ -588,7 +956,7 @@ func TestExchangeQuitBeforeConfirm(t *testing.T) {
 
 	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
-	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC)
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	var value int64 = 1e8
@@ -597,13 +965,14 @@ func TestExchangeQuitBeforeConfirm(t *testing.T) {
 	txid := e.sender.(*dummySender).predictTxid(t, skyAddr, skySent)
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    value,
+			Amount:   value,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -620,6 +989,7 @@ func TestExchangeQuitBeforeConfirm(t *testing.T) {
 
 	expectedDeposit := DepositInfo{
 		Seq:            1,
+		Version:        2,
 		CoinType:       scanner.CoinTypeBTC,
 		Status:         StatusWaitConfirm,
 		SkyAddress:     skyAddr,
@@ -627,7 +997,7 @@ func TestExchangeQuitBeforeConfirm(t *testing.T) {
 		DepositID:      dn.Deposit.ID(),
 		Txid:           txid,
 		SkySent:        100e6,
-		DepositValue:   dn.Deposit.Value,
+		DepositValue:   dn.Deposit.Amount,
 		ConversionRate: testSkyBtcRate,
 		Deposit:        dn.Deposit,
 	}
@@ -684,17 +1054,18 @@ func TestExchangeSendZeroCoins(t *testing.T) {
 
 	skyAddr := testSkyAddr
 	btcAddr := "foo-btc-addr"
-	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC)
+	err := e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    1, // The amount is so low that no SKY can be sent
+			Amount:   1, // The amount is so low that no SKY can be sent
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -711,6 +1082,7 @@ func TestExchangeSendZeroCoins(t *testing.T) {
 
 	expectedDeposit := DepositInfo{
 		Seq:            1,
+		Version:        2,
 		CoinType:       scanner.CoinTypeBTC,
 		Status:         StatusDone,
 		SkyAddress:     skyAddr,
@@ -719,7 +1091,7 @@ func TestExchangeSendZeroCoins(t *testing.T) {
 		Txid:           "",
 		SkySent:        0,
 		ConversionRate: testSkyBtcRate,
-		DepositValue:   dn.Deposit.Value,
+		DepositValue:   dn.Deposit.Amount,
 		Deposit:        dn.Deposit,
 		Error:          ErrEmptySendAmount.Error(),
 	}
@@ -853,6 +1225,9 @@ func testExchangeRunProcessDepositBacklog(t *testing.T, dis []DepositInfo, confi
 		require.NotEmpty(t, confirmed[i].UpdatedAt)
 		expectedDis[i].UpdatedAt = confirmed[i].UpdatedAt
 
+		require.True(t, confirmed[i].Version > di.Version)
+		expectedDis[i].Version = confirmed[i].Version
+
 		require.Equal(t, expectedDis[i], confirmed[i])
 	}
 }
@@ -881,13 +1256,14 @@ func TestExchangeProcessUnconfirmedTx(t *testing.T) {
 			SkySent:        skySent,
 			ConversionRate: testSkyBtcRate,
 			DepositValue:   depositValue,
-			Deposit: scanner.Deposit{
+			Deposit: deposits.Deposit{
 				CoinType: scanner.CoinTypeBTC,
 				Address:  "foo-btc-addr-1",
-				Value:    depositValue,
+				Amount:   depositValue,
 				Height:   20,
 				Tx:       "foo-tx-1",
 				N:        1,
+				Final:    true,
 			},
 		},
 		{
@@ -900,13 +1276,14 @@ func TestExchangeProcessUnconfirmedTx(t *testing.T) {
 			SkySent:        skySent,
 			ConversionRate: testSkyBtcRate,
 			DepositValue:   depositValue,
-			Deposit: scanner.Deposit{
+			Deposit: deposits.Deposit{
 				CoinType: scanner.CoinTypeBTC,
 				Address:  "foo-btc-addr-2",
-				Value:    depositValue,
+				Amount:   depositValue,
 				Height:   20,
 				Tx:       "foo-tx-2",
 				N:        2,
+				Final:    true,
 			},
 		},
 	}
@@ -940,13 +1317,14 @@ func TestExchangeProcessWaitSendDeposits(t *testing.T) {
 			Txid:           txid1,
 			ConversionRate: testSkyBtcRate,
 			DepositValue:   depositValue,
-			Deposit: scanner.Deposit{
+			Deposit: deposits.Deposit{
 				CoinType: scanner.CoinTypeBTC,
 				Address:  "foo-btc-addr-1",
-				Value:    depositValue,
+				Amount:   depositValue,
 				Height:   20,
 				Tx:       "foo-tx-1",
 				N:        1,
+				Final:    true,
 			},
 		},
 		{
@@ -959,19 +1337,20 @@ func TestExchangeProcessWaitSendDeposits(t *testing.T) {
 			Txid:           txid2,
 			ConversionRate: testSkyBtcRate,
 			DepositValue:   depositValue,
-			Deposit: scanner.Deposit{
+			Deposit: deposits.Deposit{
 				CoinType: scanner.CoinTypeBTC,
 				Address:  "foo-btc-addr-2",
-				Value:    depositValue,
+				Amount:   depositValue,
 				Height:   20,
 				Tx:       "foo-tx-2",
 				N:        2,
+				Final:    true,
 			},
 		},
 	}
 
 	testExchangeRunProcessDepositBacklog(t, dis, func(e *Exchange, di DepositInfo) {
-		err := e.store.BindAddress(di.SkyAddress, di.DepositAddress, di.CoinType)
+		err := e.store.BindAddress(di.SkyAddress, di.DepositAddress, di.CoinType, "")
 		require.NoError(t, err)
 
 		skySent, err := CalculateBtcSkyValue(di.DepositValue, di.ConversionRate, testMaxDecimals)
@@ -996,13 +1375,14 @@ func TestExchangeSaveIncomingDepositCreateDepositFailed(t *testing.T) {
 	btcAddr := "foo-btc-addr"
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    1e8,
+			Amount:   1e8,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -1018,7 +1398,7 @@ func TestExchangeSaveIncomingDepositCreateDepositFailed(t *testing.T) {
 
 	// Return error on GetOrCreateDepositInfo
 	createDepositErr := errors.New("GetOrCreateDepositInfo failed")
-	e.store.(*MockStore).On("GetOrCreateDepositInfo", dn.Deposit, testSkyBtcRate).Return(DepositInfo{}, createDepositErr)
+	e.store.(*MockStore).On("GetOrCreateQuotedDepositInfo", dn.Deposit, testSkyBtcRate, (*ConversionQuote)(nil), (*DisplayValue)(nil)).Return(DepositInfo{}, createDepositErr)
 
 	// First loop calls saveIncomingDeposit
 	// err is written to ErrC after this method finishes
@@ -1029,7 +1409,7 @@ func TestExchangeSaveIncomingDepositCreateDepositFailed(t *testing.T) {
 	// Check that we logged the failed save, so that we can recover it later
 	logEntry := hook.LastEntry()
 	require.Equal(t, logEntry.Message, "saveIncomingDeposit failed. This deposit will not be reprocessed until teller is restarted.")
-	loggedDeposit := logEntry.Data["deposit"].(scanner.Deposit)
+	loggedDeposit := logEntry.Data["deposit"].(deposits.Deposit)
 	require.Equal(t, dn.Deposit, loggedDeposit)
 }
 
@@ -1048,13 +1428,14 @@ func TestExchangeProcessWaitSendDepositFailed(t *testing.T) {
 	btcAddr := "foo-btc-addr"
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    1e8,
+			Amount:   1e8,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -1082,7 +1463,10 @@ func TestExchangeProcessWaitSendDepositFailed(t *testing.T) {
 		ConversionRate: testSkyBtcRate,
 		Deposit:        dn.Deposit,
 	}
-	e.store.(*MockStore).On("GetOrCreateDepositInfo", dn.Deposit, testSkyBtcRate).Return(di, nil)
+	e.store.(*MockStore).On("GetOrCreateQuotedDepositInfo", dn.Deposit, testSkyBtcRate, (*ConversionQuote)(nil), (*DisplayValue)(nil)).Return(di, nil)
+
+	// GetDepositInfoOfSkyAddress is called to check for the first deposit
+	e.store.(*MockStore).On("GetDepositInfoOfSkyAddress", skyAddr).Return([]DepositInfo{di}, nil).Once()
 
 	// UpdateDepositInfo fails
 	updateDepositInfoErr := errors.New("UpdateDepositInfo error")
@@ -1143,13 +1527,14 @@ func TestExchangeProcessWaitSendNoSkyAddrBound(t *testing.T) {
 	btcAddr := "foo-btc-addr"
 
 	dn := scanner.DepositNote{
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  btcAddr,
-			Value:    1e8,
+			Amount:   1e8,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        2,
+			Final:    true,
 		},
 		ErrC: make(chan error, 1),
 	}
@@ -1165,7 +1550,7 @@ func TestExchangeProcessWaitSendNoSkyAddrBound(t *testing.T) {
 	// Check that we logged the failed save, so that we can recover it later
 	logEntry := hook.LastEntry()
 	require.Equal(t, logEntry.Message, "saveIncomingDeposit failed. This deposit will not be reprocessed until teller is restarted.")
-	loggedDeposit := logEntry.Data["deposit"].(scanner.Deposit)
+	loggedDeposit := logEntry.Data["deposit"].(deposits.Deposit)
 	require.Equal(t, dn.Deposit, loggedDeposit)
 }
 
@@ -1187,7 +1572,7 @@ func TestExchangeBindAddress(t *testing.T) {
 
 	require.Len(t, dummyScanner.addrs, 0)
 
-	err = s.BindAddress("a", "b", scanner.CoinTypeBTC)
+	err = s.BindAddress("a", "b", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	// Should be added to dummyScanner
@@ -1198,6 +1583,309 @@ func TestExchangeBindAddress(t *testing.T) {
 	skyAddr, err := s.store.GetBindAddress("b", scanner.CoinTypeBTC)
 	require.NoError(t, err)
 	require.Equal(t, "a", skyAddr)
+
+	bound, err := s.IsBound("b", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.True(t, bound)
+
+	bound, err = s.IsBound("c", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.False(t, bound)
+
+	skyAddr, err = s.GetBoundSkyAddress("b", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "a", skyAddr)
+
+	skyAddr, err = s.GetBoundSkyAddress("c", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Empty(t, skyAddr)
+}
+
+func TestExchangeTrackEvents(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+	tracker := &dummyTracker{}
+	e.tracker = tracker
+
+	skyAddr := testSkyAddr
+	err := e.store.BindAddress(skyAddr, "foo-btc-addr", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+	err = e.store.BindAddress(skyAddr, "bar-btc-addr", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	// Only the first deposit of the skycoin address is tracked
+	di, err := e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "foo-btc-addr",
+		Amount:   1e8,
+		Height:   20,
+		Tx:       "foo-tx",
+		N:        2,
+		Final:    true,
+	})
+	require.NoError(t, err)
+
+	_, err = e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "bar-btc-addr",
+		Amount:   1e8,
+		Height:   21,
+		Tx:       "bar-tx",
+		N:        1,
+		Final:    true,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []trackedEvent{
+		{
+			name:    analytics.EventFirstDeposit,
+			skyAddr: skyAddr,
+			props: analytics.Properties{
+				"coin_type": scanner.CoinTypeBTC,
+			},
+		},
+	}, tracker.events)
+
+	// The payout is tracked once the sent coins are confirmed
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Len(t, tracker.events, 1)
+
+	e.sender.(*dummySender).setTxConfirmed(di.Txid)
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusDone, di.Status)
+
+	require.Len(t, tracker.events, 2)
+	require.Equal(t, trackedEvent{
+		name:    analytics.EventPayoutCompleted,
+		skyAddr: skyAddr,
+		props: analytics.Properties{
+			"coin_type": scanner.CoinTypeBTC,
+			"sky_sent":  di.SkySent,
+		},
+	}, tracker.events[1])
+}
+
+func TestExchangeRateGuardReview(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, hook := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+	rateGuard, err := NewRateGuard(RateGuardConfig{
+		ReferenceRates: map[string]string{
+			scanner.CoinTypeBTC: "50",
+		},
+		MaxDeviation: 10,
+	})
+	require.NoError(t, err)
+	e.rateGuard = rateGuard
+
+	skyAddr := testSkyAddr
+	err = e.store.BindAddress(skyAddr, "foo-btc-addr", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	di, err := e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "foo-btc-addr",
+		Amount:   1e8,
+		Height:   20,
+		Tx:       "foo-tx",
+		N:        2,
+		Final:    true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, testSkyBtcRate, di.ConversionRate)
+
+	// The rate deviates from the reference rate, the deposit is held for review
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.NotEmpty(t, di.Error)
+	require.Empty(t, di.Txid)
+	var alerted bool
+	for _, e := range hook.AllEntries() {
+		if e.Data["alert"] == "rate_guard" {
+			alerted = true
+		}
+	}
+	require.True(t, alerted)
+
+	// Handling the deposit again doesn't send it
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitReview, di.Status)
+
+	// Invalid rates are rejected
+	_, err = e.ApproveDeposit(di.DepositID, "foo", 0)
+	require.Error(t, err)
+
+	// Approving the deposit at a corrected rate requeues it
+	di, err = e.ApproveDeposit(di.DepositID, "50", 0)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+	require.True(t, di.RateReviewed)
+	require.Empty(t, di.Error)
+	require.Equal(t, "50", di.ConversionRate)
+	require.Equal(t, di, <-e.depositChan)
+
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(50e6), di.SkySent)
+
+	// Deposits which aren't held for review can't be approved
+	_, err = e.ApproveDeposit(di.DepositID, "", 0)
+	require.Equal(t, ErrDepositNotInReview, err)
+
+	saved, err := e.store.(*Store).getDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, saved.Status)
+
+	_, err = e.ApproveDeposit("bar-tx:1", "", 0)
+	require.IsType(t, dbutil.ObjectNotExistErr{}, err)
+}
+
+func TestExchangeSkyAmountOverflowReview(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, hook := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+
+	skyAddr := testSkyAddr
+	err := e.store.BindAddress(skyAddr, "foo-btc-addr", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	di, err := e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "foo-btc-addr",
+		Amount:   1e8,
+		Height:   20,
+		Tx:       "foo-tx",
+		N:        2,
+		Final:    true,
+	})
+	require.NoError(t, err)
+
+	// 1 BTC at this rate is more droplets than an int64 holds
+	di, err = e.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.ConversionRate = "1e20"
+		return di
+	})
+	require.NoError(t, err)
+
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Equal(t, ErrSkyAmountOverflow.Error(), di.Error)
+	require.Empty(t, di.Txid)
+	require.Zero(t, di.SkySent)
+	var alerted bool
+	for _, e := range hook.AllEntries() {
+		if e.Data["alert"] == "sky_amount_overflow" {
+			alerted = true
+		}
+	}
+	require.True(t, alerted)
+
+	// Approving at the same rate holds it again
+	di, err = e.ApproveDeposit(di.DepositID, "", 0)
+	require.NoError(t, err)
+	require.Equal(t, di, <-e.depositChan)
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitReview, di.Status)
+
+	di, err = e.ApproveDeposit(di.DepositID, "500", 0)
+	require.NoError(t, err)
+	require.Equal(t, di, <-e.depositChan)
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, uint64(500e6), di.SkySent)
+}
+
+type dummyAddressSeer struct {
+	seen map[string]bool
+	err  error
+}
+
+func (s dummyAddressSeer) AddressSeen(addr string) (bool, error) {
+	return s.seen[addr], s.err
+}
+
+func TestExchangeFirstUse(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+
+	err := e.store.BindAddress(testSkyAddr, "foo-btc-addr", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	send := func(n uint32) DepositInfo {
+		di, err := e.saveIncomingDeposit(deposits.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  "foo-btc-addr",
+			Amount:   1e8,
+			Height:   20,
+			Tx:       "foo-tx",
+			N:        n,
+			Final:    true,
+		})
+		require.NoError(t, err)
+
+		di, err = e.handleDepositInfoState(di)
+		require.NoError(t, err)
+		require.Equal(t, StatusWaitConfirm, di.Status)
+		return di
+	}
+
+	// Not checked unless enabled
+	di := send(0)
+	require.False(t, di.FirstUse)
+
+	e.CheckFirstUse(dummyAddressSeer{})
+	di = send(1)
+	require.True(t, di.FirstUse)
+
+	saved, err := e.store.(*Store).getDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.True(t, saved.FirstUse)
+
+	dss, err := e.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 2)
+	require.False(t, dss[0].FirstUse)
+	require.True(t, dss[1].FirstUse)
+
+	details, err := e.GetDepositStatusDetail(func(di DepositInfo) bool {
+		return di.DepositID == saved.DepositID
+	})
+	require.NoError(t, err)
+	require.Len(t, details, 1)
+	require.True(t, details[0].FirstUse)
+
+	// An address which received coins isn't flagged
+	e.CheckFirstUse(dummyAddressSeer{
+		seen: map[string]bool{testSkyAddr: true},
+	})
+	di = send(2)
+	require.False(t, di.FirstUse)
+
+	// A failed lookup doesn't hold the send
+	e.CheckFirstUse(dummyAddressSeer{
+		err: errors.New("node unavailable"),
+	})
+	di = send(3)
+	require.False(t, di.FirstUse)
 }
 
 func TestExchangeCreateTransaction(t *testing.T) {
@@ -1206,7 +1894,7 @@ func TestExchangeCreateTransaction(t *testing.T) {
 	}
 
 	log, _ := testutil.NewLogger(t)
-	s, err := NewExchange(log, nil, nil, newDummySender(), cfg)
+	s, err := NewExchange(log, nil, nil, newDummySender(), analytics.Noop{}, cfg)
 	require.NoError(t, err)
 
 	// Create transaction with no SkyAddress
@@ -1259,11 +1947,280 @@ func TestExchangeCreateTransaction(t *testing.T) {
 }
 
 func TestExchangeGetDepositStatuses(t *testing.T) {
-	// TODO
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	scan := newDummyScanner()
+	s := &Exchange{
+		store:       store,
+		multiplexer: scan,
+	}
+
+	_, err = s.GetDepositStatuses(testSkyAddr)
+	require.Equal(t, ErrSkyAddressNotBound, err)
+
+	err = store.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+	err = store.BindAddress(testSkyAddr, "ethaddr1", scanner.CoinTypeETH, "")
+	require.NoError(t, err)
+
+	_, err = store.addDepositInfo(DepositInfo{
+		CoinType:       scanner.CoinTypeBTC,
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr1",
+		DepositID:      "btctx:1",
+		DepositValue:   1e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+		Deposit: deposits.Deposit{
+			CoinType:      scanner.CoinTypeBTC,
+			Address:       "btcaddr1",
+			Tx:            "btctx",
+			N:             1,
+			Amount:        1e8,
+			Confirmations: 3,
+			Final:         true,
+		},
+	})
+	require.NoError(t, err)
+
+	dss, err := s.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 2)
+
+	byCoin := make(map[string]DepositStatus, len(dss))
+	for _, ds := range dss {
+		byCoin[ds.CoinType] = ds
+	}
+
+	require.Equal(t, StatusWaitSend.String(), byCoin[scanner.CoinTypeBTC].Status)
+	require.Equal(t, int64(3), byCoin[scanner.CoinTypeBTC].Confirmations)
+	require.Equal(t, StatusWaitDeposit.String(), byCoin[scanner.CoinTypeETH].Status)
+	require.Equal(t, int64(0), byCoin[scanner.CoinTypeETH].Confirmations)
+
+	// Deposits in a block which is not final yet are detected
+	scan.pending = []deposits.Deposit{
+		{
+			CoinType:      scanner.CoinTypeETH,
+			Address:       "ethaddr1",
+			Tx:            "ethtx",
+			Amount:        1e8,
+			Confirmations: 12,
+		},
+		{
+			CoinType:      scanner.CoinTypeBTC,
+			Address:       "btcaddr1",
+			Tx:            "btctx2",
+			Amount:        1e8,
+			Confirmations: 2,
+		},
+		// Already received
+		{
+			CoinType:      scanner.CoinTypeBTC,
+			Address:       "btcaddr1",
+			Tx:            "btctx",
+			N:             1,
+			Amount:        1e8,
+			Confirmations: 3,
+		},
+		// Not bound to the skycoin address
+		{
+			CoinType:      scanner.CoinTypeBTC,
+			Address:       "btcaddr2",
+			Tx:            "btctx3",
+			Amount:        1e8,
+			Confirmations: 2,
+		},
+	}
+
+	dss, err = s.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 3)
+
+	confirmations := make(map[string]int64, len(dss))
+	for _, ds := range dss {
+		confirmations[ds.CoinType+" "+ds.Status] = ds.Confirmations
+	}
+	require.Equal(t, map[string]int64{
+		"BTC " + StatusWaitSend.String(): 3,
+		"BTC " + DepositStatusDetected:   2,
+		"ETH " + DepositStatusDetected:   12,
+	}, confirmations)
 }
 
 func TestExchangeGetDepositStatusDetail(t *testing.T) {
-	// TODO
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	s := &Exchange{
+		store:       store,
+		multiplexer: newDummyScanner(),
+		cfg: Config{
+			Explorer: explorer.Links{
+				Deposits: map[string]explorer.Coin{
+					scanner.CoinTypeBTC: {
+						TxURL:      "https://blockstream.info/tx/{txid}",
+						AddressURL: "https://blockstream.info/address/{address}",
+					},
+				},
+				Payout: explorer.Coin{
+					TxURL: "https://explorer.skycoin.com/app/transaction/{txid}",
+				},
+			},
+		},
+	}
+
+	err = store.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+	err = store.BindAddress(testSkyAddr, "ethaddr1", scanner.CoinTypeETH, "")
+	require.NoError(t, err)
+
+	_, err = store.addDepositInfo(DepositInfo{
+		CoinType:       scanner.CoinTypeBTC,
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr1",
+		DepositID:      "btctx:1",
+		DepositValue:   1e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitConfirm,
+		Txid:           "skytx",
+		SkySent:        500e6,
+		Deposit: deposits.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  "btcaddr1",
+			Tx:       "btctx",
+			N:        1,
+			Amount:   1e8,
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = store.addDepositInfo(DepositInfo{
+		CoinType:       scanner.CoinTypeETH,
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "ethaddr1",
+		DepositID:      "ethtx:0",
+		DepositValue:   1e9,
+		ConversionRate: "50",
+		Status:         StatusWaitSend,
+		Deposit: deposits.Deposit{
+			CoinType: scanner.CoinTypeETH,
+			Address:  "ethaddr1",
+			Tx:       "ethtx",
+			Amount:   1e9,
+		},
+	})
+	require.NoError(t, err)
+
+	dss, err := s.GetDepositStatusDetail(func(di DepositInfo) bool {
+		return true
+	})
+	require.NoError(t, err)
+	require.Len(t, dss, 2)
+
+	byCoin := make(map[string]DepositStatusDetail, len(dss))
+	for _, ds := range dss {
+		byCoin[ds.CoinType] = ds
+	}
+
+	btc := byCoin[scanner.CoinTypeBTC]
+	require.Equal(t, StatusWaitConfirm.String(), btc.Status)
+	require.Equal(t, "skytx", btc.Txid)
+	require.Equal(t, DepositLinks{
+		DepositAddress: "https://blockstream.info/address/btcaddr1",
+		DepositTx:      "https://blockstream.info/tx/btctx",
+		Txid:           "https://explorer.skycoin.com/app/transaction/skytx",
+	}, btc.Explorer)
+
+	// A coin type without an explorer has no links
+	require.Equal(t, DepositLinks{}, byCoin[scanner.CoinTypeETH].Explorer)
+
+	// The public status links the skycoin transaction
+	statuses, err := s.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	for _, ds := range statuses {
+		if ds.CoinType == scanner.CoinTypeBTC {
+			require.Equal(t, "https://explorer.skycoin.com/app/transaction/skytx", ds.TxURL)
+		} else {
+			require.Empty(t, ds.TxURL)
+		}
+	}
+}
+
+type dummyReceiptLinker struct{}
+
+func (dummyReceiptLinker) Link(depositID string) string {
+	return "https://example.com/api/receipt?deposit_id=" + depositID
+}
+
+func TestExchangeGetDepositStatusesReceiptURL(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	s := &Exchange{
+		store:       store,
+		multiplexer: newDummyScanner(),
+	}
+
+	err = store.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+	err = store.BindAddress(testSkyAddr, "btcaddr2", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	for _, di := range []DepositInfo{
+		{
+			DepositAddress: "btcaddr1",
+			DepositID:      "btctx1:0",
+			Status:         StatusDone,
+			Txid:           "skytx",
+			SkySent:        500e6,
+		},
+		{
+			DepositAddress: "btcaddr2",
+			DepositID:      "btctx2:0",
+			Status:         StatusWaitSend,
+		},
+	} {
+		di.CoinType = scanner.CoinTypeBTC
+		di.SkyAddress = testSkyAddr
+		di.DepositValue = 1e8
+		di.ConversionRate = testSkyBtcRate
+		_, err = store.addDepositInfo(di)
+		require.NoError(t, err)
+	}
+
+	// Without receipts, no status has a receipt link
+	statuses, err := s.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	for _, ds := range statuses {
+		require.Empty(t, ds.ReceiptURL)
+	}
+
+	// Only the completed deposit has a receipt
+	s.cfg.Receipts = dummyReceiptLinker{}
+	statuses, err = s.GetDepositStatuses(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	for _, ds := range statuses {
+		if ds.Status == StatusDone.String() {
+			require.Equal(t, "https://example.com/api/receipt?deposit_id=btctx1:0", ds.ReceiptURL)
+		} else {
+			require.Empty(t, ds.ReceiptURL)
+		}
+	}
 }
 
 func TestExchangeGetBindNum(t *testing.T) {
@@ -1286,7 +2243,7 @@ func TestExchangeGetBindNum(t *testing.T) {
 	require.Equal(t, num, 0)
 	require.NoError(t, err)
 
-	err = s.store.BindAddress("a", "b", scanner.CoinTypeBTC)
+	err = s.store.BindAddress("a", "b", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	num, err = s.GetBindNum("a")
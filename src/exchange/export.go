@@ -0,0 +1,363 @@
+package exchange
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/shopspring/decimal"
+
+	"github.com/skycoin/teller/src/deposits"
+)
+
+// ExportFormat is the output format of a deposit export
+type ExportFormat string
+
+const (
+	// ExportCSV writes a header row, then one row per deposit
+	ExportCSV ExportFormat = "csv"
+	// ExportJSONL writes one JSON object per line per deposit
+	ExportJSONL ExportFormat = "jsonl"
+)
+
+// exportDateFormat is accepted in addition to RFC3339 for the export date range
+const exportDateFormat = "2006-01-02"
+
+var exportColumns = []string{
+	"seq",
+	"updated_at",
+	"status",
+	"coin_type",
+	"deposit_address",
+	"sky_address",
+	"deposit_id",
+	"deposit_value",
+	"deposit_amount",
+	"conversion_rate",
+	"sky_sent",
+	"txid",
+	"error",
+	"refund_value",
+	"refund_address",
+	"quote_currency",
+	"coin_price",
+	"sky_price",
+	"display_currency",
+	"display_value",
+	"display_price",
+}
+
+// NewExportFormatFromStr returns the ExportFormat named by s, defaulting to ExportCSV if s is empty
+func NewExportFormatFromStr(s string) (ExportFormat, error) {
+	switch ExportFormat(s) {
+	case "", ExportCSV:
+		return ExportCSV, nil
+	case ExportJSONL:
+		return ExportJSONL, nil
+	default:
+		return "", fmt.Errorf("Invalid export format %q", s)
+	}
+}
+
+// ExportFilter selects the deposits to export. Zero fields match all deposits.
+type ExportFilter struct {
+	// Deposits updated at or after From
+	From time.Time
+	// Deposits updated before To
+	To time.Time
+	// Deposits in any of Statuses
+	Statuses []Status
+	// Deposits of CoinType
+	CoinType string
+	// Deposits of at least MinAmount, in whole coins of the deposit's coin type
+	MinAmount decimal.Decimal
+}
+
+// NewExportFilter parses the export filter args. Dates are RFC3339 or YYYY-MM-DD (UTC),
+// statuses are comma separated and minAmount is in whole coins, e.g. "0.5".
+func NewExportFilter(from, to, statuses, coinType, minAmount string) (ExportFilter, error) {
+	var flt ExportFilter
+	var err error
+
+	if from != "" {
+		flt.From, err = parseExportDate(from)
+		if err != nil {
+			return ExportFilter{}, fmt.Errorf("Invalid from date: %v", err)
+		}
+	}
+
+	if to != "" {
+		flt.To, err = parseExportDate(to)
+		if err != nil {
+			return ExportFilter{}, fmt.Errorf("Invalid to date: %v", err)
+		}
+	}
+
+	if !flt.From.IsZero() && !flt.To.IsZero() && !flt.To.After(flt.From) {
+		return ExportFilter{}, fmt.Errorf("to date must be after from date")
+	}
+
+	if statuses != "" {
+		for _, s := range strings.Split(statuses, ",") {
+			st := NewStatusFromStr(strings.TrimSpace(s))
+			if st == StatusUnknown {
+				return ExportFilter{}, fmt.Errorf("Unknown status %q", s)
+			}
+			flt.Statuses = append(flt.Statuses, st)
+		}
+	}
+
+	if coinType != "" {
+		if _, err := deposits.GetCoin(coinType); err != nil {
+			return ExportFilter{}, err
+		}
+		flt.CoinType = coinType
+	}
+
+	if minAmount != "" {
+		flt.MinAmount, err = decimal.NewFromString(minAmount)
+		if err != nil {
+			return ExportFilter{}, fmt.Errorf("Invalid min amount: %v", err)
+		}
+		if flt.MinAmount.Sign() < 0 {
+			return ExportFilter{}, fmt.Errorf("Min amount can't be negative")
+		}
+	}
+
+	return flt, nil
+}
+
+func parseExportDate(s string) (time.Time, error) {
+	if t, err := time.Parse(exportDateFormat, s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// Match returns true if di is selected by the filter
+func (f ExportFilter) Match(di DepositInfo) bool {
+	updatedAt := time.Unix(di.UpdatedAt, 0)
+	if !f.From.IsZero() && updatedAt.Before(f.From) {
+		return false
+	}
+
+	if !f.To.IsZero() && !updatedAt.Before(f.To) {
+		return false
+	}
+
+	if len(f.Statuses) != 0 {
+		found := false
+		for _, st := range f.Statuses {
+			if di.Status == st {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.CoinType != "" && di.CoinType != f.CoinType {
+		return false
+	}
+
+	if f.MinAmount.Sign() > 0 {
+		coin, err := deposits.GetCoin(di.CoinType)
+		if err != nil {
+			return false
+		}
+		if coin.Coins(di.DepositValue).LessThan(f.MinAmount) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// exportRecord is a DepositInfo as written by the DepositExporter
+type exportRecord struct {
+	Seq            uint64 `json:"seq"`
+	UpdatedAt      string `json:"updated_at"`
+	Status         string `json:"status"`
+	CoinType       string `json:"coin_type"`
+	DepositAddress string `json:"deposit_address"`
+	SkyAddress     string `json:"sky_address"`
+	DepositID      string `json:"deposit_id"`
+	DepositValue   int64  `json:"deposit_value"`
+	DepositAmount  string `json:"deposit_amount"`
+	ConversionRate string `json:"conversion_rate"`
+	SkySent        uint64 `json:"sky_sent"`
+	Txid           string `json:"txid"`
+	Error          string `json:"error"`
+	RefundValue    int64  `json:"refund_value"`
+	RefundAddress  string `json:"refund_address"`
+	// The prices the conversion rate was calculated from, empty unless the deposit was priced in the quote currency
+	QuoteCurrency string `json:"quote_currency"`
+	CoinPrice     string `json:"coin_price"`
+	SkyPrice      string `json:"sky_price"`
+	// The value of the deposit in the display currency when it was received, empty if it has none
+	DisplayCurrency string `json:"display_currency"`
+	DisplayValue    string `json:"display_value"`
+	DisplayPrice    string `json:"display_price"`
+}
+
+func newExportRecord(di DepositInfo) exportRecord {
+	// Deposits of unknown coin types are still exported, without their amount in coins
+	var amount string
+	if coin, err := deposits.GetCoin(di.CoinType); err == nil {
+		amount = coin.Coins(di.DepositValue).String()
+	}
+
+	r := exportRecord{
+		Seq:            di.Seq,
+		UpdatedAt:      time.Unix(di.UpdatedAt, 0).UTC().Format(time.RFC3339),
+		Status:         di.Status.String(),
+		CoinType:       di.CoinType,
+		DepositAddress: di.DepositAddress,
+		SkyAddress:     di.SkyAddress,
+		DepositID:      di.DepositID,
+		DepositValue:   di.DepositValue,
+		DepositAmount:  amount,
+		ConversionRate: di.ConversionRate,
+		SkySent:        di.SkySent,
+		Txid:           di.Txid,
+		Error:          di.Error,
+		RefundValue:    di.RefundValue,
+		RefundAddress:  di.RefundAddress,
+	}
+
+	if di.Quote != nil {
+		r.QuoteCurrency = di.Quote.Currency
+		r.CoinPrice = di.Quote.CoinPrice
+		r.SkyPrice = di.Quote.SkyPrice
+	}
+
+	if di.Display != nil {
+		r.DisplayCurrency = di.Display.Currency
+		r.DisplayValue = di.Display.Value
+		r.DisplayPrice = di.Display.Price
+	}
+
+	return r
+}
+
+func (r exportRecord) row() []string {
+	return []string{
+		strconv.FormatUint(r.Seq, 10),
+		r.UpdatedAt,
+		r.Status,
+		r.CoinType,
+		r.DepositAddress,
+		r.SkyAddress,
+		r.DepositID,
+		strconv.FormatInt(r.DepositValue, 10),
+		r.DepositAmount,
+		r.ConversionRate,
+		strconv.FormatUint(r.SkySent, 10),
+		r.Txid,
+		r.Error,
+		strconv.FormatInt(r.RefundValue, 10),
+		r.RefundAddress,
+		r.QuoteCurrency,
+		r.CoinPrice,
+		r.SkyPrice,
+		r.DisplayCurrency,
+		r.DisplayValue,
+		r.DisplayPrice,
+	}
+}
+
+// DepositExporter writes deposits to an io.Writer one at a time, so that
+// exports don't have to be held in memory
+type DepositExporter struct {
+	format ExportFormat
+	csv    *csv.Writer
+	json   *json.Encoder
+	count  int
+}
+
+// NewDepositExporter creates a DepositExporter writing to w in format
+func NewDepositExporter(w io.Writer, format ExportFormat) (*DepositExporter, error) {
+	e := &DepositExporter{
+		format: format,
+	}
+
+	switch format {
+	case ExportCSV:
+		e.csv = csv.NewWriter(w)
+		if err := e.csv.Write(exportColumns); err != nil {
+			return nil, err
+		}
+	case ExportJSONL:
+		e.json = json.NewEncoder(w)
+	default:
+		return nil, fmt.Errorf("Invalid export format %q", format)
+	}
+
+	return e, nil
+}
+
+// Write writes a deposit
+func (e *DepositExporter) Write(di DepositInfo) error {
+	r := newExportRecord(di)
+
+	var err error
+	switch e.format {
+	case ExportCSV:
+		err = e.csv.Write(r.row())
+	case ExportJSONL:
+		err = e.json.Encode(r)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.count++
+	return nil
+}
+
+// Flush writes any buffered data to the underlying io.Writer
+func (e *DepositExporter) Flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		return e.csv.Error()
+	}
+	return nil
+}
+
+// Count returns the number of deposits written
+func (e *DepositExporter) Count() int {
+	return e.count
+}
+
+// ExportDepositsDB writes the deposits in db matching flt to w, and returns the number written.
+// It only reads from db, so it can be used on a db opened read-only, without starting a teller.
+func ExportDepositsDB(db *bolt.DB, w io.Writer, format ExportFormat, flt ExportFilter) (int, error) {
+	forEach := func(dflt DepositFilter, f func(DepositInfo) error) error {
+		return db.View(func(tx *bolt.Tx) error {
+			return forEachDepositInfoTx(tx, dflt, f)
+		})
+	}
+
+	return exportDeposits(forEach, w, format, flt)
+}
+
+func exportDeposits(forEach func(DepositFilter, func(DepositInfo) error) error, w io.Writer, format ExportFormat, flt ExportFilter) (int, error) {
+	exp, err := NewDepositExporter(w, format)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := forEach(flt.Match, exp.Write); err != nil {
+		return exp.Count(), err
+	}
+
+	return exp.Count(), exp.Flush()
+}
@@ -0,0 +1,299 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestNewExportFilter(t *testing.T) {
+	tt := []struct {
+		name      string
+		from      string
+		to        string
+		statuses  string
+		coinType  string
+		minAmount string
+		flt       ExportFilter
+		err       string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:      "all",
+			from:      "2018-01-02",
+			to:        "2018-02-03T04:05:06Z",
+			statuses:  "waiting_review, done",
+			coinType:  scanner.CoinTypeBTC,
+			minAmount: "0.5",
+			flt: ExportFilter{
+				From:      time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC),
+				To:        time.Date(2018, 2, 3, 4, 5, 6, 0, time.UTC),
+				Statuses:  []Status{StatusWaitReview, StatusDone},
+				CoinType:  scanner.CoinTypeBTC,
+				MinAmount: decimal.New(5, -1),
+			},
+		},
+		{
+			name: "invalid from",
+			from: "yesterday",
+			err:  "Invalid from date",
+		},
+		{
+			name: "invalid to",
+			to:   "2018-13-01",
+			err:  "Invalid to date",
+		},
+		{
+			name: "to before from",
+			from: "2018-01-02",
+			to:   "2018-01-01",
+			err:  "to date must be after from date",
+		},
+		{
+			name:     "unknown status",
+			statuses: "done,foo",
+			err:      `Unknown status "foo"`,
+		},
+		{
+			name:     "unknown coin type",
+			coinType: "FOO",
+			err:      "unsupported coin type",
+		},
+		{
+			name:      "invalid min amount",
+			minAmount: "1btc",
+			err:       "Invalid min amount",
+		},
+		{
+			name:      "negative min amount",
+			minAmount: "-1",
+			err:       "Min amount can't be negative",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			flt, err := NewExportFilter(tc.from, tc.to, tc.statuses, tc.coinType, tc.minAmount)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.True(t, tc.flt.From.Equal(flt.From))
+			require.True(t, tc.flt.To.Equal(flt.To))
+			require.Equal(t, tc.flt.Statuses, flt.Statuses)
+			require.Equal(t, tc.flt.CoinType, flt.CoinType)
+			require.True(t, tc.flt.MinAmount.Equal(flt.MinAmount))
+		})
+	}
+}
+
+func TestExportFilterMatch(t *testing.T) {
+	ts := time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	di := DepositInfo{
+		UpdatedAt:    ts.Unix(),
+		Status:       StatusDone,
+		CoinType:     scanner.CoinTypeBTC,
+		DepositValue: 1e8,
+	}
+
+	tt := []struct {
+		name  string
+		flt   ExportFilter
+		match bool
+	}{
+		{
+			name:  "empty",
+			match: true,
+		},
+		{
+			name: "from inclusive",
+			flt: ExportFilter{
+				From: ts,
+			},
+			match: true,
+		},
+		{
+			name: "after from",
+			flt: ExportFilter{
+				From: ts.Add(time.Second),
+			},
+		},
+		{
+			name: "to exclusive",
+			flt: ExportFilter{
+				To: ts,
+			},
+		},
+		{
+			name: "before to",
+			flt: ExportFilter{
+				To: ts.Add(time.Second),
+			},
+			match: true,
+		},
+		{
+			name: "status",
+			flt: ExportFilter{
+				Statuses: []Status{StatusWaitSend, StatusDone},
+			},
+			match: true,
+		},
+		{
+			name: "other status",
+			flt: ExportFilter{
+				Statuses: []Status{StatusWaitSend},
+			},
+		},
+		{
+			name: "other coin type",
+			flt: ExportFilter{
+				CoinType: scanner.CoinTypeETH,
+			},
+		},
+		{
+			name: "min amount equal",
+			flt: ExportFilter{
+				MinAmount: decimal.New(1, 0),
+			},
+			match: true,
+		},
+		{
+			name: "below min amount",
+			flt: ExportFilter{
+				MinAmount: decimal.New(101, -2),
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.match, tc.flt.Match(di))
+		})
+	}
+
+	// Deposits of unknown coin types have no amount to compare
+	require.False(t, ExportFilter{
+		MinAmount: decimal.New(1, 0),
+	}.Match(DepositInfo{
+		DepositValue: 1e8,
+	}))
+}
+
+func TestExportDepositsDB(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	dpis := []DepositInfo{
+		{
+			DepositID:      "t1:1",
+			CoinType:       scanner.CoinTypeBTC,
+			DepositAddress: "b1",
+			SkyAddress:     "s1",
+			DepositValue:   15e7,
+			ConversionRate: testSkyBtcRate,
+			Status:         StatusWaitSend,
+		},
+		{
+			DepositID:      "t2:1",
+			CoinType:       scanner.CoinTypeETH,
+			DepositAddress: "e2",
+			SkyAddress:     "s2,\"quoted\"",
+			DepositValue:   2e9,
+			Txid:           "txid-2",
+			ConversionRate: "50",
+			SkySent:        100e6,
+			Status:         StatusWaitConfirm,
+			RefundAddress:  "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+			Quote: &ConversionQuote{
+				Currency:  "USD",
+				CoinPrice: "100",
+				SkyPrice:  "2",
+			},
+			Display: &DisplayValue{
+				Currency: "USD",
+				Value:    "200.00",
+				Price:    "100",
+			},
+		},
+	}
+
+	for _, dpi := range dpis {
+		_, err := s.addDepositInfo(dpi)
+		require.NoError(t, err)
+	}
+
+	flt, err := NewExportFilter("", "", "waiting_confirm", "", "")
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := ExportDepositsDB(s.db, &buf, ExportCSV, flt)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, exportColumns, rows[0])
+	require.Equal(t, []string{
+		"2",
+		rows[1][1],
+		"waiting_confirm",
+		scanner.CoinTypeETH,
+		"e2",
+		"s2,\"quoted\"",
+		"t2:1",
+		"2000000000",
+		"2",
+		"50",
+		"100000000",
+		"txid-2",
+		"",
+		"0",
+		"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed",
+		"USD",
+		"100",
+		"2",
+		"USD",
+		"200.00",
+		"100",
+	}, rows[1])
+
+	_, err = time.Parse(time.RFC3339, rows[1][1])
+	require.NoError(t, err)
+
+	buf.Reset()
+	n, err = ExportDepositsDB(s.db, &buf, ExportJSONL, ExportFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	amounts := make(map[string]string, len(lines))
+	for _, l := range lines {
+		var r exportRecord
+		require.NoError(t, json.Unmarshal([]byte(l), &r))
+		amounts[r.DepositID] = r.DepositAmount
+	}
+	require.Equal(t, map[string]string{
+		"t1:1": "1.5",
+		"t2:1": "2",
+	}, amounts)
+
+	_, err = ExportDepositsDB(s.db, &buf, ExportFormat("xml"), ExportFilter{})
+	require.Error(t, err)
+}
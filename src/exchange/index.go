@@ -0,0 +1,325 @@
+package exchange
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// Deposit indexes.
+// The admin listings and lookups find deposits through secondary indexes, instead of scanning DepositInfoBkt.
+// The indexes are updated with each deposit info, in the same bolt.Tx. Deposits are indexed by their
+// deposit address in BtcTxsBkt already, and by txid, status and update time in the buckets below.
+// An index key is the indexed value, "/" and the deposit ID, so the deposits with a value are found
+// by seeking to its prefix, and the update time index is ordered by time.
+//
+// The indexes of a db written before they were added are built when the Store is created.
+// A db written by an older teller after the indexes were built, e.g. after a downgrade,
+// has stale indexes, which are rebuilt by the tool's rebuildindexes command.
+
+var (
+	// DepositTxidIndexBkt indexes the deposits by the txid of their BTC/ETH transaction, and of their skycoin transaction
+	DepositTxidIndexBkt = []byte("deposit_txid_index")
+
+	// DepositStatusIndexBkt indexes the deposits by Status
+	DepositStatusIndexBkt = []byte("deposit_status_index")
+
+	// DepositUpdatedIndexBkt indexes the deposits by UpdatedAt
+	DepositUpdatedIndexBkt = []byte("deposit_updated_index")
+
+	depositIndexBkts = [][]byte{
+		DepositTxidIndexBkt,
+		DepositStatusIndexBkt,
+		DepositUpdatedIndexBkt,
+	}
+)
+
+// DepositQuery selects deposits. Empty criteria match any deposit.
+type DepositQuery struct {
+	// Deposits to DepositAddress
+	DepositAddress string
+	// Deposits whose BTC/ETH transaction or skycoin transaction has Txid
+	Txid string
+	// Deposits in any of Statuses
+	Statuses []Status
+	// Deposits updated at or after From
+	From time.Time
+	// Deposits updated before To
+	To time.Time
+	// Filter is applied to the deposits matching the other criteria, if not nil
+	Filter DepositFilter
+}
+
+// Match returns true if the deposit matches the query
+func (q DepositQuery) Match(di DepositInfo) bool {
+	if q.DepositAddress != "" && di.DepositAddress != q.DepositAddress {
+		return false
+	}
+
+	if q.Txid != "" && !hasTxid(di, q.Txid) {
+		return false
+	}
+
+	if len(q.Statuses) != 0 {
+		found := false
+		for _, st := range q.Statuses {
+			if di.Status == st {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	updatedAt := time.Unix(di.UpdatedAt, 0)
+	if !q.From.IsZero() && updatedAt.Before(q.From) {
+		return false
+	}
+
+	if !q.To.IsZero() && !updatedAt.Before(q.To) {
+		return false
+	}
+
+	return q.Filter == nil || q.Filter(di)
+}
+
+// hasTxid returns true if txid is the deposit's BTC/ETH transaction or its skycoin transaction
+func hasTxid(di DepositInfo, txid string) bool {
+	if di.Txid == txid {
+		return true
+	}
+	depositTxid, _, err := deposits.ParseID(di.DepositID)
+	return err == nil && depositTxid == txid
+}
+
+func indexKey(value, depositID string) []byte {
+	return []byte(value + "/" + depositID)
+}
+
+func statusIndexValue(st Status) string {
+	return fmt.Sprintf("%03d", st)
+}
+
+func updatedIndexValue(updatedAt int64) string {
+	return fmt.Sprintf("%020d", updatedAt)
+}
+
+// depositIndexKeys returns the index keys of a deposit info, by index bucket
+func depositIndexKeys(di DepositInfo) map[string][][]byte {
+	var txids [][]byte
+	if depositTxid, _, err := deposits.ParseID(di.DepositID); err == nil {
+		txids = append(txids, indexKey(depositTxid, di.DepositID))
+	}
+	if di.Txid != "" {
+		txids = append(txids, indexKey(di.Txid, di.DepositID))
+	}
+
+	return map[string][][]byte{
+		string(DepositTxidIndexBkt):    txids,
+		string(DepositStatusIndexBkt):  {indexKey(statusIndexValue(di.Status), di.DepositID)},
+		string(DepositUpdatedIndexBkt): {indexKey(updatedIndexValue(di.UpdatedAt), di.DepositID)},
+	}
+}
+
+// indexDepositInfoTx replaces the index keys of before with those of after.
+// before is empty for a new deposit info.
+func indexDepositInfoTx(tx *bolt.Tx, before, after DepositInfo) error {
+	var old map[string][][]byte
+	if before.DepositID != "" {
+		old = depositIndexKeys(before)
+	}
+
+	for bktName, keys := range depositIndexKeys(after) {
+		bkt := tx.Bucket([]byte(bktName))
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr([]byte(bktName))
+		}
+
+		for _, k := range old[bktName] {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		for _, k := range keys {
+			if err := bkt.Put(k, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// initDepositIndexesTx creates and builds the deposit indexes, unless they exist
+func initDepositIndexesTx(tx *bolt.Tx) error {
+	if tx.Bucket(DepositStatusIndexBkt) != nil {
+		return nil
+	}
+
+	_, err := rebuildDepositIndexesTx(tx)
+	return err
+}
+
+// rebuildDepositIndexesTx recreates the deposit indexes from DepositInfoBkt, and returns the number of deposits indexed
+func rebuildDepositIndexesTx(tx *bolt.Tx) (int, error) {
+	for _, bkt := range depositIndexBkts {
+		if tx.Bucket(bkt) != nil {
+			if err := tx.DeleteBucket(bkt); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := tx.CreateBucket(bkt); err != nil {
+			return 0, dbutil.NewCreateBucketFailedErr(bkt, err)
+		}
+	}
+
+	var n int
+	if err := forEachDepositInfoTx(tx, func(DepositInfo) bool { return true }, func(di DepositInfo) error {
+		n++
+		return indexDepositInfoTx(tx, DepositInfo{}, di)
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// RebuildDepositIndexes recreates the deposit indexes from the deposit infos, and returns the number of deposits indexed
+func (s *Store) RebuildDepositIndexes() (int, error) {
+	var n int
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		n, err = rebuildDepositIndexesTx(tx)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	s.log.WithField("deposits", n).Info("Rebuilt the deposit indexes")
+
+	return n, nil
+}
+
+// QueryDepositInfos calls f with each deposit info matching q, in DepositID order.
+// The deposits are found through the most selective index of q's criteria.
+// A query without an indexed criterion scans all the deposits.
+func (s *Store) QueryDepositInfos(q DepositQuery, f func(DepositInfo) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return queryDepositInfosTx(tx, q, f)
+	})
+}
+
+func queryDepositInfosTx(tx *bolt.Tx, q DepositQuery, f func(DepositInfo) error) error {
+	ids, indexed, err := queryDepositIDsTx(tx, q)
+	if err != nil {
+		return err
+	}
+
+	if !indexed {
+		return forEachDepositInfoTx(tx, q.Match, f)
+	}
+
+	sort.Strings(ids)
+
+	for i, id := range ids {
+		// A deposit can be indexed twice with the same value, e.g. a txid
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+
+		var di DepositInfo
+		if err := dbutil.GetBucketObject(tx, DepositInfoBkt, id, &di); err != nil {
+			return err
+		}
+
+		if !q.Match(di) {
+			continue
+		}
+
+		if err := f(di); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryDepositIDsTx returns the IDs of the deposits found through the most selective index of q's criteria,
+// a superset of the deposits matching q. It returns false if q has no indexed criterion.
+func queryDepositIDsTx(tx *bolt.Tx, q DepositQuery) ([]string, bool, error) {
+	switch {
+	case q.DepositAddress != "":
+		var ids []string
+		if err := dbutil.GetBucketObject(tx, BtcTxsBkt, q.DepositAddress, &ids); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+			default:
+				return nil, false, err
+			}
+		}
+		return ids, true, nil
+
+	case q.Txid != "":
+		ids, err := scanIndexTx(tx, DepositTxidIndexBkt, []byte(q.Txid+"/"), nil)
+		return ids, true, err
+
+	case len(q.Statuses) != 0:
+		var ids []string
+		for _, st := range q.Statuses {
+			stIDs, err := scanIndexTx(tx, DepositStatusIndexBkt, []byte(statusIndexValue(st)+"/"), nil)
+			if err != nil {
+				return nil, false, err
+			}
+			ids = append(ids, stIDs...)
+		}
+		return ids, true, nil
+
+	case !q.From.IsZero() || !q.To.IsZero():
+		var to []byte
+		if !q.To.IsZero() {
+			to = []byte(updatedIndexValue(q.To.Unix()) + "/")
+		}
+		ids, err := scanIndexTx(tx, DepositUpdatedIndexBkt, []byte(updatedIndexValue(q.From.Unix())+"/"), to)
+		return ids, true, err
+
+	default:
+		return nil, false, nil
+	}
+}
+
+// scanIndexTx returns the deposit IDs of an index's keys from start. If to is nil, the keys have the prefix start,
+// otherwise they are before to.
+func scanIndexTx(tx *bolt.Tx, bktName, start, to []byte) ([]string, error) {
+	bkt := tx.Bucket(bktName)
+	if bkt == nil {
+		return nil, dbutil.NewBucketNotExistErr(bktName)
+	}
+
+	var ids []string
+	c := bkt.Cursor()
+	for k, _ := c.Seek(start); k != nil; k, _ = c.Next() {
+		if to == nil && !bytes.HasPrefix(k, start) {
+			break
+		}
+		if to != nil && bytes.Compare(k, to) >= 0 {
+			break
+		}
+
+		i := bytes.IndexByte(k, '/')
+		if i < 0 {
+			return nil, fmt.Errorf("Invalid %s key %q", bktName, k)
+		}
+		ids = append(ids, string(k[i+1:]))
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,186 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func queryDepositIDs(t *testing.T, s *Store, q DepositQuery) []string {
+	ids := []string{}
+	require.NoError(t, s.QueryDepositInfos(q, func(di DepositInfo) error {
+		ids = append(ids, di.DepositID)
+		return nil
+	}))
+	return ids
+}
+
+func createIndexTestDeposits(t *testing.T, s *Store) {
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.NoError(t, s.BindAddress(testSkyAddr2, "btcaddr2", scanner.CoinTypeBTC, ""))
+
+	for _, dv := range []deposits.Deposit{
+		{Address: "btcaddr1", Tx: "btx1", N: 0},
+		{Address: "btcaddr1", Tx: "btx1", N: 1},
+		{Address: "btcaddr2", Tx: "btx2", N: 0},
+	} {
+		dv.CoinType = scanner.CoinTypeBTC
+		dv.Amount = 1e6
+		dv.Final = true
+		_, err := s.GetOrCreateDepositInfo(dv, testSkyBtcRate)
+		require.NoError(t, err)
+	}
+
+	_, err := s.UpdateDepositInfo("btx1:1", func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "skytx1"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+}
+
+func TestStoreQueryDepositInfos(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	createIndexTestDeposits(t, s)
+
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name string
+		q    DepositQuery
+		ids  []string
+	}{
+		{
+			"all",
+			DepositQuery{},
+			[]string{"btx1:0", "btx1:1", "btx2:0"},
+		},
+		{
+			"deposit address",
+			DepositQuery{DepositAddress: "btcaddr1"},
+			[]string{"btx1:0", "btx1:1"},
+		},
+		{
+			"unknown deposit address",
+			DepositQuery{DepositAddress: "btcaddr3"},
+			[]string{},
+		},
+		{
+			"deposit txid",
+			DepositQuery{Txid: "btx1"},
+			[]string{"btx1:0", "btx1:1"},
+		},
+		{
+			"skycoin txid",
+			DepositQuery{Txid: "skytx1"},
+			[]string{"btx1:1"},
+		},
+		{
+			"txid prefix",
+			DepositQuery{Txid: "btx"},
+			[]string{},
+		},
+		{
+			"status",
+			DepositQuery{Statuses: []Status{StatusWaitSend}},
+			[]string{"btx1:0", "btx2:0"},
+		},
+		{
+			"statuses",
+			DepositQuery{Statuses: []Status{StatusWaitConfirm, StatusWaitSend}},
+			[]string{"btx1:0", "btx1:1", "btx2:0"},
+		},
+		{
+			"deposit address and status",
+			DepositQuery{DepositAddress: "btcaddr1", Statuses: []Status{StatusWaitSend}},
+			[]string{"btx1:0"},
+		},
+		{
+			"updated between",
+			DepositQuery{From: now.Add(-time.Hour), To: now.Add(time.Hour)},
+			[]string{"btx1:0", "btx1:1", "btx2:0"},
+		},
+		{
+			"updated before",
+			DepositQuery{To: now.Add(-time.Hour)},
+			[]string{},
+		},
+		{
+			"updated after",
+			DepositQuery{From: now.Add(time.Hour)},
+			[]string{},
+		},
+		{
+			"filter",
+			DepositQuery{Txid: "btx1", Filter: func(di DepositInfo) bool {
+				return di.Txid == ""
+			}},
+			[]string{"btx1:0"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.ids, queryDepositIDs(t, s, tc.q))
+		})
+	}
+
+	// A status change moves the deposit in the status index
+	_, err := s.UpdateDepositInfo("btx1:1", func(di DepositInfo) DepositInfo {
+		di.Status = StatusDone
+		return di
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"btx1:1"}, queryDepositIDs(t, s, DepositQuery{Statuses: []Status{StatusDone}}))
+	require.Empty(t, queryDepositIDs(t, s, DepositQuery{Statuses: []Status{StatusWaitConfirm}}))
+	require.Equal(t, []string{"btx1:1"}, queryDepositIDs(t, s, DepositQuery{Txid: "skytx1"}))
+}
+
+func TestStoreRebuildDepositIndexes(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	createIndexTestDeposits(t, s)
+
+	// Stale indexes, e.g. of deposits written by an older teller
+	require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		for _, bkt := range depositIndexBkts {
+			if err := tx.DeleteBucket(bkt); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(bkt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	require.Empty(t, queryDepositIDs(t, s, DepositQuery{Txid: "btx1"}))
+
+	n, err := s.RebuildDepositIndexes()
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Equal(t, []string{"btx1:0", "btx1:1"}, queryDepositIDs(t, s, DepositQuery{Txid: "btx1"}))
+	require.Equal(t, []string{"btx1:1"}, queryDepositIDs(t, s, DepositQuery{Statuses: []Status{StatusWaitConfirm}}))
+
+	// A db written before the indexes were added is indexed when the store is created
+	require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		for _, bkt := range depositIndexBkts {
+			if err := tx.DeleteBucket(bkt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	s, err = NewStore(s.log, s.db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"btx1:0", "btx2:0"}, queryDepositIDs(t, s, DepositQuery{Statuses: []Status{StatusWaitSend}}))
+	require.Equal(t, []string{"btx2:0"}, queryDepositIDs(t, s, DepositQuery{Txid: "btx2"}))
+}
@@ -0,0 +1,57 @@
+package exchange
+
+import (
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/errutil"
+)
+
+// ErrDepositNotFound is returned if no deposit has the deposit ID
+var ErrDepositNotFound = errutil.New(errutil.NotFound, "Deposit not found")
+
+// DepositRecord is teller's record of a deposit, with the ledger transactions of its status changes
+type DepositRecord struct {
+	OwnerDeposit
+	// Deposit is the deposit as it was received from the scanner
+	Deposit deposits.Deposit `json:"deposit"`
+}
+
+// GetDepositRecord returns the record of a deposit, or ErrDepositNotFound
+func (s *Store) GetDepositRecord(depositID string) (DepositRecord, error) {
+	di, err := s.getDepositInfo(depositID)
+	if err != nil {
+		if _, ok := err.(dbutil.ObjectNotExistErr); ok {
+			return DepositRecord{}, ErrDepositNotFound
+		}
+		return DepositRecord{}, err
+	}
+
+	h, err := s.GetLedgerTransactions(depositID)
+	if err != nil {
+		return DepositRecord{}, err
+	}
+	if h == nil {
+		h = []LedgerTransaction{}
+	}
+
+	return DepositRecord{
+		OwnerDeposit: OwnerDeposit{
+			DepositStatusDetail: newDepositStatusDetail(di),
+			DepositValue:        di.DepositValue,
+			SkySent:             di.SkySent,
+			History:             h,
+		},
+		Deposit: di.Deposit,
+	}, nil
+}
+
+// GetDepositRecord returns the record of a deposit, or ErrDepositNotFound. See Store.GetDepositRecord.
+func (s *Exchange) GetDepositRecord(depositID string) (DepositRecord, error) {
+	r, err := s.store.GetDepositRecord(depositID)
+	if err != nil {
+		return DepositRecord{}, err
+	}
+
+	r.Link(s.cfg.Explorer)
+	return r, nil
+}
@@ -0,0 +1,53 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestStoreGetDepositRecord(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	_, err := s.GetDepositRecord("btx1:1")
+	require.Equal(t, ErrDepositNotFound, err)
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+
+	dv := deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr1",
+		Amount:   1e6,
+		Tx:       "btx1",
+		N:        1,
+		Height:   500000,
+		Final:    true,
+	}
+	di, err := s.GetOrCreateDepositInfo(dv, testSkyBtcRate)
+	require.NoError(t, err)
+
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "skytx1"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+
+	rec, err := s.GetDepositRecord("btx1:1")
+	require.NoError(t, err)
+	require.Equal(t, dv, rec.Deposit)
+	require.Equal(t, testSkyAddr, rec.SkyAddress)
+	require.Equal(t, "btcaddr1", rec.DepositAddress)
+	require.Equal(t, StatusWaitConfirm.String(), rec.Status)
+	require.Equal(t, int64(1e6), rec.DepositValue)
+	require.Equal(t, uint64(5e6), rec.SkySent)
+	require.NotEmpty(t, rec.History)
+	for _, lt := range rec.History {
+		require.Equal(t, "btx1:1", lt.DepositID)
+	}
+}
@@ -0,0 +1,514 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// Ledger accounts.
+// Every ledger transaction balances per currency: the sum of its postings is zero.
+// Debits are positive amounts, credits are negative amounts.
+//
+// A deposit moves through the ledger as follows:
+//
+//	deposit saved:      Dr deposits_received (coin)   Cr conversion (coin)
+//	skycoin sent:       Dr conversion (SKY)           Cr sky_liability (SKY)
+//	send confirmed:     Dr sky_liability (SKY)        Cr sky_paid (SKY)
+//	nothing to send:    Dr conversion (coin)          Cr fees (coin)
+//	over campaign cap:  Dr conversion (coin)          Cr refunds (coin)
+//	ignored as dust:    Dr conversion (coin)          Cr dust (coin)
+//
+// The fees account holds deposits that were too small to convert to any SKY.
+// The dust account holds deposits below the dust threshold, which are neither converted nor refunded.
+// The refunds account holds the parts of deposits over the campaign cap, which are owed back to the depositors.
+// Skycoin transaction fees are paid in coin hours, not SKY, so they are not recorded.
+const (
+	AccountDepositsReceived = "deposits_received"
+	AccountConversion       = "conversion"
+	AccountSkyLiability     = "sky_liability"
+	AccountSkyPaid          = "sky_paid"
+	AccountFees             = "fees"
+	AccountRefunds          = "refunds"
+	AccountDust             = "dust"
+
+	// CurrencySKY is the ledger currency for skycoin, measured in droplets.
+	// Deposit coins use their coin type as the currency, measured in the
+	// same unit as DepositInfo.DepositValue (satoshis for BTC, gwei for ETH)
+	CurrencySKY = "SKY"
+)
+
+var (
+	// LedgerBkt maps a sequence number to a LedgerTransaction
+	LedgerBkt = []byte("ledger")
+
+	// LedgerBalanceBkt maps a "<currency>:<account>" key to the account's balance
+	LedgerBalanceBkt = []byte("ledger_balance")
+
+	// ErrLedgerUnbalanced is returned if the postings of a ledger transaction,
+	// or the balances of all accounts, do not sum to zero for a currency
+	ErrLedgerUnbalanced = errors.New("Ledger is unbalanced")
+)
+
+// Posting is a single debit (positive amount) or credit (negative amount) to an account
+type Posting struct {
+	Account  string `json:"account"`
+	Currency string `json:"currency"`
+	Amount   int64  `json:"amount"`
+}
+
+// LedgerTransaction is a balanced set of postings caused by a deposit changing state
+type LedgerTransaction struct {
+	Seq       uint64    `json:"seq"`
+	Time      int64     `json:"time"`
+	DepositID string    `json:"deposit_id"`
+	Status    string    `json:"status"`
+	Postings  []Posting `json:"postings"`
+}
+
+// LedgerBalances maps currency to account to balance
+type LedgerBalances map[string]map[string]int64
+
+// LedgerDrift is an account balance which does not match the balance
+// derived from the deposit records
+type LedgerDrift struct {
+	Currency string `json:"currency"`
+	Account  string `json:"account"`
+	Expected int64  `json:"expected"`
+	Actual   int64  `json:"actual"`
+}
+
+// LedgerDriftErr is returned by CheckLedger if any account balance drifted
+type LedgerDriftErr struct {
+	Drifts []LedgerDrift
+}
+
+func (e LedgerDriftErr) Error() string {
+	s := make([]string, 0, len(e.Drifts))
+	for _, d := range e.Drifts {
+		s = append(s, fmt.Sprintf("%s %s expected=%d actual=%d", d.Currency, d.Account, d.Expected, d.Actual))
+	}
+	return fmt.Sprintf("Ledger balances drifted from deposit records: %s", strings.Join(s, ", "))
+}
+
+// LedgerReport is a snapshot of the ledger account balances along with
+// any drift from the deposit records
+type LedgerReport struct {
+	Balances LedgerBalances `json:"balances"`
+	Drift    []LedgerDrift  `json:"drift"`
+}
+
+func (b LedgerBalances) add(p Posting) {
+	if b[p.Currency] == nil {
+		b[p.Currency] = make(map[string]int64)
+	}
+	b[p.Currency][p.Account] += p.Amount
+}
+
+// checkBalanced returns ErrLedgerUnbalanced if any currency does not sum to zero
+func (b LedgerBalances) checkBalanced() error {
+	for _, accounts := range b {
+		var sum int64
+		for _, v := range accounts {
+			sum += v
+		}
+		if sum != 0 {
+			return ErrLedgerUnbalanced
+		}
+	}
+	return nil
+}
+
+// ledgerPosition returns the postings that a deposit has contributed to the ledger,
+// in total, by the time it has reached its current state
+func ledgerPosition(di DepositInfo) []Posting {
+	switch di.Status {
+	case StatusWaitSend, StatusWaitReview, StatusWaitConfirm, StatusDone, StatusDustIgnored:
+	default:
+		return nil
+	}
+
+	var ps []Posting
+	if di.DepositValue != 0 {
+		ps = append(ps,
+			Posting{Account: AccountDepositsReceived, Currency: di.CoinType, Amount: di.DepositValue},
+			Posting{Account: AccountConversion, Currency: di.CoinType, Amount: -di.DepositValue},
+		)
+	}
+
+	if di.RefundValue != 0 {
+		ps = append(ps,
+			Posting{Account: AccountConversion, Currency: di.CoinType, Amount: di.RefundValue},
+			Posting{Account: AccountRefunds, Currency: di.CoinType, Amount: -di.RefundValue},
+		)
+	}
+
+	skySent := int64(di.SkySent)
+	if skySent != 0 {
+		ps = append(ps,
+			Posting{Account: AccountConversion, Currency: CurrencySKY, Amount: skySent},
+			Posting{Account: AccountSkyLiability, Currency: CurrencySKY, Amount: -skySent},
+		)
+	}
+
+	if di.Status == StatusDone {
+		if skySent != 0 {
+			ps = append(ps,
+				Posting{Account: AccountSkyLiability, Currency: CurrencySKY, Amount: skySent},
+				Posting{Account: AccountSkyPaid, Currency: CurrencySKY, Amount: -skySent},
+			)
+		} else if kept := di.DepositValue - di.RefundValue; kept != 0 {
+			ps = append(ps,
+				Posting{Account: AccountConversion, Currency: di.CoinType, Amount: kept},
+				Posting{Account: AccountFees, Currency: di.CoinType, Amount: -kept},
+			)
+		}
+	}
+
+	if di.Status == StatusDustIgnored {
+		if kept := di.DepositValue - di.RefundValue; kept != 0 {
+			ps = append(ps,
+				Posting{Account: AccountConversion, Currency: di.CoinType, Amount: kept},
+				Posting{Account: AccountDust, Currency: di.CoinType, Amount: -kept},
+			)
+		}
+	}
+
+	return ps
+}
+
+// ledgerTransition returns the postings needed to move a deposit from one state to another.
+// Postings which cancel out are dropped, so this returns nil if nothing changed.
+func ledgerTransition(before, after DepositInfo) []Posting {
+	b := make(LedgerBalances)
+	for _, p := range ledgerPosition(before) {
+		p.Amount = -p.Amount
+		b.add(p)
+	}
+	for _, p := range ledgerPosition(after) {
+		b.add(p)
+	}
+
+	var ps []Posting
+	for currency, accounts := range b {
+		for account, v := range accounts {
+			if v != 0 {
+				ps = append(ps, Posting{Account: account, Currency: currency, Amount: v})
+			}
+		}
+	}
+
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].Currency != ps[j].Currency {
+			return ps[i].Currency < ps[j].Currency
+		}
+		return ps[i].Account < ps[j].Account
+	})
+
+	return ps
+}
+
+func ledgerBalanceKey(currency, account string) string {
+	return currency + ":" + account
+}
+
+func ledgerSeqKey(seq uint64) string {
+	// zero padded so that bolt's byte ordering matches the sequence ordering
+	return fmt.Sprintf("%020d", seq)
+}
+
+// postLedgerTransitionTx records the ledger transaction for a deposit's state change,
+// within the bolt transaction that saves the DepositInfo
+func (s *Store) postLedgerTransitionTx(tx *bolt.Tx, before, after DepositInfo) error {
+	ps := ledgerTransition(before, after)
+	if len(ps) == 0 {
+		return nil
+	}
+
+	return s.postLedgerTx(tx, LedgerTransaction{
+		DepositID: after.DepositID,
+		Status:    after.Status.String(),
+		Postings:  ps,
+	})
+}
+
+// postLedgerTx saves a ledger transaction and applies it to the account balances.
+// It fails if the transaction is not balanced, or if the resulting balances are not.
+func (s *Store) postLedgerTx(tx *bolt.Tx, lt LedgerTransaction) error {
+	sums := make(LedgerBalances)
+	for _, p := range lt.Postings {
+		sums.add(p)
+	}
+	if err := sums.checkBalanced(); err != nil {
+		s.log.WithField("ledgerTransaction", lt).WithError(err).Error("FIXME: Constructed unbalanced ledger transaction")
+		return err
+	}
+
+	seq, err := dbutil.NextSequence(tx, LedgerBkt)
+	if err != nil {
+		return err
+	}
+
+	lt.Seq = seq
+	lt.Time = time.Now().UTC().Unix()
+
+	if err := dbutil.PutBucketValue(tx, LedgerBkt, ledgerSeqKey(seq), lt); err != nil {
+		return err
+	}
+
+	for _, p := range lt.Postings {
+		key := ledgerBalanceKey(p.Currency, p.Account)
+
+		var balance int64
+		if err := dbutil.GetBucketObject(tx, LedgerBalanceBkt, key, &balance); err != nil {
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+			default:
+				return err
+			}
+		}
+
+		if err := dbutil.PutBucketValue(tx, LedgerBalanceBkt, key, balance+p.Amount); err != nil {
+			return err
+		}
+	}
+
+	balances, err := s.getLedgerBalancesTx(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := balances.checkBalanced(); err != nil {
+		s.log.WithField("balances", balances).WithError(err).Error("CRITICAL ERROR: Ledger account balances are unbalanced")
+		return err
+	}
+
+	return nil
+}
+
+// initLedgerTx posts the ledger transactions of deposits that were saved
+// before the ledger existed. It is a no-op once the ledger has any transactions.
+func (s *Store) initLedgerTx(tx *bolt.Tx) error {
+	bkt := tx.Bucket(LedgerBkt)
+	if bkt == nil {
+		return dbutil.NewBucketNotExistErr(LedgerBkt)
+	}
+
+	if k, _ := bkt.Cursor().First(); k != nil {
+		return nil
+	}
+
+	return dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+		var dpi DepositInfo
+		if err := json.Unmarshal(v, &dpi); err != nil {
+			return err
+		}
+
+		return s.postLedgerTransitionTx(tx, DepositInfo{}, dpi)
+	})
+}
+
+// GetLedgerBalances returns the balance of every ledger account
+func (s *Store) GetLedgerBalances() (LedgerBalances, error) {
+	var balances LedgerBalances
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		balances, err = s.getLedgerBalancesTx(tx)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+func (s *Store) getLedgerBalancesTx(tx *bolt.Tx) (LedgerBalances, error) {
+	balances := make(LedgerBalances)
+	if err := dbutil.ForEach(tx, LedgerBalanceBkt, func(k, v []byte) error {
+		pts := strings.SplitN(string(k), ":", 2)
+		if len(pts) != 2 {
+			return fmt.Errorf("Invalid ledger balance key \"%s\"", string(k))
+		}
+
+		var balance int64
+		if err := json.Unmarshal(v, &balance); err != nil {
+			return err
+		}
+
+		balances.add(Posting{Currency: pts[0], Account: pts[1], Amount: balance})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return balances, nil
+}
+
+// GetLedgerTransactions returns the ledger transactions of a deposit
+func (s *Store) GetLedgerTransactions(depositID string) ([]LedgerTransaction, error) {
+	var lts []LedgerTransaction
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, LedgerBkt, func(k, v []byte) error {
+			var lt LedgerTransaction
+			if err := json.Unmarshal(v, &lt); err != nil {
+				return err
+			}
+
+			if lt.DepositID == depositID {
+				lts = append(lts, lt)
+			}
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return lts, nil
+}
+
+// ledgerTotals sums the deposit records by status, to derive the account balances independently of
+// ledgerPosition, which posts the ledger transactions. A bug in ledgerPosition shows up as drift.
+type ledgerTotals struct {
+	received map[string]int64 // deposit value of the received deposits, by coin type
+	refunded map[string]int64 // refund value of the received deposits, by coin type
+	fees     map[string]int64 // value kept of the done deposits which were sent no SKY, by coin type
+	dust     map[string]int64 // value kept of the deposits ignored as dust, by coin type
+	skySent  int64            // SKY sent to the received deposits
+	skyPaid  int64            // SKY sent to the done deposits
+}
+
+func (t *ledgerTotals) add(di DepositInfo) {
+	switch di.Status {
+	case StatusWaitSend, StatusWaitReview, StatusWaitConfirm, StatusDone, StatusDustIgnored:
+	default:
+		return
+	}
+
+	if t.received == nil {
+		t.received = make(map[string]int64)
+		t.refunded = make(map[string]int64)
+		t.fees = make(map[string]int64)
+		t.dust = make(map[string]int64)
+	}
+
+	t.received[di.CoinType] += di.DepositValue
+	t.refunded[di.CoinType] += di.RefundValue
+	t.skySent += int64(di.SkySent)
+
+	switch di.Status {
+	case StatusDone:
+		t.skyPaid += int64(di.SkySent)
+		if di.SkySent == 0 {
+			t.fees[di.CoinType] += di.DepositValue - di.RefundValue
+		}
+	case StatusDustIgnored:
+		t.dust[di.CoinType] += di.DepositValue - di.RefundValue
+	}
+}
+
+// balances returns the account balances the totals should have been posted as
+func (t *ledgerTotals) balances() LedgerBalances {
+	b := make(LedgerBalances)
+	set := func(currency, account string, v int64) {
+		if v != 0 {
+			b.add(Posting{Account: account, Currency: currency, Amount: v})
+		}
+	}
+
+	for coinType, received := range t.received {
+		refunded := t.refunded[coinType]
+		fees := t.fees[coinType]
+		dust := t.dust[coinType]
+
+		set(coinType, AccountDepositsReceived, received)
+		set(coinType, AccountRefunds, -refunded)
+		set(coinType, AccountFees, -fees)
+		set(coinType, AccountDust, -dust)
+		set(coinType, AccountConversion, refunded+fees+dust-received)
+	}
+
+	set(CurrencySKY, AccountConversion, t.skySent)
+	set(CurrencySKY, AccountSkyLiability, t.skyPaid-t.skySent)
+	set(CurrencySKY, AccountSkyPaid, -t.skyPaid)
+
+	return b
+}
+
+// CheckLedger verifies that the ledger is balanced and that every account balance
+// matches the balance derived from the totals of the deposit records.
+// Returns ErrLedgerUnbalanced or a LedgerDriftErr if the check fails.
+func (s *Store) CheckLedger() error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		actual, err := s.getLedgerBalancesTx(tx)
+		if err != nil {
+			return err
+		}
+
+		if err := actual.checkBalanced(); err != nil {
+			return err
+		}
+
+		var totals ledgerTotals
+		if err := dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+			var dpi DepositInfo
+			if err := json.Unmarshal(v, &dpi); err != nil {
+				return err
+			}
+
+			totals.add(dpi)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		expected := totals.balances()
+
+		var drifts []LedgerDrift
+		compare := func(a, b LedgerBalances, flip bool) {
+			for currency, accounts := range a {
+				for account, v := range accounts {
+					w := b[currency][account]
+					if flip {
+						// already reported when comparing the other way
+						if _, ok := b[currency][account]; ok {
+							continue
+						}
+						v, w = w, v
+					}
+					if v != w {
+						drifts = append(drifts, LedgerDrift{
+							Currency: currency,
+							Account:  account,
+							Expected: v,
+							Actual:   w,
+						})
+					}
+				}
+			}
+		}
+
+		compare(expected, actual, false)
+		compare(actual, expected, true)
+
+		if len(drifts) == 0 {
+			return nil
+		}
+
+		sort.Slice(drifts, func(i, j int) bool {
+			if drifts[i].Currency != drifts[j].Currency {
+				return drifts[i].Currency < drifts[j].Currency
+			}
+			return drifts[i].Account < drifts[j].Account
+		})
+
+		return LedgerDriftErr{Drifts: drifts}
+	})
+}
@@ -0,0 +1,328 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestLedgerTransition(t *testing.T) {
+	di := DepositInfo{
+		CoinType:     scanner.CoinTypeBTC,
+		DepositID:    "btx1:1",
+		DepositValue: 1e6,
+		Status:       StatusWaitSend,
+	}
+
+	require.Equal(t, []Posting{
+		{Account: AccountConversion, Currency: scanner.CoinTypeBTC, Amount: -1e6},
+		{Account: AccountDepositsReceived, Currency: scanner.CoinTypeBTC, Amount: 1e6},
+	}, ledgerTransition(DepositInfo{}, di))
+
+	sent := di
+	sent.Status = StatusWaitConfirm
+	sent.SkySent = 5e6
+	require.Equal(t, []Posting{
+		{Account: AccountConversion, Currency: CurrencySKY, Amount: 5e6},
+		{Account: AccountSkyLiability, Currency: CurrencySKY, Amount: -5e6},
+	}, ledgerTransition(di, sent))
+
+	done := sent
+	done.Status = StatusDone
+	require.Equal(t, []Posting{
+		{Account: AccountSkyLiability, Currency: CurrencySKY, Amount: 5e6},
+		{Account: AccountSkyPaid, Currency: CurrencySKY, Amount: -5e6},
+	}, ledgerTransition(sent, done))
+
+	// nothing changed
+	require.Empty(t, ledgerTransition(done, done))
+
+	// deposit too small to send anything
+	empty := di
+	empty.Status = StatusDone
+	empty.Error = ErrEmptySendAmount.Error()
+	require.Equal(t, []Posting{
+		{Account: AccountConversion, Currency: scanner.CoinTypeBTC, Amount: 1e6},
+		{Account: AccountFees, Currency: scanner.CoinTypeBTC, Amount: -1e6},
+	}, ledgerTransition(di, empty))
+}
+
+func TestStoreLedger(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	err := s.BindAddress("a", "b", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	dv := deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "b",
+		Amount:   1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}
+
+	di, err := s.GetOrCreateDepositInfo(dv, testSkyBtcRate)
+	require.NoError(t, err)
+
+	// getting an existing deposit does not post again
+	_, err = s.GetOrCreateDepositInfo(dv, testSkyBtcRate)
+	require.NoError(t, err)
+
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "txid"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+
+	balances, err := s.GetLedgerBalances()
+	require.NoError(t, err)
+	require.Equal(t, LedgerBalances{
+		scanner.CoinTypeBTC: {
+			AccountDepositsReceived: 1e6,
+			AccountConversion:       -1e6,
+		},
+		CurrencySKY: {
+			AccountConversion:   5e6,
+			AccountSkyLiability: -5e6,
+		},
+	}, balances)
+	require.NoError(t, s.CheckLedger())
+
+	// a rolled back update does not post
+	_, err = s.UpdateDepositInfoCallback(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusDone
+		return di
+	}, func(di DepositInfo) error {
+		return ErrNoResponse
+	})
+	require.Equal(t, ErrNoResponse, err)
+
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusDone
+		return di
+	})
+	require.NoError(t, err)
+
+	balances, err = s.GetLedgerBalances()
+	require.NoError(t, err)
+	require.Equal(t, LedgerBalances{
+		scanner.CoinTypeBTC: {
+			AccountDepositsReceived: 1e6,
+			AccountConversion:       -1e6,
+		},
+		CurrencySKY: {
+			AccountConversion:   5e6,
+			AccountSkyLiability: 0,
+			AccountSkyPaid:      -5e6,
+		},
+	}, balances)
+	require.NoError(t, s.CheckLedger())
+
+	lts, err := s.GetLedgerTransactions(di.DepositID)
+	require.NoError(t, err)
+	require.Len(t, lts, 3)
+	require.Equal(t, StatusWaitSend.String(), lts[0].Status)
+	require.Equal(t, StatusWaitConfirm.String(), lts[1].Status)
+	require.Equal(t, StatusDone.String(), lts[2].Status)
+
+	// modify a deposit record without going through the ledger
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var dpi DepositInfo
+		if err := dbutil.GetBucketObject(tx, DepositInfoBkt, di.DepositID, &dpi); err != nil {
+			return err
+		}
+		dpi.SkySent = 6e6
+		return dbutil.PutBucketValue(tx, DepositInfoBkt, di.DepositID, dpi)
+	})
+	require.NoError(t, err)
+
+	err = s.CheckLedger()
+	require.Equal(t, LedgerDriftErr{
+		Drifts: []LedgerDrift{
+			{Currency: CurrencySKY, Account: AccountConversion, Expected: 6e6, Actual: 5e6},
+			{Currency: CurrencySKY, Account: AccountSkyPaid, Expected: -6e6, Actual: -5e6},
+		},
+	}, err)
+}
+
+func TestStoreLedgerUnbalanced(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return s.postLedgerTx(tx, LedgerTransaction{
+			DepositID: "btx1:1",
+			Postings: []Posting{
+				{Account: AccountDepositsReceived, Currency: scanner.CoinTypeBTC, Amount: 1e6},
+				{Account: AccountConversion, Currency: scanner.CoinTypeBTC, Amount: -1e5},
+			},
+		})
+	})
+	require.Equal(t, ErrLedgerUnbalanced, err)
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return dbutil.PutBucketValue(tx, LedgerBalanceBkt, ledgerBalanceKey(CurrencySKY, AccountSkyPaid), -1)
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, ErrLedgerUnbalanced, s.CheckLedger())
+}
+
+func TestStoreLedgerCorruptedEntry(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	err := s.BindAddress("a", "b", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	di, err := s.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "b",
+		Amount:   1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "txid"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.CheckLedger())
+
+	// A balanced entry which pays out the unconfirmed send, as a bug in the postings of a deposit would
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return s.postLedgerTx(tx, LedgerTransaction{
+			DepositID: di.DepositID,
+			Status:    StatusWaitConfirm.String(),
+			Postings: []Posting{
+				{Account: AccountSkyLiability, Currency: CurrencySKY, Amount: 5e6},
+				{Account: AccountSkyPaid, Currency: CurrencySKY, Amount: -5e6},
+			},
+		})
+	})
+	require.NoError(t, err)
+
+	err = s.CheckLedger()
+	require.Equal(t, LedgerDriftErr{
+		Drifts: []LedgerDrift{
+			{Currency: CurrencySKY, Account: AccountSkyLiability, Expected: -5e6, Actual: 0},
+			{Currency: CurrencySKY, Account: AccountSkyPaid, Expected: 0, Actual: -5e6},
+		},
+	}, err)
+}
+
+func TestLedgerTotals(t *testing.T) {
+	dis := []DepositInfo{
+		{CoinType: scanner.CoinTypeBTC, DepositValue: 1e6, Status: StatusWaitSend},
+		{CoinType: scanner.CoinTypeBTC, DepositValue: 2e6, SkySent: 5e6, Status: StatusWaitConfirm},
+		{CoinType: scanner.CoinTypeBTC, DepositValue: 3e6, RefundValue: 1e6, SkySent: 7e6, Status: StatusDone},
+		{CoinType: scanner.CoinTypeBTC, DepositValue: 10, Status: StatusDone},
+		{CoinType: scanner.CoinTypeETH, DepositValue: 20, Status: StatusDustIgnored},
+		{CoinType: scanner.CoinTypeETH, DepositValue: 9e9, Status: StatusWaitDeposit},
+	}
+
+	var totals ledgerTotals
+	for _, di := range dis {
+		totals.add(di)
+	}
+
+	expected := totals.balances()
+	require.NoError(t, expected.checkBalanced())
+	require.Equal(t, LedgerBalances{
+		scanner.CoinTypeBTC: {
+			AccountDepositsReceived: 6000010,
+			AccountRefunds:          -1e6,
+			AccountFees:             -10,
+			AccountConversion:       -5e6,
+		},
+		scanner.CoinTypeETH: {
+			AccountDepositsReceived: 20,
+			AccountDust:             -20,
+		},
+		CurrencySKY: {
+			AccountConversion:   12e6,
+			AccountSkyLiability: -5e6,
+			AccountSkyPaid:      -7e6,
+		},
+	}, expected)
+
+	// The postings of each deposit add up to the totals
+	posted := make(LedgerBalances)
+	for _, di := range dis {
+		for _, p := range ledgerPosition(di) {
+			posted.add(p)
+		}
+	}
+	for currency, accounts := range posted {
+		for account, v := range accounts {
+			require.Equal(t, expected[currency][account], v, "%s %s", currency, account)
+		}
+	}
+}
+
+func TestStoreLedgerInitExistingDeposits(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	// Deposits saved before the ledger existed
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(DepositInfoBkt); err != nil {
+			return err
+		}
+
+		for _, di := range []DepositInfo{
+			{
+				CoinType:     scanner.CoinTypeBTC,
+				DepositID:    "btx1:1",
+				DepositValue: 1e6,
+				SkySent:      5e6,
+				Status:       StatusDone,
+			},
+			{
+				CoinType:     scanner.CoinTypeBTC,
+				DepositID:    "btx2:1",
+				DepositValue: 2e6,
+				Status:       StatusWaitSend,
+			},
+		} {
+			if err := dbutil.PutBucketValue(tx, DepositInfoBkt, di.DepositID, di); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	log, _ := testutil.NewLogger(t)
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+	require.NoError(t, s.CheckLedger())
+
+	balances, err := s.GetLedgerBalances()
+	require.NoError(t, err)
+	require.Equal(t, int64(3e6), balances[scanner.CoinTypeBTC][AccountDepositsReceived])
+	require.Equal(t, int64(-5e6), balances[CurrencySKY][AccountSkyPaid])
+
+	// opening the store again does not post twice
+	s, err = NewStore(log, db)
+	require.NoError(t, err)
+	require.NoError(t, s.CheckLedger())
+}
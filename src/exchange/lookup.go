@@ -0,0 +1,227 @@
+package exchange
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// Owner is a skycoin address found by a lookup, with its binding and deposit history
+type Owner struct {
+	SkyAddress string `json:"skycoin_address"`
+	// Bindings are the deposit addresses bound to the skycoin address
+	Bindings []Binding `json:"bindings"`
+	// CancelledBindings are the bindings the owner cancelled, oldest first
+	CancelledBindings []CancelledBinding `json:"cancelled_bindings"`
+	Deposits          []OwnerDeposit     `json:"deposits"`
+}
+
+// Binding is a deposit address bound to a skycoin address
+type Binding struct {
+	DepositAddress string `json:"deposit_address"`
+	CoinType       string `json:"coin_type"`
+	// Version of the binding, see Store.CancelBinding
+	Version uint64 `json:"version"`
+}
+
+// OwnerDeposit is a deposit of an Owner, with the ledger transactions of its status changes
+type OwnerDeposit struct {
+	DepositStatusDetail
+	// Deposit amount, in the coin type's smallest unit
+	DepositValue int64 `json:"deposit_value"`
+	// SKY sent, in droplets
+	SkySent uint64              `json:"sky_sent"`
+	History []LedgerTransaction `json:"history"`
+}
+
+// LookupOwners returns the skycoin addresses which a deposit address or a transaction belongs to.
+// depositAddr matches its current binding, the bindings of it which were cancelled, and its deposits.
+// txid matches the deposits received in the BTC/ETH transaction, or paid out by the skycoin transaction.
+// The current owner comes first. Either depositAddr or txid may be empty.
+// The deposits are found through the deposit indexes, but the ledger is scanned for their history,
+// so this is meant for support lookups, not for the API.
+func (s *Store) LookupOwners(depositAddr, txid string) ([]Owner, error) {
+	var owners []Owner
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		var skyAddrs []string
+		seen := make(map[string]struct{})
+		found := func(skyAddr string) {
+			if _, ok := seen[skyAddr]; ok || skyAddr == "" {
+				return
+			}
+			seen[skyAddr] = struct{}{}
+			skyAddrs = append(skyAddrs, skyAddr)
+		}
+
+		if depositAddr != "" {
+			for _, coinType := range deposits.CoinTypes() {
+				skyAddr, err := s.getBindAddressTx(tx, depositAddr, coinType)
+				if err != nil {
+					return err
+				}
+				found(skyAddr)
+			}
+		}
+
+		cbs, err := getCancelledBindingsTx(tx)
+		if err != nil {
+			return err
+		}
+
+		// Most recent cancellations first
+		for i := len(cbs) - 1; i >= 0; i-- {
+			if depositAddr != "" && cbs[i].DepositAddress == depositAddr {
+				found(cbs[i].SkyAddress)
+			}
+		}
+
+		// The deposits are found through the deposit indexes
+		var queries []DepositQuery
+		if depositAddr != "" {
+			queries = append(queries, DepositQuery{
+				DepositAddress: depositAddr,
+			})
+		}
+		if txid != "" {
+			queries = append(queries, DepositQuery{
+				Txid: txid,
+			})
+		}
+
+		for _, q := range queries {
+			if err := queryDepositInfosTx(tx, q, func(di DepositInfo) error {
+				found(di.SkyAddress)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		if len(skyAddrs) == 0 {
+			return nil
+		}
+
+		history := make(map[string][]LedgerTransaction)
+		if err := dbutil.ForEach(tx, LedgerBkt, func(k, v []byte) error {
+			var lt LedgerTransaction
+			if err := json.Unmarshal(v, &lt); err != nil {
+				return err
+			}
+			if lt.DepositID != "" {
+				history[lt.DepositID] = append(history[lt.DepositID], lt)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, skyAddr := range skyAddrs {
+			o := Owner{
+				SkyAddress:        skyAddr,
+				Bindings:          []Binding{},
+				CancelledBindings: []CancelledBinding{},
+				Deposits:          []OwnerDeposit{},
+			}
+
+			boundAddrs, err := s.getSkyBindBtcAddressesTx(tx, skyAddr)
+			if err != nil {
+				return err
+			}
+
+			for _, a := range boundAddrs {
+				coinType, err := s.getBindCoinTypeTx(tx, skyAddr, a)
+				if err != nil {
+					return err
+				}
+				version, err := s.getBindVersionTx(tx, a, coinType)
+				if err != nil {
+					return err
+				}
+				o.Bindings = append(o.Bindings, Binding{
+					DepositAddress: a,
+					CoinType:       coinType,
+					Version:        version,
+				})
+			}
+
+			for _, cb := range cbs {
+				if cb.SkyAddress == skyAddr {
+					o.CancelledBindings = append(o.CancelledBindings, cb)
+				}
+			}
+
+			// The owner's deposits were made to the deposit addresses it has bound
+			depositAddrs := boundAddrs
+			for _, cb := range o.CancelledBindings {
+				depositAddrs = append(depositAddrs, cb.DepositAddress)
+			}
+
+			dis := make(map[string]DepositInfo)
+			for _, a := range depositAddrs {
+				if err := queryDepositInfosTx(tx, DepositQuery{
+					DepositAddress: a,
+					Filter: func(di DepositInfo) bool {
+						return di.SkyAddress == skyAddr
+					},
+				}, func(di DepositInfo) error {
+					dis[di.DepositID] = di
+					return nil
+				}); err != nil {
+					return err
+				}
+			}
+
+			depositIDs := make([]string, 0, len(dis))
+			for id := range dis {
+				depositIDs = append(depositIDs, id)
+			}
+			sort.Strings(depositIDs)
+
+			for _, id := range depositIDs {
+				di := dis[id]
+
+				h := history[di.DepositID]
+				if h == nil {
+					h = []LedgerTransaction{}
+				}
+
+				o.Deposits = append(o.Deposits, OwnerDeposit{
+					DepositStatusDetail: newDepositStatusDetail(di),
+					DepositValue:        di.DepositValue,
+					SkySent:             di.SkySent,
+					History:             h,
+				})
+			}
+
+			owners = append(owners, o)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return owners, nil
+}
+
+// LookupOwners returns the skycoin addresses which a deposit address or a transaction belongs to,
+// with their binding and deposit history. See Store.LookupOwners.
+func (s *Exchange) LookupOwners(depositAddr, txid string) ([]Owner, error) {
+	owners, err := s.store.LookupOwners(depositAddr, txid)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range owners {
+		for j := range owners[i].Deposits {
+			owners[i].Deposits[j].Link(s.cfg.Explorer)
+		}
+	}
+
+	return owners, nil
+}
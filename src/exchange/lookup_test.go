@@ -0,0 +1,90 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestStoreLookupOwners(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	// btcaddr1 was bound to testSkyAddr2, cancelled and reused for testSkyAddr
+	require.NoError(t, s.BindAddress(testSkyAddr2, "btcaddr1", scanner.CoinTypeBTC, ""))
+	_, err := s.CancelBinding(testSkyAddr2, "btcaddr1", scanner.CoinTypeBTC, 0, true, time.Now())
+	require.NoError(t, err)
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr2", scanner.CoinTypeBTC, ""))
+
+	di, err := s.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr1",
+		Amount:   1e6,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "skytx1"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+
+	// The current owner comes first, then the owner of the cancelled binding
+	owners, err := s.LookupOwners("btcaddr1", "")
+	require.NoError(t, err)
+	require.Len(t, owners, 2)
+
+	o := owners[0]
+	require.Equal(t, testSkyAddr, o.SkyAddress)
+	require.Equal(t, []Binding{
+		{DepositAddress: "btcaddr1", CoinType: scanner.CoinTypeBTC, Version: 3},
+		{DepositAddress: "btcaddr2", CoinType: scanner.CoinTypeBTC, Version: 1},
+	}, o.Bindings)
+	require.Empty(t, o.CancelledBindings)
+	require.Len(t, o.Deposits, 1)
+	require.Equal(t, "btx1:1", o.Deposits[0].DepositID)
+	require.Equal(t, StatusWaitConfirm.String(), o.Deposits[0].Status)
+	require.Equal(t, int64(1e6), o.Deposits[0].DepositValue)
+	require.Equal(t, uint64(5e6), o.Deposits[0].SkySent)
+	require.NotEmpty(t, o.Deposits[0].History)
+
+	o = owners[1]
+	require.Equal(t, testSkyAddr2, o.SkyAddress)
+	require.Empty(t, o.Bindings)
+	require.Len(t, o.CancelledBindings, 1)
+	require.Equal(t, "btcaddr1", o.CancelledBindings[0].DepositAddress)
+	require.Empty(t, o.Deposits)
+
+	// A deposit's BTC transaction or skycoin payout transaction
+	for _, txid := range []string{"btx1", "skytx1"} {
+		owners, err = s.LookupOwners("", txid)
+		require.NoError(t, err)
+		require.Len(t, owners, 1, txid)
+		require.Equal(t, testSkyAddr, owners[0].SkyAddress)
+	}
+
+	// A bound deposit address without deposits
+	owners, err = s.LookupOwners("btcaddr2", "")
+	require.NoError(t, err)
+	require.Len(t, owners, 1)
+	require.Equal(t, testSkyAddr, owners[0].SkyAddress)
+
+	owners, err = s.LookupOwners("btcaddr3", "")
+	require.NoError(t, err)
+	require.Empty(t, owners)
+
+	owners, err = s.LookupOwners("", "btx2")
+	require.NoError(t, err)
+	require.Empty(t, owners)
+}
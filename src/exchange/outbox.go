@@ -0,0 +1,306 @@
+package exchange
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/skycoin/src/coin"
+
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// Send outbox.
+// A deposit's skycoin transaction is signed, then saved to the outbox in the same
+// db transaction that moves the deposit to StatusWaitConfirm. It is broadcast afterwards,
+// and marked as broadcast once the node has accepted it.
+//
+// If teller stops before the outbox entry is saved, nothing was broadcast and the
+// deposit is still StatusWaitSend, so a new transaction is created on restart.
+// If teller stops after the entry is saved, the same signed transaction is broadcast
+// on restart. Broadcasting a transaction twice doesn't send the coins twice, since
+// both broadcasts have the same txid and spend the same outputs.
+//
+// Each entry has the deposit's idempotency key, which is saved with its txid in SendKeyBkt.
+// An entry with another txid is refused for a saved key, and so is its broadcast by the sender,
+// so a deposit can't be paid by two transactions, see sender/idempotency.go.
+
+var (
+	// SendOutboxBkt maps a skycoin txid to the OutboxEntry of its signed transaction
+	SendOutboxBkt = []byte("send_outbox")
+
+	// SendKeyBkt maps a deposit's idempotency key to the txid of the skycoin transaction paying it
+	SendKeyBkt = []byte("send_keys")
+)
+
+// ErrBroadcastPending is returned while a deposit's saved transaction could not be broadcast yet
+var ErrBroadcastPending = errors.New("Skycoin transaction is waiting to be broadcast")
+
+// SendRefusedErr is the reason a deposit is held for review when its saved transaction can't be broadcast
+type SendRefusedErr struct {
+	Txid string
+	Err  error
+}
+
+func (e SendRefusedErr) Error() string {
+	return fmt.Sprintf("Skycoin transaction %s was refused: %v", e.Txid, e.Err)
+}
+
+// OutboxEntry is a signed skycoin transaction paying a deposit
+type OutboxEntry struct {
+	Txid      string `json:"txid"`
+	DepositID string `json:"deposit_id"`
+	// Idempotency key of the deposit, see sender.IdempotencyKey
+	Key string `json:"key"`
+	// Hex encoded serialized transaction
+	RawTx     string `json:"raw_tx"`
+	CreatedAt int64  `json:"created_at"`
+	// 0 until the node accepts the transaction
+	BroadcastAt int64 `json:"broadcast_at"`
+}
+
+// Transaction decodes the entry's transaction
+func (e OutboxEntry) Transaction() (*coin.Transaction, error) {
+	b, err := hex.DecodeString(e.RawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := coin.TransactionDeserialize(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+func newOutboxEntry(depositID string, tx *coin.Transaction) OutboxEntry {
+	return OutboxEntry{
+		Txid:      tx.TxIDHex(),
+		DepositID: depositID,
+		Key:       sender.IdempotencyKey(depositID),
+		RawTx:     hex.EncodeToString(tx.Serialize()),
+		CreatedAt: time.Now().UTC().Unix(),
+	}
+}
+
+// CommitSend updates a deposit and saves its outbox entry and idempotency key in one db transaction.
+// It returns a sender.DuplicateSendErr if the key was saved with another transaction.
+func (s *Store) CommitSend(depositID string, update func(DepositInfo) DepositInfo, entry OutboxEntry) (DepositInfo, error) {
+	var t Transition
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := putSendKeyTx(tx, entry); err != nil {
+			return err
+		}
+
+		var err error
+		t, err = s.updateDepositInfoTx(tx, depositID, update)
+		if err != nil {
+			return err
+		}
+
+		return dbutil.PutBucketValue(tx, SendOutboxBkt, entry.Txid, entry)
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.states.committed(t)
+
+	return t.To, nil
+}
+
+// GetOutboxEntry returns the outbox entry of a txid. It returns false if there is none,
+// which is the case for deposits sent before the outbox was added.
+func (s *Store) GetOutboxEntry(txid string) (OutboxEntry, bool, error) {
+	var entry OutboxEntry
+	var ok bool
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		err := dbutil.GetBucketObject(tx, SendOutboxBkt, txid, &entry)
+		switch err.(type) {
+		case nil:
+			ok = true
+			return nil
+		case dbutil.ObjectNotExistErr:
+			return nil
+		default:
+			return err
+		}
+	}); err != nil {
+		return OutboxEntry{}, false, err
+	}
+
+	return entry, ok, nil
+}
+
+// putSendKeyTx saves an outbox entry's idempotency key with its txid,
+// unless the key was saved with another transaction
+func putSendKeyTx(tx *bolt.Tx, entry OutboxEntry) error {
+	savedTxid, err := dbutil.GetBucketString(tx, SendKeyBkt, entry.Key)
+	switch err.(type) {
+	case nil:
+		if savedTxid != entry.Txid {
+			return sender.DuplicateSendErr{
+				Key:       entry.Key,
+				Txid:      entry.Txid,
+				SavedTxid: savedTxid,
+			}
+		}
+		return nil
+	case dbutil.ObjectNotExistErr:
+		return dbutil.PutBucketValue(tx, SendKeyBkt, entry.Key, entry.Txid)
+	default:
+		return err
+	}
+}
+
+// GetSendKey returns the txid of the transaction saved with an idempotency key,
+// or false if no transaction was saved with it
+func (s *Store) GetSendKey(key string) (string, bool, error) {
+	var txid string
+	var ok bool
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		txid, err = dbutil.GetBucketString(tx, SendKeyBkt, key)
+		switch err.(type) {
+		case nil:
+			ok = true
+			return nil
+		case dbutil.ObjectNotExistErr:
+			return nil
+		default:
+			return err
+		}
+	}); err != nil {
+		return "", false, err
+	}
+
+	return txid, ok, nil
+}
+
+// initSendKeysTx saves the idempotency keys of the outbox entries saved before the keys were added
+func initSendKeysTx(tx *bolt.Tx) error {
+	var entries []OutboxEntry
+	if err := dbutil.ForEach(tx, SendOutboxBkt, func(k, v []byte) error {
+		var entry OutboxEntry
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return err
+		}
+		if entry.Key == "" {
+			entries = append(entries, entry)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		entry.Key = sender.IdempotencyKey(entry.DepositID)
+		if err := putSendKeyTx(tx, entry); err != nil {
+			return err
+		}
+		if err := dbutil.PutBucketValue(tx, SendOutboxBkt, entry.Txid, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkBroadcast records that an outbox entry's transaction was accepted by the node.
+// An entry which is already marked keeps its first broadcast time.
+func (s *Store) MarkBroadcast(txid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var entry OutboxEntry
+		if err := dbutil.GetBucketObject(tx, SendOutboxBkt, txid, &entry); err != nil {
+			return err
+		}
+
+		if entry.BroadcastAt != 0 {
+			return nil
+		}
+
+		entry.BroadcastAt = time.Now().UTC().Unix()
+		return dbutil.PutBucketValue(tx, SendOutboxBkt, txid, entry)
+	})
+}
+
+// dispatch broadcasts a deposit's saved transaction, unless it was already broadcast.
+// A transaction which is already confirmed is only marked as broadcast, since the node
+// would refuse it for spending spent outputs.
+// Only the errors of an unreachable node, a sender.RPCError or ErrNoResponse, are retried.
+// A transaction refused by the sender for any other reason, e.g. permanently, see sender.IsPermanent,
+// is not retried, since it would hold up the later payouts: the deposit is held for review,
+// and returned with StatusWaitReview.
+func (s *Exchange) dispatch(di DepositInfo) (DepositInfo, error) {
+	log := s.log.WithField("deposit", di)
+
+	entry, ok, err := s.store.GetOutboxEntry(di.Txid)
+	if err != nil {
+		log.WithError(err).Error("store.GetOutboxEntry failed")
+		return di, err
+	}
+
+	if !ok || entry.BroadcastAt != 0 {
+		return di, nil
+	}
+
+	if rsp := s.sender.IsTxConfirmed(entry.Txid); rsp != nil && rsp.Err == nil && rsp.Confirmed {
+		log.Info("Saved transaction is already confirmed")
+		return di, s.store.MarkBroadcast(entry.Txid)
+	}
+
+	skyTx, err := entry.Transaction()
+	if err != nil {
+		log.WithError(err).Error("Decode saved transaction failed")
+		return di, err
+	}
+
+	rsp, err := s.broadcastTransaction(skyTx, entry.Key)
+	switch {
+	case err == nil:
+	case sender.IsPermanent(err):
+		log.WithError(err).Error("broadcastTransaction refused the saved transaction, it won't be retried")
+		return s.holdForReview(di, SendRefusedErr{
+			Txid: entry.Txid,
+			Err:  err,
+		})
+	case isBroadcastRetryable(err):
+		log.WithError(err).Error("broadcastTransaction failed, it will be retried")
+		return di, ErrBroadcastPending
+	default:
+		log.WithError(err).Error("broadcastTransaction failed with an unexpected error, it won't be retried")
+		return s.holdForReview(di, SendRefusedErr{
+			Txid: entry.Txid,
+			Err:  err,
+		})
+	}
+
+	// Invariant assertion: do not return this as an error, since
+	// coins have been sent. This should never occur.
+	if rsp.Txid != entry.Txid {
+		log.Error("CRITICAL ERROR: BroadcastTxResponse.Txid != OutboxEntry.Txid")
+	}
+
+	if err := s.store.MarkBroadcast(entry.Txid); err != nil {
+		// The transaction is broadcast again on the next attempt, which is harmless
+		log.WithError(err).Error("store.MarkBroadcast failed, it will be retried")
+		return di, ErrBroadcastPending
+	}
+
+	return di, nil
+}
+
+// isBroadcastRetryable returns true if a broadcast failed because the skycoin node or the sender is unavailable
+func isBroadcastRetryable(err error) bool {
+	if _, ok := err.(sender.RPCError); ok {
+		return true
+	}
+	return err == ErrNoResponse
+}
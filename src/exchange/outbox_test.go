@@ -0,0 +1,308 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/coin"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestStoreCommitSend(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	_, err := s.addDepositInfo(DepositInfo{
+		DepositID:      "btx1:1",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+	})
+	require.NoError(t, err)
+
+	skyTx := &coin.Transaction{
+		Out: []coin.TransactionOutput{
+			{
+				Address: cipher.MustDecodeBase58Address(testSkyAddr),
+				Coins:   100e6,
+			},
+		},
+	}
+	txid := skyTx.TxIDHex()
+
+	_, ok, err := s.GetOutboxEntry(txid)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	di, err := s.CommitSend("btx1:1", func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = txid
+		di.SkySent = 100e6
+		return di
+	}, newOutboxEntry("btx1:1", skyTx))
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, txid, di.Txid)
+
+	di, err = s.getDepositInfo("btx1:1")
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+
+	entry, ok, err := s.GetOutboxEntry(txid)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txid, entry.Txid)
+	require.Equal(t, "btx1:1", entry.DepositID)
+	require.Equal(t, sender.IdempotencyKey("btx1:1"), entry.Key)
+	require.NotEmpty(t, entry.CreatedAt)
+	require.Empty(t, entry.BroadcastAt)
+
+	decoded, err := entry.Transaction()
+	require.NoError(t, err)
+	require.Equal(t, txid, decoded.TxIDHex())
+
+	// MarkBroadcast keeps the first broadcast time
+	require.NoError(t, s.MarkBroadcast(txid))
+	entry, _, err = s.GetOutboxEntry(txid)
+	require.NoError(t, err)
+	require.NotEmpty(t, entry.BroadcastAt)
+
+	broadcastAt := entry.BroadcastAt
+	require.NoError(t, s.MarkBroadcast(txid))
+	entry, _, err = s.GetOutboxEntry(txid)
+	require.NoError(t, err)
+	require.Equal(t, broadcastAt, entry.BroadcastAt)
+
+	err = s.MarkBroadcast("unknown")
+	require.IsType(t, dbutil.ObjectNotExistErr{}, err)
+
+	savedTxid, ok, err := s.GetSendKey(entry.Key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, txid, savedTxid)
+
+	_, ok, err = s.GetSendKey(sender.IdempotencyKey("btx2:1"))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestStoreCommitSendDuplicateKey(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	_, err := s.addDepositInfo(DepositInfo{
+		DepositID:      "btx1:1",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+	})
+	require.NoError(t, err)
+
+	newTx := func(coins uint64) *coin.Transaction {
+		return &coin.Transaction{
+			Out: []coin.TransactionOutput{
+				{
+					Address: cipher.MustDecodeBase58Address(testSkyAddr),
+					Coins:   coins,
+				},
+			},
+		}
+	}
+
+	// The deposit's key was saved with another transaction, e.g. by a send which was rolled back
+	skyTx := newTx(100e6)
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return putSendKeyTx(tx, newOutboxEntry("btx1:1", newTx(200e6)))
+	})
+	require.NoError(t, err)
+
+	_, err = s.CommitSend("btx1:1", func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = skyTx.TxIDHex()
+		di.SkySent = 100e6
+		return di
+	}, newOutboxEntry("btx1:1", skyTx))
+	require.Equal(t, sender.DuplicateSendErr{
+		Key:       sender.IdempotencyKey("btx1:1"),
+		Txid:      skyTx.TxIDHex(),
+		SavedTxid: newTx(200e6).TxIDHex(),
+	}, err)
+
+	// Nothing is saved
+	di, err := s.getDepositInfo("btx1:1")
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, di.Status)
+
+	_, ok, err := s.GetOutboxEntry(skyTx.TxIDHex())
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestStoreInitSendKeys(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	// An entry saved before the keys were added
+	entry := newOutboxEntry("btx1:1", &coin.Transaction{})
+	entry.Key = ""
+	err = db.Update(func(tx *bolt.Tx) error {
+		return dbutil.PutBucketValue(tx, SendOutboxBkt, entry.Txid, entry)
+	})
+	require.NoError(t, err)
+
+	s, err = NewStore(log, db)
+	require.NoError(t, err)
+
+	key := sender.IdempotencyKey("btx1:1")
+	txid, ok, err := s.GetSendKey(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, entry.Txid, txid)
+
+	entry, ok, err = s.GetOutboxEntry(entry.Txid)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, key, entry.Key)
+}
+
+func TestStoreCommitSendUnknownDeposit(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	skyTx := &coin.Transaction{}
+	_, err := s.CommitSend("btx1:1", func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		return di
+	}, newOutboxEntry("btx1:1", skyTx))
+	require.Error(t, err)
+
+	// Nothing is saved to the outbox if the deposit can't be updated
+	_, ok, err := s.GetOutboxEntry(skyTx.TxIDHex())
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, ok, err = s.GetSendKey(sender.IdempotencyKey("btx1:1"))
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestExchangeDuplicateSend(t *testing.T) {
+	e, shutdown := newCapTestExchange(t, CampaignCapConfig{})
+	defer shutdown()
+
+	// The deposit's key was saved with another transaction
+	err := e.store.(*Store).db.Update(func(tx *bolt.Tx) error {
+		return dbutil.PutBucketValue(tx, SendKeyBkt, sender.IdempotencyKey("btc-tx-1:1"), "other-txid")
+	})
+	require.NoError(t, err)
+
+	di := handleCapDeposit(t, e, 1, 1e8)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Zero(t, di.SkySent)
+	require.Empty(t, di.Txid)
+	require.Contains(t, di.Error, "was already used by skycoin transaction other-txid")
+}
+
+func TestExchangeSendRefusedHeldForReview(t *testing.T) {
+	// A saved transaction refused permanently by the sender is held for review,
+	// and the later deposits are still sent
+	e, shutdown, hook := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	s := e.sender.(*dummySender)
+	txid := s.predictTxid(t, testSkyAddr, 100e6)
+	refusal := sender.DuplicateSendErr{
+		Key:       sender.IdempotencyKey("refused-tx:1"),
+		Txid:      txid,
+		SavedTxid: "other-txid",
+	}
+	s.Lock()
+	s.broadcastTxidErrs = map[string]error{
+		txid: refusal,
+	}
+	s.Unlock()
+
+	deposit := func(skyAddr, btcAddr, tx string) deposits.Deposit {
+		require.NoError(t, e.store.BindAddress(skyAddr, btcAddr, scanner.CoinTypeBTC, ""))
+
+		dn := scanner.DepositNote{
+			Deposit: deposits.Deposit{
+				CoinType: scanner.CoinTypeBTC,
+				Address:  btcAddr,
+				Amount:   1e8,
+				Height:   20,
+				Tx:       tx,
+				N:        1,
+				Final:    true,
+			},
+			ErrC: make(chan error, 1),
+		}
+		mp := e.multiplexer.(*scanner.Multiplexer)
+		mp.GetScanner(scanner.CoinTypeBTC).(*dummyScanner).addDeposit(dn)
+		require.NoError(t, <-dn.ErrC)
+		return dn.Deposit
+	}
+
+	waitStatus := func(depositID string, status Status) DepositInfo {
+		timeout := time.After(dbScanTimeout)
+		for {
+			di, err := e.store.(*Store).getDepositInfo(depositID)
+			require.NoError(t, err)
+			if di.Status == status {
+				return di
+			}
+
+			select {
+			case <-time.After(dbCheckWaitTime):
+			case <-timeout:
+				t.Fatalf("Waiting for deposit %s to be %s timed out, it is %s", depositID, status, di.Status)
+			}
+		}
+	}
+
+	refused := deposit(testSkyAddr, "refused-btc-addr", "refused-tx")
+	di := waitStatus(refused.ID(), StatusWaitReview)
+	require.Equal(t, txid, di.Txid)
+	require.Equal(t, SendRefusedErr{
+		Txid: txid,
+		Err:  refusal,
+	}.Error(), di.Error)
+
+	// The refused transaction is not broadcast
+	entry, ok, err := e.store.GetOutboxEntry(txid)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Zero(t, entry.BroadcastAt)
+
+	var alerted bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["alert"] == "send_refused" {
+			alerted = true
+		}
+	}
+	require.True(t, alerted)
+
+	// The next deposit is sent
+	sent := deposit(testSkyAddr2, "sent-btc-addr", "sent-tx")
+	di = waitStatus(sent.ID(), StatusWaitConfirm)
+	s.setTxConfirmed(di.Txid)
+	waitStatus(sent.ID(), StatusDone)
+}
@@ -0,0 +1,221 @@
+package exchange
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// The payout log is a public, append-only record of the skycoin paid out, which lets anyone
+// check how the skycoin was distributed without learning who received it.
+// Each entry hides the skycoin address behind a salted hash and the amount in a range,
+// and is chained to the entry before it by PrevHash, so that a published log can't be
+// rewritten without changing the Hash of every later entry.
+
+var (
+	// PayoutLogBkt maps a sequence number to a PayoutLogEntry
+	PayoutLogBkt = []byte("payout_log")
+
+	// PayoutLogIndexBkt maps a deposit ID to the sequence number of its PayoutLogEntry
+	PayoutLogIndexBkt = []byte("payout_log_index")
+
+	// ErrPayoutLogBroken is returned by VerifyPayoutLog if the hash chain is broken
+	ErrPayoutLogBroken = errors.New("Payout log hash chain is broken")
+)
+
+// PayoutLogEntry is a payout in the public payout log
+type PayoutLogEntry struct {
+	Seq uint64 `json:"seq"`
+	// Time the payout was confirmed, unix seconds
+	Time int64 `json:"time"`
+	// Hex encoded HMAC-SHA256 of the skycoin address, keyed by the payout log salt
+	SkyAddressHash string `json:"sky_address_hash"`
+	// Range of the skycoin sent, in whole SKY, e.g. "10-100"
+	AmountBucket string `json:"amount_bucket"`
+	Txid         string `json:"txid"`
+	// Hash of the previous entry, empty for the first entry
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// ComputeHash returns the hex encoded SHA256 of the entry's fields, other than Hash, joined by "|"
+func (e PayoutLogEntry) ComputeHash() string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%d|%d|%s|%s|%s|%s", e.Seq, e.Time, e.SkyAddressHash, e.AmountBucket, e.Txid, e.PrevHash)))
+	return hex.EncodeToString(h[:])
+}
+
+// VerifyPayoutLog checks the hash chain of consecutive entries, starting after the entry whose hash is prevHash.
+// prevHash is empty if entries starts with the first entry.
+func VerifyPayoutLog(entries []PayoutLogEntry, prevHash string) error {
+	for _, e := range entries {
+		if e.PrevHash != prevHash || e.ComputeHash() != e.Hash {
+			return ErrPayoutLogBroken
+		}
+		prevHash = e.Hash
+	}
+	return nil
+}
+
+// payoutAmountBucket returns the power of ten range of whole SKY containing droplets, e.g. "10-100".
+// Amounts below 1 SKY are "0-1".
+func payoutAmountBucket(droplets uint64) string {
+	sky := droplets / droplet.Multiplier
+	if sky == 0 {
+		return "0-1"
+	}
+
+	lower := uint64(1)
+	for sky/lower >= 10 {
+		lower *= 10
+	}
+
+	return strconv.FormatUint(lower, 10) + "-" + strconv.FormatUint(lower*10, 10)
+}
+
+// AppendPayoutLog appends the payout of a StatusDone deposit to the payout log, unless it is logged already.
+// The skycoin address is hashed with salt. It returns true if an entry was appended.
+func (s *Store) AppendPayoutLog(di DepositInfo, salt []byte) (bool, error) {
+	if di.Status != StatusDone || di.SkySent == 0 {
+		return false, fmt.Errorf("deposit %s has not paid out any skycoin", di.DepositID)
+	}
+
+	var appended bool
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		if logged, err := dbutil.BucketHasKey(tx, PayoutLogIndexBkt, di.DepositID); err != nil {
+			return err
+		} else if logged {
+			return nil
+		}
+
+		var prevHash string
+		bkt := tx.Bucket(PayoutLogBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(PayoutLogBkt)
+		}
+		if _, v := bkt.Cursor().Last(); v != nil {
+			var last PayoutLogEntry
+			if err := json.Unmarshal(v, &last); err != nil {
+				return err
+			}
+			prevHash = last.Hash
+		}
+
+		seq, err := dbutil.NextSequence(tx, PayoutLogBkt)
+		if err != nil {
+			return err
+		}
+
+		e := PayoutLogEntry{
+			Seq:            seq,
+			Time:           di.UpdatedAt,
+			SkyAddressHash: analytics.HashAddress(salt, di.SkyAddress),
+			AmountBucket:   payoutAmountBucket(di.SkySent),
+			Txid:           di.Txid,
+			PrevHash:       prevHash,
+		}
+		e.Hash = e.ComputeHash()
+
+		if err := dbutil.PutBucketValue(tx, PayoutLogBkt, ledgerSeqKey(seq), e); err != nil {
+			return err
+		}
+
+		appended = true
+		return dbutil.PutBucketValue(tx, PayoutLogIndexBkt, di.DepositID, strconv.FormatUint(seq, 10))
+	}); err != nil {
+		return false, err
+	}
+
+	return appended, nil
+}
+
+// GetPayoutLog returns up to limit payout log entries with a Seq greater than since, oldest first
+func (s *Store) GetPayoutLog(since uint64, limit int) ([]PayoutLogEntry, error) {
+	var entries []PayoutLogEntry
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(PayoutLogBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(PayoutLogBkt)
+		}
+
+		c := bkt.Cursor()
+		for k, v := c.Seek([]byte(ledgerSeqKey(since + 1))); k != nil && len(entries) < limit; k, v = c.Next() {
+			var e PayoutLogEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			entries = append(entries, e)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// logPayout is a TransitionHook which appends confirmed payouts to the payout log
+func (s *Exchange) logPayout(t Transition) {
+	if t.To.Status != StatusDone || t.To.SkySent == 0 {
+		return
+	}
+
+	if _, err := s.store.AppendPayoutLog(t.To, []byte(s.cfg.PayoutLog.Salt)); err != nil {
+		// The payout is logged by backfillPayoutLog when the exchange restarts
+		s.log.WithError(err).WithField("depositID", t.To.DepositID).Error("AppendPayoutLog failed")
+	}
+}
+
+// backfillPayoutLog appends the payouts which are not logged yet, in the order they were confirmed.
+// These were confirmed while the payout log was disabled, or failed to be logged.
+func (s *Exchange) backfillPayoutLog() error {
+	dis, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return di.Status == StatusDone && di.SkySent != 0
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(dis, func(i, j int) bool {
+		if dis[i].UpdatedAt != dis[j].UpdatedAt {
+			return dis[i].UpdatedAt < dis[j].UpdatedAt
+		}
+		return dis[i].Seq < dis[j].Seq
+	})
+
+	var n int
+	for _, di := range dis {
+		appended, err := s.store.AppendPayoutLog(di, []byte(s.cfg.PayoutLog.Salt))
+		if err != nil {
+			return err
+		}
+		if appended {
+			n++
+		}
+	}
+
+	if n != 0 {
+		s.log.WithFields(logrus.Fields{
+			"appended": n,
+		}).Info("Backfilled payout log")
+	}
+
+	return nil
+}
+
+// GetPayoutLog returns up to limit payout log entries with a Seq greater than since, oldest first
+func (s *Exchange) GetPayoutLog(since uint64, limit int) ([]PayoutLogEntry, error) {
+	return s.store.GetPayoutLog(since, limit)
+}
@@ -0,0 +1,258 @@
+package exchange
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestPayoutAmountBucket(t *testing.T) {
+	cases := map[uint64]string{
+		0:        "0-1",
+		999999:   "0-1",
+		1e6:      "1-10",
+		9999999:  "1-10",
+		10e6:     "10-100",
+		123e6:    "100-1000",
+		1000e6:   "1000-10000",
+		99999e6:  "10000-100000",
+		100000e6: "100000-1000000",
+	}
+
+	for droplets, bucket := range cases {
+		require.Equal(t, bucket, payoutAmountBucket(droplets), droplets)
+	}
+}
+
+func newPayoutDepositInfo(id, skyAddr string, skySent uint64, updatedAt int64) DepositInfo {
+	return DepositInfo{
+		CoinType:       scanner.CoinTypeBTC,
+		DepositID:      id,
+		DepositAddress: "btc-addr",
+		SkyAddress:     skyAddr,
+		Status:         StatusDone,
+		Txid:           "txid-" + id,
+		SkySent:        skySent,
+		ConversionRate: testSkyBtcRate,
+		DepositValue:   1e6,
+		UpdatedAt:      updatedAt,
+		Deposit: deposits.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  "btc-addr",
+			Tx:       strings.Split(id, ":")[0],
+			N:        1,
+			Amount:   1e6,
+		},
+	}
+}
+
+func TestStoreAppendPayoutLog(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	salt := []byte("payout-salt")
+
+	entries, err := s.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Only paid out deposits can be logged
+	di := newPayoutDepositInfo("btx1:1", "sky1", 5e6, 100)
+	di.Status = StatusWaitConfirm
+	_, err = s.AppendPayoutLog(di, salt)
+	require.Error(t, err)
+
+	di = newPayoutDepositInfo("btx1:1", "sky1", 0, 100)
+	_, err = s.AppendPayoutLog(di, salt)
+	require.Error(t, err)
+
+	dis := []DepositInfo{
+		newPayoutDepositInfo("btx1:1", "sky1", 5e6, 100),
+		newPayoutDepositInfo("btx2:1", "sky2", 250e6, 200),
+		newPayoutDepositInfo("btx3:1", "sky1", 1e6/2, 300),
+	}
+
+	for _, di := range dis {
+		appended, err := s.AppendPayoutLog(di, salt)
+		require.NoError(t, err)
+		require.True(t, appended)
+	}
+
+	// Appending a logged deposit again does nothing
+	appended, err := s.AppendPayoutLog(dis[1], salt)
+	require.NoError(t, err)
+	require.False(t, appended)
+
+	entries, err = s.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	require.Equal(t, PayoutLogEntry{
+		Seq:            1,
+		Time:           100,
+		SkyAddressHash: analytics.HashAddress(salt, "sky1"),
+		AmountBucket:   "1-10",
+		Txid:           "txid-btx1:1",
+		Hash:           entries[0].Hash,
+	}, entries[0])
+	require.Equal(t, "100-1000", entries[1].AmountBucket)
+	require.Equal(t, "0-1", entries[2].AmountBucket)
+
+	// The same skycoin address has the same hash
+	require.Equal(t, entries[0].SkyAddressHash, entries[2].SkyAddressHash)
+	require.NotEqual(t, entries[0].SkyAddressHash, entries[1].SkyAddressHash)
+
+	// Each entry is chained to the one before
+	require.Empty(t, entries[0].PrevHash)
+	require.Equal(t, entries[0].Hash, entries[1].PrevHash)
+	require.Equal(t, entries[1].Hash, entries[2].PrevHash)
+	require.NoError(t, VerifyPayoutLog(entries, ""))
+
+	// Pages of the log verify from the hash of the entry before them
+	page, err := s.GetPayoutLog(1, 1)
+	require.NoError(t, err)
+	require.Equal(t, entries[1:2], page)
+	require.NoError(t, VerifyPayoutLog(page, entries[0].Hash))
+	require.Equal(t, ErrPayoutLogBroken, VerifyPayoutLog(page, ""))
+
+	page, err = s.GetPayoutLog(3, 10)
+	require.NoError(t, err)
+	require.Empty(t, page)
+
+	// Changing an entry breaks the chain
+	tampered := append([]PayoutLogEntry{}, entries...)
+	tampered[1].AmountBucket = "1-10"
+	require.Equal(t, ErrPayoutLogBroken, VerifyPayoutLog(tampered, ""))
+
+	tampered[1].Hash = tampered[1].ComputeHash()
+	require.Equal(t, ErrPayoutLogBroken, VerifyPayoutLog(tampered, ""))
+
+	// Removing an entry breaks the chain
+	require.Equal(t, ErrPayoutLogBroken, VerifyPayoutLog([]PayoutLogEntry{entries[0], entries[2]}, ""))
+}
+
+func TestExchangePayoutLog(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	e, err := NewExchange(log, store, nil, nil, analytics.Noop{}, Config{
+		BtcRate: testSkyBtcRate,
+		PayoutLog: PayoutLogConfig{
+			Enabled: true,
+			Salt:    "payout-salt",
+		},
+	})
+	require.NoError(t, err)
+
+	di := newPayoutDepositInfo("btx1:1", "sky1", 0, 100)
+	di.Status = StatusWaitSend
+	di.Txid = ""
+	_, err = store.addDepositInfo(di)
+	require.NoError(t, err)
+
+	_, err = store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "txid-btx1:1"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+
+	// Sent but unconfirmed deposits aren't logged
+	entries, err := e.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	di, err = store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusDone
+		return di
+	})
+	require.NoError(t, err)
+
+	entries, err = e.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, analytics.HashAddress([]byte("payout-salt"), "sky1"), entries[0].SkyAddressHash)
+	require.Equal(t, "1-10", entries[0].AmountBucket)
+	require.Equal(t, di.Txid, entries[0].Txid)
+	require.Equal(t, di.UpdatedAt, entries[0].Time)
+
+	// Backfilling skips the logged deposit
+	require.NoError(t, e.backfillPayoutLog())
+	entries, err = e.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestExchangeBackfillPayoutLog(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	// Payouts confirmed while the payout log is disabled aren't logged
+	e, err := NewExchange(log, store, nil, nil, analytics.Noop{}, Config{
+		BtcRate: testSkyBtcRate,
+	})
+	require.NoError(t, err)
+
+	dis := []DepositInfo{
+		newPayoutDepositInfo("btx1:1", "sky1", 5e6, 300),
+		newPayoutDepositInfo("btx2:1", "sky2", 50e6, 100),
+		newPayoutDepositInfo("btx3:1", "sky3", 0, 150),
+		newPayoutDepositInfo("btx4:1", "sky4", 500e6, 200),
+	}
+	dis[2].Txid = ""
+	dis[2].Error = ErrEmptySendAmount.Error()
+
+	for _, di := range dis {
+		saved, err := store.addDepositInfo(di)
+		require.NoError(t, err)
+
+		// addDepositInfo sets UpdatedAt to now, restore when the payout was confirmed
+		saved.UpdatedAt = di.UpdatedAt
+		err = db.Update(func(tx *bolt.Tx) error {
+			return dbutil.PutBucketValue(tx, DepositInfoBkt, saved.DepositID, saved)
+		})
+		require.NoError(t, err)
+	}
+
+	entries, err := e.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	e.cfg.PayoutLog = PayoutLogConfig{
+		Enabled: true,
+		Salt:    "payout-salt",
+	}
+
+	// Payouts are logged in the order they were confirmed, skipping empty sends
+	require.NoError(t, e.backfillPayoutLog())
+	entries, err = e.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	require.Equal(t, "txid-btx2:1", entries[0].Txid)
+	require.Equal(t, "txid-btx4:1", entries[1].Txid)
+	require.Equal(t, "txid-btx1:1", entries[2].Txid)
+	require.NoError(t, VerifyPayoutLog(entries, ""))
+
+	// Backfilling again appends nothing
+	require.NoError(t, e.backfillPayoutLog())
+	again, err := e.GetPayoutLog(0, 10)
+	require.NoError(t, err)
+	require.Equal(t, entries, again)
+}
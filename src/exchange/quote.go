@@ -0,0 +1,159 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+// Quote currency pricing.
+// With a QuoteConfig, SKY is sold at a price in a quote currency, e.g. USD, instead of at a fixed SKY per coin rate.
+// Its feeds quote the price of each coin type and of SKY in the quote currency, and a deposit received
+// is converted at the coin's price divided by SKY's price, e.g. BTC/USD over SKY/USD. Both prices are saved
+// with the deposit as its ConversionQuote, so the conversion can be audited. The price of SKY is fixed
+// by SkyPrice, unless there are SKY feeds, which SkyPrice is the fallback of like the configured rates.
+// While a price is unavailable, deposits are converted at the rate feeds' rate or the configured rate.
+
+// QuoteConfig configures the sale price of SKY in a quote currency. It is disabled if Currency is empty.
+type QuoteConfig struct {
+	// Currency the prices are quoted in, e.g. USD
+	Currency string
+	// Price of SKY in Currency, decimal string. Can be empty if there are SKY feeds.
+	SkyPrice string
+	// Feeds quoting the price of BTC, ETH and SKY in Currency
+	Feeds RateFeedConfig
+}
+
+// Validate returns an error if the configuration is invalid
+func (c QuoteConfig) Validate() error {
+	if c.Currency == "" {
+		if c.SkyPrice != "" || len(c.Feeds.Feeds) != 0 {
+			return errors.New("Quote currency is required for a SKY price or price feeds")
+		}
+		return nil
+	}
+
+	if c.SkyPrice != "" {
+		if _, err := ParseRate(c.SkyPrice); err != nil {
+			return fmt.Errorf("Invalid SKY price: %v", err)
+		}
+	}
+
+	if err := c.Feeds.validate(scanner.CoinTypeBTC, scanner.CoinTypeETH, CurrencySKY); err != nil {
+		return err
+	}
+
+	var hasSky, hasCoin bool
+	for _, f := range c.Feeds.Feeds {
+		if f.CoinType == CurrencySKY {
+			hasSky = true
+		} else {
+			hasCoin = true
+		}
+	}
+
+	if !hasCoin {
+		return fmt.Errorf("A BTC or ETH price feed in %s is required", c.Currency)
+	}
+
+	if !hasSky && c.SkyPrice == "" {
+		return fmt.Errorf("A SKY price or SKY price feed in %s is required", c.Currency)
+	}
+
+	return nil
+}
+
+// ConversionQuote records the two legs of a deposit's conversion through the quote currency.
+// The deposit's ConversionRate is CoinPrice / SkyPrice.
+type ConversionQuote struct {
+	Currency string `json:"currency"`
+	// CoinPrice is the price of the deposit's coin type in Currency
+	CoinPrice       string `json:"coin_price"`
+	CoinPriceSource string `json:"coin_price_source"`
+	// SkyPrice is the price of SKY in Currency
+	SkyPrice       string `json:"sky_price"`
+	SkyPriceSource string `json:"sky_price_source"`
+}
+
+// quoteFeeds prices the coin types and SKY in the quote currency
+type quoteFeeds struct {
+	cfg   QuoteConfig
+	feeds *rateFeeds
+}
+
+// newQuoteFeeds creates the quoteFeeds. It returns nil if the quote currency is disabled.
+func newQuoteFeeds(log logrus.FieldLogger, cfg QuoteConfig) (*quoteFeeds, error) {
+	if cfg.Currency == "" {
+		return nil, nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Only SKY has a configured price, a coin type has no price until its feeds agree
+	feeds := newFeeds(log.WithField("currency", cfg.Currency), cfg.Feeds, map[string]string{
+		CurrencySKY: cfg.SkyPrice,
+	})
+	feeds.log = feeds.log.WithField("prefix", "teller.exchange.quotefeeds")
+
+	return &quoteFeeds{
+		cfg:   cfg,
+		feeds: feeds,
+	}, nil
+}
+
+// quote returns the SKY per coin rate of coinType at now and its conversion quote.
+// It returns false if the coin type or SKY has no price.
+func (q *quoteFeeds) quote(coinType string, now time.Time) (string, ConversionQuote, bool) {
+	coinPrice, coinSource := q.feeds.rate(coinType, now)
+	if coinPrice == "" {
+		return "", ConversionQuote{}, false
+	}
+
+	skyPrice, skySource := q.feeds.rate(CurrencySKY, now)
+	if skyPrice == "" {
+		return "", ConversionQuote{}, false
+	}
+
+	coin, err := ParseRate(coinPrice)
+	if err != nil {
+		return "", ConversionQuote{}, false
+	}
+
+	sky, err := ParseRate(skyPrice)
+	if err != nil {
+		return "", ConversionQuote{}, false
+	}
+
+	rate := coin.Div(sky).Round(rateFeedDecimals)
+	if rate.LessThanOrEqual(decimal.New(0, 0)) {
+		return "", ConversionQuote{}, false
+	}
+
+	return rate.String(), ConversionQuote{
+		Currency:        q.cfg.Currency,
+		CoinPrice:       coinPrice,
+		CoinPriceSource: coinSource,
+		SkyPrice:        skyPrice,
+		SkyPriceSource:  skySource,
+	}, true
+}
+
+// statuses returns the price of each coin type and SKY with feeds at now, and the health of its feeds
+func (q *quoteFeeds) statuses(now time.Time) []RateFeedStatus {
+	if q == nil {
+		return nil
+	}
+
+	statuses := q.feeds.statuses(now)
+	for i := range statuses {
+		statuses[i].Currency = q.cfg.Currency
+	}
+	return statuses
+}
@@ -0,0 +1,205 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestQuoteConfigValidate(t *testing.T) {
+	feed := func(name, coinType string) RateFeed {
+		return RateFeed{
+			Name:     name,
+			CoinType: coinType,
+			URL:      "https://example.com/" + name,
+		}
+	}
+
+	require.NoError(t, QuoteConfig{}.Validate())
+	require.NoError(t, QuoteConfig{
+		Currency: "USD",
+		SkyPrice: "0.5",
+		Feeds: RateFeedConfig{
+			Feeds: []RateFeed{feed("a", scanner.CoinTypeBTC)},
+		},
+	}.Validate())
+	require.NoError(t, QuoteConfig{
+		Currency: "USD",
+		Feeds: RateFeedConfig{
+			Feeds: []RateFeed{feed("a", scanner.CoinTypeBTC), feed("b", CurrencySKY)},
+		},
+	}.Validate())
+
+	for _, cfg := range []QuoteConfig{
+		{SkyPrice: "0.5"},
+		{Feeds: RateFeedConfig{Feeds: []RateFeed{feed("a", scanner.CoinTypeBTC)}}},
+		{Currency: "USD", SkyPrice: "0.5"},
+		{Currency: "USD", Feeds: RateFeedConfig{Feeds: []RateFeed{feed("a", scanner.CoinTypeBTC)}}},
+		{Currency: "USD", SkyPrice: "0", Feeds: RateFeedConfig{Feeds: []RateFeed{feed("a", scanner.CoinTypeBTC)}}},
+		{Currency: "USD", SkyPrice: "0.5", Feeds: RateFeedConfig{Feeds: []RateFeed{feed("a", "XRP")}}},
+	} {
+		require.Error(t, cfg.Validate(), "%+v", cfg)
+	}
+}
+
+func newTestQuoteFeeds(t *testing.T, cfg QuoteConfig) *quoteFeeds {
+	log, _ := testutil.NewLogger(t)
+	q, err := newQuoteFeeds(log, cfg)
+	require.NoError(t, err)
+	require.NotNil(t, q)
+	return q
+}
+
+func TestQuoteFeedsQuote(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	q, err := newQuoteFeeds(log, QuoteConfig{})
+	require.NoError(t, err)
+	require.Nil(t, q)
+	require.Nil(t, q.statuses(time.Now()))
+
+	q = newTestQuoteFeeds(t, QuoteConfig{
+		Currency: "USD",
+		SkyPrice: "2",
+		Feeds: RateFeedConfig{
+			Feeds: []RateFeed{
+				{Name: "btc", CoinType: scanner.CoinTypeBTC, URL: "https://example.com/btc"},
+				{Name: "sky", CoinType: CurrencySKY, URL: "https://example.com/sky"},
+			},
+		},
+	})
+
+	price := func(feed RateFeed, p string) rateQuote {
+		d, err := ParseRate(p)
+		require.NoError(t, err)
+		return rateQuote{feed: feed, quote: d}
+	}
+
+	now := time.Now()
+
+	// The coin has no price until its feeds agree
+	_, _, ok := q.quote(scanner.CoinTypeBTC, now)
+	require.False(t, ok)
+
+	q.feeds.update([]rateQuote{
+		price(q.cfg.Feeds.Feeds[0], "10000"),
+		{feed: q.cfg.Feeds.Feeds[1], err: fmt.Errorf("timeout")},
+	}, now)
+
+	// SKY is priced at SkyPrice until its feeds agree
+	rate, quote, ok := q.quote(scanner.CoinTypeBTC, now)
+	require.True(t, ok)
+	require.Equal(t, "5000", rate)
+	require.Equal(t, ConversionQuote{
+		Currency:        "USD",
+		CoinPrice:       "10000",
+		CoinPriceSource: RateSourceQuorum,
+		SkyPrice:        "2",
+		SkyPriceSource:  RateSourceConfigured,
+	}, quote)
+
+	q.feeds.update([]rateQuote{
+		price(q.cfg.Feeds.Feeds[0], "10000"),
+		price(q.cfg.Feeds.Feeds[1], "3"),
+	}, now)
+
+	rate, quote, ok = q.quote(scanner.CoinTypeBTC, now)
+	require.True(t, ok)
+	require.Equal(t, "3333.33333333", rate)
+	require.Equal(t, "3", quote.SkyPrice)
+	require.Equal(t, RateSourceQuorum, quote.SkyPriceSource)
+
+	// ETH has no feeds
+	_, _, ok = q.quote(scanner.CoinTypeETH, now)
+	require.False(t, ok)
+
+	// The coin's price is unavailable once it is stale
+	_, _, ok = q.quote(scanner.CoinTypeBTC, now.Add(defaultRateFeedMaxStaleness+time.Minute))
+	require.False(t, ok)
+
+	statuses := q.statuses(now)
+	require.Len(t, statuses, 2)
+	for _, s := range statuses {
+		require.Equal(t, "USD", s.Currency)
+	}
+	require.Equal(t, scanner.CoinTypeBTC, statuses[0].CoinType)
+	require.Equal(t, "10000", statuses[0].Rate)
+	require.Equal(t, CurrencySKY, statuses[1].CoinType)
+	require.Equal(t, "3", statuses[1].Rate)
+}
+
+func TestExchangeGetRateFromQuote(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"price": "12000"}`)
+	}))
+	defer srv.Close()
+
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+	e.cfg.Quote = QuoteConfig{
+		Currency: "USD",
+		SkyPrice: "1.5",
+		Feeds: RateFeedConfig{
+			Feeds: []RateFeed{
+				{Name: "a", CoinType: scanner.CoinTypeBTC, URL: srv.URL, Field: "price"},
+			},
+		},
+	}
+	e.quotes = newTestQuoteFeeds(t, e.cfg.Quote)
+
+	// The configured rate is used until the BTC price is known
+	rate, quote, err := e.getRate(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, testSkyBtcRate, rate)
+	require.Nil(t, quote)
+
+	e.quotes.feeds.poll(time.Now())
+
+	rate, err = e.CurrentRate(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "8000", rate)
+
+	// Both legs of the conversion are saved with the deposit
+	require.NoError(t, e.store.BindAddress(testSkyAddr, "btcaddr", scanner.CoinTypeBTC, ""))
+	di, err := e.saveIncomingDeposit(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr",
+		Amount:   1e8,
+		Tx:       "btx",
+		Final:    true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "8000", di.ConversionRate)
+	require.Equal(t, &ConversionQuote{
+		Currency:        "USD",
+		CoinPrice:       "12000",
+		CoinPriceSource: RateSourceQuorum,
+		SkyPrice:        "1.5",
+		SkyPriceSource:  RateSourceConfigured,
+	}, di.Quote)
+
+	detail, err := e.GetDepositStatusDetail(func(DepositInfo) bool { return true })
+	require.NoError(t, err)
+	require.Len(t, detail, 1)
+	require.Equal(t, di.Quote, detail[0].Quote)
+
+	// The rate is recorded in the rate history
+	history, err := e.GetRateHistory(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "8000", history[len(history)-1].Rate)
+
+	statuses := e.RateFeedStatuses()
+	require.Len(t, statuses, 1)
+	require.Equal(t, "USD", statuses[0].Currency)
+	require.Equal(t, "12000", statuses[0].Rate)
+}
@@ -0,0 +1,538 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	defaultRateFeedPollInterval = time.Minute
+	defaultRateFeedMaxStaleness = time.Minute * 10
+	rateFeedTimeout             = time.Second * 10
+	// rateFeedResponseLimit bounds how much of a feed's response is read
+	rateFeedResponseLimit = 64 * 1024
+	// rateFeedDecimals is the precision the agreed rates are rounded to
+	rateFeedDecimals = 8
+)
+
+const (
+	// RateSourceQuorum is the source of a rate the feeds agreed on in their last poll
+	RateSourceQuorum = "quorum"
+	// RateSourceLastKnownGood is the source of the last agreed rate, while the feeds don't agree and it isn't stale
+	RateSourceLastKnownGood = "last_known_good"
+	// RateSourceConfigured is the source of the configured rate, used until the feeds agree and once their rate is stale
+	RateSourceConfigured = "configured"
+)
+
+// RateFeedConfig configures the rate feeds. The feeds are disabled if there are no Feeds.
+type RateFeedConfig struct {
+	Feeds []RateFeed
+	// Number of a coin type's feeds whose quotes must agree. Defaults to a majority of the coin type's feeds.
+	Quorum int
+	// Max percent a quote may deviate from the median quote before it is rejected as an outlier. 0 rejects no quotes.
+	MaxDeviation float64
+	// How long the last agreed rate is used while the feeds don't agree, before falling back to the configured rate
+	MaxStaleness time.Duration
+	// How often the feeds are polled
+	PollInterval time.Duration
+}
+
+// RateFeed is a price source which quotes the SKY rate of a coin type
+type RateFeed struct {
+	Name     string
+	CoinType string
+	URL      string
+	// Field is the dot separated path of the quote in a JSON response, e.g. "data.price".
+	// If empty, the response is the quote, as a plain number.
+	Field string
+	// Invert is set if the feed quotes the coin per SKY, rather than SKY per coin
+	Invert bool
+}
+
+// Validate returns an error if the configuration is invalid
+func (c RateFeedConfig) Validate() error {
+	return c.validate(scanner.CoinTypeBTC, scanner.CoinTypeETH)
+}
+
+// validate returns an error if the configuration is invalid, or has feeds of coin types other than coinTypes
+func (c RateFeedConfig) validate(coinTypes ...string) error {
+	if c.Quorum < 0 {
+		return errors.New("Rate feed quorum can't be negative")
+	}
+
+	if c.MaxDeviation < 0 {
+		return errors.New("Rate feed max deviation can't be negative")
+	}
+
+	names := make(map[string]struct{}, len(c.Feeds))
+	counts := make(map[string]int)
+	for _, f := range c.Feeds {
+		if f.Name == "" {
+			return errors.New("Rate feed name missing")
+		}
+		if _, ok := names[f.Name]; ok {
+			return fmt.Errorf("Duplicate rate feed %q", f.Name)
+		}
+		names[f.Name] = struct{}{}
+
+		supported := false
+		for _, coinType := range coinTypes {
+			if f.CoinType == coinType {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("Rate feed %q: %v", f.Name, scanner.ErrUnsupportedCoinType)
+		}
+		counts[f.CoinType]++
+
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return fmt.Errorf("Rate feed %q url invalid: %v", f.Name, err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("Rate feed %q url must be an absolute http or https URL", f.Name)
+		}
+	}
+
+	for coinType, n := range counts {
+		if c.Quorum > n {
+			return fmt.Errorf("Rate feed quorum %d is more than the %d %s feeds", c.Quorum, n, coinType)
+		}
+	}
+
+	return nil
+}
+
+// quorumSize returns the number of a coin type's n feeds which must agree
+func (c RateFeedConfig) quorumSize(n int) int {
+	if c.Quorum > 0 {
+		return c.Quorum
+	}
+	return n/2 + 1
+}
+
+// RateFeedHealth is the result of a feed's last poll
+type RateFeedHealth struct {
+	Name string `json:"name"`
+	// Healthy is true if the feed responded to the last poll with a quote which wasn't an outlier
+	Healthy bool `json:"healthy"`
+	// Quote is the SKY per coin rate the feed last quoted
+	Quote string `json:"quote,omitempty"`
+	// Outlier is true if the last quote was rejected for deviating from the median quote
+	Outlier bool `json:"outlier"`
+	// Error of the last poll, if it failed
+	Error string `json:"error,omitempty"`
+	// Failures is the number of consecutive polls which failed
+	Failures      int        `json:"failures"`
+	CheckedAt     *time.Time `json:"checked_at"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+}
+
+// RateFeedStatus is the rate of a coin type, and the health of its feeds
+type RateFeedStatus struct {
+	CoinType string `json:"coin_type"`
+	// Currency is the quote currency of the price feeds of a QuoteConfig, empty for the rate feeds
+	Currency string `json:"currency,omitempty"`
+	// Rate is the SKY per coin rate deposits are converted at, or the price of the coin in Currency
+	Rate string `json:"rate"`
+	// Source of the rate, RateSourceQuorum, RateSourceLastKnownGood or RateSourceConfigured
+	Source string `json:"source"`
+	// AgreedAt is when the feeds last agreed on a rate, nil if they never have
+	AgreedAt *time.Time       `json:"agreed_at"`
+	Feeds    []RateFeedHealth `json:"feeds"`
+}
+
+type agreedRate struct {
+	rate decimal.Decimal
+	at   time.Time
+	// current is false if the feeds didn't agree in the polls since
+	current bool
+}
+
+// rateFeeds polls several price sources of each coin type concurrently, and agrees on the median of their quotes,
+// after rejecting the outliers. The last agreed rate is used while the feeds fail, until it is older than MaxStaleness.
+type rateFeeds struct {
+	sync.RWMutex
+	log        logrus.FieldLogger
+	cfg        RateFeedConfig
+	client     *http.Client
+	configured map[string]string
+	health     map[string]*RateFeedHealth
+	agreed     map[string]agreedRate
+	// stale is set for the coin types which fell back to their configured rate, so that it is alerted once
+	stale map[string]bool
+}
+
+// newRateFeeds creates the rateFeeds. It returns nil if there are no feeds.
+// configured are the rates of each coin type from the config, used until the feeds agree.
+func newRateFeeds(log logrus.FieldLogger, cfg RateFeedConfig, configured map[string]string) (*rateFeeds, error) {
+	if len(cfg.Feeds) == 0 {
+		return nil, nil
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return newFeeds(log, cfg, configured), nil
+}
+
+// newFeeds creates the rateFeeds of a validated config
+func newFeeds(log logrus.FieldLogger, cfg RateFeedConfig, configured map[string]string) *rateFeeds {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultRateFeedPollInterval
+	}
+
+	if cfg.MaxStaleness <= 0 {
+		cfg.MaxStaleness = defaultRateFeedMaxStaleness
+	}
+
+	health := make(map[string]*RateFeedHealth, len(cfg.Feeds))
+	for _, f := range cfg.Feeds {
+		health[f.Name] = &RateFeedHealth{
+			Name: f.Name,
+		}
+	}
+
+	return &rateFeeds{
+		log:        log.WithField("prefix", "teller.exchange.ratefeeds"),
+		cfg:        cfg,
+		client:     httpclient.New(rateFeedTimeout),
+		configured: configured,
+		health:     health,
+		agreed:     make(map[string]agreedRate),
+		stale:      make(map[string]bool),
+	}
+}
+
+// run polls the feeds at startup, then every PollInterval until quit is closed
+func (f *rateFeeds) run(quit <-chan struct{}) {
+	ticker := time.NewTicker(f.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		f.poll(time.Now())
+
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type rateQuote struct {
+	feed  RateFeed
+	quote decimal.Decimal
+	err   error
+}
+
+// poll queries all the feeds concurrently, then agrees on a rate for each coin type
+func (f *rateFeeds) poll(now time.Time) {
+	quotes := make([]rateQuote, len(f.cfg.Feeds))
+
+	var wg sync.WaitGroup
+	for i, feed := range f.cfg.Feeds {
+		wg.Add(1)
+		go func(i int, feed RateFeed) {
+			defer wg.Done()
+			q, err := f.fetch(feed)
+			quotes[i] = rateQuote{
+				feed:  feed,
+				quote: q,
+				err:   err,
+			}
+		}(i, feed)
+	}
+	wg.Wait()
+
+	f.update(quotes, now)
+}
+
+// update records the health of each feed and the rate the quotes of each coin type agree on
+func (f *rateFeeds) update(quotes []rateQuote, now time.Time) {
+	f.Lock()
+	defer f.Unlock()
+
+	byCoinType := make(map[string][]rateQuote)
+	for _, q := range quotes {
+		h := f.health[q.feed.Name]
+		checkedAt := now
+		h.CheckedAt = &checkedAt
+		h.Outlier = false
+
+		if q.err != nil {
+			f.log.WithError(q.err).WithField("feed", q.feed.Name).Warn("Get rate quote failed")
+			h.Healthy = false
+			h.Error = q.err.Error()
+			h.Failures++
+			continue
+		}
+
+		h.Healthy = true
+		h.Quote = q.quote.String()
+		h.Error = ""
+		h.Failures = 0
+		h.LastSuccessAt = &checkedAt
+
+		byCoinType[q.feed.CoinType] = append(byCoinType[q.feed.CoinType], q)
+	}
+
+	feedCounts := make(map[string]int)
+	for _, feed := range f.cfg.Feeds {
+		feedCounts[feed.CoinType]++
+	}
+
+	for coinType, n := range feedCounts {
+		log := f.log.WithField("coinType", coinType)
+
+		accepted, outliers := rejectOutliers(byCoinType[coinType], f.cfg.MaxDeviation)
+		for _, q := range outliers {
+			h := f.health[q.feed.Name]
+			h.Healthy = false
+			h.Outlier = true
+			log.WithFields(logrus.Fields{
+				"feed":  q.feed.Name,
+				"quote": q.quote.String(),
+			}).Warn("Rejected outlier rate quote")
+		}
+
+		last := f.agreed[coinType]
+		if quorum := f.cfg.quorumSize(n); len(accepted) < quorum {
+			if last.current {
+				log.WithFields(logrus.Fields{
+					"agreed": len(accepted),
+					"quorum": quorum,
+				}).Error("Rate feeds have no quorum, using the last agreed rate")
+			}
+			last.current = false
+			f.agreed[coinType] = last
+			continue
+		}
+
+		rate := median(accepted).Round(rateFeedDecimals)
+		if !last.current || !last.rate.Equal(rate) {
+			log.WithField("rate", rate.String()).Info("Rate feeds agreed on a rate")
+		}
+		f.agreed[coinType] = agreedRate{
+			rate:    rate,
+			at:      now,
+			current: true,
+		}
+	}
+}
+
+// rejectOutliers splits the quotes into those within maxDeviation percent of their median, and the outliers
+func rejectOutliers(quotes []rateQuote, maxDeviation float64) (accepted, outliers []rateQuote) {
+	if len(quotes) == 0 || maxDeviation <= 0 {
+		return quotes, nil
+	}
+
+	m := median(quotes)
+	for _, q := range quotes {
+		if percentChange(m, q.quote) > maxDeviation {
+			outliers = append(outliers, q)
+		} else {
+			accepted = append(accepted, q)
+		}
+	}
+
+	return accepted, outliers
+}
+
+// median returns the median of the quotes, the mean of the middle two of an even number of quotes
+func median(quotes []rateQuote) decimal.Decimal {
+	rates := make([]decimal.Decimal, len(quotes))
+	for i, q := range quotes {
+		rates[i] = q.quote
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		return rates[i].LessThan(rates[j])
+	})
+
+	mid := len(rates) / 2
+	if len(rates)%2 == 1 {
+		return rates[mid]
+	}
+	return rates[mid-1].Add(rates[mid]).Div(decimal.New(2, 0))
+}
+
+// rate returns the rate of coinType at now, and its source. The configured rate is returned
+// until the feeds agree, and once their last agreed rate is older than MaxStaleness.
+func (f *rateFeeds) rate(coinType string, now time.Time) (string, string) {
+	configured := f.configured[coinType]
+
+	f.Lock()
+	defer f.Unlock()
+
+	a, ok := f.agreed[coinType]
+	if !ok {
+		return configured, RateSourceConfigured
+	}
+
+	if now.Sub(a.at) > f.cfg.MaxStaleness {
+		if !f.stale[coinType] {
+			f.log.WithFields(logrus.Fields{
+				"coinType":     coinType,
+				"agreedAt":     a.at,
+				"maxStaleness": f.cfg.MaxStaleness,
+				"rate":         configured,
+			}).WithField("alert", "rate_feeds_stale").Error("ALERT: the last agreed rate is stale, using the configured rate")
+		}
+		f.stale[coinType] = true
+		return configured, RateSourceConfigured
+	}
+
+	if f.stale[coinType] {
+		f.log.WithField("coinType", coinType).Info("Rate feeds agreed again, stopped using the configured rate")
+		f.stale[coinType] = false
+	}
+
+	if a.current {
+		return a.rate.String(), RateSourceQuorum
+	}
+	return a.rate.String(), RateSourceLastKnownGood
+}
+
+// statuses returns the rate of each coin type with feeds at now, and the health of its feeds, sorted by coin type and feed name
+func (f *rateFeeds) statuses(now time.Time) []RateFeedStatus {
+	if f == nil {
+		return nil
+	}
+
+	byCoinType := make(map[string]*RateFeedStatus)
+	var coinTypes []string
+	for _, feed := range f.cfg.Feeds {
+		if _, ok := byCoinType[feed.CoinType]; !ok {
+			rate, source := f.rate(feed.CoinType, now)
+			byCoinType[feed.CoinType] = &RateFeedStatus{
+				CoinType: feed.CoinType,
+				Rate:     rate,
+				Source:   source,
+				Feeds:    []RateFeedHealth{},
+			}
+			coinTypes = append(coinTypes, feed.CoinType)
+		}
+	}
+
+	f.RLock()
+	defer f.RUnlock()
+
+	for _, feed := range f.cfg.Feeds {
+		s := byCoinType[feed.CoinType]
+		s.Feeds = append(s.Feeds, *f.health[feed.Name])
+		if a, ok := f.agreed[feed.CoinType]; ok && s.AgreedAt == nil {
+			at := a.at
+			s.AgreedAt = &at
+		}
+	}
+
+	sort.Strings(coinTypes)
+	statuses := make([]RateFeedStatus, 0, len(coinTypes))
+	for _, coinType := range coinTypes {
+		s := byCoinType[coinType]
+		sort.Slice(s.Feeds, func(i, j int) bool {
+			return s.Feeds[i].Name < s.Feeds[j].Name
+		})
+		statuses = append(statuses, *s)
+	}
+
+	return statuses
+}
+
+// RateFeedStatuses returns the rate of each coin type with price feeds, and the health of its feeds,
+// followed by the prices of the quote currency's feeds. It returns nil if both are disabled.
+func (s *Exchange) RateFeedStatuses() []RateFeedStatus {
+	now := time.Now()
+	statuses := append(s.feeds.statuses(now), s.quotes.statuses(now)...)
+	return append(statuses, s.display.statuses(now)...)
+}
+
+// fetch returns the SKY per coin rate quoted by a feed
+func (f *rateFeeds) fetch(feed RateFeed) (decimal.Decimal, error) {
+	rsp, err := f.client.Get(feed.URL)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return decimal.Decimal{}, fmt.Errorf("Rate feed returned status %d", rsp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(rsp.Body, rateFeedResponseLimit))
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	q, err := parseQuote(b, feed.Field)
+	if err != nil {
+		return decimal.Decimal{}, err
+	}
+
+	if feed.Invert {
+		q = decimal.New(1, 0).Div(q)
+	}
+
+	return q, nil
+}
+
+// parseQuote parses a quote from a plain number, or from the field of a JSON response as a number or string
+func parseQuote(b []byte, field string) (decimal.Decimal, error) {
+	s := strings.TrimSpace(string(b))
+
+	if field != "" {
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return decimal.Decimal{}, err
+		}
+
+		for _, k := range strings.Split(field, ".") {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return decimal.Decimal{}, fmt.Errorf("Rate feed response has no field %q", field)
+			}
+			if v, ok = obj[k]; !ok {
+				return decimal.Decimal{}, fmt.Errorf("Rate feed response has no field %q", field)
+			}
+		}
+
+		switch x := v.(type) {
+		case json.Number:
+			s = x.String()
+		case string:
+			s = x
+		default:
+			return decimal.Decimal{}, fmt.Errorf("Rate feed field %q is not a number", field)
+		}
+	}
+
+	q, err := ParseRate(s)
+	if err != nil {
+		return decimal.Decimal{}, fmt.Errorf("Invalid rate quote: %v", err)
+	}
+
+	return q, nil
+}
@@ -0,0 +1,347 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestRateFeedConfigValidate(t *testing.T) {
+	feed := func(name, coinType string) RateFeed {
+		return RateFeed{
+			Name:     name,
+			CoinType: coinType,
+			URL:      "https://example.com/" + name,
+		}
+	}
+
+	require.NoError(t, RateFeedConfig{}.Validate())
+	require.NoError(t, RateFeedConfig{
+		Feeds:  []RateFeed{feed("a", scanner.CoinTypeBTC), feed("b", scanner.CoinTypeBTC), feed("c", scanner.CoinTypeETH)},
+		Quorum: 1,
+	}.Validate())
+
+	for _, cfg := range []RateFeedConfig{
+		{Quorum: -1},
+		{MaxDeviation: -1},
+		{Feeds: []RateFeed{feed("", scanner.CoinTypeBTC)}},
+		{Feeds: []RateFeed{feed("a", scanner.CoinTypeBTC), feed("a", scanner.CoinTypeETH)}},
+		{Feeds: []RateFeed{feed("a", "SKY")}},
+		{Feeds: []RateFeed{{Name: "a", CoinType: scanner.CoinTypeBTC, URL: "/price"}}},
+		{Feeds: []RateFeed{{Name: "a", CoinType: scanner.CoinTypeBTC, URL: "ftp://example.com"}}},
+		{Feeds: []RateFeed{feed("a", scanner.CoinTypeBTC), feed("b", scanner.CoinTypeETH)}, Quorum: 2},
+	} {
+		require.Error(t, cfg.Validate(), "%+v", cfg)
+	}
+}
+
+func TestParseQuote(t *testing.T) {
+	for _, tc := range []struct {
+		body  string
+		field string
+		quote string
+		err   bool
+	}{
+		{body: "500\n", quote: "500"},
+		{body: "512.25", quote: "512.25"},
+		{body: `{"price": 500.5}`, field: "price", quote: "500.5"},
+		{body: `{"data": {"amount": "0.0002"}}`, field: "data.amount", quote: "0.0002"},
+		{body: "0", err: true},
+		{body: "-5", err: true},
+		{body: "foo", err: true},
+		{body: `{"price": 500}`, err: true},
+		{body: `{"price": 500}`, field: "amount", err: true},
+		{body: `{"data": 500}`, field: "data.amount", err: true},
+		{body: `{"price": true}`, field: "price", err: true},
+		{body: `{"price"`, field: "price", err: true},
+	} {
+		q, err := parseQuote([]byte(tc.body), tc.field)
+		if tc.err {
+			require.Error(t, err, tc.body)
+			continue
+		}
+		require.NoError(t, err, tc.body)
+		require.Equal(t, tc.quote, q.String(), tc.body)
+	}
+}
+
+func newTestRateFeeds(t *testing.T, cfg RateFeedConfig) *rateFeeds {
+	log, _ := testutil.NewLogger(t)
+	f, err := newRateFeeds(log, cfg, map[string]string{
+		scanner.CoinTypeBTC: "500",
+		scanner.CoinTypeETH: "50",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, f)
+	return f
+}
+
+func TestNewRateFeedsDisabled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	f, err := newRateFeeds(log, RateFeedConfig{}, nil)
+	require.NoError(t, err)
+	require.Nil(t, f)
+	require.Nil(t, f.statuses(time.Now()))
+
+	_, err = newRateFeeds(log, RateFeedConfig{
+		Feeds: []RateFeed{{Name: "a", CoinType: "SKY", URL: "https://example.com"}},
+	}, nil)
+	require.Error(t, err)
+}
+
+func TestRateFeedsPoll(t *testing.T) {
+	quotes := map[string]string{
+		"/a": "600",
+		"/b": "610",
+		"/c": "605",
+		"/d": "0.002",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, ok := quotes[r.URL.Path]
+		if !ok {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, q)
+	}))
+	defer srv.Close()
+
+	f := newTestRateFeeds(t, RateFeedConfig{
+		Feeds: []RateFeed{
+			{Name: "a", CoinType: scanner.CoinTypeBTC, URL: srv.URL + "/a"},
+			{Name: "b", CoinType: scanner.CoinTypeBTC, URL: srv.URL + "/b"},
+			{Name: "c", CoinType: scanner.CoinTypeBTC, URL: srv.URL + "/c"},
+			{Name: "d", CoinType: scanner.CoinTypeETH, URL: srv.URL + "/d", Invert: true},
+			{Name: "e", CoinType: scanner.CoinTypeETH, URL: srv.URL + "/e"},
+		},
+		Quorum:       1,
+		MaxDeviation: 5,
+		MaxStaleness: time.Minute,
+	})
+
+	now := time.Now()
+
+	// The configured rate is used until the feeds have agreed
+	rate, source := f.rate(scanner.CoinTypeBTC, now)
+	require.Equal(t, "500", rate)
+	require.Equal(t, RateSourceConfigured, source)
+
+	f.poll(now)
+
+	rate, source = f.rate(scanner.CoinTypeBTC, now)
+	require.Equal(t, "605", rate)
+	require.Equal(t, RateSourceQuorum, source)
+
+	rate, source = f.rate(scanner.CoinTypeETH, now)
+	require.Equal(t, "500", rate)
+	require.Equal(t, RateSourceQuorum, source)
+
+	statuses := f.statuses(now)
+	require.Len(t, statuses, 2)
+	require.Equal(t, scanner.CoinTypeBTC, statuses[0].CoinType)
+	require.Equal(t, scanner.CoinTypeETH, statuses[1].CoinType)
+
+	eth := statuses[1]
+	require.Equal(t, "500", eth.Rate)
+	require.Equal(t, RateSourceQuorum, eth.Source)
+	require.NotNil(t, eth.AgreedAt)
+	require.Len(t, eth.Feeds, 2)
+	require.True(t, eth.Feeds[0].Healthy)
+	require.Equal(t, "500", eth.Feeds[0].Quote)
+	require.False(t, eth.Feeds[1].Healthy)
+	require.Equal(t, 1, eth.Feeds[1].Failures)
+	require.Contains(t, eth.Feeds[1].Error, "503")
+	require.NotNil(t, eth.Feeds[1].CheckedAt)
+	require.Nil(t, eth.Feeds[1].LastSuccessAt)
+
+	// All the BTC feeds fail, so the last agreed rate is used until it is stale
+	delete(quotes, "/a")
+	delete(quotes, "/b")
+	delete(quotes, "/c")
+	f.poll(now.Add(time.Second * 30))
+
+	rate, source = f.rate(scanner.CoinTypeBTC, now.Add(time.Second*30))
+	require.Equal(t, "605", rate)
+	require.Equal(t, RateSourceLastKnownGood, source)
+
+	btc := f.statuses(now.Add(time.Second * 30))[0]
+	require.Equal(t, RateSourceLastKnownGood, btc.Source)
+	require.Equal(t, now, *btc.AgreedAt)
+	for _, h := range btc.Feeds {
+		require.False(t, h.Healthy)
+		require.Equal(t, now, *h.LastSuccessAt)
+	}
+
+	rate, source = f.rate(scanner.CoinTypeBTC, now.Add(time.Minute*2))
+	require.Equal(t, "500", rate)
+	require.Equal(t, RateSourceConfigured, source)
+
+	// The feeds recover
+	quotes["/a"] = "700"
+	quotes["/b"] = "702"
+	f.poll(now.Add(time.Minute * 2))
+
+	rate, source = f.rate(scanner.CoinTypeBTC, now.Add(time.Minute*2))
+	require.Equal(t, "701", rate)
+	require.Equal(t, RateSourceQuorum, source)
+}
+
+func TestRateFeedsOutliers(t *testing.T) {
+	f := newTestRateFeeds(t, RateFeedConfig{
+		Feeds: []RateFeed{
+			{Name: "a", CoinType: scanner.CoinTypeBTC, URL: "https://example.com/a"},
+			{Name: "b", CoinType: scanner.CoinTypeBTC, URL: "https://example.com/b"},
+			{Name: "c", CoinType: scanner.CoinTypeBTC, URL: "https://example.com/c"},
+			{Name: "d", CoinType: scanner.CoinTypeBTC, URL: "https://example.com/d"},
+		},
+		MaxDeviation: 10,
+	})
+
+	quote := func(name string, q string) rateQuote {
+		for _, feed := range f.cfg.Feeds {
+			if feed.Name == name {
+				d, err := ParseRate(q)
+				require.NoError(t, err)
+				return rateQuote{feed: feed, quote: d}
+			}
+		}
+		t.Fatalf("unknown feed %s", name)
+		return rateQuote{}
+	}
+
+	now := time.Now()
+
+	// The broken quote of d is rejected, and the rest agree on their median
+	f.update([]rateQuote{
+		quote("a", "500"),
+		quote("b", "510"),
+		quote("c", "505"),
+		quote("d", "5000"),
+	}, now)
+
+	rate, source := f.rate(scanner.CoinTypeBTC, now)
+	require.Equal(t, "505", rate)
+	require.Equal(t, RateSourceQuorum, source)
+
+	health := f.statuses(now)[0].Feeds
+	require.False(t, health[3].Healthy)
+	require.True(t, health[3].Outlier)
+	require.Equal(t, "5000", health[3].Quote)
+	require.Equal(t, 0, health[3].Failures)
+
+	// The median of an even number of quotes is the mean of the middle two
+	f.update([]rateQuote{
+		quote("a", "500"),
+		quote("b", "501"),
+		quote("c", "502"),
+		quote("d", "503"),
+	}, now.Add(time.Minute))
+
+	rate, _ = f.rate(scanner.CoinTypeBTC, now.Add(time.Minute))
+	require.Equal(t, "501.5", rate)
+	for _, h := range f.statuses(now.Add(time.Minute))[0].Feeds {
+		require.True(t, h.Healthy)
+		require.False(t, h.Outlier)
+	}
+
+	// Two feeds disagreeing isn't a majority of the four feeds
+	f.update([]rateQuote{
+		quote("a", "500"),
+		quote("b", "800"),
+		{feed: f.cfg.Feeds[2], err: fmt.Errorf("timeout")},
+		{feed: f.cfg.Feeds[3], err: fmt.Errorf("timeout")},
+	}, now.Add(time.Minute*2))
+
+	rate, source = f.rate(scanner.CoinTypeBTC, now.Add(time.Minute*2))
+	require.Equal(t, "501.5", rate)
+	require.Equal(t, RateSourceLastKnownGood, source)
+}
+
+func TestExchangeGetRateFromFeeds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"rate": "612.5"}`)
+	}))
+	defer srv.Close()
+
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+	feeds := newTestRateFeeds(t, RateFeedConfig{
+		Feeds: []RateFeed{
+			{Name: "a", CoinType: scanner.CoinTypeBTC, URL: srv.URL, Field: "rate"},
+		},
+	})
+	e.feeds = feeds
+
+	rate, _, err := e.getRate(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "500", rate)
+
+	feeds.poll(time.Now())
+
+	rate, _, err = e.getRate(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "612.5", rate)
+
+	// The agreed rate is recorded in the rate history
+	history, err := e.GetRateHistory(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "612.5", history[len(history)-1].Rate)
+
+	statuses := e.RateFeedStatuses()
+	require.Len(t, statuses, 1)
+	require.Equal(t, "612.5", statuses[0].Rate)
+}
+
+func TestExchangeCurrentRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"rate": "612.5"}`)
+	}))
+	defer srv.Close()
+
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+
+	rate, err := e.CurrentRate(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, e.cfg.BtcRate, rate)
+
+	rate, err = e.CurrentRate(scanner.CoinTypeETH)
+	require.NoError(t, err)
+	require.Equal(t, e.cfg.EthRate, rate)
+
+	_, err = e.CurrentRate("foo")
+	require.Equal(t, scanner.ErrUnsupportedCoinType, err)
+
+	feeds := newTestRateFeeds(t, RateFeedConfig{
+		Feeds: []RateFeed{
+			{Name: "a", CoinType: scanner.CoinTypeBTC, URL: srv.URL, Field: "rate"},
+		},
+	})
+	e.feeds = feeds
+	feeds.poll(time.Now())
+
+	history, err := e.GetRateHistory(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+
+	rate, err = e.CurrentRate(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "612.5", rate)
+
+	// Quoting the rate doesn't record it
+	after, err := e.GetRateHistory(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, history, after)
+}
@@ -0,0 +1,140 @@
+package exchange
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const rateGuardWindow = time.Minute
+
+// RateGuardConfig configures the RateGuard. A zero limit disables that check.
+type RateGuardConfig struct {
+	// Reference SKY per coin rates, keyed by coin type
+	ReferenceRates map[string]string
+	// Max percent a rate may deviate from its reference rate
+	MaxDeviation float64
+	// Max percent a rate may move within a minute
+	MaxChangePerMinute float64
+}
+
+// RateGuardErr is returned when a rate is refused by the RateGuard
+type RateGuardErr struct {
+	CoinType string
+	Rate     string
+	Reason   string
+}
+
+func (e RateGuardErr) Error() string {
+	return fmt.Sprintf("Rate %s for %s refused: %s", e.Rate, e.CoinType, e.Reason)
+}
+
+type rateObservation struct {
+	rate decimal.Decimal
+	t    time.Time
+}
+
+// RateGuard refuses conversions at rates which are far from a reference rate,
+// or which moved too fast, so that deposits aren't paid at a broken rate
+type RateGuard struct {
+	sync.Mutex
+	cfg          RateGuardConfig
+	refs         map[string]decimal.Decimal
+	observations map[string][]rateObservation
+}
+
+// NewRateGuard creates a RateGuard
+func NewRateGuard(cfg RateGuardConfig) (*RateGuard, error) {
+	if cfg.MaxDeviation < 0 || cfg.MaxChangePerMinute < 0 {
+		return nil, fmt.Errorf("Rate guard limits can't be negative")
+	}
+
+	refs := make(map[string]decimal.Decimal, len(cfg.ReferenceRates))
+	for coinType, rate := range cfg.ReferenceRates {
+		if rate == "" {
+			continue
+		}
+
+		r, err := ParseRate(rate)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s reference rate: %v", coinType, err)
+		}
+		refs[coinType] = r
+	}
+
+	return &RateGuard{
+		cfg:          cfg,
+		refs:         refs,
+		observations: make(map[string][]rateObservation),
+	}, nil
+}
+
+// Observe records a rate quoted at time t, for the rate of change check
+func (g *RateGuard) Observe(coinType string, rate decimal.Decimal, t time.Time) {
+	g.Lock()
+	defer g.Unlock()
+
+	obs := g.recentObservations(coinType, t)
+	g.observations[coinType] = append(obs, rateObservation{
+		rate: rate,
+		t:    t,
+	})
+}
+
+// recentObservations drops the observations older than the rate of change window
+func (g *RateGuard) recentObservations(coinType string, t time.Time) []rateObservation {
+	obs := g.observations[coinType]
+	i := 0
+	for i < len(obs) && t.Sub(obs[i].t) > rateGuardWindow {
+		i++
+	}
+	obs = obs[i:]
+	g.observations[coinType] = obs
+	return obs
+}
+
+// Check returns a RateGuardErr if rate deviates too far from the coin type's reference rate,
+// or if the rates observed within the last minute moved too much
+func (g *RateGuard) Check(coinType, rate string, t time.Time) error {
+	r, err := ParseRate(rate)
+	if err != nil {
+		return err
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	refuse := func(reason string, args ...interface{}) error {
+		return RateGuardErr{
+			CoinType: coinType,
+			Rate:     rate,
+			Reason:   fmt.Sprintf(reason, args...),
+		}
+	}
+
+	if ref, ok := g.refs[coinType]; ok && g.cfg.MaxDeviation > 0 {
+		deviation := percentChange(ref, r)
+		if deviation > g.cfg.MaxDeviation {
+			return refuse("deviates %.2f%% from the reference rate %s, max is %.2f%%", deviation, ref.String(), g.cfg.MaxDeviation)
+		}
+	}
+
+	if g.cfg.MaxChangePerMinute > 0 {
+		for _, o := range g.recentObservations(coinType, t) {
+			change := percentChange(o.rate, r)
+			if change > g.cfg.MaxChangePerMinute {
+				return refuse("moved %.2f%% from %s within a minute, max is %.2f%%", change, o.rate.String(), g.cfg.MaxChangePerMinute)
+			}
+		}
+	}
+
+	return nil
+}
+
+// percentChange returns the absolute change from a to b, as a percentage of a
+func percentChange(a, b decimal.Decimal) float64 {
+	pct, _ := b.Sub(a).Abs().Div(a).Mul(decimal.New(100, 0)).Float64()
+	return pct
+}
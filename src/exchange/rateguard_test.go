@@ -0,0 +1,104 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestNewRateGuard(t *testing.T) {
+	_, err := NewRateGuard(RateGuardConfig{
+		MaxDeviation: -1,
+	})
+	require.Error(t, err)
+
+	_, err = NewRateGuard(RateGuardConfig{
+		MaxChangePerMinute: -1,
+	})
+	require.Error(t, err)
+
+	_, err = NewRateGuard(RateGuardConfig{
+		ReferenceRates: map[string]string{
+			scanner.CoinTypeBTC: "foo",
+		},
+	})
+	require.Error(t, err)
+
+	_, err = NewRateGuard(RateGuardConfig{
+		ReferenceRates: map[string]string{
+			scanner.CoinTypeBTC: "100",
+			scanner.CoinTypeETH: "",
+		},
+	})
+	require.NoError(t, err)
+}
+
+func TestRateGuardDeviation(t *testing.T) {
+	g, err := NewRateGuard(RateGuardConfig{
+		ReferenceRates: map[string]string{
+			scanner.CoinTypeBTC: "100",
+		},
+		MaxDeviation: 10,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	for _, rate := range []string{"100", "90", "110", "105.5"} {
+		require.NoError(t, g.Check(scanner.CoinTypeBTC, rate, now), rate)
+	}
+
+	for _, rate := range []string{"89", "111", "1000", "1"} {
+		err := g.Check(scanner.CoinTypeBTC, rate, now)
+		require.Error(t, err, rate)
+		require.IsType(t, RateGuardErr{}, err)
+	}
+
+	// No reference rate for ETH
+	require.NoError(t, g.Check(scanner.CoinTypeETH, "1000", now))
+
+	// Invalid rates are refused
+	require.Error(t, g.Check(scanner.CoinTypeBTC, "foo", now))
+}
+
+func TestRateGuardChangePerMinute(t *testing.T) {
+	g, err := NewRateGuard(RateGuardConfig{
+		MaxChangePerMinute: 5,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	// No observations yet
+	require.NoError(t, g.Check(scanner.CoinTypeBTC, "1000", now))
+
+	g.Observe(scanner.CoinTypeBTC, decimal.New(100, 0), now)
+	require.NoError(t, g.Check(scanner.CoinTypeBTC, "104", now.Add(time.Second*30)))
+
+	err = g.Check(scanner.CoinTypeBTC, "106", now.Add(time.Second*30))
+	require.Error(t, err)
+	require.IsType(t, RateGuardErr{}, err)
+
+	// Other coin types are unaffected
+	require.NoError(t, g.Check(scanner.CoinTypeETH, "106", now.Add(time.Second*30)))
+
+	// The observation expires after a minute
+	require.NoError(t, g.Check(scanner.CoinTypeBTC, "106", now.Add(time.Minute*2)))
+}
+
+func TestRateGuardDisabled(t *testing.T) {
+	g, err := NewRateGuard(RateGuardConfig{
+		ReferenceRates: map[string]string{
+			scanner.CoinTypeBTC: "100",
+		},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	g.Observe(scanner.CoinTypeBTC, decimal.New(100, 0), now)
+	require.NoError(t, g.Check(scanner.CoinTypeBTC, "10000", now))
+}
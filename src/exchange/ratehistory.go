@@ -0,0 +1,125 @@
+package exchange
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// RateHistoryBkt maps a sequence number to a RateChange
+var RateHistoryBkt = []byte("rate_history")
+
+// RateChange records a conversion rate and the rules it was applied with, effective from EffectiveAt
+// until the next RateChange of the same coin type
+type RateChange struct {
+	Seq      uint64 `json:"seq"`
+	CoinType string `json:"coin_type"`
+	// SKY per coin, decimal string
+	Rate string `json:"rate"`
+	// Number of decimal places the sent SKY is rounded down to
+	MaxDecimals int   `json:"max_decimals"`
+	EffectiveAt int64 `json:"effective_at"`
+}
+
+// RecordRate records the rate and rules of a coin type in effect from time t, unless they are the latest ones recorded.
+// It returns true if a RateChange was recorded.
+func (s *Store) RecordRate(coinType, rate string, maxDecimals int, t time.Time) (bool, error) {
+	var recorded bool
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		var last *RateChange
+		if err := forEachRateChangeTx(tx, func(rc RateChange) error {
+			if rc.CoinType == coinType {
+				last = &rc
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if last != nil && last.Rate == rate && last.MaxDecimals == maxDecimals {
+			return nil
+		}
+
+		seq, err := dbutil.NextSequence(tx, RateHistoryBkt)
+		if err != nil {
+			return err
+		}
+
+		recorded = true
+		return dbutil.PutBucketValue(tx, RateHistoryBkt, ledgerSeqKey(seq), RateChange{
+			Seq:         seq,
+			CoinType:    coinType,
+			Rate:        rate,
+			MaxDecimals: maxDecimals,
+			EffectiveAt: t.UTC().Unix(),
+		})
+	}); err != nil {
+		return false, err
+	}
+
+	return recorded, nil
+}
+
+// GetRateHistory returns the rate changes of a coin type, oldest first. All coin types are returned if coinType is empty.
+func (s *Store) GetRateHistory(coinType string) ([]RateChange, error) {
+	var rcs []RateChange
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return forEachRateChangeTx(tx, func(rc RateChange) error {
+			if coinType == "" || rc.CoinType == coinType {
+				rcs = append(rcs, rc)
+			}
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return rcs, nil
+}
+
+func forEachRateChangeTx(tx *bolt.Tx, f func(RateChange) error) error {
+	return dbutil.ForEach(tx, RateHistoryBkt, func(k, v []byte) error {
+		var rc RateChange
+		if err := json.Unmarshal(v, &rc); err != nil {
+			return err
+		}
+		return f(rc)
+	})
+}
+
+// recordRates records the configured rates, which take effect when the exchange starts
+func (s *Exchange) recordRates() error {
+	now := time.Now()
+	rates := s.cfg.rates()
+	for _, coinType := range deposits.CoinTypes() {
+		rate := rates[coinType]
+		if rate == "" {
+			continue
+		}
+
+		recorded, err := s.store.RecordRate(coinType, rate, s.cfg.MaxDecimals, now)
+		if err != nil {
+			return err
+		}
+
+		if recorded {
+			s.log.WithFields(logrus.Fields{
+				"coinType":    coinType,
+				"rate":        rate,
+				"maxDecimals": s.cfg.MaxDecimals,
+			}).Info("Recorded rate change")
+		}
+	}
+
+	return nil
+}
+
+// GetRateHistory returns the rate changes of a coin type, oldest first. All coin types are returned if coinType is empty.
+func (s *Exchange) GetRateHistory(coinType string) ([]RateChange, error) {
+	return s.store.GetRateHistory(coinType)
+}
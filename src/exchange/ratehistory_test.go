@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestStoreRecordRate(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	t0 := time.Unix(1519905600, 0)
+
+	recorded, err := s.RecordRate(scanner.CoinTypeBTC, "100", 3, t0)
+	require.NoError(t, err)
+	require.True(t, recorded)
+
+	// Unchanged rate and rules
+	recorded, err = s.RecordRate(scanner.CoinTypeBTC, "100", 3, t0.Add(time.Hour))
+	require.NoError(t, err)
+	require.False(t, recorded)
+
+	// Other coin type
+	recorded, err = s.RecordRate(scanner.CoinTypeETH, "100", 3, t0.Add(time.Hour))
+	require.NoError(t, err)
+	require.True(t, recorded)
+
+	// Changed rules
+	recorded, err = s.RecordRate(scanner.CoinTypeBTC, "100", 2, t0.Add(time.Hour*2))
+	require.NoError(t, err)
+	require.True(t, recorded)
+
+	// Changed rate
+	recorded, err = s.RecordRate(scanner.CoinTypeBTC, "120", 2, t0.Add(time.Hour*3))
+	require.NoError(t, err)
+	require.True(t, recorded)
+
+	// Changed back to an earlier rate
+	recorded, err = s.RecordRate(scanner.CoinTypeBTC, "100", 2, t0.Add(time.Hour*4))
+	require.NoError(t, err)
+	require.True(t, recorded)
+
+	rcs, err := s.GetRateHistory(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, []RateChange{
+		{Seq: 1, CoinType: scanner.CoinTypeBTC, Rate: "100", MaxDecimals: 3, EffectiveAt: t0.Unix()},
+		{Seq: 3, CoinType: scanner.CoinTypeBTC, Rate: "100", MaxDecimals: 2, EffectiveAt: t0.Add(time.Hour * 2).Unix()},
+		{Seq: 4, CoinType: scanner.CoinTypeBTC, Rate: "120", MaxDecimals: 2, EffectiveAt: t0.Add(time.Hour * 3).Unix()},
+		{Seq: 5, CoinType: scanner.CoinTypeBTC, Rate: "100", MaxDecimals: 2, EffectiveAt: t0.Add(time.Hour * 4).Unix()},
+	}, rcs)
+
+	rcs, err = s.GetRateHistory("")
+	require.NoError(t, err)
+	require.Len(t, rcs, 5)
+	require.Equal(t, scanner.CoinTypeETH, rcs[1].CoinType)
+}
+
+func TestExchangeRecordRates(t *testing.T) {
+	e, shutdown, _ := runExchange(t)
+	defer shutdown()
+	defer e.Shutdown()
+
+	// The configured rates are recorded when the exchange starts
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-time.After(dbCheckWaitTime):
+				rcs, err := e.GetRateHistory("")
+				require.NoError(t, err)
+				if len(rcs) != 0 {
+					return
+				}
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(dbScanTimeout):
+		t.Fatal("Waiting for rate history timed out")
+	}
+
+	rcs, err := e.GetRateHistory(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Len(t, rcs, 1)
+	require.Equal(t, testSkyBtcRate, rcs[0].Rate)
+	require.NotEmpty(t, rcs[0].EffectiveAt)
+
+	closeMultiplexer(e)
+}
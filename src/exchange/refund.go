@@ -0,0 +1,65 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+// ValidateRefundAddress returns an error if addr can't receive a refund of coinType on the coin's main network.
+// The refund address of a binding is where the operator sends back a deposit, or the part of it, which isn't converted.
+func ValidateRefundAddress(coinType, addr string) error {
+	switch coinType {
+	case scanner.CoinTypeBTC:
+		return validateBTCRefundAddress(addr)
+	case scanner.CoinTypeETH:
+		return validateETHRefundAddress(addr)
+	default:
+		return fmt.Errorf("Refund addresses of %s are not supported", coinType)
+	}
+}
+
+// validateBTCRefundAddress accepts P2PKH and P2SH addresses of the bitcoin main network
+func validateBTCRefundAddress(addr string) error {
+	a, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		return fmt.Errorf("Invalid BTC refund address: %v", err)
+	}
+
+	switch a.(type) {
+	case *btcutil.AddressPubKeyHash, *btcutil.AddressScriptHash:
+	default:
+		return errors.New("Invalid BTC refund address: must be a P2PKH or P2SH address")
+	}
+
+	if !a.IsForNet(&chaincfg.MainNetParams) {
+		return errors.New("Invalid BTC refund address: not a mainnet address")
+	}
+
+	return nil
+}
+
+// validateETHRefundAddress accepts 0x prefixed hex addresses, which must have a valid EIP-55 checksum if they are mixed case
+func validateETHRefundAddress(addr string) error {
+	if !strings.HasPrefix(addr, "0x") || !common.IsHexAddress(addr) {
+		return errors.New("Invalid ETH refund address: must be 0x followed by 40 hex digits")
+	}
+
+	a := common.HexToAddress(addr)
+	if a == (common.Address{}) {
+		return errors.New("Invalid ETH refund address: the zero address can't receive a refund")
+	}
+
+	digits := addr[2:]
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) && a.Hex() != addr {
+		return errors.New("Invalid ETH refund address: checksum mismatch")
+	}
+
+	return nil
+}
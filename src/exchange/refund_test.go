@@ -0,0 +1,91 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+const (
+	testBtcRefundAddr = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+	testEthRefundAddr = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+)
+
+func TestValidateRefundAddress(t *testing.T) {
+	cases := []struct {
+		name     string
+		coinType string
+		addr     string
+		valid    bool
+	}{
+		{"btc p2pkh", scanner.CoinTypeBTC, testBtcRefundAddr, true},
+		{"btc p2sh", scanner.CoinTypeBTC, "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", true},
+		{"btc testnet", scanner.CoinTypeBTC, "mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn", false},
+		{"btc bad checksum", scanner.CoinTypeBTC, "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN3", false},
+		{"btc pubkey", scanner.CoinTypeBTC, "02192d74d0cb94344c9569c2e77901573d8d7903c3ebec3a957724895dca52c6b4", false},
+		{"btc eth address", scanner.CoinTypeBTC, testEthRefundAddr, false},
+		{"eth checksummed", scanner.CoinTypeETH, testEthRefundAddr, true},
+		{"eth lower case", scanner.CoinTypeETH, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", true},
+		{"eth upper case", scanner.CoinTypeETH, "0x5AAEB6053F3E94C9B9A09F33669435E7EF1BEAED", true},
+		{"eth bad checksum", scanner.CoinTypeETH, "0x5aaeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{"eth no prefix", scanner.CoinTypeETH, "5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", false},
+		{"eth short", scanner.CoinTypeETH, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA", false},
+		{"eth zero", scanner.CoinTypeETH, "0x0000000000000000000000000000000000000000", false},
+		{"eth btc address", scanner.CoinTypeETH, testBtcRefundAddr, false},
+		{"unknown coin type", "SKY", testBtcRefundAddr, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRefundAddress(tc.coinType, tc.addr)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestStoreRefundAddress(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, testBtcRefundAddr))
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr2", scanner.CoinTypeBTC, ""))
+
+	deposit := func(addr, tx string) DepositInfo {
+		di, err := s.GetOrCreateDepositInfo(deposits.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  addr,
+			Amount:   1e6,
+			Tx:       tx,
+			N:        1,
+			Final:    true,
+		}, testSkyBtcRate)
+		require.NoError(t, err)
+		return di
+	}
+
+	// The refund address is copied to the deposits of the binding
+	require.Equal(t, testBtcRefundAddr, deposit("btcaddr1", "btx1").RefundAddress)
+	require.Empty(t, deposit("btcaddr2", "btx2").RefundAddress)
+
+	// A cancelled binding keeps its refund address, and a deposit which restores it gets the refund address
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr3", scanner.CoinTypeBTC, testBtcRefundAddr))
+	cb, err := s.CancelBinding(testSkyAddr, "btcaddr3", scanner.CoinTypeBTC, 0, false, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, testBtcRefundAddr, cb.RefundAddress)
+	require.Equal(t, testBtcRefundAddr, deposit("btcaddr3", "btx3").RefundAddress)
+
+	// A reused deposit address doesn't keep its last refund address
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr4", scanner.CoinTypeBTC, testBtcRefundAddr))
+	_, err = s.CancelBinding(testSkyAddr, "btcaddr4", scanner.CoinTypeBTC, 0, true, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, s.BindAddress(testSkyAddr2, "btcaddr4", scanner.CoinTypeBTC, ""))
+	require.Empty(t, deposit("btcaddr4", "btx4").RefundAddress)
+}
@@ -0,0 +1,259 @@
+package exchange
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// Run state markers.
+// Teller saves a run state marker in ExchangeMetaBkt when it starts, and replaces it with its shutdown report
+// as the last write before the db is closed. The marker is written in a bolt.Tx like the deposits, so a crash
+// can't leave it half written. A marker still RunStateRunning when teller starts means the last run never
+// shut down, e.g. it was killed, crashed or lost power, so teller runs the startup check before its services
+// start: it reconciles the ledger with the deposits, and checks the deposits which were in flight or updated
+// during the interrupted run. The deposits whose processing may have been interrupted are listed in the
+// startup report, which the admin panel serves at /api/startup_report, so an operator can check them.
+
+const (
+	// RunStateRunning is saved when teller starts
+	RunStateRunning = "running"
+	// RunStateStopped is saved when teller shuts down
+	RunStateStopped = "stopped"
+	// RunStateFailed is saved when teller shuts down because a service failed
+	RunStateFailed = "failed"
+
+	runStateKey = "run_state"
+)
+
+const (
+	// InterruptedSend is the reason of a deposit waiting to be sent which was updated during the interrupted run.
+	// It may have been mid-send, it is sent again, which its idempotency key keeps from paying it twice.
+	InterruptedSend = "send_interrupted"
+	// InterruptedBroadcast is the reason of a deposit whose saved skycoin transaction wasn't broadcast.
+	// The transaction is broadcast again.
+	InterruptedBroadcast = "broadcast_pending"
+	// InterruptedConfirm is the reason of a deposit waiting for its skycoin transaction's confirmation
+	// which was updated during the interrupted run
+	InterruptedConfirm = "confirm_pending"
+	// InterruptedInvalid is the reason of a deposit whose record is inconsistent with its status, see DepositInfo.ValidateForStatus
+	InterruptedInvalid = "invalid_state"
+)
+
+// RunState is the run state marker of a teller run
+type RunState struct {
+	State     string     `json:"state"`
+	StartedAt time.Time  `json:"started_at"`
+	StoppedAt *time.Time `json:"stopped_at"`
+	// Reason teller stopped, e.g. "signal" or "compact"
+	Reason string `json:"reason,omitempty"`
+	// Error of the service which failed, with RunStateFailed
+	Error   string `json:"error,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// InterruptedDeposit is a deposit whose processing may have been interrupted by an unclean shutdown
+type InterruptedDeposit struct {
+	DepositID string `json:"deposit_id"`
+	Status    string `json:"status"`
+	CoinType  string `json:"coin_type"`
+	UpdatedAt int64  `json:"updated_at"`
+	Txid      string `json:"txid,omitempty"`
+	// Reason is InterruptedSend, InterruptedBroadcast, InterruptedConfirm or InterruptedInvalid
+	Reason string `json:"reason"`
+	// Error is the deposit's error, or the consistency check's with InterruptedInvalid
+	Error string `json:"error,omitempty"`
+}
+
+// StartupReport reports how teller's last run stopped, and the startup check's findings if it didn't shut down cleanly
+type StartupReport struct {
+	StartedAt time.Time `json:"started_at"`
+	// Previous is the run state marker of the last run, nil if the db hadn't been run with the markers before
+	Previous *RunState `json:"previous"`
+	// Unclean is true if the last run never shut down, in which case the startup check ran
+	Unclean bool `json:"unclean"`
+	// LedgerError is the error of the ledger reconciliation, see Store.CheckLedger
+	LedgerError string `json:"ledger_error,omitempty"`
+	// Deposits whose processing may have been interrupted, in DepositID order
+	Deposits []InterruptedDeposit `json:"deposits"`
+}
+
+// getRunStateTx returns the run state marker. It returns nil if there is none.
+func getRunStateTx(tx *bolt.Tx) (*RunState, error) {
+	var rs RunState
+	if err := dbutil.GetBucketObject(tx, ExchangeMetaBkt, runStateKey, &rs); err != nil {
+		switch err.(type) {
+		case dbutil.ObjectNotExistErr:
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+	return &rs, nil
+}
+
+// StartRun saves the running marker of a run started at now, and returns the startup report.
+// If the last run didn't shut down, the startup check is run first.
+func (s *Store) StartRun(now time.Time, version string) (StartupReport, error) {
+	var prev *RunState
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		prev, err = getRunStateTx(tx)
+		return err
+	}); err != nil {
+		return StartupReport{}, err
+	}
+
+	report := StartupReport{
+		StartedAt: now,
+		Previous:  prev,
+		Unclean:   prev != nil && prev.State == RunStateRunning,
+		Deposits:  []InterruptedDeposit{},
+	}
+
+	if report.Unclean {
+		log := s.log.WithField("startedAt", prev.StartedAt)
+		log.WithField("alert", "unclean_shutdown").Error("ALERT: teller did not shut down cleanly, checking the deposits")
+
+		if err := s.CheckLedger(); err != nil {
+			report.LedgerError = err.Error()
+			log.WithError(err).Error("Startup check: the ledger doesn't reconcile with the deposits")
+		}
+
+		deposits, err := s.CheckInterruptedDeposits(prev.StartedAt)
+		if err != nil {
+			return StartupReport{}, err
+		}
+		report.Deposits = deposits
+
+		for _, d := range deposits {
+			log.WithFields(logrus.Fields{
+				"depositID": d.DepositID,
+				"status":    d.Status,
+				"reason":    d.Reason,
+			}).Warn("Startup check: deposit processing may have been interrupted")
+		}
+
+		log.WithField("deposits", len(deposits)).Info("Startup check finished")
+	}
+
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		return dbutil.PutBucketValue(tx, ExchangeMetaBkt, runStateKey, RunState{
+			State:     RunStateRunning,
+			StartedAt: now,
+			Version:   version,
+		})
+	}); err != nil {
+		return StartupReport{}, err
+	}
+
+	s.startup = report
+
+	return report, nil
+}
+
+// StopRun saves the shutdown report of the run, as RunStateStopped, or RunStateFailed if runErr is not nil
+func (s *Store) StopRun(now time.Time, reason string, runErr error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		rs, err := getRunStateTx(tx)
+		if err != nil {
+			return err
+		}
+		if rs == nil {
+			rs = &RunState{}
+		}
+
+		rs.State = RunStateStopped
+		rs.StoppedAt = &now
+		rs.Reason = reason
+		rs.Error = ""
+		if runErr != nil {
+			rs.State = RunStateFailed
+			rs.Error = runErr.Error()
+		}
+
+		return dbutil.PutBucketValue(tx, ExchangeMetaBkt, runStateKey, rs)
+	})
+}
+
+// StartupReport returns the startup report of StartRun
+func (s *Store) StartupReport() StartupReport {
+	return s.startup
+}
+
+// CheckInterruptedDeposits returns the deposits whose processing may have been interrupted by a run started at since:
+// the deposits waiting to be sent or confirmed which were updated since, the deposits whose saved skycoin
+// transaction wasn't broadcast, and the deposits inconsistent with their status.
+func (s *Store) CheckInterruptedDeposits(since time.Time) ([]InterruptedDeposit, error) {
+	deposits := []InterruptedDeposit{}
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return forEachDepositInfoTx(tx, func(DepositInfo) bool { return true }, func(di DepositInfo) error {
+			reason, reasonErr, err := interruptedReasonTx(tx, di, since)
+			if err != nil {
+				return err
+			}
+			if reason == "" {
+				return nil
+			}
+
+			if reasonErr == "" {
+				reasonErr = di.Error
+			}
+
+			deposits = append(deposits, InterruptedDeposit{
+				DepositID: di.DepositID,
+				Status:    di.Status.String(),
+				CoinType:  di.CoinType,
+				UpdatedAt: di.UpdatedAt,
+				Txid:      di.Txid,
+				Reason:    reason,
+				Error:     reasonErr,
+			})
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return deposits, nil
+}
+
+// interruptedReasonTx returns the reason the deposit's processing may have been interrupted, and the error of
+// its consistency check. It returns an empty reason if it wasn't.
+func interruptedReasonTx(tx *bolt.Tx, di DepositInfo, since time.Time) (string, string, error) {
+	if err := di.ValidateForStatus(); err != nil {
+		return InterruptedInvalid, err.Error(), nil
+	}
+
+	updated := !time.Unix(di.UpdatedAt, 0).Before(since.Truncate(time.Second))
+
+	switch di.Status {
+	case StatusWaitSend:
+		if updated {
+			return InterruptedSend, "", nil
+		}
+
+	case StatusWaitConfirm:
+		var entry OutboxEntry
+		err := dbutil.GetBucketObject(tx, SendOutboxBkt, di.Txid, &entry)
+		switch err.(type) {
+		case nil:
+			if entry.BroadcastAt == 0 {
+				return InterruptedBroadcast, "", nil
+			}
+		case dbutil.ObjectNotExistErr:
+		default:
+			return "", "", err
+		}
+
+		if updated {
+			return InterruptedConfirm, "", nil
+		}
+	}
+
+	return "", "", nil
+}
@@ -0,0 +1,158 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/coin"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+func TestStoreRunState(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	// The first run has no marker to check
+	start := time.Now().Add(-time.Hour)
+	report, err := s.StartRun(start, "1.0.0")
+	require.NoError(t, err)
+	require.Nil(t, report.Previous)
+	require.False(t, report.Unclean)
+	require.Empty(t, report.Deposits)
+	require.Equal(t, report, s.StartupReport())
+
+	stop := start.Add(time.Minute)
+	require.NoError(t, s.StopRun(stop, "signal", nil))
+
+	// A clean shutdown is reported, without the startup check
+	report, err = s.StartRun(start.Add(2*time.Minute), "1.0.0")
+	require.NoError(t, err)
+	require.False(t, report.Unclean)
+	require.NotNil(t, report.Previous)
+	require.Equal(t, RunStateStopped, report.Previous.State)
+	require.Equal(t, "signal", report.Previous.Reason)
+	require.Equal(t, "1.0.0", report.Previous.Version)
+	require.True(t, stop.Equal(*report.Previous.StoppedAt))
+
+	require.NoError(t, s.StopRun(time.Now(), "error", errors.New("scanner failed")))
+	report, err = s.StartRun(time.Now(), "1.0.1")
+	require.NoError(t, err)
+	require.False(t, report.Unclean)
+	require.Equal(t, RunStateFailed, report.Previous.State)
+	require.Equal(t, "scanner failed", report.Previous.Error)
+
+	// The run isn't stopped, as if teller crashed
+	report, err = s.StartRun(time.Now(), "1.0.1")
+	require.NoError(t, err)
+	require.True(t, report.Unclean)
+	require.Equal(t, RunStateRunning, report.Previous.State)
+	require.Nil(t, report.Previous.StoppedAt)
+	require.Empty(t, report.LedgerError)
+	require.Empty(t, report.Deposits)
+	require.Equal(t, report, s.StartupReport())
+}
+
+func TestStoreStartRunUnclean(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	_, err := s.StartRun(time.Now().Add(-time.Hour), "1.0.0")
+	require.NoError(t, err)
+
+	_, err = s.addDepositInfo(DepositInfo{
+		DepositID:      "btx1:1",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr1",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+	})
+	require.NoError(t, err)
+
+	_, err = s.addDepositInfo(DepositInfo{
+		DepositID:      "btx2:1",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr2",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusDone,
+		Txid:           "skytx2",
+		SkySent:        100e6,
+	})
+	require.NoError(t, err)
+
+	// Sent but not broadcast
+	skyTx := &coin.Transaction{
+		Out: []coin.TransactionOutput{
+			{
+				Address: cipher.MustDecodeBase58Address(testSkyAddr),
+				Coins:   100e6,
+			},
+		},
+	}
+	_, err = s.addDepositInfo(DepositInfo{
+		DepositID:      "btx3:1",
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "btcaddr3",
+		DepositValue:   1e6,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+	})
+	require.NoError(t, err)
+	_, err = s.CommitSend("btx3:1", func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = skyTx.TxIDHex()
+		di.SkySent = 100e6
+		return di
+	}, newOutboxEntry("btx3:1", skyTx))
+	require.NoError(t, err)
+
+	report, err := s.StartRun(time.Now(), "1.0.0")
+	require.NoError(t, err)
+	require.True(t, report.Unclean)
+	require.Len(t, report.Deposits, 2)
+
+	require.Equal(t, "btx1:1", report.Deposits[0].DepositID)
+	require.Equal(t, StatusWaitSend.String(), report.Deposits[0].Status)
+	require.Equal(t, InterruptedSend, report.Deposits[0].Reason)
+
+	require.Equal(t, "btx3:1", report.Deposits[1].DepositID)
+	require.Equal(t, StatusWaitConfirm.String(), report.Deposits[1].Status)
+	require.Equal(t, skyTx.TxIDHex(), report.Deposits[1].Txid)
+	require.Equal(t, InterruptedBroadcast, report.Deposits[1].Reason)
+
+	// Once broadcast, the deposit is only reported if it was updated during the interrupted run
+	require.NoError(t, s.MarkBroadcast(skyTx.TxIDHex()))
+
+	deposits, err := s.CheckInterruptedDeposits(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Len(t, deposits, 2)
+	require.Equal(t, InterruptedConfirm, deposits[1].Reason)
+
+	deposits, err = s.CheckInterruptedDeposits(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, deposits)
+
+	// An inconsistent deposit is reported whenever it was updated
+	require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		di, err := s.getDepositInfoTx(tx, "btx2:1")
+		if err != nil {
+			return err
+		}
+		di.Txid = ""
+		return dbutil.PutBucketValue(tx, DepositInfoBkt, di.DepositID, di)
+	}))
+
+	deposits, err = s.CheckInterruptedDeposits(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, deposits, 1)
+	require.Equal(t, "btx2:1", deposits[0].DepositID)
+	require.Equal(t, InterruptedInvalid, deposits[0].Reason)
+	require.Equal(t, "Txid missing", deposits[0].Error)
+}
@@ -0,0 +1,208 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/skycoin/skycoin/src/visor"
+
+	"github.com/skycoin/teller/src/deposits"
+)
+
+// Rate change simulations.
+// A rate simulation previews the impact of a rate or MaxDecimals change on the deposits which weren't sent skycoin yet,
+// before the operator applies it mid-campaign. A deposit's rate is saved when its block is final, so a rate change
+// only applies to the deposits which the scanners detected but are not final yet, and to the deposits held for review,
+// which a reviewer can approve at the new rate. MaxDecimals applies when the skycoin is sent, so a change of it
+// applies to the deposits waiting to be sent too. The dust threshold, validators, rate guard and campaign cap
+// are not simulated, the simulated amounts are those sent if the deposits pass them.
+
+// SimulatedDetected is the status of a simulated deposit which was detected but is not final yet
+const SimulatedDetected = "detected"
+
+// RateSimulation is a proposed change of a coin type's rate, or of MaxDecimals
+type RateSimulation struct {
+	CoinType string `json:"coin_type"`
+	// Rate is the proposed SKY per coin rate, decimal string. Empty to keep the current rate.
+	Rate string `json:"rate"`
+	// MaxDecimals is the proposed MaxDecimals. Nil to keep the current one.
+	MaxDecimals *int `json:"max_decimals"`
+}
+
+// Validate returns an error if the simulation is invalid
+func (r RateSimulation) Validate() error {
+	if _, err := deposits.GetCoin(r.CoinType); err != nil {
+		return err
+	}
+
+	if r.Rate != "" {
+		if _, err := ParseRate(r.Rate); err != nil {
+			return err
+		}
+	}
+
+	if r.MaxDecimals != nil {
+		if *r.MaxDecimals < 0 {
+			return errors.New("max_decimals can't be negative")
+		}
+		if uint64(*r.MaxDecimals) > visor.MaxDropletPrecision {
+			return fmt.Errorf("max_decimals is larger than visor.MaxDropletPrecision=%d", visor.MaxDropletPrecision)
+		}
+	}
+
+	if r.Rate == "" && r.MaxDecimals == nil {
+		return errors.New("A rate or max_decimals is required")
+	}
+
+	return nil
+}
+
+// SimulatedDeposit is the skycoin a deposit is sent now, and after the simulated change
+type SimulatedDeposit struct {
+	DepositID      string `json:"deposit_id"`
+	Status         string `json:"status"`
+	SkyAddress     string `json:"skyaddr,omitempty"`
+	DepositAddress string `json:"deposit_address"`
+	// DepositValue is in the coin type's smallest unit, net of the refund over the campaign cap
+	DepositValue int64  `json:"deposit_value"`
+	Rate         string `json:"rate"`
+	ProposedRate string `json:"proposed_rate"`
+	// The skycoin amounts are in droplets
+	Sky         uint64 `json:"sky"`
+	ProposedSky uint64 `json:"proposed_sky"`
+	SkyDelta    int64  `json:"sky_delta"`
+}
+
+// RateSimulationReport is the impact of a RateSimulation on the deposits which weren't sent skycoin yet
+type RateSimulationReport struct {
+	CoinType            string `json:"coin_type"`
+	CurrentRate         string `json:"current_rate"`
+	ProposedRate        string `json:"proposed_rate"`
+	MaxDecimals         int    `json:"max_decimals"`
+	ProposedMaxDecimals int    `json:"proposed_max_decimals"`
+	// Deposits which weren't sent skycoin yet, detected deposits last
+	Deposits []SimulatedDeposit `json:"deposits"`
+	// The totals are in droplets
+	Sky         uint64 `json:"sky"`
+	ProposedSky uint64 `json:"proposed_sky"`
+	SkyDelta    int64  `json:"sky_delta"`
+}
+
+// SimulateRate returns the impact of sim on the deposits of its coin type which weren't sent skycoin yet
+func (s *Exchange) SimulateRate(sim RateSimulation) (RateSimulationReport, error) {
+	if err := sim.Validate(); err != nil {
+		return RateSimulationReport{}, err
+	}
+
+	coin, err := deposits.GetCoin(sim.CoinType)
+	if err != nil {
+		return RateSimulationReport{}, err
+	}
+
+	currentRate, err := s.CurrentRate(sim.CoinType)
+	if err != nil {
+		return RateSimulationReport{}, err
+	}
+
+	report := RateSimulationReport{
+		CoinType:            sim.CoinType,
+		CurrentRate:         currentRate,
+		ProposedRate:        currentRate,
+		MaxDecimals:         s.cfg.MaxDecimals,
+		ProposedMaxDecimals: s.cfg.MaxDecimals,
+		Deposits:            []SimulatedDeposit{},
+	}
+	if sim.Rate != "" {
+		report.ProposedRate = sim.Rate
+	}
+	if sim.MaxDecimals != nil {
+		report.ProposedMaxDecimals = *sim.MaxDecimals
+	}
+
+	add := func(d SimulatedDeposit) error {
+		sky, err := CalculateSkyValue(coin.Coins(d.DepositValue), d.Rate, report.MaxDecimals)
+		if err != nil {
+			return err
+		}
+		proposed, err := CalculateSkyValue(coin.Coins(d.DepositValue), d.ProposedRate, report.ProposedMaxDecimals)
+		if err != nil {
+			return err
+		}
+
+		// The amounts are at most maxSkyDroplets, which fits an int64
+		d.Sky = sky
+		d.ProposedSky = proposed
+		d.SkyDelta = int64(proposed) - int64(sky)
+
+		report.Sky += sky
+		report.ProposedSky += proposed
+		report.SkyDelta += d.SkyDelta
+
+		report.Deposits = append(report.Deposits, d)
+		return nil
+	}
+
+	dis, err := s.store.GetDepositInfoArray(func(di DepositInfo) bool {
+		return di.CoinType == sim.CoinType
+	})
+	if err != nil {
+		return RateSimulationReport{}, err
+	}
+
+	received := make(map[string]struct{}, len(dis))
+	for _, di := range dis {
+		received[di.DepositID] = struct{}{}
+
+		d := SimulatedDeposit{
+			DepositID:      di.DepositID,
+			Status:         di.Status.String(),
+			SkyAddress:     di.SkyAddress,
+			DepositAddress: di.DepositAddress,
+			DepositValue:   di.DepositValue - di.RefundValue,
+			Rate:           di.ConversionRate,
+			ProposedRate:   di.ConversionRate,
+		}
+
+		switch di.Status {
+		case StatusWaitSend:
+		case StatusWaitReview:
+			// A reviewer can approve the deposit at the proposed rate, which works its refund out again
+			d.DepositValue = di.DepositValue
+			if sim.Rate != "" {
+				d.ProposedRate = sim.Rate
+			}
+		default:
+			continue
+		}
+
+		if err := add(d); err != nil {
+			return RateSimulationReport{}, err
+		}
+	}
+
+	// The detected deposits are converted at the rate when they are final
+	for _, dv := range s.multiplexer.PendingDeposits(sim.CoinType) {
+		if _, ok := received[dv.ID()]; ok {
+			continue
+		}
+
+		skyAddr, err := s.store.GetBindAddress(dv.Address, sim.CoinType)
+		if err != nil {
+			return RateSimulationReport{}, err
+		}
+
+		if err := add(SimulatedDeposit{
+			DepositID:      dv.ID(),
+			Status:         SimulatedDetected,
+			SkyAddress:     skyAddr,
+			DepositAddress: dv.Address,
+			DepositValue:   dv.Amount,
+			Rate:           report.CurrentRate,
+			ProposedRate:   report.ProposedRate,
+		}); err != nil {
+			return RateSimulationReport{}, err
+		}
+	}
+
+	return report, nil
+}
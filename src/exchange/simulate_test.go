@@ -0,0 +1,187 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestRateSimulationValidate(t *testing.T) {
+	maxDecimals := func(n int) *int { return &n }
+
+	require.NoError(t, RateSimulation{CoinType: scanner.CoinTypeBTC, Rate: "200"}.Validate())
+	require.NoError(t, RateSimulation{CoinType: scanner.CoinTypeETH, MaxDecimals: maxDecimals(0)}.Validate())
+
+	for _, sim := range []RateSimulation{
+		{Rate: "200"},
+		{CoinType: "XRP", Rate: "200"},
+		{CoinType: scanner.CoinTypeBTC},
+		{CoinType: scanner.CoinTypeBTC, Rate: "0"},
+		{CoinType: scanner.CoinTypeBTC, Rate: "abc"},
+		{CoinType: scanner.CoinTypeBTC, MaxDecimals: maxDecimals(-1)},
+		{CoinType: scanner.CoinTypeBTC, MaxDecimals: maxDecimals(7)},
+	} {
+		require.Error(t, sim.Validate(), "%+v", sim)
+	}
+}
+
+func TestExchangeSimulateRate(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	scan := newDummyScanner()
+	s, err := NewExchange(log, store, scan, newDummySender(), analytics.Noop{}, Config{
+		BtcRate:     testSkyBtcRate,
+		EthRate:     "10",
+		MaxDecimals: 3,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, store.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.NoError(t, store.BindAddress(testSkyAddr2, "btcaddr2", scanner.CoinTypeBTC, ""))
+
+	add := func(di DepositInfo) {
+		di.CoinType = scanner.CoinTypeBTC
+		di.SkyAddress = testSkyAddr
+		di.DepositAddress = "btcaddr1"
+		_, err := store.addDepositInfo(di)
+		require.NoError(t, err)
+	}
+
+	// Its rate is saved, only a change of MaxDecimals applies
+	add(DepositInfo{
+		DepositID:      "btctx1:0",
+		DepositValue:   123456789,
+		ConversionRate: "100",
+		Status:         StatusWaitSend,
+	})
+	// A reviewer can approve it at the proposed rate
+	add(DepositInfo{
+		DepositID:      "btctx2:0",
+		DepositValue:   1e8,
+		ConversionRate: "1000",
+		Status:         StatusWaitReview,
+	})
+	// Already sent
+	add(DepositInfo{
+		DepositID:      "btctx3:0",
+		DepositValue:   1e8,
+		ConversionRate: "100",
+		Status:         StatusDone,
+		Txid:           "skytx3",
+		SkySent:        100e6,
+	})
+
+	scan.pending = []deposits.Deposit{
+		{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  "btcaddr2",
+			Tx:       "btctx4",
+			Amount:   5e7,
+		},
+		// Already received
+		{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  "btcaddr1",
+			Tx:       "btctx1",
+			Amount:   123456789,
+		},
+		// Another coin type
+		{
+			CoinType: scanner.CoinTypeETH,
+			Address:  "ethaddr1",
+			Tx:       "ethtx1",
+			Amount:   1e9,
+		},
+	}
+
+	report, err := s.SimulateRate(RateSimulation{
+		CoinType: scanner.CoinTypeBTC,
+		Rate:     "150",
+	})
+	require.NoError(t, err)
+	require.Equal(t, scanner.CoinTypeBTC, report.CoinType)
+	require.Equal(t, testSkyBtcRate, report.CurrentRate)
+	require.Equal(t, "150", report.ProposedRate)
+	require.Equal(t, 3, report.MaxDecimals)
+	require.Equal(t, 3, report.ProposedMaxDecimals)
+
+	require.Equal(t, []SimulatedDeposit{
+		{
+			DepositID:      "btctx1:0",
+			Status:         StatusWaitSend.String(),
+			SkyAddress:     testSkyAddr,
+			DepositAddress: "btcaddr1",
+			DepositValue:   123456789,
+			Rate:           "100",
+			ProposedRate:   "100",
+			Sky:            123456000,
+			ProposedSky:    123456000,
+		},
+		{
+			DepositID:      "btctx2:0",
+			Status:         StatusWaitReview.String(),
+			SkyAddress:     testSkyAddr,
+			DepositAddress: "btcaddr1",
+			DepositValue:   1e8,
+			Rate:           "1000",
+			ProposedRate:   "150",
+			Sky:            1000e6,
+			ProposedSky:    150e6,
+			SkyDelta:       -850e6,
+		},
+		{
+			DepositID:      "btctx4:0",
+			Status:         SimulatedDetected,
+			SkyAddress:     testSkyAddr2,
+			DepositAddress: "btcaddr2",
+			DepositValue:   5e7,
+			Rate:           testSkyBtcRate,
+			ProposedRate:   "150",
+			Sky:            50e6,
+			ProposedSky:    75e6,
+			SkyDelta:       25e6,
+		},
+	}, report.Deposits)
+
+	require.Equal(t, uint64(1173456000), report.Sky)
+	require.Equal(t, uint64(348456000), report.ProposedSky)
+	require.Equal(t, int64(-825e6), report.SkyDelta)
+
+	// A change of MaxDecimals applies to every deposit which wasn't sent
+	maxDecimals := 1
+	report, err = s.SimulateRate(RateSimulation{
+		CoinType:    scanner.CoinTypeBTC,
+		MaxDecimals: &maxDecimals,
+	})
+	require.NoError(t, err)
+	require.Equal(t, testSkyBtcRate, report.ProposedRate)
+	require.Equal(t, 1, report.ProposedMaxDecimals)
+	require.Len(t, report.Deposits, 3)
+	require.Equal(t, uint64(123400000), report.Deposits[0].ProposedSky)
+	require.Equal(t, int64(-56000), report.Deposits[0].SkyDelta)
+	require.Equal(t, "1000", report.Deposits[1].ProposedRate)
+	require.Equal(t, int64(-56000), report.SkyDelta)
+
+	// No ETH deposits were received
+	report, err = s.SimulateRate(RateSimulation{
+		CoinType: scanner.CoinTypeETH,
+		Rate:     "20",
+	})
+	require.NoError(t, err)
+	require.Len(t, report.Deposits, 1)
+	require.Equal(t, "ethtx1:0", report.Deposits[0].DepositID)
+	require.Equal(t, int64(10e6), report.Deposits[0].SkyDelta)
+
+	_, err = s.SimulateRate(RateSimulation{CoinType: scanner.CoinTypeBTC})
+	require.Error(t, err)
+}
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+)
+
+// Report snapshots.
+// A bolt read transaction doesn't block the writers, but it holds the db's mmap lock, and a writer which grows
+// the db file waits for every open read transaction to finish before remapping it. An export streamed to a slow
+// admin client holds its read transaction for as long as the client takes to read it, and stalls the deposit
+// processing meanwhile.
+//
+// With a SnapshotConfig, the exports and the ledger report are run against a snapshot of the store instead.
+// The snapshot is a copy of the db file, made by a read transaction at the speed of the disk, which is opened
+// read only. The report then reads a consistent state of the store for as long as it takes, and the snapshot
+// is deleted when it is done. Each report copies the whole db, so the snapshot dir needs room for a copy per
+// concurrent report.
+
+// SnapshotConfig configures the report snapshots
+type SnapshotConfig struct {
+	Enabled bool
+	// Dir of the snapshot files. Defaults to the db file's dir.
+	Dir string
+}
+
+// Snapshot is a read only copy of a Store
+type Snapshot struct {
+	*Store
+	path string
+}
+
+// Snapshot copies the store to a file in dir, the db file's dir if empty, and opens the copy read only.
+// The snapshot must be closed, which deletes its file.
+func (s *Store) Snapshot(dir string) (*Snapshot, error) {
+	if dir == "" {
+		dir = filepath.Dir(s.db.Path())
+	}
+
+	f, err := ioutil.TempFile(dir, "teller-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	path := f.Name()
+	if err := f.Close(); err != nil {
+		os.Remove(path) // nolint: errcheck
+		return nil, err
+	}
+
+	start := time.Now()
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	}); err != nil {
+		os.Remove(path) // nolint: errcheck
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0400, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		os.Remove(path) // nolint: errcheck
+		return nil, err
+	}
+
+	store, err := newReadOnlyStore(s.log, db)
+	if err != nil {
+		db.Close()      // nolint: errcheck
+		os.Remove(path) // nolint: errcheck
+		return nil, err
+	}
+
+	s.log.WithFields(logrus.Fields{
+		"path":     path,
+		"duration": time.Since(start),
+	}).Debug("Snapshot store")
+
+	return &Snapshot{
+		Store: store,
+		path:  path,
+	}, nil
+}
+
+// Close closes the snapshot and deletes its file
+func (s *Snapshot) Close() error {
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	return os.Remove(s.path)
+}
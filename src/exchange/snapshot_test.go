@@ -0,0 +1,127 @@
+package exchange
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestStoreSnapshot(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	dir, err := ioutil.TempDir("", "teller-snapshot-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = s.addDepositInfo(DepositInfo{
+		DepositID:      "t1:1",
+		CoinType:       scanner.CoinTypeBTC,
+		DepositAddress: "b1",
+		SkyAddress:     "s1",
+		DepositValue:   1e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+	})
+	require.NoError(t, err)
+
+	snap, err := s.Snapshot(dir)
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "teller-snapshot-*"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// The store is written while the snapshot is read
+	_, err = s.addDepositInfo(DepositInfo{
+		DepositID:      "t2:1",
+		CoinType:       scanner.CoinTypeBTC,
+		DepositAddress: "b1",
+		SkyAddress:     "s1",
+		DepositValue:   2e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+	})
+	require.NoError(t, err)
+
+	count := func(s Storer) int {
+		n := 0
+		require.NoError(t, s.QueryDepositInfos(DepositQuery{}, func(DepositInfo) error {
+			n++
+			return nil
+		}))
+		return n
+	}
+
+	require.Equal(t, 1, count(snap))
+	require.Equal(t, 2, count(s))
+	require.NoError(t, snap.CheckLedger())
+
+	// The snapshot is read only
+	_, err = snap.addDepositInfo(DepositInfo{
+		DepositID: "t3:1",
+		CoinType:  scanner.CoinTypeBTC,
+		Status:    StatusWaitSend,
+	})
+	require.Error(t, err)
+
+	require.NoError(t, snap.Close())
+	files, err = filepath.Glob(filepath.Join(dir, "teller-snapshot-*"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestExchangeReportSnapshots(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	e := newTestExchange(t, log, db)
+
+	dir, err := ioutil.TempDir("", "teller-snapshot-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e.cfg.Snapshots = SnapshotConfig{
+		Enabled: true,
+		Dir:     dir,
+	}
+
+	_, err = e.store.(*Store).addDepositInfo(DepositInfo{
+		DepositID:      "t1:1",
+		CoinType:       scanner.CoinTypeBTC,
+		DepositAddress: "b1",
+		SkyAddress:     "s1",
+		DepositValue:   1e8,
+		ConversionRate: testSkyBtcRate,
+		Status:         StatusWaitSend,
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	n, err := e.ExportDeposits(&buf, ExportJSONL, ExportFilter{})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Contains(t, buf.String(), `"deposit_id":"t1:1"`)
+
+	report, err := e.GetLedgerReport()
+	require.NoError(t, err)
+	require.Empty(t, report.Drift)
+
+	// The snapshots are deleted once the reports are done
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+
+	// A report fails if the snapshot can't be made
+	e.cfg.Snapshots.Dir = filepath.Join(dir, "missing")
+	_, err = e.ExportDeposits(&buf, ExportJSONL, ExportFilter{})
+	require.Error(t, err)
+}
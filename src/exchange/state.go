@@ -0,0 +1,213 @@
+package exchange
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/boltdb/bolt"
+)
+
+// A deposit's Status only moves along the transitions declared in depositTransitions.
+// The Store checks every DepositInfo update against them, and rejects an update which
+// would make an undeclared transition, or whose guard fails, before anything is written.
+// The StateMachine's tx hooks are called with a transition in the bolt.Tx which makes it,
+// and its hooks are called with it after it is committed.
+//
+//     StatusWaitDeposit -> StatusWaitSend        deposit received
+//     StatusWaitSend    -> StatusWaitConfirm     skycoin transaction created
+//     StatusWaitSend    -> StatusDone            deposit too small to send anything
+//     StatusWaitSend    -> StatusWaitReview      rate or amount refused, or over the campaign cap
+//     StatusWaitSend    -> StatusDustIgnored     deposit below the dust threshold
+//     StatusWaitReview  -> StatusWaitSend        approved after review
+//     StatusWaitConfirm -> StatusDone            skycoin transaction confirmed
+//     StatusWaitConfirm -> StatusWaitReview      skycoin transaction refused permanently by the sender
+//
+// StatusWaitDeposit is never saved, so a saved deposit's first transition is from it.
+// Updates which don't change the Status aren't transitions and are not checked.
+
+// TransitionGuard checks that a deposit may make a transition, given its states before and after
+type TransitionGuard func(from, to DepositInfo) error
+
+// TransitionHook is called with a transition after it has been committed
+type TransitionHook func(Transition)
+
+// TransitionTxHook is called with a transition in the bolt.Tx which makes it, so that its writes
+// are committed with the transition. An error rolls the transition back.
+type TransitionTxHook func(tx *bolt.Tx, t Transition) error
+
+// Transition is a change of a deposit's Status
+type Transition struct {
+	From DepositInfo
+	To   DepositInfo
+}
+
+// IsTransition returns true if the Status changed
+func (t Transition) IsTransition() bool {
+	return t.From.Status != t.To.Status
+}
+
+// InvalidTransitionErr is returned when a deposit update makes an undeclared transition, or fails its guard
+type InvalidTransitionErr struct {
+	From   Status
+	To     Status
+	Reason error // The guard's error, nil if the transition isn't declared
+}
+
+func (e InvalidTransitionErr) Error() string {
+	if e.Reason == nil {
+		return fmt.Sprintf("Invalid deposit status transition from %s to %s", e.From, e.To)
+	}
+	return fmt.Sprintf("Invalid deposit status transition from %s to %s: %v", e.From, e.To, e.Reason)
+}
+
+// depositTransitions declares the valid transitions and their guards. A nil guard always passes.
+var depositTransitions = map[Status]map[Status]TransitionGuard{
+	StatusWaitDeposit: {
+		StatusWaitSend: nil,
+	},
+	StatusWaitSend: {
+		StatusWaitConfirm: guardSent,
+		StatusDone:        guardEmptySend,
+		StatusWaitReview:  guardHoldReason,
+		StatusDustIgnored: guardDust,
+	},
+	StatusWaitReview: {
+		StatusWaitSend: guardReviewed,
+	},
+	StatusWaitConfirm: {
+		StatusDone:       guardSent,
+		StatusWaitReview: guardHoldReason,
+	},
+}
+
+// guardSent requires the skycoin transaction to be recorded
+func guardSent(from, to DepositInfo) error {
+	if to.Txid == "" {
+		return errors.New("Txid missing")
+	}
+	return nil
+}
+
+// guardEmptySend only lets a deposit skip StatusWaitConfirm if it was too small to send anything
+func guardEmptySend(from, to DepositInfo) error {
+	if to.Txid == "" && to.Error != ErrEmptySendAmount.Error() {
+		return errors.New("deposit was not sent and its send amount is not empty")
+	}
+	return nil
+}
+
+// guardHoldReason requires the reason for holding a deposit to be recorded
+func guardHoldReason(from, to DepositInfo) error {
+	if to.Error == "" {
+		return errors.New("reason for review missing")
+	}
+	return nil
+}
+
+// guardDust requires the dust threshold to be recorded
+func guardDust(from, to DepositInfo) error {
+	if to.Error == "" || to.Txid != "" || to.SkySent != 0 {
+		return errors.New("deposit was sent or its dust threshold is missing")
+	}
+	return nil
+}
+
+// guardReviewed only releases a deposit from review once it has been approved
+func guardReviewed(from, to DepositInfo) error {
+	if !to.RateReviewed {
+		return errors.New("deposit was not approved")
+	}
+	return nil
+}
+
+// StateMachine checks deposit transitions against depositTransitions, and calls hooks after them
+type StateMachine struct {
+	sync.RWMutex
+	hooks   []TransitionHook
+	txHooks []TransitionTxHook
+}
+
+// NewStateMachine creates a StateMachine
+func NewStateMachine() *StateMachine {
+	return &StateMachine{}
+}
+
+// Check returns an InvalidTransitionErr if t isn't a valid transition.
+// Updates which don't change the Status are always valid.
+func (m *StateMachine) Check(t Transition) error {
+	if !t.IsTransition() {
+		return nil
+	}
+
+	guard, ok := depositTransitions[t.From.Status][t.To.Status]
+	if !ok {
+		return InvalidTransitionErr{
+			From: t.From.Status,
+			To:   t.To.Status,
+		}
+	}
+
+	if guard != nil {
+		if err := guard(t.From, t.To); err != nil {
+			return InvalidTransitionErr{
+				From:   t.From.Status,
+				To:     t.To.Status,
+				Reason: err,
+			}
+		}
+	}
+
+	return nil
+}
+
+// OnTransition adds a hook which is called after each committed transition.
+// Hooks are called in the order they were added, from the goroutine which made the update,
+// so they must not block.
+func (m *StateMachine) OnTransition(h TransitionHook) {
+	m.Lock()
+	defer m.Unlock()
+	m.hooks = append(m.hooks, h)
+}
+
+// OnTransitionTx adds a hook which is called in the bolt.Tx of each transition, before it is committed.
+// Hooks are called in the order they were added.
+func (m *StateMachine) OnTransitionTx(h TransitionTxHook) {
+	m.Lock()
+	defer m.Unlock()
+	m.txHooks = append(m.txHooks, h)
+}
+
+// making calls the tx hooks with t in tx, if it is a transition
+func (m *StateMachine) making(tx *bolt.Tx, t Transition) error {
+	if !t.IsTransition() {
+		return nil
+	}
+
+	m.RLock()
+	hooks := m.txHooks
+	m.RUnlock()
+
+	for _, h := range hooks {
+		if err := h(tx, t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// committed calls the hooks with t, if it is a transition
+func (m *StateMachine) committed(t Transition) {
+	if !t.IsTransition() {
+		return
+	}
+
+	m.RLock()
+	hooks := m.hooks
+	m.RUnlock()
+
+	for _, h := range hooks {
+		h(t)
+	}
+}
@@ -0,0 +1,274 @@
+package exchange
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestStateMachineCheck(t *testing.T) {
+	m := NewStateMachine()
+
+	di := DepositInfo{
+		DepositID: "btx1:1",
+		Status:    StatusWaitSend,
+	}
+
+	with := func(status Status, f func(*DepositInfo)) DepositInfo {
+		d := di
+		d.Status = status
+		if f != nil {
+			f(&d)
+		}
+		return d
+	}
+
+	sent := func(d *DepositInfo) {
+		d.Txid = "txid"
+		d.SkySent = 1e6
+	}
+
+	cases := []struct {
+		from  DepositInfo
+		to    DepositInfo
+		valid bool
+	}{
+		{with(StatusWaitDeposit, nil), with(StatusWaitSend, nil), true},
+		{with(StatusWaitSend, nil), with(StatusWaitConfirm, sent), true},
+		{with(StatusWaitSend, nil), with(StatusWaitConfirm, nil), false},
+		{with(StatusWaitSend, nil), with(StatusDone, func(d *DepositInfo) { d.Error = ErrEmptySendAmount.Error() }), true},
+		{with(StatusWaitSend, nil), with(StatusDone, nil), false},
+		{with(StatusWaitSend, nil), with(StatusWaitReview, func(d *DepositInfo) { d.Error = "rate refused" }), true},
+		{with(StatusWaitSend, nil), with(StatusWaitReview, nil), false},
+		{with(StatusWaitSend, nil), with(StatusDustIgnored, func(d *DepositInfo) { d.Error = "below the minimum" }), true},
+		{with(StatusWaitSend, nil), with(StatusDustIgnored, nil), false},
+		{with(StatusDustIgnored, nil), with(StatusWaitSend, func(d *DepositInfo) { d.RateReviewed = true }), false},
+		{with(StatusWaitReview, nil), with(StatusWaitSend, func(d *DepositInfo) { d.RateReviewed = true }), true},
+		{with(StatusWaitReview, nil), with(StatusWaitSend, nil), false},
+		{with(StatusWaitReview, nil), with(StatusWaitConfirm, sent), false},
+		{with(StatusWaitConfirm, sent), with(StatusDone, sent), true},
+		{with(StatusWaitConfirm, sent), with(StatusWaitSend, nil), false},
+		{with(StatusWaitConfirm, sent), with(StatusWaitReview, func(d *DepositInfo) { sent(d); d.Error = "transaction refused" }), true},
+		{with(StatusWaitConfirm, sent), with(StatusWaitReview, sent), false},
+		{with(StatusDone, sent), with(StatusWaitSend, nil), false},
+		{with(StatusDone, sent), with(StatusWaitConfirm, sent), false},
+		{with(StatusWaitSend, nil), with(StatusWaitDeposit, nil), false},
+		{with(StatusWaitSend, nil), with(StatusUnknown, nil), false},
+		// Not a transition
+		{with(StatusDone, sent), with(StatusDone, sent), true},
+		{with(StatusWaitReview, nil), with(StatusWaitReview, func(d *DepositInfo) { d.ConversionRate = "500" }), true},
+	}
+
+	for _, tc := range cases {
+		name := fmt.Sprintf("%s->%s", tc.from.Status, tc.to.Status)
+		t.Run(name, func(t *testing.T) {
+			err := m.Check(Transition{
+				From: tc.from,
+				To:   tc.to,
+			})
+			if tc.valid {
+				require.NoError(t, err)
+				return
+			}
+
+			require.IsType(t, InvalidTransitionErr{}, err)
+			terr := err.(InvalidTransitionErr)
+			require.Equal(t, tc.from.Status, terr.From)
+			require.Equal(t, tc.to.Status, terr.To)
+		})
+	}
+}
+
+func TestStoreTransitions(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	var transitions []Transition
+	s.StateMachine().OnTransition(func(t Transition) {
+		transitions = append(transitions, t)
+	})
+
+	err := s.BindAddress("a", "b", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	dv := deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "b",
+		Amount:   1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}
+
+	// Creating a deposit is a transition from StatusWaitDeposit
+	di, err := s.GetOrCreateDepositInfo(dv, testSkyBtcRate)
+	require.NoError(t, err)
+	require.Len(t, transitions, 1)
+	require.Equal(t, StatusWaitDeposit, transitions[0].From.Status)
+	require.Equal(t, di, transitions[0].To)
+
+	// Getting it again is not
+	_, err = s.GetOrCreateDepositInfo(dv, testSkyBtcRate)
+	require.NoError(t, err)
+	require.Len(t, transitions, 1)
+
+	// An invalid transition is rejected and nothing is saved
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusDone
+		di.SkySent = 5e6
+		return di
+	})
+	require.IsType(t, InvalidTransitionErr{}, err)
+	require.Equal(t, StatusWaitSend, err.(InvalidTransitionErr).From)
+	require.Equal(t, StatusDone, err.(InvalidTransitionErr).To)
+	require.Error(t, err.(InvalidTransitionErr).Reason)
+	require.Len(t, transitions, 1)
+
+	saved, err := s.getDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, saved.Status)
+	require.Zero(t, saved.SkySent)
+
+	balances, err := s.GetLedgerBalances()
+	require.NoError(t, err)
+	require.Empty(t, balances[CurrencySKY])
+
+	// Updates which don't change the status don't call the hooks
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Error = "retrying"
+		return di
+	})
+	require.NoError(t, err)
+	require.Len(t, transitions, 1)
+
+	// Rolled back transitions don't call the hooks
+	_, err = s.UpdateDepositInfoCallback(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitReview
+		di.Error = "rate refused"
+		return di
+	}, func(di DepositInfo) error {
+		return ErrNoResponse
+	})
+	require.Equal(t, ErrNoResponse, err)
+	require.Len(t, transitions, 1)
+
+	di, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "txid"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+	require.Len(t, transitions, 2)
+	require.Equal(t, StatusWaitSend, transitions[1].From.Status)
+	require.Equal(t, di, transitions[1].To)
+
+	// Sent deposits can't go back to waiting to be sent
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitSend
+		return di
+	})
+	require.Equal(t, InvalidTransitionErr{
+		From: StatusWaitConfirm,
+		To:   StatusWaitSend,
+	}, err)
+	require.Len(t, transitions, 2)
+}
+
+func TestStoreTransitionTxHooks(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	hookBkt := []byte("hook")
+	var failHook error
+	s.StateMachine().OnTransitionTx(func(tx *bolt.Tx, t Transition) error {
+		if failHook != nil {
+			return failHook
+		}
+		bkt, err := tx.CreateBucketIfNotExists(hookBkt)
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(t.To.DepositID+"/"+t.To.Status.String()), []byte(t.From.Status.String()))
+	})
+
+	var committed int
+	s.StateMachine().OnTransition(func(t Transition) {
+		committed++
+	})
+
+	hooked := func() map[string]string {
+		m := map[string]string{}
+		require.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+			bkt := tx.Bucket(hookBkt)
+			if bkt == nil {
+				return nil
+			}
+			return bkt.ForEach(func(k, v []byte) error {
+				m[string(k)] = string(v)
+				return nil
+			})
+		}))
+		return m
+	}
+
+	err := s.BindAddress("a", "b", scanner.CoinTypeBTC, "")
+	require.NoError(t, err)
+
+	di, err := s.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "b",
+		Amount:   1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"btx1:1/waiting_send": "waiting_deposit",
+	}, hooked())
+
+	// Updates which don't change the status don't call the tx hooks
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Error = "retrying"
+		return di
+	})
+	require.NoError(t, err)
+	require.Len(t, hooked(), 1)
+
+	// A tx hook's error rolls the transition back, and the hooks aren't called
+	failHook = fmt.Errorf("hook failed")
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "txid"
+		di.SkySent = 5e6
+		return di
+	})
+	require.Equal(t, failHook, err)
+	require.Equal(t, 1, committed)
+
+	saved, err := s.getDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitSend, saved.Status)
+
+	failHook = nil
+	_, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "txid"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, committed)
+	require.Equal(t, map[string]string{
+		"btx1:1/waiting_send":    "waiting_deposit",
+		"btx1:1/waiting_confirm": "waiting_send",
+	}, hooked())
+}
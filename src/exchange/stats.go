@@ -0,0 +1,86 @@
+package exchange
+
+import (
+	"encoding/json"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// CampaignStats are the public aggregate totals of the campaign, taken from the ledger
+type CampaignStats struct {
+	// Deposits received by coin type net of refunds, in the ledger unit of the coin (satoshis for BTC, gwei for ETH)
+	Raised map[string]int64 `json:"raised"`
+	// Number of distinct skycoin addresses whose deposits are in the ledger
+	Participants int `json:"participants"`
+	// Skycoin sent, in droplets
+	SkySent int64 `json:"sky_sent"`
+	// Nil if no campaign cap is set
+	Cap *CapProgress `json:"cap,omitempty"`
+}
+
+// GetCampaignStats returns the campaign totals. The totals are the ledger account balances,
+// so a deposit counts once it is posted to the ledger.
+func (s *Store) GetCampaignStats() (CampaignStats, error) {
+	stats := CampaignStats{
+		Raised: make(map[string]int64),
+	}
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		balances, err := s.getLedgerBalancesTx(tx)
+		if err != nil {
+			return err
+		}
+
+		for currency, accounts := range balances {
+			if currency == CurrencySKY {
+				stats.SkySent = accounts[AccountConversion]
+				continue
+			}
+			// Refunds are credits, so adding them subtracts the refunded amounts
+			stats.Raised[currency] = accounts[AccountDepositsReceived] + accounts[AccountRefunds]
+		}
+
+		participants := make(map[string]struct{})
+		if err := dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+			var dpi DepositInfo
+			if err := json.Unmarshal(v, &dpi); err != nil {
+				return err
+			}
+
+			if len(ledgerPosition(dpi)) != 0 {
+				participants[dpi.SkyAddress] = struct{}{}
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		stats.Participants = len(participants)
+		return nil
+	}); err != nil {
+		return CampaignStats{}, err
+	}
+
+	return stats, nil
+}
+
+// GetCampaignStats returns the public aggregate totals of the campaign, and its progress towards the campaign cap
+func (s *Exchange) GetCampaignStats() (CampaignStats, error) {
+	stats, err := s.store.GetCampaignStats()
+	if err != nil {
+		return CampaignStats{}, err
+	}
+
+	if s.cap.enabled() {
+		p, err := s.capProgress()
+		if err != nil {
+			return CampaignStats{}, err
+		}
+		stats.Cap = &p
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,53 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+)
+
+func TestStoreGetCampaignStats(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	stats, err := s.GetCampaignStats()
+	require.NoError(t, err)
+	require.Equal(t, CampaignStats{
+		Raised: map[string]int64{},
+	}, stats)
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr2", scanner.CoinTypeBTC, ""))
+	require.NoError(t, s.BindAddress(testSkyAddr2, "ethaddr1", scanner.CoinTypeETH, ""))
+
+	for _, dv := range []deposits.Deposit{
+		{CoinType: scanner.CoinTypeBTC, Address: "btcaddr1", Amount: 1e6, Tx: "btx1", N: 1, Final: true},
+		{CoinType: scanner.CoinTypeBTC, Address: "btcaddr2", Amount: 2e6, Tx: "btx2", N: 1, Final: true},
+		{CoinType: scanner.CoinTypeETH, Address: "ethaddr1", Amount: 3e9, Tx: "etx1", N: 1, Final: true},
+	} {
+		_, err := s.GetOrCreateDepositInfo(dv, testSkyBtcRate)
+		require.NoError(t, err)
+	}
+
+	_, err = s.UpdateDepositInfo("btx1:1", func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitConfirm
+		di.Txid = "txid"
+		di.SkySent = 5e6
+		return di
+	})
+	require.NoError(t, err)
+
+	stats, err = s.GetCampaignStats()
+	require.NoError(t, err)
+	require.Equal(t, CampaignStats{
+		Raised: map[string]int64{
+			scanner.CoinTypeBTC: 3e6,
+			scanner.CoinTypeETH: 3e9,
+		},
+		Participants: 2,
+		SkySent:      5e6,
+	}, stats)
+}
@@ -0,0 +1,109 @@
+package exchange
+
+import "sync"
+
+// StatusCacheStats are the lookups of the deposit status cache
+type StatusCacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+}
+
+// statusCache caches the deposit infos of skycoin addresses, so that polling /api/status doesn't
+// scan the store on each request. An entry is dropped on each change of its skycoin address's deposit
+// statuses, the same events which wake the statusWatcher. Deposits detected by the scanners but not
+// final yet are kept out of the cache, since they change without an event.
+type statusCache struct {
+	sync.Mutex
+	size    int
+	entries map[string][]DepositInfo
+	// gen is bumped by each invalidation, so that a read which raced with one isn't cached
+	gen   uint64
+	stats StatusCacheStats
+}
+
+// newStatusCache creates a statusCache holding up to size skycoin addresses. It is nil if size is 0.
+func newStatusCache(size int) *statusCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &statusCache{
+		size:    size,
+		entries: make(map[string][]DepositInfo),
+	}
+}
+
+// get returns the deposit infos of skyAddr, reading them with load on a miss
+func (c *statusCache) get(skyAddr string, load func(string) ([]DepositInfo, error)) ([]DepositInfo, error) {
+	if c == nil {
+		return load(skyAddr)
+	}
+
+	c.Lock()
+	if dis, ok := c.entries[skyAddr]; ok {
+		c.stats.Hits++
+		c.Unlock()
+		return dis, nil
+	}
+	c.stats.Misses++
+	gen := c.gen
+	c.Unlock()
+
+	dis, err := load(skyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.gen != gen {
+		return dis, nil
+	}
+
+	if len(c.entries) >= c.size {
+		// Evict any entry, the polled addresses are read back soon
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[skyAddr] = dis
+
+	return dis, nil
+}
+
+// changed drops the cached deposit infos of skyAddr
+func (c *statusCache) changed(skyAddr string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.gen++
+	c.stats.Invalidations++
+	delete(c.entries, skyAddr)
+}
+
+// onTransition is a TransitionHook which drops the cached deposit infos of the deposit's skycoin address
+func (c *statusCache) onTransition(t Transition) {
+	c.changed(t.To.SkyAddress)
+}
+
+// Stats returns the lookups of the cache
+func (c *statusCache) Stats() StatusCacheStats {
+	if c == nil {
+		return StatusCacheStats{}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	return stats
+}
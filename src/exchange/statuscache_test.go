@@ -0,0 +1,130 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestStatusCache(t *testing.T) {
+	c := newStatusCache(2)
+
+	loads := 0
+	load := func(skyAddr string) ([]DepositInfo, error) {
+		loads++
+		return []DepositInfo{{SkyAddress: skyAddr}}, nil
+	}
+
+	dis, err := c.get("a", load)
+	require.NoError(t, err)
+	require.Equal(t, "a", dis[0].SkyAddress)
+	_, err = c.get("a", load)
+	require.NoError(t, err)
+	require.Equal(t, 1, loads)
+
+	// A change drops the entry
+	c.changed("a")
+	_, err = c.get("a", load)
+	require.NoError(t, err)
+	require.Equal(t, 2, loads)
+
+	// A read which raced with a change isn't cached
+	_, err = c.get("b", func(skyAddr string) ([]DepositInfo, error) {
+		c.changed(skyAddr)
+		return load(skyAddr)
+	})
+	require.NoError(t, err)
+	_, err = c.get("b", load)
+	require.NoError(t, err)
+	require.Equal(t, 4, loads)
+
+	// Errors aren't cached
+	_, err = c.get("c", func(string) ([]DepositInfo, error) {
+		return nil, errors.New("failed")
+	})
+	require.Error(t, err)
+
+	// The cache holds up to size entries
+	_, err = c.get("c", load)
+	require.NoError(t, err)
+
+	require.Equal(t, StatusCacheStats{
+		Hits:          1,
+		Misses:        6,
+		Invalidations: 2,
+		Entries:       2,
+	}, c.Stats())
+
+	// A nil cache always loads
+	var nc *statusCache
+	_, err = nc.get("a", load)
+	require.NoError(t, err)
+	nc.changed("a")
+	require.Equal(t, 6, loads)
+	require.Equal(t, StatusCacheStats{}, nc.Stats())
+}
+
+func TestExchangeStatusCache(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	e := newTestExchange(t, log, db)
+	defer closeMultiplexer(e)
+	e.statuses = newStatusCache(10)
+	e.store.StateMachine().OnTransition(e.statuses.onTransition)
+
+	skyAddr := "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+
+	_, err := e.GetDepositStatuses(skyAddr)
+	require.Equal(t, ErrSkyAddressNotBound, err)
+
+	// Binding drops the cached unbound address
+	require.NoError(t, e.BindAddress(skyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	dss, err := e.GetDepositStatuses(skyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 1)
+	require.Equal(t, StatusWaitDeposit.String(), dss[0].Status)
+
+	dss, err = e.GetDepositStatuses(skyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 1)
+	require.Equal(t, uint64(1), e.StatusCacheStats().Hits)
+
+	// A deposit's transition drops it too
+	_, err = e.store.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr1",
+		Amount:   1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+
+	dss, err = e.GetDepositStatuses(skyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 1)
+	require.Equal(t, StatusWaitSend.String(), dss[0].Status)
+
+	// And cancelling a binding
+	require.NoError(t, e.BindAddress(skyAddr, "btcaddr2", scanner.CoinTypeBTC, ""))
+	dss, err = e.GetDepositStatuses(skyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 2)
+
+	require.NoError(t, e.CancelBinding(skyAddr, "btcaddr2", scanner.CoinTypeBTC, false))
+	dss, err = e.GetDepositStatuses(skyAddr)
+	require.NoError(t, err)
+	require.Len(t, dss, 1)
+
+	stats := e.StatusCacheStats()
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(5), stats.Misses)
+}
@@ -0,0 +1,70 @@
+package exchange
+
+import "sync"
+
+// statusWatcher wakes the callers waiting for a change of a skycoin address's deposit statuses.
+// It is notified by the StateMachine's transitions, and by bindings being added or cancelled,
+// which change the statuses without a transition.
+type statusWatcher struct {
+	sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+func newStatusWatcher() *statusWatcher {
+	return &statusWatcher{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// watch returns a channel which is closed at the next change of skyAddr's deposit statuses,
+// and a func which stops watching. The stop func must be called.
+func (w *statusWatcher) watch(skyAddr string) (<-chan struct{}, func()) {
+	if w == nil {
+		return nil, func() {}
+	}
+
+	c := make(chan struct{})
+
+	w.Lock()
+	defer w.Unlock()
+
+	if w.waiters[skyAddr] == nil {
+		w.waiters[skyAddr] = make(map[chan struct{}]struct{})
+	}
+	w.waiters[skyAddr][c] = struct{}{}
+
+	return c, func() {
+		w.Lock()
+		defer w.Unlock()
+
+		if _, ok := w.waiters[skyAddr][c]; !ok {
+			// Already woken
+			return
+		}
+
+		delete(w.waiters[skyAddr], c)
+		if len(w.waiters[skyAddr]) == 0 {
+			delete(w.waiters, skyAddr)
+		}
+	}
+}
+
+// changed wakes the callers watching skyAddr
+func (w *statusWatcher) changed(skyAddr string) {
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	defer w.Unlock()
+
+	for c := range w.waiters[skyAddr] {
+		close(c)
+	}
+	delete(w.waiters, skyAddr)
+}
+
+// onTransition is a TransitionHook which wakes the callers watching the deposit's skycoin address
+func (w *statusWatcher) onTransition(t Transition) {
+	w.changed(t.To.SkyAddress)
+}
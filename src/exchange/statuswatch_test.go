@@ -0,0 +1,105 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func requireWoken(t *testing.T, c <-chan struct{}) {
+	select {
+	case <-c:
+	default:
+		t.Fatal("watcher was not woken")
+	}
+}
+
+func requireNotWoken(t *testing.T, c <-chan struct{}) {
+	select {
+	case <-c:
+		t.Fatal("watcher was woken")
+	default:
+	}
+}
+
+func TestStatusWatcher(t *testing.T) {
+	w := newStatusWatcher()
+
+	a1, stopA1 := w.watch("a")
+	a2, stopA2 := w.watch("a")
+	b, stopB := w.watch("b")
+
+	w.changed("a")
+	requireWoken(t, a1)
+	requireWoken(t, a2)
+	requireNotWoken(t, b)
+	require.Len(t, w.waiters, 1)
+
+	// Stopping after being woken is safe
+	stopA1()
+	stopA2()
+
+	// A stopped watcher isn't woken, and is forgotten
+	stopB()
+	require.Empty(t, w.waiters)
+	w.changed("b")
+	requireNotWoken(t, b)
+
+	// A nil watcher never wakes
+	var nw *statusWatcher
+	c, stop := nw.watch("a")
+	nw.changed("a")
+	require.Nil(t, c)
+	stop()
+}
+
+func TestExchangeWatchDepositStatuses(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	e := newTestExchange(t, log, db)
+	defer closeMultiplexer(e)
+
+	skyAddr := "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+
+	// Binding changes the statuses
+	c, stop := e.WatchDepositStatuses(skyAddr)
+	require.NoError(t, e.BindAddress(skyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	requireWoken(t, c)
+	stop()
+
+	// So does a deposit's transition
+	c, stop = e.WatchDepositStatuses(skyAddr)
+	other, stopOther := e.WatchDepositStatuses("23jVqPuKNZuGJuXbb1aRT3fUiAGY3AQbmTQ")
+	defer stopOther()
+
+	_, err := e.store.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr1",
+		Amount:   1e6,
+		Height:   20,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+	requireWoken(t, c)
+	requireNotWoken(t, other)
+	stop()
+
+	// And adding or cancelling a binding
+	c, stop = e.WatchDepositStatuses(skyAddr)
+	defer stop()
+	require.NoError(t, e.BindAddress(skyAddr, "btcaddr2", scanner.CoinTypeBTC, ""))
+	requireWoken(t, c)
+
+	c, stop = e.WatchDepositStatuses(skyAddr)
+	defer stop()
+	require.NoError(t, e.CancelBinding(skyAddr, "btcaddr2", scanner.CoinTypeBTC, false))
+	requireWoken(t, c)
+}
@@ -10,12 +10,14 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/sirupsen/logrus"
 
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/errutil"
 )
 
 var (
-	// ExchangeMetaBkt stores metadata about the exchange (unused)
+	// ExchangeMetaBkt stores metadata about the exchange, the run state marker of runstate.go
 	ExchangeMetaBkt = []byte("exchange_meta")
 
 	// DepositInfoBkt maps a BTC transaction to a DepositInfo
@@ -24,6 +26,9 @@ var (
 	// BindAddressBkt maps a BTC address to a SKY address
 	BindAddressBkt = []byte("bind_address")
 
+	// RefundAddressBkt maps a deposit address to the refund address given when it was bound
+	RefundAddressBkt = []byte("refund_address")
+
 	// BtcTxsBkt maps a BTC address to multiple BTC transactions
 	BtcTxsBkt = []byte("btc_txs")
 
@@ -31,26 +36,50 @@ var (
 	SkyDepositSeqsIndexBkt = []byte("sky_deposit_seqs_index")
 
 	// ErrAddressAlreadyBound is returned if an address has already been bound to a SKY address
-	ErrAddressAlreadyBound = errors.New("Address already bound to a SKY address")
+	ErrAddressAlreadyBound = errutil.New(errutil.Conflict, "Address already bound to a SKY address")
 )
 
 // Storer interface for exchange storage
 type Storer interface {
 	GetBindAddress(depositAddr, coinType string) (string, error)
-	BindAddress(skyAddr, depositAddr, coinType string) error
-	GetOrCreateDepositInfo(scanner.Deposit, string) (DepositInfo, error)
+	BindAddress(skyAddr, depositAddr, coinType, refundAddr string) error
+	GetOrCreateDepositInfo(deposits.Deposit, string) (DepositInfo, error)
+	GetOrCreateQuotedDepositInfo(deposits.Deposit, string, *ConversionQuote, *DisplayValue) (DepositInfo, error)
 	GetDepositInfoArray(DepositFilter) ([]DepositInfo, error)
+	ForEachDepositInfo(DepositFilter, func(DepositInfo) error) error
+	QueryDepositInfos(DepositQuery, func(DepositInfo) error) error
 	GetDepositInfoOfSkyAddress(string) ([]DepositInfo, error)
 	UpdateDepositInfo(string, func(DepositInfo) DepositInfo) (DepositInfo, error)
 	UpdateDepositInfoCallback(string, func(DepositInfo) DepositInfo, func(DepositInfo) error) (DepositInfo, error)
+	UpdateDepositInfoVersion(string, uint64, func(DepositInfo) DepositInfo, func(DepositInfo) error) (DepositInfo, error)
+	CommitSend(string, func(DepositInfo) DepositInfo, OutboxEntry) (DepositInfo, error)
+	GetOutboxEntry(string) (OutboxEntry, bool, error)
+	MarkBroadcast(string) error
+	GetSendKey(string) (string, bool, error)
+	RecordRate(string, string, int, time.Time) (bool, error)
+	GetRateHistory(string) ([]RateChange, error)
 	GetSkyBindAddresses(string) ([]string, error)
+	CancelBinding(string, string, string, uint64, bool, time.Time) (CancelledBinding, error)
 	GetDepositStats() (int64, int64, error)
+	GetLedgerBalances() (LedgerBalances, error)
+	GetCampaignStats() (CampaignStats, error)
+	CheckLedger() error
+	AppendPayoutLog(DepositInfo, []byte) (bool, error)
+	GetPayoutLog(uint64, int) ([]PayoutLogEntry, error)
+	GetAuditLogPage(uint64, int) (AuditLogPage, error)
+	LookupOwners(string, string) ([]Owner, error)
+	GetDepositRecord(string) (DepositRecord, error)
+	Snapshot(string) (*Snapshot, error)
+	StateMachine() *StateMachine
 }
 
 // Store storage for exchange
 type Store struct {
-	db  *bolt.DB
-	log logrus.FieldLogger
+	db     *bolt.DB
+	log    logrus.FieldLogger
+	states *StateMachine
+	// startup is the report of StartRun, see runstate.go
+	startup StartupReport
 }
 
 // NewStore creates a Store instance
@@ -59,6 +88,10 @@ func NewStore(log logrus.FieldLogger, db *bolt.DB) (*Store, error) {
 		return nil, errors.New("new exchange Store failed, db is nil")
 	}
 
+	if db.IsReadOnly() {
+		return newReadOnlyStore(log, db)
+	}
+
 	if err := db.Update(func(tx *bolt.Tx) error {
 		// create exchange meta bucket if not exist
 		if _, err := tx.CreateBucketIfNotExists(ExchangeMetaBkt); err != nil {
@@ -70,14 +103,18 @@ func NewStore(log logrus.FieldLogger, db *bolt.DB) (*Store, error) {
 			return dbutil.NewCreateBucketFailedErr(DepositInfoBkt, err)
 		}
 
-		// create bind address bucket if not exist
-		btcBktFullName := dbutil.ByteJoin(BindAddressBkt, scanner.CoinTypeBTC, "_")
-		if _, err := tx.CreateBucketIfNotExists(btcBktFullName); err != nil {
-			return dbutil.NewCreateBucketFailedErr(btcBktFullName, err)
+		// create the bind and refund address buckets of the coin types if not exist
+		for _, coinType := range deposits.CoinTypes() {
+			for _, bkt := range [][]byte{BindAddressBkt, RefundAddressBkt} {
+				bktFullName := dbutil.ByteJoin(bkt, coinType, "_")
+				if _, err := tx.CreateBucketIfNotExists(bktFullName); err != nil {
+					return dbutil.NewCreateBucketFailedErr(bktFullName, err)
+				}
+			}
 		}
-		ethBktFullName := dbutil.ByteJoin(BindAddressBkt, scanner.CoinTypeETH, "_")
-		if _, err := tx.CreateBucketIfNotExists(ethBktFullName); err != nil {
-			return dbutil.NewCreateBucketFailedErr(ethBktFullName, err)
+
+		if err := createBindVersionBktsTx(tx); err != nil {
+			return err
 		}
 
 		if _, err := tx.CreateBucketIfNotExists(SkyDepositSeqsIndexBkt); err != nil {
@@ -88,16 +125,112 @@ func NewStore(log logrus.FieldLogger, db *bolt.DB) (*Store, error) {
 			return dbutil.NewCreateBucketFailedErr(BtcTxsBkt, err)
 		}
 
+		if _, err := tx.CreateBucketIfNotExists(LedgerBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(LedgerBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(LedgerBalanceBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(LedgerBalanceBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(SendOutboxBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(SendOutboxBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(SendKeyBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(SendKeyBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(RateHistoryBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(RateHistoryBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(CancelledBindingBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(CancelledBindingBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(PayoutLogBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(PayoutLogBkt, err)
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(PayoutLogIndexBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(PayoutLogIndexBkt, err)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	s := &Store{
+		db:     db,
+		log:    log.WithField("prefix", "exchange.Store"),
+		states: NewStateMachine(),
+	}
+
+	// Record deposits saved before the ledger was added
+	if err := db.Update(s.initLedgerTx); err != nil {
+		return nil, err
+	}
+
+	// Save the idempotency keys of the transactions saved before the keys were added
+	if err := db.Update(initSendKeysTx); err != nil {
+		return nil, err
+	}
+
+	// Index the deposits saved before the indexes were added
+	if err := db.Update(initDepositIndexesTx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// newReadOnlyStore creates a Store on a db opened read only, such as an archived snapshot.
+// The buckets can't be created, so the db must have been opened read-write by this version of teller.
+func newReadOnlyStore(log logrus.FieldLogger, db *bolt.DB) (*Store, error) {
+	bkts := [][]byte{
+		ExchangeMetaBkt,
+		DepositInfoBkt,
+		SkyDepositSeqsIndexBkt,
+		BtcTxsBkt,
+		LedgerBkt,
+		LedgerBalanceBkt,
+		SendOutboxBkt,
+		SendKeyBkt,
+		RateHistoryBkt,
+		CancelledBindingBkt,
+		PayoutLogBkt,
+		PayoutLogIndexBkt,
+		DepositTxidIndexBkt,
+		DepositStatusIndexBkt,
+		DepositUpdatedIndexBkt,
+	}
+	for _, coinType := range deposits.CoinTypes() {
+		bkts = append(bkts, dbutil.ByteJoin(BindAddressBkt, coinType, "_"), dbutil.ByteJoin(RefundAddressBkt, coinType, "_"))
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		for _, bkt := range bkts {
+			if tx.Bucket(bkt) == nil {
+				return dbutil.NewBucketNotExistErr(bkt)
+			}
+		}
 		return nil
 	}); err != nil {
 		return nil, err
 	}
 
 	return &Store{
-		db:  db,
-		log: log.WithField("prefix", "exchange.Store"),
+		db:     db,
+		log:    log.WithField("prefix", "exchange.Store"),
+		states: NewStateMachine(),
 	}, nil
+}
 
+// StateMachine returns the StateMachine which checks the store's deposit transitions
+func (s *Store) StateMachine() *StateMachine {
+	return s.states
 }
 
 // GetBindAddress returns bound skycoin address of given bitcoin address.
@@ -127,49 +260,106 @@ func (s *Store) getBindAddressTx(tx *bolt.Tx, depositAddr, coinType string) (str
 	}
 }
 
-// BindAddress binds a skycoin address to a deposit address
-func (s *Store) BindAddress(skyAddr, depositAddr, coinType string) error {
-	log := s.log.WithField("skyAddr", skyAddr)
-	log = log.WithField("depositAddr", depositAddr)
-	return s.db.Update(func(tx *bolt.Tx) error {
-		existingSkyAddr, err := s.getBindAddressTx(tx, depositAddr, coinType)
+// getBindCoinTypeTx returns the coin type of a deposit address bound to skyAddr,
+// or an empty string if it isn't bound
+func (s *Store) getBindCoinTypeTx(tx *bolt.Tx, skyAddr, depositAddr string) (string, error) {
+	for _, coinType := range deposits.CoinTypes() {
+		boundAddr, err := s.getBindAddressTx(tx, depositAddr, coinType)
 		if err != nil {
-			return err
+			return "", err
 		}
 
-		if existingSkyAddr != "" {
-			err := ErrAddressAlreadyBound
-			log.WithError(err).Error("Attempted to bind an address twice")
-			return err
+		if boundAddr == skyAddr {
+			return coinType, nil
 		}
+	}
 
-		// update index of skycoin address and the deposit seq
-		var addrs []string
-		if err := dbutil.GetBucketObject(tx, SkyDepositSeqsIndexBkt, skyAddr, &addrs); err != nil {
-			switch err.(type) {
-			case dbutil.ObjectNotExistErr:
-			default:
-				return err
-			}
+	return "", nil
+}
+
+// getRefundAddressTx returns the refund address of a deposit address, or an empty string if it has none
+func (s *Store) getRefundAddressTx(tx *bolt.Tx, depositAddr, coinType string) (string, error) {
+	refundAddr, err := dbutil.GetBucketString(tx, dbutil.ByteJoin(RefundAddressBkt, coinType, "_"), depositAddr)
+	switch err.(type) {
+	case nil:
+		return refundAddr, nil
+	case dbutil.ObjectNotExistErr:
+		return "", nil
+	default:
+		return "", err
+	}
+}
+
+// BindAddress binds a skycoin address to a deposit address, with an optional refund address
+func (s *Store) BindAddress(skyAddr, depositAddr, coinType, refundAddr string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.bindAddressTx(tx, skyAddr, depositAddr, coinType, refundAddr)
+	})
+}
+
+func (s *Store) bindAddressTx(tx *bolt.Tx, skyAddr, depositAddr, coinType, refundAddr string) error {
+	log := s.log.WithField("skyAddr", skyAddr)
+	log = log.WithField("depositAddr", depositAddr)
+
+	existingSkyAddr, err := s.getBindAddressTx(tx, depositAddr, coinType)
+	if err != nil {
+		return err
+	}
+
+	if existingSkyAddr != "" {
+		err := ErrAddressAlreadyBound
+		log.WithError(err).Error("Attempted to bind an address twice")
+		return err
+	}
+
+	// update index of skycoin address and the deposit seq
+	var addrs []string
+	if err := dbutil.GetBucketObject(tx, SkyDepositSeqsIndexBkt, skyAddr, &addrs); err != nil {
+		switch err.(type) {
+		case dbutil.ObjectNotExistErr:
+		default:
+			return err
 		}
+	}
+
+	addrs = append(addrs, depositAddr)
+	if err := dbutil.PutBucketValue(tx, SkyDepositSeqsIndexBkt, skyAddr, addrs); err != nil {
+		return err
+	}
 
-		addrs = append(addrs, depositAddr)
-		if err := dbutil.PutBucketValue(tx, SkyDepositSeqsIndexBkt, skyAddr, addrs); err != nil {
+	// A reused deposit address doesn't keep the refund address of its last binding
+	refundBktFullName := dbutil.ByteJoin(RefundAddressBkt, coinType, "_")
+	if refundAddr == "" {
+		if err := tx.Bucket(refundBktFullName).Delete([]byte(depositAddr)); err != nil {
 			return err
 		}
+	} else if err := dbutil.PutBucketValue(tx, refundBktFullName, depositAddr, refundAddr); err != nil {
+		return err
+	}
 
-		bindBktFullName := dbutil.ByteJoin(BindAddressBkt, coinType, "_")
-		return dbutil.PutBucketValue(tx, bindBktFullName, depositAddr, skyAddr)
-	})
+	if err := s.incrBindVersionTx(tx, depositAddr, coinType); err != nil {
+		return err
+	}
+
+	bindBktFullName := dbutil.ByteJoin(BindAddressBkt, coinType, "_")
+	return dbutil.PutBucketValue(tx, bindBktFullName, depositAddr, skyAddr)
 }
 
 // GetOrCreateDepositInfo creates a DepositInfo unless one exists with the DepositInfo.DepositID key,
 // in which case it returns the existing DepositInfo.
-func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (DepositInfo, error) {
+func (s *Store) GetOrCreateDepositInfo(dv deposits.Deposit, rate string) (DepositInfo, error) {
+	return s.GetOrCreateQuotedDepositInfo(dv, rate, nil, nil)
+}
+
+// GetOrCreateQuotedDepositInfo is GetOrCreateDepositInfo, saving the conversion quote the rate was calculated from
+// and the display value with a new DepositInfo. quote is nil if the rate wasn't priced in the quote currency,
+// and display is nil if the deposit has no display value.
+func (s *Store) GetOrCreateQuotedDepositInfo(dv deposits.Deposit, rate string, quote *ConversionQuote, display *DisplayValue) (DepositInfo, error) {
 	log := s.log.WithField("deposit", dv)
 	log = log.WithField("rate", rate)
 
 	var finalDepositInfo DepositInfo
+	var created bool
 	if err := s.db.Update(func(tx *bolt.Tx) error {
 		di, err := s.getDepositInfoTx(tx, dv.ID())
 
@@ -187,6 +377,16 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 				return err
 			}
 
+			if skyAddr == "" {
+				// A deposit to the address of a cancelled binding is sent to the cancelled binding's skycoin address
+				skyAddr, err = s.restoreCancelledBindingTx(tx, dv.Address, dv.CoinType)
+				if err != nil {
+					err = fmt.Errorf("restoreCancelledBindingTx failed: %v", err)
+					log.WithError(err).Error(err)
+					return err
+				}
+			}
+
 			if skyAddr == "" {
 				err = ErrNoBoundAddress
 				log.WithError(err).Error(err)
@@ -195,15 +395,25 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 
 			log = log.WithField("skyAddr", skyAddr)
 
+			refundAddr, err := s.getRefundAddressTx(tx, dv.Address, dv.CoinType)
+			if err != nil {
+				err = fmt.Errorf("getRefundAddressTx failed: %v", err)
+				log.WithError(err).Error(err)
+				return err
+			}
+
 			di := DepositInfo{
 				CoinType:       dv.CoinType,
 				SkyAddress:     skyAddr,
 				DepositAddress: dv.Address,
+				RefundAddress:  refundAddr,
 				DepositID:      dv.ID(),
 				Status:         StatusWaitSend,
-				DepositValue:   dv.Value,
+				DepositValue:   dv.Amount,
 				// Save the rate at the time this deposit was noticed
 				ConversionRate: rate,
+				Quote:          quote,
+				Display:        display,
 				Deposit:        dv,
 			}
 
@@ -217,6 +427,7 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 			}
 
 			finalDepositInfo = updatedDi
+			created = true
 
 			return nil
 
@@ -229,8 +440,11 @@ func (s *Store) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (Deposit
 		return DepositInfo{}, err
 	}
 
-	return finalDepositInfo, nil
+	if created {
+		s.states.committed(newDepositTransition(finalDepositInfo))
+	}
 
+	return finalDepositInfo, nil
 }
 
 // addDepositInfo adds deposit info into storage, return seq or error
@@ -244,9 +458,21 @@ func (s *Store) addDepositInfo(di DepositInfo) (DepositInfo, error) {
 		return di, err
 	}
 
+	s.states.committed(newDepositTransition(updatedDi))
+
 	return updatedDi, nil
 }
 
+// newDepositTransition returns the transition of a new deposit from StatusWaitDeposit
+func newDepositTransition(di DepositInfo) Transition {
+	from := di
+	from.Status = StatusWaitDeposit
+	return Transition{
+		From: from,
+		To:   di,
+	}
+}
+
 // addDepositInfoTx adds deposit info into storage, return seq or error
 func (s *Store) addDepositInfoTx(tx *bolt.Tx, di DepositInfo) (DepositInfo, error) {
 	log := s.log.WithField("depositInfo", di)
@@ -265,6 +491,7 @@ func (s *Store) addDepositInfoTx(tx *bolt.Tx, di DepositInfo) (DepositInfo, erro
 
 	updatedDi := di
 	updatedDi.Seq = seq
+	updatedDi.Version = 1
 	updatedDi.UpdatedAt = time.Now().UTC().Unix()
 
 	if err := updatedDi.ValidateForStatus(); err != nil {
@@ -276,6 +503,14 @@ func (s *Store) addDepositInfoTx(tx *bolt.Tx, di DepositInfo) (DepositInfo, erro
 		return di, err
 	}
 
+	if err := indexDepositInfoTx(tx, DepositInfo{}, updatedDi); err != nil {
+		return di, err
+	}
+
+	if err := s.postLedgerTransitionTx(tx, DepositInfo{}, updatedDi); err != nil {
+		return di, err
+	}
+
 	// update btc_txids bucket
 	var txs []string
 	if err := dbutil.GetBucketObject(tx, BtcTxsBkt, updatedDi.DepositAddress, &txs); err != nil {
@@ -291,6 +526,10 @@ func (s *Store) addDepositInfoTx(tx *bolt.Tx, di DepositInfo) (DepositInfo, erro
 		return di, err
 	}
 
+	if err := s.states.making(tx, newDepositTransition(updatedDi)); err != nil {
+		return di, err
+	}
+
 	return updatedDi, nil
 }
 
@@ -322,19 +561,9 @@ func (s *Store) getDepositInfoTx(tx *bolt.Tx, btcTx string) (DepositInfo, error)
 func (s *Store) GetDepositInfoArray(flt DepositFilter) ([]DepositInfo, error) {
 	var dpis []DepositInfo
 
-	if err := s.db.View(func(tx *bolt.Tx) error {
-		return dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
-			var dpi DepositInfo
-			if err := json.Unmarshal(v, &dpi); err != nil {
-				return err
-			}
-
-			if flt(dpi) {
-				dpis = append(dpis, dpi)
-			}
-
-			return nil
-		})
+	if err := s.ForEachDepositInfo(flt, func(dpi DepositInfo) error {
+		dpis = append(dpis, dpi)
+		return nil
 	}); err != nil {
 		return nil, err
 	}
@@ -342,6 +571,28 @@ func (s *Store) GetDepositInfoArray(flt DepositFilter) ([]DepositInfo, error) {
 	return dpis, nil
 }
 
+// ForEachDepositInfo calls f with each filtered deposit info, without loading them all at once
+func (s *Store) ForEachDepositInfo(flt DepositFilter, f func(DepositInfo) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return forEachDepositInfoTx(tx, flt, f)
+	})
+}
+
+func forEachDepositInfoTx(tx *bolt.Tx, flt DepositFilter, f func(DepositInfo) error) error {
+	return dbutil.ForEach(tx, DepositInfoBkt, func(k, v []byte) error {
+		var dpi DepositInfo
+		if err := json.Unmarshal(v, &dpi); err != nil {
+			return err
+		}
+
+		if !flt(dpi) {
+			return nil
+		}
+
+		return f(dpi)
+	})
+}
+
 // GetDepositInfoOfSkyAddress returns all deposit info that are bound
 // to the given skycoin address
 func (s *Store) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, error) {
@@ -368,8 +619,14 @@ func (s *Store) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, error
 			// has not sent a deposit to the exchange, so the status is
 			// StatusWaitDeposit.
 			if len(txns) == 0 {
+				coinType, err := s.getBindCoinTypeTx(tx, skyAddr, depositAddr)
+				if err != nil {
+					return err
+				}
+
 				dpis = append(dpis, DepositInfo{
 					Status:         StatusWaitDeposit,
+					CoinType:       coinType,
 					DepositAddress: depositAddr,
 					SkyAddress:     skyAddr,
 					UpdatedAt:      time.Now().UTC().Unix(),
@@ -415,36 +672,60 @@ func (s *Store) UpdateDepositInfo(btcTx string, update func(DepositInfo) Deposit
 // inside of the transaction.  If the callback returns an error, the DepositInfo update
 // is rolled back.
 func (s *Store) UpdateDepositInfoCallback(btcTx string, update func(DepositInfo) DepositInfo, callback func(DepositInfo) error) (DepositInfo, error) {
+	return s.UpdateDepositInfoVersion(btcTx, 0, update, callback)
+}
+
+// updateDepositInfoTx updates deposit info, posts its ledger transition and calls the tx hooks with it, in a bolt.Tx.
+// The update is rejected with an InvalidTransitionErr if its status change isn't a valid transition.
+// The caller must pass the returned Transition to s.states.committed once the bolt.Tx is committed.
+func (s *Store) updateDepositInfoTx(tx *bolt.Tx, btcTx string, update func(DepositInfo) DepositInfo) (Transition, error) {
 	log := s.log.WithField("btcTx", btcTx)
 
 	var dpi DepositInfo
-	if err := s.db.Update(func(tx *bolt.Tx) error {
-		if err := dbutil.GetBucketObject(tx, DepositInfoBkt, btcTx, &dpi); err != nil {
-			return err
-		}
+	if err := dbutil.GetBucketObject(tx, DepositInfoBkt, btcTx, &dpi); err != nil {
+		return Transition{}, err
+	}
 
-		log = log.WithField("depositInfo", dpi)
+	log = log.WithField("depositInfo", dpi)
 
-		if dpi.DepositID != btcTx {
-			log.Error("DepositInfo.DepositID does not match btcTx")
-			err := fmt.Errorf("DepositInfo %+v saved under different key %s", dpi, btcTx)
-			return err
-		}
+	if dpi.DepositID != btcTx {
+		log.Error("DepositInfo.DepositID does not match btcTx")
+		err := fmt.Errorf("DepositInfo %+v saved under different key %s", dpi, btcTx)
+		return Transition{}, err
+	}
 
-		dpi = update(dpi)
-		dpi.UpdatedAt = time.Now().UTC().Unix()
+	before := dpi
+	dpi = update(dpi)
+	dpi.Version = before.Version + 1
+	dpi.UpdatedAt = time.Now().UTC().Unix()
 
-		if err := dbutil.PutBucketValue(tx, DepositInfoBkt, btcTx, dpi); err != nil {
-			return err
-		}
+	t := Transition{
+		From: before,
+		To:   dpi,
+	}
 
-		return callback(dpi)
+	if err := s.states.Check(t); err != nil {
+		log.WithError(err).Error("DepositInfo update rejected")
+		return Transition{}, err
+	}
 
-	}); err != nil {
-		return DepositInfo{}, err
+	if err := dbutil.PutBucketValue(tx, DepositInfoBkt, btcTx, dpi); err != nil {
+		return Transition{}, err
 	}
 
-	return dpi, nil
+	if err := indexDepositInfoTx(tx, before, dpi); err != nil {
+		return Transition{}, err
+	}
+
+	if err := s.postLedgerTransitionTx(tx, before, dpi); err != nil {
+		return Transition{}, err
+	}
+
+	if err := s.states.making(tx, t); err != nil {
+		return Transition{}, err
+	}
+
+	return t, nil
 }
 
 // GetSkyBindAddresses returns the addresses of the given sky address bound
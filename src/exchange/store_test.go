@@ -1,12 +1,15 @@
 package exchange
 
 import (
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/scanner"
 	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/testutil"
@@ -21,16 +24,21 @@ func (m *MockStore) GetBindAddress(btcAddr, coinType string) (string, error) {
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockStore) BindAddress(skyAddr, btcAddr, coinType string) error {
-	args := m.Called(skyAddr, btcAddr, coinType)
+func (m *MockStore) BindAddress(skyAddr, btcAddr, coinType, refundAddr string) error {
+	args := m.Called(skyAddr, btcAddr, coinType, refundAddr)
 	return args.Error(0)
 }
 
-func (m *MockStore) GetOrCreateDepositInfo(dv scanner.Deposit, rate string) (DepositInfo, error) {
+func (m *MockStore) GetOrCreateDepositInfo(dv deposits.Deposit, rate string) (DepositInfo, error) {
 	args := m.Called(dv, rate)
 	return args.Get(0).(DepositInfo), args.Error(1)
 }
 
+func (m *MockStore) GetOrCreateQuotedDepositInfo(dv deposits.Deposit, rate string, quote *ConversionQuote, display *DisplayValue) (DepositInfo, error) {
+	args := m.Called(dv, rate, quote, display)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
 func (m *MockStore) GetDepositInfoArray(filt DepositFilter) ([]DepositInfo, error) {
 	args := m.Called(filt)
 
@@ -42,6 +50,16 @@ func (m *MockStore) GetDepositInfoArray(filt DepositFilter) ([]DepositInfo, erro
 	return dis.([]DepositInfo), args.Error(1)
 }
 
+func (m *MockStore) ForEachDepositInfo(filt DepositFilter, f func(DepositInfo) error) error {
+	args := m.Called(filt, f)
+	return args.Error(0)
+}
+
+func (m *MockStore) QueryDepositInfos(q DepositQuery, f func(DepositInfo) error) error {
+	args := m.Called(q, f)
+	return args.Error(0)
+}
+
 func (m *MockStore) GetDepositInfoOfSkyAddress(skyAddr string) ([]DepositInfo, error) {
 	args := m.Called(skyAddr)
 
@@ -63,6 +81,93 @@ func (m *MockStore) UpdateDepositInfoCallback(btcTx string, f func(DepositInfo)
 	return args.Get(0).(DepositInfo), args.Error(1)
 }
 
+func (m *MockStore) UpdateDepositInfoVersion(btcTx string, version uint64, f func(DepositInfo) DepositInfo, callback func(DepositInfo) error) (DepositInfo, error) {
+	args := m.Called(btcTx, version, f, callback)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) AppendPayoutLog(di DepositInfo, salt []byte) (bool, error) {
+	args := m.Called(di, salt)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStore) GetPayoutLog(since uint64, limit int) ([]PayoutLogEntry, error) {
+	args := m.Called(since, limit)
+
+	entries := args.Get(0)
+	if entries == nil {
+		return nil, args.Error(1)
+	}
+
+	return entries.([]PayoutLogEntry), args.Error(1)
+}
+
+func (m *MockStore) GetAuditLogPage(cursor uint64, limit int) (AuditLogPage, error) {
+	args := m.Called(cursor, limit)
+	return args.Get(0).(AuditLogPage), args.Error(1)
+}
+
+func (m *MockStore) LookupOwners(depositAddr, txid string) ([]Owner, error) {
+	args := m.Called(depositAddr, txid)
+
+	owners := args.Get(0)
+	if owners == nil {
+		return nil, args.Error(1)
+	}
+
+	return owners.([]Owner), args.Error(1)
+}
+
+func (m *MockStore) GetDepositRecord(depositID string) (DepositRecord, error) {
+	args := m.Called(depositID)
+	return args.Get(0).(DepositRecord), args.Error(1)
+}
+
+func (m *MockStore) StateMachine() *StateMachine {
+	return NewStateMachine()
+}
+
+func (m *MockStore) CommitSend(depositID string, f func(DepositInfo) DepositInfo, entry OutboxEntry) (DepositInfo, error) {
+	args := m.Called(depositID, f, entry)
+	return args.Get(0).(DepositInfo), args.Error(1)
+}
+
+func (m *MockStore) GetOutboxEntry(txid string) (OutboxEntry, bool, error) {
+	args := m.Called(txid)
+	return args.Get(0).(OutboxEntry), args.Bool(1), args.Error(2)
+}
+
+func (m *MockStore) MarkBroadcast(txid string) error {
+	args := m.Called(txid)
+	return args.Error(0)
+}
+
+func (m *MockStore) GetSendKey(key string) (string, bool, error) {
+	args := m.Called(key)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
+
+func (m *MockStore) RecordRate(coinType, rate string, maxDecimals int, t time.Time) (bool, error) {
+	args := m.Called(coinType, rate, maxDecimals, t)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStore) GetRateHistory(coinType string) ([]RateChange, error) {
+	args := m.Called(coinType)
+
+	rcs := args.Get(0)
+	if rcs == nil {
+		return nil, args.Error(1)
+	}
+
+	return rcs.([]RateChange), args.Error(1)
+}
+
+func (m *MockStore) CancelBinding(skyAddr, depositAddr, coinType string, version uint64, reused bool, t time.Time) (CancelledBinding, error) {
+	args := m.Called(skyAddr, depositAddr, coinType, version, reused, t)
+	return args.Get(0).(CancelledBinding), args.Error(1)
+}
+
 func (m *MockStore) GetSkyBindAddresses(skyAddr string) ([]string, error) {
 	args := m.Called(skyAddr)
 
@@ -79,6 +184,38 @@ func (m *MockStore) GetDepositStats() (int64, int64, error) {
 	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
 }
 
+func (m *MockStore) GetLedgerBalances() (LedgerBalances, error) {
+	args := m.Called()
+
+	b := args.Get(0)
+	if b == nil {
+		return nil, args.Error(1)
+	}
+
+	return b.(LedgerBalances), args.Error(1)
+}
+
+func (m *MockStore) GetCampaignStats() (CampaignStats, error) {
+	args := m.Called()
+	return args.Get(0).(CampaignStats), args.Error(1)
+}
+
+func (m *MockStore) CheckLedger() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockStore) Snapshot(dir string) (*Snapshot, error) {
+	args := m.Called(dir)
+
+	s := args.Get(0)
+	if s == nil {
+		return nil, args.Error(1)
+	}
+
+	return s.(*Snapshot), args.Error(1)
+}
+
 func newTestStore(t *testing.T) (*Store, func()) {
 	db, shutdown := testutil.PrepareDB(t)
 
@@ -101,11 +238,53 @@ func TestStoreNewStore(t *testing.T) {
 		require.NotNil(t, tx.Bucket(dbutil.ByteJoin(BindAddressBkt, scanner.CoinTypeETH, "_")))
 		require.NotNil(t, tx.Bucket(SkyDepositSeqsIndexBkt))
 		require.NotNil(t, tx.Bucket(BtcTxsBkt))
+		require.NotNil(t, tx.Bucket(SendOutboxBkt))
+		require.NotNil(t, tx.Bucket(RateHistoryBkt))
+		require.NotNil(t, tx.Bucket(CancelledBindingBkt))
+		require.NotNil(t, tx.Bucket(PayoutLogBkt))
+		require.NotNil(t, tx.Bucket(PayoutLogIndexBkt))
 		return nil
 	})
 	require.NoError(t, err)
 }
 
+func TestStoreNewStoreReadOnly(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	path := filepath.Join(t.TempDir(), "teller.db")
+
+	// A db which was never opened by teller has no buckets
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	_, err = NewStore(log, db)
+	require.Equal(t, dbutil.NewBucketNotExistErr(ExchangeMetaBkt), err)
+	require.NoError(t, db.Close())
+
+	db, err = bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+	require.NoError(t, s.BindAddress("skyaddr1", "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.NoError(t, db.Close())
+
+	db, err = bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	require.NoError(t, err)
+	defer db.Close()
+
+	s, err = NewStore(log, db)
+	require.NoError(t, err)
+
+	skyAddr, err := s.GetBindAddress("btcaddr1", scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "skyaddr1", skyAddr)
+
+	err = s.BindAddress("skyaddr2", "btcaddr2", scanner.CoinTypeBTC, "")
+	require.Equal(t, bolt.ErrDatabaseReadOnly, err)
+}
+
 func TestStoreAddDepositInfo(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
@@ -179,7 +358,7 @@ func TestStoreBindAddress(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
 
-	err := s.BindAddress("sa1", "ba1", scanner.CoinTypeBTC)
+	err := s.BindAddress("sa1", "ba1", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	// check bucket
@@ -198,7 +377,7 @@ func TestStoreBindAddress(t *testing.T) {
 	require.NoError(t, err)
 
 	// A sky address can have multiple addresses bound to it
-	err = s.BindAddress("sa1", "ba2", scanner.CoinTypeBTC)
+	err = s.BindAddress("sa1", "ba2", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 }
 
@@ -206,14 +385,14 @@ func TestStoreBindAddressTwiceFails(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
 
-	err := s.BindAddress("a", "b", scanner.CoinTypeBTC)
+	err := s.BindAddress("a", "b", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
-	err = s.BindAddress("a", "b", scanner.CoinTypeBTC)
+	err = s.BindAddress("a", "b", scanner.CoinTypeBTC, "")
 	require.Error(t, err)
 	require.Equal(t, ErrAddressAlreadyBound, err)
 
-	err = s.BindAddress("c", "b", scanner.CoinTypeBTC)
+	err = s.BindAddress("c", "b", scanner.CoinTypeBTC, "")
 	require.Error(t, err)
 	require.Equal(t, ErrAddressAlreadyBound, err)
 }
@@ -223,11 +402,11 @@ func TestStoreGetBindAddress(t *testing.T) {
 	defer shutdown()
 
 	// init the bind address bucket
-	err := s.BindAddress("skyaddr1", "btcaddr1", scanner.CoinTypeBTC)
+	err := s.BindAddress("skyaddr1", "btcaddr1", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
-	err = s.BindAddress("skyaddr2", "btcaddr2", scanner.CoinTypeBTC)
+	err = s.BindAddress("skyaddr2", "btcaddr2", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
-	err = s.BindAddress("skyaddr2", "btcaddr3", scanner.CoinTypeBTC)
+	err = s.BindAddress("skyaddr2", "btcaddr3", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	var testCases = []struct {
@@ -386,7 +565,7 @@ func TestStoreGetDepositInfoOfSkyAddress(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
 
-	err := s.BindAddress("skyaddr1", "btcaddr1", scanner.CoinTypeBTC)
+	err := s.BindAddress("skyaddr1", "btcaddr1", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	dpis, err := s.GetDepositInfoOfSkyAddress("skyaddr1")
@@ -394,7 +573,7 @@ func TestStoreGetDepositInfoOfSkyAddress(t *testing.T) {
 	require.Len(t, dpis, 1)
 	require.Equal(t, dpis[0].DepositAddress, "btcaddr1")
 
-	err = s.BindAddress("skyaddr1", "btcaddr2", scanner.CoinTypeBTC)
+	err = s.BindAddress("skyaddr1", "btcaddr2", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	dpis, err = s.GetDepositInfoOfSkyAddress("skyaddr1")
@@ -403,6 +582,22 @@ func TestStoreGetDepositInfoOfSkyAddress(t *testing.T) {
 	require.Equal(t, dpis[0].DepositAddress, "btcaddr1")
 	require.Equal(t, dpis[1].DepositAddress, "btcaddr2")
 
+	// The coin type of addresses waiting for a deposit is looked up from the binding
+	err = s.BindAddress("skyaddr1", "ethaddr1", scanner.CoinTypeETH, "")
+	require.NoError(t, err)
+
+	dpis, err = s.GetDepositInfoOfSkyAddress("skyaddr1")
+	require.NoError(t, err)
+	require.Len(t, dpis, 3)
+	for _, dpi := range dpis {
+		require.Equal(t, StatusWaitDeposit, dpi.Status)
+		if dpi.DepositAddress == "ethaddr1" {
+			require.Equal(t, scanner.CoinTypeETH, dpi.CoinType)
+		} else {
+			require.Equal(t, scanner.CoinTypeBTC, dpi.CoinType)
+		}
+	}
+
 	// Multiple txns saved
 	di3 := DepositInfo{
 		SkyAddress:     "skyaddr3",
@@ -416,9 +611,9 @@ func TestStoreGetDepositInfoOfSkyAddress(t *testing.T) {
 	require.Equal(t, di3.Seq, uint64(1))
 	require.NoError(t, err)
 
-	err = s.BindAddress("skyaddr3", "btcaddr3", scanner.CoinTypeBTC)
+	err = s.BindAddress("skyaddr3", "btcaddr3", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
-	err = s.BindAddress("skyaddr3", "btcaddr4", scanner.CoinTypeBTC)
+	err = s.BindAddress("skyaddr3", "btcaddr4", scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	di4 := DepositInfo{
@@ -498,51 +693,6 @@ func TestStoreGetDepositInfoArray(t *testing.T) {
 	require.Equal(t, dpis[1].SkyAddress, ds1[0].SkyAddress)
 }
 
-func TestStoreIsValidBtcTx(t *testing.T) {
-	cases := []struct {
-		name  string
-		valid bool
-		btctx string
-	}{
-		{
-			"empty string",
-			false,
-			"",
-		},
-		{
-			"colon only",
-			false,
-			":",
-		},
-		{
-			"multiple colons",
-			false,
-			"txid:2:2",
-		},
-		{
-			"no txid",
-			false,
-			":2",
-		},
-		{
-			"no n",
-			false,
-			"txid:",
-		},
-		{
-			"n not int",
-			false,
-			"txid:b",
-		},
-	}
-
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			require.Equal(t, tc.valid, isValidBtcTx(tc.btctx))
-		})
-	}
-}
-
 func TestStoreGetOrCreateDepositInfoAlreadyExists(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
@@ -555,13 +705,14 @@ func TestStoreGetOrCreateDepositInfoAlreadyExists(t *testing.T) {
 		SkyAddress:     "foo-sky-addr",
 		DepositValue:   1e6,
 		ConversionRate: testSkyBtcRate,
-		Deposit: scanner.Deposit{
+		Deposit: deposits.Deposit{
 			CoinType: scanner.CoinTypeBTC,
 			Address:  "foo-btc-addr",
-			Value:    1e6,
+			Amount:   1e6,
 			Height:   20,
 			Tx:       "foo-tx",
 			N:        1,
+			Final:    true,
 		},
 	}
 
@@ -571,23 +722,26 @@ func TestStoreGetOrCreateDepositInfoAlreadyExists(t *testing.T) {
 	// Check the saved deposit info
 	foundDi, err := s.getDepositInfo(di.DepositID)
 	require.NoError(t, err)
-	// Seq and UpdatedAt should be set by addDepositInfo
+	// Seq, Version and UpdatedAt should be set by addDepositInfo
 	require.Equal(t, uint64(1), foundDi.Seq)
+	require.Equal(t, uint64(1), foundDi.Version)
 	require.NotEmpty(t, foundDi.UpdatedAt)
 
 	// Other fields should be unchanged
 	di.Seq = foundDi.Seq
+	di.Version = foundDi.Version
 	di.UpdatedAt = foundDi.UpdatedAt
 	require.Equal(t, di, foundDi)
 
 	// GetOrCreateDepositInfo, deposit info exists
-	dv := scanner.Deposit{
+	dv := deposits.Deposit{
 		CoinType: scanner.CoinTypeBTC,
 		Address:  di.Deposit.Address + "-2",
-		Value:    di.Deposit.Value * 2,
+		Amount:   di.Deposit.Amount * 2,
 		Height:   di.Deposit.Height + 1,
 		Tx:       di.Deposit.Tx,
 		N:        di.Deposit.N,
+		Final:    true,
 	}
 	require.Equal(t, di.Deposit.ID(), dv.ID())
 
@@ -603,7 +757,7 @@ func TestStoreGetOrCreateDepositInfoNoBoundSkyAddr(t *testing.T) {
 	s, shutdown := newTestStore(t)
 	defer shutdown()
 
-	dv := scanner.Deposit{
+	dv := deposits.Deposit{
 		Address:  "foo-btc-addr",
 		CoinType: scanner.CoinTypeBTC,
 	}
@@ -624,7 +778,7 @@ func TestStoreGetSkyBindAddresses(t *testing.T) {
 	require.Nil(t, addrs)
 
 	btcAddr1 := "btcaddr1"
-	err = s.BindAddress(skyAddr, btcAddr1, scanner.CoinTypeBTC)
+	err = s.BindAddress(skyAddr, btcAddr1, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	addrs, err = s.GetSkyBindAddresses(skyAddr)
@@ -633,7 +787,7 @@ func TestStoreGetSkyBindAddresses(t *testing.T) {
 	require.Equal(t, addrs[0], btcAddr1)
 
 	btcAddr2 := "btcaddr2"
-	err = s.BindAddress(skyAddr, btcAddr2, scanner.CoinTypeBTC)
+	err = s.BindAddress(skyAddr, btcAddr2, scanner.CoinTypeBTC, "")
 	require.NoError(t, err)
 
 	addrs, err = s.GetSkyBindAddresses(skyAddr)
@@ -0,0 +1,185 @@
+package exchange
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Send throttle.
+// The deposits waiting to be sent are queued by skycoin address, and the queue is served
+// round robin, so that an address with many deposits doesn't hold up the other addresses.
+// At most SendsPerSecond sends are started per second, and at most MaxInFlight sent deposits
+// wait for their confirmation at a time, so that a backlog of deposits doesn't trip the
+// skycoin node's own limits. With a CoinHourGate, a send also waits until the hot wallet
+// has the coin hours to pay for it.
+//
+// A deposit is processed until its transaction is broadcast before the next deposit is taken
+// from the queue. A transaction created before the previous one is broadcast could spend the
+// same outputs, while the outputs spent by the node's unconfirmed transactions are not spendable.
+
+// SendThrottleConfig configures the throttling of skycoin sends
+type SendThrottleConfig struct {
+	SendsPerSecond float64 // Max sends started per second, 0 doesn't limit the rate
+	MaxInFlight    int     // Max sent deposits waiting for their confirmation, defaults to 1
+}
+
+// Validate returns an error if the send throttle config is invalid
+func (c SendThrottleConfig) Validate() error {
+	if c.SendsPerSecond < 0 {
+		return errors.New("SendsPerSecond can't be negative")
+	}
+
+	if c.MaxInFlight < 0 {
+		return errors.New("MaxInFlight can't be negative")
+	}
+
+	return nil
+}
+
+// sendQueue holds the deposits waiting to be sent, by skycoin address
+type sendQueue struct {
+	sync.Mutex
+	// skycoin addresses with queued deposits, in the order they are served
+	addrs    []string
+	deposits map[string][]DepositInfo
+	n        int
+	readyC   chan struct{}
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		deposits: make(map[string][]DepositInfo),
+		readyC:   make(chan struct{}, 1),
+	}
+}
+
+// push queues a deposit after the deposits of its skycoin address
+func (q *sendQueue) push(di DepositInfo) {
+	q.Lock()
+	defer q.Unlock()
+
+	if _, ok := q.deposits[di.SkyAddress]; !ok {
+		q.addrs = append(q.addrs, di.SkyAddress)
+	}
+	q.deposits[di.SkyAddress] = append(q.deposits[di.SkyAddress], di)
+	q.n++
+
+	select {
+	case q.readyC <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the oldest deposit of the next skycoin address, waiting for one to be queued.
+// It returns false if quit is closed while waiting.
+func (q *sendQueue) pop(quit <-chan struct{}) (DepositInfo, bool) {
+	for {
+		if di, ok := q.next(); ok {
+			return di, true
+		}
+
+		select {
+		case <-q.readyC:
+		case <-quit:
+			return DepositInfo{}, false
+		}
+	}
+}
+
+func (q *sendQueue) next() (DepositInfo, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.n == 0 {
+		return DepositInfo{}, false
+	}
+
+	addr := q.addrs[0]
+	dis := q.deposits[addr]
+	di := dis[0]
+
+	q.addrs = q.addrs[1:]
+	if len(dis) == 1 {
+		delete(q.deposits, addr)
+	} else {
+		q.deposits[addr] = dis[1:]
+		q.addrs = append(q.addrs, addr)
+	}
+	q.n--
+
+	return di, true
+}
+
+// Len returns the number of queued deposits
+func (q *sendQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.n
+}
+
+// runSends processes the deposits of the send queue, adding the goroutine of each deposit to wg
+func (s *Exchange) runSends(wg *sync.WaitGroup) {
+	log := s.log.WithField("goroutine", "sendSky")
+
+	var limiter *rate.Limiter
+	if s.cfg.SendThrottle.SendsPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(s.cfg.SendThrottle.SendsPerSecond), 1)
+	}
+
+	inFlight := make(chan struct{}, s.cfg.SendThrottle.MaxInFlight)
+
+	for {
+		di, ok := s.sendQueue.pop(s.quit)
+		if !ok {
+			log.Info("exchange.Exchange send loop quit")
+			return
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		case <-s.quit:
+			log.Info("exchange.Exchange send loop quit")
+			return
+		}
+
+		if s.hours != nil && !s.hours.Wait(s.sendQueue.Len()+1, s.quit) {
+			log.Info("exchange.Exchange send loop quit")
+			return
+		}
+
+		if limiter != nil {
+			r := limiter.Reserve()
+			select {
+			case <-time.After(r.Delay()):
+			case <-s.quit:
+				r.Cancel()
+				log.Info("exchange.Exchange send loop quit")
+				return
+			}
+		}
+
+		sentC := make(chan struct{})
+		wg.Add(1)
+		go func(di DepositInfo) {
+			defer wg.Done()
+			defer func() {
+				<-inFlight
+			}()
+
+			log := log.WithField("depositInfo", di)
+			if err := s.processWaitSendDeposit(di, sentC); err != nil {
+				log.WithError(err).Error("processWaitSendDeposit failed. This deposit will not be reprocessed until teller is restarted.")
+			}
+		}(di)
+
+		select {
+		case <-sentC:
+		case <-s.quit:
+			log.Info("exchange.Exchange send loop quit")
+			return
+		}
+	}
+}
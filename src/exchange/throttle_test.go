@@ -0,0 +1,220 @@
+package exchange
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestSendQueue(t *testing.T) {
+	q := newSendQueue()
+
+	for _, di := range []DepositInfo{
+		{DepositID: "a1", SkyAddress: "a"},
+		{DepositID: "a2", SkyAddress: "a"},
+		{DepositID: "a3", SkyAddress: "a"},
+		{DepositID: "b1", SkyAddress: "b"},
+		{DepositID: "c1", SkyAddress: "c"},
+	} {
+		q.push(di)
+	}
+	require.Equal(t, 5, q.Len())
+
+	// The addresses are served round robin, and each address's deposits in order
+	var ids []string
+	for i := 0; i < 3; i++ {
+		di, ok := q.pop(nil)
+		require.True(t, ok)
+		ids = append(ids, di.DepositID)
+	}
+	require.Equal(t, []string{"a1", "b1", "c1"}, ids)
+
+	q.push(DepositInfo{DepositID: "b2", SkyAddress: "b"})
+	for q.Len() > 0 {
+		di, ok := q.pop(nil)
+		require.True(t, ok)
+		ids = append(ids, di.DepositID)
+	}
+	require.Equal(t, []string{"a1", "b1", "c1", "a2", "b2", "a3"}, ids)
+
+	quit := make(chan struct{})
+	close(quit)
+	_, ok := q.pop(quit)
+	require.False(t, ok)
+}
+
+func TestExchangeSendThrottle(t *testing.T) {
+	for _, maxInFlight := range []int{1, 2} {
+		t.Run(fmt.Sprintf("max_in_flight=%d", maxInFlight), func(t *testing.T) {
+			log, _ := testutil.NewLogger(t)
+			e, run, shutdown := setupExchange(t, log)
+			e.cfg.SendThrottle = SendThrottleConfig{
+				SendsPerSecond: 100,
+				MaxInFlight:    maxInFlight,
+			}
+
+			var depositValue int64 = 1e8
+			var txids []string
+			for i, skyAddr := range []string{testSkyAddr, testSkyAddr2} {
+				depositAddr := fmt.Sprintf("foo-btc-addr-%d", i+1)
+				require.NoError(t, e.store.BindAddress(skyAddr, depositAddr, scanner.CoinTypeBTC, ""))
+
+				_, err := e.store.(*Store).addDepositInfo(DepositInfo{
+					Seq:            uint64(i + 1),
+					CoinType:       scanner.CoinTypeBTC,
+					Status:         StatusWaitSend,
+					SkyAddress:     skyAddr,
+					DepositAddress: depositAddr,
+					DepositID:      fmt.Sprintf("foo-tx-%d:1", i+1),
+					ConversionRate: testSkyBtcRate,
+					DepositValue:   depositValue,
+					Deposit: deposits.Deposit{
+						CoinType: scanner.CoinTypeBTC,
+						Address:  depositAddr,
+						Amount:   depositValue,
+						Height:   20,
+						Tx:       fmt.Sprintf("foo-tx-%d", i+1),
+						N:        1,
+						Final:    true,
+					},
+				})
+				require.NoError(t, err)
+
+				skySent, err := CalculateBtcSkyValue(depositValue, testSkyBtcRate, testMaxDecimals)
+				require.NoError(t, err)
+				txids = append(txids, e.sender.(*dummySender).predictTxid(t, skyAddr, skySent))
+			}
+
+			go run()
+			defer shutdown()
+			defer e.Shutdown()
+
+			waitStatuses := func(want ...Status) {
+				var got []Status
+				for start := time.Now(); time.Since(start) < dbScanTimeout; time.Sleep(dbCheckWaitTime) {
+					dis, err := e.store.GetDepositInfoArray(func(di DepositInfo) bool {
+						return true
+					})
+					require.NoError(t, err)
+
+					got = got[:0]
+					for _, di := range dis {
+						got = append(got, di.Status)
+					}
+					if fmt.Sprint(got) == fmt.Sprint(want) {
+						return
+					}
+				}
+				t.Fatalf("Waiting for the deposit statuses %v timed out, they are %v", want, got)
+			}
+
+			if maxInFlight == 1 {
+				// The second deposit is not sent until the first is confirmed
+				waitStatuses(StatusWaitConfirm, StatusWaitSend)
+				time.Sleep(dbCheckWaitTime)
+				waitStatuses(StatusWaitConfirm, StatusWaitSend)
+			} else {
+				// Both deposits are sent without waiting for the confirmation
+				waitStatuses(StatusWaitConfirm, StatusWaitConfirm)
+			}
+
+			e.sender.(*dummySender).setTxConfirmed(txids[0])
+			waitStatuses(StatusDone, StatusWaitConfirm)
+
+			e.sender.(*dummySender).setTxConfirmed(txids[1])
+			waitStatuses(StatusDone, StatusDone)
+		})
+	}
+}
+
+type dummyCoinHourGate struct {
+	sync.Mutex
+	queued []int
+	readyC chan struct{}
+}
+
+func (g *dummyCoinHourGate) Wait(queued int, quit <-chan struct{}) bool {
+	g.Lock()
+	g.queued = append(g.queued, queued)
+	g.Unlock()
+
+	select {
+	case <-g.readyC:
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+func TestExchangeWaitForCoinHours(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	e, run, shutdown := setupExchange(t, log)
+	gate := &dummyCoinHourGate{
+		readyC: make(chan struct{}),
+	}
+	e.WaitForCoinHours(gate)
+
+	var depositValue int64 = 1e8
+	require.NoError(t, e.store.BindAddress(testSkyAddr, "foo-btc-addr-1", scanner.CoinTypeBTC, ""))
+	_, err := e.store.(*Store).addDepositInfo(DepositInfo{
+		Seq:            1,
+		CoinType:       scanner.CoinTypeBTC,
+		Status:         StatusWaitSend,
+		SkyAddress:     testSkyAddr,
+		DepositAddress: "foo-btc-addr-1",
+		DepositID:      "foo-tx-1:1",
+		ConversionRate: testSkyBtcRate,
+		DepositValue:   depositValue,
+		Deposit: deposits.Deposit{
+			CoinType: scanner.CoinTypeBTC,
+			Address:  "foo-btc-addr-1",
+			Amount:   depositValue,
+			Height:   20,
+			Tx:       "foo-tx-1",
+			N:        1,
+			Final:    true,
+		},
+	})
+	require.NoError(t, err)
+
+	go run()
+	defer shutdown()
+	defer e.Shutdown()
+
+	// The deposit isn't sent while the gate waits for the coin hours
+	waited := func() bool {
+		gate.Lock()
+		defer gate.Unlock()
+		return len(gate.queued) != 0
+	}
+	for start := time.Now(); !waited() && time.Since(start) < dbScanTimeout; time.Sleep(dbCheckWaitTime) {
+	}
+	require.True(t, waited())
+	gate.Lock()
+	require.Equal(t, []int{1}, gate.queued)
+	gate.Unlock()
+
+	status := func() Status {
+		dis, err := e.store.GetDepositInfoArray(func(di DepositInfo) bool {
+			return true
+		})
+		require.NoError(t, err)
+		require.Len(t, dis, 1)
+		return dis[0].Status
+	}
+
+	time.Sleep(dbCheckWaitTime)
+	require.Equal(t, StatusWaitSend, status())
+
+	close(gate.readyC)
+	for start := time.Now(); status() != StatusWaitConfirm && time.Since(start) < dbScanTimeout; time.Sleep(dbCheckWaitTime) {
+	}
+	require.Equal(t, StatusWaitConfirm, status())
+}
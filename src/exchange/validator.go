@@ -0,0 +1,359 @@
+package exchange
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+// Deposit validation.
+// Operators check each deposit with logic of their own before it is credited, e.g. against
+// a chain analytics score or a list of sanctioned addresses. The validators are compiled in,
+// registered by name with RegisterDepositValidator, or an external HTTP service, and the
+// config enables them by name. They are asked in order, and the first which doesn't accept
+// the deposit holds it for review, or rejects it, which holds it for a full refund.
+// A deposit approved after a review is not validated again.
+
+const (
+	// DepositAccept lets the deposit be credited
+	DepositAccept = "accept"
+	// DepositHold holds the deposit for a manual review
+	DepositHold = "hold"
+	// DepositReject holds the deposit for a refund of all of it
+	DepositReject = "reject"
+
+	// DepositValidatorHTTP is the name of the external HTTP validator in DepositValidationConfig.Validators
+	DepositValidatorHTTP = "http"
+
+	defaultDepositValidatorTimeout = time.Second * 10
+	// depositValidatorResponseLimit bounds how much of the HTTP validator's response is read
+	depositValidatorResponseLimit = 64 * 1024
+)
+
+// DepositCheck is the deposit a validator is asked about
+type DepositCheck struct {
+	// DepositID is the deposit's transaction and output, "txid:n"
+	DepositID      string `json:"deposit_id"`
+	CoinType       string `json:"coin_type"`
+	DepositAddress string `json:"deposit_address"`
+	DepositValue   int64  `json:"deposit_value"`
+	SkyAddress     string `json:"sky_address"`
+	RefundAddress  string `json:"refund_address,omitempty"`
+	ConversionRate string `json:"conversion_rate"`
+}
+
+// DepositVerdict is a validator's decision on a deposit
+type DepositVerdict struct {
+	// Action is DepositAccept, DepositHold or DepositReject
+	Action string `json:"action"`
+	// Reason is recorded in the deposit's error when it is held or rejected
+	Reason string `json:"reason,omitempty"`
+}
+
+// DepositValidator decides whether a deposit is credited
+type DepositValidator interface {
+	ValidateDeposit(c DepositCheck) (DepositVerdict, error)
+}
+
+// DepositValidatorErr is the error of a deposit held or rejected by a validator
+type DepositValidatorErr struct {
+	Validator string
+	Action    string
+	Reason    string
+}
+
+func (e DepositValidatorErr) Error() string {
+	switch e.Action {
+	case DepositReject:
+		return fmt.Sprintf("Deposit rejected by validator %s: %s", e.Validator, e.Reason)
+	default:
+		return fmt.Sprintf("Deposit held by validator %s: %s", e.Validator, e.Reason)
+	}
+}
+
+// DepositValidationConfig configures the deposit validators. Validation is disabled if there are no Validators.
+type DepositValidationConfig struct {
+	// Validators are the names of the validators which check each deposit, in order.
+	// DepositValidatorHTTP is the HTTP validator, the others must be registered with RegisterDepositValidator.
+	Validators []string
+	// HTTP configures the HTTP validator
+	HTTP HTTPDepositValidatorConfig
+	// FailOpen accepts a deposit when a validator fails. Otherwise the deposit is held for review.
+	FailOpen bool
+}
+
+// HTTPDepositValidatorConfig configures the external HTTP validator
+type HTTPDepositValidatorConfig struct {
+	// URL which the DepositCheck is POSTed to, as JSON. It responds with a DepositVerdict.
+	URL string
+	// Token is sent as a bearer token in the Authorization header, if set
+	Token string
+	// Timeout of a request, defaults to 10 seconds
+	Timeout time.Duration
+}
+
+var (
+	depositValidatorsMu sync.RWMutex
+	depositValidators   = make(map[string]DepositValidator)
+)
+
+// RegisterDepositValidator registers a compiled-in validator as name, which the config enables.
+// It is meant to be called from an init function, and panics if name is already registered.
+func RegisterDepositValidator(name string, v DepositValidator) {
+	depositValidatorsMu.Lock()
+	defer depositValidatorsMu.Unlock()
+
+	if v == nil {
+		panic("RegisterDepositValidator validator is nil")
+	}
+
+	if _, ok := depositValidators[name]; ok || name == DepositValidatorHTTP {
+		panic(fmt.Sprintf("RegisterDepositValidator called twice for %q", name))
+	}
+
+	depositValidators[name] = v
+}
+
+// RegisteredDepositValidators returns the names of the compiled-in validators, sorted
+func RegisteredDepositValidators() []string {
+	depositValidatorsMu.RLock()
+	defer depositValidatorsMu.RUnlock()
+
+	names := make([]string, 0, len(depositValidators))
+	for name := range depositValidators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate returns an error if the configuration is invalid
+func (c DepositValidationConfig) Validate() error {
+	depositValidatorsMu.RLock()
+	defer depositValidatorsMu.RUnlock()
+
+	names := make(map[string]struct{}, len(c.Validators))
+	for _, name := range c.Validators {
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("Duplicate deposit validator %q", name)
+		}
+		names[name] = struct{}{}
+
+		if name == DepositValidatorHTTP {
+			continue
+		}
+
+		if _, ok := depositValidators[name]; !ok {
+			return fmt.Errorf("Deposit validator %q is not registered", name)
+		}
+	}
+
+	if _, ok := names[DepositValidatorHTTP]; ok {
+		u, err := url.Parse(c.HTTP.URL)
+		if err != nil {
+			return fmt.Errorf("Deposit validator url invalid: %v", err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return errors.New("Deposit validator url must be an absolute http or https URL")
+		}
+	}
+
+	if c.HTTP.Timeout < 0 {
+		return errors.New("Deposit validator timeout can't be negative")
+	}
+
+	return nil
+}
+
+type namedDepositValidator struct {
+	name string
+	DepositValidator
+}
+
+// depositValidation asks the enabled validators about each deposit
+type depositValidation struct {
+	validators []namedDepositValidator
+	failOpen   bool
+}
+
+// newDepositValidation returns the validation configured by cfg, nil if it is disabled
+func newDepositValidation(cfg DepositValidationConfig) (*depositValidation, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Validators) == 0 {
+		return nil, nil
+	}
+
+	depositValidatorsMu.RLock()
+	defer depositValidatorsMu.RUnlock()
+
+	v := &depositValidation{
+		failOpen: cfg.FailOpen,
+	}
+
+	for _, name := range cfg.Validators {
+		dv := depositValidators[name]
+		if name == DepositValidatorHTTP {
+			dv = NewHTTPDepositValidator(cfg.HTTP)
+		}
+
+		v.validators = append(v.validators, namedDepositValidator{
+			name:             name,
+			DepositValidator: dv,
+		})
+	}
+
+	return v, nil
+}
+
+// validateDeposit asks the validators about the deposit in order, and returns a DepositValidatorErr from
+// the first which holds or rejects it. A validator which fails holds the deposit, unless validation fails open.
+func (s *Exchange) validateDeposit(di DepositInfo) error {
+	if s.validation == nil {
+		return nil
+	}
+
+	c := DepositCheck{
+		DepositID:      di.DepositID,
+		CoinType:       di.CoinType,
+		DepositAddress: di.DepositAddress,
+		DepositValue:   di.DepositValue,
+		SkyAddress:     di.SkyAddress,
+		RefundAddress:  di.RefundAddress,
+		ConversionRate: di.ConversionRate,
+	}
+
+	for _, v := range s.validation.validators {
+		log := s.log.WithField("depositID", di.DepositID).WithField("validator", v.name)
+
+		verdict, err := v.ValidateDeposit(c)
+		if err == nil {
+			switch verdict.Action {
+			case DepositAccept:
+				continue
+			case DepositHold, DepositReject:
+				return DepositValidatorErr{
+					Validator: v.name,
+					Action:    verdict.Action,
+					Reason:    verdict.Reason,
+				}
+			default:
+				err = fmt.Errorf("Unknown deposit verdict action %q", verdict.Action)
+			}
+		}
+
+		if s.validation.failOpen {
+			log.WithError(err).Warn("Deposit validator failed, accepting the deposit")
+			continue
+		}
+
+		log.WithError(err).Error("Deposit validator failed")
+		return DepositValidatorErr{
+			Validator: v.name,
+			Action:    DepositHold,
+			Reason:    fmt.Sprintf("validator failed: %v", err),
+		}
+	}
+
+	return nil
+}
+
+// holdForValidator sets a deposit held or rejected by a validator to StatusWaitReview.
+// A rejected deposit records all of it to be refunded.
+func (s *Exchange) holdForValidator(di DepositInfo, reason error) (DepositInfo, error) {
+	if e, ok := reason.(DepositValidatorErr); !ok || e.Action != DepositReject {
+		return s.holdForReview(di, reason)
+	}
+
+	log := s.log.WithField("deposit", di).WithField("refundAddress", di.RefundAddress)
+
+	log.WithField("alert", "deposit_validator").WithError(reason).Error("ALERT: deposit rejected by a validator, holding deposit for a refund")
+
+	if di.RefundAddress == "" {
+		log.Warn("Deposit has no refund address, the depositor must be asked where to send the refund")
+	}
+
+	di, err := s.store.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Status = StatusWaitReview
+		di.Error = reason.Error()
+		di.RefundValue = di.DepositValue
+		return di
+	})
+	if err != nil {
+		log.WithError(err).Error("UpdateDepositInfo set StatusWaitReview failed")
+		return di, err
+	}
+
+	log.Info("DepositInfo set to StatusWaitReview")
+
+	return di, nil
+}
+
+// HTTPDepositValidator asks an external service about each deposit
+type HTTPDepositValidator struct {
+	cfg    HTTPDepositValidatorConfig
+	client *http.Client
+}
+
+// NewHTTPDepositValidator creates an HTTPDepositValidator
+func NewHTTPDepositValidator(cfg HTTPDepositValidatorConfig) *HTTPDepositValidator {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultDepositValidatorTimeout
+	}
+
+	return &HTTPDepositValidator{
+		cfg:    cfg,
+		client: httpclient.New(cfg.Timeout),
+	}
+}
+
+// ValidateDeposit POSTs the deposit to the service, which must respond 200 with a DepositVerdict
+func (v *HTTPDepositValidator) ValidateDeposit(c DepositCheck) (DepositVerdict, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return DepositVerdict{}, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, v.cfg.URL, bytes.NewReader(b))
+	if err != nil {
+		return DepositVerdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if v.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+v.cfg.Token)
+	}
+
+	rsp, err := v.client.Do(req)
+	if err != nil {
+		return DepositVerdict{}, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return DepositVerdict{}, fmt.Errorf("Deposit validator returned status %d", rsp.StatusCode)
+	}
+
+	b, err = ioutil.ReadAll(io.LimitReader(rsp.Body, depositValidatorResponseLimit))
+	if err != nil {
+		return DepositVerdict{}, err
+	}
+
+	var verdict DepositVerdict
+	if err := json.Unmarshal(b, &verdict); err != nil {
+		return DepositVerdict{}, fmt.Errorf("Invalid deposit validator response: %v", err)
+	}
+
+	return verdict, nil
+}
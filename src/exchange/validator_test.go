@@ -0,0 +1,204 @@
+package exchange
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/scanner"
+)
+
+// verdictValidator returns the verdict it is set to, and records the deposits it was asked about
+type verdictValidator struct {
+	sync.Mutex
+	verdict DepositVerdict
+	err     error
+	checked []DepositCheck
+}
+
+func (v *verdictValidator) set(verdict DepositVerdict, err error) {
+	v.Lock()
+	defer v.Unlock()
+	v.verdict = verdict
+	v.err = err
+	v.checked = nil
+}
+
+func (v *verdictValidator) ValidateDeposit(c DepositCheck) (DepositVerdict, error) {
+	v.Lock()
+	defer v.Unlock()
+	v.checked = append(v.checked, c)
+	return v.verdict, v.err
+}
+
+var testValidator = &verdictValidator{}
+
+func init() {
+	RegisterDepositValidator("test", testValidator)
+}
+
+func TestDepositValidationConfig(t *testing.T) {
+	require.Contains(t, RegisteredDepositValidators(), "test")
+
+	require.Panics(t, func() {
+		RegisterDepositValidator("test", testValidator)
+	})
+	require.Panics(t, func() {
+		RegisterDepositValidator(DepositValidatorHTTP, testValidator)
+	})
+
+	v, err := newDepositValidation(DepositValidationConfig{})
+	require.NoError(t, err)
+	require.Nil(t, v)
+
+	for _, tc := range []struct {
+		cfg DepositValidationConfig
+		err string
+	}{
+		{
+			cfg: DepositValidationConfig{
+				Validators: []string{"unknown"},
+			},
+			err: `Deposit validator "unknown" is not registered`,
+		},
+		{
+			cfg: DepositValidationConfig{
+				Validators: []string{"test", "test"},
+			},
+			err: `Duplicate deposit validator "test"`,
+		},
+		{
+			cfg: DepositValidationConfig{
+				Validators: []string{DepositValidatorHTTP},
+			},
+			err: "Deposit validator url must be an absolute http or https URL",
+		},
+	} {
+		_, err := newDepositValidation(tc.cfg)
+		require.Error(t, err)
+		require.Equal(t, tc.err, err.Error())
+	}
+}
+
+func TestExchangeDepositValidation(t *testing.T) {
+	e, shutdown := newCapTestExchange(t, CampaignCapConfig{})
+	defer shutdown()
+
+	var err error
+	e.validation, err = newDepositValidation(DepositValidationConfig{
+		Validators: []string{"test"},
+	})
+	require.NoError(t, err)
+
+	// An accepted deposit is sent
+	testValidator.set(DepositVerdict{Action: DepositAccept}, nil)
+	di := handleCapDeposit(t, e, 1, 1e8)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Equal(t, []DepositCheck{{
+		DepositID:      "btc-tx-1:1",
+		CoinType:       scanner.CoinTypeBTC,
+		DepositAddress: "btc-addr-1",
+		DepositValue:   1e8,
+		SkyAddress:     testSkyAddr,
+		ConversionRate: testSkyBtcRate,
+	}}, testValidator.checked)
+
+	// A held deposit waits for review
+	testValidator.set(DepositVerdict{Action: DepositHold, Reason: "low score"}, nil)
+	di = handleCapDeposit(t, e, 2, 1e8)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Equal(t, "Deposit held by validator test: low score", di.Error)
+	require.Zero(t, di.RefundValue)
+
+	// Once approved, it is not validated again
+	di, err = e.ApproveDeposit(di.DepositID, "", 0)
+	require.NoError(t, err)
+	<-e.depositChan
+	di, err = e.handleDepositInfoState(di)
+	require.NoError(t, err)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+	require.Len(t, testValidator.checked, 1)
+
+	// A rejected deposit is held for a refund of all of it
+	testValidator.set(DepositVerdict{Action: DepositReject, Reason: "sanctioned"}, nil)
+	di = handleCapDeposit(t, e, 3, 1e8)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Equal(t, "Deposit rejected by validator test: sanctioned", di.Error)
+	require.Equal(t, int64(1e8), di.RefundValue)
+	require.Zero(t, di.SkySent)
+
+	// A failed validator holds the deposit
+	testValidator.set(DepositVerdict{}, errors.New("timeout"))
+	di = handleCapDeposit(t, e, 4, 1e8)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Equal(t, "Deposit held by validator test: validator failed: timeout", di.Error)
+
+	testValidator.set(DepositVerdict{Action: "maybe"}, nil)
+	di = handleCapDeposit(t, e, 5, 1e8)
+	require.Equal(t, StatusWaitReview, di.Status)
+	require.Equal(t, `Deposit held by validator test: validator failed: Unknown deposit verdict action "maybe"`, di.Error)
+
+	// Unless validation fails open
+	e.validation.failOpen = true
+	testValidator.set(DepositVerdict{}, errors.New("timeout"))
+	di = handleCapDeposit(t, e, 6, 1e8)
+	require.Equal(t, StatusWaitConfirm, di.Status)
+
+	testValidator.set(DepositVerdict{}, nil)
+}
+
+func TestHTTPDepositValidator(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var c DepositCheck
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&c))
+
+		switch c.SkyAddress {
+		case "sanctioned":
+			w.Write([]byte(`{"action":"reject","reason":"sanctioned address"}`))
+		case "broken":
+			w.Write([]byte(`not json`))
+		default:
+			w.Write([]byte(`{"action":"accept"}`))
+		}
+	}))
+	defer srv.Close()
+
+	v := NewHTTPDepositValidator(HTTPDepositValidatorConfig{
+		URL:   srv.URL,
+		Token: "secret",
+	})
+
+	verdict, err := v.ValidateDeposit(DepositCheck{SkyAddress: "ok"})
+	require.NoError(t, err)
+	require.Equal(t, DepositVerdict{Action: DepositAccept}, verdict)
+
+	verdict, err = v.ValidateDeposit(DepositCheck{SkyAddress: "sanctioned"})
+	require.NoError(t, err)
+	require.Equal(t, DepositVerdict{
+		Action: DepositReject,
+		Reason: "sanctioned address",
+	}, verdict)
+
+	_, err = v.ValidateDeposit(DepositCheck{SkyAddress: "broken"})
+	require.Error(t, err)
+
+	v = NewHTTPDepositValidator(HTTPDepositValidatorConfig{
+		URL: srv.URL,
+	})
+	_, err = v.ValidateDeposit(DepositCheck{SkyAddress: "ok"})
+	require.Error(t, err)
+	require.Equal(t, "Deposit validator returned status 401", err.Error())
+}
@@ -0,0 +1,119 @@
+package exchange
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/errutil"
+)
+
+// BindVersionBkt maps a deposit address to the version of its binding.
+// The version is incremented whenever the address is bound or its binding is cancelled.
+var BindVersionBkt = []byte("bind_version")
+
+// VersionConflictErr is returned by a compare-and-swap update of a record which was changed
+// since the caller read it. It is wrapped as an errutil.Conflict error.
+type VersionConflictErr struct {
+	// Record is "deposit" or "binding"
+	Record string
+	// Key is the deposit ID or the deposit address
+	Key      string
+	Expected uint64
+	Actual   uint64
+}
+
+func (e VersionConflictErr) Error() string {
+	return fmt.Sprintf("The %s %s was changed, it is at version %d, not %d", e.Record, e.Key, e.Actual, e.Expected)
+}
+
+// checkVersion returns a conflict if version is set and isn't the record's current version
+func checkVersion(record, key string, version, actual uint64) error {
+	if version == 0 || version == actual {
+		return nil
+	}
+
+	return errutil.Wrap(errutil.Conflict, VersionConflictErr{
+		Record:   record,
+		Key:      key,
+		Expected: version,
+		Actual:   actual,
+	})
+}
+
+func bindVersionBkt(coinType string) []byte {
+	return dbutil.ByteJoin(BindVersionBkt, coinType, "_")
+}
+
+// getBindVersionTx returns the binding version of a deposit address, 0 if it was never bound
+func (s *Store) getBindVersionTx(tx *bolt.Tx, depositAddr, coinType string) (uint64, error) {
+	// An archived db of an older teller has no versions
+	if tx.Bucket(bindVersionBkt(coinType)) == nil {
+		return 0, nil
+	}
+
+	var version uint64
+	if err := dbutil.GetBucketObject(tx, bindVersionBkt(coinType), depositAddr, &version); err != nil {
+		switch err.(type) {
+		case dbutil.ObjectNotExistErr:
+			return 0, nil
+		default:
+			return 0, err
+		}
+	}
+
+	return version, nil
+}
+
+// incrBindVersionTx increments the binding version of a deposit address
+func (s *Store) incrBindVersionTx(tx *bolt.Tx, depositAddr, coinType string) error {
+	version, err := s.getBindVersionTx(tx, depositAddr, coinType)
+	if err != nil {
+		return err
+	}
+
+	return dbutil.PutBucketValue(tx, bindVersionBkt(coinType), depositAddr, version+1)
+}
+
+// createBindVersionBktsTx creates the binding version buckets
+func createBindVersionBktsTx(tx *bolt.Tx) error {
+	for _, coinType := range deposits.CoinTypes() {
+		bktFullName := bindVersionBkt(coinType)
+		if _, err := tx.CreateBucketIfNotExists(bktFullName); err != nil {
+			return dbutil.NewCreateBucketFailedErr(bktFullName, err)
+		}
+	}
+	return nil
+}
+
+// UpdateDepositInfoVersion is UpdateDepositInfoCallback, which only updates the deposit if version is its current version,
+// so that an update made since the caller read the deposit isn't overwritten. If the deposit was updated since,
+// nothing is changed and a VersionConflictErr is returned. A version of 0 updates the deposit whatever its version.
+func (s *Store) UpdateDepositInfoVersion(btcTx string, version uint64, update func(DepositInfo) DepositInfo, callback func(DepositInfo) error) (DepositInfo, error) {
+	var t Transition
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		dpi, err := s.getDepositInfoTx(tx, btcTx)
+		if err != nil {
+			return err
+		}
+
+		if err := checkVersion("deposit", btcTx, version, dpi.Version); err != nil {
+			return err
+		}
+
+		t, err = s.updateDepositInfoTx(tx, btcTx, update)
+		if err != nil {
+			return err
+		}
+
+		return callback(t.To)
+	}); err != nil {
+		return DepositInfo{}, err
+	}
+
+	s.states.committed(t)
+
+	return t.To, nil
+}
@@ -0,0 +1,134 @@
+package exchange
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/errutil"
+)
+
+func TestStoreUpdateDepositInfoVersion(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+
+	di, err := s.GetOrCreateDepositInfo(deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "btcaddr1",
+		Amount:   1e6,
+		Tx:       "btx1",
+		N:        1,
+		Final:    true,
+	}, testSkyBtcRate)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), di.Version)
+
+	noop := func(di DepositInfo) error { return nil }
+
+	// Two operators read the deposit at version 1, the first update wins
+	di, err = s.UpdateDepositInfoVersion(di.DepositID, 1, func(di DepositInfo) DepositInfo {
+		di.Error = "first"
+		return di
+	}, noop)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), di.Version)
+
+	_, err = s.UpdateDepositInfoVersion(di.DepositID, 1, func(di DepositInfo) DepositInfo {
+		di.Error = "second"
+		return di
+	}, noop)
+	require.Equal(t, errutil.Conflict, errutil.KindOf(err))
+	var conflict VersionConflictErr
+	require.True(t, errors.As(err, &conflict))
+	require.Equal(t, VersionConflictErr{
+		Record:   "deposit",
+		Key:      "btx1:1",
+		Expected: 1,
+		Actual:   2,
+	}, conflict)
+	require.Equal(t, "The deposit btx1:1 was changed, it is at version 2, not 1", err.Error())
+
+	di, err = s.getDepositInfo(di.DepositID)
+	require.NoError(t, err)
+	require.Equal(t, "first", di.Error)
+	require.Equal(t, uint64(2), di.Version)
+
+	// The update func can't change the version
+	di, err = s.UpdateDepositInfo(di.DepositID, func(di DepositInfo) DepositInfo {
+		di.Version = 10
+		return di
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), di.Version)
+
+	// A deposit saved before versions were added starts at version 0
+	di.Version = 0
+	require.NoError(t, s.db.Update(func(tx *bolt.Tx) error {
+		return dbutil.PutBucketValue(tx, DepositInfoBkt, di.DepositID, di)
+	}))
+
+	_, err = s.UpdateDepositInfoVersion(di.DepositID, 3, func(di DepositInfo) DepositInfo { return di }, noop)
+	require.Equal(t, errutil.Conflict, errutil.KindOf(err))
+
+	di, err = s.UpdateDepositInfoVersion(di.DepositID, 0, func(di DepositInfo) DepositInfo { return di }, noop)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), di.Version)
+}
+
+func TestStoreBindingVersion(t *testing.T) {
+	s, shutdown := newTestStore(t)
+	defer shutdown()
+
+	// The current owner comes first
+	bindingVersion := func() uint64 {
+		owners, err := s.LookupOwners("btcaddr1", "")
+		require.NoError(t, err)
+		require.NotEmpty(t, owners)
+		require.Len(t, owners[0].Bindings, 1)
+		return owners[0].Bindings[0].Version
+	}
+
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.Equal(t, uint64(1), bindingVersion())
+
+	_, err := s.CancelBinding(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, 2, false, time.Now())
+	require.Equal(t, errutil.Conflict, errutil.KindOf(err))
+	var conflict VersionConflictErr
+	require.True(t, errors.As(err, &conflict))
+	require.Equal(t, VersionConflictErr{
+		Record:   "binding",
+		Key:      "btcaddr1",
+		Expected: 2,
+		Actual:   1,
+	}, conflict)
+
+	// Still bound
+	require.Equal(t, uint64(1), bindingVersion())
+
+	_, err = s.CancelBinding(testSkyAddr, "btcaddr1", scanner.CoinTypeBTC, 1, true, time.Now())
+	require.NoError(t, err)
+
+	// Binding the address again doesn't reuse the version of its last binding
+	require.NoError(t, s.BindAddress(testSkyAddr2, "btcaddr1", scanner.CoinTypeBTC, ""))
+	require.Equal(t, uint64(3), bindingVersion())
+
+	_, err = s.CancelBinding(testSkyAddr2, "btcaddr1", scanner.CoinTypeBTC, 1, true, time.Now())
+	require.Equal(t, errutil.Conflict, errutil.KindOf(err))
+
+	// The binding version of each coin type is separate
+	require.NoError(t, s.BindAddress(testSkyAddr, "btcaddr1", scanner.CoinTypeETH, ""))
+	require.NoError(t, s.db.View(func(tx *bolt.Tx) error {
+		v, err := s.getBindVersionTx(tx, "btcaddr1", scanner.CoinTypeETH)
+		require.NoError(t, err)
+		require.Equal(t, uint64(1), v)
+		return nil
+	}))
+}
@@ -0,0 +1,140 @@
+// Package explorer builds the links to chain explorers of the transactions and addresses teller reports
+package explorer
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// TxidPlaceholder is replaced by the transaction id in Coin.TxURL
+	TxidPlaceholder = "{txid}"
+	// AddressPlaceholder is replaced by the address in Coin.AddressURL
+	AddressPlaceholder = "{address}"
+)
+
+// Coin is the explorer of a coin type. An empty URL has no link.
+type Coin struct {
+	// URL of a transaction, with TxidPlaceholder in place of its id
+	TxURL string
+	// URL of an address, with AddressPlaceholder in place of it
+	AddressURL string
+}
+
+var presets = map[string]Coin{
+	"blockstream": {
+		TxURL:      "https://blockstream.info/tx/{txid}",
+		AddressURL: "https://blockstream.info/address/{address}",
+	},
+	"blockstream-testnet": {
+		TxURL:      "https://blockstream.info/testnet/tx/{txid}",
+		AddressURL: "https://blockstream.info/testnet/address/{address}",
+	},
+	"etherscan": {
+		TxURL:      "https://etherscan.io/tx/{txid}",
+		AddressURL: "https://etherscan.io/address/{address}",
+	},
+	"skycoin": {
+		TxURL:      "https://explorer.skycoin.com/app/transaction/{txid}",
+		AddressURL: "https://explorer.skycoin.com/app/address/{address}",
+	},
+}
+
+// Preset returns the Coin of a named explorer, e.g. "blockstream"
+func Preset(name string) (Coin, error) {
+	c, ok := presets[name]
+	if !ok {
+		return Coin{}, fmt.Errorf("Unknown explorer %q, the explorers are %s", name, strings.Join(Presets(), ", "))
+	}
+	return c, nil
+}
+
+// Presets returns the names of the explorers which have a preset
+func Presets() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate returns an error if a URL is not an absolute http(s) URL with its placeholder
+func (c Coin) Validate() error {
+	if err := validateURL(c.TxURL, TxidPlaceholder); err != nil {
+		return fmt.Errorf("Invalid TxURL: %v", err)
+	}
+	if err := validateURL(c.AddressURL, AddressPlaceholder); err != nil {
+		return fmt.Errorf("Invalid AddressURL: %v", err)
+	}
+	return nil
+}
+
+func validateURL(s, placeholder string) error {
+	if s == "" {
+		return nil
+	}
+
+	if !strings.Contains(s, placeholder) {
+		return fmt.Errorf("%s is missing", placeholder)
+	}
+
+	u, err := url.Parse(strings.Replace(s, placeholder, "x", -1))
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+
+	return nil
+}
+
+// Tx returns the link of a transaction, or "" if the coin has no transaction links
+func (c Coin) Tx(txid string) string {
+	return link(c.TxURL, TxidPlaceholder, txid)
+}
+
+// Address returns the link of an address, or "" if the coin has no address links
+func (c Coin) Address(addr string) string {
+	return link(c.AddressURL, AddressPlaceholder, addr)
+}
+
+func link(tmpl, placeholder, v string) string {
+	if tmpl == "" || v == "" {
+		return ""
+	}
+	return strings.Replace(tmpl, placeholder, url.PathEscape(v), -1)
+}
+
+// Links are the explorers of the deposit coin types and of the payout chain.
+// The zero Links has no links.
+type Links struct {
+	// Deposits are the explorers of the deposit coin types, keyed by coin type
+	Deposits map[string]Coin
+	// Payout is the explorer of the chain the skycoin is sent on
+	Payout Coin
+}
+
+// DepositTx returns the link of a transaction of a deposit coin type, or "" if it has no explorer
+func (l Links) DepositTx(coinType, txid string) string {
+	return l.Deposits[coinType].Tx(txid)
+}
+
+// DepositAddress returns the link of an address of a deposit coin type, or "" if it has no explorer
+func (l Links) DepositAddress(coinType, addr string) string {
+	return l.Deposits[coinType].Address(addr)
+}
+
+// PayoutTx returns the link of a payout transaction, or "" if the payout chain has no explorer
+func (l Links) PayoutTx(txid string) string {
+	return l.Payout.Tx(txid)
+}
+
+// PayoutAddress returns the link of a skycoin address, or "" if the payout chain has no explorer
+func (l Links) PayoutAddress(addr string) string {
+	return l.Payout.Address(addr)
+}
@@ -0,0 +1,64 @@
+package explorer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresets(t *testing.T) {
+	for _, name := range Presets() {
+		c, err := Preset(name)
+		require.NoError(t, err)
+		require.NoError(t, c.Validate(), name)
+	}
+
+	_, err := Preset("blockchair")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "blockstream, blockstream-testnet, etherscan, skycoin")
+}
+
+func TestCoinValidate(t *testing.T) {
+	require.NoError(t, Coin{}.Validate())
+	require.NoError(t, Coin{TxURL: "http://localhost:8001/tx/{txid}"}.Validate())
+
+	for _, c := range []Coin{
+		{TxURL: "https://example.com/tx/"},
+		{TxURL: "/tx/{txid}"},
+		{TxURL: "javascript:alert('{txid}')"},
+		{AddressURL: "https://example.com/address/{txid}"},
+		{AddressURL: "https://%zz/{address}"},
+	} {
+		require.Error(t, c.Validate(), c)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	// The zero Links has no links
+	var l Links
+	require.Empty(t, l.DepositTx("BTC", "tx1"))
+	require.Empty(t, l.PayoutAddress("2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"))
+
+	l = Links{
+		Deposits: map[string]Coin{
+			"BTC": presets["blockstream"],
+			"ETH": {AddressURL: "https://etherscan.io/address/{address}"},
+		},
+		Payout: Coin{
+			TxURL:      "https://explorer.example.com/tx/{txid}?chain=mdl",
+			AddressURL: "https://explorer.example.com/{address}/{address}",
+		},
+	}
+
+	require.Equal(t, "https://blockstream.info/tx/tx1", l.DepositTx("BTC", "tx1"))
+	require.Equal(t, "https://blockstream.info/address/1FeDtFhARLxjKUPPkQqEBL78tisenc9znS", l.DepositAddress("BTC", "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS"))
+	require.Equal(t, "https://etherscan.io/address/0xab", l.DepositAddress("ETH", "0xab"))
+	require.Empty(t, l.DepositTx("ETH", "0xcd"))
+	require.Empty(t, l.DepositTx("SKY", "tx1"))
+	require.Empty(t, l.DepositTx("BTC", ""))
+	require.Equal(t, "https://explorer.example.com/tx/tx2?chain=mdl", l.PayoutTx("tx2"))
+	require.Equal(t, "https://explorer.example.com/a/a", l.PayoutAddress("a"))
+
+	// The values are escaped
+	require.Equal(t, "https://blockstream.info/tx/a%2Fb%3Fc", l.DepositTx("BTC", "a/b?c"))
+}
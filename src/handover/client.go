@@ -0,0 +1,137 @@
+package handover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TokenHeader carries the handover token of the admin API's handover endpoints
+	TokenHeader = "X-Handover-Token"
+	// clientTimeout bounds a handover request. Quiescing waits for the skycoin send in progress.
+	clientTimeout = time.Minute
+)
+
+// StateResponse is the response of the admin API's handover endpoints
+type StateResponse struct {
+	State string `json:"state"`
+}
+
+// Client calls the handover endpoints of an instance's admin API
+type Client struct {
+	// Addr is the admin API's base URL, e.g. http://127.0.0.1:7711
+	Addr  string
+	Token string
+	HTTP  *http.Client
+}
+
+// NewClient creates a Client
+func NewClient(addr, token string) *Client {
+	return &Client{
+		Addr:  strings.TrimRight(addr, "/"),
+		Token: token,
+		HTTP: &http.Client{
+			Timeout: clientTimeout,
+		},
+	}
+}
+
+// State returns the handover state of the instance
+func (c *Client) State() (string, error) {
+	return c.do(http.MethodGet, "/api/handover")
+}
+
+// Quiesce quiesces the instance
+func (c *Client) Quiesce() error {
+	return c.expect(http.MethodPost, "/api/handover/quiesce", StateQuiesced)
+}
+
+// Resume resumes the quiesced instance
+func (c *Client) Resume() error {
+	return c.expect(http.MethodPost, "/api/handover/resume", StateRunning)
+}
+
+// Release releases the quiesced instance
+func (c *Client) Release() error {
+	return c.expect(http.MethodPost, "/api/handover/release", StateReleased)
+}
+
+func (c *Client) expect(method, path, state string) error {
+	s, err := c.do(method, path)
+	if err != nil {
+		return err
+	}
+
+	if s != state {
+		return fmt.Errorf("%s %s: teller is %s, not %s", method, path, s, state)
+	}
+
+	return nil
+}
+
+func (c *Client) do(method, path string) (string, error) {
+	req, err := http.NewRequest(method, c.Addr+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(TokenHeader, c.Token)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var sr StateResponse
+	if err := json.Unmarshal(body, &sr); err != nil {
+		return "", err
+	}
+
+	return sr.State, nil
+}
+
+// TakeOver quiesces and releases the instance. If the release fails, the instance is resumed.
+// On success, the instance is shutting down and its db can be opened once its lock is released.
+func TakeOver(log logrus.FieldLogger, c *Client) error {
+	log = log.WithFields(logrus.Fields{
+		"prefix": "teller.handover",
+		"from":   c.Addr,
+	})
+
+	log.Info("Quiescing old teller")
+	if err := c.Quiesce(); err != nil {
+		log.WithError(err).Error("Quiesce old teller failed")
+		// Quiesce may have succeeded after the request failed
+		if err := c.Resume(); err != nil {
+			log.WithError(err).Error("Resume old teller failed")
+		}
+		return err
+	}
+
+	log.Info("Releasing old teller")
+	if err := c.Release(); err != nil {
+		log.WithError(err).Error("Release old teller failed, resuming it")
+		if err := c.Resume(); err != nil {
+			log.WithError(err).Error("Resume old teller failed")
+		}
+		return err
+	}
+
+	log.Info("Old teller released")
+	return nil
+}
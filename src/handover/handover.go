@@ -0,0 +1,134 @@
+// Package handover hands a teller's db over to a new teller instance,
+// for upgrades without downtime.
+//
+// The old instance is quiesced: it stops binding addresses and sending skycoin,
+// once the bind and send in progress are finished. The old instance is then released:
+// it shuts down, which releases the db. The new instance opens the db once it is released.
+// bolt's file lock keeps the two instances from using the db at the same time.
+package handover
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// StateRunning is the state of an instance which is binding addresses and sending skycoin
+	StateRunning = "running"
+	// StateQuiesced is the state of an instance which has stopped binding addresses and sending skycoin
+	StateQuiesced = "quiesced"
+	// StateReleased is the state of an instance which is shutting down to release its db
+	StateReleased = "released"
+)
+
+var (
+	// ErrNotQuiesced is returned if an instance is released before it is quiesced
+	ErrNotQuiesced = errors.New("teller is not quiesced")
+	// ErrReleased is returned if an instance is quiesced or resumed after it is released
+	ErrReleased = errors.New("teller is released")
+)
+
+// Quiescer is a service which can be paused for a handover
+type Quiescer interface {
+	// Quiesce pauses the service. It returns when the work in progress is finished.
+	Quiesce()
+	// Resume undoes Quiesce
+	Resume()
+}
+
+// Coordinator tracks the handover state of an instance
+type Coordinator struct {
+	log       logrus.FieldLogger
+	quiescers []Quiescer
+	released  chan struct{}
+
+	sync.Mutex
+	state string
+}
+
+// NewCoordinator creates a Coordinator. The quiescers are quiesced in order and resumed in reverse order.
+func NewCoordinator(log logrus.FieldLogger, quiescers ...Quiescer) *Coordinator {
+	return &Coordinator{
+		log:       log.WithField("prefix", "teller.handover"),
+		quiescers: quiescers,
+		released:  make(chan struct{}),
+		state:     StateRunning,
+	}
+}
+
+// HandoverState returns the handover state
+func (c *Coordinator) HandoverState() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.state
+}
+
+// Quiesce quiesces the instance. Quiescing a quiesced instance does nothing.
+func (c *Coordinator) Quiesce() error {
+	c.Lock()
+	defer c.Unlock()
+
+	switch c.state {
+	case StateReleased:
+		return ErrReleased
+	case StateQuiesced:
+		return nil
+	}
+
+	c.log.Info("Quiescing for handover")
+	for _, q := range c.quiescers {
+		q.Quiesce()
+	}
+	c.state = StateQuiesced
+	c.log.Info("Quiesced for handover")
+
+	return nil
+}
+
+// Resume resumes a quiesced instance, aborting the handover. Resuming a running instance does nothing.
+func (c *Coordinator) Resume() error {
+	c.Lock()
+	defer c.Unlock()
+
+	switch c.state {
+	case StateReleased:
+		return ErrReleased
+	case StateRunning:
+		return nil
+	}
+
+	for i := len(c.quiescers) - 1; i >= 0; i-- {
+		c.quiescers[i].Resume()
+	}
+	c.state = StateRunning
+	c.log.Info("Resumed, handover aborted")
+
+	return nil
+}
+
+// Release releases a quiesced instance, closing the channel returned by Released.
+// Releasing a released instance does nothing.
+func (c *Coordinator) Release() error {
+	c.Lock()
+	defer c.Unlock()
+
+	switch c.state {
+	case StateRunning:
+		return ErrNotQuiesced
+	case StateReleased:
+		return nil
+	}
+
+	c.state = StateReleased
+	close(c.released)
+	c.log.Info("Released for handover, shutting down")
+
+	return nil
+}
+
+// Released returns a channel which is closed when the instance is released. The instance must then shut down.
+func (c *Coordinator) Released() <-chan struct{} {
+	return c.released
+}
@@ -0,0 +1,106 @@
+package handover
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type recordQuiescer struct {
+	name  string
+	calls *[]string
+}
+
+func (q recordQuiescer) Quiesce() {
+	*q.calls = append(*q.calls, "quiesce "+q.name)
+}
+
+func (q recordQuiescer) Resume() {
+	*q.calls = append(*q.calls, "resume "+q.name)
+}
+
+func TestCoordinator(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	var calls []string
+	c := NewCoordinator(log, recordQuiescer{"a", &calls}, recordQuiescer{"b", &calls})
+	require.Equal(t, StateRunning, c.HandoverState())
+
+	// Resuming or releasing a running instance
+	require.NoError(t, c.Resume())
+	require.Equal(t, ErrNotQuiesced, c.Release())
+	require.Empty(t, calls)
+
+	// Quiesced in order, resumed in reverse order
+	require.NoError(t, c.Quiesce())
+	require.NoError(t, c.Quiesce())
+	require.Equal(t, StateQuiesced, c.HandoverState())
+	require.NoError(t, c.Resume())
+	require.Equal(t, StateRunning, c.HandoverState())
+	require.Equal(t, []string{"quiesce a", "quiesce b", "resume b", "resume a"}, calls)
+
+	select {
+	case <-c.Released():
+		t.Fatal("Released is closed before Release")
+	default:
+	}
+
+	require.NoError(t, c.Quiesce())
+	require.NoError(t, c.Release())
+	require.NoError(t, c.Release())
+	require.Equal(t, StateReleased, c.HandoverState())
+
+	select {
+	case <-c.Released():
+	default:
+		t.Fatal("Released is not closed after Release")
+	}
+
+	// A released instance stays quiesced
+	require.Equal(t, ErrReleased, c.Quiesce())
+	require.Equal(t, ErrReleased, c.Resume())
+	require.Equal(t, "quiesce a", calls[len(calls)-2])
+	require.Equal(t, "quiesce b", calls[len(calls)-1])
+}
+
+func TestTakeOverReleaseFailed(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "token", r.Header.Get(TokenHeader))
+		paths = append(paths, r.URL.Path)
+
+		switch r.URL.Path {
+		case "/api/handover/quiesce":
+			w.Write([]byte(`{"state":"quiesced"}`)) // nolint: errcheck
+		case "/api/handover/resume":
+			w.Write([]byte(`{"state":"running"}`)) // nolint: errcheck
+		default:
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	err := TakeOver(log, NewClient(srv.URL+"/", "token"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "500")
+
+	// The instance is resumed when the release fails
+	require.Equal(t, []string{"/api/handover/quiesce", "/api/handover/release", "/api/handover/resume"}, paths)
+}
+
+func TestClientUnexpectedState(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"state":"running"}`)) // nolint: errcheck
+	}))
+	defer srv.Close()
+
+	err := NewClient(srv.URL, "token").Quiesce()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "teller is running, not quiesced")
+}
@@ -0,0 +1,503 @@
+package metrics
+
+import (
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/replica"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/teller"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// DepositStatsGetter returns the deposit totals
+type DepositStatsGetter interface {
+	GetDepositStats() (*exchange.DepositStats, error)
+	GetLedgerReport() (*exchange.LedgerReport, error)
+}
+
+// AddrManager returns the state of the deposit address pools
+type AddrManager interface {
+	Remaining(coinType string) (uint64, error)
+	QueueStats() map[string]addrs.QueueStats
+}
+
+// PollStatsGetter returns the state of a scanner's polling for new blocks
+type PollStatsGetter interface {
+	Stats() scanner.PollStats
+}
+
+// DBStatsGetter returns the stats of the teller db
+type DBStatsGetter interface {
+	DBStats() (dbutil.Stats, error)
+}
+
+// StatusCacheStatsGetter returns the lookups of the deposit status cache
+type StatusCacheStatsGetter interface {
+	StatusCacheStats() exchange.StatusCacheStats
+}
+
+// ForecastGetter returns the predicted exhaustion of the deposit address pools
+type ForecastGetter interface {
+	Forecasts() []addrs.Forecast
+}
+
+// SkewStatusGetter returns the last check of the clock skew guard
+type SkewStatusGetter interface {
+	Status() clock.SkewStatus
+}
+
+// RateLimitStatsGetter returns the requests of the rate limited API endpoints
+type RateLimitStatsGetter interface {
+	RateLimitStats() []teller.RateLimitStats
+}
+
+// ReplicationStatusGetter returns the state of the db's replication to the standby
+type ReplicationStatusGetter interface {
+	Status() (replica.Status, error)
+}
+
+// ExchangeGatherer gathers the deposit totals and ledger balances
+func ExchangeGatherer(s DepositStatsGetter) Gatherer {
+	return func() ([]Metric, error) {
+		stats, err := s.GetDepositStats()
+		if err != nil {
+			return nil, err
+		}
+
+		report, err := s.GetLedgerReport()
+		if err != nil {
+			return nil, err
+		}
+
+		ms := []Metric{
+			{
+				Name:  "teller_btc_received_satoshis_total",
+				Help:  "Total BTC received in deposits, in satoshis",
+				Type:  TypeCounter,
+				Value: float64(stats.TotalBTCReceived),
+			},
+			{
+				Name:  "teller_sky_sent_droplets_total",
+				Help:  "Total SKY sent for deposits, in droplets",
+				Type:  TypeCounter,
+				Value: float64(stats.TotalSKYSent),
+			},
+			{
+				Name:  "teller_ledger_drifted_accounts",
+				Help:  "Number of ledger accounts whose balance drifted from the deposit records",
+				Type:  TypeGauge,
+				Value: float64(len(report.Drift)),
+			},
+		}
+
+		for currency, accounts := range report.Balances {
+			for account, balance := range accounts {
+				ms = append(ms, Metric{
+					Name: "teller_ledger_balance",
+					Help: "Ledger account balance, in the currency's smallest unit",
+					Type: TypeGauge,
+					Labels: map[string]string{
+						"currency": currency,
+						"account":  account,
+					},
+					Value: float64(balance),
+				})
+			}
+		}
+
+		return ms, nil
+	}
+}
+
+// AddrGatherer gathers the remaining deposit addresses and the allocation queue stats of each coin type
+func AddrGatherer(am AddrManager) Gatherer {
+	return func() ([]Metric, error) {
+		var ms []Metric
+		for coinType, qs := range am.QueueStats() {
+			labels := map[string]string{
+				"coin_type": coinType,
+			}
+
+			remaining, err := am.Remaining(coinType)
+			if err != nil {
+				return nil, err
+			}
+
+			ms = append(ms, []Metric{
+				{
+					Name:   "teller_deposit_addresses_remaining",
+					Help:   "Number of unused deposit addresses",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  float64(remaining),
+				},
+				{
+					Name:   "teller_bind_queue_depth",
+					Help:   "Number of bind requests waiting for a deposit address",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  float64(qs.Depth),
+				},
+				{
+					Name:   "teller_bind_queue_peak_depth",
+					Help:   "Most bind requests waiting for a deposit address at once",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  float64(qs.PeakDepth),
+				},
+				{
+					Name:   "teller_bind_queue_served_total",
+					Help:   "Bind requests which reached the deposit address pool",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(qs.Served),
+				},
+				{
+					Name:   "teller_bind_queue_timed_out_total",
+					Help:   "Bind requests which timed out waiting for a deposit address",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(qs.TimedOut),
+				},
+				{
+					Name:   "teller_bind_queue_rejected_total",
+					Help:   "Bind requests rejected because the queue was full",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(qs.Rejected),
+				},
+				{
+					Name:   "teller_bind_queue_max_wait_seconds",
+					Help:   "Longest time a served bind request waited for a deposit address",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  qs.MaxWait.Seconds(),
+				},
+			}...)
+		}
+
+		return ms, nil
+	}
+}
+
+// ScannerGatherer gathers the polling state of each coin type's scanner
+func ScannerGatherer(polls map[string]PollStatsGetter) Gatherer {
+	return func() ([]Metric, error) {
+		var ms []Metric
+		for coinType, p := range polls {
+			labels := map[string]string{
+				"coin_type": coinType,
+			}
+
+			stats := p.Stats()
+			ms = append(ms, []Metric{
+				{
+					Name:   "teller_scanner_poll_period_seconds",
+					Help:   "Wait before the scanner next polls its node for a new block",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  stats.Period.Seconds(),
+				},
+				{
+					Name:   "teller_scanner_block_interval_seconds",
+					Help:   "Median of the recent inter-block times observed by the scanner, 0 until enough blocks were observed",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  stats.BlockInterval.Seconds(),
+				},
+				{
+					Name:   "teller_scanner_polls_total",
+					Help:   "Polls of the scanner's node for a new block",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(stats.Polls),
+				},
+				{
+					Name:   "teller_scanner_blocks_total",
+					Help:   "New blocks observed by the scanner",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(stats.Blocks),
+				},
+			}...)
+		}
+
+		return ms, nil
+	}
+}
+
+// DBGatherer gathers the size, freelist and bucket key counts of the teller db.
+// A db whose free bytes keep growing can be shrunk by a compaction.
+func DBGatherer(db DBStatsGetter) Gatherer {
+	return func() ([]Metric, error) {
+		stats, err := db.DBStats()
+		if err != nil {
+			return nil, err
+		}
+
+		ms := []Metric{
+			{
+				Name:  "teller_db_size_bytes",
+				Help:  "Size of the db file",
+				Type:  TypeGauge,
+				Value: float64(stats.Size),
+			},
+			{
+				Name:  "teller_db_free_pages",
+				Help:  "Pages of the db file free for reuse",
+				Type:  TypeGauge,
+				Value: float64(stats.FreePages),
+			},
+			{
+				Name:  "teller_db_pending_pages",
+				Help:  "Pages of the db file freed but still used by an open read transaction",
+				Type:  TypeGauge,
+				Value: float64(stats.PendingPages),
+			},
+			{
+				Name:  "teller_db_free_bytes",
+				Help:  "Bytes of the pages of the db file free for reuse",
+				Type:  TypeGauge,
+				Value: float64(stats.FreeBytes),
+			},
+			{
+				Name:  "teller_db_freelist_bytes",
+				Help:  "Bytes used by the freelist of the db",
+				Type:  TypeGauge,
+				Value: float64(stats.FreelistBytes),
+			},
+			{
+				Name:  "teller_db_read_txs_total",
+				Help:  "Read transactions of the db",
+				Type:  TypeCounter,
+				Value: float64(stats.ReadTxs),
+			},
+			{
+				Name:  "teller_db_open_read_txs",
+				Help:  "Read transactions of the db open now",
+				Type:  TypeGauge,
+				Value: float64(stats.OpenReadTxs),
+			},
+		}
+
+		for bucket, keys := range stats.BucketKeys {
+			ms = append(ms, Metric{
+				Name: "teller_db_bucket_keys",
+				Help: "Number of keys in a top level bucket of the db, including its nested buckets",
+				Type: TypeGauge,
+				Labels: map[string]string{
+					"bucket": bucket,
+				},
+				Value: float64(keys),
+			})
+		}
+
+		return ms, nil
+	}
+}
+
+// StatusCacheGatherer gathers the lookups of the deposit status cache.
+// Its hit rate is rate(teller_status_cache_hits_total) / (rate(teller_status_cache_hits_total) + rate(teller_status_cache_misses_total)).
+func StatusCacheGatherer(s StatusCacheStatsGetter) Gatherer {
+	return func() ([]Metric, error) {
+		stats := s.StatusCacheStats()
+		return []Metric{
+			{
+				Name:  "teller_status_cache_hits_total",
+				Help:  "Deposit status requests answered from the cache",
+				Type:  TypeCounter,
+				Value: float64(stats.Hits),
+			},
+			{
+				Name:  "teller_status_cache_misses_total",
+				Help:  "Deposit status requests which read the db",
+				Type:  TypeCounter,
+				Value: float64(stats.Misses),
+			},
+			{
+				Name:  "teller_status_cache_invalidations_total",
+				Help:  "Deposit status changes which dropped a skycoin address from the cache",
+				Type:  TypeCounter,
+				Value: float64(stats.Invalidations),
+			},
+			{
+				Name:  "teller_status_cache_entries",
+				Help:  "Skycoin addresses whose deposit statuses are cached",
+				Type:  TypeGauge,
+				Value: float64(stats.Entries),
+			},
+		}, nil
+	}
+}
+
+// ClockSkewGatherer gathers the offsets of the clock measured by the clock skew guard.
+// The offsets are positive if the clock is behind.
+func ClockSkewGatherer(g SkewStatusGetter) Gatherer {
+	return func() ([]Metric, error) {
+		status := g.Status()
+
+		var skewed float64
+		if status.Skewed {
+			skewed = 1
+		}
+
+		return []Metric{
+			{
+				Name:  "teller_clock_ntp_offset_seconds",
+				Help:  "Median offset of the NTP servers from the clock",
+				Type:  TypeGauge,
+				Value: status.NTPOffset.Seconds(),
+			},
+			{
+				Name:  "teller_clock_chain_offset_seconds",
+				Help:  "Offset of the newest chain tip's block time from the clock",
+				Type:  TypeGauge,
+				Value: status.ChainOffset.Seconds(),
+			},
+			{
+				Name:  "teller_clock_skewed",
+				Help:  "1 while the clock is skewed and time-sensitive operations are paused",
+				Type:  TypeGauge,
+				Value: skewed,
+			},
+		}, nil
+	}
+}
+
+// ForecastGatherer gathers the bind rate and predicted exhaustion of each deposit address pool
+func ForecastGatherer(g ForecastGetter) Gatherer {
+	return func() ([]Metric, error) {
+		var ms []Metric
+		for _, fc := range g.Forecasts() {
+			labels := map[string]string{
+				"coin_type": fc.CoinType,
+			}
+
+			var alert float64
+			if fc.Alert {
+				alert = 1
+			}
+
+			ms = append(ms, []Metric{
+				{
+					Name:   "teller_deposit_address_bind_rate",
+					Help:   "Deposit addresses used per hour over the forecast window, net of the returned addresses",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  fc.BindRate,
+				},
+				{
+					Name:   "teller_deposit_address_forecast_alert",
+					Help:   "1 while the deposit address pool is predicted to run out within the alert horizon",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  alert,
+				},
+			}...)
+
+			// Not reported while the pool isn't shrinking
+			if fc.ExhaustedAt != nil {
+				ms = append(ms, Metric{
+					Name:   "teller_deposit_address_exhaustion_seconds",
+					Help:   "Predicted time until the deposit address pool runs out at the bind rate",
+					Type:   TypeGauge,
+					Labels: labels,
+					Value:  fc.ExhaustedAt.Sub(fc.CheckedAt).Seconds(),
+				})
+			}
+		}
+
+		return ms, nil
+	}
+}
+
+// ReplicationGatherer gathers the lag of the db's replica, at the primary or the standby
+func ReplicationGatherer(g ReplicationStatusGetter) Gatherer {
+	return func() ([]Metric, error) {
+		status, err := g.Status()
+		if err != nil {
+			return nil, err
+		}
+
+		labels := map[string]string{
+			"role": status.Role,
+		}
+
+		ms := []Metric{
+			{
+				Name:   "teller_replication_txid",
+				Help:   "Db transaction of the primary's db, or of the standby's replica",
+				Type:   TypeGauge,
+				Labels: labels,
+				Value:  float64(status.TxID),
+			},
+			{
+				Name:   "teller_replication_sync_bytes",
+				Help:   "Size of the chunks sent by the last replication sync",
+				Type:   TypeGauge,
+				Labels: labels,
+				Value:  float64(status.SyncBytes),
+			},
+			{
+				Name:   "teller_replication_failures",
+				Help:   "Consecutive replication syncs which failed, at the standby",
+				Type:   TypeGauge,
+				Labels: labels,
+				Value:  float64(status.Failures),
+			},
+		}
+
+		// Not reported until the first sync
+		if status.SyncedAt != nil {
+			ms = append(ms, Metric{
+				Name:   "teller_replication_lag_seconds",
+				Help:   "How long the db may have changed without the replica",
+				Type:   TypeGauge,
+				Labels: labels,
+				Value:  status.Lag,
+			})
+		}
+
+		return ms, nil
+	}
+}
+
+// RateLimitGatherer gathers the requests of each rate limited API endpoint.
+// The requests of the clients exempt from the rate limits are counted apart, in teller_http_rate_limit_exempt_requests_total.
+func RateLimitGatherer(g RateLimitStatsGetter) Gatherer {
+	return func() ([]Metric, error) {
+		var ms []Metric
+		for _, stats := range g.RateLimitStats() {
+			labels := map[string]string{
+				"path": stats.Path,
+			}
+
+			ms = append(ms, []Metric{
+				{
+					Name:   "teller_http_rate_limit_requests_total",
+					Help:   "Requests of the rate limited clients to the endpoint, including the refused ones",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(stats.Requests),
+				},
+				{
+					Name:   "teller_http_rate_limit_limited_total",
+					Help:   "Requests to the endpoint refused by its rate limit",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(stats.Limited),
+				},
+				{
+					Name:   "teller_http_rate_limit_exempt_requests_total",
+					Help:   "Requests of the clients exempt from the rate limits to the endpoint",
+					Type:   TypeCounter,
+					Labels: labels,
+					Value:  float64(stats.Exempt),
+				},
+			}...)
+		}
+
+		return ms, nil
+	}
+}
@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/replica"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/teller"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+type dummyExchange struct{}
+
+func (dummyExchange) GetDepositStats() (*exchange.DepositStats, error) {
+	return &exchange.DepositStats{
+		TotalBTCReceived: 1e8,
+		TotalSKYSent:     5e8,
+	}, nil
+}
+
+func (dummyExchange) GetLedgerReport() (*exchange.LedgerReport, error) {
+	return &exchange.LedgerReport{
+		Balances: exchange.LedgerBalances{
+			"BTC": {
+				exchange.AccountDepositsReceived: 1e8,
+			},
+		},
+	}, nil
+}
+
+type dummyAddrManager struct{}
+
+func (dummyAddrManager) Remaining(coinType string) (uint64, error) {
+	return 7, nil
+}
+
+func (dummyAddrManager) QueueStats() map[string]addrs.QueueStats {
+	return map[string]addrs.QueueStats{
+		"BTC": {
+			Depth:   3,
+			Served:  10,
+			MaxWait: time.Millisecond * 1500,
+		},
+	}
+}
+
+type dummyPoll struct{}
+
+func (dummyPoll) Stats() scanner.PollStats {
+	return scanner.PollStats{
+		Period:        time.Second * 30,
+		BlockInterval: time.Minute * 10,
+		Polls:         42,
+		Blocks:        3,
+	}
+}
+
+type dummyDB struct{}
+
+func (dummyDB) DBStats() (dbutil.Stats, error) {
+	return dbutil.Stats{
+		Size:      1 << 20,
+		FreePages: 12,
+		FreeBytes: 12 * 4096,
+		BucketKeys: map[string]int{
+			"deposit_info": 40,
+		},
+	}, nil
+}
+
+type dummyStatusCache struct{}
+
+type dummySkewGuard struct{}
+
+func (dummySkewGuard) Status() clock.SkewStatus {
+	return clock.SkewStatus{
+		NTPOffset:   -time.Minute * 5,
+		ChainOffset: time.Second * 30,
+		Skewed:      true,
+	}
+}
+
+type dummyForecaster struct{}
+
+func (dummyForecaster) Forecasts() []addrs.Forecast {
+	now := time.Now()
+	exhaustedAt := now.Add(time.Hour * 2)
+	return []addrs.Forecast{
+		{
+			CoinType:    "BTC",
+			BindRate:    12.5,
+			ExhaustedAt: &exhaustedAt,
+			Alert:       true,
+			CheckedAt:   now,
+		},
+		{
+			CoinType:  "ETH",
+			CheckedAt: now,
+		},
+	}
+}
+
+func (dummyStatusCache) StatusCacheStats() exchange.StatusCacheStats {
+	return exchange.StatusCacheStats{
+		Hits:          90,
+		Misses:        10,
+		Invalidations: 4,
+		Entries:       6,
+	}
+}
+
+// findMetric returns the value of the sample with name and labels
+func findMetric(t *testing.T, ms []Metric, name string, labels map[string]string) float64 {
+	for _, m := range ms {
+		if m.Name == name && len(m.Labels) == len(labels) {
+			match := true
+			for k, v := range labels {
+				if m.Labels[k] != v {
+					match = false
+				}
+			}
+			if match {
+				return m.Value
+			}
+		}
+	}
+	t.Fatalf("Metric %s %v not found", name, labels)
+	return 0
+}
+
+func TestExchangeGatherer(t *testing.T) {
+	ms, err := ExchangeGatherer(dummyExchange{})()
+	require.NoError(t, err)
+
+	require.Equal(t, 1e8, findMetric(t, ms, "teller_btc_received_satoshis_total", nil))
+	require.Equal(t, 5e8, findMetric(t, ms, "teller_sky_sent_droplets_total", nil))
+	require.Equal(t, 0.0, findMetric(t, ms, "teller_ledger_drifted_accounts", nil))
+	require.Equal(t, 1e8, findMetric(t, ms, "teller_ledger_balance", map[string]string{
+		"currency": "BTC",
+		"account":  exchange.AccountDepositsReceived,
+	}))
+}
+
+func TestAddrGatherer(t *testing.T) {
+	ms, err := AddrGatherer(dummyAddrManager{})()
+	require.NoError(t, err)
+
+	labels := map[string]string{"coin_type": "BTC"}
+	require.Equal(t, 7.0, findMetric(t, ms, "teller_deposit_addresses_remaining", labels))
+	require.Equal(t, 3.0, findMetric(t, ms, "teller_bind_queue_depth", labels))
+	require.Equal(t, 10.0, findMetric(t, ms, "teller_bind_queue_served_total", labels))
+	require.Equal(t, 1.5, findMetric(t, ms, "teller_bind_queue_max_wait_seconds", labels))
+}
+
+func TestScannerGatherer(t *testing.T) {
+	ms, err := ScannerGatherer(map[string]PollStatsGetter{
+		"BTC": dummyPoll{},
+	})()
+	require.NoError(t, err)
+
+	labels := map[string]string{"coin_type": "BTC"}
+	require.Equal(t, 30.0, findMetric(t, ms, "teller_scanner_poll_period_seconds", labels))
+	require.Equal(t, 600.0, findMetric(t, ms, "teller_scanner_block_interval_seconds", labels))
+	require.Equal(t, 42.0, findMetric(t, ms, "teller_scanner_polls_total", labels))
+	require.Equal(t, 3.0, findMetric(t, ms, "teller_scanner_blocks_total", labels))
+}
+
+func TestDBGatherer(t *testing.T) {
+	ms, err := DBGatherer(dummyDB{})()
+	require.NoError(t, err)
+
+	require.Equal(t, float64(1<<20), findMetric(t, ms, "teller_db_size_bytes", nil))
+	require.Equal(t, 12.0, findMetric(t, ms, "teller_db_free_pages", nil))
+	require.Equal(t, 12.0*4096, findMetric(t, ms, "teller_db_free_bytes", nil))
+	require.Equal(t, 40.0, findMetric(t, ms, "teller_db_bucket_keys", map[string]string{
+		"bucket": "deposit_info",
+	}))
+}
+
+func TestStatusCacheGatherer(t *testing.T) {
+	ms, err := StatusCacheGatherer(dummyStatusCache{})()
+	require.NoError(t, err)
+
+	require.Equal(t, 90.0, findMetric(t, ms, "teller_status_cache_hits_total", nil))
+	require.Equal(t, 10.0, findMetric(t, ms, "teller_status_cache_misses_total", nil))
+	require.Equal(t, 4.0, findMetric(t, ms, "teller_status_cache_invalidations_total", nil))
+	require.Equal(t, 6.0, findMetric(t, ms, "teller_status_cache_entries", nil))
+}
+
+func TestClockSkewGatherer(t *testing.T) {
+	ms, err := ClockSkewGatherer(dummySkewGuard{})()
+	require.NoError(t, err)
+
+	require.Equal(t, -300.0, findMetric(t, ms, "teller_clock_ntp_offset_seconds", nil))
+	require.Equal(t, 30.0, findMetric(t, ms, "teller_clock_chain_offset_seconds", nil))
+	require.Equal(t, 1.0, findMetric(t, ms, "teller_clock_skewed", nil))
+
+	// The guard is disabled
+	var g *clock.SkewGuard
+	ms, err = ClockSkewGatherer(g)()
+	require.NoError(t, err)
+	require.Equal(t, 0.0, findMetric(t, ms, "teller_clock_skewed", nil))
+}
+
+type dummyReplica struct {
+	status replica.Status
+}
+
+func (r dummyReplica) Status() (replica.Status, error) {
+	return r.status, nil
+}
+
+func TestReplicationGatherer(t *testing.T) {
+	standby := map[string]string{"role": replica.RoleStandby}
+
+	// Not synced yet
+	ms, err := ReplicationGatherer(dummyReplica{replica.Status{
+		Role:     replica.RoleStandby,
+		Failures: 3,
+	}})()
+	require.NoError(t, err)
+	require.Equal(t, 3.0, findMetric(t, ms, "teller_replication_failures", standby))
+	for _, m := range ms {
+		require.NotEqual(t, "teller_replication_lag_seconds", m.Name)
+	}
+
+	syncedAt := time.Now()
+	ms, err = ReplicationGatherer(dummyReplica{replica.Status{
+		Role:      replica.RoleStandby,
+		TxID:      42,
+		SyncedAt:  &syncedAt,
+		Lag:       2.5,
+		SyncBytes: 65536,
+	}})()
+	require.NoError(t, err)
+	require.Equal(t, 42.0, findMetric(t, ms, "teller_replication_txid", standby))
+	require.Equal(t, 2.5, findMetric(t, ms, "teller_replication_lag_seconds", standby))
+	require.Equal(t, 65536.0, findMetric(t, ms, "teller_replication_sync_bytes", standby))
+	require.Equal(t, 0.0, findMetric(t, ms, "teller_replication_failures", standby))
+}
+
+func TestForecastGatherer(t *testing.T) {
+	ms, err := ForecastGatherer(dummyForecaster{})()
+	require.NoError(t, err)
+
+	btc := map[string]string{"coin_type": "BTC"}
+	require.Equal(t, 12.5, findMetric(t, ms, "teller_deposit_address_bind_rate", btc))
+	require.Equal(t, 7200.0, findMetric(t, ms, "teller_deposit_address_exhaustion_seconds", btc))
+	require.Equal(t, 1.0, findMetric(t, ms, "teller_deposit_address_forecast_alert", btc))
+
+	// A pool which isn't shrinking has no exhaustion time
+	eth := map[string]string{"coin_type": "ETH"}
+	require.Equal(t, 0.0, findMetric(t, ms, "teller_deposit_address_bind_rate", eth))
+	require.Equal(t, 0.0, findMetric(t, ms, "teller_deposit_address_forecast_alert", eth))
+	for _, m := range ms {
+		require.False(t, m.Name == "teller_deposit_address_exhaustion_seconds" && m.Labels["coin_type"] == "ETH")
+	}
+}
+
+type dummyRateLimits struct{}
+
+func (dummyRateLimits) RateLimitStats() []teller.RateLimitStats {
+	return []teller.RateLimitStats{
+		{
+			Path:     "/api/status",
+			Requests: 100,
+			Limited:  5,
+			Exempt:   40,
+		},
+	}
+}
+
+func TestRateLimitGatherer(t *testing.T) {
+	ms, err := RateLimitGatherer(dummyRateLimits{})()
+	require.NoError(t, err)
+
+	labels := map[string]string{"path": "/api/status"}
+	require.Equal(t, 100.0, findMetric(t, ms, "teller_http_rate_limit_requests_total", labels))
+	require.Equal(t, 5.0, findMetric(t, ms, "teller_http_rate_limit_limited_total", labels))
+	require.Equal(t, 40.0, findMetric(t, ms, "teller_http_rate_limit_exempt_requests_total", labels))
+}
@@ -0,0 +1,109 @@
+// Package metrics exports teller's metrics in the Prometheus text format
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TypeGauge is a value which can go up and down
+	TypeGauge = "gauge"
+	// TypeCounter is a value which only increases
+	TypeCounter = "counter"
+
+	// ContentType is the content type of the text exposition format
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// Metric is a single sample
+type Metric struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Gatherer returns the current value of a group of metrics
+type Gatherer func() ([]Metric, error)
+
+// Gather calls every gatherer and returns all of their metrics
+func Gather(gatherers []Gatherer) ([]Metric, error) {
+	var ms []Metric
+	for _, g := range gatherers {
+		m, err := g()
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m...)
+	}
+	return ms, nil
+}
+
+// WriteText writes metrics in the Prometheus text exposition format.
+// Samples with the same name are grouped under a single HELP and TYPE line.
+func WriteText(w io.Writer, ms []Metric) error {
+	var names []string
+	byName := make(map[string][]Metric)
+	for _, m := range ms {
+		if _, ok := byName[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		byName[m.Name] = append(byName[m.Name], m)
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		group := byName[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeHelp(group[0].Help))
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, group[0].Type)
+		for _, m := range group {
+			buf.WriteString(name)
+			writeLabels(&buf, m.Labels)
+			buf.WriteByte(' ')
+			buf.WriteString(strconv.FormatFloat(m.Value, 'g', -1, 64))
+			buf.WriteByte('\n')
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeLabels(buf *bytes.Buffer, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, "%s=\"%s\"", k, escapeLabelValue(labels[k]))
+	}
+	buf.WriteByte('}')
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	ms := []Metric{
+		{
+			Name:   "teller_deposit_addresses_remaining",
+			Help:   "Number of unused deposit addresses",
+			Type:   TypeGauge,
+			Labels: map[string]string{"coin_type": "BTC"},
+			Value:  10,
+		},
+		{
+			Name:  "teller_sky_sent_droplets_total",
+			Help:  "Total SKY sent\nfor deposits",
+			Type:  TypeCounter,
+			Value: 1.5e9,
+		},
+		{
+			Name: "teller_deposit_addresses_remaining",
+			Help: "Number of unused deposit addresses",
+			Type: TypeGauge,
+			Labels: map[string]string{
+				"coin_type": `E"T\H`,
+				"a":         "b",
+			},
+			Value: 0.25,
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteText(&buf, ms))
+
+	expected := `# HELP teller_deposit_addresses_remaining Number of unused deposit addresses
+# TYPE teller_deposit_addresses_remaining gauge
+teller_deposit_addresses_remaining{coin_type="BTC"} 10
+teller_deposit_addresses_remaining{a="b",coin_type="E\"T\\H"} 0.25
+# HELP teller_sky_sent_droplets_total Total SKY sent\nfor deposits
+# TYPE teller_sky_sent_droplets_total counter
+teller_sky_sent_droplets_total 1.5e+09
+`
+	require.Equal(t, expected, buf.String())
+}
+
+func TestGather(t *testing.T) {
+	a := func() ([]Metric, error) {
+		return []Metric{{Name: "a"}}, nil
+	}
+	b := func() ([]Metric, error) {
+		return []Metric{{Name: "b"}, {Name: "c"}}, nil
+	}
+
+	ms, err := Gather([]Gatherer{a, b})
+	require.NoError(t, err)
+	require.Equal(t, []Metric{{Name: "a"}, {Name: "b"}, {Name: "c"}}, ms)
+
+	gatherErr := errors.New("gather failed")
+	_, err = Gather([]Gatherer{a, func() ([]Metric, error) {
+		return nil, gatherErr
+	}})
+	require.Equal(t, gatherErr, err)
+}
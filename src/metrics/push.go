@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const pushTimeout = time.Second * 10
+
+// PushConfig configures the Pusher
+type PushConfig struct {
+	// Base URL of the Prometheus Pushgateway
+	URL string
+	// Job and Instance group the pushed metrics in the Pushgateway
+	Job      string
+	Instance string
+	// How often to push. Metrics are always pushed once more on shutdown.
+	Interval time.Duration
+}
+
+// Validate returns an error if the configuration is invalid
+func (c PushConfig) Validate() error {
+	if c.URL == "" {
+		return errors.New("Pushgateway URL missing")
+	}
+
+	if c.Job == "" {
+		return errors.New("Pushgateway job missing")
+	}
+
+	if c.Interval <= 0 {
+		return errors.New("Push interval must be > 0")
+	}
+
+	return nil
+}
+
+// Pusher pushes metrics to a Prometheus Pushgateway periodically and on shutdown,
+// so that the metrics of short-lived tellers aren't lost
+type Pusher struct {
+	log       logrus.FieldLogger
+	cfg       PushConfig
+	gatherers []Gatherer
+	client    *http.Client
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+// NewPusher creates a Pusher
+func NewPusher(log logrus.FieldLogger, cfg PushConfig, gatherers ...Gatherer) (*Pusher, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &Pusher{
+		log:       log.WithField("prefix", "teller.metrics"),
+		cfg:       cfg,
+		gatherers: gatherers,
+		client:    httpclient.New(pushTimeout),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// Run pushes the metrics every interval until Shutdown is called, then pushes them a final time
+func (p *Pusher) Run() error {
+	log := p.log.WithField("config", p.cfg)
+	log.Info("Start metrics push service")
+	defer log.Info("Metrics push service closed")
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.quit:
+			if err := p.Push(); err != nil {
+				log.WithError(err).Error("Final metrics push failed")
+			}
+			return nil
+		case <-ticker.C:
+			if err := p.Push(); err != nil {
+				log.WithError(err).Error("Metrics push failed")
+			}
+		}
+	}
+}
+
+// Shutdown pushes the metrics a final time and stops the Pusher
+func (p *Pusher) Shutdown() {
+	close(p.quit)
+	<-p.done
+}
+
+// Push gathers the metrics and replaces this instance's group in the Pushgateway
+func (p *Pusher) Push() error {
+	ms, err := Gather(p.gatherers)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf, ms); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, p.groupURL(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK && rsp.StatusCode != http.StatusAccepted {
+		body, _ := ioutil.ReadAll(rsp.Body) // nolint: errcheck
+		return fmt.Errorf("Pushgateway returned status %d: %s", rsp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// groupURL returns the Pushgateway URL of the job and instance's grouping key
+func (p *Pusher) groupURL() string {
+	u := strings.TrimRight(p.cfg.URL, "/") + "/metrics/job/" + url.PathEscape(p.cfg.Job)
+	if p.cfg.Instance != "" {
+		u += "/instance/" + url.PathEscape(p.cfg.Instance)
+	}
+	return u
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type dummyPushgateway struct {
+	sync.Mutex
+	status int
+	paths  []string
+	bodies []string
+}
+
+func (g *dummyPushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.Lock()
+	defer g.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body) // nolint: errcheck
+	if r.Method != http.MethodPut || r.Header.Get("Content-Type") != ContentType {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	g.paths = append(g.paths, r.URL.EscapedPath())
+	g.bodies = append(g.bodies, string(body))
+	w.WriteHeader(g.status)
+}
+
+func (g *dummyPushgateway) pushes() int {
+	g.Lock()
+	defer g.Unlock()
+	return len(g.bodies)
+}
+
+func TestPusherPush(t *testing.T) {
+	gw := &dummyPushgateway{status: http.StatusOK}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	log, _ := testutil.NewLogger(t)
+
+	value := 1.0
+	p, err := NewPusher(log, PushConfig{
+		URL:      srv.URL + "/",
+		Job:      "teller",
+		Instance: "rehearsal/1",
+		Interval: time.Hour,
+	}, func() ([]Metric, error) {
+		return []Metric{{Name: "teller_test", Help: "Test", Type: TypeGauge, Value: value}}, nil
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, p.Push())
+	require.Equal(t, []string{"/metrics/job/teller/instance/rehearsal%2F1"}, gw.paths)
+	require.Equal(t, "# HELP teller_test Test\n# TYPE teller_test gauge\nteller_test 1\n", gw.bodies[0])
+
+	gw.status = http.StatusInternalServerError
+	require.Error(t, p.Push())
+}
+
+func TestPusherRunPushesOnShutdown(t *testing.T) {
+	gw := &dummyPushgateway{status: http.StatusOK}
+	srv := httptest.NewServer(gw)
+	defer srv.Close()
+
+	log, _ := testutil.NewLogger(t)
+
+	p, err := NewPusher(log, PushConfig{
+		URL:      srv.URL,
+		Job:      "teller",
+		Interval: time.Millisecond * 10,
+	})
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, p.Run())
+	}()
+
+	// Pushes on the interval
+	for i := 0; i < 100 && gw.pushes() < 2; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.True(t, gw.pushes() >= 2)
+
+	// Pushes once more on shutdown
+	n := gw.pushes()
+	p.Shutdown()
+	<-done
+	require.True(t, gw.pushes() > n)
+	require.Equal(t, "/metrics/job/teller", gw.paths[len(gw.paths)-1])
+}
+
+func TestNewPusherInvalidConfig(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	_, err := NewPusher(log, PushConfig{
+		Job:      "teller",
+		Interval: time.Second,
+	})
+	require.Error(t, err)
+
+	_, err = NewPusher(log, PushConfig{
+		URL:      "http://localhost:9091",
+		Job:      "teller",
+		Interval: 0,
+	})
+	require.Error(t, err)
+}
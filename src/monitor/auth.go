@@ -0,0 +1,453 @@
+package monitor
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+const (
+	sessionCookieName = "teller_admin_session"
+	csrfHeader        = "X-CSRF-Token"
+	challengeTTL      = time.Minute * 2
+	challengeLen      = 32
+	sessionTokenLen   = 32
+)
+
+// AuthConfig configures login sessions for the monitor service
+type AuthConfig struct {
+	Enabled             bool
+	SessionTTL          time.Duration
+	SecureCookie        bool
+	TOTPSecret          string
+	WebAuthnRPID        string
+	WebAuthnOrigin      string
+	WebAuthnCredentials []string
+}
+
+type session struct {
+	csrfToken string
+	expiresAt time.Time
+}
+
+// auth manages admin login sessions.
+// A session is created after a successful WebAuthn assertion, or a TOTP code
+// if configured as a fallback. The session token is kept in an HttpOnly cookie,
+// and state changing requests must also send the session's CSRF token in a header.
+type auth struct {
+	sync.Mutex
+	cfg        AuthConfig
+	totp       *totp
+	throttle   *totpThrottle // failed TOTP codes, nil if TOTP login is not configured
+	webAuthn   *webAuthn
+	sessions   map[string]session
+	challenges map[string]time.Time
+}
+
+func newAuth(cfg AuthConfig) (*auth, error) {
+	a := &auth{
+		cfg:        cfg,
+		sessions:   make(map[string]session),
+		challenges: make(map[string]time.Time),
+	}
+
+	if cfg.TOTPSecret != "" {
+		t, err := newTOTP(cfg.TOTPSecret)
+		if err != nil {
+			return nil, err
+		}
+		a.totp = t
+		a.throttle = newTOTPThrottle()
+	}
+
+	if len(cfg.WebAuthnCredentials) != 0 {
+		w, err := newWebAuthn(cfg.WebAuthnRPID, cfg.WebAuthnOrigin, cfg.WebAuthnCredentials)
+		if err != nil {
+			return nil, err
+		}
+		a.webAuthn = w
+	}
+
+	if a.totp == nil && a.webAuthn == nil {
+		return nil, errors.New("No admin login method is configured")
+	}
+
+	return a, nil
+}
+
+func randomToken(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newChallenge creates a WebAuthn challenge which can be used once
+func (a *auth) newChallenge() ([]byte, error) {
+	c, err := randomToken(challengeLen)
+	if err != nil {
+		return nil, err
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	now := time.Now()
+	for k, exp := range a.challenges {
+		if now.After(exp) {
+			delete(a.challenges, k)
+		}
+	}
+
+	a.challenges[string(c)] = now.Add(challengeTTL)
+
+	return c, nil
+}
+
+// useChallenge returns true if the challenge was issued and has not expired,
+// and removes it so that it can't be replayed
+func (a *auth) useChallenge(c []byte) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	exp, ok := a.challenges[string(c)]
+	if !ok {
+		return false
+	}
+	delete(a.challenges, string(c))
+
+	return time.Now().Before(exp)
+}
+
+func (a *auth) newSession() (string, session, error) {
+	token, err := randomToken(sessionTokenLen)
+	if err != nil {
+		return "", session{}, err
+	}
+
+	csrf, err := randomToken(sessionTokenLen)
+	if err != nil {
+		return "", session{}, err
+	}
+
+	s := session{
+		csrfToken: hex.EncodeToString(csrf),
+		expiresAt: time.Now().Add(a.cfg.SessionTTL),
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	now := time.Now()
+	for k, v := range a.sessions {
+		if now.After(v.expiresAt) {
+			delete(a.sessions, k)
+		}
+	}
+
+	tokenStr := hex.EncodeToString(token)
+	a.sessions[tokenStr] = s
+
+	return tokenStr, s, nil
+}
+
+// getSession returns the session of a request, if it exists and has not expired
+func (a *auth) getSession(r *http.Request) (string, session, bool) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", session{}, false
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	s, ok := a.sessions[c.Value]
+	if !ok {
+		return "", session{}, false
+	}
+
+	if time.Now().After(s.expiresAt) {
+		delete(a.sessions, c.Value)
+		return "", session{}, false
+	}
+
+	return c.Value, s, true
+}
+
+func (a *auth) deleteSession(token string) {
+	a.Lock()
+	defer a.Unlock()
+	delete(a.sessions, token)
+}
+
+func (a *auth) setSessionCookie(w http.ResponseWriter, token string, s session) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  s.expiresAt,
+		MaxAge:   int(time.Until(s.expiresAt) / time.Second),
+		HttpOnly: true,
+		Secure:   a.cfg.SecureCookie,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// Require wraps a handler so that it is only accessible with a valid session.
+// Requests other than GET and HEAD must also carry the session's CSRF token.
+func (a *auth) Require(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, s, ok := a.getSession(r)
+		if !ok {
+			httputil.ErrResponse(w, http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(s.csrfToken)) != 1 {
+				httputil.ErrResponse(w, http.StatusForbidden, "Invalid CSRF token")
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+type sessionResponse struct {
+	CSRFToken string `json:"csrf_token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+type webAuthnChallengeResponse struct {
+	Challenge        string   `json:"challenge"`
+	RPID             string   `json:"rp_id"`
+	AllowCredentials []string `json:"allow_credentials"`
+	Timeout          int64    `json:"timeout"`
+}
+
+type totpLoginRequest struct {
+	Code string `json:"code"`
+}
+
+func (a *auth) login(w http.ResponseWriter, r *http.Request) {
+	log := logger.FromContext(r.Context())
+
+	token, s, err := a.newSession()
+	if err != nil {
+		log.WithError(err).Error("newSession failed")
+		httputil.ErrResponse(w, http.StatusInternalServerError)
+		return
+	}
+
+	a.setSessionCookie(w, token, s)
+
+	log.Info("Admin logged in")
+
+	if err := httputil.JSONResponse(w, sessionResponse{
+		CSRFToken: s.csrfToken,
+		ExpiresAt: s.expiresAt.Unix(),
+	}); err != nil {
+		log.WithError(err).Error("Write json response failed")
+	}
+}
+
+// webAuthnChallengeHandler issues a challenge for navigator.credentials.get()
+// Method: POST
+// URI: /api/auth/webauthn/challenge
+func (a *auth) webAuthnChallengeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logger.FromContext(r.Context())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if a.webAuthn == nil {
+			httputil.ErrResponse(w, http.StatusNotFound, "WebAuthn login is not configured")
+			return
+		}
+
+		c, err := a.newChallenge()
+		if err != nil {
+			log.WithError(err).Error("newChallenge failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, webAuthnChallengeResponse{
+			Challenge:        base64.RawURLEncoding.EncodeToString(c),
+			RPID:             a.webAuthn.rpID,
+			AllowCredentials: a.webAuthn.CredentialIDs(),
+			Timeout:          int64(challengeTTL / time.Millisecond),
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// webAuthnLoginHandler verifies a security key assertion and creates a session
+// Method: POST
+// URI: /api/auth/webauthn/login
+// Args:
+//     {"credential_id": "...", "client_data_json": "...", "authenticator_data": "...", "signature": "..."}
+func (a *auth) webAuthnLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logger.FromContext(r.Context())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if a.webAuthn == nil {
+			httputil.ErrResponse(w, http.StatusNotFound, "WebAuthn login is not configured")
+			return
+		}
+
+		var req webAuthnAssertion
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+			return
+		}
+		defer r.Body.Close()
+
+		if err := a.webAuthn.Verify(req, a.useChallenge); err != nil {
+			log.WithError(err).Warn("WebAuthn login failed")
+			httputil.ErrResponse(w, http.StatusUnauthorized)
+			return
+		}
+
+		a.login(w, r)
+	}
+}
+
+// totpLoginHandler verifies a TOTP code and creates a session
+// Method: POST
+// URI: /api/auth/totp/login
+// A client is locked out after 5 failed codes, and TOTP login after 20 failed codes within 15 minutes.
+// Args:
+//     {"code": "123456"}
+func (a *auth) totpLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logger.FromContext(r.Context())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if a.totp == nil {
+			httputil.ErrResponse(w, http.StatusNotFound, "TOTP login is not configured")
+			return
+		}
+
+		var req totpLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+			return
+		}
+		defer r.Body.Close()
+
+		now := time.Now()
+		client := clientIP(r)
+		if ok, wait := a.throttle.allow(client, now); !ok {
+			log.Warn("TOTP login refused, too many failed codes")
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+			httputil.ErrResponse(w, http.StatusTooManyRequests, "Too many failed codes, try again later")
+			return
+		}
+
+		if !a.totp.Verify(req.Code, now) {
+			log.Warn("TOTP login failed")
+			a.throttle.fail(client, now)
+			httputil.ErrResponse(w, http.StatusUnauthorized)
+			return
+		}
+
+		a.throttle.succeed(client)
+		a.login(w, r)
+	}
+}
+
+// clientIP returns the IP of the client of r, which the failed TOTP codes are counted by
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sessionHandler returns the current session's CSRF token and expiry
+// Method: GET
+// URI: /api/auth/session
+func (a *auth) sessionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log := logger.FromContext(r.Context())
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		_, s, ok := a.getSession(r)
+		if !ok {
+			httputil.ErrResponse(w, http.StatusUnauthorized)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, sessionResponse{
+			CSRFToken: s.csrfToken,
+			ExpiresAt: s.expiresAt.Unix(),
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// logoutHandler ends the current session
+// Method: POST
+// URI: /api/auth/logout
+func (a *auth) logoutHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		token, _, _ := a.getSession(r)
+		if token != "" {
+			a.deleteSession(token)
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   a.cfg.SecureCookie,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
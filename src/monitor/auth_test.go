@@ -0,0 +1,311 @@
+package monitor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// RFC 6238 test secret "12345678901234567890"
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	o, err := newTOTP(testTOTPSecret)
+	require.NoError(t, err)
+
+	// RFC 6238 appendix B SHA1 vectors, truncated to 6 digits
+	tt := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range tt {
+		require.Equal(t, tc.code, totpCode(o.secret, tc.unix/totpPeriod))
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	o, err := newTOTP(testTOTPSecret)
+	require.NoError(t, err)
+
+	now := time.Unix(1111111109, 0)
+
+	require.False(t, o.Verify("000000", now))
+	require.False(t, o.Verify("81804", now))
+
+	// accepted within the allowed skew
+	require.True(t, o.Verify("081804", now.Add(time.Second*totpPeriod)))
+
+	// a code can't be used twice
+	require.False(t, o.Verify("081804", now))
+}
+
+func TestTOTPThrottle(t *testing.T) {
+	th := newTOTPThrottle()
+	now := time.Unix(1520000000, 0)
+
+	fail := func(client string, n int) {
+		for i := 0; i < n; i++ {
+			th.fail(client, now)
+		}
+	}
+
+	allowed := func(client string) bool {
+		ok, _ := th.allow(client, now)
+		return ok
+	}
+
+	// A client is locked out after totpMaxFailures
+	fail("1.2.3.4", totpMaxFailures-1)
+	require.True(t, allowed("1.2.3.4"))
+	fail("1.2.3.4", 1)
+	ok, wait := th.allow("1.2.3.4", now)
+	require.False(t, ok)
+	require.Equal(t, totpLockout, wait)
+	require.True(t, allowed("5.6.7.8"))
+
+	// The lockout doubles at each further failure
+	now = now.Add(totpLockout)
+	require.True(t, allowed("1.2.3.4"))
+	fail("1.2.3.4", 1)
+	_, wait = th.allow("1.2.3.4", now)
+	require.Equal(t, totpLockout*2, wait)
+
+	// A login forgets the client's failures
+	th.succeed("1.2.3.4")
+	require.True(t, allowed("1.2.3.4"))
+
+	// The lockout is at most totpMaxLockout
+	th = newTOTPThrottle()
+	fail("1.2.3.4", totpMaxFailures+7)
+	_, wait = th.allow("1.2.3.4", now)
+	require.Equal(t, totpMaxLockout, wait)
+
+	// Failures spread over many clients lock out every client
+	th = newTOTPThrottle()
+	for i := 0; i < totpGlobalMaxFailures; i++ {
+		require.True(t, allowed("9.9.9.9"))
+		th.fail(fmt.Sprintf("10.0.0.%d", i), now)
+	}
+	ok, wait = th.allow("9.9.9.9", now)
+	require.False(t, ok)
+	require.Equal(t, totpGlobalWindow, wait)
+
+	now = now.Add(totpGlobalWindow)
+	require.True(t, allowed("9.9.9.9"))
+
+	// The global count restarts each window
+	th = newTOTPThrottle()
+	for i := 0; i < totpGlobalMaxFailures-1; i++ {
+		th.fail(fmt.Sprintf("10.0.0.%d", i), now)
+	}
+	now = now.Add(totpGlobalWindow + time.Second)
+	th.fail("10.0.1.1", now)
+	require.True(t, allowed("9.9.9.9"))
+}
+
+func TestTOTPLoginThrottled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	a, err := newAuth(AuthConfig{
+		Enabled:    true,
+		SessionTTL: time.Minute,
+		TOTPSecret: testTOTPSecret,
+	})
+	require.NoError(t, err)
+	h := a.totpLoginHandler()
+
+	do := func(remoteAddr, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/totp/login", strings.NewReader(`{"code":"`+code+`"}`))
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < totpMaxFailures; i++ {
+		require.Equal(t, http.StatusUnauthorized, do("1.2.3.4:5000", "000000").Code)
+	}
+
+	// The valid code of a locked out client is refused
+	code := totpCode(a.totp.secret, time.Now().Unix()/totpPeriod)
+	w := do("1.2.3.4:5001", code)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusOK, do("5.6.7.8:5000", code).Code)
+}
+
+type testSecurityKey struct {
+	id   []byte
+	priv *ecdsa.PrivateKey
+}
+
+func newTestSecurityKey(t *testing.T) testSecurityKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return testSecurityKey{
+		id:   []byte("test-credential"),
+		priv: priv,
+	}
+}
+
+func (k testSecurityKey) credential(t *testing.T) string {
+	der, err := x509.MarshalPKIXPublicKey(&k.priv.PublicKey)
+	require.NoError(t, err)
+	return base64.RawURLEncoding.EncodeToString(k.id) + ":" + base64.StdEncoding.EncodeToString(der)
+}
+
+func (k testSecurityKey) assert(t *testing.T, rpID, origin string, challenge []byte, signCount uint32) webAuthnAssertion {
+	clientData, err := json.Marshal(collectedClientData{
+		Type:      "webauthn.get",
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    origin,
+	})
+	require.NoError(t, err)
+
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	authData := append([]byte{}, rpIDHash[:]...)
+	authData = append(authData, authDataFlagUserPresent)
+	var count [4]byte
+	binary.BigEndian.PutUint32(count[:], signCount)
+	authData = append(authData, count[:]...)
+
+	clientDataHash := sha256.Sum256(clientData)
+	signed := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, k.priv, signed[:])
+	require.NoError(t, err)
+
+	enc := base64.RawURLEncoding.EncodeToString
+	return webAuthnAssertion{
+		CredentialID:      enc(k.id),
+		ClientDataJSON:    enc(clientData),
+		AuthenticatorData: enc(authData),
+		Signature:         enc(sig),
+	}
+}
+
+func TestWebAuthnVerify(t *testing.T) {
+	key := newTestSecurityKey(t)
+	rpID := "admin.example.com"
+	origin := "https://admin.example.com"
+
+	w, err := newWebAuthn(rpID, origin, []string{key.credential(t)})
+	require.NoError(t, err)
+
+	challenge := []byte("challenge")
+	accept := func(c []byte) bool { return string(c) == string(challenge) }
+
+	require.NoError(t, w.Verify(key.assert(t, rpID, origin, challenge, 1), accept))
+
+	// signature counter must increase
+	require.Equal(t, errSignCountNotIncrease, w.Verify(key.assert(t, rpID, origin, challenge, 1), accept))
+	require.NoError(t, w.Verify(key.assert(t, rpID, origin, challenge, 2), accept))
+
+	require.Equal(t, errInvalidClientData, w.Verify(key.assert(t, rpID, "https://evil.example.com", challenge, 3), accept))
+	require.Equal(t, errInvalidClientData, w.Verify(key.assert(t, rpID, origin, []byte("other"), 3), accept))
+	require.Equal(t, errInvalidAuthData, w.Verify(key.assert(t, "evil.example.com", origin, challenge, 3), accept))
+
+	a := key.assert(t, rpID, origin, challenge, 3)
+	a.Signature = key.assert(t, rpID, origin, challenge, 4).Signature
+	require.Equal(t, errInvalidSignature, w.Verify(a, accept))
+
+	other := newTestSecurityKey(t)
+	other.id = []byte("other-credential")
+	require.Equal(t, errUnknownCredential, w.Verify(other.assert(t, rpID, origin, challenge, 3), accept))
+}
+
+func TestParseWebAuthnCredential(t *testing.T) {
+	_, err := parseWebAuthnCredential(newTestSecurityKey(t).credential(t))
+	require.NoError(t, err)
+
+	// Only P-256 keys are supported
+	for _, curve := range []elliptic.Curve{elliptic.P384(), elliptic.P521()} {
+		priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+		key := testSecurityKey{
+			id:   []byte("test-credential"),
+			priv: priv,
+		}
+
+		_, err = parseWebAuthnCredential(key.credential(t))
+		require.Error(t, err, curve.Params().Name)
+	}
+}
+
+func TestAuthChallengeSingleUse(t *testing.T) {
+	a, err := newAuth(AuthConfig{
+		Enabled:    true,
+		SessionTTL: time.Minute,
+		TOTPSecret: testTOTPSecret,
+	})
+	require.NoError(t, err)
+
+	c, err := a.newChallenge()
+	require.NoError(t, err)
+
+	require.True(t, a.useChallenge(c))
+	require.False(t, a.useChallenge(c))
+	require.False(t, a.useChallenge([]byte("unknown")))
+}
+
+func TestAuthRequire(t *testing.T) {
+	a, err := newAuth(AuthConfig{
+		Enabled:    true,
+		SessionTTL: time.Minute,
+		TOTPSecret: testTOTPSecret,
+	})
+	require.NoError(t, err)
+
+	h := a.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(method string, cookie *http.Cookie, csrf string) int {
+		req := httptest.NewRequest(method, "/api/stats", strings.NewReader(""))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		if csrf != "" {
+			req.Header.Set(csrfHeader, csrf)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, nil, ""))
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, &http.Cookie{Name: sessionCookieName, Value: "bad"}, ""))
+
+	token, s, err := a.newSession()
+	require.NoError(t, err)
+	cookie := &http.Cookie{Name: sessionCookieName, Value: token}
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, cookie, ""))
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, cookie, ""))
+	require.Equal(t, http.StatusForbidden, do(http.MethodPost, cookie, "bad"))
+	require.Equal(t, http.StatusOK, do(http.MethodPost, cookie, s.csrfToken))
+
+	a.deleteSession(token)
+	require.Equal(t, http.StatusUnauthorized, do(http.MethodGet, cookie, ""))
+}
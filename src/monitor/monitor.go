@@ -4,15 +4,40 @@ package monitor
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/abuse"
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/capture"
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/explorer"
+	"github.com/skycoin/teller/src/handover"
+	"github.com/skycoin/teller/src/notify"
+	"github.com/skycoin/teller/src/replica"
+	"github.com/skycoin/teller/src/runbook"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/flagutil"
 	"github.com/skycoin/teller/src/util/httputil"
 	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/pauseutil"
+	"github.com/skycoin/teller/src/version"
 )
 
 const (
@@ -24,6 +49,14 @@ const (
 	serverReadTimeout  = time.Second * 10
 	serverWriteTimeout = time.Second * 60
 	serverIdleTimeout  = time.Second * 120
+
+	// auditLogDefaultLimit is the number of audit log entries returned if limit is not set
+	auditLogDefaultLimit = 1000
+	// auditLogMaxLimit is the maximum number of audit log entries returned by a request
+	auditLogMaxLimit = 10000
+
+	// AuditLogSignatureHeader is the response header of the signature of an audit log page
+	AuditLogSignatureHeader = "X-Audit-Log-Signature"
 )
 
 // AddrManager interface provides apis to access resource of btc address
@@ -33,8 +66,113 @@ type AddrManager interface {
 
 // DepositStatusGetter  interface provides api to access exchange resource
 type DepositStatusGetter interface {
-	GetDepositStatusDetail(flt exchange.DepositFilter) ([]exchange.DepositStatusDetail, error)
+	QueryDepositStatusDetail(q exchange.DepositQuery) ([]exchange.DepositStatusDetail, error)
 	GetDepositStats() (*exchange.DepositStats, error)
+	GetLedgerReport() (*exchange.LedgerReport, error)
+	ApproveDeposit(depositID, rate string, version uint64) (exchange.DepositInfo, error)
+	ExportDeposits(w io.Writer, format exchange.ExportFormat, flt exchange.ExportFilter) (int, error)
+	LookupOwners(depositAddr, txid string) ([]exchange.Owner, error)
+	GetDepositRecord(depositID string) (exchange.DepositRecord, error)
+	GetAuditLogPage(cursor uint64, limit int) (exchange.AuditLogPage, error)
+	SimulateRate(sim exchange.RateSimulation) (exchange.RateSimulationReport, error)
+}
+
+// DepositInspector fetches the transaction of a deposit from the coin's node, and compares it with the deposit
+type DepositInspector interface {
+	InspectDeposit(dv deposits.Deposit) (scanner.DepositInspection, error)
+}
+
+// QueueStatsGetter interface provides the coin types, their deposit address allocation queue stats and their pool shards
+type QueueStatsGetter interface {
+	CoinTypes() []string
+	QueueStats() map[string]addrs.QueueStats
+	Shards() []addrs.ShardStatus
+}
+
+// PoolForecaster returns the predicted exhaustion of the deposit address pools
+type PoolForecaster interface {
+	Forecasts() []addrs.Forecast
+}
+
+// RateFeedStatusGetter returns the rates agreed by the price feeds, and the health of each feed
+type RateFeedStatusGetter interface {
+	RateFeedStatuses() []exchange.RateFeedStatus
+}
+
+// StartupReporter returns how the last run stopped, and the startup check's findings
+type StartupReporter interface {
+	StartupReport() exchange.StartupReport
+}
+
+// RunbookStatusGetter returns the state of the runbook's rules
+type RunbookStatusGetter interface {
+	Status() runbook.Status
+}
+
+// CoinHoursGetter returns the coin hours of the hot wallet, and the hours needed by the queued sends
+type CoinHoursGetter interface {
+	Status() sender.HoursStatus
+}
+
+// ReplicationSource serves the snapshots of the db to the standby
+type ReplicationSource interface {
+	ServeSync(w http.ResponseWriter, r *http.Request)
+	Status() (replica.Status, error)
+}
+
+// FeatureFlags are the feature flags which operators flip at runtime
+type FeatureFlags interface {
+	Flags() map[string]bool
+	Statuses() []flagutil.Status
+	Enable(name string) (flagutil.Status, error)
+	Disable(name string) (flagutil.Status, error)
+	Reset(name string) (flagutil.Status, error)
+}
+
+// ContactEraser deletes the contact emails of a skycoin address's bindings
+type ContactEraser interface {
+	EraseContacts(skyAddr string) (int, error)
+}
+
+// EmailPreviewer renders the contact emails with example data
+type EmailPreviewer interface {
+	PreviewEmail(event, language string) (notify.Preview, error)
+}
+
+// Handover hands the db over to a new teller instance
+type Handover interface {
+	HandoverState() string
+	Quiesce() error
+	Resume() error
+	Release() error
+}
+
+// Subsystems pauses and resumes teller's subsystems by name
+type Subsystems interface {
+	Statuses() []pauseutil.Status
+	Pause(name string) (pauseutil.Status, error)
+	Resume(name string) (pauseutil.Status, error)
+}
+
+// DBCompactor reports the stats of the teller db and takes requests to compact it
+type DBCompactor interface {
+	DBStats() (dbutil.Stats, error)
+	RequestCompact()
+	Requested() <-chan struct{}
+}
+
+// AbuseStatsGetter reports the anomalous clients seen by the abuse detection
+type AbuseStatsGetter interface {
+	Stats() abuse.Stats
+}
+
+// Capturer records the requests and responses of the API during a capture session
+type Capturer interface {
+	Start(f capture.Filter, d time.Duration) (capture.Session, error)
+	Stop() (capture.Session, error)
+	Session() *capture.Session
+	Records(sessionID string, limit int) ([]capture.Record, error)
+	Clear() (int, error)
 }
 
 // ScanAddressGetter get scanning address interface
@@ -45,6 +183,17 @@ type ScanAddressGetter interface {
 // Config configuration info for monitor service
 type Config struct {
 	Addr string
+	Auth AuthConfig
+	// HandoverToken authorizes the handover endpoints, which are disabled if it is empty
+	HandoverToken string
+	// ReplicationToken authorizes the standby's sync endpoint, which is disabled if it is empty
+	ReplicationToken string
+	// DBPath is the teller db file, whose size is reported by /api/runtime
+	DBPath string
+	// Explorer links of the approved deposits
+	Explorer explorer.Links
+	// AuditLogKey signs the audit log pages, which are not exported if it is null
+	AuditLogKey cipher.SecKey
 }
 
 // Monitor monitor service struct
@@ -54,13 +203,35 @@ type Monitor struct {
 	EthAddrManager AddrManager
 	DepositStatusGetter
 	ScanAddressGetter
-	cfg  Config
-	ln   *http.Server
-	quit chan struct{}
+	QueueStatsGetter
+	ContactEraser
+	Handover
+	Subsystems  Subsystems
+	DB          DBCompactor
+	Abuse       AbuseStatsGetter
+	Capture     Capturer
+	Inspector   DepositInspector
+	Forecaster  PoolForecaster
+	Emails      EmailPreviewer
+	RateFeeds   RateFeedStatusGetter
+	Features    FeatureFlags
+	Replication ReplicationSource
+	Startup     StartupReporter
+	Runbook     RunbookStatusGetter
+	CoinHours   CoinHoursGetter
+	cfg         Config
+	auth        *auth
+	ln          *http.Server
+	quit        chan struct{}
 }
 
-// New creates monitor service
-func New(log logrus.FieldLogger, cfg Config, addrManager, ethAddrManager AddrManager, dpstget DepositStatusGetter, sag ScanAddressGetter) *Monitor {
+// New creates monitor service. ce is nil if contact emails are disabled, db is nil if the db can't be compacted,
+// capturer is nil if capturing requests is disabled, di is nil if deposits can't be inspected,
+// pf is nil if the address pools aren't forecast, ep is nil if contact emails are disabled,
+// rf is nil if the rates aren't taken from price feeds, rs is nil if the db isn't replicated,
+// sr is nil if teller doesn't save run state markers, rb is nil if the runbook is disabled,
+// and ch is nil if the coin hours aren't tracked.
+func New(log logrus.FieldLogger, cfg Config, addrManager, ethAddrManager AddrManager, dpstget DepositStatusGetter, sag ScanAddressGetter, qsg QueueStatsGetter, ce ContactEraser, ho Handover, ss Subsystems, db DBCompactor, as AbuseStatsGetter, capturer Capturer, di DepositInspector, pf PoolForecaster, ep EmailPreviewer, rf RateFeedStatusGetter, ff FeatureFlags, rs ReplicationSource, sr StartupReporter, rb RunbookStatusGetter, ch CoinHoursGetter) *Monitor {
 	return &Monitor{
 		log:                 log.WithField("prefix", "teller.monitor"),
 		cfg:                 cfg,
@@ -68,6 +239,22 @@ func New(log logrus.FieldLogger, cfg Config, addrManager, ethAddrManager AddrMan
 		EthAddrManager:      ethAddrManager,
 		DepositStatusGetter: dpstget,
 		ScanAddressGetter:   sag,
+		QueueStatsGetter:    qsg,
+		ContactEraser:       ce,
+		Handover:            ho,
+		Subsystems:          ss,
+		DB:                  db,
+		Abuse:               as,
+		Capture:             capturer,
+		Inspector:           di,
+		Forecaster:          pf,
+		Emails:              ep,
+		RateFeeds:           rf,
+		Features:            ff,
+		Replication:         rs,
+		Startup:             sr,
+		Runbook:             rb,
+		CoinHours:           ch,
 		quit:                make(chan struct{}),
 	}
 }
@@ -78,6 +265,15 @@ func (m *Monitor) Run() error {
 	log.Info("Start monitor service...")
 	defer log.Info("Monitor Service closed")
 
+	if m.cfg.Auth.Enabled {
+		a, err := newAuth(m.cfg.Auth)
+		if err != nil {
+			log.WithError(err).Error("Configure admin login failed")
+			return err
+		}
+		m.auth = a
+	}
+
 	mux := m.setupMux()
 
 	m.ln = &http.Server{
@@ -102,9 +298,67 @@ func (m *Monitor) Run() error {
 func (m *Monitor) setupMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	mux.Handle("/api/address", httputil.LogHandler(m.log, m.addressHandler()))
-	mux.Handle("/api/deposit_status", httputil.LogHandler(m.log, m.depositStatus()))
-	mux.Handle("/api/stats", httputil.LogHandler(m.log, m.statsHandler()))
+	// Endpoints require a login session when admin auth is enabled
+	requireAuth := func(h http.Handler) http.Handler {
+		if m.auth == nil {
+			return h
+		}
+		return m.auth.Require(h)
+	}
+
+	mux.Handle("/api/address", httputil.LogHandler(m.log, requireAuth(m.addressHandler())))
+	mux.Handle("/api/address/queue", httputil.LogHandler(m.log, requireAuth(m.addressQueueHandler())))
+	mux.Handle("/api/address/forecast", httputil.LogHandler(m.log, requireAuth(m.addressForecastHandler())))
+	mux.Handle("/api/address/shards", httputil.LogHandler(m.log, requireAuth(m.addressShardsHandler())))
+	mux.Handle("/api/rates/feeds", httputil.LogHandler(m.log, requireAuth(m.rateFeedsHandler())))
+	mux.Handle("/api/rates/simulate", httputil.LogHandler(m.log, requireAuth(m.simulateRateHandler())))
+	mux.Handle("/api/deposit_status", httputil.LogHandler(m.log, requireAuth(m.depositStatus())))
+	mux.Handle("/api/deposit/approve", httputil.LogHandler(m.log, requireAuth(m.approveDepositHandler())))
+	mux.Handle("/api/deposit/export", httputil.LogHandler(m.log, requireAuth(m.exportDepositsHandler())))
+	mux.Handle("/api/deposit/inspect", httputil.LogHandler(m.log, requireAuth(m.inspectDepositHandler())))
+	mux.Handle("/api/lookup", httputil.LogHandler(m.log, requireAuth(m.lookupHandler())))
+	mux.Handle("/api/stats", httputil.LogHandler(m.log, requireAuth(m.statsHandler())))
+	mux.Handle("/api/ledger", httputil.LogHandler(m.log, requireAuth(m.ledgerHandler())))
+	mux.Handle("/api/audit-log", httputil.LogHandler(m.log, requireAuth(m.auditLogHandler())))
+	mux.Handle("/api/runtime", httputil.LogHandler(m.log, requireAuth(m.runtimeHandler())))
+	mux.Handle("/api/contacts/erase", httputil.LogHandler(m.log, requireAuth(m.eraseContactsHandler())))
+	mux.Handle("/api/email/preview", httputil.LogHandler(m.log, requireAuth(m.emailPreviewHandler())))
+	mux.Handle("/api/subsystems", httputil.LogHandler(m.log, requireAuth(m.subsystemsHandler(http.MethodGet, nil))))
+	mux.Handle("/api/subsystems/pause", httputil.LogHandler(m.log, requireAuth(m.subsystemsHandler(http.MethodPost, m.Subsystems.Pause))))
+	mux.Handle("/api/subsystems/resume", httputil.LogHandler(m.log, requireAuth(m.subsystemsHandler(http.MethodPost, m.Subsystems.Resume))))
+	mux.Handle("/api/features", httputil.LogHandler(m.log, requireAuth(m.featuresHandler(http.MethodGet, nil))))
+	mux.Handle("/api/features/enable", httputil.LogHandler(m.log, requireAuth(m.featuresHandler(http.MethodPost, m.enableFeature))))
+	mux.Handle("/api/features/disable", httputil.LogHandler(m.log, requireAuth(m.featuresHandler(http.MethodPost, m.disableFeature))))
+	mux.Handle("/api/features/reset", httputil.LogHandler(m.log, requireAuth(m.featuresHandler(http.MethodPost, m.resetFeature))))
+	mux.Handle("/api/db", httputil.LogHandler(m.log, requireAuth(m.dbHandler(http.MethodGet))))
+	mux.Handle("/api/db/compact", httputil.LogHandler(m.log, requireAuth(m.dbHandler(http.MethodPost))))
+	mux.Handle("/api/abuse", httputil.LogHandler(m.log, requireAuth(m.abuseHandler())))
+	mux.Handle("/api/capture", httputil.LogHandler(m.log, requireAuth(m.captureHandler(http.MethodGet))))
+	mux.Handle("/api/capture/start", httputil.LogHandler(m.log, requireAuth(m.captureHandler(http.MethodPost))))
+	mux.Handle("/api/capture/stop", httputil.LogHandler(m.log, requireAuth(m.captureStopHandler())))
+	mux.Handle("/api/capture/records", httputil.LogHandler(m.log, requireAuth(m.captureRecordsHandler())))
+	mux.Handle("/api/replication", httputil.LogHandler(m.log, requireAuth(m.replicationHandler())))
+	mux.Handle("/api/startup_report", httputil.LogHandler(m.log, requireAuth(m.startupReportHandler())))
+	mux.Handle("/api/runbook", httputil.LogHandler(m.log, requireAuth(m.runbookHandler())))
+	mux.Handle("/api/coin_hours", httputil.LogHandler(m.log, requireAuth(m.coinHoursHandler())))
+
+	// The handover endpoints are called by the new teller instance, which has the token instead of a login session
+	mux.Handle("/api/handover", httputil.LogHandler(m.log, m.requireHandoverToken(m.handoverHandler(http.MethodGet, nil))))
+	mux.Handle("/api/handover/quiesce", httputil.LogHandler(m.log, m.requireHandoverToken(m.handoverHandler(http.MethodPost, m.handoverQuiesce))))
+	mux.Handle("/api/handover/resume", httputil.LogHandler(m.log, m.requireHandoverToken(m.handoverHandler(http.MethodPost, m.handoverResume))))
+	mux.Handle("/api/handover/release", httputil.LogHandler(m.log, m.requireHandoverToken(m.handoverHandler(http.MethodPost, m.handoverRelease))))
+
+	// The sync endpoint is called by the standby, which has the replication token instead of a login session
+	mux.Handle(replica.SyncPath, httputil.LogHandler(m.log, m.requireReplicationToken(m.replicationSyncHandler())))
+
+	if m.auth != nil {
+		mux.Handle("/api/auth/webauthn/challenge", httputil.LogHandler(m.log, m.auth.webAuthnChallengeHandler()))
+		mux.Handle("/api/auth/webauthn/login", httputil.LogHandler(m.log, m.auth.webAuthnLoginHandler()))
+		mux.Handle("/api/auth/totp/login", httputil.LogHandler(m.log, m.auth.totpLoginHandler()))
+		mux.Handle("/api/auth/session", httputil.LogHandler(m.log, m.auth.sessionHandler()))
+		mux.Handle("/api/auth/logout", httputil.LogHandler(m.log, m.auth.logoutHandler()))
+	}
+
 	return mux
 }
 
@@ -162,11 +416,158 @@ func (m *Monitor) addressHandler() http.HandlerFunc {
 	}
 }
 
-// depositStatus returns all deposit status
+// addressQueueHandler returns the deposit address allocation queue stats of each coin type
+// Method: GET
+// URI: /api/address/queue
+func (m *Monitor) addressQueueHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.QueueStats()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// addressShardsHandler returns this instance's shard of each coin type's deposit address pool,
+// if the pools are sharded across teller instances
+// Method: GET
+// URI: /api/address/shards
+func (m *Monitor) addressShardsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.Shards()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// addressForecastHandler returns the bind rate of each coin type's deposit address pool,
+// and when the pool is predicted to run out at that rate
+// Method: GET
+// URI: /api/address/forecast
+func (m *Monitor) addressForecastHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Forecaster == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Address forecast disabled")
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.Forecaster.Forecasts()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// rateFeedsHandler returns the rate of each coin type with price feeds, where it came from,
+// and the health of each feed in its last poll
+// Method: GET
+// URI: /api/rates/feeds
+func (m *Monitor) rateFeedsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.RateFeeds == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Rate feeds disabled")
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.RateFeeds.RateFeedStatuses()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// simulateRateHandler previews the impact of a rate or max_decimals change on the deposits which weren't sent skycoin yet,
+// without applying it. The skycoin amounts are in droplets.
+// Method: POST
+// URI: /api/rates/simulate
+// Args:
+//     {"coin_type": "BTC", "rate": "...", "max_decimals": 3}
+func (m *Monitor) simulateRateHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		var sim exchange.RateSimulation
+		if err := json.NewDecoder(r.Body).Decode(&sim); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+			return
+		}
+		defer r.Body.Close()
+
+		if err := sim.Validate(); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log = log.WithField("rateSimulation", sim)
+
+		report, err := m.SimulateRate(sim)
+		if err != nil {
+			log.WithError(err).Error("SimulateRate failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, report); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// depositStatus returns the status of the deposits matching the args, all deposits without args.
+// The deposits are found through the deposit indexes.
 // Method: GET
 // URI: /api/deposit_status
 // Args:
-//     - status # available value("waiting_deposit", "waiting_send", "waiting_confirm", "done")
+//     - status # available value("waiting_deposit", "waiting_send", "waiting_review", "waiting_confirm", "done", "dust_ignored")
+//     - deposit_addr # deposits to a BTC/ETH deposit address
+//     - txid # deposits whose BTC/ETH transaction or skycoin transaction has the txid
+//     - from # deposits updated at or after a date, RFC3339 or YYYY-MM-DD (UTC)
+//     - to # deposits updated before a date, RFC3339 or YYYY-MM-DD (UTC)
 func (m *Monitor) depositStatus() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -178,47 +579,228 @@ func (m *Monitor) depositStatus() http.HandlerFunc {
 			return
 		}
 
-		status := r.FormValue("status")
-		if status == "" {
-			// returns all status
-			dpis, err := m.GetDepositStatusDetail(func(dpi exchange.DepositInfo) bool {
-				return true
-			})
-			if err != nil {
-				log.WithError(err).Error("GetDepositStatusDetail failed")
-				httputil.ErrResponse(w, http.StatusInternalServerError)
+		// The dates are parsed the same as the export's
+		flt, err := exchange.NewExportFilter(r.FormValue("from"), r.FormValue("to"), "", "", "")
+		if err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		q := exchange.DepositQuery{
+			DepositAddress: r.FormValue("deposit_addr"),
+			Txid:           r.FormValue("txid"),
+			From:           flt.From,
+			To:             flt.To,
+		}
+
+		if status := r.FormValue("status"); status != "" {
+			st := exchange.NewStatusFromStr(status)
+			if st == exchange.StatusUnknown {
+				err := fmt.Sprintf("unknown status %v", status)
+				httputil.ErrResponse(w, http.StatusBadRequest, err)
+				log.WithField("depositStatus", status).Error("Unknown status")
 				return
 			}
-			httputil.JSONResponse(w, dpis)
+			q.Statuses = []exchange.Status{st}
+		}
+
+		dpis, err := m.QueryDepositStatusDetail(q)
+		if err != nil {
+			log.WithError(err).Error("QueryDepositStatusDetail failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		httputil.JSONResponse(w, dpis)
+	}
+}
+
+type approveDepositRequest struct {
+	DepositID string `json:"deposit_id"`
+	Rate      string `json:"rate"`
+	Version   uint64 `json:"version"`
+}
+
+// approveDepositHandler releases a deposit held for review by the rate guard.
+// If rate is set, the deposit is converted at rate instead of its saved rate.
+// If version is set, the deposit is only approved if it wasn't changed since it was read at that version,
+// otherwise it returns 409 with the deposit's current version.
+// Method: POST
+// URI: /api/deposit/approve
+// Args:
+//     {"deposit_id": "...", "rate": "...", "version": 3}
+func (m *Monitor) approveDepositHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req approveDepositRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+			return
+		}
+		defer r.Body.Close()
+
+		if req.DepositID == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing deposit_id")
+			return
+		}
+
+		log = log.WithField("approveDepositRequest", req)
+
+		di, err := m.ApproveDeposit(req.DepositID, req.Rate, req.Version)
+		if err != nil {
+			log.WithError(err).Error("ApproveDeposit failed")
+			var conflict exchange.VersionConflictErr
+			switch err.(type) {
+			case dbutil.ObjectNotExistErr:
+				httputil.ErrResponse(w, http.StatusNotFound)
+			default:
+				if err == exchange.ErrDepositNotInReview || errors.As(err, &conflict) {
+					httputil.ErrResponse(w, http.StatusConflict, err.Error())
+					return
+				}
+				httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			}
 			return
 		}
 
-		st := exchange.NewStatusFromStr(status)
-		switch st {
-		case exchange.StatusUnknown:
-			err := fmt.Sprintf("unknown status %v", status)
-			httputil.ErrResponse(w, http.StatusBadRequest, err)
-			log.WithField("depositStatus", status).Error("Unknown status")
+		log.WithField("depositInfo", di).Info("Deposit approved")
+
+		d := exchange.DepositStatusDetail{
+			Seq:            di.Seq,
+			Version:        di.Version,
+			UpdatedAt:      di.UpdatedAt,
+			Status:         di.Status.String(),
+			SkyAddress:     di.SkyAddress,
+			DepositAddress: di.DepositAddress,
+			CoinType:       di.CoinType,
+			Txid:           di.Txid,
+			DepositID:      di.DepositID,
+			ConversionRate: di.ConversionRate,
+		}
+		d.Link(m.cfg.Explorer)
+
+		if err := httputil.JSONResponse(w, d); err != nil {
+			log.WithError(err).Error("Write json response failed")
 			return
-		default:
-			dpis, err := m.GetDepositStatusDetail(func(dpi exchange.DepositInfo) bool {
-				return dpi.Status == st
-			})
+		}
+	}
+}
+
+type eraseContactsRequest struct {
+	SkyAddr string `json:"skyaddr"`
+}
+
+type eraseContactsResponse struct {
+	Erased int `json:"erased"`
+}
+
+type subsystemRequest struct {
+	Name string `json:"name"`
+}
+
+type subsystemsResponse struct {
+	Subsystems []pauseutil.Status `json:"subsystems"`
+}
+
+type runtimeResponse struct {
+	version.Info
+	CoinTypes     []string       `json:"coin_types"`
+	Uptime        int64          `json:"uptime"`
+	Goroutines    int            `json:"goroutines"`
+	HeapAlloc     uint64         `json:"heap_alloc"`
+	Sys           uint64         `json:"sys"`
+	DBSize        int64          `json:"db_size"`
+	QueueDepths   map[string]int `json:"queue_depths"`
+	HandoverState string         `json:"handover_state,omitempty"`
+	// Feature flags by name, omitted if the feature flags are not available
+	Features map[string]bool `json:"features,omitempty"`
+}
+
+// runtimeHandler returns the build of teller and its runtime stats, for support requests.
+// uptime is in seconds, heap_alloc, sys and db_size are in bytes.
+// Method: GET
+// URI: /api/runtime
+func (m *Monitor) runtimeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		var dbSize int64
+		if m.cfg.DBPath != "" {
+			fi, err := os.Stat(m.cfg.DBPath)
 			if err != nil {
-				log.WithError(err).Error("GetDepositStatusDetail failed")
+				log.WithError(err).Error("os.Stat db failed")
 				httputil.ErrResponse(w, http.StatusInternalServerError)
 				return
 			}
+			dbSize = fi.Size()
+		}
+
+		queueDepths := make(map[string]int)
+		for coinType, qs := range m.QueueStats() {
+			queueDepths[coinType] = qs.Depth
+		}
+
+		var handoverState string
+		if m.Handover != nil {
+			handoverState = m.HandoverState()
+		}
+
+		var features map[string]bool
+		if m.Features != nil {
+			features = m.Features.Flags()
+		}
 
-			httputil.JSONResponse(w, dpis)
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+
+		if err := httputil.JSONResponse(w, runtimeResponse{
+			Info:          version.Get(),
+			CoinTypes:     m.CoinTypes(),
+			Uptime:        int64(version.Uptime() / time.Second),
+			Goroutines:    runtime.NumGoroutine(),
+			HeapAlloc:     ms.HeapAlloc,
+			Sys:           ms.Sys,
+			DBSize:        dbSize,
+			QueueDepths:   queueDepths,
+			HandoverState: handoverState,
+			Features:      features,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
 		}
 	}
 }
 
-// stats returns all deposit stats, including total BTC received and total SKY sent.
+// lookupResponse is the response of /api/lookup
+type lookupResponse struct {
+	// Owners are the skycoin addresses found, the current owner first
+	Owners []exchange.Owner `json:"owners"`
+}
+
+// lookupHandler returns the skycoin address which a deposit address or transaction belongs to,
+// with its bindings and the history of its deposits, for support requests which only have a txid.
+// A deposit address which was cancelled and reused also returns its previous owners.
 // Method: GET
-// URI: /api/stats
-func (m *Monitor) statsHandler() http.HandlerFunc {
+// URI: /api/lookup
+// Args:
+//
+//	deposit_addr # BTC/ETH deposit address
+//	txid # BTC/ETH transaction of a deposit, or skycoin transaction of its payout
+func (m *Monitor) lookupHandler() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		log := logger.FromContext(ctx)
@@ -229,14 +811,978 @@ func (m *Monitor) statsHandler() http.HandlerFunc {
 			return
 		}
 
-		ts, err := m.GetDepositStats()
+		depositAddr := strings.TrimSpace(r.FormValue("deposit_addr"))
+		txid := strings.TrimSpace(r.FormValue("txid"))
+		if (depositAddr == "") == (txid == "") {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Either deposit_addr or txid is required")
+			return
+		}
+
+		owners, err := m.LookupOwners(depositAddr, txid)
 		if err != nil {
-			log.WithError(err).Error("GetDepositStats failed")
+			log.WithError(err).Error("LookupOwners failed")
 			httputil.ErrResponse(w, http.StatusInternalServerError)
 			return
 		}
 
-		if err := httputil.JSONResponse(w, ts); err != nil {
+		if len(owners) == 0 {
+			httputil.ErrResponse(w, http.StatusNotFound, "No binding or deposit found")
+			return
+		}
+
+		if err := httputil.JSONResponse(w, lookupResponse{
+			Owners: owners,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// inspectDepositResponse is the response of /api/deposit/inspect
+type inspectDepositResponse struct {
+	Deposit exchange.DepositRecord `json:"deposit"`
+	// Chain is the deposit's transaction on the coin's node and the scanner's record of the deposit
+	Chain scanner.DepositInspection `json:"chain"`
+}
+
+// inspectDepositHandler returns a deposit with the raw transaction, outputs, fee and block of its BTC/ETH transaction
+// as reported by the coin's node, and the records the scanner and the exchange kept of it,
+// for investigating disputed amounts without a block explorer.
+// Method: GET
+// URI: /api/deposit/inspect
+// Args:
+//
+//	deposit_id # the deposit's txid:n
+func (m *Monitor) inspectDepositHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Inspector == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Deposit inspection disabled")
+			return
+		}
+
+		depositID := strings.TrimSpace(r.FormValue("deposit_id"))
+		if depositID == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "deposit_id is required")
+			return
+		}
+
+		if _, _, err := deposits.ParseID(depositID); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		rec, err := m.GetDepositRecord(depositID)
+		if err != nil {
+			if err == exchange.ErrDepositNotFound {
+				httputil.ErrResponse(w, http.StatusNotFound, err.Error())
+				return
+			}
+			log.WithError(err).Error("GetDepositRecord failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		chain, err := m.Inspector.InspectDeposit(rec.Deposit)
+		if err != nil {
+			log.WithError(err).Error("InspectDeposit failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, inspectDepositResponse{
+			Deposit: rec,
+			Chain:   chain,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// eraseContactsHandler deletes the contact emails of every binding of a skycoin address,
+// for erasure requests made to the operator.
+// Method: POST
+// URI: /api/contacts/erase
+// Args:
+//
+//	{"skyaddr": "..."}
+func (m *Monitor) eraseContactsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.ContactEraser == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Contact emails disabled")
+			return
+		}
+
+		var req eraseContactsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+			return
+		}
+		defer r.Body.Close()
+
+		if req.SkyAddr == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing skyaddr")
+			return
+		}
+
+		n, err := m.EraseContacts(req.SkyAddr)
+		if err != nil {
+			log.WithError(err).Error("EraseContacts failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, eraseContactsResponse{
+			Erased: n,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// emailPreviewHandler renders the email of an event with example data, to check the templates.
+// The email is rendered in the language it would be sent in, after falling back from the language asked for.
+// Method: GET
+// URI: /api/email/preview
+// Args:
+//     - event # "deposit_address" or "payout_sent"
+//     - language # optional, e.g. "pt-br", defaults to the default language
+func (m *Monitor) emailPreviewHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Emails == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Contact emails disabled")
+			return
+		}
+
+		event := r.URL.Query().Get("event")
+		if event == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing event")
+			return
+		}
+
+		preview, err := m.Emails.PreviewEmail(event, r.URL.Query().Get("language"))
+		switch err {
+		case nil:
+		case notify.ErrUnknownEvent, notify.ErrInvalidLanguage:
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		default:
+			log.WithError(err).Error("PreviewEmail failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, preview); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// subsystemsHandler lists the subsystems, or pauses or resumes one of them.
+// A paused subsystem finishes the unit of work in progress, which is reported by "busy",
+// and then waits. Deposits and emails are kept queued meanwhile.
+// Method: GET /api/subsystems, POST /api/subsystems/pause, /api/subsystems/resume
+// Request Body (POST):
+//
+//	{"name": "sender"}
+//
+// Response:
+//
+//	GET: {"subsystems": [{"name": "sender", "paused": true, "paused_at": 1520000000, "busy": false}]}
+//	POST: {"name": "sender", "paused": true, "paused_at": 1520000000, "busy": false}
+func (m *Monitor) subsystemsHandler(method string, step func(name string) (pauseutil.Status, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if step == nil {
+			if err := httputil.JSONResponse(w, subsystemsResponse{
+				Subsystems: m.Subsystems.Statuses(),
+			}); err != nil {
+				log.WithError(err).Error("Write json response failed")
+			}
+			return
+		}
+
+		var req subsystemRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+			return
+		}
+		defer r.Body.Close()
+
+		if req.Name == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing name")
+			return
+		}
+
+		status, err := step(req.Name)
+		if err != nil {
+			switch err {
+			case pauseutil.ErrUnknownSubsystem:
+				httputil.ErrResponse(w, http.StatusNotFound, err.Error())
+			default:
+				log.WithError(err).Error("Subsystem step failed")
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		// Operators are expected to find who paused sending in the log
+		log.WithFields(logrus.Fields{
+			"subsystem": status.Name,
+			"paused":    status.Paused,
+		}).Warn("Subsystem pause state changed")
+
+		if err := httputil.JSONResponse(w, status); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+type featureRequest struct {
+	Name string `json:"name"`
+}
+
+type featuresResponse struct {
+	Features []flagutil.Status `json:"features"`
+}
+
+func (m *Monitor) enableFeature(name string) (flagutil.Status, error) {
+	return m.Features.Enable(name)
+}
+
+func (m *Monitor) disableFeature(name string) (flagutil.Status, error) {
+	return m.Features.Disable(name)
+}
+
+func (m *Monitor) resetFeature(name string) (flagutil.Status, error) {
+	return m.Features.Reset(name)
+}
+
+// featuresHandler returns the feature flags, or with a step, changes the flag named by the request until teller restarts
+// Method: GET, or POST with a step
+// URI: /api/features, /api/features/enable, /api/features/disable, /api/features/reset
+// Args:
+//
+//	{"name": "stats_stream"}
+func (m *Monitor) featuresHandler(method string, step func(name string) (flagutil.Status, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Features == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Feature flags disabled")
+			return
+		}
+
+		if step == nil {
+			if err := httputil.JSONResponse(w, featuresResponse{
+				Features: m.Features.Statuses(),
+			}); err != nil {
+				log.WithError(err).Error("Write json response failed")
+			}
+			return
+		}
+
+		var req featureRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+			return
+		}
+		defer r.Body.Close()
+
+		if req.Name == "" {
+			httputil.ErrResponse(w, http.StatusBadRequest, "Missing name")
+			return
+		}
+
+		status, err := step(req.Name)
+		if err != nil {
+			switch err {
+			case flagutil.ErrUnknownFlag:
+				httputil.ErrResponse(w, http.StatusNotFound, err.Error())
+			default:
+				log.WithError(err).Error("Feature flag step failed")
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+			}
+			return
+		}
+
+		log.WithFields(logrus.Fields{
+			"feature":    status.Name,
+			"enabled":    status.Enabled,
+			"configured": status.Configured,
+		}).Warn("Feature flag changed")
+
+		if err := httputil.JSONResponse(w, status); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+type dbResponse struct {
+	dbutil.Stats
+	// CompactRequested is true once a compaction was requested, teller restarts to make it
+	CompactRequested bool `json:"compact_requested"`
+}
+
+// dbHandler returns the stats of the db, and requests a compaction on POST.
+// A compaction stops teller's services, copies the db into a fresh file which replaces it,
+// and starts the services again. The API is unavailable meanwhile.
+// size, free_bytes and freelist_bytes are in bytes.
+// Method: GET /api/db, POST /api/db/compact
+func (m *Monitor) dbHandler(method string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.DB == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "DB compaction disabled")
+			return
+		}
+
+		stats, err := m.DB.DBStats()
+		if err != nil {
+			log.WithError(err).Error("DBStats failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if method == http.MethodPost {
+			// Operators are expected to find who restarted teller in the log
+			log.WithField("dbStats", stats).Info("DB compaction requested")
+			m.DB.RequestCompact()
+		}
+
+		rsp := dbResponse{
+			Stats: stats,
+		}
+		select {
+		case <-m.DB.Requested():
+			rsp.CompactRequested = true
+		default:
+		}
+
+		if err := httputil.JSONResponse(w, rsp); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// abuseHandler returns the anomalous clients and ASNs seen by the abuse detection
+func (m *Monitor) abuseHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Abuse == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Abuse detection disabled")
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.Abuse.Stats()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+type captureResponse struct {
+	// Session is the last capture session, null if none was started
+	Session *capture.Session `json:"session"`
+}
+
+type captureStartRequest struct {
+	capture.Filter
+	// How long to record, e.g. "15m"
+	Duration string `json:"duration"`
+}
+
+// captureHandler returns the last capture session, or starts a capture session on POST
+func (m *Monitor) captureHandler(method string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Capture == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Capture disabled")
+			return
+		}
+
+		if method == http.MethodPost {
+			var req captureStartRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				httputil.ErrResponse(w, http.StatusBadRequest, "Invalid json request body")
+				return
+			}
+			defer r.Body.Close()
+
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				httputil.ErrResponse(w, http.StatusBadRequest, "Invalid duration")
+				return
+			}
+
+			if _, err := m.Capture.Start(req.Filter, d); err != nil {
+				switch err {
+				case capture.ErrSessionActive:
+					httputil.ErrResponse(w, http.StatusConflict, err.Error())
+				default:
+					httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+				}
+				return
+			}
+		}
+
+		m.writeCaptureSession(w, log)
+	}
+}
+
+// captureStopHandler stops the active capture session
+func (m *Monitor) captureStopHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Capture == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Capture disabled")
+			return
+		}
+
+		if _, err := m.Capture.Stop(); err != nil {
+			httputil.ErrResponse(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		m.writeCaptureSession(w, log)
+	}
+}
+
+func (m *Monitor) writeCaptureSession(w http.ResponseWriter, log logrus.FieldLogger) {
+	if err := httputil.JSONResponse(w, captureResponse{
+		Session: m.Capture.Session(),
+	}); err != nil {
+		log.WithError(err).Error("Write json response failed")
+	}
+}
+
+type captureRecordsResponse struct {
+	Records []capture.Record `json:"records"`
+}
+
+type captureClearResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// captureRecordsHandler returns the records of the capture sessions, or deletes them on DELETE.
+// The records are filtered by the "session" form value, and limited to the newest "limit" records.
+func (m *Monitor) captureRecordsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+			w.Header().Set("Allow", "GET, DELETE")
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Capture == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Capture disabled")
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			n, err := m.Capture.Clear()
+			if err != nil {
+				log.WithError(err).Error("Capture.Clear failed")
+				httputil.ErrResponse(w, http.StatusInternalServerError)
+				return
+			}
+
+			log.WithField("deleted", n).Warn("Capture records deleted")
+
+			if err := httputil.JSONResponse(w, captureClearResponse{
+				Deleted: n,
+			}); err != nil {
+				log.WithError(err).Error("Write json response failed")
+			}
+			return
+		}
+
+		var limit int
+		if v := r.FormValue("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				httputil.ErrResponse(w, http.StatusBadRequest, "Invalid limit")
+				return
+			}
+			limit = n
+		}
+
+		records, err := m.Capture.Records(r.FormValue("session"), limit)
+		if err != nil {
+			log.WithError(err).Error("Capture.Records failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if records == nil {
+			records = []capture.Record{}
+		}
+
+		if err := httputil.JSONResponse(w, captureRecordsResponse{
+			Records: records,
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// requireHandoverToken rejects requests without the handover token
+func (m *Monitor) requireHandoverToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Handover == nil || m.cfg.HandoverToken == "" {
+			httputil.ErrResponse(w, http.StatusForbidden, "Handover disabled")
+			return
+		}
+
+		token := r.Header.Get(handover.TokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(m.cfg.HandoverToken)) != 1 {
+			httputil.ErrResponse(w, http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requireReplicationToken rejects requests without the replication token
+func (m *Monitor) requireReplicationToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Replication == nil || m.cfg.ReplicationToken == "" {
+			httputil.ErrResponse(w, http.StatusForbidden, "Replication disabled")
+			return
+		}
+
+		token := r.Header.Get(replica.TokenHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(m.cfg.ReplicationToken)) != 1 {
+			httputil.ErrResponse(w, http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// replicationSyncHandler streams the chunks of the db which differ from the standby's replica
+// Method: POST
+// URI: /api/replication/sync
+// Header: X-Replication-Token
+func (m *Monitor) replicationSyncHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.Replication.ServeSync(w, r)
+	}
+}
+
+// replicationHandler returns the state of the db's replication to the standby
+// Method: GET
+// URI: /api/replication
+func (m *Monitor) replicationHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Replication == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Replication disabled")
+			return
+		}
+
+		status, err := m.Replication.Status()
+		if err != nil {
+			log.WithError(err).Error("Replication.Status failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, status); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// startupReportHandler returns how the last run stopped, and the deposits whose processing may have been
+// interrupted if it didn't shut down cleanly
+// Method: GET
+// URI: /api/startup_report
+func (m *Monitor) startupReportHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Startup == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Startup report disabled")
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.Startup.StartupReport()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// runbookHandler returns the runbook's rules, whether they are triggered and the results of their last actions
+// Method: GET
+// URI: /api/runbook
+func (m *Monitor) runbookHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.Runbook == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Runbook disabled")
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.Runbook.Status()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+func (m *Monitor) coinHoursHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.CoinHours == nil {
+			httputil.ErrResponse(w, http.StatusForbidden, "Coin hour tracking disabled")
+			return
+		}
+
+		if err := httputil.JSONResponse(w, m.CoinHours.Status()); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+func (m *Monitor) handoverQuiesce() error {
+	return m.Quiesce()
+}
+
+func (m *Monitor) handoverResume() error {
+	return m.Resume()
+}
+
+func (m *Monitor) handoverRelease() error {
+	return m.Release()
+}
+
+// handoverHandler applies a handover step and returns the handover state.
+// Quiesce returns once the binds and skycoin send in progress are finished.
+// Release makes teller shut down, so that the new instance can open the db.
+// Method: GET /api/handover, POST /api/handover/quiesce, /api/handover/resume, /api/handover/release
+// Header: X-Handover-Token
+// Response:
+//
+//	{"state": "running"|"quiesced"|"released"}
+func (m *Monitor) handoverHandler(method string, step func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if step != nil {
+			if err := step(); err != nil {
+				log.WithError(err).Error("Handover step failed")
+				switch err {
+				case handover.ErrNotQuiesced, handover.ErrReleased:
+					httputil.ErrResponse(w, http.StatusConflict, err.Error())
+				default:
+					httputil.ErrResponse(w, http.StatusInternalServerError)
+				}
+				return
+			}
+		}
+
+		if err := httputil.JSONResponse(w, handover.StateResponse{
+			State: m.HandoverState(),
+		}); err != nil {
+			log.WithError(err).Error("Write json response failed")
+		}
+	}
+}
+
+// exportDepositsHandler streams the deposits matching the filters as CSV or JSON lines.
+// All filters are optional.
+// Method: GET
+// URI: /api/deposit/export
+// Args:
+//     - format # "csv" (default) or "jsonl"
+//     - from # deposits updated at or after this date, RFC3339 or YYYY-MM-DD
+//     - to # deposits updated before this date, RFC3339 or YYYY-MM-DD
+//     - status # comma separated statuses, e.g. "waiting_review,done"
+//     - coin # coin type, e.g. "BTC"
+//     - min_amount # minimum deposit amount, in whole coins of the deposit's coin type
+func (m *Monitor) exportDepositsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		format, err := exchange.NewExportFormatFromStr(r.FormValue("format"))
+		if err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		flt, err := exchange.NewExportFilter(r.FormValue("from"), r.FormValue("to"), r.FormValue("status"), r.FormValue("coin"), r.FormValue("min_amount"))
+		if err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log = log.WithField("exportFilter", flt)
+
+		switch format {
+		case exchange.ExportCSV:
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		case exchange.ExportJSONL:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="deposits.%s"`, format))
+
+		// The deposits are written as they are read, so once the export has started
+		// an error can't change the response status, and is only logged
+		n, err := m.ExportDeposits(w, format, flt)
+		if err != nil {
+			log.WithError(err).WithField("exported", n).Error("ExportDeposits failed")
+			return
+		}
+
+		log.WithField("exported", n).Info("Exported deposits")
+	}
+}
+
+// auditLogHandler exports a page of the audit log, the ledger transactions chained by their hashes.
+// The page is signed with the audit log key, the signature is in the X-Audit-Log-Signature header.
+// Method: GET
+// URI: /api/audit-log
+// Args:
+//
+//	cursor # optional, only entries with a greater seq are returned, the cursor of the previous page. Defaults to 0, the start of the log.
+//	limit # optional, maximum number of entries to return, up to 10000. Defaults to 1000.
+func (m *Monitor) auditLogHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if m.cfg.AuditLogKey == (cipher.SecKey{}) {
+			httputil.ErrResponse(w, http.StatusForbidden, "Audit log export disabled")
+			return
+		}
+
+		var cursor uint64
+		if v := r.FormValue("cursor"); v != "" {
+			var err error
+			cursor, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				httputil.ErrResponse(w, http.StatusBadRequest, "Invalid cursor")
+				return
+			}
+		}
+
+		limit := auditLogDefaultLimit
+		if v := r.FormValue("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > auditLogMaxLimit {
+				httputil.ErrResponse(w, http.StatusBadRequest, "Invalid limit")
+				return
+			}
+			limit = n
+		}
+
+		page, err := m.GetAuditLogPage(cursor, limit)
+		if err != nil {
+			log.WithError(err).Error("GetAuditLogPage failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		// The signature is of the exact bytes of the response body
+		b, err := json.MarshalIndent(page, "", "    ")
+		if err != nil {
+			log.WithError(err).Error("json.MarshalIndent failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(AuditLogSignatureHeader, exchange.SignAuditLogPage(b, m.cfg.AuditLogKey))
+
+		if _, err := w.Write(b); err != nil {
+			log.WithError(err).Error("Write audit log page failed")
+		}
+	}
+}
+
+// stats returns all deposit stats, including total BTC received and total SKY sent.
+// Method: GET
+// URI: /api/stats
+func (m *Monitor) statsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		ts, err := m.GetDepositStats()
+		if err != nil {
+			log.WithError(err).Error("GetDepositStats failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, ts); err != nil {
+			log.WithError(err).Error("Write json response failed")
+			return
+		}
+	}
+}
+
+// ledgerHandler returns the ledger account balances, and any accounts
+// whose balance drifted from the deposit records
+// Method: GET
+// URI: /api/ledger
+func (m *Monitor) ledgerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		report, err := m.GetLedgerReport()
+		if err != nil {
+			log.WithError(err).Error("GetLedgerReport failed")
+			httputil.ErrResponse(w, http.StatusInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, report); err != nil {
 			log.WithError(err).Error("Write json response failed")
 			return
 		}
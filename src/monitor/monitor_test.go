@@ -3,15 +3,41 @@ package monitor
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/boltdb/bolt"
 	"github.com/stretchr/testify/require"
 
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/abuse"
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/capture"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/handover"
+	"github.com/skycoin/teller/src/notify"
+	"github.com/skycoin/teller/src/replica"
+	"github.com/skycoin/teller/src/runbook"
 	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/errutil"
+	"github.com/skycoin/teller/src/util/flagutil"
+	"github.com/skycoin/teller/src/util/pauseutil"
 	"github.com/skycoin/teller/src/util/testutil"
+	"github.com/skycoin/teller/src/version"
 )
 
 type dummyBtcAddrMgr struct {
@@ -28,22 +54,32 @@ func (db *dummyEthAddrMgr) Remaining() uint64 {
 	return db.Num
 }
 
+type dummyContactEraser map[string]int
+
+func (ce dummyContactEraser) EraseContacts(skyAddr string) (int, error) {
+	n := ce[skyAddr]
+	delete(ce, skyAddr)
+	return n, nil
+}
+
 type dummyDepositStatusGetter struct {
 	dpis []exchange.DepositInfo
 }
 
-func (dps dummyDepositStatusGetter) GetDepositStatusDetail(flt exchange.DepositFilter) ([]exchange.DepositStatusDetail, error) {
+func (dps dummyDepositStatusGetter) QueryDepositStatusDetail(q exchange.DepositQuery) ([]exchange.DepositStatusDetail, error) {
 	var ds []exchange.DepositStatusDetail
 	for _, dpi := range dps.dpis {
-		if flt(dpi) {
+		if q.Match(dpi) {
 			ds = append(ds, exchange.DepositStatusDetail{
 				Seq:            dpi.Seq,
+				Version:        dpi.Version,
 				DepositAddress: dpi.DepositAddress,
 				SkyAddress:     dpi.SkyAddress,
 				Status:         dpi.Status.String(),
 				UpdatedAt:      dpi.UpdatedAt,
 				Txid:           dpi.Txid,
 				CoinType:       dpi.CoinType,
+				DepositID:      dpi.DepositID,
 			})
 		}
 	}
@@ -65,6 +101,160 @@ func (dps dummyDepositStatusGetter) GetDepositStats() (*exchange.DepositStats, e
 	}, nil
 }
 
+func (dps dummyDepositStatusGetter) GetLedgerReport() (*exchange.LedgerReport, error) {
+	return &exchange.LedgerReport{
+		Balances: exchange.LedgerBalances{},
+	}, nil
+}
+
+// GetAuditLogPage returns a ledger transaction for each deposit
+func (dps dummyDepositStatusGetter) GetAuditLogPage(cursor uint64, limit int) (exchange.AuditLogPage, error) {
+	page := exchange.AuditLogPage{
+		Entries: []exchange.AuditLogEntry{},
+		Cursor:  cursor,
+	}
+
+	var prevHash string
+	for i, dpi := range dps.dpis {
+		e := exchange.AuditLogEntry{
+			LedgerTransaction: exchange.LedgerTransaction{
+				Seq:       uint64(i + 1),
+				Time:      dpi.UpdatedAt,
+				DepositID: dpi.DepositID,
+				Status:    dpi.Status.String(),
+			},
+			PrevHash: prevHash,
+		}
+		e.Hash = e.ComputeHash()
+		prevHash = e.Hash
+
+		switch {
+		case e.Seq <= cursor:
+			page.PrevHash = e.Hash
+		case len(page.Entries) < limit:
+			page.Entries = append(page.Entries, e)
+			page.Cursor = e.Seq
+		default:
+			page.More = true
+		}
+	}
+
+	return page, nil
+}
+
+func (dps dummyDepositStatusGetter) ApproveDeposit(depositID, rate string, version uint64) (exchange.DepositInfo, error) {
+	for _, dpi := range dps.dpis {
+		if dpi.DepositID != depositID {
+			continue
+		}
+		if version != 0 && version != dpi.Version {
+			return exchange.DepositInfo{}, errutil.Wrap(errutil.Conflict, exchange.VersionConflictErr{
+				Record:   "deposit",
+				Key:      depositID,
+				Expected: version,
+				Actual:   dpi.Version,
+			})
+		}
+		if dpi.Status != exchange.StatusWaitReview {
+			return exchange.DepositInfo{}, exchange.ErrDepositNotInReview
+		}
+		dpi.Status = exchange.StatusWaitSend
+		return dpi, nil
+	}
+	return exchange.DepositInfo{}, dbutil.NewObjectNotExistErr(exchange.DepositInfoBkt, []byte(depositID))
+}
+
+func (dps dummyDepositStatusGetter) ExportDeposits(w io.Writer, format exchange.ExportFormat, flt exchange.ExportFilter) (int, error) {
+	exp, err := exchange.NewDepositExporter(w, format)
+	if err != nil {
+		return 0, err
+	}
+	for _, dpi := range dps.dpis {
+		if flt.Match(dpi) {
+			if err := exp.Write(dpi); err != nil {
+				return exp.Count(), err
+			}
+		}
+	}
+	return exp.Count(), exp.Flush()
+}
+
+func (dps dummyDepositStatusGetter) LookupOwners(depositAddr, txid string) ([]exchange.Owner, error) {
+	var owners []exchange.Owner
+	for _, dpi := range dps.dpis {
+		if (depositAddr != "" && dpi.DepositAddress == depositAddr) || (txid != "" && dpi.Txid == txid) {
+			owners = append(owners, exchange.Owner{
+				SkyAddress: dpi.SkyAddress,
+				Bindings: []exchange.Binding{
+					{DepositAddress: dpi.DepositAddress, CoinType: dpi.CoinType},
+				},
+			})
+		}
+	}
+	return owners, nil
+}
+
+func (dps dummyDepositStatusGetter) SimulateRate(sim exchange.RateSimulation) (exchange.RateSimulationReport, error) {
+	report := exchange.RateSimulationReport{
+		CoinType:     sim.CoinType,
+		CurrentRate:  "500",
+		ProposedRate: sim.Rate,
+		Deposits:     []exchange.SimulatedDeposit{},
+	}
+	for _, dpi := range dps.dpis {
+		if dpi.CoinType != sim.CoinType || dpi.Status != exchange.StatusWaitReview {
+			continue
+		}
+		report.Deposits = append(report.Deposits, exchange.SimulatedDeposit{
+			DepositID:      dpi.DepositID,
+			Status:         dpi.Status.String(),
+			SkyAddress:     dpi.SkyAddress,
+			DepositAddress: dpi.DepositAddress,
+			DepositValue:   dpi.DepositValue,
+			Rate:           dpi.ConversionRate,
+			ProposedRate:   sim.Rate,
+		})
+	}
+	return report, nil
+}
+
+func (dps dummyDepositStatusGetter) GetDepositRecord(depositID string) (exchange.DepositRecord, error) {
+	for _, dpi := range dps.dpis {
+		if dpi.DepositID == depositID {
+			return exchange.DepositRecord{
+				OwnerDeposit: exchange.OwnerDeposit{
+					DepositValue: dpi.DepositValue,
+					History:      []exchange.LedgerTransaction{},
+				},
+				Deposit: dpi.Deposit,
+			}, nil
+		}
+	}
+	return exchange.DepositRecord{}, exchange.ErrDepositNotFound
+}
+
+type dummyQueueStats struct {
+	stats  map[string]addrs.QueueStats
+	shards []addrs.ShardStatus
+}
+
+func (dq dummyQueueStats) CoinTypes() []string {
+	var coinTypes []string
+	for coinType := range dq.stats {
+		coinTypes = append(coinTypes, coinType)
+	}
+	sort.Strings(coinTypes)
+	return coinTypes
+}
+
+func (dq dummyQueueStats) QueueStats() map[string]addrs.QueueStats {
+	return dq.stats
+}
+
+func (dq dummyQueueStats) Shards() []addrs.ShardStatus {
+	return dq.shards
+}
+
 type dummyScanAddrs struct {
 	addrs []string
 }
@@ -83,6 +273,7 @@ func TestRunMonitor(t *testing.T) {
 		{
 			DepositAddress: "b2",
 			SkyAddress:     "s2",
+			DepositID:      "t2:0",
 			Status:         exchange.StatusWaitSend,
 		},
 		{
@@ -100,16 +291,43 @@ func TestRunMonitor(t *testing.T) {
 			SkyAddress:     "s6",
 			Status:         exchange.StatusDone,
 		},
+		{
+			DepositAddress: "b6",
+			SkyAddress:     "s7",
+			DepositID:      "t6:0",
+			Status:         exchange.StatusWaitReview,
+			Version:        2,
+		},
 	}
 
 	dummyDps := dummyDepositStatusGetter{dpis: dpis}
 
 	cfg := Config{
-		"localhost:7908",
+		Addr: "localhost:7908",
 	}
 
 	log, _ := testutil.NewLogger(t)
-	m := New(log, cfg, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDps, &dummyScanAddrs{})
+	queueStats := map[string]addrs.QueueStats{
+		scanner.CoinTypeBTC: {
+			Depth:     2,
+			PeakDepth: 10,
+			Served:    100,
+			TimedOut:  1,
+			MaxWait:   time.Second,
+		},
+	}
+	shards := []addrs.ShardStatus{
+		{
+			CoinType:  scanner.CoinTypeBTC,
+			Index:     1,
+			Count:     2,
+			Start:     500,
+			End:       1000,
+			PoolSize:  1000,
+			Remaining: 10,
+		},
+	}
+	m := New(log, cfg, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDps, &dummyScanAddrs{}, dummyQueueStats{stats: queueStats, shards: shards}, dummyContactEraser{"2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW": 2}, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
 
 	time.AfterFunc(1*time.Second, func() {
 		rsp, err := http.Get(fmt.Sprintf("http://localhost:7908/api/address"))
@@ -121,6 +339,24 @@ func TestRunMonitor(t *testing.T) {
 		require.Equal(t, uint64(10), addrUsage.RestAddrNum)
 		rsp.Body.Close()
 
+		rsp, err = http.Get(fmt.Sprintf("http://localhost:7908/api/address/queue"))
+		require.Nil(t, err)
+		require.Equal(t, 200, rsp.StatusCode)
+		var gotQueueStats map[string]addrs.QueueStats
+		err = json.NewDecoder(rsp.Body).Decode(&gotQueueStats)
+		require.Nil(t, err)
+		require.Equal(t, queueStats, gotQueueStats)
+		rsp.Body.Close()
+
+		rsp, err = http.Get(fmt.Sprintf("http://localhost:7908/api/address/shards"))
+		require.Nil(t, err)
+		require.Equal(t, 200, rsp.StatusCode)
+		var gotShards []addrs.ShardStatus
+		err = json.NewDecoder(rsp.Body).Decode(&gotShards)
+		require.Nil(t, err)
+		require.Equal(t, shards, gotShards)
+		rsp.Body.Close()
+
 		var tt = []struct {
 			name        string
 			status      string
@@ -151,12 +387,42 @@ func TestRunMonitor(t *testing.T) {
 				http.StatusOK,
 				dpis[3:5],
 			},
+			{
+				"get deposit that are in waiting_review status",
+				"waiting_review",
+				http.StatusOK,
+				dpis[5:6],
+			},
 			{
 				"get unknown status",
 				"invalid",
 				http.StatusBadRequest,
 				nil,
 			},
+			{
+				"get deposits to a deposit address",
+				"&deposit_addr=b2",
+				http.StatusOK,
+				dpis[1:2],
+			},
+			{
+				"get deposits of a txid",
+				"&txid=t6",
+				http.StatusOK,
+				dpis[5:6],
+			},
+			{
+				"get deposits to a deposit address in a status",
+				"done&deposit_addr=b5",
+				http.StatusOK,
+				dpis[4:5],
+			},
+			{
+				"get deposits of an invalid date",
+				"&from=2018-13-01",
+				http.StatusBadRequest,
+				nil,
+			},
 		}
 
 		for _, tc := range tt {
@@ -172,11 +438,13 @@ func TestRunMonitor(t *testing.T) {
 					for _, s := range st {
 						dss = append(dss, exchange.DepositInfo{
 							Seq:            s.Seq,
+							Version:        s.Version,
 							UpdatedAt:      s.UpdatedAt,
 							Status:         exchange.NewStatusFromStr(s.Status),
 							DepositAddress: s.DepositAddress,
 							SkyAddress:     s.SkyAddress,
 							Txid:           s.Txid,
+							DepositID:      s.DepositID,
 						})
 					}
 					require.Equal(t, tc.expectValue, dss)
@@ -184,6 +452,136 @@ func TestRunMonitor(t *testing.T) {
 			})
 		}
 
+		var approveTT = []struct {
+			name       string
+			body       string
+			expectCode int
+		}{
+			{
+				"approve deposit in review",
+				`{"deposit_id": "t6:0"}`,
+				http.StatusOK,
+			},
+			{
+				"approve deposit at its version",
+				`{"deposit_id": "t6:0", "version": 2}`,
+				http.StatusOK,
+			},
+			{
+				"approve deposit changed since its version",
+				`{"deposit_id": "t6:0", "version": 1}`,
+				http.StatusConflict,
+			},
+			{
+				"approve deposit not in review",
+				`{"deposit_id": "t2:0"}`,
+				http.StatusConflict,
+			},
+			{
+				"approve deposit missing id",
+				`{"deposit_id": ""}`,
+				http.StatusBadRequest,
+			},
+			{
+				"approve unknown deposit",
+				`{"deposit_id": "t7:0"}`,
+				http.StatusNotFound,
+			},
+			{
+				"approve invalid json",
+				`{"deposit_id":`,
+				http.StatusBadRequest,
+			},
+		}
+
+		for _, tc := range approveTT {
+			t.Run(tc.name, func(t *testing.T) {
+				rsp, err := http.Post("http://localhost:7908/api/deposit/approve", "application/json", strings.NewReader(tc.body))
+				require.Nil(t, err)
+				defer rsp.Body.Close()
+				require.Equal(t, tc.expectCode, rsp.StatusCode)
+				if rsp.StatusCode == 200 {
+					var ds exchange.DepositStatusDetail
+					require.Nil(t, json.NewDecoder(rsp.Body).Decode(&ds))
+					require.Equal(t, "t6:0", ds.DepositID)
+					require.Equal(t, exchange.StatusWaitSend.String(), ds.Status)
+				}
+			})
+		}
+
+		rsp, err = http.Get("http://localhost:7908/api/deposit/approve")
+		require.Nil(t, err)
+		require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+		rsp.Body.Close()
+
+		for _, erased := range []int{2, 0} {
+			rsp, err = http.Post("http://localhost:7908/api/contacts/erase", "application/json", strings.NewReader(`{"skyaddr": "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"}`))
+			require.Nil(t, err)
+			require.Equal(t, http.StatusOK, rsp.StatusCode)
+			var ecr eraseContactsResponse
+			require.Nil(t, json.NewDecoder(rsp.Body).Decode(&ecr))
+			require.Equal(t, erased, ecr.Erased)
+			rsp.Body.Close()
+		}
+
+		rsp, err = http.Post("http://localhost:7908/api/contacts/erase", "application/json", strings.NewReader(`{}`))
+		require.Nil(t, err)
+		require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+		rsp.Body.Close()
+
+		var exportTT = []struct {
+			name        string
+			query       string
+			expectCode  int
+			expectLines int
+		}{
+			{
+				"export csv",
+				"status=waiting_review",
+				http.StatusOK,
+				2,
+			},
+			{
+				"export jsonl",
+				"format=jsonl&status=done,waiting_send",
+				http.StatusOK,
+				3,
+			},
+			{
+				"export invalid format",
+				"format=xml",
+				http.StatusBadRequest,
+				0,
+			},
+			{
+				"export unknown status",
+				"status=invalid",
+				http.StatusBadRequest,
+				0,
+			},
+			{
+				"export invalid date",
+				"from=yesterday",
+				http.StatusBadRequest,
+				0,
+			},
+		}
+
+		for _, tc := range exportTT {
+			t.Run(tc.name, func(t *testing.T) {
+				rsp, err := http.Get(fmt.Sprintf("http://localhost:7908/api/deposit/export?%s", tc.query))
+				require.Nil(t, err)
+				defer rsp.Body.Close()
+				require.Equal(t, tc.expectCode, rsp.StatusCode)
+				if rsp.StatusCode == 200 {
+					b, err := ioutil.ReadAll(rsp.Body)
+					require.Nil(t, err)
+					lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+					require.Len(t, lines, tc.expectLines)
+				}
+			})
+		}
+
 		m.Shutdown()
 	})
 
@@ -191,3 +589,1209 @@ func TestRunMonitor(t *testing.T) {
 		return
 	}
 }
+
+type dummyQuiescer struct {
+	quiesced bool
+}
+
+func (q *dummyQuiescer) Quiesce() {
+	q.quiesced = true
+}
+
+func (q *dummyQuiescer) Resume() {
+	q.quiesced = false
+}
+
+func TestHandoverEndpoints(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	q := &dummyQuiescer{}
+	coordinator := handover.NewCoordinator(log, q)
+
+	m := New(log, Config{
+		HandoverToken: "token",
+	}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, coordinator, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	// A wrong token is rejected
+	_, err := handover.NewClient(srv.URL, "wrong").State()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "401")
+
+	c := handover.NewClient(srv.URL, "token")
+
+	state, err := c.State()
+	require.NoError(t, err)
+	require.Equal(t, handover.StateRunning, state)
+
+	// Release requires quiesce first
+	err = c.Release()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "409")
+
+	require.NoError(t, c.Quiesce())
+	require.True(t, q.quiesced)
+
+	require.NoError(t, c.Resume())
+	require.False(t, q.quiesced)
+
+	require.NoError(t, handover.TakeOver(log, c))
+	require.True(t, q.quiesced)
+
+	select {
+	case <-coordinator.Released():
+	default:
+		t.Fatal("coordinator was not released")
+	}
+
+	// Quiesce uses POST
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/handover/quiesce", nil)
+	require.NoError(t, err)
+	req.Header.Set(handover.TokenHeader, "token")
+	rsp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestHandoverDisabled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// Without a token, handover is disabled even if a coordinator is given
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, handover.NewCoordinator(log), pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	err := handover.NewClient(srv.URL, "").Quiesce()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "403")
+}
+
+func TestSubsystemsEndpoints(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	var sendGate pauseutil.Gate
+	subsystems := pauseutil.NewRegistry()
+	subsystems.Add("sender", &sendGate)
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, subsystems, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	post := func(path, body string) *http.Response {
+		rsp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		return rsp
+	}
+
+	rsp := post("/api/subsystems/pause", `{"name":"sender"}`)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var status pauseutil.Status
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&status))
+	rsp.Body.Close()
+	require.Equal(t, "sender", status.Name)
+	require.True(t, status.Paused)
+	require.True(t, sendGate.Status().Paused)
+
+	rsp, err := http.Get(srv.URL + "/api/subsystems")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var sr subsystemsResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&sr))
+	rsp.Body.Close()
+	require.Len(t, sr.Subsystems, 1)
+	require.True(t, sr.Subsystems[0].Paused)
+
+	rsp = post("/api/subsystems/resume", `{"name":"sender"}`)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+	require.False(t, sendGate.Status().Paused)
+
+	// Unknown subsystem
+	rsp = post("/api/subsystems/pause", `{"name":"webhooks"}`)
+	require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Missing name
+	rsp = post("/api/subsystems/pause", `{}`)
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Pause uses POST
+	rsp, err = http.Get(srv.URL + "/api/subsystems/pause")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestFeaturesEndpoints(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	features := flagutil.NewRegistry(map[string]bool{
+		"bind_quote":   true,
+		"stats_stream": true,
+	}, map[string]bool{
+		"stats_stream": false,
+	})
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, features, nil, nil, nil, nil)
+
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	post := func(path, body string) *http.Response {
+		rsp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		return rsp
+	}
+
+	rsp := post("/api/features/enable", `{"name":"stats_stream"}`)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var status flagutil.Status
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&status))
+	rsp.Body.Close()
+	require.Equal(t, "stats_stream", status.Name)
+	require.True(t, status.Enabled)
+	require.False(t, status.Configured)
+	require.NotZero(t, status.ChangedAt)
+	require.True(t, features.Enabled("stats_stream"))
+
+	rsp = post("/api/features/disable", `{"name":"bind_quote"}`)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+	require.False(t, features.Enabled("bind_quote"))
+
+	rsp, err := http.Get(srv.URL + "/api/features")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var fr featuresResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&fr))
+	rsp.Body.Close()
+	require.Len(t, fr.Features, 2)
+	require.Equal(t, "bind_quote", fr.Features[0].Name)
+	require.False(t, fr.Features[0].Enabled)
+	require.True(t, fr.Features[0].Configured)
+
+	// The runtime shows the flags
+	rsp, err = http.Get(srv.URL + "/api/runtime")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var rr runtimeResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&rr))
+	rsp.Body.Close()
+	require.Equal(t, map[string]bool{
+		"bind_quote":   false,
+		"stats_stream": true,
+	}, rr.Features)
+
+	rsp = post("/api/features/reset", `{"name":"bind_quote"}`)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+	require.True(t, features.Enabled("bind_quote"))
+
+	// Unknown flag
+	rsp = post("/api/features/enable", `{"name":"websockets"}`)
+	require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Missing name
+	rsp = post("/api/features/enable", `{}`)
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Enable uses POST
+	rsp, err = http.Get(srv.URL + "/api/features/enable")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/features")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestDBEndpoints(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("deposit_info"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	}))
+
+	compactor := dbutil.NewCompactor(db)
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), compactor, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/db")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var dr dbResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&dr))
+	rsp.Body.Close()
+	require.NotZero(t, dr.Size)
+	require.Equal(t, map[string]int{"deposit_info": 1}, dr.BucketKeys)
+	require.False(t, dr.CompactRequested)
+
+	// Compact uses POST
+	rsp, err = http.Get(srv.URL + "/api/db/compact")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+
+	rsp, err = http.Post(srv.URL+"/api/db/compact", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&dr))
+	rsp.Body.Close()
+	require.True(t, dr.CompactRequested)
+
+	select {
+	case <-compactor.Requested():
+	default:
+		t.Fatal("Compaction was not requested")
+	}
+}
+
+type dummyAbuseStats abuse.Stats
+
+func (s dummyAbuseStats) Stats() abuse.Stats {
+	return abuse.Stats(s)
+}
+
+func TestAbuseHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	stats := abuse.Stats{
+		Requests: 10,
+		Anomalies: map[string]uint64{
+			abuse.AnomalyBotUserAgent: 3,
+		},
+		Throttled: 1,
+		Refused:   2,
+		ASNs:      []abuse.ASNStats{},
+		Clients: []abuse.ClientStats{
+			{
+				Client:  "1.2.3.4",
+				Strikes: 3,
+			},
+		},
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, dummyAbuseStats(stats), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/abuse")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var s abuse.Stats
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&s))
+	rsp.Body.Close()
+	require.Equal(t, stats, s)
+
+	rsp, err = http.Post(srv.URL+"/api/abuse", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/abuse")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+type dummyForecaster []addrs.Forecast
+
+func (f dummyForecaster) Forecasts() []addrs.Forecast {
+	return f
+}
+
+func TestAddressForecastHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	exhaustedAt := time.Date(2018, 3, 2, 12, 0, 0, 0, time.UTC)
+	forecasts := []addrs.Forecast{
+		{
+			CoinType:    "BTC",
+			Remaining:   240,
+			BindRate:    10,
+			Span:        time.Hour * 6,
+			ExhaustedAt: &exhaustedAt,
+			Alert:       true,
+			CheckedAt:   exhaustedAt.Add(-time.Hour * 24),
+		},
+		{
+			CoinType:  "ETH",
+			Remaining: 100,
+			Span:      time.Hour * 6,
+			CheckedAt: exhaustedAt.Add(-time.Hour * 24),
+		},
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, dummyForecaster(forecasts), nil, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/address/forecast")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var fcs []addrs.Forecast
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&fcs))
+	rsp.Body.Close()
+	require.Equal(t, forecasts, fcs)
+
+	rsp, err = http.Post(srv.URL+"/api/address/forecast", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/address/forecast")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+type dummyRateFeeds []exchange.RateFeedStatus
+
+func (f dummyRateFeeds) RateFeedStatuses() []exchange.RateFeedStatus {
+	return f
+}
+
+func TestRateFeedsHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	agreedAt := time.Date(2018, 3, 2, 12, 0, 0, 0, time.UTC)
+	checkedAt := agreedAt.Add(time.Minute)
+	statuses := []exchange.RateFeedStatus{
+		{
+			CoinType: "BTC",
+			Rate:     "505",
+			Source:   exchange.RateSourceLastKnownGood,
+			AgreedAt: &agreedAt,
+			Feeds: []exchange.RateFeedHealth{
+				{
+					Name:          "a",
+					Quote:         "505",
+					Error:         "Rate feed returned status 503",
+					Failures:      1,
+					CheckedAt:     &checkedAt,
+					LastSuccessAt: &agreedAt,
+				},
+				{
+					Name:      "b",
+					Quote:     "5000",
+					Outlier:   true,
+					CheckedAt: &checkedAt,
+				},
+			},
+		},
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, dummyRateFeeds(statuses), nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/rates/feeds")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var rfs []exchange.RateFeedStatus
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&rfs))
+	rsp.Body.Close()
+	require.Equal(t, statuses, rfs)
+
+	rsp, err = http.Post(srv.URL+"/api/rates/feeds", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/rates/feeds")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestSimulateRateHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	dps := &dummyDepositStatusGetter{
+		dpis: []exchange.DepositInfo{
+			{
+				CoinType:       scanner.CoinTypeBTC,
+				DepositAddress: "b1",
+				SkyAddress:     "s1",
+				DepositID:      "t1:0",
+				DepositValue:   1e8,
+				ConversionRate: "1000",
+				Status:         exchange.StatusWaitReview,
+			},
+			{
+				CoinType:       scanner.CoinTypeBTC,
+				DepositAddress: "b2",
+				SkyAddress:     "s2",
+				DepositID:      "t2:0",
+				Status:         exchange.StatusDone,
+			},
+		},
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, dps, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	schema := testutil.RequireSchema(t, "rates_simulate", adminSchemas["rates_simulate"])
+
+	rsp, err := http.Post(srv.URL+"/api/rates/simulate", "application/json", strings.NewReader(`{"coin_type":"BTC","rate":"500"}`))
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode, string(body))
+	require.NoError(t, schema.Validate(body), string(body))
+
+	var report exchange.RateSimulationReport
+	require.NoError(t, json.Unmarshal(body, &report))
+	require.Equal(t, "500", report.ProposedRate)
+	require.Len(t, report.Deposits, 1)
+	require.Equal(t, "t1:0", report.Deposits[0].DepositID)
+
+	for body, code := range map[string]int{
+		`{"coin_type":"BTC"}`:                    http.StatusBadRequest,
+		`{"coin_type":"XRP","rate":"500"}`:       http.StatusBadRequest,
+		`{"coin_type":"BTC","rate":"-1"}`:        http.StatusBadRequest,
+		`{"coin_type":"BTC","max_decimals":-1}`:  http.StatusBadRequest,
+		`{"coin_type":"BTC","max_decimals":"a"}`: http.StatusBadRequest,
+		`not json`:                               http.StatusBadRequest,
+	} {
+		rsp, err := http.Post(srv.URL+"/api/rates/simulate", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, code, rsp.StatusCode, body)
+		rsp.Body.Close()
+	}
+
+	rsp, err = http.Get(srv.URL + "/api/rates/simulate")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	require.Equal(t, http.MethodPost, rsp.Header.Get("Allow"))
+	rsp.Body.Close()
+}
+
+type dummyReplication struct {
+	status replica.Status
+}
+
+func (r dummyReplication) ServeSync(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("synced"))
+}
+
+func (r dummyReplication) Status() (replica.Status, error) {
+	return r.status, nil
+}
+
+type dummyStartupReporter exchange.StartupReport
+
+func (r dummyStartupReporter) StartupReport() exchange.StartupReport {
+	return exchange.StartupReport(r)
+}
+
+func TestStartupReportHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	startedAt := time.Now().UTC().Truncate(time.Second)
+	report := exchange.StartupReport{
+		StartedAt: startedAt,
+		Previous: &exchange.RunState{
+			State:     exchange.RunStateRunning,
+			StartedAt: startedAt.Add(-time.Hour),
+		},
+		Unclean: true,
+		Deposits: []exchange.InterruptedDeposit{
+			{
+				DepositID: "btx:0",
+				Status:    exchange.StatusWaitSend.String(),
+				CoinType:  scanner.CoinTypeBTC,
+				UpdatedAt: startedAt.Add(-time.Minute).Unix(),
+				Reason:    exchange.InterruptedSend,
+			},
+		},
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, dummyStartupReporter(report), nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/startup_report")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var r exchange.StartupReport
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&r))
+	rsp.Body.Close()
+	require.Equal(t, report, r)
+
+	rsp, err = http.Post(srv.URL+"/api/startup_report", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Forbidden without run state markers
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/startup_report")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+type dummyRunbook runbook.Status
+
+func (r dummyRunbook) Status() runbook.Status {
+	return runbook.Status(r)
+}
+
+func TestRunbookHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	status := runbook.Status{
+		Rules: []runbook.RuleStatus{
+			{
+				Name:        "sky_down",
+				Condition:   runbook.ConditionNodeDown,
+				Triggered:   true,
+				TriggeredAt: 1519905600,
+				Summary:     "sky node is down since 2018-03-01T11:55:00Z, for 5m0s",
+				CheckedAt:   1519905600,
+				Actions: []runbook.ActionResult{
+					{Type: runbook.ActionFallbackNode, Target: runbook.NodeSky, At: 1519905600, Detail: "switched to http://127.0.0.1:16430"},
+				},
+			},
+		},
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, dummyRunbook(status), nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/runbook")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var s runbook.Status
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&s))
+	rsp.Body.Close()
+	require.Equal(t, status, s)
+
+	rsp, err = http.Post(srv.URL+"/api/runbook", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Forbidden if the runbook is disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/runbook")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+type dummyCoinHours sender.HoursStatus
+
+func (h dummyCoinHours) Status() sender.HoursStatus {
+	return sender.HoursStatus(h)
+}
+
+func TestCoinHoursHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	checkedAt := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	recoveryAt := checkedAt.Add(time.Hour * 2)
+	status := sender.HoursStatus{
+		Coins:         "100.000000",
+		Hours:         5,
+		IncomingHours: 5,
+		AccrualRate:   100,
+		Queued:        21,
+		Required:      210,
+		Waiting:       true,
+		RecoveryAt:    &recoveryAt,
+		CheckedAt:     &checkedAt,
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, dummyCoinHours(status))
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/coin_hours")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var s sender.HoursStatus
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&s))
+	rsp.Body.Close()
+	require.Equal(t, status, s)
+
+	// Forbidden if the coin hours aren't tracked
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/coin_hours")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestReplicationHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	syncedAt := time.Now().UTC().Truncate(time.Second)
+	status := replica.Status{
+		Role:        replica.RolePrimary,
+		TxID:        12,
+		StandbyTxID: 11,
+		SyncedAt:    &syncedAt,
+		Lag:         1.5,
+		SyncBytes:   4096,
+	}
+
+	m := New(log, Config{
+		ReplicationToken: "token",
+	}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, dummyReplication{status}, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/replication")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var st replica.Status
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&st))
+	rsp.Body.Close()
+	require.Equal(t, status, st)
+
+	sync := func(url, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, url+replica.SyncPath, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set(replica.TokenHeader, token)
+		}
+		rsp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return rsp
+	}
+
+	// The sync endpoint requires the replication token
+	rsp = sync(srv.URL, "")
+	require.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	rsp.Body.Close()
+
+	rsp = sync(srv.URL, "wrong")
+	require.Equal(t, http.StatusUnauthorized, rsp.StatusCode)
+	rsp.Body.Close()
+
+	rsp = sync(srv.URL, "token")
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	b, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, "synced", string(b))
+
+	// Without a token, the sync endpoint is disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, dummyReplication{status}, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp = sync(srv2.URL, "")
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Disabled
+	m = New(log, Config{
+		ReplicationToken: "token",
+	}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv3 := httptest.NewServer(m.setupMux())
+	defer srv3.Close()
+
+	rsp, err = http.Get(srv3.URL + "/api/replication")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+
+	rsp = sync(srv3.URL, "token")
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+type dummyEmailPreviewer struct{}
+
+func (p dummyEmailPreviewer) PreviewEmail(event, language string) (notify.Preview, error) {
+	if event != notify.EventPayoutSent {
+		return notify.Preview{}, notify.ErrUnknownEvent
+	}
+	if language == "../en" {
+		return notify.Preview{}, notify.ErrInvalidLanguage
+	}
+
+	return notify.Preview{
+		Event:     event,
+		Language:  "en",
+		Subject:   "1500.000000 SKY sent",
+		Body:      "1500.000000 SKY have been sent",
+		Languages: []string{"en"},
+	}, nil
+}
+
+func TestEmailPreviewHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, dummyEmailPreviewer{}, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/email/preview?event=payout_sent&language=pt-br")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var preview notify.Preview
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&preview))
+	rsp.Body.Close()
+	require.Equal(t, "en", preview.Language)
+	require.Equal(t, "1500.000000 SKY sent", preview.Subject)
+
+	for _, uri := range []string{
+		"/api/email/preview",
+		"/api/email/preview?event=unknown",
+		"/api/email/preview?event=payout_sent&language=../en",
+	} {
+		rsp, err = http.Get(srv.URL + uri)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, rsp.StatusCode, uri)
+		rsp.Body.Close()
+	}
+
+	// Disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/email/preview?event=payout_sent")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestCaptureHandlers(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	recorder, err := capture.New(log, config.Capture{
+		Enabled:     true,
+		MaxDuration: time.Hour,
+		MaxRecords:  10,
+		MaxBodySize: 1024,
+	}, db, clock.Real{})
+	require.NoError(t, err)
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, recorder, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	getSession := func() *capture.Session {
+		rsp, err := http.Get(srv.URL + "/api/capture")
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		var s captureResponse
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(&s))
+		return s.Session
+	}
+
+	require.Nil(t, getSession())
+
+	rsp, err := http.Post(srv.URL+"/api/capture/stop", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Invalid requests
+	for _, body := range []string{
+		`{"duration":"15m"}`,
+		`{"endpoints":["/api/bind"]}`,
+		`{"endpoints":["/api/bind"],"duration":"2h"}`,
+		`not json`,
+	} {
+		rsp, err = http.Post(srv.URL+"/api/capture/start", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusBadRequest, rsp.StatusCode, body)
+		rsp.Body.Close()
+	}
+
+	rsp, err = http.Post(srv.URL+"/api/capture/start", "application/json", strings.NewReader(`{"endpoints":["/api/bind"],"duration":"15m"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var started captureResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&started))
+	rsp.Body.Close()
+	require.NotNil(t, started.Session)
+	require.True(t, started.Session.Active)
+	require.Equal(t, []string{"/api/bind"}, started.Session.Endpoints)
+
+	rsp, err = http.Post(srv.URL+"/api/capture/start", "application/json", strings.NewReader(`{"endpoints":["/api/bind"],"duration":"15m"}`))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusConflict, rsp.StatusCode)
+	rsp.Body.Close()
+
+	// Record a request
+	h := recorder.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"deposit_address":"1abc"}`)) // nolint: errcheck
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(`{}`)))
+
+	getRecords := func(query string) []capture.Record {
+		rsp, err := http.Get(srv.URL + "/api/capture/records" + query)
+		require.NoError(t, err)
+		defer rsp.Body.Close()
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		var r captureRecordsResponse
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(&r))
+		return r.Records
+	}
+
+	records := getRecords("?session=" + started.Session.ID)
+	require.Len(t, records, 1)
+	require.Equal(t, `{"deposit_address":"1abc"}`, records[0].ResponseBody)
+	require.Empty(t, getRecords("?session=other"))
+
+	rsp, err = http.Get(srv.URL + "/api/capture/records?limit=x")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+	rsp.Body.Close()
+
+	rsp, err = http.Post(srv.URL+"/api/capture/stop", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	rsp.Body.Close()
+
+	s := getSession()
+	require.False(t, s.Active)
+	require.Equal(t, 1, s.Records)
+
+	req, err := http.NewRequest(http.MethodDelete, srv.URL+"/api/capture/records", nil)
+	require.NoError(t, err)
+	rsp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var cleared captureClearResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&cleared))
+	rsp.Body.Close()
+	require.Equal(t, 1, cleared.Deleted)
+	require.Empty(t, getRecords(""))
+
+	// Disabled
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/capture")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestRuntimeHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	dbPath := filepath.Join(t.TempDir(), "teller.db")
+	require.NoError(t, os.WriteFile(dbPath, make([]byte, 4096), 0600))
+
+	queueStats := map[string]addrs.QueueStats{
+		scanner.CoinTypeBTC: {Depth: 3},
+		scanner.CoinTypeETH: {Depth: 0},
+	}
+	m := New(log, Config{
+		DBPath: dbPath,
+	}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, &dummyDepositStatusGetter{}, &dummyScanAddrs{}, dummyQueueStats{stats: queueStats}, nil, handover.NewCoordinator(log), pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/runtime")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	var rr runtimeResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&rr))
+	require.Equal(t, version.Get(), rr.Info)
+	require.Equal(t, []string{scanner.CoinTypeBTC, scanner.CoinTypeETH}, rr.CoinTypes)
+	require.Equal(t, int64(4096), rr.DBSize)
+	require.Equal(t, map[string]int{
+		scanner.CoinTypeBTC: 3,
+		scanner.CoinTypeETH: 0,
+	}, rr.QueueDepths)
+	require.Equal(t, handover.StateRunning, rr.HandoverState)
+	require.NotZero(t, rr.Goroutines)
+	require.NotZero(t, rr.Sys)
+
+	rsp, err = http.Post(srv.URL+"/api/runtime", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+func TestLookupHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	dps := &dummyDepositStatusGetter{
+		dpis: []exchange.DepositInfo{
+			{
+				DepositAddress: "b1",
+				SkyAddress:     "s1",
+				CoinType:       scanner.CoinTypeBTC,
+				Txid:           "skytx1",
+			},
+		},
+	}
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, dps, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	for _, q := range []string{"deposit_addr=b1", "txid=skytx1"} {
+		rsp, err := http.Get(srv.URL + "/api/lookup?" + q)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode, q)
+		var lr lookupResponse
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(&lr))
+		rsp.Body.Close()
+		require.Len(t, lr.Owners, 1)
+		require.Equal(t, "s1", lr.Owners[0].SkyAddress)
+		require.Equal(t, "b1", lr.Owners[0].Bindings[0].DepositAddress)
+	}
+
+	for q, code := range map[string]int{
+		"deposit_addr=b2":        http.StatusNotFound,
+		"":                       http.StatusBadRequest,
+		"deposit_addr=b1&txid=x": http.StatusBadRequest,
+	} {
+		rsp, err := http.Get(srv.URL + "/api/lookup?" + q)
+		require.NoError(t, err)
+		require.Equal(t, code, rsp.StatusCode, q)
+		rsp.Body.Close()
+	}
+
+	rsp, err := http.Post(srv.URL+"/api/lookup", "application/json", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+	rsp.Body.Close()
+}
+
+type dummyTxInspector map[string]*scanner.TxDetail
+
+func (d dummyTxInspector) InspectTx(txid string) (*scanner.TxDetail, error) {
+	tx, ok := d[txid]
+	if !ok {
+		return nil, scanner.ErrTxNotFound
+	}
+	return tx, nil
+}
+
+func TestInspectDepositHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	dv := deposits.Deposit{
+		CoinType: scanner.CoinTypeBTC,
+		Address:  "b1",
+		Tx:       "btx1",
+		N:        1,
+		Amount:   1e8,
+		Height:   500000,
+	}
+	dps := &dummyDepositStatusGetter{
+		dpis: []exchange.DepositInfo{
+			{
+				DepositID:    dv.ID(),
+				DepositValue: dv.Amount,
+				Deposit:      dv,
+			},
+		},
+	}
+
+	scanStore, err := scanner.NewStore(log, db)
+	require.NoError(t, err)
+
+	// Deposits can't be inspected without an inspector
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, dps, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	rsp, err := http.Get(srv.URL + "/api/deposit/inspect?deposit_id=btx1:1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+	srv.Close()
+
+	fee := int64(1000)
+	inspector := scanner.NewInspector(scanStore)
+	inspector.AddNode(scanner.CoinTypeBTC, dummyTxInspector{
+		"btx1": {
+			Txid:          "btx1",
+			Hex:           "0100",
+			BlockHash:     "blockhash",
+			BlockHeight:   500000,
+			Confirmations: 6,
+			Outputs: []scanner.TxOutput{
+				{N: 0, Value: 5e7, Addresses: []string{"change"}},
+				{N: 1, Value: 9e7, Addresses: []string{"b1"}},
+			},
+			Fee: &fee,
+		},
+	})
+
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, dps, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, inspector, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv = httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	rsp, err = http.Get(srv.URL + "/api/deposit/inspect?deposit_id=btx1:1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	var ir inspectDepositResponse
+	require.NoError(t, json.NewDecoder(rsp.Body).Decode(&ir))
+	rsp.Body.Close()
+
+	require.Equal(t, dv, ir.Deposit.Deposit)
+	require.NotNil(t, ir.Chain.Tx)
+	require.Equal(t, "0100", ir.Chain.Tx.Hex)
+	require.Equal(t, fee, *ir.Chain.Tx.Fee)
+	require.Nil(t, ir.Chain.Scanned)
+	// The scanner has no record of the deposit, and the output's value differs from the deposit's
+	require.Equal(t, []string{
+		"The scanner has no record of the deposit",
+		"Output 1 has a value of 90000000, the deposit's is 100000000",
+	}, ir.Chain.Mismatches)
+
+	for q, code := range map[string]int{
+		"deposit_id=btx2:0": http.StatusNotFound,
+		"deposit_id=btx1":   http.StatusBadRequest,
+		"":                  http.StatusBadRequest,
+	} {
+		rsp, err := http.Get(srv.URL + "/api/deposit/inspect?" + q)
+		require.NoError(t, err)
+		require.Equal(t, code, rsp.StatusCode, q)
+		rsp.Body.Close()
+	}
+}
+
+func TestAuditLogHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	dps := &dummyDepositStatusGetter{}
+	for i := 0; i < 5; i++ {
+		dps.dpis = append(dps.dpis, exchange.DepositInfo{
+			DepositID: fmt.Sprintf("t%d:0", i),
+			Status:    exchange.StatusDone,
+			UpdatedAt: int64(1520510400 + i),
+		})
+	}
+
+	pubKey, secKey := cipher.GenerateKeyPair()
+	m := New(log, Config{
+		AuditLogKey: secKey,
+	}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, dps, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	// Read the log in pages of 2, resuming from the cursor of each page
+	var cursor uint64
+	var prevHash string
+	var entries []exchange.AuditLogEntry
+	for n := 0; ; n++ {
+		require.True(t, n < 5)
+
+		rsp, err := http.Get(fmt.Sprintf("%s/api/audit-log?cursor=%d&limit=2", srv.URL, cursor))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		body, err := ioutil.ReadAll(rsp.Body)
+		require.NoError(t, err)
+		rsp.Body.Close()
+
+		page, err := exchange.VerifyAuditLogPage(body, rsp.Header.Get(AuditLogSignatureHeader), pubKey.Hex(), prevHash)
+		require.NoError(t, err)
+		require.Equal(t, prevHash, page.PrevHash)
+
+		entries = append(entries, page.Entries...)
+		if !page.More {
+			break
+		}
+
+		cursor = page.Cursor
+		prevHash = page.Entries[len(page.Entries)-1].Hash
+	}
+
+	require.Len(t, entries, 5)
+	require.NoError(t, exchange.VerifyAuditLog(entries, ""))
+
+	// A changed page fails to verify
+	rsp, err := http.Get(srv.URL + "/api/audit-log")
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	rsp.Body.Close()
+
+	sig := rsp.Header.Get(AuditLogSignatureHeader)
+	_, err = exchange.VerifyAuditLogPage(body, sig, pubKey.Hex(), "")
+	require.NoError(t, err)
+	_, err = exchange.VerifyAuditLogPage([]byte(strings.Replace(string(body), "t2:0", "t9:0", 1)), sig, pubKey.Hex(), "")
+	require.Error(t, err)
+
+	for q, code := range map[string]int{
+		"cursor=x":    http.StatusBadRequest,
+		"limit=0":     http.StatusBadRequest,
+		"limit=10001": http.StatusBadRequest,
+	} {
+		rsp, err := http.Get(srv.URL + "/api/audit-log?" + q)
+		require.NoError(t, err)
+		require.Equal(t, code, rsp.StatusCode, q)
+		rsp.Body.Close()
+	}
+
+	// Disabled without a key
+	m = New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, dps, &dummyScanAddrs{}, dummyQueueStats{}, nil, nil, pauseutil.NewRegistry(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	srv2 := httptest.NewServer(m.setupMux())
+	defer srv2.Close()
+
+	rsp, err = http.Get(srv2.URL + "/api/audit-log")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+	rsp.Body.Close()
+}
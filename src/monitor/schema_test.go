@@ -0,0 +1,211 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/abuse"
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/handover"
+	"github.com/skycoin/teller/src/notify"
+	"github.com/skycoin/teller/src/replica"
+	"github.com/skycoin/teller/src/runbook"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/sender"
+	"github.com/skycoin/teller/src/util/flagutil"
+	"github.com/skycoin/teller/src/util/pauseutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// adminSchemas are the response types of the admin API, by the name of their schema in testdata/schemas
+var adminSchemas = map[string]interface{}{
+	"address":                 addressUsage{},
+	"address_queue":           map[string]addrs.QueueStats{},
+	"address_forecast":        []addrs.Forecast{},
+	"address_shards":          []addrs.ShardStatus{},
+	"rate_feeds":              []exchange.RateFeedStatus{},
+	"rates_simulate":          exchange.RateSimulationReport{},
+	"deposit_status":          []exchange.DepositStatusDetail{},
+	"deposit_approve":         exchange.DepositStatusDetail{},
+	"deposit_inspect":         inspectDepositResponse{},
+	"lookup":                  lookupResponse{},
+	"stats":                   exchange.DepositStats{},
+	"ledger":                  exchange.LedgerReport{},
+	"audit_log":               exchange.AuditLogPage{},
+	"runtime":                 runtimeResponse{},
+	"contacts_erase":          eraseContactsResponse{},
+	"email_preview":           notify.Preview{},
+	"subsystems":              subsystemsResponse{},
+	"subsystems_pause":        pauseutil.Status{},
+	"features":                featuresResponse{},
+	"features_enable":         flagutil.Status{},
+	"db":                      dbResponse{},
+	"abuse":                   abuse.Stats{},
+	"capture":                 captureResponse{},
+	"capture_records":         captureRecordsResponse{},
+	"capture_records_delete":  captureClearResponse{},
+	"handover":                handover.StateResponse{},
+	"replication":             replica.Status{},
+	"startup_report":          exchange.StartupReport{},
+	"runbook":                 runbook.Status{},
+	"coin_hours":              sender.HoursStatus{},
+	"auth_session":            sessionResponse{},
+	"auth_webauthn_challenge": webAuthnChallengeResponse{},
+}
+
+func TestAdminResponseSchemas(t *testing.T) {
+	for name, v := range adminSchemas {
+		t.Run(name, func(t *testing.T) {
+			testutil.RequireSchema(t, name, v)
+		})
+	}
+}
+
+func TestAdminResponsesMatchSchemas(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	dps := &dummyDepositStatusGetter{
+		dpis: []exchange.DepositInfo{
+			{
+				Seq:            1,
+				CoinType:       scanner.CoinTypeBTC,
+				DepositAddress: "b1",
+				SkyAddress:     "s1",
+				Status:         exchange.StatusDone,
+				DepositID:      "btx1:0",
+				Txid:           "btx1",
+				DepositValue:   1e8,
+			},
+		},
+	}
+	queueStats := dummyQueueStats{
+		stats: map[string]addrs.QueueStats{
+			scanner.CoinTypeBTC: {
+				Depth:   1,
+				Served:  10,
+				MaxWait: time.Second,
+			},
+		},
+		shards: []addrs.ShardStatus{
+			{
+				CoinType:    scanner.CoinTypeBTC,
+				Count:       2,
+				End:         500,
+				PoolSize:    1000,
+				Remaining:   240,
+				UsedOutside: 1,
+			},
+		},
+	}
+	exhaustedAt := time.Date(2018, 3, 2, 12, 0, 0, 0, time.UTC)
+	forecasts := dummyForecaster{
+		{
+			CoinType:    scanner.CoinTypeBTC,
+			Remaining:   240,
+			BindRate:    10,
+			Span:        time.Hour,
+			ExhaustedAt: &exhaustedAt,
+			CheckedAt:   exhaustedAt.Add(-time.Hour * 24),
+		},
+	}
+
+	agreedAt := exhaustedAt.Add(-time.Hour)
+	rateFeeds := dummyRateFeeds{
+		{
+			CoinType: scanner.CoinTypeBTC,
+			Rate:     "505",
+			Source:   exchange.RateSourceQuorum,
+			AgreedAt: &agreedAt,
+			Feeds: []exchange.RateFeedHealth{
+				{Name: "a", Healthy: true, Quote: "505", CheckedAt: &agreedAt, LastSuccessAt: &agreedAt},
+				{Name: "b", Error: "timeout", Failures: 3, CheckedAt: &agreedAt},
+			},
+		},
+	}
+
+	m := New(log, Config{}, &dummyBtcAddrMgr{10}, &dummyEthAddrMgr{10}, dps, &dummyScanAddrs{}, queueStats, nil, nil, pauseutil.NewRegistry(), nil, dummyAbuseStats(abuse.Stats{}), nil, nil, forecasts, dummyEmailPreviewer{}, rateFeeds, flagutil.NewRegistry(config.FeatureFlagDefaults, nil), dummyReplication{replica.Status{
+		Role:      replica.RoleStandby,
+		TxID:      12,
+		SyncedAt:  &agreedAt,
+		Lag:       1.5,
+		SyncBytes: 4096,
+	}}, dummyStartupReporter{
+		StartedAt: agreedAt,
+		Previous: &exchange.RunState{
+			State:     exchange.RunStateStopped,
+			StartedAt: exhaustedAt.Add(-time.Hour * 24),
+			StoppedAt: &agreedAt,
+			Reason:    "signal",
+		},
+		Deposits: []exchange.InterruptedDeposit{},
+	}, dummyRunbook{
+		DryRun: true,
+		Rules: []runbook.RuleStatus{
+			{
+				Name:        "btc_low",
+				Condition:   runbook.ConditionPoolLow,
+				Triggered:   true,
+				TriggeredAt: agreedAt.Unix(),
+				Summary:     "BTC deposit address pool has 9 addresses left, the minimum is 10",
+				CheckedAt:   agreedAt.Unix(),
+				Actions: []runbook.ActionResult{
+					{Type: runbook.ActionPause, Target: "scanner", At: agreedAt.Unix(), DryRun: true},
+				},
+			},
+		},
+	}, dummyCoinHours{
+		Coins:       "100.000000",
+		Hours:       5,
+		AccrualRate: 100,
+		Queued:      3,
+		Required:    30,
+		Waiting:     true,
+		RecoveryAt:  &exhaustedAt,
+		CheckedAt:   &agreedAt,
+	})
+	srv := httptest.NewServer(m.setupMux())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		schema string
+		uri    string
+	}{
+		{"address", "/api/address"},
+		{"address_queue", "/api/address/queue"},
+		{"address_forecast", "/api/address/forecast"},
+		{"address_shards", "/api/address/shards"},
+		{"rate_feeds", "/api/rates/feeds"},
+		{"deposit_status", "/api/deposit_status"},
+		{"lookup", "/api/lookup?deposit_addr=b1"},
+		{"stats", "/api/stats"},
+		{"ledger", "/api/ledger"},
+		{"subsystems", "/api/subsystems"},
+		{"features", "/api/features"},
+		{"abuse", "/api/abuse"},
+		{"email_preview", "/api/email/preview?event=payout_sent"},
+		{"replication", "/api/replication"},
+		{"startup_report", "/api/startup_report"},
+		{"runbook", "/api/runbook"},
+		{"coin_hours", "/api/coin_hours"},
+	} {
+		t.Run(tc.schema, func(t *testing.T) {
+			schema := testutil.RequireSchema(t, tc.schema, adminSchemas[tc.schema])
+
+			rsp, err := http.Get(srv.URL + tc.uri)
+			require.NoError(t, err)
+			defer rsp.Body.Close()
+			body, err := ioutil.ReadAll(rsp.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, http.StatusOK, rsp.StatusCode, string(body))
+			require.NoError(t, schema.Validate(body), string(body))
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package monitor
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	totpPeriod = 30 // seconds
+	totpDigits = 6
+	// Number of periods before and after the current one that are accepted,
+	// to tolerate clock drift between the server and the authenticator app
+	totpSkew = 1
+
+	// Failed codes a client can send before it is locked out
+	totpMaxFailures = 5
+	// First lockout of a client, doubled at each further failure up to totpMaxLockout
+	totpLockout    = time.Minute
+	totpMaxLockout = time.Hour
+	// Failed codes of all clients within totpGlobalWindow after which TOTP login is locked out
+	// for totpGlobalWindow, so that guesses spread over many IPs are bounded too
+	totpGlobalMaxFailures = 20
+	totpGlobalWindow      = time.Minute * 15
+)
+
+// totp verifies RFC 6238 time-based one time passwords
+type totp struct {
+	sync.Mutex
+	secret   []byte
+	lastStep int64 // last accepted time step, codes can't be reused
+}
+
+func newTOTP(secret string) (*totp, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TOTP secret: %v", err)
+	}
+
+	return &totp{
+		secret: key,
+	}, nil
+}
+
+// Verify returns true if code is valid at time t and was not used before
+func (o *totp) Verify(code string, t time.Time) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	step := t.Unix() / totpPeriod
+	for i := step - totpSkew; i <= step+totpSkew; i++ {
+		if i <= o.lastStep {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCode(o.secret, i)), []byte(code)) == 1 {
+			o.lastStep = i
+			return true
+		}
+	}
+
+	return false
+}
+
+// totpFailures counts the failed codes of a client, or of all clients
+type totpFailures struct {
+	count       int
+	since       time.Time // first failure counted
+	lockedUntil time.Time
+}
+
+// totpThrottle limits the failed TOTP codes per client and globally, so that a code can't be guessed.
+// A locked out client is refused without checking its code.
+type totpThrottle struct {
+	sync.Mutex
+	clients map[string]*totpFailures
+	global  totpFailures
+}
+
+func newTOTPThrottle() *totpThrottle {
+	return &totpThrottle{
+		clients: make(map[string]*totpFailures),
+	}
+}
+
+// allow returns true if client can try a code at time t, or else how long until it can
+func (th *totpThrottle) allow(client string, t time.Time) (bool, time.Duration) {
+	th.Lock()
+	defer th.Unlock()
+
+	lockedUntil := th.global.lockedUntil
+	if c, ok := th.clients[client]; ok && c.lockedUntil.After(lockedUntil) {
+		lockedUntil = c.lockedUntil
+	}
+
+	if t.Before(lockedUntil) {
+		return false, lockedUntil.Sub(t)
+	}
+	return true, 0
+}
+
+// fail records a failed code of client at time t
+func (th *totpThrottle) fail(client string, t time.Time) {
+	th.Lock()
+	defer th.Unlock()
+
+	// Forget the clients which stopped failing
+	for k, c := range th.clients {
+		if t.Sub(c.since) > totpMaxLockout && t.After(c.lockedUntil) {
+			delete(th.clients, k)
+		}
+	}
+
+	c, ok := th.clients[client]
+	if !ok {
+		c = &totpFailures{
+			since: t,
+		}
+		th.clients[client] = c
+	}
+
+	c.count++
+	if c.count >= totpMaxFailures {
+		lockout := totpMaxLockout
+		if n := uint(c.count - totpMaxFailures); n < 7 {
+			if d := totpLockout << n; d < lockout {
+				lockout = d
+			}
+		}
+		c.lockedUntil = t.Add(lockout)
+	}
+
+	if t.Sub(th.global.since) > totpGlobalWindow {
+		th.global = totpFailures{
+			since:       t,
+			lockedUntil: th.global.lockedUntil,
+		}
+	}
+
+	th.global.count++
+	if th.global.count >= totpGlobalMaxFailures {
+		th.global = totpFailures{
+			since:       t,
+			lockedUntil: t.Add(totpGlobalWindow),
+		}
+	}
+}
+
+// succeed forgets the failed codes of client once it logged in
+func (th *totpThrottle) succeed(client string) {
+	th.Lock()
+	defer th.Unlock()
+
+	delete(th.clients, client)
+}
+
+// totpCode computes the HOTP value (RFC 4226) for a TOTP time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", value%1000000)
+}
@@ -0,0 +1,217 @@
+package monitor
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+const (
+	// authenticatorData flag for "user present"
+	authDataFlagUserPresent = 0x01
+	// rpIdHash (32) + flags (1) + signCount (4)
+	authDataMinLen = 37
+)
+
+var (
+	errUnknownCredential    = errors.New("Unknown credential")
+	errInvalidClientData    = errors.New("Invalid client data")
+	errInvalidAuthData      = errors.New("Invalid authenticator data")
+	errInvalidSignature     = errors.New("Invalid signature")
+	errSignCountNotIncrease = errors.New("Signature counter did not increase, the security key may be cloned")
+)
+
+// webAuthnCredential is a security key registered by an operator
+type webAuthnCredential struct {
+	ID        []byte
+	PublicKey *ecdsa.PublicKey
+	signCount uint32
+}
+
+// webAuthn verifies WebAuthn assertions made by registered security keys.
+// Security keys are registered out of band by adding their credential ID
+// and SPKI public key to the config, so only assertions (logins) are handled.
+// Only ES256 (ECDSA P-256 with SHA-256) keys are supported.
+type webAuthn struct {
+	sync.Mutex
+	rpID        string
+	origin      string
+	credentials []*webAuthnCredential
+}
+
+// parseWebAuthnCredential parses a "<base64url credential ID>:<base64 SPKI public key>" string
+func parseWebAuthnCredential(s string) (*webAuthnCredential, error) {
+	pts := strings.SplitN(s, ":", 2)
+	if len(pts) != 2 {
+		return nil, errors.New("WebAuthn credential must be formatted as <credential ID>:<public key>")
+	}
+
+	id, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(pts[0], "="))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WebAuthn credential ID: %v", err)
+	}
+
+	der, err := base64.StdEncoding.DecodeString(pts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WebAuthn public key encoding: %v", err)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WebAuthn public key: %v", err)
+	}
+
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok || ecPub.Curve != elliptic.P256() {
+		return nil, errors.New("WebAuthn public key must be an ECDSA P-256 key")
+	}
+
+	return &webAuthnCredential{
+		ID:        id,
+		PublicKey: ecPub,
+	}, nil
+}
+
+func newWebAuthn(rpID, origin string, credentials []string) (*webAuthn, error) {
+	w := &webAuthn{
+		rpID:   rpID,
+		origin: origin,
+	}
+
+	for _, c := range credentials {
+		cred, err := parseWebAuthnCredential(c)
+		if err != nil {
+			return nil, err
+		}
+		w.credentials = append(w.credentials, cred)
+	}
+
+	return w, nil
+}
+
+// CredentialIDs returns the base64url encoded IDs of registered credentials,
+// sent to the browser as the allowCredentials list
+func (w *webAuthn) CredentialIDs() []string {
+	ids := make([]string, 0, len(w.credentials))
+	for _, c := range w.credentials {
+		ids = append(ids, base64.RawURLEncoding.EncodeToString(c.ID))
+	}
+	return ids
+}
+
+// webAuthnAssertion is the data returned by navigator.credentials.get(),
+// with binary fields base64url encoded
+type webAuthnAssertion struct {
+	CredentialID      string `json:"credential_id"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+	Signature         string `json:"signature"`
+}
+
+type collectedClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// Verify verifies an assertion. checkChallenge is called with the decoded
+// challenge, and must return true if the challenge was issued by us and is unused.
+func (w *webAuthn) Verify(a webAuthnAssertion, checkChallenge func([]byte) bool) error {
+	decode := func(s string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+
+	credID, err := decode(a.CredentialID)
+	if err != nil {
+		return errUnknownCredential
+	}
+
+	var cred *webAuthnCredential
+	for _, c := range w.credentials {
+		if bytes.Equal(c.ID, credID) {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return errUnknownCredential
+	}
+
+	clientDataJSON, err := decode(a.ClientDataJSON)
+	if err != nil {
+		return errInvalidClientData
+	}
+
+	var clientData collectedClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return errInvalidClientData
+	}
+
+	if clientData.Type != "webauthn.get" || clientData.Origin != w.origin {
+		return errInvalidClientData
+	}
+
+	challenge, err := decode(clientData.Challenge)
+	if err != nil || !checkChallenge(challenge) {
+		return errInvalidClientData
+	}
+
+	authData, err := decode(a.AuthenticatorData)
+	if err != nil || len(authData) < authDataMinLen {
+		return errInvalidAuthData
+	}
+
+	rpIDHash := sha256.Sum256([]byte(w.rpID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return errInvalidAuthData
+	}
+
+	if authData[32]&authDataFlagUserPresent == 0 {
+		return errInvalidAuthData
+	}
+
+	sig, err := decode(a.Signature)
+	if err != nil {
+		return errInvalidSignature
+	}
+
+	var esig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(sig, &esig); err != nil {
+		return errInvalidSignature
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+
+	if !ecdsa.Verify(cred.PublicKey, signed[:], esig.R, esig.S) {
+		return errInvalidSignature
+	}
+
+	// Authenticators that implement a signature counter must always increase it
+	signCount := binary.BigEndian.Uint32(authData[33:37])
+
+	w.Lock()
+	defer w.Unlock()
+
+	if signCount != 0 || cred.signCount != 0 {
+		if signCount <= cred.signCount {
+			return errSignCountNotIncrease
+		}
+	}
+	cred.signCount = signCount
+
+	return nil
+}
@@ -0,0 +1,467 @@
+// Package notify emails the optional contact address of a binding,
+// to confirm the binding and to report its payouts
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/explorer"
+	"github.com/skycoin/teller/src/util/pauseutil"
+)
+
+const (
+	// messageBufferSize is the number of emails which can wait to be sent
+	messageBufferSize = 1000
+	// maxEmailLength is the longest email address accepted, per RFC 5321
+	maxEmailLength = 254
+)
+
+var (
+	// ErrInvalidEmail is returned for an email address which is not a bare address, e.g. "user@example.com"
+	ErrInvalidEmail = errors.New("Invalid email address")
+	// ErrInvalidSignature is returned if the signature of a status link is invalid
+	ErrInvalidSignature = errors.New("Invalid signature")
+)
+
+// Config configures a Notifier
+type Config struct {
+	// Page which shows a binding's status, linked to in the emails
+	StatusURL string
+	// Key of the status link signatures
+	SigningKey string
+	// Hex encoded 32 byte AES-256 key of the stored emails
+	EncryptionKey string
+	// Coin paid out, named in the payout emails. Defaults to SKY.
+	PayoutCoin string
+	// Directory of the templates which override the built in templates, see LoadTemplates
+	TemplatesDir string
+	// Language of the emails of contacts which didn't give one. Defaults to DefaultLanguage.
+	DefaultLanguage string
+	// Explorer links of the addresses in the emails
+	Explorer explorer.Links
+	// Receipt links of the payout emails, nil if receipts are disabled
+	Receipts ReceiptLinker
+	// Operator branding of the emails
+	Branding Branding
+}
+
+// Branding is the operator's branding of the emails. Empty fields are left out.
+type Branding struct {
+	// Name of the service, which signs the emails
+	ServiceName string
+	// Page where depositors get help
+	SupportURL string
+	// Email address depositors can write to
+	ContactEmail string
+}
+
+// ReceiptLinker returns the signed receipt link of a completed deposit
+type ReceiptLinker interface {
+	Link(depositID string) string
+}
+
+// Validate returns an error if the configuration is invalid
+func (c Config) Validate() error {
+	u, err := url.Parse(c.StatusURL)
+	if err != nil {
+		return fmt.Errorf("Invalid StatusURL: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return errors.New("StatusURL must be an absolute URL")
+	}
+
+	if c.SigningKey == "" {
+		return errors.New("SigningKey is required")
+	}
+
+	if _, err := parseEncryptionKey(c.EncryptionKey); err != nil {
+		return err
+	}
+
+	if _, err := NormalizeLanguage(c.DefaultLanguage); err != nil {
+		return errors.New("Invalid DefaultLanguage")
+	}
+
+	return nil
+}
+
+func parseEncryptionKey(key string) ([]byte, error) {
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid EncryptionKey: %v", err)
+	}
+
+	if len(b) != 32 {
+		return nil, errors.New("EncryptionKey must be 32 bytes, hex encoded")
+	}
+
+	return b, nil
+}
+
+// ValidateEmail returns ErrInvalidEmail if email is not a bare email address
+func ValidateEmail(email string) error {
+	if len(email) > maxEmailLength {
+		return ErrInvalidEmail
+	}
+
+	a, err := mail.ParseAddress(email)
+	if err != nil || a.Address != email {
+		return ErrInvalidEmail
+	}
+
+	return nil
+}
+
+// Message is an email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(Message) error
+}
+
+// Notifier emails the contact addresses of bindings.
+// Emails are sent in the background; they are dropped if the mailer can't keep up.
+type Notifier struct {
+	log        logrus.FieldLogger
+	cfg        Config
+	store      *Store
+	mailer     Mailer
+	templates  *Templates
+	signingKey []byte
+	messages   chan Message
+	pauseGate  pauseutil.Gate // pauses sending, emails are queued meanwhile
+	quit       chan struct{}
+	done       chan struct{}
+}
+
+// NewNotifier creates a Notifier, which saves the contact emails in db
+func NewNotifier(log logrus.FieldLogger, db *bolt.DB, mailer Mailer, cfg Config) (*Notifier, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	key, err := parseEncryptionKey(cfg.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := NewStore(db, key)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := LoadTemplates(cfg.TemplatesDir, cfg.DefaultLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Notifier{
+		log:        log.WithField("prefix", "teller.notify"),
+		cfg:        cfg,
+		store:      store,
+		mailer:     mailer,
+		templates:  templates,
+		signingKey: []byte(cfg.SigningKey),
+		messages:   make(chan Message, messageBufferSize),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Run sends the queued emails, until Shutdown is called
+func (n *Notifier) Run() error {
+	log := n.log
+	log.Info("Start notify service")
+	defer log.Info("Notify service closed")
+	defer close(n.done)
+
+	for {
+		select {
+		case <-n.quit:
+			if n.pauseGate.Status().Paused {
+				log.WithField("queued", len(n.messages)).Warn("Notify service is paused, dropping the queued emails")
+				return nil
+			}
+
+			// Send whatever is left in the buffer
+			for {
+				select {
+				case m := <-n.messages:
+					n.send(m)
+				default:
+					return nil
+				}
+			}
+		case m := <-n.messages:
+			if !n.pauseGate.Enter(n.quit) {
+				log.WithField("subject", m.Subject).Warn("Notify service quit while paused, dropping email")
+				continue
+			}
+			n.send(m)
+			n.pauseGate.Leave()
+		}
+	}
+}
+
+// PauseGate returns the gate which pauses sending emails
+func (n *Notifier) PauseGate() *pauseutil.Gate {
+	return &n.pauseGate
+}
+
+// Shutdown sends the queued emails and stops the Notifier
+func (n *Notifier) Shutdown() {
+	close(n.quit)
+	<-n.done
+}
+
+func (n *Notifier) send(m Message) {
+	if err := n.mailer.Send(m); err != nil {
+		// The recipient is not logged, the email addresses are only stored encrypted
+		n.log.WithError(err).WithField("subject", m.Subject).Error("Send email failed, dropping email")
+	}
+}
+
+func (n *Notifier) queue(m Message) {
+	select {
+	case n.messages <- m:
+	default:
+		n.log.WithField("subject", m.Subject).Warn("Email buffer is full, dropping email")
+	}
+}
+
+// sign returns the signature of the status link of a binding
+func (n *Notifier) sign(skyAddr, depositAddr string) []byte {
+	h := hmac.New(sha256.New, n.signingKey)
+	h.Write([]byte(skyAddr + "\n" + depositAddr)) // nolint: errcheck
+	return h.Sum(nil)
+}
+
+// VerifySignature returns ErrInvalidSignature if sig is not the signature of the binding's status link
+func (n *Notifier) VerifySignature(skyAddr, depositAddr, sig string) error {
+	b, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(b, n.sign(skyAddr, depositAddr)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// StatusLink returns the signed status link of a binding
+func (n *Notifier) StatusLink(skyAddr, depositAddr string) string {
+	q := url.Values{}
+	q.Set("skyaddr", skyAddr)
+	q.Set("deposit_addr", depositAddr)
+	q.Set("sig", hex.EncodeToString(n.sign(skyAddr, depositAddr)))
+
+	sep := "?"
+	if strings.Contains(n.cfg.StatusURL, "?") {
+		sep = "&"
+	}
+
+	return n.cfg.StatusURL + sep + q.Encode()
+}
+
+// AddContact saves the contact email of a binding and emails it the deposit address.
+// language is the language of the emails, the default language is used if it is empty.
+func (n *Notifier) AddContact(skyAddr, depositAddr, coinType, email, language string) error {
+	if err := ValidateEmail(email); err != nil {
+		return err
+	}
+
+	language, err := NormalizeLanguage(language)
+	if err != nil {
+		return err
+	}
+
+	if err := n.store.Add(Contact{
+		SkyAddress:     skyAddr,
+		DepositAddress: depositAddr,
+		CoinType:       coinType,
+		Email:          email,
+		Language:       language,
+		CreatedAt:      time.Now().UTC().Unix(),
+	}); err != nil {
+		return err
+	}
+
+	n.queueEvent(email, EventDepositAddress, language, n.emailData(skyAddr, depositAddr, coinType))
+
+	return nil
+}
+
+// emailData returns the data of the emails of a binding
+func (n *Notifier) emailData(skyAddr, depositAddr, coinType string) EmailData {
+	return EmailData{
+		SkyAddress:         skyAddr,
+		DepositAddress:     depositAddr,
+		CoinType:           coinType,
+		StatusLink:         n.StatusLink(skyAddr, depositAddr),
+		SkyAddressLink:     n.cfg.Explorer.PayoutAddress(skyAddr),
+		DepositAddressLink: n.cfg.Explorer.DepositAddress(coinType, depositAddr),
+		ServiceName:        n.cfg.Branding.ServiceName,
+		SupportURL:         n.cfg.Branding.SupportURL,
+		ContactEmail:       n.cfg.Branding.ContactEmail,
+	}
+}
+
+// queueEvent renders the email of event and queues it
+func (n *Notifier) queueEvent(to, event, language string, data EmailData) {
+	subject, body, _, err := n.templates.Render(event, language, data)
+	if err != nil {
+		n.log.WithError(err).WithFields(logrus.Fields{
+			"event":    event,
+			"language": language,
+		}).Error("Render email failed, dropping email")
+		return
+	}
+
+	n.queue(Message{
+		To:      to,
+		Subject: subject,
+		Body:    body,
+	})
+}
+
+func (n *Notifier) payoutCoin() string {
+	if n.cfg.PayoutCoin == "" {
+		return "SKY"
+	}
+	return n.cfg.PayoutCoin
+}
+
+// Preview is an email rendered with example data
+type Preview struct {
+	Event string `json:"event"`
+	// Language the email was rendered in, after falling back from the language asked for
+	Language string `json:"language"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+	// Languages which have a template of any event
+	Languages []string `json:"languages"`
+}
+
+// PreviewEmail renders the email of event in language with example data, for checking the templates
+func (n *Notifier) PreviewEmail(event, language string) (Preview, error) {
+	language, err := NormalizeLanguage(language)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	data := n.emailData(exampleData.SkyAddress, exampleData.DepositAddress, exampleData.CoinType)
+	data.Amount = exampleData.Amount
+	data.PayoutCoin = n.payoutCoin()
+	if n.cfg.Receipts != nil {
+		data.ReceiptLink = n.cfg.Receipts.Link(exampleDepositID)
+	}
+
+	subject, body, rendered, err := n.templates.Render(event, language, data)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	return Preview{
+		Event:     event,
+		Language:  rendered,
+		Subject:   subject,
+		Body:      body,
+		Languages: n.templates.Languages(),
+	}, nil
+}
+
+// EraseContact deletes the contact email of a binding, if sig is the signature of its status link
+func (n *Notifier) EraseContact(skyAddr, depositAddr, sig string) error {
+	if err := n.VerifySignature(skyAddr, depositAddr, sig); err != nil {
+		return err
+	}
+
+	erased, err := n.store.Erase(skyAddr, depositAddr)
+	if err != nil {
+		return err
+	}
+
+	if erased {
+		n.log.WithField("skyAddr", skyAddr).Info("Erased contact email of binding")
+	}
+
+	return nil
+}
+
+// EraseContacts deletes the contact emails of every binding of skyAddr, and returns the number deleted
+func (n *Notifier) EraseContacts(skyAddr string) (int, error) {
+	erased, err := n.store.EraseAll(skyAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	n.log.WithFields(logrus.Fields{
+		"skyAddr": skyAddr,
+		"erased":  erased,
+	}).Info("Erased contact emails of skycoin address")
+
+	return erased, nil
+}
+
+// Watch emails the contacts of a skycoin address when the skycoin sent for one of its deposits is confirmed
+func (n *Notifier) Watch(states *exchange.StateMachine) {
+	states.OnTransition(n.onTransition)
+}
+
+func (n *Notifier) onTransition(t exchange.Transition) {
+	if t.From.Status != exchange.StatusWaitConfirm || t.To.Status != exchange.StatusDone {
+		return
+	}
+
+	skyAddr := t.To.SkyAddress
+	log := n.log.WithField("skyAddr", skyAddr)
+
+	contacts, err := n.store.ContactsOf(skyAddr)
+	if err != nil {
+		log.WithError(err).Error("store.ContactsOf failed, not sending payout email")
+		return
+	}
+
+	if len(contacts) == 0 {
+		return
+	}
+
+	sky, err := droplet.ToString(t.To.SkySent)
+	if err != nil {
+		log.WithError(err).Error("droplet.ToString failed, not sending payout email")
+		return
+	}
+
+	// The same email can be given for several bindings, send it once
+	sent := make(map[string]struct{}, len(contacts))
+	for _, c := range contacts {
+		if _, ok := sent[c.Email]; ok {
+			continue
+		}
+		sent[c.Email] = struct{}{}
+
+		data := n.emailData(skyAddr, c.DepositAddress, t.To.CoinType)
+		data.Amount = sky
+		data.PayoutCoin = n.payoutCoin()
+		if n.cfg.Receipts != nil {
+			data.ReceiptLink = n.cfg.Receipts.Link(t.To.DepositID)
+		}
+		n.queueEvent(c.Email, EventPayoutSent, c.Language, data)
+	}
+}
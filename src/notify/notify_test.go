@@ -0,0 +1,634 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/explorer"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+const (
+	testSkyAddr       = "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"
+	testSkyAddr2      = "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	testDepositAddr   = "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS"
+	testDepositAddr2  = "17mMWfDrDpXqmHULjvYMqKU6rwxYSeMrWS"
+	testEncryptionKey = "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+)
+
+type dummyMailer struct {
+	sync.Mutex
+	messages []Message
+	err      error
+}
+
+func (m *dummyMailer) Send(msg Message) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *dummyMailer) sent() []Message {
+	m.Lock()
+	defer m.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+func testConfig() Config {
+	return Config{
+		StatusURL:     "https://example.com/status",
+		SigningKey:    "signing-key",
+		EncryptionKey: testEncryptionKey,
+	}
+}
+
+func newTestNotifier(t *testing.T, db *bolt.DB) (*Notifier, *dummyMailer) {
+	log, _ := testutil.NewLogger(t)
+	mailer := &dummyMailer{}
+	n, err := NewNotifier(log, db, mailer, testConfig())
+	require.NoError(t, err)
+	return n, mailer
+}
+
+// payoutConfirmed returns the transition of a deposit of skyAddr whose payout of 1500 SKY is confirmed
+func payoutConfirmed(skyAddr, coinType string) exchange.Transition {
+	di := exchange.DepositInfo{
+		Status:     exchange.StatusWaitConfirm,
+		CoinType:   coinType,
+		SkyAddress: skyAddr,
+		DepositID:  "btctx:1",
+		Txid:       "skytx",
+		SkySent:    1500e6,
+	}
+
+	t := exchange.Transition{
+		From: di,
+		To:   di,
+	}
+	t.To.Status = exchange.StatusDone
+	return t
+}
+
+type dummyReceiptLinker struct{}
+
+func (dummyReceiptLinker) Link(depositID string) string {
+	return "https://example.com/api/receipt?deposit_id=" + url.QueryEscape(depositID)
+}
+
+// sendQueued sends the queued emails
+func sendQueued(t *testing.T, n *Notifier) {
+	done := make(chan error)
+	go func() {
+		done <- n.Run()
+	}()
+	n.Shutdown()
+	require.NoError(t, <-done)
+}
+
+func TestValidateEmail(t *testing.T) {
+	require.NoError(t, ValidateEmail("user@example.com"))
+	require.NoError(t, ValidateEmail("user+tag@sub.example.com"))
+
+	for _, email := range []string{
+		"",
+		"user",
+		"@example.com",
+		"User <user@example.com>",
+		"user@example.com, other@example.com",
+		" user@example.com",
+		strings.Repeat("a", 250) + "@example.com",
+	} {
+		require.Equal(t, ErrInvalidEmail, ValidateEmail(email), email)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.NoError(t, testConfig().Validate())
+
+	c := testConfig()
+	c.StatusURL = "/status"
+	require.Error(t, c.Validate())
+
+	c = testConfig()
+	c.SigningKey = ""
+	require.Error(t, c.Validate())
+
+	c = testConfig()
+	c.EncryptionKey = "0011"
+	require.Error(t, c.Validate())
+
+	c = testConfig()
+	c.EncryptionKey = strings.Repeat("z", 64)
+	require.Error(t, c.Validate())
+}
+
+func TestStatusLink(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	n, _ := newTestNotifier(t, db)
+
+	link := n.StatusLink(testSkyAddr, testDepositAddr)
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	require.Equal(t, "example.com", u.Host)
+	require.Equal(t, "/status", u.Path)
+	require.Equal(t, testSkyAddr, u.Query().Get("skyaddr"))
+	require.Equal(t, testDepositAddr, u.Query().Get("deposit_addr"))
+
+	sig := u.Query().Get("sig")
+	require.NoError(t, n.VerifySignature(testSkyAddr, testDepositAddr, sig))
+	require.Equal(t, ErrInvalidSignature, n.VerifySignature(testSkyAddr, testDepositAddr2, sig))
+	require.Equal(t, ErrInvalidSignature, n.VerifySignature(testSkyAddr2, testDepositAddr, sig))
+	require.Equal(t, ErrInvalidSignature, n.VerifySignature(testSkyAddr, testDepositAddr, "zz"))
+	require.Equal(t, ErrInvalidSignature, n.VerifySignature(testSkyAddr, testDepositAddr, ""))
+
+	// A status URL with a query keeps it
+	n.cfg.StatusURL = "https://example.com/?page=status"
+	u, err = url.Parse(n.StatusLink(testSkyAddr, testDepositAddr))
+	require.NoError(t, err)
+	require.Equal(t, "status", u.Query().Get("page"))
+	require.Equal(t, sig, u.Query().Get("sig"))
+}
+
+func TestAddContact(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	n, mailer := newTestNotifier(t, db)
+
+	require.Equal(t, ErrInvalidEmail, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user", ""))
+
+	require.NoError(t, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user@example.com", ""))
+	sendQueued(t, n)
+
+	msgs := mailer.sent()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "user@example.com", msgs[0].To)
+	require.Equal(t, "Your BTC deposit address", msgs[0].Subject)
+	require.Contains(t, msgs[0].Body, testDepositAddr)
+	require.Contains(t, msgs[0].Body, testSkyAddr)
+	require.Contains(t, msgs[0].Body, n.StatusLink(testSkyAddr, testDepositAddr))
+	require.NotContains(t, msgs[0].Body, "explorer")
+
+	// The email is stored encrypted
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ContactBkt).ForEach(func(k, v []byte) error {
+			require.False(t, bytes.Contains(v, []byte("user@example.com")))
+			return nil
+		})
+	}))
+
+	contacts, err := n.store.ContactsOf(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	require.Equal(t, "user@example.com", contacts[0].Email)
+	require.Equal(t, testDepositAddr, contacts[0].DepositAddress)
+	require.Equal(t, "BTC", contacts[0].CoinType)
+}
+
+func TestPayoutEmail(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	n, mailer := newTestNotifier(t, db)
+
+	require.NoError(t, n.store.Add(Contact{
+		SkyAddress:     testSkyAddr,
+		DepositAddress: testDepositAddr,
+		CoinType:       "BTC",
+		Email:          "user@example.com",
+	}))
+	require.NoError(t, n.store.Add(Contact{
+		SkyAddress:     testSkyAddr,
+		DepositAddress: testDepositAddr2,
+		CoinType:       "BTC",
+		Email:          "user@example.com",
+	}))
+	require.NoError(t, n.store.Add(Contact{
+		SkyAddress:     testSkyAddr2,
+		DepositAddress: "0x" + strings.Repeat("1", 40),
+		CoinType:       "ETH",
+		Email:          "other@example.com",
+	}))
+
+	// Other transitions are ignored
+	sent := payoutConfirmed(testSkyAddr, "BTC")
+	sent.From.Status = exchange.StatusWaitSend
+	sent.To.Status = exchange.StatusWaitConfirm
+	n.onTransition(sent)
+	// Skycoin addresses without contacts are ignored
+	n.onTransition(payoutConfirmed("cBnu9sUvv12dovBmjQKTtfE4rbjMmf3fzW", "BTC"))
+	// An email given for several bindings is sent once
+	n.onTransition(payoutConfirmed(testSkyAddr, "BTC"))
+	sendQueued(t, n)
+
+	msgs := mailer.sent()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "user@example.com", msgs[0].To)
+	require.Equal(t, "1500.000000 SKY sent", msgs[0].Subject)
+	require.Contains(t, msgs[0].Body, testSkyAddr)
+	require.Contains(t, msgs[0].Body, "https://example.com/status?")
+	require.NotContains(t, msgs[0].Body, "receipt")
+
+	// A fiber chain's coin is named instead of SKY
+	n, mailer = newTestNotifier(t, db)
+	n.cfg.PayoutCoin = "MDL"
+	n.onTransition(payoutConfirmed(testSkyAddr2, "ETH"))
+	sendQueued(t, n)
+
+	msgs = mailer.sent()
+	require.Len(t, msgs, 1)
+	require.Equal(t, "1500.000000 MDL sent", msgs[0].Subject)
+	require.Contains(t, msgs[0].Body, "1500.000000 MDL have been sent")
+	require.Contains(t, msgs[0].Body, "for your ETH deposit")
+
+	// The receipt of the deposit is linked, if receipts are enabled
+	n, mailer = newTestNotifier(t, db)
+	n.cfg.Receipts = dummyReceiptLinker{}
+	n.onTransition(payoutConfirmed(testSkyAddr, "BTC"))
+	sendQueued(t, n)
+
+	msgs = mailer.sent()
+	require.Len(t, msgs, 1)
+	require.Contains(t, msgs[0].Body, "Download the receipt of this deposit for your records:\n\nhttps://example.com/api/receipt?deposit_id=btctx%3A1\n")
+}
+
+func TestSendFailure(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, hook := testutil.NewLogger(t)
+	mailer := &dummyMailer{
+		err: errors.New("connection refused"),
+	}
+	n, err := NewNotifier(log, db, mailer, testConfig())
+	require.NoError(t, err)
+
+	require.NoError(t, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user@example.com", ""))
+	sendQueued(t, n)
+
+	// The recipient is never logged
+	var failed bool
+	for _, e := range hook.AllEntries() {
+		s, err := e.String()
+		require.NoError(t, err)
+		require.NotContains(t, s, "user@example.com")
+		if e.Message == "Send email failed, dropping email" {
+			failed = true
+		}
+	}
+	require.True(t, failed)
+}
+
+func TestErase(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	n, _ := newTestNotifier(t, db)
+
+	for _, c := range []Contact{
+		{SkyAddress: testSkyAddr, DepositAddress: testDepositAddr, CoinType: "BTC", Email: "a@example.com", CreatedAt: time.Now().Unix()},
+		{SkyAddress: testSkyAddr, DepositAddress: testDepositAddr2, CoinType: "BTC", Email: "b@example.com"},
+		{SkyAddress: testSkyAddr2, DepositAddress: testDepositAddr, CoinType: "BTC", Email: "c@example.com"},
+	} {
+		require.NoError(t, n.store.Add(c))
+	}
+
+	// Erasing a binding's contact requires the signature of its status link
+	sig := n.sign(testSkyAddr, testDepositAddr)
+	require.Equal(t, ErrInvalidSignature, n.EraseContact(testSkyAddr, testDepositAddr2, string(sig)))
+
+	u, err := url.Parse(n.StatusLink(testSkyAddr, testDepositAddr))
+	require.NoError(t, err)
+	require.NoError(t, n.EraseContact(testSkyAddr, testDepositAddr, u.Query().Get("sig")))
+
+	contacts, err := n.store.ContactsOf(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	require.Equal(t, testDepositAddr2, contacts[0].DepositAddress)
+
+	// Erasing again is not an error
+	require.NoError(t, n.EraseContact(testSkyAddr, testDepositAddr, u.Query().Get("sig")))
+
+	// Erasing a skycoin address erases all of its contacts, and only its contacts
+	erased, err := n.EraseContacts(testSkyAddr)
+	require.NoError(t, err)
+	require.Equal(t, 1, erased)
+
+	contacts, err = n.store.ContactsOf(testSkyAddr)
+	require.NoError(t, err)
+	require.Empty(t, contacts)
+
+	contacts, err = n.store.ContactsOf(testSkyAddr2)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+
+	erased, err = n.EraseContacts(testSkyAddr)
+	require.NoError(t, err)
+	require.Equal(t, 0, erased)
+}
+
+func TestStoreWrongKey(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	n, _ := newTestNotifier(t, db)
+	require.NoError(t, n.store.Add(Contact{
+		SkyAddress:     testSkyAddr,
+		DepositAddress: testDepositAddr,
+		Email:          "user@example.com",
+	}))
+
+	other, err := NewStore(db, bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	_, err = other.ContactsOf(testSkyAddr)
+	require.Error(t, err)
+}
+
+func TestFormatMessage(t *testing.T) {
+	date := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+	b := formatMessage("teller@example.com", Message{
+		To:      "user@example.com",
+		Subject: "Your BTC deposit address",
+		Body:    "body\n",
+	}, date)
+
+	require.Equal(t, "From: teller@example.com\r\n"+
+		"To: user@example.com\r\n"+
+		"Subject: Your BTC deposit address\r\n"+
+		"Date: Tue, 02 Jan 2018 03:04:05 +0000\r\n"+
+		"MIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\n"+
+		"\r\n"+
+		"body\n", string(b))
+}
+
+func TestNewSMTPMailer(t *testing.T) {
+	_, err := NewSMTPMailer(SMTPConfig{
+		Addr: "smtp.example.com:587",
+		From: "teller@example.com",
+	})
+	require.NoError(t, err)
+
+	_, err = NewSMTPMailer(SMTPConfig{
+		Addr: "smtp.example.com",
+		From: "teller@example.com",
+	})
+	require.Error(t, err)
+
+	_, err = NewSMTPMailer(SMTPConfig{
+		Addr: "smtp.example.com:587",
+		From: "Teller",
+	})
+	require.Error(t, err)
+}
+
+// writeTemplates writes the templates of each "<language>/<event>" to a temporary templates dir
+func writeTemplates(t *testing.T, templates map[string]string) string {
+	dir, err := ioutil.TempDir("", "notify")
+	require.NoError(t, err)
+
+	for name, text := range templates {
+		path := filepath.Join(dir, name+templateExt)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		require.NoError(t, ioutil.WriteFile(path, []byte(text), 0644))
+	}
+
+	return dir
+}
+
+const testPayoutSentES = `{{define "subject"}}{{.Amount}} {{.PayoutCoin}}
+enviados{{end}}{{define "body"}}Se han enviado {{.Amount}} {{.PayoutCoin}} a {{.SkyAddress}}.
+{{.StatusLink}}
+{{end}}`
+
+func TestNormalizeLanguage(t *testing.T) {
+	for in, out := range map[string]string{
+		"":      "",
+		"en":    "en",
+		"pt-BR": "pt-br",
+		"zh_CN": "zh-cn",
+	} {
+		language, err := NormalizeLanguage(in)
+		require.NoError(t, err, in)
+		require.Equal(t, out, language)
+	}
+
+	for _, language := range []string{"e", "english", "../en", "en-", "en/es", strings.Repeat("en-", 20) + "en"} {
+		_, err := NormalizeLanguage(language)
+		require.Equal(t, ErrInvalidLanguage, err, language)
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"es/payout_sent":     testPayoutSentES,
+		"pt/deposit_address": `{{define "subject"}}Seu endereço de depósito {{.CoinType}}{{end}}{{define "body"}}{{.DepositAddress}}{{end}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	templates, err := LoadTemplates(dir, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"en", "es", "pt"}, templates.Languages())
+
+	for _, tc := range []struct {
+		event    string
+		language string
+		rendered string
+		subject  string
+	}{
+		{EventPayoutSent, "es", "es", "1500.000000 SKY enviados"},
+		// A regional language falls back to its base language
+		{EventPayoutSent, "es-mx", "es", "1500.000000 SKY enviados"},
+		// The built in template is used for an event a language doesn't have
+		{EventDepositAddress, "es", "en", "Your BTC deposit address"},
+		{EventDepositAddress, "pt-br", "pt", "Seu endereço de depósito BTC"},
+		{EventPayoutSent, "fr", "en", "1500.000000 SKY sent"},
+		{EventPayoutSent, "", "en", "1500.000000 SKY sent"},
+	} {
+		subject, body, rendered, err := templates.Render(tc.event, tc.language, exampleData)
+		require.NoError(t, err)
+		require.Equal(t, tc.rendered, rendered, tc.language)
+		require.Equal(t, tc.subject, subject)
+		require.NotEmpty(t, body)
+	}
+
+	_, _, _, err = templates.Render("refund_sent", "en", exampleData)
+	require.Equal(t, ErrUnknownEvent, err)
+
+	// The default language is used for the contacts which didn't give one
+	templates, err = LoadTemplates(dir, "es")
+	require.NoError(t, err)
+	_, _, rendered, err := templates.Render(EventPayoutSent, "", exampleData)
+	require.NoError(t, err)
+	require.Equal(t, "es", rendered)
+	_, _, rendered, err = templates.Render(EventPayoutSent, "fr", exampleData)
+	require.NoError(t, err)
+	require.Equal(t, "es", rendered)
+	_, _, rendered, err = templates.Render(EventDepositAddress, "", exampleData)
+	require.NoError(t, err)
+	require.Equal(t, "en", rendered)
+
+	_, err = LoadTemplates(filepath.Join(dir, "missing"), "")
+	require.Error(t, err)
+
+	// Invalid templates fail loading, rather than sending
+	for name, templates := range map[string]map[string]string{
+		"unknown event":    {"es/refund_sent": testPayoutSentES},
+		"uppercase dir":    {"ES/payout_sent": testPayoutSentES},
+		"missing body":     {"es/payout_sent": `{{define "subject"}}Enviados{{end}}`},
+		"unknown field":    {"es/payout_sent": `{{define "subject"}}{{.Amount}}{{end}}{{define "body"}}{{.Email}}{{end}}`},
+		"invalid template": {"es/payout_sent": `{{define "subject"}}{{.Amount}{{end}}{{define "body"}}{{end}}`},
+	} {
+		dir := writeTemplates(t, templates)
+		_, err := LoadTemplates(dir, "")
+		os.RemoveAll(dir)
+		require.Error(t, err, name)
+	}
+}
+
+func TestPayoutEmailLanguage(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	dir := writeTemplates(t, map[string]string{
+		"es/payout_sent": testPayoutSentES,
+	})
+	defer os.RemoveAll(dir)
+
+	log, _ := testutil.NewLogger(t)
+	mailer := &dummyMailer{}
+	cfg := testConfig()
+	cfg.TemplatesDir = dir
+	n, err := NewNotifier(log, db, mailer, cfg)
+	require.NoError(t, err)
+
+	require.Equal(t, ErrInvalidLanguage, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user@example.com", "../es"))
+
+	// The deposit address email falls back to English, and the language is kept for the payout email
+	require.NoError(t, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user@example.com", "es-MX"))
+	contacts, err := n.store.ContactsOf(testSkyAddr)
+	require.NoError(t, err)
+	require.Len(t, contacts, 1)
+	require.Equal(t, "es-mx", contacts[0].Language)
+
+	n.onTransition(payoutConfirmed(testSkyAddr, "BTC"))
+	sendQueued(t, n)
+
+	msgs := mailer.sent()
+	require.Len(t, msgs, 2)
+	require.Equal(t, "Your BTC deposit address", msgs[0].Subject)
+	require.Equal(t, "1500.000000 SKY enviados", msgs[1].Subject)
+	require.Contains(t, msgs[1].Body, "Se han enviado 1500.000000 SKY a "+testSkyAddr)
+	require.Contains(t, msgs[1].Body, n.StatusLink(testSkyAddr, testDepositAddr))
+}
+
+func TestPreviewEmail(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	n, _ := newTestNotifier(t, db)
+	n.cfg.PayoutCoin = "MDL"
+
+	preview, err := n.PreviewEmail(EventPayoutSent, "pt-BR")
+	require.NoError(t, err)
+	require.Equal(t, EventPayoutSent, preview.Event)
+	require.Equal(t, "en", preview.Language)
+	require.Equal(t, "1500.000000 MDL sent", preview.Subject)
+	require.Contains(t, preview.Body, "https://example.com/status?")
+	require.NotContains(t, preview.Body, "receipt")
+	require.Equal(t, []string{"en"}, preview.Languages)
+
+	n.cfg.Receipts = dummyReceiptLinker{}
+	preview, err = n.PreviewEmail(EventPayoutSent, "")
+	require.NoError(t, err)
+	require.Contains(t, preview.Body, "https://example.com/api/receipt?deposit_id="+url.QueryEscape(exampleDepositID))
+
+	_, err = n.PreviewEmail("refund_sent", "")
+	require.Equal(t, ErrUnknownEvent, err)
+	_, err = n.PreviewEmail(EventPayoutSent, "../en")
+	require.Equal(t, ErrInvalidLanguage, err)
+}
+
+func TestEmailExplorerLinks(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	mailer := &dummyMailer{}
+	cfg := testConfig()
+	cfg.Explorer = explorer.Links{
+		Deposits: map[string]explorer.Coin{
+			"BTC": {AddressURL: "https://blockstream.info/address/{address}"},
+		},
+		Payout: explorer.Coin{AddressURL: "https://explorer.skycoin.com/app/address/{address}"},
+	}
+	n, err := NewNotifier(log, db, mailer, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user@example.com", ""))
+	n.onTransition(payoutConfirmed(testSkyAddr, "BTC"))
+	sendQueued(t, n)
+
+	msgs := mailer.sent()
+	require.Len(t, msgs, 2)
+	require.Contains(t, msgs[0].Body, "https://blockstream.info/address/"+testDepositAddr)
+	require.Contains(t, msgs[1].Body, "https://explorer.skycoin.com/app/address/"+testSkyAddr)
+}
+
+func TestEmailBranding(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	mailer := &dummyMailer{}
+	cfg := testConfig()
+	cfg.Branding = Branding{
+		ServiceName:  "Example Exchange",
+		SupportURL:   "https://example.com/support",
+		ContactEmail: "support@example.com",
+	}
+	n, err := NewNotifier(log, db, mailer, cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user@example.com", ""))
+	n.onTransition(payoutConfirmed(testSkyAddr, "BTC"))
+	sendQueued(t, n)
+
+	msgs := mailer.sent()
+	require.Len(t, msgs, 2)
+	for _, msg := range msgs {
+		require.Contains(t, msg.Body, "Questions? Visit https://example.com/support or write to support@example.com.\n\nExample Exchange\n")
+	}
+
+	// Without branding, the emails have no signature
+	db2, shutdown2 := testutil.PrepareDB(t)
+	defer shutdown2()
+	n, mailer = newTestNotifier(t, db2)
+	require.NoError(t, n.AddContact(testSkyAddr, testDepositAddr, "BTC", "user@example.com", ""))
+	sendQueued(t, n)
+
+	msgs = mailer.sent()
+	require.Len(t, msgs, 1)
+	require.NotContains(t, msgs[0].Body, "Questions?")
+	require.True(t, strings.HasSuffix(msgs[0].Body, "You can delete it on the status page.\n"), msgs[0].Body)
+}
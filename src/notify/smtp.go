@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPConfig configures an SMTPMailer
+type SMTPConfig struct {
+	// host:port of the SMTP server
+	Addr string
+	// Username and password for PLAIN auth. Auth is skipped if the username is empty.
+	Username string
+	Password string
+	// Sender of the emails
+	From string
+}
+
+// Validate returns an error if the configuration is invalid
+func (c SMTPConfig) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("Invalid Addr: %v", err)
+	}
+
+	if err := ValidateEmail(c.From); err != nil {
+		return errors.New("From must be an email address")
+	}
+
+	return nil
+}
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	cfg  SMTPConfig
+	auth smtp.Auth
+}
+
+// NewSMTPMailer creates an SMTPMailer
+func NewSMTPMailer(cfg SMTPConfig) (*SMTPMailer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr) // nolint: errcheck
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	return &SMTPMailer{
+		cfg:  cfg,
+		auth: auth,
+	}, nil
+}
+
+// Send sends an email
+func (m *SMTPMailer) Send(msg Message) error {
+	return smtp.SendMail(m.cfg.Addr, m.auth, m.cfg.From, []string{msg.To}, formatMessage(m.cfg.From, msg, time.Now()))
+}
+
+// formatMessage formats msg as a plain text RFC 5322 message
+func formatMessage(from string, msg Message, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(msg.Body)
+	return b.Bytes()
+}
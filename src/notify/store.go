@@ -0,0 +1,220 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+// ContactBkt maps a binding, keyed by skycoin address and deposit address, to its encrypted contact email
+var ContactBkt = []byte("contacts")
+
+// keySep separates the skycoin address and deposit address of a ContactBkt key.
+// It does not occur in either address.
+const keySep = "/"
+
+// Contact is the contact email of a binding
+type Contact struct {
+	SkyAddress     string
+	DepositAddress string
+	CoinType       string
+	Email          string
+	// Language of the emails, empty for the default language
+	Language  string
+	CreatedAt int64
+}
+
+// contactRecord is a Contact as saved in the db, with its email encrypted
+type contactRecord struct {
+	CoinType       string `json:"coin_type"`
+	EncryptedEmail []byte `json:"encrypted_email"`
+	Language       string `json:"language,omitempty"`
+	CreatedAt      int64  `json:"created_at"`
+}
+
+// Store saves the contact emails of bindings, encrypted with AES-GCM
+type Store struct {
+	db   *bolt.DB
+	aead cipher.AEAD
+}
+
+// NewStore creates a Store. key is the 32 byte AES-256 key of the emails.
+func NewStore(db *bolt.DB, key []byte) (*Store, error) {
+	if db == nil {
+		return nil, errors.New("new notify Store failed, db is nil")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(ContactBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(ContactBkt, err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db:   db,
+		aead: aead,
+	}, nil
+}
+
+func contactKey(skyAddr, depositAddr string) []byte {
+	return []byte(skyAddr + keySep + depositAddr)
+}
+
+// encrypt encrypts the email of the binding key. The nonce is prepended to the ciphertext.
+// The key is authenticated, so that a ciphertext can't be moved to another binding.
+func (s *Store) encrypt(key []byte, email string) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return s.aead.Seal(nonce, nonce, []byte(email), key), nil
+}
+
+func (s *Store) decrypt(key, b []byte) (string, error) {
+	n := s.aead.NonceSize()
+	if len(b) < n {
+		return "", errors.New("Encrypted email is too short")
+	}
+
+	email, err := s.aead.Open(nil, b[:n], b[n:], key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(email), nil
+}
+
+// Add saves a contact, replacing the binding's previous contact
+func (s *Store) Add(c Contact) error {
+	key := contactKey(c.SkyAddress, c.DepositAddress)
+
+	encrypted, err := s.encrypt(key, c.Email)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return dbutil.PutBucketValue(tx, ContactBkt, string(key), contactRecord{
+			CoinType:       c.CoinType,
+			EncryptedEmail: encrypted,
+			Language:       c.Language,
+			CreatedAt:      c.CreatedAt,
+		})
+	})
+}
+
+// ContactsOf returns the contacts of the bindings of skyAddr
+func (s *Store) ContactsOf(skyAddr string) ([]Contact, error) {
+	var contacts []Contact
+	prefix := []byte(skyAddr + keySep)
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(ContactBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(ContactBkt)
+		}
+
+		c := bkt.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r contactRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+
+			email, err := s.decrypt(k, r.EncryptedEmail)
+			if err != nil {
+				return err
+			}
+
+			contacts = append(contacts, Contact{
+				SkyAddress:     skyAddr,
+				DepositAddress: string(k[len(prefix):]),
+				CoinType:       r.CoinType,
+				Email:          email,
+				Language:       r.Language,
+				CreatedAt:      r.CreatedAt,
+			})
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// Erase deletes the contact of a binding. It returns false if the binding has no contact.
+func (s *Store) Erase(skyAddr, depositAddr string) (bool, error) {
+	var erased bool
+	key := contactKey(skyAddr, depositAddr)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(ContactBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(ContactBkt)
+		}
+
+		if bkt.Get(key) == nil {
+			return nil
+		}
+
+		erased = true
+		return bkt.Delete(key)
+	})
+
+	return erased, err
+}
+
+// EraseAll deletes the contacts of every binding of skyAddr, and returns the number deleted
+func (s *Store) EraseAll(skyAddr string) (int, error) {
+	var n int
+	prefix := []byte(skyAddr + keySep)
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(ContactBkt)
+		if bkt == nil {
+			return dbutil.NewBucketNotExistErr(ContactBkt)
+		}
+
+		// Collect the keys first, a bucket can't be modified while iterating it
+		var keys [][]byte
+		c := bkt.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		n = len(keys)
+		return nil
+	})
+
+	return n, err
+}
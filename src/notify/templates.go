@@ -0,0 +1,329 @@
+package notify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	// EventDepositAddress is the email which gives a new binding its deposit address
+	EventDepositAddress = "deposit_address"
+	// EventPayoutSent is the email which reports a payout
+	EventPayoutSent = "payout_sent"
+
+	// DefaultLanguage is the language of the built in templates
+	DefaultLanguage = "en"
+
+	// templateExt is the extension of the template files in a templates dir
+	templateExt = ".tmpl"
+	// maxLanguageLength is the longest language tag accepted
+	maxLanguageLength = 35
+)
+
+// Events are the emails which have a template
+var Events = []string{EventDepositAddress, EventPayoutSent}
+
+var (
+	// ErrInvalidLanguage is returned for a language which is not a language tag, e.g. "en" or "pt-br"
+	ErrInvalidLanguage = errors.New("Invalid language")
+	// ErrUnknownEvent is returned for an event which has no template
+	ErrUnknownEvent = errors.New("Unknown email event")
+
+	languageRe = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+)
+
+// builtinSignature ends the bodies of the built in templates with the operator's branding, if any
+const builtinSignature = `
+{{- if or .SupportURL .ContactEmail}}
+
+Questions? {{if .SupportURL}}Visit {{.SupportURL}}{{if .ContactEmail}} or write to {{.ContactEmail}}{{end}}{{else}}Write to {{.ContactEmail}}{{end}}.
+{{- end}}
+{{- if .ServiceName}}
+
+{{.ServiceName}}
+{{- end}}`
+
+// builtinTemplates are the English templates, used for any event and language the templates dir doesn't have
+var builtinTemplates = map[string]string{
+	EventDepositAddress: `{{define "subject"}}Your {{.CoinType}} deposit address{{end}}
+{{- define "body"}}Send {{.CoinType}} to this deposit address to receive skycoin at {{.SkyAddress}}:
+
+{{.DepositAddress}}
+{{- if .DepositAddressLink}}
+
+See the deposit address on the explorer:
+
+{{.DepositAddressLink}}
+{{- end}}
+
+Follow the status of your deposits at:
+
+{{.StatusLink}}
+
+This email address is only used to notify you about this deposit address.
+You can delete it on the status page.` + builtinSignature + `
+{{end}}`,
+
+	EventPayoutSent: `{{define "subject"}}{{.Amount}} {{.PayoutCoin}} sent{{end}}
+{{- define "body"}}{{.Amount}} {{.PayoutCoin}} have been sent to {{.SkyAddress}} for your {{.CoinType}} deposit.
+{{- if .SkyAddressLink}}
+
+See the address on the explorer:
+
+{{.SkyAddressLink}}
+{{- end}}
+
+Follow the status of your deposits at:
+
+{{.StatusLink}}
+{{- if .ReceiptLink}}
+
+Download the receipt of this deposit for your records:
+
+{{.ReceiptLink}}
+{{- end}}` + builtinSignature + `
+{{end}}`,
+}
+
+// EmailData is the data the templates are executed with. The payout fields are empty for the other events.
+type EmailData struct {
+	SkyAddress     string
+	DepositAddress string
+	CoinType       string
+	StatusLink     string
+	// Explorer links of the addresses, empty if their coin type has no explorer
+	SkyAddressLink     string
+	DepositAddressLink string
+	// Amount paid out, in whole coins
+	Amount string
+	// Coin paid out, e.g. SKY
+	PayoutCoin string
+	// Signed link of the deposit's receipt, empty if receipts are disabled
+	ReceiptLink string
+	// Operator branding, empty if not configured
+	ServiceName  string
+	SupportURL   string
+	ContactEmail string
+}
+
+// exampleDepositID is the deposit of the receipt link of the previews
+const exampleDepositID = "a1075db55d416d3ca199f55b6084e2115b9345e16c5cf302fc80e9d5fbf5d48d:0"
+
+// exampleData is the data of the previews, and of the check of the templates when they are loaded
+var exampleData = EmailData{
+	SkyAddress:         "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+	DepositAddress:     "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS",
+	CoinType:           "BTC",
+	StatusLink:         "https://example.com/status",
+	SkyAddressLink:     "https://explorer.skycoin.com/app/address/2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+	DepositAddressLink: "https://blockstream.info/address/1FeDtFhARLxjKUPPkQqEBL78tisenc9znS",
+	Amount:             "1500.000000",
+	PayoutCoin:         "SKY",
+	ReceiptLink:        "https://example.com/api/receipt",
+	ServiceName:        "Example Exchange",
+	SupportURL:         "https://example.com/support",
+	ContactEmail:       "support@example.com",
+}
+
+// NormalizeLanguage returns the lowercase language tag, or ErrInvalidLanguage. An empty language is left empty.
+func NormalizeLanguage(language string) (string, error) {
+	language = strings.ToLower(strings.Replace(language, "_", "-", -1))
+	if language == "" {
+		return "", nil
+	}
+
+	if len(language) > maxLanguageLength || !languageRe.MatchString(language) {
+		return "", ErrInvalidLanguage
+	}
+
+	return language, nil
+}
+
+// Templates renders the emails of each event in the language of the contact
+type Templates struct {
+	defaultLanguage string
+	// templates of each language and event
+	templates map[string]map[string]*template.Template
+}
+
+// LoadTemplates loads the built in templates, overridden by the templates of dir.
+// dir has a directory per language, e.g. "pt-br", with a <event>.tmpl file per event,
+// which defines a "subject" and a "body" template. An empty dir only loads the built in templates.
+func LoadTemplates(dir, defaultLanguage string) (*Templates, error) {
+	if defaultLanguage == "" {
+		defaultLanguage = DefaultLanguage
+	}
+
+	defaultLanguage, err := NormalizeLanguage(defaultLanguage)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Templates{
+		defaultLanguage: defaultLanguage,
+		templates: map[string]map[string]*template.Template{
+			DefaultLanguage: {},
+		},
+	}
+
+	for event, text := range builtinTemplates {
+		tmpl, err := parseTemplate(event, text)
+		if err != nil {
+			return nil, err
+		}
+		t.templates[DefaultLanguage][event] = tmpl
+	}
+
+	if dir == "" {
+		return t, nil
+	}
+
+	langDirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fi := range langDirs {
+		if !fi.IsDir() {
+			continue
+		}
+
+		language, err := NormalizeLanguage(fi.Name())
+		if err != nil || language != fi.Name() {
+			return nil, fmt.Errorf("Templates dir %s is not a lowercase language tag", filepath.Join(dir, fi.Name()))
+		}
+
+		if err := t.loadLanguage(filepath.Join(dir, language), language); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+func (t *Templates) loadLanguage(dir, language string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range files {
+		if fi.IsDir() || filepath.Ext(fi.Name()) != templateExt {
+			continue
+		}
+
+		path := filepath.Join(dir, fi.Name())
+		event := strings.TrimSuffix(fi.Name(), templateExt)
+		if _, ok := builtinTemplates[event]; !ok {
+			return fmt.Errorf("Template %s is not of an event, the events are %s", path, strings.Join(Events, ", "))
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		tmpl, err := parseTemplate(event, string(b))
+		if err != nil {
+			return fmt.Errorf("Template %s: %v", path, err)
+		}
+
+		if t.templates[language] == nil {
+			t.templates[language] = map[string]*template.Template{}
+		}
+		t.templates[language][event] = tmpl
+	}
+
+	return nil
+}
+
+// parseTemplate parses the template of an event, and checks that it renders the example data
+func parseTemplate(event, text string) (*template.Template, error) {
+	tmpl, err := template.New(event).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range []string{"subject", "body"} {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("%q template is not defined", name)
+		}
+	}
+
+	if _, _, err := execute(tmpl, exampleData); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+func execute(tmpl *template.Template, data EmailData) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", err
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return "", "", err
+	}
+
+	// The subject is a header, so it is kept on one line
+	return strings.Join(strings.Fields(subject.String()), " "), body.String(), nil
+}
+
+// Languages returns the languages which have a template of any event
+func (t *Templates) Languages() []string {
+	languages := make([]string, 0, len(t.templates))
+	for language := range t.templates {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// lookup returns the template of event in language and the language it is in. It falls back to the
+// base language of a regional language, e.g. "pt" for "pt-br", then to the default language, then to English.
+func (t *Templates) lookup(event, language string) (*template.Template, string, error) {
+	if _, ok := builtinTemplates[event]; !ok {
+		return nil, "", ErrUnknownEvent
+	}
+
+	var languages []string
+	if language != "" {
+		languages = append(languages, language)
+		if i := strings.Index(language, "-"); i > 0 {
+			languages = append(languages, language[:i])
+		}
+	}
+	languages = append(languages, t.defaultLanguage, DefaultLanguage)
+
+	for _, l := range languages {
+		if tmpl, ok := t.templates[l][event]; ok {
+			return tmpl, l, nil
+		}
+	}
+
+	// Not reached, the built in templates have every event
+	return nil, "", ErrUnknownEvent
+}
+
+// Render returns the subject and body of the email of event in language, and the language it was rendered in
+func (t *Templates) Render(event, language string, data EmailData) (subject, body, rendered string, err error) {
+	tmpl, rendered, err := t.lookup(event, language)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	subject, body, err = execute(tmpl, data)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	return subject, body, rendered, nil
+}
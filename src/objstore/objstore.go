@@ -0,0 +1,384 @@
+// Package objstore stores objects in an S3 compatible or GCS bucket, so that teller can run without a persistent disk
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	// BackendS3 stores objects with the S3 API, on AWS or an S3 compatible service such as MinIO
+	BackendS3 = "s3"
+	// BackendGCS stores objects with the XML API of Google Cloud Storage, authenticated with HMAC keys
+	BackendGCS = "gcs"
+
+	// EncryptionDefault leaves the encryption of the objects to the bucket's default
+	EncryptionDefault = ""
+	// EncryptionAES256 encrypts the objects with keys managed by S3 (SSE-S3)
+	EncryptionAES256 = "aes256"
+	// EncryptionKMS encrypts the objects with a key of the cloud's key management service (SSE-KMS or CMEK)
+	EncryptionKMS = "kms"
+	// EncryptionCustomer encrypts the objects with a key sent with each request (SSE-C or CSEK)
+	EncryptionCustomer = "customer"
+
+	gcsEndpoint = "https://storage.googleapis.com"
+	// gcsRegion is the signing region of GCS, which serves every location from one endpoint
+	gcsRegion = "auto"
+)
+
+// ErrNotExist is returned by Get when the object doesn't exist
+var ErrNotExist = errors.New("Object does not exist")
+
+// Config configures a Bucket
+type Config struct {
+	// BackendS3 or BackendGCS
+	Backend string
+	// Base URL of the API. Defaults to the AWS endpoint of Region for BackendS3, and to GCS for BackendGCS.
+	Endpoint string
+	// Region of the bucket, for BackendS3
+	Region string
+	Bucket string
+	// Prefix is prepended to the keys, e.g. "teller/"
+	Prefix string
+	// Access keys, or the HMAC keys of a GCS service account
+	AccessKeyID     string
+	SecretAccessKey string
+	// Address the bucket in the URL path instead of the hostname, as S3 compatible services often require.
+	// GCS is always addressed by path.
+	PathStyle bool
+	// Server side encryption, one of the Encryption constants
+	Encryption string
+	// KMS key ID or ARN for BackendS3, or key resource name for BackendGCS, when Encryption is EncryptionKMS
+	KMSKeyID string
+	// Base64 encoded 256 bit key, when Encryption is EncryptionCustomer
+	CustomerKey string
+}
+
+// Validate returns an error if the configuration is invalid
+func (c Config) Validate() error {
+	switch c.Backend {
+	case BackendS3:
+		if c.Region == "" {
+			return errors.New("Object storage region missing")
+		}
+	case BackendGCS:
+		if c.Encryption == EncryptionAES256 {
+			return errors.New("GCS always encrypts objects with Google managed keys, aes256 encryption is S3 only")
+		}
+	default:
+		return fmt.Errorf("Invalid object storage backend \"%s\"", c.Backend)
+	}
+
+	if c.Bucket == "" {
+		return errors.New("Object storage bucket missing")
+	}
+
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return errors.New("Object storage access key missing")
+	}
+
+	if c.Endpoint != "" {
+		u, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return fmt.Errorf("Object storage endpoint is invalid: %v", err)
+		}
+		if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("Object storage endpoint \"%s\" is not an http(s) URL", c.Endpoint)
+		}
+	}
+
+	switch c.Encryption {
+	case EncryptionDefault, EncryptionAES256:
+	case EncryptionKMS:
+		if c.KMSKeyID == "" {
+			return errors.New("Object storage KMS key missing")
+		}
+	case EncryptionCustomer:
+		if _, err := parseCustomerKey(c.CustomerKey); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Invalid object storage encryption \"%s\"", c.Encryption)
+	}
+
+	return nil
+}
+
+func parseCustomerKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("Object storage customer key is not base64: %v", err)
+	}
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("Object storage customer key must be 32 bytes, not %d", len(key))
+	}
+
+	return key, nil
+}
+
+// Error is an error response of the object storage API
+type Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("Object storage returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("Object storage returned status %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Bucket stores objects in a bucket
+type Bucket struct {
+	cfg    Config
+	signer signer
+	// base is the URL of the bucket, without a trailing slash
+	base string
+	// The encryption headers of uploads and downloads
+	putHeaders http.Header
+	getHeaders http.Header
+	client     *http.Client
+	now        func() time.Time
+}
+
+// New creates a Bucket
+func New(cfg Config) (*Bucket, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	region := cfg.Region
+	if cfg.Backend == BackendGCS {
+		region = gcsRegion
+	}
+
+	endpoint := strings.TrimRight(cfg.Endpoint, "/")
+	if endpoint == "" {
+		if cfg.Backend == BackendGCS {
+			endpoint = gcsEndpoint
+		} else {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", cfg.Region)
+		}
+	}
+
+	base := endpoint + "/" + escape(cfg.Bucket)
+	if cfg.Backend == BackendS3 && !cfg.PathStyle {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		u.Host = cfg.Bucket + "." + u.Host
+		base = strings.TrimRight(u.String(), "/")
+	}
+
+	putHeaders, getHeaders, err := encryptionHeaders(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Bucket{
+		cfg: cfg,
+		signer: signer{
+			accessKeyID:     cfg.AccessKeyID,
+			secretAccessKey: cfg.SecretAccessKey,
+			region:          region,
+		},
+		base:       base,
+		putHeaders: putHeaders,
+		getHeaders: getHeaders,
+		// Backups are streamed for longer than a client timeout, the callers' contexts bound the requests
+		client: httpclient.New(0),
+		now:    time.Now,
+	}, nil
+}
+
+// encryptionHeaders returns the headers which encrypt an object on upload, and the headers which a download needs
+func encryptionHeaders(cfg Config) (http.Header, http.Header, error) {
+	put := make(http.Header)
+	get := make(http.Header)
+
+	prefix := "X-Amz-Server-Side-Encryption"
+	if cfg.Backend == BackendGCS {
+		prefix = "X-Goog-Encryption"
+	}
+
+	switch cfg.Encryption {
+	case EncryptionAES256:
+		put.Set(prefix, "AES256")
+
+	case EncryptionKMS:
+		if cfg.Backend == BackendGCS {
+			put.Set("X-Goog-Encryption-Kms-Key-Name", cfg.KMSKeyID)
+		} else {
+			put.Set(prefix, "aws:kms")
+			put.Set(prefix+"-Aws-Kms-Key-Id", cfg.KMSKeyID)
+		}
+
+	case EncryptionCustomer:
+		key, err := parseCustomerKey(cfg.CustomerKey)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if cfg.Backend == BackendGCS {
+			sum := sha256.Sum256(key)
+			get.Set(prefix+"-Algorithm", "AES256")
+			get.Set(prefix+"-Key", cfg.CustomerKey)
+			get.Set(prefix+"-Key-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+		} else {
+			sum := md5.Sum(key)
+			get.Set(prefix+"-Customer-Algorithm", "AES256")
+			get.Set(prefix+"-Customer-Key", cfg.CustomerKey)
+			get.Set(prefix+"-Customer-Key-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+		}
+
+		for k, v := range get {
+			put[k] = v
+		}
+	}
+
+	return put, get, nil
+}
+
+// Get returns the contents of the object key, or ErrNotExist
+func (b *Bucket) Get(ctx context.Context, key string) ([]byte, error) {
+	rsp, err := b.do(ctx, http.MethodGet, b.objectURL(key), b.getHeaders, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	return ioutil.ReadAll(rsp.Body)
+}
+
+// Put saves size bytes read from r as the object key, replacing it if it exists.
+// The body is streamed, it is not hashed before the upload.
+func (b *Bucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	rsp, err := b.do(ctx, http.MethodPut, b.objectURL(key), b.putHeaders, r, size, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	return rsp.Body.Close()
+}
+
+// Delete removes the object key. Removing an object which doesn't exist is not an error.
+func (b *Bucket) Delete(ctx context.Context, key string) error {
+	rsp, err := b.do(ctx, http.MethodDelete, b.objectURL(key), nil, nil, 0, emptyPayloadHash)
+	switch err {
+	case nil:
+		return rsp.Body.Close()
+	case ErrNotExist:
+		return nil
+	default:
+		return err
+	}
+}
+
+// listResult is the response of a ListObjectsV2 request
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the keys which start with prefix, sorted
+func (b *Bucket) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	var token string
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", b.cfg.Prefix+prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+
+		rsp, err := b.do(ctx, http.MethodGet, b.base+"/?"+canonicalQuery(q), nil, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var res listResult
+		err = xml.NewDecoder(rsp.Body).Decode(&res)
+		rsp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range res.Contents {
+			keys = append(keys, strings.TrimPrefix(c.Key, b.cfg.Prefix))
+		}
+
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+func (b *Bucket) objectURL(key string) string {
+	return b.base + "/" + escapePath(b.cfg.Prefix+key)
+}
+
+// do sends a signed request, and returns an *Error if the response isn't a 2xx, or ErrNotExist if the object doesn't exist
+func (b *Bucket) do(ctx context.Context, method, u string, headers http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	b.signer.sign(req, payloadHash, b.now())
+
+	rsp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return rsp, nil
+	}
+
+	defer rsp.Body.Close()
+
+	apiErr := &Error{
+		StatusCode: rsp.StatusCode,
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(rsp.Body, 1<<16))
+	if err == nil && len(data) != 0 {
+		xml.NewDecoder(bytes.NewReader(data)).Decode(apiErr) // nolint: errcheck
+	}
+
+	// A missing bucket is a configuration error, not a missing object
+	if rsp.StatusCode == http.StatusNotFound && apiErr.Code != "NoSuchBucket" {
+		return nil, ErrNotExist
+	}
+
+	return nil, apiErr
+}
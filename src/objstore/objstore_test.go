@@ -0,0 +1,201 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 serves the path style S3 API of one bucket, paginating listings by one key
+type fakeS3 struct {
+	sync.Mutex
+	t       *testing.T
+	bucket  string
+	objects map[string][]byte
+	headers map[string]http.Header
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	require.True(f.t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/"))
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != f.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message></Error>")
+		return
+	}
+
+	if len(parts) == 1 || parts[1] == "" {
+		require.Equal(f.t, "2", r.URL.Query().Get("list-type"))
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, prefix) && k > r.URL.Query().Get("continuation-token") {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		fmt.Fprint(w, "<ListBucketResult>")
+		if len(keys) > 0 {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", keys[0])
+		}
+		if len(keys) > 1 {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[0])
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+		return
+	}
+
+	key := parts[1]
+	switch r.Method {
+	case http.MethodPut:
+		require.Equal(f.t, unsignedPayload, r.Header.Get("X-Amz-Content-Sha256"))
+		data, err := ioutil.ReadAll(r.Body)
+		require.NoError(f.t, err)
+		f.objects[key] = data
+		f.headers[key] = r.Header
+	case http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		require.Equal(f.t, f.headers[key].Get("X-Amz-Server-Side-Encryption-Customer-Key"), r.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+		w.Write(data) // nolint: errcheck
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestBucket(t *testing.T) {
+	f := &fakeS3{
+		t:       t,
+		bucket:  "teller",
+		objects: make(map[string][]byte),
+		headers: make(map[string]http.Header),
+	}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	customerKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	cfg := Config{
+		Backend:         BackendS3,
+		Endpoint:        srv.URL,
+		Region:          "us-east-1",
+		Bucket:          "teller",
+		Prefix:          "prod/",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PathStyle:       true,
+		Encryption:      EncryptionCustomer,
+		CustomerKey:     customerKey,
+	}
+	b, err := New(cfg)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	_, err = b.Get(ctx, "a/1")
+	require.Equal(t, ErrNotExist, err)
+
+	for _, k := range []string{"a/1", "a/2", "b/1"} {
+		require.NoError(t, b.Put(ctx, k, strings.NewReader(k), int64(len(k))))
+	}
+	require.Equal(t, "AES256", f.headers["prod/a/1"].Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"))
+	require.Equal(t, customerKey, f.headers["prod/a/1"].Get("X-Amz-Server-Side-Encryption-Customer-Key"))
+
+	data, err := b.Get(ctx, "a/2")
+	require.NoError(t, err)
+	require.Equal(t, "a/2", string(data))
+
+	keys, err := b.List(ctx, "a/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/1", "a/2"}, keys)
+
+	require.NoError(t, b.Delete(ctx, "a/1"))
+	keys, err = b.List(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/2", "b/1"}, keys)
+
+	// A missing bucket isn't a missing object
+	cfg.Bucket = "other"
+	b, err = New(cfg)
+	require.NoError(t, err)
+	_, err = b.Get(ctx, "a/2")
+	apiErr, ok := err.(*Error)
+	require.True(t, ok)
+	require.Equal(t, "NoSuchBucket", apiErr.Code)
+}
+
+func TestBucketURL(t *testing.T) {
+	b, err := New(Config{
+		Backend:         BackendS3,
+		Region:          "eu-west-1",
+		Bucket:          "teller",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		Encryption:      EncryptionKMS,
+		KMSKeyID:        "alias/teller",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "https://teller.s3.eu-west-1.amazonaws.com/a%20b", b.objectURL("a b"))
+	require.Equal(t, "aws:kms", b.putHeaders.Get("X-Amz-Server-Side-Encryption"))
+	require.Equal(t, "alias/teller", b.putHeaders.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	require.Empty(t, b.getHeaders)
+
+	b, err = New(Config{
+		Backend:         BackendGCS,
+		Bucket:          "teller",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		Encryption:      EncryptionKMS,
+		KMSKeyID:        "projects/p/locations/global/keyRings/r/cryptoKeys/k",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "https://storage.googleapis.com/teller/x", b.objectURL("x"))
+	require.Equal(t, "auto", b.signer.region)
+	require.Equal(t, "projects/p/locations/global/keyRings/r/cryptoKeys/k", b.putHeaders.Get("X-Goog-Encryption-Kms-Key-Name"))
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{
+		Backend:         BackendS3,
+		Region:          "us-east-1",
+		Bucket:          "teller",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+	}
+	require.NoError(t, valid.Validate())
+
+	for _, update := range []func(c *Config){
+		func(c *Config) { c.Backend = "azure" },
+		func(c *Config) { c.Region = "" },
+		func(c *Config) { c.Bucket = "" },
+		func(c *Config) { c.SecretAccessKey = "" },
+		func(c *Config) { c.Endpoint = "minio:9000" },
+		func(c *Config) { c.Encryption = "rot13" },
+		func(c *Config) { c.Encryption = EncryptionKMS },
+		func(c *Config) { c.Encryption = EncryptionCustomer; c.CustomerKey = "c2hvcnQ=" },
+		func(c *Config) { c.Backend = BackendGCS; c.Encryption = EncryptionAES256 },
+	} {
+		c := valid
+		update(&c)
+		require.Error(t, c.Validate(), "%+v", c)
+	}
+}
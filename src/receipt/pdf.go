@@ -0,0 +1,148 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// A4, in points
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+
+	pdfMargin      = 50
+	pdfValueX      = 190 // x of the values, right of the labels
+	pdfLineHeight  = 16
+	pdfTitleSize   = 18
+	pdfHeadingSize = 14
+	pdfFontSize    = 9
+
+	// pdfMaxValueLen is the longest value which fits right of the labels, longer values are wrapped
+	pdfMaxValueLen = 70
+)
+
+type pdfText struct {
+	x, y int
+	size int
+	bold bool
+	s    string
+}
+
+// pdfLines lays out the receipt's text top down, as the HTML receipt
+func (s *Service) pdfLines(r Receipt) []pdfText {
+	var lines []pdfText
+	y := pdfPageHeight - pdfMargin
+
+	add := func(x, size int, bold bool, text string) {
+		lines = append(lines, pdfText{x: x, y: y, size: size, bold: bold, s: text})
+	}
+
+	b := s.cfg.Branding
+	if b.Name != "" {
+		y -= pdfTitleSize
+		add(pdfMargin, pdfTitleSize, true, b.Name)
+		y -= pdfLineHeight
+	}
+
+	y -= pdfHeadingSize
+	add(pdfMargin, pdfHeadingSize, true, "Deposit receipt")
+	y -= pdfLineHeight
+
+	for _, row := range r.rows() {
+		y -= pdfLineHeight
+		add(pdfMargin, pdfFontSize, true, row.Label)
+
+		value := row.Value
+		for len(value) > pdfMaxValueLen {
+			add(pdfValueX, pdfFontSize, false, value[:pdfMaxValueLen])
+			value = value[pdfMaxValueLen:]
+			y -= pdfLineHeight
+		}
+		add(pdfValueX, pdfFontSize, false, value)
+	}
+
+	var footer []string
+	if b.Website != "" {
+		footer = append(footer, b.Website)
+	}
+	if b.SupportEmail != "" {
+		footer = append(footer, "Questions about this receipt: "+b.SupportEmail)
+	}
+	if b.Footer != "" {
+		footer = append(footer, strings.Split(b.Footer, "\n")...)
+	}
+
+	y -= pdfLineHeight
+	for _, line := range footer {
+		y -= pdfLineHeight
+		add(pdfMargin, pdfFontSize, false, line)
+	}
+
+	return lines
+}
+
+// pdfEscape escapes a PDF string literal. Characters outside of Latin-1 can't be shown by the standard fonts,
+// they are replaced with "?".
+func pdfEscape(s string) string {
+	var b bytes.Buffer
+	for _, c := range s {
+		switch {
+		case c == '\\' || c == '(' || c == ')':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c == '\r' || c == '\n' || c == '\t':
+			b.WriteByte(' ')
+		case c < 0x20 || c > 0xff:
+			b.WriteByte('?')
+		case c >= 0x80:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// renderPDF writes the receipt as a single page PDF, in the standard Helvetica fonts,
+// so that no PDF library or embedded font is needed
+func (s *Service) renderPDF(w io.Writer, r Receipt) error {
+	var content bytes.Buffer
+	for _, t := range s.pdfLines(r) {
+		font := "F1"
+		if t.bold {
+			font = "F2"
+		}
+		fmt.Fprintf(&content, "BT /%s %d Tf %d %d Td (%s) Tj ET\n", font, t.size, t.x, t.y, pdfEscape(t.s))
+	}
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 4 0 R /F2 5 0 R >> >> /Contents 6 0 R >>", pdfPageWidth, pdfPageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		fmt.Sprintf("<< /Title (%s) /Producer (teller) >>", pdfEscape(s.title(r))),
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, len(objects), xref)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
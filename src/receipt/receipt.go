@@ -0,0 +1,373 @@
+// Package receipt renders the receipts of completed deposits, for the depositors' tax records.
+// A receipt is an HTML page, or optionally a PDF, retrieved through a signed link
+// which is included in the deposit statuses and the payout emails.
+package receipt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/exchange"
+)
+
+const (
+	// FormatHTML is the format of an HTML receipt
+	FormatHTML = "html"
+	// FormatPDF is the format of a PDF receipt
+	FormatPDF = "pdf"
+
+	// timeLayout is the format of the receipts' timestamps
+	timeLayout = "2006-01-02 15:04:05 MST"
+)
+
+var (
+	// ErrInvalidSignature is returned if the signature of a receipt link is invalid
+	ErrInvalidSignature = errors.New("Invalid signature")
+	// ErrNotCompleted is returned for a deposit which is not done, it has no receipt yet
+	ErrNotCompleted = errors.New("Deposit is not completed")
+	// ErrInvalidFormat is returned for a format which is not FormatHTML or FormatPDF
+	ErrInvalidFormat = errors.New("Invalid format")
+	// ErrPDFDisabled is returned for FormatPDF if PDF receipts are disabled
+	ErrPDFDisabled = errors.New("PDF receipts disabled")
+)
+
+// Branding is the operator's branding printed on the receipts. Empty fields are left out.
+type Branding struct {
+	// Name of the operator, the receipts' title
+	Name string
+	// Absolute URL of the logo shown on the HTML receipts
+	LogoURL string
+	Website string
+	// Where depositors ask about their receipts
+	SupportEmail string
+	// Text printed at the bottom of the receipts, e.g. the operator's legal name and address
+	Footer string
+}
+
+// Config configures a Service
+type Config struct {
+	// URL of teller's /api/receipt endpoint, which the receipt links point to
+	URL string
+	// Key of the receipt link signatures
+	SigningKey string
+	// Serve the receipts as PDF too, not only as HTML
+	PDF bool
+	// Coin paid out, named on the receipts. Defaults to SKY.
+	PayoutCoin string
+	Branding   Branding
+}
+
+// Validate returns an error if the configuration is invalid
+func (c Config) Validate() error {
+	if err := validateURL(c.URL); err != nil {
+		return fmt.Errorf("Invalid URL: %v", err)
+	}
+
+	if c.SigningKey == "" {
+		return errors.New("SigningKey is required")
+	}
+
+	if c.Branding.LogoURL != "" {
+		if err := validateURL(c.Branding.LogoURL); err != nil {
+			return fmt.Errorf("Invalid Branding.LogoURL: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// Receipt is the receipt of a completed deposit
+type Receipt struct {
+	DepositID      string
+	CoinType       string
+	DepositAddress string
+	// Transaction of the deposit, and its explorer link
+	DepositTxid  string
+	DepositTxURL string
+	// Amount deposited, in whole coins
+	DepositAmount string
+	// Part of the deposit refunded for exceeding the campaign cap, in whole coins. Empty if nothing was refunded.
+	RefundAmount string
+	// Coins paid out per deposited coin
+	ConversionRate string
+	SkyAddress     string
+	// Amount paid out, in whole coins
+	PayoutAmount string
+	PayoutCoin   string
+	// Transaction of the payout and its explorer link, empty if the deposit was too small to pay anything out
+	PayoutTxid  string
+	PayoutTxURL string
+	// When the deposit was received, the payout sent and confirmed.
+	// ReceivedAt and SentAt are zero if the deposit's ledger history doesn't have them.
+	ReceivedAt  time.Time
+	SentAt      time.Time
+	CompletedAt time.Time
+}
+
+// row is a line of a receipt, shared by the HTML and PDF receipts
+type row struct {
+	Label string
+	Value string
+	// Link of the value on the HTML receipts, e.g. an explorer link
+	URL string
+}
+
+func (r Receipt) rows() []row {
+	rows := []row{
+		{Label: "Deposit ID", Value: r.DepositID},
+		{Label: "Deposited", Value: r.DepositAmount + " " + r.CoinType},
+	}
+
+	if r.RefundAmount != "" {
+		rows = append(rows, row{Label: "Refunded", Value: r.RefundAmount + " " + r.CoinType})
+	}
+
+	rows = append(rows, []row{
+		{Label: "Rate", Value: fmt.Sprintf("1 %s = %s %s", r.CoinType, r.ConversionRate, r.PayoutCoin)},
+		{Label: "Paid out", Value: r.PayoutAmount + " " + r.PayoutCoin},
+		{Label: "Deposit address", Value: r.DepositAddress},
+		{Label: "Deposit transaction", Value: r.DepositTxid, URL: r.DepositTxURL},
+		{Label: r.PayoutCoin + " address", Value: r.SkyAddress},
+	}...)
+
+	if r.PayoutTxid != "" {
+		rows = append(rows, row{Label: "Payout transaction", Value: r.PayoutTxid, URL: r.PayoutTxURL})
+	}
+
+	for _, t := range []struct {
+		label string
+		t     time.Time
+	}{
+		{"Received", r.ReceivedAt},
+		{"Sent", r.SentAt},
+		{"Completed", r.CompletedAt},
+	} {
+		if !t.t.IsZero() {
+			rows = append(rows, row{Label: t.label, Value: t.t.UTC().Format(timeLayout)})
+		}
+	}
+
+	return rows
+}
+
+var htmlTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 50em; padding: 0 1em; color: #222; }
+header img { max-height: 4em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.5em; border-bottom: 1px solid #ddd; vertical-align: top; }
+td { word-break: break-all; }
+footer { margin-top: 2em; color: #666; font-size: 0.9em; white-space: pre-line; }
+</style>
+</head>
+<body>
+<header>
+{{if .Branding.LogoURL}}<img src="{{.Branding.LogoURL}}" alt="{{.Branding.Name}}">{{end}}
+{{if .Branding.Name}}<h1>{{.Branding.Name}}</h1>{{end}}
+</header>
+<h2>Deposit receipt</h2>
+<table>
+{{range .Rows}}
+<tr><th>{{.Label}}</th><td>{{if .URL}}<a href="{{.URL}}">{{.Value}}</a>{{else}}{{.Value}}{{end}}</td></tr>
+{{end}}
+</table>
+<footer>
+{{- if .Branding.Website}}<a href="{{.Branding.Website}}">{{.Branding.Website}}</a>
+{{end}}
+{{- if .Branding.SupportEmail}}Questions about this receipt: <a href="mailto:{{.Branding.SupportEmail}}">{{.Branding.SupportEmail}}</a>
+{{end}}
+{{- if .Branding.Footer}}{{.Branding.Footer}}{{end -}}
+</footer>
+</body>
+</html>
+`))
+
+type htmlPage struct {
+	Title    string
+	Branding Branding
+	Rows     []row
+}
+
+// Service signs the receipt links and renders the receipts
+type Service struct {
+	cfg        Config
+	signingKey []byte
+}
+
+// NewService creates a Service
+func NewService(cfg Config) (*Service, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if cfg.PayoutCoin == "" {
+		cfg.PayoutCoin = "SKY"
+	}
+
+	return &Service{
+		cfg:        cfg,
+		signingKey: []byte(cfg.SigningKey),
+	}, nil
+}
+
+// PDFEnabled returns true if the receipts can be rendered as PDF
+func (s *Service) PDFEnabled() bool {
+	return s.cfg.PDF
+}
+
+// sign returns the signature of the receipt link of a deposit
+func (s *Service) sign(depositID string) []byte {
+	h := hmac.New(sha256.New, s.signingKey)
+	h.Write([]byte("receipt\n" + depositID)) // nolint: errcheck
+	return h.Sum(nil)
+}
+
+// Verify returns ErrInvalidSignature if sig is not the signature of the deposit's receipt link
+func (s *Service) Verify(depositID, sig string) error {
+	b, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(b, s.sign(depositID)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Link returns the signed receipt link of a deposit. The link doesn't expire.
+func (s *Service) Link(depositID string) string {
+	q := url.Values{}
+	q.Set("deposit_id", depositID)
+	q.Set("sig", hex.EncodeToString(s.sign(depositID)))
+
+	sep := "?"
+	if strings.Contains(s.cfg.URL, "?") {
+		sep = "&"
+	}
+
+	return s.cfg.URL + sep + q.Encode()
+}
+
+// Receipt returns the receipt of a deposit from its record, or ErrNotCompleted if it is not done.
+// The explorer links are the record's, see exchange.DepositStatusDetail.Link.
+func (s *Service) Receipt(r exchange.DepositRecord) (Receipt, error) {
+	if r.Status != exchange.StatusDone.String() {
+		return Receipt{}, ErrNotCompleted
+	}
+
+	coin, err := deposits.GetCoin(r.CoinType)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	payout, err := droplet.ToString(r.SkySent)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	depositTxid, _, err := deposits.ParseID(r.DepositID)
+	if err != nil {
+		return Receipt{}, err
+	}
+
+	rcpt := Receipt{
+		DepositID:      r.DepositID,
+		CoinType:       r.CoinType,
+		DepositAddress: r.DepositAddress,
+		DepositTxid:    depositTxid,
+		DepositTxURL:   r.Explorer.DepositTx,
+		DepositAmount:  coin.Coins(r.DepositValue).String(),
+		ConversionRate: r.ConversionRate,
+		SkyAddress:     r.SkyAddress,
+		PayoutAmount:   payout,
+		PayoutCoin:     s.cfg.PayoutCoin,
+		PayoutTxid:     r.Txid,
+		PayoutTxURL:    r.Explorer.Txid,
+		CompletedAt:    time.Unix(r.UpdatedAt, 0).UTC(),
+	}
+
+	if r.RefundValue > 0 {
+		rcpt.RefundAmount = coin.Coins(r.RefundValue).String()
+	}
+
+	// The deposit was received at its first ledger transaction, and sent when it was waiting for confirmation
+	for _, lt := range r.History {
+		if rcpt.ReceivedAt.IsZero() {
+			rcpt.ReceivedAt = time.Unix(lt.Time, 0).UTC()
+		}
+		if rcpt.SentAt.IsZero() && lt.Status == exchange.StatusWaitConfirm.String() {
+			rcpt.SentAt = time.Unix(lt.Time, 0).UTC()
+		}
+	}
+
+	return rcpt, nil
+}
+
+// ContentType returns the content type of a format
+func ContentType(format string) string {
+	if format == FormatPDF {
+		return "application/pdf"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// Render writes the receipt in format, FormatHTML or FormatPDF
+func (s *Service) Render(w io.Writer, r Receipt, format string) error {
+	switch format {
+	case FormatHTML:
+		return s.renderHTML(w, r)
+	case FormatPDF:
+		if !s.cfg.PDF {
+			return ErrPDFDisabled
+		}
+		return s.renderPDF(w, r)
+	default:
+		return ErrInvalidFormat
+	}
+}
+
+func (s *Service) title(r Receipt) string {
+	if s.cfg.Branding.Name != "" {
+		return s.cfg.Branding.Name + " receipt " + r.DepositID
+	}
+	return "Receipt " + r.DepositID
+}
+
+func (s *Service) renderHTML(w io.Writer, r Receipt) error {
+	var b bytes.Buffer
+	if err := htmlTemplate.Execute(&b, htmlPage{
+		Title:    s.title(r),
+		Branding: s.cfg.Branding,
+		Rows:     r.rows(),
+	}); err != nil {
+		return err
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
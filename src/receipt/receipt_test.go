@@ -0,0 +1,193 @@
+package receipt
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/exchange"
+)
+
+func testConfig() Config {
+	return Config{
+		URL:        "https://teller.example.com/api/receipt",
+		SigningKey: "secret",
+		PDF:        true,
+		Branding: Branding{
+			Name:         "Example <Teller>",
+			LogoURL:      "https://example.com/logo.png",
+			SupportEmail: "support@example.com",
+			Footer:       "Example Ltd (Reg. 1234)\n1 Main St",
+		},
+	}
+}
+
+func testRecord() exchange.DepositRecord {
+	var r exchange.DepositRecord
+	r.Status = exchange.StatusDone.String()
+	r.CoinType = "BTC"
+	r.SkyAddress = "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	r.DepositAddress = "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS"
+	r.DepositID = "btctx:1"
+	r.Txid = "skytx"
+	r.ConversionRate = "500"
+	r.UpdatedAt = 1514768400
+	r.DepositValue = 3e8
+	r.SkySent = 1000e6
+	r.RefundValue = 1e8
+	r.Explorer.DepositTx = "https://blockstream.info/tx/btctx"
+	r.Explorer.Txid = "https://explorer.skycoin.com/app/transaction/skytx"
+	r.History = []exchange.LedgerTransaction{
+		{Time: 1514764800, Status: exchange.StatusWaitSend.String()},
+		{Time: 1514766600, Status: exchange.StatusWaitConfirm.String()},
+		{Time: 1514768400, Status: exchange.StatusDone.String()},
+	}
+	return r
+}
+
+func TestConfigValidate(t *testing.T) {
+	require.NoError(t, testConfig().Validate())
+
+	cfg := testConfig()
+	cfg.URL = "/api/receipt"
+	require.EqualError(t, cfg.Validate(), "Invalid URL: must be an absolute http or https URL")
+
+	cfg = testConfig()
+	cfg.SigningKey = ""
+	require.EqualError(t, cfg.Validate(), "SigningKey is required")
+
+	cfg = testConfig()
+	cfg.Branding.LogoURL = "javascript:alert(1)"
+	require.EqualError(t, cfg.Validate(), "Invalid Branding.LogoURL: must be an absolute http or https URL")
+}
+
+func TestLink(t *testing.T) {
+	s, err := NewService(testConfig())
+	require.NoError(t, err)
+
+	link := s.Link("btctx:1")
+	require.Regexp(t, `^https://teller\.example\.com/api/receipt\?deposit_id=btctx%3A1&sig=[0-9a-f]{64}$`, link)
+
+	sig := regexp.MustCompile(`sig=([0-9a-f]+)`).FindStringSubmatch(link)[1]
+	require.NoError(t, s.Verify("btctx:1", sig))
+	require.Equal(t, ErrInvalidSignature, s.Verify("btctx:2", sig))
+	require.Equal(t, ErrInvalidSignature, s.Verify("btctx:1", "zz"))
+	require.Equal(t, ErrInvalidSignature, s.Verify("btctx:1", ""))
+
+	// Another key's signatures are invalid
+	cfg := testConfig()
+	cfg.SigningKey = "other"
+	cfg.URL = "https://teller.example.com/api/receipt?lang=en"
+	s2, err := NewService(cfg)
+	require.NoError(t, err)
+	require.Equal(t, ErrInvalidSignature, s2.Verify("btctx:1", sig))
+	require.Contains(t, s2.Link("btctx:1"), "/api/receipt?lang=en&deposit_id=")
+}
+
+func TestReceipt(t *testing.T) {
+	s, err := NewService(testConfig())
+	require.NoError(t, err)
+
+	r, err := s.Receipt(testRecord())
+	require.NoError(t, err)
+	require.Equal(t, Receipt{
+		DepositID:      "btctx:1",
+		CoinType:       "BTC",
+		DepositAddress: "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS",
+		DepositTxid:    "btctx",
+		DepositTxURL:   "https://blockstream.info/tx/btctx",
+		DepositAmount:  "3",
+		RefundAmount:   "1",
+		ConversionRate: "500",
+		SkyAddress:     "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv",
+		PayoutAmount:   "1000.000000",
+		PayoutCoin:     "SKY",
+		PayoutTxid:     "skytx",
+		PayoutTxURL:    "https://explorer.skycoin.com/app/transaction/skytx",
+		ReceivedAt:     time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+		SentAt:         time.Date(2018, 1, 1, 0, 30, 0, 0, time.UTC),
+		CompletedAt:    time.Date(2018, 1, 1, 1, 0, 0, 0, time.UTC),
+	}, r)
+
+	// A deposit which isn't done has no receipt
+	rec := testRecord()
+	rec.Status = exchange.StatusWaitConfirm.String()
+	_, err = s.Receipt(rec)
+	require.Equal(t, ErrNotCompleted, err)
+
+	// A deposit too small to pay anything out has no payout transaction, nor a history from before the ledger
+	rec = testRecord()
+	rec.Txid = ""
+	rec.SkySent = 0
+	rec.History = nil
+	r, err = s.Receipt(rec)
+	require.NoError(t, err)
+	require.Equal(t, "0.000000", r.PayoutAmount)
+	require.True(t, r.ReceivedAt.IsZero())
+	for _, row := range r.rows() {
+		require.NotEqual(t, "Payout transaction", row.Label)
+		require.NotEqual(t, "Received", row.Label)
+	}
+}
+
+func TestRender(t *testing.T) {
+	s, err := NewService(testConfig())
+	require.NoError(t, err)
+
+	r, err := s.Receipt(testRecord())
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, s.Render(&b, r, FormatHTML))
+	html := b.String()
+	require.Contains(t, html, "<title>Example &lt;Teller&gt; receipt btctx:1</title>")
+	require.Contains(t, html, `<img src="https://example.com/logo.png"`)
+	require.Contains(t, html, "<tr><th>Rate</th><td>1 BTC = 500 SKY</td></tr>")
+	require.Contains(t, html, `<a href="https://blockstream.info/tx/btctx">btctx</a>`)
+	require.Contains(t, html, "<tr><th>Paid out</th><td>1000.000000 SKY</td></tr>")
+	require.Contains(t, html, "<tr><th>Completed</th><td>2018-01-01 01:00:00 UTC</td></tr>")
+	require.Contains(t, html, "mailto:support@example.com")
+	require.Contains(t, html, "Example Ltd (Reg. 1234)\n1 Main St")
+
+	b.Reset()
+	require.NoError(t, s.Render(&b, r, FormatPDF))
+	pdf := b.Bytes()
+	require.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")))
+	require.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+	require.Contains(t, string(pdf), "(Example <Teller>) Tj")
+	require.Contains(t, string(pdf), "(Example Ltd \\(Reg. 1234\\)) Tj")
+	require.Contains(t, string(pdf), "(1 BTC = 500 SKY) Tj")
+
+	// The xref table points at each object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	require.NotNil(t, m)
+	xref, err := strconv.Atoi(string(m[1]))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(pdf[xref:], []byte("xref\n0 8\n")))
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(pdf[xref:], -1)
+	require.Len(t, offsets, 7)
+	for i, o := range offsets {
+		off, err := strconv.Atoi(string(o[1]))
+		require.NoError(t, err)
+		require.True(t, bytes.HasPrefix(pdf[off:], []byte(fmt.Sprintf("%d 0 obj\n", i+1))))
+	}
+
+	require.Equal(t, ErrInvalidFormat, s.Render(&b, r, "txt"))
+
+	cfg := testConfig()
+	cfg.PDF = false
+	s, err = NewService(cfg)
+	require.NoError(t, err)
+	require.False(t, s.PDFEnabled())
+	require.Equal(t, ErrPDFDisabled, s.Render(&b, r, FormatPDF))
+}
+
+func TestPDFEscape(t *testing.T) {
+	require.Equal(t, `a\\b \(c\) d`, pdfEscape("a\\b (c)\nd"))
+	require.Equal(t, `caf\351 ?`, pdfEscape("café ✓"))
+}
@@ -0,0 +1,487 @@
+// Package reconcile periodically compares the exchange ledger with the chains and
+// the hot wallet, and writes a signed report of the result
+package reconcile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/cipher"
+	"github.com/skycoin/skycoin/src/util/droplet"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/exchange"
+)
+
+const (
+	// CheckDeposits compares the deposits received by the ledger with the deposits scanned from the chain
+	CheckDeposits = "deposits"
+	// CheckOutflows compares the SKY sent by the ledger with the hot wallet transactions
+	CheckOutflows = "outflows"
+
+	// retryInterval is how long to wait before reconciling again after a failure
+	retryInterval = time.Minute * 10
+
+	reportTimeFormat = "20060102T150405Z"
+	reportPrefix     = "reconcile-"
+	reportExt        = ".json"
+	// SigExt is appended to a report's filename to get the filename of its signature
+	SigExt = ".sig"
+	// ReportPrefix is the prefix of the keys of the reports uploaded to object storage
+	ReportPrefix = "reports/"
+
+	// uploadTimeout bounds the upload of a report to object storage
+	uploadTimeout = time.Minute
+)
+
+// Config configures the Reconciler
+type Config struct {
+	// How often to reconcile and write a report
+	Interval time.Duration
+	// Directory the reports are written to, unless they are uploaded to object storage
+	ReportDir string
+	// Hex encoded secret key the reports are signed with
+	SecretKey string
+	// Max difference tolerated per currency, in whole coins, e.g. {"BTC": "0.0001"}.
+	// Currencies which are not listed tolerate no difference.
+	Tolerances map[string]string
+}
+
+// Validate returns an error if the configuration is invalid
+func (c Config) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("Reconcile interval must be > 0")
+	}
+
+	if _, err := parseSecretKey(c.SecretKey); err != nil {
+		return err
+	}
+
+	_, err := parseTolerances(c.Tolerances)
+	return err
+}
+
+// Ledger provides the exchange's ledger and deposit records
+type Ledger interface {
+	GetLedgerBalances() (exchange.LedgerBalances, error)
+	CheckLedger() error
+	ForEachDepositInfo(exchange.DepositFilter, func(exchange.DepositInfo) error) error
+}
+
+// ChainDeposits returns the totals of the deposits scanned from the chains, by coin type
+type ChainDeposits interface {
+	GetDepositTotals() (map[string]int64, error)
+}
+
+// ObjectStore saves objects in an object storage bucket, such as an objstore.Bucket
+type ObjectStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// HotWallet returns the droplets sent to an address by a hot wallet transaction
+type HotWallet interface {
+	GetTxOutput(txid, addr string) (uint64, error)
+}
+
+// Check compares a ledger total with the total observed by an independent source.
+// Amounts are in the currency's smallest unit, as in the ledger.
+type Check struct {
+	Name       string `json:"name"`
+	Currency   string `json:"currency"`
+	Ledger     int64  `json:"ledger"`
+	Observed   int64  `json:"observed"`
+	Difference int64  `json:"difference"`
+	Tolerance  int64  `json:"tolerance"`
+	OK         bool   `json:"ok"`
+}
+
+// Report is the result of a reconciliation
+type Report struct {
+	Time        time.Time               `json:"time"`
+	Balances    exchange.LedgerBalances `json:"balances"`
+	LedgerDrift []exchange.LedgerDrift  `json:"ledger_drift"`
+	Checks      []Check                 `json:"checks"`
+	// OK is false if the ledger drifted from the deposit records or any check exceeded its tolerance
+	OK bool `json:"ok"`
+}
+
+// Reconciler compares the ledger totals with the deposits scanned from the chains and
+// the outflows of the hot wallet, writes a signed report and alerts on discrepancies
+type Reconciler struct {
+	log        logrus.FieldLogger
+	cfg        Config
+	secKey     cipher.SecKey
+	tolerances map[string]int64
+	ledger     Ledger
+	chain      ChainDeposits
+	hotWallet  HotWallet
+	objects    ObjectStore
+	// outflows caches the outflows of confirmed transactions, which can't change, by txid
+	outflows map[string]uint64
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+// NewReconciler creates a Reconciler. If chain or hotWallet are nil, their checks are skipped.
+// If objects is not nil, the reports are uploaded to it under ReportPrefix instead of being written to the report dir.
+func NewReconciler(log logrus.FieldLogger, cfg Config, ledger Ledger, chain ChainDeposits, hotWallet HotWallet, objects ObjectStore) (*Reconciler, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	if objects == nil && cfg.ReportDir == "" {
+		return nil, errors.New("Reconcile report dir missing")
+	}
+
+	secKey, err := parseSecretKey(cfg.SecretKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tolerances, err := parseTolerances(cfg.Tolerances)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reconciler{
+		log:        log.WithField("prefix", "teller.reconcile"),
+		cfg:        cfg,
+		secKey:     secKey,
+		tolerances: tolerances,
+		ledger:     ledger,
+		chain:      chain,
+		hotWallet:  hotWallet,
+		objects:    objects,
+		outflows:   make(map[string]uint64),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Run reconciles once at startup, then every interval until Shutdown is called.
+// A failed reconciliation is retried sooner than the interval.
+func (r *Reconciler) Run() error {
+	log := r.log.WithFields(logrus.Fields{
+		"interval":  r.cfg.Interval,
+		"reportDir": r.cfg.ReportDir,
+	})
+	log.Info("Start reconcile service")
+	defer log.Info("Reconcile service closed")
+	defer close(r.done)
+
+	if r.objects == nil {
+		if err := os.MkdirAll(r.cfg.ReportDir, 0700); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.quit:
+			return nil
+		case <-timer.C:
+			wait := r.cfg.Interval
+			if _, err := r.WriteReport(time.Now()); err != nil {
+				log.WithError(err).Error("Reconcile failed")
+				if retryInterval < wait {
+					wait = retryInterval
+				}
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+// Shutdown stops the Reconciler
+func (r *Reconciler) Shutdown() {
+	close(r.quit)
+	<-r.done
+}
+
+// WriteReport reconciles, writes the signed report to the report dir and returns its path.
+// The report is uploaded instead if the Reconciler has an ObjectStore, and the object key is returned.
+func (r *Reconciler) WriteReport(now time.Time) (string, error) {
+	report, err := r.Reconcile(now)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(report, "", "    ")
+	if err != nil {
+		return "", err
+	}
+
+	sig := []byte(cipher.SignHash(cipher.SumSHA256(b), r.secKey).Hex() + "\n")
+
+	name := reportPrefix + report.Time.Format(reportTimeFormat) + reportExt
+
+	var path string
+	if r.objects != nil {
+		path = ReportPrefix + name
+		if err := r.upload(path, b, sig); err != nil {
+			return "", err
+		}
+	} else {
+		path = filepath.Join(r.cfg.ReportDir, name)
+
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			return "", err
+		}
+
+		if err := ioutil.WriteFile(path+SigExt, sig, 0600); err != nil {
+			return "", err
+		}
+	}
+
+	r.log.WithFields(logrus.Fields{
+		"report": path,
+		"ok":     report.OK,
+	}).Info("Wrote reconciliation report")
+
+	return path, nil
+}
+
+// upload uploads a report and its signature under key
+func (r *Reconciler) upload(key string, report, sig []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadTimeout)
+	defer cancel()
+
+	if err := r.objects.Put(ctx, key, bytes.NewReader(report), int64(len(report))); err != nil {
+		return err
+	}
+
+	return r.objects.Put(ctx, key+SigExt, bytes.NewReader(sig), int64(len(sig)))
+}
+
+// Reconcile compares the ledger totals with the chains and the hot wallet.
+// Discrepancies beyond the tolerances are alerted and reported, not returned as errors.
+func (r *Reconciler) Reconcile(now time.Time) (*Report, error) {
+	balances, err := r.ledger.GetLedgerBalances()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Time:     now.UTC(),
+		Balances: balances,
+		Checks:   []Check{},
+		OK:       true,
+	}
+
+	switch err := r.ledger.CheckLedger().(type) {
+	case nil:
+	case exchange.LedgerDriftErr:
+		report.LedgerDrift = err.Drifts
+		report.OK = false
+	default:
+		return nil, err
+	}
+
+	if r.chain != nil {
+		checks, err := r.checkDeposits(balances)
+		if err != nil {
+			return nil, err
+		}
+		report.Checks = append(report.Checks, checks...)
+	}
+
+	if r.hotWallet != nil {
+		check, err := r.checkOutflows(balances)
+		if err != nil {
+			return nil, err
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	for _, c := range report.Checks {
+		if c.OK {
+			continue
+		}
+
+		report.OK = false
+
+		r.log.WithFields(logrus.Fields{
+			"check":      c.Name,
+			"currency":   c.Currency,
+			"ledger":     c.Ledger,
+			"observed":   c.Observed,
+			"difference": c.Difference,
+			"tolerance":  c.Tolerance,
+		}).WithField("alert", "reconcile_discrepancy").Error("ALERT: Reconciliation discrepancy exceeds tolerance")
+	}
+
+	return report, nil
+}
+
+// checkDeposits compares the deposits received by the ledger with the processed deposits
+// scanned from the chain, for each coin type
+func (r *Reconciler) checkDeposits(balances exchange.LedgerBalances) ([]Check, error) {
+	totals, err := r.chain.GetDepositTotals()
+	if err != nil {
+		return nil, err
+	}
+
+	coinTypes := make(map[string]struct{})
+	for coinType := range totals {
+		coinTypes[coinType] = struct{}{}
+	}
+	for currency := range balances {
+		if currency != exchange.CurrencySKY {
+			coinTypes[currency] = struct{}{}
+		}
+	}
+
+	checks := make([]Check, 0, len(coinTypes))
+	for coinType := range coinTypes {
+		checks = append(checks, r.newCheck(CheckDeposits, coinType, balances[coinType][exchange.AccountDepositsReceived], totals[coinType]))
+	}
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].Currency < checks[j].Currency
+	})
+
+	return checks, nil
+}
+
+// checkOutflows compares the SKY sent according to the ledger with the outputs of
+// the hot wallet transactions to the deposits' skycoin addresses
+func (r *Reconciler) checkOutflows(balances exchange.LedgerBalances) (Check, error) {
+	sky := balances[exchange.CurrencySKY]
+	sent := -(sky[exchange.AccountSkyLiability] + sky[exchange.AccountSkyPaid])
+
+	hasTxid := func(di exchange.DepositInfo) bool {
+		return di.Txid != ""
+	}
+
+	// The hot wallet is queried after the deposits are read, so that the db isn't held open by the queries
+	var dis []exchange.DepositInfo
+	if err := r.ledger.ForEachDepositInfo(hasTxid, func(di exchange.DepositInfo) error {
+		dis = append(dis, di)
+		return nil
+	}); err != nil {
+		return Check{}, err
+	}
+
+	var outflows uint64
+	for _, di := range dis {
+		if coins, ok := r.outflows[di.Txid]; ok {
+			outflows += coins
+			continue
+		}
+
+		coins, err := r.hotWallet.GetTxOutput(di.Txid, di.SkyAddress)
+		if err != nil {
+			return Check{}, fmt.Errorf("Get output of transaction %s failed: %v", di.Txid, err)
+		}
+
+		if di.Status == exchange.StatusDone {
+			r.outflows[di.Txid] = coins
+		}
+
+		outflows += coins
+	}
+
+	return r.newCheck(CheckOutflows, exchange.CurrencySKY, sent, int64(outflows)), nil
+}
+
+func (r *Reconciler) newCheck(name, currency string, ledger, observed int64) Check {
+	diff := ledger - observed
+	tolerance := r.tolerances[currency]
+
+	return Check{
+		Name:       name,
+		Currency:   currency,
+		Ledger:     ledger,
+		Observed:   observed,
+		Difference: diff,
+		Tolerance:  tolerance,
+		OK:         diff <= tolerance && -diff <= tolerance,
+	}
+}
+
+// VerifyReport verifies a report's signature against the hex encoded public key of the secret key it was signed with
+func VerifyReport(report []byte, sig, pubKey string) error {
+	pub, err := cipher.PubKeyFromHex(strings.TrimSpace(pubKey))
+	if err != nil {
+		return fmt.Errorf("Invalid public key: %v", err)
+	}
+
+	s, err := cipher.SigFromHex(strings.TrimSpace(sig))
+	if err != nil {
+		return fmt.Errorf("Invalid signature: %v", err)
+	}
+
+	return cipher.VerifySignature(pub, s, cipher.SumSHA256(report))
+}
+
+func parseSecretKey(s string) (cipher.SecKey, error) {
+	if s == "" {
+		return cipher.SecKey{}, errors.New("Reconcile secret key missing")
+	}
+
+	secKey, err := cipher.SecKeyFromHex(s)
+	if err != nil {
+		return cipher.SecKey{}, fmt.Errorf("Invalid reconcile secret key: %v", err)
+	}
+
+	if err := secKey.Verify(); err != nil {
+		return cipher.SecKey{}, fmt.Errorf("Invalid reconcile secret key: %v", err)
+	}
+
+	return secKey, nil
+}
+
+// parseTolerances converts the tolerances in whole coins to the smallest unit of each currency
+func parseTolerances(tolerances map[string]string) (map[string]int64, error) {
+	amounts := make(map[string]int64, len(tolerances))
+	for currency, s := range tolerances {
+		if s == "" {
+			continue
+		}
+
+		var decimals int32
+		if currency == exchange.CurrencySKY {
+			decimals = droplet.Exponent
+		} else {
+			coin, err := deposits.GetCoin(currency)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid %s tolerance: %v", currency, err)
+			}
+			decimals = coin.Decimals
+		}
+
+		d, err := decimal.NewFromString(s)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s tolerance: %v", currency, err)
+		}
+
+		if d.Sign() < 0 {
+			return nil, fmt.Errorf("%s tolerance can't be negative", currency)
+		}
+
+		amount := d.Mul(decimal.New(1, decimals))
+		if !amount.Equal(amount.Truncate(0)) {
+			return nil, fmt.Errorf("%s tolerance has more than %d decimal places", currency, decimals)
+		}
+
+		amounts[currency] = amount.IntPart()
+	}
+
+	return amounts, nil
+}
@@ -0,0 +1,360 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type dummyLedger struct {
+	balances exchange.LedgerBalances
+	drift    error
+	dis      []exchange.DepositInfo
+}
+
+func (l *dummyLedger) GetLedgerBalances() (exchange.LedgerBalances, error) {
+	return l.balances, nil
+}
+
+func (l *dummyLedger) CheckLedger() error {
+	return l.drift
+}
+
+func (l *dummyLedger) ForEachDepositInfo(flt exchange.DepositFilter, f func(exchange.DepositInfo) error) error {
+	for _, di := range l.dis {
+		if flt(di) {
+			if err := f(di); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type dummyChain map[string]int64
+
+func (c dummyChain) GetDepositTotals() (map[string]int64, error) {
+	return c, nil
+}
+
+type dummyHotWallet struct {
+	outputs map[string]uint64
+	calls   int
+}
+
+func (w *dummyHotWallet) GetTxOutput(txid, addr string) (uint64, error) {
+	w.calls++
+	coins, ok := w.outputs[txid+":"+addr]
+	if !ok {
+		return 0, errors.New("Transaction not found")
+	}
+	return coins, nil
+}
+
+func newTestConfig(t *testing.T) (Config, cipher.PubKey, func()) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	require.NoError(t, err)
+
+	pub, sec := cipher.GenerateKeyPair()
+
+	return Config{
+		Interval:  time.Hour * 24,
+		ReportDir: dir,
+		SecretKey: sec.Hex(),
+		Tolerances: map[string]string{
+			scanner.CoinTypeBTC:  "0.00000001",
+			exchange.CurrencySKY: "0",
+		},
+	}, pub, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestLedger() *dummyLedger {
+	return &dummyLedger{
+		balances: exchange.LedgerBalances{
+			scanner.CoinTypeBTC: {
+				exchange.AccountDepositsReceived: 3e8,
+				exchange.AccountConversion:       -3e8,
+			},
+			scanner.CoinTypeETH: {
+				exchange.AccountDepositsReceived: 5e9,
+				exchange.AccountConversion:       -5e9,
+			},
+			exchange.CurrencySKY: {
+				exchange.AccountConversion:   300e6,
+				exchange.AccountSkyLiability: -100e6,
+				exchange.AccountSkyPaid:      -200e6,
+			},
+		},
+		dis: []exchange.DepositInfo{
+			{
+				DepositID:  "t1:0",
+				SkyAddress: "s1",
+				Txid:       "sky1",
+				SkySent:    200e6,
+				Status:     exchange.StatusDone,
+			},
+			{
+				DepositID:  "t2:0",
+				SkyAddress: "s2",
+				Txid:       "sky2",
+				SkySent:    100e6,
+				Status:     exchange.StatusWaitConfirm,
+			},
+			{
+				DepositID:  "t3:0",
+				SkyAddress: "s3",
+				Status:     exchange.StatusWaitSend,
+			},
+		},
+	}
+}
+
+func TestParseTolerances(t *testing.T) {
+	amounts, err := parseTolerances(map[string]string{
+		scanner.CoinTypeBTC:  "0.0001",
+		scanner.CoinTypeETH:  "0.000000001",
+		exchange.CurrencySKY: "1",
+		"FOO":                "",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		scanner.CoinTypeBTC:  1e4,
+		scanner.CoinTypeETH:  1,
+		exchange.CurrencySKY: 1e6,
+	}, amounts)
+
+	_, err = parseTolerances(map[string]string{"FOO": "1"})
+	require.Error(t, err)
+
+	_, err = parseTolerances(map[string]string{scanner.CoinTypeBTC: "-1"})
+	require.Error(t, err)
+
+	_, err = parseTolerances(map[string]string{scanner.CoinTypeBTC: "1btc"})
+	require.Error(t, err)
+
+	_, err = parseTolerances(map[string]string{exchange.CurrencySKY: "0.0000001"})
+	require.Error(t, err)
+}
+
+func TestReconcile(t *testing.T) {
+	log, hook := testutil.NewLogger(t)
+
+	cfg, _, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	ledger := newTestLedger()
+	chain := dummyChain{
+		// One satoshi less than the ledger, within the tolerance
+		scanner.CoinTypeBTC: 3e8 - 1,
+		// One gwei more than the ledger, ETH has no tolerance
+		scanner.CoinTypeETH: 5e9 + 1,
+	}
+	wallet := &dummyHotWallet{
+		outputs: map[string]uint64{
+			"sky1:s1": 200e6,
+			"sky2:s2": 100e6,
+		},
+	}
+
+	r, err := NewReconciler(log, cfg, ledger, chain, wallet, nil)
+	require.NoError(t, err)
+
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	report, err := r.Reconcile(now)
+	require.NoError(t, err)
+	require.Equal(t, now, report.Time)
+	require.False(t, report.OK)
+	require.Empty(t, report.LedgerDrift)
+	require.Equal(t, []Check{
+		{
+			Name:       CheckDeposits,
+			Currency:   scanner.CoinTypeBTC,
+			Ledger:     3e8,
+			Observed:   3e8 - 1,
+			Difference: 1,
+			Tolerance:  1,
+			OK:         true,
+		},
+		{
+			Name:       CheckDeposits,
+			Currency:   scanner.CoinTypeETH,
+			Ledger:     5e9,
+			Observed:   5e9 + 1,
+			Difference: -1,
+			OK:         false,
+		},
+		{
+			Name:     CheckOutflows,
+			Currency: exchange.CurrencySKY,
+			Ledger:   300e6,
+			Observed: 300e6,
+			OK:       true,
+		},
+	}, report.Checks)
+	require.Equal(t, 2, wallet.calls)
+
+	var alerts int
+	for _, e := range hook.AllEntries() {
+		if e.Data["alert"] == "reconcile_discrepancy" {
+			alerts++
+			require.Equal(t, scanner.CoinTypeETH, e.Data["currency"])
+		}
+	}
+	require.Equal(t, 1, alerts)
+
+	// The outflow of the confirmed transaction is cached.
+	// A skycoin transaction that doesn't reach the chain is a discrepancy.
+	wallet.outputs["sky2:s2"] = 0
+	chain[scanner.CoinTypeETH] = 5e9
+	report, err = r.Reconcile(now)
+	require.NoError(t, err)
+	require.Equal(t, 3, wallet.calls)
+	require.False(t, report.OK)
+	require.Equal(t, Check{
+		Name:       CheckOutflows,
+		Currency:   exchange.CurrencySKY,
+		Ledger:     300e6,
+		Observed:   200e6,
+		Difference: 100e6,
+		OK:         false,
+	}, report.Checks[2])
+
+	// The hot wallet failing is an error, not a discrepancy
+	delete(wallet.outputs, "sky2:s2")
+	_, err = r.Reconcile(now)
+	require.Error(t, err)
+
+	// Ledger drift is reported
+	wallet.outputs["sky2:s2"] = 100e6
+	drifts := []exchange.LedgerDrift{
+		{
+			Currency: scanner.CoinTypeBTC,
+			Account:  exchange.AccountDepositsReceived,
+			Expected: 3e8,
+			Actual:   4e8,
+		},
+	}
+	ledger.drift = exchange.LedgerDriftErr{Drifts: drifts}
+	report, err = r.Reconcile(now)
+	require.NoError(t, err)
+	require.False(t, report.OK)
+	require.Equal(t, drifts, report.LedgerDrift)
+
+	ledger.drift = nil
+	report, err = r.Reconcile(now)
+	require.NoError(t, err)
+	require.True(t, report.OK)
+
+	// Checks without a source are skipped
+	r, err = NewReconciler(log, cfg, ledger, nil, nil, nil)
+	require.NoError(t, err)
+	report, err = r.Reconcile(now)
+	require.NoError(t, err)
+	require.True(t, report.OK)
+	require.Empty(t, report.Checks)
+}
+
+func TestWriteReport(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg, pub, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	r, err := NewReconciler(log, cfg, newTestLedger(), nil, nil, nil)
+	require.NoError(t, err)
+
+	path, err := r.WriteReport(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(cfg.ReportDir, "reconcile-20180102T030405Z.json"), path)
+
+	report, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	sig, err := ioutil.ReadFile(path + SigExt)
+	require.NoError(t, err)
+
+	require.NoError(t, VerifyReport(report, string(sig), pub.Hex()))
+
+	// A modified report fails verification
+	report[len(report)-2] = ' '
+	require.Error(t, VerifyReport(report, string(sig), pub.Hex()))
+
+	// A different key fails verification
+	other, _ := cipher.GenerateKeyPair()
+	report[len(report)-2] = '\n'
+	require.NoError(t, VerifyReport(report, string(sig), pub.Hex()))
+	require.Error(t, VerifyReport(report, string(sig), other.Hex()))
+}
+
+type memObjectStore map[string][]byte
+
+func (m memObjectStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m[key] = data
+	return nil
+}
+
+func TestWriteReportObject(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg, pub, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	// The report dir isn't needed
+	cfg.ReportDir = ""
+	objects := memObjectStore{}
+	r, err := NewReconciler(log, cfg, newTestLedger(), nil, nil, objects)
+	require.NoError(t, err)
+
+	key, err := r.WriteReport(time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, "reports/reconcile-20180102T030405Z.json", key)
+	require.Len(t, objects, 2)
+
+	require.NoError(t, VerifyReport(objects[key], string(objects[key+SigExt]), pub.Hex()))
+}
+
+func TestNewReconcilerInvalidConfig(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg, _, cleanup := newTestConfig(t)
+	defer cleanup()
+
+	c := cfg
+	c.Interval = 0
+	_, err := NewReconciler(log, c, nil, nil, nil, nil)
+	require.Error(t, err)
+
+	c = cfg
+	c.ReportDir = ""
+	_, err = NewReconciler(log, c, nil, nil, nil, nil)
+	require.Error(t, err)
+
+	c = cfg
+	c.SecretKey = "abc"
+	_, err = NewReconciler(log, c, nil, nil, nil, nil)
+	require.Error(t, err)
+
+	c = cfg
+	c.Tolerances = map[string]string{scanner.CoinTypeBTC: "-0.1"}
+	_, err = NewReconciler(log, c, nil, nil, nil, nil)
+	require.Error(t, err)
+}
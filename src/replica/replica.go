@@ -0,0 +1,120 @@
+// Package replica replicates the teller db to a warm standby teller.
+// The standby polls the primary's admin panel with the hashes of its replica's chunks, and the
+// primary streams back the chunks of a snapshot of the db which differ from them. The snapshot is
+// taken in a single read transaction, so once they are applied the replica is a consistent copy of
+// the db, and a failover to the standby loses at most the changes since its last sync.
+package replica
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	// TokenHeader carries the replication token of the admin API's sync endpoint
+	TokenHeader = "X-Replication-Token"
+	// MACTrailer carries the hex HMAC-SHA256 of a sync response's body, keyed by the replication token
+	MACTrailer = "X-Replication-Mac"
+	// SyncPath is the admin API's sync endpoint
+	SyncPath = "/api/replication/sync"
+	// ChunkSize is the size of the chunks of the db which are compared and sent
+	ChunkSize = 64 * 1024
+
+	// RolePrimary is the Role of the teller whose db is replicated
+	RolePrimary = "primary"
+	// RoleStandby is the Role of the teller which keeps the replica
+	RoleStandby = "standby"
+
+	// endFrame is the index of the frame which ends a sync response
+	endFrame = math.MaxUint32
+	// maxSyncRequestSize bounds a sync request, which has the hashes of a 16GB replica in its JSON
+	maxSyncRequestSize = 16 * 1024 * 1024
+)
+
+// ErrInvalidFrame is returned when a sync response has a malformed chunk frame
+var ErrInvalidFrame = errors.New("Invalid sync response frame")
+
+// SyncRequest is the standby's replica, sent to the sync endpoint as JSON
+type SyncRequest struct {
+	// TxID is the db transaction of the snapshot which the replica is a copy of, 0 if unknown
+	TxID uint64 `json:"txid"`
+	Size int64  `json:"size"`
+	// Hashes are the SHA-256 hashes of each ChunkSize chunk of the replica, the last chunk may be shorter
+	Hashes [][]byte `json:"hashes"`
+}
+
+// SyncHeader is the first line of a sync response, as JSON.
+// It is followed by a frame for each chunk of the snapshot whose hash differs from the standby's,
+// a 4 byte big endian chunk index, a 4 byte big endian length and the chunk,
+// and is ended by a frame with the index 0xFFFFFFFF and no chunk.
+type SyncHeader struct {
+	// TxID is the db transaction of the snapshot
+	TxID uint64 `json:"txid"`
+	Size int64  `json:"size"`
+}
+
+// Status is the state of the replication, at the primary or the standby
+type Status struct {
+	Role string `json:"role"`
+	// TxID is the db transaction of the primary's db, or of the standby's replica
+	TxID uint64 `json:"txid"`
+	// StandbyTxID is the db transaction of the last snapshot sent to the standby, at the primary
+	StandbyTxID uint64     `json:"standby_txid,omitempty"`
+	SyncedAt    *time.Time `json:"synced_at"`
+	// Lag is how long the db may have changed without the replica, in seconds.
+	// At the standby it is the time since its last successful sync started.
+	// At the primary it is the time since the last sync, or 0 if the db is unchanged since.
+	Lag float64 `json:"lag"`
+	// SyncBytes is the size of the chunks sent by the last sync
+	SyncBytes int64 `json:"sync_bytes"`
+	// Failures is the number of consecutive syncs which failed, at the standby
+	Failures int    `json:"failures"`
+	Error    string `json:"error,omitempty"`
+}
+
+func hashChunk(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
+
+// numChunks returns the number of chunks of a file of size bytes
+func numChunks(size int64) int {
+	return int((size + ChunkSize - 1) / ChunkSize)
+}
+
+func writeFrame(w io.Writer, index uint32, chunk []byte) error {
+	var h [8]byte
+	binary.BigEndian.PutUint32(h[:4], index)
+	binary.BigEndian.PutUint32(h[4:], uint32(len(chunk)))
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(chunk)
+	return err
+}
+
+// readFrame reads a chunk frame, which is the end frame if index is endFrame
+func readFrame(r *bufio.Reader) (uint32, []byte, error) {
+	var h [8]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return 0, nil, err
+	}
+
+	index := binary.BigEndian.Uint32(h[:4])
+	n := binary.BigEndian.Uint32(h[4:])
+	if n > ChunkSize || (index == endFrame && n != 0) {
+		return 0, nil, ErrInvalidFrame
+	}
+
+	chunk := make([]byte, n)
+	if _, err := io.ReadFull(r, chunk); err != nil {
+		return 0, nil, err
+	}
+
+	return index, chunk, nil
+}
@@ -0,0 +1,325 @@
+package replica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+var testBucket = []byte("test")
+
+func putValues(t *testing.T, db *bolt.DB, start, n int) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(testBucket)
+		if err != nil {
+			return err
+		}
+		for i := start; i < start+n; i++ {
+			if err := bkt.Put([]byte(fmt.Sprintf("key-%06d", i)), bytes.Repeat([]byte{byte(i)}, 512)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func newTestSource(t *testing.T, db *bolt.DB, token string) (*Source, *httptest.Server) {
+	log, _ := testutil.NewLogger(t)
+	src := NewSource(log, db, token)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, SyncPath, r.URL.Path)
+		src.ServeSync(w, r.WithContext(logger.WithContext(r.Context(), log)))
+	}))
+
+	return src, srv
+}
+
+func newTestStandby(t *testing.T, primary, token, path string) *Standby {
+	log, _ := testutil.NewLogger(t)
+	s, err := NewStandby(log, StandbyConfig{
+		Primary:  primary,
+		Token:    token,
+		Interval: time.Second,
+		Timeout:  time.Second * 10,
+		Path:     path,
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func requireReplica(t *testing.T, path string, n int) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  time.Second,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(testBucket)
+		require.NotNil(t, bkt)
+		require.Equal(t, n, bkt.Stats().KeyN)
+		for i := 0; i < n; i++ {
+			require.Equal(t, bytes.Repeat([]byte{byte(i)}, 512), bkt.Get([]byte(fmt.Sprintf("key-%06d", i))))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestReplicate(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	putValues(t, db, 0, 1000)
+
+	src, srv := newTestSource(t, db, "secret")
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "replica")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "teller.db")
+
+	s := newTestStandby(t, srv.URL, "secret", path)
+	ctx := context.Background()
+
+	// The first sync sends the whole db
+	require.NoError(t, s.Sync(ctx))
+
+	dbSize := func() int64 {
+		var size int64
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			size = tx.Size()
+			return nil
+		}))
+		return size
+	}
+
+	st, err := s.Status()
+	require.NoError(t, err)
+	require.Equal(t, RoleStandby, st.Role)
+	require.Equal(t, dbSize(), st.SyncBytes)
+	require.NotNil(t, st.SyncedAt)
+	require.Zero(t, st.Failures)
+
+	pst, err := src.Status()
+	require.NoError(t, err)
+	require.Equal(t, RolePrimary, pst.Role)
+	require.Equal(t, st.TxID, pst.TxID)
+	require.Equal(t, st.TxID, pst.StandbyTxID)
+	require.Zero(t, pst.Lag)
+
+	// Later syncs send the chunks which changed
+	putValues(t, db, 1000, 10)
+	require.NoError(t, s.Sync(ctx))
+
+	st, err = s.Status()
+	require.NoError(t, err)
+	require.True(t, st.SyncBytes > 0)
+	require.True(t, st.SyncBytes < dbSize())
+
+	// An unchanged db sends nothing
+	require.NoError(t, s.Sync(ctx))
+	st, err = s.Status()
+	require.NoError(t, err)
+	require.Zero(t, st.SyncBytes)
+
+	// The replica is locked while the standby runs
+	log, _ := testutil.NewLogger(t)
+	_, err = NewStandby(log, s.cfg)
+	require.Error(t, err)
+
+	go s.Run()
+	s.Shutdown()
+	requireReplica(t, path, 1010)
+
+	// A standby with the wrong token refuses the responses
+	s = newTestStandby(t, srv.URL, "wrong", path)
+	putValues(t, db, 1010, 10)
+
+	pst, err = src.Status()
+	require.NoError(t, err)
+	require.NotEqual(t, pst.TxID, pst.StandbyTxID)
+	require.True(t, pst.Lag > 0)
+
+	syncErr := s.Sync(ctx)
+	require.Error(t, syncErr)
+	require.Equal(t, "Replication sync response MAC is invalid", syncErr.Error())
+
+	st, err = s.Status()
+	require.NoError(t, err)
+	require.Equal(t, 1, st.Failures)
+	require.Equal(t, syncErr.Error(), st.Error)
+	require.Nil(t, st.SyncedAt)
+
+	go s.Run()
+	s.Shutdown()
+	requireReplica(t, path, 1010)
+
+	_, err = os.Stat(path + journalExt)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRecover(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	putValues(t, db, 0, 100)
+
+	_, srv := newTestSource(t, db, "secret")
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "replica")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "teller.db")
+
+	// Nothing to recover
+	require.NoError(t, Recover(path))
+
+	// A journaled sync which was not applied
+	b, err := json.Marshal(SyncRequest{})
+	require.NoError(t, err)
+	rsp, err := http.Post(srv.URL+SyncPath, "application/json", bytes.NewReader(b))
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+
+	journal, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path+journalExt, journal, 0600))
+	require.NoError(t, ioutil.WriteFile(path, nil, 0600))
+
+	require.NoError(t, Recover(path))
+	requireReplica(t, path, 100)
+
+	_, err = os.Stat(path + journalExt)
+	require.True(t, os.IsNotExist(err))
+
+	st, err := loadState(path)
+	require.NoError(t, err)
+	require.NotZero(t, st.TxID)
+}
+
+func TestServeSyncInvalid(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	_, srv := newTestSource(t, db, "secret")
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + SyncPath)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, rsp.StatusCode)
+
+	rsp, err = http.Post(srv.URL+SyncPath, "application/json", bytes.NewReader([]byte("not json")))
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+}
+
+// stalledWriter is the response of a standby which stops reading, until released
+type stalledWriter struct {
+	header  http.Header
+	writing chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (w *stalledWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *stalledWriter) WriteHeader(int) {}
+
+func (w *stalledWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.writing)
+	})
+	<-w.release
+	return len(p), nil
+}
+
+func TestServeSyncStalledStandby(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	putValues(t, db, 0, 100)
+
+	log, _ := testutil.NewLogger(t)
+	src := NewSource(log, db, "secret")
+
+	w := &stalledWriter{
+		header:  make(http.Header),
+		writing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	req := httptest.NewRequest(http.MethodPost, SyncPath, bytes.NewReader([]byte("{}")))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		src.ServeSync(w, req)
+	}()
+
+	<-w.writing
+
+	// The db grows, which remaps it, while the response is stalled
+	grown := make(chan struct{})
+	go func() {
+		defer close(grown)
+		putValues(t, db, 100, 10000)
+	}()
+
+	select {
+	case <-grown:
+	case <-time.After(time.Second * 10):
+		t.Fatal("the stalled sync blocked the db from growing")
+	}
+
+	close(w.release)
+	<-done
+}
+
+func TestStandbyConfigValidate(t *testing.T) {
+	cfg := StandbyConfig{
+		Primary:  "http://127.0.0.1:7711",
+		Token:    "secret",
+		Interval: time.Second,
+		Timeout:  time.Minute,
+		Path:     "teller.db",
+	}
+	require.NoError(t, cfg.Validate())
+
+	c := cfg
+	c.Primary = "127.0.0.1:7711"
+	require.Error(t, c.Validate())
+
+	c = cfg
+	c.Token = ""
+	require.Error(t, c.Validate())
+
+	c = cfg
+	c.Interval = 0
+	require.Error(t, c.Validate())
+}
@@ -0,0 +1,250 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// Source serves the snapshots of the primary's db to the standby
+type Source struct {
+	log   logrus.FieldLogger
+	db    *bolt.DB
+	token string
+
+	sync.Mutex
+	// db transaction of the last snapshot sent to the standby, and when
+	sentTxID  uint64
+	syncedAt  time.Time
+	syncBytes int64
+}
+
+// NewSource creates a Source. The token keys the MAC of the sync responses.
+func NewSource(log logrus.FieldLogger, db *bolt.DB, token string) *Source {
+	return &Source{
+		log:   log.WithField("prefix", "teller.replica"),
+		db:    db,
+		token: token,
+	}
+}
+
+// ServeSync streams the chunks of a snapshot of the db which differ from the standby's replica.
+// The chunks are collected into a temp file next to the db within the read transaction, and streamed once it
+// is closed, so that a slow standby doesn't hold the transaction, which would block the db from growing.
+// The caller checks the request's replication token.
+// Method: POST
+// Request: SyncRequest
+// Response: SyncHeader, followed by the chunk frames, with the MACTrailer
+func (s *Source) ServeSync(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.FromContext(ctx)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SyncRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSyncRequestSize)).Decode(&req); err != nil {
+		httputil.ErrResponse(w, http.StatusBadRequest, "Invalid sync request")
+		return
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.db.Path()), "teller-sync-")
+	if err != nil {
+		log.WithError(err).Error("Create the sync's temp file failed")
+		httputil.ErrResponse(w, http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		f.Close()           // nolint: errcheck
+		os.Remove(f.Name()) // nolint: errcheck
+	}()
+
+	fw := bufio.NewWriterSize(f, ChunkSize)
+	cw := &chunkWriter{
+		w:      fw,
+		hashes: req.Hashes,
+		buf:    make([]byte, 0, ChunkSize),
+	}
+
+	var h SyncHeader
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		h = SyncHeader{
+			TxID: uint64(tx.ID()),
+			Size: tx.Size(),
+		}
+
+		// No chunks are sent if the replica is a copy of this snapshot already
+		if h.TxID == req.TxID && h.Size == req.Size {
+			return nil
+		}
+
+		if _, err := tx.WriteTo(cw); err != nil {
+			return err
+		}
+		return cw.flush()
+	}); err != nil {
+		log.WithError(err).Error("Read db snapshot failed")
+		httputil.ErrResponse(w, http.StatusInternalServerError)
+		return
+	}
+
+	if err := fw.Flush(); err != nil {
+		log.WithError(err).Error("Write the sync's temp file failed")
+		httputil.ErrResponse(w, http.StatusInternalServerError)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		log.WithError(err).Error("Seek the sync's temp file failed")
+		httputil.ErrResponse(w, http.StatusInternalServerError)
+		return
+	}
+
+	// The first sync of a large db outlasts the admin panel's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.WithError(err).Warn("SetWriteDeadline failed, the sync is closed at the server write timeout")
+	}
+
+	if err := s.writeSync(w, h, f); err != nil {
+		// The response is cut short without its MAC, which the standby refuses
+		log.WithError(err).Error("Send db snapshot to the standby failed")
+		return
+	}
+
+	s.Lock()
+	s.sentTxID = h.TxID
+	s.syncedAt = time.Now()
+	s.syncBytes = cw.sent
+	s.Unlock()
+
+	log.WithFields(logrus.Fields{
+		"txid":        h.TxID,
+		"standbyTxID": req.TxID,
+		"sent":        cw.sent,
+	}).Debug("Sent db snapshot to the standby")
+}
+
+// writeSync writes the sync response of the snapshot h, whose chunk frames are read from frames
+func (s *Source) writeSync(w http.ResponseWriter, h SyncHeader, frames io.Reader) error {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Trailer", MACTrailer)
+	w.WriteHeader(http.StatusOK)
+
+	mac := hmac.New(sha256.New, []byte(s.token))
+	bw := bufio.NewWriterSize(io.MultiWriter(w, mac), ChunkSize)
+
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if _, err := bw.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(bw, frames); err != nil {
+		return err
+	}
+
+	if err := writeFrame(bw, endFrame, nil); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	w.Header().Set(MACTrailer, hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// Status returns the state of the replication to the standby
+func (s *Source) Status() (Status, error) {
+	var txid uint64
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		txid = uint64(tx.ID())
+		return nil
+	}); err != nil {
+		return Status{}, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	st := Status{
+		Role:        RolePrimary,
+		TxID:        txid,
+		StandbyTxID: s.sentTxID,
+		SyncBytes:   s.syncBytes,
+	}
+
+	if !s.syncedAt.IsZero() {
+		t := s.syncedAt
+		st.SyncedAt = &t
+		if txid != s.sentTxID {
+			st.Lag = time.Since(t).Seconds()
+		}
+	}
+
+	return st, nil
+}
+
+// chunkWriter writes the frames of the chunks of a snapshot whose hash differs from hashes
+type chunkWriter struct {
+	w      io.Writer
+	hashes [][]byte
+	buf    []byte
+	index  uint32
+	sent   int64
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := copy(c.buf[len(c.buf):cap(c.buf)], p)
+		c.buf = c.buf[:len(c.buf)+k]
+		p = p[k:]
+
+		if len(c.buf) == ChunkSize {
+			if err := c.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush writes the buffered chunk's frame if it differs, and starts the next chunk
+func (c *chunkWriter) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+
+	if int(c.index) >= len(c.hashes) || !bytes.Equal(c.hashes[c.index], hashChunk(c.buf)) {
+		if err := writeFrame(c.w, c.index, c.buf); err != nil {
+			return err
+		}
+		c.sent += int64(len(c.buf))
+	}
+
+	c.index++
+	c.buf = c.buf[:0]
+	return nil
+}
@@ -0,0 +1,498 @@
+package replica
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	stateExt   = ".state"
+	journalExt = ".journal"
+	tmpExt     = ".tmp"
+)
+
+// StandbyConfig configures the Standby
+type StandbyConfig struct {
+	// Primary is the URL of the primary's admin panel
+	Primary string
+	// Token authenticates the standby to the primary, and keys the MAC of its responses
+	Token string
+	// How often to sync the replica
+	Interval time.Duration
+	// Timeout of a sync, which must fit the first sync of the whole db
+	Timeout time.Duration
+	// Path of the replica
+	Path string
+}
+
+// Validate returns an error if the configuration is invalid
+func (c StandbyConfig) Validate() error {
+	u, err := url.Parse(c.Primary)
+	if err != nil {
+		return fmt.Errorf("Replication primary invalid: %v", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return errors.New("Replication primary must be an absolute http or https URL")
+	}
+
+	if c.Token == "" {
+		return errors.New("Replication token missing")
+	}
+
+	if c.Interval <= 0 {
+		return errors.New("Replication interval must be > 0")
+	}
+
+	if c.Timeout <= 0 {
+		return errors.New("Replication timeout must be > 0")
+	}
+
+	if c.Path == "" {
+		return errors.New("Replica path missing")
+	}
+
+	return nil
+}
+
+// state is the snapshot which the replica is a copy of, saved beside it
+type state struct {
+	TxID uint64 `json:"txid"`
+	Size int64  `json:"size"`
+}
+
+// Standby keeps a replica of the primary's db, synced every interval.
+// It holds an exclusive lock on the replica, so teller can't open it while the Standby runs.
+// A sync is written to a journal beside the replica once its MAC is verified, then applied,
+// so a replica left half applied by a crash is completed by Recover or the next NewStandby.
+type Standby struct {
+	log    logrus.FieldLogger
+	cfg    StandbyConfig
+	client *http.Client
+	f      *os.File
+	quit   chan struct{}
+	done   chan struct{}
+	// cancel aborts a sync in progress when Shutdown is called
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	sync.Mutex
+	state  state
+	hashes [][]byte
+	// syncedAt is when the last successful sync started
+	syncedAt  time.Time
+	syncBytes int64
+	failures  int
+	err       error
+}
+
+// NewStandby creates a Standby, locks its replica and completes a sync left half applied
+func NewStandby(log logrus.FieldLogger, cfg StandbyConfig) (*Standby, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(cfg.Path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Lock replica %s failed, is teller running on it? %v", cfg.Path, err)
+	}
+
+	if err := recoverJournal(f, cfg.Path); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	st, err := loadState(cfg.Path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	hashes, err := loadHashes(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Standby{
+		log:    log.WithField("prefix", "teller.replica"),
+		cfg:    cfg,
+		client: httpclient.New(cfg.Timeout),
+		f:      f,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		state:  st,
+		hashes: hashes,
+	}, nil
+}
+
+// Run syncs the replica once at startup, then every interval until Shutdown is called
+func (s *Standby) Run() error {
+	log := s.log.WithField("primary", s.cfg.Primary).WithField("interval", s.cfg.Interval)
+	log.Info("Start replication standby")
+	defer log.Info("Replication standby closed")
+	defer close(s.done)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return nil
+		case <-timer.C:
+			if err := s.Sync(s.ctx); err != nil && s.ctx.Err() == nil {
+				log.WithField("alert", "replication_sync").WithError(err).Error("ALERT: replication sync failed")
+			}
+			timer.Reset(s.cfg.Interval)
+		}
+	}
+}
+
+// Shutdown stops the Standby, aborting a sync in progress, and unlocks the replica.
+// A sync which is being applied is completed first.
+func (s *Standby) Shutdown() {
+	close(s.quit)
+	s.cancel()
+	<-s.done
+
+	if err := s.f.Close(); err != nil {
+		s.log.WithError(err).Error("Close replica failed")
+	}
+}
+
+// Sync fetches the chunks of the primary's db which differ from the replica, and applies them
+func (s *Standby) Sync(ctx context.Context) error {
+	start := time.Now()
+
+	n, err := s.sync(ctx)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.err = err
+	if err != nil {
+		s.failures++
+		return err
+	}
+
+	s.failures = 0
+	s.syncedAt = start
+	s.syncBytes = n
+
+	s.log.WithFields(logrus.Fields{
+		"txid":     s.state.TxID,
+		"received": n,
+		"duration": time.Since(start),
+	}).Debug("Synced replica")
+
+	return nil
+}
+
+func (s *Standby) sync(ctx context.Context) (int64, error) {
+	s.Lock()
+	b, err := json.Marshal(SyncRequest{
+		TxID:   s.state.TxID,
+		Size:   s.state.Size,
+		Hashes: s.hashes,
+	})
+	s.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.cfg.Primary+SyncPath, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TokenHeader, s.cfg.Token)
+
+	rsp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Replication sync returned status %d", rsp.StatusCode)
+	}
+
+	// The response is journaled, only once its MAC is verified is it applied
+	journal := s.cfg.Path + journalExt
+	tmp := journal + tmpExt
+
+	jf, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	defer jf.Close()
+
+	mac := hmac.New(sha256.New, []byte(s.cfg.Token))
+	if _, err := io.Copy(io.MultiWriter(jf, mac), rsp.Body); err != nil {
+		return 0, err
+	}
+
+	want, err := hex.DecodeString(rsp.Trailer.Get(MACTrailer))
+	if err != nil || !hmac.Equal(want, mac.Sum(nil)) {
+		return 0, errors.New("Replication sync response MAC is invalid")
+	}
+
+	if err := jf.Sync(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, journal); err != nil {
+		return 0, err
+	}
+
+	if _, err := jf.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	n, err := s.apply(jf)
+	if err != nil {
+		// The replica may be half applied, so its chunks are hashed again and the next sync compares them all
+		s.state = state{}
+		if hashes, herr := loadHashes(s.f); herr == nil {
+			s.hashes = hashes
+		}
+		return 0, err
+	}
+
+	return n, os.Remove(journal)
+}
+
+// apply writes the journaled sync response r to the replica, and returns the size of its chunks
+func (s *Standby) apply(r io.Reader) (int64, error) {
+	st, changed, n, err := applyJournal(s.f, r)
+	if err != nil {
+		return 0, err
+	}
+
+	hashes := s.hashes
+	if k := numChunks(st.Size); k < len(hashes) {
+		hashes = hashes[:k]
+	}
+	for i, h := range changed {
+		for int(i) >= len(hashes) {
+			hashes = append(hashes, nil)
+		}
+		hashes[i] = h
+	}
+
+	if err := saveState(s.cfg.Path, st); err != nil {
+		return 0, err
+	}
+
+	s.state = st
+	s.hashes = hashes
+
+	return n, nil
+}
+
+// Status returns the state of the replica
+func (s *Standby) Status() (Status, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	st := Status{
+		Role:      RoleStandby,
+		TxID:      s.state.TxID,
+		SyncBytes: s.syncBytes,
+		Failures:  s.failures,
+	}
+
+	if !s.syncedAt.IsZero() {
+		t := s.syncedAt
+		st.SyncedAt = &t
+		st.Lag = time.Since(t).Seconds()
+	}
+
+	if s.err != nil {
+		st.Error = s.err.Error()
+	}
+
+	return st, nil
+}
+
+// Recover completes a sync left half applied to the replica at path, so that teller can open it.
+// It is called before a standby's replica is opened by teller after a failover.
+func Recover(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	return recoverJournal(f, path)
+}
+
+// recoverJournal applies the journal of the replica f at path, if there is one
+func recoverJournal(f *os.File, path string) error {
+	journal := path + journalExt
+
+	jf, err := os.Open(journal)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer jf.Close()
+
+	st, _, _, err := applyJournal(f, jf)
+	if err != nil {
+		return fmt.Errorf("Apply replication journal %s failed: %v", journal, err)
+	}
+
+	if err := saveState(path, st); err != nil {
+		return err
+	}
+
+	return os.Remove(journal)
+}
+
+// applyJournal writes the chunks of a sync response to the replica f and syncs it.
+// It returns the snapshot of the response, the hashes of the chunks written by index and their size.
+// Applying a journal again is harmless.
+func applyJournal(f *os.File, r io.Reader) (state, map[uint32][]byte, int64, error) {
+	br := bufio.NewReaderSize(r, ChunkSize)
+
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return state{}, nil, 0, err
+	}
+
+	var h SyncHeader
+	if err := json.Unmarshal(line, &h); err != nil {
+		return state{}, nil, 0, fmt.Errorf("Invalid sync response header: %v", err)
+	}
+	if h.Size < 0 {
+		return state{}, nil, 0, ErrInvalidFrame
+	}
+
+	changed := make(map[uint32][]byte)
+	var n int64
+	for {
+		index, chunk, err := readFrame(br)
+		if err != nil {
+			return state{}, nil, 0, err
+		}
+		if index == endFrame {
+			break
+		}
+
+		off := int64(index) * ChunkSize
+		if off+int64(len(chunk)) > h.Size {
+			return state{}, nil, 0, ErrInvalidFrame
+		}
+
+		if _, err := f.WriteAt(chunk, off); err != nil {
+			return state{}, nil, 0, err
+		}
+
+		changed[index] = hashChunk(chunk)
+		n += int64(len(chunk))
+	}
+
+	if err := f.Truncate(h.Size); err != nil {
+		return state{}, nil, 0, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return state{}, nil, 0, err
+	}
+
+	return state{
+		TxID: h.TxID,
+		Size: h.Size,
+	}, changed, n, nil
+}
+
+// loadState loads the state of the replica at path, which is empty if the replica was never synced
+func loadState(path string) (state, error) {
+	b, err := ioutil.ReadFile(path + stateExt)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state{}, nil
+		}
+		return state{}, err
+	}
+
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return state{}, fmt.Errorf("Invalid replica state %s: %v", path+stateExt, err)
+	}
+
+	return st, nil
+}
+
+// saveState saves the state of the replica at path, through a rename
+func saveState(path string, st state) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + stateExt + tmpExt
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path+stateExt)
+}
+
+// loadHashes hashes each chunk of the replica
+func loadHashes(f *os.File) ([][]byte, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([][]byte, 0, numChunks(fi.Size()))
+	buf := make([]byte, ChunkSize)
+	for off := int64(0); off < fi.Size(); off += ChunkSize {
+		k, err := f.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		hashes = append(hashes, hashChunk(buf[:k]))
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,94 @@
+package runbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	pagerTimeout = time.Second * 30
+	// pagerResponseLimit bounds how much of an error response is read
+	pagerResponseLimit = 4096
+)
+
+// Page is the page of a rule which triggered or was resolved
+type Page struct {
+	// DedupKey is the same for the pages of a rule, so that the pager can resolve the page of its trigger
+	DedupKey  string `json:"dedup_key"`
+	Rule      string `json:"rule"`
+	Condition string `json:"condition"`
+	// PageTriggered or PageResolved
+	Status  string `json:"status"`
+	Summary string `json:"summary"`
+	Time    int64  `json:"time"`
+}
+
+func newPage(r Rule, status, summary string, now time.Time) Page {
+	return Page{
+		DedupKey:  "teller-" + r.Name,
+		Rule:      r.Name,
+		Condition: r.Condition,
+		Status:    status,
+		Summary:   summary,
+		Time:      now.Unix(),
+	}
+}
+
+// Pager pages the operator
+type Pager interface {
+	Page(Page) error
+}
+
+// WebhookPager posts the pages as JSON to a webhook, e.g. of an incident management service's integration
+type WebhookPager struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewWebhookPager creates a WebhookPager. The requests have a bearer token if token is set.
+func NewWebhookPager(url, token string) *WebhookPager {
+	return &WebhookPager{
+		url:    url,
+		token:  token,
+		client: httpclient.New(pagerTimeout),
+	}
+}
+
+// Page posts the page, and returns an error unless the webhook responds with a 2xx status
+func (p *WebhookPager) Page(page Page) error {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(rsp.Body, pagerResponseLimit))
+		return fmt.Errorf("Pager webhook returned status %d: %s", rsp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
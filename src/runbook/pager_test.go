@@ -0,0 +1,49 @@
+package runbook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPager(t *testing.T) {
+	var got Page
+	var auth string
+	status := http.StatusAccepted
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		auth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+		w.Write([]byte("rejected\n")) // nolint: errcheck
+	}))
+	defer srv.Close()
+
+	page := Page{
+		DedupKey:  "teller-sky_down",
+		Rule:      "sky_down",
+		Condition: ConditionNodeDown,
+		Status:    PageTriggered,
+		Summary:   "sky node is down",
+		Time:      1519905600,
+	}
+
+	p := NewWebhookPager(srv.URL, "")
+	require.NoError(t, p.Page(page))
+	require.Equal(t, page, got)
+	require.Empty(t, auth)
+
+	p = NewWebhookPager(srv.URL, "token")
+	require.NoError(t, p.Page(page))
+	require.Equal(t, "Bearer token", auth)
+
+	status = http.StatusBadRequest
+	err := p.Page(page)
+	require.Error(t, err)
+	require.Equal(t, "Pager webhook returned status 400: rejected", err.Error())
+}
@@ -0,0 +1,472 @@
+// Package runbook automates the operators' responses to alert conditions. A rule has a condition, e.g. the skycoin node
+// being down for 5 minutes, and the actions which run when the condition becomes true, e.g. switching to a fallback
+// node and paging the operator, so that the usual fixes don't wait for someone to wake up.
+//
+// The conditions are checked every interval. A rule triggers once when its condition becomes true, and is resolved
+// when the condition is false again. The actions are not undone when it is resolved: a paused subsystem stays paused
+// and a node stays switched to its fallback until an operator undoes it, or teller restarts.
+package runbook
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/pauseutil"
+)
+
+const (
+	// ConditionNodeDown is true while a node has been down for Rule.For
+	ConditionNodeDown = "node_down"
+	// ConditionPoolLow is true while a deposit address pool has fewer than Rule.Below addresses left
+	ConditionPoolLow = "pool_low"
+	// ConditionSendFailures is true while the deposits failed to send Rule.Streak times in a row
+	ConditionSendFailures = "send_failures"
+
+	// ActionPause pauses Action.Subsystem
+	ActionPause = "pause"
+	// ActionFallbackNode switches Action.Node to its fallback
+	ActionFallbackNode = "fallback_node"
+	// ActionPage pages the operator
+	ActionPage = "page"
+
+	// NodeSky is the skycoin node which sends the payouts
+	NodeSky = "sky"
+	// NodeBTC is btcd
+	NodeBTC = "btc"
+
+	// PageTriggered is the status of the page sent when a rule triggers
+	PageTriggered = "triggered"
+	// PageResolved is the status of the page sent when a rule is resolved
+	PageResolved = "resolved"
+
+	defaultInterval = time.Second * 30
+)
+
+// ErrNoPager is returned by the page action if there is no Pager
+var ErrNoPager = errors.New("No pager configured")
+
+// Action is what a rule does when it triggers
+type Action struct {
+	// ActionPause, ActionFallbackNode or ActionPage
+	Type string
+	// Subsystem paused by ActionPause
+	Subsystem string
+	// Node switched by ActionFallbackNode
+	Node string
+}
+
+// target returns what the action acts on, for the logs and statuses
+func (a Action) target() string {
+	switch a.Type {
+	case ActionPause:
+		return a.Subsystem
+	case ActionFallbackNode:
+		return a.Node
+	default:
+		return ""
+	}
+}
+
+// Rule is a condition, and the actions which run when it becomes true
+type Rule struct {
+	Name string
+	// ConditionNodeDown, ConditionPoolLow or ConditionSendFailures
+	Condition string
+	// Node and how long it has been down, for ConditionNodeDown
+	Node string
+	For  time.Duration
+	// Coin type and remaining addresses, for ConditionPoolLow
+	CoinType string
+	Below    uint64
+	// Consecutive send failures, for ConditionSendFailures
+	Streak  int
+	Actions []Action
+}
+
+// Config configures the Runbook
+type Config struct {
+	// Interval is how often the conditions are checked
+	Interval time.Duration
+	// DryRun logs the actions which would run, without running them
+	DryRun bool
+	Rules  []Rule
+	// Fallbacks are the addresses which ActionFallbackNode switches the nodes to, by node
+	Fallbacks map[string]string
+}
+
+// Node is a node whose connection the runbook watches, and which it switches to a fallback
+type Node interface {
+	// DownSince returns when the node's requests began to fail, zero if the last request succeeded
+	DownSince() time.Time
+	// SwitchNode uses the node at addr from now on
+	SwitchNode(addr string) error
+}
+
+// PoolCounter returns the remaining addresses of a coin type's deposit address pool
+type PoolCounter interface {
+	Remaining(coinType string) (uint64, error)
+}
+
+// SendFailureCounter returns the consecutive failures to send the deposits
+type SendFailureCounter interface {
+	SendFailures() int
+}
+
+// Subsystems pauses teller's subsystems by name
+type Subsystems interface {
+	Statuses() []pauseutil.Status
+	Pause(name string) (pauseutil.Status, error)
+}
+
+// ActionResult is the result of an action of a rule's last trigger
+type ActionResult struct {
+	Type   string `json:"type"`
+	Target string `json:"target,omitempty"`
+	At     int64  `json:"at"`
+	// DryRun is true if the action was only logged
+	DryRun bool   `json:"dry_run,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RuleStatus is the state of a rule
+type RuleStatus struct {
+	Name      string `json:"name"`
+	Condition string `json:"condition"`
+	Triggered bool   `json:"triggered"`
+	// When the rule last triggered, and the results of its actions
+	TriggeredAt int64          `json:"triggered_at,omitempty"`
+	Actions     []ActionResult `json:"actions"`
+	// Summary of the condition at the last check
+	Summary   string `json:"summary"`
+	CheckedAt int64  `json:"checked_at,omitempty"`
+	// Error of the last check, e.g. if the pool couldn't be read. The rule is unchanged by a failed check.
+	Error string `json:"error,omitempty"`
+}
+
+// Status is the state of the runbook's rules
+type Status struct {
+	DryRun bool         `json:"dry_run"`
+	Rules  []RuleStatus `json:"rules"`
+}
+
+// Runbook checks the rules' conditions, and runs their actions when they trigger
+type Runbook struct {
+	log        logrus.FieldLogger
+	cfg        Config
+	nodes      map[string]Node
+	pools      PoolCounter
+	sends      SendFailureCounter
+	subsystems Subsystems
+	pager      Pager
+
+	mu       sync.Mutex
+	statuses []RuleStatus
+	// switched are the nodes which were switched to their fallbacks
+	switched map[string]bool
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// New creates a Runbook. nodes are the nodes which are connected to, by name. It returns an error if a rule refers
+// to a node, subsystem or pager which teller doesn't have, e.g. a btc node while BTC is disabled.
+func New(log logrus.FieldLogger, cfg Config, nodes map[string]Node, pools PoolCounter, sends SendFailureCounter, subsystems Subsystems, pager Pager) (*Runbook, error) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+
+	names := make(map[string]struct{})
+	for _, s := range subsystems.Statuses() {
+		names[s.Name] = struct{}{}
+	}
+
+	statuses := make([]RuleStatus, len(cfg.Rules))
+	for i, r := range cfg.Rules {
+		switch r.Condition {
+		case ConditionNodeDown:
+			if _, ok := nodes[r.Node]; !ok {
+				return nil, fmt.Errorf("Rule %s watches the %s node, which is not connected to", r.Name, r.Node)
+			}
+		case ConditionPoolLow, ConditionSendFailures:
+		default:
+			return nil, fmt.Errorf("Rule %s has an invalid condition %q", r.Name, r.Condition)
+		}
+
+		for _, a := range r.Actions {
+			switch a.Type {
+			case ActionPause:
+				if _, ok := names[a.Subsystem]; !ok {
+					return nil, fmt.Errorf("Rule %s pauses the unknown subsystem %q", r.Name, a.Subsystem)
+				}
+			case ActionFallbackNode:
+				if _, ok := nodes[a.Node]; !ok {
+					return nil, fmt.Errorf("Rule %s switches the %s node, which is not connected to", r.Name, a.Node)
+				}
+				if cfg.Fallbacks[a.Node] == "" {
+					return nil, fmt.Errorf("Rule %s switches the %s node, which has no fallback", r.Name, a.Node)
+				}
+			case ActionPage:
+				if pager == nil {
+					return nil, fmt.Errorf("Rule %s pages, but there is no pager", r.Name)
+				}
+			default:
+				return nil, fmt.Errorf("Rule %s has an invalid action %q", r.Name, a.Type)
+			}
+		}
+
+		statuses[i] = RuleStatus{
+			Name:      r.Name,
+			Condition: r.Condition,
+			Actions:   []ActionResult{},
+		}
+	}
+
+	return &Runbook{
+		log:        log.WithField("prefix", "teller.runbook"),
+		cfg:        cfg,
+		nodes:      nodes,
+		pools:      pools,
+		sends:      sends,
+		subsystems: subsystems,
+		pager:      pager,
+		statuses:   statuses,
+		switched:   make(map[string]bool),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Run checks the rules at startup, then every Interval until Shutdown is called
+func (b *Runbook) Run() error {
+	log := b.log.WithFields(logrus.Fields{
+		"rules":    len(b.cfg.Rules),
+		"interval": b.cfg.Interval,
+		"dryRun":   b.cfg.DryRun,
+	})
+	log.Info("Start runbook")
+	defer log.Info("Runbook closed")
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		b.Check(time.Now())
+
+		select {
+		case <-b.quit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the Runbook
+func (b *Runbook) Shutdown() {
+	close(b.quit)
+	<-b.done
+}
+
+// Status returns the state of the rules
+func (b *Runbook) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rules := make([]RuleStatus, len(b.statuses))
+	for i, s := range b.statuses {
+		s.Actions = append([]ActionResult{}, s.Actions...)
+		rules[i] = s
+	}
+
+	return Status{
+		DryRun: b.cfg.DryRun,
+		Rules:  rules,
+	}
+}
+
+// Check checks each rule's condition at now, and runs the actions of the rules which trigger
+func (b *Runbook) Check(now time.Time) {
+	for i, r := range b.cfg.Rules {
+		log := b.log.WithFields(logrus.Fields{
+			"rule":      r.Name,
+			"condition": r.Condition,
+		})
+
+		triggered, summary, err := b.evaluate(r, now)
+
+		b.mu.Lock()
+		s := b.statuses[i]
+		b.mu.Unlock()
+
+		s.CheckedAt = now.Unix()
+
+		if err != nil {
+			log.WithError(err).Error("Check runbook condition failed")
+			s.Error = err.Error()
+			b.setStatus(i, s)
+			continue
+		}
+
+		s.Error = ""
+		s.Summary = summary
+		log = log.WithField("summary", summary)
+
+		switch {
+		case triggered && !s.Triggered:
+			log.WithField("alert", "runbook").Error("ALERT: runbook rule triggered, running its actions")
+			s.Triggered = true
+			s.TriggeredAt = now.Unix()
+			s.Actions = b.runActions(log, r, summary, now)
+		case !triggered && s.Triggered:
+			log.Info("Runbook rule resolved")
+			s.Triggered = false
+			b.pageResolved(log, r, summary, now)
+		}
+
+		b.setStatus(i, s)
+	}
+}
+
+func (b *Runbook) setStatus(i int, s RuleStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.statuses[i] = s
+}
+
+// evaluate returns whether the rule's condition is true at now, and a summary of it
+func (b *Runbook) evaluate(r Rule, now time.Time) (bool, string, error) {
+	switch r.Condition {
+	case ConditionNodeDown:
+		downSince := b.nodes[r.Node].DownSince()
+		if downSince.IsZero() {
+			return false, fmt.Sprintf("%s node is up", r.Node), nil
+		}
+		down := now.Sub(downSince)
+		summary := fmt.Sprintf("%s node is down since %s, for %s", r.Node, downSince.UTC().Format(time.RFC3339), down.Truncate(time.Second))
+		return down >= r.For, summary, nil
+
+	case ConditionPoolLow:
+		remaining, err := b.pools.Remaining(r.CoinType)
+		if err != nil {
+			return false, "", err
+		}
+		summary := fmt.Sprintf("%s deposit address pool has %d addresses left, the minimum is %d", r.CoinType, remaining, r.Below)
+		return remaining < r.Below, summary, nil
+
+	case ConditionSendFailures:
+		failures := b.sends.SendFailures()
+		summary := fmt.Sprintf("%d consecutive send failures, the limit is %d", failures, r.Streak)
+		return failures >= r.Streak, summary, nil
+
+	default:
+		return false, "", fmt.Errorf("Invalid condition %q", r.Condition)
+	}
+}
+
+// runActions runs the actions of a rule which triggered, in order. An action which fails doesn't stop the next,
+// so that the operator is paged even if the fallback node couldn't be switched to.
+func (b *Runbook) runActions(log logrus.FieldLogger, r Rule, summary string, now time.Time) []ActionResult {
+	results := make([]ActionResult, 0, len(r.Actions))
+
+	for _, a := range r.Actions {
+		res := ActionResult{
+			Type:   a.Type,
+			Target: a.target(),
+			At:     now.Unix(),
+			DryRun: b.cfg.DryRun,
+		}
+
+		log := log.WithFields(logrus.Fields{
+			"action": a.Type,
+			"target": res.Target,
+		})
+
+		if b.cfg.DryRun {
+			log.Warn("Runbook dry run, not running the action")
+			results = append(results, res)
+			continue
+		}
+
+		detail, err := b.runAction(a, r, summary, now)
+		res.Detail = detail
+		if err != nil {
+			log.WithError(err).Error("Runbook action failed")
+			res.Error = err.Error()
+		} else {
+			log.WithField("detail", detail).Warn("Runbook action ran")
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+func (b *Runbook) runAction(a Action, r Rule, summary string, now time.Time) (string, error) {
+	switch a.Type {
+	case ActionPause:
+		s, err := b.subsystems.Pause(a.Subsystem)
+		if err != nil {
+			return "", err
+		}
+		if s.Busy {
+			return "paused, finishing the work in progress", nil
+		}
+		return "paused", nil
+
+	case ActionFallbackNode:
+		b.mu.Lock()
+		switched := b.switched[a.Node]
+		b.mu.Unlock()
+
+		fallback := b.cfg.Fallbacks[a.Node]
+		if switched {
+			return "already switched to " + fallback, nil
+		}
+
+		if err := b.nodes[a.Node].SwitchNode(fallback); err != nil {
+			return "", err
+		}
+
+		b.mu.Lock()
+		b.switched[a.Node] = true
+		b.mu.Unlock()
+
+		return "switched to " + fallback, nil
+
+	case ActionPage:
+		if b.pager == nil {
+			return "", ErrNoPager
+		}
+		if err := b.pager.Page(newPage(r, PageTriggered, summary, now)); err != nil {
+			return "", err
+		}
+		return "paged", nil
+
+	default:
+		return "", fmt.Errorf("Invalid action %q", a.Type)
+	}
+}
+
+// pageResolved pages that a rule is resolved, if it pages when it triggers
+func (b *Runbook) pageResolved(log logrus.FieldLogger, r Rule, summary string, now time.Time) {
+	if b.cfg.DryRun || b.pager == nil {
+		return
+	}
+
+	for _, a := range r.Actions {
+		if a.Type != ActionPage {
+			continue
+		}
+
+		if err := b.pager.Page(newPage(r, PageResolved, summary, now)); err != nil {
+			log.WithError(err).Error("Page runbook rule resolved failed")
+		}
+		return
+	}
+}
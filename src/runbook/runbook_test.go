@@ -0,0 +1,362 @@
+package runbook
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/pauseutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type dummyNode struct {
+	sync.Mutex
+	downSince time.Time
+	switched  []string
+	err       error
+}
+
+func (n *dummyNode) DownSince() time.Time {
+	n.Lock()
+	defer n.Unlock()
+	return n.downSince
+}
+
+func (n *dummyNode) SwitchNode(addr string) error {
+	n.Lock()
+	defer n.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.switched = append(n.switched, addr)
+	return nil
+}
+
+type dummyPools map[string]uint64
+
+func (p dummyPools) Remaining(coinType string) (uint64, error) {
+	n, ok := p[coinType]
+	if !ok {
+		return 0, errors.New("no pool")
+	}
+	return n, nil
+}
+
+type dummySends int
+
+func (s *dummySends) SendFailures() int {
+	return int(*s)
+}
+
+type dummyPager struct {
+	pages []Page
+	err   error
+}
+
+func (p *dummyPager) Page(page Page) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.pages = append(p.pages, page)
+	return nil
+}
+
+func newSubsystems() *pauseutil.Registry {
+	r := pauseutil.NewRegistry()
+	r.Add("scanner", &pauseutil.Gate{})
+	r.Add("sender", &pauseutil.Gate{})
+	return r
+}
+
+func subsystemPaused(t *testing.T, r *pauseutil.Registry, name string) bool {
+	for _, s := range r.Statuses() {
+		if s.Name == name {
+			return s.Paused
+		}
+	}
+	t.Fatalf("unknown subsystem %s", name)
+	return false
+}
+
+func TestNewInvalid(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	nodes := map[string]Node{
+		NodeSky: &dummyNode{},
+	}
+
+	cases := []struct {
+		name  string
+		rule  Rule
+		pager Pager
+		err   string
+	}{
+		{
+			name: "node not connected to",
+			rule: Rule{Name: "btc_down", Condition: ConditionNodeDown, Node: NodeBTC},
+			err:  "Rule btc_down watches the btc node, which is not connected to",
+		},
+		{
+			name: "unknown subsystem",
+			rule: Rule{Name: "low", Condition: ConditionPoolLow, Actions: []Action{{Type: ActionPause, Subsystem: "mailer"}}},
+			err:  `Rule low pauses the unknown subsystem "mailer"`,
+		},
+		{
+			name: "no fallback",
+			rule: Rule{Name: "low", Condition: ConditionPoolLow, Actions: []Action{{Type: ActionFallbackNode, Node: NodeSky}}},
+			err:  "Rule low switches the sky node, which has no fallback",
+		},
+		{
+			name: "no pager",
+			rule: Rule{Name: "low", Condition: ConditionPoolLow, Actions: []Action{{Type: ActionPage}}},
+			err:  "Rule low pages, but there is no pager",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := New(log, Config{Rules: []Rule{tc.rule}}, nodes, dummyPools{}, new(dummySends), newSubsystems(), tc.pager)
+			require.Error(t, err)
+			require.Equal(t, tc.err, err.Error())
+		})
+	}
+}
+
+func TestRunbookNodeDown(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	node := &dummyNode{}
+	pager := &dummyPager{}
+	subsystems := newSubsystems()
+
+	b, err := New(log, Config{
+		Rules: []Rule{
+			{
+				Name:      "sky_down",
+				Condition: ConditionNodeDown,
+				Node:      NodeSky,
+				For:       time.Minute * 5,
+				Actions: []Action{
+					{Type: ActionPause, Subsystem: "sender"},
+					{Type: ActionFallbackNode, Node: NodeSky},
+					{Type: ActionPage},
+				},
+			},
+		},
+		Fallbacks: map[string]string{
+			NodeSky: "http://127.0.0.1:16430",
+		},
+	}, map[string]Node{NodeSky: node}, dummyPools{}, new(dummySends), subsystems, pager)
+	require.NoError(t, err)
+
+	start := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	b.Check(start)
+	s := b.Status()
+	require.False(t, s.DryRun)
+	require.Len(t, s.Rules, 1)
+	require.False(t, s.Rules[0].Triggered)
+	require.Equal(t, "sky node is up", s.Rules[0].Summary)
+	require.Empty(t, s.Rules[0].Actions)
+
+	// Down for less than 5 minutes
+	node.downSince = start
+	b.Check(start.Add(time.Minute * 2))
+	require.False(t, b.Status().Rules[0].Triggered)
+	require.Empty(t, pager.pages)
+
+	// Down for 5 minutes, the actions run in order
+	now := start.Add(time.Minute * 5)
+	b.Check(now)
+	s = b.Status()
+	require.True(t, s.Rules[0].Triggered)
+	require.Equal(t, now.Unix(), s.Rules[0].TriggeredAt)
+	require.Equal(t, "sky node is down since 2018-03-01T12:00:00Z, for 5m0s", s.Rules[0].Summary)
+	require.Equal(t, []ActionResult{
+		{Type: ActionPause, Target: "sender", At: now.Unix(), Detail: "paused"},
+		{Type: ActionFallbackNode, Target: NodeSky, At: now.Unix(), Detail: "switched to http://127.0.0.1:16430"},
+		{Type: ActionPage, At: now.Unix(), Detail: "paged"},
+	}, s.Rules[0].Actions)
+
+	require.Equal(t, []string{"http://127.0.0.1:16430"}, node.switched)
+	require.True(t, subsystemPaused(t, subsystems, "sender"))
+
+	require.Len(t, pager.pages, 1)
+	require.Equal(t, Page{
+		DedupKey:  "teller-sky_down",
+		Rule:      "sky_down",
+		Condition: ConditionNodeDown,
+		Status:    PageTriggered,
+		Summary:   s.Rules[0].Summary,
+		Time:      now.Unix(),
+	}, pager.pages[0])
+
+	// The rule doesn't trigger again while its condition stays true
+	b.Check(now.Add(time.Minute))
+	require.Len(t, pager.pages, 1)
+	require.Equal(t, now.Unix(), b.Status().Rules[0].TriggeredAt)
+
+	// The rule is resolved when the node is up again
+	node.downSince = time.Time{}
+	b.Check(now.Add(time.Minute * 2))
+	s = b.Status()
+	require.False(t, s.Rules[0].Triggered)
+	require.Len(t, s.Rules[0].Actions, 3)
+	require.Len(t, pager.pages, 2)
+	require.Equal(t, PageResolved, pager.pages[1].Status)
+	require.Equal(t, "teller-sky_down", pager.pages[1].DedupKey)
+
+	// The next trigger doesn't switch the node again, and a failed action doesn't stop the next
+	pager.err = errors.New("pager down")
+	node.downSince = now
+	now = now.Add(time.Minute * 10)
+	b.Check(now)
+	s = b.Status()
+	require.True(t, s.Rules[0].Triggered)
+	require.Equal(t, []ActionResult{
+		{Type: ActionPause, Target: "sender", At: now.Unix(), Detail: "paused"},
+		{Type: ActionFallbackNode, Target: NodeSky, At: now.Unix(), Detail: "already switched to http://127.0.0.1:16430"},
+		{Type: ActionPage, At: now.Unix(), Error: "pager down"},
+	}, s.Rules[0].Actions)
+	require.Len(t, node.switched, 1)
+}
+
+func TestRunbookPoolLowAndSendFailures(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	pools := dummyPools{"BTC": 100}
+	sends := new(dummySends)
+	subsystems := newSubsystems()
+
+	b, err := New(log, Config{
+		Rules: []Rule{
+			{
+				Name:      "btc_low",
+				Condition: ConditionPoolLow,
+				CoinType:  "BTC",
+				Below:     50,
+				Actions:   []Action{{Type: ActionPause, Subsystem: "scanner"}},
+			},
+			{
+				Name:      "eth_low",
+				Condition: ConditionPoolLow,
+				CoinType:  "ETH",
+				Below:     50,
+				Actions:   []Action{{Type: ActionPause, Subsystem: "scanner"}},
+			},
+			{
+				Name:      "send_failing",
+				Condition: ConditionSendFailures,
+				Streak:    3,
+				Actions:   []Action{{Type: ActionPause, Subsystem: "sender"}},
+			},
+		},
+	}, nil, pools, sends, subsystems, nil)
+	require.NoError(t, err)
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	b.Check(now)
+	s := b.Status()
+	require.False(t, s.Rules[0].Triggered)
+	require.Equal(t, "BTC deposit address pool has 100 addresses left, the minimum is 50", s.Rules[0].Summary)
+	// A pool which can't be read is an error of the check
+	require.Equal(t, "no pool", s.Rules[1].Error)
+	require.False(t, s.Rules[1].Triggered)
+	require.False(t, s.Rules[2].Triggered)
+	require.Equal(t, "0 consecutive send failures, the limit is 3", s.Rules[2].Summary)
+
+	pools["BTC"] = 49
+	*sends = 2
+	b.Check(now)
+	s = b.Status()
+	require.True(t, s.Rules[0].Triggered)
+	require.False(t, s.Rules[2].Triggered)
+
+	require.True(t, subsystemPaused(t, subsystems, "scanner"))
+	require.False(t, subsystemPaused(t, subsystems, "sender"))
+
+	*sends = 3
+	b.Check(now)
+	s = b.Status()
+	require.True(t, s.Rules[2].Triggered)
+	require.Equal(t, "3 consecutive send failures, the limit is 3", s.Rules[2].Summary)
+	require.True(t, subsystemPaused(t, subsystems, "sender"))
+}
+
+func TestRunbookDryRun(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	node := &dummyNode{}
+	pager := &dummyPager{}
+	subsystems := newSubsystems()
+
+	b, err := New(log, Config{
+		DryRun: true,
+		Rules: []Rule{
+			{
+				Name:      "sky_down",
+				Condition: ConditionNodeDown,
+				Node:      NodeSky,
+				Actions: []Action{
+					{Type: ActionPause, Subsystem: "sender"},
+					{Type: ActionFallbackNode, Node: NodeSky},
+					{Type: ActionPage},
+				},
+			},
+		},
+		Fallbacks: map[string]string{
+			NodeSky: "http://127.0.0.1:16430",
+		},
+	}, map[string]Node{NodeSky: node}, dummyPools{}, new(dummySends), subsystems, pager)
+	require.NoError(t, err)
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	node.downSince = now
+	b.Check(now)
+
+	s := b.Status()
+	require.True(t, s.DryRun)
+	require.True(t, s.Rules[0].Triggered)
+	require.Len(t, s.Rules[0].Actions, 3)
+	for _, a := range s.Rules[0].Actions {
+		require.True(t, a.DryRun)
+		require.Empty(t, a.Detail)
+	}
+
+	// Nothing ran
+	require.Empty(t, node.switched)
+	require.Empty(t, pager.pages)
+	require.False(t, subsystemPaused(t, subsystems, "sender"))
+
+	// Nor is the resolved page sent
+	node.downSince = time.Time{}
+	b.Check(now)
+	require.False(t, b.Status().Rules[0].Triggered)
+	require.Empty(t, pager.pages)
+}
+
+func TestRunbookRunShutdown(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	b, err := New(log, Config{
+		Interval: time.Millisecond * 10,
+		Rules: []Rule{
+			{Name: "send_failing", Condition: ConditionSendFailures, Streak: 1},
+		},
+	}, nil, dummyPools{}, new(dummySends), newSubsystems(), nil)
+	require.NoError(t, err)
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- b.Run()
+	}()
+
+	// The rules are checked at startup
+	for i := 0; i < 100 && b.Status().Rules[0].CheckedAt == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.NotZero(t, b.Status().Rules[0].CheckedAt)
+
+	b.Shutdown()
+	require.NoError(t, <-errC)
+}
@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/pauseutil"
 )
 
 const (
@@ -18,7 +21,11 @@ type CommonScanner interface {
 	GetStorer() Storer
 	GetDeposit() <-chan DepositNote
 	GetQuitChan() <-chan struct{}
-	GetScannedDepositChan() chan<- Deposit
+	GetScannedDepositChan() chan<- deposits.Deposit
+	GetPauseGate() *pauseutil.Gate
+	GetPollScheduler() *PollScheduler
+	GetFinality() Finality
+	PendingDeposits() []deposits.Deposit
 	Shutdown()
 	Run(
 		getBlockCount func() (int64, error),
@@ -35,9 +42,17 @@ type BaseScanner struct {
 	log      logrus.FieldLogger
 	depositC chan DepositNote
 	// Internal deposit value channel
-	scannedDeposits chan Deposit
-	quit            chan struct{}
-	done            chan struct{}
+	scannedDeposits chan deposits.Deposit
+	lagGuard        *LagGuard
+	pauseGate       pauseutil.Gate // pauses scanning between blocks
+	poll            *PollScheduler // how long to wait for a new block
+	coinType        string
+	finality        Finality
+	// Deposits detected in the block being scanned, which is not final yet
+	pending   []deposits.Deposit
+	pendingMu sync.RWMutex
+	quit      chan struct{}
+	done      chan struct{}
 }
 
 //CommonVout common transaction output info
@@ -59,10 +74,17 @@ type CommonBlock struct {
 	Hash     string
 	NextHash string
 	RawTx    []CommonTx
+	// Confirmations is set by the scanner when the block is scanned
+	Confirmations int64
 }
 
 //NewBaseScanner creates base scanner instance
-func NewBaseScanner(store Storer, log logrus.FieldLogger, cfg Config) *BaseScanner {
+func NewBaseScanner(store Storer, log logrus.FieldLogger, coinType string, cfg Config) (*BaseScanner, error) {
+	finality, err := NewFinality(log, cfg.Finality)
+	if err != nil {
+		return nil, err
+	}
+
 	if cfg.ScanPeriod == 0 {
 		cfg.ScanPeriod = blockScanPeriod
 	}
@@ -75,10 +97,14 @@ func NewBaseScanner(store Storer, log logrus.FieldLogger, cfg Config) *BaseScann
 		store:           store,
 		quit:            make(chan struct{}),
 		depositC:        make(chan DepositNote),
-		scannedDeposits: make(chan Deposit, cfg.DepositBufferSize),
+		scannedDeposits: make(chan deposits.Deposit, cfg.DepositBufferSize),
+		lagGuard:        NewLagGuard(log, cfg.Lag),
+		poll:            NewPollScheduler(cfg.Poll, cfg.ScanPeriod),
+		coinType:        coinType,
+		finality:        finality,
 		done:            make(chan struct{}),
 		Cfg:             cfg,
-	}
+	}, nil
 }
 
 // loadUnprocessedDeposits loads unprocessed Deposits into the scannedDeposits
@@ -111,7 +137,7 @@ func (s *BaseScanner) loadUnprocessedDeposits() error {
 // If this exits early, or the exchange reported an error, the deposit will
 // not be marked as processed. When restarted, unprocessed deposits will be
 // sent to the exchange for processing again.
-func (s *BaseScanner) processDeposit(dv Deposit) error {
+func (s *BaseScanner) processDeposit(dv deposits.Deposit) error {
 	log := s.log.WithField("deposit", dv)
 	log.Info("Sending deposit to depositC")
 
@@ -166,11 +192,60 @@ func (s *BaseScanner) GetQuitChan() <-chan struct{} {
 }
 
 //GetScannedDepositChan returns scanned deposit channel
-func (s *BaseScanner) GetScannedDepositChan() chan<- Deposit {
+func (s *BaseScanner) GetScannedDepositChan() chan<- deposits.Deposit {
 	return s.scannedDeposits
 }
 
-//Shutdown shutdown base scanner
+// GetPauseGate returns the gate which pauses block scanning
+func (s *BaseScanner) GetPauseGate() *pauseutil.Gate {
+	return &s.pauseGate
+}
+
+// GetPollScheduler returns the scheduler of the polls for a new block
+func (s *BaseScanner) GetPollScheduler() *PollScheduler {
+	return s.poll
+}
+
+// GetFinality returns the finality policy of the scanned blocks
+func (s *BaseScanner) GetFinality() Finality {
+	return s.finality
+}
+
+// PendingDeposits returns the deposits detected in a block which has the confirmations required,
+// but is not final yet. They are not saved, and are sent to the exchange once the block is final.
+func (s *BaseScanner) PendingDeposits() []deposits.Deposit {
+	s.pendingMu.RLock()
+	defer s.pendingMu.RUnlock()
+	return s.pending
+}
+
+func (s *BaseScanner) setPendingDeposits(dvs []deposits.Deposit) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	s.pending = dvs
+}
+
+// detectDeposits sets the pending deposits to the deposits of a block which is not final
+func (s *BaseScanner) detectDeposits(block *CommonBlock) error {
+	addrs, err := s.store.GetScanAddresses(s.coinType)
+	if err != nil {
+		return err
+	}
+
+	dvs, err := scanSpecifiedBlock(block, s.coinType, addrs)
+	if err != nil {
+		return err
+	}
+
+	for i := range dvs {
+		dvs[i].Final = false
+	}
+
+	s.setPendingDeposits(dvs)
+	return nil
+}
+
+// Shutdown shutdown base scanner
 func (s *BaseScanner) Shutdown() {
 	close(s.depositC)
 	close(s.quit)
@@ -239,6 +314,23 @@ func (s *BaseScanner) Run(
 			}
 		}
 
+		// Wait for a new block
+		// Returns true if the scanner quit
+		waitBlock := func() error {
+			if !s.poll.Wait(s.quit) {
+				return errQuit
+			}
+			return nil
+		}
+
+		hashAt := func(height int64) (string, error) {
+			b, err := getBlockAtHeight(height)
+			if err != nil {
+				return "", err
+			}
+			return b.Hash, nil
+		}
+
 		deposits := 0
 		for {
 			select {
@@ -247,6 +339,11 @@ func (s *BaseScanner) Run(
 			default:
 			}
 
+			// Deposits already scanned are still sent to the exchange while paused
+			if !s.pauseGate.Wait(s.quit) {
+				return
+			}
+
 			blockHash, blockHeight := getBlockHashAndHeight(block)
 			log = log.WithFields(logrus.Fields{
 				"height": blockHash,
@@ -265,16 +362,66 @@ func (s *BaseScanner) Run(
 			}
 
 			log = log.WithField("bestHeight", bestHeight)
+			s.poll.Observe(bestHeight, time.Now())
+
+			// Confirmations computed from a node which lags the network can't be trusted
+			if s.lagGuard.Check(bestHeight, time.Now()) {
+				log.Warn("Node lags the network tip, waiting")
+				if wait() != nil {
+					return
+				}
+				continue
+			}
 
 			// If not enough confirmations exist for this block, wait
 			if blockHeight+s.Cfg.ConfirmationsRequired > bestHeight {
 				log.Info("Not enough confirmations, waiting")
+				if waitBlock() != nil {
+					return
+				}
+				continue
+			}
+
+			// Deposits are detected once the block has enough confirmations,
+			// but are only sent to the exchange once the block is final
+			block.Confirmations = bestHeight - blockHeight + 1
+			final, err := s.finality.Final(block, hashAt, time.Now())
+			if err == ErrBlockReorged {
+				s.setPendingDeposits(nil)
+				log.Warn("Block was reorganized out of the chain, getting the block at its height again")
+
+				b, err := getBlockAtHeight(blockHeight)
+				if err != nil {
+					log.WithError(err).Error("getBlockAtHeight failed")
+					if wait() != nil {
+						return
+					}
+					continue
+				}
+
+				block = b
+				continue
+			}
+			if err != nil {
+				log.WithError(err).Error("Finality check failed")
 				if wait() != nil {
 					return
 				}
 				continue
 			}
 
+			if !final {
+				if err := s.detectDeposits(block); err != nil {
+					log.WithError(err).Error("detectDeposits failed")
+				}
+
+				log.Info("Block is not final, waiting")
+				if waitBlock() != nil {
+					return
+				}
+				continue
+			}
+
 			// Scan the block for deposits
 			n, err := scanBlock(block)
 			if err != nil {
@@ -290,6 +437,8 @@ func (s *BaseScanner) Run(
 				continue
 			}
 
+			s.setPendingDeposits(nil)
+
 			deposits += n
 			log.WithFields(logrus.Fields{
 				"scannedDeposits":      n,
@@ -14,6 +14,9 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcutil"
 	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/pauseutil"
 )
 
 var (
@@ -30,19 +33,28 @@ type Config struct {
 	DepositBufferSize     int           // size of GetDeposit() channel
 	InitialScanHeight     int64         // what blockchain height to begin scanning from
 	ConfirmationsRequired int64         // how many confirmations to wait for block
+	Lag                   LagConfig     // when to stop confirming deposits because the node lags the network
+	Poll                  PollConfig    // how long to wait for a new block, ScanPeriod if not enabled
+	Finality              FinalityConfig
 }
 
 // BTCScanner blockchain scanner to check if there're deposit coins
 type BTCScanner struct {
 	log       logrus.FieldLogger
 	btcClient BtcRPCClient
+	// Set when scanning with btcd's transaction filter
+	txFilterClient BtcTxFilterClient
+	txFilter       *BtcTxFilter
 	// Deposit value channel, exposed by public API, intended for public consumption
 	Base CommonScanner
 }
 
 // NewBTCScanner creates scanner instance
 func NewBTCScanner(log logrus.FieldLogger, store Storer, btc BtcRPCClient, cfg Config) (*BTCScanner, error) {
-	bs := NewBaseScanner(store, log.WithField("prefix", "scanner.btc"), cfg)
+	bs, err := NewBaseScanner(store, log.WithField("prefix", "scanner.btc"), CoinTypeBTC, cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	return &BTCScanner{
 		btcClient: btc,
@@ -51,10 +63,36 @@ func NewBTCScanner(log logrus.FieldLogger, store Storer, btc BtcRPCClient, cfg C
 	}, nil
 }
 
+// NewBTCScannerTxFilter creates a scanner which loads the scan addresses into btcd's
+// websocket transaction filter, and only fetches the transactions of each block
+// which are relevant to them, instead of every transaction of every block.
+// txFilter must be Reset whenever btc connects or reconnects to btcd.
+func NewBTCScannerTxFilter(log logrus.FieldLogger, store Storer, btc BtcTxFilterClient, txFilter *BtcTxFilter, cfg Config) (*BTCScanner, error) {
+	s, err := NewBTCScanner(log, store, btc, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s.txFilterClient = btc
+	s.txFilter = txFilter
+
+	return s, nil
+}
+
 func (s *BTCScanner) Run() error {
 	return s.Base.Run(s.GetBlockCount, s.getBlockAtHeight, s.waitForNextBlock, s.scanBlock)
 }
 
+// GetPauseGate returns the gate which pauses block scanning
+func (s *BTCScanner) GetPauseGate() *pauseutil.Gate {
+	return s.Base.GetPauseGate()
+}
+
+// GetPollScheduler returns the scheduler of the polls for a new block
+func (s *BTCScanner) GetPollScheduler() *PollScheduler {
+	return s.Base.GetPollScheduler()
+}
+
 // Shutdown shutdown the scanner
 func (s *BTCScanner) Shutdown() {
 	s.log.Info("Closing BTC scanner")
@@ -110,6 +148,10 @@ func (s *BTCScanner) getBlockAtHeight(height int64) (*CommonBlock, error) {
 		return nil, err
 	}
 
+	if s.txFilter != nil {
+		return s.getFilteredBlock(hash)
+	}
+
 	block, err := s.btcClient.GetBlockVerboseTx(hash)
 	if err != nil {
 		log.WithError(err).Error("btcClient.GetBlockVerboseTx failed")
@@ -162,6 +204,10 @@ func (s *BTCScanner) getNextBlock(block *CommonBlock) (*CommonBlock, error) {
 		return nil, err
 	}
 
+	if s.txFilter != nil {
+		return s.getFilteredBlock(nxtHash)
+	}
+
 	s.log.WithField("nextHash", nxtHash.String()).Debug("Calling s.btcClient.GetBlockVerboseTx")
 	btc, err := s.btcClient.GetBlockVerboseTx(nxtHash)
 	if err != nil {
@@ -186,19 +232,24 @@ func (s *BTCScanner) waitForNextBlock(block *CommonBlock) (*CommonBlock, error)
 			return nil, err
 		}
 
-		for {
+		if s.txFilter != nil {
+			block, err = s.waitForNextHash(block, hash)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for block.NextHash == "" {
 			btcBlock, err := s.btcClient.GetBlockVerboseTx(hash)
 			if err != nil {
 				log.WithError(err).Error("btcClient.GetBlockVerboseTx failed, retrying")
 			}
 
 			if err != nil || btcBlock.NextHash == "" {
-				select {
-				case <-s.Base.GetQuitChan():
+				if !s.Base.GetPollScheduler().Wait(s.Base.GetQuitChan()) {
 					return nil, errQuit
-				case <-time.After(s.Base.GetScanPeriod()):
-					continue
 				}
+				continue
 			}
 			block, err = btcBlock2CommonBlock(btcBlock)
 			if err != nil {
@@ -218,12 +269,10 @@ func (s *BTCScanner) waitForNextBlock(block *CommonBlock) (*CommonBlock, error)
 			log.Debug("No new block yet")
 		}
 		if err != nil || nextBlock == nil {
-			select {
-			case <-s.Base.GetQuitChan():
+			if !s.Base.GetPollScheduler().Wait(s.Base.GetQuitChan()) {
 				return nil, errQuit
-			case <-time.After(s.Base.GetScanPeriod()):
-				continue
 			}
+			continue
 		}
 
 		log.WithFields(logrus.Fields{
@@ -237,7 +286,18 @@ func (s *BTCScanner) waitForNextBlock(block *CommonBlock) (*CommonBlock, error)
 
 // AddScanAddress adds new scan address
 func (s *BTCScanner) AddScanAddress(addr, coinType string) error {
-	return s.Base.GetStorer().AddScanAddress(addr, coinType)
+	if err := s.Base.GetStorer().AddScanAddress(addr, coinType); err != nil {
+		return err
+	}
+
+	if s.txFilter != nil {
+		s.addTxFilterAddress(addr)
+	}
+
+	// A deposit to the new address is likely soon
+	s.Base.GetPollScheduler().Boost(time.Now())
+
+	return nil
 }
 
 // GetScanAddresses returns the deposit addresses that need to scan
@@ -245,7 +305,33 @@ func (s *BTCScanner) GetScanAddresses() ([]string, error) {
 	return s.Base.GetStorer().GetScanAddresses(CoinTypeBTC)
 }
 
+// PendingDeposits returns the deposits detected in a block which is not final yet
+func (s *BTCScanner) PendingDeposits(coinType string) []deposits.Deposit {
+	return s.Base.PendingDeposits()
+}
+
 //GetDeposit returns channel of depositnote
 func (s *BTCScanner) GetDeposit() <-chan DepositNote {
 	return s.Base.GetDeposit()
 }
+
+// BtcTipClient returns btcd's best block header
+type BtcTipClient interface {
+	GetBestBlockHash() (*chainhash.Hash, error)
+	GetBlockHeaderVerbose(*chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error)
+}
+
+// BtcTipTime returns the block time of btcd's best block
+func BtcTipTime(c BtcTipClient) (time.Time, error) {
+	hash, err := c.GetBestBlockHash()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	header, err := c.GetBlockHeaderVerbose(hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(header.Time, 0), nil
+}
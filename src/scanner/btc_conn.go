@@ -0,0 +1,376 @@
+package scanner
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	btcrpcclient "github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/connutil"
+)
+
+// BtcConn is a connection to btcd which reconnects when the connection fails.
+// btcrpcclient reconnects its websocket only once btcd closes it, so a connection to a btcd which failed over
+// to another address, and never closed it, hangs the requests forever. A BtcConn times out the requests,
+// drops a connection which failed or timed out, and reconnects on the next request after a backoff,
+// resolving btcd's host again. It implements the rpc client interfaces of the BTC scanner, the tx filter,
+// the address history check and the deposit inspector. SwitchNode moves to another btcd, e.g. a fallback node.
+type BtcConn struct {
+	baseLog logrus.FieldLogger
+	dial    func(host string) (btcConnClient, error)
+
+	mu       sync.Mutex
+	log      logrus.FieldLogger
+	host     string
+	r        *connutil.Reconnector
+	client   btcConnClient
+	shutdown bool
+}
+
+// btcConnClient is the btcd rpc client of a BtcConn, a *btcrpcclient.Client
+type btcConnClient interface {
+	GetBlockVerboseTx(*chainhash.Hash) (*btcjson.GetBlockVerboseResult, error)
+	GetBlockHash(int64) (*chainhash.Hash, error)
+	GetBlockCount() (int64, error)
+	GetBestBlockHash() (*chainhash.Hash, error)
+	GetBlockHeaderVerbose(*chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error)
+	GetRawTransactionVerbose(*chainhash.Hash) (*btcjson.TxRawResult, error)
+	SearchRawTransactions(address btcutil.Address, skip, count int, reverse bool, filterAddrs []string) ([]*wire.MsgTx, error)
+	LoadTxFilter(reload bool, addresses []btcutil.Address, outPoints []wire.OutPoint) error
+	RescanBlocks([]chainhash.Hash) ([]btcjson.RescannedBlock, error)
+	Shutdown()
+}
+
+// NewBtcConn connects to btcd. The notification handlers, if not nil, are set on each new connection,
+// so OnClientConnected is called when it reconnects. It returns an error if btcd can't be connected to.
+func NewBtcConn(log logrus.FieldLogger, cfg btcrpcclient.ConnConfig, handlers *btcrpcclient.NotificationHandlers, reconnect connutil.Config) (*BtcConn, error) {
+	// A failed connection is replaced by a new client, rather than reconnected by btcrpcclient
+	cfg.DisableAutoReconnect = true
+	cfg.DisableConnectOnNew = false
+	cfg.HTTPPostMode = false
+
+	return newBtcConn(log, cfg.Host, func(host string) (btcConnClient, error) {
+		cfg := cfg
+		cfg.Host = host
+		client, err := btcrpcclient.New(&cfg, handlers)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}, reconnect)
+}
+
+func newBtcConn(log logrus.FieldLogger, host string, dial func(host string) (btcConnClient, error), reconnect connutil.Config) (*BtcConn, error) {
+	c := &BtcConn{
+		baseLog: log.WithField("prefix", "scanner.btc.conn"),
+		dial:    dial,
+	}
+	c.log, c.r = c.newReconnector(host, reconnect)
+	c.host = host
+
+	client, err := c.connect(c.log, c.r, host)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+
+	return c, nil
+}
+
+// newReconnector returns the logger and Reconnector of the connection to btcd at host
+func (c *BtcConn) newReconnector(host string, reconnect connutil.Config) (logrus.FieldLogger, *connutil.Reconnector) {
+	log := c.baseLog.WithField("host", host)
+	return log, connutil.NewReconnector(log, reconnect, "btcd_unreachable", clock.Real{})
+}
+
+// Host returns btcd's host
+func (c *BtcConn) Host() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.host
+}
+
+// DownSince returns when the connection to btcd began to fail, zero if the last request succeeded
+func (c *BtcConn) DownSince() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.r.DownSince()
+}
+
+// SwitchNode connects to btcd at host, e.g. a fallback node while btcd is down, and replaces the connection with it.
+// The connection is kept if host can't be connected to. The requests in progress finish with the previous connection.
+func (c *BtcConn) SwitchNode(host string) error {
+	c.mu.Lock()
+	reconnect := c.r.Config()
+	c.mu.Unlock()
+
+	log, r := c.newReconnector(host, reconnect)
+	client, err := c.connect(log, r, host)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.shutdown {
+		client.Shutdown()
+		return btcrpcclient.ErrClientShutdown
+	}
+
+	if c.client != nil {
+		c.client.Shutdown()
+	}
+
+	log.WithField("previous", c.host).Warn("Switched to another btcd")
+
+	c.log = log
+	c.host = host
+	c.r = r
+	c.client = client
+
+	return nil
+}
+
+// connect resolves btcd's host and connects to it, within the connect timeout
+func (c *BtcConn) connect(log logrus.FieldLogger, r *connutil.Reconnector, host string) (btcConnClient, error) {
+	timeout := r.Config().ConnectTimeout
+
+	addrs, err := r.Resolve(host)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		client btcConnClient
+		err    error
+	}
+
+	resC := make(chan result, 1)
+	go func() {
+		client, err := c.dial(host)
+		resC <- result{client, err}
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case res := <-resC:
+		if res.err != nil {
+			return nil, res.err
+		}
+		log.WithField("addrs", addrs).Info("Connected to btcd")
+		return res.client, nil
+
+	case <-t.C:
+		// A connection established after the timeout is closed
+		go func() {
+			if res := <-resC; res.err == nil {
+				res.client.Shutdown()
+			}
+		}()
+
+		return nil, connutil.TimeoutErr{
+			Op:    "Connecting to btcd",
+			After: timeout,
+		}
+	}
+}
+
+// get returns the connected client and its Reconnector, reconnecting if the last connection failed and the backoff elapsed
+func (c *BtcConn) get() (btcConnClient, *connutil.Reconnector, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.shutdown {
+		return nil, nil, btcrpcclient.ErrClientShutdown
+	}
+
+	if c.client != nil {
+		return c.client, c.r, nil
+	}
+
+	if err := c.r.Ready(); err != nil {
+		return nil, nil, err
+	}
+
+	client, err := c.connect(c.log, c.r, c.host)
+	if err != nil {
+		c.r.Failed(err)
+		return nil, nil, err
+	}
+	c.client = client
+
+	return client, c.r, nil
+}
+
+// drop closes the client after its connection failed, unless it was already replaced
+func (c *BtcConn) drop(client btcConnClient, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != client {
+		return
+	}
+
+	c.client = nil
+	client.Shutdown()
+
+	if !c.shutdown {
+		c.r.Failed(err)
+	}
+}
+
+// call makes a request to btcd within the request timeout, dropping the connection if it fails
+func (c *BtcConn) call(f func(btcConnClient) (interface{}, error)) (interface{}, error) {
+	client, r, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := connutil.WithTimeout("btcd request", r.Config().RequestTimeout, func() (interface{}, error) {
+		return f(client)
+	})
+
+	if isBtcConnErr(err) {
+		c.drop(client, err)
+		return nil, err
+	}
+
+	r.Succeeded()
+
+	return v, err
+}
+
+// isBtcConnErr returns true if err is a failure of the connection to btcd, rather than an error response
+func isBtcConnErr(err error) bool {
+	switch err {
+	case nil:
+		return false
+	case btcrpcclient.ErrClientDisconnect, btcrpcclient.ErrClientShutdown, btcrpcclient.ErrClientNotConnected:
+		return true
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// GetBlockVerboseTx returns a block with its transactions
+func (c *BtcConn) GetBlockVerboseTx(hash *chainhash.Hash) (*btcjson.GetBlockVerboseResult, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.GetBlockVerboseTx(hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*btcjson.GetBlockVerboseResult), nil
+}
+
+// GetBlockHash returns the hash of the block at a height
+func (c *BtcConn) GetBlockHash(height int64) (*chainhash.Hash, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.GetBlockHash(height)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*chainhash.Hash), nil
+}
+
+// GetBlockCount returns the height of the best block
+func (c *BtcConn) GetBlockCount() (int64, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.GetBlockCount()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return v.(int64), nil
+}
+
+// GetBestBlockHash returns the hash of the best block
+func (c *BtcConn) GetBestBlockHash() (*chainhash.Hash, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.GetBestBlockHash()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*chainhash.Hash), nil
+}
+
+// GetBlockHeaderVerbose returns a block's header
+func (c *BtcConn) GetBlockHeaderVerbose(hash *chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.GetBlockHeaderVerbose(hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*btcjson.GetBlockHeaderVerboseResult), nil
+}
+
+// GetRawTransactionVerbose returns a transaction
+func (c *BtcConn) GetRawTransactionVerbose(hash *chainhash.Hash) (*btcjson.TxRawResult, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.GetRawTransactionVerbose(hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*btcjson.TxRawResult), nil
+}
+
+// SearchRawTransactions returns the transactions of an address. Requires btcd's --addrindex.
+func (c *BtcConn) SearchRawTransactions(address btcutil.Address, skip, count int, reverse bool, filterAddrs []string) ([]*wire.MsgTx, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.SearchRawTransactions(address, skip, count, reverse, filterAddrs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]*wire.MsgTx), nil
+}
+
+// LoadTxFilter loads addresses and outpoints into the connection's transaction filter
+func (c *BtcConn) LoadTxFilter(reload bool, addresses []btcutil.Address, outPoints []wire.OutPoint) error {
+	_, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return nil, client.LoadTxFilter(reload, addresses, outPoints)
+	})
+	return err
+}
+
+// RescanBlocks returns the transactions of the blocks which match the connection's transaction filter
+func (c *BtcConn) RescanBlocks(hashes []chainhash.Hash) ([]btcjson.RescannedBlock, error) {
+	v, err := c.call(func(client btcConnClient) (interface{}, error) {
+		return client.RescanBlocks(hashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]btcjson.RescannedBlock), nil
+}
+
+// Shutdown closes the connection
+func (c *BtcConn) Shutdown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.shutdown {
+		return
+	}
+	c.shutdown = true
+
+	if c.client != nil {
+		c.client.Shutdown()
+		c.client = nil
+	}
+}
@@ -0,0 +1,242 @@
+package scanner
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	btcrpcclient "github.com/btcsuite/btcd/rpcclient"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/connutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// fakeBtcConnClient is a btcd connection which answers getblockcount, fails it, or hangs it until it is shut down
+type fakeBtcConnClient struct {
+	btcConnClient
+	count int64
+	err   error
+	hang  bool
+	shut  chan struct{}
+}
+
+func (c *fakeBtcConnClient) GetBlockCount() (int64, error) {
+	if c.hang {
+		<-c.shut
+		return 0, btcrpcclient.ErrClientShutdown
+	}
+	return c.count, c.err
+}
+
+func (c *fakeBtcConnClient) Shutdown() {
+	select {
+	case <-c.shut:
+	default:
+		close(c.shut)
+	}
+}
+
+func TestBtcConn(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// The connections dialed, the next is a copy of next
+	var mu sync.Mutex
+	var dialed []*fakeBtcConnClient
+	next := fakeBtcConnClient{count: 500000}
+	setNext := func(c fakeBtcConnClient) {
+		mu.Lock()
+		defer mu.Unlock()
+		next = c
+	}
+	dial := func(host string) (btcConnClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := next
+		c.shut = make(chan struct{})
+		dialed = append(dialed, &c)
+		return &c, nil
+	}
+	last := func() *fakeBtcConnClient {
+		mu.Lock()
+		defer mu.Unlock()
+		return dialed[len(dialed)-1]
+	}
+
+	c, err := newBtcConn(log, "127.0.0.1:8334", dial, connutil.Config{
+		MinBackoff:     time.Millisecond * 100,
+		MaxBackoff:     time.Second,
+		RequestTimeout: time.Millisecond * 100,
+	})
+	require.NoError(t, err)
+	defer c.Shutdown()
+
+	count, err := c.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(500000), count)
+	require.Len(t, dialed, 1)
+
+	// An error response isn't a connection failure
+	last().err = &btcjson.RPCError{Code: -1, Message: "failed"}
+	_, err = c.GetBlockCount()
+	require.Error(t, err)
+	require.Equal(t, 0, c.r.Failures())
+	require.Len(t, dialed, 1)
+
+	// A dropped connection fails the requests until it is reconnected after the backoff
+	last().err = btcrpcclient.ErrClientDisconnect
+	_, err = c.GetBlockCount()
+	require.Equal(t, btcrpcclient.ErrClientDisconnect, err)
+	require.Equal(t, 1, c.r.Failures())
+
+	select {
+	case <-dialed[0].shut:
+	default:
+		t.Fatal("Failed connection was not shut down")
+	}
+
+	_, err = c.GetBlockCount()
+	_, ok := err.(connutil.BackoffErr)
+	require.True(t, ok)
+	require.Len(t, dialed, 1)
+
+	time.Sleep(time.Millisecond * 100)
+	count, err = c.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(500000), count)
+	require.Equal(t, 0, c.r.Failures())
+	require.Len(t, dialed, 2)
+
+	// A request which hangs times out, and its connection is replaced
+	last().hang = true
+	_, err = c.GetBlockCount()
+	require.EqualError(t, err, "btcd request timed out after 100ms")
+	require.Equal(t, 1, c.r.Failures())
+
+	time.Sleep(time.Millisecond * 100)
+	count, err = c.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(500000), count)
+	require.Len(t, dialed, 3)
+
+	// The backoff doubles after each consecutive failure
+	setNext(fakeBtcConnClient{err: btcrpcclient.ErrClientDisconnect})
+	last().err = btcrpcclient.ErrClientDisconnect
+	_, err = c.GetBlockCount()
+	require.Error(t, err)
+	time.Sleep(time.Millisecond * 100)
+	_, err = c.GetBlockCount()
+	require.Error(t, err)
+	require.Equal(t, 2, c.r.Failures())
+	time.Sleep(time.Millisecond * 100)
+	_, err = c.GetBlockCount()
+	_, ok = err.(connutil.BackoffErr)
+	require.True(t, ok)
+
+	c.Shutdown()
+	_, err = c.GetBlockCount()
+	require.Equal(t, btcrpcclient.ErrClientShutdown, err)
+}
+
+func TestBtcConnSwitchNode(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// The connections dialed, by host. The fallback answers a later block count.
+	var mu sync.Mutex
+	dialed := make(map[string][]*fakeBtcConnClient)
+	dial := func(host string) (btcConnClient, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		c := &fakeBtcConnClient{
+			count: 500000,
+			shut:  make(chan struct{}),
+		}
+		if host == "127.0.0.1:18334" {
+			c.count = 500001
+		}
+		dialed[host] = append(dialed[host], c)
+		return c, nil
+	}
+
+	c, err := newBtcConn(log, "127.0.0.1:8334", dial, connutil.Config{
+		MinBackoff: time.Minute,
+	})
+	require.NoError(t, err)
+	defer c.Shutdown()
+
+	dialed["127.0.0.1:8334"][0].err = btcrpcclient.ErrClientDisconnect
+	_, err = c.GetBlockCount()
+	require.Error(t, err)
+	require.False(t, c.DownSince().IsZero())
+
+	// The fallback is connected to, and the failed connection's backoff doesn't apply to it
+	require.NoError(t, c.SwitchNode("127.0.0.1:18334"))
+	require.Equal(t, "127.0.0.1:18334", c.Host())
+	require.True(t, c.DownSince().IsZero())
+
+	count, err := c.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(500001), count)
+	require.Len(t, dialed["127.0.0.1:8334"], 1)
+
+	// A host which doesn't resolve keeps the connection
+	require.Error(t, c.SwitchNode("teller-test.invalid:8334"))
+	require.Equal(t, "127.0.0.1:18334", c.Host())
+
+	c.Shutdown()
+	require.Equal(t, btcrpcclient.ErrClientShutdown, c.SwitchNode("127.0.0.1:8334"))
+	select {
+	case <-dialed["127.0.0.1:8334"][1].shut:
+	default:
+		t.Fatal("Connection dialed after the shutdown was not shut down")
+	}
+}
+
+func TestBtcConnConnectTimeout(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// A server which accepts the connections, but never answers the websocket handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	connC := make(chan net.Conn, 10)
+	defer func() {
+		l.Close()
+		for {
+			select {
+			case c := <-connC:
+				c.Close()
+			default:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			connC <- c
+		}
+	}()
+
+	_, err = NewBtcConn(log, btcrpcclient.ConnConfig{
+		Host:       l.Addr().String(),
+		Endpoint:   "ws",
+		DisableTLS: true,
+	}, nil, connutil.Config{
+		ConnectTimeout: time.Millisecond * 100,
+	})
+	require.EqualError(t, err, "Connecting to btcd timed out after 100ms")
+
+	// A host which doesn't resolve fails to connect
+	_, err = NewBtcConn(log, btcrpcclient.ConnConfig{
+		Host:       "teller-test.invalid:8334",
+		Endpoint:   "ws",
+		DisableTLS: true,
+	}, nil, connutil.Config{})
+	require.Error(t, err)
+}
@@ -0,0 +1,227 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoQuorum is returned by BtcQuorum if not enough btcd nodes agree on a result
+var ErrNoQuorum = errors.New("btcd nodes did not reach a quorum")
+
+// BtcQuorum is a BtcRPCClient which queries several btcd nodes in parallel, and only returns
+// a result which a quorum of them agree on, so that a single faulty or malicious node
+// can't make the scanner credit a deposit which isn't in the chain.
+type BtcQuorum struct {
+	log     logrus.FieldLogger
+	clients []BtcRPCClient
+	quorum  int
+}
+
+// NewBtcQuorum creates a BtcQuorum of clients, which requires quorum of them to agree
+func NewBtcQuorum(log logrus.FieldLogger, clients []BtcRPCClient, quorum int) (*BtcQuorum, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("BtcQuorum requires at least one client")
+	}
+	if quorum < 1 || quorum > len(clients) {
+		return nil, fmt.Errorf("Invalid quorum %d of %d nodes", quorum, len(clients))
+	}
+
+	return &BtcQuorum{
+		log:     log.WithField("prefix", "scanner.btc.quorum"),
+		clients: clients,
+		quorum:  quorum,
+	}, nil
+}
+
+// quorumResult is the result of a node, with the key results are compared by
+type quorumResult struct {
+	node  int
+	key   string
+	value interface{}
+	err   error
+}
+
+// query calls f on every node in parallel
+func (q *BtcQuorum) query(f func(BtcRPCClient) (string, interface{}, error)) []quorumResult {
+	results := make([]quorumResult, len(q.clients))
+
+	var wg sync.WaitGroup
+	for i, c := range q.clients {
+		wg.Add(1)
+		go func(i int, c BtcRPCClient) {
+			defer wg.Done()
+			key, value, err := f(c)
+			results[i] = quorumResult{
+				node:  i,
+				key:   key,
+				value: value,
+				err:   err,
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// agree returns the result of the nodes with the most common key, if a quorum of them returned it
+func (q *BtcQuorum) agree(log logrus.FieldLogger, results []quorumResult) (interface{}, error) {
+	votes := make(map[string][]int)
+	var failed []int
+	for _, r := range results {
+		if r.err != nil {
+			log.WithError(r.err).WithField("node", r.node).Warn("btcd node failed")
+			failed = append(failed, r.node)
+			continue
+		}
+		votes[r.key] = append(votes[r.key], r.node)
+	}
+
+	var best string
+	for k, nodes := range votes {
+		if len(nodes) > len(votes[best]) || (len(nodes) == len(votes[best]) && k < best) {
+			best = k
+		}
+	}
+
+	agreeing := votes[best]
+	log = log.WithFields(logrus.Fields{
+		"agreeing": agreeing,
+		"failed":   failed,
+		"quorum":   q.quorum,
+	})
+
+	if len(agreeing) < q.quorum {
+		if len(votes) > 1 {
+			log.WithField("alert", "btc_quorum").Error("ALERT: btcd nodes disagree and no quorum was reached")
+		} else {
+			log.Error("Not enough btcd nodes responded for a quorum")
+		}
+		return nil, ErrNoQuorum
+	}
+
+	if len(votes) > 1 {
+		// Quorum was reached, but some nodes disagree with it
+		var disagreeing []int
+		for k, nodes := range votes {
+			if k != best {
+				disagreeing = append(disagreeing, nodes...)
+			}
+		}
+		sort.Ints(disagreeing)
+		log.WithField("disagreeing", disagreeing).Warn("btcd nodes disagree with the quorum")
+	}
+
+	return results[agreeing[0]].value, nil
+}
+
+// GetBlockCount returns the highest block count which a quorum of the nodes have reached
+func (q *BtcQuorum) GetBlockCount() (int64, error) {
+	results := q.query(func(c BtcRPCClient) (string, interface{}, error) {
+		n, err := c.GetBlockCount()
+		return "", n, err
+	})
+
+	var counts []int64
+	for _, r := range results {
+		if r.err != nil {
+			q.log.WithError(r.err).WithField("node", r.node).Warn("btcd node GetBlockCount failed")
+			continue
+		}
+		counts = append(counts, r.value.(int64))
+	}
+
+	if len(counts) < q.quorum {
+		q.log.WithField("responded", len(counts)).Error("Not enough btcd nodes responded to GetBlockCount for a quorum")
+		return 0, ErrNoQuorum
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i] > counts[j]
+	})
+
+	return counts[q.quorum-1], nil
+}
+
+// GetBlockHash returns the hash of the block at height, if a quorum of the nodes agree on it
+func (q *BtcQuorum) GetBlockHash(height int64) (*chainhash.Hash, error) {
+	results := q.query(func(c BtcRPCClient) (string, interface{}, error) {
+		hash, err := c.GetBlockHash(height)
+		if err != nil {
+			return "", nil, err
+		}
+		return hash.String(), hash, nil
+	})
+
+	v, err := q.agree(q.log.WithField("blockHeight", height), results)
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(*chainhash.Hash), nil
+}
+
+// GetBlockVerboseTx returns a block, if a quorum of the nodes agree on its transactions.
+// NextHash is only set once a quorum of the agreeing nodes have the same next block.
+func (q *BtcQuorum) GetBlockVerboseTx(hash *chainhash.Hash) (*btcjson.GetBlockVerboseResult, error) {
+	results := q.query(func(c BtcRPCClient) (string, interface{}, error) {
+		block, err := c.GetBlockVerboseTx(hash)
+		if err != nil {
+			return "", nil, err
+		}
+		return btcBlockDigest(block), block, nil
+	})
+
+	log := q.log.WithField("blockHash", hash.String())
+	v, err := q.agree(log, results)
+	if err != nil {
+		return nil, err
+	}
+
+	agreed := *v.(*btcjson.GetBlockVerboseResult)
+
+	// Nodes are at different heights, so they may agree on the block but not know the next one yet
+	digest := btcBlockDigest(&agreed)
+	nextHashes := make(map[string]int)
+	for _, r := range results {
+		if r.err == nil && r.key == digest {
+			nextHashes[r.value.(*btcjson.GetBlockVerboseResult).NextHash]++
+		}
+	}
+
+	agreed.NextHash = ""
+	for h, n := range nextHashes {
+		if h != "" && n >= q.quorum {
+			agreed.NextHash = h
+		}
+	}
+
+	return &agreed, nil
+}
+
+// Shutdown shuts down the clients of all the nodes
+func (q *BtcQuorum) Shutdown() {
+	for _, c := range q.clients {
+		c.Shutdown()
+	}
+}
+
+// btcBlockDigest returns a digest of the block's fields which the scanner uses, except NextHash
+func btcBlockDigest(block *btcjson.GetBlockVerboseResult) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s:%d:%d\n", block.Hash, block.Height, len(block.RawTx))
+	for _, tx := range block.RawTx {
+		fmt.Fprintf(h, "%s:%d\n", tx.Txid, len(tx.Vout))
+		for _, v := range tx.Vout {
+			fmt.Fprintf(h, "%d:%v:%q\n", v.N, v.Value, v.ScriptPubKey.Addresses)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,231 @@
+package scanner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+var errNodeDown = errors.New("node down")
+
+// quorumNode is a btcd node with a chain of blocks, keyed by hash
+type quorumNode struct {
+	count    int64
+	hashes   map[int64]string
+	blocks   map[string]*btcjson.GetBlockVerboseResult
+	err      error
+	shutdown bool
+}
+
+func (n *quorumNode) GetBlockVerboseTx(hash *chainhash.Hash) (*btcjson.GetBlockVerboseResult, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	b, ok := n.blocks[hash.String()]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	cp := *b
+	return &cp, nil
+}
+
+func (n *quorumNode) GetBlockHash(height int64) (*chainhash.Hash, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	hash, ok := n.hashes[height]
+	if !ok {
+		return nil, errNoBlockHash
+	}
+	return chainhash.NewHashFromStr(hash)
+}
+
+func (n *quorumNode) GetBlockCount() (int64, error) {
+	if n.err != nil {
+		return 0, n.err
+	}
+	return n.count, nil
+}
+
+func (n *quorumNode) Shutdown() {
+	n.shutdown = true
+}
+
+const (
+	quorumHash1 = "00000000000000000000000000000000000000000000000000000000000000a1"
+	quorumHash2 = "00000000000000000000000000000000000000000000000000000000000000a2"
+	quorumHashB = "00000000000000000000000000000000000000000000000000000000000000b2"
+)
+
+func quorumBlock(hash string, height int64, nextHash string, value float64) *btcjson.GetBlockVerboseResult {
+	return &btcjson.GetBlockVerboseResult{
+		Hash:     hash,
+		Height:   height,
+		NextHash: nextHash,
+		RawTx: []btcjson.TxRawResult{
+			{
+				Txid: "tx" + hash[len(hash)-2:],
+				Vout: []btcjson.Vout{
+					{
+						Value: value,
+						N:     0,
+						ScriptPubKey: btcjson.ScriptPubKeyResult{
+							Addresses: []string{"1LEkderht5M5yWj82M87bEd4XDBsczLkp9"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func newQuorumNode(count int64, blocks ...*btcjson.GetBlockVerboseResult) *quorumNode {
+	n := &quorumNode{
+		count:  count,
+		hashes: make(map[int64]string),
+		blocks: make(map[string]*btcjson.GetBlockVerboseResult),
+	}
+	for _, b := range blocks {
+		n.hashes[b.Height] = b.Hash
+		n.blocks[b.Hash] = b
+	}
+	return n
+}
+
+func TestNewBtcQuorum(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	_, err := NewBtcQuorum(log, nil, 1)
+	require.Error(t, err)
+
+	nodes := []BtcRPCClient{&quorumNode{}, &quorumNode{}}
+	_, err = NewBtcQuorum(log, nodes, 0)
+	require.Error(t, err)
+	_, err = NewBtcQuorum(log, nodes, 3)
+	require.Error(t, err)
+
+	q, err := NewBtcQuorum(log, nodes, 2)
+	require.NoError(t, err)
+
+	q.Shutdown()
+	for _, n := range nodes {
+		require.True(t, n.(*quorumNode).shutdown)
+	}
+}
+
+func TestBtcQuorumGetBlockCount(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	a := newQuorumNode(12)
+	b := newQuorumNode(10)
+	c := newQuorumNode(11)
+	q, err := NewBtcQuorum(log, []BtcRPCClient{a, b, c}, 2)
+	require.NoError(t, err)
+
+	// The highest block count which 2 nodes have reached
+	n, err := q.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(11), n)
+
+	c.err = errNodeDown
+	n, err = q.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(10), n)
+
+	b.err = errNodeDown
+	_, err = q.GetBlockCount()
+	require.Equal(t, ErrNoQuorum, err)
+}
+
+func TestBtcQuorumGetBlockHash(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	a := newQuorumNode(2, quorumBlock(quorumHash1, 1, quorumHash2, 1), quorumBlock(quorumHash2, 2, "", 1))
+	b := newQuorumNode(2, quorumBlock(quorumHash1, 1, quorumHash2, 1), quorumBlock(quorumHash2, 2, "", 1))
+	c := newQuorumNode(2, quorumBlock(quorumHash1, 1, quorumHashB, 1), quorumBlock(quorumHashB, 2, "", 1))
+	q, err := NewBtcQuorum(log, []BtcRPCClient{a, b, c}, 2)
+	require.NoError(t, err)
+
+	hash, err := q.GetBlockHash(1)
+	require.NoError(t, err)
+	require.Equal(t, quorumHash1, hash.String())
+
+	// The node on another chain is outvoted
+	hash, err = q.GetBlockHash(2)
+	require.NoError(t, err)
+	require.Equal(t, quorumHash2, hash.String())
+
+	// No quorum if one of the agreeing nodes fails
+	b.err = errNodeDown
+	_, err = q.GetBlockHash(2)
+	require.Equal(t, ErrNoQuorum, err)
+
+	// A node which doesn't have the block yet doesn't count
+	b.err = nil
+	delete(b.hashes, 2)
+	_, err = q.GetBlockHash(2)
+	require.Equal(t, ErrNoQuorum, err)
+}
+
+func TestBtcQuorumGetBlockVerboseTx(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	a := newQuorumNode(2, quorumBlock(quorumHash1, 1, quorumHash2, 1))
+	b := newQuorumNode(1, quorumBlock(quorumHash1, 1, "", 1))
+	c := newQuorumNode(1, quorumBlock(quorumHash1, 1, "", 5))
+	q, err := NewBtcQuorum(log, []BtcRPCClient{a, b, c}, 2)
+	require.NoError(t, err)
+
+	hash1, err := chainhash.NewHashFromStr(quorumHash1)
+	require.NoError(t, err)
+
+	// a and b agree on the transactions, but only a has the next block
+	block, err := q.GetBlockVerboseTx(hash1)
+	require.NoError(t, err)
+	require.Equal(t, quorumHash1, block.Hash)
+	require.Equal(t, 1.0, block.RawTx[0].Vout[0].Value)
+	require.Empty(t, block.NextHash)
+
+	b.blocks[quorumHash1].NextHash = quorumHash2
+	block, err = q.GetBlockVerboseTx(hash1)
+	require.NoError(t, err)
+	require.Equal(t, quorumHash2, block.NextHash)
+
+	// The node's block isn't modified
+	require.Equal(t, quorumHash2, a.blocks[quorumHash1].NextHash)
+
+	// A node reporting a different deposit value doesn't agree
+	a.err = errNodeDown
+	_, err = q.GetBlockVerboseTx(hash1)
+	require.Equal(t, ErrNoQuorum, err)
+}
+
+func TestBtcBlockDigest(t *testing.T) {
+	block := quorumBlock(quorumHash1, 1, quorumHash2, 1)
+	d := btcBlockDigest(block)
+
+	// NextHash is not part of the digest
+	block.NextHash = ""
+	require.Equal(t, d, btcBlockDigest(block))
+
+	for _, f := range []func(b *btcjson.GetBlockVerboseResult){
+		func(b *btcjson.GetBlockVerboseResult) { b.Hash = quorumHash2 },
+		func(b *btcjson.GetBlockVerboseResult) { b.Height = 2 },
+		func(b *btcjson.GetBlockVerboseResult) { b.RawTx[0].Txid = "tx" },
+		func(b *btcjson.GetBlockVerboseResult) { b.RawTx[0].Vout[0].Value = 1.00000001 },
+		func(b *btcjson.GetBlockVerboseResult) { b.RawTx[0].Vout[0].N = 1 },
+		func(b *btcjson.GetBlockVerboseResult) {
+			b.RawTx[0].Vout[0].ScriptPubKey.Addresses = []string{"1KJ2BVa3MhQBMqXqyGqE9bgoUFRfYDtHdu"}
+		},
+		func(b *btcjson.GetBlockVerboseResult) { b.RawTx = append(b.RawTx, btcjson.TxRawResult{}) },
+	} {
+		b := quorumBlock(quorumHash1, 1, quorumHash2, 1)
+		f(b)
+		require.NotEqual(t, d, btcBlockDigest(b))
+	}
+}
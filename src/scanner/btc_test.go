@@ -12,6 +12,7 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/stretchr/testify/require"
 
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/testutil"
 )
@@ -183,7 +184,7 @@ func testScannerRunProcessedLoop(t *testing.T, scr *BTCScanner, nDeposits int64)
 		// check all deposits
 		err := scr.Base.GetStorer().(*Store).db.View(func(tx *bolt.Tx) error {
 			for _, dv := range dvs {
-				var d Deposit
+				var d deposits.Deposit
 				err := dbutil.GetBucketObject(tx, DepositBkt, dv.ID(), &d)
 				require.NoError(t, err)
 				if err != nil {
@@ -193,7 +194,7 @@ func testScannerRunProcessedLoop(t *testing.T, scr *BTCScanner, nDeposits int64)
 				require.True(t, d.Processed)
 				require.Equal(t, CoinTypeBTC, d.CoinType)
 				require.NotEmpty(t, d.Address)
-				require.NotEmpty(t, d.Value)
+				require.NotEmpty(t, d.Amount)
 				require.NotEmpty(t, d.Height)
 				require.NotEmpty(t, d.Tx)
 			}
@@ -370,11 +371,11 @@ func testScannerLoadUnprocessedDeposits(t *testing.T, btcDB *bolt.DB) {
 	defer shutdown()
 
 	// NOTE: This data is fake, but the addresses and Txid are valid
-	unprocessedDeposits := []Deposit{
+	unprocessedDeposits := []deposits.Deposit{
 		{
 			CoinType:  CoinTypeBTC,
 			Address:   "1LEkderht5M5yWj82M87bEd4XDBsczLkp9",
-			Value:     1e8,
+			Amount:    1e8,
 			Height:    23505,
 			Tx:        "239e007dc20805add047d305cdfb87de1bae9bea1e47acbf58f38731ad58d70d",
 			N:         1,
@@ -383,7 +384,7 @@ func testScannerLoadUnprocessedDeposits(t *testing.T, btcDB *bolt.DB) {
 		{
 			CoinType:  CoinTypeBTC,
 			Address:   "16Lr3Zhjjb7KxeDxGPUrh3DMo29Lstif7j",
-			Value:     10e8,
+			Amount:    10e8,
 			Height:    23505,
 			Tx:        "bf41a5352b6d59a401cd946432117b25fd5fc43186aef5cbbe3170c40050d104",
 			N:         1,
@@ -391,10 +392,10 @@ func testScannerLoadUnprocessedDeposits(t *testing.T, btcDB *bolt.DB) {
 		},
 	}
 
-	processedDeposit := Deposit{
+	processedDeposit := deposits.Deposit{
 		CoinType:  CoinTypeBTC,
 		Address:   "1GH9ukgyetEJoWQFwUUeLcWQ8UgVgipLKb",
-		Value:     100e8,
+		Amount:    100e8,
 		Height:    23517,
 		Tx:        "d61be86942d69dc7ba6d49c817957ecd0918798f030c73739206e6f48fe2a7c5",
 		N:         1,
@@ -453,7 +454,7 @@ func testScannerProcessDepositError(t *testing.T, btcDB *bolt.DB) {
 		// check all deposits, none should be marked as "Processed"
 		err := scr.Base.GetStorer().(*Store).db.View(func(tx *bolt.Tx) error {
 			for _, dv := range dvs {
-				var d Deposit
+				var d deposits.Deposit
 				err := dbutil.GetBucketObject(tx, DepositBkt, dv.ID(), &d)
 				require.NoError(t, err)
 				if err != nil {
@@ -463,7 +464,7 @@ func testScannerProcessDepositError(t *testing.T, btcDB *bolt.DB) {
 				require.False(t, d.Processed)
 				require.Equal(t, CoinTypeBTC, d.CoinType)
 				require.Equal(t, "1LEkderht5M5yWj82M87bEd4XDBsczLkp9", d.Address)
-				require.NotEmpty(t, d.Value)
+				require.NotEmpty(t, d.Amount)
 				require.NotEmpty(t, d.Height)
 				require.NotEmpty(t, d.Tx)
 			}
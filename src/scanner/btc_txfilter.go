@@ -0,0 +1,246 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// BtcTxFilterClient is a btcd websocket client which can filter the transactions
+// of a block by address, using btcd's loadtxfilter and rescanblocks commands
+type BtcTxFilterClient interface {
+	BtcRPCClient
+	GetBlockHeaderVerbose(*chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error)
+	LoadTxFilter(reload bool, addresses []btcutil.Address, outPoints []wire.OutPoint) error
+	RescanBlocks([]chainhash.Hash) ([]btcjson.RescannedBlock, error)
+}
+
+// BtcTxFilter tracks whether the scan addresses are loaded into btcd's
+// transaction filter. btcd keeps the filter per websocket connection,
+// so Reset must be called whenever the client connects or reconnects,
+// e.g. from rpcclient.NotificationHandlers.OnClientConnected.
+// The filter is then reloaded before the next block is scanned.
+type BtcTxFilter struct {
+	sync.Mutex
+	loaded int32
+}
+
+// NewBtcTxFilter creates a BtcTxFilter
+func NewBtcTxFilter() *BtcTxFilter {
+	return &BtcTxFilter{}
+}
+
+// Reset marks the filter as not loaded
+func (f *BtcTxFilter) Reset() {
+	atomic.StoreInt32(&f.loaded, 0)
+}
+
+func (f *BtcTxFilter) isLoaded() bool {
+	return atomic.LoadInt32(&f.loaded) == 1
+}
+
+func (f *BtcTxFilter) setLoaded() {
+	atomic.StoreInt32(&f.loaded, 1)
+}
+
+// decodeBtcAddresses decodes mainnet addresses for the transaction filter
+func decodeBtcAddresses(addrs []string) ([]btcutil.Address, error) {
+	as := make([]btcutil.Address, 0, len(addrs))
+	for _, a := range addrs {
+		addr, err := btcutil.DecodeAddress(a, &chaincfg.MainNetParams)
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, addr)
+	}
+	return as, nil
+}
+
+// loadTxFilter loads all scan addresses into btcd's transaction filter, if not loaded yet
+func (s *BTCScanner) loadTxFilter() error {
+	s.txFilter.Lock()
+	defer s.txFilter.Unlock()
+
+	if s.txFilter.isLoaded() {
+		return nil
+	}
+
+	addrs, err := s.GetScanAddresses()
+	if err != nil {
+		s.log.WithError(err).Error("GetScanAddresses failed")
+		return err
+	}
+
+	as, err := decodeBtcAddresses(addrs)
+	if err != nil {
+		s.log.WithError(err).Error("decodeBtcAddresses failed")
+		return err
+	}
+
+	if err := s.txFilterClient.LoadTxFilter(true, as, nil); err != nil {
+		s.log.WithError(err).Error("btcClient.LoadTxFilter failed")
+		return err
+	}
+
+	s.txFilter.setLoaded()
+	s.log.WithField("addresses", len(as)).Info("Loaded btcd transaction filter")
+
+	return nil
+}
+
+// addTxFilterAddress adds an address to btcd's transaction filter.
+// If the filter is not loaded, the address will be included when it is.
+func (s *BTCScanner) addTxFilterAddress(addr string) {
+	log := s.log.WithField("addr", addr)
+
+	s.txFilter.Lock()
+	defer s.txFilter.Unlock()
+
+	if !s.txFilter.isLoaded() {
+		return
+	}
+
+	as, err := decodeBtcAddresses([]string{addr})
+	if err == nil {
+		err = s.txFilterClient.LoadTxFilter(false, as, nil)
+	}
+
+	if err != nil {
+		// Reload the whole filter before scanning the next block
+		log.WithError(err).Warn("Add address to btcd transaction filter failed, the filter will be reloaded")
+		s.txFilter.Reset()
+	}
+}
+
+// getFilteredBlock returns a block which only includes the transactions
+// relevant to the scan addresses
+func (s *BTCScanner) getFilteredBlock(hash *chainhash.Hash) (*CommonBlock, error) {
+	log := s.log.WithField("blockHash", hash.String())
+
+	if err := s.loadTxFilter(); err != nil {
+		return nil, err
+	}
+
+	header, err := s.txFilterClient.GetBlockHeaderVerbose(hash)
+	if err != nil {
+		log.WithError(err).Error("btcClient.GetBlockHeaderVerbose failed")
+		return nil, err
+	}
+
+	rbs, err := s.txFilterClient.RescanBlocks([]chainhash.Hash{*hash})
+	if err != nil {
+		log.WithError(err).Error("btcClient.RescanBlocks failed")
+		return nil, err
+	}
+
+	return btcFilteredBlock2CommonBlock(header, rbs)
+}
+
+// waitForNextHash polls the block header until NextHash is set.
+// The block's transactions were already scanned, so the block is not fetched again.
+func (s *BTCScanner) waitForNextHash(block *CommonBlock, hash *chainhash.Hash) (*CommonBlock, error) {
+	log := s.log.WithField("blockHash", block.Hash)
+
+	for {
+		header, err := s.txFilterClient.GetBlockHeaderVerbose(hash)
+		if err != nil {
+			log.WithError(err).Error("btcClient.GetBlockHeaderVerbose failed, retrying")
+		}
+
+		if err != nil || header.NextHash == "" {
+			if !s.Base.GetPollScheduler().Wait(s.Base.GetQuitChan()) {
+				return nil, errQuit
+			}
+			continue
+		}
+
+		nextBlock := *block
+		nextBlock.NextHash = header.NextHash
+		return &nextBlock, nil
+	}
+}
+
+// btcFilteredBlock2CommonBlock converts a block header and its rescanned transactions to a common block
+func btcFilteredBlock2CommonBlock(header *btcjson.GetBlockHeaderVerboseResult, rbs []btcjson.RescannedBlock) (*CommonBlock, error) {
+	cb := CommonBlock{
+		Hash:     header.Hash,
+		NextHash: header.NextHash,
+		Height:   int64(header.Height),
+	}
+
+	for _, rb := range rbs {
+		if rb.Hash != header.Hash {
+			continue
+		}
+
+		cb.RawTx = make([]CommonTx, 0, len(rb.Transactions))
+		for _, txHex := range rb.Transactions {
+			b, err := hex.DecodeString(txHex)
+			if err != nil {
+				return nil, err
+			}
+
+			var tx wire.MsgTx
+			if err := tx.Deserialize(bytes.NewReader(b)); err != nil {
+				return nil, err
+			}
+
+			cbTx := CommonTx{
+				Txid: tx.TxHash().String(),
+				Vout: make([]CommonVout, 0, len(tx.TxOut)),
+			}
+
+			for _, o := range tx.TxOut {
+				// N is left unset, the same as btcBlock2CommonBlock,
+				// so that deposit IDs don't depend on the scan mode
+				cbTx.Vout = append(cbTx.Vout, CommonVout{
+					Value:     o.Value,
+					Addresses: pkScriptAddrs(o.PkScript),
+				})
+			}
+
+			cb.RawTx = append(cb.RawTx, cbTx)
+		}
+	}
+
+	return &cb, nil
+}
+
+// pkScriptAddrs returns the mainnet address paid by a P2PKH or P2SH output script.
+// Other script types can't pay to a teller deposit address.
+func pkScriptAddrs(pkScript []byte) []string {
+	const (
+		opDup         = 0x76
+		opHash160     = 0xa9
+		opData20      = 0x14
+		opEqual       = 0x87
+		opEqualVerify = 0x88
+		opCheckSig    = 0xac
+	)
+
+	var addr btcutil.Address
+	var err error
+
+	switch {
+	case len(pkScript) == 25 && pkScript[0] == opDup && pkScript[1] == opHash160 &&
+		pkScript[2] == opData20 && pkScript[23] == opEqualVerify && pkScript[24] == opCheckSig:
+		addr, err = btcutil.NewAddressPubKeyHash(pkScript[3:23], &chaincfg.MainNetParams)
+	case len(pkScript) == 23 && pkScript[0] == opHash160 && pkScript[1] == opData20 && pkScript[22] == opEqual:
+		addr, err = btcutil.NewAddressScriptHashFromHash(pkScript[2:22], &chaincfg.MainNetParams)
+	default:
+		return nil
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	return []string{addr.EncodeAddress()}
+}
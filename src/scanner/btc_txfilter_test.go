@@ -0,0 +1,229 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+var errNoTxFilter = errors.New("Transaction filter must be loaded before rescanning")
+
+// dummyBtcTxFilterClient filters blocks like btcd's rescanblocks
+type dummyBtcTxFilterClient struct {
+	*dummyBtcrpcclient
+	filter          map[string]struct{}
+	loadCount       int
+	rescanCallCount int
+}
+
+func (c *dummyBtcTxFilterClient) GetBlockHeaderVerbose(hash *chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error) {
+	b, err := c.GetBlockVerboseTx(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return &btcjson.GetBlockHeaderVerboseResult{
+		Hash:     b.Hash,
+		Height:   int32(b.Height),
+		NextHash: b.NextHash,
+	}, nil
+}
+
+func (c *dummyBtcTxFilterClient) LoadTxFilter(reload bool, addresses []btcutil.Address, outPoints []wire.OutPoint) error {
+	if reload || c.filter == nil {
+		c.filter = make(map[string]struct{})
+		c.loadCount++
+	}
+
+	for _, a := range addresses {
+		c.filter[a.EncodeAddress()] = struct{}{}
+	}
+
+	return nil
+}
+
+func (c *dummyBtcTxFilterClient) RescanBlocks(hashes []chainhash.Hash) ([]btcjson.RescannedBlock, error) {
+	c.rescanCallCount++
+
+	if c.filter == nil {
+		return nil, errNoTxFilter
+	}
+
+	var rbs []btcjson.RescannedBlock
+	for i := range hashes {
+		var b btcjson.GetBlockVerboseResult
+		if err := c.db.View(func(tx *bolt.Tx) error {
+			return json.Unmarshal(tx.Bucket(dummyBlocksBktName).Get([]byte(hashes[i].String())), &b)
+		}); err != nil {
+			return nil, err
+		}
+
+		rb := btcjson.RescannedBlock{
+			Hash: b.Hash,
+		}
+
+	loop:
+		for _, tx := range b.RawTx {
+			for _, v := range tx.Vout {
+				for _, a := range v.ScriptPubKey.Addresses {
+					if _, ok := c.filter[a]; ok {
+						rb.Transactions = append(rb.Transactions, tx.Hex)
+						continue loop
+					}
+				}
+			}
+		}
+
+		if len(rb.Transactions) != 0 {
+			rbs = append(rbs, rb)
+		}
+	}
+
+	return rbs, nil
+}
+
+// disconnect simulates btcd forgetting the filter
+func (c *dummyBtcTxFilterClient) disconnect() {
+	c.filter = nil
+}
+
+func setupTxFilterScanner(t *testing.T, btcDB *bolt.DB) (*BTCScanner, *dummyBtcTxFilterClient, func()) {
+	db, shutdown := testutil.PrepareDB(t)
+
+	log, _ := testutil.NewLogger(t)
+
+	rpc := &dummyBtcTxFilterClient{
+		dummyBtcrpcclient: newDummyBtcrpcclient(btcDB),
+	}
+	rpc.blockHashes[235205] = "000000000000018d8ece83a004c5a919210d67798d13aa901c4d07f8bf87b719"
+	rpc.blockCount = 235214
+
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+	store.AddSupportedCoin(CoinTypeBTC)
+
+	scr, err := NewBTCScannerTxFilter(log, store, rpc, NewBtcTxFilter(), Config{
+		ScanPeriod:            time.Millisecond * 10,
+		DepositBufferSize:     5,
+		InitialScanHeight:     235205,
+		ConfirmationsRequired: 0,
+	})
+	require.NoError(t, err)
+
+	return scr, rpc, shutdown
+}
+
+func TestBtcFilteredBlockMatchesVerboseBlock(t *testing.T) {
+	btcDB := openDummyBtcDB(t)
+	defer btcDB.Close()
+
+	// Decoding the raw transactions must find the same addresses and values
+	// as btcd's verbose block
+	err := btcDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(dummyBlocksBktName).ForEach(func(k, v []byte) error {
+			var b btcjson.GetBlockVerboseResult
+			require.NoError(t, json.Unmarshal(v, &b))
+
+			rb := btcjson.RescannedBlock{
+				Hash: b.Hash,
+			}
+			for _, tx := range b.RawTx {
+				rb.Transactions = append(rb.Transactions, tx.Hex)
+			}
+
+			header := &btcjson.GetBlockHeaderVerboseResult{
+				Hash:     b.Hash,
+				Height:   int32(b.Height),
+				NextHash: b.NextHash,
+			}
+
+			filtered, err := btcFilteredBlock2CommonBlock(header, []btcjson.RescannedBlock{rb})
+			require.NoError(t, err)
+
+			verbose, err := btcBlock2CommonBlock(&b)
+			require.NoError(t, err)
+
+			require.Equal(t, len(verbose.RawTx), len(filtered.RawTx))
+			for i, tx := range verbose.RawTx {
+				require.Equal(t, tx.Txid, filtered.RawTx[i].Txid)
+				require.Equal(t, len(tx.Vout), len(filtered.RawTx[i].Vout))
+				for j, v := range tx.Vout {
+					fv := filtered.RawTx[i].Vout[j]
+					require.Equal(t, v.Value, fv.Value)
+					// P2PK and other script types are not decoded
+					if len(fv.Addresses) != 0 {
+						require.Equal(t, v.Addresses, fv.Addresses)
+					}
+				}
+			}
+
+			return nil
+		})
+	})
+	require.NoError(t, err)
+}
+
+func TestScannerTxFilterRunProcessDeposits(t *testing.T) {
+	btcDB := openDummyBtcDB(t)
+	defer btcDB.Close()
+
+	scr, rpc, shutdown := setupTxFilterScanner(t, btcDB)
+	defer shutdown()
+
+	testScannerRun(t, scr)
+
+	require.Equal(t, 1, rpc.loadCount)
+	require.Len(t, rpc.filter, 3)
+	require.NotZero(t, rpc.rescanCallCount)
+}
+
+func TestScannerTxFilterReload(t *testing.T) {
+	btcDB := openDummyBtcDB(t)
+	defer btcDB.Close()
+
+	scr, rpc, shutdown := setupTxFilterScanner(t, btcDB)
+	defer shutdown()
+
+	err := scr.AddScanAddress("1N8G4JM8krsHLQZjC51R7ZgwDyihmgsQYA", CoinTypeBTC)
+	require.NoError(t, err)
+
+	// The filter is loaded before the first block is scanned
+	require.Nil(t, rpc.filter)
+	hash, err := chainhash.NewHashFromStr(rpc.blockHashes[235205])
+	require.NoError(t, err)
+	_, err = scr.getFilteredBlock(hash)
+	require.NoError(t, err)
+	require.Equal(t, 1, rpc.loadCount)
+	require.Len(t, rpc.filter, 1)
+
+	// Addresses added while loaded are added to the filter
+	err = scr.AddScanAddress("1LEkderht5M5yWj82M87bEd4XDBsczLkp9", CoinTypeBTC)
+	require.NoError(t, err)
+	require.Len(t, rpc.filter, 2)
+
+	// After a reconnect the filter is reloaded with all addresses
+	rpc.disconnect()
+	scr.txFilter.Reset()
+
+	b, err := scr.getFilteredBlock(hash)
+	require.NoError(t, err)
+	require.Equal(t, 2, rpc.loadCount)
+	require.Len(t, rpc.filter, 2)
+	require.NotEmpty(t, b.RawTx)
+
+	// If the filter is lost without a reset, rescanning fails rather than
+	// silently returning no transactions
+	rpc.disconnect()
+	_, err = scr.getFilteredBlock(hash)
+	require.Equal(t, errNoTxFilter, err)
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/skycoin/skycoin/src/cipher"
 
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/util/httputil"
 )
 
@@ -18,7 +19,10 @@ type DummyScanner struct {
 	addrs    []string
 	addrsMap map[string]struct{}
 	deposits chan DepositNote
-	log      logrus.FieldLogger
+	quit     chan struct{}
+	// closed is true once Shutdown closed the deposits channel
+	closed bool
+	log    logrus.FieldLogger
 	sync.RWMutex
 }
 
@@ -28,9 +32,29 @@ func NewDummyScanner(log logrus.FieldLogger) *DummyScanner {
 		log:      log.WithField("prefix", "scanner.dummy"),
 		addrsMap: make(map[string]struct{}),
 		deposits: make(chan DepositNote, 100),
+		quit:     make(chan struct{}),
 	}
 }
 
+// Run waits for Shutdown, the deposits are added from the HTTP interface
+func (s *DummyScanner) Run() error {
+	<-s.quit
+	return nil
+}
+
+// Shutdown stops Run and closes the deposits channel
+func (s *DummyScanner) Shutdown() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.deposits)
+	close(s.quit)
+}
+
 // AddScanAddress adds an address
 func (s *DummyScanner) AddScanAddress(addr, coinType string) error {
 	s.Lock()
@@ -64,6 +88,11 @@ func (s *DummyScanner) GetDeposit() <-chan DepositNote {
 	return s.deposits
 }
 
+// PendingDeposits returns no deposits, the dummy deposits are final when they are added
+func (s *DummyScanner) PendingDeposits(coinType string) []deposits.Deposit {
+	return nil
+}
+
 // HTTP Interface
 
 // BindHandlers binds dummy scanner HTTP handlers
@@ -135,14 +164,23 @@ func (s *DummyScanner) addDepositHandler(w http.ResponseWriter, r *http.Request)
 		n = uint32(n64)
 	}
 
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.closed {
+		httputil.ErrResponse(w, http.StatusServiceUnavailable, "scanner is shut down")
+		return
+	}
+
 	select {
-	case s.deposits <- NewDepositNote(Deposit{
+	case s.deposits <- NewDepositNote(deposits.Deposit{
 		CoinType: coinType,
 		Address:  addr,
-		Value:    value,
+		Amount:   value,
 		Height:   height,
 		Tx:       tx,
 		N:        n,
+		Final:    true,
 	}):
 	default:
 		httputil.ErrResponse(w, http.StatusServiceUnavailable, "deposits channel is full")
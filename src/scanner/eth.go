@@ -8,6 +8,7 @@ package scanner
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 	"strconv"
 	"strings"
@@ -18,7 +19,9 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/sirupsen/logrus"
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/util/mathutil"
+	"github.com/skycoin/teller/src/util/pauseutil"
 )
 
 // ETHScanner blockchain scanner to check if there're deposit coins
@@ -31,7 +34,10 @@ type ETHScanner struct {
 // NewETHScanner creates scanner instance
 func NewETHScanner(log logrus.FieldLogger, store Storer, eth EthRPCClient, cfg Config) (*ETHScanner, error) {
 
-	bs := NewBaseScanner(store, log.WithField("prefix", "scanner.eth"), cfg)
+	bs, err := NewBaseScanner(store, log.WithField("prefix", "scanner.eth"), CoinTypeETH, cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	return &ETHScanner{
 		ethClient: eth,
@@ -45,6 +51,16 @@ func (s *ETHScanner) Run() error {
 	return s.Base.Run(s.ethClient.GetBlockCount, s.getBlockAtHeight, s.waitForNextBlock, s.scanBlock)
 }
 
+// GetPauseGate returns the gate which pauses block scanning
+func (s *ETHScanner) GetPauseGate() *pauseutil.Gate {
+	return s.Base.GetPauseGate()
+}
+
+// GetPollScheduler returns the scheduler of the polls for a new block
+func (s *ETHScanner) GetPollScheduler() *PollScheduler {
+	return s.Base.GetPollScheduler()
+}
+
 // Shutdown shutdown the scanner
 func (s *ETHScanner) Shutdown() {
 	s.log.Info("Closing ETH scanner")
@@ -118,12 +134,10 @@ func (s *ETHScanner) waitForNextBlock(block *CommonBlock) (*CommonBlock, error)
 			log.Debug("No new block yet")
 		}
 		if err != nil || nextBlock == nil {
-			select {
-			case <-s.Base.GetQuitChan():
+			if !s.Base.GetPollScheduler().Wait(s.Base.GetQuitChan()) {
 				return nil, errQuit
-			case <-time.After(s.Base.GetScanPeriod()):
-				continue
 			}
+			continue
 		}
 
 		log.WithFields(logrus.Fields{
@@ -137,7 +151,14 @@ func (s *ETHScanner) waitForNextBlock(block *CommonBlock) (*CommonBlock, error)
 
 // AddScanAddress adds new scan address
 func (s *ETHScanner) AddScanAddress(addr, coinType string) error {
-	return s.Base.GetStorer().AddScanAddress(addr, coinType)
+	if err := s.Base.GetStorer().AddScanAddress(addr, coinType); err != nil {
+		return err
+	}
+
+	// A deposit to the new address is likely soon
+	s.Base.GetPollScheduler().Boost(time.Now())
+
+	return nil
 }
 
 // GetScanAddresses returns the deposit addresses that need to scan
@@ -145,6 +166,11 @@ func (s *ETHScanner) GetScanAddresses() ([]string, error) {
 	return s.Base.GetStorer().GetScanAddresses(CoinTypeETH)
 }
 
+// PendingDeposits returns the deposits detected in a block which is not final yet
+func (s *ETHScanner) PendingDeposits(coinType string) []deposits.Deposit {
+	return s.Base.PendingDeposits()
+}
+
 // GetDeposit returns deposit value channel.
 func (s *ETHScanner) GetDeposit() <-chan DepositNote {
 	return s.Base.GetDeposit()
@@ -166,7 +192,10 @@ func ethBlock2CommonBlock(block *types.Block) (*CommonBlock, error) {
 		cbTx.Txid = tx.Hash().String()
 		cbTx.Vout = make([]CommonVout, 0, 1)
 		//1 eth = 1e18 wei ,tx.Value() is very big that may overflow(int64), so store it as Gwei(1Gwei=1e9wei) and recover it when used
-		amt := mathutil.Wei2Gwei(tx.Value())
+		amt, err := mathutil.Wei2Gwei(tx.Value())
+		if err != nil {
+			return nil, fmt.Errorf("tx %s value %s: %v", cbTx.Txid, tx.Value(), err)
+		}
 
 		//ethcoin address must be lowercase
 		realaddr := strings.ToLower(to.String())
@@ -229,6 +258,18 @@ func (ec *EthClient) GetBlockVerboseTx(seq uint64) (*types.Block, error) {
 	return block, nil
 }
 
+// TipTime returns the block time of geth's latest block
+func (ec *EthClient) TipTime() (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	header, err := ethclient.NewClient(ec.c).HeaderByNumber(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(header.Time.Int64(), 0), nil
+}
+
 //GetTransaction returns transaction by txhash
 func (ec *EthClient) GetTransaction(txhash common.Hash) (*types.Transaction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -11,6 +11,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/stretchr/testify/require"
 
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/testutil"
 )
@@ -200,7 +201,7 @@ func testEthScannerRunProcessedLoop(t *testing.T, scr *ETHScanner, nDeposits int
 		// check all deposits
 		err := scr.Base.GetStorer().(*Store).db.View(func(tx *bolt.Tx) error {
 			for _, dv := range dvs {
-				var d Deposit
+				var d deposits.Deposit
 				err := dbutil.GetBucketObject(tx, DepositBkt, dv.ID(), &d)
 				require.NoError(t, err)
 				if err != nil {
@@ -210,8 +211,8 @@ func testEthScannerRunProcessedLoop(t *testing.T, scr *ETHScanner, nDeposits int
 				require.True(t, d.Processed)
 				require.Equal(t, CoinTypeETH, d.CoinType)
 				require.NotEmpty(t, d.Address)
-				if d.Value != 0 { //value(0x87b127ee022abcf9881b9bad6bb6aac25229dff0) = 0
-					require.NotEmpty(t, d.Value)
+				if d.Amount != 0 { //value(0x87b127ee022abcf9881b9bad6bb6aac25229dff0) = 0
+					require.NotEmpty(t, d.Amount)
 				}
 				require.NotEmpty(t, d.Height)
 				require.NotEmpty(t, d.Tx)
@@ -376,11 +377,11 @@ func testEthScannerLoadUnprocessedDeposits(t *testing.T, ethDB *bolt.DB) {
 	defer shutdown()
 
 	// NOTE: This data is fake, but the addresses and Txid are valid
-	unprocessedDeposits := []Deposit{
+	unprocessedDeposits := []deposits.Deposit{
 		{
 			CoinType:  CoinTypeETH,
 			Address:   "0x196736a260c6e7c86c88a73e2ffec400c9caef71",
-			Value:     1e8,
+			Amount:    1e8,
 			Height:    2325212,
 			Tx:        "0xc724f4aae6f89e6296aec22c6795e7423b6776e2ee3c5f942cf3817a9ded0c32",
 			N:         1,
@@ -389,7 +390,7 @@ func testEthScannerLoadUnprocessedDeposits(t *testing.T, ethDB *bolt.DB) {
 		{
 			CoinType:  CoinTypeETH,
 			Address:   "0x2a5ee9b4307a0030982ed00ca7e904a20fc53a12",
-			Value:     10e8,
+			Amount:    10e8,
 			Height:    2325212,
 			Tx:        "0xca8d662c6cf2dcd0e8c9075b58bfbfa7ee4769e5efd6f45e490309d58074913e",
 			N:         1,
@@ -397,10 +398,10 @@ func testEthScannerLoadUnprocessedDeposits(t *testing.T, ethDB *bolt.DB) {
 		},
 	}
 
-	processedDeposit := Deposit{
+	processedDeposit := deposits.Deposit{
 		CoinType:  CoinTypeETH,
 		Address:   "0x87b127ee022abcf9881b9bad6bb6aac25229dff0",
-		Value:     100e8,
+		Amount:    100e8,
 		Height:    2325212,
 		Tx:        "0x01d15c4d79953e2c647ce668045e8d98369ff958b2b021fbdf9e39bceab3add9",
 		N:         1,
@@ -456,7 +457,7 @@ func testEthScannerProcessDepositError(t *testing.T, ethDB *bolt.DB) {
 		// check all deposits, none should be marked as "Processed"
 		err := scr.Base.GetStorer().(*Store).db.View(func(tx *bolt.Tx) error {
 			for _, dv := range dvs {
-				var d Deposit
+				var d deposits.Deposit
 				err := dbutil.GetBucketObject(tx, DepositBkt, dv.ID(), &d)
 				require.NoError(t, err)
 				if err != nil {
@@ -466,8 +467,8 @@ func testEthScannerProcessDepositError(t *testing.T, ethDB *bolt.DB) {
 				require.False(t, d.Processed)
 				require.Equal(t, CoinTypeETH, d.CoinType)
 				require.Equal(t, "0xbfc39b6f805a9e40e77291aff27aee3c96915bdd", d.Address)
-				if d.Value != 0 { //value(0x87b127ee022abcf9881b9bad6bb6aac25229dff0) = 0
-					require.NotEmpty(t, d.Value)
+				if d.Amount != 0 { //value(0x87b127ee022abcf9881b9bad6bb6aac25229dff0) = 0
+					require.NotEmpty(t, d.Amount)
 				}
 				require.NotEmpty(t, d.Height)
 				require.NotEmpty(t, d.Tx)
@@ -0,0 +1,243 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	checkpointCheckInterval = time.Minute
+	checkpointSourceTimeout = time.Second * 10
+	// checkpointResponseLimit bounds how much of a checkpoint service's response is read
+	checkpointResponseLimit = 4096
+)
+
+// FinalityPolicy is how a scanner decides that a block, and the deposits in it, can't be reversed.
+// Deposits are credited in two phases: a deposit is detected once its block has the
+// confirmations required, and it is only sent to the exchange once its block is final.
+type FinalityPolicy string
+
+const (
+	// FinalityConfirmations treats a block as final once it has the confirmations required
+	FinalityConfirmations FinalityPolicy = "confirmations"
+	// FinalityCheckpoint treats a block as final once a checkpoint service has checkpointed
+	// a block at or above its height, which is also in the node's chain
+	FinalityCheckpoint FinalityPolicy = "checkpoint"
+	// FinalityHybrid treats a block as final once it has the confirmations required,
+	// and then stayed in the node's chain for the settle window
+	FinalityHybrid FinalityPolicy = "hybrid"
+)
+
+var (
+	// ErrBlockReorged is returned by Finality.Final if the block is no longer in the node's chain
+	ErrBlockReorged = errors.New("Block was reorganized out of the chain")
+	// ErrCheckpointMismatch is returned by Finality.Final if the node's chain doesn't contain the checkpoint
+	ErrCheckpointMismatch = errors.New("Node's chain doesn't match the checkpoint")
+)
+
+// FinalityConfig configures a scanner's Finality
+type FinalityConfig struct {
+	// Policy defaults to FinalityConfirmations
+	Policy FinalityPolicy
+	// URL of the checkpoint service for FinalityCheckpoint, returning
+	// the latest checkpoint as JSON, e.g. {"height": 500000, "hash": "0000..."}
+	CheckpointURL string
+	// How often to query the checkpoint service
+	CheckInterval time.Duration
+	// How long a block must stay in the node's chain after it has the confirmations required,
+	// for FinalityHybrid
+	SettleWindow time.Duration
+}
+
+// Finality decides if a block which has the confirmations required is final
+type Finality interface {
+	// Policy returns the finality policy
+	Policy() FinalityPolicy
+	// Final returns true if the block is final. hashAt returns the hash of the node's block at a height.
+	// ErrBlockReorged is returned if the block is no longer in the node's chain,
+	// and it must be fetched again.
+	Final(block *CommonBlock, hashAt func(int64) (string, error), now time.Time) (bool, error)
+}
+
+// NewFinality creates the Finality of a FinalityConfig
+func NewFinality(log logrus.FieldLogger, cfg FinalityConfig) (Finality, error) {
+	switch cfg.Policy {
+	case "", FinalityConfirmations:
+		return confirmationsFinality{}, nil
+	case FinalityCheckpoint:
+		if cfg.CheckpointURL == "" {
+			return nil, errors.New("Checkpoint finality requires a CheckpointURL")
+		}
+		if cfg.CheckInterval <= 0 {
+			cfg.CheckInterval = checkpointCheckInterval
+		}
+		return &checkpointFinality{
+			log:    log,
+			cfg:    cfg,
+			client: httpclient.New(checkpointSourceTimeout),
+		}, nil
+	case FinalityHybrid:
+		if cfg.SettleWindow <= 0 {
+			return nil, errors.New("Hybrid finality requires a SettleWindow")
+		}
+		return &hybridFinality{
+			window: cfg.SettleWindow,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Invalid finality policy %q", cfg.Policy)
+	}
+}
+
+// checkInChain returns ErrBlockReorged if the block is no longer the node's block at its height
+func checkInChain(block *CommonBlock, hashAt func(int64) (string, error)) error {
+	hash, err := hashAt(block.Height)
+	if err != nil {
+		return err
+	}
+
+	if hash != block.Hash {
+		return ErrBlockReorged
+	}
+
+	return nil
+}
+
+type confirmationsFinality struct{}
+
+func (f confirmationsFinality) Policy() FinalityPolicy {
+	return FinalityConfirmations
+}
+
+func (f confirmationsFinality) Final(block *CommonBlock, hashAt func(int64) (string, error), now time.Time) (bool, error) {
+	return true, nil
+}
+
+// hybridFinality waits for the settle window after a block is first checked.
+// The scanner checks one block at a time, so only that block is tracked.
+type hybridFinality struct {
+	window     time.Duration
+	hash       string
+	detectedAt time.Time
+}
+
+func (f *hybridFinality) Policy() FinalityPolicy {
+	return FinalityHybrid
+}
+
+func (f *hybridFinality) Final(block *CommonBlock, hashAt func(int64) (string, error), now time.Time) (bool, error) {
+	if f.hash != block.Hash {
+		f.hash = block.Hash
+		f.detectedAt = now
+	}
+
+	if err := checkInChain(block, hashAt); err != nil {
+		if err == ErrBlockReorged {
+			f.hash = ""
+		}
+		return false, err
+	}
+
+	return now.Sub(f.detectedAt) >= f.window, nil
+}
+
+// Checkpoint is a block which a checkpoint service considers final
+type Checkpoint struct {
+	Height int64  `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+// checkpointFinality asks a checkpoint service for the latest final block
+type checkpointFinality struct {
+	sync.Mutex
+	log        logrus.FieldLogger
+	cfg        FinalityConfig
+	client     *http.Client
+	checkpoint Checkpoint
+	checkedAt  time.Time
+	mismatch   bool
+}
+
+func (f *checkpointFinality) Policy() FinalityPolicy {
+	return FinalityCheckpoint
+}
+
+func (f *checkpointFinality) Final(block *CommonBlock, hashAt func(int64) (string, error), now time.Time) (bool, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.checkedAt.IsZero() || now.Sub(f.checkedAt) >= f.cfg.CheckInterval {
+		f.checkedAt = now
+		cp, err := f.fetchCheckpoint()
+		if err != nil {
+			// The last checkpoint is still final
+			f.log.WithError(err).WithField("lastCheckpoint", f.checkpoint).Error("Get checkpoint failed")
+		} else {
+			f.checkpoint = cp
+		}
+	}
+
+	if f.checkpoint.Height < block.Height {
+		return false, nil
+	}
+
+	if err := checkInChain(block, hashAt); err != nil {
+		return false, err
+	}
+
+	hash, err := hashAt(f.checkpoint.Height)
+	if err != nil {
+		return false, err
+	}
+
+	log := f.log.WithFields(logrus.Fields{
+		"checkpoint": f.checkpoint,
+		"nodeHash":   hash,
+	})
+
+	mismatch := hash != f.checkpoint.Hash
+	switch {
+	case mismatch && !f.mismatch:
+		log.WithField("alert", "checkpoint_mismatch").Error("ALERT: node's chain doesn't match the checkpoint, stopped finalizing deposits")
+	case !mismatch && f.mismatch:
+		log.Info("Node's chain matches the checkpoint, resumed finalizing deposits")
+	}
+	f.mismatch = mismatch
+
+	if mismatch {
+		return false, ErrCheckpointMismatch
+	}
+
+	return true, nil
+}
+
+func (f *checkpointFinality) fetchCheckpoint() (Checkpoint, error) {
+	rsp, err := f.client.Get(f.cfg.CheckpointURL)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return Checkpoint{}, fmt.Errorf("Checkpoint service returned status %d", rsp.StatusCode)
+	}
+
+	var cp Checkpoint
+	if err := json.NewDecoder(io.LimitReader(rsp.Body, checkpointResponseLimit)).Decode(&cp); err != nil {
+		return Checkpoint{}, err
+	}
+
+	if cp.Height <= 0 || cp.Hash == "" {
+		return Checkpoint{}, fmt.Errorf("Invalid checkpoint %+v", cp)
+	}
+
+	return cp, nil
+}
@@ -0,0 +1,250 @@
+package scanner
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// testChain returns a hashAt of a chain with the given block hashes
+func testChain(hashes map[int64]string) func(int64) (string, error) {
+	return func(height int64) (string, error) {
+		hash, ok := hashes[height]
+		if !ok {
+			return "", errNoBlockHash
+		}
+		return hash, nil
+	}
+}
+
+func TestNewFinality(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	f, err := NewFinality(log, FinalityConfig{})
+	require.NoError(t, err)
+	require.Equal(t, FinalityConfirmations, f.Policy())
+
+	final, err := f.Final(&CommonBlock{Height: 10}, nil, time.Now())
+	require.NoError(t, err)
+	require.True(t, final)
+
+	for _, cfg := range []FinalityConfig{
+		{Policy: FinalityCheckpoint},
+		{Policy: FinalityHybrid},
+		{Policy: "finalized"},
+	} {
+		_, err := NewFinality(log, cfg)
+		require.Error(t, err, "%+v", cfg)
+	}
+}
+
+func TestHybridFinality(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	f, err := NewFinality(log, FinalityConfig{
+		Policy:       FinalityHybrid,
+		SettleWindow: time.Minute * 10,
+	})
+	require.NoError(t, err)
+	require.Equal(t, FinalityHybrid, f.Policy())
+
+	chain := map[int64]string{
+		10: "a10",
+		11: "a11",
+	}
+	hashAt := testChain(chain)
+
+	block := &CommonBlock{
+		Height: 10,
+		Hash:   "a10",
+	}
+
+	start := time.Now()
+	final, err := f.Final(block, hashAt, start)
+	require.NoError(t, err)
+	require.False(t, final)
+
+	final, err = f.Final(block, hashAt, start.Add(time.Minute*9))
+	require.NoError(t, err)
+	require.False(t, final)
+
+	// A reorg within the settle window restarts it for the block replacing it
+	chain[10] = "b10"
+	_, err = f.Final(block, hashAt, start.Add(time.Minute*9))
+	require.Equal(t, ErrBlockReorged, err)
+
+	block = &CommonBlock{
+		Height: 10,
+		Hash:   "b10",
+	}
+	reorgAt := start.Add(time.Minute * 9)
+	final, err = f.Final(block, hashAt, reorgAt)
+	require.NoError(t, err)
+	require.False(t, final)
+
+	final, err = f.Final(block, hashAt, start.Add(time.Minute*10))
+	require.NoError(t, err)
+	require.False(t, final)
+
+	final, err = f.Final(block, hashAt, reorgAt.Add(time.Minute*10))
+	require.NoError(t, err)
+	require.True(t, final)
+
+	// Errors getting the node's block are returned
+	_, err = f.Final(&CommonBlock{Height: 12, Hash: "a12"}, hashAt, reorgAt)
+	require.Equal(t, errNoBlockHash, err)
+}
+
+func TestCheckpointFinality(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	ts := newTipServer(`{"height": 10, "hash": "a10"}`)
+	defer ts.Close()
+
+	f, err := NewFinality(log, FinalityConfig{
+		Policy:        FinalityCheckpoint,
+		CheckpointURL: ts.URL,
+		CheckInterval: time.Minute,
+	})
+	require.NoError(t, err)
+	require.Equal(t, FinalityCheckpoint, f.Policy())
+
+	chain := map[int64]string{
+		9:  "a9",
+		10: "a10",
+		11: "a11",
+	}
+	hashAt := testChain(chain)
+
+	start := time.Now()
+
+	// Blocks at or below the checkpoint are final
+	final, err := f.Final(&CommonBlock{Height: 9, Hash: "a9"}, hashAt, start)
+	require.NoError(t, err)
+	require.True(t, final)
+
+	final, err = f.Final(&CommonBlock{Height: 11, Hash: "a11"}, hashAt, start)
+	require.NoError(t, err)
+	require.False(t, final)
+
+	// A block which is no longer in the chain isn't final
+	_, err = f.Final(&CommonBlock{Height: 9, Hash: "b9"}, hashAt, start)
+	require.Equal(t, ErrBlockReorged, err)
+
+	// The checkpoint is queried once per CheckInterval
+	ts.set(`{"height": 11, "hash": "a11"}`, http.StatusOK)
+	final, err = f.Final(&CommonBlock{Height: 11, Hash: "a11"}, hashAt, start.Add(time.Second*30))
+	require.NoError(t, err)
+	require.False(t, final)
+	require.Equal(t, 1, ts.calls)
+
+	final, err = f.Final(&CommonBlock{Height: 11, Hash: "a11"}, hashAt, start.Add(time.Minute))
+	require.NoError(t, err)
+	require.True(t, final)
+	require.Equal(t, 2, ts.calls)
+
+	// The last checkpoint is used if the service fails
+	ts.set("", http.StatusInternalServerError)
+	final, err = f.Final(&CommonBlock{Height: 11, Hash: "a11"}, hashAt, start.Add(time.Minute*2))
+	require.NoError(t, err)
+	require.True(t, final)
+
+	// A node on a different chain than the checkpoint doesn't finalize blocks
+	ts.set(`{"height": 11, "hash": "c11"}`, http.StatusOK)
+	_, err = f.Final(&CommonBlock{Height: 10, Hash: "a10"}, hashAt, start.Add(time.Minute*3))
+	require.Equal(t, ErrCheckpointMismatch, err)
+}
+
+func TestFetchCheckpoint(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	ts := newTipServer(`{"height": 10, "hash": "a10"}`)
+	defer ts.Close()
+
+	f, err := NewFinality(log, FinalityConfig{
+		Policy:        FinalityCheckpoint,
+		CheckpointURL: ts.URL,
+	})
+	require.NoError(t, err)
+	cf := f.(*checkpointFinality)
+	require.Equal(t, checkpointCheckInterval, cf.cfg.CheckInterval)
+
+	cp, err := cf.fetchCheckpoint()
+	require.NoError(t, err)
+	require.Equal(t, Checkpoint{
+		Height: 10,
+		Hash:   "a10",
+	}, cp)
+
+	for _, body := range []string{
+		"10",
+		`{"height": 0, "hash": "a10"}`,
+		`{"height": 10}`,
+	} {
+		ts.set(body, http.StatusOK)
+		_, err := cf.fetchCheckpoint()
+		require.Error(t, err, body)
+	}
+
+	ts.set(`{"height": 10, "hash": "a10"}`, http.StatusNotFound)
+	_, err = cf.fetchCheckpoint()
+	require.Error(t, err)
+}
+
+func TestBaseScannerDetectDeposits(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+	store.AddSupportedCoin(CoinTypeBTC)
+	require.NoError(t, store.AddScanAddress("btcaddr1", CoinTypeBTC))
+
+	s, err := NewBaseScanner(store, log, CoinTypeBTC, Config{})
+	require.NoError(t, err)
+	require.Equal(t, FinalityConfirmations, s.GetFinality().Policy())
+
+	_, err = NewBaseScanner(store, log, CoinTypeBTC, Config{
+		Finality: FinalityConfig{
+			Policy: FinalityHybrid,
+		},
+	})
+	require.Error(t, err)
+
+	block := &CommonBlock{
+		Height:        10,
+		Hash:          "a10",
+		Confirmations: 3,
+		RawTx: []CommonTx{
+			{
+				Txid: "tx1",
+				Vout: []CommonVout{
+					{Value: 1e8, N: 0, Addresses: []string{"btcaddr1"}},
+					{Value: 2e8, N: 1, Addresses: []string{"btcaddr2"}},
+				},
+			},
+		},
+	}
+
+	require.NoError(t, s.detectDeposits(block))
+
+	dvs := s.PendingDeposits()
+	require.Len(t, dvs, 1)
+	require.Equal(t, "btcaddr1", dvs[0].Address)
+	require.Equal(t, int64(1e8), dvs[0].Amount)
+	require.Equal(t, int64(3), dvs[0].Confirmations)
+	require.False(t, dvs[0].Final)
+
+	// Detected deposits aren't saved
+	unprocessed, err := store.GetUnprocessedDeposits()
+	require.NoError(t, err)
+	require.Empty(t, unprocessed)
+
+	s.setPendingDeposits(nil)
+	require.Empty(t, s.PendingDeposits())
+}
@@ -0,0 +1,74 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+// BtcSearchRPCClient is the btcd rpc client method used to find the transactions of an address.
+// btcd must be run with --addrindex.
+type BtcSearchRPCClient interface {
+	SearchRawTransactions(address btcutil.Address, skip, count int, reverse bool, filterAddrs []string) ([]*wire.MsgTx, error)
+}
+
+// BtcHistory checks btcd for the on-chain transactions of BTC addresses
+type BtcHistory struct {
+	c BtcSearchRPCClient
+}
+
+// NewBtcHistory creates a BtcHistory
+func NewBtcHistory(c BtcSearchRPCClient) *BtcHistory {
+	return &BtcHistory{c: c}
+}
+
+// HasHistory returns true if a BTC address has any transaction
+func (h *BtcHistory) HasHistory(addr string) (bool, error) {
+	a, err := btcutil.DecodeAddress(addr, &chaincfg.MainNetParams)
+	if err != nil {
+		return false, err
+	}
+
+	txns, err := h.c.SearchRawTransactions(a, 0, 1, false, nil)
+	if err != nil {
+		// btcd returns an error instead of an empty result for an address without transactions
+		if rpcErr, ok := err.(*btcjson.RPCError); ok && rpcErr.Code == btcjson.ErrRPCNoTxInfo {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return len(txns) != 0, nil
+}
+
+// HasHistory returns true if an ethereum address has sent any transaction or has a balance.
+// geth doesn't index the transactions received by an address, so an address which received coins
+// and forwarded all of them is only found by the transactions it sent.
+func (ec *EthClient) HasHistory(addr string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, method := range []string{"eth_getTransactionCount", "eth_getBalance"} {
+		var v string
+		if err := ec.c.CallContext(ctx, &v, method, addr, "latest"); err != nil {
+			return false, err
+		}
+
+		n, ok := new(big.Int).SetString(strings.TrimPrefix(v, "0x"), 16)
+		if !ok {
+			return false, fmt.Errorf("Invalid %s result %q", method, v)
+		}
+		if n.Sign() != 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,67 @@
+package scanner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/stretchr/testify/require"
+)
+
+type dummyBtcSearchClient struct {
+	txns map[string][]*wire.MsgTx
+	err  error
+}
+
+func (c *dummyBtcSearchClient) SearchRawTransactions(address btcutil.Address, skip, count int, reverse bool, filterAddrs []string) ([]*wire.MsgTx, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	txns, ok := c.txns[address.EncodeAddress()]
+	if !ok {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCNoTxInfo,
+			Message: "No Txns available",
+		}
+	}
+
+	return txns, nil
+}
+
+func TestBtcHistoryHasHistory(t *testing.T) {
+	h := NewBtcHistory(&dummyBtcSearchClient{
+		txns: map[string][]*wire.MsgTx{
+			"1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy": {&wire.MsgTx{}},
+		},
+	})
+
+	seen, err := h.HasHistory("1JNonvXRyZvZ4ZJ9PE8voyo67UQN1TpoGy")
+	require.NoError(t, err)
+	require.True(t, seen)
+
+	seen, err = h.HasHistory("14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj")
+	require.NoError(t, err)
+	require.False(t, seen)
+
+	_, err = h.HasHistory("bad")
+	require.Error(t, err)
+
+	// Other errors, e.g. btcd running without --addrindex
+	h = NewBtcHistory(&dummyBtcSearchClient{
+		err: &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Address index must be enabled (--addrindex)",
+		},
+	})
+	_, err = h.HasHistory("14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj")
+	require.Error(t, err)
+
+	h = NewBtcHistory(&dummyBtcSearchClient{
+		err: errors.New("connection refused"),
+	})
+	_, err = h.HasHistory("14JwrdSxYXPxSi6crLKVwR4k2dbjfVZ3xj")
+	require.Error(t, err)
+}
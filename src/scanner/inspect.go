@@ -0,0 +1,371 @@
+package scanner
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcutil"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rlp"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/mathutil"
+)
+
+// maxFeeInputs is the most inputs a BTC transaction's fee is computed for, each input is fetched from btcd
+const maxFeeInputs = 50
+
+// ErrTxNotFound is returned if the node doesn't know the transaction
+var ErrTxNotFound = errors.New("Transaction not found")
+
+// TxDetail is a transaction as reported by the coin's node.
+// Values are in the coin's smallest unit, see deposits.Coin.
+type TxDetail struct {
+	Txid string `json:"txid"`
+	// Hex is the raw transaction
+	Hex string `json:"hex"`
+	// BlockHash and BlockHeight are the block which confirmed the transaction, empty if it isn't confirmed
+	BlockHash     string     `json:"block_hash"`
+	BlockHeight   int64      `json:"block_height"`
+	Confirmations int64      `json:"confirmations"`
+	Outputs       []TxOutput `json:"outputs"`
+	// Fee is nil if it couldn't be determined, FeeError is why
+	Fee      *int64 `json:"fee"`
+	FeeError string `json:"fee_error,omitempty"`
+}
+
+// TxOutput is an output of a TxDetail
+type TxOutput struct {
+	N         uint32   `json:"n"`
+	Value     int64    `json:"value"`
+	Addresses []string `json:"addresses"`
+}
+
+// TxInspector fetches transactions from a coin's node
+type TxInspector interface {
+	InspectTx(txid string) (*TxDetail, error)
+}
+
+// BtcInspectRPCClient is the btcd rpc client methods used to inspect a transaction.
+// btcd must be run with --txindex.
+type BtcInspectRPCClient interface {
+	GetRawTransactionVerbose(*chainhash.Hash) (*btcjson.TxRawResult, error)
+	GetBlockHeaderVerbose(*chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error)
+}
+
+// BtcInspector fetches BTC transactions from btcd
+type BtcInspector struct {
+	c BtcInspectRPCClient
+}
+
+// NewBtcInspector creates a BtcInspector
+func NewBtcInspector(c BtcInspectRPCClient) *BtcInspector {
+	return &BtcInspector{c: c}
+}
+
+// InspectTx returns a BTC transaction, or ErrTxNotFound
+func (b *BtcInspector) InspectTx(txid string) (*TxDetail, error) {
+	tx, err := b.getTx(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &TxDetail{
+		Txid:          tx.Txid,
+		Hex:           tx.Hex,
+		BlockHash:     tx.BlockHash,
+		Confirmations: int64(tx.Confirmations),
+		Outputs:       make([]TxOutput, 0, len(tx.Vout)),
+	}
+
+	if tx.BlockHash != "" {
+		hash, err := chainhash.NewHashFromStr(tx.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+
+		header, err := b.c.GetBlockHeaderVerbose(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		d.BlockHeight = int64(header.Height)
+	}
+
+	var outputs int64
+	for _, v := range tx.Vout {
+		amt, err := btcutil.NewAmount(v.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		d.Outputs = append(d.Outputs, TxOutput{
+			N:         v.N,
+			Value:     int64(amt),
+			Addresses: v.ScriptPubKey.Addresses,
+		})
+		outputs += int64(amt)
+	}
+
+	fee, err := b.fee(tx, outputs)
+	if err != nil {
+		d.FeeError = err.Error()
+	} else {
+		d.Fee = &fee
+	}
+
+	return d, nil
+}
+
+// fee returns the value of a transaction's inputs minus its outputs, by fetching the transactions spent by its inputs
+func (b *BtcInspector) fee(tx *btcjson.TxRawResult, outputs int64) (int64, error) {
+	if len(tx.Vin) > maxFeeInputs {
+		return 0, fmt.Errorf("More than %d inputs", maxFeeInputs)
+	}
+
+	var inputs int64
+	for _, in := range tx.Vin {
+		if in.IsCoinBase() {
+			return 0, errors.New("Coinbase transaction")
+		}
+
+		prev, err := b.getTx(in.Txid)
+		if err != nil {
+			return 0, fmt.Errorf("Get input %s:%d failed: %v", in.Txid, in.Vout, err)
+		}
+
+		if int(in.Vout) >= len(prev.Vout) {
+			return 0, fmt.Errorf("Input %s:%d doesn't exist", in.Txid, in.Vout)
+		}
+
+		amt, err := btcutil.NewAmount(prev.Vout[in.Vout].Value)
+		if err != nil {
+			return 0, err
+		}
+		inputs += int64(amt)
+	}
+
+	return inputs - outputs, nil
+}
+
+func (b *BtcInspector) getTx(txid string) (*btcjson.TxRawResult, error) {
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := b.c.GetRawTransactionVerbose(hash)
+	if err != nil {
+		if rpcErr, ok := err.(*btcjson.RPCError); ok && rpcErr.Code == btcjson.ErrRPCNoTxInfo {
+			return nil, ErrTxNotFound
+		}
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+// InspectTx returns an ETH transaction, or ErrTxNotFound.
+// Values are in Gwei, rounded down, like the ETH deposits.
+func (ec *EthClient) InspectTx(txid string) (*TxDetail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	hash := common.HexToHash(txid)
+
+	var raw json.RawMessage
+	if err := ec.c.CallContext(ctx, &raw, "eth_getTransactionByHash", hash); err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ErrTxNotFound
+	}
+
+	tx := new(types.Transaction)
+	if err := json.Unmarshal(raw, tx); err != nil {
+		return nil, err
+	}
+
+	var block struct {
+		BlockNumber *hexutil.Big `json:"blockNumber"`
+		BlockHash   *common.Hash `json:"blockHash"`
+	}
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return nil, err
+	}
+
+	b, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &TxDetail{
+		Txid: tx.Hash().String(),
+		Hex:  "0x" + hex.EncodeToString(b),
+	}
+
+	if to := tx.To(); to != nil {
+		amt, err := mathutil.Wei2Gwei(tx.Value())
+		if err != nil {
+			return nil, err
+		}
+
+		d.Outputs = []TxOutput{{
+			N:         0,
+			Value:     amt,
+			Addresses: []string{strings.ToLower(to.String())},
+		}}
+	}
+
+	if block.BlockNumber == nil || block.BlockHash == nil {
+		d.FeeError = "Transaction is pending"
+		return d, nil
+	}
+
+	d.BlockHash = block.BlockHash.String()
+	d.BlockHeight = (*big.Int)(block.BlockNumber).Int64()
+
+	count, err := ec.GetBlockCount()
+	if err != nil {
+		return nil, err
+	}
+	d.Confirmations = count - d.BlockHeight + 1
+
+	receipt, err := ethclient.NewClient(ec.c).TransactionReceipt(ctx, hash)
+	if err != nil {
+		d.FeeError = fmt.Sprintf("Get receipt failed: %v", err)
+		return d, nil
+	}
+
+	fee, err := mathutil.Wei2Gwei(new(big.Int).Mul(receipt.GasUsed, tx.GasPrice()))
+	if err != nil {
+		d.FeeError = err.Error()
+		return d, nil
+	}
+	d.Fee = &fee
+
+	return d, nil
+}
+
+// DepositInspection is a deposit's transaction as reported by the coin's node, with the scanner's record of the deposit
+type DepositInspection struct {
+	// Scanned is the deposit saved by the scanner, nil if the scanner has no record of it
+	Scanned *deposits.Deposit `json:"scanned"`
+	// Tx is nil if the transaction couldn't be fetched from the node, TxError is why
+	Tx      *TxDetail `json:"tx"`
+	TxError string    `json:"tx_error,omitempty"`
+	// Mismatches are the differences between the deposit, the scanner's record and the transaction
+	Mismatches []string `json:"mismatches"`
+}
+
+// Inspector looks up deposits in the scanner's records and their transactions on the coins' nodes
+type Inspector struct {
+	store *Store
+	nodes map[string]TxInspector
+}
+
+// NewInspector creates an Inspector. The nodes of the coin types are added with AddNode.
+func NewInspector(store *Store) *Inspector {
+	return &Inspector{
+		store: store,
+		nodes: make(map[string]TxInspector),
+	}
+}
+
+// AddNode sets the node which a coin type's transactions are fetched from
+func (i *Inspector) AddNode(coinType string, n TxInspector) {
+	i.nodes[coinType] = n
+}
+
+// InspectDeposit fetches the transaction of a deposit received by the exchange, and compares it with the deposit.
+// A transaction which can't be fetched is reported in TxError, the error is only for the scanner's records.
+func (i *Inspector) InspectDeposit(dv deposits.Deposit) (DepositInspection, error) {
+	in := DepositInspection{
+		Mismatches: []string{},
+	}
+
+	scanned, ok, err := i.store.GetDeposit(dv.ID())
+	if err != nil {
+		return DepositInspection{}, err
+	}
+
+	if ok {
+		in.Scanned = &scanned
+		if scanned.Amount != dv.Amount {
+			in.Mismatches = append(in.Mismatches, fmt.Sprintf("The scanner saved a value of %d, the deposit's is %d", scanned.Amount, dv.Amount))
+		}
+	} else {
+		in.Mismatches = append(in.Mismatches, "The scanner has no record of the deposit")
+	}
+
+	n, ok := i.nodes[dv.CoinType]
+	if !ok {
+		in.TxError = fmt.Sprintf("%s node is not enabled", dv.CoinType)
+		return in, nil
+	}
+
+	tx, err := n.InspectTx(dv.Tx)
+	if err != nil {
+		in.TxError = err.Error()
+		if err == ErrTxNotFound {
+			in.Mismatches = append(in.Mismatches, "The node doesn't know the transaction")
+		}
+		return in, nil
+	}
+	in.Tx = tx
+
+	in.Mismatches = append(in.Mismatches, txMismatches(dv, tx)...)
+
+	return in, nil
+}
+
+// txMismatches compares a deposit with the output of its transaction
+func txMismatches(dv deposits.Deposit, tx *TxDetail) []string {
+	var m []string
+
+	if tx.BlockHash == "" {
+		m = append(m, "The transaction is not in a block")
+	} else if tx.BlockHeight != dv.Height {
+		m = append(m, fmt.Sprintf("The transaction is in block %d, the deposit was scanned in block %d", tx.BlockHeight, dv.Height))
+	}
+
+	var out *TxOutput
+	for i := range tx.Outputs {
+		if tx.Outputs[i].N == dv.N {
+			out = &tx.Outputs[i]
+			break
+		}
+	}
+
+	if out == nil {
+		return append(m, fmt.Sprintf("The transaction has no output %d", dv.N))
+	}
+
+	if out.Value != dv.Amount {
+		m = append(m, fmt.Sprintf("Output %d has a value of %d, the deposit's is %d", dv.N, out.Value, dv.Amount))
+	}
+
+	paid := false
+	for _, a := range out.Addresses {
+		if strings.EqualFold(a, dv.Address) {
+			paid = true
+			break
+		}
+	}
+	if !paid {
+		m = append(m, fmt.Sprintf("Output %d isn't paid to the deposit address %s", dv.N, dv.Address))
+	}
+
+	return m
+}
@@ -0,0 +1,205 @@
+package scanner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/dbutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+const (
+	testInspectTxid   = "e8e1a8c5ebe1e0bfd9c4bdd3e5e8b1c1b3c60b3b0e0a532a6a8f7d0f1a6b7c01"
+	testInspectPrevID = "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+	testInspectBlock  = "000000000000000000241b1e5f0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b"
+)
+
+type fakeBtcInspectClient struct {
+	txs     map[string]*btcjson.TxRawResult
+	heights map[string]int32
+}
+
+func (c fakeBtcInspectClient) GetRawTransactionVerbose(h *chainhash.Hash) (*btcjson.TxRawResult, error) {
+	tx, ok := c.txs[h.String()]
+	if !ok {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCNoTxInfo,
+			Message: "No information available about transaction",
+		}
+	}
+	return tx, nil
+}
+
+func (c fakeBtcInspectClient) GetBlockHeaderVerbose(h *chainhash.Hash) (*btcjson.GetBlockHeaderVerboseResult, error) {
+	height, ok := c.heights[h.String()]
+	if !ok {
+		return nil, errors.New("block not found")
+	}
+	return &btcjson.GetBlockHeaderVerboseResult{
+		Hash:   h.String(),
+		Height: height,
+	}, nil
+}
+
+func newFakeBtcInspectClient() fakeBtcInspectClient {
+	return fakeBtcInspectClient{
+		txs: map[string]*btcjson.TxRawResult{
+			testInspectTxid: {
+				Hex:  "0100000001",
+				Txid: testInspectTxid,
+				Vin: []btcjson.Vin{
+					{Txid: testInspectPrevID, Vout: 1},
+				},
+				Vout: []btcjson.Vout{
+					{Value: 0.5, N: 0, ScriptPubKey: btcjson.ScriptPubKeyResult{Addresses: []string{"change"}}},
+					{Value: 1.2, N: 1, ScriptPubKey: btcjson.ScriptPubKeyResult{Addresses: []string{"btcaddr1"}}},
+				},
+				BlockHash:     testInspectBlock,
+				Confirmations: 3,
+			},
+			testInspectPrevID: {
+				Txid: testInspectPrevID,
+				Vout: []btcjson.Vout{
+					{Value: 5, N: 0},
+					{Value: 1.7001, N: 1},
+				},
+			},
+		},
+		heights: map[string]int32{
+			testInspectBlock: 500000,
+		},
+	}
+}
+
+func TestBtcInspectorInspectTx(t *testing.T) {
+	c := newFakeBtcInspectClient()
+	b := NewBtcInspector(c)
+
+	d, err := b.InspectTx(testInspectTxid)
+	require.NoError(t, err)
+	require.Equal(t, testInspectTxid, d.Txid)
+	require.Equal(t, "0100000001", d.Hex)
+	require.Equal(t, testInspectBlock, d.BlockHash)
+	require.Equal(t, int64(500000), d.BlockHeight)
+	require.Equal(t, int64(3), d.Confirmations)
+	require.Equal(t, []TxOutput{
+		{N: 0, Value: 5e7, Addresses: []string{"change"}},
+		{N: 1, Value: 12e7, Addresses: []string{"btcaddr1"}},
+	}, d.Outputs)
+	require.NotNil(t, d.Fee)
+	require.Equal(t, int64(1e4), *d.Fee)
+	require.Empty(t, d.FeeError)
+
+	_, err = b.InspectTx(testInspectBlock)
+	require.Equal(t, ErrTxNotFound, err)
+
+	// The fee is unknown if an input can't be fetched
+	delete(c.txs, testInspectPrevID)
+	d, err = b.InspectTx(testInspectTxid)
+	require.NoError(t, err)
+	require.Nil(t, d.Fee)
+	require.NotEmpty(t, d.FeeError)
+}
+
+type fakeTxInspector map[string]*TxDetail
+
+func (f fakeTxInspector) InspectTx(txid string) (*TxDetail, error) {
+	tx, ok := f[txid]
+	if !ok {
+		return nil, ErrTxNotFound
+	}
+	return tx, nil
+}
+
+func TestInspectorInspectDeposit(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	dv := deposits.Deposit{
+		CoinType: CoinTypeBTC,
+		Address:  "btcaddr1",
+		Tx:       "btx1",
+		N:        1,
+		Amount:   12e7,
+		Height:   500000,
+		Final:    true,
+	}
+
+	tx := &TxDetail{
+		Txid:        "btx1",
+		BlockHash:   testInspectBlock,
+		BlockHeight: 500000,
+		Outputs: []TxOutput{
+			{N: 0, Value: 5e7, Addresses: []string{"change"}},
+			{N: 1, Value: 12e7, Addresses: []string{"btcaddr1"}},
+		},
+	}
+
+	i := NewInspector(s)
+
+	// Without a node, only the scanner's record is reported
+	in, err := i.InspectDeposit(dv)
+	require.NoError(t, err)
+	require.Nil(t, in.Scanned)
+	require.Nil(t, in.Tx)
+	require.Equal(t, "BTC node is not enabled", in.TxError)
+	require.Equal(t, []string{"The scanner has no record of the deposit"}, in.Mismatches)
+
+	require.NoError(t, db.Update(func(btx *bolt.Tx) error {
+		return dbutil.PutBucketValue(btx, DepositBkt, dv.ID(), dv)
+	}))
+
+	i.AddNode(CoinTypeBTC, fakeTxInspector{"btx1": tx})
+
+	in, err = i.InspectDeposit(dv)
+	require.NoError(t, err)
+	require.Equal(t, &dv, in.Scanned)
+	require.Equal(t, tx, in.Tx)
+	require.Empty(t, in.TxError)
+	require.Empty(t, in.Mismatches)
+
+	// A deposit which differs from its transaction
+	other := dv
+	other.Amount = 13e7
+	other.Height = 499999
+	other.Address = "btcaddr2"
+	in, err = i.InspectDeposit(other)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"The scanner saved a value of 120000000, the deposit's is 130000000",
+		"The transaction is in block 500000, the deposit was scanned in block 499999",
+		"Output 1 has a value of 120000000, the deposit's is 130000000",
+		"Output 1 isn't paid to the deposit address btcaddr2",
+	}, in.Mismatches)
+
+	other = dv
+	other.N = 2
+	in, err = i.InspectDeposit(other)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"The scanner has no record of the deposit",
+		"The transaction has no output 2",
+	}, in.Mismatches)
+
+	// A transaction unknown to the node
+	other = dv
+	other.Tx = "btx2"
+	in, err = i.InspectDeposit(other)
+	require.NoError(t, err)
+	require.Nil(t, in.Tx)
+	require.Equal(t, ErrTxNotFound.Error(), in.TxError)
+	require.Equal(t, []string{
+		"The scanner has no record of the deposit",
+		"The node doesn't know the transaction",
+	}, in.Mismatches)
+}
@@ -0,0 +1,217 @@
+package scanner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/httpclient"
+)
+
+const (
+	lagCheckInterval = time.Minute
+	tipSourceTimeout = time.Second * 10
+	// tipResponseLimit bounds how much of a tip source's response is read
+	tipResponseLimit = 1024
+)
+
+// LagConfig configures the LagGuard
+type LagConfig struct {
+	// URLs of explorers or peers which return the network's best block height,
+	// as a plain number, or as the result of a JSON-RPC response, e.g. {"result": "0x4e3b0c"}
+	TipURLs []string
+	// Max blocks the node's best height may be behind the network tip before
+	// the scanner stops confirming deposits. 0 disables the guard.
+	MaxLag int64
+	// How often to query the tip sources
+	CheckInterval time.Duration
+}
+
+// LagStatus is the last lag measured by the LagGuard
+type LagStatus struct {
+	NetworkTip int64
+	BestHeight int64
+	Lag        int64
+	Paused     bool
+	CheckedAt  time.Time
+}
+
+// LagGuard measures how far the node's best height lags the network tip reported by
+// several independent sources, and pauses confirming deposits when the lag is too large.
+// A lagging node may be stalled or on a stale fork, so the confirmations computed from
+// its best height can't be trusted.
+type LagGuard struct {
+	sync.Mutex
+	log    logrus.FieldLogger
+	cfg    LagConfig
+	client *http.Client
+	status LagStatus
+}
+
+// NewLagGuard creates a LagGuard. It returns nil if the guard is disabled, which is safe to use.
+func NewLagGuard(log logrus.FieldLogger, cfg LagConfig) *LagGuard {
+	if cfg.MaxLag <= 0 || len(cfg.TipURLs) == 0 {
+		return nil
+	}
+
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = lagCheckInterval
+	}
+
+	return &LagGuard{
+		log:    log,
+		cfg:    cfg,
+		client: httpclient.New(tipSourceTimeout),
+	}
+}
+
+// Check measures the lag of bestHeight and returns true if confirming deposits is paused.
+// The tip sources are queried at most once per CheckInterval. If none of them can be
+// reached, the last network tip is used.
+func (g *LagGuard) Check(bestHeight int64, now time.Time) bool {
+	if g == nil {
+		return false
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if g.status.CheckedAt.IsZero() || now.Sub(g.status.CheckedAt) >= g.cfg.CheckInterval {
+		g.refreshTip(now)
+	}
+
+	// The network tip is unknown until a source has responded once
+	if g.status.NetworkTip == 0 {
+		return false
+	}
+
+	lag := g.status.NetworkTip - bestHeight
+	paused := lag > g.cfg.MaxLag
+
+	log := g.log.WithFields(logrus.Fields{
+		"networkTip": g.status.NetworkTip,
+		"bestHeight": bestHeight,
+		"lag":        lag,
+		"maxLag":     g.cfg.MaxLag,
+	})
+
+	switch {
+	case paused && !g.status.Paused:
+		log.WithField("alert", "scanner_lag").Error("ALERT: node lags the network tip, paused confirming deposits")
+	case !paused && g.status.Paused:
+		log.Info("Node caught up with the network tip, resumed confirming deposits")
+	}
+
+	g.status.BestHeight = bestHeight
+	g.status.Lag = lag
+	g.status.Paused = paused
+
+	return paused
+}
+
+// Status returns the last lag measured
+func (g *LagGuard) Status() LagStatus {
+	if g == nil {
+		return LagStatus{}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	return g.status
+}
+
+// refreshTip sets the network tip to the median height of the sources which responded,
+// so that a single broken or malicious source can't pause or unpause the scanner
+func (g *LagGuard) refreshTip(now time.Time) {
+	g.status.CheckedAt = now
+
+	var heights []int64
+	for _, u := range g.cfg.TipURLs {
+		h, err := g.fetchTip(u)
+		if err != nil {
+			g.log.WithError(err).WithField("tipURL", u).Warn("Get network tip failed")
+			continue
+		}
+		heights = append(heights, h)
+	}
+
+	if len(heights) == 0 {
+		g.log.WithField("lastNetworkTip", g.status.NetworkTip).Error("No network tip source responded")
+		return
+	}
+
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	g.status.NetworkTip = heights[len(heights)/2]
+}
+
+func (g *LagGuard) fetchTip(u string) (int64, error) {
+	rsp, err := g.client.Get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("Tip source returned status %d", rsp.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(rsp.Body, tipResponseLimit))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseTipHeight(b)
+}
+
+// parseTipHeight parses a plain block height, or the result of a JSON-RPC response
+// as a number or hex string
+func parseTipHeight(b []byte) (int64, error) {
+	s := strings.TrimSpace(string(b))
+
+	if strings.HasPrefix(s, "{") {
+		var rsp struct {
+			Result json.RawMessage `json:"result"`
+		}
+		if err := json.Unmarshal([]byte(s), &rsp); err != nil {
+			return 0, err
+		}
+		if len(rsp.Result) == 0 {
+			return 0, errors.New("Tip source response has no result")
+		}
+
+		s = string(rsp.Result)
+		if unquoted, err := strconv.Unquote(s); err == nil {
+			s = unquoted
+		}
+	}
+
+	var h int64
+	var err error
+	if strings.HasPrefix(s, "0x") {
+		h, err = strconv.ParseInt(s[2:], 16, 64)
+	} else {
+		h, err = strconv.ParseInt(s, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("Invalid block height %q", s)
+	}
+
+	if h <= 0 {
+		return 0, fmt.Errorf("Invalid block height %d", h)
+	}
+
+	return h, nil
+}
@@ -0,0 +1,200 @@
+package scanner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type tipServer struct {
+	sync.Mutex
+	*httptest.Server
+	body   string
+	status int
+	calls  int
+}
+
+func newTipServer(body string) *tipServer {
+	ts := &tipServer{
+		body:   body,
+		status: http.StatusOK,
+	}
+
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ts.Lock()
+		defer ts.Unlock()
+		ts.calls++
+		w.WriteHeader(ts.status)
+		fmt.Fprint(w, ts.body)
+	}))
+
+	return ts
+}
+
+func (ts *tipServer) set(body string, status int) {
+	ts.Lock()
+	defer ts.Unlock()
+	ts.body = body
+	ts.status = status
+}
+
+func TestParseTipHeight(t *testing.T) {
+	tt := []struct {
+		name   string
+		body   string
+		height int64
+		err    bool
+	}{
+		{
+			name:   "plain",
+			body:   "512345\n",
+			height: 512345,
+		},
+		{
+			name:   "json-rpc hex",
+			body:   `{"jsonrpc":"2.0","id":83,"result":"0x4e3b0c"}`,
+			height: 0x4e3b0c,
+		},
+		{
+			name:   "json-rpc number",
+			body:   `{"result": 100}`,
+			height: 100,
+		},
+		{
+			name: "json-rpc no result",
+			body: `{"error": "rate limited"}`,
+			err:  true,
+		},
+		{
+			name: "not a number",
+			body: "<html>",
+			err:  true,
+		},
+		{
+			name: "zero",
+			body: "0",
+			err:  true,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := parseTipHeight([]byte(tc.body))
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.height, h)
+		})
+	}
+}
+
+func TestNewLagGuardDisabled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	g := NewLagGuard(log, LagConfig{
+		TipURLs: []string{"http://localhost"},
+	})
+	require.Nil(t, g)
+	require.False(t, g.Check(1, time.Now()))
+	require.Equal(t, LagStatus{}, g.Status())
+
+	g = NewLagGuard(log, LagConfig{
+		MaxLag: 3,
+	})
+	require.Nil(t, g)
+}
+
+func TestLagGuardCheck(t *testing.T) {
+	log, hook := testutil.NewLogger(t)
+
+	s1 := newTipServer("100")
+	defer s1.Close()
+	s2 := newTipServer("101")
+	defer s2.Close()
+	s3 := newTipServer("5000") // a broken source
+	defer s3.Close()
+
+	g := NewLagGuard(log, LagConfig{
+		TipURLs:       []string{s1.URL, s2.URL, s3.URL},
+		MaxLag:        3,
+		CheckInterval: time.Minute,
+	})
+	require.NotNil(t, g)
+
+	now := time.Now()
+
+	// The median tip is 101, so the broken source doesn't pause the scanner
+	require.False(t, g.Check(98, now))
+	require.Equal(t, LagStatus{
+		NetworkTip: 101,
+		BestHeight: 98,
+		Lag:        3,
+		CheckedAt:  now,
+	}, g.Status())
+
+	// The tip sources aren't queried again within the check interval
+	require.True(t, g.Check(97, now.Add(time.Second)))
+	require.Equal(t, 1, s1.calls)
+	require.True(t, g.Status().Paused)
+
+	var alerts int
+	for _, e := range hook.AllEntries() {
+		if e.Data["alert"] == "scanner_lag" {
+			alerts++
+		}
+	}
+	require.Equal(t, 1, alerts)
+
+	// Still paused, no repeated alert
+	require.True(t, g.Check(97, now.Add(time.Second*2)))
+
+	// The sources are queried again after the check interval and the node caught up
+	s1.set("110", http.StatusOK)
+	s2.set("110", http.StatusOK)
+	require.False(t, g.Check(108, now.Add(time.Minute)))
+	require.Equal(t, 2, s1.calls)
+	require.Equal(t, int64(110), g.Status().NetworkTip)
+	require.False(t, g.Status().Paused)
+
+	alerts = 0
+	for _, e := range hook.AllEntries() {
+		if e.Data["alert"] == "scanner_lag" {
+			alerts++
+		}
+	}
+	require.Equal(t, 1, alerts)
+
+	// If no source responds, the last network tip is used
+	s1.set("", http.StatusServiceUnavailable)
+	s2.set("", http.StatusServiceUnavailable)
+	s3.set("", http.StatusServiceUnavailable)
+	require.True(t, g.Check(100, now.Add(time.Minute*2)))
+	require.Equal(t, int64(110), g.Status().NetworkTip)
+}
+
+func TestLagGuardUnknownTip(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := newTipServer("")
+	s.set("", http.StatusInternalServerError)
+	defer s.Close()
+
+	g := NewLagGuard(log, LagConfig{
+		TipURLs: []string{s.URL},
+		MaxLag:  1,
+	})
+
+	// Scanning isn't paused before the network tip is known
+	require.False(t, g.Check(1, time.Now()))
+	require.Equal(t, int64(0), g.Status().NetworkTip)
+}
@@ -3,19 +3,28 @@ package scanner
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/deposits"
 )
 
-// Multiplexer manager of scanner
+// Multiplexer manager of scanner.
+// It is the registry of the scanners by coin type: the coin types with a scanner are the coin types teller binds
+// deposit addresses of. A scanner can be added while the multiplexer runs, its deposits are forwarded from then on.
 type Multiplexer struct {
 	scannerMap   map[string]Scanner
 	outChan      chan DepositNote
 	scannerCount int
-	quit         chan struct{}
-	done         chan struct{}
-	log          logrus.FieldLogger
+	// running is true once Run forwards the deposits of the scanners
+	running bool
+	// forwarders counts the goroutines forwarding the deposits of a scanner
+	forwarders sync.WaitGroup
+	quit       chan struct{}
+	done       chan struct{}
+	log        logrus.FieldLogger
 	sync.RWMutex
 }
 
@@ -38,6 +47,13 @@ func (m *Multiplexer) AddScanner(scanner Scanner, coinType string) error {
 	}
 	m.RWMutex.Lock()
 	defer m.RWMutex.Unlock()
+
+	select {
+	case <-m.quit:
+		return errors.New("multiplexer is shut down")
+	default:
+	}
+
 	_, existsScanner := m.scannerMap[coinType]
 	if existsScanner {
 		return fmt.Errorf("scanner of coinType %s already exists", coinType)
@@ -45,6 +61,10 @@ func (m *Multiplexer) AddScanner(scanner Scanner, coinType string) error {
 
 	m.scannerMap[coinType] = scanner
 	m.scannerCount++
+
+	if m.running {
+		m.forward(scanner)
+	}
 	return nil
 }
 
@@ -59,26 +79,49 @@ func (m *Multiplexer) AddScanAddress(depositAddr, coinType string) error {
 	return scanner.AddScanAddress(depositAddr, coinType)
 }
 
-//Multiplex forward multi-scanner deposit to a shared aggregate channel, think of "Goroutine merging channel"
-func (m *Multiplexer) Multiplex() error {
+// PendingDeposits returns the deposits detected by the scanner of coinType which are not final yet
+func (m *Multiplexer) PendingDeposits(coinType string) []deposits.Deposit {
+	m.RWMutex.RLock()
+	defer m.RWMutex.RUnlock()
+	scanner, existsScanner := m.scannerMap[coinType]
+	if !existsScanner {
+		return nil
+	}
+	return scanner.PendingDeposits(coinType)
+}
+
+// forward forwards the deposits of a scanner to the aggregate channel, until the scanner closes its channel.
+// The lock must be held.
+func (m *Multiplexer) forward(scan Scanner) {
+	m.forwarders.Add(1)
+	go func() {
+		defer m.log.Info("Scan goroutine exited")
+		defer m.forwarders.Done()
+		for dv := range scan.GetDeposit() {
+			m.outChan <- dv
+		}
+	}()
+}
+
+// Run forwards the deposits of the scanners to a shared aggregate channel, think of "Goroutine merging channel",
+// until Shutdown is called and the scanners are shut down. It doesn't run the scanners.
+func (m *Multiplexer) Run() error {
+	m.RWMutex.Lock()
 	log := m.log.WithField("scanner count ", m.scannerCount)
 	log.Info("Start multiplex service")
+	m.running = true
+	for _, scan := range m.scannerMap {
+		m.forward(scan)
+	}
+	m.RWMutex.Unlock()
+
 	defer func() {
 		log.Info("Multiplex service closed")
 		close(m.done)
 	}()
-	var wg sync.WaitGroup
-	for _, scan := range m.scannerMap {
-		wg.Add(1)
-		go func(scan Scanner) {
-			defer log.Info("Scan goroutine exited")
-			defer wg.Done()
-			for dv := range scan.GetDeposit() {
-				m.outChan <- dv
-			}
-		}(scan)
-	}
-	wg.Wait()
+
+	<-m.quit
+	m.forwarders.Wait()
 
 	return nil
 }
@@ -90,20 +133,40 @@ func (m *Multiplexer) GetDeposit() <-chan DepositNote {
 
 // GetScannerCount returns scanner count.
 func (m *Multiplexer) GetScannerCount() int {
+	m.RWMutex.RLock()
+	defer m.RWMutex.RUnlock()
 	return m.scannerCount
 }
 
-// Shutdown shutdown the multiplexer
+// CoinTypes returns the coin types which have a scanner, sorted
+func (m *Multiplexer) CoinTypes() []string {
+	m.RWMutex.RLock()
+	defer m.RWMutex.RUnlock()
+
+	coinTypes := make([]string, 0, len(m.scannerMap))
+	for coinType := range m.scannerMap {
+		coinTypes = append(coinTypes, coinType)
+	}
+	sort.Strings(coinTypes)
+	return coinTypes
+}
+
+// Shutdown shutdown the multiplexer, once its scanners are shut down.
+// The deposit values channel is closed when the deposits of the scanners have been forwarded.
 func (m *Multiplexer) Shutdown() {
 	m.log.Info("Closing Multiplexer")
+	m.RWMutex.Lock()
 	close(m.quit)
-	close(m.outChan)
+	m.RWMutex.Unlock()
 	m.log.Info("Waiting for Multiplexer to stop")
 	<-m.done
+	close(m.outChan)
 }
 
 // GetScanner returns Scanner according to coinType
 func (m *Multiplexer) GetScanner(coinType string) Scanner {
+	m.RWMutex.RLock()
+	defer m.RWMutex.RUnlock()
 	scanner, existsScanner := m.scannerMap[coinType]
 	if !existsScanner {
 		return nil
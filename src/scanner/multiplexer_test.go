@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/util/testutil"
 	"github.com/stretchr/testify/require"
 )
@@ -110,7 +111,7 @@ func TestMultiplexerOnlyBtc(t *testing.T) {
 
 	nDeposits := testAddBtcScanAddresses(t, m)
 
-	go m.Multiplex()
+	go m.Run()
 
 	done := make(chan struct{})
 	go func() {
@@ -170,7 +171,7 @@ func TestMultiplexerForAll(t *testing.T) {
 	nDepositsBtc := testAddBtcScanAddresses(t, m)
 	nDepositsEth := testAddEthScanAddresses(t, m)
 
-	go m.Multiplex()
+	go m.Run()
 
 	done := make(chan struct{})
 	go func() {
@@ -203,3 +204,50 @@ func TestMultiplexerForAll(t *testing.T) {
 	require.NoError(t, err)
 	<-done
 }
+
+func TestMultiplexerRegisterWhileRunning(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	m := NewMultiplexer(log)
+
+	btc := NewDummyScanner(log)
+	require.NoError(t, m.AddScanner(btc, CoinTypeBTC))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, m.Run())
+	}()
+
+	// A community coin is registered while the multiplexer runs
+	ltc := NewDummyScanner(log)
+	require.NoError(t, m.AddScanner(ltc, "LTC"))
+	require.Equal(t, []string{CoinTypeBTC, "LTC"}, m.CoinTypes())
+	require.Equal(t, Scanner(ltc), m.GetScanner("LTC"))
+
+	require.NoError(t, m.AddScanAddress("ltcaddr", "LTC"))
+	require.Equal(t, []string{"ltcaddr"}, ltc.addrs)
+
+	ltc.deposits <- NewDepositNote(deposits.Deposit{
+		CoinType: "LTC",
+		Address:  "ltcaddr",
+		Tx:       "ltctx",
+	})
+
+	select {
+	case dv := <-m.GetDeposit():
+		require.Equal(t, "LTC", dv.CoinType)
+		require.Equal(t, "ltctx", dv.Tx)
+	case <-time.After(time.Second * 3):
+		t.Fatal("deposit of the scanner registered while running not forwarded")
+	}
+
+	btc.Shutdown()
+	ltc.Shutdown()
+	m.Shutdown()
+	<-done
+
+	_, ok := <-m.GetDeposit()
+	require.False(t, ok)
+
+	require.Error(t, m.AddScanner(NewDummyScanner(log), CoinTypeETH))
+}
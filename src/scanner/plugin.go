@@ -0,0 +1,664 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/util/pauseutil"
+)
+
+// Scanner plugins.
+// A scanner plugin is a sidecar process which serves a coin type's blocks to teller instead of its node,
+// e.g. a community maintained indexer of a chain teller wasn't built for. The coin type is BTC, ETH,
+// or a coin type the plugin adds, which is registered with deposits.RegisterCoin. Teller starts the plugin's command and
+// speaks the plugin protocol with it over stdio, and the scanner checks the blocks the plugin serves as it does
+// the node's: the deposit addresses, confirmations, finality and lag are all checked by teller, so the plugin only
+// has to serve the blocks by height. The plugin's stderr is logged. If the plugin exits or stops answering,
+// it is killed and started again on the next request.
+//
+// The protocol is one JSON object per line in each direction. Teller sends a request and waits for its response
+// before sending the next:
+//
+//     {"id": 1, "method": "get_block", "params": {"height": 500000}}
+//     {"id": 1, "result": {"height": 500000, "hash": "...", "txs": [...]}}
+//     {"id": 2, "method": "get_block_count"}
+//     {"id": 2, "error": "node unavailable"}
+//
+// The methods are:
+//     hello, params {"protocol": 1, "coin_type": "BTC"}, result {"protocol": 1, "name": "...", "version": "..."}.
+//         It is the first request to a started plugin, which must answer with the protocol version it speaks.
+//     get_block_count, result the height of the best block
+//     get_block, params {"height": 500000}, result the block at height, or null if there is no block at height yet.
+//         A block is {"height": 500000, "hash": "...", "txs": [{"txid": "...", "outputs": [{"n": 0, "value": 1000, "addresses": ["..."]}]}]}.
+//         An output's value is in the coin type's smallest unit, see deposits.Coin.
+//     validate_address, params {"address": "..."}, result {"valid": true} or {"valid": false, "reason": "..."}.
+//         It is only requested by the coin types the plugin adds, to check the addresses of their deposit address pool.
+
+// PluginProtocolVersion is the version of the scanner plugin protocol
+const PluginProtocolVersion = 1
+
+const (
+	defaultPluginRequestTimeout = time.Second * 30
+	pluginShutdownTimeout       = time.Second * 5
+)
+
+var (
+	// ErrPluginBlockNotFound is returned if the plugin has no block at a height
+	ErrPluginBlockNotFound = errors.New("Plugin has no block at this height")
+	// ErrPluginShutdown is returned by the requests to a plugin after it was shut down
+	ErrPluginShutdown = errors.New("Plugin was shut down")
+)
+
+// PluginConfig configures a scanner plugin
+type PluginConfig struct {
+	// CoinType of the blocks served by the plugin
+	CoinType string
+	// Command is the plugin's executable and its arguments
+	Command []string
+	// RequestTimeout is how long to wait for a response, after which the plugin is killed
+	RequestTimeout time.Duration
+}
+
+// PluginInfo is the plugin's response to hello
+type PluginInfo struct {
+	Protocol int    `json:"protocol"`
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+}
+
+type pluginRequest struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method"`
+	Params interface{} `json:"params,omitempty"`
+}
+
+type pluginResponse struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+type pluginHelloParams struct {
+	Protocol int    `json:"protocol"`
+	CoinType string `json:"coin_type"`
+}
+
+type pluginBlockParams struct {
+	Height int64 `json:"height"`
+}
+
+type pluginAddressParams struct {
+	Address string `json:"address"`
+}
+
+type pluginAddressResult struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason"`
+}
+
+// PluginBlock is a block served by a plugin
+type PluginBlock struct {
+	Height int64      `json:"height"`
+	Hash   string     `json:"hash"`
+	Txs    []PluginTx `json:"txs"`
+}
+
+// PluginTx is a transaction of a PluginBlock
+type PluginTx struct {
+	Txid    string         `json:"txid"`
+	Outputs []PluginOutput `json:"outputs"`
+}
+
+// PluginOutput is an output of a PluginTx
+type PluginOutput struct {
+	N         uint32   `json:"n"`
+	Value     int64    `json:"value"`
+	Addresses []string `json:"addresses"`
+}
+
+// pluginProcess is a started plugin
+type pluginProcess struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan pluginResponse
+	// exited is closed once the process exited, err is why its responses stopped
+	exited chan struct{}
+	err    error
+}
+
+// PluginClient runs a scanner plugin and makes the protocol's requests to it. It implements PluginRPCClient.
+type PluginClient struct {
+	log logrus.FieldLogger
+	cfg PluginConfig
+
+	// mu is held for each request, so the requests are made one at a time
+	mu       sync.Mutex
+	proc     *pluginProcess
+	info     PluginInfo
+	nextID   uint64
+	shutdown bool
+
+	// quit is closed by Shutdown, which interrupts the request being made
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewPluginClient creates a PluginClient. The plugin is started by the first request.
+func NewPluginClient(log logrus.FieldLogger, cfg PluginConfig) (*PluginClient, error) {
+	if len(cfg.Command) == 0 || cfg.Command[0] == "" {
+		return nil, errors.New("Plugin command missing")
+	}
+
+	if _, err := deposits.GetCoin(cfg.CoinType); err != nil {
+		return nil, err
+	}
+
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = defaultPluginRequestTimeout
+	}
+
+	return &PluginClient{
+		log: log.WithFields(logrus.Fields{
+			"prefix":   "scanner.plugin",
+			"coinType": cfg.CoinType,
+			"plugin":   cfg.Command[0],
+		}),
+		cfg:  cfg,
+		quit: make(chan struct{}),
+	}, nil
+}
+
+// Info returns the plugin's response to hello, starting the plugin if it isn't running
+func (c *PluginClient) Info() (PluginInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.start(); err != nil {
+		return PluginInfo{}, err
+	}
+	return c.info, nil
+}
+
+// GetBlockCount returns the height of the plugin's best block
+func (c *PluginClient) GetBlockCount() (int64, error) {
+	var height int64
+	if err := c.call("get_block_count", nil, &height); err != nil {
+		return 0, err
+	}
+	return height, nil
+}
+
+// GetBlock returns the plugin's block at height, nil if there is no block at height yet
+func (c *PluginClient) GetBlock(height int64) (*PluginBlock, error) {
+	var b *PluginBlock
+	if err := c.call("get_block", pluginBlockParams{Height: height}, &b); err != nil {
+		return nil, err
+	}
+
+	if b != nil && b.Height != height {
+		return nil, fmt.Errorf("Plugin returned the block at height %d for height %d", b.Height, height)
+	}
+
+	return b, nil
+}
+
+// ValidateAddress returns an error if the plugin doesn't accept addr as an address of its coin type
+func (c *PluginClient) ValidateAddress(addr string) error {
+	var v pluginAddressResult
+	if err := c.call("validate_address", pluginAddressParams{Address: addr}, &v); err != nil {
+		return err
+	}
+
+	if !v.Valid {
+		if v.Reason == "" {
+			return errors.New("Invalid address")
+		}
+		return errors.New(v.Reason)
+	}
+
+	return nil
+}
+
+// Shutdown stops the plugin. It is sent EOF on its stdin, and killed if it doesn't exit.
+func (c *PluginClient) Shutdown() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.shutdown = true
+	c.stop()
+}
+
+// call makes a request to the plugin, starting it if it isn't running, and decodes its result into result
+func (c *PluginClient) call(method string, params, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.start(); err != nil {
+		return err
+	}
+
+	return c.request(method, params, result)
+}
+
+// start starts the plugin if it isn't running, and says hello to it
+func (c *PluginClient) start() error {
+	if c.shutdown {
+		return ErrPluginShutdown
+	}
+
+	if c.proc != nil {
+		select {
+		case <-c.proc.exited:
+			c.log.WithError(c.proc.err).Warn("Plugin exited, starting it again")
+			c.proc = nil
+		default:
+			return nil
+		}
+	}
+
+	c.log.WithField("command", c.cfg.Command).Info("Starting plugin")
+
+	cmd := exec.Command(c.cfg.Command[0], c.cfg.Command[1:]...) // nolint: gosec
+	cmd.Env = os.Environ()
+	cmd.Stderr = &pluginLogWriter{log: c.log}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		c.log.WithError(err).Error("Start plugin failed")
+		return err
+	}
+
+	p := &pluginProcess{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan pluginResponse),
+		exited:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.exited)
+
+		dec := json.NewDecoder(stdout)
+		for {
+			var rsp pluginResponse
+			if err := dec.Decode(&rsp); err != nil {
+				p.err = err
+				if err == io.EOF {
+					p.err = errors.New("Plugin closed its stdout")
+				}
+				break
+			}
+			p.responses <- rsp
+		}
+
+		// Wait closes stdout, which must not be read after
+		if err := cmd.Wait(); err != nil {
+			p.err = fmt.Errorf("%v: %v", p.err, err)
+		}
+	}()
+
+	c.proc = p
+
+	var info PluginInfo
+	if err := c.request("hello", pluginHelloParams{
+		Protocol: PluginProtocolVersion,
+		CoinType: c.cfg.CoinType,
+	}, &info); err != nil {
+		c.log.WithError(err).Error("Plugin hello failed")
+		c.stop()
+		return err
+	}
+
+	if info.Protocol != PluginProtocolVersion {
+		err := fmt.Errorf("Plugin speaks protocol version %d, teller speaks %d", info.Protocol, PluginProtocolVersion)
+		c.log.WithError(err).Error("Plugin protocol version mismatch")
+		c.stop()
+		return err
+	}
+
+	c.info = info
+	c.log.WithFields(logrus.Fields{
+		"name":    info.Name,
+		"version": info.Version,
+	}).Info("Plugin started")
+
+	return nil
+}
+
+// request sends a request to the running plugin and waits for its response.
+// The plugin is killed if it doesn't respond in time or its response is out of order,
+// since the responses to later requests can't be told apart from it.
+func (c *PluginClient) request(method string, params, result interface{}) error {
+	p := c.proc
+
+	c.nextID++
+	req := pluginRequest{
+		ID:     c.nextID,
+		Method: method,
+		Params: params,
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.stdin.Write(append(b, '\n')); err != nil {
+		c.log.WithError(err).WithField("method", method).Error("Write plugin request failed")
+		c.stop()
+		return err
+	}
+
+	t := time.NewTimer(c.cfg.RequestTimeout)
+	defer t.Stop()
+
+	var rsp pluginResponse
+	select {
+	case rsp = <-p.responses:
+	case <-p.exited:
+		return fmt.Errorf("Plugin exited: %v", p.err)
+	case <-c.quit:
+		return ErrPluginShutdown
+	case <-t.C:
+		err := fmt.Errorf("Plugin %s request timed out after %s", method, c.cfg.RequestTimeout)
+		c.log.WithError(err).Error("Killing plugin")
+		c.kill()
+		return err
+	}
+
+	if rsp.ID != req.ID {
+		err := fmt.Errorf("Plugin responded to request %d, expected %d", rsp.ID, req.ID)
+		c.log.WithError(err).Error("Killing plugin")
+		c.kill()
+		return err
+	}
+
+	if rsp.Error != "" {
+		return fmt.Errorf("Plugin %s failed: %s", method, rsp.Error)
+	}
+
+	if err := json.Unmarshal(rsp.Result, result); err != nil {
+		return fmt.Errorf("Invalid plugin %s result: %v", method, err)
+	}
+
+	return nil
+}
+
+// stop closes the running plugin's stdin, and kills it if it doesn't exit in pluginShutdownTimeout
+func (c *PluginClient) stop() {
+	p := c.proc
+	if p == nil {
+		return
+	}
+	c.proc = nil
+
+	p.stdin.Close() // nolint: errcheck
+	c.waitExit(p)
+}
+
+// kill kills the running plugin
+func (c *PluginClient) kill() {
+	p := c.proc
+	if p == nil {
+		return
+	}
+	c.proc = nil
+
+	p.cmd.Process.Kill() // nolint: errcheck
+	c.waitExit(p)
+}
+
+// waitExit waits for the plugin to exit, killing it if it doesn't in pluginShutdownTimeout
+func (c *PluginClient) waitExit(p *pluginProcess) {
+	// Drain the responses, so the reader sees the plugin exit
+	t := time.NewTimer(pluginShutdownTimeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.responses:
+			continue
+		case <-p.exited:
+			c.log.WithError(p.err).Info("Plugin stopped")
+			return
+		case <-t.C:
+			c.log.Warn("Plugin didn't exit, killing it")
+			p.cmd.Process.Kill() // nolint: errcheck
+			t.Reset(pluginShutdownTimeout)
+		}
+	}
+}
+
+// pluginLogWriter logs the lines written to it, the plugin's stderr
+type pluginLogWriter struct {
+	log logrus.FieldLogger
+	buf bytes.Buffer
+}
+
+func (w *pluginLogWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// Keep the incomplete line for the next write
+			w.buf.WriteString(line)
+			return len(p), nil
+		}
+
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			w.log.WithField("stderr", line).Info("Plugin log")
+		}
+	}
+}
+
+// PluginScanner scans the blocks served by a scanner plugin for deposits
+type PluginScanner struct {
+	log      logrus.FieldLogger
+	client   PluginRPCClient
+	coinType string
+	Base     CommonScanner
+}
+
+// NewPluginScanner creates a scanner of the coin type's blocks served by a plugin
+func NewPluginScanner(log logrus.FieldLogger, store Storer, client PluginRPCClient, coinType string, cfg Config) (*PluginScanner, error) {
+	log = log.WithField("prefix", "scanner.plugin."+strings.ToLower(coinType))
+
+	bs, err := NewBaseScanner(store, log, coinType, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PluginScanner{
+		log:      log,
+		client:   client,
+		coinType: coinType,
+		Base:     bs,
+	}, nil
+}
+
+// Run starts the scanner
+func (s *PluginScanner) Run() error {
+	return s.Base.Run(s.client.GetBlockCount, s.getBlockAtHeight, s.waitForNextBlock, s.scanBlock)
+}
+
+// GetPauseGate returns the gate which pauses block scanning
+func (s *PluginScanner) GetPauseGate() *pauseutil.Gate {
+	return s.Base.GetPauseGate()
+}
+
+// GetPollScheduler returns the scheduler of the polls for a new block
+func (s *PluginScanner) GetPollScheduler() *PollScheduler {
+	return s.Base.GetPollScheduler()
+}
+
+// Shutdown shutdown the scanner
+func (s *PluginScanner) Shutdown() {
+	s.log.Info("Closing plugin scanner")
+	s.client.Shutdown()
+	s.Base.Shutdown()
+	s.log.Info("Plugin scanner stopped")
+}
+
+// scanBlock checks the block's outputs against the deposit addresses, and saves the deposits found
+func (s *PluginScanner) scanBlock(block *CommonBlock) (int, error) {
+	log := s.log.WithField("hash", block.Hash)
+	log = log.WithField("height", block.Height)
+
+	log.Debug("Scanning block")
+
+	dvs, err := s.Base.GetStorer().ScanBlock(block, s.coinType)
+	if err != nil {
+		log.WithError(err).Error("store.ScanBlock failed")
+		return 0, err
+	}
+
+	log = log.WithField("scannedDeposits", len(dvs))
+	log.Infof("Counted %d deposits from block", len(dvs))
+
+	n := 0
+	for _, dv := range dvs {
+		select {
+		case s.Base.GetScannedDepositChan() <- dv:
+			n++
+		case <-s.Base.GetQuitChan():
+			return n, errQuit
+		}
+	}
+
+	return n, nil
+}
+
+// getBlockAtHeight returns the block at height. It returns ErrPluginBlockNotFound if there is none yet.
+func (s *PluginScanner) getBlockAtHeight(height int64) (*CommonBlock, error) {
+	b, err := s.client.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		return nil, ErrPluginBlockNotFound
+	}
+	return pluginBlock2CommonBlock(s.coinType, b), nil
+}
+
+// waitForNextBlock polls for the block after block until it is available
+func (s *PluginScanner) waitForNextBlock(block *CommonBlock) (*CommonBlock, error) {
+	log := s.log.WithField("blockHash", block.Hash)
+	log = log.WithField("blockHeight", block.Height)
+	log.Debug("Waiting for the next block")
+
+	for {
+		nextBlock, err := s.client.GetBlock(block.Height + 1)
+		if err != nil {
+			log.WithError(err).Error("GetBlock failed")
+		}
+		if err == nil && nextBlock == nil {
+			log.Debug("No new block yet")
+		}
+		if err != nil || nextBlock == nil {
+			if !s.Base.GetPollScheduler().Wait(s.Base.GetQuitChan()) {
+				return nil, errQuit
+			}
+			continue
+		}
+
+		log.WithFields(logrus.Fields{
+			"hash":   nextBlock.Hash,
+			"height": nextBlock.Height,
+		}).Debug("Found nextBlock")
+
+		return pluginBlock2CommonBlock(s.coinType, nextBlock), nil
+	}
+}
+
+// AddScanAddress adds new scan address
+func (s *PluginScanner) AddScanAddress(addr, coinType string) error {
+	if err := s.Base.GetStorer().AddScanAddress(addr, coinType); err != nil {
+		return err
+	}
+
+	// A deposit to the new address is likely soon
+	s.Base.GetPollScheduler().Boost(time.Now())
+
+	return nil
+}
+
+// GetScanAddresses returns the deposit addresses that need to scan
+func (s *PluginScanner) GetScanAddresses() ([]string, error) {
+	return s.Base.GetStorer().GetScanAddresses(s.coinType)
+}
+
+// ValidateAddress returns an error if the plugin doesn't accept addr as an address of the coin type.
+// It checks the deposit address pool of a coin type added by the plugin.
+func (s *PluginScanner) ValidateAddress(addr string) error {
+	return s.client.ValidateAddress(addr)
+}
+
+// PendingDeposits returns the deposits detected in a block which is not final yet
+func (s *PluginScanner) PendingDeposits(coinType string) []deposits.Deposit {
+	return s.Base.PendingDeposits()
+}
+
+// GetDeposit returns deposit value channel.
+func (s *PluginScanner) GetDeposit() <-chan DepositNote {
+	return s.Base.GetDeposit()
+}
+
+// pluginBlock2CommonBlock converts a plugin's block to a common block
+func pluginBlock2CommonBlock(coinType string, b *PluginBlock) *CommonBlock {
+	cb := CommonBlock{
+		Height: b.Height,
+		Hash:   b.Hash,
+		RawTx:  make([]CommonTx, 0, len(b.Txs)),
+	}
+
+	for _, tx := range b.Txs {
+		ctx := CommonTx{
+			Txid: tx.Txid,
+			Vout: make([]CommonVout, 0, len(tx.Outputs)),
+		}
+
+		for _, o := range tx.Outputs {
+			addrs := o.Addresses
+			// The ETH deposit addresses are saved lowercase, like the ETH scanner's
+			if coinType == CoinTypeETH {
+				addrs = make([]string, len(o.Addresses))
+				for i, a := range o.Addresses {
+					addrs[i] = strings.ToLower(a)
+				}
+			}
+
+			ctx.Vout = append(ctx.Vout, CommonVout{
+				Value:     o.Value,
+				N:         o.N,
+				Addresses: addrs,
+			})
+		}
+
+		cb.RawTx = append(cb.RawTx, ctx)
+	}
+
+	return &cb
+}
@@ -0,0 +1,285 @@
+package scanner
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// TestPluginHelperProcess is the plugin started by the tests, it isn't a test.
+// It serves the blocks 10 to 12, its behavior is set by TELLER_TEST_PLUGIN.
+func TestPluginHelperProcess(t *testing.T) {
+	mode := os.Getenv("TELLER_TEST_PLUGIN")
+	if mode == "" {
+		return
+	}
+	defer os.Exit(0)
+
+	blocks := map[int64]PluginBlock{
+		10: {Height: 10, Hash: "hash10", Txs: []PluginTx{}},
+		11: {Height: 11, Hash: "hash11", Txs: []PluginTx{
+			{Txid: "tx11", Outputs: []PluginOutput{
+				{N: 0, Value: 1000, Addresses: []string{"change"}},
+				{N: 1, Value: 5000, Addresses: []string{"addr1"}},
+			}},
+		}},
+		12: {Height: 12, Hash: "hash12", Txs: []PluginTx{}},
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	r := bufio.NewScanner(os.Stdin)
+	for r.Scan() {
+		var req struct {
+			ID     uint64 `json:"id"`
+			Method string `json:"method"`
+			Params struct {
+				Protocol int    `json:"protocol"`
+				CoinType string `json:"coin_type"`
+				Height   int64  `json:"height"`
+				Address  string `json:"address"`
+			} `json:"params"`
+		}
+		if err := json.Unmarshal(r.Bytes(), &req); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid request:", err)
+			os.Exit(1)
+		}
+
+		rsp := map[string]interface{}{"id": req.ID}
+		switch req.Method {
+		case "hello":
+			protocol := req.Params.Protocol
+			if mode == "protocol2" {
+				protocol = 2
+			}
+			rsp["result"] = PluginInfo{Protocol: protocol, Name: "test-" + req.Params.CoinType, Version: "0.1.0"}
+		case "get_block_count":
+			switch mode {
+			case "hang":
+				time.Sleep(time.Minute)
+			case "crash_once":
+				state := os.Getenv("TELLER_TEST_PLUGIN_STATE")
+				if _, err := os.Stat(state); os.IsNotExist(err) {
+					ioutil.WriteFile(state, nil, 0600) // nolint: errcheck
+					fmt.Fprintln(os.Stderr, "crashing")
+					os.Exit(2)
+				}
+			case "error":
+				rsp["error"] = "node unavailable"
+			}
+			if mode != "error" {
+				rsp["result"] = 12
+			}
+		case "get_block":
+			b, ok := blocks[req.Params.Height]
+			if ok {
+				rsp["result"] = b
+			} else {
+				rsp["result"] = nil
+			}
+		case "validate_address":
+			if strings.HasPrefix(req.Params.Address, "addr") {
+				rsp["result"] = map[string]interface{}{"valid": true}
+			} else {
+				rsp["result"] = map[string]interface{}{"valid": false, "reason": "not an addr address"}
+			}
+		default:
+			rsp["error"] = "unknown method " + req.Method
+		}
+
+		if err := enc.Encode(rsp); err != nil {
+			os.Exit(1)
+		}
+	}
+}
+
+func newTestPluginClient(t *testing.T, mode string, env ...string) *PluginClient {
+	log, _ := testutil.NewLogger(t)
+
+	os.Setenv("TELLER_TEST_PLUGIN", mode)
+	for i := 0; i+1 < len(env); i += 2 {
+		os.Setenv(env[i], env[i+1])
+	}
+	t.Cleanup(func() {
+		os.Unsetenv("TELLER_TEST_PLUGIN")
+		for i := 0; i+1 < len(env); i += 2 {
+			os.Unsetenv(env[i])
+		}
+	})
+
+	c, err := NewPluginClient(log, PluginConfig{
+		CoinType:       CoinTypeBTC,
+		Command:        []string{os.Args[0], "-test.run=TestPluginHelperProcess"},
+		RequestTimeout: time.Second * 2,
+	})
+	require.NoError(t, err)
+	t.Cleanup(c.Shutdown)
+
+	return c
+}
+
+func TestNewPluginClient(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	_, err := NewPluginClient(log, PluginConfig{CoinType: CoinTypeBTC})
+	require.Error(t, err)
+
+	_, err = NewPluginClient(log, PluginConfig{CoinType: "XRP", Command: []string{"plugin"}})
+	require.Error(t, err)
+
+	c, err := NewPluginClient(log, PluginConfig{CoinType: CoinTypeETH, Command: []string{"plugin"}})
+	require.NoError(t, err)
+	require.Equal(t, defaultPluginRequestTimeout, c.cfg.RequestTimeout)
+}
+
+func TestPluginClient(t *testing.T) {
+	c := newTestPluginClient(t, "ok")
+
+	info, err := c.Info()
+	require.NoError(t, err)
+	require.Equal(t, PluginInfo{Protocol: PluginProtocolVersion, Name: "test-BTC", Version: "0.1.0"}, info)
+
+	height, err := c.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(12), height)
+
+	b, err := c.GetBlock(11)
+	require.NoError(t, err)
+	require.NotNil(t, b)
+	require.Equal(t, "hash11", b.Hash)
+	require.Len(t, b.Txs, 1)
+	require.Equal(t, PluginOutput{N: 1, Value: 5000, Addresses: []string{"addr1"}}, b.Txs[0].Outputs[1])
+
+	// There is no block 13 yet
+	b, err = c.GetBlock(13)
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	c.Shutdown()
+	_, err = c.GetBlockCount()
+	require.Equal(t, ErrPluginShutdown, err)
+}
+
+func TestPluginClientValidateAddress(t *testing.T) {
+	c := newTestPluginClient(t, "ok")
+
+	require.NoError(t, c.ValidateAddress("addr1"))
+	require.EqualError(t, c.ValidateAddress("bad"), "not an addr address")
+}
+
+func TestPluginClientErrors(t *testing.T) {
+	c := newTestPluginClient(t, "error")
+	_, err := c.GetBlockCount()
+	require.EqualError(t, err, "Plugin get_block_count failed: node unavailable")
+
+	// The plugin keeps running after an error response
+	b, err := c.GetBlock(10)
+	require.NoError(t, err)
+	require.Equal(t, "hash10", b.Hash)
+
+	c = newTestPluginClient(t, "protocol2")
+	_, err = c.GetBlockCount()
+	require.EqualError(t, err, "Plugin speaks protocol version 2, teller speaks 1")
+
+	c = newTestPluginClient(t, "hang")
+	_, err = c.GetBlockCount()
+	require.EqualError(t, err, "Plugin get_block_count request timed out after 2s")
+
+	// The hung plugin was killed, a new one is started
+	b, err = c.GetBlock(12)
+	require.NoError(t, err)
+	require.Equal(t, "hash12", b.Hash)
+}
+
+func TestPluginClientRestart(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "crashed")
+	c := newTestPluginClient(t, "crash_once", "TELLER_TEST_PLUGIN_STATE", state)
+
+	_, err := c.GetBlockCount()
+	require.Error(t, err)
+
+	// The plugin exited, it is started again
+	height, err := c.GetBlockCount()
+	require.NoError(t, err)
+	require.Equal(t, int64(12), height)
+}
+
+func TestPluginScanner(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+	store, err := NewStore(log, db)
+	require.NoError(t, err)
+	store.AddSupportedCoin(CoinTypeBTC)
+
+	c := newTestPluginClient(t, "ok")
+
+	scr, err := NewPluginScanner(log, store, c, CoinTypeBTC, Config{
+		ScanPeriod:            time.Millisecond * 10,
+		DepositBufferSize:     5,
+		InitialScanHeight:     10,
+		ConfirmationsRequired: 1,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, scr.AddScanAddress("addr1", CoinTypeBTC))
+	addrs, err := scr.GetScanAddresses()
+	require.NoError(t, err)
+	require.Equal(t, []string{"addr1"}, addrs)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := scr.Run()
+		require.NoError(t, err)
+	}()
+
+	select {
+	case dv := <-scr.GetDeposit():
+		require.Equal(t, CoinTypeBTC, dv.CoinType)
+		require.Equal(t, "addr1", dv.Address)
+		require.Equal(t, "tx11", dv.Tx)
+		require.Equal(t, uint32(1), dv.N)
+		require.Equal(t, int64(5000), dv.Amount)
+		require.Equal(t, int64(11), dv.Height)
+		require.True(t, dv.Final)
+		dv.ErrC <- nil
+	case <-time.After(time.Second * 10):
+		t.Fatal("No deposit from the plugin scanner")
+	}
+
+	scr.Shutdown()
+	<-done
+}
+
+func TestPluginBlock2CommonBlock(t *testing.T) {
+	b := &PluginBlock{
+		Height: 5,
+		Hash:   "hash5",
+		Txs: []PluginTx{
+			{Txid: "tx", Outputs: []PluginOutput{{N: 2, Value: 7, Addresses: []string{"0xABCdef"}}}},
+		},
+	}
+
+	cb := pluginBlock2CommonBlock(CoinTypeETH, b)
+	require.Equal(t, &CommonBlock{
+		Height: 5,
+		Hash:   "hash5",
+		RawTx: []CommonTx{
+			{Txid: "tx", Vout: []CommonVout{{N: 2, Value: 7, Addresses: []string{"0xabcdef"}}}},
+		},
+	}, cb)
+
+	cb = pluginBlock2CommonBlock(CoinTypeBTC, b)
+	require.Equal(t, []string{"0xABCdef"}, cb.RawTx[0].Vout[0].Addresses)
+}
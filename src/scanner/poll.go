@@ -0,0 +1,233 @@
+package scanner
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// pollHistorySize is the number of recent inter-block times the expected block interval is estimated from
+	pollHistorySize = 11
+	// pollMinHistory is the number of inter-block times needed before the estimate is used
+	pollMinHistory   = 3
+	defaultBindBoost = time.Minute * 10
+)
+
+// PollConfig configures a PollScheduler
+type PollConfig struct {
+	// Shortest wait between polls, used when a new block is expected or after a deposit address was bound.
+	// 0 disables adaptive polling, and the scanner polls every ScanPeriod.
+	MinPeriod time.Duration
+	// Longest wait between polls, used when no block is expected yet or a block is long overdue.
+	// Defaults to ScanPeriod.
+	MaxPeriod time.Duration
+	// How long to poll every MinPeriod after a deposit address was bound
+	BindBoost time.Duration
+}
+
+// PollStats are the PollScheduler's current estimates, for metrics
+type PollStats struct {
+	// Wait before the next poll
+	Period time.Duration
+	// Median of the recent inter-block times, 0 until enough blocks were observed
+	BlockInterval time.Duration
+	Polls         uint64
+	Blocks        uint64
+}
+
+// PollScheduler decides how long the scanner waits before polling the node for a new block.
+// It estimates the block interval from the recent inter-block times of the node's best height,
+// and waits until about half the interval has passed since the last block before polling every
+// MinPeriod. If the block is more than twice the interval overdue, it backs off towards MaxPeriod.
+// After a deposit address is bound, it polls immediately and then every MinPeriod for BindBoost,
+// since a deposit is likely to follow.
+type PollScheduler struct {
+	sync.Mutex
+	cfg        PollConfig
+	scanPeriod time.Duration
+	boostC     chan struct{}
+
+	height      int64
+	lastBlockAt time.Time
+	intervals   []time.Duration
+	boostUntil  time.Time
+	// Polls made since the next block became overdue
+	misses int
+	polls  uint64
+	blocks uint64
+}
+
+// NewPollScheduler creates a PollScheduler. scanPeriod is the fixed wait of a disabled scheduler.
+func NewPollScheduler(cfg PollConfig, scanPeriod time.Duration) *PollScheduler {
+	if cfg.MinPeriod > 0 {
+		if cfg.MaxPeriod <= 0 {
+			cfg.MaxPeriod = scanPeriod
+		}
+		if cfg.MaxPeriod < cfg.MinPeriod {
+			cfg.MaxPeriod = cfg.MinPeriod
+		}
+		if cfg.BindBoost <= 0 {
+			cfg.BindBoost = defaultBindBoost
+		}
+	}
+
+	return &PollScheduler{
+		cfg:        cfg,
+		scanPeriod: scanPeriod,
+		boostC:     make(chan struct{}, 1),
+	}
+}
+
+func (p *PollScheduler) enabled() bool {
+	return p.cfg.MinPeriod > 0
+}
+
+// Observe records the node's best height. A height greater than the last one observed
+// is a new block, whose arrival time is used to estimate the block interval.
+func (p *PollScheduler) Observe(height int64, now time.Time) {
+	p.Lock()
+	defer p.Unlock()
+
+	switch {
+	case p.lastBlockAt.IsZero() || height < p.height:
+		// The first height observed, or the node reorganized to a shorter chain, are not block arrivals
+	case height == p.height:
+		return
+	default:
+		// Several blocks found at once share the time since the last one
+		d := now.Sub(p.lastBlockAt) / time.Duration(height-p.height)
+		p.intervals = append(p.intervals, d)
+		if len(p.intervals) > pollHistorySize {
+			p.intervals = p.intervals[len(p.intervals)-pollHistorySize:]
+		}
+		p.blocks += uint64(height - p.height)
+	}
+
+	p.height = height
+	p.lastBlockAt = now
+	p.misses = 0
+}
+
+// Boost polls immediately, then every MinPeriod for BindBoost.
+// It is called when a deposit address is bound.
+func (p *PollScheduler) Boost(now time.Time) {
+	if !p.enabled() {
+		return
+	}
+
+	p.Lock()
+	p.boostUntil = now.Add(p.cfg.BindBoost)
+	p.Unlock()
+
+	select {
+	case p.boostC <- struct{}{}:
+	default:
+	}
+}
+
+// Wait waits until the next poll. It returns false if quit is closed.
+func (p *PollScheduler) Wait(quit <-chan struct{}) bool {
+	d := p.next(time.Now())
+
+	p.Lock()
+	p.polls++
+	p.Unlock()
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-quit:
+		return false
+	case <-t.C:
+		return true
+	case <-p.boostC:
+		return true
+	}
+}
+
+// next returns the wait before the next poll, and counts a missed poll if the block is overdue
+func (p *PollScheduler) next(now time.Time) time.Duration {
+	p.Lock()
+	defer p.Unlock()
+
+	d := p.period(now)
+	if p.enabled() && p.overdue(now) {
+		p.misses++
+	}
+	return d
+}
+
+func (p *PollScheduler) period(now time.Time) time.Duration {
+	if !p.enabled() {
+		return p.scanPeriod
+	}
+
+	if now.Before(p.boostUntil) {
+		return p.cfg.MinPeriod
+	}
+
+	interval := p.blockInterval()
+	if interval == 0 {
+		return p.clamp(p.scanPeriod)
+	}
+
+	elapsed := now.Sub(p.lastBlockAt)
+	switch {
+	case elapsed < interval/2:
+		// Sleep until the next block may arrive
+		return p.clamp(interval/2 - elapsed)
+	case elapsed <= interval*2:
+		return p.cfg.MinPeriod
+	default:
+		// Overdue, e.g. the node is stalled or the network is slow, double the wait each poll
+		d := p.cfg.MinPeriod
+		for i := 0; i < p.misses && d < p.cfg.MaxPeriod; i++ {
+			d *= 2
+		}
+		return p.clamp(d)
+	}
+}
+
+func (p *PollScheduler) overdue(now time.Time) bool {
+	interval := p.blockInterval()
+	return interval != 0 && !now.Before(p.boostUntil) && now.Sub(p.lastBlockAt) > interval*2
+}
+
+// blockInterval returns the median of the recent inter-block times, 0 if too few blocks were observed
+func (p *PollScheduler) blockInterval() time.Duration {
+	if len(p.intervals) < pollMinHistory {
+		return 0
+	}
+
+	sorted := append([]time.Duration{}, p.intervals...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return sorted[len(sorted)/2]
+}
+
+func (p *PollScheduler) clamp(d time.Duration) time.Duration {
+	if d < p.cfg.MinPeriod {
+		return p.cfg.MinPeriod
+	}
+	if d > p.cfg.MaxPeriod {
+		return p.cfg.MaxPeriod
+	}
+	return d
+}
+
+// Stats returns the current estimates
+func (p *PollScheduler) Stats() PollStats {
+	p.Lock()
+	defer p.Unlock()
+
+	return PollStats{
+		Period:        p.period(time.Now()),
+		BlockInterval: p.blockInterval(),
+		Polls:         p.polls,
+		Blocks:        p.blocks,
+	}
+}
@@ -0,0 +1,149 @@
+package scanner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollSchedulerDisabled(t *testing.T) {
+	p := NewPollScheduler(PollConfig{}, time.Second*20)
+
+	now := time.Now()
+	for i := int64(0); i < 5; i++ {
+		p.Observe(100+i, now.Add(time.Duration(i)*time.Second))
+	}
+	p.Boost(now)
+
+	require.Equal(t, time.Second*20, p.period(now.Add(time.Second*5)))
+
+	// The block interval is still estimated
+	require.Equal(t, time.Second, p.Stats().BlockInterval)
+	require.Equal(t, time.Second*20, p.Stats().Period)
+}
+
+func TestPollSchedulerPeriod(t *testing.T) {
+	p := NewPollScheduler(PollConfig{
+		MinPeriod: time.Second,
+		MaxPeriod: time.Minute,
+		BindBoost: time.Minute * 2,
+	}, time.Second*20)
+
+	start := time.Now()
+
+	// The scan period is used until the block interval is known
+	p.Observe(100, start)
+	require.Equal(t, time.Second*20, p.period(start))
+
+	// Blocks every 10 minutes, with one outlier
+	p.Observe(101, start.Add(time.Minute*10))
+	p.Observe(102, start.Add(time.Minute*20))
+	require.Equal(t, time.Second*20, p.period(start.Add(time.Minute*20)))
+	p.Observe(103, start.Add(time.Minute*50))
+	p.Observe(104, start.Add(time.Minute*60))
+	require.Equal(t, time.Minute*10, p.blockInterval())
+
+	last := start.Add(time.Minute * 60)
+
+	// Backs off while the next block isn't expected
+	require.Equal(t, time.Minute, p.period(last))
+	require.Equal(t, time.Minute, p.period(last.Add(time.Minute*4)))
+	require.Equal(t, time.Second*30, p.period(last.Add(time.Minute*4+time.Second*30)))
+	require.Equal(t, time.Second, p.period(last.Add(time.Minute*5-time.Millisecond*200)))
+
+	// Polls fast once the next block is expected
+	require.Equal(t, time.Second, p.period(last.Add(time.Minute*5)))
+	require.Equal(t, time.Second, p.period(last.Add(time.Minute*20)))
+
+	// Backs off when the block is overdue
+	overdue := last.Add(time.Minute * 21)
+	var waits []time.Duration
+	for i := 0; i < 8; i++ {
+		waits = append(waits, p.next(overdue))
+	}
+	require.Equal(t, []time.Duration{
+		time.Second,
+		time.Second * 2,
+		time.Second * 4,
+		time.Second * 8,
+		time.Second * 16,
+		time.Second * 32,
+		time.Minute,
+		time.Minute,
+	}, waits)
+
+	// A new block resets the backoff. Two blocks found at once count as two intervals of half the time.
+	p.Observe(106, overdue)
+	require.Equal(t, time.Minute, p.period(overdue))
+	require.Equal(t, uint64(6), p.Stats().Blocks)
+
+	// Binding polls fast for BindBoost
+	require.Equal(t, time.Minute, p.period(overdue.Add(time.Minute)))
+	p.Boost(overdue)
+	require.Equal(t, time.Second, p.period(overdue))
+	require.Equal(t, time.Second, p.period(overdue.Add(time.Minute)))
+	require.Equal(t, time.Minute, p.period(overdue.Add(time.Minute*2)))
+}
+
+func TestPollSchedulerObserve(t *testing.T) {
+	p := NewPollScheduler(PollConfig{
+		MinPeriod: time.Second,
+	}, time.Second*20)
+
+	// MaxPeriod defaults to the scan period
+	require.Equal(t, time.Second*20, p.cfg.MaxPeriod)
+	require.Equal(t, defaultBindBoost, p.cfg.BindBoost)
+
+	start := time.Now()
+	p.Observe(100, start)
+	p.Observe(100, start.Add(time.Second*5))
+	require.Empty(t, p.intervals)
+
+	// Several blocks at once
+	p.Observe(103, start.Add(time.Second*30))
+	require.Equal(t, []time.Duration{time.Second * 10}, p.intervals)
+
+	// A shorter chain isn't a block arrival
+	p.Observe(102, start.Add(time.Second*40))
+	require.Equal(t, []time.Duration{time.Second * 10}, p.intervals)
+	p.Observe(103, start.Add(time.Second*52))
+	require.Equal(t, []time.Duration{time.Second * 10, time.Second * 12}, p.intervals)
+
+	// Only the recent intervals are kept
+	h := int64(103)
+	at := start.Add(time.Second * 52)
+	for i := 0; i < pollHistorySize*2; i++ {
+		h++
+		at = at.Add(time.Second * 15)
+		p.Observe(h, at)
+	}
+	require.Len(t, p.intervals, pollHistorySize)
+	require.Equal(t, time.Second*15, p.blockInterval())
+}
+
+func TestPollSchedulerWait(t *testing.T) {
+	p := NewPollScheduler(PollConfig{
+		MinPeriod: time.Hour,
+		MaxPeriod: time.Hour,
+	}, time.Hour)
+
+	// Binding an address wakes up a waiting poll
+	done := make(chan bool)
+	go func() {
+		done <- p.Wait(make(chan struct{}))
+	}()
+
+	p.Boost(time.Now())
+	select {
+	case ok := <-done:
+		require.True(t, ok)
+	case <-time.After(time.Second * 5):
+		t.Fatal("Wait was not woken up by Boost")
+	}
+
+	quit := make(chan struct{})
+	close(quit)
+	require.False(t, p.Wait(quit))
+	require.Equal(t, uint64(2), p.Stats().Polls)
+}
@@ -1,17 +1,23 @@
 package scanner
 
 import (
-	"fmt"
-
 	"github.com/btcsuite/btcd/btcjson"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/skycoin/teller/src/deposits"
 )
 
-// Scanner provids apis for interacting with a scan service
+// Scanner provids apis for interacting with a scan service.
+// A chain is added by implementing a Scanner of its coin type, and registering it with Multiplexer.AddScanner.
 type Scanner interface {
 	AddScanAddress(string, string) error
 	GetDeposit() <-chan DepositNote
+	// PendingDeposits returns the deposits of a coin type which were detected, but are not final yet
+	PendingDeposits(string) []deposits.Deposit
+	// Run scans the chain until Shutdown is called
+	Run() error
+	Shutdown()
 }
 
 // BtcRPCClient rpcclient interface
@@ -29,32 +35,24 @@ type EthRPCClient interface {
 	Shutdown()
 }
 
+// PluginRPCClient is the client of a scanner plugin, see PluginClient
+type PluginRPCClient interface {
+	GetBlockCount() (int64, error)
+	GetBlock(height int64) (*PluginBlock, error)
+	ValidateAddress(addr string) error
+	Shutdown()
+}
+
 // DepositNote wraps a Deposit with an ack channel
 type DepositNote struct {
-	Deposit
+	deposits.Deposit
 	ErrC chan error
 }
 
 // NewDepositNote returns a DepositNote
-func NewDepositNote(dv Deposit) DepositNote {
+func NewDepositNote(dv deposits.Deposit) DepositNote {
 	return DepositNote{
 		Deposit: dv,
 		ErrC:    make(chan error, 1),
 	}
 }
-
-// Deposit struct
-type Deposit struct {
-	CoinType  string // coin type
-	Address   string // deposit address
-	Value     int64  // deposit amount. For BTC, measured in satoshis.
-	Height    int64  // the block height
-	Tx        string // the transaction id
-	N         uint32 // the index of vout in the tx [BTC]
-	Processed bool   // whether this was received by the exchange and saved
-}
-
-// ID returns $tx:$n formatted ID string
-func (d Deposit) ID() string {
-	return fmt.Sprintf("%s:%d", d.Tx, d.N)
-}
@@ -8,14 +8,15 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/sirupsen/logrus"
 
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/util/dbutil"
 )
 
 // CoinTypeBTC is BTC coin type
-const CoinTypeBTC = "BTC"
+const CoinTypeBTC = deposits.CoinTypeBTC
 
 // CoinTypeETH is ETH coin type
-const CoinTypeETH = "ETH"
+const CoinTypeETH = deposits.CoinTypeETH
 
 var (
 	// scan meta info bucket
@@ -31,7 +32,7 @@ var (
 	dvIndexListKey = "dv_index_list"
 
 	// unsupported coin type
-	ErrUnsupportedCoinType = errors.New("unsupported coin type")
+	ErrUnsupportedCoinType = deposits.ErrUnsupportedCoinType
 )
 
 // DepositsEmptyErr is returned if there are no deposit values
@@ -70,8 +71,8 @@ type Storer interface {
 	GetScanAddresses(string) ([]string, error)
 	AddScanAddress(string, string) error
 	SetDepositProcessed(string) error
-	GetUnprocessedDeposits() ([]Deposit, error)
-	ScanBlock(*CommonBlock, string) ([]Deposit, error)
+	GetUnprocessedDeposits() ([]deposits.Deposit, error)
+	ScanBlock(*CommonBlock, string) ([]deposits.Deposit, error)
 }
 
 // Store records scanner meta info for BTC deposits
@@ -172,7 +173,7 @@ func (s *Store) AddScanAddress(addr, coinType string) error {
 // SetDepositProcessed marks a Deposit as processed
 func (s *Store) SetDepositProcessed(dvKey string) error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		var dv Deposit
+		var dv deposits.Deposit
 		if err := dbutil.GetBucketObject(tx, DepositBkt, dvKey, &dv); err != nil {
 			return err
 		}
@@ -187,18 +188,44 @@ func (s *Store) SetDepositProcessed(dvKey string) error {
 	})
 }
 
+// GetDeposit returns the Deposit saved for a deposit ID, and false if there is none
+func (s *Store) GetDeposit(dvKey string) (deposits.Deposit, bool, error) {
+	var dv deposits.Deposit
+	var found bool
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		err := dbutil.GetBucketObject(tx, DepositBkt, dvKey, &dv)
+		switch err.(type) {
+		case nil:
+			found = true
+			return nil
+		case dbutil.ObjectNotExistErr:
+			return nil
+		default:
+			return err
+		}
+	}); err != nil {
+		return deposits.Deposit{}, false, err
+	}
+
+	return dv, found, nil
+}
+
 // GetUnprocessedDeposits returns all Deposits not marked as Processed
-func (s *Store) GetUnprocessedDeposits() ([]Deposit, error) {
-	var dvs []Deposit
+func (s *Store) GetUnprocessedDeposits() ([]deposits.Deposit, error) {
+	var dvs []deposits.Deposit
 
 	if err := s.db.View(func(tx *bolt.Tx) error {
 		return dbutil.ForEach(tx, DepositBkt, func(k, v []byte) error {
-			var dv Deposit
+			var dv deposits.Deposit
 			if err := json.Unmarshal(v, &dv); err != nil {
 				return err
 			}
 
 			if !dv.Processed {
+				// Deposits are only stored once their block has the required confirmations,
+				// but deposits stored before the Final flag existed don't have it set
+				dv.Final = true
 				dvs = append(dvs, dv)
 			}
 
@@ -211,9 +238,34 @@ func (s *Store) GetUnprocessedDeposits() ([]Deposit, error) {
 	return dvs, nil
 }
 
+// GetDepositTotals returns the sum of the amounts of the processed Deposits, by coin type.
+// These are the payments to the deposit addresses seen on chain which were sent to the exchange.
+func (s *Store) GetDepositTotals() (map[string]int64, error) {
+	totals := make(map[string]int64)
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, DepositBkt, func(k, v []byte) error {
+			var dv deposits.Deposit
+			if err := json.Unmarshal(v, &dv); err != nil {
+				return err
+			}
+
+			if dv.Processed {
+				totals[dv.CoinType] += dv.Amount
+			}
+
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
 // pushDepositTx adds an Deposit in a bolt.Tx
 // Returns DepositExistsErr if the deposit already exists
-func (s *Store) pushDepositTx(tx *bolt.Tx, dv Deposit) error {
+func (s *Store) pushDepositTx(tx *bolt.Tx, dv deposits.Deposit) error {
 	key := dv.ID()
 
 	// Check if the deposit value already exists
@@ -229,7 +281,7 @@ func (s *Store) pushDepositTx(tx *bolt.Tx, dv Deposit) error {
 
 // ScanBlock scans a coin block for deposits and adds them
 // If the deposit already exists, the result is omitted from the returned list
-func (s *Store) ScanBlock(block *CommonBlock, coinType string) ([]Deposit, error) {
+func (s *Store) ScanBlock(block *CommonBlock, coinType string) ([]deposits.Deposit, error) {
 	return s.scanBlock(block, coinType)
 }
 
@@ -237,8 +289,8 @@ func (s *Store) ScanBlock(block *CommonBlock, coinType string) ([]Deposit, error
 // 1. get deposit address by coinType
 // 2. call callback function to get deposit
 // 3. push deposit into db, finished at one transaction
-func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]Deposit, error) {
-	var dvs []Deposit
+func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]deposits.Deposit, error) {
+	var dvs []deposits.Deposit
 
 	if err := s.db.Update(func(tx *bolt.Tx) error {
 		addrs, err := s.getScanAddressesTx(tx, coinType)
@@ -247,13 +299,13 @@ func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]Deposit, error
 			return err
 		}
 
-		deposits, err := scanSpecifiedBlock(block, coinType, addrs)
+		found, err := scanSpecifiedBlock(block, coinType, addrs)
 		if err != nil {
 			s.log.WithError(err).Error("ScanBlock failed")
 			return err
 		}
 
-		for _, dv := range deposits {
+		for _, dv := range found {
 			if err := s.pushDepositTx(tx, dv); err != nil {
 				log := s.log.WithField("deposit", dv)
 				switch err.(type) {
@@ -278,8 +330,8 @@ func (s *Store) scanBlock(block *CommonBlock, coinType string) ([]Deposit, error
 }
 
 // ScanBTCBlock scan the given block and returns the next block hash or error
-func scanSpecifiedBlock(block *CommonBlock, coinType string, depositAddrs []string) ([]Deposit, error) {
-	var dv []Deposit
+func scanSpecifiedBlock(block *CommonBlock, coinType string, depositAddrs []string) ([]deposits.Deposit, error) {
+	var dv []deposits.Deposit
 
 	addrMap := map[string]struct{}{}
 	for _, a := range depositAddrs {
@@ -292,13 +344,16 @@ func scanSpecifiedBlock(block *CommonBlock, coinType string, depositAddrs []stri
 
 			for _, a := range v.Addresses {
 				if _, ok := addrMap[a]; ok {
-					dv = append(dv, Deposit{
-						CoinType: coinType,
-						Address:  a,
-						Value:    int64(amt),
-						Height:   block.Height,
-						Tx:       tx.Txid,
-						N:        v.N,
+					dv = append(dv, deposits.Deposit{
+						CoinType:      coinType,
+						Address:       a,
+						Amount:        int64(amt),
+						Height:        block.Height,
+						Confirmations: block.Confirmations,
+						// Blocks are only scanned once they have the required confirmations
+						Final: true,
+						Tx:    tx.Txid,
+						N:     v.N,
 					})
 				}
 			}
@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/util/dbutil"
 	"github.com/skycoin/teller/src/util/testutil"
 )
@@ -40,7 +41,7 @@ func (m *MockStore) SetDepositProcessed(dv string) error {
 	return args.Error(1)
 }
 
-func (m *MockStore) GetUnprocessedDeposits() ([]Deposit, error) {
+func (m *MockStore) GetUnprocessedDeposits() ([]deposits.Deposit, error) {
 	args := m.Called()
 
 	dvs := args.Get(0)
@@ -49,10 +50,10 @@ func (m *MockStore) GetUnprocessedDeposits() ([]Deposit, error) {
 		return nil, args.Error(1)
 	}
 
-	return dvs.([]Deposit), args.Error(1)
+	return dvs.([]deposits.Deposit), args.Error(1)
 }
 
-func (m *MockStore) ScanBlock(*btcjson.GetBlockVerboseResult) ([]Deposit, error) {
+func (m *MockStore) ScanBlock(*btcjson.GetBlockVerboseResult) ([]deposits.Deposit, error) {
 	args := m.Called()
 
 	dvs := args.Get(0)
@@ -61,11 +62,11 @@ func (m *MockStore) ScanBlock(*btcjson.GetBlockVerboseResult) ([]Deposit, error)
 		return nil, args.Error(1)
 	}
 
-	return dvs.([]Deposit), args.Error(1)
+	return dvs.([]deposits.Deposit), args.Error(1)
 }
 
 func TestBtcTxN(t *testing.T) {
-	d := Deposit{
+	d := deposits.Deposit{
 		Tx: "foo",
 		N:  2,
 	}
@@ -201,17 +202,17 @@ func TestPushDeposit(t *testing.T) {
 	db, shutdown := testutil.PrepareDB(t)
 	defer shutdown()
 
-	dvs := []Deposit{
+	dvs := []deposits.Deposit{
 		{
 			Address: "b1",
-			Value:   1,
+			Amount:  1,
 			Height:  1,
 			Tx:      "t1",
 			N:       1,
 		},
 		{
 			Address: "b2",
-			Value:   2,
+			Amount:  2,
 			Height:  2,
 			Tx:      "t2",
 			N:       2,
@@ -240,23 +241,88 @@ func TestPushDeposit(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestGetDepositTotals(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	log, _ := testutil.NewLogger(t)
+
+	s, err := NewStore(log, db)
+	require.NoError(t, err)
+
+	totals, err := s.GetDepositTotals()
+	require.NoError(t, err)
+	require.Empty(t, totals)
+
+	dvs := []deposits.Deposit{
+		{
+			CoinType:  CoinTypeBTC,
+			Address:   "b1",
+			Amount:    1e8,
+			Tx:        "t1",
+			N:         1,
+			Processed: true,
+		},
+		{
+			CoinType:  CoinTypeBTC,
+			Address:   "b2",
+			Amount:    2e8,
+			Tx:        "t2",
+			N:         1,
+			Processed: true,
+		},
+		{
+			CoinType: CoinTypeBTC,
+			Address:  "b3",
+			Amount:   4e8,
+			Tx:       "t3",
+			N:        1,
+		},
+		{
+			CoinType:  CoinTypeETH,
+			Address:   "e1",
+			Amount:    5e9,
+			Tx:        "t4",
+			N:         0,
+			Processed: true,
+		},
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, dv := range dvs {
+			if err := s.pushDepositTx(tx, dv); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	totals, err = s.GetDepositTotals()
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{
+		CoinTypeBTC: 3e8,
+		CoinTypeETH: 5e9,
+	}, totals)
+}
+
 func TestPutBktValue(t *testing.T) {
 
 	type kv struct {
 		key   string
-		value Deposit
+		value deposits.Deposit
 	}
 
-	dvs := []Deposit{
-		Deposit{
+	dvs := []deposits.Deposit{
+		deposits.Deposit{
 			Tx: "t1",
 			N:  1,
 		},
-		Deposit{
+		deposits.Deposit{
 			Tx: "t2",
 			N:  2,
 		},
-		Deposit{
+		deposits.Deposit{
 			Tx: "t3",
 			N:  3,
 		},
@@ -283,7 +349,7 @@ func TestPutBktValue(t *testing.T) {
 		name    string
 		putV    []kv
 		key     string
-		expectV Deposit
+		expectV deposits.Deposit
 		err     error
 	}{
 		{
@@ -315,7 +381,7 @@ func TestPutBktValue(t *testing.T) {
 			require.NoError(t, err)
 
 			err = db.View(func(tx *bolt.Tx) error {
-				var dv Deposit
+				var dv deposits.Deposit
 				require.Nil(t, dbutil.GetBucketObject(tx, bktName, tc.key, &dv))
 				require.Equal(t, tc.expectV, dv)
 				return nil
@@ -330,19 +396,19 @@ func TestGetBktValue(t *testing.T) {
 
 	type kv struct {
 		key   string
-		value Deposit
+		value deposits.Deposit
 	}
 
-	dvs := []Deposit{
-		Deposit{
+	dvs := []deposits.Deposit{
+		deposits.Deposit{
 			Tx: "t1",
 			N:  1,
 		},
-		Deposit{
+		deposits.Deposit{
 			Tx: "t2",
 			N:  2,
 		},
-		Deposit{
+		deposits.Deposit{
 			Tx: "t3",
 			N:  3,
 		},
@@ -370,14 +436,14 @@ func TestGetBktValue(t *testing.T) {
 		init    []kv
 		key     string
 		v       interface{}
-		expectV Deposit
+		expectV deposits.Deposit
 		err     error
 	}{
 		{
 			"normal",
 			init,
 			"k1",
-			&Deposit{},
+			&deposits.Deposit{},
 			dvs[0],
 			nil,
 		},
@@ -385,7 +451,7 @@ func TestGetBktValue(t *testing.T) {
 			"not exist",
 			init,
 			"k5",
-			&Deposit{},
+			&deposits.Deposit{},
 			dvs[0],
 			dbutil.NewObjectNotExistErr(bktName, []byte("k5")),
 		},
@@ -393,9 +459,9 @@ func TestGetBktValue(t *testing.T) {
 			"invalid accept value",
 			init,
 			"k3",
-			Deposit{},
+			deposits.Deposit{},
 			dvs[0],
-			errors.New("decode value failed: json: Unmarshal(non-pointer scanner.Deposit)"),
+			errors.New("decode value failed: json: Unmarshal(non-pointer deposits.Deposit)"),
 		},
 	}
 
@@ -422,7 +488,7 @@ func TestGetBktValue(t *testing.T) {
 				require.Equal(t, tc.err, err)
 
 				if err == nil {
-					v := tc.v.(*Deposit)
+					v := tc.v.(*deposits.Deposit)
 					require.Equal(t, tc.expectV, *v)
 				}
 
@@ -2,11 +2,13 @@ package sender
 
 import (
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -28,6 +30,10 @@ func randSHA256() (cipher.SHA256, error) {
 	return cipher.SumSHA256(b), nil
 }
 
+// errDummyNodeDown is returned by the DummySender's node requests while the simulated node is down,
+// wrapped in an RPCError so that the exchange retries them like the errors of an unreachable node
+var errDummyNodeDown = errors.New("Dummy node is down")
+
 // DummyTransaction wraps a *coin.Transaction with metadata for DummySender
 type DummyTransaction struct {
 	*coin.Transaction
@@ -41,23 +47,60 @@ type DummySender struct {
 	broadcastTxns map[string]*DummyTransaction
 	seq           int64
 	secKey        cipher.SecKey
+	keys          KeyStore
 	log           logrus.FieldLogger
+	// When the simulated node went down, zero while it is up
+	downSince time.Time
 	sync.RWMutex
 }
 
-// NewDummySender creates a DummySender
-func NewDummySender(log logrus.FieldLogger) *DummySender {
+// NewDummySender creates a DummySender. The broadcasts' idempotency keys are looked up in keys, if not nil.
+func NewDummySender(log logrus.FieldLogger, keys KeyStore) *DummySender {
 	_, sec := cipher.GenerateDeterministicKeyPair([]byte(seed))
 
 	return &DummySender{
 		broadcastTxns: make(map[string]*DummyTransaction),
 		secKey:        sec,
+		keys:          keys,
 		log:           log.WithField("prefix", "sender.dummy"),
 	}
 }
 
+// SetNodeDown takes the simulated node down, which fails the node requests until it is brought up again
+func (s *DummySender) SetNodeDown(down bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case down && s.downSince.IsZero():
+		s.log.Warn("Dummy node is down")
+		s.downSince = time.Now()
+	case !down && !s.downSince.IsZero():
+		s.log.Info("Dummy node is up")
+		s.downSince = time.Time{}
+	}
+}
+
+// DownSince returns when the simulated node went down, zero if it is up
+func (s *DummySender) DownSince() time.Time {
+	s.RLock()
+	defer s.RUnlock()
+	return s.downSince
+}
+
+// SwitchNode switches to the node at addr, which brings the simulated node up
+func (s *DummySender) SwitchNode(addr string) error {
+	s.log.WithField("addr", addr).Info("Dummy node switched")
+	s.SetNodeDown(false)
+	return nil
+}
+
 // CreateTransaction creates a fake skycoin transaction
 func (s *DummySender) CreateTransaction(addr string, coins uint64) (*coin.Transaction, error) {
+	if !s.DownSince().IsZero() {
+		return nil, RPCError{errDummyNodeDown}
+	}
+
 	c, err := droplet.ToString(coins)
 	if err != nil {
 		s.log.WithError(err).Error("droplet.ToString failed")
@@ -90,7 +133,7 @@ func (s *DummySender) CreateTransaction(addr string, coins uint64) (*coin.Transa
 }
 
 // BroadcastTransaction broadcasts a fake skycoin transaction
-func (s *DummySender) BroadcastTransaction(txn *coin.Transaction) *BroadcastTxResponse {
+func (s *DummySender) BroadcastTransaction(txn *coin.Transaction, key string) *BroadcastTxResponse {
 	s.log.WithField("txid", txn.TxIDHex()).Info("BroadcastTransaction")
 
 	s.Lock()
@@ -98,12 +141,27 @@ func (s *DummySender) BroadcastTransaction(txn *coin.Transaction) *BroadcastTxRe
 
 	req := BroadcastTxRequest{
 		Tx:   txn,
+		Key:  key,
 		RspC: make(chan *BroadcastTxResponse, 1),
 	}
 
+	if !s.downSince.IsZero() {
+		return &BroadcastTxResponse{
+			Err: RPCError{errDummyNodeDown},
+			Req: req,
+		}
+	}
+
+	if err := checkSendKey(s.keys, key, txn.TxIDHex()); err != nil {
+		return &BroadcastTxResponse{
+			Err: err,
+			Req: req,
+		}
+	}
+
 	if _, ok := s.broadcastTxns[txn.TxIDHex()]; ok {
 		return &BroadcastTxResponse{
-			Err: fmt.Errorf("Transaction %s was already broadcast"),
+			Err: fmt.Errorf("Transaction %s was already broadcast", txn.TxIDHex()),
 			Req: req,
 		}
 	}
@@ -129,24 +187,112 @@ func (s *DummySender) IsTxConfirmed(txid string) *ConfirmResponse {
 	s.RLock()
 	defer s.RUnlock()
 
+	req := ConfirmRequest{
+		Txid: txid,
+		RspC: make(chan *ConfirmResponse, 1),
+	}
+
+	if !s.downSince.IsZero() {
+		return &ConfirmResponse{
+			Err: RPCError{errDummyNodeDown},
+			Req: req,
+		}
+	}
+
 	txn := s.broadcastTxns[txid]
 
 	return &ConfirmResponse{
 		Confirmed: txn != nil && txn.Confirmed,
 		Err:       nil,
-		Req: ConfirmRequest{
-			Txid: txid,
-			RspC: make(chan *ConfirmResponse, 1),
-		},
+		Req:       req,
 	}
 }
 
+// AddressSeen returns true if a fake skycoin transaction has an output to the address
+func (s *DummySender) AddressSeen(addr string) (bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.downSince.IsZero() {
+		return false, RPCError{errDummyNodeDown}
+	}
+
+	for _, txn := range s.broadcastTxns {
+		for _, o := range txn.Out {
+			if o.Address.String() == addr {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// GetTxOutput returns the droplets sent to an address by a fake skycoin transaction
+func (s *DummySender) GetTxOutput(txid, addr string) (uint64, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if !s.downSince.IsZero() {
+		return 0, RPCError{errDummyNodeDown}
+	}
+
+	txn := s.broadcastTxns[txid]
+	if txn == nil {
+		return 0, fmt.Errorf("Transaction %s not found", txid)
+	}
+
+	var coins uint64
+	for _, o := range txn.Out {
+		if o.Address.String() == addr {
+			coins += o.Coins
+		}
+	}
+
+	return coins, nil
+}
+
 // HTTP interface
 
 // BindHandlers binds admin API handlers to the mux
 func (s *DummySender) BindHandlers(mux *http.ServeMux) {
 	mux.Handle("/dummy/sender/broadcasts", http.HandlerFunc(s.getBroadcastedTransactionsHandler))
 	mux.Handle("/dummy/sender/confirm", http.HandlerFunc(s.confirmBroadcastedTransactionHandler))
+	mux.Handle("/dummy/sender/node", http.HandlerFunc(s.nodeHandler))
+}
+
+type dummyNodeResponse struct {
+	Down bool `json:"down"`
+	// When the node went down, as a unix timestamp, 0 while it is up
+	DownSince int64 `json:"down_since"`
+}
+
+// nodeHandler returns whether the simulated node is down. It is taken down or brought up by the form value
+// "down", "true" or "false".
+func (s *DummySender) nodeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		down, err := strconv.ParseBool(r.FormValue("down"))
+		if err != nil {
+			httputil.ErrResponse(w, http.StatusBadRequest, "invalid down, must be true or false")
+			return
+		}
+		s.SetNodeDown(down)
+	default:
+		httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	rsp := dummyNodeResponse{}
+	if downSince := s.DownSince(); !downSince.IsZero() {
+		rsp.Down = true
+		rsp.DownSince = downSince.Unix()
+	}
+
+	if err := httputil.JSONResponse(w, rsp); err != nil {
+		s.log.WithError(err).Error(err)
+	}
 }
 
 func (s *DummySender) getBroadcastedTransactions() []*DummyTransaction {
@@ -1,6 +1,10 @@
 package sender
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -11,7 +15,8 @@ import (
 func TestDummySender(t *testing.T) {
 	log, _ := testutil.NewLogger(t)
 
-	s := NewDummySender(log)
+	keys := dummyKeys{}
+	s := NewDummySender(log, keys)
 
 	addr := "2VZu3rZozQ6nN37YSdj3EZJV7wSFVuLSm2X"
 	var coins uint64 = 100
@@ -29,13 +34,44 @@ func TestDummySender(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEqual(t, txn.TxIDHex(), txn2.TxIDHex())
 
-	bRsp := s.BroadcastTransaction(txn)
+	seen, err := s.AddressSeen(addr)
+	require.NoError(t, err)
+	require.False(t, seen)
+
+	key := IdempotencyKey("btc-tx:1")
+	keys[key] = txn.TxIDHex()
+
+	bRsp := s.BroadcastTransaction(txn, key)
 	require.NotNil(t, bRsp)
 	require.NoError(t, bRsp.Err)
 	require.Equal(t, txn.TxIDHex(), bRsp.Txid)
 
+	// Another txn with the same idempotency key is refused
+	bRsp = s.BroadcastTransaction(txn2, key)
+	require.NotNil(t, bRsp)
+	require.Equal(t, DuplicateSendErr{
+		Key:       key,
+		Txid:      txn2.TxIDHex(),
+		SavedTxid: txn.TxIDHex(),
+	}, bRsp.Err)
+
+	seen, err = s.AddressSeen(addr)
+	require.NoError(t, err)
+	require.True(t, seen)
+
+	_, err = s.GetTxOutput(txn2.TxIDHex(), addr)
+	require.Error(t, err)
+
+	sent, err := s.GetTxOutput(txn.TxIDHex(), addr)
+	require.NoError(t, err)
+	require.Equal(t, coins, sent)
+
+	sent, err = s.GetTxOutput(txn.TxIDHex(), "t5apgjk4LvV9PQareTPzWkE88o1G5A55FW")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), sent)
+
 	// Broadcasting twice causes an error
-	bRsp = s.BroadcastTransaction(txn)
+	bRsp = s.BroadcastTransaction(txn, key)
 	require.NotNil(t, bRsp)
 	require.Error(t, bRsp.Err)
 	require.Empty(t, bRsp.Txid)
@@ -52,3 +88,57 @@ func TestDummySender(t *testing.T) {
 	require.NoError(t, cRsp.Err)
 	require.True(t, cRsp.Confirmed)
 }
+
+func TestDummySenderNodeDown(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	s := NewDummySender(log, dummyKeys{})
+
+	addr := "2VZu3rZozQ6nN37YSdj3EZJV7wSFVuLSm2X"
+	txn, err := s.CreateTransaction(addr, 100)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	s.BindHandlers(mux)
+
+	node := func(method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/dummy/sender/node", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	w := node(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"down":false,"down_since":0}`, w.Body.String())
+
+	w = node(http.MethodPost, "down=maybe")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = node(http.MethodPost, "down=true")
+	require.Equal(t, http.StatusOK, w.Code)
+	var rsp dummyNodeResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	require.True(t, rsp.Down)
+	require.Equal(t, s.DownSince().Unix(), rsp.DownSince)
+
+	_, err = s.CreateTransaction(addr, 100)
+	require.Equal(t, RPCError{errDummyNodeDown}, err)
+	require.Equal(t, RPCError{errDummyNodeDown}, s.BroadcastTransaction(txn, "").Err)
+	require.Equal(t, RPCError{errDummyNodeDown}, s.IsTxConfirmed(txn.TxIDHex()).Err)
+	_, err = s.AddressSeen(addr)
+	require.Equal(t, RPCError{errDummyNodeDown}, err)
+
+	// Switching to a fallback node brings it up
+	require.NoError(t, s.SwitchNode("127.0.0.1:6430"))
+	require.True(t, s.DownSince().IsZero())
+
+	bRsp := s.BroadcastTransaction(txn, "")
+	require.NoError(t, bRsp.Err)
+
+	node(http.MethodPost, "down=true")
+	w = node(http.MethodPost, "down=false")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"down":false,"down_since":0}`, w.Body.String())
+	require.NoError(t, s.IsTxConfirmed(txn.TxIDHex()).Err)
+}
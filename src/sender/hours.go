@@ -0,0 +1,284 @@
+package sender
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+)
+
+// Coin hour tracking.
+// A skycoin transaction burns part of the coin hours of the outputs it spends as its fee, and the node rejects
+// a transaction whose inputs have no hours. A hot wallet which pays out often can spend its hours faster than
+// its coins earn them, and its payouts then fail into retries until hours accrued again.
+//
+// The HoursTracker reads the hours of the wallet's spendable outputs every CheckInterval, and predicts the hours
+// needed by the queued sends at HoursPerSend each. A send waits while the wallet can't pay for it, instead of failing,
+// and teller logs an error with alert=coin_hours_low while the wallet can't pay for all the queued sends, with
+// the estimated time the hours are recovered. An output earns an hour per whole coin per hour, so the estimate is
+// the missing hours divided by the wallet's whole coins. The hours of the unconfirmed change of the previous sends
+// are counted towards the recovery, as they become spendable once the sends are confirmed.
+
+const (
+	defaultHoursCheckInterval = time.Minute
+)
+
+// HoursConfig configures the HoursTracker
+type HoursConfig struct {
+	// CheckInterval is how often the wallet's hours are read
+	CheckInterval time.Duration
+	// HoursPerSend is the hours predicted to be spent by a send, including its fee.
+	// It has no default: the fee is a share of the hours of the outputs a send spends, which depend on the wallet.
+	HoursPerSend uint64
+}
+
+// HoursBalance is the coin hours of the hot wallet
+type HoursBalance struct {
+	// Coins of the spendable and incoming outputs, in droplets
+	Coins uint64
+	// Hours of the spendable outputs
+	Hours uint64
+	// IncomingHours of the outputs created by unconfirmed transactions
+	IncomingHours uint64
+}
+
+// HoursReader reads the coin hours of the hot wallet
+type HoursReader interface {
+	CoinHours() (HoursBalance, error)
+}
+
+// HoursStatus is the coin hours of the hot wallet, and the hours needed by the queued sends
+type HoursStatus struct {
+	Coins         string `json:"coins"`
+	Hours         uint64 `json:"hours"`
+	IncomingHours uint64 `json:"incoming_hours"`
+	// AccrualRate is the hours earned per hour
+	AccrualRate uint64 `json:"accrual_rate"`
+	// Queued is the sends waiting to be created
+	Queued int `json:"queued"`
+	// Required is the hours predicted to be spent by the queued sends
+	Required uint64 `json:"required"`
+	// Sufficient is true if the wallet has the required hours
+	Sufficient bool `json:"sufficient"`
+	// Waiting is true while a send waits for hours
+	Waiting bool `json:"waiting"`
+	// RecoveryAt is when the wallet is estimated to have the required hours, nil if it has them or earns none
+	RecoveryAt *time.Time `json:"recovery_at"`
+	CheckedAt  *time.Time `json:"checked_at"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// HoursTracker tracks the coin hours of the hot wallet, and delays the sends it can't pay for
+type HoursTracker struct {
+	log    logrus.FieldLogger
+	cfg    HoursConfig
+	wallet HoursReader
+
+	mu      sync.Mutex
+	balance HoursBalance
+	// spent is the hours predicted to be spent by the sends since the last check
+	spent     uint64
+	queued    int
+	waiting   bool
+	alerted   bool
+	checkedAt time.Time
+	err       error
+	// checkedC is closed after each check, to wake the waiting sends
+	checkedC chan struct{}
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// NewHoursTracker creates a HoursTracker of the wallet's coin hours
+func NewHoursTracker(log logrus.FieldLogger, cfg HoursConfig, wallet HoursReader) *HoursTracker {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultHoursCheckInterval
+	}
+
+	return &HoursTracker{
+		log:      log.WithField("prefix", "sender.hours"),
+		cfg:      cfg,
+		wallet:   wallet,
+		checkedC: make(chan struct{}),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run checks the wallet's hours at startup, then every CheckInterval until Shutdown is called
+func (t *HoursTracker) Run() error {
+	log := t.log.WithField("config", t.cfg)
+	log.Info("Start coin hour tracker")
+	defer log.Info("Coin hour tracker closed")
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		t.Check(time.Now())
+
+		select {
+		case <-t.quit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the HoursTracker
+func (t *HoursTracker) Shutdown() {
+	close(t.quit)
+	<-t.done
+}
+
+// Check reads the wallet's hours at now, alerts if they don't cover the queued sends and wakes the waiting sends
+func (t *HoursTracker) Check(now time.Time) {
+	b, err := t.wallet.CoinHours()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.checkedAt = now
+	if err != nil {
+		t.err = err
+		t.log.WithError(err).Error("Read coin hours failed")
+		return
+	}
+
+	t.err = nil
+	t.balance = b
+	t.spent = 0
+
+	s := t.status()
+	log := t.log.WithFields(logrus.Fields{
+		"hours":         s.Hours,
+		"incomingHours": s.IncomingHours,
+		"required":      s.Required,
+		"queued":        s.Queued,
+		"accrualRate":   s.AccrualRate,
+	})
+	if s.RecoveryAt != nil {
+		log = log.WithField("recoveryAt", *s.RecoveryAt)
+	}
+
+	switch {
+	case !s.Sufficient && !t.alerted:
+		log.WithField("alert", "coin_hours_low").Error("ALERT: hot wallet lacks the coin hours of the queued sends, delaying the sends")
+		t.alerted = true
+	case s.Sufficient && t.alerted:
+		log.Info("Hot wallet has the coin hours of the queued sends again")
+		t.alerted = false
+	}
+
+	close(t.checkedC)
+	t.checkedC = make(chan struct{})
+}
+
+// Wait waits until the wallet can pay for a send, queued is the number of sends waiting including this one.
+// A send doesn't wait while the wallet's hours are unknown, it fails as usual if they are short.
+// It returns false if quit is closed while waiting.
+func (t *HoursTracker) Wait(queued int, quit <-chan struct{}) bool {
+	logged := false
+
+	for {
+		t.mu.Lock()
+		t.queued = queued
+		if t.checkedAt.IsZero() || t.err != nil || t.available() >= t.cfg.HoursPerSend {
+			t.spent += t.cfg.HoursPerSend
+			t.queued = queued - 1
+			t.waiting = false
+			t.mu.Unlock()
+			return true
+		}
+
+		if !logged {
+			t.log.WithFields(logrus.Fields{
+				"hours":    t.available(),
+				"required": t.cfg.HoursPerSend,
+			}).Warn("Hot wallet lacks the coin hours of a send, waiting for them")
+			logged = true
+		}
+
+		t.waiting = true
+		checkedC := t.checkedC
+		t.mu.Unlock()
+
+		select {
+		case <-checkedC:
+		case <-quit:
+			t.mu.Lock()
+			t.waiting = false
+			t.mu.Unlock()
+			return false
+		}
+	}
+}
+
+// Status returns the wallet's hours at the last check, and the hours needed by the queued sends
+func (t *HoursTracker) Status() HoursStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status()
+}
+
+// available returns the spendable hours left after the sends since the last check
+func (t *HoursTracker) available() uint64 {
+	if t.spent >= t.balance.Hours {
+		return 0
+	}
+	return t.balance.Hours - t.spent
+}
+
+func (t *HoursTracker) status() HoursStatus {
+	coins, err := droplet.ToString(t.balance.Coins)
+	if err != nil {
+		coins = ""
+	}
+
+	s := HoursStatus{
+		Coins:         coins,
+		Hours:         t.available(),
+		IncomingHours: t.balance.IncomingHours,
+		AccrualRate:   t.balance.Coins / droplet.Multiplier,
+		Queued:        t.queued,
+		Required:      uint64(t.queued) * t.cfg.HoursPerSend,
+		Waiting:       t.waiting,
+	}
+	s.Sufficient = s.Hours >= s.Required
+
+	if t.err != nil {
+		s.Error = t.err.Error()
+	}
+
+	if t.checkedAt.IsZero() {
+		return s
+	}
+
+	checkedAt := t.checkedAt
+	s.CheckedAt = &checkedAt
+
+	if s.Sufficient {
+		return s
+	}
+
+	// The unconfirmed change is spendable once confirmed
+	var missing uint64
+	if have := s.Hours + s.IncomingHours; have < s.Required {
+		missing = s.Required - have
+	}
+
+	switch {
+	case missing == 0:
+		s.RecoveryAt = &checkedAt
+	case s.AccrualRate != 0:
+		hours := (missing + s.AccrualRate - 1) / s.AccrualRate
+		recoveryAt := checkedAt.Add(time.Duration(hours) * time.Hour)
+		s.RecoveryAt = &recoveryAt
+	}
+
+	return s
+}
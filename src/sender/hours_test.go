@@ -0,0 +1,178 @@
+package sender
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type dummyHoursReader struct {
+	sync.Mutex
+	balance HoursBalance
+	err     error
+}
+
+func (r *dummyHoursReader) CoinHours() (HoursBalance, error) {
+	r.Lock()
+	defer r.Unlock()
+	return r.balance, r.err
+}
+
+func (r *dummyHoursReader) set(b HoursBalance, err error) {
+	r.Lock()
+	defer r.Unlock()
+	r.balance = b
+	r.err = err
+}
+
+func TestHoursTrackerStatus(t *testing.T) {
+	log, hook := testutil.NewLogger(t)
+	wallet := &dummyHoursReader{}
+	tr := NewHoursTracker(log, HoursConfig{HoursPerSend: 10}, wallet)
+
+	// Nothing is known before the first check
+	s := tr.Status()
+	require.Nil(t, s.CheckedAt)
+	require.Nil(t, s.RecoveryAt)
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	wallet.set(HoursBalance{
+		Coins:         100e6,
+		Hours:         25,
+		IncomingHours: 5,
+	}, nil)
+	tr.Check(now)
+
+	s = tr.Status()
+	require.Equal(t, HoursStatus{
+		Coins:         "100.000000",
+		Hours:         25,
+		IncomingHours: 5,
+		AccrualRate:   100,
+		Sufficient:    true,
+		CheckedAt:     &now,
+	}, s)
+
+	// Two sends predicted to spend 20 hours leave 5
+	quit := make(chan struct{})
+	require.True(t, tr.Wait(5, quit))
+	require.True(t, tr.Wait(4, quit))
+	s = tr.Status()
+	require.Equal(t, uint64(5), s.Hours)
+	require.Equal(t, 3, s.Queued)
+	require.Equal(t, uint64(30), s.Required)
+	require.False(t, s.Sufficient)
+
+	// At the next check, 200 hours are missing for 21 queued sends, which 100 coins earn in 2 hours
+	wallet.set(HoursBalance{
+		Coins:         100e6,
+		Hours:         5,
+		IncomingHours: 5,
+	}, nil)
+	tr.mu.Lock()
+	tr.queued = 21
+	tr.mu.Unlock()
+	tr.Check(now)
+	s = tr.Status()
+	require.False(t, s.Sufficient)
+	require.Equal(t, uint64(210), s.Required)
+	require.Equal(t, now.Add(time.Hour*2), *s.RecoveryAt)
+	require.Equal(t, "coin_hours_low", hook.LastEntry().Data["alert"])
+
+	// The unconfirmed change covers the missing hours once it is confirmed
+	tr.mu.Lock()
+	tr.queued = 1
+	tr.mu.Unlock()
+	tr.Check(now)
+	s = tr.Status()
+	require.False(t, s.Sufficient)
+	require.Equal(t, now, *s.RecoveryAt)
+
+	// A wallet without coins never recovers
+	wallet.set(HoursBalance{}, nil)
+	tr.Check(now)
+	s = tr.Status()
+	require.False(t, s.Sufficient)
+	require.Nil(t, s.RecoveryAt)
+
+	// A failed check keeps the last balance
+	wallet.set(HoursBalance{Hours: 100}, errors.New("node down"))
+	tr.Check(now)
+	s = tr.Status()
+	require.Equal(t, uint64(0), s.Hours)
+	require.Equal(t, "node down", s.Error)
+
+	wallet.set(HoursBalance{Hours: 100}, nil)
+	tr.Check(now)
+	s = tr.Status()
+	require.True(t, s.Sufficient)
+	require.Empty(t, s.Error)
+	require.Equal(t, "Hot wallet has the coin hours of the queued sends again", hook.LastEntry().Message)
+}
+
+func TestHoursTrackerWait(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	wallet := &dummyHoursReader{}
+	tr := NewHoursTracker(log, HoursConfig{HoursPerSend: 1}, wallet)
+	quit := make(chan struct{})
+
+	// A send doesn't wait while the hours are unknown
+	require.True(t, tr.Wait(1, quit))
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	tr.Check(now)
+
+	// The send waits until a check finds hours
+	waitC := make(chan bool, 1)
+	go func() {
+		waitC <- tr.Wait(1, quit)
+	}()
+
+	for i := 0; i < 100 && !tr.Status().Waiting; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.True(t, tr.Status().Waiting)
+
+	tr.Check(now)
+	select {
+	case <-waitC:
+		t.Fatal("Wait returned without hours")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	wallet.set(HoursBalance{Coins: 1e6, Hours: 1}, nil)
+	tr.Check(now)
+	require.True(t, <-waitC)
+	require.False(t, tr.Status().Waiting)
+
+	// The hour is predicted to be spent, so the next send waits until quit is closed
+	go func() {
+		waitC <- tr.Wait(1, quit)
+	}()
+	close(quit)
+	require.False(t, <-waitC)
+}
+
+func TestHoursTrackerRunShutdown(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	tr := NewHoursTracker(log, HoursConfig{CheckInterval: time.Millisecond * 10}, &dummyHoursReader{})
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- tr.Run()
+	}()
+
+	// The hours are checked at startup
+	for i := 0; i < 100 && tr.Status().CheckedAt == nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.NotNil(t, tr.Status().CheckedAt)
+
+	tr.Shutdown()
+	require.NoError(t, <-errC)
+}
@@ -0,0 +1,61 @@
+package sender
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Idempotency keys.
+// The skycoin transaction paying a deposit is created with the deposit's idempotency key,
+// which the exchange saves with the transaction's txid before it is broadcast.
+// As the key is derived from the deposit's txid:vout, a deposit gets the same key however often
+// it is sent, so before broadcasting a transaction the sender looks up its key, and refuses it if
+// another transaction was saved with the key. A deposit can't be paid twice this way, even if teller
+// restarts mid-send and its status is rolled back to resend it.
+
+// IdempotencyKey returns the idempotency key of the transaction paying a deposit,
+// the hex SHA256 of the deposit's "txid:vout" ID
+func IdempotencyKey(depositID string) string {
+	h := sha256.Sum256([]byte(depositID))
+	return hex.EncodeToString(h[:])
+}
+
+// KeyStore looks up the txid of the transaction saved with an idempotency key
+type KeyStore interface {
+	GetSendKey(key string) (string, bool, error)
+}
+
+// DuplicateSendErr is returned when a transaction's idempotency key was saved with another transaction
+type DuplicateSendErr struct {
+	Key       string
+	Txid      string
+	SavedTxid string
+}
+
+func (e DuplicateSendErr) Error() string {
+	return fmt.Sprintf("Idempotency key %s was already used by skycoin transaction %s, refusing transaction %s", e.Key, e.SavedTxid, e.Txid)
+}
+
+// checkSendKey returns a DuplicateSendErr if key was saved with a transaction other than txid.
+// A request without a key, or a sender without a KeyStore, isn't checked.
+func checkSendKey(keys KeyStore, key, txid string) error {
+	if keys == nil || key == "" {
+		return nil
+	}
+
+	savedTxid, ok, err := keys.GetSendKey(key)
+	if err != nil {
+		return err
+	}
+
+	if ok && savedTxid != txid {
+		return DuplicateSendErr{
+			Key:       key,
+			Txid:      txid,
+			SavedTxid: savedTxid,
+		}
+	}
+
+	return nil
+}
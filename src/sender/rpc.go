@@ -2,12 +2,23 @@ package sender
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 
 	"github.com/skycoin/skycoin/src/api/cli"
 	"github.com/skycoin/skycoin/src/api/webrpc"
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/coin"
+	"github.com/skycoin/skycoin/src/util/droplet"
 	"github.com/skycoin/skycoin/src/wallet"
+
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/connutil"
 )
 
 // RPCError wraps errors from the skycoin CLI/RPC library
@@ -15,15 +26,40 @@ type RPCError struct {
 	error
 }
 
-// RPC provides methods for sending coins
+// NewRPCError wraps err in an RPCError, for a Sender whose node failed the request
+func NewRPCError(err error) RPCError {
+	return RPCError{err}
+}
+
+// Chain is the fiber chain which RPC sends coins on
+type Chain struct {
+	// Name of the chain, e.g. "skycoin"
+	Name string
+	// GenesisHash is the hex encoded hash of the chain's genesis block.
+	// If set, NewRPC checks that the node is on the chain.
+	GenesisHash string
+}
+
+// RPC provides methods for sending coins.
+// The webrpc client's requests keep their connections to the node alive on http.DefaultTransport, so a node which
+// failed over to another address would be called at its old address until those connections break. RPC times out
+// the requests, and after a request fails to connect or times out, fails the requests until a backoff elapsed.
+// It then resolves the node's host again, checks that the node accepts connections and closes the idle
+// connections, so that the next request connects to the node's current address.
+// SwitchNode moves to another node, e.g. a fallback node.
 type RPC struct {
+	log        logrus.FieldLogger
 	walletFile string
 	changeAddr string
-	rpcClient  *webrpc.Client
+	chain      Chain
+
+	mu      sync.Mutex
+	rpcAddr string
+	r       *connutil.Reconnector
 }
 
-// NewRPC creates RPC instance
-func NewRPC(wltFile, rpcAddr string) (*RPC, error) {
+// NewRPC creates RPC instance, which sends the coin of chain from the wallet wltFile with the node at rpcAddr
+func NewRPC(log logrus.FieldLogger, wltFile, rpcAddr string, chain Chain, reconnect connutil.Config) (*RPC, error) {
 	wlt, err := wallet.Load(wltFile)
 	if err != nil {
 		return nil, err
@@ -37,13 +73,151 @@ func NewRPC(wltFile, rpcAddr string) (*RPC, error) {
 		Addr: rpcAddr,
 	}
 
+	if chain.GenesisHash != "" {
+		if err := checkGenesisHash(rpcClient, chain); err != nil {
+			return nil, err
+		}
+	}
+
 	return &RPC{
+		log:        log,
 		walletFile: wltFile,
 		changeAddr: wlt.Entries[0].Address.String(),
-		rpcClient:  rpcClient,
+		chain:      chain,
+		rpcAddr:    rpcAddr,
+		r:          newReconnector(log, rpcAddr, reconnect),
 	}, nil
 }
 
+func newReconnector(log logrus.FieldLogger, rpcAddr string, reconnect connutil.Config) *connutil.Reconnector {
+	log = log.WithFields(logrus.Fields{
+		"prefix": "sender.rpc",
+		"host":   rpcAddr,
+	})
+	return connutil.NewReconnector(log, reconnect, "skycoin_node_unreachable", clock.Real{})
+}
+
+// node returns the node's address and its Reconnector
+func (c *RPC) node() (string, *connutil.Reconnector) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rpcAddr, c.r
+}
+
+// Node returns the address of the node
+func (c *RPC) Node() string {
+	rpcAddr, _ := c.node()
+	return rpcAddr
+}
+
+// DownSince returns when the node's requests began to fail, zero if the last request succeeded
+func (c *RPC) DownSince() time.Time {
+	_, r := c.node()
+	return r.DownSince()
+}
+
+// SwitchNode sends with the node at rpcAddr from now on, e.g. a fallback node while the node is down.
+// If the chain's genesis hash is set, the node must be on the chain. The requests in progress finish with the previous node.
+func (c *RPC) SwitchNode(rpcAddr string) error {
+	_, r := c.node()
+
+	if c.chain.GenesisHash != "" {
+		if _, err := connutil.WithTimeout("Skycoin node request", r.Config().RequestTimeout, func() (interface{}, error) {
+			return nil, checkGenesisHash(&webrpc.Client{
+				Addr: rpcAddr,
+			}, c.chain)
+		}); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	previous := c.rpcAddr
+	c.rpcAddr = rpcAddr
+	c.r = newReconnector(c.log, rpcAddr, r.Config())
+	c.mu.Unlock()
+
+	http.DefaultClient.CloseIdleConnections()
+
+	c.log.WithFields(logrus.Fields{
+		"previous": previous,
+		"host":     rpcAddr,
+	}).Warn("Switched to another skycoin node")
+
+	return nil
+}
+
+// call makes a request to the node within the request timeout, reconnecting first if the last request failed.
+// Each request has its own webrpc client, as a request which timed out may still be running.
+func (c *RPC) call(f func(*webrpc.Client) (interface{}, error)) (interface{}, error) {
+	rpcAddr, r := c.node()
+
+	if r.Failures() != 0 {
+		if err := r.Ready(); err != nil {
+			return nil, err
+		}
+		if err := reconnect(rpcAddr, r); err != nil {
+			r.Failed(err)
+			return nil, err
+		}
+	}
+
+	rpcClient := &webrpc.Client{
+		Addr: rpcAddr,
+	}
+
+	v, err := connutil.WithTimeout("Skycoin node request", r.Config().RequestTimeout, func() (interface{}, error) {
+		return f(rpcClient)
+	})
+	if _, ok := err.(net.Error); ok {
+		r.Failed(err)
+		return nil, err
+	}
+
+	r.Succeeded()
+
+	return v, err
+}
+
+// reconnect resolves the node's host and connects to it within the connect timeout,
+// and closes the idle connections to the node's previous address
+func reconnect(rpcAddr string, r *connutil.Reconnector) error {
+	timeout := r.Config().ConnectTimeout
+
+	if _, err := r.Resolve(rpcAddr); err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", rpcAddr, timeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+
+	http.DefaultClient.CloseIdleConnections()
+
+	return nil
+}
+
+// checkGenesisHash returns an error if the node isn't on chain, so that a wallet of
+// another fiber chain is never spent by mistake
+func checkGenesisHash(rpcClient *webrpc.Client, chain Chain) error {
+	blocks, err := rpcClient.GetBlocksBySeq([]uint64{0})
+	if err != nil {
+		return RPCError{err}
+	}
+
+	if len(blocks.Blocks) == 0 {
+		return fmt.Errorf("%s node has no genesis block", chain.Name)
+	}
+
+	if hash := blocks.Blocks[0].Head.BlockHash; hash != chain.GenesisHash {
+		return fmt.Errorf("Node is not on the %s chain, its genesis block is %s, not %s", chain.Name, hash, chain.GenesisHash)
+	}
+
+	return nil
+}
+
 // CreateTransaction creates a raw Skycoin transaction offline, that can be broadcast later
 func (c *RPC) CreateTransaction(recvAddr string, amount uint64) (*coin.Transaction, error) {
 	// TODO -- this can support sending to multiple receivers at once,
@@ -57,32 +231,130 @@ func (c *RPC) CreateTransaction(recvAddr string, amount uint64) (*coin.Transacti
 		return nil, err
 	}
 
-	txn, err := cli.CreateRawTxFromWallet(c.rpcClient, c.walletFile, c.changeAddr, []cli.SendAmount{sendAmount})
+	v, err := c.call(func(rpcClient *webrpc.Client) (interface{}, error) {
+		return cli.CreateRawTxFromWallet(rpcClient, c.walletFile, c.changeAddr, []cli.SendAmount{sendAmount})
+	})
 	if err != nil {
 		return nil, RPCError{err}
 	}
 
-	return txn, nil
+	return v.(*coin.Transaction), nil
 }
 
 // BroadcastTransaction broadcasts a transaction and returns its txid
 func (c *RPC) BroadcastTransaction(tx *coin.Transaction) (string, error) {
-	txid, err := c.rpcClient.InjectTransaction(tx)
+	v, err := c.call(func(rpcClient *webrpc.Client) (interface{}, error) {
+		return rpcClient.InjectTransaction(tx)
+	})
 	if err != nil {
 		return "", RPCError{err}
 	}
 
-	return txid, nil
+	return v.(string), nil
 }
 
 // GetTransaction returns transaction by txid
 func (c *RPC) GetTransaction(txid string) (*webrpc.TxnResult, error) {
-	txn, err := c.rpcClient.GetTransactionByID(txid)
+	v, err := c.call(func(rpcClient *webrpc.Client) (interface{}, error) {
+		return rpcClient.GetTransactionByID(txid)
+	})
 	if err != nil {
 		return nil, RPCError{err}
 	}
 
-	return txn, nil
+	return v.(*webrpc.TxnResult), nil
+}
+
+// AddressSeen returns true if the address has ever received an output
+func (c *RPC) AddressSeen(addr string) (bool, error) {
+	v, err := c.call(func(rpcClient *webrpc.Client) (interface{}, error) {
+		return rpcClient.GetAddressUxOuts([]string{addr})
+	})
+	if err != nil {
+		return false, RPCError{err}
+	}
+
+	for _, u := range v.([]webrpc.AddrUxoutResult) {
+		if len(u.UxOuts) != 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// GetTxOutput returns the droplets sent to an address by a transaction
+func (c *RPC) GetTxOutput(txid, addr string) (uint64, error) {
+	txn, err := c.GetTransaction(txid)
+	if err != nil {
+		return 0, err
+	}
+
+	if txn.Transaction == nil {
+		return 0, fmt.Errorf("Transaction %s not found", txid)
+	}
+
+	var coins uint64
+	for _, o := range txn.Transaction.Transaction.Out {
+		if o.Address != addr {
+			continue
+		}
+
+		amt, err := droplet.FromString(o.Coins)
+		if err != nil {
+			return 0, err
+		}
+
+		coins += amt
+	}
+
+	return coins, nil
+}
+
+// CoinHours returns the coin hours of the wallet's unspent outputs
+func (c *RPC) CoinHours() (HoursBalance, error) {
+	wlt, err := wallet.Load(c.walletFile)
+	if err != nil {
+		return HoursBalance{}, err
+	}
+
+	addrs := wlt.GetAddresses()
+	addrStrs := make([]string, len(addrs))
+	for i, a := range addrs {
+		addrStrs[i] = a.String()
+	}
+
+	v, err := c.call(func(rpcClient *webrpc.Client) (interface{}, error) {
+		return rpcClient.GetUnspentOutputs(addrStrs)
+	})
+	if err != nil {
+		return HoursBalance{}, RPCError{err}
+	}
+
+	outputs := v.(*webrpc.OutputsResult).Outputs
+
+	var b HoursBalance
+	for _, o := range outputs.SpendableOutputs() {
+		coins, err := droplet.FromString(o.Coins)
+		if err != nil {
+			return HoursBalance{}, err
+		}
+
+		b.Coins += coins
+		b.Hours += o.Hours
+	}
+
+	for _, o := range outputs.IncomingOutputs {
+		coins, err := droplet.FromString(o.Coins)
+		if err != nil {
+			return HoursBalance{}, err
+		}
+
+		b.Coins += coins
+		b.IncomingHours += o.Hours
+	}
+
+	return b, nil
 }
 
 func validateSendAmount(amt cli.SendAmount) error {
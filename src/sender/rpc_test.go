@@ -0,0 +1,121 @@
+package sender
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/connutil"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestRPCReconnect(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	// A node which answers get_address_uxouts with one output, or hangs the requests
+	var hang, calls int32
+	done := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&hang) == 1 {
+			<-done
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":"1","result":[{"address":"addr","uxouts":[{"uxid":"a"}]}]}`)) // nolint: errcheck
+	}))
+	defer srv.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	c := &RPC{
+		rpcAddr: addr,
+		r: newReconnector(log, addr, connutil.Config{
+			ConnectTimeout: time.Second,
+			RequestTimeout: time.Millisecond * 100,
+			MinBackoff:     time.Millisecond * 100,
+		}),
+	}
+
+	seen, err := c.AddressSeen("addr")
+	require.NoError(t, err)
+	require.True(t, seen)
+
+	// A request which hangs times out, then the requests fail until the backoff elapsed
+	atomic.StoreInt32(&hang, 1)
+	_, err = c.AddressSeen("addr")
+	require.EqualError(t, err, "Skycoin node request timed out after 100ms")
+	require.IsType(t, RPCError{}, err)
+	require.Equal(t, 1, c.r.Failures())
+
+	atomic.StoreInt32(&hang, 0)
+	_, err = c.AddressSeen("addr")
+	require.Error(t, err)
+	_, ok := err.(RPCError).error.(connutil.BackoffErr)
+	require.True(t, ok)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// After the backoff, the node is reconnected to
+	time.Sleep(time.Millisecond * 100)
+	seen, err = c.AddressSeen("addr")
+	require.NoError(t, err)
+	require.True(t, seen)
+	require.Equal(t, 0, c.r.Failures())
+
+	// A node which refuses the connections fails the reconnection
+	close(done)
+	srv.Close()
+	c.r.Failed(errors.New("connection reset"))
+	time.Sleep(time.Millisecond * 100)
+	_, err = c.AddressSeen("addr")
+	require.Error(t, err)
+	require.Equal(t, 2, c.r.Failures())
+}
+
+func TestRPCSwitchNode(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	var calls [2]int32
+	node := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls[i], 1)
+			w.Write([]byte(`{"jsonrpc":"2.0","id":"1","result":[{"address":"addr","uxouts":[]}]}`)) // nolint: errcheck
+		}))
+	}
+	srv := node(0)
+	defer srv.Close()
+	fallback := node(1)
+	defer fallback.Close()
+
+	addr := strings.TrimPrefix(srv.URL, "http://")
+	c := &RPC{
+		log:     log,
+		rpcAddr: addr,
+		r: newReconnector(log, addr, connutil.Config{
+			MinBackoff: time.Minute,
+		}),
+	}
+
+	// The node is down since its first failure
+	c.r.Failed(errors.New("connection reset"))
+	require.False(t, c.DownSince().IsZero())
+
+	fallbackAddr := strings.TrimPrefix(fallback.URL, "http://")
+	require.NoError(t, c.SwitchNode(fallbackAddr))
+	require.Equal(t, fallbackAddr, c.Node())
+
+	// The fallback node doesn't inherit the node's failures and backoff
+	require.True(t, c.DownSince().IsZero())
+	require.Equal(t, time.Minute, c.r.Config().MinBackoff)
+
+	seen, err := c.AddressSeen("addr")
+	require.NoError(t, err)
+	require.False(t, seen)
+	require.Equal(t, int32(0), atomic.LoadInt32(&calls[0]))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls[1]))
+}
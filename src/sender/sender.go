@@ -13,10 +13,26 @@ var (
 	ErrClosed = errors.New("Send service closed")
 )
 
+// IsPermanent returns true if err is a refusal which would be returned on every retry of the request:
+// a request which fails its Verify, or a transaction whose idempotency key was used by another transaction.
+// The caller must not retry such a request.
+func IsPermanent(err error) bool {
+	if _, ok := err.(DuplicateSendErr); ok {
+		return true
+	}
+
+	switch err {
+	case ErrTxEmpty, ErrTxidEmpty:
+		return true
+	default:
+		return false
+	}
+}
+
 // Sender provids apis for sending skycoin
 type Sender interface {
 	CreateTransaction(string, uint64) (*coin.Transaction, error)
-	BroadcastTransaction(*coin.Transaction) *BroadcastTxResponse
+	BroadcastTransaction(tx *coin.Transaction, key string) *BroadcastTxResponse
 	IsTxConfirmed(string) *ConfirmResponse
 }
 
@@ -38,13 +54,15 @@ func (s *RetrySender) CreateTransaction(recvAddr string, coins uint64) (*coin.Tr
 	return s.s.SkyClient.CreateTransaction(recvAddr, coins)
 }
 
-// BroadcastTransaction sends a transaction in a goroutine
-func (s *RetrySender) BroadcastTransaction(tx *coin.Transaction) *BroadcastTxResponse {
+// BroadcastTransaction sends a transaction in a goroutine.
+// key is the transaction's idempotency key, or empty to broadcast it unchecked.
+func (s *RetrySender) BroadcastTransaction(tx *coin.Transaction, key string) *BroadcastTxResponse {
 	rspC := make(chan *BroadcastTxResponse, 1)
 
 	go func() {
 		s.s.broadcastTxChan <- BroadcastTxRequest{
 			Tx:   tx,
+			Key:  key,
 			RspC: rspC,
 		}
 	}()
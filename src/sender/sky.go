@@ -17,16 +17,24 @@ const (
 	confirmTxRetryWait   = 3 * time.Second
 )
 
+var (
+	// ErrTxEmpty is returned by BroadcastTxRequest.Verify if the request has no transaction
+	ErrTxEmpty = errors.New("Tx empty")
+	// ErrTxidEmpty is returned by ConfirmRequest.Verify if the request has no txid
+	ErrTxidEmpty = errors.New("Txid empty")
+)
+
 // BroadcastTxRequest send coin request struct
 type BroadcastTxRequest struct {
 	Tx   *coin.Transaction
+	Key  string                    // idempotency key, see idempotency.go
 	RspC chan *BroadcastTxResponse // response
 }
 
 // Verify verifies the request parameters
 func (r BroadcastTxRequest) Verify() error {
 	if r.Tx == nil {
-		return errors.New("Tx empty")
+		return ErrTxEmpty
 	}
 
 	return nil
@@ -48,7 +56,7 @@ type ConfirmRequest struct {
 // Verify verifies the request parameters
 func (r ConfirmRequest) Verify() error {
 	if r.Txid == "" {
-		return errors.New("Txid empty")
+		return ErrTxidEmpty
 	}
 
 	return nil
@@ -65,6 +73,7 @@ type ConfirmResponse struct {
 type SendService struct {
 	log             logrus.FieldLogger
 	SkyClient       SkyClient
+	keys            KeyStore
 	quit            chan struct{}
 	done            chan struct{}
 	broadcastTxChan chan BroadcastTxRequest
@@ -78,10 +87,11 @@ type SkyClient interface {
 	GetTransaction(string) (*webrpc.TxnResult, error)
 }
 
-// NewService creates sender instance
-func NewService(log logrus.FieldLogger, skycli SkyClient) *SendService {
+// NewService creates sender instance. The broadcasts' idempotency keys are looked up in keys, if not nil.
+func NewService(log logrus.FieldLogger, skycli SkyClient, keys KeyStore) *SendService {
 	return &SendService{
 		SkyClient:       skycli,
+		keys:            keys,
 		log:             log.WithField("prefix", "sender.service"),
 		quit:            make(chan struct{}),
 		done:            make(chan struct{}),
@@ -203,6 +213,11 @@ func (s *SendService) BroadcastTx(req BroadcastTxRequest) (*BroadcastTxResponse,
 		return nil, err
 	}
 
+	if err := checkSendKey(s.keys, req.Key, req.Tx.TxIDHex()); err != nil {
+		log.WithError(err).Error("checkSendKey failed")
+		return nil, err
+	}
+
 	txid, err := s.SkyClient.BroadcastTransaction(req.Tx)
 	if err != nil {
 		log.WithError(err).Error("SkyClient.BroadcastTransaction failed")
@@ -225,6 +240,12 @@ func (s *SendService) BroadcastTxRetry(req BroadcastTxRequest) (*BroadcastTxResp
 		return nil, err
 	}
 
+	// The key is checked once, a transaction refused for it would be refused on every retry, see IsPermanent
+	if err := checkSendKey(s.keys, req.Key, req.Tx.TxIDHex()); err != nil {
+		log.WithError(err).Error("checkSendKey failed")
+		return nil, err
+	}
+
 	// This loop tries to send the coins until it succeeds.
 	// TODO: if this gets stuck, nothing will proceed.
 	// Add logic to give up sending after some number of retries if necessary
@@ -26,6 +26,14 @@ type dummySkycli struct {
 	getTxErr        error
 }
 
+// dummyKeys maps idempotency keys to txids
+type dummyKeys map[string]string
+
+func (k dummyKeys) GetSendKey(key string) (string, bool, error) {
+	txid, ok := k[key]
+	return txid, ok, nil
+}
+
 func newDummySkycli() *dummySkycli {
 	return &dummySkycli{}
 }
@@ -103,7 +111,8 @@ func TestSenderBroadcastTransaction(t *testing.T) {
 	dsc := newDummySkycli()
 
 	dsc.changeBroadcastTxTxid("1111")
-	s := NewService(log, dsc)
+	keys := dummyKeys{}
+	s := NewService(log, dsc, keys)
 	go func() {
 		s.Run()
 	}()
@@ -117,7 +126,7 @@ func TestSenderBroadcastTransaction(t *testing.T) {
 			return "", err
 		}
 
-		rsp := sdr.BroadcastTransaction(tx)
+		rsp := sdr.BroadcastTransaction(tx, "")
 		require.NotNil(t, rsp)
 
 		if rsp.Err != nil {
@@ -164,6 +173,25 @@ func TestSenderBroadcastTransaction(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, "1111", txid)
 
+	t.Log("=== Run\tTest broadcastTx with a used idempotency key")
+	tx, err := sdr.CreateTransaction(addr, 20)
+	require.NoError(t, err)
+	key := IdempotencyKey("btc-tx:1")
+	keys[key] = "2222"
+
+	rsp := sdr.BroadcastTransaction(tx, key)
+	require.NotNil(t, rsp)
+	require.Equal(t, DuplicateSendErr{
+		Key:       key,
+		Txid:      tx.TxIDHex(),
+		SavedTxid: "2222",
+	}, rsp.Err)
+
+	keys[key] = tx.TxIDHex()
+	rsp = sdr.BroadcastTransaction(tx, key)
+	require.NotNil(t, rsp)
+	require.NoError(t, rsp.Err)
+
 	t.Log("=== Run\tTest invalid request address")
 	txid, err = broadcastTx(sdr, "invalid address", 20)
 	require.Equal(t, "Invalid base58 character", err.Error())
@@ -180,6 +208,20 @@ func TestSenderBroadcastTransaction(t *testing.T) {
 	require.Empty(t, txid)
 }
 
+func TestIsPermanent(t *testing.T) {
+	require.True(t, IsPermanent(DuplicateSendErr{
+		Key:       "key",
+		Txid:      "1111",
+		SavedTxid: "2222",
+	}))
+	require.True(t, IsPermanent(BroadcastTxRequest{}.Verify()))
+	require.True(t, IsPermanent(ConfirmRequest{}.Verify()))
+
+	require.False(t, IsPermanent(nil))
+	require.False(t, IsPermanent(RPCError{errors.New("connect to node failed")}))
+	require.False(t, IsPermanent(errors.New("connect to node failed")))
+}
+
 func TestCreateTransactionVerify(t *testing.T) {
 	var testCases = []struct {
 		name       string
@@ -0,0 +1,215 @@
+package teller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// A binding is cancelled by the owner of its skycoin address, who signs the CancelBindHash
+// of the binding and the current time with the address's secret key
+
+// cancelBindMaxSkew is how far the timestamp of a cancel request can be from the current time,
+// limiting how long a signed request can be replayed
+const cancelBindMaxSkew = 10 * time.Minute
+
+// errInvalidCancelSignature is returned if the signature of a cancel request is invalid or its timestamp is too old
+var errInvalidCancelSignature = errors.New("Invalid signature")
+
+// CancelBindHash returns the hash signed by the owner of a skycoin address to cancel its binding to a deposit address.
+// timestamp is the unix time of the request.
+func CancelBindHash(skyAddr, depositAddr, coinType string, timestamp int64) cipher.SHA256 {
+	msg := strings.Join([]string{
+		"teller-cancel-bind",
+		skyAddr,
+		depositAddr,
+		coinType,
+		strconv.FormatInt(timestamp, 10),
+	}, "\n")
+	return cipher.SumSHA256([]byte(msg))
+}
+
+// verifyCancelBind checks that sig is the signature of a cancel request by the owner of skyAddr,
+// made within cancelBindMaxSkew of now
+func verifyCancelBind(skyAddr, depositAddr, coinType string, timestamp int64, sig string, now time.Time) error {
+	t := time.Unix(timestamp, 0)
+	if t.Before(now.Add(-cancelBindMaxSkew)) || t.After(now.Add(cancelBindMaxSkew)) {
+		return errInvalidCancelSignature
+	}
+
+	addr, err := cipher.DecodeBase58Address(skyAddr)
+	if err != nil {
+		return errInvalidCancelSignature
+	}
+
+	s, err := cipher.SigFromHex(sig)
+	if err != nil {
+		return errInvalidCancelSignature
+	}
+
+	if err := cipher.ChkSig(addr, CancelBindHash(skyAddr, depositAddr, coinType, timestamp), s); err != nil {
+		return errInvalidCancelSignature
+	}
+
+	return nil
+}
+
+// CancelBinding cancels the binding of a deposit address to a skycoin address, if it has received no deposits.
+// sig is the signature of the CancelBindHash by the skycoin address's secret key, and the timestamp
+// is checked against now. The deposit address is returned to the address pool if the cancel policy is config.CancelPolicyReuse.
+func (s *Service) CancelBinding(skyAddr, depositAddr, coinType string, timestamp int64, sig string, now time.Time) error {
+	if err := verifyCancelBind(skyAddr, depositAddr, coinType, timestamp, sig, now); err != nil {
+		return err
+	}
+
+	// The address pool is not changed during a handover
+	s.bindMu.RLock()
+	defer s.bindMu.RUnlock()
+
+	if s.quiesced {
+		return ErrQuiesced
+	}
+
+	if !s.bindGate.TryEnter() {
+		return ErrMaintenance
+	}
+	defer s.bindGate.Leave()
+
+	reuse := s.cfg.CancelPolicy == config.CancelPolicyReuse
+
+	if err := s.exchanger.CancelBinding(skyAddr, depositAddr, coinType, reuse); err != nil {
+		return err
+	}
+
+	s.tracker.Track(analytics.EventBindCancelled, skyAddr, analytics.Properties{
+		"coin_type": coinType,
+	})
+
+	if !reuse {
+		return nil
+	}
+
+	// The binding is cancelled, so a failure to return the address only loses it from the pool
+	if err := s.addrManager.ReturnAddress(coinType, depositAddr); err != nil {
+		s.log.WithError(err).WithFields(logrus.Fields{
+			"depositAddr": depositAddr,
+			"coinType":    coinType,
+		}).Error("addrManager.ReturnAddress failed")
+	}
+
+	return nil
+}
+
+// cancelBindRequest is the body of DELETE /api/bind
+type cancelBindRequest struct {
+	SkyAddr     string `json:"skyaddr"`
+	DepositAddr string `json:"deposit_addr"`
+	CoinType    string `json:"coin_type"`
+	Timestamp   int64  `json:"timestamp"`
+	Sig         string `json:"sig"`
+}
+
+// CancelBindHandler cancels a binding which has received no deposits
+// Method: DELETE
+// URI: /api/bind
+// Args:
+//
+//	{"skyaddr": "...", "deposit_addr": "...", "coin_type": "BTC", "timestamp": 1520000000, "sig": "..."}
+func CancelBindHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		w.Header().Set("Accept", "application/json")
+
+		if !validMethod(ctx, w, r, []string{http.MethodDelete}) {
+			return
+		}
+
+		if !s.validJSONContentType(ctx, w, r) {
+			return
+		}
+
+		var req cancelBindRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			code := requestBodyStatus(err)
+			err = fmt.Errorf("Invalid json request body: %v", err)
+			errorResponse(ctx, w, code, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		switch {
+		case req.SkyAddr == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
+			return
+		case req.DepositAddr == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing deposit_addr"))
+			return
+		case req.CoinType == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing coin_type"))
+			return
+		case req.Timestamp == 0:
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing timestamp"))
+			return
+		case req.Sig == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing sig"))
+			return
+		}
+
+		log = log.WithFields(logrus.Fields{
+			"skyAddr":     req.SkyAddr,
+			"depositAddr": req.DepositAddr,
+			"coinType":    req.CoinType,
+		})
+		ctx = logger.WithContext(ctx, log)
+
+		// The request's timestamp can't be checked, a replayed request could be accepted
+		if s.skew.Skewed() {
+			clockSkewResponse(ctx, w)
+			return
+		}
+
+		if err := s.service.CancelBinding(req.SkyAddr, req.DepositAddr, req.CoinType, req.Timestamp, req.Sig, s.clock.Now()); err != nil {
+			if err == errInvalidCancelSignature {
+				errorResponse(ctx, w, http.StatusForbidden, err)
+				return
+			}
+			switch err {
+			case ErrQuiesced:
+				w.Header().Set(errCodeHeader, errCodeHandover)
+				w.Header().Set("Retry-After", bindRetryAfter)
+			case ErrMaintenance:
+				w.Header().Set(errCodeHeader, errCodeMaintenance)
+				w.Header().Set("Retry-After", maintenanceRetryAfter)
+			}
+			log.WithError(err).Error("service.CancelBinding failed")
+			serviceErrorResponse(ctx, w, err)
+			return
+		}
+
+		log.Info("Cancelled binding")
+
+		if err := httputil.JSONResponse(w, struct{}{}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package teller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/skycoin/src/cipher"
+
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type cancelExchanger struct {
+	exchange.Exchanger
+	bindings  map[string]string
+	deposited map[string]bool
+	reused    map[string]bool
+}
+
+func (e *cancelExchanger) CancelBinding(skyAddr, depositAddr, coinType string, reused bool) error {
+	if e.bindings[depositAddr] != skyAddr {
+		return exchange.ErrBindingNotFound
+	}
+	if e.deposited[depositAddr] {
+		return exchange.ErrBindingHasDeposits
+	}
+	delete(e.bindings, depositAddr)
+	e.reused[depositAddr] = reused
+	return nil
+}
+
+func signCancelBind(sec cipher.SecKey, depositAddr string, timestamp int64) string {
+	skyAddr := cipher.AddressFromSecKey(sec).String()
+	return cipher.SignHash(CancelBindHash(skyAddr, depositAddr, scanner.CoinTypeBTC, timestamp), sec).Hex()
+}
+
+func TestVerifyCancelBind(t *testing.T) {
+	_, sec := cipher.GenerateKeyPair()
+	skyAddr := cipher.AddressFromSecKey(sec).String()
+	_, otherSec := cipher.GenerateKeyPair()
+
+	now := time.Now()
+	ts := now.Unix()
+	sig := signCancelBind(sec, "btcaddr1", ts)
+
+	require.NoError(t, verifyCancelBind(skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, sig, now))
+
+	// Clock skew within the limit
+	require.NoError(t, verifyCancelBind(skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, sig, now.Add(cancelBindMaxSkew-time.Second)))
+	require.NoError(t, verifyCancelBind(skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, sig, now.Add(-cancelBindMaxSkew+time.Second)))
+
+	tt := []struct {
+		name        string
+		skyAddr     string
+		depositAddr string
+		coinType    string
+		ts          int64
+		sig         string
+		now         time.Time
+	}{
+		{"expired", skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, sig, now.Add(cancelBindMaxSkew + time.Second)},
+		{"future", skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, sig, now.Add(-cancelBindMaxSkew - time.Second)},
+		{"other deposit address", skyAddr, "btcaddr2", scanner.CoinTypeBTC, ts, sig, now},
+		{"other coin type", skyAddr, "btcaddr1", scanner.CoinTypeETH, ts, sig, now},
+		{"other timestamp", skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts + 1, sig, now},
+		{"other key", skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, signCancelBind(otherSec, "btcaddr1", ts), now},
+		{"invalid skycoin address", "bad", "btcaddr1", scanner.CoinTypeBTC, ts, sig, now},
+		{"invalid sig", skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, "bad", now},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyCancelBind(tc.skyAddr, tc.depositAddr, tc.coinType, tc.ts, tc.sig, tc.now)
+			require.Equal(t, errInvalidCancelSignature, err)
+		})
+	}
+}
+
+func TestCancelBindHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	_, sec := cipher.GenerateKeyPair()
+	skyAddr := cipher.AddressFromSecKey(sec).String()
+
+	exchanger := &cancelExchanger{
+		bindings: map[string]string{
+			"btcaddr1": skyAddr,
+			"btcaddr2": skyAddr,
+		},
+		deposited: map[string]bool{
+			"btcaddr2": true,
+		},
+		reused: make(map[string]bool),
+	}
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+	}, &Service{
+		cfg: config.Teller{
+			CancelPolicy: config.CancelPolicyRetire,
+		},
+		exchanger: exchanger,
+		tracker:   analytics.Noop{},
+	}, nil, clock.Real{})
+
+	ts := time.Now().Unix()
+	body := func(depositAddr, sig string) string {
+		return fmt.Sprintf(`{"skyaddr":%q,"deposit_addr":%q,"coin_type":"BTC","timestamp":%d,"sig":%q}`, skyAddr, depositAddr, ts, sig)
+	}
+
+	tt := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+	}{
+		{
+			name:   "cancelled",
+			method: http.MethodDelete,
+			body:   body("btcaddr1", signCancelBind(sec, "btcaddr1", ts)),
+			code:   http.StatusOK,
+		},
+		{
+			name:   "already cancelled",
+			method: http.MethodDelete,
+			body:   body("btcaddr1", signCancelBind(sec, "btcaddr1", ts)),
+			code:   http.StatusNotFound,
+		},
+		{
+			name:   "has deposits",
+			method: http.MethodDelete,
+			body:   body("btcaddr2", signCancelBind(sec, "btcaddr2", ts)),
+			code:   http.StatusConflict,
+		},
+		{
+			name:   "invalid signature",
+			method: http.MethodDelete,
+			body:   body("btcaddr2", signCancelBind(sec, "btcaddr1", ts)),
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "missing sig",
+			method: http.MethodDelete,
+			body:   body("btcaddr2", ""),
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid json",
+			method: http.MethodDelete,
+			body:   `{"skyaddr":`,
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid method",
+			method: http.MethodGet,
+			code:   http.StatusMethodNotAllowed,
+		},
+	}
+
+	h := s.setupMux()
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/bind", strings.NewReader(tc.body))
+			req = req.WithContext(logger.WithContext(req.Context(), log))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+			require.Equal(t, tc.code, w.Code, w.Body.String())
+		})
+	}
+
+	require.Equal(t, map[string]bool{"btcaddr1": false}, exchanger.reused)
+}
+
+func TestServiceCancelBindingReuse(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	_, sec := cipher.GenerateKeyPair()
+	skyAddr := cipher.AddressFromSecKey(sec).String()
+
+	gen, err := addrs.NewAddrs(log, db, []string{"btcaddr1"}, "test_bucket")
+	require.NoError(t, err)
+	addrManager := addrs.NewAddrManager(addrs.AllocConfig{})
+	require.NoError(t, addrManager.PushGenerator(gen, scanner.CoinTypeBTC))
+
+	depositAddr, err := addrManager.NewAddress(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "btcaddr1", depositAddr)
+
+	exchanger := &cancelExchanger{
+		bindings: map[string]string{
+			"btcaddr1": skyAddr,
+		},
+		reused: make(map[string]bool),
+	}
+
+	s := &Service{
+		log: log,
+		cfg: config.Teller{
+			CancelPolicy: config.CancelPolicyReuse,
+		},
+		exchanger:   exchanger,
+		addrManager: addrManager,
+		tracker:     analytics.Noop{},
+	}
+
+	ts := time.Now().Unix()
+	require.NoError(t, s.CancelBinding(skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, signCancelBind(sec, "btcaddr1", ts), time.Now()))
+	require.Equal(t, map[string]bool{"btcaddr1": true}, exchanger.reused)
+
+	// The deposit address is back in the pool
+	depositAddr, err = addrManager.NewAddress(scanner.CoinTypeBTC)
+	require.NoError(t, err)
+	require.Equal(t, "btcaddr1", depositAddr)
+
+	// Cancelling is paused during a handover
+	s.Quiesce()
+	err = s.CancelBinding(skyAddr, "btcaddr1", scanner.CoinTypeBTC, ts, signCancelBind(sec, "btcaddr1", ts), time.Now())
+	require.Equal(t, ErrQuiesced, err)
+}
@@ -0,0 +1,80 @@
+package teller
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/httputil"
+)
+
+// cdnHandler wraps a handler to identify clients behind a CDN, see config.CDN.
+// The requests verified as coming from the CDN get the client IP of cfg.ClientIPHeader as their RemoteAddr,
+// so that the rate limits, the abuse detection and the logs see the client instead of the CDN's edge server.
+// The other requests keep their RemoteAddr and lose cfg.ClientIPHeader, or are refused if cfg.RejectDirect is true.
+func cdnHandler(cfg config.CDN, h http.Handler) http.Handler {
+	secret := []byte(cfg.OriginSecret)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified := fromCDN(cfg, secret, r)
+
+		// The secret is not passed on, so that it isn't logged or recorded
+		if cfg.OriginSecretHeader != "" {
+			r.Header.Del(cfg.OriginSecretHeader)
+		}
+
+		clientIP := r.Header.Get(cfg.ClientIPHeader)
+		r.Header.Del(cfg.ClientIPHeader)
+
+		if !verified {
+			if cfg.RejectDirect {
+				w.Header().Set(errCodeHeader, errCodeDirectOrigin)
+				httputil.ErrResponse(w, http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if ip := parseHostIP(clientIP); ip != nil {
+			r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// fromCDN returns true if r carries the origin secret, or came with a client certificate verified by web.cdn.client_ca
+func fromCDN(cfg config.CDN, secret []byte, r *http.Request) bool {
+	if len(secret) != 0 && cfg.OriginSecretHeader != "" {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(cfg.OriginSecretHeader)), secret) == 1 {
+			return true
+		}
+	}
+
+	if cfg.ClientCA != "" && r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		return true
+	}
+
+	return false
+}
+
+// loadCertPool loads the PEM encoded certificates of a file
+func loadCertPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("no PEM certificate found")
+	}
+
+	return pool, nil
+}
@@ -0,0 +1,159 @@
+package teller
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gz-c/tollbooth"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+)
+
+func TestCDNHandler(t *testing.T) {
+	cfg := config.CDN{
+		Enabled:            true,
+		ClientIPHeader:     "CF-Connecting-IP",
+		OriginSecretHeader: "X-Origin-Secret",
+		OriginSecret:       "secret",
+	}
+
+	var remoteAddr string
+	var header http.Header
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		header = r.Header
+	})
+
+	do := func(h http.Handler, secret, clientIP string, state *tls.ConnectionState) *httptest.ResponseRecorder {
+		remoteAddr = ""
+		header = nil
+		req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		req.RemoteAddr = "198.51.100.1:5000"
+		req.TLS = state
+		if secret != "" {
+			req.Header.Set("X-Origin-Secret", secret)
+		}
+		if clientIP != "" {
+			req.Header.Set("CF-Connecting-IP", clientIP)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// A verified request is identified by its client IP header, and the headers are not passed on
+	w := do(cdnHandler(cfg, h), "secret", "2001:db8::1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "[2001:db8::1]:0", remoteAddr)
+	require.Empty(t, header.Get("X-Origin-Secret"))
+	require.Empty(t, header.Get("CF-Connecting-IP"))
+
+	// A verified request without a valid client IP keeps its RemoteAddr
+	do(cdnHandler(cfg, h), "secret", "not-an-ip", nil)
+	require.Equal(t, "198.51.100.1:5000", remoteAddr)
+
+	// A direct request can't spoof its client IP
+	w = do(cdnHandler(cfg, h), "wrong", "203.0.113.1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "198.51.100.1:5000", remoteAddr)
+	require.Empty(t, header.Get("CF-Connecting-IP"))
+	require.Empty(t, header.Get("X-Origin-Secret"))
+
+	// A client certificate isn't trusted without web.cdn.client_ca
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{&x509.Certificate{}}},
+	}
+	do(cdnHandler(cfg, h), "", "203.0.113.1", verified)
+	require.Equal(t, "198.51.100.1:5000", remoteAddr)
+
+	// Direct requests are refused with reject_direct
+	rejectCfg := cfg
+	rejectCfg.RejectDirect = true
+	w = do(cdnHandler(rejectCfg, h), "", "203.0.113.1", nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, errCodeDirectOrigin, w.Header().Get(errCodeHeader))
+	require.Nil(t, header)
+
+	w = do(cdnHandler(rejectCfg, h), "secret", "203.0.113.1", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "203.0.113.1:0", remoteAddr)
+
+	// A request with the CDN's client certificate is verified
+	mtlsCfg := config.CDN{
+		Enabled:        true,
+		ClientIPHeader: "CF-Connecting-IP",
+		ClientCA:       "cdn-ca.pem",
+		RejectDirect:   true,
+	}
+	w = do(cdnHandler(mtlsCfg, h), "", "203.0.113.1", verified)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "203.0.113.1:0", remoteAddr)
+
+	w = do(cdnHandler(mtlsCfg, h), "", "203.0.113.1", &tls.ConnectionState{})
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = do(cdnHandler(mtlsCfg, h), "secret", "203.0.113.1", nil)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCDNHandlerRateLimit(t *testing.T) {
+	cfg := config.CDN{
+		Enabled:            true,
+		ClientIPHeader:     "CF-Connecting-IP",
+		OriginSecretHeader: "X-Origin-Secret",
+		OriginSecret:       "secret",
+	}
+
+	h := cdnHandler(cfg, ipLimit(tollbooth.NewLimiter(1, time.Hour, nil), 64, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	do := func(remoteAddr, secret, clientIP string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/bind", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Origin-Secret", secret)
+		req.Header.Set("CF-Connecting-IP", clientIP)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Clients behind the same edge server have their own limits
+	require.Equal(t, http.StatusOK, do("198.51.100.1:5000", "secret", "203.0.113.1"))
+	require.Equal(t, http.StatusOK, do("198.51.100.1:5000", "secret", "203.0.113.2"))
+	require.Equal(t, http.StatusTooManyRequests, do("198.51.100.2:5000", "secret", "203.0.113.1"))
+
+	// A direct client can't get a new limit with a spoofed client IP
+	require.Equal(t, http.StatusOK, do("192.0.2.1:5000", "", "203.0.113.3"))
+	require.Equal(t, http.StatusTooManyRequests, do("192.0.2.1:5000", "", "203.0.113.4"))
+}
+
+func TestLoadCertPool(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := loadCertPool(filepath.Join(dir, "missing.pem"))
+	require.Error(t, err)
+
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, ioutil.WriteFile(empty, []byte("not a certificate"), 0600))
+	_, err = loadCertPool(empty)
+	require.Error(t, err)
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	ca := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0600))
+
+	pool, err := loadCertPool(ca)
+	require.NoError(t, err)
+	require.NotNil(t, pool)
+}
@@ -0,0 +1,265 @@
+package teller
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/objstore"
+	"github.com/skycoin/teller/src/util/dbutil"
+)
+
+const (
+	// How often expired certs are removed from the autocert cache
+	tlsAutoCertCacheCleanupInterval = time.Hour * 24
+)
+
+var (
+	// AutoCertCacheBkt maps an autocert cache key to its data
+	AutoCertCacheBkt = []byte("autocert_cache")
+)
+
+// CertCache is an autocert.Cache which can list its keys, so that expired certs can be removed
+type CertCache interface {
+	autocert.Cache
+	Keys(ctx context.Context) ([]string, error)
+}
+
+// NewCertCache creates the autocert cache backend configured by web.auto_tls_cache.
+// bucket is the object_storage bucket, nil if unset.
+func NewCertCache(cfg config.Web, db *bolt.DB, bucket *objstore.Bucket) (CertCache, error) {
+	switch cfg.AutoTLSCache {
+	case config.AutoTLSCacheDir:
+		return DirCertCache{autocert.DirCache(cfg.AutoTLSCacheDir)}, nil
+	case config.AutoTLSCacheDB:
+		return NewBoltCertCache(db)
+	case config.AutoTLSCacheObject:
+		if bucket == nil {
+			return nil, errors.New("new ObjectCertCache failed, bucket is nil")
+		}
+		return NewObjectCertCache(bucket), nil
+	default:
+		return nil, fmt.Errorf("Invalid autocert cache \"%s\"", cfg.AutoTLSCache)
+	}
+}
+
+// DirCertCache stores autocert data in a directory
+type DirCertCache struct {
+	autocert.DirCache
+}
+
+// Keys returns the names of the files in the cache directory
+func (d DirCertCache) Keys(ctx context.Context) ([]string, error) {
+	fs, err := ioutil.ReadDir(string(d.DirCache))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var keys []string
+	for _, f := range fs {
+		if f.Mode().IsRegular() {
+			keys = append(keys, f.Name())
+		}
+	}
+
+	return keys, nil
+}
+
+// BoltCertCache stores autocert data in the teller database
+type BoltCertCache struct {
+	db *bolt.DB
+}
+
+// NewBoltCertCache creates a BoltCertCache
+func NewBoltCertCache(db *bolt.DB) (*BoltCertCache, error) {
+	if db == nil {
+		return nil, errors.New("new BoltCertCache failed, db is nil")
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(AutoCertCacheBkt); err != nil {
+			return dbutil.NewCreateBucketFailedErr(AutoCertCacheBkt, err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return &BoltCertCache{
+		db: db,
+	}, nil
+}
+
+// Get returns the data saved under key, or autocert.ErrCacheMiss
+func (c *BoltCertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(AutoCertCacheBkt).Get([]byte(key))
+		if v == nil {
+			return autocert.ErrCacheMiss
+		}
+		data = append([]byte{}, v...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Put saves data under key
+func (c *BoltCertCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(AutoCertCacheBkt).Put([]byte(key), data)
+	})
+}
+
+// Delete removes key
+func (c *BoltCertCache) Delete(ctx context.Context, key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(AutoCertCacheBkt).Delete([]byte(key))
+	})
+}
+
+// Keys returns all keys in the cache
+func (c *BoltCertCache) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	if err := c.db.View(func(tx *bolt.Tx) error {
+		return dbutil.ForEach(tx, AutoCertCacheBkt, func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ObjectStore is a bucket of objects, such as an objstore.Bucket
+type ObjectStore interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// objectCertCachePrefix is the prefix of the autocert cache's object keys
+const objectCertCachePrefix = "autocert/"
+
+// ObjectCertCache stores autocert data in an object storage bucket, under objectCertCachePrefix
+type ObjectCertCache struct {
+	bucket ObjectStore
+}
+
+// NewObjectCertCache creates an ObjectCertCache
+func NewObjectCertCache(bucket ObjectStore) *ObjectCertCache {
+	return &ObjectCertCache{
+		bucket: bucket,
+	}
+}
+
+// Get returns the data saved under key, or autocert.ErrCacheMiss
+func (c *ObjectCertCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.bucket.Get(ctx, objectCertCachePrefix+key)
+	if err == objstore.ErrNotExist {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+// Put saves data under key
+func (c *ObjectCertCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.bucket.Put(ctx, objectCertCachePrefix+key, bytes.NewReader(data), int64(len(data)))
+}
+
+// Delete removes key
+func (c *ObjectCertCache) Delete(ctx context.Context, key string) error {
+	return c.bucket.Delete(ctx, objectCertCachePrefix+key)
+}
+
+// Keys returns all keys in the cache
+func (c *ObjectCertCache) Keys(ctx context.Context) ([]string, error) {
+	keys, err := c.bucket.List(ctx, objectCertCachePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, objectCertCachePrefix)
+	}
+
+	return keys, nil
+}
+
+// cleanCertCache removes cached certs which have expired at time now.
+// Entries which don't contain a certificate, such as the ACME account key, are kept.
+// Returns the removed keys.
+func cleanCertCache(ctx context.Context, cache CertCache, now time.Time) ([]string, error) {
+	keys, err := cache.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, k := range keys {
+		data, err := cache.Get(ctx, k)
+		switch err {
+		case nil:
+		case autocert.ErrCacheMiss:
+			continue
+		default:
+			return removed, err
+		}
+
+		notAfter, ok := certNotAfter(data)
+		if !ok || now.Before(notAfter) {
+			continue
+		}
+
+		if err := cache.Delete(ctx, k); err != nil {
+			return removed, err
+		}
+
+		removed = append(removed, k)
+	}
+
+	return removed, nil
+}
+
+// certNotAfter returns the expiry of the leaf certificate in PEM data
+func certNotAfter(data []byte) (time.Time, bool) {
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			return time.Time{}, false
+		}
+
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		return cert.NotAfter, true
+	}
+}
@@ -0,0 +1,146 @@
+package teller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/skycoin/teller/src/objstore"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// testCertPEM returns a key and self signed cert encoded like autocert's cache entries
+func testCertPEM(t *testing.T, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.Add(-time.Hour * 24 * 90),
+		NotAfter:     notAfter,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+}
+
+func testCleanCertCache(t *testing.T, cache CertCache) {
+	ctx := context.Background()
+	now := time.Now()
+
+	require.NoError(t, cache.Put(ctx, "expired.example.com", testCertPEM(t, now.Add(-time.Hour))))
+	require.NoError(t, cache.Put(ctx, "valid.example.com", testCertPEM(t, now.Add(time.Hour))))
+	require.NoError(t, cache.Put(ctx, "acme_account.key", []byte("not a cert")))
+
+	keys, err := cache.Keys(ctx)
+	require.NoError(t, err)
+	sort.Strings(keys)
+	require.Equal(t, []string{"acme_account.key", "expired.example.com", "valid.example.com"}, keys)
+
+	removed, err := cleanCertCache(ctx, cache, now)
+	require.NoError(t, err)
+	require.Equal(t, []string{"expired.example.com"}, removed)
+
+	_, err = cache.Get(ctx, "expired.example.com")
+	require.Equal(t, autocert.ErrCacheMiss, err)
+
+	_, err = cache.Get(ctx, "valid.example.com")
+	require.NoError(t, err)
+
+	_, err = cache.Get(ctx, "acme_account.key")
+	require.NoError(t, err)
+}
+
+func TestCleanCertCacheDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cert-cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	testCleanCertCache(t, DirCertCache{autocert.DirCache(dir)})
+}
+
+func TestCleanCertCacheBolt(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+
+	cache, err := NewBoltCertCache(db)
+	require.NoError(t, err)
+
+	testCleanCertCache(t, cache)
+}
+
+// memObjectStore is an ObjectStore in memory
+type memObjectStore map[string][]byte
+
+func (m memObjectStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, ok := m[key]
+	if !ok {
+		return nil, objstore.ErrNotExist
+	}
+	return data, nil
+}
+
+func (m memObjectStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m[key] = data
+	return nil
+}
+
+func (m memObjectStore) Delete(ctx context.Context, key string) error {
+	delete(m, key)
+	return nil
+}
+
+func (m memObjectStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for k := range m {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func TestCleanCertCacheObject(t *testing.T) {
+	bucket := memObjectStore{
+		"backups/teller.db": []byte("not in the cache"),
+	}
+
+	testCleanCertCache(t, NewObjectCertCache(bucket))
+
+	_, ok := bucket["autocert/valid.example.com"]
+	require.True(t, ok)
+	_, ok = bucket["backups/teller.db"]
+	require.True(t, ok)
+}
+
+func TestDirCertCacheKeysMissingDir(t *testing.T) {
+	keys, err := DirCertCache{autocert.DirCache("does-not-exist")}.Keys(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
@@ -0,0 +1,76 @@
+package teller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Request content types.
+// The API's JSON request bodies are sent with a Content-Type of application/json, which is parsed as a media type,
+// so that its case and parameters don't matter, e.g. "application/json; charset=utf-8" is accepted.
+// JSON is UTF-8, so a charset other than utf-8 is refused. web.content_type is "strict" or "lenient":
+// the strict mode refuses the parameters other than charset, and the lenient mode ignores them,
+// and also accepts the structured syntax suffix types, e.g. application/vnd.api+json, and a charset of utf8.
+// A body which isn't JSON, e.g. a form, is refused in both modes, so that a cross origin form can't post to the API.
+
+const (
+	// ContentTypeStrict only accepts application/json, with an optional charset of utf-8
+	ContentTypeStrict = "strict"
+	// ContentTypeLenient also accepts the +json types, other parameters and a charset of utf8
+	ContentTypeLenient = "lenient"
+)
+
+var errInvalidContentType = errors.New("Invalid content type")
+
+// parseJSONContentType returns an error if contentType isn't the content type of a JSON body in mode
+func parseJSONContentType(contentType, mode string) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errInvalidContentType
+	}
+
+	lenient := mode == ContentTypeLenient
+
+	if mediaType != "application/json" && !(lenient && isJSONSuffixType(mediaType)) {
+		return errInvalidContentType
+	}
+
+	for k, v := range params {
+		if k != "charset" {
+			if lenient {
+				continue
+			}
+			return fmt.Errorf("Invalid content type parameter %q", k)
+		}
+
+		switch strings.ToLower(v) {
+		case "utf-8":
+		case "utf8":
+			if !lenient {
+				return fmt.Errorf("Unsupported charset %q, must be utf-8", v)
+			}
+		default:
+			return fmt.Errorf("Unsupported charset %q, must be utf-8", v)
+		}
+	}
+
+	return nil
+}
+
+// isJSONSuffixType returns true for an application media type with the +json structured syntax suffix, see RFC 6839
+func isJSONSuffixType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json") && len(mediaType) > len("application/+json")
+}
+
+// validJSONContentType writes a 415 response and returns false if the request body isn't JSON, per web.content_type
+func (s *HTTPServer) validJSONContentType(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if err := parseJSONContentType(r.Header.Get("Content-Type"), s.cfg.Web.ContentType); err != nil {
+		errorResponse(ctx, w, http.StatusUnsupportedMediaType, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package teller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestParseJSONContentType(t *testing.T) {
+	cases := []struct {
+		contentType string
+		strict      string
+		lenient     string
+	}{
+		{"application/json", "", ""},
+		{"application/json; charset=utf-8", "", ""},
+		{"Application/JSON; Charset=UTF-8", "", ""},
+		{"application/json;charset=\"utf-8\"", "", ""},
+		{"application/json; charset=utf8", `Unsupported charset "utf8", must be utf-8`, ""},
+		{"application/json; charset=iso-8859-1", `Unsupported charset "iso-8859-1", must be utf-8`, `Unsupported charset "iso-8859-1", must be utf-8`},
+		{"application/json; version=2", `Invalid content type parameter "version"`, ""},
+		{"application/vnd.api+json", "Invalid content type", ""},
+		{"application/+json", "Invalid content type", "Invalid content type"},
+		{"text/json+json", "Invalid content type", "Invalid content type"},
+		{"text/plain", "Invalid content type", "Invalid content type"},
+		{"application/x-www-form-urlencoded", "Invalid content type", "Invalid content type"},
+		{"application/json; charset", "Invalid content type", "Invalid content type"},
+		{"", "Invalid content type", "Invalid content type"},
+	}
+
+	for _, tc := range cases {
+		for _, m := range []struct {
+			mode string
+			err  string
+		}{
+			{ContentTypeStrict, tc.strict},
+			{ContentTypeLenient, tc.lenient},
+			// The zero value is strict
+			{"", tc.strict},
+		} {
+			err := parseJSONContentType(tc.contentType, m.mode)
+			if m.err == "" {
+				require.NoError(t, err, "%q %s", tc.contentType, m.mode)
+			} else {
+				require.EqualError(t, err, m.err, "%q %s", tc.contentType, m.mode)
+			}
+		}
+	}
+}
+
+func TestJSONContentTypeHandlers(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled:  true,
+			ContentType: ContentTypeStrict,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}
+	s := NewHTTPServer(log, cfg, &Service{}, nil, clock.Real{})
+
+	handlers := []struct {
+		method string
+		h      http.HandlerFunc
+	}{
+		{http.MethodPost, BindHandler(s)},
+		{http.MethodDelete, BindHandler(s)},
+		{http.MethodPost, EraseContactHandler(s)},
+	}
+
+	do := func(method string, h http.HandlerFunc, contentType string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/bind", strings.NewReader("{"))
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	// Each handler parses the content type the same way, the body is only decoded after it is accepted
+	for _, h := range handlers {
+		w := do(h.method, h.h, "application/json; charset=utf-8")
+		require.Equal(t, http.StatusBadRequest, w.Code, h.method)
+		require.Contains(t, w.Body.String(), "Invalid json request body")
+
+		w = do(h.method, h.h, "application/json; charset=latin1")
+		require.Equal(t, http.StatusUnsupportedMediaType, w.Code, h.method)
+		require.Contains(t, w.Body.String(), "Unsupported charset")
+
+		w = do(h.method, h.h, "application/vnd.api+json")
+		require.Equal(t, http.StatusUnsupportedMediaType, w.Code, h.method)
+	}
+
+	s.cfg.Web.ContentType = ContentTypeLenient
+	for _, h := range handlers {
+		w := do(h.method, h.h, "application/vnd.api+json; charset=UTF8")
+		require.Equal(t, http.StatusBadRequest, w.Code, h.method)
+
+		w = do(h.method, h.h, "text/plain")
+		require.Equal(t, http.StatusUnsupportedMediaType, w.Code, h.method)
+	}
+}
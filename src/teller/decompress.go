@@ -0,0 +1,160 @@
+package teller
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// Compressed request bodies.
+// API clients can send request bodies compressed with Content-Encoding gzip or deflate, e.g. partner integrations
+// posting large payloads. A body is decompressed as the handler reads it, so after the rate limits, and reading
+// more than web.max_request_body bytes of the decompressed body fails, so that a small compressed body can't expand
+// without bound. The handlers refuse such a body with a 413, and a body with another Content-Encoding is refused with a 415.
+// The limit applies to uncompressed bodies too.
+
+// requestBodyTooLargeErr is returned by the reads of a request body past web.max_request_body
+type requestBodyTooLargeErr struct {
+	limit int64
+}
+
+func (e requestBodyTooLargeErr) Error() string {
+	return fmt.Sprintf("Request body is larger than %d bytes", e.limit)
+}
+
+// requestBodyErr is returned by the reads of a compressed request body which can't be decompressed
+type requestBodyErr struct {
+	encoding string
+	err      error
+}
+
+func (e requestBodyErr) Error() string {
+	return fmt.Sprintf("Invalid %s request body: %v", e.encoding, e.err)
+}
+
+// requestBodyStatus returns the status of a response to a request whose body failed to decode with err
+func requestBodyStatus(err error) int {
+	if _, ok := err.(requestBodyTooLargeErr); ok {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// decompressBody decompresses the request bodies with a Content-Encoding, and limits the bodies to web.max_request_body
+func (s *HTTPServer) decompressBody(h http.Handler) http.Handler {
+	limit := s.cfg.Web.MaxRequestBody
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+		switch encoding {
+		case "", "identity":
+			if limit > 0 && r.ContentLength > limit {
+				ctx := logger.WithContext(r.Context(), s.log)
+				errorResponse(ctx, w, http.StatusRequestEntityTooLarge, requestBodyTooLargeErr{limit})
+				return
+			}
+		case "gzip", "x-gzip", "deflate":
+			// The handlers see the decompressed body
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		default:
+			ctx := logger.WithContext(r.Context(), s.log)
+			errorResponse(ctx, w, http.StatusUnsupportedMediaType, fmt.Errorf("Unsupported Content-Encoding %q", encoding))
+			return
+		}
+
+		r.Body = &decompressedBody{
+			encoding: encoding,
+			body:     r.Body,
+			limit:    limit,
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// decompressedBody decompresses a request body when it is first read, and fails the reads past limit
+type decompressedBody struct {
+	encoding string
+	body     io.ReadCloser
+	limit    int64
+	r        io.Reader
+	read     int64
+}
+
+func (b *decompressedBody) Read(p []byte) (int, error) {
+	if b.limit > 0 && b.read > b.limit {
+		return 0, requestBodyTooLargeErr{b.limit}
+	}
+
+	if b.r == nil {
+		r, err := newBodyReader(b.encoding, b.body)
+		if err != nil {
+			return 0, requestBodyErr{b.encoding, err}
+		}
+		b.r = r
+	}
+
+	// Read one byte past the limit, to tell a body of exactly limit bytes from a larger one
+	if b.limit > 0 && int64(len(p)) > b.limit-b.read+1 {
+		p = p[:b.limit-b.read+1]
+	}
+
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+
+	if b.limit > 0 && b.read > b.limit {
+		return n - int(b.read-b.limit), requestBodyTooLargeErr{b.limit}
+	}
+
+	if err != nil && err != io.EOF && b.encoding != "" && b.encoding != "identity" {
+		err = requestBodyErr{b.encoding, err}
+	}
+
+	return n, err
+}
+
+func (b *decompressedBody) Close() error {
+	return b.body.Close()
+}
+
+// newBodyReader returns a reader of the body decompressed from encoding.
+// A deflate body is zlib wrapped raw deflate data, but some clients send it unwrapped, so both are read.
+func newBodyReader(encoding string, body io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		br := bufio.NewReader(body)
+		header, err := br.Peek(2)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if isZlibHeader(header) {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return body, nil
+	}
+}
+
+// isZlibHeader returns true if b starts with a zlib header of deflate data, see RFC 1950
+func isZlibHeader(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
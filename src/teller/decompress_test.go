@@ -0,0 +1,133 @@
+package teller
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func compressBody(t *testing.T, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		require.NoError(t, err)
+		w = fw
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+
+	_, err := w.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestDecompressBody(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			MaxRequestBody: 64,
+		},
+	}, &Service{}, nil, clock.Real{})
+
+	type request struct {
+		SkyAddr string `json:"skyaddr"`
+	}
+
+	// Echoes the decoded request, like the API handlers decode theirs
+	h := s.decompressBody(s.withLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		require.NotContains(t, []string{"gzip", "x-gzip", "deflate"}, strings.ToLower(r.Header.Get("Content-Encoding")))
+
+		var req request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errorResponse(ctx, w, requestBodyStatus(err), err)
+			return
+		}
+
+		w.Write([]byte(req.SkyAddr)) // nolint: errcheck
+	})))
+
+	post := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/bind", bytes.NewReader(body))
+		if encoding != "" {
+			r.Header.Set("Content-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	body := []byte(`{"skyaddr":"2do3K1YLMy3Aq6EcPMdncEurP5BfAUdFPJj"}`)
+
+	for _, tc := range []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"uncompressed", "", body},
+		{"identity", "identity", body},
+		{"gzip", "gzip", compressBody(t, "gzip", body)},
+		{"x-gzip", "x-gzip", compressBody(t, "gzip", body)},
+		{"deflate", "deflate", compressBody(t, "deflate", body)},
+		{"raw deflate", "deflate", compressBody(t, "raw-deflate", body)},
+		{"case insensitive", "GZIP", compressBody(t, "gzip", body)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := post(tc.encoding, tc.body)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			require.Equal(t, "2do3K1YLMy3Aq6EcPMdncEurP5BfAUdFPJj", w.Body.String())
+		})
+	}
+
+	// The limit applies to the decompressed body, however small the compressed body is
+	large := []byte(`{"skyaddr":"` + strings.Repeat("a", 1000) + `"}`)
+	compressed := compressBody(t, "gzip", large)
+	require.True(t, len(compressed) < 64)
+
+	w := post("gzip", compressed)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	w = post("deflate", compressBody(t, "deflate", large))
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// An uncompressed body over the limit is refused by its Content-Length
+	w = post("", large)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// A body of exactly the limit is accepted
+	exact := []byte(`{"skyaddr":"` + strings.Repeat("b", 64-len(`{"skyaddr":""}`)) + `"}`)
+	require.Len(t, exact, 64)
+	w = post("gzip", compressBody(t, "gzip", exact))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// A body which isn't compressed as it claims is invalid
+	w = post("gzip", body)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Invalid gzip request body")
+
+	w = post("br", body)
+	require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
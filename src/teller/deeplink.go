@@ -0,0 +1,169 @@
+package teller
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// Deep links let a user resume a bind flow on another device, e.g. start it on a phone and deposit from a desktop.
+// With deep links enabled, /api/bind returns a link to the deep_links.url frontend page, with a signed token
+// of the binding in its link parameter. The page passes the token to /api/status in the link parameter, which
+// verifies it and returns the binding with the statuses, so that the page can restore the flow.
+// A token expires after deep_links.ttl, and is refused once its binding is cancelled.
+
+// deepLinkParam is the query parameter of a deep link token, on the frontend page and /api/status
+const deepLinkParam = "link"
+
+// errInvalidDeepLink is returned for a deep link token which is malformed, forged or expired
+var errInvalidDeepLink = errors.New("Invalid or expired link")
+
+// DeepLinkBinding is the binding encoded in a deep link token
+type DeepLinkBinding struct {
+	SkyAddress     string `json:"skyaddr"`
+	CoinType       string `json:"coin_type"`
+	DepositAddress string `json:"deposit_address"`
+	// When the link expires, as a unix timestamp
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// deepLinks issues and checks deep link tokens
+type deepLinks struct {
+	url string
+	key []byte
+	ttl time.Duration
+}
+
+// newDeepLinks creates a deepLinks, or returns nil if deep links are disabled
+func newDeepLinks(cfg config.DeepLinks) *deepLinks {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	return &deepLinks{
+		url: cfg.URL,
+		key: []byte(cfg.SigningKey),
+		ttl: cfg.TTL,
+	}
+}
+
+func (d *deepLinks) sign(payload string) []byte {
+	h := hmac.New(sha256.New, d.key)
+	h.Write([]byte("deeplink\n" + payload)) // nolint: errcheck
+	return h.Sum(nil)
+}
+
+// token returns the token of a binding, valid until the returned expiry time.
+// The token is the base64 encoded "skyaddr\ncoin_type\ndeposit_address\nexpiry" payload and
+// its hex encoded signature, joined by ".".
+func (d *deepLinks) token(skyAddr, coinType, depositAddr string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(d.ttl).UTC()
+	payload := strings.Join([]string{
+		skyAddr,
+		coinType,
+		depositAddr,
+		strconv.FormatInt(expiresAt.Unix(), 10),
+	}, "\n")
+
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + hex.EncodeToString(d.sign(payload)), expiresAt
+}
+
+// link returns the deep link of a binding to the frontend page, valid until the returned expiry time
+func (d *deepLinks) link(skyAddr, coinType, depositAddr string, now time.Time) (string, time.Time) {
+	token, expiresAt := d.token(skyAddr, coinType, depositAddr, now)
+
+	q := url.Values{}
+	q.Set(deepLinkParam, token)
+
+	sep := "?"
+	if strings.Contains(d.url, "?") {
+		sep = "&"
+	}
+
+	return d.url + sep + q.Encode(), expiresAt
+}
+
+// verify returns the binding of a token which is valid at time now
+func (d *deepLinks) verify(token string, now time.Time) (DeepLinkBinding, error) {
+	pts := strings.Split(token, ".")
+	if len(pts) != 2 {
+		return DeepLinkBinding{}, errInvalidDeepLink
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(pts[0])
+	if err != nil {
+		return DeepLinkBinding{}, errInvalidDeepLink
+	}
+	payload := string(b)
+
+	sig, err := hex.DecodeString(pts[1])
+	if err != nil || !hmac.Equal(sig, d.sign(payload)) {
+		return DeepLinkBinding{}, errInvalidDeepLink
+	}
+
+	fields := strings.Split(payload, "\n")
+	if len(fields) != 4 {
+		return DeepLinkBinding{}, errInvalidDeepLink
+	}
+
+	expiresAt, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return DeepLinkBinding{}, errInvalidDeepLink
+	}
+
+	return DeepLinkBinding{
+		SkyAddress:     fields[0],
+		CoinType:       fields[1],
+		DepositAddress: fields[2],
+		ExpiresAt:      expiresAt,
+	}, nil
+}
+
+// verifyDeepLink returns the binding of a deep link token sent to /api/status, which must still be bound.
+// skyAddr is the request's skyaddr, which must be the binding's if it is set.
+// It writes an error response and returns false if the token can't be used.
+func (s *HTTPServer) verifyDeepLink(ctx context.Context, w http.ResponseWriter, token, skyAddr string) (DeepLinkBinding, bool) {
+	log := logger.FromContext(ctx)
+
+	if s.deepLinks == nil {
+		errorResponse(ctx, w, http.StatusForbidden, errors.New("Deep links disabled"))
+		return DeepLinkBinding{}, false
+	}
+
+	b, err := s.deepLinks.verify(token, s.clock.Now())
+	if err != nil {
+		errorResponse(ctx, w, http.StatusUnauthorized, err)
+		return DeepLinkBinding{}, false
+	}
+
+	if skyAddr != "" && skyAddr != b.SkyAddress {
+		errorResponse(ctx, w, http.StatusBadRequest, errors.New("skyaddr is not the link's skycoin address"))
+		return DeepLinkBinding{}, false
+	}
+
+	// A cancelled binding's deposit address can be bound to another skycoin address
+	boundAddr, err := s.service.GetBoundSkyAddress(b.DepositAddress, b.CoinType)
+	if err != nil {
+		log.WithError(err).Error("service.GetBoundSkyAddress failed")
+		serviceErrorResponse(ctx, w, err)
+		return DeepLinkBinding{}, false
+	}
+
+	if boundAddr != b.SkyAddress {
+		errorResponse(ctx, w, http.StatusUnauthorized, errInvalidDeepLink)
+		return DeepLinkBinding{}, false
+	}
+
+	return b, true
+}
@@ -0,0 +1,217 @@
+package teller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func testDeepLinksConfig() config.DeepLinks {
+	return config.DeepLinks{
+		Enabled:    true,
+		URL:        "https://teller.example.com/resume",
+		SigningKey: "deeplink-key",
+		TTL:        time.Hour,
+	}
+}
+
+func TestDeepLinks(t *testing.T) {
+	require.Nil(t, newDeepLinks(config.DeepLinks{}))
+
+	d := newDeepLinks(testDeepLinksConfig())
+
+	now := time.Now()
+	link, expiresAt := d.link("skyaddr", scanner.CoinTypeBTC, "btcaddr", now)
+	require.Equal(t, now.Add(time.Hour).Unix(), expiresAt.Unix())
+	require.True(t, strings.HasPrefix(link, "https://teller.example.com/resume?link="), link)
+
+	u, err := url.Parse(link)
+	require.NoError(t, err)
+	token := u.Query().Get(deepLinkParam)
+
+	b, err := d.verify(token, now)
+	require.NoError(t, err)
+	require.Equal(t, DeepLinkBinding{
+		SkyAddress:     "skyaddr",
+		CoinType:       scanner.CoinTypeBTC,
+		DepositAddress: "btcaddr",
+		ExpiresAt:      expiresAt.Unix(),
+	}, b)
+
+	// Expired
+	_, err = d.verify(token, expiresAt)
+	require.Equal(t, errInvalidDeepLink, err)
+
+	// Tampered payload or signature
+	pts := strings.Split(token, ".")
+	_, err = d.verify(pts[0]+"."+strings.Repeat("0", len(pts[1])), now)
+	require.Equal(t, errInvalidDeepLink, err)
+	_, err = d.verify("x"+token, now)
+	require.Equal(t, errInvalidDeepLink, err)
+	_, err = d.verify("garbage", now)
+	require.Equal(t, errInvalidDeepLink, err)
+
+	// Signed with another key
+	cfg := testDeepLinksConfig()
+	cfg.SigningKey = "other-key"
+	_, err = newDeepLinks(cfg).verify(token, now)
+	require.Equal(t, errInvalidDeepLink, err)
+
+	// A URL with a query keeps it
+	cfg = testDeepLinksConfig()
+	cfg.URL = "https://teller.example.com/?page=resume"
+	link, _ = newDeepLinks(cfg).link("skyaddr", scanner.CoinTypeBTC, "btcaddr", now)
+	require.True(t, strings.HasPrefix(link, "https://teller.example.com/?page=resume&link="), link)
+}
+
+type deepLinkExchanger struct {
+	quoteExchanger
+	bindings map[string]string
+}
+
+func (e deepLinkExchanger) BindAddress(skyAddr, depositAddr, coinType, refundAddr string) error {
+	e.bindings[depositAddr] = skyAddr
+	return nil
+}
+
+func (e deepLinkExchanger) GetBoundSkyAddress(depositAddr, coinType string) (string, error) {
+	return e.bindings[depositAddr], nil
+}
+
+func (e deepLinkExchanger) GetDepositStatuses(skyAddr string) ([]exchange.DepositStatus, error) {
+	return []exchange.DepositStatus{
+		{
+			Status:   exchange.StatusWaitDeposit.String(),
+			CoinType: scanner.CoinTypeBTC,
+		},
+	}, nil
+}
+
+func TestStatusHandlerDeepLink(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	gen, err := addrs.NewAddrs(log, db, []string{"btcaddr1", "btcaddr2"}, "test_bucket")
+	require.NoError(t, err)
+	addrManager := addrs.NewAddrManager(addrs.AllocConfig{})
+	require.NoError(t, addrManager.PushGenerator(gen, scanner.CoinTypeBTC))
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(now)
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	exchanger := deepLinkExchanger{
+		bindings: map[string]string{},
+	}
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}
+	s := NewHTTPServer(log, cfg, &Service{
+		log:         log,
+		exchanger:   exchanger,
+		addrManager: addrManager,
+		tracker:     analytics.Noop{},
+	}, nil, clk)
+
+	bind := func() BindResponse {
+		body := `{"skyaddr":"` + skyAddr + `","coin_type":"BTC"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		BindHandler(s)(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var rsp BindResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		return rsp
+	}
+
+	status := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/status?"+query, nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+		StatusHandler(s)(w, req)
+		return w
+	}
+
+	// No link while deep links are disabled
+	rsp := bind()
+	require.Empty(t, rsp.DeepLink)
+
+	w := status("link=x")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), "Deep links disabled")
+
+	cfg.DeepLinks = testDeepLinksConfig()
+	s.deepLinks = newDeepLinks(cfg.DeepLinks)
+
+	rsp = bind()
+	require.Equal(t, now.Add(time.Hour).Unix(), rsp.DeepLinkExpiresAt)
+
+	u, err := url.Parse(rsp.DeepLink)
+	require.NoError(t, err)
+	token := u.Query().Get(deepLinkParam)
+	require.NotEmpty(t, token)
+
+	// The link restores the binding on another device
+	w = status("link=" + url.QueryEscape(token))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var statusRsp StatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &statusRsp))
+	require.Len(t, statusRsp.Statuses, 1)
+	require.Equal(t, &DeepLinkBinding{
+		SkyAddress:     skyAddr,
+		CoinType:       scanner.CoinTypeBTC,
+		DepositAddress: rsp.DepositAddress,
+		ExpiresAt:      rsp.DeepLinkExpiresAt,
+	}, statusRsp.Binding)
+
+	// A request with skyaddr alone has no binding
+	w = status("skyaddr=" + skyAddr)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	statusRsp = StatusResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &statusRsp))
+	require.Nil(t, statusRsp.Binding)
+
+	// The skyaddr must be the link's
+	w = status("skyaddr=2cpfDxtw4H8RuCTQbYdrNQjgGTGnUP8bQbM&link=" + url.QueryEscape(token))
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = status("link=garbage")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// A deposit address bound to another skycoin address after the binding was cancelled
+	exchanger.bindings[rsp.DepositAddress] = "2cpfDxtw4H8RuCTQbYdrNQjgGTGnUP8bQbM"
+	w = status("link=" + url.QueryEscape(token))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Expired
+	exchanger.bindings[rsp.DepositAddress] = skyAddr
+	clk.Advance(time.Hour)
+	w = status("link=" + url.QueryEscape(token))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Contains(t, w.Body.String(), errInvalidDeepLink.Error())
+}
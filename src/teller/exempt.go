@@ -0,0 +1,152 @@
+package teller
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gz-c/tollbooth/libstring"
+	"github.com/gz-c/tollbooth/limiter"
+
+	"github.com/skycoin/teller/src/config"
+)
+
+// Rate limit exemptions.
+// The clients of web.rate_limit.exempt, e.g. health checkers, internal dashboards and trusted partners, bypass the
+// rate limits and the abuse throttling. A client is exempt if its IP, looked up like for the rate limits, is in one
+// of the exempt networks, or if it sends an exempt API key in the X-Api-Key header.
+// The requests of each rate limited endpoint are counted, with the exempt ones apart, so that the metrics show
+// how much of an endpoint's traffic is limited.
+
+// rateLimitExemption matches the clients exempt from the rate limits
+type rateLimitExemption struct {
+	nets    []*net.IPNet
+	apiKeys [][]byte
+}
+
+// newRateLimitExemption creates a rateLimitExemption, or returns nil if no client is exempt
+func newRateLimitExemption(cfg config.RateLimitExempt) *rateLimitExemption {
+	if len(cfg.CIDRs) == 0 && len(cfg.APIKeys) == 0 {
+		return nil
+	}
+
+	// The CIDRs are validated
+	e := &rateLimitExemption{
+		nets: cfg.Networks(),
+	}
+
+	for _, key := range cfg.APIKeys {
+		e.apiKeys = append(e.apiKeys, []byte(key))
+	}
+
+	return e
+}
+
+// exempt returns true if the client of r, with IP ip, is exempt
+func (e *rateLimitExemption) exempt(ip string, r *http.Request) bool {
+	if e == nil {
+		return false
+	}
+
+	if apiKey := r.Header.Get(apiKeyHeader); apiKey != "" {
+		for _, k := range e.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(apiKey), k) == 1 {
+				return true
+			}
+		}
+	}
+
+	if hostIP := parseHostIP(ip); hostIP != nil {
+		for _, n := range e.nets {
+			if n.Contains(hostIP) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// exemptLimit wraps a handler to serve the requests of exempt clients with exempt, which isn't rate limited,
+// and the others with limited. Clients are identified like in ipLimit, with the IP lookups of lmt.
+func exemptLimit(e *rateLimitExemption, c *rateLimitCounter, lmt *limiter.Limiter, exempt, limited http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := libstring.RemoteIP(lmt.GetIPLookups(), lmt.GetForwardedForIndexFromBehind(), r)
+		if e.exempt(ip, r) {
+			atomic.AddUint64(&c.exempt, 1)
+			exempt.ServeHTTP(w, r)
+			return
+		}
+
+		atomic.AddUint64(&c.requests, 1)
+		limited.ServeHTTP(w, r)
+	})
+}
+
+// RateLimitStats counts the requests of a rate limited endpoint
+type RateLimitStats struct {
+	Path string
+	// Requests of the limited clients, including the refused ones
+	Requests uint64
+	// Requests refused by the rate limit
+	Limited uint64
+	// Requests of the exempt clients
+	Exempt uint64
+}
+
+// rateLimitCounter counts the requests of an endpoint
+type rateLimitCounter struct {
+	requests uint64
+	limited  uint64
+	exempt   uint64
+}
+
+// rateLimitCounters counts the requests of the rate limited endpoints
+type rateLimitCounters struct {
+	sync.Mutex
+	endpoints map[string]*rateLimitCounter
+}
+
+func newRateLimitCounters() *rateLimitCounters {
+	return &rateLimitCounters{
+		endpoints: make(map[string]*rateLimitCounter),
+	}
+}
+
+// endpoint returns the counter of path
+func (c *rateLimitCounters) endpoint(path string) *rateLimitCounter {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.endpoints[path]
+	if !ok {
+		e = &rateLimitCounter{}
+		c.endpoints[path] = e
+	}
+	return e
+}
+
+// stats returns the counts of each endpoint, ordered by path
+func (c *rateLimitCounters) stats() []RateLimitStats {
+	c.Lock()
+	defer c.Unlock()
+
+	stats := make([]RateLimitStats, 0, len(c.endpoints))
+	for path, e := range c.endpoints {
+		stats = append(stats, RateLimitStats{
+			Path:     path,
+			Requests: atomic.LoadUint64(&e.requests),
+			Limited:  atomic.LoadUint64(&e.limited),
+			Exempt:   atomic.LoadUint64(&e.exempt),
+		})
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Path < stats[j].Path
+	})
+
+	return stats
+}
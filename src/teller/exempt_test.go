@@ -0,0 +1,96 @@
+package teller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestRateLimitExemption(t *testing.T) {
+	require.Nil(t, newRateLimitExemption(config.RateLimitExempt{}))
+
+	e := newRateLimitExemption(config.RateLimitExempt{
+		CIDRs:   []string{"10.0.0.0/8", "2001:db8::/32", "1.2.3.4"},
+		APIKeys: []string{"monitor-key"},
+	})
+
+	exempt := func(ip, apiKey string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		return e.exempt(ip, req)
+	}
+
+	require.True(t, exempt("10.1.2.3", ""))
+	require.True(t, exempt("[2001:db8::1]:5000", ""))
+	require.True(t, exempt("::ffff:10.0.0.1", ""))
+	require.True(t, exempt("1.2.3.4:5000", ""))
+	require.False(t, exempt("1.2.3.5", ""))
+	require.False(t, exempt("", ""))
+	require.True(t, exempt("8.8.8.8", "monitor-key"))
+	require.False(t, exempt("8.8.8.8", "other-key"))
+}
+
+func TestRateLimitExemptRequests(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			ThrottleMax:      1,
+			ThrottleDuration: time.Hour,
+			RateLimit: config.RateLimit{
+				Exempt: config.RateLimitExempt{
+					CIDRs:   []string{"10.0.0.0/8"},
+					APIKeys: []string{"monitor-key"},
+				},
+			},
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}, &Service{}, nil, clock.Real{})
+	mux := s.setupMux()
+
+	// The handler refuses the method, so that a request which isn't rate limited gets a 405
+	do := func(remoteAddr, apiKey string) int {
+		req := httptest.NewRequest(http.MethodPut, "/api/qr", nil)
+		req.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			req.Header.Set(apiKeyHeader, apiKey)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusMethodNotAllowed, do("1.2.3.4:5000", ""))
+	require.Equal(t, http.StatusTooManyRequests, do("1.2.3.4:5000", ""))
+
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusMethodNotAllowed, do("10.0.0.1:5000", ""))
+		require.Equal(t, http.StatusMethodNotAllowed, do("1.2.3.4:5000", "monitor-key"))
+	}
+
+	require.Equal(t, http.StatusTooManyRequests, do("1.2.3.4:5000", "other-key"))
+
+	var stats RateLimitStats
+	for _, st := range s.rateLimits.stats() {
+		if st.Path == "/api/qr" {
+			stats = st
+		}
+	}
+	require.Equal(t, RateLimitStats{
+		Path:     "/api/qr",
+		Requests: 3,
+		Limited:  2,
+		Exempt:   6,
+	}, stats)
+}
@@ -0,0 +1,76 @@
+package teller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// Health endpoints.
+// With web.health, the probes of a container runtime or load balancer can check teller at /healthz and /readyz.
+// They aren't rate limited or logged, since they are requested every few seconds, and /readyz reports that
+// teller is not ready once it begins to shut down, so that it is taken out of service before its listeners close.
+
+var errShuttingDown = errors.New("Teller is shutting down")
+
+// withLogger puts the server's logger in the request context, without logging the request
+func (s *HTTPServer) withLogger(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(logger.WithContext(r.Context(), s.log)))
+	})
+}
+
+// HealthResponse http response for /healthz and /readyz
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// HealthHandler reports that teller is running
+// Method: GET, HEAD
+// URI: /healthz
+func HealthHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet, http.MethodHead}) {
+			return
+		}
+
+		if err := httputil.JSONResponse(w, HealthResponse{
+			Status: "ok",
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// ReadyHandler reports whether teller is serving requests, 503 once it is shutting down
+// Method: GET, HEAD
+// URI: /readyz
+func ReadyHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet, http.MethodHead}) {
+			return
+		}
+
+		select {
+		case <-s.quit:
+			w.Header().Set(errCodeHeader, errCodeShuttingDown)
+			errorResponse(ctx, w, http.StatusServiceUnavailable, errShuttingDown)
+			return
+		default:
+		}
+
+		if err := httputil.JSONResponse(w, HealthResponse{
+			Status: "ok",
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
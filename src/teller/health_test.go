@@ -0,0 +1,63 @@
+package teller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			Health: true,
+		},
+	}, &Service{}, nil, clock.Real{})
+	mux := s.setupMux()
+
+	get := func(method, uri string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, uri, nil))
+		return w
+	}
+
+	for _, uri := range []string{"/healthz", "/readyz"} {
+		w := get(http.MethodGet, uri)
+		require.Equal(t, http.StatusOK, w.Code, uri)
+
+		var rsp HealthResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+		require.Equal(t, "ok", rsp.Status)
+
+		w = get(http.MethodHead, uri)
+		require.Equal(t, http.StatusOK, w.Code, uri)
+
+		w = get(http.MethodPost, uri)
+		require.Equal(t, http.StatusMethodNotAllowed, w.Code, uri)
+		require.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+	}
+
+	// Not ready once shutting down, but still alive
+	close(s.quit)
+
+	w := get(http.MethodGet, "/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, errCodeShuttingDown, w.Header().Get(errCodeHeader))
+
+	w = get(http.MethodGet, "/healthz")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The probes are only served with web.health
+	s = NewHTTPServer(log, config.Config{}, &Service{}, nil, clock.Real{})
+	w = httptest.NewRecorder()
+	s.setupMux().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.NotEqual(t, http.StatusOK, w.Code)
+}
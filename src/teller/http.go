@@ -2,20 +2,26 @@ package teller
 
 import (
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 
 	"time"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
 	"github.com/gz-c/tollbooth"
 	"github.com/rs/cors"
+	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
 	"github.com/unrolled/secure"
 	"golang.org/x/crypto/acme/autocert"
@@ -23,12 +29,23 @@ import (
 	"github.com/skycoin/skycoin/src/cipher"
 	"github.com/skycoin/skycoin/src/util/droplet"
 
+	"github.com/skycoin/teller/src/abuse"
 	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/capture"
 	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/deposits"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/explorer"
+	"github.com/skycoin/teller/src/notify"
+	"github.com/skycoin/teller/src/receipt"
 	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/errutil"
+	"github.com/skycoin/teller/src/util/flagutil"
 	"github.com/skycoin/teller/src/util/httputil"
 	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/qrcode"
+	"github.com/skycoin/teller/src/version"
 )
 
 const (
@@ -41,12 +58,82 @@ const (
 	serverWriteTimeout = time.Second * 60
 	serverIdleTimeout  = time.Second * 120
 
-	// Directory where cached SSL certs from Let's Encrypt are stored
-	tlsAutoCertCache = "cert-cache"
+	// walletOrigin is the origin of a local skycoin wallet, which is allowed to call the API
+	walletOrigin = "http://127.0.0.1:6420"
 )
 
+const (
+	// errCodeHeader carries a machine readable error code on some error responses
+	errCodeHeader = "X-Error-Code"
+	// errCodeDepleted is sent when the deposit address pool of the requested coin type is empty
+	errCodeDepleted = "depleted"
+	// errCodeBusy is sent when a bind request couldn't get a deposit address in time due to contention
+	errCodeBusy = "busy"
+	// bindRetryAfter is the Retry-After seconds sent with errCodeBusy and errCodeHandover,
+	// and with other 429 and 503 service errors
+	bindRetryAfter = "1"
+	// errCodeNotStarted is sent when binding is not open to the requester yet
+	errCodeNotStarted = "not_started"
+	// errCodeHandover is sent when binding is paused for a handover to a new teller instance
+	errCodeHandover = "handover"
+	// errCodeEnded is sent when binding is refused by an archived teller, see config.Archive
+	errCodeEnded = "ended"
+	// errCodeCapReached is sent when binding is refused because the campaign cap was reached
+	errCodeCapReached = "cap_reached"
+	// errCodeMaintenance is sent when an operator paused binding from the admin API
+	errCodeMaintenance = "maintenance"
+	// maintenanceRetryAfter is the Retry-After seconds sent with errCodeMaintenance and errCodeClockSkew
+	maintenanceRetryAfter = "60"
+	// errCodeClockSkew is sent when a time-sensitive request is refused while the server clock is skewed,
+	// see config.ClockSkew
+	errCodeClockSkew = "clock_skew"
+	// depletedRetryAfter is the Retry-After seconds sent with errCodeDepleted, for the pool to be refilled
+	depletedRetryAfter = "300"
+	// errCodeRateLimited is sent when a client exceeded the request rate limit
+	errCodeRateLimited = "rate_limited"
+	// errCodeCaptchaRequired is sent when a throttled client can solve a captcha instead of
+	// waiting, by sending its solution in captchaTokenHeader
+	errCodeCaptchaRequired = "captcha_required"
+	// errCodeDirectOrigin is sent when a request which didn't come through the CDN is refused, see config.CDN
+	errCodeDirectOrigin = "direct_origin"
+	// errCodeFeatureDisabled is sent when a request is refused because its feature flag is disabled, see config.FeatureFlags
+	errCodeFeatureDisabled = "feature_disabled"
+	// errCodeShuttingDown is sent by /readyz once teller is shutting down
+	errCodeShuttingDown = "shutting_down"
+	// captchaTokenHeader carries a captcha solution of a client throttled by the abuse detection
+	captchaTokenHeader = "X-Captcha-Token"
+	// apiKeyHeader carries an allowlisted API key on bind requests
+	apiKeyHeader = "X-Api-Key"
+)
+
+const (
+	// LaunchPhaseClosed is the launch phase before teller.start_at when nothing is allowlisted
+	LaunchPhaseClosed = "closed"
+	// LaunchPhaseAllowlist is the launch phase before teller.start_at when only allowlisted
+	// skycoin addresses and API keys can bind
+	LaunchPhaseAllowlist = "allowlist"
+	// LaunchPhasePublic is the launch phase once anyone can bind
+	LaunchPhasePublic = "public"
+	// LaunchPhaseEnded is the launch phase of an archived teller, which only serves the status of the event
+	LaunchPhaseEnded = "ended"
+)
+
+const (
+	qrFormatPNG    = "png"
+	qrFormatSVG    = "svg"
+	qrDefaultScale = 8
+	qrMaxScale     = 32
+	// btcDecimals is the number of decimal places of a BTC amount
+	btcDecimals = 8
+)
+
+// coinTypeSKY is the coin type of skycoin payout addresses
+const coinTypeSKY = "SKY"
+
 var (
 	errInternalServerError = errors.New("Internal Server Error")
+	errEventEnded          = errors.New("The event has ended")
+	errClockSkewed         = errors.New("Paused while the server clock is skewed, try again later")
 )
 
 // HTTPServer exposes the API endpoints and static website
@@ -54,22 +141,128 @@ type HTTPServer struct {
 	cfg           config.Config
 	log           logrus.FieldLogger
 	service       *Service
-	httpListener  *http.Server
-	httpsListener *http.Server
+	certCache     CertCache
+	listeners     *listenerSet // servers of the web listen addresses, which can be reloaded
+	launch        launchGate
+	widget        *widgetGate
+	deepLinks     *deepLinks // signed links of the bindings, nil if disabled
+	stats         *statsStream
+	abuse         *abuse.Guard
+	statusWaiting int32             // number of requests held by /api/status/wait
+	statusStreams int32             // number of connections open on /api/ws
+	clock         clock.Clock       // time of the launch gate, widget sessions, cancel requests and rate limits
+	skew          *clock.SkewGuard  // pauses the users of clock while it is skewed, nil if disabled
+	capture       *capture.Recorder // records the requests of a capture session, nil if disabled
+	explorer      explorer.Links    // explorer links of the status page
+	receipts      *receipt.Service  // receipts of the completed deposits, nil if disabled
+	scanners      ScannerRegistry   // scanners of the coin types which can be bound
+	features      *flagutil.Registry
+	exempt        *rateLimitExemption // clients which bypass the rate limits, nil if none
+	rateLimits    *rateLimitCounters  // requests of the rate limited endpoints
 	quit          chan struct{}
 	done          chan struct{}
 }
 
+// launchGate restricts binding to an allowlist until the start time
+type launchGate struct {
+	startAt   time.Time
+	allowlist map[string]struct{}
+	apiKeys   [][]byte
+}
+
+func newLaunchGate(cfg config.Teller) launchGate {
+	g := launchGate{
+		startAt:   cfg.StartTime(),
+		allowlist: make(map[string]struct{}, len(cfg.Allowlist)),
+	}
+
+	for _, addr := range cfg.Allowlist {
+		g.allowlist[addr] = struct{}{}
+	}
+
+	for _, key := range cfg.AllowlistAPIKeys {
+		g.apiKeys = append(g.apiKeys, []byte(key))
+	}
+
+	return g
+}
+
+// phase returns the launch phase at time now
+func (g launchGate) phase(now time.Time) string {
+	switch {
+	case g.startAt.IsZero() || !now.Before(g.startAt):
+		return LaunchPhasePublic
+	case len(g.allowlist) == 0 && len(g.apiKeys) == 0:
+		return LaunchPhaseClosed
+	default:
+		return LaunchPhaseAllowlist
+	}
+}
+
+// canBind returns true if skyAddr can be bound at time now by a request with apiKey
+func (g launchGate) canBind(now time.Time, skyAddr, apiKey string) bool {
+	if g.phase(now) == LaunchPhasePublic {
+		return true
+	}
+
+	if _, ok := g.allowlist[skyAddr]; ok {
+		return true
+	}
+
+	if apiKey == "" {
+		return false
+	}
+
+	for _, k := range g.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(apiKey), k) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// launchTime returns the time of the launch gate. While the clock is skewed, the gate stays at the
+// last time the clock was found right, so that a skewed clock can't open binding early.
+func (s *HTTPServer) launchTime() time.Time {
+	now := s.clock.Now()
+	status := s.skew.Status()
+	if status.Skewed && status.SyncedAt.Before(now) {
+		return status.SyncedAt
+	}
+	return now
+}
+
+// clockSkewResponse refuses a time-sensitive request while the clock is skewed
+func clockSkewResponse(ctx context.Context, w http.ResponseWriter) {
+	w.Header().Set(errCodeHeader, errCodeClockSkew)
+	w.Header().Set("Retry-After", maintenanceRetryAfter)
+	errorResponse(ctx, w, http.StatusServiceUnavailable, errClockSkewed)
+}
+
 // NewHTTPServer creates an HTTPServer
-func NewHTTPServer(log logrus.FieldLogger, cfg config.Config, service *Service) *HTTPServer {
+func NewHTTPServer(log logrus.FieldLogger, cfg config.Config, service *Service, certCache CertCache, clk clock.Clock) *HTTPServer {
 	return &HTTPServer{
-		cfg: cfg.Redacted(),
+		cfg:       cfg.Redacted(),
+		launch:    newLaunchGate(cfg.Teller),
+		widget:    newWidgetGate(cfg.Widget),
+		deepLinks: newDeepLinks(cfg.DeepLinks),
+		stats:     newStatsStream(cfg.Stats),
+		clock:     clk,
+		// The explorer config is validated
+		explorer:   cfg.Explorer.Links(cfg.Payout),
+		scanners:   configCoinTypes(cfg),
+		exempt:     newRateLimitExemption(cfg.Web.RateLimit.Exempt),
+		rateLimits: newRateLimitCounters(),
+		features:   flagutil.NewRegistry(config.FeatureFlagDefaults, cfg.FeatureFlags),
 		log: log.WithFields(logrus.Fields{
 			"prefix": "teller.http",
 		}),
-		service: service,
-		quit:    make(chan struct{}),
-		done:    make(chan struct{}),
+		service:   service,
+		certCache: certCache,
+		listeners: newListenerSet(log.WithField("prefix", "teller.http")),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
 	}
 }
 
@@ -82,20 +275,93 @@ func (s *HTTPServer) Run() error {
 
 	var mux http.Handler = s.setupMux()
 
+	if s.cfg.Web.CDN.Enabled {
+		log.WithFields(logrus.Fields{
+			"clientIPHeader": s.cfg.Web.CDN.ClientIPHeader,
+			"rejectDirect":   s.cfg.Web.CDN.RejectDirect,
+		}).Info("Serving behind a CDN")
+	}
+
+	tlsConfig := &tls.Config{
+		GetCertificate: s.listeners.getCertificate,
+	}
+
+	if s.cfg.Web.AutoTLSHost != "" {
+		log.Info("Using Let's Encrypt autocert")
+		if s.certCache == nil {
+			return errors.New("web.auto_tls_host is set but no autocert cache was configured")
+		}
+
+		// https://godoc.org/golang.org/x/crypto/acme/autocert
+		// https://stackoverflow.com/a/40494806
+		certManager := autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.cfg.Web.AutoTLSHost),
+			Cache:      s.certCache,
+		}
+
+		go s.cleanCertCacheLoop()
+
+		tlsConfig.GetCertificate = certManager.GetCertificate
+	}
+
+	// Verify the client certificates of the CDN. Other clients may connect without one,
+	// web.cdn.reject_direct refuses their requests.
+	if s.cfg.Web.CDN.Enabled && s.cfg.Web.CDN.ClientCA != "" {
+		pool, err := loadCertPool(s.cfg.Web.CDN.ClientCA)
+		if err != nil {
+			return fmt.Errorf("load web.cdn.client_ca failed: %v", err)
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	newHandler := func(web config.Web) http.Handler {
+		return s.webHandler(mux, web)
+	}
+
+	if err := s.listeners.start(s.cfg.Web, tlsConfig, newHandler); err != nil {
+		select {
+		case <-s.quit:
+			return nil
+		default:
+			log.WithError(err).Error("ListenAndServe or ListenAndServeTLS error")
+			return fmt.Errorf("http serve failed: %v", err)
+		}
+	}
+
+	select {
+	case err := <-s.listeners.errC:
+		select {
+		case <-s.quit:
+			return nil
+		default:
+			return fmt.Errorf("http serve failed: %v", err)
+		}
+	case <-s.quit:
+		return nil
+	}
+}
+
+// webHandler wraps mux in the security headers and CDN verification, which depend on the listen addresses of web
+func (s *HTTPServer) webHandler(mux http.Handler, web config.Web) http.Handler {
+	log := s.log
+
 	allowedHosts := []string{} // empty array means all hosts allowed
 	sslHost := ""
-	if s.cfg.Web.AutoTLSHost == "" {
+	if web.AutoTLSHost == "" {
 		// Note: if AutoTLSHost is not set, but HTTPSAddr is set, then
 		// http will redirect to the HTTPSAddr listening IP, which would be
 		// either 127.0.0.1 or 0.0.0.0
 		// When running behind a DNS name, make sure to set AutoTLSHost
-		sslHost = s.cfg.Web.HTTPSAddr
+		sslHost = web.HTTPSAddr
 	} else {
-		sslHost = s.cfg.Web.AutoTLSHost
+		sslHost = web.AutoTLSHost
 		// When using -auto-tls-host,
 		// which implies automatic Let's Encrypt SSL cert generation in production,
 		// restrict allowed hosts to that host.
-		allowedHosts = []string{s.cfg.Web.AutoTLSHost}
+		allowedHosts = []string{web.AutoTLSHost}
 	}
 
 	if len(allowedHosts) == 0 {
@@ -106,110 +372,63 @@ func (s *HTTPServer) Run() error {
 
 	log = log.WithField("sslHost", sslHost)
 
+	var frameAncestors string
+	if s.widget != nil {
+		frameAncestors = s.widget.frameAncestors()
+		log = log.WithField("frameAncestors", frameAncestors)
+	}
+
 	log.Info("Configured")
 
-	secureMiddleware := configureSecureMiddleware(sslHost, allowedHosts)
-	mux = secureMiddleware.Handler(mux)
+	secureMiddleware := configureSecureMiddleware(sslHost, allowedHosts, frameAncestors)
+	h := secureMiddleware.Handler(mux)
 
-	if s.cfg.Web.HTTPAddr != "" {
-		s.httpListener = setupHTTPListener(s.cfg.Web.HTTPAddr, mux)
+	if web.CDN.Enabled {
+		h = cdnHandler(web.CDN, h)
 	}
 
-	handleListenErr := func(f func() error) error {
-		if err := f(); err != nil {
-			select {
-			case <-s.quit:
-				return nil
-			default:
-				log.WithError(err).Error("ListenAndServe or ListenAndServeTLS error")
-				return fmt.Errorf("http serve failed: %v", err)
-			}
-		}
-		return nil
-	}
+	return h
+}
 
-	if s.cfg.Web.HTTPAddr != "" {
-		log.Info(fmt.Sprintf("HTTP server listening on http://%s", s.cfg.Web.HTTPAddr))
+// Reload changes the listen addresses and TLS certificate of the running server to those of web.
+// The listeners of the addresses which changed are replaced once the new addresses are listened on,
+// and the requests in flight on the old listeners finish. The other web settings need a restart.
+func (s *HTTPServer) Reload(web config.Web) error {
+	if err := s.listeners.reload(web); err != nil {
+		return err
 	}
-	if s.cfg.Web.HTTPSAddr != "" {
-		log.Info(fmt.Sprintf("HTTPS server listening on https://%s", s.cfg.Web.HTTPSAddr))
-	}
-
-	var tlsCert, tlsKey string
-	if s.cfg.Web.HTTPSAddr != "" {
-		log.Info("Using TLS")
-
-		s.httpsListener = setupHTTPListener(s.cfg.Web.HTTPSAddr, mux)
-
-		tlsCert = s.cfg.Web.TLSCert
-		tlsKey = s.cfg.Web.TLSKey
-
-		if s.cfg.Web.AutoTLSHost != "" {
-			log.Info("Using Let's Encrypt autocert")
-			// https://godoc.org/golang.org/x/crypto/acme/autocert
-			// https://stackoverflow.com/a/40494806
-			certManager := autocert.Manager{
-				Prompt:     autocert.AcceptTOS,
-				HostPolicy: autocert.HostWhitelist(s.cfg.Web.AutoTLSHost),
-				Cache:      autocert.DirCache(tlsAutoCertCache),
-			}
-
-			s.httpsListener.TLSConfig = &tls.Config{
-				GetCertificate: certManager.GetCertificate,
-			}
-
-			// These will be autogenerated by the autocert middleware
-			tlsCert = ""
-			tlsKey = ""
-		}
 
-	}
+	s.log.Info("Reloaded the web listen config")
+	return nil
+}
 
-	return handleListenErr(func() error {
-		var wg sync.WaitGroup
-		errC := make(chan error)
+// cleanCertCacheLoop periodically removes expired certs from the autocert cache,
+// so that certs of hosts which are no longer served do not accumulate
+func (s *HTTPServer) cleanCertCacheLoop() {
+	log := s.log.WithField("goroutine", "cleanCertCache")
 
-		if s.cfg.Web.HTTPAddr != "" {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if err := s.httpListener.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-					log.WithError(err).Println("ListenAndServe error")
-					errC <- err
-				}
-			}()
-		}
+	ticker := time.NewTicker(tlsAutoCertCacheCleanupInterval)
+	defer ticker.Stop()
 
-		if s.cfg.Web.HTTPSAddr != "" {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if err := s.httpsListener.ListenAndServeTLS(tlsCert, tlsKey); err != nil && err != http.ErrServerClosed {
-					log.WithError(err).Error("ListenAndServeTLS error")
-					errC <- err
-				}
-			}()
+	for {
+		removed, err := cleanCertCache(context.Background(), s.certCache, time.Now())
+		if err != nil {
+			log.WithError(err).Error("cleanCertCache failed")
+		} else if len(removed) != 0 {
+			log.WithField("keys", removed).Info("Removed expired certs from autocert cache")
 		}
 
-		done := make(chan struct{})
-
-		go func() {
-			wg.Wait()
-			close(done)
-		}()
-
 		select {
-		case err := <-errC:
-			return err
 		case <-s.quit:
-			return nil
-		case <-done:
-			return nil
+			return
+		case <-ticker.C:
 		}
-	})
+	}
 }
 
-func configureSecureMiddleware(sslHost string, allowedHosts []string) *secure.Secure {
+// configureSecureMiddleware configures the security headers. If frameAncestors is set,
+// it is sent as the CSP instead of denying use in iframes, see widget.go.
+func configureSecureMiddleware(sslHost string, allowedHosts []string, frameAncestors string) *secure.Secure {
 	sslRedirect := true
 	if sslHost == "" {
 		sslRedirect = false
@@ -223,6 +442,9 @@ func configureSecureMiddleware(sslHost string, allowedHosts []string) *secure.Se
 		// https://developer.mozilla.org/en-US/docs/Web/HTTP/CSP
 		// FIXME: Web frontend code has inline styles, CSP doesn't work yet
 		// ContentSecurityPolicy: "default-src 'self'",
+		// frame-ancestors doesn't restrict the page itself, only which pages can embed it
+		// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Content-Security-Policy/frame-ancestors
+		ContentSecurityPolicy: frameAncestors,
 
 		// Set HSTS to one year, for this domain only, do not add to chrome preload list
 		// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Strict-Transport-Security
@@ -230,9 +452,10 @@ func configureSecureMiddleware(sslHost string, allowedHosts []string) *secure.Se
 		STSIncludeSubdomains: false,
 		STSPreload:           false,
 
-		// Deny use in iframes
+		// Deny use in iframes, unless embedding is allowed by frameAncestors.
+		// X-Frame-Options can't allow multiple origins, so it is not sent then.
 		// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Frame-Options
-		FrameDeny: true,
+		FrameDeny: frameAncestors == "",
 
 		// Disable MIME sniffing in browsers
 		// https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Content-Type-Options
@@ -257,35 +480,142 @@ func setupHTTPListener(addr string, handler http.Handler) *http.Server {
 	}
 }
 
+// listenNetwork returns the network to listen on addr with.
+// An IPv4 host listens on IPv4 only, e.g. "0.0.0.0:7071", and an IPv6 host
+// listens on IPv6 only, e.g. "[::]:7071". An empty host, e.g. ":7071", or a hostname
+// listens on both.
+func listenNetwork(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "tcp"
+	}
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return "tcp"
+	case ip.To4() != nil:
+		return "tcp4"
+	default:
+		return "tcp6"
+	}
+}
+
+// VersionResponse http response for /api/version
+type VersionResponse struct {
+	version.Info
+	CoinTypes []string `json:"coin_types"`
+}
+
+// VersionHandler returns the build of teller and the coin types it accepts
+// Method: GET
+// URI: /api/version
+func VersionHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		if err := httputil.JSONResponse(w, VersionResponse{
+			Info:      version.Get(),
+			CoinTypes: s.enabledCoinTypes(),
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
 func (s *HTTPServer) setupMux() *http.ServeMux {
 	mux := http.NewServeMux()
 
-	ratelimit := func(h http.Handler) http.Handler {
-		limiter := tollbooth.NewLimiter(s.cfg.Web.ThrottleMax, s.cfg.Web.ThrottleDuration, nil)
+	ratelimit := func(path string, h http.Handler) http.Handler {
+		rule := endpointRateLimit(s.cfg.Web, path)
+		limiter := tollbooth.NewLimiter(rule.max, rule.duration, nil)
 		if s.cfg.Web.BehindProxy {
 			limiter.SetIPLookups([]string{"X-Forwarded-For", "RemoteAddr", "X-Real-IP"})
 		}
-		return tollbooth.LimitHandler(limiter, h)
+
+		counter := s.rateLimits.endpoint(path)
+		limiter.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddUint64(&counter.limited, 1)
+		})
+
+		limited := keyLimit(newKeyLimiter(rule, limiter), limiter, s.cfg.Web.ThrottleIPv6Prefix, s.clock, h)
+		if s.abuse != nil {
+			limited = abuseLimit(s.abuse, limiter, s.cfg.Web.ThrottleIPv6Prefix, limited)
+		}
+		return exemptLimit(s.exempt, counter, limiter, h, limited)
 	}
 
 	handleAPI := func(path string, h http.Handler) {
 		// Allow requests from a local skycoin wallet
 		h = cors.New(cors.Options{
-			AllowedOrigins: []string{"http://127.0.0.1:6420"},
+			AllowedOrigins: []string{walletOrigin},
 		}).Handler(h)
 
+		// Recorded before compression, with the CORS headers
+		h = s.capture.Handler(h)
+
+		// Recorded decompressed
+		h = s.decompressBody(h)
+
 		h = gziphandler.GzipHandler(h)
 
 		mux.Handle(path, h)
 	}
 
+	// Rate limit requests from embedded widgets per widget session
+	widgetLimit := func(h http.Handler) http.Handler {
+		if s.widget == nil {
+			return h
+		}
+		return s.widget.limit(h, s.clock)
+	}
+
 	// API Methods
-	handleAPI("/api/bind", ratelimit(httputil.LogHandler(s.log, BindHandler(s))))
-	handleAPI("/api/status", ratelimit(httputil.LogHandler(s.log, StatusHandler(s))))
+	handleAPI("/api/bind", ratelimit("/api/bind", httputil.LogHandler(s.log, widgetLimit(BindHandler(s)))))
+	handleAPI("/api/status", ratelimit("/api/status", httputil.LogHandler(s.log, widgetLimit(StatusHandler(s)))))
+	handleAPI("/api/status/wait", ratelimit("/api/status/wait", httputil.LogHandler(s.log, widgetLimit(StatusWaitHandler(s)))))
+
+	// Not gzipped nor wrapped by CORS, since the connection is hijacked by the websocket
+	mux.Handle("/api/ws", ratelimit("/api/ws", httputil.LogHandler(s.log, StatusWSHandler(s))))
+
 	handleAPI("/api/config", ConfigHandler(s))
+	handleAPI("/api/version", VersionHandler(s))
+	handleAPI("/api/rates/history", ratelimit("/api/rates/history", httputil.LogHandler(s.log, RateHistoryHandler(s))))
+	handleAPI("/api/public-status", PublicStatusHandler(s))
+	handleAPI("/api/qr", ratelimit("/api/qr", httputil.LogHandler(s.log, QRHandler(s))))
+	handleAPI("/api/verify-address", ratelimit("/api/verify-address", httputil.LogHandler(s.log, VerifyAddressHandler(s))))
+	handleAPI("/api/contact/erase", ratelimit("/api/contact/erase", httputil.LogHandler(s.log, EraseContactHandler(s))))
+	handleAPI("/api/receipt", ratelimit("/api/receipt", httputil.LogHandler(s.log, ReceiptHandler(s))))
+
+	if s.cfg.PayoutLog.Enabled {
+		handleAPI("/api/payouts/log", ratelimit("/api/payouts/log", httputil.LogHandler(s.log, PayoutLogHandler(s))))
+	}
+
+	handleAPI("/api/stats", ratelimit("/api/stats", httputil.LogHandler(s.log, StatsHandler(s))))
+
+	// Not gzipped, since the gzip writer buffers the events
+	mux.Handle("/api/stats/stream", ratelimit("/api/stats/stream", httputil.LogHandler(s.log, StatsStreamHandler(s))))
+
+	// Widget session tokens are requested by partner pages, so only partner origins are allowed
+	if s.widget != nil {
+		mux.Handle("/api/widget/session", s.widget.widgetCORS(gziphandler.GzipHandler(s.capture.Handler(ratelimit("/api/widget/session", httputil.LogHandler(s.log, WidgetSessionHandler(s)))))))
+	}
+
+	if s.cfg.Web.Health {
+		mux.Handle("/healthz", s.withLogger(HealthHandler(s)))
+		mux.Handle("/readyz", s.withLogger(ReadyHandler(s)))
+	}
 
 	// Static files
-	mux.Handle("/", gziphandler.GzipHandler(http.FileServer(http.Dir(s.cfg.Web.StaticDir))))
+	mux.Handle("/", gziphandler.GzipHandler(staticHandler(s.cfg.Web)))
 
 	return mux
 }
@@ -296,33 +626,7 @@ func (s *HTTPServer) Shutdown() {
 	defer s.log.Info("Shutdown HTTP server(s)")
 	close(s.quit)
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	shutdown := func(proto string, ln *http.Server) {
-		defer wg.Done()
-		if ln == nil {
-			return
-		}
-		log := s.log.WithFields(logrus.Fields{
-			"proto":   proto,
-			"timeout": shutdownTimeout,
-		})
-
-		defer log.Info("Shutdown server")
-		log.Info("Shutting down server")
-
-		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
-		defer cancel()
-		if err := ln.Shutdown(ctx); err != nil {
-			log.WithError(err).Error("HTTP server shutdown error")
-		}
-	}
-
-	shutdown("HTTP", s.httpListener)
-	shutdown("HTTPS", s.httpsListener)
-
-	wg.Wait()
+	s.listeners.shutdown()
 
 	<-s.done
 }
@@ -331,19 +635,91 @@ func (s *HTTPServer) Shutdown() {
 type BindResponse struct {
 	DepositAddress string `json:"deposit_address,omitempty"`
 	CoinType       string `json:"coin_type,omitempty"`
+	// The rate when the address was bound, in skycoin per whole coin as sky_btc_exchange_rate of /api/config.
+	// The rate isn't locked, a deposit is converted at the rate when it is received.
+	// The quote is omitted if the rate couldn't be quoted.
+	SkyExchangeRate string `json:"sky_exchange_rate,omitempty"`
+	// Skycoin sent for common deposit amounts at SkyExchangeRate
+	Estimates []BindEstimate `json:"estimates,omitempty"`
+	// When the rate was quoted, as a unix timestamp
+	QuotedAt int64 `json:"quoted_at,omitempty"`
+	// Signed link of the binding, to resume the bind flow on another device, if deep links are enabled.
+	// It is omitted while the server clock is skewed.
+	DeepLink string `json:"deep_link,omitempty"`
+	// When the deep link expires, as a unix timestamp
+	DeepLinkExpiresAt int64 `json:"deep_link_expires_at,omitempty"`
+}
+
+// BindEstimate is the skycoin sent for a deposit amount
+type BindEstimate struct {
+	// Amount of coin_type, in whole coins
+	Amount string `json:"amount"`
+	Sky    string `json:"sky"`
+}
+
+// bindEstimateAmounts are the deposit amounts, in whole coins, of the estimates of BindResponse
+var bindEstimateAmounts = map[string][]string{
+	scanner.CoinTypeBTC: {"0.001", "0.01", "0.1", "1"},
+	scanner.CoinTypeETH: {"0.01", "0.1", "1", "10"},
+}
+
+// quoteBind sets the rate and estimates of a BindResponse
+func quoteBind(rsp *BindResponse, rate string, maxDecimals int, now time.Time) error {
+	skyPer, err := skyPerCoin(rsp.CoinType, rate, maxDecimals)
+	if err != nil {
+		return err
+	}
+
+	estimates := make([]BindEstimate, 0, len(bindEstimateAmounts[rsp.CoinType]))
+	for _, a := range bindEstimateAmounts[rsp.CoinType] {
+		amount, err := decimal.NewFromString(a)
+		if err != nil {
+			return err
+		}
+
+		droplets, err := exchange.CalculateSkyValue(amount, rate, maxDecimals)
+		if err != nil {
+			return err
+		}
+
+		sky, err := droplet.ToString(droplets)
+		if err != nil {
+			return err
+		}
+
+		estimates = append(estimates, BindEstimate{
+			Amount: a,
+			Sky:    sky,
+		})
+	}
+
+	rsp.SkyExchangeRate = skyPer
+	rsp.Estimates = estimates
+	rsp.QuotedAt = now.Unix()
+	return nil
 }
 
 type bindRequest struct {
 	SkyAddr  string `json:"skyaddr"`
 	CoinType string `json:"coin_type"`
+	Email    string `json:"email,omitempty"`
+	// Language of the contact emails, e.g. "pt-br"
+	Language string `json:"language,omitempty"`
+	// Address of coin_type where a deposit which isn't converted is refunded
+	RefundAddress string `json:"refund_address,omitempty"`
 }
 
-// BindHandler binds skycoin address with a bitcoin address
-// Method: POST
+// BindHandler binds skycoin address with a bitcoin address.
+// A DELETE request cancels a binding, see CancelBindHandler.
+// Method: POST, DELETE
 // Accept: application/json
 // URI: /api/bind
 // Args:
-//    {"skyaddr": "...", "coin_type": "BTC"}
+//
+//	{"skyaddr": "...", "coin_type": "BTC", "email": "...", "language": "en", "refund_address": "..."}
+//	email is optional, and ignored if contact emails are disabled
+//	language is optional, the language of the emails. The default language is used if there are no templates in it.
+//	refund_address is optional, a mainnet address of coin_type
 func BindHandler(s *HTTPServer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -351,28 +727,53 @@ func BindHandler(s *HTTPServer) http.HandlerFunc {
 
 		w.Header().Set("Accept", "application/json")
 
-		if !validMethod(ctx, w, r, []string{http.MethodPost}) {
+		if !validMethod(ctx, w, r, []string{http.MethodPost, http.MethodDelete}) {
 			return
 		}
 
-		if r.Header.Get("Content-Type") != "application/json" {
-			errorResponse(ctx, w, http.StatusUnsupportedMediaType, errors.New("Invalid content type"))
+		// An archive can't change the bindings
+		if s.cfg.Archive.Enabled {
+			w.Header().Set(errCodeHeader, errCodeEnded)
+			errorResponse(ctx, w, http.StatusGone, errEventEnded)
+			return
+		}
+
+		if r.Method == http.MethodDelete {
+			CancelBindHandler(s)(w, r)
+			return
+		}
+
+		if !s.validJSONContentType(ctx, w, r) {
 			return
 		}
 
 		bindReq := &bindRequest{}
 		decoder := json.NewDecoder(r.Body)
 		if err := decoder.Decode(&bindReq); err != nil {
+			code := requestBodyStatus(err)
 			err = fmt.Errorf("Invalid json request body: %v", err)
-			errorResponse(ctx, w, http.StatusBadRequest, err)
+			errorResponse(ctx, w, code, err)
 			return
 		}
 		defer r.Body.Close()
 
 		// Remove extraneous whitespace
 		bindReq.SkyAddr = strings.Trim(bindReq.SkyAddr, "\n\t ")
+		bindReq.Email = strings.TrimSpace(bindReq.Email)
+		bindReq.Language = strings.TrimSpace(bindReq.Language)
+		bindReq.RefundAddress = strings.TrimSpace(bindReq.RefundAddress)
+
+		if !s.service.ContactsEnabled() {
+			bindReq.Email = ""
+		}
 
-		log = log.WithField("bindReq", bindReq)
+		// The email is not logged, it is only stored encrypted
+		logReq := *bindReq
+		if logReq.Email != "" {
+			logReq.Email = "<redacted>"
+		}
+
+		log = log.WithField("bindReq", logReq)
 		ctx = logger.WithContext(ctx, log)
 		r = r.WithContext(ctx)
 
@@ -386,22 +787,18 @@ func BindHandler(s *HTTPServer) http.HandlerFunc {
 			return
 		}
 
-		switch bindReq.CoinType {
-		case scanner.CoinTypeBTC:
-			if !s.cfg.BtcRPC.Enabled {
-				errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("%s not enabled", scanner.CoinTypeBTC))
-				return
-			}
-		case scanner.CoinTypeETH:
-			if !s.cfg.EthRPC.Enabled {
-				errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("%s not enabled", scanner.CoinTypeETH))
-				return
-			}
-		case "":
+		// The coin types with a registered scanner can be bound
+		switch {
+		case bindReq.CoinType == "":
 			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing coin_type"))
 			return
-		default:
-			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid coin_type"))
+		case !s.coinTypeEnabled(bindReq.CoinType):
+			errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("Invalid coin_type, must be one of: %s", strings.Join(s.enabledCoinTypes(), ", ")))
+			return
+		}
+
+		// A coin type without a feature flag can't be turned off
+		if flag, ok := bindFlags[bindReq.CoinType]; ok && !s.featureEnabled(ctx, w, flag) {
 			return
 		}
 
@@ -411,15 +808,59 @@ func BindHandler(s *HTTPServer) http.HandlerFunc {
 			return
 		}
 
+		if bindReq.Email != "" {
+			if err := notify.ValidateEmail(bindReq.Email); err != nil {
+				errorResponse(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+
+			if _, err := notify.NormalizeLanguage(bindReq.Language); err != nil {
+				errorResponse(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		if bindReq.RefundAddress != "" {
+			if err := exchange.ValidateRefundAddress(bindReq.CoinType, bindReq.RefundAddress); err != nil {
+				errorResponse(ctx, w, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		now := s.launchTime()
+		if !s.launch.canBind(now, bindReq.SkyAddr, r.Header.Get(apiKeyHeader)) {
+			if s.skew.Skewed() && s.launch.canBind(s.clock.Now(), bindReq.SkyAddr, r.Header.Get(apiKeyHeader)) {
+				clockSkewResponse(ctx, w)
+				return
+			}
+			w.Header().Set(errCodeHeader, errCodeNotStarted)
+			w.Header().Set("Retry-After", retryAfterSeconds(s.launch.startAt.Sub(now)))
+			errorResponse(ctx, w, http.StatusServiceUnavailable, errors.New("Binding has not started"))
+			return
+		}
+
 		log.Info("Calling service.BindAddress")
 
-		coinAddr, err := s.service.BindAddress(bindReq.SkyAddr, bindReq.CoinType)
+		coinAddr, err := s.service.BindAddress(bindReq.SkyAddr, bindReq.CoinType, bindReq.RefundAddress)
 		if err != nil {
 			log.WithError(err).Error("service.BindAddress failed")
-			if err != addrs.ErrDepositAddressEmpty && err != ErrMaxBoundAddresses {
-				err = errInternalServerError
+			switch err {
+			case addrs.ErrDepositAddressEmpty:
+				w.Header().Set(errCodeHeader, errCodeDepleted)
+				w.Header().Set("Retry-After", depletedRetryAfter)
+			case addrs.ErrAllocQueueFull, addrs.ErrAllocTimeout:
+				w.Header().Set(errCodeHeader, errCodeBusy)
+				w.Header().Set("Retry-After", bindRetryAfter)
+			case ErrQuiesced:
+				w.Header().Set(errCodeHeader, errCodeHandover)
+				w.Header().Set("Retry-After", bindRetryAfter)
+			case ErrMaintenance:
+				w.Header().Set(errCodeHeader, errCodeMaintenance)
+				w.Header().Set("Retry-After", maintenanceRetryAfter)
+			case exchange.ErrCampaignCapReached:
+				w.Header().Set(errCodeHeader, errCodeCapReached)
 			}
-			errorResponse(ctx, w, http.StatusInternalServerError, err)
+			serviceErrorResponse(ctx, w, err)
 			return
 		}
 
@@ -429,10 +870,35 @@ func BindHandler(s *HTTPServer) http.HandlerFunc {
 
 		log.Infof("Bound sky and %s addresses", bindReq.CoinType)
 
-		if err := httputil.JSONResponse(w, BindResponse{
+		// The binding is made, so a failure to save the email doesn't fail the request
+		if bindReq.Email != "" {
+			if err := s.service.AddContact(bindReq.SkyAddr, coinAddr, bindReq.CoinType, bindReq.Email, bindReq.Language); err != nil {
+				log.WithError(err).Error("service.AddContact failed")
+			}
+		}
+
+		rsp := BindResponse{
 			DepositAddress: coinAddr,
 			CoinType:       bindReq.CoinType,
-		}); err != nil {
+		}
+
+		// The binding is made, so the response is sent without a quote if the rate can't be quoted
+		if s.features.Enabled(config.FlagBindQuote) {
+			if rate, err := s.service.CurrentRate(bindReq.CoinType); err != nil {
+				log.WithError(err).Error("service.CurrentRate failed")
+			} else if err := quoteBind(&rsp, rate, s.cfg.SkyExchanger.MaxDecimals, s.clock.Now()); err != nil {
+				log.WithError(err).Error("quoteBind failed")
+			}
+		}
+
+		// The link would expire at the wrong time
+		if s.deepLinks != nil && !s.skew.Skewed() {
+			link, expiresAt := s.deepLinks.link(bindReq.SkyAddr, bindReq.CoinType, coinAddr, s.clock.Now())
+			rsp.DeepLink = link
+			rsp.DeepLinkExpiresAt = expiresAt.Unix()
+		}
+
+		if err := httputil.JSONResponse(w, rsp); err != nil {
 			log.WithError(err).Error(err)
 		}
 	}
@@ -440,14 +906,27 @@ func BindHandler(s *HTTPServer) http.HandlerFunc {
 
 // StatusResponse http response for /api/status
 type StatusResponse struct {
-	Statuses []exchange.DepositStatus `json:"statuses,omitempty"`
+	Statuses []DepositStatus `json:"statuses,omitempty"`
+	// Binding of the deep link, if the statuses were requested with one
+	Binding *DeepLinkBinding `json:"binding,omitempty"`
+}
+
+// DepositStatus is a deposit's status with the confirmations required by its coin type,
+// so that frontends can show the deposit's progress
+type DepositStatus struct {
+	exchange.DepositStatus
+	ConfirmationsRequired int64 `json:"confirmations_required"`
+	// How the deposit becomes final once it has the confirmations required, see ConfigResponse
+	Finality string `json:"finality"`
 }
 
-// StatusHandler returns the deposit status of specific skycoin address
+// StatusHandler returns the deposit status of specific skycoin address.
+// The status is an HTML page if the request prefers text/html, e.g. a status link opened in a browser.
 // Method: GET
 // URI: /api/status
 // Args:
 //     skyaddr
+//     link - a deep link token of /api/bind, instead of skyaddr. The response includes its binding.
 func StatusHandler(s *HTTPServer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -457,11 +936,23 @@ func StatusHandler(s *HTTPServer) http.HandlerFunc {
 			return
 		}
 
+		w.Header().Add("Vary", "Accept")
+
 		skyAddr := r.URL.Query().Get("skyaddr")
 
 		// Remove extraneous whitespace
 		skyAddr = strings.Trim(skyAddr, "\n\t ")
 
+		var binding *DeepLinkBinding
+		if token := r.URL.Query().Get(deepLinkParam); token != "" {
+			b, ok := s.verifyDeepLink(ctx, w, token, skyAddr)
+			if !ok {
+				return
+			}
+			binding = &b
+			skyAddr = b.SkyAddress
+		}
+
 		if skyAddr == "" {
 			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
 			return
@@ -487,7 +978,7 @@ func StatusHandler(s *HTTPServer) http.HandlerFunc {
 		depositStatuses, err := s.service.GetDepositStatuses(skyAddr)
 		if err != nil {
 			log.WithError(err).Error("service.GetDepositStatuses failed")
-			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			serviceErrorResponse(ctx, w, err)
 			return
 		}
 
@@ -500,8 +991,29 @@ func StatusHandler(s *HTTPServer) http.HandlerFunc {
 
 		log.Info("Got depositStatuses")
 
+		statuses := make([]DepositStatus, 0, len(depositStatuses))
+		for _, ds := range depositStatuses {
+			statuses = append(statuses, s.newDepositStatus(ds))
+		}
+
+		if acceptsHTML(r) {
+			page, err := renderStatusPage(s.cfg.Branding, skyAddr, s.explorer.PayoutAddress(skyAddr), statuses)
+			if err != nil {
+				log.WithError(err).Error("renderStatusPage failed")
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if _, err := w.Write(page); err != nil {
+				log.WithError(err).Error(err)
+			}
+			return
+		}
+
 		if err := httputil.JSONResponse(w, StatusResponse{
-			Statuses: depositStatuses,
+			Statuses: statuses,
+			Binding:  binding,
 		}); err != nil {
 			log.WithError(err).Error(err)
 		}
@@ -513,10 +1025,40 @@ type ConfigResponse struct {
 	Enabled                  bool   `json:"enabled"`
 	BtcConfirmationsRequired int64  `json:"btc_confirmations_required"`
 	EthConfirmationsRequired int64  `json:"eth_confirmations_required"`
+	BtcFinality              string `json:"btc_finality"`
+	EthFinality              string `json:"eth_finality"`
 	MaxBoundAddresses        int    `json:"max_bound_addrs"`
 	SkyBtcExchangeRate       string `json:"sky_btc_exchange_rate"`
 	SkyEthExchangeRate       string `json:"sky_eth_exchange_rate"`
 	MaxDecimals              int    `json:"max_decimals"`
+	EmailEnabled             bool   `json:"email_enabled"`
+	LaunchPhase              string `json:"launch_phase"`
+	StartAt                  string `json:"start_at,omitempty"`
+	// The coin paid out, and its fiber chain. The exchange rates are in this coin.
+	PayoutCoin  string `json:"payout_coin"`
+	PayoutChain string `json:"payout_chain"`
+	// Feature flags by name, so that the frontend hides the features which are off
+	Features map[string]bool `json:"features"`
+	// The operator's branding, its fields are empty unless configured
+	Branding ServiceBranding `json:"branding"`
+	// Coin types added by scanner plugins
+	PluginCoins []PluginCoinConfig `json:"plugin_coins"`
+}
+
+// PluginCoinConfig is the config of a coin type added by a scanner plugin
+type PluginCoinConfig struct {
+	CoinType              string `json:"coin_type"`
+	Decimals              int32  `json:"decimals"`
+	ConfirmationsRequired int64  `json:"confirmations_required"`
+	SkyExchangeRate       string `json:"sky_exchange_rate"`
+}
+
+// ServiceBranding is the operator's branding of the frontend
+type ServiceBranding struct {
+	ServiceName  string `json:"service_name,omitempty"`
+	SupportURL   string `json:"support_url,omitempty"`
+	LogoURL      string `json:"logo_url,omitempty"`
+	ContactEmail string `json:"contact_email,omitempty"`
 }
 
 // ConfigHandler returns the teller configuration
@@ -532,49 +1074,644 @@ func ConfigHandler(s *HTTPServer) http.HandlerFunc {
 		}
 
 		// Convert the exchange rate to a skycoin balance string
-		rate := s.cfg.SkyExchanger.SkyBtcExchangeRate
 		maxDecimals := s.cfg.SkyExchanger.MaxDecimals
-		dropletsPerBTC, err := exchange.CalculateBtcSkyValue(exchange.SatoshisPerBTC, rate, maxDecimals)
+		skyPerBTC, err := skyPerCoin(scanner.CoinTypeBTC, s.cfg.SkyExchanger.SkyBtcExchangeRate, maxDecimals)
 		if err != nil {
-			log.WithError(err).Error("exchange.CalculateBtcSkyValue failed")
+			log.WithError(err).Error("skyPerCoin failed")
 			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
 			return
 		}
 
-		skyPerBTC, err := droplet.ToString(dropletsPerBTC)
-		if err != nil {
-			log.WithError(err).Error("droplet.ToString failed")
-			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
-			return
-		}
-		rate = s.cfg.SkyExchanger.SkyEthExchangeRate
-		dropletsPerETH, err := exchange.CalculateEthSkyValue(big.NewInt(exchange.WeiPerETH), rate, maxDecimals)
+		skyPerETH, err := skyPerCoin(scanner.CoinTypeETH, s.cfg.SkyExchanger.SkyEthExchangeRate, maxDecimals)
 		if err != nil {
-			log.WithError(err).Error("exchange.CalculateEthSkyValue failed")
+			log.WithError(err).Error("skyPerCoin failed")
 			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
 			return
 		}
-		skyPerETH, err := droplet.ToString(dropletsPerETH)
-		if err != nil {
-			log.WithError(err).Error("droplet.ToString failed")
-			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
-			return
+
+		pluginCoins := make([]PluginCoinConfig, 0, len(s.cfg.PluginCoins))
+		for _, pc := range s.cfg.PluginCoins {
+			skyPer, err := skyPerCoin(pc.CoinType, pc.SkyExchangeRate, maxDecimals)
+			if err != nil {
+				log.WithError(err).Error("skyPerCoin failed")
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+				return
+			}
+
+			pluginCoins = append(pluginCoins, PluginCoinConfig{
+				CoinType:              pc.CoinType,
+				Decimals:              pc.Decimals,
+				ConfirmationsRequired: pc.ConfirmationsRequired,
+				SkyExchangeRate:       skyPer,
+			})
+		}
+
+		phase := s.launch.phase(s.launchTime())
+		if s.cfg.Archive.Enabled {
+			phase = LaunchPhaseEnded
 		}
 
 		if err := httputil.JSONResponse(w, ConfigResponse{
 			Enabled:                  s.cfg.Web.APIEnabled,
 			BtcConfirmationsRequired: s.cfg.BtcScanner.ConfirmationsRequired,
 			EthConfirmationsRequired: s.cfg.EthScanner.ConfirmationsRequired,
+			BtcFinality:              s.finality(scanner.CoinTypeBTC),
+			EthFinality:              s.finality(scanner.CoinTypeETH),
 			SkyBtcExchangeRate:       skyPerBTC,
 			SkyEthExchangeRate:       skyPerETH,
 			MaxDecimals:              maxDecimals,
 			MaxBoundAddresses:        s.cfg.Teller.MaxBoundAddresses,
+			EmailEnabled:             s.service.ContactsEnabled(),
+			LaunchPhase:              phase,
+			StartAt:                  s.cfg.Teller.StartAt,
+			PayoutCoin:               s.payoutCoin(),
+			PayoutChain:              s.cfg.Payout.Chain,
+			Features:                 s.features.Flags(),
+			Branding: ServiceBranding{
+				ServiceName:  s.cfg.Branding.ServiceName,
+				SupportURL:   s.cfg.Branding.SupportURL,
+				LogoURL:      s.cfg.Branding.LogoURL,
+				ContactEmail: s.cfg.Branding.ContactEmail,
+			},
+			PluginCoins: pluginCoins,
 		}); err != nil {
 			log.WithError(err).Error(err)
 		}
 	}
 }
 
+// skyPerCoin returns the skycoin sent per whole coin at rate, as a balance string
+func skyPerCoin(coinType, rate string, maxDecimals int) (string, error) {
+	var droplets uint64
+	var err error
+	switch coinType {
+	case scanner.CoinTypeBTC:
+		droplets, err = exchange.CalculateBtcSkyValue(exchange.SatoshisPerBTC, rate, maxDecimals)
+	case scanner.CoinTypeETH:
+		droplets, err = exchange.CalculateEthSkyValue(big.NewInt(exchange.WeiPerETH), rate, maxDecimals)
+	default:
+		// A coin type added by a scanner plugin
+		if _, err := deposits.GetCoin(coinType); err != nil {
+			return "", scanner.ErrUnsupportedCoinType
+		}
+		droplets, err = exchange.CalculateSkyValue(decimal.New(1, 0), rate, maxDecimals)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return droplet.ToString(droplets)
+}
+
+// RateChange is a rate change of /api/rates/history
+type RateChange struct {
+	exchange.RateChange
+	// Skycoin sent per whole coin, as published by /api/config while the rate was in effect
+	SkyPerCoin string `json:"sky_per_coin"`
+	// When the next rate of the coin type took effect, omitted for the current rate
+	EffectiveUntil int64 `json:"effective_until,omitempty"`
+}
+
+// RateHistoryResponse http response for /api/rates/history
+type RateHistoryResponse struct {
+	Rates []RateChange `json:"rates"`
+}
+
+// RateHistoryHandler returns the rates in effect over time, so that a deposit's
+// conversion rate can be checked against the rate published at its deposit time
+// Method: GET
+// URI: /api/rates/history
+// Args:
+//
+//	coin_type # optional, "BTC", "ETH" or a plugin coin type. All coin types are returned if omitted.
+func RateHistoryHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		coinType := r.URL.Query().Get("coin_type")
+		if coinType != "" {
+			if _, err := deposits.GetCoin(coinType); err != nil {
+				errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid coin_type"))
+				return
+			}
+		}
+
+		rcs, err := s.service.GetRateHistory(coinType)
+		if err != nil {
+			log.WithError(err).Error("service.GetRateHistory failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		rates := make([]RateChange, len(rcs))
+		next := make(map[string]int64)
+		for i := len(rcs) - 1; i >= 0; i-- {
+			rc := rcs[i]
+
+			sky, err := skyPerCoin(rc.CoinType, rc.Rate, rc.MaxDecimals)
+			if err != nil {
+				log.WithError(err).WithField("rateChange", rc).Error("skyPerCoin failed")
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+				return
+			}
+
+			rates[i] = RateChange{
+				RateChange:     rc,
+				SkyPerCoin:     sky,
+				EffectiveUntil: next[rc.CoinType],
+			}
+			next[rc.CoinType] = rc.EffectiveAt
+		}
+
+		if err := httputil.JSONResponse(w, RateHistoryResponse{
+			Rates: rates,
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// PublicStatusResponse http response for /api/public-status
+type PublicStatusResponse struct {
+	// Depleted is true when no enabled coin type has deposit addresses left
+	Depleted      bool            `json:"depleted"`
+	CoinsDepleted map[string]bool `json:"coins_depleted"`
+	// Ended is true when teller is archived, every coin type is depleted then
+	Ended bool `json:"ended"`
+	// CapReached is true when the campaign cap was reached, binding is closed then
+	CapReached bool `json:"cap_reached"`
+	// Maintenance is true while an operator paused binding
+	Maintenance bool `json:"maintenance"`
+	// ClockSkewed is true while the server clock is skewed, and the launch gate, widget sessions
+	// and cancel requests are paused
+	ClockSkewed bool `json:"clock_skewed"`
+}
+
+// PublicStatusHandler returns the service availability status
+// Method: GET
+// URI: /api/public-status
+func PublicStatusHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		coinTypes := s.enabledCoinTypes()
+
+		rsp := PublicStatusResponse{
+			Depleted:      len(coinTypes) > 0,
+			CoinsDepleted: make(map[string]bool, len(coinTypes)),
+			Ended:         s.cfg.Archive.Enabled,
+		}
+
+		for _, coinType := range coinTypes {
+			// An archive has no address pools
+			if rsp.Ended {
+				rsp.CoinsDepleted[coinType] = true
+				continue
+			}
+
+			depleted, err := s.service.Depleted(coinType)
+			if err != nil {
+				log.WithError(err).WithField("coinType", coinType).Error("service.Depleted failed")
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+				return
+			}
+
+			rsp.CoinsDepleted[coinType] = depleted
+			rsp.Depleted = rsp.Depleted && depleted
+		}
+
+		if !rsp.Ended {
+			reached, err := s.service.CapReached()
+			if err != nil {
+				log.WithError(err).Error("service.CapReached failed")
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+				return
+			}
+			rsp.CapReached = reached
+			rsp.Maintenance = s.service.Maintenance()
+			rsp.ClockSkewed = s.skew.Skewed()
+		}
+
+		if err := httputil.JSONResponse(w, rsp); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+type eraseContactRequest struct {
+	SkyAddr     string `json:"skyaddr"`
+	DepositAddr string `json:"deposit_addr"`
+	Sig         string `json:"sig"`
+}
+
+// EraseContactHandler deletes the contact email of a binding.
+// The skyaddr, deposit_addr and sig are the query parameters of the status link sent to the email.
+// Method: POST
+// Accept: application/json
+// URI: /api/contact/erase
+// Args:
+//
+//	{"skyaddr": "...", "deposit_addr": "...", "sig": "..."}
+func EraseContactHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		w.Header().Set("Accept", "application/json")
+
+		if !validMethod(ctx, w, r, []string{http.MethodPost}) {
+			return
+		}
+
+		if !s.validJSONContentType(ctx, w, r) {
+			return
+		}
+
+		var req eraseContactRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			code := requestBodyStatus(err)
+			err = fmt.Errorf("Invalid json request body: %v", err)
+			errorResponse(ctx, w, code, err)
+			return
+		}
+		defer r.Body.Close()
+
+		if !s.service.ContactsEnabled() {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("Contact emails disabled"))
+			return
+		}
+
+		switch {
+		case req.SkyAddr == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
+			return
+		case req.DepositAddr == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing deposit_addr"))
+			return
+		case req.Sig == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing sig"))
+			return
+		}
+
+		if err := s.service.EraseContact(req.SkyAddr, req.DepositAddr, req.Sig); err != nil {
+			if err == notify.ErrInvalidSignature {
+				errorResponse(ctx, w, http.StatusForbidden, err)
+				return
+			}
+			log.WithError(err).Error("service.EraseContact failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, struct{}{}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// VerifyAddressResponse http response for /api/verify-address
+type VerifyAddressResponse struct {
+	Address  string `json:"address"`
+	CoinType string `json:"coin_type"`
+	Valid    bool   `json:"valid"`
+	// Error is why the address is invalid
+	Error string `json:"error,omitempty"`
+	// OnChain is true if the address has received coins. It is omitted if the chain couldn't be checked.
+	OnChain *bool `json:"on_chain,omitempty"`
+	// Bound is true if deposit addresses are already bound to the address
+	Bound          bool `json:"bound"`
+	BoundAddresses int  `json:"bound_addresses"`
+	// CanBind is false if the address has reached the max number of bound deposit addresses
+	CanBind bool `json:"can_bind"`
+}
+
+// VerifyAddressHandler checks a payout address before it is bound, so that frontends
+// can validate it as the user types. An invalid address is not an error, the response
+// has valid set to false.
+// Method: GET
+// URI: /api/verify-address
+// Args:
+//     address # payout address
+//     coin_type # optional payout coin type, only payout.coin (default) is supported
+func VerifyAddressHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		query := r.URL.Query()
+
+		// Remove extraneous whitespace
+		address := strings.Trim(query.Get("address"), "\n\t ")
+		if address == "" {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing address"))
+			return
+		}
+
+		coinType := query.Get("coin_type")
+		switch coinType {
+		case "":
+			coinType = s.payoutCoin()
+		case s.payoutCoin():
+		default:
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid coin_type"))
+			return
+		}
+
+		log = log.WithFields(logrus.Fields{
+			"address":  address,
+			"coinType": coinType,
+		})
+		ctx = logger.WithContext(ctx, log)
+
+		rsp := VerifyAddressResponse{
+			Address:  address,
+			CoinType: coinType,
+		}
+
+		if _, err := cipher.DecodeBase58Address(address); err != nil {
+			rsp.Error = fmt.Sprintf("Invalid skycoin address: %v", err)
+			if err := httputil.JSONResponse(w, rsp); err != nil {
+				log.WithError(err).Error(err)
+			}
+			return
+		}
+
+		rsp.Valid = true
+
+		num, err := s.service.GetBindNum(address)
+		if err != nil {
+			log.WithError(err).Error("service.GetBindNum failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		rsp.BoundAddresses = num
+		rsp.Bound = num > 0
+		rsp.CanBind = s.cfg.Teller.MaxBoundAddresses <= 0 || num < s.cfg.Teller.MaxBoundAddresses
+
+		if s.cfg.Archive.Enabled {
+			// An archive doesn't connect to the skycoin node
+			rsp.CanBind = false
+		} else {
+			// The chain lookup is advisory, so a node which is down doesn't block binding
+			seen, err := s.service.SkyAddressSeen(address)
+			if err != nil {
+				log.WithError(err).Error("service.SkyAddressSeen failed")
+			} else {
+				rsp.OnChain = &seen
+			}
+		}
+
+		if err := httputil.JSONResponse(w, rsp); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// QRHandler renders a BIP21 payment URI for a bound BTC deposit address as a QR code,
+// for frontends and emails which can't render QR codes themselves
+// Method: GET
+// URI: /api/qr
+// Args:
+//     address # bound BTC deposit address
+//     amount # optional BTC amount to request
+//     format # optional, "png" (default) or "svg"
+//     scale # optional pixels per module, 1 to 32 (default 8)
+func QRHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		if !s.coinTypeEnabled(scanner.CoinTypeBTC) {
+			errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("%s not enabled", scanner.CoinTypeBTC))
+			return
+		}
+
+		query := r.URL.Query()
+
+		address := strings.Trim(query.Get("address"), "\n\t ")
+		if address == "" {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing address"))
+			return
+		}
+
+		if _, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams); err != nil {
+			errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("Invalid address: %v", err))
+			return
+		}
+
+		uri := "bitcoin:" + address
+
+		if amount := query.Get("amount"); amount != "" {
+			btc, err := decimal.NewFromString(amount)
+			if err != nil {
+				errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("Invalid amount: %v", err))
+				return
+			}
+
+			if btc.Sign() <= 0 {
+				errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid amount: must be positive"))
+				return
+			}
+
+			if btc.Exponent() < -btcDecimals {
+				errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("Invalid amount: more than %d decimal places", btcDecimals))
+				return
+			}
+
+			uri += "?amount=" + btc.String()
+		}
+
+		format := query.Get("format")
+		switch format {
+		case "":
+			format = qrFormatPNG
+		case qrFormatPNG, qrFormatSVG:
+		default:
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid format"))
+			return
+		}
+
+		scale := qrDefaultScale
+		if v := query.Get("scale"); v != "" {
+			var err error
+			scale, err = strconv.Atoi(v)
+			if err != nil || scale < 1 || scale > qrMaxScale {
+				errorResponse(ctx, w, http.StatusBadRequest, fmt.Errorf("Invalid scale: must be 1 to %d", qrMaxScale))
+				return
+			}
+		}
+
+		log = log.WithField("uri", uri)
+		ctx = logger.WithContext(ctx, log)
+		r = r.WithContext(ctx)
+
+		bound, err := s.service.IsBound(address, scanner.CoinTypeBTC)
+		if err != nil {
+			log.WithError(err).Error("service.IsBound failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		if !bound {
+			errorResponse(ctx, w, http.StatusNotFound, errors.New("Address is not bound"))
+			return
+		}
+
+		code, err := qrcode.Encode([]byte(uri))
+		if err != nil {
+			log.WithError(err).Error("qrcode.Encode failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		var body []byte
+		switch format {
+		case qrFormatPNG:
+			body, err = code.PNG(scale)
+			if err != nil {
+				log.WithError(err).Error("qrcode.PNG failed")
+				errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "image/png")
+		case qrFormatSVG:
+			body = code.SVG(scale)
+			w.Header().Set("Content-Type", "image/svg+xml")
+		}
+
+		// The image only depends on the query, so it can be cached
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+
+		if _, err := w.Write(body); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// confirmationsRequired returns the confirmations a deposit of coinType needs before it is received
+func (s *HTTPServer) confirmationsRequired(coinType string) int64 {
+	switch coinType {
+	case scanner.CoinTypeBTC:
+		return s.cfg.BtcScanner.ConfirmationsRequired
+	case scanner.CoinTypeETH:
+		return s.cfg.EthScanner.ConfirmationsRequired
+	default:
+		pc, _ := s.pluginCoin(coinType)
+		return pc.ConfirmationsRequired
+	}
+}
+
+// pluginCoin returns the config of a coin type added by a scanner plugin
+func (s *HTTPServer) pluginCoin(coinType string) (config.PluginCoin, bool) {
+	for _, pc := range s.cfg.PluginCoins {
+		if pc.CoinType == coinType {
+			return pc, true
+		}
+	}
+	return config.PluginCoin{}, false
+}
+
+// finality returns the finality policy of the deposits of coinType
+func (s *HTTPServer) finality(coinType string) string {
+	var policy string
+	switch coinType {
+	case scanner.CoinTypeBTC:
+		policy = s.cfg.BtcScanner.Finality.Policy
+	case scanner.CoinTypeETH:
+		policy = s.cfg.EthScanner.Finality.Policy
+	}
+
+	if policy == "" {
+		return config.FinalityConfirmations
+	}
+	return policy
+}
+
+// payoutCoin returns the coin type of the payout addresses, SKY unless a fiber chain's coin is paid out
+func (s *HTTPServer) payoutCoin() string {
+	if s.cfg.Payout.Coin == "" {
+		return coinTypeSKY
+	}
+	return s.cfg.Payout.Coin
+}
+
+func (s *HTTPServer) newDepositStatus(ds exchange.DepositStatus) DepositStatus {
+	required := s.confirmationsRequired(ds.CoinType)
+
+	// Deposits scanned before confirmations were saved had at least the required confirmations,
+	// since the scanners only send deposits once they are confirmed
+	if ds.Confirmations == 0 && ds.Status != exchange.StatusWaitDeposit.String() {
+		ds.Confirmations = required
+	}
+
+	return DepositStatus{
+		DepositStatus:         ds,
+		ConfirmationsRequired: required,
+		Finality:              s.finality(ds.CoinType),
+	}
+}
+
+// bindFlags are the feature flags which gate binding the deposit addresses of each coin type
+var bindFlags = map[string]string{
+	scanner.CoinTypeBTC: config.FlagBindBTC,
+	scanner.CoinTypeETH: config.FlagBindETH,
+}
+
+// featureEnabled writes a 403 response if the feature flag is off
+func (s *HTTPServer) featureEnabled(ctx context.Context, w http.ResponseWriter, name string) bool {
+	if s.features.Enabled(name) {
+		return true
+	}
+
+	w.Header().Set(errCodeHeader, errCodeFeatureDisabled)
+	errorResponse(ctx, w, http.StatusForbidden, fmt.Errorf("Feature %s disabled", name))
+	return false
+}
+
+// enabledCoinTypes returns the coin types that can be bound, which have a registered scanner
+func (s *HTTPServer) enabledCoinTypes() []string {
+	return s.scanners.CoinTypes()
+}
+
+// coinTypeEnabled returns true if coinType can be bound
+func (s *HTTPServer) coinTypeEnabled(coinType string) bool {
+	for _, ct := range s.enabledCoinTypes() {
+		if ct == coinType {
+			return true
+		}
+	}
+	return false
+}
+
 func validMethod(ctx context.Context, w http.ResponseWriter, r *http.Request, allowed []string) bool {
 	for _, m := range allowed {
 		if r.Method == m {
@@ -606,6 +1743,47 @@ func verifySkycoinAddress(ctx context.Context, w http.ResponseWriter, skyAddr st
 	return true
 }
 
+// errorKindStatus returns the HTTP status code of an error kind
+func errorKindStatus(kind errutil.Kind) int {
+	switch kind {
+	case errutil.NotFound:
+		return http.StatusNotFound
+	case errutil.Conflict:
+		return http.StatusConflict
+	case errutil.Unavailable:
+		return http.StatusServiceUnavailable
+	case errutil.RateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// serviceErrorResponse responds to an error returned by the service with the status code of its kind.
+// The messages of internal errors are not shown to the client.
+func serviceErrorResponse(ctx context.Context, w http.ResponseWriter, err error) {
+	code := errorKindStatus(errutil.KindOf(err))
+	if code == http.StatusInternalServerError {
+		err = errInternalServerError
+	}
+
+	// Every transient failure tells the client when to retry
+	if (code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable) && w.Header().Get("Retry-After") == "" {
+		w.Header().Set("Retry-After", bindRetryAfter)
+	}
+
+	errorResponse(ctx, w, code, err)
+}
+
+// retryAfterSeconds formats a wait as Retry-After seconds, rounded up to at least 1
+func retryAfterSeconds(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
 func errorResponse(ctx context.Context, w http.ResponseWriter, code int, err error) {
 	log := logger.FromContext(ctx)
 	log.WithFields(logrus.Fields{
@@ -0,0 +1,1086 @@
+package teller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/notify"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+	"github.com/skycoin/teller/src/version"
+)
+
+func TestNewDepositStatus(t *testing.T) {
+	s := &HTTPServer{
+		cfg: config.Config{
+			BtcScanner: config.BtcScanner{
+				ConfirmationsRequired: 2,
+			},
+			EthScanner: config.EthScanner{
+				ConfirmationsRequired: 12,
+				Finality: config.ScannerFinality{
+					Policy: config.FinalityHybrid,
+				},
+			},
+		},
+	}
+
+	tt := []struct {
+		name   string
+		ds     exchange.DepositStatus
+		expect DepositStatus
+	}{
+		{
+			name: "waiting deposit",
+			ds: exchange.DepositStatus{
+				Status:   exchange.StatusWaitDeposit.String(),
+				CoinType: scanner.CoinTypeETH,
+			},
+			expect: DepositStatus{
+				DepositStatus: exchange.DepositStatus{
+					Status:   exchange.StatusWaitDeposit.String(),
+					CoinType: scanner.CoinTypeETH,
+				},
+				ConfirmationsRequired: 12,
+				Finality:              config.FinalityHybrid,
+			},
+		},
+		{
+			name: "detected",
+			ds: exchange.DepositStatus{
+				Status:        exchange.DepositStatusDetected,
+				CoinType:      scanner.CoinTypeETH,
+				Confirmations: 12,
+			},
+			expect: DepositStatus{
+				DepositStatus: exchange.DepositStatus{
+					Status:        exchange.DepositStatusDetected,
+					CoinType:      scanner.CoinTypeETH,
+					Confirmations: 12,
+				},
+				ConfirmationsRequired: 12,
+				Finality:              config.FinalityHybrid,
+			},
+		},
+		{
+			name: "received",
+			ds: exchange.DepositStatus{
+				Status:        exchange.StatusDone.String(),
+				CoinType:      scanner.CoinTypeBTC,
+				Confirmations: 5,
+			},
+			expect: DepositStatus{
+				DepositStatus: exchange.DepositStatus{
+					Status:        exchange.StatusDone.String(),
+					CoinType:      scanner.CoinTypeBTC,
+					Confirmations: 5,
+				},
+				ConfirmationsRequired: 2,
+				Finality:              config.FinalityConfirmations,
+			},
+		},
+		{
+			name: "received before confirmations were saved",
+			ds: exchange.DepositStatus{
+				Status:   exchange.StatusWaitSend.String(),
+				CoinType: scanner.CoinTypeBTC,
+			},
+			expect: DepositStatus{
+				DepositStatus: exchange.DepositStatus{
+					Status:        exchange.StatusWaitSend.String(),
+					CoinType:      scanner.CoinTypeBTC,
+					Confirmations: 2,
+				},
+				ConfirmationsRequired: 2,
+				Finality:              config.FinalityConfirmations,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expect, s.newDepositStatus(tc.ds))
+		})
+	}
+
+	b, err := json.Marshal(tt[2].expect)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"seq": 0,
+		"updated_at": 0,
+		"status": "done",
+		"coin_type": "BTC",
+		"confirmations": 5,
+		"confirmations_required": 2,
+		"finality": "confirmations"
+	}`, string(b))
+}
+
+func TestServiceErrorResponse(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	ctx := logger.WithContext(context.Background(), log)
+
+	tt := []struct {
+		name string
+		err  error
+		code int
+		body string
+	}{
+		{
+			name: "not found",
+			err:  exchange.ErrSkyAddressNotBound,
+			code: http.StatusNotFound,
+			body: exchange.ErrSkyAddressNotBound.Error(),
+		},
+		{
+			name: "conflict",
+			err:  ErrMaxBoundAddresses,
+			code: http.StatusConflict,
+			body: ErrMaxBoundAddresses.Error(),
+		},
+		{
+			name: "unavailable",
+			err:  addrs.ErrDepositAddressEmpty,
+			code: http.StatusServiceUnavailable,
+			body: addrs.ErrDepositAddressEmpty.Error(),
+		},
+		{
+			name: "rate limited",
+			err:  addrs.ErrAllocQueueFull,
+			code: http.StatusTooManyRequests,
+			body: addrs.ErrAllocQueueFull.Error(),
+		},
+		{
+			name: "wrapped",
+			err:  fmt.Errorf("bind failed: %w", exchange.ErrAddressAlreadyBound),
+			code: http.StatusConflict,
+			body: "bind failed: " + exchange.ErrAddressAlreadyBound.Error(),
+		},
+		{
+			name: "internal",
+			err:  errors.New("db is corrupted"),
+			code: http.StatusInternalServerError,
+			body: http.StatusText(http.StatusInternalServerError),
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			serviceErrorResponse(ctx, w, tc.err)
+			require.Equal(t, tc.code, w.Code)
+			require.Equal(t, tc.body, strings.TrimSpace(w.Body.String()))
+		})
+	}
+}
+
+func TestLaunchGate(t *testing.T) {
+	start := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	before := start.Add(-time.Second)
+	allowed := "2do3K1YLMy3Aq6EcPMdncEurP5BfAUdFPJj"
+	other := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+
+	g := newLaunchGate(config.Teller{})
+	require.Equal(t, LaunchPhasePublic, g.phase(before))
+	require.True(t, g.canBind(before, other, ""))
+
+	g = newLaunchGate(config.Teller{
+		StartAt: start.Format(time.RFC3339),
+	})
+	require.Equal(t, LaunchPhaseClosed, g.phase(before))
+	require.False(t, g.canBind(before, other, ""))
+	require.Equal(t, LaunchPhasePublic, g.phase(start))
+	require.True(t, g.canBind(start, other, ""))
+
+	g = newLaunchGate(config.Teller{
+		StartAt:          start.Format(time.RFC3339),
+		Allowlist:        []string{allowed},
+		AllowlistAPIKeys: []string{"partner-key"},
+	})
+	require.Equal(t, LaunchPhaseAllowlist, g.phase(before))
+	require.True(t, g.canBind(before, allowed, ""))
+	require.False(t, g.canBind(before, other, ""))
+	require.False(t, g.canBind(before, other, "wrong-key"))
+	require.True(t, g.canBind(before, other, "partner-key"))
+	require.Equal(t, LaunchPhasePublic, g.phase(start))
+	require.True(t, g.canBind(start, other, ""))
+}
+
+func TestBindHandlerNotStarted(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	start := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+		Teller: config.Teller{
+			StartAt:          start.Format(time.RFC3339),
+			AllowlistAPIKeys: []string{"partner-key"},
+		},
+	}
+	s := NewHTTPServer(log, cfg, &Service{}, nil, clock.NewFake(start.Add(-time.Hour)))
+	require.Equal(t, "<redacted>", s.cfg.Teller.AllowlistAPIKeys[0])
+
+	body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(apiKeyHeader, "<redacted>")
+	w := httptest.NewRecorder()
+
+	BindHandler(s)(w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, errCodeNotStarted, w.Header().Get(errCodeHeader))
+	require.Equal(t, "3600", w.Header().Get("Retry-After"))
+}
+
+func TestConfigHandlerLaunchPhase(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	start := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(-time.Second))
+
+	s := NewHTTPServer(log, config.Config{
+		Teller: config.Teller{
+			StartAt: start.Format(time.RFC3339),
+		},
+		SkyExchanger: config.SkyExchanger{
+			SkyBtcExchangeRate: "500",
+			SkyEthExchangeRate: "50",
+		},
+	}, &Service{}, nil, clk)
+
+	phase := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+
+		ConfigHandler(s)(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var rsp ConfigResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		return rsp.LaunchPhase
+	}
+
+	require.Equal(t, LaunchPhaseClosed, phase())
+
+	clk.Advance(time.Second)
+	require.Equal(t, LaunchPhasePublic, phase())
+}
+
+func TestConfigHandlerPluginCoins(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	if _, err := deposits.GetCoin("TPC"); err != nil {
+		require.NoError(t, deposits.RegisterCoin(deposits.Coin{Type: "TPC", Decimals: 6}))
+	}
+
+	s := NewHTTPServer(log, config.Config{
+		SkyExchanger: config.SkyExchanger{
+			SkyBtcExchangeRate: "500",
+			SkyEthExchangeRate: "50",
+			MaxDecimals:        2,
+		},
+		PluginCoins: []config.PluginCoin{
+			{
+				CoinType:              "TPC",
+				Decimals:              6,
+				SkyExchangeRate:       "2.125",
+				ConfirmationsRequired: 6,
+			},
+		},
+	}, &Service{}, nil, clock.Real{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	w := httptest.NewRecorder()
+
+	ConfigHandler(s)(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var rsp ConfigResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	require.Equal(t, []PluginCoinConfig{
+		{
+			CoinType:              "TPC",
+			Decimals:              6,
+			ConfirmationsRequired: 6,
+			SkyExchangeRate:       "2.120000",
+		},
+	}, rsp.PluginCoins)
+
+	require.Equal(t, int64(6), s.confirmationsRequired("TPC"))
+	require.Equal(t, coinTypeList{"TPC"}, configCoinTypes(s.cfg))
+}
+
+func TestLaunchGateClockSkew(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	start := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(-time.Hour))
+	tip := clk.Now()
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+		Teller: config.Teller{
+			StartAt: start.Format(time.RFC3339),
+		},
+		SkyExchanger: config.SkyExchanger{
+			SkyBtcExchangeRate: "500",
+			SkyEthExchangeRate: "50",
+		},
+	}, &Service{}, nil, clk)
+	s.skew = clock.NewSkewGuard(log, clock.SkewConfig{
+		MaxChainSkew: time.Hour * 3,
+	}, clk, []clock.ChainSource{{
+		Name: "BTC",
+		Tip: func() (time.Time, error) {
+			return tip, nil
+		},
+	}})
+
+	phase := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+
+		ConfigHandler(s)(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var rsp ConfigResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		return rsp.LaunchPhase
+	}
+
+	require.False(t, s.skew.Check())
+	require.Equal(t, LaunchPhaseClosed, phase())
+
+	// The clock jumps a day ahead of the chain, past the start time
+	clk.Advance(time.Hour * 24)
+	require.True(t, s.skew.Check())
+
+	// The gate stays closed
+	require.Equal(t, LaunchPhaseClosed, phase())
+
+	body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	BindHandler(s)(w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, errCodeClockSkew, w.Header().Get(errCodeHeader))
+	require.Equal(t, maintenanceRetryAfter, w.Header().Get("Retry-After"))
+
+	// The chain confirms the time, the gate opens
+	tip = clk.Now()
+	require.False(t, s.skew.Check())
+	require.Equal(t, LaunchPhasePublic, phase())
+}
+
+func TestPayoutCoin(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		SkyExchanger: config.SkyExchanger{
+			SkyBtcExchangeRate: "500",
+			SkyEthExchangeRate: "50",
+		},
+	}
+
+	get := func(s *HTTPServer, h func(*HTTPServer) http.HandlerFunc, uri string, v interface{}) int {
+		req := httptest.NewRequest(http.MethodGet, uri, nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+		h(s)(w, req)
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), v))
+		}
+		return w.Code
+	}
+
+	// SKY is paid out by default
+	s := NewHTTPServer(log, cfg, &Service{}, nil, clock.Real{})
+	var cfgRsp ConfigResponse
+	require.Equal(t, http.StatusOK, get(s, ConfigHandler, "/api/config", &cfgRsp))
+	require.Equal(t, "SKY", cfgRsp.PayoutCoin)
+	require.Equal(t, ServiceBranding{}, cfgRsp.Branding)
+
+	cfg.Branding = config.Branding{
+		ServiceName:  "Example Exchange",
+		SupportURL:   "https://example.com/support",
+		LogoURL:      "https://example.com/logo.png",
+		ContactEmail: "support@example.com",
+	}
+	cfg.Payout = config.Payout{
+		Coin:  "MDL",
+		Chain: "mdl",
+	}
+	s = NewHTTPServer(log, cfg, &Service{}, nil, clock.Real{})
+
+	cfgRsp = ConfigResponse{}
+	require.Equal(t, http.StatusOK, get(s, ConfigHandler, "/api/config", &cfgRsp))
+	require.Equal(t, "MDL", cfgRsp.PayoutCoin)
+	require.Equal(t, "mdl", cfgRsp.PayoutChain)
+	require.Equal(t, ServiceBranding{
+		ServiceName:  "Example Exchange",
+		SupportURL:   "https://example.com/support",
+		LogoURL:      "https://example.com/logo.png",
+		ContactEmail: "support@example.com",
+	}, cfgRsp.Branding)
+
+	// Payout addresses are of the fiber chain's coin
+	var verifyRsp VerifyAddressResponse
+	require.Equal(t, http.StatusOK, get(s, VerifyAddressHandler, "/api/verify-address?address=invalid", &verifyRsp))
+	require.Equal(t, "MDL", verifyRsp.CoinType)
+	require.False(t, verifyRsp.Valid)
+	require.Equal(t, http.StatusBadRequest, get(s, VerifyAddressHandler, "/api/verify-address?address=invalid&coin_type=SKY", &verifyRsp))
+}
+
+func TestArchived(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+		SkyExchanger: config.SkyExchanger{
+			SkyBtcExchangeRate: "500",
+			SkyEthExchangeRate: "50",
+		},
+		Archive: config.Archive{
+			Enabled: true,
+		},
+	}, &Service{}, nil, clock.Real{})
+
+	// Binds and cancels are refused
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+		req := httptest.NewRequest(method, "/api/bind", strings.NewReader(body))
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		BindHandler(s)(w, req)
+		require.Equal(t, http.StatusGone, w.Code, method)
+		require.Equal(t, errCodeEnded, w.Header().Get(errCodeHeader), method)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	w := httptest.NewRecorder()
+	ConfigHandler(s)(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var cfgRsp ConfigResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &cfgRsp))
+	require.Equal(t, LaunchPhaseEnded, cfgRsp.LaunchPhase)
+
+	// The address pools aren't loaded
+	req = httptest.NewRequest(http.MethodGet, "/api/public-status", nil)
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	w = httptest.NewRecorder()
+	PublicStatusHandler(s)(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var statusRsp PublicStatusResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &statusRsp))
+	require.Equal(t, PublicStatusResponse{
+		Depleted: true,
+		CoinsDepleted: map[string]bool{
+			scanner.CoinTypeBTC: true,
+		},
+		Ended: true,
+	}, statusRsp)
+}
+
+func TestBindHandlerQuiesced(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}
+	service := &Service{}
+	service.Quiesce()
+	s := NewHTTPServer(log, cfg, service, nil, clock.Real{})
+
+	body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	BindHandler(s)(w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, errCodeHandover, w.Header().Get(errCodeHeader))
+	require.Equal(t, bindRetryAfter, w.Header().Get("Retry-After"))
+}
+
+func TestBindHandlerMaintenance(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}
+	service := &Service{}
+	service.bindGate.Pause()
+	s := NewHTTPServer(log, cfg, service, nil, clock.Real{})
+
+	body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	BindHandler(s)(w, req)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, errCodeMaintenance, w.Header().Get(errCodeHeader))
+	require.Equal(t, maintenanceRetryAfter, w.Header().Get("Retry-After"))
+	require.True(t, service.Maintenance())
+	require.False(t, service.bindGate.Status().Busy)
+}
+
+func TestBindHandlerInvalidRefundAddress(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}
+	s := NewHTTPServer(log, cfg, &Service{}, nil, clock.Real{})
+
+	// A testnet address can't receive a mainnet refund
+	body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC","refund_address":"mipcBbFg9gMiCh81Kj8tqqdgoZub1ZJRfn"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	BindHandler(s)(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Invalid BTC refund address")
+}
+
+func TestBindHandlerInvalidLanguage(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}
+	s := NewHTTPServer(log, cfg, &Service{
+		contacts: &dummyContactBook{},
+	}, nil, clock.Real{})
+
+	body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC","email":"user@example.com","language":"../en"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	BindHandler(s)(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), notify.ErrInvalidLanguage.Error())
+}
+
+type capExchanger struct {
+	exchange.Exchanger
+	reached bool
+}
+
+func (e capExchanger) CapReached() (bool, error) {
+	return e.reached, nil
+}
+
+func TestCampaignCapReached(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+	}
+
+	publicStatus := func(s *HTTPServer) PublicStatusResponse {
+		req := httptest.NewRequest(http.MethodGet, "/api/public-status", nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+		PublicStatusHandler(s)(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var rsp PublicStatusResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		return rsp
+	}
+
+	s := NewHTTPServer(log, cfg, &Service{
+		exchanger: capExchanger{},
+	}, nil, clock.Real{})
+	require.False(t, publicStatus(s).CapReached)
+
+	cfg.BtcRPC.Enabled = true
+	s = NewHTTPServer(log, cfg, &Service{
+		exchanger: capExchanger{
+			reached: true,
+		},
+		tracker: analytics.Noop{},
+	}, nil, clock.Real{})
+
+	// Binds are refused once the cap is reached
+	body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	BindHandler(s)(w, req)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, errCodeCapReached, w.Header().Get(errCodeHeader))
+
+	cfg.BtcRPC.Enabled = false
+	s = NewHTTPServer(log, cfg, &Service{
+		exchanger: capExchanger{
+			reached: true,
+		},
+	}, nil, clock.Real{})
+	require.True(t, publicStatus(s).CapReached)
+}
+
+type quoteExchanger struct {
+	exchange.Exchanger
+	rate string
+}
+
+func (e quoteExchanger) CapReached() (bool, error) {
+	return false, nil
+}
+
+func (e quoteExchanger) BindAddress(skyAddr, depositAddr, coinType, refundAddr string) error {
+	return nil
+}
+
+func (e quoteExchanger) CurrentRate(coinType string) (string, error) {
+	if e.rate == "" {
+		return "", errors.New("no rate")
+	}
+	return e.rate, nil
+}
+
+func TestBindHandlerQuote(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	bind := func(rate string) BindResponse {
+		gen, err := addrs.NewAddrs(log, db, []string{"btcaddr1"}, "test_bucket_"+rate)
+		require.NoError(t, err)
+		addrManager := addrs.NewAddrManager(addrs.AllocConfig{})
+		require.NoError(t, addrManager.PushGenerator(gen, scanner.CoinTypeBTC))
+
+		s := NewHTTPServer(log, config.Config{
+			Web: config.Web{
+				APIEnabled: true,
+			},
+			BtcRPC: config.BtcRPC{
+				Enabled: true,
+			},
+			SkyExchanger: config.SkyExchanger{
+				MaxDecimals: 3,
+			},
+		}, &Service{
+			log:         log,
+			exchanger:   quoteExchanger{rate: rate},
+			addrManager: addrManager,
+			tracker:     analytics.Noop{},
+		}, nil, clock.NewFake(now))
+
+		body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		BindHandler(s)(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var rsp BindResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+		return rsp
+	}
+
+	require.Equal(t, BindResponse{
+		DepositAddress:  "btcaddr1",
+		CoinType:        scanner.CoinTypeBTC,
+		SkyExchangeRate: "612.500000",
+		Estimates: []BindEstimate{
+			{Amount: "0.001", Sky: "0.612000"},
+			{Amount: "0.01", Sky: "6.125000"},
+			{Amount: "0.1", Sky: "61.250000"},
+			{Amount: "1", Sky: "612.500000"},
+		},
+		QuotedAt: now.Unix(),
+	}, bind("612.5"))
+
+	// The address is bound without a quote if the rate can't be quoted
+	require.Equal(t, BindResponse{
+		DepositAddress: "btcaddr1",
+		CoinType:       scanner.CoinTypeBTC,
+	}, bind(""))
+}
+
+func TestBindHandlerFeatureFlags(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	gen, err := addrs.NewAddrs(log, db, []string{"btcaddr1"}, "test_bucket")
+	require.NoError(t, err)
+	addrManager := addrs.NewAddrManager(addrs.AllocConfig{})
+	require.NoError(t, addrManager.PushGenerator(gen, scanner.CoinTypeBTC))
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+		SkyExchanger: config.SkyExchanger{
+			MaxDecimals: 3,
+		},
+		FeatureFlags: map[string]bool{
+			config.FlagBindBTC: false,
+		},
+	}, &Service{
+		log:         log,
+		exchanger:   quoteExchanger{rate: "612.5"},
+		addrManager: addrManager,
+		tracker:     analytics.Noop{},
+	}, nil, clock.Real{})
+
+	bind := func() *httptest.ResponseRecorder {
+		body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"BTC"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		BindHandler(s)(w, req)
+		return w
+	}
+
+	// The coin's binding is disabled by the config
+	w := bind()
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Equal(t, errCodeFeatureDisabled, w.Header().Get(errCodeHeader))
+	require.Contains(t, w.Body.String(), "Feature bind_btc disabled")
+
+	// An operator enables it, and disables the quote
+	_, err = s.features.Enable(config.FlagBindBTC)
+	require.NoError(t, err)
+	_, err = s.features.Disable(config.FlagBindQuote)
+	require.NoError(t, err)
+
+	w = bind()
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var rsp BindResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	require.Equal(t, BindResponse{
+		DepositAddress: "btcaddr1",
+		CoinType:       scanner.CoinTypeBTC,
+	}, rsp)
+}
+
+func TestBindHandlerScannerRegistry(t *testing.T) {
+	db, shutdown := testutil.PrepareDB(t)
+	defer shutdown()
+	log, _ := testutil.NewLogger(t)
+
+	gen, err := addrs.NewAddrs(log, db, []string{"ltcaddr1"}, "test_bucket")
+	require.NoError(t, err)
+	addrManager := addrs.NewAddrManager(addrs.AllocConfig{})
+	require.NoError(t, addrManager.PushGenerator(gen, "LTC"))
+
+	// The config enables ETH, but only the coin types with a scanner can be bound
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		EthRPC: config.EthRPC{
+			Enabled: true,
+		},
+	}, &Service{
+		log:         log,
+		exchanger:   quoteExchanger{},
+		addrManager: addrManager,
+		tracker:     analytics.Noop{},
+	}, nil, clock.Real{})
+	s.scanners = coinTypeList{scanner.CoinTypeBTC, "LTC"}
+
+	bind := func(coinType string) *httptest.ResponseRecorder {
+		body := `{"skyaddr":"2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv","coin_type":"` + coinType + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/bind", strings.NewReader(body))
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		BindHandler(s)(w, req)
+		return w
+	}
+
+	// A community coin has no feature flag
+	w := bind("LTC")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var rsp BindResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+	require.Equal(t, BindResponse{
+		DepositAddress: "ltcaddr1",
+		CoinType:       "LTC",
+	}, rsp)
+
+	w = bind(scanner.CoinTypeETH)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Invalid coin_type, must be one of: BTC, LTC")
+
+	w = bind("")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Missing coin_type")
+
+	require.Equal(t, []string{scanner.CoinTypeBTC, "LTC"}, s.enabledCoinTypes())
+}
+
+type dummyContactBook struct {
+	erased []string
+}
+
+func (c *dummyContactBook) AddContact(skyAddr, depositAddr, coinType, email, language string) error {
+	return nil
+}
+
+func (c *dummyContactBook) EraseContact(skyAddr, depositAddr, sig string) error {
+	if sig != "good" {
+		return notify.ErrInvalidSignature
+	}
+	c.erased = append(c.erased, skyAddr+":"+depositAddr)
+	return nil
+}
+
+func TestEraseContactHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	contacts := &dummyContactBook{}
+	s := NewHTTPServer(log, config.Config{}, &Service{
+		contacts: contacts,
+	}, nil, clock.Real{})
+
+	tt := []struct {
+		name string
+		body string
+		code int
+	}{
+		{
+			name: "erased",
+			body: `{"skyaddr":"s1","deposit_addr":"d1","sig":"good"}`,
+			code: http.StatusOK,
+		},
+		{
+			name: "invalid signature",
+			body: `{"skyaddr":"s1","deposit_addr":"d2","sig":"bad"}`,
+			code: http.StatusForbidden,
+		},
+		{
+			name: "missing sig",
+			body: `{"skyaddr":"s1","deposit_addr":"d2"}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "invalid json",
+			body: `{"skyaddr":`,
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/contact/erase", strings.NewReader(tc.body))
+			req = req.WithContext(logger.WithContext(req.Context(), log))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			EraseContactHandler(s)(w, req)
+			require.Equal(t, tc.code, w.Code)
+		})
+	}
+
+	require.Equal(t, []string{"s1:d1"}, contacts.erased)
+
+	// Contact emails are disabled
+	s = NewHTTPServer(log, config.Config{}, &Service{}, nil, clock.Real{})
+	req := httptest.NewRequest(http.MethodPost, "/api/contact/erase", strings.NewReader(tt[0].body))
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	EraseContactHandler(s)(w, req)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestVersionHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := NewHTTPServer(log, config.Config{
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+	}, &Service{}, nil, clock.Real{})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	w := httptest.NewRecorder()
+
+	VersionHandler(s)(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var rsp VersionResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+	require.Equal(t, version.Get(), rsp.Info)
+	require.Equal(t, []string{scanner.CoinTypeBTC}, rsp.CoinTypes)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/version", nil)
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	w = httptest.NewRecorder()
+
+	VersionHandler(s)(w, req)
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+type rateHistoryExchanger struct {
+	exchange.Exchanger
+	rates []exchange.RateChange
+}
+
+func (e rateHistoryExchanger) GetRateHistory(coinType string) ([]exchange.RateChange, error) {
+	var rcs []exchange.RateChange
+	for _, rc := range e.rates {
+		if coinType == "" || rc.CoinType == coinType {
+			rcs = append(rcs, rc)
+		}
+	}
+	return rcs, nil
+}
+
+func TestRateHistoryHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := NewHTTPServer(log, config.Config{}, &Service{
+		exchanger: rateHistoryExchanger{
+			rates: []exchange.RateChange{
+				{Seq: 1, CoinType: scanner.CoinTypeBTC, Rate: "500", MaxDecimals: 0, EffectiveAt: 100},
+				{Seq: 2, CoinType: scanner.CoinTypeETH, Rate: "30.5", MaxDecimals: 3, EffectiveAt: 100},
+				{Seq: 3, CoinType: scanner.CoinTypeBTC, Rate: "512.25", MaxDecimals: 1, EffectiveAt: 200},
+			},
+		},
+	}, nil, clock.Real{})
+
+	get := func(query string) (int, RateHistoryResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/rates/history"+query, nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+
+		RateHistoryHandler(s)(w, req)
+
+		var rsp RateHistoryResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+		}
+		return w.Code, rsp
+	}
+
+	code, rsp := get("")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, rsp.Rates, 3)
+
+	// Each rate is effective until the next rate of its coin type
+	require.Equal(t, "500.000000", rsp.Rates[0].SkyPerCoin)
+	require.Equal(t, int64(200), rsp.Rates[0].EffectiveUntil)
+	require.Equal(t, "30.500000", rsp.Rates[1].SkyPerCoin)
+	require.Empty(t, rsp.Rates[1].EffectiveUntil)
+	require.Equal(t, "512.200000", rsp.Rates[2].SkyPerCoin)
+	require.Empty(t, rsp.Rates[2].EffectiveUntil)
+
+	code, rsp = get("?coin_type=BTC")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, rsp.Rates, 2)
+	require.Equal(t, "512.25", rsp.Rates[1].Rate)
+
+	code, _ = get("?coin_type=DOGE")
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestListenNetwork(t *testing.T) {
+	cases := map[string]string{
+		"0.0.0.0:7071":          "tcp4",
+		"127.0.0.1:7071":        "tcp4",
+		"[::]:7071":             "tcp6",
+		"[::1]:7071":            "tcp6",
+		"[fe80::1%eth0]:7071":   "tcp6",
+		":7071":                 "tcp",
+		"localhost:7071":        "tcp",
+		"no-port":               "tcp",
+		"[::ffff:1.2.3.4]:7071": "tcp4",
+	}
+
+	for addr, network := range cases {
+		require.Equal(t, network, listenNetwork(addr), addr)
+	}
+}
@@ -0,0 +1,267 @@
+package teller
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/gz-c/tollbooth/limiter"
+
+	"github.com/skycoin/teller/src/config"
+)
+
+// Rate limiting algorithms.
+// The API is rate limited per client and endpoint by one of these algorithms, selected by
+// web.rate_limit.algorithm and overridden per endpoint:
+//   - token_bucket is tollbooth's limiter, which allows throttle_max requests per throttle_duration,
+//     refilled evenly over the duration
+//   - sliding_window logs the client's requests, and allows throttle_max within any throttle_duration
+//   - leaky_bucket allows a burst of requests, drained at a sustained rate
+const (
+	rateLimitTokenBucket   = "token_bucket"
+	rateLimitSlidingWindow = "sliding_window"
+	rateLimitLeakyBucket   = "leaky_bucket"
+)
+
+// keyLimiter limits the requests of each client key
+type keyLimiter interface {
+	// allow reports whether a request of key is allowed at t, and if not, how long until one is
+	allow(key string, t time.Time) (bool, time.Duration)
+	// limit returns the requests allowed per duration, for the X-Rate-Limit headers
+	limit() (int64, time.Duration)
+}
+
+// rateLimitRule is the rate limit of an endpoint
+type rateLimitRule struct {
+	algorithm string
+	// max requests per duration, for token_bucket and sliding_window
+	max      int64
+	duration time.Duration
+	// burst of requests, drained at rate per second, for leaky_bucket
+	burst int
+	rate  float64
+}
+
+// endpointRateLimit returns the rate limit of the endpoint at path.
+// Its web.rate_limit.endpoints rule overrides web.rate_limit, which defaults to web.throttle_max per web.throttle_duration.
+// The leaky bucket's burst defaults to max, and its rate to max per duration.
+func endpointRateLimit(c config.Web, path string) rateLimitRule {
+	r := rateLimitRule{
+		algorithm: c.RateLimit.Algorithm,
+		max:       c.ThrottleMax,
+		duration:  c.ThrottleDuration,
+		burst:     c.RateLimit.Burst,
+		rate:      c.RateLimit.Rate,
+	}
+
+	for _, e := range c.RateLimit.Endpoints {
+		if e.Path != path {
+			continue
+		}
+
+		if e.Algorithm != "" {
+			r.algorithm = e.Algorithm
+		}
+		if e.Max != 0 {
+			r.max = e.Max
+		}
+		if e.Duration != 0 {
+			r.duration = e.Duration
+		}
+		if e.Burst != 0 {
+			r.burst = e.Burst
+		}
+		if e.Rate != 0 {
+			r.rate = e.Rate
+		}
+	}
+
+	if r.algorithm == "" {
+		r.algorithm = rateLimitTokenBucket
+	}
+
+	if r.burst == 0 {
+		r.burst = int(r.max)
+	}
+
+	if r.rate == 0 && r.duration > 0 {
+		r.rate = float64(r.max) / r.duration.Seconds()
+	}
+
+	return r
+}
+
+// newKeyLimiter returns the limiter of the rule. lmt is the tollbooth limiter of the token bucket.
+func newKeyLimiter(r rateLimitRule, lmt *limiter.Limiter) keyLimiter {
+	switch r.algorithm {
+	case rateLimitSlidingWindow:
+		return newSlidingWindowLimiter(r.max, r.duration)
+	case rateLimitLeakyBucket:
+		return newLeakyBucketLimiter(r.burst, r.rate)
+	default:
+		return tollboothLimiter{lmt}
+	}
+}
+
+// tollboothLimiter is the token bucket of a tollbooth limiter.
+// Tollbooth keeps its own time, so the time of a request is ignored.
+type tollboothLimiter struct {
+	lmt *limiter.Limiter
+}
+
+func (l tollboothLimiter) allow(key string, t time.Time) (bool, time.Duration) {
+	if l.lmt.LimitReached(key) {
+		return false, limitRetryWait(l.lmt)
+	}
+	return true, 0
+}
+
+func (l tollboothLimiter) limit() (int64, time.Duration) {
+	return l.lmt.GetMax(), l.lmt.GetTTL()
+}
+
+// slidingWindowLimiter allows max requests per key within any window
+type slidingWindowLimiter struct {
+	sync.Mutex
+	max    int64
+	window time.Duration
+	// times of the requests of each key within the last window, oldest first
+	logs      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func newSlidingWindowLimiter(max int64, window time.Duration) *slidingWindowLimiter {
+	return &slidingWindowLimiter{
+		max:    max,
+		window: window,
+		logs:   make(map[string][]time.Time),
+	}
+}
+
+func (l *slidingWindowLimiter) allow(key string, t time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.sweep(t)
+
+	log := l.logs[key]
+	start := t.Add(-l.window)
+	i := 0
+	for i < len(log) && !log[i].After(start) {
+		i++
+	}
+	log = log[i:]
+
+	if int64(len(log)) >= l.max {
+		l.logs[key] = log
+		if len(log) == 0 {
+			return false, l.window
+		}
+		return false, log[0].Add(l.window).Sub(t)
+	}
+
+	l.logs[key] = append(log, t)
+	return true, 0
+}
+
+// sweep drops the keys without requests in the last window, once per window
+func (l *slidingWindowLimiter) sweep(t time.Time) {
+	if t.Sub(l.lastSweep) < l.window {
+		return
+	}
+	l.lastSweep = t
+
+	start := t.Add(-l.window)
+	for key, log := range l.logs {
+		if len(log) == 0 || !log[len(log)-1].After(start) {
+			delete(l.logs, key)
+		}
+	}
+}
+
+func (l *slidingWindowLimiter) limit() (int64, time.Duration) {
+	return l.max, l.window
+}
+
+// leakyBucketLimiter allows a burst of requests per key, which drains at rate requests per second
+type leakyBucketLimiter struct {
+	sync.Mutex
+	burst int
+	rate  float64
+	// buckets of each key, which fill by 1 per request
+	buckets   map[string]*leakyBucket
+	lastSweep time.Time
+}
+
+type leakyBucket struct {
+	level float64
+	t     time.Time
+}
+
+func newLeakyBucketLimiter(burst int, rate float64) *leakyBucketLimiter {
+	return &leakyBucketLimiter{
+		burst:   burst,
+		rate:    rate,
+		buckets: make(map[string]*leakyBucket),
+	}
+}
+
+func (l *leakyBucketLimiter) allow(key string, t time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.sweep(t)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &leakyBucket{t: t}
+		l.buckets[key] = b
+	}
+
+	b.level = l.drain(b, t)
+	b.t = t
+
+	if b.level+1 > float64(l.burst) {
+		if l.rate <= 0 {
+			return false, l.drainTime()
+		}
+		return false, time.Duration((b.level + 1 - float64(l.burst)) / l.rate * float64(time.Second))
+	}
+
+	b.level++
+	return true, 0
+}
+
+// drain returns the level of the bucket at t
+func (l *leakyBucketLimiter) drain(b *leakyBucket, t time.Time) float64 {
+	if !t.After(b.t) {
+		return b.level
+	}
+	return math.Max(0, b.level-t.Sub(b.t).Seconds()*l.rate)
+}
+
+// drainTime is how long a full bucket takes to drain
+func (l *leakyBucketLimiter) drainTime() time.Duration {
+	if l.rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+}
+
+// sweep drops the empty buckets, once per drainTime
+func (l *leakyBucketLimiter) sweep(t time.Time) {
+	if t.Sub(l.lastSweep) < l.drainTime() {
+		return
+	}
+	l.lastSweep = t
+
+	for key, b := range l.buckets {
+		if l.drain(b, t) == 0 {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *leakyBucketLimiter) limit() (int64, time.Duration) {
+	return int64(l.burst), l.drainTime()
+}
@@ -0,0 +1,177 @@
+package teller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gz-c/tollbooth"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+)
+
+func TestEndpointRateLimit(t *testing.T) {
+	c := config.Web{
+		ThrottleMax:      60,
+		ThrottleDuration: time.Minute,
+		RateLimit: config.RateLimit{
+			Algorithm: rateLimitSlidingWindow,
+			Endpoints: []config.RateLimitEndpoint{
+				{
+					Path:      "/api/bind",
+					Algorithm: rateLimitLeakyBucket,
+					Burst:     5,
+					Rate:      0.1,
+				},
+				{
+					Path:     "/api/status",
+					Max:      120,
+					Duration: time.Minute * 2,
+				},
+			},
+		},
+	}
+
+	require.Equal(t, rateLimitRule{
+		algorithm: rateLimitSlidingWindow,
+		max:       60,
+		duration:  time.Minute,
+		burst:     60,
+		rate:      1,
+	}, endpointRateLimit(c, "/api/qr"))
+
+	require.Equal(t, rateLimitRule{
+		algorithm: rateLimitLeakyBucket,
+		max:       60,
+		duration:  time.Minute,
+		burst:     5,
+		rate:      0.1,
+	}, endpointRateLimit(c, "/api/bind"))
+
+	require.Equal(t, rateLimitRule{
+		algorithm: rateLimitSlidingWindow,
+		max:       120,
+		duration:  time.Minute * 2,
+		burst:     120,
+		rate:      1,
+	}, endpointRateLimit(c, "/api/status"))
+
+	// No algorithm is the token bucket
+	require.Equal(t, rateLimitTokenBucket, endpointRateLimit(config.Web{}, "/api/bind").algorithm)
+}
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	l := newSlidingWindowLimiter(3, time.Minute)
+	t0 := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The whole window can be used at once
+	for i := 0; i < 3; i++ {
+		ok, _ := l.allow("a", t0.Add(time.Duration(i)*time.Second))
+		require.True(t, ok)
+	}
+
+	ok, wait := l.allow("a", t0.Add(time.Second*30))
+	require.False(t, ok)
+	require.Equal(t, time.Second*30, wait)
+
+	// Other keys have their own window
+	ok, _ = l.allow("b", t0.Add(time.Second*30))
+	require.True(t, ok)
+
+	// Requests are allowed again as the oldest leave the window, not all at once at a window edge
+	ok, _ = l.allow("a", t0.Add(time.Minute+time.Millisecond))
+	require.True(t, ok)
+	ok, wait = l.allow("a", t0.Add(time.Minute+time.Millisecond*2))
+	require.False(t, ok)
+	require.Equal(t, time.Second-time.Millisecond*2, wait)
+
+	// Idle keys are swept
+	ok, _ = l.allow("c", t0.Add(time.Hour))
+	require.True(t, ok)
+	require.Len(t, l.logs, 1)
+
+	max, duration := l.limit()
+	require.Equal(t, int64(3), max)
+	require.Equal(t, time.Minute, duration)
+}
+
+func TestLeakyBucketLimiter(t *testing.T) {
+	l := newLeakyBucketLimiter(2, 0.5)
+	t0 := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The burst is allowed
+	ok, _ := l.allow("a", t0)
+	require.True(t, ok)
+	ok, _ = l.allow("a", t0)
+	require.True(t, ok)
+
+	ok, wait := l.allow("a", t0)
+	require.False(t, ok)
+	require.Equal(t, time.Second*2, wait)
+
+	// The bucket drains at the sustained rate
+	ok, wait = l.allow("a", t0.Add(time.Second))
+	require.False(t, ok)
+	require.Equal(t, time.Second, wait)
+
+	ok, _ = l.allow("a", t0.Add(time.Second*2))
+	require.True(t, ok)
+	ok, _ = l.allow("a", t0.Add(time.Second*2))
+	require.False(t, ok)
+
+	// Empty buckets are swept
+	ok, _ = l.allow("b", t0.Add(time.Hour))
+	require.True(t, ok)
+	require.Len(t, l.buckets, 1)
+
+	max, duration := l.limit()
+	require.Equal(t, int64(2), max)
+	require.Equal(t, time.Second*4, duration)
+}
+
+func TestKeyLimit(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	lmt := tollbooth.NewLimiter(2, time.Minute, nil)
+	lh := keyLimit(newKeyLimiter(rateLimitRule{
+		algorithm: rateLimitSlidingWindow,
+		max:       2,
+		duration:  time.Minute,
+	}, lmt), lmt, 64, clk, h)
+
+	do := func(path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		lh.ServeHTTP(w, req)
+		return w
+	}
+
+	// Unlike the token bucket, both requests of the window can be made at once
+	w := do("/api/bind", "1.2.3.4:5000")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "2", w.Header().Get("X-Rate-Limit-Limit"))
+	require.Equal(t, "1m0s", w.Header().Get("X-Rate-Limit-Duration"))
+	require.Equal(t, http.StatusOK, do("/api/bind", "1.2.3.4:5001").Code)
+
+	clk.Advance(time.Second * 20)
+	w = do("/api/bind", "1.2.3.4:5000")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, errCodeRateLimited, w.Header().Get(errCodeHeader))
+	require.Equal(t, "40", w.Header().Get("Retry-After"))
+
+	// Clients are limited per path
+	require.Equal(t, http.StatusOK, do("/api/status", "1.2.3.4:5000").Code)
+
+	// And per IPv6 network
+	require.Equal(t, http.StatusOK, do("/api/bind", "[2001:db8::1]:5000").Code)
+	require.Equal(t, http.StatusOK, do("/api/bind", "[2001:db8::2]:5000").Code)
+	require.Equal(t, http.StatusTooManyRequests, do("/api/bind", "[2001:db8::3]:5000").Code)
+
+	clk.Advance(time.Second * 40)
+	require.Equal(t, http.StatusOK, do("/api/bind", "1.2.3.4:5000").Code)
+}
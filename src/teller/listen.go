@@ -0,0 +1,275 @@
+package teller
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/config"
+)
+
+// listenAddr is a web listen address
+type listenAddr struct {
+	name   string // "HTTP", "HTTPS", "HTTP IPv6" or "HTTPS IPv6"
+	addr   string
+	useTLS bool
+}
+
+// listenAddrs returns the listen addresses which are set in web
+func listenAddrs(web config.Web) []listenAddr {
+	var addrs []listenAddr
+	for _, a := range []listenAddr{
+		{"HTTP", web.HTTPAddr, false},
+		{"HTTP IPv6", web.HTTPAddr6, false},
+		{"HTTPS", web.HTTPSAddr, true},
+		{"HTTPS IPv6", web.HTTPSAddr6, true},
+	} {
+		if a.addr != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// webServer is the server of a listen address
+type webServer struct {
+	listenAddr
+	srv *http.Server
+	ln  net.Listener
+}
+
+// listenerSet serves the web listen addresses.
+// When the addresses are reloaded, the new addresses are listened on before the listeners which
+// they replace are shut down, so requests in flight finish and a reload which fails keeps the current listeners.
+type listenerSet struct {
+	log     logrus.FieldLogger
+	handler atomic.Value // http.Handler of the current web config
+	cert    atomic.Value // *tls.Certificate of web.tls_cert and web.tls_key
+	errC    chan error
+	wg      sync.WaitGroup // serving and draining servers
+
+	mu         sync.Mutex
+	web        config.Web
+	tlsConfig  *tls.Config
+	newHandler func(config.Web) http.Handler
+	servers    map[string]*webServer
+	started    bool
+	closed     bool
+}
+
+func newListenerSet(log logrus.FieldLogger) *listenerSet {
+	return &listenerSet{
+		log:     log,
+		errC:    make(chan error, 1),
+		servers: make(map[string]*webServer),
+	}
+}
+
+// ServeHTTP serves a request with the handler of the current web config
+func (l *listenerSet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.handler.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+// getCertificate returns the certificate of web.tls_cert and web.tls_key, for tls.Config.GetCertificate
+func (l *listenerSet) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, _ := l.cert.Load().(*tls.Certificate) // nolint: errcheck
+	if cert == nil {
+		return nil, errors.New("No TLS certificate loaded")
+	}
+	return cert, nil
+}
+
+// start listens on the addresses of web. The HTTPS listeners use tlsConfig,
+// and the requests are served by the handler which newHandler creates for the web config.
+func (l *listenerSet) start(web config.Web, tlsConfig *tls.Config, newHandler func(config.Web) http.Handler) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return errors.New("HTTP server is shut down")
+	}
+
+	l.tlsConfig = tlsConfig
+	l.newHandler = newHandler
+
+	if err := l.listen(web); err != nil {
+		return err
+	}
+	l.started = true
+
+	return nil
+}
+
+// reload changes the listen addresses and TLS certificate to those of web.
+// The other web settings are kept from the start.
+func (l *listenerSet) reload(web config.Web) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return errors.New("HTTP server is shut down")
+	}
+
+	if !l.started {
+		return errors.New("HTTP server is not running")
+	}
+
+	if web.AutoTLSHost != l.web.AutoTLSHost {
+		return errors.New("web.auto_tls_host can't be changed without a restart")
+	}
+
+	next := l.web.WithListen(web)
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	return l.listen(next)
+}
+
+// listen replaces the servers of the addresses which changed, l.mu must be locked
+func (l *listenerSet) listen(web config.Web) error {
+	var cert *tls.Certificate
+	if web.HTTPSAddr != "" && web.AutoTLSHost == "" {
+		c, err := tls.LoadX509KeyPair(web.TLSCert, web.TLSKey)
+		if err != nil {
+			return fmt.Errorf("load web.tls_cert and web.tls_key failed: %v", err)
+		}
+		cert = &c
+	}
+
+	addrs := listenAddrs(web)
+
+	// Listen on the new addresses, closing them all if one fails
+	var opened []*webServer
+	for _, a := range addrs {
+		if cur, ok := l.servers[a.name]; ok && cur.addr == a.addr {
+			continue
+		}
+
+		ln, err := net.Listen(listenNetwork(a.addr), a.addr)
+		if err != nil {
+			for _, ws := range opened {
+				ws.ln.Close() // nolint: errcheck
+			}
+			return fmt.Errorf("listen on %s failed: %v", a.addr, err)
+		}
+
+		srv := setupHTTPListener(a.addr, l)
+		if a.useTLS {
+			srv.TLSConfig = l.tlsConfig
+		}
+
+		opened = append(opened, &webServer{
+			listenAddr: a,
+			srv:        srv,
+			ln:         ln,
+		})
+	}
+
+	if cert != nil {
+		l.cert.Store(cert)
+	}
+	l.handler.Store(l.newHandler(web))
+	l.web = web
+
+	// Drain the servers of the addresses which changed or were removed
+	keep := make(map[string]bool, len(addrs))
+	for _, a := range addrs {
+		if cur, ok := l.servers[a.name]; ok && cur.addr == a.addr {
+			keep[a.name] = true
+		}
+	}
+	for name, ws := range l.servers {
+		if keep[name] {
+			continue
+		}
+		delete(l.servers, name)
+		l.wg.Add(1)
+		go func(ws *webServer) {
+			defer l.wg.Done()
+			l.drain(ws)
+		}(ws)
+	}
+
+	for _, ws := range opened {
+		l.servers[ws.name] = ws
+		l.serve(ws)
+	}
+
+	return nil
+}
+
+func (l *listenerSet) serve(ws *webServer) {
+	proto := "http"
+	if ws.useTLS {
+		proto = "https"
+	}
+	l.log.WithField("network", listenNetwork(ws.addr)).Info(fmt.Sprintf("%s server listening on %s://%s", strings.ToUpper(proto), proto, ws.addr))
+
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		var err error
+		if ws.useTLS {
+			// The certificate is from srv.TLSConfig
+			err = ws.srv.ServeTLS(ws.ln, "", "")
+		} else {
+			err = ws.srv.Serve(ws.ln)
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			l.log.WithError(err).WithField("addr", ws.addr).Error("ListenAndServe or ListenAndServeTLS error")
+			select {
+			case l.errC <- err:
+			default:
+			}
+		}
+	}()
+}
+
+// drain shuts a server down, waiting up to shutdownTimeout for its requests to finish
+func (l *listenerSet) drain(ws *webServer) {
+	log := l.log.WithFields(logrus.Fields{
+		"proto":   ws.name,
+		"addr":    ws.addr,
+		"timeout": shutdownTimeout,
+	})
+
+	defer log.Info("Shutdown server")
+	log.Info("Shutting down server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := ws.srv.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("HTTP server shutdown error")
+	}
+
+	// The listener isn't closed by Shutdown if Serve hasn't started yet
+	ws.ln.Close() // nolint: errcheck
+}
+
+// shutdown drains the servers, and waits for the servers drained by reloads
+func (l *listenerSet) shutdown() {
+	l.mu.Lock()
+	l.closed = true
+	for name, ws := range l.servers {
+		delete(l.servers, name)
+		l.wg.Add(1)
+		go func(ws *webServer) {
+			defer l.wg.Done()
+			l.drain(ws)
+		}(ws)
+	}
+	l.mu.Unlock()
+
+	l.wg.Wait()
+}
@@ -0,0 +1,157 @@
+package teller
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// freeAddr returns a local address which nothing listens on
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+	return addr
+}
+
+func TestListenerSetReload(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	l := newListenerSet(log)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	// The handler responds with the HTTP address of the config it was created for
+	newHandler := func(web config.Web) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				close(entered)
+				<-release
+			}
+			w.Write([]byte(web.HTTPAddr)) // nolint: errcheck
+		})
+	}
+
+	client := &http.Client{
+		Timeout: time.Second * 5,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // nolint: gosec
+		},
+	}
+	get := func(url string) (string, error) {
+		rsp, err := client.Get(url)
+		if err != nil {
+			return "", err
+		}
+		defer rsp.Body.Close()
+		body, err := ioutil.ReadAll(rsp.Body)
+		return string(body), err
+	}
+
+	addr1 := freeAddr(t)
+	web := config.Web{
+		HTTPAddr:     addr1,
+		AutoTLSCache: config.AutoTLSCacheDB,
+	}
+
+	// Reloading before the server runs fails
+	require.Error(t, l.reload(web))
+
+	require.NoError(t, l.start(web, &tls.Config{GetCertificate: l.getCertificate}, newHandler))
+
+	body, err := get("http://" + addr1 + "/")
+	require.NoError(t, err)
+	require.Equal(t, addr1, body)
+
+	// A request in flight when the address changes finishes on the old listener
+	slow := make(chan string, 1)
+	go func() {
+		body, err := get("http://" + addr1 + "/slow")
+		require.NoError(t, err)
+		slow <- body
+	}()
+	<-entered
+
+	addr2 := freeAddr(t)
+	web.HTTPAddr = addr2
+	require.NoError(t, l.reload(web))
+
+	body, err = get("http://" + addr2 + "/")
+	require.NoError(t, err)
+	require.Equal(t, addr2, body)
+
+	close(release)
+	require.Equal(t, addr1, <-slow)
+
+	// The old address stops listening once it is drained
+	for i := 0; ; i++ {
+		if _, err := get("http://" + addr1 + "/"); err != nil {
+			break
+		}
+		require.True(t, i < 500, "old listener wasn't shut down")
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// A reload which can't listen keeps the current listeners
+	taken, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer taken.Close()
+
+	web.HTTPAddr = taken.Addr().String()
+	require.Error(t, l.reload(web))
+
+	body, err = get("http://" + addr2 + "/")
+	require.NoError(t, err)
+	require.Equal(t, addr2, body)
+
+	// TLS is enabled with a reload, the HTTP listener is kept
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, testCertPEM(t, time.Now().Add(time.Hour)), 0600))
+
+	addr3 := freeAddr(t)
+	web.HTTPAddr = addr2
+	web.HTTPSAddr = addr3
+	web.TLSCert = filepath.Join(dir, "missing.pem")
+	web.TLSKey = web.TLSCert
+	require.Error(t, l.reload(web))
+
+	web.TLSCert = certFile
+	web.TLSKey = certFile
+	require.NoError(t, l.reload(web))
+
+	body, err = get("https://" + addr3 + "/")
+	require.NoError(t, err)
+	require.Equal(t, addr2, body)
+
+	body, err = get("http://" + addr2 + "/")
+	require.NoError(t, err)
+	require.Equal(t, addr2, body)
+
+	// An invalid config or a change of web.auto_tls_host is refused
+	web.TLSKey = ""
+	require.Error(t, l.reload(web))
+	web.TLSKey = certFile
+	web.AutoTLSHost = "example.com"
+	require.Error(t, l.reload(web))
+
+	l.shutdown()
+
+	_, err = get("http://" + addr2 + "/")
+	require.Error(t, err)
+	_, err = get("https://" + addr3 + "/")
+	require.Error(t, err)
+
+	web.AutoTLSHost = ""
+	require.Error(t, l.reload(web))
+}
@@ -0,0 +1,79 @@
+package teller
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+const (
+	// payoutLogDefaultLimit is the number of payout log entries returned if limit is not set
+	payoutLogDefaultLimit = 100
+	// payoutLogMaxLimit is the maximum number of payout log entries returned by a request
+	payoutLogMaxLimit = 1000
+)
+
+// PayoutLogResponse http response for /api/payouts/log
+type PayoutLogResponse struct {
+	Entries []exchange.PayoutLogEntry `json:"entries"`
+}
+
+// PayoutLogHandler returns the public log of skycoin payouts, oldest first.
+// Each entry's hash chains it to the entry before, see exchange.PayoutLogEntry.
+// Method: GET
+// URI: /api/payouts/log
+// Args:
+//
+//	since # optional, only entries with a greater seq are returned. Defaults to 0, the start of the log.
+//	limit # optional, maximum number of entries to return, up to 1000. Defaults to 100.
+func PayoutLogHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		var since uint64
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			since, err = strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid since"))
+				return
+			}
+		}
+
+		limit := payoutLogDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			limit, err = strconv.Atoi(v)
+			if err != nil || limit <= 0 || limit > payoutLogMaxLimit {
+				errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid limit"))
+				return
+			}
+		}
+
+		entries, err := s.service.GetPayoutLog(since, limit)
+		if err != nil {
+			log.WithError(err).Error("service.GetPayoutLog failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		if entries == nil {
+			entries = []exchange.PayoutLogEntry{}
+		}
+
+		if err := httputil.JSONResponse(w, PayoutLogResponse{
+			Entries: entries,
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package teller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type payoutLogExchanger struct {
+	exchange.Exchanger
+	entries []exchange.PayoutLogEntry
+}
+
+func (e payoutLogExchanger) GetPayoutLog(since uint64, limit int) ([]exchange.PayoutLogEntry, error) {
+	var entries []exchange.PayoutLogEntry
+	for _, pe := range e.entries {
+		if pe.Seq > since && len(entries) < limit {
+			entries = append(entries, pe)
+		}
+	}
+	return entries, nil
+}
+
+func TestPayoutLogHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	var entries []exchange.PayoutLogEntry
+	for i := uint64(1); i <= 150; i++ {
+		entries = append(entries, exchange.PayoutLogEntry{
+			Seq:          i,
+			AmountBucket: "1-10",
+		})
+	}
+
+	s := NewHTTPServer(log, config.Config{}, &Service{
+		exchanger: payoutLogExchanger{
+			entries: entries,
+		},
+	}, nil, clock.Real{})
+
+	do := func(method, query string) (int, PayoutLogResponse) {
+		req := httptest.NewRequest(method, "/api/payouts/log"+query, nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+
+		PayoutLogHandler(s)(w, req)
+
+		var rsp PayoutLogResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+		}
+		return w.Code, rsp
+	}
+
+	code, rsp := do(http.MethodGet, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, rsp.Entries, payoutLogDefaultLimit)
+	require.Equal(t, uint64(1), rsp.Entries[0].Seq)
+
+	code, rsp = do(http.MethodGet, "?since=100&limit=20")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, rsp.Entries, 20)
+	require.Equal(t, uint64(101), rsp.Entries[0].Seq)
+
+	// The end of the log is an empty list, not null
+	req := httptest.NewRequest(http.MethodGet, "/api/payouts/log?since=150", nil)
+	req = req.WithContext(logger.WithContext(req.Context(), log))
+	w := httptest.NewRecorder()
+	PayoutLogHandler(s)(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.JSONEq(t, `{"entries":[]}`, w.Body.String())
+
+	for _, query := range []string{"?since=-1", "?since=x", "?limit=0", "?limit=1001", "?limit=x"} {
+		code, _ = do(http.MethodGet, query)
+		require.Equal(t, http.StatusBadRequest, code, query)
+	}
+
+	code, _ = do(http.MethodPost, "")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+}
@@ -0,0 +1,129 @@
+package teller
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gz-c/tollbooth/libstring"
+	"github.com/gz-c/tollbooth/limiter"
+
+	"github.com/skycoin/teller/src/abuse"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/httputil"
+)
+
+// ipLimit wraps a handler to rate limit requests per client IP and path, like tollbooth.LimitHandler.
+// Unlike tollbooth, client IPs are normalized, and IPv6 addresses are bucketed by their
+// first ipv6Prefix bits, so that a host can't bypass the limit by rotating through the
+// addresses of its subnet.
+func ipLimit(lmt *limiter.Limiter, ipv6Prefix int, h http.Handler) http.Handler {
+	return keyLimit(tollboothLimiter{lmt}, lmt, ipv6Prefix, clock.Real{}, h)
+}
+
+// keyLimit wraps a handler to rate limit requests per client IP and path with kl, at the time of clk.
+// Clients are identified like in ipLimit, with the IP lookups of lmt, and limited requests get lmt's response.
+func keyLimit(kl keyLimiter, lmt *limiter.Limiter, ipv6Prefix int, clk clock.Clock, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		max, duration := kl.limit()
+		w.Header().Add("X-Rate-Limit-Limit", strconv.FormatInt(max, 10))
+		w.Header().Add("X-Rate-Limit-Duration", duration.String())
+
+		ip := libstring.RemoteIP(lmt.GetIPLookups(), lmt.GetForwardedForIndexFromBehind(), r)
+		if ip != "" {
+			key := strings.Join([]string{ipKey(ip, ipv6Prefix), r.URL.Path}, "|")
+			if ok, wait := kl.allow(key, clk.Now()); !ok {
+				lmt.ExecOnLimitReached(w, r)
+				w.Header().Set(errCodeHeader, errCodeRateLimited)
+				w.Header().Set("Retry-After", retryAfterSeconds(wait))
+				w.Header().Add("Content-Type", lmt.GetMessageContentType())
+				w.WriteHeader(lmt.GetStatusCode())
+				w.Write([]byte(lmt.GetMessage())) // nolint: errcheck
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// abuseLimit wraps a handler to refuse the requests of clients throttled by the abuse detection.
+// Clients are identified like in ipLimit, with the IP lookups of lmt.
+func abuseLimit(g *abuse.Guard, lmt *limiter.Limiter, ipv6Prefix int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := libstring.RemoteIP(lmt.GetIPLookups(), lmt.GetForwardedForIndexFromBehind(), r)
+		if ip != "" {
+			d := g.Check(ipKey(ip, ipv6Prefix), parseHostIP(ip), r.UserAgent(), r.Header.Get(captchaTokenHeader))
+			if !d.Allow {
+				errCode := errCodeRateLimited
+				if d.Captcha {
+					errCode = errCodeCaptchaRequired
+				}
+				w.Header().Set(errCodeHeader, errCode)
+				w.Header().Set("Retry-After", retryAfterSeconds(d.RetryAfter))
+				httputil.ErrResponse(w, http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limitRetryAfter returns the Retry-After seconds of a limited request, the time for the limiter to allow one more
+func limitRetryAfter(lmt *limiter.Limiter) string {
+	return retryAfterSeconds(limitRetryWait(lmt))
+}
+
+// limitRetryWait returns the time for the limiter to allow one more request
+func limitRetryWait(lmt *limiter.Limiter) time.Duration {
+	if lmt.GetMax() <= 0 {
+		return lmt.GetTTL()
+	}
+	return lmt.GetTTL() / time.Duration(lmt.GetMax())
+}
+
+// ipKey returns the rate limiting key of a client IP.
+// The IP may have a port and brackets, e.g. "[2001:db8::1]:5000" from a RemoteAddr.
+// IPv4 and IPv4-mapped IPv6 addresses are keyed by the IPv4 address.
+// IPv6 addresses are keyed by their ipv6Prefix network, e.g. "2001:db8::/64".
+// If ipv6Prefix is 0 or 128, they are keyed by the full address.
+// Strings which aren't IPs are returned unchanged.
+func ipKey(s string, ipv6Prefix int) string {
+	s = strings.TrimSpace(s)
+	ip := parseHostIP(s)
+	if ip == nil {
+		return s
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	if ipv6Prefix <= 0 || ipv6Prefix >= 8*net.IPv6len {
+		return ip.String()
+	}
+
+	n := net.IPNet{
+		IP:   ip.Mask(net.CIDRMask(ipv6Prefix, 8*net.IPv6len)),
+		Mask: net.CIDRMask(ipv6Prefix, 8*net.IPv6len),
+	}
+	return n.String()
+}
+
+// parseHostIP parses a client IP which may have a port, brackets and a zone.
+// It returns nil if s isn't an IP.
+func parseHostIP(s string) net.IP {
+	host := strings.TrimSpace(s)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if i := strings.LastIndex(host, "%"); i >= 0 {
+		host = host[:i]
+	}
+
+	return net.ParseIP(host)
+}
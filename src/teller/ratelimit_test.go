@@ -0,0 +1,162 @@
+package teller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gz-c/tollbooth"
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/abuse"
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestIPKey(t *testing.T) {
+	cases := []struct {
+		ip     string
+		prefix int
+		key    string
+	}{
+		{"1.2.3.4", 64, "1.2.3.4"},
+		{"1.2.3.4:5000", 64, "1.2.3.4"},
+		{"::ffff:1.2.3.4", 64, "1.2.3.4"},
+		{"[::ffff:1.2.3.4]:5000", 64, "1.2.3.4"},
+		{" 2001:db8::1 ", 64, "2001:db8::/64"},
+		{"2001:DB8:0:0:ffff::1", 64, "2001:db8::/64"},
+		{"[2001:db8:0:1::1]:5000", 64, "2001:db8:0:1::/64"},
+		{"[2001:db8:0:1::1]", 48, "2001:db8::/48"},
+		{"[fe80::1%eth0]:5000", 64, "fe80::/64"},
+		{"2001:db8::1", 128, "2001:db8::1"},
+		{"2001:db8::1", 0, "2001:db8::1"},
+		{"not-an-ip", 64, "not-an-ip"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.ip, func(t *testing.T) {
+			require.Equal(t, tc.key, ipKey(tc.ip, tc.prefix))
+		})
+	}
+}
+
+func TestIPLimit(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	do := func(h http.Handler, remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/bind", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Addresses of the same /64 share a limit
+	lh := ipLimit(tollbooth.NewLimiter(1, time.Hour, nil), 64, h)
+	require.Equal(t, http.StatusOK, do(lh, "[2001:db8::1]:5000"))
+	require.Equal(t, http.StatusTooManyRequests, do(lh, "[2001:db8::2]:5001"))
+	require.Equal(t, http.StatusOK, do(lh, "[2001:db8:0:1::1]:5000"))
+	require.Equal(t, http.StatusOK, do(lh, "1.2.3.4:5000"))
+	require.Equal(t, http.StatusTooManyRequests, do(lh, "[::ffff:1.2.3.4]:5001"))
+
+	// Per address limits
+	lh = ipLimit(tollbooth.NewLimiter(1, time.Hour, nil), 128, h)
+	require.Equal(t, http.StatusOK, do(lh, "[2001:db8::1]:5000"))
+	require.Equal(t, http.StatusOK, do(lh, "[2001:db8::2]:5000"))
+	require.Equal(t, http.StatusTooManyRequests, do(lh, "[2001:db8:0::2]:5001"))
+
+	// Behind a proxy, the forwarded address is limited
+	lmt := tollbooth.NewLimiter(1, time.Hour, nil)
+	lmt.SetIPLookups([]string{"X-Forwarded-For", "RemoteAddr", "X-Real-IP"})
+	lh = ipLimit(lmt, 64, h)
+	forwarded := func(ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/bind", nil)
+		req.Header.Set("X-Forwarded-For", ip)
+		w := httptest.NewRecorder()
+		lh.ServeHTTP(w, req)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, forwarded("2001:db8::1"))
+	require.Equal(t, http.StatusTooManyRequests, forwarded("10.0.0.1, 2001:db8::ab"))
+}
+
+func TestIPLimitRetryAfter(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	lh := ipLimit(tollbooth.NewLimiter(4, time.Minute, nil), 64, h)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/bind", nil)
+	req.RemoteAddr = "1.2.3.4:5000"
+
+	w := httptest.NewRecorder()
+	lh.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Empty(t, w.Header().Get("Retry-After"))
+
+	// The limiter allows a request every 15 seconds
+	w = httptest.NewRecorder()
+	lh.ServeHTTP(w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, errCodeRateLimited, w.Header().Get(errCodeHeader))
+	require.Equal(t, "15", w.Header().Get("Retry-After"))
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	require.Equal(t, "1", retryAfterSeconds(0))
+	require.Equal(t, "1", retryAfterSeconds(-time.Hour))
+	require.Equal(t, "1", retryAfterSeconds(time.Millisecond))
+	require.Equal(t, "2", retryAfterSeconds(time.Second+time.Millisecond))
+	require.Equal(t, "3600", retryAfterSeconds(time.Hour))
+}
+
+func TestAbuseLimit(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	clk := clock.NewFake(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+
+	captcha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"success": %t}`, r.PostFormValue("response") == "solved")
+	}))
+	defer captcha.Close()
+
+	g, err := abuse.New(log, config.Abuse{
+		Enabled:          true,
+		BotUserAgents:    []string{"curl"},
+		StrikeTTL:        time.Hour,
+		Penalty:          time.Minute,
+		MaxPenalty:       time.Hour,
+		CaptchaVerifyURL: captcha.URL,
+		CaptchaSecret:    "secret",
+	}, clk)
+	require.NoError(t, err)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	lh := abuseLimit(g, tollbooth.NewLimiter(100, time.Hour, nil), 64, h)
+
+	do := func(remoteAddr, userAgent, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/bind", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", userAgent)
+		if token != "" {
+			req.Header.Set(captchaTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		lh.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusOK, do("[2001:db8::1]:5000", "Mozilla/5.0", "").Code)
+
+	w := do("[2001:db8::1]:5000", "curl/7.58.0", "")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, errCodeCaptchaRequired, w.Header().Get(errCodeHeader))
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+
+	// Clients are keyed by their IPv6 network
+	require.Equal(t, http.StatusTooManyRequests, do("[2001:db8::2]:5000", "Mozilla/5.0", "").Code)
+	require.Equal(t, http.StatusOK, do("1.2.3.4:5000", "Mozilla/5.0", "").Code)
+
+	require.Equal(t, http.StatusTooManyRequests, do("[2001:db8::2]:5000", "Mozilla/5.0", "wrong").Code)
+	require.Equal(t, http.StatusOK, do("[2001:db8::2]:5000", "Mozilla/5.0", "solved").Code)
+}
@@ -0,0 +1,114 @@
+package teller
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/skycoin/teller/src/receipt"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// ReceiptHandler returns the receipt of a completed deposit, as HTML or PDF.
+// The deposit_id and sig are the query parameters of the receipt link in the deposit's status and payout email.
+// Method: GET
+// URI: /api/receipt
+// Args:
+//
+//	deposit_id # the deposit's ID, "txid:n"
+//	sig # the signature of the receipt link
+//	format # optional, "html" (default) or "pdf" if PDF receipts are enabled
+func ReceiptHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		if s.receipts == nil {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("Receipts disabled"))
+			return
+		}
+
+		query := r.URL.Query()
+		depositID := strings.Trim(query.Get("deposit_id"), "\n\t ")
+		sig := query.Get("sig")
+
+		switch {
+		case depositID == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing deposit_id"))
+			return
+		case sig == "":
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing sig"))
+			return
+		}
+
+		format := query.Get("format")
+		switch format {
+		case "":
+			format = receipt.FormatHTML
+		case receipt.FormatHTML:
+		case receipt.FormatPDF:
+			if !s.receipts.PDFEnabled() {
+				errorResponse(ctx, w, http.StatusForbidden, receipt.ErrPDFDisabled)
+				return
+			}
+		default:
+			errorResponse(ctx, w, http.StatusBadRequest, receipt.ErrInvalidFormat)
+			return
+		}
+
+		if err := s.receipts.Verify(depositID, sig); err != nil {
+			errorResponse(ctx, w, http.StatusForbidden, err)
+			return
+		}
+
+		log = log.WithField("depositID", depositID)
+		ctx = logger.WithContext(ctx, log)
+
+		rec, err := s.service.GetDepositRecord(depositID)
+		if err != nil {
+			log.WithError(err).Error("service.GetDepositRecord failed")
+			serviceErrorResponse(ctx, w, err)
+			return
+		}
+
+		rcpt, err := s.receipts.Receipt(rec)
+		if err != nil {
+			if err == receipt.ErrNotCompleted {
+				errorResponse(ctx, w, http.StatusNotFound, err)
+				return
+			}
+			log.WithError(err).Error("receipts.Receipt failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		var b bytes.Buffer
+		if err := s.receipts.Render(&b, rcpt, format); err != nil {
+			log.WithError(err).Error("receipts.Render failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", receipt.ContentType(format))
+		if format == receipt.FormatPDF {
+			w.Header().Set("Content-Disposition", `attachment; filename="receipt-`+strings.Replace(depositID, ":", "-", -1)+`.pdf"`)
+		}
+
+		// A completed deposit's receipt doesn't change, but it is only cached by the depositor's browser
+		w.Header().Set("Cache-Control", "private, max-age=86400")
+
+		if _, err := w.Write(b.Bytes()); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
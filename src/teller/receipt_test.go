@@ -0,0 +1,144 @@
+package teller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/receipt"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type receiptExchanger struct {
+	exchange.Exchanger
+	records map[string]exchange.DepositRecord
+}
+
+func (e receiptExchanger) GetDepositRecord(depositID string) (exchange.DepositRecord, error) {
+	r, ok := e.records[depositID]
+	if !ok {
+		return exchange.DepositRecord{}, exchange.ErrDepositNotFound
+	}
+	return r, nil
+}
+
+func TestReceiptHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	var done, sending exchange.DepositRecord
+	done.Status = exchange.StatusDone.String()
+	done.CoinType = "BTC"
+	done.DepositID = "btctx:1"
+	done.DepositValue = 1e8
+	done.ConversionRate = "500"
+	done.SkySent = 500e6
+	done.Txid = "skytx"
+	sending = done
+	sending.DepositID = "btctx:2"
+	sending.Status = exchange.StatusWaitConfirm.String()
+
+	receipts, err := receipt.NewService(receipt.Config{
+		URL:        "https://teller.example.com/api/receipt",
+		SigningKey: "secret",
+	})
+	require.NoError(t, err)
+
+	cfg := config.Config{}
+	cfg.Web.APIEnabled = true
+	s := NewHTTPServer(log, cfg, &Service{
+		exchanger: receiptExchanger{
+			records: map[string]exchange.DepositRecord{
+				done.DepositID:    done,
+				sending.DepositID: sending,
+			},
+		},
+	}, nil, clock.Real{})
+
+	do := func(method, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/receipt"+query, nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+		ReceiptHandler(s)(w, req)
+		return w
+	}
+
+	query := func(depositID string) string {
+		u, err := url.Parse(receipts.Link(depositID))
+		require.NoError(t, err)
+		return "?" + u.RawQuery
+	}
+
+	// Receipts disabled
+	w := do(http.MethodGet, query(done.DepositID))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), "Receipts disabled")
+
+	s.receipts = receipts
+
+	w = do(http.MethodPost, query(done.DepositID))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = do(http.MethodGet, query(done.DepositID))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, "private, max-age=86400", w.Header().Get("Cache-Control"))
+	require.Contains(t, w.Body.String(), "<tr><th>Paid out</th><td>500.000000 SKY</td></tr>")
+
+	w = do(http.MethodGet, query(done.DepositID)+"&format=html")
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// PDF receipts are disabled
+	w = do(http.MethodGet, query(done.DepositID)+"&format=pdf")
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = do(http.MethodGet, query(done.DepositID)+"&format=txt")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do(http.MethodGet, "?sig=00")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Missing deposit_id")
+
+	w = do(http.MethodGet, "?deposit_id=btctx:1")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), "Missing sig")
+
+	// The signature of another deposit is invalid
+	w = do(http.MethodGet, strings.Replace(query(done.DepositID), "btctx%3A1", "btctx%3A2", 1))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Contains(t, w.Body.String(), "Invalid signature")
+
+	// A deposit which isn't done has no receipt yet
+	w = do(http.MethodGet, query(sending.DepositID))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	w = do(http.MethodGet, query("missing:0"))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	// PDF receipts are downloaded
+	s.receipts, err = receipt.NewService(receipt.Config{
+		URL:        "https://teller.example.com/api/receipt",
+		SigningKey: "secret",
+		PDF:        true,
+	})
+	require.NoError(t, err)
+
+	w = do(http.MethodGet, query(done.DepositID)+"&format=pdf")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/pdf", w.Header().Get("Content-Type"))
+	require.Equal(t, `attachment; filename="receipt-btctx-1.pdf"`, w.Header().Get("Content-Disposition"))
+	require.True(t, bytes.HasPrefix(w.Body.Bytes(), []byte("%PDF-")))
+
+	// The API is disabled
+	s.cfg.Web.APIEnabled = false
+	w = do(http.MethodGet, query(done.DepositID))
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
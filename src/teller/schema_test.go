@@ -0,0 +1,124 @@
+package teller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// publicSchemas are the response types of the public API, by the name of their schema in testdata/schemas
+var publicSchemas = map[string]interface{}{
+	"bind":           BindResponse{},
+	"cancel_bind":    struct{}{},
+	"status":         StatusResponse{},
+	"status_wait":    StatusWaitResponse{},
+	"status_ws":      StatusUpdate{},
+	"config":         ConfigResponse{},
+	"version":        VersionResponse{},
+	"rates_history":  RateHistoryResponse{},
+	"public_status":  PublicStatusResponse{},
+	"stats":          StatsResponse{},
+	"payouts_log":    PayoutLogResponse{},
+	"verify_address": VerifyAddressResponse{},
+	"contact_erase":  struct{}{},
+	"widget_session": WidgetSessionResponse{},
+	"health":         HealthResponse{},
+}
+
+func TestPublicResponseSchemas(t *testing.T) {
+	for name, v := range publicSchemas {
+		t.Run(name, func(t *testing.T) {
+			testutil.RequireSchema(t, name, v)
+		})
+	}
+}
+
+func TestPublicResponsesMatchSchemas(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	cfg := config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+		BtcScanner: config.BtcScanner{
+			ConfirmationsRequired: 2,
+		},
+		SkyExchanger: config.SkyExchanger{
+			SkyBtcExchangeRate: "500",
+			SkyEthExchangeRate: "50",
+		},
+	}
+
+	withExchanger := func(e exchange.Exchanger) *HTTPServer {
+		return NewHTTPServer(log, cfg, &Service{
+			exchanger: e,
+		}, nil, clock.Real{})
+	}
+
+	s := withExchanger(nil)
+	statuses := withExchanger(statusExchanger{
+		statuses: []exchange.DepositStatus{
+			{
+				Seq:           1,
+				UpdatedAt:     1519905600,
+				Status:        exchange.StatusWaitSend.String(),
+				CoinType:      scanner.CoinTypeBTC,
+				Confirmations: 3,
+			},
+		},
+	})
+	rates := withExchanger(rateHistoryExchanger{
+		rates: []exchange.RateChange{
+			{Seq: 1, CoinType: scanner.CoinTypeBTC, Rate: "500", EffectiveAt: 100},
+			{Seq: 2, CoinType: scanner.CoinTypeBTC, Rate: "512.25", MaxDecimals: 1, EffectiveAt: 200},
+		},
+	})
+	payouts := withExchanger(payoutLogExchanger{
+		entries: []exchange.PayoutLogEntry{
+			{Seq: 1, Time: 1519905600, SkyAddressHash: "ab", AmountBucket: "1-10", Txid: "tx1", Hash: "cd"},
+		},
+	})
+
+	cfg.Archive.Enabled = true
+	archived := withExchanger(nil)
+
+	for _, tc := range []struct {
+		schema  string
+		s       *HTTPServer
+		handler func(*HTTPServer) http.HandlerFunc
+		uri     string
+	}{
+		{"status", statuses, StatusHandler, "/api/status?skyaddr=2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"},
+		{"config", s, ConfigHandler, "/api/config"},
+		{"version", s, VersionHandler, "/api/version"},
+		{"rates_history", rates, RateHistoryHandler, "/api/rates/history"},
+		{"payouts_log", payouts, PayoutLogHandler, "/api/payouts/log"},
+		{"verify_address", s, VerifyAddressHandler, "/api/verify-address?address=invalid"},
+		{"public_status", archived, PublicStatusHandler, "/api/public-status"},
+		{"health", s, ReadyHandler, "/readyz"},
+	} {
+		t.Run(tc.schema, func(t *testing.T) {
+			schema := testutil.RequireSchema(t, tc.schema, publicSchemas[tc.schema])
+
+			req := httptest.NewRequest(http.MethodGet, tc.uri, nil)
+			req = req.WithContext(logger.WithContext(req.Context(), log))
+			w := httptest.NewRecorder()
+
+			tc.handler(tc.s)(w, req)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			require.NoError(t, schema.Validate(w.Body.Bytes()), w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package teller
+
+import (
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/skycoin/teller/src/config"
+)
+
+// staticHandler serves the static website from cfg.StaticDir.
+// If cfg.Languages is set, each language's frontend build is in a subdirectory of cfg.StaticDir.
+// A path which starts with a language, e.g. /zh/index.html, is served from that language's build.
+// Other paths are served from the build of the language in the cfg.LanguageCookie cookie,
+// or else the language negotiated from Accept-Language, or else the first language.
+func staticHandler(cfg config.Web) http.Handler {
+	files := http.FileServer(http.Dir(cfg.StaticDir))
+	if len(cfg.Languages) == 0 {
+		return files
+	}
+
+	languages := make(map[string]string, len(cfg.Languages))
+	for _, l := range cfg.Languages {
+		languages[strings.ToLower(l)] = l
+	}
+
+	builds := make(map[string]http.Handler, len(cfg.Languages))
+	for _, l := range cfg.Languages {
+		builds[l] = http.FileServer(http.Dir(filepath.Join(cfg.StaticDir, l)))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		if _, ok := builds[prefix]; ok {
+			w.Header().Set("Content-Language", prefix)
+			files.ServeHTTP(w, r)
+			return
+		}
+
+		l := ""
+		if c, err := r.Cookie(cfg.LanguageCookie); err == nil {
+			l = languages[strings.ToLower(c.Value)]
+		}
+		if l == "" {
+			l = negotiateLanguage(r.Header.Get("Accept-Language"), cfg.Languages)
+		}
+
+		// The response depends on the language, so caches must not share it between languages
+		w.Header().Add("Vary", "Accept-Language, Cookie")
+		w.Header().Set("Content-Language", l)
+		builds[l].ServeHTTP(w, r)
+	})
+}
+
+// negotiateLanguage returns the language of languages which an Accept-Language header prefers.
+// A language matches a tag of the header exactly, or else by their primary subtag, e.g. "zh" matches "zh-CN".
+// It returns the first language if the header accepts none of them.
+func negotiateLanguage(acceptLanguage string, languages []string) string {
+	type accepted struct {
+		tag string
+		q   float64
+	}
+
+	var tags []accepted
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, p := range fields[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimPrefix(p, "q="), 64)
+			if err != nil {
+				v = 0
+			}
+			q = v
+		}
+
+		if q > 0 {
+			tags = append(tags, accepted{tag, q})
+		}
+	}
+
+	// Tags of equal quality keep the order of the header
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].q > tags[j].q
+	})
+
+	primary := func(tag string) string {
+		return strings.SplitN(tag, "-", 2)[0]
+	}
+
+	for _, a := range tags {
+		if a.tag == "*" {
+			break
+		}
+
+		for _, l := range languages {
+			if strings.ToLower(l) == a.tag {
+				return l
+			}
+		}
+
+		for _, l := range languages {
+			if primary(strings.ToLower(l)) == primary(a.tag) {
+				return l
+			}
+		}
+	}
+
+	return languages[0]
+}
@@ -0,0 +1,107 @@
+package teller
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	languages := []string{"en", "zh", "pt-BR"}
+
+	tt := []struct {
+		name           string
+		acceptLanguage string
+		language       string
+	}{
+		{"no header", "", "en"},
+		{"exact", "zh", "zh"},
+		{"case insensitive", "PT-br", "pt-BR"},
+		{"primary subtag", "zh-CN,en;q=0.8", "zh"},
+		{"primary subtag of the language", "pt", "pt-BR"},
+		{"quality", "en;q=0.5, zh;q=0.9", "zh"},
+		{"order of equal quality", "zh, en", "zh"},
+		{"unavailable", "de-DE, fr;q=0.8, zh;q=0.1", "zh"},
+		{"refused", "zh;q=0, de", "en"},
+		{"wildcard", "de, *;q=0.5, zh;q=0.1", "en"},
+		{"invalid quality", "zh;q=x, pt", "pt-BR"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.language, negotiateLanguage(tc.acceptLanguage, languages))
+		})
+	}
+}
+
+func TestStaticHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, l := range []string{"", "en", "zh"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, l), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, l, "index.html"), []byte("index"+l), 0644))
+	}
+
+	get := func(h http.Handler, path, acceptLanguage string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// Without languages, the static dir is served
+	h := staticHandler(config.Web{
+		StaticDir: dir,
+	})
+	w := get(h, "/", "zh", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "index", w.Body.String())
+	require.Empty(t, w.Header().Get("Content-Language"))
+
+	h = staticHandler(config.Web{
+		StaticDir:      dir,
+		Languages:      []string{"en", "zh"},
+		LanguageCookie: "lang",
+	})
+
+	w = get(h, "/", "", nil)
+	require.Equal(t, "indexen", w.Body.String())
+	require.Equal(t, "en", w.Header().Get("Content-Language"))
+	require.Equal(t, "Accept-Language, Cookie", w.Header().Get("Vary"))
+
+	w = get(h, "/", "zh-CN,zh;q=0.9", nil)
+	require.Equal(t, "indexzh", w.Body.String())
+	require.Equal(t, "zh", w.Header().Get("Content-Language"))
+
+	// The cookie overrides Accept-Language
+	w = get(h, "/", "zh-CN", &http.Cookie{Name: "lang", Value: "en"})
+	require.Equal(t, "indexen", w.Body.String())
+
+	// An unknown language in the cookie is ignored
+	w = get(h, "/", "zh-CN", &http.Cookie{Name: "lang", Value: "de"})
+	require.Equal(t, "indexzh", w.Body.String())
+
+	// A language's path is served from its build, whatever the request prefers
+	w = get(h, "/zh/", "en", &http.Cookie{Name: "lang", Value: "en"})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "indexzh", w.Body.String())
+	require.Equal(t, "zh", w.Header().Get("Content-Language"))
+
+	w = get(h, "/missing.html", "", nil)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,391 @@
+package teller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/skycoin/skycoin/src/util/droplet"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/deposits"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// statsRetryAfter is the Retry-After seconds sent when the stream has too many clients
+const statsRetryAfter = "30"
+
+// errTooManyStatsClients is returned when stats.max_clients streams are open
+var errTooManyStatsClients = errors.New("Too many stats stream clients")
+
+// StatsResponse is the response of /api/stats, and an event of /api/stats/stream.
+// The amounts and participants are rounded down to the stats granularity.
+type StatsResponse struct {
+	// Deposits received by coin type, in whole coins. Omitted if withheld.
+	Raised map[string]string `json:"raised,omitempty"`
+	// Number of distinct skycoin addresses which made a deposit
+	Participants int `json:"participants"`
+	// Omitted if withheld
+	SkySent string `json:"sky_sent,omitempty"`
+	// Omitted if stats.sky_cap is not set, or if withheld
+	SkyRemaining string `json:"sky_remaining,omitempty"`
+	// Omitted if sky_exchanger.campaign_cap is not set
+	Cap *CapStatus `json:"cap,omitempty"`
+	// Withheld is true while fewer than stats.min_participants made a deposit, the amounts aren't published then
+	Withheld  bool  `json:"withheld"`
+	UpdatedAt int64 `json:"updated_at"`
+}
+
+// CapStatus is the campaign's progress towards its cap, in whole coins.
+// Only deposits which were sent skycoin count towards the cap, so the amounts can be less than
+// the raised and sent totals of the StatsResponse. The raised and sent amounts are omitted if withheld.
+type CapStatus struct {
+	// Omitted if the BTC raised is not capped
+	MaxBTC    string `json:"max_btc,omitempty"`
+	BTCRaised string `json:"btc_raised,omitempty"`
+	// Omitted if the skycoin sent is not capped
+	MaxSky  string `json:"max_sky,omitempty"`
+	SkySent string `json:"sky_sent,omitempty"`
+	// Reached is true when the cap was reached, binding is closed then
+	Reached bool `json:"reached"`
+}
+
+// statsStream caches the latest campaign stats, so that the ledger is read
+// at most once per interval however many clients are streaming or polling
+type statsStream struct {
+	cfg    config.Stats
+	skyCap uint64 // droplets, only used if cfg.SkyCap is set
+	// Granularity of the amounts in ledger units by coin type, and in droplets. 0 if not rounded.
+	granularity    map[string]int64
+	skyGranularity uint64
+
+	sync.Mutex
+	latest  *StatsResponse
+	updated time.Time
+	clients int
+}
+
+func newStatsStream(cfg config.Stats) *statsStream {
+	var skyCap uint64
+	if cfg.SkyCap != "" {
+		// SkyCap is checked by config.Stats.Validate
+		skyCap, _ = droplet.FromString(cfg.SkyCap) // nolint: errcheck
+	}
+
+	// The granularities are checked by config.Stats.Validate
+	granularity := make(map[string]int64, 2)
+	for coinType, g := range map[string]string{
+		deposits.CoinTypeBTC: cfg.BTCGranularity,
+		deposits.CoinTypeETH: cfg.ETHGranularity,
+	} {
+		if g == "" {
+			continue
+		}
+		coin, _ := deposits.GetCoin(coinType) // nolint: errcheck
+		d, _ := decimal.NewFromString(g)      // nolint: errcheck
+		granularity[coinType] = d.Mul(decimal.New(1, coin.Decimals)).IntPart()
+	}
+
+	var skyGranularity uint64
+	if cfg.SkyGranularity != "" {
+		skyGranularity, _ = droplet.FromString(cfg.SkyGranularity) // nolint: errcheck
+	}
+
+	return &statsStream{
+		cfg:            cfg,
+		skyCap:         skyCap,
+		granularity:    granularity,
+		skyGranularity: skyGranularity,
+	}
+}
+
+// roundDown rounds amount down to a multiple of granularity, if granularity is set
+func roundDown(amount, granularity int64) int64 {
+	if granularity <= 0 {
+		return amount
+	}
+	return amount - amount%granularity
+}
+
+// roundDownSky rounds droplets down to a multiple of stats.sky_granularity
+func (ss *statsStream) roundDownSky(droplets uint64) uint64 {
+	if ss.skyGranularity == 0 {
+		return droplets
+	}
+	return droplets - droplets%ss.skyGranularity
+}
+
+// join registers a client, returning false if stats.max_clients are already streaming
+func (ss *statsStream) join() bool {
+	ss.Lock()
+	defer ss.Unlock()
+
+	if ss.clients >= ss.cfg.MaxClients {
+		return false
+	}
+
+	ss.clients++
+	return true
+}
+
+// leave unregisters a client
+func (ss *statsStream) leave() {
+	ss.Lock()
+	defer ss.Unlock()
+	ss.clients--
+}
+
+// get returns the cached stats, reading them from the ledger if they are older than the interval
+func (ss *statsStream) get(s *Service, coinTypes []string, now time.Time) (StatsResponse, error) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	// The clock can be moved back in a sandbox, see clock.Shifted
+	if age := now.Sub(ss.updated); ss.latest != nil && age >= 0 && age < ss.cfg.Interval {
+		return *ss.latest, nil
+	}
+
+	stats, err := s.GetCampaignStats()
+	if err != nil {
+		return StatsResponse{}, err
+	}
+
+	rsp, err := ss.newStatsResponse(stats, coinTypes, now)
+	if err != nil {
+		return StatsResponse{}, err
+	}
+
+	ss.latest = &rsp
+	ss.updated = now
+	return rsp, nil
+}
+
+// newStatsResponse converts the ledger amounts of stats to decimal strings, rounded down to the granularity.
+// The raised amount of every coin type in coinTypes is included, even if nothing was raised yet.
+// While fewer than stats.min_participants made a deposit, only the participants and the cap are included.
+func (ss *statsStream) newStatsResponse(stats exchange.CampaignStats, coinTypes []string, now time.Time) (StatsResponse, error) {
+	rsp := StatsResponse{
+		Participants: int(roundDown(int64(stats.Participants), int64(ss.cfg.ParticipantsGranularity))),
+		Withheld:     stats.Participants < ss.cfg.MinParticipants,
+		UpdatedAt:    now.Unix(),
+	}
+
+	if stats.Cap != nil {
+		var err error
+		rsp.Cap, err = ss.newCapStatus(*stats.Cap, rsp.Withheld)
+		if err != nil {
+			return StatsResponse{}, err
+		}
+	}
+
+	if rsp.Withheld {
+		return rsp, nil
+	}
+
+	rsp.Raised = make(map[string]string, len(coinTypes))
+
+	raised := make(map[string]int64, len(coinTypes))
+	for _, coinType := range coinTypes {
+		raised[coinType] = 0
+	}
+	for coinType, amount := range stats.Raised {
+		raised[coinType] = amount
+	}
+
+	for coinType, amount := range raised {
+		coin, err := deposits.GetCoin(coinType)
+		if err != nil {
+			return StatsResponse{}, fmt.Errorf("%s: %v", coinType, err)
+		}
+		rsp.Raised[coinType] = coin.Coins(roundDown(amount, ss.granularity[coinType])).String()
+	}
+
+	if stats.SkySent < 0 {
+		return StatsResponse{}, fmt.Errorf("Skycoin sent is negative: %d", stats.SkySent)
+	}
+	skySent := uint64(stats.SkySent)
+
+	var err error
+	rsp.SkySent, err = droplet.ToString(ss.roundDownSky(skySent))
+	if err != nil {
+		return StatsResponse{}, err
+	}
+
+	if ss.cfg.SkyCap != "" {
+		var remaining uint64
+		if skySent < ss.skyCap {
+			remaining = ss.skyCap - skySent
+		}
+
+		rsp.SkyRemaining, err = droplet.ToString(ss.roundDownSky(remaining))
+		if err != nil {
+			return StatsResponse{}, err
+		}
+	}
+
+	return rsp, nil
+}
+
+// newCapStatus converts the ledger amounts of p to decimal strings, rounded down to the granularity.
+// If withheld, only the maximums and whether the cap was reached are included.
+func (ss *statsStream) newCapStatus(p exchange.CapProgress, withheld bool) (*CapStatus, error) {
+	cs := &CapStatus{
+		Reached: p.Reached,
+	}
+
+	if p.MaxBTC != 0 {
+		coin, err := deposits.GetCoin(deposits.CoinTypeBTC)
+		if err != nil {
+			return nil, err
+		}
+		cs.MaxBTC = coin.Coins(p.MaxBTC).String()
+		if !withheld {
+			cs.BTCRaised = coin.Coins(roundDown(p.BTCRaised, ss.granularity[deposits.CoinTypeBTC])).String()
+		}
+	}
+
+	if p.MaxSky != 0 {
+		var err error
+		cs.MaxSky, err = droplet.ToString(p.MaxSky)
+		if err != nil {
+			return nil, err
+		}
+		if !withheld {
+			cs.SkySent, err = droplet.ToString(ss.roundDownSky(p.SkySent))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return cs, nil
+}
+
+// StatsHandler returns the public campaign stats, the same as the latest event of /api/stats/stream.
+// The stats are read from the ledger at most once per stats.interval, so the response can be cached that long.
+// Method: GET
+// URI: /api/stats
+func StatsHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		coinTypes := s.enabledCoinTypes()
+		sort.Strings(coinTypes)
+
+		rsp, err := s.stats.get(s.service, coinTypes, s.clock.Now())
+		if err != nil {
+			log.WithError(err).Error("stats.get failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int64(s.cfg.Stats.Interval/time.Second)))
+
+		if err := httputil.JSONResponse(w, rsp); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// StatsStreamHandler streams the public campaign stats as Server-Sent Events.
+// An event is sent when the client connects and every stats.interval after that.
+// Method: GET
+// URI: /api/stats/stream
+func StatsStreamHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		if !s.featureEnabled(ctx, w, config.FlagStatsStream) {
+			return
+		}
+
+		if !s.stats.join() {
+			w.Header().Set(errCodeHeader, errCodeBusy)
+			w.Header().Set("Retry-After", statsRetryAfter)
+			errorResponse(ctx, w, http.StatusServiceUnavailable, errTooManyStatsClients)
+			return
+		}
+		defer s.stats.leave()
+
+		// The stream stays open past the server's write timeout
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			log.WithError(err).Warn("SetWriteDeadline failed, the stream is closed at the server write timeout")
+		}
+
+		coinTypes := s.enabledCoinTypes()
+		sort.Strings(coinTypes)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		// Stop nginx from buffering the stream
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		ticker := time.NewTicker(s.cfg.Stats.Interval)
+		defer ticker.Stop()
+
+		for {
+			// A failed read skips the event, the client keeps the previous numbers
+			rsp, err := s.stats.get(s.service, coinTypes, s.clock.Now())
+			if err != nil {
+				log.WithError(err).Error("stats.get failed")
+			} else if err := writeStatsEvent(w, rsp); err != nil {
+				log.WithError(err).Debug("writeStatsEvent failed, client disconnected")
+				return
+			}
+
+			if err := rc.Flush(); err != nil {
+				log.WithError(err).Debug("Flush failed, client disconnected")
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// writeStatsEvent writes rsp as a Server-Sent Event
+func writeStatsEvent(w http.ResponseWriter, rsp StatsResponse) error {
+	d, err := json.Marshal(rsp)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "data: %s\n\n", d)
+	return err
+}
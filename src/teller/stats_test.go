@@ -0,0 +1,339 @@
+package teller
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type statsExchanger struct {
+	exchange.Exchanger
+	stats exchange.CampaignStats
+	calls int
+}
+
+func (e *statsExchanger) GetCampaignStats() (exchange.CampaignStats, error) {
+	e.calls++
+	return e.stats, nil
+}
+
+func TestStatsStreamGet(t *testing.T) {
+	exchanger := &statsExchanger{
+		stats: exchange.CampaignStats{
+			Raised: map[string]int64{
+				scanner.CoinTypeBTC: 150000000,
+			},
+			Participants: 3,
+			SkySent:      250e6,
+		},
+	}
+	s := &Service{
+		exchanger: exchanger,
+	}
+
+	ss := newStatsStream(config.Stats{
+		Interval: time.Second * 10,
+		SkyCap:   "1000",
+	})
+
+	now := time.Now()
+	rsp, err := ss.get(s, []string{scanner.CoinTypeBTC, scanner.CoinTypeETH}, now)
+	require.NoError(t, err)
+	require.Equal(t, StatsResponse{
+		Raised: map[string]string{
+			scanner.CoinTypeBTC: "1.5",
+			scanner.CoinTypeETH: "0",
+		},
+		Participants: 3,
+		SkySent:      "250.000000",
+		SkyRemaining: "750.000000",
+		UpdatedAt:    now.Unix(),
+	}, rsp)
+	require.Equal(t, 1, exchanger.calls)
+
+	// Cached until the interval passes
+	exchanger.stats.SkySent = 1500e6
+	_, err = ss.get(s, []string{scanner.CoinTypeBTC, scanner.CoinTypeETH}, now.Add(time.Second*5))
+	require.NoError(t, err)
+	require.Equal(t, 1, exchanger.calls)
+
+	// More sent than the cap
+	rsp, err = ss.get(s, []string{scanner.CoinTypeBTC, scanner.CoinTypeETH}, now.Add(time.Second*10))
+	require.NoError(t, err)
+	require.Equal(t, 2, exchanger.calls)
+	require.Equal(t, "1500.000000", rsp.SkySent)
+	require.Equal(t, "0.000000", rsp.SkyRemaining)
+
+	// No cap
+	ss = newStatsStream(config.Stats{
+		Interval: time.Second * 10,
+	})
+	rsp, err = ss.get(s, nil, now)
+	require.NoError(t, err)
+	require.Empty(t, rsp.SkyRemaining)
+	require.Equal(t, map[string]string{scanner.CoinTypeBTC: "1.5"}, rsp.Raised)
+	require.Nil(t, rsp.Cap)
+
+	// Campaign cap progress
+	exchanger.stats.Cap = &exchange.CapProgress{
+		BTCRaised: 125000000,
+		MaxBTC:    200000000,
+		SkySent:   1500e6,
+	}
+	rsp, err = ss.get(s, nil, now.Add(time.Second*20))
+	require.NoError(t, err)
+	require.Equal(t, &CapStatus{
+		MaxBTC:    "2",
+		BTCRaised: "1.25",
+	}, rsp.Cap)
+
+	exchanger.stats.Cap = &exchange.CapProgress{
+		BTCRaised: 125000000,
+		SkySent:   1500e6,
+		MaxSky:    1500e6,
+		Reached:   true,
+	}
+	rsp, err = ss.get(s, nil, now.Add(time.Second*30))
+	require.NoError(t, err)
+	require.Equal(t, &CapStatus{
+		MaxSky:  "1500.000000",
+		SkySent: "1500.000000",
+		Reached: true,
+	}, rsp.Cap)
+}
+
+func TestStatsStreamHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	exchanger := &statsExchanger{
+		stats: exchange.CampaignStats{
+			Raised: map[string]int64{
+				scanner.CoinTypeBTC: 1e8,
+			},
+			Participants: 1,
+			SkySent:      100e6,
+		},
+	}
+
+	s := NewHTTPServer(log, config.Config{
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+		Web: config.Web{
+			APIEnabled:       true,
+			ThrottleMax:      100,
+			ThrottleDuration: time.Minute,
+		},
+		Stats: config.Stats{
+			Interval:   time.Millisecond * 50,
+			MaxClients: 1,
+		},
+	}, &Service{
+		exchanger: exchanger,
+	}, nil, clock.Real{})
+
+	srv := httptest.NewServer(s.setupMux())
+	defer srv.Close()
+
+	rsp, err := http.Get(srv.URL + "/api/stats/stream")
+	require.NoError(t, err)
+	defer rsp.Body.Close()
+
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.Equal(t, "text/event-stream", rsp.Header.Get("Content-Type"))
+
+	// Events keep coming
+	r := bufio.NewReader(rsp.Body)
+	for i := 0; i < 2; i++ {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(line, "data: "), line)
+
+		var event StatsResponse
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+		require.Equal(t, map[string]string{scanner.CoinTypeBTC: "1"}, event.Raised)
+		require.Equal(t, 1, event.Participants)
+		require.Equal(t, "100.000000", event.SkySent)
+
+		line, err = r.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "\n", line)
+	}
+
+	// Only one client is allowed
+	rsp2, err := http.Get(srv.URL + "/api/stats/stream")
+	require.NoError(t, err)
+	rsp2.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, rsp2.StatusCode)
+	require.Equal(t, statsRetryAfter, rsp2.Header.Get("Retry-After"))
+
+	// The stream ends when the server shuts down
+	close(s.quit)
+	_, err = io.Copy(io.Discard, r)
+	require.NoError(t, err)
+}
+
+func TestStatsStreamGranularity(t *testing.T) {
+	exchanger := &statsExchanger{
+		stats: exchange.CampaignStats{
+			Raised: map[string]int64{
+				scanner.CoinTypeBTC: 123456789,
+				scanner.CoinTypeETH: 4567e6,
+			},
+			Participants: 17,
+			SkySent:      2345678e6,
+			Cap: &exchange.CapProgress{
+				BTCRaised: 123456789,
+				MaxBTC:    10e8,
+				SkySent:   2345678e6,
+				MaxSky:    5e12,
+			},
+		},
+	}
+	s := &Service{
+		exchanger: exchanger,
+	}
+
+	cfg := config.Stats{
+		Interval:                time.Second * 10,
+		SkyCap:                  "5000000",
+		BTCGranularity:          "0.01",
+		ETHGranularity:          "1",
+		SkyGranularity:          "1000",
+		ParticipantsGranularity: 5,
+		MinParticipants:         10,
+		MaxClients:              1,
+	}
+	require.NoError(t, cfg.Validate())
+
+	coinTypes := []string{scanner.CoinTypeBTC, scanner.CoinTypeETH}
+	now := time.Now()
+	rsp, err := newStatsStream(cfg).get(s, coinTypes, now)
+	require.NoError(t, err)
+	require.Equal(t, StatsResponse{
+		Raised: map[string]string{
+			scanner.CoinTypeBTC: "1.23",
+			scanner.CoinTypeETH: "4",
+		},
+		Participants: 15,
+		SkySent:      "2345000.000000",
+		SkyRemaining: "2654000.000000",
+		Cap: &CapStatus{
+			MaxBTC:    "10",
+			BTCRaised: "1.23",
+			MaxSky:    "5000000.000000",
+			SkySent:   "2345000.000000",
+		},
+		UpdatedAt: now.Unix(),
+	}, rsp)
+
+	// The amounts are withheld until there are enough participants
+	exchanger.stats.Participants = 9
+	rsp, err = newStatsStream(cfg).get(s, coinTypes, now)
+	require.NoError(t, err)
+	require.Equal(t, StatsResponse{
+		Participants: 5,
+		Cap: &CapStatus{
+			MaxBTC: "10",
+			MaxSky: "5000000.000000",
+		},
+		Withheld:  true,
+		UpdatedAt: now.Unix(),
+	}, rsp)
+
+	for _, c := range []config.Stats{
+		{BTCGranularity: "0.000000001"},
+		{BTCGranularity: "0"},
+		{ETHGranularity: "-1"},
+		{SkyGranularity: "0.0000001"},
+		{SkyGranularity: "0"},
+		{ParticipantsGranularity: -1},
+		{MinParticipants: -1},
+	} {
+		c.Interval = cfg.Interval
+		c.MaxClients = 1
+		require.Error(t, c.Validate(), "%+v", c)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	exchanger := &statsExchanger{
+		stats: exchange.CampaignStats{
+			Raised: map[string]int64{
+				scanner.CoinTypeBTC: 1e8,
+			},
+			Participants: 1,
+			SkySent:      100e6,
+		},
+	}
+
+	cfg := config.Config{
+		BtcRPC: config.BtcRPC{
+			Enabled: true,
+		},
+		Web: config.Web{
+			APIEnabled:       true,
+			ThrottleMax:      100,
+			ThrottleDuration: time.Minute,
+		},
+		Stats: config.Stats{
+			Interval:   time.Second * 30,
+			MaxClients: 1,
+		},
+	}
+
+	s := NewHTTPServer(log, cfg, &Service{
+		exchanger: exchanger,
+	}, nil, clock.Real{})
+
+	srv := httptest.NewServer(s.setupMux())
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		rsp, err := http.Get(srv.URL + "/api/stats")
+		require.NoError(t, err)
+
+		require.Equal(t, http.StatusOK, rsp.StatusCode)
+		require.Equal(t, "public, max-age=30", rsp.Header.Get("Cache-Control"))
+
+		var stats StatsResponse
+		require.NoError(t, json.NewDecoder(rsp.Body).Decode(&stats))
+		rsp.Body.Close()
+
+		require.Equal(t, map[string]string{scanner.CoinTypeBTC: "1"}, stats.Raised)
+		require.Equal(t, 1, stats.Participants)
+		require.Equal(t, "100.000000", stats.SkySent)
+		require.False(t, stats.Withheld)
+	}
+
+	// The second request is served from the cache
+	require.Equal(t, 1, exchanger.calls)
+
+	cfg.Web.APIEnabled = false
+	s = NewHTTPServer(log, cfg, &Service{
+		exchanger: exchanger,
+	}, nil, clock.Real{})
+
+	srv2 := httptest.NewServer(s.setupMux())
+	defer srv2.Close()
+
+	rsp, err := http.Get(srv2.URL + "/api/stats")
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+}
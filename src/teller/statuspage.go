@@ -0,0 +1,178 @@
+package teller
+
+import (
+	"bytes"
+	"html/template"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+)
+
+// statusPageRefresh is how often the status page reloads itself, in seconds
+const statusPageRefresh = 60
+
+// statusDescriptions are the human readable deposit statuses of the status page
+var statusDescriptions = map[string]string{
+	exchange.StatusWaitDeposit.String(): "Waiting for deposit",
+	exchange.DepositStatusDetected:      "Deposit detected, waiting for it to be final",
+	exchange.StatusWaitSend.String():    "Deposit received, sending skycoin",
+	exchange.StatusWaitConfirm.String(): "Skycoin sent, waiting for confirmation",
+	exchange.StatusDone.String():        "Skycoin sent and confirmed",
+	exchange.StatusWaitReview.String():  "Deposit received, held for review",
+	exchange.StatusDustIgnored.String(): "Deposit below the minimum, not converted",
+}
+
+var statusPageTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>{{if .Branding.ServiceName}}{{.Branding.ServiceName}} - {{end}}Deposit status</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 50em; padding: 0 1em; color: #222; }
+code { word-break: break-all; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.5em; border-bottom: 1px solid #ddd; }
+.done { color: #080; }
+.logo { max-height: 4em; }
+footer { margin-top: 2em; color: #666; }
+</style>
+</head>
+<body>
+{{if .Branding.LogoURL}}<img class="logo" src="{{.Branding.LogoURL}}" alt="{{.Branding.ServiceName}}">{{end}}
+<h1>{{if .Branding.ServiceName}}{{.Branding.ServiceName}} deposit status{{else}}Deposit status{{end}}</h1>
+<p>Skycoin address: {{if .SkyAddrURL}}<a href="{{.SkyAddrURL}}"><code>{{.SkyAddr}}</code></a>{{else}}<code>{{.SkyAddr}}</code>{{end}}</p>
+{{if .Rows}}
+<table>
+<tr><th>#</th><th>Coin</th><th>Status</th><th>Confirmations</th><th>Updated</th></tr>
+{{range .Rows}}
+<tr{{if .Done}} class="done"{{end}}><td>{{.Seq}}</td><td>{{.CoinType}}</td><td>{{if .TxURL}}<a href="{{.TxURL}}">{{.Status}}</a>{{else}}{{.Status}}{{end}}{{if .ReceiptURL}} (<a href="{{.ReceiptURL}}">receipt</a>){{end}}</td><td>{{.Confirmations}}</td><td>{{.UpdatedAt}}</td></tr>
+{{if .Message}}<tr><td></td><td colspan="4">{{.Message}}</td></tr>{{end}}
+{{end}}
+</table>
+{{if .FirstUse}}
+<p>This skycoin address had never received coins when skycoin was sent to it. Check that it is an address of your wallet.</p>
+{{end}}
+{{else}}
+<p>No deposit addresses are bound to this skycoin address.</p>
+{{end}}
+<p>This page refreshes every minute.</p>
+{{if or .Branding.SupportURL .Branding.ContactEmail}}
+<footer>Questions? {{if .Branding.SupportURL}}<a href="{{.Branding.SupportURL}}">Get help</a>{{if .Branding.ContactEmail}} or write to <a href="mailto:{{.Branding.ContactEmail}}">{{.Branding.ContactEmail}}</a>{{end}}{{else}}Write to <a href="mailto:{{.Branding.ContactEmail}}">{{.Branding.ContactEmail}}</a>{{end}}.</footer>
+{{end}}
+</body>
+</html>
+`))
+
+type statusPage struct {
+	// The operator's branding, empty fields are left out
+	Branding config.Branding
+	SkyAddr  string
+	// Explorer link of SkyAddr, empty if the payout chain has no explorer
+	SkyAddrURL string
+	Refresh    int
+	Rows       []statusPageRow
+	// FirstUse is true if a payout was sent to the address before it had received coins
+	FirstUse bool
+}
+
+type statusPageRow struct {
+	Seq           uint64
+	CoinType      string
+	Status        string
+	Confirmations string
+	UpdatedAt     string
+	Done          bool
+	// Explorer link of the skycoin transaction, once sent
+	TxURL string
+	// Receipt link of the deposit, once done
+	ReceiptURL string
+	// Why the deposit was not converted, if it was ignored as dust
+	Message string
+}
+
+func newStatusPageRow(ds DepositStatus) statusPageRow {
+	status := statusDescriptions[ds.Status]
+	if status == "" {
+		status = ds.Status
+	}
+
+	// Confirmations only matter while waiting for the deposit to be confirmed
+	var confirmations string
+	if ds.Status != exchange.StatusWaitDeposit.String() {
+		confirmations = strconv.FormatInt(ds.Confirmations, 10) + " / " + strconv.FormatInt(ds.ConfirmationsRequired, 10)
+	}
+
+	return statusPageRow{
+		Seq:           ds.Seq,
+		CoinType:      ds.CoinType,
+		Status:        status,
+		Confirmations: confirmations,
+		UpdatedAt:     time.Unix(ds.UpdatedAt, 0).UTC().Format("2006-01-02 15:04 MST"),
+		Done:          ds.Status == exchange.StatusDone.String(),
+		TxURL:         ds.TxURL,
+		ReceiptURL:    ds.ReceiptURL,
+		Message:       ds.Message,
+	}
+}
+
+// renderStatusPage renders the deposit statuses of skyAddr as an HTML page.
+// skyAddrURL is the explorer link of skyAddr, or empty.
+func renderStatusPage(branding config.Branding, skyAddr, skyAddrURL string, statuses []DepositStatus) ([]byte, error) {
+	page := statusPage{
+		Branding:   branding,
+		SkyAddr:    skyAddr,
+		SkyAddrURL: skyAddrURL,
+		Refresh:    statusPageRefresh,
+	}
+	for _, ds := range statuses {
+		page.Rows = append(page.Rows, newStatusPageRow(ds))
+		page.FirstUse = page.FirstUse || ds.FirstUse
+	}
+
+	var b bytes.Buffer
+	if err := statusPageTemplate.Execute(&b, page); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// acceptsHTML returns true if the request's Accept header prefers text/html to application/json,
+// as a browser following a status link does
+func acceptsHTML(r *http.Request) bool {
+	var htmlQ, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "text/html", "application/xhtml+xml":
+			if q > htmlQ {
+				htmlQ = q
+			}
+		case "application/json":
+			if q > jsonQ {
+				jsonQ = q
+			}
+		}
+	}
+
+	return htmlQ > 0 && htmlQ > jsonQ
+}
@@ -0,0 +1,199 @@
+package teller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestAcceptsHTML(t *testing.T) {
+	cases := []struct {
+		accept string
+		html   bool
+	}{
+		{"", false},
+		{"*/*", false},
+		{"application/json", false},
+		{"text/html", true},
+		// Firefox and Chrome
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8", true},
+		{"application/json, text/html;q=0.5", false},
+		{"text/html;q=0.5, application/json;q=0.9", false},
+		{"text/html, application/json", false},
+		{"text/html;q=0", false},
+		{"text/html;q=bad", false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.accept, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+			r.Header.Set("Accept", tc.accept)
+			require.Equal(t, tc.html, acceptsHTML(r))
+		})
+	}
+}
+
+type statusExchanger struct {
+	exchange.Exchanger
+	statuses []exchange.DepositStatus
+}
+
+func (e statusExchanger) GetDepositStatuses(skyAddr string) ([]exchange.DepositStatus, error) {
+	return e.statuses, nil
+}
+
+func TestStatusHandlerHTML(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+		BtcScanner: config.BtcScanner{
+			ConfirmationsRequired: 2,
+		},
+	}, &Service{
+		exchanger: statusExchanger{
+			statuses: []exchange.DepositStatus{
+				{
+					Seq:        1,
+					UpdatedAt:  1519905600,
+					Status:     exchange.StatusDone.String(),
+					CoinType:   scanner.CoinTypeBTC,
+					ReceiptURL: "https://example.com/api/receipt?deposit_id=btctx%3A1&sig=00",
+				},
+				{
+					Seq:           2,
+					UpdatedAt:     1519905600,
+					Status:        exchange.StatusWaitSend.String(),
+					CoinType:      scanner.CoinTypeBTC,
+					Confirmations: 3,
+				},
+			},
+		},
+	}, nil, clock.Real{})
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/status?skyaddr="+skyAddr, nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		StatusHandler(s)(w, req)
+		return w
+	}
+
+	w := get("text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	require.Equal(t, "Accept", w.Header().Get("Vary"))
+
+	body := w.Body.String()
+	require.Contains(t, body, skyAddr)
+	require.Contains(t, body, `<tr class="done"><td>1</td><td>BTC</td><td>Skycoin sent and confirmed (<a href="https://example.com/api/receipt?deposit_id=btctx%3A1&amp;sig=00">receipt</a>)</td><td>2 / 2</td><td>2018-03-01 12:00 UTC</td></tr>`)
+	require.Contains(t, body, `<tr><td>2</td><td>BTC</td><td>Deposit received, sending skycoin</td><td>3 / 2</td><td>2018-03-01 12:00 UTC</td></tr>`)
+
+	w = get("application/json")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	require.Equal(t, "Accept", w.Header().Get("Vary"))
+
+	var rsp StatusResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+	require.Len(t, rsp.Statuses, 2)
+}
+
+func TestRenderStatusPageEscapes(t *testing.T) {
+	page, err := renderStatusPage(config.Branding{}, "<script>", "", []DepositStatus{
+		{
+			DepositStatus: exchange.DepositStatus{
+				Status:   "<b>",
+				CoinType: "<i>",
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NotContains(t, string(page), "<script>")
+	require.NotContains(t, string(page), "<b>")
+	require.NotContains(t, string(page), "<i>")
+
+	page, err = renderStatusPage(config.Branding{}, "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", "", nil)
+	require.NoError(t, err)
+	require.Contains(t, string(page), "No deposit addresses are bound to this skycoin address.")
+}
+
+func TestRenderStatusPageFirstUse(t *testing.T) {
+	warning := "This skycoin address had never received coins when skycoin was sent to it."
+
+	page, err := renderStatusPage(config.Branding{}, "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", "", []DepositStatus{
+		{
+			DepositStatus: exchange.DepositStatus{
+				Status:   exchange.StatusDone.String(),
+				FirstUse: true,
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(page), warning)
+
+	page, err = renderStatusPage(config.Branding{}, "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", "", []DepositStatus{
+		{
+			DepositStatus: exchange.DepositStatus{
+				Status: exchange.StatusDone.String(),
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.NotContains(t, string(page), warning)
+}
+
+func TestRenderStatusPageExplorerLinks(t *testing.T) {
+	page, err := renderStatusPage(config.Branding{}, "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", "https://explorer.skycoin.com/app/address/2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", []DepositStatus{
+		{
+			DepositStatus: exchange.DepositStatus{
+				Status: exchange.StatusDone.String(),
+				TxURL:  "https://explorer.skycoin.com/app/transaction/skytx",
+			},
+		},
+		{
+			DepositStatus: exchange.DepositStatus{
+				Status: exchange.StatusWaitDeposit.String(),
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, string(page), `<a href="https://explorer.skycoin.com/app/address/2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"><code>2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv</code></a>`)
+	require.Contains(t, string(page), `<a href="https://explorer.skycoin.com/app/transaction/skytx">Skycoin sent and confirmed</a>`)
+	require.Contains(t, string(page), `<td>Waiting for deposit</td>`)
+}
+
+func TestRenderStatusPageBranding(t *testing.T) {
+	page, err := renderStatusPage(config.Branding{}, "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", "", nil)
+	require.NoError(t, err)
+	require.Contains(t, string(page), "<title>Deposit status</title>")
+	require.NotContains(t, string(page), "<img")
+	require.NotContains(t, string(page), "<footer>")
+
+	page, err = renderStatusPage(config.Branding{
+		ServiceName:  "Example Exchange",
+		SupportURL:   "https://example.com/support",
+		LogoURL:      "https://example.com/logo.png",
+		ContactEmail: "support@example.com",
+	}, "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv", "", nil)
+	require.NoError(t, err)
+	require.Contains(t, string(page), "<title>Example Exchange - Deposit status</title>")
+	require.Contains(t, string(page), "<h1>Example Exchange deposit status</h1>")
+	require.Contains(t, string(page), `<img class="logo" src="https://example.com/logo.png" alt="Example Exchange">`)
+	require.Contains(t, string(page), `<a href="https://example.com/support">Get help</a> or write to <a href="mailto:support@example.com">support@example.com</a>`)
+}
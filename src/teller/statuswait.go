@@ -0,0 +1,177 @@
+package teller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+const (
+	// statusWaitTimeout is how long /api/status/wait holds a request by default
+	statusWaitTimeout = time.Second * 30
+	// statusWaitMaxTimeout bounds the timeout requested, to answer within the server's write timeout
+	statusWaitMaxTimeout = time.Second * 50
+	// statusWaitMaxClients is the number of requests /api/status/wait holds at once
+	statusWaitMaxClients = 1000
+	// statusWaitRetryAfter is the Retry-After seconds sent when too many requests are waiting
+	statusWaitRetryAfter = "5"
+)
+
+// errTooManyStatusWaitClients is returned when statusWaitMaxClients requests are waiting
+var errTooManyStatusWaitClients = errors.New("Too many status wait clients")
+
+// StatusWaitResponse http response for /api/status/wait
+type StatusWaitResponse struct {
+	Statuses []DepositStatus `json:"statuses"`
+	// Cursor identifies the statuses, and is sent as since by the next request
+	Cursor string `json:"cursor"`
+	// Changed is false if the request timed out without a change
+	Changed bool `json:"changed"`
+}
+
+// statusCursor returns a digest of the deposit statuses, which changes when any of them changes.
+// The time of detected deposits is the time of the request, so it is left out.
+func statusCursor(dss []exchange.DepositStatus) string {
+	h := sha256.New()
+	for _, ds := range dss {
+		updatedAt := ds.UpdatedAt
+		if ds.Status == exchange.DepositStatusDetected {
+			updatedAt = 0
+		}
+		fmt.Fprintf(h, "%d:%d:%s:%s:%d\n", ds.Seq, updatedAt, ds.Status, ds.CoinType, ds.Confirmations)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// StatusWaitHandler returns the deposit statuses of a skycoin address once they differ from
+// the statuses identified by since, waiting up to timeout seconds for a change.
+// It is a long polling alternative to polling /api/status, for clients which can't stream.
+// A request without since returns immediately.
+// Method: GET
+// URI: /api/status/wait
+// Args:
+//     skyaddr
+//     since # optional cursor of the statuses last seen
+//     timeout # optional seconds to wait, 30 by default and at most 50
+func StatusWaitHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		skyAddr := strings.Trim(r.URL.Query().Get("skyaddr"), "\n\t ")
+		if skyAddr == "" {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
+			return
+		}
+
+		log = log.WithField("skyAddr", skyAddr)
+		ctx = logger.WithContext(ctx, log)
+
+		if !verifySkycoinAddress(ctx, w, skyAddr) {
+			return
+		}
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		if !s.featureEnabled(ctx, w, config.FlagStatusWait) {
+			return
+		}
+
+		timeout := statusWaitTimeout
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs < 0 {
+				errorResponse(ctx, w, http.StatusBadRequest, errors.New("Invalid timeout"))
+				return
+			}
+			timeout = time.Duration(secs) * time.Second
+			if timeout > statusWaitMaxTimeout {
+				timeout = statusWaitMaxTimeout
+			}
+		}
+
+		since := r.URL.Query().Get("since")
+
+		if atomic.AddInt32(&s.statusWaiting, 1) > statusWaitMaxClients {
+			atomic.AddInt32(&s.statusWaiting, -1)
+			w.Header().Set(errCodeHeader, errCodeBusy)
+			w.Header().Set("Retry-After", statusWaitRetryAfter)
+			errorResponse(ctx, w, http.StatusServiceUnavailable, errTooManyStatusWaitClients)
+			return
+		}
+		defer atomic.AddInt32(&s.statusWaiting, -1)
+
+		respond := func(dss []exchange.DepositStatus, cursor string, changed bool) {
+			statuses := make([]DepositStatus, 0, len(dss))
+			for _, ds := range dss {
+				statuses = append(statuses, s.newDepositStatus(ds))
+			}
+
+			if err := httputil.JSONResponse(w, StatusWaitResponse{
+				Statuses: statuses,
+				Cursor:   cursor,
+				Changed:  changed,
+			}); err != nil {
+				log.WithError(err).Error(err)
+			}
+		}
+
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		for {
+			// Watch before reading the statuses, so that a change in between isn't missed
+			changedC, stop := s.service.WatchDepositStatuses(skyAddr)
+
+			depositStatuses, err := s.service.GetDepositStatuses(skyAddr)
+			if err != nil {
+				stop()
+				log.WithError(err).Error("service.GetDepositStatuses failed")
+				serviceErrorResponse(ctx, w, err)
+				return
+			}
+
+			cursor := statusCursor(depositStatuses)
+			if cursor != since {
+				stop()
+				respond(depositStatuses, cursor, true)
+				return
+			}
+
+			select {
+			case <-changedC:
+				// Read the changed statuses
+				stop()
+			case <-timer.C:
+				stop()
+				respond(depositStatuses, cursor, false)
+				return
+			case <-s.quit:
+				stop()
+				respond(depositStatuses, cursor, false)
+				return
+			case <-ctx.Done():
+				stop()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package teller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+type statusWaitExchanger struct {
+	exchange.Exchanger
+	sync.Mutex
+	statuses []exchange.DepositStatus
+	watching chan chan struct{}
+}
+
+func (e *statusWaitExchanger) GetDepositStatuses(skyAddr string) ([]exchange.DepositStatus, error) {
+	e.Lock()
+	defer e.Unlock()
+	return append([]exchange.DepositStatus(nil), e.statuses...), nil
+}
+
+func (e *statusWaitExchanger) WatchDepositStatuses(skyAddr string) (<-chan struct{}, func()) {
+	c := make(chan struct{})
+	select {
+	case e.watching <- c:
+	default:
+	}
+	return c, func() {}
+}
+
+func TestStatusWaitHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	exchanger := &statusWaitExchanger{
+		statuses: []exchange.DepositStatus{
+			{
+				Seq:      1,
+				Status:   exchange.StatusWaitDeposit.String(),
+				CoinType: "BTC",
+			},
+		},
+		watching: make(chan chan struct{}, 1),
+	}
+
+	s := NewHTTPServer(log, config.Config{
+		Web: config.Web{
+			APIEnabled: true,
+		},
+	}, &Service{
+		exchanger: exchanger,
+	}, nil, clock.Real{})
+
+	do := func(query string) (int, StatusWaitResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/status/wait?skyaddr="+skyAddr+query, nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		w := httptest.NewRecorder()
+
+		StatusWaitHandler(s)(w, req)
+
+		var rsp StatusWaitResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+		}
+		return w.Code, rsp
+	}
+
+	code, _ := do("&timeout=x")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = do("&timeout=-1")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// Without since, the statuses are returned immediately
+	code, rsp := do("")
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, rsp.Changed)
+	require.Len(t, rsp.Statuses, 1)
+	require.NotEmpty(t, rsp.Cursor)
+	<-exchanger.watching
+	cursor := rsp.Cursor
+
+	// Unchanged statuses time out
+	code, rsp = do("&timeout=0&since=" + cursor)
+	require.Equal(t, http.StatusOK, code)
+	require.False(t, rsp.Changed)
+	require.Equal(t, cursor, rsp.Cursor)
+	<-exchanger.watching
+
+	// A change wakes the request
+	done := make(chan StatusWaitResponse)
+	go func() {
+		_, rsp := do("&timeout=10&since=" + cursor)
+		done <- rsp
+	}()
+
+	c := <-exchanger.watching
+	exchanger.Lock()
+	exchanger.statuses[0].Status = exchange.StatusWaitSend.String()
+	exchanger.statuses[0].UpdatedAt = 1
+	exchanger.Unlock()
+	close(c)
+
+	select {
+	case rsp = <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("request was not woken")
+	}
+	require.True(t, rsp.Changed)
+	require.NotEqual(t, cursor, rsp.Cursor)
+	require.Equal(t, exchange.StatusWaitSend.String(), rsp.Statuses[0].Status)
+}
+
+func TestStatusCursor(t *testing.T) {
+	dss := []exchange.DepositStatus{
+		{
+			Seq:       1,
+			UpdatedAt: 100,
+			Status:    exchange.DepositStatusDetected,
+			CoinType:  "BTC",
+		},
+	}
+	cursor := statusCursor(dss)
+
+	// The time of a detected deposit is the time of the request
+	dss[0].UpdatedAt = 200
+	require.Equal(t, cursor, statusCursor(dss))
+
+	dss[0].Confirmations = 1
+	require.NotEqual(t, cursor, statusCursor(dss))
+}
@@ -0,0 +1,239 @@
+package teller
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+const (
+	// statusWSMaxClients is the number of connections /api/ws keeps open at once
+	statusWSMaxClients = 1000
+	// statusWSRetryAfter is the Retry-After seconds sent when too many connections are open
+	statusWSRetryAfter = "5"
+	// statusWSPingInterval is how often a ping is sent, so that proxies don't close an idle connection
+	// and a client which went away is noticed
+	statusWSPingInterval = time.Second * 30
+	// statusWSWriteTimeout bounds the time to write a message to the client
+	statusWSWriteTimeout = time.Second * 10
+	// statusWSMaxMessageBytes bounds the messages read from the client, which are ignored
+	statusWSMaxMessageBytes = 512
+)
+
+var (
+	// errTooManyStatusWSClients is returned when statusWSMaxClients connections are open
+	errTooManyStatusWSClients = errors.New("Too many status websocket clients")
+	// errStatusWSOrigin is returned when a page of another site opens a websocket
+	errStatusWSOrigin = errors.New("Origin is not allowed")
+)
+
+// StatusUpdate is a message of /api/ws, sent when the deposit statuses of the subscribed skycoin address change
+type StatusUpdate struct {
+	Statuses []DepositStatus `json:"statuses"`
+	// Cursor identifies the statuses, like the cursor of /api/status/wait
+	Cursor string `json:"cursor"`
+}
+
+// StatusWSHandler subscribes a websocket to the deposit statuses of a skycoin address.
+// The statuses are pushed when the client connects and each time they change,
+// e.g. as a deposit moves from waiting_deposit to waiting_confirm, waiting_send and done,
+// so that clients don't need to poll /api/status. Messages sent by the client are ignored.
+// A client reconnecting with the cursor of the last update it got is only sent the statuses once they differ.
+// Method: GET
+// URI: /api/ws
+// Args:
+//     skyaddr
+//     since # optional cursor of the statuses last seen
+func StatusWSHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodGet}) {
+			return
+		}
+
+		skyAddr := strings.Trim(r.URL.Query().Get("skyaddr"), "\n\t ")
+		if skyAddr == "" {
+			errorResponse(ctx, w, http.StatusBadRequest, errors.New("Missing skyaddr"))
+			return
+		}
+
+		log = log.WithField("skyAddr", skyAddr)
+		ctx = logger.WithContext(ctx, log)
+
+		if !verifySkycoinAddress(ctx, w, skyAddr) {
+			return
+		}
+
+		if !s.cfg.Web.APIEnabled {
+			errorResponse(ctx, w, http.StatusForbidden, errors.New("API disabled"))
+			return
+		}
+
+		if !s.featureEnabled(ctx, w, config.FlagStatusWS) {
+			return
+		}
+
+		since := r.URL.Query().Get("since")
+
+		if atomic.AddInt32(&s.statusStreams, 1) > statusWSMaxClients {
+			atomic.AddInt32(&s.statusStreams, -1)
+			w.Header().Set(errCodeHeader, errCodeBusy)
+			w.Header().Set("Retry-After", statusWSRetryAfter)
+			errorResponse(ctx, w, http.StatusServiceUnavailable, errTooManyStatusWSClients)
+			return
+		}
+		defer atomic.AddInt32(&s.statusStreams, -1)
+
+		websocket.Server{
+			Handshake: s.checkStatusWSOrigin,
+			Handler: func(ws *websocket.Conn) {
+				defer ws.Close()
+
+				// The connection stays open past the server's read and write timeouts
+				if err := ws.SetDeadline(time.Time{}); err != nil {
+					log.WithError(err).Warn("SetDeadline failed, the websocket is closed at the server timeouts")
+				}
+
+				s.streamStatuses(ws, log, skyAddr, since)
+			},
+		}.ServeHTTP(hijackResponseWriter{w}, r)
+	}
+}
+
+// streamStatuses sends the deposit statuses of skyAddr to ws until the client goes away or the server stops
+func (s *HTTPServer) streamStatuses(ws *websocket.Conn, log logrus.FieldLogger, skyAddr, since string) {
+	ws.MaxPayloadBytes = statusWSMaxMessageBytes
+
+	// The client's messages are read to answer its pings and notice when it closes the connection
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var msg []byte
+		for {
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(v interface{}) error {
+		if err := ws.SetWriteDeadline(time.Now().Add(statusWSWriteTimeout)); err != nil {
+			return err
+		}
+		return websocket.JSON.Send(ws, v)
+	}
+
+	ping := func() error {
+		if err := ws.SetWriteDeadline(time.Now().Add(statusWSWriteTimeout)); err != nil {
+			return err
+		}
+		ws.PayloadType = websocket.PingFrame
+		defer func() {
+			ws.PayloadType = websocket.TextFrame
+		}()
+		_, err := ws.Write(nil)
+		return err
+	}
+
+	ticker := time.NewTicker(statusWSPingInterval)
+	defer ticker.Stop()
+
+	for {
+		// Watch before reading the statuses, so that a change in between isn't missed
+		changedC, stop := s.service.WatchDepositStatuses(skyAddr)
+
+		depositStatuses, err := s.service.GetDepositStatuses(skyAddr)
+		if err != nil {
+			// The client reconnects with the cursor of the last update
+			stop()
+			log.WithError(err).Error("service.GetDepositStatuses failed")
+			return
+		}
+
+		if cursor := statusCursor(depositStatuses); cursor != since {
+			if err := send(s.newStatusUpdate(depositStatuses, cursor)); err != nil {
+				stop()
+				log.WithError(err).Debug("Send failed, client disconnected")
+				return
+			}
+			since = cursor
+		}
+
+		select {
+		case <-changedC:
+			// Read the changed statuses
+			stop()
+		case <-ticker.C:
+			stop()
+			if err := ping(); err != nil {
+				log.WithError(err).Debug("Ping failed, client disconnected")
+				return
+			}
+		case <-closed:
+			stop()
+			return
+		case <-s.quit:
+			stop()
+			return
+		}
+	}
+}
+
+// newStatusUpdate creates the StatusUpdate of the deposit statuses identified by cursor
+func (s *HTTPServer) newStatusUpdate(dss []exchange.DepositStatus, cursor string) StatusUpdate {
+	statuses := make([]DepositStatus, 0, len(dss))
+	for _, ds := range dss {
+		statuses = append(statuses, s.newDepositStatus(ds))
+	}
+
+	return StatusUpdate{
+		Statuses: statuses,
+		Cursor:   cursor,
+	}
+}
+
+// checkStatusWSOrigin refuses websockets opened by the pages of other sites, which browsers don't stop with CORS.
+// Pages of the teller website, a local skycoin wallet and the widget partners are allowed,
+// as are clients which are not browsers and send no Origin.
+func (s *HTTPServer) checkStatusWSOrigin(cfg *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return errStatusWSOrigin
+	}
+
+	if u.Host != r.Host && origin != walletOrigin && (s.widget == nil || !s.widget.isPartner(origin)) {
+		return errStatusWSOrigin
+	}
+
+	cfg.Origin = u
+	return nil
+}
+
+// hijackResponseWriter hijacks the connection of a wrapped http.ResponseWriter, e.g. the one of httputil.LogHandler,
+// for websocket.Server which needs an http.Hijacker
+type hijackResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w hijackResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
@@ -0,0 +1,127 @@
+package teller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestStatusWSHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	skyAddr := "2GgFvqoyk9RjwVzj8tqfcXVXB4orBwoc9qv"
+	exchanger := &statusWaitExchanger{
+		statuses: []exchange.DepositStatus{
+			{
+				Seq:      1,
+				Status:   exchange.StatusWaitDeposit.String(),
+				CoinType: "BTC",
+			},
+		},
+		watching: make(chan chan struct{}, 1),
+	}
+
+	newServer := func(flags map[string]bool) (*HTTPServer, *httptest.Server) {
+		s := NewHTTPServer(log, config.Config{
+			Web: config.Web{
+				APIEnabled:       true,
+				ThrottleMax:      100,
+				ThrottleDuration: time.Minute,
+				RateLimit: config.RateLimit{
+					Algorithm: rateLimitSlidingWindow,
+				},
+			},
+			FeatureFlags: flags,
+		}, &Service{
+			exchanger: exchanger,
+		}, nil, clock.Real{})
+		return s, httptest.NewServer(s.setupMux())
+	}
+
+	// Disabled by default
+	_, srv := newServer(nil)
+	defer srv.Close()
+	rsp, err := http.Get(srv.URL + "/api/ws?skyaddr=" + skyAddr)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusForbidden, rsp.StatusCode)
+
+	s, srv := newServer(map[string]bool{
+		config.FlagStatusWS: true,
+	})
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/ws?skyaddr="
+
+	rsp, err = http.Get(srv.URL + "/api/ws")
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, rsp.StatusCode)
+
+	// Pages of other sites can't subscribe
+	_, err = websocket.Dial(wsURL+skyAddr, "", "http://example.com")
+	require.Error(t, err)
+
+	receive := func(ws *websocket.Conn) StatusUpdate {
+		require.NoError(t, ws.SetReadDeadline(time.Now().Add(time.Second*5)))
+		var u StatusUpdate
+		require.NoError(t, websocket.JSON.Receive(ws, &u))
+		return u
+	}
+
+	change := func(status string, updatedAt int64) {
+		c := <-exchanger.watching
+		exchanger.Lock()
+		exchanger.statuses[0].Status = status
+		exchanger.statuses[0].UpdatedAt = updatedAt
+		exchanger.Unlock()
+		close(c)
+	}
+
+	// The statuses are sent on connect, then at each change
+	ws, err := websocket.Dial(wsURL+skyAddr, "", srv.URL)
+	require.NoError(t, err)
+
+	u := receive(ws)
+	require.Len(t, u.Statuses, 1)
+	require.Equal(t, exchange.StatusWaitDeposit.String(), u.Statuses[0].Status)
+	require.NotEmpty(t, u.Cursor)
+	cursor := u.Cursor
+
+	change(exchange.StatusWaitSend.String(), 1)
+	u = receive(ws)
+	require.Equal(t, exchange.StatusWaitSend.String(), u.Statuses[0].Status)
+	require.NotEqual(t, cursor, u.Cursor)
+	cursor = u.Cursor
+
+	require.Equal(t, int32(1), atomic.LoadInt32(&s.statusStreams))
+	require.NoError(t, ws.Close())
+	// The watch of the closed connection
+	<-exchanger.watching
+
+	// A client reconnecting with its cursor is only sent the next change
+	ws, err = websocket.Dial(wsURL+skyAddr+"&since="+cursor, "", srv.URL)
+	require.NoError(t, err)
+	defer ws.Close()
+
+	change(exchange.StatusDone.String(), 2)
+	u = receive(ws)
+	require.Equal(t, exchange.StatusDone.String(), u.Statuses[0].Status)
+
+	// The closed connection was released
+	for i := 0; atomic.LoadInt32(&s.statusStreams) != 1; i++ {
+		require.True(t, i < 500, "the closed websocket was not released")
+		time.Sleep(time.Millisecond * 10)
+	}
+}
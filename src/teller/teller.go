@@ -1,20 +1,75 @@
 package teller
 
 import (
-	"errors"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/skycoin/teller/src/abuse"
 	"github.com/skycoin/teller/src/addrs"
+	"github.com/skycoin/teller/src/analytics"
+	"github.com/skycoin/teller/src/capture"
 	"github.com/skycoin/teller/src/config"
 	"github.com/skycoin/teller/src/exchange"
+	"github.com/skycoin/teller/src/receipt"
+	"github.com/skycoin/teller/src/scanner"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/errutil"
+	"github.com/skycoin/teller/src/util/flagutil"
+	"github.com/skycoin/teller/src/util/pauseutil"
 )
 
 var (
 	// ErrMaxBoundAddresses is returned when the maximum number of address to bind to a SKY address has been reached
-	ErrMaxBoundAddresses = errors.New("The maximum number of addresses have been assigned to this SKY address")
+	ErrMaxBoundAddresses = errutil.New(errutil.Conflict, "The maximum number of addresses have been assigned to this SKY address")
+	// ErrQuiesced is returned when binding is paused for a handover to a new teller instance
+	ErrQuiesced = errutil.New(errutil.Unavailable, "Binding is paused for a teller upgrade, try again shortly")
+	// ErrMaintenance is returned when an operator paused binding from the admin API
+	ErrMaintenance = errutil.New(errutil.Unavailable, "Binding is paused for maintenance, try again later")
+	// ErrRefundAddressIsDeposit is returned when the refund address of a binding is a deposit address of teller,
+	// which would convert the refund instead of returning it
+	ErrRefundAddressIsDeposit = errutil.New(errutil.Conflict, "The refund address is a deposit address of this teller")
 )
 
+// AddressSeer reports whether an address has received coins on chain
+type AddressSeer interface {
+	AddressSeen(addr string) (bool, error)
+}
+
+// ScannerRegistry has the scanners of the coin types whose deposits are scanned, which are the coin types that can be bound
+type ScannerRegistry interface {
+	CoinTypes() []string
+}
+
+// coinTypeList is a ScannerRegistry of fixed coin types
+type coinTypeList []string
+
+// CoinTypes returns the coin types
+func (c coinTypeList) CoinTypes() []string {
+	return c
+}
+
+// configCoinTypes returns the coin types enabled in cfg, the ScannerRegistry of a teller which doesn't scan, as an archive
+func configCoinTypes(cfg config.Config) coinTypeList {
+	var coinTypes coinTypeList
+	if cfg.BtcRPC.Enabled {
+		coinTypes = append(coinTypes, scanner.CoinTypeBTC)
+	}
+	if cfg.EthRPC.Enabled {
+		coinTypes = append(coinTypes, scanner.CoinTypeETH)
+	}
+	for _, pc := range cfg.PluginCoins {
+		coinTypes = append(coinTypes, pc.CoinType)
+	}
+	return coinTypes
+}
+
+// ContactBook saves the optional contact emails of bindings
+type ContactBook interface {
+	AddContact(skyAddr, depositAddr, coinType, email, language string) error
+	EraseContact(skyAddr, depositAddr, sig string) error
+}
+
 // Teller provides the HTTP and teller service
 type Teller struct {
 	cfg      config.Teller
@@ -24,18 +79,33 @@ type Teller struct {
 	done     chan struct{}
 }
 
-// New creates a Teller
-func New(log logrus.FieldLogger, exchanger exchange.Exchanger, addrManager *addrs.AddrManager, cfg config.Config) *Teller {
+// New creates a Teller. scanners is nil if teller doesn't scan, binding the coin types enabled in cfg,
+// contacts is nil if contact emails are disabled, skew is nil if the clock skew guard is disabled,
+// recorder is nil if capturing requests is disabled, and receipts is nil if receipts are disabled.
+func New(log logrus.FieldLogger, exchanger exchange.Exchanger, scanners ScannerRegistry, addrManager *addrs.AddrManager, certCache CertCache, tracker analytics.Tracker, skyChain AddressSeer, contacts ContactBook, guard *abuse.Guard, clk clock.Clock, skew *clock.SkewGuard, recorder *capture.Recorder, receipts *receipt.Service, cfg config.Config) *Teller {
+	httpServ := NewHTTPServer(log, cfg, &Service{
+		log:         log.WithField("prefix", "teller.service"),
+		cfg:         cfg.Teller,
+		exchanger:   exchanger,
+		addrManager: addrManager,
+		tracker:     tracker,
+		skyChain:    skyChain,
+		contacts:    contacts,
+	}, certCache, clk)
+	httpServ.abuse = guard
+	httpServ.skew = skew
+	httpServ.capture = recorder
+	httpServ.receipts = receipts
+	if scanners != nil {
+		httpServ.scanners = scanners
+	}
+
 	return &Teller{
-		cfg:  cfg.Teller,
-		log:  log.WithField("prefix", "teller"),
-		quit: make(chan struct{}),
-		done: make(chan struct{}),
-		httpServ: NewHTTPServer(log, cfg.Redacted(), &Service{
-			cfg:         cfg.Teller,
-			exchanger:   exchanger,
-			addrManager: addrManager,
-		}),
+		cfg:      cfg.Redacted().Teller,
+		log:      log.WithField("prefix", "teller"),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		httpServ: httpServ,
 	}
 }
 
@@ -69,16 +139,96 @@ func (s *Teller) Shutdown() {
 	<-s.done
 }
 
+// ReloadWeb changes the listen addresses and TLS certificate of the HTTP server, see HTTPServer.Reload
+func (s *Teller) ReloadWeb(web config.Web) error {
+	return s.httpServ.Reload(web)
+}
+
+// Quiesce stops binding addresses, returning once the binds in progress are finished
+func (s *Teller) Quiesce() {
+	s.httpServ.service.Quiesce()
+}
+
+// Resume undoes Quiesce
+func (s *Teller) Resume() {
+	s.httpServ.service.Resume()
+}
+
+// Features returns the feature flags of the HTTP server, which operators flip from the admin panel
+func (s *Teller) Features() *flagutil.Registry {
+	return s.httpServ.features
+}
+
+// RateLimitStats returns the requests of each rate limited endpoint, see RateLimitStats
+func (s *Teller) RateLimitStats() []RateLimitStats {
+	return s.httpServ.rateLimits.stats()
+}
+
+// BindGate returns the gate which pauses binding and cancelling bindings for maintenance
+func (s *Teller) BindGate() *pauseutil.Gate {
+	return &s.httpServ.service.bindGate
+}
+
 // Service combines Exchanger and AddrGenerator
 type Service struct {
+	log         logrus.FieldLogger
 	cfg         config.Teller
 	exchanger   exchange.Exchanger // exchange Teller client
 	addrManager *addrs.AddrManager // address manager
+	tracker     analytics.Tracker  // funnel events
+	skyChain    AddressSeer        // skycoin chain lookups
+	contacts    ContactBook        // contact emails, nil if disabled
+
+	// bindMu is read locked by BindAddress and locked to set quiesced
+	bindMu   sync.RWMutex
+	quiesced bool
+
+	// bindGate is paused by operators to put binding in maintenance
+	bindGate pauseutil.Gate
+}
+
+// Quiesce makes BindAddress return ErrQuiesced, once the binds in progress are finished
+func (s *Service) Quiesce() {
+	s.bindMu.Lock()
+	defer s.bindMu.Unlock()
+	s.quiesced = true
+}
+
+// Resume undoes Quiesce
+func (s *Service) Resume() {
+	s.bindMu.Lock()
+	defer s.bindMu.Unlock()
+	s.quiesced = false
 }
 
-// BindAddress binds skycoin address with a deposit address according to coinType
+// BindAddress binds skycoin address with a deposit address according to coinType,
+// with an optional refund address of coinType.
 // return deposit address
-func (s *Service) BindAddress(skyAddr, coinType string) (string, error) {
+func (s *Service) BindAddress(skyAddr, coinType, refundAddr string) (string, error) {
+	s.bindMu.RLock()
+	defer s.bindMu.RUnlock()
+
+	if s.quiesced {
+		return "", ErrQuiesced
+	}
+
+	if !s.bindGate.TryEnter() {
+		return "", ErrMaintenance
+	}
+	defer s.bindGate.Leave()
+
+	s.tracker.Track(analytics.EventBindStarted, skyAddr, analytics.Properties{
+		"coin_type": coinType,
+	})
+
+	reached, err := s.exchanger.CapReached()
+	if err != nil {
+		return "", err
+	}
+	if reached {
+		return "", exchange.ErrCampaignCapReached
+	}
+
 	if s.cfg.MaxBoundAddresses > 0 {
 		num, err := s.exchanger.GetBindNum(skyAddr)
 		if err != nil {
@@ -89,17 +239,124 @@ func (s *Service) BindAddress(skyAddr, coinType string) (string, error) {
 			return "", ErrMaxBoundAddresses
 		}
 	}
+
+	if refundAddr != "" {
+		bound, err := s.exchanger.IsBound(refundAddr, coinType)
+		if err != nil {
+			return "", err
+		}
+		if bound {
+			return "", ErrRefundAddressIsDeposit
+		}
+	}
+
 	depositAddr, err := s.addrManager.NewAddress(coinType)
 	if err != nil {
+		if err == addrs.ErrDepositAddressEmpty {
+			s.log.WithFields(logrus.Fields{
+				"alert":    "address_pool_depleted",
+				"coinType": coinType,
+			}).Error("ALERT: deposit address pool is depleted, binding is unavailable")
+		}
 		return "", err
 	}
-	if err := s.exchanger.BindAddress(skyAddr, depositAddr, coinType); err != nil {
+	if err := s.exchanger.BindAddress(skyAddr, depositAddr, coinType, refundAddr); err != nil {
 		return "", err
 	}
+
+	s.tracker.Track(analytics.EventBindCompleted, skyAddr, analytics.Properties{
+		"coin_type": coinType,
+	})
+
 	return depositAddr, nil
 }
 
+// Maintenance returns true if binding is paused for maintenance
+func (s *Service) Maintenance() bool {
+	return s.bindGate.Status().Paused
+}
+
+// ContactsEnabled returns true if contact emails can be given for bindings
+func (s *Service) ContactsEnabled() bool {
+	return s.contacts != nil
+}
+
+// AddContact saves the contact email of a binding, and the language of its emails
+func (s *Service) AddContact(skyAddr, depositAddr, coinType, email, language string) error {
+	return s.contacts.AddContact(skyAddr, depositAddr, coinType, email, language)
+}
+
+// EraseContact deletes the contact email of a binding, sig is the signature from its status link
+func (s *Service) EraseContact(skyAddr, depositAddr, sig string) error {
+	return s.contacts.EraseContact(skyAddr, depositAddr, sig)
+}
+
+// Depleted returns true if the deposit address pool of coinType has no addresses left
+func (s *Service) Depleted(coinType string) (bool, error) {
+	n, err := s.addrManager.Remaining(coinType)
+	if err != nil {
+		return false, err
+	}
+	return n == 0, nil
+}
+
+// CapReached returns true if the campaign cap was reached, binding is closed then
+func (s *Service) CapReached() (bool, error) {
+	return s.exchanger.CapReached()
+}
+
+// IsBound returns true if the deposit address of coinType is bound to a skycoin address
+func (s *Service) IsBound(depositAddr, coinType string) (bool, error) {
+	return s.exchanger.IsBound(depositAddr, coinType)
+}
+
+// GetBoundSkyAddress returns the skycoin address the deposit address of coinType is bound to, empty if it isn't bound
+func (s *Service) GetBoundSkyAddress(depositAddr, coinType string) (string, error) {
+	return s.exchanger.GetBoundSkyAddress(depositAddr, coinType)
+}
+
+// GetBindNum returns the number of deposit addresses bound to the skycoin address
+func (s *Service) GetBindNum(skyAddr string) (int, error) {
+	return s.exchanger.GetBindNum(skyAddr)
+}
+
+// SkyAddressSeen returns true if the skycoin address has received coins on chain
+func (s *Service) SkyAddressSeen(skyAddr string) (bool, error) {
+	return s.skyChain.AddressSeen(skyAddr)
+}
+
+// GetRateHistory returns the rate changes of a coin type, oldest first
+func (s *Service) GetRateHistory(coinType string) ([]exchange.RateChange, error) {
+	return s.exchanger.GetRateHistory(coinType)
+}
+
+// CurrentRate returns the rate a deposit of coinType received now would be converted at
+func (s *Service) CurrentRate(coinType string) (string, error) {
+	return s.exchanger.CurrentRate(coinType)
+}
+
+// GetPayoutLog returns up to limit payout log entries with a Seq greater than since, oldest first
+func (s *Service) GetPayoutLog(since uint64, limit int) ([]exchange.PayoutLogEntry, error) {
+	return s.exchanger.GetPayoutLog(since, limit)
+}
+
+// GetCampaignStats returns the public aggregate totals of the campaign
+func (s *Service) GetCampaignStats() (exchange.CampaignStats, error) {
+	return s.exchanger.GetCampaignStats()
+}
+
 // GetDepositStatuses returns deposit status of given skycoin address
 func (s *Service) GetDepositStatuses(skyAddr string) ([]exchange.DepositStatus, error) {
 	return s.exchanger.GetDepositStatuses(skyAddr)
 }
+
+// GetDepositRecord returns the record of a deposit, or exchange.ErrDepositNotFound
+func (s *Service) GetDepositRecord(depositID string) (exchange.DepositRecord, error) {
+	return s.exchanger.GetDepositRecord(depositID)
+}
+
+// WatchDepositStatuses returns a channel which is closed at the next change of the deposit statuses
+// of skyAddr, and a func which stops watching, which must be called
+func (s *Service) WatchDepositStatuses(skyAddr string) (<-chan struct{}, func()) {
+	return s.exchanger.WatchDepositStatuses(skyAddr)
+}
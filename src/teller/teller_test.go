@@ -37,6 +37,10 @@ func (de dummyExchanger) GetDepositStatusDetail(flt exchange.DepositFilter) ([]e
 	return nil, nil
 }
 
+func (de dummyExchanger) QueryDepositStatusDetail(q exchange.DepositQuery) ([]exchange.DepositStatusDetail, error) {
+	return nil, nil
+}
+
 func (de dummyExchanger) BindNum(skyAddr string) (int, error) {
 	if de.skyAddrs == nil {
 		return 0, nil
@@ -0,0 +1,223 @@
+package teller
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gz-c/tollbooth"
+	"github.com/gz-c/tollbooth/limiter"
+	"github.com/rs/cors"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/httputil"
+	"github.com/skycoin/teller/src/util/logger"
+)
+
+// Widget mode lets partner checkout pages embed the website in a frame.
+// The partner page requests a widget session token from /api/widget/session,
+// then passes it to the embedded website, which sends it in the widgetSessionHeader
+// of its bind and status requests. Those requests are rate limited per session.
+
+// widgetSessionHeader carries a widget session token on bind and status requests
+const widgetSessionHeader = "X-Widget-Session"
+
+var (
+	// errInvalidWidgetSession is returned for a widget session token which is malformed, forged or expired
+	errInvalidWidgetSession = errors.New("Invalid or expired widget session")
+	// errWidgetOrigin is returned when a widget session is requested by a page which is not a partner origin
+	errWidgetOrigin = errors.New("Origin is not a widget partner")
+)
+
+// widgetGate issues and checks widget session tokens
+type widgetGate struct {
+	origins    []string
+	originsSet map[string]struct{}
+	key        []byte
+	ttl        time.Duration
+	limiter    *limiter.Limiter
+}
+
+// newWidgetGate creates a widgetGate, or returns nil if widget mode is disabled
+func newWidgetGate(cfg config.Widget) *widgetGate {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	g := &widgetGate{
+		originsSet: make(map[string]struct{}, len(cfg.PartnerOrigins)),
+		key:        []byte(cfg.SigningKey),
+		ttl:        cfg.SessionTTL,
+		limiter:    tollbooth.NewLimiter(cfg.ThrottleMax, cfg.ThrottleDuration, nil),
+	}
+
+	for _, o := range cfg.PartnerOrigins {
+		o = strings.TrimSuffix(o, "/")
+		g.origins = append(g.origins, o)
+		g.originsSet[o] = struct{}{}
+	}
+
+	return g
+}
+
+// frameAncestors returns the CSP which allows the partner origins to embed the website
+func (g *widgetGate) frameAncestors() string {
+	return "frame-ancestors 'self' " + strings.Join(g.origins, " ")
+}
+
+func (g *widgetGate) isPartner(origin string) bool {
+	_, ok := g.originsSet[origin]
+	return ok
+}
+
+func (g *widgetGate) sign(payload string) []byte {
+	h := hmac.New(sha256.New, g.key)
+	h.Write([]byte(payload)) // nolint: errcheck
+	return h.Sum(nil)
+}
+
+// issue returns a session token for origin, valid until the returned expiry time.
+// The token is the base64 encoded "origin\nexpiry\nnonce" payload and its hex encoded signature, joined by ".".
+func (g *widgetGate) issue(origin string, now time.Time) (string, time.Time, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := now.Add(g.ttl).UTC()
+	payload := strings.Join([]string{
+		origin,
+		strconv.FormatInt(expiresAt.Unix(), 10),
+		hex.EncodeToString(nonce),
+	}, "\n")
+
+	token := base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + hex.EncodeToString(g.sign(payload))
+	return token, expiresAt, nil
+}
+
+// verify returns the partner origin of a session token which is valid at time now
+func (g *widgetGate) verify(token string, now time.Time) (string, error) {
+	pts := strings.Split(token, ".")
+	if len(pts) != 2 {
+		return "", errInvalidWidgetSession
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(pts[0])
+	if err != nil {
+		return "", errInvalidWidgetSession
+	}
+	payload := string(b)
+
+	sig, err := hex.DecodeString(pts[1])
+	if err != nil || !hmac.Equal(sig, g.sign(payload)) {
+		return "", errInvalidWidgetSession
+	}
+
+	fields := strings.Split(payload, "\n")
+	if len(fields) != 3 {
+		return "", errInvalidWidgetSession
+	}
+
+	expiresAt, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return "", errInvalidWidgetSession
+	}
+
+	// A partner removed from the config can no longer use its sessions
+	if !g.isPartner(fields[0]) {
+		return "", errInvalidWidgetSession
+	}
+
+	return fields[0], nil
+}
+
+// limit wraps a handler to check the widget session token of requests which send one,
+// and to rate limit them per session. Requests without a token are passed through.
+// Tokens are checked against the time of clk.
+func (g *widgetGate) limit(h http.Handler, clk clock.Clock) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(widgetSessionHeader)
+		if token == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+
+		if _, err := g.verify(token, clk.Now()); err != nil {
+			errorResponse(ctx, w, http.StatusUnauthorized, err)
+			return
+		}
+
+		if g.limiter.LimitReached(token) {
+			w.Header().Set(errCodeHeader, errCodeRateLimited)
+			w.Header().Set("Retry-After", limitRetryAfter(g.limiter))
+			errorResponse(ctx, w, http.StatusTooManyRequests, errors.New("Too many requests for this widget session"))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// WidgetSessionResponse http response for /api/widget/session
+type WidgetSessionResponse struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// WidgetSessionHandler issues a widget session token to a partner page
+// Method: POST
+// URI: /api/widget/session
+func WidgetSessionHandler(s *HTTPServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.FromContext(ctx)
+
+		if !validMethod(ctx, w, r, []string{http.MethodPost}) {
+			return
+		}
+
+		origin := r.Header.Get("Origin")
+		if !s.widget.isPartner(origin) {
+			errorResponse(ctx, w, http.StatusForbidden, errWidgetOrigin)
+			return
+		}
+
+		// The session would expire at the wrong time
+		if s.skew.Skewed() {
+			clockSkewResponse(ctx, w)
+			return
+		}
+
+		token, expiresAt, err := s.widget.issue(origin, s.clock.Now())
+		if err != nil {
+			log.WithError(err).Error("widget.issue failed")
+			errorResponse(ctx, w, http.StatusInternalServerError, errInternalServerError)
+			return
+		}
+
+		if err := httputil.JSONResponse(w, WidgetSessionResponse{
+			Token:     token,
+			ExpiresAt: expiresAt.Unix(),
+		}); err != nil {
+			log.WithError(err).Error(err)
+		}
+	}
+}
+
+// widgetCORS allows the partner origins to request widget sessions
+func (g *widgetGate) widgetCORS(h http.Handler) http.Handler {
+	return cors.New(cors.Options{
+		AllowedOrigins: g.origins,
+		AllowedMethods: []string{http.MethodPost},
+	}).Handler(h)
+}
@@ -0,0 +1,215 @@
+package teller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/config"
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/logger"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func testWidgetConfig() config.Widget {
+	return config.Widget{
+		Enabled:          true,
+		PartnerOrigins:   []string{"https://shop.example.com", "https://pay.example.org/"},
+		SigningKey:       "widget-key",
+		SessionTTL:       time.Minute,
+		ThrottleMax:      2,
+		ThrottleDuration: time.Hour,
+	}
+}
+
+func TestWidgetGate(t *testing.T) {
+	require.Nil(t, newWidgetGate(config.Widget{}))
+
+	g := newWidgetGate(testWidgetConfig())
+	require.Equal(t, "frame-ancestors 'self' https://shop.example.com https://pay.example.org", g.frameAncestors())
+	require.True(t, g.isPartner("https://pay.example.org"))
+	require.False(t, g.isPartner("https://evil.example.com"))
+
+	now := time.Now()
+	token, expiresAt, err := g.issue("https://shop.example.com", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(time.Minute).Unix(), expiresAt.Unix())
+
+	origin, err := g.verify(token, now)
+	require.NoError(t, err)
+	require.Equal(t, "https://shop.example.com", origin)
+
+	// Each session gets its own token
+	token2, _, err := g.issue("https://shop.example.com", now)
+	require.NoError(t, err)
+	require.NotEqual(t, token, token2)
+
+	// Expired
+	_, err = g.verify(token, expiresAt)
+	require.Equal(t, errInvalidWidgetSession, err)
+
+	// Tampered payload or signature
+	pts := strings.Split(token, ".")
+	_, err = g.verify(pts[0]+"."+strings.Repeat("0", len(pts[1])), now)
+	require.Equal(t, errInvalidWidgetSession, err)
+	_, err = g.verify("x"+token, now)
+	require.Equal(t, errInvalidWidgetSession, err)
+	_, err = g.verify("garbage", now)
+	require.Equal(t, errInvalidWidgetSession, err)
+
+	// Signed with another key
+	cfg := testWidgetConfig()
+	cfg.SigningKey = "other-key"
+	_, err = newWidgetGate(cfg).verify(token, now)
+	require.Equal(t, errInvalidWidgetSession, err)
+
+	// Partner removed from the config
+	cfg = testWidgetConfig()
+	cfg.PartnerOrigins = []string{"https://pay.example.org"}
+	_, err = newWidgetGate(cfg).verify(token, now)
+	require.Equal(t, errInvalidWidgetSession, err)
+}
+
+func TestWidgetSessionHandler(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	s := NewHTTPServer(log, config.Config{
+		Widget: testWidgetConfig(),
+	}, &Service{}, nil, clock.Real{})
+	require.Equal(t, "<redacted>", s.cfg.Widget.SigningKey)
+
+	h := s.setupMux()
+
+	tt := []struct {
+		name   string
+		method string
+		origin string
+		code   int
+	}{
+		{
+			name:   "partner",
+			method: http.MethodPost,
+			origin: "https://shop.example.com",
+			code:   http.StatusOK,
+		},
+		{
+			name:   "not a partner",
+			method: http.MethodPost,
+			origin: "https://evil.example.com",
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "no origin",
+			method: http.MethodPost,
+			code:   http.StatusForbidden,
+		},
+		{
+			name:   "invalid method",
+			method: http.MethodGet,
+			origin: "https://shop.example.com",
+			code:   http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/widget/session", nil)
+			if tc.origin != "" {
+				req.Header.Set("Origin", tc.origin)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+			require.Equal(t, tc.code, w.Code, w.Body.String())
+
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			require.Equal(t, tc.origin, w.Header().Get("Access-Control-Allow-Origin"))
+
+			var rsp WidgetSessionResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&rsp))
+			require.NotEmpty(t, rsp.ExpiresAt)
+
+			origin, err := s.widget.verify(rsp.Token, time.Now())
+			require.NoError(t, err)
+			require.Equal(t, tc.origin, origin)
+		})
+	}
+
+	// Widget mode disabled
+	s = NewHTTPServer(log, config.Config{}, &Service{}, nil, clock.Real{})
+	req := httptest.NewRequest(http.MethodPost, "/api/widget/session", nil)
+	req.Header.Set("Origin", "https://shop.example.com")
+	w := httptest.NewRecorder()
+	s.setupMux().ServeHTTP(w, req)
+	require.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestWidgetLimit(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	clk := clock.NewFake(time.Now())
+	g := newWidgetGate(testWidgetConfig())
+	h := g.limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), clk)
+
+	var lastRetryAfter string
+	do := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		req = req.WithContext(logger.WithContext(req.Context(), log))
+		if token != "" {
+			req.Header.Set(widgetSessionHeader, token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		lastRetryAfter = w.Header().Get("Retry-After")
+		return w.Code
+	}
+
+	// Requests without a session are not limited here
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusOK, do(""))
+	}
+
+	require.Equal(t, http.StatusUnauthorized, do("garbage"))
+
+	token, _, err := g.issue("https://shop.example.com", clk.Now())
+	require.NoError(t, err)
+	token2, _, err := g.issue("https://shop.example.com", clk.Now())
+	require.NoError(t, err)
+
+	// 2 requests per hour, which the limiter spreads out
+	require.Equal(t, http.StatusOK, do(token))
+	require.Equal(t, http.StatusTooManyRequests, do(token))
+	require.Equal(t, "1800", lastRetryAfter)
+
+	// Each session has its own limit
+	require.Equal(t, http.StatusOK, do(token2))
+
+	// Sessions expire
+	clk.Advance(testWidgetConfig().SessionTTL + time.Second)
+	require.Equal(t, http.StatusUnauthorized, do(token2))
+}
+
+func TestConfigureSecureMiddlewareFrameAncestors(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	configureSecureMiddleware("", nil, "").Handler(h).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	require.Empty(t, w.Header().Get("Content-Security-Policy"))
+
+	frameAncestors := newWidgetGate(testWidgetConfig()).frameAncestors()
+	w = httptest.NewRecorder()
+	configureSecureMiddleware("", nil, frameAncestors).Handler(h).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Empty(t, w.Header().Get("X-Frame-Options"))
+	require.Equal(t, frameAncestors, w.Header().Get("Content-Security-Policy"))
+}
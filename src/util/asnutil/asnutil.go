@@ -0,0 +1,165 @@
+// Package asnutil looks up the autonomous system (ASN) of an IP address, from the
+// CSV edition of the MaxMind GeoLite2 ASN database
+package asnutil
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// ASN is an autonomous system
+type ASN struct {
+	Number       uint32 `json:"number"`
+	Organization string `json:"organization"`
+}
+
+// ipRange is the range of IPs of a network, in their 16 byte form
+type ipRange struct {
+	start net.IP
+	end   net.IP
+	asn   ASN
+}
+
+// DB maps IP networks to their ASN. The zero value is an empty DB.
+type DB struct {
+	ranges []ipRange
+}
+
+// LoadFiles loads the GeoLite2 ASN CSV files into one DB, e.g.
+// GeoLite2-ASN-Blocks-IPv4.csv and GeoLite2-ASN-Blocks-IPv6.csv
+func LoadFiles(paths ...string) (*DB, error) {
+	db := &DB{}
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+
+		err = db.load(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Load ASN db %s failed: %v", p, err)
+		}
+	}
+
+	db.sort()
+	return db, nil
+}
+
+// Load loads a GeoLite2 ASN CSV file, which has a header row and the columns
+// network, autonomous_system_number and autonomous_system_organization
+func Load(r io.Reader) (*DB, error) {
+	db := &DB{}
+	if err := db.load(r); err != nil {
+		return nil, err
+	}
+	db.sort()
+	return db, nil
+}
+
+func (db *DB) load(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(rec) < 2 {
+			return fmt.Errorf("line %d: expected at least 2 columns, got %d", line, len(rec))
+		}
+
+		_, n, err := net.ParseCIDR(rec[0])
+		if err != nil {
+			if line == 1 {
+				// Header
+				continue
+			}
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+
+		number, err := strconv.ParseUint(rec[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid autonomous_system_number %q", line, rec[1])
+		}
+
+		asn := ASN{
+			Number: uint32(number),
+		}
+		if len(rec) > 2 {
+			asn.Organization = rec[2]
+		}
+
+		db.ranges = append(db.ranges, newIPRange(n, asn))
+	}
+}
+
+func newIPRange(n *net.IPNet, asn ASN) ipRange {
+	start := n.IP.To16()
+	end := make(net.IP, net.IPv6len)
+	copy(end, start)
+
+	// A 4 byte IPv4 mask applies to the last 4 bytes of the 16 byte form
+	mask := n.Mask
+	offset := net.IPv6len - len(mask)
+	for i := range mask {
+		end[offset+i] |= ^mask[i]
+	}
+
+	return ipRange{
+		start: start,
+		end:   end,
+		asn:   asn,
+	}
+}
+
+func (db *DB) sort() {
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+}
+
+// Len returns the number of networks in the DB
+func (db *DB) Len() int {
+	if db == nil {
+		return 0
+	}
+	return len(db.ranges)
+}
+
+// Lookup returns the ASN of an IP, or false if its network isn't in the DB
+func (db *DB) Lookup(ip net.IP) (ASN, bool) {
+	if db == nil {
+		return ASN{}, false
+	}
+
+	ip = ip.To16()
+	if ip == nil {
+		return ASN{}, false
+	}
+
+	// The last network starting at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 {
+		return ASN{}, false
+	}
+
+	if bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return ASN{}, false
+	}
+
+	return db.ranges[i].asn, true
+}
@@ -0,0 +1,96 @@
+package asnutil
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testIPv4CSV = `network,autonomous_system_number,autonomous_system_organization
+1.0.0.0/24,13335,CLOUDFLARENET
+8.8.8.0/24,15169,GOOGLE
+192.0.2.0/25,64496,"Example, Inc."
+`
+
+const testIPv6CSV = `network,autonomous_system_number,autonomous_system_organization
+2001:db8::/32,64497,EXAMPLE-V6
+`
+
+func TestLoad(t *testing.T) {
+	db, err := Load(strings.NewReader(testIPv4CSV))
+	require.NoError(t, err)
+	require.Equal(t, 3, db.Len())
+
+	cases := []struct {
+		ip  string
+		asn ASN
+		ok  bool
+	}{
+		{"1.0.0.1", ASN{13335, "CLOUDFLARENET"}, true},
+		{"1.0.0.255", ASN{13335, "CLOUDFLARENET"}, true},
+		{"1.0.1.0", ASN{}, false},
+		{"8.8.8.8", ASN{15169, "GOOGLE"}, true},
+		{"192.0.2.127", ASN{64496, "Example, Inc."}, true},
+		{"192.0.2.128", ASN{}, false},
+		{"0.0.0.1", ASN{}, false},
+		{"255.255.255.255", ASN{}, false},
+		{"::ffff:8.8.8.8", ASN{15169, "GOOGLE"}, true},
+	}
+
+	for _, tc := range cases {
+		asn, ok := db.Lookup(net.ParseIP(tc.ip))
+		require.Equal(t, tc.ok, ok, tc.ip)
+		require.Equal(t, tc.asn, asn, tc.ip)
+	}
+
+	for _, s := range []string{
+		"network,autonomous_system_number\n1.0.0.0/24,x\n",
+		"network,autonomous_system_number\n1.0.0.0/24,1\nfoo,2\n",
+		"network\n1.0.0.0/24\n",
+	} {
+		_, err := Load(strings.NewReader(s))
+		require.Error(t, err, s)
+	}
+
+	// A nil or empty DB has no networks
+	var empty *DB
+	require.Equal(t, 0, empty.Len())
+	_, ok := empty.Lookup(net.ParseIP("8.8.8.8"))
+	require.False(t, ok)
+	_, ok = (&DB{}).Lookup(net.ParseIP("8.8.8.8"))
+	require.False(t, ok)
+}
+
+func TestLoadFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asnutil")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	v4 := filepath.Join(dir, "GeoLite2-ASN-Blocks-IPv4.csv")
+	v6 := filepath.Join(dir, "GeoLite2-ASN-Blocks-IPv6.csv")
+	require.NoError(t, ioutil.WriteFile(v4, []byte(testIPv4CSV), 0600))
+	require.NoError(t, ioutil.WriteFile(v6, []byte(testIPv6CSV), 0600))
+
+	db, err := LoadFiles(v4, v6)
+	require.NoError(t, err)
+	require.Equal(t, 4, db.Len())
+
+	asn, ok := db.Lookup(net.ParseIP("2001:db8:ffff::1"))
+	require.True(t, ok)
+	require.Equal(t, ASN{64497, "EXAMPLE-V6"}, asn)
+
+	asn, ok = db.Lookup(net.ParseIP("8.8.8.8"))
+	require.True(t, ok)
+	require.Equal(t, uint32(15169), asn.Number)
+
+	_, ok = db.Lookup(net.ParseIP("2001:db9::1"))
+	require.False(t, ok)
+
+	_, err = LoadFiles(filepath.Join(dir, "missing.csv"))
+	require.Error(t, err)
+}
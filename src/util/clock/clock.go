@@ -0,0 +1,160 @@
+// Package clock provides the current time to time dependent behavior, such as the
+// teller.start_at launch gate, so that it can be tested and rehearsed at a chosen time
+package clock
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/skycoin/teller/src/util/httputil"
+)
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+// Real is the system clock
+type Real struct{}
+
+// Now returns time.Now()
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Fake is a clock which stands still until it is set or advanced, for tests
+type Fake struct {
+	sync.Mutex
+	t time.Time
+}
+
+// NewFake creates a Fake stopped at t
+func NewFake(t time.Time) *Fake {
+	return &Fake{
+		t: t,
+	}
+}
+
+// Now returns the time the clock is stopped at
+func (c *Fake) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.t
+}
+
+// Set stops the clock at t
+func (c *Fake) Set(t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = t
+}
+
+// Advance moves the clock forward by d
+func (c *Fake) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// Shifted is the system clock moved by an adjustable offset, for rehearsing in a sandbox.
+// Unlike Fake the time keeps running, so that timeouts and intervals behave normally.
+type Shifted struct {
+	sync.RWMutex
+	offset time.Duration
+	now    func() time.Time
+}
+
+// NewShifted creates a Shifted clock with no offset
+func NewShifted() *Shifted {
+	return &Shifted{
+		now: time.Now,
+	}
+}
+
+// Now returns the system time plus the offset
+func (c *Shifted) Now() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.now().Add(c.offset)
+}
+
+// Offset returns how far the clock is from the system time
+func (c *Shifted) Offset() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return c.offset
+}
+
+// Set moves the clock to t, from where it keeps running
+func (c *Shifted) Set(t time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.offset = t.Sub(c.now())
+}
+
+// Advance moves the clock forward by d, or back if d is negative
+func (c *Shifted) Advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.offset += d
+}
+
+// Reset moves the clock back to the system time
+func (c *Shifted) Reset() {
+	c.Lock()
+	defer c.Unlock()
+	c.offset = 0
+}
+
+// HTTP interface
+
+// BindHandlers binds the time travel API handlers to the mux
+func (c *Shifted) BindHandlers(mux *http.ServeMux) {
+	mux.Handle("/dummy/clock", http.HandlerFunc(c.clockHandler))
+}
+
+type clockResponse struct {
+	Now    time.Time `json:"now"`
+	Offset string    `json:"offset"`
+}
+
+// clockHandler returns the time of the clock. It is moved by the form values
+// "set", an RFC3339 time, "advance", a duration, or "reset".
+func (c *Shifted) clockHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		switch {
+		case r.FormValue("set") != "":
+			t, err := time.Parse(time.RFC3339, r.FormValue("set"))
+			if err != nil {
+				httputil.ErrResponse(w, http.StatusBadRequest, "invalid set, must be an RFC3339 time")
+				return
+			}
+			c.Set(t)
+		case r.FormValue("advance") != "":
+			d, err := time.ParseDuration(r.FormValue("advance"))
+			if err != nil {
+				httputil.ErrResponse(w, http.StatusBadRequest, "invalid advance, must be a duration")
+				return
+			}
+			c.Advance(d)
+		case r.FormValue("reset") != "":
+			c.Reset()
+		default:
+			httputil.ErrResponse(w, http.StatusBadRequest, "set, advance or reset required")
+			return
+		}
+	default:
+		httputil.ErrResponse(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := httputil.JSONResponse(w, clockResponse{
+		Now:    c.Now().UTC(),
+		Offset: c.Offset().String(),
+	}); err != nil {
+		httputil.ErrResponse(w, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,141 @@
+package clock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	require.Equal(t, start, c.Now())
+
+	c.Advance(time.Hour)
+	require.Equal(t, start.Add(time.Hour), c.Now())
+
+	c.Set(start)
+	require.Equal(t, start, c.Now())
+}
+
+func TestShifted(t *testing.T) {
+	system := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewShifted()
+	c.now = func() time.Time {
+		return system
+	}
+
+	require.Equal(t, system, c.Now())
+
+	c.Set(system.Add(time.Hour * 24))
+	require.Equal(t, time.Hour*24, c.Offset())
+
+	// The clock keeps running
+	system = system.Add(time.Minute)
+	require.Equal(t, system.Add(time.Hour*24), c.Now())
+
+	c.Advance(-time.Hour)
+	require.Equal(t, time.Hour*23, c.Offset())
+
+	c.Reset()
+	require.Equal(t, system, c.Now())
+}
+
+func TestShiftedClockHandler(t *testing.T) {
+	system := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := NewShifted()
+	c.now = func() time.Time {
+		return system
+	}
+
+	mux := http.NewServeMux()
+	c.BindHandlers(mux)
+
+	tt := []struct {
+		name   string
+		method string
+		form   string
+		code   int
+		now    time.Time
+		offset string
+	}{
+		{
+			name:   "get",
+			method: http.MethodGet,
+			code:   http.StatusOK,
+			now:    system,
+			offset: "0s",
+		},
+		{
+			name:   "set",
+			method: http.MethodPost,
+			form:   "set=2018-03-08T12:00:00Z",
+			code:   http.StatusOK,
+			now:    system.Add(time.Hour * 24 * 7),
+			offset: "168h0m0s",
+		},
+		{
+			name:   "advance",
+			method: http.MethodPost,
+			form:   "advance=-1h",
+			code:   http.StatusOK,
+			now:    system.Add(time.Hour * 167),
+			offset: "167h0m0s",
+		},
+		{
+			name:   "reset",
+			method: http.MethodPost,
+			form:   "reset=1",
+			code:   http.StatusOK,
+			now:    system,
+			offset: "0s",
+		},
+		{
+			name:   "invalid set",
+			method: http.MethodPost,
+			form:   "set=tomorrow",
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid advance",
+			method: http.MethodPost,
+			form:   "advance=1 day",
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "missing form",
+			method: http.MethodPost,
+			code:   http.StatusBadRequest,
+		},
+		{
+			name:   "invalid method",
+			method: http.MethodPut,
+			code:   http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/dummy/clock", strings.NewReader(tc.form))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			mux.ServeHTTP(w, req)
+			require.Equal(t, tc.code, w.Code, w.Body.String())
+
+			if tc.code != http.StatusOK {
+				return
+			}
+
+			var rsp clockResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rsp))
+			require.True(t, tc.now.Equal(rsp.Now), rsp.Now.String())
+			require.Equal(t, tc.offset, rsp.Offset)
+		})
+	}
+}
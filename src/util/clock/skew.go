@@ -0,0 +1,340 @@
+package clock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	skewCheckInterval = time.Minute
+	ntpTimeout        = time.Second * 5
+	ntpPort           = "123"
+	ntpPacketSize     = 48
+	// ntpEpochOffset is the number of seconds from the NTP epoch, 1900, to the unix epoch
+	ntpEpochOffset = 2208988800
+)
+
+// SkewConfig configures the SkewGuard
+type SkewConfig struct {
+	// NTP servers, as host or host:port, whose median offset is compared to MaxSkew
+	NTPServers []string
+	// Max offset of the clock from the NTP servers. 0 disables the NTP check.
+	MaxSkew time.Duration
+	// Max distance of the clock from the newest chain tip's block time. 0 disables the chain check.
+	// Block times are only loosely tied to the real time, so this catches a clock which is hours or days off.
+	MaxChainSkew time.Duration
+	// How often to check the clock
+	CheckInterval time.Duration
+}
+
+// ChainSource returns the block time of a chain's tip, such as btcd's best block
+type ChainSource struct {
+	Name string
+	Tip  func() (time.Time, error)
+}
+
+// SkewStatus is the last check of the SkewGuard
+type SkewStatus struct {
+	// Median offset of the NTP servers from the clock. Positive if the clock is behind.
+	NTPOffset time.Duration
+	// Number of NTP servers which responded
+	NTPServers int
+	// Block time of the newest chain tip, and its distance from the clock. Positive if the clock is behind.
+	ChainTip    time.Time
+	ChainOffset time.Duration
+	Skewed      bool
+	Reason      string
+	CheckedAt   time.Time
+	// The time of the last check which found the clock right
+	SyncedAt time.Time
+}
+
+// SkewGuard compares a clock to NTP servers and the block times of chain tips, and reports it as
+// skewed when it is off by more than a threshold. Time-sensitive behavior, such as the
+// teller.start_at launch gate, is paused while the clock is skewed, since a skewed clock
+// could open a sale early.
+type SkewGuard struct {
+	sync.RWMutex
+	log    logrus.FieldLogger
+	cfg    SkewConfig
+	clock  Clock
+	chains []ChainSource
+	status SkewStatus
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+// NewSkewGuard creates a SkewGuard of clk. It returns nil if both checks are disabled, which is safe to use.
+func NewSkewGuard(log logrus.FieldLogger, cfg SkewConfig, clk Clock, chains []ChainSource) *SkewGuard {
+	if len(cfg.NTPServers) == 0 {
+		cfg.MaxSkew = 0
+	}
+	if len(chains) == 0 {
+		cfg.MaxChainSkew = 0
+	}
+	if cfg.MaxSkew <= 0 && cfg.MaxChainSkew <= 0 {
+		return nil
+	}
+
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = skewCheckInterval
+	}
+
+	return &SkewGuard{
+		log:    log.WithField("prefix", "teller.clock"),
+		cfg:    cfg,
+		clock:  clk,
+		chains: chains,
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Run checks the clock at startup, then every CheckInterval until Shutdown is called
+func (g *SkewGuard) Run() error {
+	log := g.log.WithField("config", g.cfg)
+	log.Info("Start clock skew guard")
+	defer log.Info("Clock skew guard closed")
+	defer close(g.done)
+
+	ticker := time.NewTicker(g.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		g.Check()
+
+		select {
+		case <-g.quit:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown stops the SkewGuard
+func (g *SkewGuard) Shutdown() {
+	close(g.quit)
+	<-g.done
+}
+
+// Check measures the clock and returns true if it is skewed. If no NTP server or chain
+// responds, the last measurement is kept.
+func (g *SkewGuard) Check() bool {
+	if g == nil {
+		return false
+	}
+
+	status := g.Status()
+
+	if g.cfg.MaxSkew > 0 {
+		if offset, n := g.ntpOffset(); n > 0 {
+			status.NTPOffset = offset
+			status.NTPServers = n
+		} else {
+			g.log.WithField("lastNTPOffset", status.NTPOffset).Error("No NTP server responded")
+		}
+	}
+
+	now := g.clock.Now()
+
+	if g.cfg.MaxChainSkew > 0 {
+		if tip, ok := g.chainTip(); ok {
+			status.ChainTip = tip
+		} else {
+			g.log.WithField("lastChainTip", status.ChainTip).Error("No chain tip source responded")
+		}
+		if !status.ChainTip.IsZero() {
+			status.ChainOffset = status.ChainTip.Sub(now)
+		}
+	}
+
+	skewed, reason := g.skewed(status)
+
+	log := g.log.WithFields(logrus.Fields{
+		"ntpOffset":   status.NTPOffset,
+		"chainOffset": status.ChainOffset,
+		"reason":      reason,
+	})
+
+	switch {
+	case skewed && !status.Skewed:
+		log.WithField("alert", "clock_skew").Error("ALERT: clock is skewed, paused time-sensitive operations")
+	case !skewed && status.Skewed:
+		log.Info("Clock is back in sync, resumed time-sensitive operations")
+	}
+
+	status.Skewed = skewed
+	status.Reason = reason
+	status.CheckedAt = now
+	if !skewed {
+		status.SyncedAt = now
+	}
+
+	g.Lock()
+	defer g.Unlock()
+	g.status = status
+
+	return skewed
+}
+
+// skewed returns true and the reason if the clock is off by more than a threshold
+func (g *SkewGuard) skewed(status SkewStatus) (bool, string) {
+	if g.cfg.MaxSkew > 0 && status.NTPServers > 0 && abs(status.NTPOffset) > g.cfg.MaxSkew {
+		return true, fmt.Sprintf("clock is %s from NTP, max %s", status.NTPOffset, g.cfg.MaxSkew)
+	}
+
+	if g.cfg.MaxChainSkew > 0 && !status.ChainTip.IsZero() && abs(status.ChainOffset) > g.cfg.MaxChainSkew {
+		return true, fmt.Sprintf("clock is %s from the newest chain tip, max %s", status.ChainOffset, g.cfg.MaxChainSkew)
+	}
+
+	return false, ""
+}
+
+// Skewed returns true if the last check found the clock skewed
+func (g *SkewGuard) Skewed() bool {
+	if g == nil {
+		return false
+	}
+
+	g.RLock()
+	defer g.RUnlock()
+	return g.status.Skewed
+}
+
+// Status returns the last check of the clock
+func (g *SkewGuard) Status() SkewStatus {
+	if g == nil {
+		return SkewStatus{}
+	}
+
+	g.RLock()
+	defer g.RUnlock()
+	return g.status
+}
+
+// ntpOffset returns the median offset of the NTP servers which responded, so that a single
+// broken server can't pause or unpause teller, and the number of servers which responded
+func (g *SkewGuard) ntpOffset() (time.Duration, int) {
+	var offsets []time.Duration
+	for _, s := range g.cfg.NTPServers {
+		offset, err := queryNTP(s, g.clock.Now)
+		if err != nil {
+			g.log.WithError(err).WithField("ntpServer", s).Warn("NTP query failed")
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+
+	if len(offsets) == 0 {
+		return 0, 0
+	}
+
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i] < offsets[j]
+	})
+
+	return offsets[len(offsets)/2], len(offsets)
+}
+
+// chainTip returns the newest block time of the chains which responded.
+// A stalled node's old tip doesn't make the clock look ahead, as long as another chain is current.
+func (g *SkewGuard) chainTip() (time.Time, bool) {
+	var newest time.Time
+	for _, c := range g.chains {
+		t, err := c.Tip()
+		if err != nil {
+			g.log.WithError(err).WithField("chain", c.Name).Warn("Get chain tip time failed")
+			continue
+		}
+		if t.After(newest) {
+			newest = t
+		}
+	}
+
+	return newest, !newest.IsZero()
+}
+
+// queryNTP returns the offset of an NTP server from the clock now, with an SNTP request (RFC 4330)
+func queryNTP(server string, now func() time.Time) (time.Duration, error) {
+	addr := server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		addr = net.JoinHostPort(server, ntpPort)
+	}
+
+	conn, err := net.DialTimeout("udp", addr, ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(ntpTimeout)); err != nil {
+		return 0, err
+	}
+
+	req := make([]byte, ntpPacketSize)
+	// Leap indicator 0, version 4, mode 3 (client)
+	req[0] = 0x23
+	t1 := now()
+	// The server echoes the transmit timestamp as the originate timestamp of its response
+	putNTPTime(req[40:], t1)
+
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	rsp := make([]byte, ntpPacketSize)
+	n, err := conn.Read(rsp)
+	if err != nil {
+		return 0, err
+	}
+	t4 := now()
+
+	if n < ntpPacketSize {
+		return 0, errors.New("NTP response is too short")
+	}
+	if rsp[0]&0x7 != 4 {
+		return 0, errors.New("NTP response is not in server mode")
+	}
+	// Stratum 0 is a kiss-o'-death message, 16 is unsynchronized
+	if rsp[1] == 0 || rsp[1] >= 16 {
+		return 0, fmt.Errorf("NTP server is unsynchronized, stratum %d", rsp[1])
+	}
+	if !bytes.Equal(rsp[24:32], req[40:48]) {
+		return 0, errors.New("NTP response doesn't match the request")
+	}
+
+	t2 := ntpTime(rsp[32:40])
+	t3 := ntpTime(rsp[40:48])
+
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2, nil
+}
+
+// putNTPTime writes t as a 64 bit NTP timestamp
+func putNTPTime(b []byte, t time.Time) {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	binary.BigEndian.PutUint64(b, secs<<32|frac)
+}
+
+// ntpTime reads a 64 bit NTP timestamp
+func ntpTime(b []byte) time.Time {
+	v := binary.BigEndian.Uint64(b)
+	secs := int64(v>>32) - ntpEpochOffset
+	nsecs := int64(((v & 0xffffffff) * uint64(time.Second)) >> 32)
+	return time.Unix(secs, nsecs)
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
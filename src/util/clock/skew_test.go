@@ -0,0 +1,198 @@
+package clock
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+// runNTPServer runs an NTP server whose time is offset from the system time, and returns its address
+func runNTPServer(t *testing.T, offset time.Duration) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go func() {
+		buf := make([]byte, ntpPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < ntpPacketSize {
+				continue
+			}
+
+			rsp := make([]byte, ntpPacketSize)
+			// Version 4, mode 4 (server), stratum 2
+			rsp[0] = 0x24
+			rsp[1] = 2
+			copy(rsp[24:32], buf[40:48])
+			now := time.Now().Add(offset)
+			putNTPTime(rsp[32:], now)
+			putNTPTime(rsp[40:], now)
+
+			conn.WriteTo(rsp, addr) // nolint: errcheck
+		}
+	}()
+
+	return conn.LocalAddr().String(), func() {
+		conn.Close()
+	}
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Date(2018, 3, 1, 12, 0, 0, 123456789, time.UTC)
+	b := make([]byte, 8)
+	putNTPTime(b, now)
+	require.WithinDuration(t, now, ntpTime(b), time.Microsecond)
+}
+
+func TestQueryNTP(t *testing.T) {
+	addr, shutdown := runNTPServer(t, time.Hour)
+	defer shutdown()
+
+	offset, err := queryNTP(addr, time.Now)
+	require.NoError(t, err)
+	require.InDelta(t, float64(time.Hour), float64(offset), float64(time.Second))
+
+	// A clock which is ahead has a negative offset
+	offset, err = queryNTP(addr, func() time.Time {
+		return time.Now().Add(time.Hour * 2)
+	})
+	require.NoError(t, err)
+	require.InDelta(t, float64(-time.Hour), float64(offset), float64(time.Second))
+}
+
+func TestNewSkewGuardDisabled(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	require.Nil(t, NewSkewGuard(log, SkewConfig{}, Real{}, nil))
+	// The thresholds are ignored without their sources
+	require.Nil(t, NewSkewGuard(log, SkewConfig{
+		MaxSkew:      time.Second,
+		MaxChainSkew: time.Hour,
+	}, Real{}, nil))
+
+	// A nil guard is never skewed
+	var g *SkewGuard
+	require.False(t, g.Check())
+	require.False(t, g.Skewed())
+	require.Equal(t, SkewStatus{}, g.Status())
+}
+
+func TestSkewGuardNTP(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	inSync, shutdownInSync := runNTPServer(t, 0)
+	defer shutdownInSync()
+	behind, shutdownBehind := runNTPServer(t, -time.Minute)
+	defer shutdownBehind()
+	ahead, shutdownAhead := runNTPServer(t, time.Minute)
+	defer shutdownAhead()
+
+	shifted := NewShifted()
+	g := NewSkewGuard(log, SkewConfig{
+		// The median server is in sync, the others can't skew the clock on their own
+		NTPServers: []string{behind, inSync, ahead},
+		MaxSkew:    time.Second * 10,
+	}, shifted, nil)
+	require.NotNil(t, g)
+
+	require.False(t, g.Check())
+	status := g.Status()
+	require.False(t, status.Skewed)
+	require.Equal(t, 3, status.NTPServers)
+	require.InDelta(t, 0, float64(status.NTPOffset), float64(time.Second))
+	require.Equal(t, status.CheckedAt, status.SyncedAt)
+	syncedAt := status.SyncedAt
+
+	// Moving the clock forward skews it
+	shifted.Advance(time.Minute * 5)
+	require.True(t, g.Check())
+	status = g.Status()
+	require.True(t, status.Skewed)
+	require.NotEmpty(t, status.Reason)
+	require.InDelta(t, float64(-time.Minute*5), float64(status.NTPOffset), float64(time.Second))
+	require.Equal(t, syncedAt, status.SyncedAt)
+	require.True(t, g.Skewed())
+
+	shifted.Reset()
+	require.False(t, g.Check())
+	require.False(t, g.Skewed())
+	require.Empty(t, g.Status().Reason)
+}
+
+func TestSkewGuardNoNTPResponse(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	addr, shutdown := runNTPServer(t, time.Hour)
+	defer shutdown()
+
+	g := NewSkewGuard(log, SkewConfig{
+		NTPServers: []string{addr},
+		MaxSkew:    time.Second * 10,
+	}, Real{}, nil)
+	require.True(t, g.Check())
+
+	// The last offset is kept while no server responds
+	g.cfg.NTPServers = []string{"127.0.0.1:1"}
+	g.Check()
+	require.True(t, g.Skewed())
+	require.Equal(t, 1, g.Status().NTPServers)
+}
+
+func TestSkewGuardChain(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := NewFake(now)
+
+	btcTip := now.Add(-time.Minute * 10)
+	ethTip := now.Add(-time.Second * 15)
+	var ethErr error
+
+	g := NewSkewGuard(log, SkewConfig{
+		MaxChainSkew: time.Hour * 3,
+	}, clk, []ChainSource{
+		{
+			Name: "BTC",
+			Tip: func() (time.Time, error) {
+				return btcTip, nil
+			},
+		},
+		{
+			Name: "ETH",
+			Tip: func() (time.Time, error) {
+				return ethTip, ethErr
+			},
+		},
+	})
+	require.NotNil(t, g)
+
+	require.False(t, g.Check())
+	require.Equal(t, ethTip, g.Status().ChainTip)
+	require.Equal(t, time.Second*-15, g.Status().ChainOffset)
+
+	// A stalled node doesn't skew the clock while another chain is current
+	btcTip = now.Add(-time.Hour * 24)
+	require.False(t, g.Check())
+
+	// The clock is a day ahead of every chain
+	ethErr = errors.New("geth is down")
+	require.True(t, g.Check())
+	require.Equal(t, -time.Hour*24, g.Status().ChainOffset)
+
+	// The clock is behind the chains
+	ethErr = nil
+	clk.Set(now.Add(-time.Hour * 4))
+	require.True(t, g.Check())
+	require.Equal(t, time.Hour*4-time.Second*15, g.Status().ChainOffset)
+
+	clk.Set(now)
+	require.False(t, g.Check())
+}
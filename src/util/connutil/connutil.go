@@ -0,0 +1,280 @@
+// Package connutil reconnects to teller's nodes. A Reconnector spaces the attempts to reconnect to a node
+// with an exponential backoff, resolves the node's host again before each attempt, and alerts after
+// too many consecutive failures.
+package connutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/skycoin/teller/src/util/clock"
+)
+
+const (
+	// DefaultConnectTimeout is the default Config.ConnectTimeout
+	DefaultConnectTimeout = time.Second * 10
+	// DefaultRequestTimeout is the default Config.RequestTimeout
+	DefaultRequestTimeout = time.Minute
+	// DefaultMinBackoff is the default Config.MinBackoff
+	DefaultMinBackoff = time.Second
+	// DefaultMaxBackoff is the default Config.MaxBackoff
+	DefaultMaxBackoff = time.Minute
+	// DefaultAlertAfter is the default Config.AlertAfter
+	DefaultAlertAfter = 5
+)
+
+// Config configures the reconnection to a node. The zero values are replaced by the defaults.
+type Config struct {
+	// ConnectTimeout bounds resolving the node's host and connecting to it
+	ConnectTimeout time.Duration
+	// RequestTimeout bounds a request to the node, after which its connection is dropped
+	RequestTimeout time.Duration
+	// MinBackoff is the wait after the first failure, doubled after each consecutive failure
+	MinBackoff time.Duration
+	// MaxBackoff bounds the wait between attempts
+	MaxBackoff time.Duration
+	// AlertAfter is the number of consecutive failures after which an alert is logged
+	AlertAfter int
+}
+
+// WithDefaults returns the config with its zero values replaced by the defaults
+func (c Config) WithDefaults() Config {
+	if c.ConnectTimeout == 0 {
+		c.ConnectTimeout = DefaultConnectTimeout
+	}
+	if c.RequestTimeout == 0 {
+		c.RequestTimeout = DefaultRequestTimeout
+	}
+	if c.MinBackoff == 0 {
+		c.MinBackoff = DefaultMinBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = c.MinBackoff
+	}
+	if c.AlertAfter == 0 {
+		c.AlertAfter = DefaultAlertAfter
+	}
+	return c
+}
+
+// Backoff returns the wait after the nth consecutive failure
+func (c Config) Backoff(failures int) time.Duration {
+	wait := c.MinBackoff
+	for i := 1; i < failures && wait < c.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	return wait
+}
+
+// TimeoutErr is returned if a request or connection attempt didn't finish in time. It is a net.Error.
+type TimeoutErr struct {
+	Op    string
+	After time.Duration
+}
+
+func (e TimeoutErr) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Op, e.After)
+}
+
+// Timeout returns true, for net.Error
+func (e TimeoutErr) Timeout() bool {
+	return true
+}
+
+// Temporary returns true, for net.Error
+func (e TimeoutErr) Temporary() bool {
+	return true
+}
+
+// BackoffErr is returned by Reconnector.Ready until the backoff after the last failure elapsed
+type BackoffErr struct {
+	Until    time.Time
+	Failures int
+	Err      error
+}
+
+func (e BackoffErr) Error() string {
+	return fmt.Sprintf("Reconnecting at %s after %d consecutive failures, last error: %v", e.Until.UTC().Format(time.RFC3339), e.Failures, e.Err)
+}
+
+// WithTimeout calls f and returns its result, or a TimeoutErr if it doesn't return within timeout.
+// f can't be interrupted, so it must be unblocked by the caller after a timeout, e.g. by closing its connection.
+func WithTimeout(op string, timeout time.Duration, f func() (interface{}, error)) (interface{}, error) {
+	type result struct {
+		v   interface{}
+		err error
+	}
+
+	resC := make(chan result, 1)
+	go func() {
+		v, err := f()
+		resC <- result{v, err}
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case res := <-resC:
+		return res.v, res.err
+	case <-t.C:
+		return nil, TimeoutErr{
+			Op:    op,
+			After: timeout,
+		}
+	}
+}
+
+// Reconnector tracks the consecutive connection failures of a node. Ready fails until the backoff after
+// the last failure elapsed, so that a node which is down isn't flooded with connection attempts.
+// A Reconnector is safe for concurrent use.
+type Reconnector struct {
+	log   logrus.FieldLogger
+	cfg   Config
+	alert string
+	clock clock.Clock
+
+	mu        sync.Mutex
+	failures  int
+	until     time.Time
+	err       error
+	addrs     []string
+	downSince time.Time
+}
+
+// NewReconnector creates a Reconnector of a node. alert is the alert field of the alert logged after cfg.AlertAfter
+// consecutive failures, e.g. "btcd_unreachable".
+func NewReconnector(log logrus.FieldLogger, cfg Config, alert string, clk clock.Clock) *Reconnector {
+	return &Reconnector{
+		log:   log,
+		cfg:   cfg.WithDefaults(),
+		alert: alert,
+		clock: clk,
+	}
+}
+
+// Config returns the config, with the defaults
+func (r *Reconnector) Config() Config {
+	return r.cfg
+}
+
+// Failures returns the number of consecutive failures
+func (r *Reconnector) Failures() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failures
+}
+
+// DownSince returns when the first of the consecutive failures happened, zero if the last request succeeded
+func (r *Reconnector) DownSince() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.downSince
+}
+
+// Ready returns a BackoffErr if the backoff after the last failure hasn't elapsed
+func (r *Reconnector) Ready() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures != 0 && r.clock.Now().Before(r.until) {
+		return BackoffErr{
+			Until:    r.until,
+			Failures: r.failures,
+			Err:      r.err,
+		}
+	}
+
+	return nil
+}
+
+// Failed records a failure to connect to the node or of its connection, and backs off
+func (r *Reconnector) Failed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures++
+	r.err = err
+
+	now := r.clock.Now()
+	if r.failures == 1 {
+		r.downSince = now
+	}
+
+	wait := r.cfg.Backoff(r.failures)
+	r.until = now.Add(wait)
+
+	log := r.log.WithError(err).WithFields(logrus.Fields{
+		"failures": r.failures,
+		"backoff":  wait,
+	})
+
+	if r.failures == r.cfg.AlertAfter {
+		log.WithField("alert", r.alert).Error("ALERT: node connection failed too many consecutive times")
+		return
+	}
+
+	log.Warn("Node connection failed, reconnecting after the backoff")
+}
+
+// Succeeded records a successful request of the node, which resets the consecutive failures
+func (r *Reconnector) Succeeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failures == 0 {
+		return
+	}
+
+	r.log.WithField("failures", r.failures).Info("Node connection recovered")
+
+	r.failures = 0
+	r.err = nil
+	r.until = time.Time{}
+	r.downSince = time.Time{}
+}
+
+// Resolve looks up the host of addr, a host:port, within the connect timeout, and logs the addresses if they changed.
+// The host is resolved again before each connection, so that teller follows a node which moved to another address.
+func (r *Reconnector) Resolve(addr string) ([]string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.ConnectTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(addrs)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.addrs != nil && strings.Join(r.addrs, ",") != strings.Join(addrs, ",") {
+		r.log.WithFields(logrus.Fields{
+			"host":     host,
+			"previous": r.addrs,
+			"addrs":    addrs,
+		}).Info("Node host resolves to new addresses")
+	}
+	r.addrs = addrs
+
+	return addrs, nil
+}
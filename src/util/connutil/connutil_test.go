@@ -0,0 +1,135 @@
+package connutil
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/skycoin/teller/src/util/clock"
+	"github.com/skycoin/teller/src/util/testutil"
+)
+
+func TestConfigBackoff(t *testing.T) {
+	cfg := Config{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Second * 10,
+	}.WithDefaults()
+
+	require.Equal(t, DefaultConnectTimeout, cfg.ConnectTimeout)
+	require.Equal(t, DefaultRequestTimeout, cfg.RequestTimeout)
+	require.Equal(t, DefaultAlertAfter, cfg.AlertAfter)
+
+	for failures, wait := range map[int]time.Duration{
+		1:   time.Second,
+		2:   time.Second * 2,
+		3:   time.Second * 4,
+		4:   time.Second * 8,
+		5:   time.Second * 10,
+		100: time.Second * 10,
+	} {
+		require.Equal(t, wait, cfg.Backoff(failures), "failures=%d", failures)
+	}
+}
+
+func TestReconnector(t *testing.T) {
+	log, hook := testutil.NewLogger(t)
+	clk := clock.NewFake(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	r := NewReconnector(log, Config{
+		MinBackoff: time.Second,
+		MaxBackoff: time.Second * 4,
+		AlertAfter: 3,
+	}, "node_unreachable", clk)
+
+	require.NoError(t, r.Ready())
+	require.True(t, r.DownSince().IsZero())
+
+	errDown := errors.New("connection refused")
+
+	downAt := clk.Now()
+	r.Failed(errDown)
+	require.Equal(t, 1, r.Failures())
+	err := r.Ready()
+	require.Error(t, err)
+	require.Equal(t, BackoffErr{
+		Until:    clk.Now().Add(time.Second),
+		Failures: 1,
+		Err:      errDown,
+	}, err)
+
+	clk.Advance(time.Second)
+	require.NoError(t, r.Ready())
+
+	r.Failed(errDown)
+	clk.Advance(time.Second)
+	require.Error(t, r.Ready())
+	clk.Advance(time.Second)
+	require.NoError(t, r.Ready())
+
+	// The alert is logged once the failures reach AlertAfter
+	for _, e := range hook.AllEntries() {
+		require.NotContains(t, e.Data, "alert")
+	}
+	r.Failed(errDown)
+	require.Equal(t, "node_unreachable", hook.LastEntry().Data["alert"])
+
+	r.Failed(errDown)
+	require.Equal(t, 4, r.Failures())
+	require.NotContains(t, hook.LastEntry().Data, "alert")
+
+	// The node is down since the first of the consecutive failures
+	require.Equal(t, downAt, r.DownSince())
+
+	r.Succeeded()
+	require.Equal(t, 0, r.Failures())
+	require.True(t, r.DownSince().IsZero())
+	require.NoError(t, r.Ready())
+	require.Equal(t, "Node connection recovered", hook.LastEntry().Message)
+}
+
+func TestReconnectorResolve(t *testing.T) {
+	log, _ := testutil.NewLogger(t)
+	r := NewReconnector(log, Config{}, "node_unreachable", clock.Real{})
+
+	addrs, err := r.Resolve("127.0.0.1:6430")
+	require.NoError(t, err)
+	require.Equal(t, []string{"127.0.0.1"}, addrs)
+
+	addrs, err = r.Resolve("localhost:6430")
+	require.NoError(t, err)
+	require.NotEmpty(t, addrs)
+
+	_, err = r.Resolve("teller-test.invalid:6430")
+	require.Error(t, err)
+}
+
+func TestWithTimeout(t *testing.T) {
+	v, err := WithTimeout("Request", time.Second, func() (interface{}, error) {
+		return 1, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, v)
+
+	errFailed := errors.New("failed")
+	_, err = WithTimeout("Request", time.Second, func() (interface{}, error) {
+		return nil, errFailed
+	})
+	require.Equal(t, errFailed, err)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	v, err = WithTimeout("Request", time.Millisecond*10, func() (interface{}, error) {
+		<-done
+		return 1, nil
+	})
+	require.EqualError(t, err, "Request timed out after 10ms")
+	require.Nil(t, v)
+
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+}
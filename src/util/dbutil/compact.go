@@ -0,0 +1,317 @@
+package dbutil
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// compactTxMaxSize is the bytes of keys and values copied in each write transaction of CompactFile
+const compactTxMaxSize = 64 << 20
+
+// Stats are the size and contents of a bolt.DB, for metrics
+type Stats struct {
+	// Size of the db file, in bytes
+	Size int64 `json:"size"`
+	// Pages free for reuse, which an insert fills before the file grows.
+	// The file never shrinks, so free pages are only reclaimed by a compaction.
+	FreePages int `json:"free_pages"`
+	// Pages freed by a transaction which are still used by an open read transaction
+	PendingPages int `json:"pending_pages"`
+	// Bytes of the free pages
+	FreeBytes int `json:"free_bytes"`
+	// Bytes used by the freelist itself
+	FreelistBytes int `json:"freelist_bytes"`
+	// Read transactions started since the db was opened
+	ReadTxs int `json:"read_txs"`
+	// Read transactions open now
+	OpenReadTxs int `json:"open_read_txs"`
+	// Number of keys of each top level bucket, including the keys of its nested buckets
+	BucketKeys map[string]int `json:"bucket_keys"`
+}
+
+// GetStats returns the stats of a db. The keys of every bucket are counted, so it reads the whole db.
+func GetStats(db *bolt.DB) (Stats, error) {
+	fi, err := os.Stat(db.Path())
+	if err != nil {
+		return Stats{}, err
+	}
+
+	bs := db.Stats()
+	s := Stats{
+		Size:          fi.Size(),
+		FreePages:     bs.FreePageN,
+		PendingPages:  bs.PendingPageN,
+		FreeBytes:     bs.FreeAlloc,
+		FreelistBytes: bs.FreelistInuse,
+		ReadTxs:       bs.TxN,
+		OpenReadTxs:   bs.OpenTxN,
+	}
+
+	s.BucketKeys, err = bucketKeys(db)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return s, nil
+}
+
+func bucketKeys(db *bolt.DB) (map[string]int, error) {
+	keys := make(map[string]int)
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			keys[string(name)] = b.Stats().KeyN
+			return nil
+		})
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Compact copies every bucket, key and bucket sequence of src into the empty db dst.
+// The copy is made from a single read transaction of src, and committed to dst
+// every txMaxSize bytes of keys and values, or in one transaction if txMaxSize is 0.
+// The pages of dst are filled up, so it has no free pages.
+func Compact(dst, src *bolt.DB, txMaxSize int64) error {
+	tx, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// A committed tx is already closed, and tx is nil if starting the next one failed
+		if tx != nil {
+			tx.Rollback() // nolint: errcheck
+		}
+	}()
+
+	var size int64
+	if err := src.View(func(srcTx *bolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			return walkBucket(b, nil, name, nil, b.Sequence(), func(path [][]byte, k, v []byte, seq uint64) error {
+				n := int64(len(k) + len(v))
+				if txMaxSize != 0 && size+n > txMaxSize {
+					if err := tx.Commit(); err != nil {
+						return err
+					}
+
+					var err error
+					tx, err = dst.Begin(true)
+					if err != nil {
+						return err
+					}
+					size = 0
+				}
+				size += n
+
+				return copyKey(tx, path, k, v, seq)
+			})
+		})
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// walkFunc is called for every bucket and key under a top level bucket, path is the names
+// of the buckets containing it. v is nil for a bucket, whose sequence is seq.
+type walkFunc func(path [][]byte, k, v []byte, seq uint64) error
+
+func walkBucket(b *bolt.Bucket, path [][]byte, k, v []byte, seq uint64, fn walkFunc) error {
+	if err := fn(path, k, v, seq); err != nil {
+		return err
+	}
+
+	if v != nil {
+		return nil
+	}
+
+	// Don't share the backing array of the caller's path
+	path = append(append([][]byte{}, path...), k)
+
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested := b.Bucket(k)
+			return walkBucket(nested, path, k, nil, nested.Sequence(), fn)
+		}
+		return walkBucket(b, path, k, v, 0, fn)
+	})
+}
+
+func copyKey(tx *bolt.Tx, path [][]byte, k, v []byte, seq uint64) error {
+	if len(path) == 0 {
+		b, err := tx.CreateBucket(k)
+		if err != nil {
+			return NewCreateBucketFailedErr(k, err)
+		}
+		return b.SetSequence(seq)
+	}
+
+	b := tx.Bucket(path[0])
+	for _, name := range path[1:] {
+		b = b.Bucket(name)
+	}
+
+	// The keys are copied in order, so the pages can be filled up
+	b.FillPercent = 1
+
+	if v == nil {
+		nested, err := b.CreateBucket(k)
+		if err != nil {
+			return NewCreateBucketFailedErr(k, err)
+		}
+		return nested.SetSequence(seq)
+	}
+
+	return b.Put(k, v)
+}
+
+// CompactResult reports a CompactFile
+type CompactResult struct {
+	SizeBefore int64
+	SizeAfter  int64
+	Duration   time.Duration
+}
+
+// CompactFile compacts the db file at path, which must not be open. The db is copied into
+// a fresh file next to it with Compact, and the copy is checked to have the same number of keys
+// in every bucket. The copy then replaces the db file with an atomic rename,
+// so the db file is either the original or the complete copy if this fails or is interrupted.
+func CompactFile(path string) (CompactResult, error) {
+	start := time.Now()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return CompactResult{}, err
+	}
+
+	src, err := bolt.Open(path, 0400, &bolt.Options{
+		ReadOnly: true,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		return CompactResult{}, err
+	}
+	defer src.Close()
+
+	// Left over by an interrupted compaction
+	tmpPath := path + ".compact"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return CompactResult{}, err
+	}
+
+	if err := compactInto(tmpPath, fi.Mode(), src); err != nil {
+		os.Remove(tmpPath) // nolint: errcheck
+		return CompactResult{}, err
+	}
+
+	if err := src.Close(); err != nil {
+		os.Remove(tmpPath) // nolint: errcheck
+		return CompactResult{}, err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath) // nolint: errcheck
+		return CompactResult{}, err
+	}
+
+	// Persist the rename
+	if err := syncDir(filepath.Dir(path)); err != nil {
+		return CompactResult{}, err
+	}
+
+	after, err := os.Stat(path)
+	if err != nil {
+		return CompactResult{}, err
+	}
+
+	return CompactResult{
+		SizeBefore: fi.Size(),
+		SizeAfter:  after.Size(),
+		Duration:   time.Since(start),
+	}, nil
+}
+
+// compactInto compacts src into a new db file at path, and checks the copy
+func compactInto(path string, mode os.FileMode, src *bolt.DB) error {
+	dst, err := bolt.Open(path, mode, nil)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	if err := Compact(dst, src, compactTxMaxSize); err != nil {
+		return err
+	}
+
+	srcKeys, err := bucketKeys(src)
+	if err != nil {
+		return err
+	}
+
+	dstKeys, err := bucketKeys(dst)
+	if err != nil {
+		return err
+	}
+
+	if len(srcKeys) != len(dstKeys) {
+		return fmt.Errorf("Compacted db has %d buckets, expected %d", len(dstKeys), len(srcKeys))
+	}
+
+	for name, n := range srcKeys {
+		if dstKeys[name] != n {
+			return fmt.Errorf("Compacted db bucket \"%s\" has %d keys, expected %d", name, dstKeys[name], n)
+		}
+	}
+
+	return dst.Close()
+}
+
+// Compactor reports the stats of a running teller's db and takes requests to compact it.
+// The db must be closed to be compacted, so a request closes Requested,
+// and teller compacts the db with CompactFile after shutting its services down.
+type Compactor struct {
+	db        *bolt.DB
+	once      sync.Once
+	requested chan struct{}
+}
+
+// NewCompactor creates a Compactor of db
+func NewCompactor(db *bolt.DB) *Compactor {
+	return &Compactor{
+		db:        db,
+		requested: make(chan struct{}),
+	}
+}
+
+// DBStats returns the stats of the db
+func (c *Compactor) DBStats() (Stats, error) {
+	return GetStats(c.db)
+}
+
+// RequestCompact requests a compaction. Requesting it again does nothing.
+func (c *Compactor) RequestCompact() {
+	c.once.Do(func() {
+		close(c.requested)
+	})
+}
+
+// Requested is closed once a compaction is requested
+func (c *Compactor) Requested() <-chan struct{} {
+	return c.requested
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
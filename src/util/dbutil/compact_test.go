@@ -0,0 +1,146 @@
+package dbutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/require"
+)
+
+func openTestDB(t *testing.T, path string) *bolt.DB {
+	db, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	return db
+}
+
+// fillTestDB writes keys, nested buckets and sequences, then deletes most of the keys
+// so that the db has free pages
+func fillTestDB(t *testing.T, db *bolt.DB) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		a, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		if err := a.SetSequence(42); err != nil {
+			return err
+		}
+
+		nested, err := a.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		if err := nested.SetSequence(7); err != nil {
+			return err
+		}
+		if err := nested.Put([]byte("k"), []byte("v")); err != nil {
+			return err
+		}
+
+		for i := 0; i < 2000; i++ {
+			if err := a.Put([]byte(fmt.Sprintf("key-%05d", i)), make([]byte, 512)); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.CreateBucket([]byte("empty"))
+		return err
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		a := tx.Bucket([]byte("a"))
+		for i := 10; i < 2000; i++ {
+			if err := a.Delete([]byte(fmt.Sprintf("key-%05d", i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func requireTestDBContents(t *testing.T, db *bolt.DB) {
+	err := db.View(func(tx *bolt.Tx) error {
+		a := tx.Bucket([]byte("a"))
+		require.NotNil(t, a)
+		require.Equal(t, uint64(42), a.Sequence())
+
+		nested := a.Bucket([]byte("nested"))
+		require.NotNil(t, nested)
+		require.Equal(t, uint64(7), nested.Sequence())
+		require.Equal(t, []byte("v"), nested.Get([]byte("k")))
+
+		for i := 0; i < 10; i++ {
+			require.Equal(t, make([]byte, 512), a.Get([]byte(fmt.Sprintf("key-%05d", i))))
+		}
+		require.Nil(t, a.Get([]byte("key-00010")))
+
+		require.NotNil(t, tx.Bucket([]byte("empty")))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbutil")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := openTestDB(t, filepath.Join(dir, "src.db"))
+	defer src.Close()
+	fillTestDB(t, src)
+
+	// A small txMaxSize commits many transactions
+	dst := openTestDB(t, filepath.Join(dir, "dst.db"))
+	defer dst.Close()
+	require.NoError(t, Compact(dst, src, 4096))
+
+	requireTestDBContents(t, dst)
+
+	srcStats, err := GetStats(src)
+	require.NoError(t, err)
+	dstStats, err := GetStats(dst)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]int{
+		"a":     12,
+		"empty": 0,
+	}, srcStats.BucketKeys)
+	require.Equal(t, srcStats.BucketKeys, dstStats.BucketKeys)
+	// The pages freed by the last transaction are released by the next one
+	require.NotZero(t, srcStats.FreePages+srcStats.PendingPages)
+	require.True(t, dstStats.Size < srcStats.Size)
+}
+
+func TestCompactFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dbutil")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "teller.db")
+	db := openTestDB(t, path)
+	fillTestDB(t, db)
+	require.NoError(t, db.Close())
+
+	// A copy left over by an interrupted compaction is replaced
+	require.NoError(t, ioutil.WriteFile(path+".compact", []byte("garbage"), 0600))
+
+	res, err := CompactFile(path)
+	require.NoError(t, err)
+	require.True(t, res.SizeAfter < res.SizeBefore)
+
+	_, err = os.Stat(path + ".compact")
+	require.True(t, os.IsNotExist(err))
+
+	db = openTestDB(t, path)
+	defer db.Close()
+	requireTestDBContents(t, db)
+
+	// The db must be closed
+	_, err = CompactFile(path)
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+// Package errutil classifies errors by kind, so that errors from any layer
+// can be mapped to a response in one place
+package errutil
+
+import "errors"
+
+// Kind is the class of an error
+type Kind int
+
+const (
+	// Internal is an unexpected failure. Errors without a kind are Internal.
+	Internal Kind = iota
+	// NotFound is returned when the requested object doesn't exist
+	NotFound
+	// Conflict is returned when the request conflicts with the current state
+	Conflict
+	// Unavailable is returned when the request can't be served at the moment
+	Unavailable
+	// RateLimited is returned when too many requests are being made
+	RateLimited
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Internal:
+		return "internal"
+	case NotFound:
+		return "not_found"
+	case Conflict:
+		return "conflict"
+	case Unavailable:
+		return "unavailable"
+	case RateLimited:
+		return "rate_limited"
+	default:
+		return "unknown"
+	}
+}
+
+// Error is an error of a Kind
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// New creates an error of kind with the message msg.
+// Errors created by New can be compared with ==, so they can be used as sentinel errors.
+func New(kind Kind, msg string) error {
+	return Error{
+		Kind: kind,
+		Err:  errors.New(msg),
+	}
+}
+
+// Wrap classifies err as kind. It returns nil if err is nil.
+func Wrap(kind Kind, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return Error{
+		Kind: kind,
+		Err:  err,
+	}
+}
+
+// KindOf returns the Kind of err, or Internal if err has no kind
+func KindOf(err error) Kind {
+	var e Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	return Internal
+}
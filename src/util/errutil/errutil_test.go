@@ -0,0 +1,38 @@
+package errutil
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKindOf(t *testing.T) {
+	errNotFound := New(NotFound, "not found")
+
+	require.Equal(t, NotFound, KindOf(errNotFound))
+	require.Equal(t, "not found", errNotFound.Error())
+	require.Equal(t, Conflict, KindOf(Wrap(Conflict, errors.New("conflict"))))
+	require.Equal(t, Internal, KindOf(errors.New("plain")))
+	require.Equal(t, Internal, KindOf(nil))
+
+	// The kind of a wrapped error is found
+	wrapped := fmt.Errorf("bind failed: %w", errNotFound)
+	require.Equal(t, NotFound, KindOf(wrapped))
+	require.True(t, errors.Is(wrapped, errNotFound))
+
+	// Sentinel errors are compared by identity, not by message
+	require.False(t, errNotFound == New(NotFound, "not found"))
+
+	require.Nil(t, Wrap(Unavailable, nil))
+}
+
+func TestKindString(t *testing.T) {
+	require.Equal(t, "internal", Internal.String())
+	require.Equal(t, "not_found", NotFound.String())
+	require.Equal(t, "conflict", Conflict.String())
+	require.Equal(t, "unavailable", Unavailable.String())
+	require.Equal(t, "rate_limited", RateLimited.String())
+	require.Equal(t, "unknown", Kind(100).String())
+}
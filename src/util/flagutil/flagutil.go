@@ -0,0 +1,156 @@
+// Package flagutil gates new endpoints and behaviors behind feature flags, which operators can flip at runtime
+package flagutil
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrUnknownFlag is returned by Registry for a flag which it doesn't have
+var ErrUnknownFlag = errors.New("Unknown feature flag")
+
+// Status is the state of a feature flag
+type Status struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Configured is the state set by the config, or the flag's default if the config doesn't set it
+	Configured bool `json:"configured"`
+	// ChangedAt is when an operator last enabled or disabled the flag, omitted if it is at its configured state
+	ChangedAt int64 `json:"changed_at,omitempty"`
+}
+
+type flag struct {
+	configured bool
+	enabled    bool
+	changedAt  time.Time
+}
+
+// Registry holds the state of the feature flags. The flags are only changed in memory,
+// so they are back at their configured state when teller restarts.
+type Registry struct {
+	sync.RWMutex
+	flags map[string]*flag
+}
+
+// NewRegistry creates a Registry of the flags of defaults, which maps a flag's name to its default state.
+// configured overrides the defaults, its flags which are not in defaults are ignored.
+func NewRegistry(defaults, configured map[string]bool) *Registry {
+	r := &Registry{
+		flags: make(map[string]*flag, len(defaults)),
+	}
+
+	for name, enabled := range defaults {
+		if v, ok := configured[name]; ok {
+			enabled = v
+		}
+
+		r.flags[name] = &flag{
+			configured: enabled,
+			enabled:    enabled,
+		}
+	}
+
+	return r
+}
+
+// Enabled returns true if the flag is enabled. Unknown flags are disabled.
+func (r *Registry) Enabled(name string) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	f, ok := r.flags[name]
+	return ok && f.enabled
+}
+
+// Flags returns the state of every flag by name
+func (r *Registry) Flags() map[string]bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	flags := make(map[string]bool, len(r.flags))
+	for name, f := range r.flags {
+		flags[name] = f.enabled
+	}
+	return flags
+}
+
+// Statuses returns the status of every flag, sorted by name
+func (r *Registry) Statuses() []Status {
+	r.RLock()
+	defer r.RUnlock()
+
+	names := make([]string, 0, len(r.flags))
+	for name := range r.flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := make([]Status, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, r.flags[name].status(name))
+	}
+	return statuses
+}
+
+// Enable enables a flag and returns its status
+func (r *Registry) Enable(name string) (Status, error) {
+	return r.set(name, true, time.Now())
+}
+
+// Disable disables a flag and returns its status
+func (r *Registry) Disable(name string) (Status, error) {
+	return r.set(name, false, time.Now())
+}
+
+// Reset puts a flag back to its configured state and returns its status
+func (r *Registry) Reset(name string) (Status, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	f, ok := r.flags[name]
+	if !ok {
+		return Status{}, ErrUnknownFlag
+	}
+
+	f.enabled = f.configured
+	f.changedAt = time.Time{}
+	return f.status(name), nil
+}
+
+func (r *Registry) set(name string, enabled bool, now time.Time) (Status, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	f, ok := r.flags[name]
+	if !ok {
+		return Status{}, ErrUnknownFlag
+	}
+
+	if f.enabled != enabled {
+		f.enabled = enabled
+		f.changedAt = now
+	}
+
+	// Changing a flag back by hand is the same as resetting it
+	if f.enabled == f.configured {
+		f.changedAt = time.Time{}
+	}
+
+	return f.status(name), nil
+}
+
+func (f *flag) status(name string) Status {
+	s := Status{
+		Name:       name,
+		Enabled:    f.enabled,
+		Configured: f.configured,
+	}
+
+	if !f.changedAt.IsZero() {
+		s.ChangedAt = f.changedAt.Unix()
+	}
+
+	return s
+}
@@ -0,0 +1,69 @@
+package flagutil
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(map[string]bool{
+		"new_coin": false,
+		"push":     true,
+	}, map[string]bool{
+		"push":    false,
+		"unknown": true,
+	})
+
+	// The config overrides the defaults, and unknown flags are disabled
+	require.False(t, r.Enabled("new_coin"))
+	require.False(t, r.Enabled("push"))
+	require.False(t, r.Enabled("unknown"))
+	require.Equal(t, map[string]bool{
+		"new_coin": false,
+		"push":     false,
+	}, r.Flags())
+
+	s, err := r.Enable("new_coin")
+	require.NoError(t, err)
+	require.True(t, s.Enabled)
+	require.False(t, s.Configured)
+	require.NotZero(t, s.ChangedAt)
+	require.True(t, r.Enabled("new_coin"))
+
+	// Enabling an enabled flag doesn't change when it was changed
+	s2, err := r.Enable("new_coin")
+	require.NoError(t, err)
+	require.Equal(t, s, s2)
+
+	statuses := r.Statuses()
+	require.Equal(t, []Status{
+		s,
+		{
+			Name: "push",
+		},
+	}, statuses)
+
+	// Disabling it again puts it back at its configured state
+	s, err = r.Disable("new_coin")
+	require.NoError(t, err)
+	require.Equal(t, Status{
+		Name: "new_coin",
+	}, s)
+
+	_, err = r.Enable("push")
+	require.NoError(t, err)
+	s, err = r.Reset("push")
+	require.NoError(t, err)
+	require.Equal(t, Status{
+		Name: "push",
+	}, s)
+	require.False(t, r.Enabled("push"))
+
+	_, err = r.Enable("unknown")
+	require.Equal(t, ErrUnknownFlag, err)
+	_, err = r.Disable("unknown")
+	require.Equal(t, ErrUnknownFlag, err)
+	_, err = r.Reset("unknown")
+	require.Equal(t, ErrUnknownFlag, err)
+}
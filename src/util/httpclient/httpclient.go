@@ -0,0 +1,291 @@
+// Package httpclient provides the http.Client of teller's requests to third party services,
+// on a shared transport with connection pooling, retries, an optional proxy and TLS public key pins
+package httpclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	dialTimeout         = time.Second * 10
+	dialKeepAlive       = time.Second * 30
+	tlsHandshakeTimeout = time.Second * 10
+	idleConnTimeout     = time.Second * 90
+	maxIdleConns        = 100
+
+	// maxRetryWait bounds the wait asked by a Retry-After header
+	maxRetryWait = time.Second * 30
+	// drainLimit is the most of a retried response's body which is read, so that its connection can be reused
+	drainLimit = 4096
+)
+
+// Config configures the shared transport
+type Config struct {
+	// Proxy is the URL of a proxy the requests are sent through.
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used if empty.
+	Proxy string
+	// Retries of an idempotent request which failed to connect or got a 429, 502, 503 or 504 response
+	Retries int
+	// RetryBackoff is the wait before the first retry, doubled for each retry
+	RetryBackoff time.Duration
+	// MaxIdleConnsPerHost is the number of idle connections kept to each host, http.DefaultMaxIdleConnsPerHost if 0
+	MaxIdleConnsPerHost int
+	// Pins maps a hostname to the base64 SHA-256 digests of the public keys which its certificate chain must contain.
+	// The digests are of the certificates' DER encoded SubjectPublicKeyInfo, as in HPKP.
+	Pins map[string][]string
+}
+
+var (
+	mu     sync.Mutex
+	shared = mustNewTransport(Config{})
+)
+
+// Configure replaces the transport of the clients created by New,
+// so it must be called at startup, before the clients are created
+func Configure(cfg Config) error {
+	t, err := NewTransport(cfg)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	shared = t
+
+	return nil
+}
+
+// New returns a client on the shared transport.
+// The timeout bounds a request including its retries, 0 leaves the requests to be bounded by their contexts.
+func New(timeout time.Duration) *http.Client {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return &http.Client{
+		Transport: shared,
+		Timeout:   timeout,
+	}
+}
+
+// NewTransport creates a transport configured by cfg
+func NewTransport(cfg Config) (http.RoundTripper, error) {
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		u, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy: %v", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, errors.New("Invalid proxy: the scheme must be http, https or socks5")
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	if cfg.Retries < 0 {
+		return nil, errors.New("Retries can't be negative")
+	}
+
+	if cfg.RetryBackoff < 0 {
+		return nil, errors.New("RetryBackoff can't be negative")
+	}
+
+	pins, err := decodePins(cfg.Pins)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(pins) != 0 {
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(pins, cs)
+		}
+	}
+
+	t := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   dialTimeout,
+			KeepAlive: dialKeepAlive,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if cfg.Retries == 0 {
+		return t, nil
+	}
+
+	return &retryTransport{
+		rt:      t,
+		retries: cfg.Retries,
+		backoff: cfg.RetryBackoff,
+	}, nil
+}
+
+func mustNewTransport(cfg Config) http.RoundTripper {
+	t, err := NewTransport(cfg)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// decodePins decodes the pinned public key digests of each hostname
+func decodePins(pins map[string][]string) (map[string][][]byte, error) {
+	decoded := make(map[string][][]byte, len(pins))
+	for host, keys := range pins {
+		if host == "" {
+			return nil, errors.New("Pinned host is empty")
+		}
+		// The pins are matched by the TLS server name, which isn't sent to an IP address
+		if net.ParseIP(host) != nil {
+			return nil, fmt.Errorf("Pinned host %s must be a hostname, not an IP address", host)
+		}
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("No pinned key for %s", host)
+		}
+
+		for _, k := range keys {
+			b, err := base64.StdEncoding.DecodeString(k)
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("Pinned key %q of %s is not a base64 SHA-256 digest", k, host)
+			}
+			decoded[host] = append(decoded[host], b)
+		}
+	}
+
+	return decoded, nil
+}
+
+// verifyPins fails a TLS connection to a pinned host unless its verified certificate chain contains a pinned public key
+func verifyPins(pins map[string][][]byte, cs tls.ConnectionState) error {
+	keys, ok := pins[cs.ServerName]
+	if !ok {
+		return nil
+	}
+
+	for _, chain := range cs.VerifiedChains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, k := range keys {
+				if string(sum[:]) == string(k) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("The certificate chain of %s has no pinned public key", cs.ServerName)
+}
+
+// retryTransport retries the idempotent requests which fail to connect or get a response that may succeed later
+type retryTransport struct {
+	rt      http.RoundTripper
+	retries int
+	backoff time.Duration
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return t.rt.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	wait := t.backoff
+
+	for i := 0; ; i++ {
+		rsp, err := t.rt.RoundTrip(req)
+		if i == t.retries || !shouldRetry(rsp, err) || ctx.Err() != nil {
+			return rsp, err
+		}
+
+		d := wait
+		if rsp != nil {
+			if ra := retryAfter(rsp); ra > d {
+				d = ra
+			}
+			io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, drainLimit)) // nolint: errcheck
+			rsp.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d):
+		}
+		wait *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// retryable returns true if a request can be sent again, which are the idempotent requests whose body can be replayed
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+
+	_, ok := req.Header["Idempotency-Key"]
+	return ok
+}
+
+func shouldRetry(rsp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// retryAfter returns the wait asked by a response's Retry-After header in seconds, at most maxRetryWait
+func retryAfter(rsp *http.Response) time.Duration {
+	s, err := strconv.Atoi(rsp.Header.Get("Retry-After"))
+	if err != nil || s <= 0 {
+		return 0
+	}
+
+	d := time.Duration(s) * time.Second
+	if d > maxRetryWait {
+		return maxRetryWait
+	}
+	return d
+}
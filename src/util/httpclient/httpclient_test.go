@@ -0,0 +1,238 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// flakyServer responds with status until it was called fails times, then with 200 and the request body
+func flakyServer(fails int32, status int) (*httptest.Server, *int32) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= fails {
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body) // nolint: errcheck
+		w.Write(body)                     // nolint: errcheck
+	}))
+	return srv, &calls
+}
+
+func newTestClient(t *testing.T, cfg Config) *http.Client {
+	rt, err := NewTransport(cfg)
+	require.NoError(t, err)
+	return &http.Client{
+		Transport: rt,
+		Timeout:   time.Second * 5,
+	}
+}
+
+func TestNewTransportInvalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{Proxy: "ftp://proxy.example.com"},
+		{Proxy: "://"},
+		{Retries: -1},
+		{RetryBackoff: -time.Second},
+		{Pins: map[string][]string{"": {base64.StdEncoding.EncodeToString(make([]byte, 32))}}},
+		{Pins: map[string][]string{"api.example.com": nil}},
+		{Pins: map[string][]string{"127.0.0.1": {base64.StdEncoding.EncodeToString(make([]byte, 32))}}},
+		{Pins: map[string][]string{"api.example.com": {"not base64"}}},
+		{Pins: map[string][]string{"api.example.com": {base64.StdEncoding.EncodeToString(make([]byte, 20))}}},
+	} {
+		_, err := NewTransport(cfg)
+		require.Error(t, err, "%+v", cfg)
+		require.Error(t, Configure(cfg))
+	}
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	srv, calls := flakyServer(2, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	c := newTestClient(t, Config{
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	})
+
+	rsp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.Equal(t, int32(3), atomic.LoadInt32(calls))
+
+	// The last response is returned once the retries are used up
+	atomic.StoreInt32(calls, -10)
+	rsp, err = c.Get(srv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+	require.Equal(t, int32(-7), atomic.LoadInt32(calls))
+
+	// A PUT is sent again with its body
+	atomic.StoreInt32(calls, 0)
+	req, err := http.NewRequest(http.MethodPut, srv.URL, strings.NewReader("metrics"))
+	require.NoError(t, err)
+	rsp, err = c.Do(req)
+	require.NoError(t, err)
+	body, err := ioutil.ReadAll(rsp.Body)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, "metrics", string(body))
+	require.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestRetryNotIdempotent(t *testing.T) {
+	srv, calls := flakyServer(1, http.StatusBadGateway)
+	defer srv.Close()
+
+	c := newTestClient(t, Config{
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	})
+
+	// A POST isn't retried
+	rsp, err := c.Post(srv.URL, "text/plain", strings.NewReader("event"))
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, rsp.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(calls))
+
+	// Unless it has an idempotency key
+	atomic.StoreInt32(calls, 0)
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("event"))
+	require.NoError(t, err)
+	req.Header.Set("Idempotency-Key", "k1")
+	rsp, err = c.Do(req)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	// Other errors aren't retried
+	srv2, calls2 := flakyServer(1, http.StatusInternalServerError)
+	defer srv2.Close()
+	rsp, err = c.Get(srv2.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, rsp.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(calls2))
+}
+
+func TestRetryAfter(t *testing.T) {
+	rsp := &http.Response{Header: http.Header{}}
+	require.Equal(t, time.Duration(0), retryAfter(rsp))
+
+	rsp.Header.Set("Retry-After", "3")
+	require.Equal(t, time.Second*3, retryAfter(rsp))
+
+	rsp.Header.Set("Retry-After", "3600")
+	require.Equal(t, maxRetryWait, retryAfter(rsp))
+
+	rsp.Header.Set("Retry-After", "Wed, 21 Oct 2015 07:28:00 GMT")
+	require.Equal(t, time.Duration(0), retryAfter(rsp))
+}
+
+func TestProxy(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		require.Equal(t, "http://api.example.com/v1", r.URL.String())
+	}))
+	defer proxy.Close()
+
+	c := newTestClient(t, Config{
+		Proxy: proxy.URL,
+	})
+
+	rsp, err := c.Get("http://api.example.com/v1")
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, int32(1), atomic.LoadInt32(&proxied))
+}
+
+func TestPins(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// The test certificate is valid for example.com, which is dialed to the server
+	addr := srv.Listener.Addr().String()
+	const host = "example.com"
+
+	sum := sha256.Sum256(srv.Certificate().RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(sum[:])
+	other := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+
+	get := func(pins map[string][]string) error {
+		rt, err := NewTransport(Config{
+			Pins: pins,
+		})
+		require.NoError(t, err)
+		tr := rt.(*http.Transport)
+		tr.TLSClientConfig.RootCAs = roots
+		tr.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}
+
+		c := &http.Client{Transport: rt}
+		rsp, err := c.Get("https://" + host + "/")
+		if err != nil {
+			return err
+		}
+		return rsp.Body.Close()
+	}
+
+	require.NoError(t, get(nil))
+	require.NoError(t, get(map[string][]string{host: {other, pin}}))
+	require.NoError(t, get(map[string][]string{"api.example.com": {other}}))
+
+	err := get(map[string][]string{host: {other}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no pinned public key")
+}
+
+func TestConfigure(t *testing.T) {
+	defer func() {
+		require.NoError(t, Configure(Config{}))
+	}()
+
+	srv, calls := flakyServer(1, http.StatusServiceUnavailable)
+	defer srv.Close()
+
+	// A client created before Configure keeps its transport
+	before := New(time.Second * 5)
+
+	require.NoError(t, Configure(Config{
+		Retries:      1,
+		RetryBackoff: time.Millisecond,
+	}))
+
+	c := New(time.Second * 5)
+	require.Equal(t, time.Second*5, c.Timeout)
+	rsp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusOK, rsp.StatusCode)
+	require.Equal(t, int32(2), atomic.LoadInt32(calls))
+
+	atomic.StoreInt32(calls, 0)
+	rsp, err = before.Get(srv.URL)
+	require.NoError(t, err)
+	rsp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, rsp.StatusCode)
+}
@@ -35,7 +35,9 @@ func WithContext(ctx context.Context, lg logrus.FieldLogger) context.Context {
 // If debug is true, the log level is logrus.DebugLevel, otherwise logrus.InfoLevel.
 // If logFilename is not the empty string, logs will also be written to that file,
 // in addition to os.Stdout.
-func NewLogger(logFilename string, debug bool) (*logrus.Logger, error) {
+// hooks are added before the log file's hook, so that hooks which modify entries, such as a RedactHook,
+// apply to both.
+func NewLogger(logFilename string, debug bool, hooks ...logrus.Hook) (*logrus.Logger, error) {
 	log := logrus.New()
 	log.Out = os.Stdout
 	log.Formatter = &prefixed.TextFormatter{
@@ -50,6 +52,10 @@ func NewLogger(logFilename string, debug bool) (*logrus.Logger, error) {
 		log.Level = logrus.DebugLevel
 	}
 
+	for _, h := range hooks {
+		log.Hooks.Add(h)
+	}
+
 	if logFilename != "" {
 		hook, err := NewFileWriteHook(logFilename)
 		if err != nil {
@@ -0,0 +1,327 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RedactAction is how a RedactHook redacts the value of a matching field
+type RedactAction string
+
+const (
+	// RedactHash replaces the value with a salted hash, so that log lines about the same value can still be matched
+	RedactHash RedactAction = "hash"
+	// RedactDrop removes the field
+	RedactDrop RedactAction = "drop"
+	// RedactTruncate keeps the start of the value
+	RedactTruncate RedactAction = "truncate"
+)
+
+// redactHashLen is the number of bytes of the HMAC kept in a hashed value
+const redactHashLen = 8
+
+// RedactRules are the fields redacted by a RedactHook.
+//
+// A rule is a field name, matched against the keys of the log entry's fields and the keys
+// inside their values, at any depth. Struct values are matched by their JSON keys, e.g. "SkyAddress"
+// of an exchange.DepositInfo. A rule with dots matches the end of a key's path, e.g. "deposit.address"
+// matches the Address of the Deposit inside a logged DepositInfo, but not other addresses.
+// Names are compared ignoring case and underscores, so "sky_address" matches "SkyAddress".
+// The query parameters of logged URLs are matched by their names.
+type RedactRules struct {
+	Hash     []string
+	Drop     []string
+	Truncate []string
+	// Key of the HMAC-SHA256 of hashed values
+	Salt []byte
+	// Number of characters kept by RedactTruncate
+	TruncateLength int
+}
+
+type redactRule struct {
+	path   []string
+	action RedactAction
+}
+
+// RedactHook is a logrus.Hook which redacts the fields of log entries.
+// It must be added before hooks which write the entry, such as a WriteHook, see NewLogger.
+type RedactHook struct {
+	rules          []redactRule
+	salt           []byte
+	truncateLength int
+}
+
+// NewRedactHook creates a RedactHook. An error is returned if a field has more than one rule.
+func NewRedactHook(r RedactRules) (*RedactHook, error) {
+	h := &RedactHook{
+		salt:           r.Salt,
+		truncateLength: r.TruncateLength,
+	}
+
+	seen := make(map[string]RedactAction)
+	add := func(names []string, action RedactAction) error {
+		for _, name := range names {
+			path := strings.Split(normalizeRedactKey(name), ".")
+			key := strings.Join(path, ".")
+			if key == "" {
+				return fmt.Errorf("Empty %s rule", action)
+			}
+			if a, ok := seen[key]; ok {
+				return fmt.Errorf("Field %q has both %s and %s rules", name, a, action)
+			}
+			seen[key] = action
+
+			h.rules = append(h.rules, redactRule{
+				path:   path,
+				action: action,
+			})
+		}
+		return nil
+	}
+
+	if err := add(r.Hash, RedactHash); err != nil {
+		return nil, err
+	}
+	if err := add(r.Drop, RedactDrop); err != nil {
+		return nil, err
+	}
+	if err := add(r.Truncate, RedactTruncate); err != nil {
+		return nil, err
+	}
+
+	if len(r.Truncate) != 0 && r.TruncateLength <= 0 {
+		return nil, errors.New("TruncateLength must be > 0")
+	}
+
+	return h, nil
+}
+
+// Levels returns logrus.AllLevels
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire redacts the fields of the logrus.Entry
+func (h *RedactHook) Fire(entry *logrus.Entry) error {
+	// The entry.Data map is shared with the logger it was created from, it must be copied before writing to
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		rv, keep := h.redactField(k, v)
+		if keep {
+			data[k] = rv
+		}
+	}
+
+	entry.Data = data
+	return nil
+}
+
+// redactField returns the redacted value of a log field, and false if it is dropped
+func (h *RedactHook) redactField(key string, v interface{}) (interface{}, bool) {
+	path := []string{normalizeRedactKey(key)}
+
+	if action, ok := h.match(path); ok {
+		return h.apply(action, v)
+	}
+
+	switch x := v.(type) {
+	case nil, error, fmt.Stringer:
+		return v, true
+	case string:
+		return h.redactURL(path, x), true
+	case []string:
+		return v, true
+	}
+
+	switch reflect.Indirect(reflect.ValueOf(v)).Kind() {
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+	default:
+		return v, true
+	}
+
+	// Walk composite values by their JSON encoding, and only replace them if something was redacted,
+	// so that unaffected values are still logged in their usual format
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v, true
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var decoded interface{}
+	if err := d.Decode(&decoded); err != nil {
+		return v, true
+	}
+
+	if redacted, changed := h.walk(path, decoded); changed {
+		return redacted, true
+	}
+
+	return v, true
+}
+
+// walk redacts a JSON decoded value, returning true if anything was redacted
+func (h *RedactHook) walk(path []string, v interface{}) (interface{}, bool) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		var changed bool
+		for k, kv := range x {
+			kpath := append(path[:len(path):len(path)], normalizeRedactKey(k))
+			if action, ok := h.match(kpath); ok {
+				rv, keep := h.apply(action, kv)
+				if keep {
+					x[k] = rv
+				} else {
+					delete(x, k)
+				}
+				changed = true
+				continue
+			}
+
+			if rv, c := h.walk(kpath, kv); c {
+				x[k] = rv
+				changed = true
+			}
+		}
+		return x, changed
+
+	case []interface{}:
+		var changed bool
+		for i, iv := range x {
+			if rv, c := h.walk(path, iv); c {
+				x[i] = rv
+				changed = true
+			}
+		}
+		return x, changed
+
+	case string:
+		rv := h.redactURL(path, x)
+		return rv, rv != x
+	}
+
+	return v, false
+}
+
+// redactURL redacts the query parameters of a URL
+func (h *RedactHook) redactURL(path []string, s string) string {
+	if !strings.Contains(s, "?") {
+		return s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil || u.RawQuery == "" {
+		return s
+	}
+
+	q := u.Query()
+	var changed bool
+	for k, vs := range q {
+		action, ok := h.match(append(path[:len(path):len(path)], normalizeRedactKey(k)))
+		if !ok {
+			continue
+		}
+
+		changed = true
+		if action == RedactDrop {
+			q.Del(k)
+			continue
+		}
+		for i, qv := range vs {
+			vs[i] = h.redactString(action, qv)
+		}
+	}
+
+	if !changed {
+		return s
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// apply redacts a value matched by a rule, returning false if it is dropped
+func (h *RedactHook) apply(action RedactAction, v interface{}) (interface{}, bool) {
+	if action == RedactDrop {
+		return nil, false
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return v, true
+	case string:
+		return h.redactString(action, x), true
+	case []string:
+		rs := make([]string, len(x))
+		for i, s := range x {
+			rs[i] = h.redactString(action, s)
+		}
+		return rs, true
+	case []interface{}:
+		rs := make([]interface{}, len(x))
+		for i, s := range x {
+			rs[i], _ = h.apply(action, s)
+		}
+		return rs, true
+	default:
+		return h.redactString(action, fmt.Sprint(v)), true
+	}
+}
+
+func (h *RedactHook) redactString(action RedactAction, s string) string {
+	if s == "" {
+		return s
+	}
+
+	switch action {
+	case RedactHash:
+		m := hmac.New(sha256.New, h.salt)
+		m.Write([]byte(s)) // nolint: errcheck
+		return hex.EncodeToString(m.Sum(nil)[:redactHashLen])
+	case RedactTruncate:
+		if len(s) <= h.truncateLength {
+			return s
+		}
+		return s[:h.truncateLength] + "..."
+	default:
+		return s
+	}
+}
+
+// match returns the action of the first rule matching the end of path
+func (h *RedactHook) match(path []string) (RedactAction, bool) {
+	for _, r := range h.rules {
+		if len(r.path) > len(path) {
+			continue
+		}
+
+		tail := path[len(path)-len(r.path):]
+		ok := true
+		for i := range r.path {
+			if r.path[i] != tail[i] {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return r.action, true
+		}
+	}
+
+	return "", false
+}
+
+func normalizeRedactKey(k string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(k), "_", "", -1))
+}
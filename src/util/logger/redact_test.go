@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+type testDeposit struct {
+	CoinType string
+	Address  string
+	Tx       string
+	Amount   int64 `json:"Value"`
+}
+
+type testDepositInfo struct {
+	SkyAddress     string
+	DepositAddress string
+	Txid           string
+	SkySent        uint64
+	Deposit        testDeposit
+}
+
+type testBindRequest struct {
+	SkyAddr  string `json:"skyaddr"`
+	CoinType string `json:"coin_type"`
+	Email    string `json:"email,omitempty"`
+}
+
+func newTestRedactHook(t *testing.T) *RedactHook {
+	h, err := NewRedactHook(RedactRules{
+		Hash:           []string{"skyaddr", "sky_address", "deposit_address", "deposit.address"},
+		Drop:           []string{"email"},
+		Truncate:       []string{"txid", "deposit.tx"},
+		Salt:           []byte("salt"),
+		TruncateLength: 4,
+	})
+	require.NoError(t, err)
+	return h
+}
+
+func TestNewRedactHook(t *testing.T) {
+	_, err := NewRedactHook(RedactRules{
+		Hash: []string{"sky_address"},
+		Drop: []string{"SkyAddress"},
+	})
+	require.Error(t, err)
+
+	_, err = NewRedactHook(RedactRules{
+		Hash: []string{""},
+	})
+	require.Error(t, err)
+
+	_, err = NewRedactHook(RedactRules{
+		Truncate: []string{"txid"},
+	})
+	require.Error(t, err)
+
+	_, err = NewRedactHook(RedactRules{})
+	require.NoError(t, err)
+}
+
+func TestRedactHookFire(t *testing.T) {
+	h := newTestRedactHook(t)
+
+	skyHash := h.redactString(RedactHash, "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW")
+	depositHash := h.redactString(RedactHash, "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS")
+	require.Len(t, skyHash, redactHashLen*2)
+	require.NotEqual(t, skyHash, depositHash)
+
+	di := testDepositInfo{
+		SkyAddress:     "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW",
+		DepositAddress: "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS",
+		Txid:           "d2e4f6a8",
+		SkySent:        1e17 + 1,
+		Deposit: testDeposit{
+			CoinType: "BTC",
+			Address:  "1FeDtFhARLxjKUPPkQqEBL78tisenc9znS",
+			Tx:       "a1b2c3d4e5",
+			Amount:   1e8,
+		},
+	}
+
+	parent := logrus.Fields{
+		"skyAddr":      "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW",
+		"email":        "user@example.com",
+		"txid":         "d2e4f6a8c0",
+		"shortTxid":    "ab",
+		"depositInfo":  di,
+		"depositInfos": []testDepositInfo{di},
+		"bindReq": testBindRequest{
+			SkyAddr:  "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW",
+			CoinType: "BTC",
+			Email:    "user@example.com",
+		},
+		"url":      "/api/status?skyaddr=2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW&email=user%40example.com&coin_type=BTC",
+		"address":  "127.0.0.1:7071",
+		"coinType": "BTC",
+		"error":    errors.New("skyaddr invalid"),
+		"height":   int64(20),
+		"config":   struct{ HTTPAddr string }{"127.0.0.1:7071"},
+	}
+
+	entry := &logrus.Entry{
+		Data: parent,
+	}
+	require.NoError(t, h.Fire(entry))
+
+	// The logger's fields are not modified
+	require.Equal(t, "user@example.com", parent["email"])
+	require.Equal(t, di, parent["depositInfo"])
+
+	data := entry.Data
+	require.Equal(t, skyHash, data["skyAddr"])
+	require.NotContains(t, data, "email")
+	require.Equal(t, "d2e4...", data["txid"])
+	require.Equal(t, "ab", data["shortTxid"])
+
+	expectedDi := map[string]interface{}{
+		"SkyAddress":     skyHash,
+		"DepositAddress": depositHash,
+		"Txid":           "d2e4...",
+		"SkySent":        json.Number("100000000000000001"),
+		"Deposit": map[string]interface{}{
+			"CoinType": "BTC",
+			"Address":  depositHash,
+			"Tx":       "a1b2...",
+			"Value":    json.Number("100000000"),
+		},
+	}
+
+	// Large numbers aren't rounded
+	require.Equal(t, expectedDi, data["depositInfo"])
+	require.Equal(t, []interface{}{expectedDi}, data["depositInfos"])
+
+	require.Equal(t, map[string]interface{}{
+		"skyaddr":   skyHash,
+		"coin_type": "BTC",
+	}, data["bindReq"])
+
+	require.Equal(t, "/api/status?coin_type=BTC&skyaddr="+skyHash, data["url"])
+
+	// Other fields are unchanged
+	require.Equal(t, "127.0.0.1:7071", data["address"])
+	require.Equal(t, "BTC", data["coinType"])
+	require.Equal(t, errors.New("skyaddr invalid"), data["error"])
+	require.Equal(t, int64(20), data["height"])
+	require.Equal(t, parent["config"], data["config"])
+}
+
+func TestNewLoggerRedactHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teller-logger")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "teller.log")
+	log, err := NewLogger(fn, true, newTestRedactHook(t))
+	require.NoError(t, err)
+	log.Out = ioutil.Discard
+
+	log.WithFields(logrus.Fields{
+		"skyAddr": "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW",
+		"email":   "user@example.com",
+	}).Info("Bound sky and BTC addresses")
+
+	b, err := ioutil.ReadFile(fn)
+	require.NoError(t, err)
+
+	line := string(b)
+	require.True(t, strings.Contains(line, "Bound sky and BTC addresses"), line)
+	require.False(t, strings.Contains(line, "2Wbi4wvxC4fkTYMsS2f6HaFfW4pafDjXcQW"), line)
+	require.False(t, strings.Contains(line, "user@example.com"), line)
+}
@@ -1,6 +1,7 @@
 package mathutil
 
 import (
+	"errors"
 	"math/big"
 
 	"github.com/shopspring/decimal"
@@ -34,9 +35,17 @@ func DecimalFromString(s string) (decimal.Decimal, error) {
 	return decimal.NewFromString(t)
 }
 
+// ErrGweiOverflow is returned by Wei2Gwei if the gwei amount doesn't fit in an int64
+var ErrGweiOverflow = errors.New("Gwei amount overflows int64")
+
 //Wei2Gwei convert wei to gwei 1e9wei = 1gwei
-func Wei2Gwei(wei *big.Int) int64 {
-	return big.NewInt(1).Div(wei, big.NewInt(1e9)).Int64()
+func Wei2Gwei(wei *big.Int) (int64, error) {
+	gwei := big.NewInt(1).Div(wei, big.NewInt(1e9))
+	// Int64 is undefined if the value doesn't fit
+	if !gwei.IsInt64() {
+		return 0, ErrGweiOverflow
+	}
+	return gwei.Int64(), nil
 }
 
 //Gwei2Wei convert gwei to wei 1gwei = 1e9wei
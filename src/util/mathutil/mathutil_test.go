@@ -3,6 +3,7 @@ package mathutil
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"testing"
 
@@ -77,7 +78,8 @@ func TestWei2Gwei(t *testing.T) {
 	for _, tc := range cases {
 		name := fmt.Sprintf("wei=%v gwei=%d", tc.wei, tc.gwei)
 		t.Run(name, func(t *testing.T) {
-			result := Wei2Gwei(tc.wei)
+			result, err := Wei2Gwei(tc.wei)
+			require.NoError(t, err)
 			require.Equal(t, tc.gwei, result, "%d == %d", tc.gwei, result)
 		})
 	}
@@ -88,4 +90,13 @@ func TestWei2Gwei(t *testing.T) {
 			require.Equal(t, 0, tc.wei.Cmp(result), "%v == %v", tc.wei, result)
 		})
 	}
+
+	// The largest amount which fits, and the smallest which doesn't
+	maxWei := Gwei2Wei(math.MaxInt64)
+	gwei, err := Wei2Gwei(maxWei)
+	require.NoError(t, err)
+	require.Equal(t, int64(math.MaxInt64), gwei)
+
+	_, err = Wei2Gwei(big.NewInt(1).Add(maxWei, big.NewInt(1e9)))
+	require.Equal(t, ErrGweiOverflow, err)
 }
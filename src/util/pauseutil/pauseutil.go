@@ -0,0 +1,184 @@
+// Package pauseutil pauses and resumes the work loops of teller's subsystems at runtime
+package pauseutil
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSubsystem is returned by Registry for a subsystem which was not added
+var ErrUnknownSubsystem = errors.New("Unknown subsystem")
+
+// Status is the pause state of a subsystem
+type Status struct {
+	Name     string `json:"name"`
+	Paused   bool   `json:"paused"`
+	PausedAt int64  `json:"paused_at,omitempty"`
+	// Busy is true while a unit of work is in progress, e.g. one which started before the pause
+	Busy bool `json:"busy"`
+}
+
+// Gate pauses a work loop between units of work. The zero value is an open Gate.
+type Gate struct {
+	sync.Mutex
+	resumeC  chan struct{}
+	pausedAt time.Time
+	busy     int
+}
+
+// wait blocks while the gate is paused. It returns with the gate locked,
+// or false if quit is closed while waiting.
+func (g *Gate) wait(quit <-chan struct{}) bool {
+	g.Lock()
+	for g.resumeC != nil {
+		resumeC := g.resumeC
+		g.Unlock()
+
+		select {
+		case <-resumeC:
+		case <-quit:
+			return false
+		}
+
+		g.Lock()
+	}
+	return true
+}
+
+// Wait blocks while the gate is paused, for work loops which don't report when they are busy.
+// It returns false if quit is closed while waiting.
+func (g *Gate) Wait(quit <-chan struct{}) bool {
+	if !g.wait(quit) {
+		return false
+	}
+	g.Unlock()
+	return true
+}
+
+// Enter starts a unit of work, waiting while the gate is paused. Leave must be called when the unit is finished.
+// It returns false if quit is closed while waiting, and then Leave must not be called.
+func (g *Gate) Enter(quit <-chan struct{}) bool {
+	if !g.wait(quit) {
+		return false
+	}
+	g.busy++
+	g.Unlock()
+	return true
+}
+
+// TryEnter starts a unit of work unless the gate is paused, for work which is refused rather than
+// queued while paused, e.g. HTTP requests. Leave must be called when the unit is finished, if it returns true.
+func (g *Gate) TryEnter() bool {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.resumeC != nil {
+		return false
+	}
+	g.busy++
+	return true
+}
+
+// Leave finishes a unit of work started by Enter or TryEnter
+func (g *Gate) Leave() {
+	g.Lock()
+	defer g.Unlock()
+	g.busy--
+}
+
+// Pause stops new units of work from starting. It does not wait for the unit in progress,
+// which is reported by Status. Pausing a paused gate does nothing.
+func (g *Gate) Pause() {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.resumeC == nil {
+		g.resumeC = make(chan struct{})
+		g.pausedAt = time.Now()
+	}
+}
+
+// Resume undoes Pause
+func (g *Gate) Resume() {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.resumeC != nil {
+		close(g.resumeC)
+		g.resumeC = nil
+		g.pausedAt = time.Time{}
+	}
+}
+
+// Status returns the pause state of the gate
+func (g *Gate) Status() Status {
+	g.Lock()
+	defer g.Unlock()
+
+	s := Status{
+		Paused: g.resumeC != nil,
+		Busy:   g.busy != 0,
+	}
+
+	if s.Paused {
+		s.PausedAt = g.pausedAt.Unix()
+	}
+
+	return s
+}
+
+// Registry names the gates of the subsystems
+type Registry struct {
+	names []string
+	gates map[string]*Gate
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		gates: make(map[string]*Gate),
+	}
+}
+
+// Add adds the gate of a subsystem. Adding a name again replaces its gate.
+func (r *Registry) Add(name string, g *Gate) {
+	if _, ok := r.gates[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.gates[name] = g
+}
+
+// Statuses returns the status of every subsystem, in the order they were added
+func (r *Registry) Statuses() []Status {
+	statuses := make([]Status, 0, len(r.names))
+	for _, name := range r.names {
+		s := r.gates[name].Status()
+		s.Name = name
+		statuses = append(statuses, s)
+	}
+	return statuses
+}
+
+// Pause pauses a subsystem and returns its status
+func (r *Registry) Pause(name string) (Status, error) {
+	return r.apply(name, (*Gate).Pause)
+}
+
+// Resume resumes a subsystem and returns its status
+func (r *Registry) Resume(name string) (Status, error) {
+	return r.apply(name, (*Gate).Resume)
+}
+
+func (r *Registry) apply(name string, f func(*Gate)) (Status, error) {
+	g, ok := r.gates[name]
+	if !ok {
+		return Status{}, ErrUnknownSubsystem
+	}
+
+	f(g)
+
+	s := g.Status()
+	s.Name = name
+	return s, nil
+}
@@ -0,0 +1,101 @@
+package pauseutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGate(t *testing.T) {
+	var g Gate
+	quit := make(chan struct{})
+
+	// The zero value is open
+	require.True(t, g.Enter(quit))
+	require.True(t, g.Status().Busy)
+
+	// Pausing doesn't wait for the unit in progress
+	g.Pause()
+	s := g.Status()
+	require.True(t, s.Paused)
+	require.True(t, s.Busy)
+	require.NotZero(t, s.PausedAt)
+	g.Leave()
+	require.False(t, g.Status().Busy)
+
+	entered := make(chan bool)
+	go func() {
+		entered <- g.Enter(quit)
+	}()
+
+	select {
+	case <-entered:
+		t.Fatal("Enter returned while paused")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	g.Resume()
+	require.True(t, <-entered)
+	s = g.Status()
+	require.False(t, s.Paused)
+	require.Zero(t, s.PausedAt)
+	g.Leave()
+
+	// Quitting while paused
+	g.Pause()
+	go func() {
+		entered <- g.Enter(quit)
+	}()
+	close(quit)
+	require.False(t, <-entered)
+	require.False(t, g.Wait(quit))
+	require.False(t, g.Status().Busy)
+}
+
+func TestGateTryEnter(t *testing.T) {
+	var g Gate
+
+	require.True(t, g.TryEnter())
+	require.True(t, g.Status().Busy)
+
+	// A paused gate refuses new units without waiting
+	g.Pause()
+	require.False(t, g.TryEnter())
+	g.Leave()
+	require.False(t, g.Status().Busy)
+
+	g.Resume()
+	require.True(t, g.TryEnter())
+	g.Leave()
+}
+
+func TestRegistry(t *testing.T) {
+	var a, b Gate
+	r := NewRegistry()
+	r.Add("a", &a)
+	r.Add("b", &b)
+
+	s, err := r.Pause("b")
+	require.NoError(t, err)
+	require.Equal(t, "b", s.Name)
+	require.True(t, s.Paused)
+	require.True(t, b.Status().Paused)
+	require.False(t, a.Status().Paused)
+
+	statuses := r.Statuses()
+	require.Len(t, statuses, 2)
+	require.Equal(t, "a", statuses[0].Name)
+	require.False(t, statuses[0].Paused)
+	require.Equal(t, "b", statuses[1].Name)
+	require.True(t, statuses[1].Paused)
+
+	s, err = r.Resume("b")
+	require.NoError(t, err)
+	require.False(t, s.Paused)
+
+	_, err = r.Pause("c")
+	require.Equal(t, ErrUnknownSubsystem, err)
+	_, err = r.Resume("c")
+	require.Equal(t, ErrUnknownSubsystem, err)
+}
@@ -0,0 +1,588 @@
+// Package qrcode encodes QR codes in byte mode with error correction level M,
+// and renders them as PNG or SVG images
+package qrcode
+
+import (
+	"errors"
+)
+
+const (
+	minVersion = 1
+	maxVersion = 40
+
+	// Format bits of error correction level M
+	eccFormatBits = 0
+
+	// Penalty weights of the mask evaluation rules
+	penaltyN1 = 3
+	penaltyN2 = 3
+	penaltyN3 = 40
+	penaltyN4 = 10
+)
+
+var (
+	// ErrDataTooLong is returned if the data doesn't fit in a version 40 QR code
+	ErrDataTooLong = errors.New("Data too long for a QR code")
+)
+
+// Error correction codewords per block, indexed by version, for error correction level M
+var eccCodewordsPerBlock = [maxVersion + 1]int{
+	-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26,
+	26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28,
+}
+
+// Error correction blocks, indexed by version, for error correction level M
+var numErrorCorrectionBlocks = [maxVersion + 1]int{
+	-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14,
+	16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49,
+}
+
+// QRCode is a grid of dark and light modules
+type QRCode struct {
+	Version int
+	Size    int
+	Mask    int
+	modules [][]bool
+	// isFunction marks the modules which aren't data, so that masking skips them
+	isFunction [][]bool
+}
+
+// Encode encodes data in the smallest QR code version which fits it,
+// using the mask with the lowest penalty
+func Encode(data []byte) (*QRCode, error) {
+	version := 0
+	for v := minVersion; v <= maxVersion; v++ {
+		if 4+charCountBits(v)+len(data)*8 <= numDataCodewords(v)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrDataTooLong
+	}
+
+	q := newQRCode(version)
+	q.drawFunctionPatterns()
+	q.drawCodewords(q.addECCAndInterleave(dataCodewords(version, data)))
+
+	minPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		penalty := q.penalty()
+		if minPenalty < 0 || penalty < minPenalty {
+			q.Mask = mask
+			minPenalty = penalty
+		}
+		// Masking twice restores the data modules
+		q.applyMask(mask)
+	}
+
+	q.applyMask(q.Mask)
+	q.drawFormatBits(q.Mask)
+
+	return q, nil
+}
+
+// Dark returns true if the module at x, y is dark.
+// Coordinates outside the code, in the quiet zone, are light.
+func (q *QRCode) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= q.Size || y >= q.Size {
+		return false
+	}
+	return q.modules[y][x]
+}
+
+func newQRCode(version int) *QRCode {
+	size := version*4 + 17
+	q := &QRCode{
+		Version:    version,
+		Size:       size,
+		modules:    make([][]bool, size),
+		isFunction: make([][]bool, size),
+	}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.isFunction[i] = make([]bool, size)
+	}
+	return q
+}
+
+// dataCodewords encodes data in byte mode, with the terminator and padding
+func dataCodewords(version int, data []byte) []byte {
+	var bb bitBuffer
+	bb.append(0x4, 4)
+	bb.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacity := numDataCodewords(version) * 8
+	terminator := capacity - bb.len()
+	if terminator > 4 {
+		terminator = 4
+	}
+	bb.append(0, terminator)
+	bb.append(0, (8-bb.len()%8)%8)
+
+	for pad := 0xEC; bb.len() < capacity; pad ^= 0xEC ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	return bb.bytes()
+}
+
+// charCountBits returns the width of the byte mode character count
+func charCountBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// numRawDataModules returns the number of modules available for data and error correction,
+// after the function patterns are drawn
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+// numDataCodewords returns the number of 8 bit data codewords of a version
+func numDataCodewords(version int) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[version]*numErrorCorrectionBlocks[version]
+}
+
+func (q *QRCode) setFunction(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.isFunction[y][x] = true
+}
+
+func (q *QRCode) drawFunctionPatterns() {
+	// Timing patterns
+	for i := 0; i < q.Size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns, which overwrite the ends of the timing patterns
+	q.drawFinderPattern(3, 3)
+	q.drawFinderPattern(q.Size-4, 3)
+	q.drawFinderPattern(3, q.Size-4)
+
+	// Alignment patterns, except where they'd overlap the finder patterns
+	pos := alignmentPatternPositions(q.Version)
+	n := len(pos)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			if (i == 0 && j == 0) || (i == 0 && j == n-1) || (i == n-1 && j == 0) {
+				continue
+			}
+			q.drawAlignmentPattern(pos[i], pos[j])
+		}
+	}
+
+	// Reserve the format bits with a dummy mask, they are redrawn once the mask is chosen
+	q.drawFormatBits(0)
+	q.drawVersion()
+}
+
+// drawFinderPattern draws a finder pattern and its separator, centered at x, y
+func (q *QRCode) drawFinderPattern(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= q.Size || yy >= q.Size {
+				continue
+			}
+			dist := maxInt(absInt(dx), absInt(dy))
+			q.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignmentPattern draws an alignment pattern centered at x, y
+func (q *QRCode) drawAlignmentPattern(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.setFunction(x+dx, y+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+// alignmentPatternPositions returns the row and column coordinates of the alignment pattern centers
+func alignmentPatternPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	numAlign := version/7 + 2
+	size := version*4 + 17
+
+	step := 26
+	if version != 32 {
+		step = ((size - 13) + (numAlign*2 - 2) - 1) / (numAlign*2 - 2) * 2
+	}
+
+	pos := make([]int, numAlign)
+	pos[0] = 6
+	for i, p := numAlign-1, size-7; i >= 1; i, p = i-1, p-step {
+		pos[i] = p
+	}
+	return pos
+}
+
+// formatBits returns the BCH encoded format information of the mask
+func formatBits(mask int) int {
+	data := eccFormatBits<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// versionBits returns the BCH encoded version information
+func versionBits(version int) int {
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	return version<<12 | rem
+}
+
+// drawFormatBits draws both copies of the format information
+func (q *QRCode) drawFormatBits(mask int) {
+	bits := formatBits(mask)
+
+	// Around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, getBit(bits, i))
+	}
+	q.setFunction(8, 7, getBit(bits, 6))
+	q.setFunction(8, 8, getBit(bits, 7))
+	q.setFunction(7, 8, getBit(bits, 8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, getBit(bits, i))
+	}
+
+	// Next to the top right and bottom left finder patterns
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.Size-1-i, 8, getBit(bits, i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.Size-15+i, getBit(bits, i))
+	}
+
+	// Always dark
+	q.setFunction(8, q.Size-8, true)
+}
+
+// drawVersion draws both copies of the version information, for versions 7 and up
+func (q *QRCode) drawVersion() {
+	if q.Version < 7 {
+		return
+	}
+
+	bits := versionBits(q.Version)
+	for i := 0; i < 18; i++ {
+		dark := getBit(bits, i)
+		a := q.Size - 11 + i%3
+		b := i / 3
+		q.setFunction(a, b, dark)
+		q.setFunction(b, a, dark)
+	}
+}
+
+// addECCAndInterleave splits the data codewords into blocks, appends each block's
+// error correction codewords and interleaves the blocks
+func (q *QRCode) addECCAndInterleave(data []byte) []byte {
+	numBlocks := numErrorCorrectionBlocks[q.Version]
+	blockECCLen := eccCodewordsPerBlock[q.Version]
+	rawCodewords := numRawDataModules(q.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(blockECCLen)
+
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := range blocks {
+		datLen := shortBlockLen - blockECCLen
+		if i >= numShortBlocks {
+			datLen++
+		}
+		dat := data[k : k+datLen]
+		k += datLen
+
+		block := make([]byte, 0, shortBlockLen+1)
+		block = append(block, dat...)
+		if i < numShortBlocks {
+			// Placeholder so that all blocks have the same length, skipped when interleaving
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, reedSolomonRemainder(dat, divisor)...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLen-blockECCLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// drawCodewords draws the codewords in the zig-zag pattern, skipping the function modules.
+// Any remainder modules are left light.
+func (q *QRCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		// Skip the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					// Upward column
+					y = q.Size - 1 - vert
+				}
+				if !q.isFunction[y][x] && i < len(data)*8 {
+					q.modules[y][x] = getBit(int(data[i>>3]), 7-(i&7))
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask pattern
+func (q *QRCode) applyMask(mask int) {
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.isFunction[y][x] {
+				continue
+			}
+
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the current modules with the mask evaluation rules. Lower is better.
+func (q *QRCode) penalty() int {
+	penalty := 0
+
+	row := func(y int) func(int) bool {
+		return func(x int) bool { return q.modules[y][x] }
+	}
+	col := func(x int) func(int) bool {
+		return func(y int) bool { return q.modules[y][x] }
+	}
+
+	for i := 0; i < q.Size; i++ {
+		penalty += q.linePenalty(row(i))
+		penalty += q.linePenalty(col(i))
+	}
+
+	// Rule 2: 2x2 blocks of the same color
+	for y := 0; y < q.Size-1; y++ {
+		for x := 0; x < q.Size-1; x++ {
+			c := q.modules[y][x]
+			if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+				penalty += penaltyN2
+			}
+		}
+	}
+
+	// Rule 4: the balance of dark and light modules
+	dark := 0
+	for _, r := range q.modules {
+		for _, m := range r {
+			if m {
+				dark++
+			}
+		}
+	}
+	total := q.Size * q.Size
+	k := (absInt(dark*20-total*10)+total-1)/total - 1
+	penalty += k * penaltyN4
+
+	return penalty
+}
+
+// finderLike is the dark and light pattern of a finder pattern's center row
+var finderLike = []bool{true, false, true, true, true, false, true}
+
+// linePenalty scores a row or column with rule 1, runs of the same color,
+// and rule 3, patterns which look like a finder pattern
+func (q *QRCode) linePenalty(dark func(int) bool) int {
+	penalty := 0
+
+	run := 1
+	for i := 1; i <= q.Size; i++ {
+		if i < q.Size && dark(i) == dark(i-1) {
+			run++
+			continue
+		}
+		if run >= 5 {
+			penalty += penaltyN1 + run - 5
+		}
+		run = 1
+	}
+
+	// The quiet zone is light, so modules outside the code count as light
+	at := func(i int) bool {
+		if i < 0 || i >= q.Size {
+			return false
+		}
+		return dark(i)
+	}
+
+	for i := -4; i+len(finderLike) <= q.Size+4; i++ {
+		match := true
+		for j, d := range finderLike {
+			if at(i+j) != d {
+				match = false
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+
+		lightBefore, lightAfter := true, true
+		for j := 1; j <= 4; j++ {
+			if at(i - j) {
+				lightBefore = false
+			}
+			if at(i + len(finderLike) - 1 + j) {
+				lightAfter = false
+			}
+		}
+		if lightBefore {
+			penalty += penaltyN3
+		}
+		if lightAfter {
+			penalty += penaltyN3
+		}
+	}
+
+	return penalty
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree,
+// without its leading coefficient, highest power first
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// reedSolomonRemainder returns the error correction codewords of data
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+	return byte(z)
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(val, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, getBit(val, i))
+	}
+}
+
+func (b bitBuffer) len() int {
+	return len(b)
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, (len(b)+7)/8)
+	for i, bit := range b {
+		if bit {
+			out[i>>3] |= 1 << uint(7-(i&7))
+		}
+	}
+	return out
+}
+
+func getBit(x, i int) bool {
+	return (x>>uint(i))&1 != 0
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,206 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// Version 1-M "HELLO WORLD" example
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	ecc := reedSolomonRemainder(data, reedSolomonDivisor(10))
+	require.Equal(t, []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}, ecc)
+}
+
+func TestFormatBits(t *testing.T) {
+	require.Equal(t, 0x5412, formatBits(0))
+	require.Equal(t, 0x5125, formatBits(1))
+	require.Equal(t, 0x5E7C, formatBits(2))
+	require.Equal(t, 0x4AA0, formatBits(7))
+}
+
+func TestVersionBits(t *testing.T) {
+	require.Equal(t, 0x07C94, versionBits(7))
+	require.Equal(t, 0x28C69, versionBits(40))
+}
+
+func TestNumDataCodewords(t *testing.T) {
+	for version, n := range map[int]int{
+		1:  16,
+		2:  28,
+		5:  86,
+		7:  124,
+		10: 216,
+		40: 2334,
+	} {
+		require.Equal(t, n, numDataCodewords(version), "version %d", version)
+	}
+}
+
+func TestAlignmentPatternPositions(t *testing.T) {
+	require.Nil(t, alignmentPatternPositions(1))
+	require.Equal(t, []int{6, 18}, alignmentPatternPositions(2))
+	require.Equal(t, []int{6, 22, 38}, alignmentPatternPositions(7))
+	require.Equal(t, []int{6, 34, 60, 86, 112, 138}, alignmentPatternPositions(32))
+	require.Equal(t, []int{6, 24, 50, 76, 102, 128, 154}, alignmentPatternPositions(36))
+	require.Equal(t, []int{6, 30, 58, 86, 114, 142, 170}, alignmentPatternPositions(40))
+}
+
+func TestEncodeVersion(t *testing.T) {
+	cases := []struct {
+		n       int
+		version int
+	}{
+		{0, 1},
+		{14, 1},
+		{15, 2},
+		{84, 5},
+		{213, 10},
+		{2331, 40},
+	}
+
+	for _, tc := range cases {
+		q, err := Encode(bytes.Repeat([]byte("a"), tc.n))
+		require.NoError(t, err)
+		require.Equal(t, tc.version, q.Version, "%d bytes", tc.n)
+		require.Equal(t, tc.version*4+17, q.Size)
+	}
+
+	_, err := Encode(bytes.Repeat([]byte("a"), 2332))
+	require.Equal(t, ErrDataTooLong, err)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, data := range []string{
+		"bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT",
+		"bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.12345678",
+		strings.Repeat("bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT?amount=0.1", 5),
+	} {
+		q, err := Encode([]byte(data))
+		require.NoError(t, err)
+
+		// Finder patterns
+		for _, c := range [][2]int{{0, 0}, {q.Size - 7, 0}, {0, q.Size - 7}} {
+			for i := 0; i < 7; i++ {
+				require.True(t, q.Dark(c[0]+i, c[1]))
+				require.True(t, q.Dark(c[0], c[1]+i))
+			}
+			require.False(t, q.Dark(c[0]+1, c[1]+1))
+			require.True(t, q.Dark(c[0]+3, c[1]+3))
+		}
+
+		// Both copies of the format information match the chosen mask
+		var bits1, bits2 int
+		for i := 0; i <= 5; i++ {
+			bits1 |= boolBit(q.Dark(8, i)) << uint(i)
+		}
+		bits1 |= boolBit(q.Dark(8, 7)) << 6
+		bits1 |= boolBit(q.Dark(8, 8)) << 7
+		bits1 |= boolBit(q.Dark(7, 8)) << 8
+		for i := 9; i < 15; i++ {
+			bits1 |= boolBit(q.Dark(14-i, 8)) << uint(i)
+		}
+		for i := 0; i < 8; i++ {
+			bits2 |= boolBit(q.Dark(q.Size-1-i, 8)) << uint(i)
+		}
+		for i := 8; i < 15; i++ {
+			bits2 |= boolBit(q.Dark(8, q.Size-15+i)) << uint(i)
+		}
+		require.Equal(t, formatBits(q.Mask), bits1)
+		require.Equal(t, formatBits(q.Mask), bits2)
+
+		// Unmasking and reading the modules back returns the codewords
+		q.applyMask(q.Mask)
+		expected := q.addECCAndInterleave(dataCodewords(q.Version, []byte(data)))
+		require.Equal(t, expected, readCodewords(q, len(expected)))
+
+		// The data codewords decode to the data
+		codewords := expected
+		if numErrorCorrectionBlocks[q.Version] == 1 {
+			require.Equal(t, byte(0x40|len(data)>>4), codewords[0])
+			require.Equal(t, data, string(decodeBytes(codewords, len(data))))
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	q, err := Encode([]byte("bitcoin:1BoatSLRHtKNngkdXEeobR76b53LETtpyT"))
+	require.NoError(t, err)
+
+	b, err := q.PNG(4)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(b))
+	require.NoError(t, err)
+	size := (q.Size + QuietZone*2) * 4
+	require.Equal(t, size, img.Bounds().Dx())
+	require.Equal(t, size, img.Bounds().Dy())
+
+	// Quiet zone is white, the top left finder pattern corner is black
+	r, _, _, _ := img.At(0, 0).RGBA()
+	require.Equal(t, uint32(0xffff), r)
+	r, _, _, _ = img.At(QuietZone*4, QuietZone*4).RGBA()
+	require.Equal(t, uint32(0), r)
+
+	svg := string(q.SVG(4))
+	require.True(t, strings.HasPrefix(svg, "<svg "))
+	require.True(t, strings.HasSuffix(svg, "</svg>"))
+	require.Contains(t, svg, "M4,4h1v1h-1z")
+}
+
+func boolBit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// readCodewords reads n codewords in the zig-zag pattern, skipping the function modules
+func readCodewords(q *QRCode, n int) []byte {
+	out := make([]byte, n)
+	i := 0
+	for right := q.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.Size - 1 - vert
+				}
+				if !q.isFunction[y][x] && i < n*8 {
+					if q.modules[y][x] {
+						out[i>>3] |= 1 << uint(7-(i&7))
+					}
+					i++
+				}
+			}
+		}
+	}
+	return out
+}
+
+// decodeBytes decodes the data of a version 1-9 byte mode segment
+func decodeBytes(codewords []byte, n int) []byte {
+	var bits []bool
+	for _, c := range codewords {
+		for i := 7; i >= 0; i-- {
+			bits = append(bits, c>>uint(i)&1 != 0)
+		}
+	}
+
+	out := make([]byte, n)
+	for i := range out {
+		for j := 0; j < 8; j++ {
+			if bits[12+i*8+j] {
+				out[i] |= 1 << uint(7-j)
+			}
+		}
+	}
+	return out
+}
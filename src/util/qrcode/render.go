@@ -0,0 +1,64 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// QuietZone is the width of the light border around the code, in modules
+const QuietZone = 4
+
+// Image returns the code as a grayscale image, with scale pixels per module
+func (q *QRCode) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+
+	size := (q.Size + QuietZone*2) * scale
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	for py := 0; py < size; py++ {
+		for px := 0; px < size; px++ {
+			c := color.White
+			if q.Dark(px/scale-QuietZone, py/scale-QuietZone) {
+				c = color.Black
+			}
+			img.Set(px, py, c)
+		}
+	}
+	return img
+}
+
+// PNG returns the code as a PNG image, with scale pixels per module
+func (q *QRCode) PNG(scale int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, q.Image(scale)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG returns the code as an SVG image, with scale pixels per module
+func (q *QRCode) SVG(scale int) []byte {
+	if scale < 1 {
+		scale = 1
+	}
+
+	n := q.Size + QuietZone*2
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, n*scale, n*scale, n, n)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`, n, n)
+	buf.WriteString(`<path fill="#000" d="`)
+	for y := 0; y < q.Size; y++ {
+		for x := 0; x < q.Size; x++ {
+			if q.Dark(x, y) {
+				fmt.Fprintf(&buf, "M%d,%dh1v1h-1z", x+QuietZone, y+QuietZone)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
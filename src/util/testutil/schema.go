@@ -0,0 +1,391 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var updateSchemas = flag.Bool("update-schemas", false, "rewrite the response schemas in testdata/schemas")
+
+// Schema is the shape of a JSON value, in the JSON Schema keywords of an OpenAPI 3.0 schema object
+type Schema struct {
+	// "object", "array", "string", "integer", "number" or "boolean". Empty allows any value.
+	Type   string `json:"type,omitempty"`
+	Format string `json:"format,omitempty"`
+	// Nullable allows null, e.g. a nil pointer, slice or map
+	Nullable bool `json:"nullable,omitempty"`
+	// Properties of an object. An object without additionalProperties only has these properties.
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	// Required properties, the others are omitted when empty
+	Required []string `json:"required,omitempty"`
+	// Items of an array
+	Items *Schema `json:"items,omitempty"`
+	// AdditionalProperties are the values of a map
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// SchemaOf returns the schema of v marshaled by encoding/json
+func SchemaOf(v interface{}) *Schema {
+	return schemaOf(reflect.TypeOf(v), map[reflect.Type]bool{})
+}
+
+func schemaOf(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Implements(jsonMarshalerType):
+		// The shape is up to MarshalJSON
+		return &Schema{}
+	case t.Kind() != reflect.Ptr && t.Implements(textMarshalerType):
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Ptr:
+		s := schemaOf(t.Elem(), seen)
+		s.Nullable = true
+		return s
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: true}
+		}
+		return &Schema{Type: "array", Nullable: true, Items: schemaOf(t.Elem(), seen)}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: "object", Nullable: true, AdditionalProperties: schemaOf(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			// A recursive type isn't expanded again
+			return &Schema{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		addFields(s, t, seen)
+		sort.Strings(s.Required)
+		return s
+	default:
+		// interface{}
+		return &Schema{}
+	}
+}
+
+// addFields adds the properties of struct t to s, with the fields of embedded structs promoted
+// like encoding/json does. A field of t takes precedence over a promoted field of the same name.
+func addFields(s *Schema, t reflect.Type, seen map[reflect.Type]bool) {
+	var direct []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && ft.Kind() == reflect.Struct && strings.SplitN(tag, ",", 2)[0] == "" && ft != timeType {
+			embedded := &Schema{Properties: map[string]*Schema{}}
+			addFields(embedded, ft, seen)
+			for name, p := range embedded.Properties {
+				s.Properties[name] = p
+			}
+			s.Required = removeString(s.Required, embedded.Properties)
+			if sf.Type.Kind() != reflect.Ptr {
+				s.Required = append(s.Required, embedded.Required...)
+			}
+			continue
+		}
+
+		if sf.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		direct = append(direct, sf)
+	}
+
+	for _, sf := range direct {
+		name := sf.Name
+		opts := ""
+		if tag := sf.Tag.Get("json"); tag != "" {
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) == 2 {
+				opts = "," + parts[1] + ","
+			}
+		}
+
+		p := schemaOf(sf.Type, seen)
+		if strings.Contains(opts, ",string,") {
+			switch p.Type {
+			case "boolean", "integer", "number", "string":
+				p = &Schema{Type: "string", Nullable: p.Nullable}
+			}
+		}
+
+		s.Properties[name] = p
+		s.Required = removeString(s.Required, map[string]*Schema{name: p})
+		if !strings.Contains(opts, ",omitempty,") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+func removeString(ss []string, remove map[string]*Schema) []string {
+	var kept []string
+	for _, s := range ss {
+		if _, ok := remove[s]; !ok {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// Validate checks that the JSON document b has the shape of s
+func (s *Schema) Validate(b []byte) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	return s.validate("$", v)
+}
+
+func (s *Schema) validate(path string, v interface{}) error {
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: got null, want %s", path, s.Type)
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %s, want object", path, jsonType(v))
+		}
+
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing %q", path, name)
+			}
+		}
+
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			p, ok := s.Properties[name]
+			if !ok {
+				p = s.AdditionalProperties
+			}
+			if p == nil {
+				return fmt.Errorf("%s: unexpected %q", path, name)
+			}
+			if err := p.validate(path+"."+name, obj[name]); err != nil {
+				return err
+			}
+		}
+
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %s, want array", path, jsonType(v))
+		}
+		for i, item := range items {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+
+	default:
+		if got := jsonType(v); got != s.Type && !(got == "integer" && s.Type == "number") {
+			return fmt.Errorf("%s: got %s, want %s", path, got, s.Type)
+		}
+	}
+
+	return nil
+}
+
+func jsonType(v interface{}) string {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if strings.ContainsAny(x.String(), ".eE") {
+			return "number"
+		}
+		return "integer"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// Diff lists the changes from schema a to b
+func Diff(a, b *Schema) []string {
+	return diff("$", a, b)
+}
+
+func diff(path string, a, b *Schema) []string {
+	var changes []string
+	if a.Type != b.Type || a.Format != b.Format {
+		changes = append(changes, fmt.Sprintf("%s: type changed from %s to %s", path, typeName(a), typeName(b)))
+		return changes
+	}
+
+	if a.Nullable != b.Nullable {
+		changes = append(changes, fmt.Sprintf("%s: nullable changed from %t to %t", path, a.Nullable, b.Nullable))
+	}
+
+	required := func(s *Schema, name string) bool {
+		for _, r := range s.Required {
+			if r == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	names := make(map[string]struct{}, len(a.Properties)+len(b.Properties))
+	for name := range a.Properties {
+		names[name] = struct{}{}
+	}
+	for name := range b.Properties {
+		names[name] = struct{}{}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		p := path + "." + name
+		pa, inA := a.Properties[name]
+		pb, inB := b.Properties[name]
+		switch {
+		case !inB:
+			changes = append(changes, p+": removed")
+		case !inA:
+			changes = append(changes, p+": added")
+		default:
+			if ra, rb := required(a, name), required(b, name); ra != rb {
+				changes = append(changes, fmt.Sprintf("%s: required changed from %t to %t", p, ra, rb))
+			}
+			changes = append(changes, diff(p, pa, pb)...)
+		}
+	}
+
+	if a.Items != nil && b.Items != nil {
+		changes = append(changes, diff(path+"[]", a.Items, b.Items)...)
+	}
+
+	switch {
+	case a.AdditionalProperties != nil && b.AdditionalProperties != nil:
+		changes = append(changes, diff(path+"{}", a.AdditionalProperties, b.AdditionalProperties)...)
+	case a.AdditionalProperties != nil:
+		changes = append(changes, path+": additionalProperties removed")
+	case b.AdditionalProperties != nil:
+		changes = append(changes, path+": additionalProperties added")
+	}
+
+	return changes
+}
+
+func typeName(s *Schema) string {
+	name := s.Type
+	if name == "" {
+		name = "any"
+	}
+	if s.Format != "" {
+		name += " (" + s.Format + ")"
+	}
+	return name
+}
+
+// RequireSchema checks that the schema of v matches the committed schema in testdata/schemas/<name>.json,
+// and returns it. Run the tests with -update-schemas to rewrite the committed schema after an intended change.
+func RequireSchema(t *testing.T, name string, v interface{}) *Schema {
+	s := SchemaOf(v)
+	path := filepath.Join("testdata", "schemas", name+".json")
+
+	if *updateSchemas {
+		b, err := json.MarshalIndent(s, "", "    ")
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0750))
+		require.NoError(t, ioutil.WriteFile(path, append(b, '\n'), 0644))
+		return s
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		require.FailNow(t, fmt.Sprintf("%s is missing, run the tests with -update-schemas to create it", path))
+	}
+	require.NoError(t, err)
+
+	var committed Schema
+	require.NoError(t, json.Unmarshal(b, &committed), path)
+
+	if changes := Diff(&committed, s); len(changes) > 0 {
+		require.FailNow(t, fmt.Sprintf("The schema of %s changed from %s:\n\t%s\n"+
+			"Removing, renaming or retyping a field breaks the API's clients. "+
+			"If the change is intended, run the tests with -update-schemas and commit %s.",
+			name, path, strings.Join(changes, "\n\t"), path))
+	}
+
+	return &committed
+}
@@ -0,0 +1,140 @@
+package testutil
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type schemaInner struct {
+	Seq       uint64 `json:"seq"`
+	UpdatedAt int64  `json:"updated_at"`
+	Status    string `json:"status"`
+}
+
+type schemaOuter struct {
+	schemaInner
+	Status   int               `json:"status"`
+	Note     string            `json:"note,omitempty"`
+	OnChain  *bool             `json:"on_chain,omitempty"`
+	Rate     float64           `json:"rate"`
+	Amount   int64             `json:"amount,string"`
+	Tags     []string          `json:"tags"`
+	Counts   map[string]uint64 `json:"counts"`
+	At       time.Time         `json:"at"`
+	Raw      json.RawMessage   `json:"raw"`
+	Any      interface{}       `json:"any"`
+	Skipped  string            `json:"-"`
+	private  string
+	Untagged bool
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(schemaOuter{})
+	require.Equal(t, "object", s.Type)
+	require.Equal(t, []string{"Untagged", "amount", "any", "at", "counts", "rate", "raw", "seq", "status", "tags", "updated_at"}, s.Required)
+	require.Len(t, s.Properties, 13)
+
+	// A field takes precedence over the embedded struct's field of the same name
+	require.Equal(t, &Schema{Type: "integer"}, s.Properties["status"])
+	require.Equal(t, &Schema{Type: "integer"}, s.Properties["seq"])
+	require.Equal(t, &Schema{Type: "boolean", Nullable: true}, s.Properties["on_chain"])
+	require.Equal(t, &Schema{Type: "number"}, s.Properties["rate"])
+	require.Equal(t, &Schema{Type: "string"}, s.Properties["amount"])
+	require.Equal(t, &Schema{Type: "array", Nullable: true, Items: &Schema{Type: "string"}}, s.Properties["tags"])
+	require.Equal(t, &Schema{Type: "object", Nullable: true, AdditionalProperties: &Schema{Type: "integer"}}, s.Properties["counts"])
+	require.Equal(t, &Schema{Type: "string", Format: "date-time"}, s.Properties["at"])
+	require.Equal(t, &Schema{}, s.Properties["raw"])
+	require.Equal(t, &Schema{}, s.Properties["any"])
+	require.Nil(t, s.Properties["-"])
+	require.Nil(t, s.Properties["private"])
+
+	// The schema survives being committed
+	b, err := json.Marshal(s)
+	require.NoError(t, err)
+	var s2 Schema
+	require.NoError(t, json.Unmarshal(b, &s2))
+	require.Empty(t, Diff(s, &s2))
+}
+
+func TestSchemaValidate(t *testing.T) {
+	s := SchemaOf(schemaOuter{})
+
+	valid, err := json.Marshal(schemaOuter{
+		Status: 1,
+		Tags:   []string{"a"},
+		Counts: map[string]uint64{"BTC": 2},
+		Raw:    json.RawMessage(`{"x":[1,2]}`),
+		Any:    "x",
+	})
+	require.NoError(t, err)
+	require.NoError(t, s.Validate(valid))
+
+	for _, body := range []string{`[]`, `{`} {
+		require.Error(t, s.Validate([]byte(body)), body)
+	}
+
+	// Each case changes a field of a valid response
+	for _, tc := range []struct {
+		field string
+		value interface{}
+		err   string
+	}{
+		{"seq", nil, "$.seq: got null, want integer"},
+		{"seq", 1.5, "$.seq: got number, want integer"},
+		{"status", "1", "$.status: got string, want integer"},
+		{"renamed", 1, `$: unexpected "renamed"`},
+		{"counts", map[string]interface{}{"BTC": true}, "$.counts.BTC: got boolean, want integer"},
+		{"tags", []interface{}{"a", 2}, "$.tags[1]: got integer, want string"},
+		{"at", nil, "$.at: got null, want string"},
+		{"on_chain", 1, "$.on_chain: got integer, want boolean"},
+	} {
+		var rsp map[string]interface{}
+		require.NoError(t, json.Unmarshal(valid, &rsp))
+		rsp[tc.field] = tc.value
+		b, err := json.Marshal(rsp)
+		require.NoError(t, err)
+
+		err = s.Validate(b)
+		require.Error(t, err, tc.field)
+		require.Equal(t, tc.err, err.Error())
+	}
+
+	// An omitempty field can be missing, the others can't
+	var rsp map[string]interface{}
+	require.NoError(t, json.Unmarshal(valid, &rsp))
+	require.NotContains(t, rsp, "note")
+	delete(rsp, "updated_at")
+	b, err := json.Marshal(rsp)
+	require.NoError(t, err)
+	err = s.Validate(b)
+	require.Error(t, err)
+	require.Equal(t, `$: missing "updated_at"`, err.Error())
+}
+
+func TestSchemaDiff(t *testing.T) {
+	type v1 struct {
+		Address  string   `json:"address"`
+		CoinType string   `json:"coin_type"`
+		Amount   int64    `json:"amount"`
+		Tags     []string `json:"tags"`
+	}
+
+	type v2 struct {
+		DepositAddress string  `json:"deposit_address"`
+		CoinType       string  `json:"coin_type,omitempty"`
+		Amount         string  `json:"amount"`
+		Tags           []int64 `json:"tags"`
+	}
+
+	require.Empty(t, Diff(SchemaOf(v1{}), SchemaOf(v1{})))
+	require.Equal(t, []string{
+		"$.address: removed",
+		"$.amount: type changed from integer to string",
+		"$.coin_type: required changed from true to false",
+		"$.deposit_address: added",
+		"$.tags[]: type changed from string to integer",
+	}, Diff(SchemaOf(v1{}), SchemaOf(v2{})))
+}
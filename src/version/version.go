@@ -0,0 +1,76 @@
+// Package version reports the build of teller, set at build time with -ldflags:
+//
+//	go build -ldflags "-X github.com/skycoin/teller/src/version.Version=v1.0.0 \
+//	    -X github.com/skycoin/teller/src/version.Commit=$(git rev-parse HEAD) \
+//	    -X github.com/skycoin/teller/src/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/teller
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+var (
+	// Version is the release version
+	Version = "dev"
+	// Commit is the git commit hash the build is from
+	Commit = ""
+	// BuildDate is the time of the build, RFC3339
+	BuildDate = ""
+)
+
+// started approximates the process start time
+var started = time.Now()
+
+// Info is the build of teller
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build of teller. If Commit was not set at build time,
+// the commit recorded by the go tool is used, if any.
+func Get() Info {
+	commit := Commit
+	if commit == "" {
+		commit = vcsRevision()
+	}
+
+	return Info{
+		Version:   Version,
+		Commit:    commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+func vcsRevision() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	var revision, modified string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+
+	if revision != "" && modified == "true" {
+		revision += "-dirty"
+	}
+
+	return revision
+}
+
+// Uptime returns how long the process has been running
+func Uptime() time.Duration {
+	return time.Since(started)
+}
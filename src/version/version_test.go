@@ -0,0 +1,27 @@
+package version
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGet(t *testing.T) {
+	defer func(v, c, d string) {
+		Version, Commit, BuildDate = v, c, d
+	}(Version, Commit, BuildDate)
+
+	Version = "v1.2.3"
+	Commit = "abcdef"
+	BuildDate = "2018-01-02T03:04:05Z"
+
+	require.Equal(t, Info{
+		Version:   "v1.2.3",
+		Commit:    "abcdef",
+		BuildDate: "2018-01-02T03:04:05Z",
+		GoVersion: runtime.Version(),
+	}, Get())
+
+	require.True(t, Uptime() > 0)
+}
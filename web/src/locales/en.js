@@ -65,6 +65,7 @@ export default {
     statuses: {
       waiting_deposit: '[tx-{id} {updated}] Waiting for BTC deposit.',
       waiting_send: '[tx-{id} {updated}] BTC deposit confirmed. Skycoin transaction is queued.',
+      waiting_review: '[tx-{id} {updated}] BTC deposit received. The exchange is on hold for review.',
       waiting_confirm: '[tx-{id} {updated}] Skycoin transaction sent.  Waiting to confirm.',
       done: '[tx-{id} {updated}] Completed. Check your Skycoin wallet.',
     },
@@ -66,6 +66,7 @@ export default {
     statuses: {
       waiting_deposit: '[tx-{id} {updated}] Ожидаем BTC депозит.',
       waiting_send: '[tx-{id} {updated}] BTC депозит подтверждён. Skycoin транзакция поставлена в очередь.',
+      waiting_review: '[tx-{id} {updated}] BTC депозит получен. Обмен приостановлен для проверки.',
       waiting_confirm: '[tx-{id} {updated}] Skycoin транзакция отправлена. Ожидаем подтверждение.',
       done: '[tx-{id} {updated}] Завершена. Проверьте ваш Skycoin кошелёк.',
     },
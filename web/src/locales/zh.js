@@ -66,6 +66,7 @@ export default {
       done: '交易 {id}: 天空币已经发送并确认(更新于{updated}).',
       waiting_deposit: '交易 {id}: 等待比特币存入(更新于 {updated}).',
       waiting_send: '交易 {id}: 比特币存入已确认; 天空币发送在队列中 (更新于 {updated}).',
+      waiting_review: '交易 {id}: 比特币存入已收到; 兑换暂停等待审核 (更新于 {updated}).',
       waiting_confirm: '交易 {id}: 天空币已发送,等待交易确认 (更新于 {updated}).',
     },
   },
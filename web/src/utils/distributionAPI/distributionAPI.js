@@ -12,18 +12,24 @@ import axios from 'axios';
 //     });
 //
 
+// A partner checkout page embedding the website passes its widget session token
+// in the widget_session query parameter, which is sent with bind and status requests
+const widgetSession = new URLSearchParams(window.location.search).get('widget_session');
+const widgetHeaders = widgetSession ? { 'X-Widget-Session': widgetSession } : {};
+
 export const getConfig = () =>
   axios.get('/api/config')
     .then(response => response.data);
 
 export const checkStatus = skyAddress =>
-  axios.get(`/api/status?skyaddr=${skyAddress}`)
+  axios.get(`/api/status?skyaddr=${skyAddress}`, { headers: widgetHeaders })
     .then(response => response.data.statuses || [])
     .catch((error) => { throw new Error(error.response.data); });
 
 export const getAddress = skyAddress =>
   axios.post('/api/bind', { skyaddr: skyAddress, coin_type: 'BTC' }, {
     headers: {
+      ...widgetHeaders,
       'Content-Type': 'application/json',
     },
   })